@@ -15,8 +15,11 @@ function handleFetchResponse (response) {
           })
       })
       .then(function (errorBody) {
-        var err = new Error(errorBody.error)
+        var err = new Error(errorBody.detail || errorBody.error)
         err.status = response.status
+        // code is a stable identifier of the problem that can be used
+        // for handling specific errors
+        err.code = errorBody.code || null
         throw err
       })
   }
@@ -57,7 +57,7 @@ describe('fetch-response/index.js', function () {
       before(function () {
         fetchMock.get('https://example.net', {
           status: 400,
-          body: '{"status":400,"error":"did not work"}'
+          body: '{"status":400,"code":"invalid_credentials","detail":"did not work","error":"did not work"}'
         })
       })
 
@@ -75,6 +75,7 @@ describe('fetch-response/index.js', function () {
           .catch(function (err) {
             assert.strictEqual(err.message, 'did not work')
             assert.strictEqual(err.status, 400)
+            assert.strictEqual(err.code, 'invalid_credentials')
             done()
           })
           .catch(function (err) {
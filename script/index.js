@@ -14,6 +14,7 @@ var events = require('./src/events')
 var accountId = document.currentScript && document.currentScript.dataset.accountId
 var scriptHost = document.currentScript && document.currentScript.src
 var noBanner = document.currentScript && 'noBanner' in document.currentScript.dataset
+var collectPerformance = document.currentScript && 'collectPerformance' in document.currentScript.dataset
 
 var scriptUrl = ''
 try {
@@ -27,7 +28,8 @@ function main () {
       type: 'EVENT',
       payload: {
         accountId: accountId,
-        event: events.pageview(context === 'initial')
+        event: events.pageview(context === 'initial', collectPerformance),
+        honeypot: events.honeypot()
       },
       meta: {
         noBanner: noBanner
@@ -36,6 +38,28 @@ function main () {
     send(message)
   })
 
+  app.on('CUSTOM', supportMiddleware, function (context, send, next) {
+    var message = {
+      type: 'EVENT',
+      payload: {
+        accountId: accountId,
+        event: events.custom(context.name, context.value),
+        honeypot: events.honeypot()
+      },
+      meta: {
+        noBanner: noBanner
+      }
+    }
+    send(message)
+  })
+
+  // `track` records an event of a custom type, e.g. `track('signup')`.
+  // Event types need to be defined for the account, otherwise the events
+  // are not shown.
+  app.track = function (name, value) {
+    app.dispatch('CUSTOM', { name: name, value: value })
+  }
+
   switch (document.readyState) {
     case 'complete':
     case 'loaded':
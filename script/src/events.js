@@ -5,7 +5,10 @@
 
 exports.pageview = pageview
 
-function pageview (initial) {
+// `pageview` creates a pageview event. In case `collectPerformance` is set,
+// the initial pageview also carries the time it took to load the page,
+// placed in one of a fixed set of coarse buckets.
+function pageview (initial, collectPerformance) {
   var canonicalLink = document.head.querySelector('link[rel="canonical"]')
   var canonicalHref = canonicalLink && canonicalLink.getAttribute('href')
   var event = {
@@ -25,8 +28,124 @@ function pageview (initial) {
     // some point in the future. Find a more robust feature detect.
     isMobile: typeof window.onorientationchange !== 'undefined'
   }
+  if (collectPerformance && initial) {
+    var loadTime = navigationTiming()
+    if (loadTime !== null) {
+      event.loadTime = loadTimeBucket(loadTime)
+    }
+  }
   if (canonicalHref && canonicalHref !== window.location.href) {
     event.rawHref = window.location.href
   }
+  var utm = utmParameters(window.location.search)
+  for (var key in utm) {
+    event[key] = utm[key]
+  }
+  return event
+}
+
+// `loadTimeBuckets` are the upper bounds in milliseconds of the buckets load
+// times are placed in. Load times above the last bound are placed in the
+// last bucket. Sending buckets instead of exact values keeps the payload
+// from being used for fingerprinting devices.
+var loadTimeBuckets = [100, 250, 500, 1000, 2500, 5000, 10000]
+
+exports.loadTimeBuckets = loadTimeBuckets
+
+exports.loadTimeBucket = loadTimeBucket
+
+// `loadTimeBucket` returns the bucket the given load time in milliseconds
+// is placed in.
+function loadTimeBucket (value) {
+  for (var i = 0; i < loadTimeBuckets.length; i++) {
+    if (value <= loadTimeBuckets[i]) {
+      return loadTimeBuckets[i]
+    }
+  }
+  return loadTimeBuckets[loadTimeBuckets.length - 1]
+}
+
+// `navigationTiming` returns the time in milliseconds it took until the
+// DOM of the page was loaded, preferring the Navigation Timing Level 2 API
+// over the deprecated `performance.timing`. It returns null in case no
+// timing information is available.
+function navigationTiming () {
+  var performance = window.performance
+  if (!performance) {
+    return null
+  }
+  if (typeof performance.getEntriesByType === 'function') {
+    var entries = performance.getEntriesByType('navigation')
+    if (entries && entries[0] && entries[0].domContentLoadedEventEnd > 0) {
+      return Math.round(entries[0].domContentLoadedEventEnd)
+    }
+  }
+  var timing = performance.timing
+  if (timing && timing.domContentLoadedEventEnd > 0) {
+    return Math.round(timing.domContentLoadedEventEnd - timing.navigationStart)
+  }
+  return null
+}
+
+exports.custom = custom
+
+// `custom` creates an event of a custom type defined by the operator of the
+// site. In case a finite number is given as value, it is sent along with
+// the event so it can be added up or averaged.
+function custom (name, value) {
+  var canonicalLink = document.head.querySelector('link[rel="canonical"]')
+  var canonicalHref = canonicalLink && canonicalLink.getAttribute('href')
+  var event = {
+    type: 'CUSTOM',
+    name: String(name),
+    href: canonicalHref || window.location.href,
+    title: document.title
+  }
+  if (typeof value === 'number' && isFinite(value)) {
+    event.value = value
+  }
   return event
 }
+
+exports.honeypot = honeypot
+
+// `honeypot` returns true in case the script is run by a browser that is
+// remote controlled. Such pageviews are sent flagged so that the server can
+// drop and count them.
+function honeypot () {
+  return !!(
+    window.navigator.webdriver ||
+    window.callPhantom ||
+    window._phantom ||
+    window.__nightmare ||
+    window.domAutomation ||
+    window.domAutomationController
+  )
+}
+
+var utmKeys = {
+  utm_source: 'utmSource',
+  utm_medium: 'utmMedium',
+  utm_campaign: 'utmCampaign'
+}
+
+exports.utmParameters = utmParameters
+
+// `utmParameters` returns the UTM parameters found in the given query string
+// keyed by the name they are stored with in the event payload. Parameters
+// that are not present or empty are skipped.
+function utmParameters (search) {
+  var result = {}
+  var pairs = (search || '').replace(/^\?/, '').split('&')
+  for (var i = 0; i < pairs.length; i++) {
+    var pair = pairs[i].split('=')
+    var key = utmKeys[pair[0]]
+    if (!key || !pair[1]) {
+      continue
+    }
+    try {
+      result[key] = decodeURIComponent(pair[1].replace(/\+/g, ' ')).slice(0, 200)
+    } catch (err) {}
+  }
+  return result
+}
@@ -22,5 +22,58 @@ describe('src/events.js', function () {
       assert.deepStrictEqual(Object.keys(event2), ['type', 'href', 'title', 'referrer', 'pageload', 'isMobile'])
       assert.strictEqual(event2.pageload, null)
     })
+
+    it('adds the load time bucket to the initial pageview when collecting performance', function () {
+      var event = events.pageview(true, true)
+      assert.strictEqual(events.loadTimeBuckets.indexOf(event.loadTime) >= 0, true)
+
+      var event2 = events.pageview(false, true)
+      assert.strictEqual('loadTime' in event2, false)
+    })
+  })
+
+  describe('loadTimeBucket(value)', function () {
+    it('returns the bucket the value is placed in', function () {
+      assert.strictEqual(events.loadTimeBucket(0), 100)
+      assert.strictEqual(events.loadTimeBucket(100), 100)
+      assert.strictEqual(events.loadTimeBucket(101), 250)
+      assert.strictEqual(events.loadTimeBucket(3000), 5000)
+      assert.strictEqual(events.loadTimeBucket(60000), 10000)
+    })
+  })
+
+  describe('custom(name, value)', function () {
+    it('creates a custom event', function () {
+      var event = events.custom('signup')
+      assert.deepStrictEqual(Object.keys(event), ['type', 'name', 'href', 'title'])
+      assert.strictEqual(event.type, 'CUSTOM')
+      assert.strictEqual(event.name, 'signup')
+
+      assert.strictEqual(events.custom('download', 12).value, 12)
+      assert.strictEqual('value' in events.custom('download', NaN), false)
+      assert.strictEqual('value' in events.custom('download', '12'), false)
+    })
+  })
+
+  describe('honeypot()', function () {
+    it('detects remote controlled browsers', function () {
+      assert.strictEqual(events.honeypot(), false)
+      window.callPhantom = function () {}
+      assert.strictEqual(events.honeypot(), true)
+      delete window.callPhantom
+    })
+  })
+
+  describe('utmParameters(search)', function () {
+    it('returns known UTM parameters', function () {
+      assert.deepStrictEqual(
+        events.utmParameters('?utm_source=news%20letter&utm_medium=email&utm_campaign=spring+sale&utm_term=x&other=y'),
+        { utmSource: 'news letter', utmMedium: 'email', utmCampaign: 'spring sale' }
+      )
+    })
+    it('skips empty and malformed values', function () {
+      assert.deepStrictEqual(events.utmParameters('?utm_source=&utm_medium=%E0%A4%A&utm_campaign'), {})
+      assert.deepStrictEqual(events.utmParameters(''), {})
+    })
   })
 })
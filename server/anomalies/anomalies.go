@@ -0,0 +1,283 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package anomalies detects unusual spikes and drops in the traffic of
+// accounts and alerts their operators using webhooks and emails.
+package anomalies
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"math"
+	"time"
+
+	"github.com/offen/offen/server/mailer"
+	"github.com/offen/offen/server/persistence"
+	"github.com/sirupsen/logrus"
+)
+
+// Store is used for looking up the accounts that are checked, their hourly
+// rollups and the recipients of alerts.
+type Store interface {
+	LookupAnomalyDetection() ([]persistence.AnomalyDetectionResult, error)
+	GetRollups(accountID, period string, from, until time.Time) ([]persistence.RollupResult, error)
+	LookupReportSubscriptions(frequency string) ([]persistence.ReportSubscriptionResult, error)
+}
+
+// Dispatcher is used for notifying the webhooks of an account.
+type Dispatcher interface {
+	Dispatch(accountID, event string, data interface{}) error
+}
+
+// Kinds of anomalies.
+const (
+	KindSpike = "spike"
+	KindDrop  = "drop"
+)
+
+// baselineDays is the number of preceding days the traffic of an hour is
+// compared against. Comparing the same hour of each day accounts for the
+// difference between traffic at day and at night.
+const baselineDays = 7
+
+// minEvents is the number of events below which hours are not considered
+// to be anomalous, as low traffic fluctuates a lot.
+const minEvents = 10
+
+// thresholds is the number of standard deviations the traffic has to
+// deviate from the baseline by for each sensitivity.
+var thresholds = map[string]float64{
+	persistence.AnomalySensitivityLow:    4,
+	persistence.AnomalySensitivityMedium: 3,
+	persistence.AnomalySensitivityHigh:   2,
+}
+
+// Anomaly describes an hour whose number of events deviates from the
+// baseline. It is the data sent with traffic_anomaly.detected.
+type Anomaly struct {
+	Kind      string    `json:"kind"`
+	From      time.Time `json:"from"`
+	Until     time.Time `json:"until"`
+	Events    int       `json:"events"`
+	Baseline  float64   `json:"baseline"`
+	Deviation float64   `json:"deviation"`
+}
+
+// Detector checks the hourly rollups of accounts for anomalies.
+type Detector struct {
+	store      Store
+	dispatcher Dispatcher
+	mailer     mailer.Mailer
+	emails     *template.Template
+	from       string
+	logger     *logrus.Logger
+	// active contains the kind of anomaly detected for each account in the
+	// last run, so alerts are not repeated while an anomaly persists
+	active map[string]string
+}
+
+// Option is used for configuring a Detector.
+type Option func(*Detector)
+
+// WithLogger sets the logger used for reporting errors.
+func WithLogger(logger *logrus.Logger) Option {
+	return func(d *Detector) {
+		d.logger = logger
+	}
+}
+
+// New creates a Detector that notifies webhooks using the given dispatcher
+// and sends emails from the given address. emails is expected to define the
+// subject_anomaly and body_anomaly templates.
+func New(store Store, dispatcher Dispatcher, m mailer.Mailer, emails *template.Template, from string, opts ...Option) *Detector {
+	d := &Detector{
+		store:      store,
+		dispatcher: dispatcher,
+		mailer:     m,
+		emails:     emails,
+		from:       from,
+		logger:     logrus.New(),
+		active:     map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Detect checks the last hour that has ended before the given time for all
+// accounts that have enabled the detection of anomalies. Accounts that are
+// younger than the baseline are skipped. Alerts that cannot be sent are
+// logged and skipped. It returns the number of anomalies detected. Calling
+// Detect on a nil Detector is a no-op.
+func (d *Detector) Detect(now time.Time) (int, error) {
+	if d == nil {
+		return 0, nil
+	}
+	accounts, err := d.store.LookupAnomalyDetection()
+	if err != nil {
+		return 0, fmt.Errorf("anomalies: error looking up accounts: %w", err)
+	}
+	var recipients map[string][]string
+	var detected int
+	for _, account := range accounts {
+		if now.Sub(account.Created) < time.Hour*24*(baselineDays+1) {
+			continue
+		}
+		anomaly, err := d.check(account, now)
+		if err != nil {
+			d.logger.WithError(err).WithField("accountId", account.AccountID).Error("Error checking for anomalies")
+			continue
+		}
+		if anomaly == nil {
+			delete(d.active, account.AccountID)
+			continue
+		}
+		detected++
+		if d.active[account.AccountID] == anomaly.Kind {
+			continue
+		}
+		d.active[account.AccountID] = anomaly.Kind
+
+		if err := d.dispatcher.Dispatch(account.AccountID, persistence.WebhookEventTrafficAnomaly, anomaly); err != nil {
+			d.logger.WithError(err).WithField("accountId", account.AccountID).Error("Error dispatching webhooks for anomaly")
+		}
+		if recipients == nil {
+			if recipients, err = d.recipients(); err != nil {
+				d.logger.WithError(err).Error("Error looking up recipients of alerts")
+				recipients = map[string][]string{}
+			}
+		}
+		for _, to := range recipients[account.AccountID] {
+			if err := d.send(to, account, *anomaly); err != nil {
+				d.logger.WithError(err).WithField("accountId", account.AccountID).Error("Error sending alert")
+			}
+		}
+	}
+	return detected, nil
+}
+
+// check compares the number of events of the last hour that has ended
+// before now against the same hour of the preceding days. Rollups are
+// matched by their position relative to now, so this works regardless of
+// the time zone the rollups of the account are aligned to.
+func (d *Detector) check(account persistence.AnomalyDetectionResult, now time.Time) (*Anomaly, error) {
+	rollups, err := d.store.GetRollups(
+		account.AccountID, persistence.RollupPeriodHour,
+		now.Add(-time.Hour*(24*baselineDays+2)), now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("anomalies: error looking up rollups: %w", err)
+	}
+	// index 0 is the last hour, index n the same hour n days before
+	counts := make([]float64, baselineDays+1)
+	var current *persistence.RollupResult
+	for i, rollup := range rollups {
+		end := rollup.Start.Add(time.Hour)
+		if end.After(now) {
+			continue
+		}
+		age := now.Sub(end)
+		if age%(time.Hour*24) >= time.Hour {
+			continue
+		}
+		day := int(age / (time.Hour * 24))
+		if day > baselineDays {
+			continue
+		}
+		counts[day] = float64(rollup.Events)
+		if day == 0 {
+			current = &rollups[i]
+		}
+	}
+
+	var mean, variance float64
+	for _, count := range counts[1:] {
+		mean += count
+	}
+	mean /= baselineDays
+	for _, count := range counts[1:] {
+		variance += (count - mean) * (count - mean)
+	}
+	variance /= baselineDays
+	// counts are expected to vary by at least the square root of their
+	// mean, so hours with very regular traffic are not flagged for minor
+	// changes
+	sigma := math.Max(math.Sqrt(variance), math.Max(math.Sqrt(mean), 1))
+	deviation := (counts[0] - mean) / sigma
+
+	threshold, ok := thresholds[account.Sensitivity]
+	if !ok {
+		return nil, nil
+	}
+	var kind string
+	switch {
+	case deviation >= threshold && counts[0] >= minEvents:
+		kind = KindSpike
+	case deviation <= -threshold && mean >= minEvents:
+		kind = KindDrop
+	default:
+		return nil, nil
+	}
+
+	result := &Anomaly{
+		Kind:      kind,
+		Events:    int(counts[0]),
+		Baseline:  math.Round(mean*100) / 100,
+		Deviation: math.Round(deviation*100) / 100,
+	}
+	if current != nil {
+		result.From = current.Start
+	} else {
+		// there is no rollup for hours without any events
+		result.From = now.Truncate(time.Hour).Add(-time.Hour)
+	}
+	result.Until = result.From.Add(time.Hour)
+	return result, nil
+}
+
+// recipients returns the email addresses of all account users subscribed to
+// reports, by account. Email addresses of account users are only stored
+// hashed otherwise.
+func (d *Detector) recipients() (map[string][]string, error) {
+	result := map[string][]string{}
+	seen := map[string]bool{}
+	for _, frequency := range persistence.ReportFrequencies {
+		subscriptions, err := d.store.LookupReportSubscriptions(frequency)
+		if err != nil {
+			return nil, fmt.Errorf("anomalies: error looking up report subscriptions: %w", err)
+		}
+		for _, subscription := range subscriptions {
+			key := subscription.AccountID + "|" + subscription.EmailAddress
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			result[subscription.AccountID] = append(result[subscription.AccountID], subscription.EmailAddress)
+		}
+	}
+	return result, nil
+}
+
+func (d *Detector) send(to string, account persistence.AnomalyDetectionResult, anomaly Anomaly) error {
+	data := map[string]interface{}{
+		"kind":        anomaly.Kind,
+		"accountName": account.Name,
+		"from":        anomaly.From.Format("2006-01-02 15:04 MST"),
+		"until":       anomaly.Until.Format("15:04 MST"),
+		"events":      anomaly.Events,
+		"baseline":    anomaly.Baseline,
+	}
+	subject, body := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	if err := d.emails.ExecuteTemplate(subject, "subject_anomaly", data); err != nil {
+		return fmt.Errorf("anomalies: error rendering email subject: %w", err)
+	}
+	if err := d.emails.ExecuteTemplate(body, "body_anomaly", data); err != nil {
+		return fmt.Errorf("anomalies: error rendering email body: %w", err)
+	}
+	if err := d.mailer.Send(d.from, to, subject.String(), body.String()); err != nil {
+		return fmt.Errorf("anomalies: error sending email: %w", err)
+	}
+	return nil
+}
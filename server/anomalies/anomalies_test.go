@@ -0,0 +1,168 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package anomalies
+
+import (
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+	"github.com/sirupsen/logrus"
+)
+
+type mockStore struct {
+	accounts      []persistence.AnomalyDetectionResult
+	rollups       map[string][]persistence.RollupResult
+	subscriptions []persistence.ReportSubscriptionResult
+	err           error
+}
+
+func (m *mockStore) LookupAnomalyDetection() ([]persistence.AnomalyDetectionResult, error) {
+	return m.accounts, m.err
+}
+
+func (m *mockStore) GetRollups(accountID, period string, from, until time.Time) ([]persistence.RollupResult, error) {
+	var result []persistence.RollupResult
+	for _, rollup := range m.rollups[accountID] {
+		if !rollup.Start.Before(from) && rollup.Start.Before(until) {
+			result = append(result, rollup)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockStore) LookupReportSubscriptions(frequency string) ([]persistence.ReportSubscriptionResult, error) {
+	var result []persistence.ReportSubscriptionResult
+	for _, subscription := range m.subscriptions {
+		if subscription.Frequency == frequency {
+			result = append(result, subscription)
+		}
+	}
+	return result, nil
+}
+
+type mockDispatcher struct {
+	dispatched []string
+}
+
+func (m *mockDispatcher) Dispatch(accountID, event string, data interface{}) error {
+	anomaly := data.(*Anomaly)
+	m.dispatched = append(m.dispatched, strings.Join([]string{accountID, event, anomaly.Kind, anomaly.From.Format(time.RFC3339)}, "|"))
+	return nil
+}
+
+type mockMailer struct {
+	sent []string
+}
+
+func (m *mockMailer) Send(from, to, subject, body string) error {
+	m.sent = append(m.sent, strings.Join([]string{from, to, subject, body}, "|"))
+	return nil
+}
+
+// hourlyRollups returns rollups for the hour starting at the given time on
+// each of the preceding days, the first count being the most recent one.
+// Negative counts are skipped.
+func hourlyRollups(start time.Time, counts ...int) []persistence.RollupResult {
+	var result []persistence.RollupResult
+	for day, count := range counts {
+		if count < 0 {
+			continue
+		}
+		result = append(result, persistence.RollupResult{
+			Period: persistence.RollupPeriodHour,
+			Start:  start.AddDate(0, 0, -day),
+			Events: count,
+		})
+	}
+	return result
+}
+
+func TestDetector_Detect(t *testing.T) {
+	now := time.Date(2020, 6, 10, 10, 20, 0, 0, time.UTC)
+	hour := time.Date(2020, 6, 10, 9, 0, 0, 0, time.UTC)
+	created := now.AddDate(0, -1, 0)
+	emails := template.Must(template.New("emails").Parse(
+		`{{ define "subject_anomaly" }}{{ .kind }} alert{{ end }}` +
+			`{{ define "body_anomaly" }}{{ .accountName }} {{ .from }} {{ .events }} {{ .baseline }}{{ end }}`,
+	))
+	store := &mockStore{
+		accounts: []persistence.AnomalyDetectionResult{
+			{AccountID: "spike", Name: "Spike", Sensitivity: persistence.AnomalySensitivityMedium, Created: created},
+			{AccountID: "drop", Name: "Drop", Sensitivity: persistence.AnomalySensitivityLow, Created: created},
+			{AccountID: "steady", Name: "Steady", Sensitivity: persistence.AnomalySensitivityHigh, Created: created},
+			{AccountID: "low", Name: "Low", Sensitivity: persistence.AnomalySensitivityHigh, Created: created},
+			{AccountID: "young", Name: "Young", Sensitivity: persistence.AnomalySensitivityHigh, Created: now.AddDate(0, 0, -2)},
+		},
+		rollups: map[string][]persistence.RollupResult{
+			"spike": append(
+				hourlyRollups(hour, 80, 20, 22, 18, 20, 21, 19, 20),
+				// other hours are not part of the baseline
+				hourlyRollups(hour.Add(-time.Hour), 500, 500)...,
+			),
+			"drop":   hourlyRollups(hour, -1, 20, 22, 18, 20, 21, 19, 20),
+			"steady": hourlyRollups(hour, 23, 20, 22, 18, 20, 21, 19, 20),
+			"low":    hourlyRollups(hour, 8, 1, 0, 1, 0, 1, 0, 1),
+			"young":  hourlyRollups(hour, 80, 1),
+		},
+		subscriptions: []persistence.ReportSubscriptionResult{
+			{AccountID: "spike", EmailAddress: "develop@offen.dev", Frequency: persistence.ReportFrequencyWeekly},
+			{AccountID: "spike", EmailAddress: "develop@offen.dev", Frequency: persistence.ReportFrequencyMonthly},
+			{AccountID: "steady", EmailAddress: "steady@offen.dev", Frequency: persistence.ReportFrequencyWeekly},
+		},
+	}
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	t.Run("ok", func(t *testing.T) {
+		dispatcher, m := &mockDispatcher{}, &mockMailer{}
+		d := New(store, dispatcher, m, emails, "alerts@offen.dev", WithLogger(logger))
+		detected, err := d.Detect(now)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if detected != 2 {
+			t.Errorf("Expected 2 anomalies, got %d", detected)
+		}
+		expectedDispatches := []string{
+			"spike|traffic_anomaly.detected|spike|2020-06-10T09:00:00Z",
+			"drop|traffic_anomaly.detected|drop|2020-06-10T09:00:00Z",
+		}
+		if !reflect.DeepEqual(expectedDispatches, dispatcher.dispatched) {
+			t.Errorf("Expected %v, got %v", expectedDispatches, dispatcher.dispatched)
+		}
+		expectedEmails := []string{
+			"alerts@offen.dev|develop@offen.dev|spike alert|Spike 2020-06-10 09:00 UTC 80 20",
+		}
+		if !reflect.DeepEqual(expectedEmails, m.sent) {
+			t.Errorf("Expected %v, got %v", expectedEmails, m.sent)
+		}
+
+		// alerts are not repeated while the anomaly persists
+		detected, err = d.Detect(now.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if detected != 2 || len(dispatcher.dispatched) != 2 || len(m.sent) != 1 {
+			t.Errorf("Unexpected repeated alerts %v %v", dispatcher.dispatched, m.sent)
+		}
+	})
+	t.Run("store error", func(t *testing.T) {
+		d := New(&mockStore{err: errors.New("did not work")}, &mockDispatcher{}, &mockMailer{}, emails, "alerts@offen.dev", WithLogger(logger))
+		if _, err := d.Detect(now); err == nil {
+			t.Error("Expected error, got nil")
+		}
+	})
+	t.Run("nil detector", func(t *testing.T) {
+		var d *Detector
+		if detected, err := d.Detect(now); detected != 0 || err != nil {
+			t.Errorf("Unexpected result %v %v", detected, err)
+		}
+	})
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/jinzhu/gorm"
 	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/keys"
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,6 +19,9 @@ type app struct {
 
 func newApp(populateMissing, quiet bool, envFileOverride string) *app {
 	logger := logrus.New()
+	if err := keys.CheckEntropySource(); err != nil {
+		logger.WithError(err).Fatal("Error checking source of randomness")
+	}
 	cfg, cfgErr := config.New(populateMissing, envFileOverride)
 	if cfgErr != nil {
 		if errors.Is(cfgErr, config.ErrPopulatedMissing) {
@@ -27,7 +31,7 @@ func newApp(populateMissing, quiet bool, envFileOverride string) *app {
 		}
 	}
 
-	logger.SetLevel(cfg.App.LogLevel.LogLevel())
+	logger = cfg.NewLogger("")
 	if !quiet && !cfg.SMTPConfigured() {
 		logger.Warn("SMTP for transactional email is not configured right now, mail delivery will be unreliable")
 		logger.Warn("Refer to the documentation to find out how to configure SMTP")
@@ -5,7 +5,6 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -88,6 +87,8 @@ func cmdDemo(subcommand string, flags []string) {
 	}
 	db, err := persistence.New(
 		relational.NewRelationalDAL(gormDB),
+		persistence.WithKDFParams(a.config.KDFParams()),
+		persistence.WithRotationSecret(a.config.Secret.Bytes()),
 	)
 	if err != nil {
 		a.logger.WithError(err).Fatal("Unable to create persistence layer")
@@ -205,7 +206,7 @@ func cmdDemo(subcommand string, flags []string) {
 		Addr: fmt.Sprintf("0.0.0.0:%d", a.config.Server.Port),
 		Handler: router.New(
 			router.WithDatabase(db),
-			router.WithLogger(a.logger),
+			router.WithLogger(a.config.NewLogger("router")),
 			router.WithTemplate(tpl),
 			router.WithEmails(emails),
 			router.WithConfig(a.config),
@@ -224,7 +225,7 @@ func cmdDemo(subcommand string, flags []string) {
 	a.logger.Infof("")
 	a.logger.Infof("in your browser.")
 
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
@@ -238,11 +239,7 @@ func cmdDemo(subcommand string, flags []string) {
 }
 
 func mustSecret(length int) []byte {
-	secret, err := keys.GenerateRandomValue(16)
-	if err != nil {
-		panic(err)
-	}
-	b, err := base64.StdEncoding.DecodeString(secret)
+	b, err := keys.GenerateRandomBytes(length)
 	if err != nil {
 		panic(err)
 	}
@@ -48,5 +48,5 @@ func cmdExpire(subcommand string, flags []string) {
 	if err != nil {
 		a.logger.WithError(err).Fatalf("Error pruning expired events")
 	}
-	a.logger.WithField("removed", affected).Info("Successfully expired events")
+	a.logger.WithField("removed", affected.Removed).Info("Successfully expired events")
 }
@@ -0,0 +1,89 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/offen/offen/server/importer"
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/persistence/relational"
+)
+
+var importUsage = `
+"import" reads historical data exported from Matomo or Google Analytics and
+stores it as daily rollups of the given account, so metrics collected before
+switching to Offen remain available. Exports are expected to contain a row
+per day. Only the number of events and users is imported, and imported
+rollups are flagged as such. Days that already have a rollup computed from
+events are skipped, days that have been imported before are replaced.
+
+Usage of "import":
+`
+
+func cmdImport(subcommand string, flags []string) {
+	cmd := flag.NewFlagSet(subcommand, flag.ExitOnError)
+	cmd.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), importUsage)
+		cmd.PrintDefaults()
+	}
+	var (
+		envFile   = cmd.String("envfile", "", "the env file to use")
+		accountID = cmd.String("account", "", "the id of the account to import data for")
+		source    = cmd.String("source", "", fmt.Sprintf("the tool the data has been exported from (one of %s)", strings.Join(persistence.ImportSources, ", ")))
+		file      = cmd.String("file", "", "the file containing the exported data")
+		dryRun    = cmd.Bool("dry-run", false, "report the days that would be imported without saving them")
+	)
+	cmd.Parse(flags)
+	a := newApp(false, true, *envFile)
+
+	if *accountID == "" || *source == "" || *file == "" {
+		a.logger.Fatal("Flags -account, -source and -file are required")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		a.logger.WithError(err).Fatal("Error opening file")
+	}
+	days, err := importer.Parse(*source, f)
+	f.Close()
+	if err != nil {
+		a.logger.WithError(err).Fatal("Error reading exported data")
+	}
+
+	gormDB, dbErr := newDB(a.config)
+	if dbErr != nil {
+		a.logger.WithError(dbErr).Fatal("Error establishing database connection")
+	}
+
+	db, err := persistence.New(
+		relational.NewRelationalDAL(gormDB),
+	)
+	if err != nil {
+		a.logger.WithError(err).Fatalf("Error setting up database")
+	}
+
+	result, err := db.ImportRollups(*accountID, *source, days, *dryRun)
+	if err != nil {
+		a.logger.WithError(err).Fatalf("Error importing data")
+	}
+	logger := a.logger.
+		WithField("account", result.AccountID).
+		WithField("source", result.Source).
+		WithField("imported", result.Imported).
+		WithField("skipped", result.Skipped)
+	if result.From != nil && result.Until != nil {
+		logger = logger.
+			WithField("from", result.From.Format("2006-01-02")).
+			WithField("until", result.Until.Format("2006-01-02"))
+	}
+	if result.DryRun {
+		logger.Info("Found days to import, run again without -dry-run to save them")
+		return
+	}
+	logger.WithField("audit", "import").Info("Successfully imported data")
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/persistence/relational"
+)
+
+var purgeUsage = `
+"purge" deletes all events of the given account that have been recorded
+before the given date, e.g. for cleaning up test data. Rollups computed from
+the deleted events are kept. Run it using -dry-run first to see how many
+events would be deleted. This is a destructive operation and cannot be undone.
+
+Usage of "purge":
+`
+
+func cmdPurge(subcommand string, flags []string) {
+	cmd := flag.NewFlagSet(subcommand, flag.ExitOnError)
+	cmd.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), purgeUsage)
+		cmd.PrintDefaults()
+	}
+	var (
+		envFile   = cmd.String("envfile", "", "the env file to use")
+		accountID = cmd.String("account", "", "the id of the account to purge events of")
+		before    = cmd.String("before", "", "delete events recorded before this date, given as YYYY-MM-DD in UTC or in RFC 3339 format")
+		dryRun    = cmd.Bool("dry-run", false, "report the matching events without deleting them")
+	)
+	cmd.Parse(flags)
+	a := newApp(false, true, *envFile)
+
+	if *accountID == "" || *before == "" {
+		a.logger.Fatal("Flags -account and -before are required")
+	}
+	deadline, err := parseDateFlag(*before)
+	if err != nil {
+		a.logger.WithError(err).Fatal("Error parsing -before")
+	}
+	if deadline.After(time.Now()) {
+		a.logger.Fatal("Flag -before is expected to be in the past")
+	}
+
+	gormDB, dbErr := newDB(a.config)
+	if dbErr != nil {
+		a.logger.WithError(dbErr).Fatal("Error establishing database connection")
+	}
+
+	db, err := persistence.New(
+		relational.NewRelationalDAL(gormDB),
+	)
+	if err != nil {
+		a.logger.WithError(err).Fatalf("Error setting up database")
+	}
+
+	result, err := db.PurgeEvents(*accountID, deadline, *dryRun)
+	if err != nil {
+		a.logger.WithError(err).Fatalf("Error purging events")
+	}
+	logger := a.logger.
+		WithField("account", result.AccountID).
+		WithField("before", result.Before.Format(time.RFC3339)).
+		WithField("events", result.Events).
+		WithField("users", result.Users)
+	if result.Oldest != nil && result.Newest != nil {
+		logger = logger.
+			WithField("oldest", result.Oldest.Format(time.RFC3339)).
+			WithField("newest", result.Newest.Format(time.RFC3339))
+	}
+	if result.DryRun {
+		logger.Info("Found matching events, run again without -dry-run to delete them")
+		return
+	}
+	logger.WithField("audit", "purge").Info("Successfully purged events")
+}
+
+// parseDateFlag parses the given value as a date in UTC or as a timestamp
+// in RFC 3339 format.
+func parseDateFlag(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected date of format YYYY-MM-DD or RFC 3339, got %q", value)
+	}
+	return t, nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/persistence/relational"
+)
+
+var rebuildUsage = `
+"rebuild" computes rollups again from the events stored in the connected
+database, e.g. after the way events are aggregated has been changed or fixed.
+It processes accounts one by one, each day by day, and reports its progress.
+In case a run is interrupted, it can be resumed by passing the last reported
+cursor using the -after flag. When receiving SIGINT or SIGTERM, the current
+day is finished before exiting. Passing -checkpoint stores the progress in the
+given file after each day and resumes from it when the command is run again.
+Encrypted payloads stored by clients and imported rollups are kept.
+
+Usage of "rebuild":
+`
+
+func cmdRebuild(subcommand string, flags []string) {
+	cmd := flag.NewFlagSet(subcommand, flag.ExitOnError)
+	cmd.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), rebuildUsage)
+		cmd.PrintDefaults()
+	}
+	var (
+		envFile    = cmd.String("envfile", "", "the env file to use")
+		accountID  = cmd.String("account", "", "only rebuild the rollups of the account with the given id")
+		from       = cmd.String("from", "", "rebuild rollups starting at this date, given as YYYY-MM-DD in UTC or in RFC 3339 format (defaults to the start of the retention period)")
+		until      = cmd.String("until", "", "rebuild rollups ending before this date, given as YYYY-MM-DD in UTC or in RFC 3339 format (defaults to now)")
+		after      = cmd.String("after", "", "resume after the given cursor")
+		pause      = cmd.Duration("pause", time.Millisecond*100, "the duration to wait in between days")
+		checkpoint = cmd.String("checkpoint", "", "the file to store progress in for resuming interrupted runs")
+	)
+	cmd.Parse(flags)
+	a := newApp(false, true, *envFile)
+
+	now := time.Now()
+	rangeFrom, rangeUntil := now.Add(-config.EventRetention), now
+	if *from != "" {
+		value, err := parseDateFlag(*from)
+		if err != nil {
+			a.logger.WithError(err).Fatal("Error parsing -from")
+		}
+		rangeFrom = value
+	}
+	if *until != "" {
+		value, err := parseDateFlag(*until)
+		if err != nil {
+			a.logger.WithError(err).Fatal("Error parsing -until")
+		}
+		rangeUntil = value
+	}
+
+	gormDB, dbErr := newDB(a.config)
+	if dbErr != nil {
+		a.logger.WithError(dbErr).Fatal("Error establishing database connection")
+	}
+
+	db, err := persistence.New(
+		relational.NewRelationalDAL(gormDB),
+	)
+	if err != nil {
+		a.logger.WithError(err).Fatalf("Error setting up database")
+	}
+
+	if *checkpoint != "" && *after == "" {
+		if value, err := ioutil.ReadFile(*checkpoint); err == nil {
+			*after = strings.TrimSpace(string(value))
+			a.logger.WithField("after", *after).Info("Resuming from checkpoint")
+		} else if !os.IsNotExist(err) {
+			a.logger.WithError(err).Fatal("Error reading checkpoint")
+		}
+	}
+	saveCheckpoint := func(cursor string) {
+		if *checkpoint == "" || cursor == "" {
+			return
+		}
+		if err := ioutil.WriteFile(*checkpoint, []byte(cursor), 0600); err != nil {
+			a.logger.WithError(err).Error("Error writing checkpoint")
+		}
+	}
+
+	done := make(chan struct{})
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-quit
+		a.logger.Info("Received signal, stopping after the current day")
+		close(done)
+	}()
+
+	result, err := db.RebuildRollups(persistence.RebuildOptions{
+		AccountID: *accountID,
+		From:      rangeFrom,
+		Until:     rangeUntil,
+		After:     *after,
+		Pause:     *pause,
+		Done:      done,
+		Progress: func(p persistence.RebuildProgress) {
+			saveCheckpoint(p.Cursor)
+			a.logger.
+				WithField("after", p.Cursor).
+				WithField("days", p.Days).
+				WithField("rollups", p.Rollups).
+				Info("Finished day")
+		},
+	})
+	if errors.Is(err, persistence.ErrRebuildInterrupted) {
+		saveCheckpoint(result.Cursor)
+		a.logger.
+			WithField("after", result.Cursor).
+			WithField("days", result.Days).
+			WithField("rollups", result.Rollups).
+			Info("Interrupted rebuilding rollups, resume by passing the given value for -after")
+		return
+	}
+	if err != nil {
+		saveCheckpoint(result.Cursor)
+		a.logger.
+			WithError(err).
+			WithField("after", result.Cursor).
+			Fatal("Error rebuilding rollups, resume by passing the given value for -after")
+	}
+	if *checkpoint != "" {
+		if err := os.Remove(*checkpoint); err != nil && !os.IsNotExist(err) {
+			a.logger.WithError(err).Error("Error removing checkpoint")
+		}
+	}
+	a.logger.
+		WithField("days", result.Days).
+		WithField("rollups", result.Rollups).
+		Info("Successfully rebuilt rollups")
+}
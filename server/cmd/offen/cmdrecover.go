@@ -0,0 +1,88 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/persistence/relational"
+)
+
+var recoverUsage = `
+"recover" grants the account user with the given email address access to an
+account using the key that has been escrowed for it. This requires key escrow
+to have been enabled using OFFEN_ESCROW_PUBLICKEY and the matching private key
+in JWK format. The account user can access the account after their next login.
+Each recovery is logged including the account, the account user and the id
+of the escrow key that was used.
+
+Usage of "recover":
+`
+
+func cmdRecover(subcommand string, flags []string) {
+	cmd := flag.NewFlagSet(subcommand, flag.ExitOnError)
+	cmd.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), recoverUsage)
+		cmd.PrintDefaults()
+	}
+	var (
+		envFile   = cmd.String("envfile", "", "the env file to use")
+		accountID = cmd.String("account", "", "the id of the account to recover")
+		email     = cmd.String("email", "", "the email address of the account user that is granted access")
+		keyFile   = cmd.String("keyfile", "", "the file containing the escrow private key")
+	)
+	cmd.Parse(flags)
+	a := newApp(false, true, *envFile)
+
+	if *accountID == "" || *email == "" || *keyFile == "" {
+		a.logger.Fatal("Flags -account, -email and -keyfile are required")
+	}
+
+	privateKey, err := ioutil.ReadFile(*keyFile)
+	if err != nil {
+		a.logger.WithError(err).Fatal("Error reading escrow private key")
+	}
+
+	gormDB, dbErr := newDB(a.config)
+	if dbErr != nil {
+		a.logger.WithError(dbErr).Fatal("Error establishing database connection")
+	}
+
+	kmsProvider, err := a.config.NewKMSProvider()
+	if err != nil {
+		a.logger.WithError(err).Fatal("Error creating KMS provider")
+	}
+	escrowKey, err := a.config.NewEscrowKey()
+	if err != nil {
+		a.logger.WithError(err).Fatal("Error reading escrow key")
+	}
+
+	db, err := persistence.New(
+		relational.NewRelationalDAL(gormDB),
+		persistence.WithKDFParams(a.config.KDFParams()),
+		persistence.WithPepper(a.config.Pepper.Bytes()),
+		persistence.WithEmailLookup(a.config.NewEmailLookup()),
+		persistence.WithRotationSecret(a.config.Secret.Bytes(), a.config.PreviousSecretBytes()...),
+		persistence.WithKMSProvider(kmsProvider),
+		persistence.WithEscrowKey(escrowKey),
+	)
+	if err != nil {
+		a.logger.WithError(err).Fatalf("Error setting up database")
+	}
+
+	logger := a.logger.
+		WithField("audit", "escrow").
+		WithField("account", *accountID).
+		WithField("email", *email)
+	if escrowKey != nil {
+		logger = logger.WithField("keyID", escrowKey.ID())
+	}
+	if err := db.RecoverAccount(*accountID, *email, privateKey); err != nil {
+		logger.WithError(err).Fatal("Error recovering account")
+	}
+	logger.Info("Successfully recovered account")
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/persistence/relational"
+)
+
+var rewrapUsage = `
+"rewrap" wraps all key encryption keys stored in the connected database that
+have not yet been wrapped using the configured KMS provider. Pending key
+rotations that have been encrypted using one of the previous secrets are
+encrypted using the current secret. Envelopes that are encrypted using keys
+derived from the credentials of account users are upgraded when they log in
+instead. It processes
+relationships in batches and reports its progress. In case a run is
+interrupted, it can be resumed by passing the last reported relationship id
+using the -after flag. When receiving SIGINT or SIGTERM, the current batch is
+finished before exiting. Passing -checkpoint stores the progress in the given
+file after each batch and resumes from it when the command is run again.
+
+Usage of "rewrap":
+`
+
+func cmdRewrap(subcommand string, flags []string) {
+	cmd := flag.NewFlagSet(subcommand, flag.ExitOnError)
+	cmd.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), rewrapUsage)
+		cmd.PrintDefaults()
+	}
+	var (
+		envFile    = cmd.String("envfile", "", "the env file to use")
+		after      = cmd.String("after", "", "resume after the relationship with the given id")
+		batchSize  = cmd.Int("batch", 100, "the number of relationships to process in a single batch")
+		pause      = cmd.Duration("pause", time.Second, "the duration to wait in between batches")
+		checkpoint = cmd.String("checkpoint", "", "the file to store progress in for resuming interrupted runs")
+	)
+	cmd.Parse(flags)
+	a := newApp(false, true, *envFile)
+
+	gormDB, dbErr := newDB(a.config)
+	if dbErr != nil {
+		a.logger.WithError(dbErr).Fatal("Error establishing database connection")
+	}
+
+	kmsProvider, err := a.config.NewKMSProvider()
+	if err != nil {
+		a.logger.WithError(err).Fatal("Error creating KMS provider")
+	}
+	if kmsProvider == nil && len(a.config.PreviousSecretBytes()) == 0 {
+		a.logger.Fatal("Neither a KMS provider nor previous secrets are configured, cannot continue")
+	}
+
+	db, err := persistence.New(
+		relational.NewRelationalDAL(gormDB),
+		persistence.WithKMSProvider(kmsProvider),
+		persistence.WithRotationSecret(a.config.Secret.Bytes(), a.config.PreviousSecretBytes()...),
+	)
+	if err != nil {
+		a.logger.WithError(err).Fatalf("Error setting up database")
+	}
+
+	if *checkpoint != "" && *after == "" {
+		if value, err := ioutil.ReadFile(*checkpoint); err == nil {
+			*after = strings.TrimSpace(string(value))
+			a.logger.WithField("after", *after).Info("Resuming from checkpoint")
+		} else if !os.IsNotExist(err) {
+			a.logger.WithError(err).Fatal("Error reading checkpoint")
+		}
+	}
+	saveCheckpoint := func(relationshipID string) {
+		if *checkpoint == "" {
+			return
+		}
+		if err := ioutil.WriteFile(*checkpoint, []byte(relationshipID), 0600); err != nil {
+			a.logger.WithError(err).Error("Error writing checkpoint")
+		}
+	}
+
+	done := make(chan struct{})
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-quit
+		a.logger.Info("Received signal, stopping after the current batch")
+		close(done)
+	}()
+
+	result, err := db.RewrapKeys(persistence.RewrapOptions{
+		After:     *after,
+		BatchSize: *batchSize,
+		Pause:     *pause,
+		Done:      done,
+		Progress: func(p persistence.RewrapProgress) {
+			saveCheckpoint(p.LastRelationshipID)
+			a.logger.
+				WithField("after", p.LastRelationshipID).
+				WithField("processed", p.Processed).
+				WithField("rewrapped", p.Rewrapped).
+				Info("Finished batch")
+		},
+	})
+	if errors.Is(err, persistence.ErrRewrapInterrupted) {
+		saveCheckpoint(result.LastRelationshipID)
+		a.logger.
+			WithField("after", result.LastRelationshipID).
+			WithField("processed", result.Processed).
+			WithField("rewrapped", result.Rewrapped).
+			Info("Interrupted rewrapping keys, resume by passing the given value for -after")
+		return
+	}
+	if err != nil {
+		saveCheckpoint(result.LastRelationshipID)
+		a.logger.
+			WithError(err).
+			WithField("after", result.LastRelationshipID).
+			Fatal("Error rewrapping keys, resume by passing the given value for -after")
+	}
+	if *checkpoint != "" {
+		if err := os.Remove(*checkpoint); err != nil && !os.IsNotExist(err) {
+			a.logger.WithError(err).Error("Error removing checkpoint")
+		}
+	}
+	a.logger.
+		WithField("processed", result.Processed).
+		WithField("rewrapped", result.Rewrapped).
+		Info("Successfully rewrapped keys")
+}
@@ -10,15 +10,21 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/offen/offen/server/anomalies"
 	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/keys"
 	"github.com/offen/offen/server/locales"
 	"github.com/offen/offen/server/persistence"
 	"github.com/offen/offen/server/persistence/relational"
 	"github.com/offen/offen/server/public"
+	"github.com/offen/offen/server/reports"
 	"github.com/offen/offen/server/router"
+	"github.com/offen/offen/server/tracing"
+	"github.com/offen/offen/server/webhooks"
 	"golang.org/x/crypto/acme/autocert"
 )
 
@@ -54,14 +60,84 @@ func cmdServe(subcommand string, flags []string) {
 	)
 	cmd.Parse(flags)
 	a := newApp(false, false, *envFile)
+	if keys.FIPSMode() {
+		a.logger.Info("Running in FIPS mode, only approved cryptographic primitives will be used")
+	}
 
 	gormDB, err := newDB(a.config)
 	if err != nil {
 		a.logger.WithError(err).Fatal("Unable to establish database connection")
 	}
+	if a.config.Metrics.Enabled {
+		relational.ObserveQueries(gormDB)
+	}
+	var tracer *tracing.Tracer
+	if a.config.Tracing.Endpoint != "" {
+		tracer = tracing.New(
+			tracing.NewOTLPExporter(a.config.Tracing.Endpoint, a.config.Tracing.ServiceName),
+			tracing.WithSampleRatio(a.config.Tracing.SampleRatio),
+			tracing.WithLogger(a.logger),
+		)
+		tracing.SetDefault(tracer)
+		relational.TraceQueries(gormDB)
+		a.logger.WithField("endpoint", a.config.Tracing.Endpoint).Info("Exporting traces")
+	}
+
+	kmsProvider, err := a.config.NewKMSProvider()
+	if err != nil {
+		a.logger.WithError(err).Fatal("Unable to create KMS provider")
+	}
+
+	escrowKey, err := a.config.NewEscrowKey()
+	if err != nil {
+		a.logger.WithError(err).Fatal("Unable to read escrow key")
+	}
+	if escrowKey != nil {
+		a.logger.WithField("keyID", escrowKey.ID()).Info("Key escrow is enabled")
+	}
+
+	var breachChecker persistence.BreachChecker
+	if client := a.config.NewBreachChecker(); client != nil {
+		breachChecker = client
+		a.logger.Info("Checking new passwords against breached passwords")
+	}
+
+	var passwordAuthenticator persistence.PasswordAuthenticator
+	ldapAuthenticator, err := a.config.NewLDAPAuthenticator()
+	if err != nil {
+		a.logger.WithError(err).Fatal("Unable to create LDAP authenticator")
+	}
+	if ldapAuthenticator != nil {
+		passwordAuthenticator = ldapAuthenticator
+		a.logger.Info("Verifying passwords against LDAP directory")
+	}
+
+	var samplingThreshold int
+	if a.config.Sampling.Enabled {
+		samplingThreshold = a.config.Sampling.Threshold
+		a.logger.WithField("threshold", samplingThreshold).Info("Sampling events of accounts exceeding the hourly threshold")
+	}
 
 	db, err := persistence.New(
 		relational.NewRelationalDAL(gormDB),
+		persistence.WithKDFParams(a.config.KDFParams()),
+		persistence.WithPepper(a.config.Pepper.Bytes()),
+		persistence.WithPasswordMinScore(a.config.Password.MinScore),
+		persistence.WithPasswordPolicy(persistence.PasswordPolicy{
+			MinLength:        a.config.Password.MinLength,
+			CharacterClasses: a.config.Password.CharacterClasses,
+			Banned:           a.config.Password.Banned,
+			HistoryDepth:     a.config.Password.History,
+		}),
+		persistence.WithPasswordMaxAge(a.config.Password.MaxAge),
+		persistence.WithBreachChecker(breachChecker),
+		persistence.WithPasswordAuthenticator(passwordAuthenticator),
+		persistence.WithEmailLookup(a.config.NewEmailLookup()),
+		persistence.WithRotationSecret(a.config.Secret.Bytes(), a.config.PreviousSecretBytes()...),
+		persistence.WithKMSProvider(kmsProvider),
+		persistence.WithEscrowKey(escrowKey),
+		persistence.WithLogger(a.config.NewLogger("persistence")),
+		persistence.WithSampling(samplingThreshold),
 	)
 	if err != nil {
 		a.logger.WithError(err).Fatal("Unable to create persistence layer")
@@ -75,6 +151,34 @@ func cmdServe(subcommand string, flags []string) {
 		}
 	}
 
+	signingKeys, err := a.config.NewSigningKeyring(
+		keys.WithKeyringLogger(a.config.NewLogger("keys")),
+	)
+	if err != nil {
+		a.logger.WithError(err).Fatal("Unable to create signing keyring")
+	}
+
+	oidcProvider := a.config.NewOIDCProvider()
+	if oidcProvider != nil {
+		a.logger.WithField("issuer", a.config.OIDC.Issuer).Info("Single sign-on is enabled")
+	}
+
+	samlServiceProvider, err := a.config.NewSAMLServiceProvider()
+	if err != nil {
+		a.logger.WithError(err).Fatal("Unable to create SAML service provider")
+	}
+	if samlServiceProvider != nil {
+		a.logger.Info("Single sign-on using SAML is enabled")
+	}
+
+	geoIP, err := a.config.NewGeoIPReader()
+	if err != nil {
+		a.logger.WithError(err).Fatal("Unable to read GeoIP database")
+	}
+	if geoIP != nil {
+		a.logger.Info("Country hints are enabled")
+	}
+
 	fs := public.NewLocalizedFS(a.config.App.Locale.String())
 	gettext, gettextErr := locales.GettextFor(a.config.App.Locale.String())
 	if gettextErr != nil {
@@ -89,32 +193,65 @@ func cmdServe(subcommand string, flags []string) {
 		a.logger.WithError(emailErr).Fatal("Failed parsing template files, cannot continue")
 	}
 
+	dispatcher := webhooks.New(db, webhooks.WithLogger(a.config.NewLogger("webhooks")))
+	reportSender := reports.New(db, a.config.NewMailer(), emails, a.config.SMTP.Sender, reports.WithLogger(a.config.NewLogger("reports")))
+	detector := anomalies.New(db, dispatcher, a.config.NewMailer(), emails, a.config.SMTP.Sender, anomalies.WithLogger(a.config.NewLogger("anomalies")))
+
+	// streams of live events never become idle, so they are ended
+	// explicitly when shutting down
+	streamsDone := make(chan struct{})
 	srv := &http.Server{
 		Addr: fmt.Sprintf("0.0.0.0:%d", a.config.Server.Port),
 		Handler: router.New(
 			router.WithDatabase(db),
-			router.WithLogger(a.logger),
+			router.WithLogger(a.config.NewLogger("router")),
 			router.WithTemplate(tpl),
 			router.WithEmails(emails),
 			router.WithConfig(a.config),
 			router.WithFS(fs),
 			router.WithMailer(a.config.NewMailer()),
+			router.WithSigningKeyring(signingKeys),
+			router.WithOIDCProvider(oidcProvider),
+			router.WithSAMLServiceProvider(samlServiceProvider),
+			router.WithShutdown(streamsDone),
+			router.WithWebhooks(dispatcher),
+			router.WithGeoIP(geoIP),
 		),
 	}
+	srv.RegisterOnShutdown(func() {
+		close(streamsDone)
+	})
+	// certificates are only requested in case no certificate has been given
+	var certManager *autocert.Manager
+	if a.config.Server.SSLCertificate == "" || a.config.Server.SSLKey == "" {
+		var certErr error
+		certManager, certErr = a.config.NewCertManager()
+		if certErr != nil {
+			a.logger.WithError(certErr).Fatal("Unable to create certificate manager")
+		}
+	}
+	// HTTP-01 challenges are answered on port 80, all other requests are
+	// redirected to https
+	var challengeSrv *http.Server
+	if certManager != nil {
+		challengeSrv = &http.Server{
+			Addr:    ":http",
+			Handler: certManager.HTTPHandler(nil),
+		}
+		go func() {
+			if err := challengeSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				a.logger.WithError(err).Fatal("Error binding server for ACME challenges to network")
+			}
+		}()
+	}
 	go func() {
 		if a.config.Server.SSLCertificate != "" && a.config.Server.SSLKey != "" {
 			err := srv.ListenAndServeTLS(a.config.Server.SSLCertificate.String(), a.config.Server.SSLKey.String())
 			if err != nil && err != http.ErrServerClosed {
 				a.logger.WithError(err).Fatal("Error binding server to network")
 			}
-		} else if len(a.config.Server.AutoTLS) != 0 {
-			m := autocert.Manager{
-				Prompt:     autocert.AcceptTOS,
-				HostPolicy: autocert.HostWhitelist(a.config.Server.AutoTLS...),
-				Cache:      autocert.DirCache(a.config.Server.CertificateCache),
-			}
-			go http.ListenAndServe(":http", m.HTTPHandler(nil))
-			if err := http.Serve(m.Listener(), srv.Handler); err != nil && err != http.ErrServerClosed {
+		} else if certManager != nil {
+			if err := srv.Serve(certManager.Listener()); err != nil && err != http.ErrServerClosed {
 				a.logger.WithError(err).Fatal("Error binding server to network")
 			}
 		} else {
@@ -123,40 +260,130 @@ func cmdServe(subcommand string, flags []string) {
 			}
 		}
 	}()
-	if len(a.config.Server.AutoTLS) != 0 {
+	if certManager != nil {
 		a.logger.Info("Server now listening on port 80 and 443 using AutoTLS")
 	} else {
 		a.logger.Infof("Server now listening on port %d", a.config.Server.Port)
 	}
 
+	if a.config.VaultConfigured() {
+		go a.config.NewVaultClient().KeepAlive(context.Background(), func(err error) {
+			a.logger.WithError(err).Error("Error renewing Vault token")
+		})
+	}
+
+	// jobs is used for waiting on running jobs before exiting, stopJobs
+	// signals that no new runs are to be started
+	var jobs sync.WaitGroup
+	stopJobs := make(chan struct{})
 	if a.config.App.SingleNode {
-		hourlyJob := time.Tick(time.Hour)
-		runOnInit := make(chan bool)
+		jobs.Add(1)
 		go func() {
+			defer jobs.Done()
+			hourlyJob := time.NewTicker(time.Hour)
+			defer hourlyJob.Stop()
+			// weekly aggregates and reports are dispatched once the first
+			// run after the beginning of a week or month happens, periods
+			// that have started before the server has been started are
+			// skipped
+			nextWeek := webhooks.StartOfWeek(time.Now()).AddDate(0, 0, 7)
+			nextMonth := reports.StartOfMonth(time.Now()).AddDate(0, 1, 0)
 			for {
-				select {
-				case <-hourlyJob:
-				case <-runOnInit:
-				}
+				// expiring events happens in a single transaction, so a run
+				// is either completed or not applied at all
 				affected, err := db.Expire(config.EventRetention)
 				if err != nil {
 					a.logger.WithError(err).Errorf("Error pruning expired events")
 					return
 				}
-				a.logger.WithField("removed", affected).Info("Cron successfully pruned expired events")
+				a.logger.WithField("removed", affected.Removed).Info("Cron successfully pruned expired events")
+				if err := dispatcher.DispatchRetentionPurge(affected); err != nil {
+					a.logger.WithError(err).Error("Error dispatching webhooks for pruned events")
+				}
+				// rollups of the previous day are recomputed too, so events
+				// that arrived late or have been deleted are reflected
+				now := time.Now().UTC()
+				yesterday := time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, time.UTC)
+				if computed, err := db.ComputeRollups(yesterday, now); err != nil {
+					a.logger.WithError(err).Error("Error computing rollups")
+				} else {
+					a.logger.WithField("computed", computed).Info("Cron successfully computed rollups")
+				}
+				// anomalies are detected using the hourly rollups that have
+				// just been computed
+				if detected, err := detector.Detect(time.Now()); err != nil {
+					a.logger.WithError(err).Error("Error detecting traffic anomalies")
+				} else {
+					a.logger.WithField("detected", detected).Info("Cron successfully checked for traffic anomalies")
+				}
+				if now := time.Now(); !now.Before(nextWeek) {
+					if err := dispatcher.DispatchWeeklyAggregates(now); err != nil {
+						a.logger.WithError(err).Error("Error dispatching webhooks for weekly aggregates")
+					}
+					if sent, err := reportSender.Send(persistence.ReportFrequencyWeekly, now); err != nil {
+						a.logger.WithError(err).Error("Error sending weekly reports")
+					} else {
+						a.logger.WithField("sent", sent).Info("Cron successfully sent weekly reports")
+					}
+					nextWeek = webhooks.StartOfWeek(now).AddDate(0, 0, 7)
+				}
+				if now := time.Now(); !now.Before(nextMonth) {
+					if sent, err := reportSender.Send(persistence.ReportFrequencyMonthly, now); err != nil {
+						a.logger.WithError(err).Error("Error sending monthly reports")
+					} else {
+						a.logger.WithField("sent", sent).Info("Cron successfully sent monthly reports")
+					}
+					nextMonth = reports.StartOfMonth(now).AddDate(0, 1, 0)
+				}
+				select {
+				case <-hourlyJob.C:
+				case <-stopJobs:
+					return
+				}
 			}
 		}()
-		runOnInit <- true
 	}
 
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	a.logger.
+		WithField("timeout", a.config.Server.ShutdownTimeout).
+		Info("Shutting down, waiting for in-flight requests and running jobs")
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.Server.ShutdownTimeout)
 	defer cancel()
+
+	close(stopJobs)
+	// Shutdown stops accepting new connections right away and waits for
+	// in-flight requests to finish
 	if err := srv.Shutdown(ctx); err != nil {
-		a.logger.WithError(err).Fatal("Error shutting down server")
+		a.logger.WithError(err).Error("Timed out draining in-flight requests, closing remaining connections")
+		srv.Close()
+	}
+	if challengeSrv != nil {
+		if err := challengeSrv.Shutdown(ctx); err != nil {
+			challengeSrv.Close()
+		}
+	}
+	jobsDone := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		a.logger.Error("Timed out waiting for running jobs, their changes will be rolled back")
+	}
+	if err := dispatcher.Close(ctx); err != nil {
+		a.logger.WithError(err).Error("Timed out delivering pending webhooks, retries are abandoned")
+	}
+	if err := tracer.Shutdown(ctx); err != nil {
+		a.logger.WithError(err).Error("Error exporting pending traces")
+	}
+	if err := gormDB.Close(); err != nil {
+		a.logger.WithError(err).Error("Error closing database connection")
 	}
 
 	a.logger.Info("Gracefully shut down server")
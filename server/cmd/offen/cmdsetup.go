@@ -4,10 +4,12 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"time"
 
 	uuid "github.com/gofrs/uuid"
@@ -27,7 +29,10 @@ A basic example for setting up a new instance looks like:
 
 $ offen setup -name "My New Account" -email me@mydomain.org -populate
 
-The command will then prompt for a password to use. Passing -populate will
+The command will then prompt for a password to use. When run in a terminal,
+it will also prompt for the account name and email in case they are not
+given, so running "offen setup -populate" walks you through creating the
+initial account. Once done, the details for logging in are printed. Passing -populate will
 create potentially missing secrets in your envfile. Do not pass the flag if you
 plan to do this yourself.
 
@@ -57,6 +62,17 @@ func cmdSetup(subcommand string, flags []string) {
 	sanitizer := bluemonday.StrictPolicy()
 	a := newApp(*populateMissing, true, *envFile)
 
+	interactive := terminal.IsTerminal(int(os.Stdin.Fd()))
+	if *source == "" && interactive {
+		stdin := bufio.NewReader(os.Stdin)
+		if *accountName == "" {
+			*accountName = promptLine(stdin, "Enter the name of the account to create:")
+		}
+		if *email == "" {
+			*email = promptLine(stdin, "Enter the email address used for login:")
+		}
+	}
+
 	pw := *password
 	if *source == "" && pw == "" {
 		received := make(chan bool, 2)
@@ -73,6 +89,16 @@ func cmdSetup(subcommand string, flags []string) {
 			a.logger.WithError(inputErr).Fatal("Error reading password")
 		}
 		pw = string(input)
+		if interactive && pw != "" {
+			a.logger.Info("Enter the password again to confirm it:")
+			confirmation, confirmErr := terminal.ReadPassword(int(os.Stdin.Fd()))
+			if confirmErr != nil {
+				a.logger.WithError(confirmErr).Fatal("Error reading password")
+			}
+			if string(confirmation) != pw {
+				a.logger.Fatal("The given passwords do not match, please run this command again")
+			}
+		}
 	}
 
 	conf := persistence.BootstrapConfig{}
@@ -138,7 +164,31 @@ func cmdSetup(subcommand string, flags []string) {
 		a.logger.WithError(dbErr).Fatal("Error establishing database connection")
 	}
 
-	db, dbErr := persistence.New(relational.NewRelationalDAL(gormDB))
+	kmsProvider, kmsErr := a.config.NewKMSProvider()
+	if kmsErr != nil {
+		a.logger.WithError(kmsErr).Fatal("Error creating KMS provider")
+	}
+
+	escrowKey, escrowErr := a.config.NewEscrowKey()
+	if escrowErr != nil {
+		a.logger.WithError(escrowErr).Fatal("Error reading escrow key")
+	}
+
+	db, dbErr := persistence.New(
+		relational.NewRelationalDAL(gormDB),
+		persistence.WithKDFParams(a.config.KDFParams()),
+		persistence.WithPepper(a.config.Pepper.Bytes()),
+		persistence.WithPasswordMinScore(a.config.Password.MinScore),
+		persistence.WithPasswordPolicy(persistence.PasswordPolicy{
+			MinLength:        a.config.Password.MinLength,
+			CharacterClasses: a.config.Password.CharacterClasses,
+			Banned:           a.config.Password.Banned,
+			HistoryDepth:     a.config.Password.History,
+		}),
+		persistence.WithEmailLookup(a.config.NewEmailLookup()),
+		persistence.WithKMSProvider(kmsProvider),
+		persistence.WithEscrowKey(escrowKey),
+	)
 	if dbErr != nil {
 		a.logger.WithError(dbErr).Fatal("Error creating persistence layer")
 	}
@@ -152,7 +202,32 @@ func cmdSetup(subcommand string, flags []string) {
 	}
 	if *source == "" {
 		a.logger.Infof("Successfully created account %s with ID %s, you can use the given credentials to access it", *accountName, *accountID)
+		a.logger.
+			WithField("url", loginURL(a)).
+			WithField("email", *email).
+			WithField("accountId", *accountID).
+			Info("Log in using these details once the instance is running")
 	} else {
 		a.logger.Infof("Successfully bootstrapped database from data in %s", *source)
 	}
 }
+
+// promptLine asks for a single line of input and returns it without
+// surrounding whitespace.
+func promptLine(r *bufio.Reader, message string) string {
+	fmt.Fprintf(os.Stderr, "%s ", message)
+	line, err := r.ReadString('\n')
+	if err != nil && line == "" {
+		return ""
+	}
+	return strings.TrimSpace(line)
+}
+
+// loginURL returns the URL of the login page of the instance, guessing the
+// host from the configuration.
+func loginURL(a *app) string {
+	if len(a.config.Server.AutoTLS) != 0 {
+		return fmt.Sprintf("https://%s/login/", a.config.Server.AutoTLS[0])
+	}
+	return fmt.Sprintf("http://localhost:%d/login/", a.config.Server.Port)
+}
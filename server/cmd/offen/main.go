@@ -7,60 +7,104 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 )
 
-var mainUsage = `
-"offen" makes the following subcommands available:
+// subcommand is a task the binary can perform. All subcommands that need
+// configuration source it using newApp, so they can be pointed at the same
+// env file using the -envfile flag.
+type subcommand struct {
+	name        string
+	description string
+	run         func(subcommand string, flags []string)
+}
+
+// defaultSubcommand is run when no subcommand is given.
+const defaultSubcommand = "serve"
 
-- "serve" runs the application (this will also run when not providing a subcommand)
-- "setup" can be used to setup a new instance
-- "secret" can be used to generate runtime secrets
-- "demo" starts an ephemeral instance for testing
-- "expire" prunes expired events from the database
-- "migrate" applies pending database migrations
-- "debug" prints the currently applied configuration values
+// subcommands contains all subcommands in the order they are listed in the
+// usage. It is populated in init as the help subcommand refers to it.
+var subcommands []subcommand
+
+func init() {
+	subcommands = []subcommand{
+		{"serve", "runs the application (this will also run when not providing a subcommand)", cmdServe},
+		{"setup", "can be used to setup a new instance", cmdSetup},
+		{"secret", "can be used to generate runtime secrets", cmdSecret},
+		{"demo", "starts an ephemeral instance for testing", cmdDemo},
+		{"expire", "prunes expired events from the database", cmdExpire},
+		{"purge", "deletes the events of an account recorded before a given date", cmdPurge},
+		{"import", "imports historical data exported from Matomo or Google Analytics", cmdImport},
+		{"rebuild", "computes rollups again from stored events", cmdRebuild},
+		{"migrate", "applies pending database migrations", cmdMigrate},
+		{"rewrap", "wraps stored keys using the configured KMS provider and secret", cmdRewrap},
+		{"recover", "grants access to an escrowed account using the escrow private key", cmdRecover},
+		{"debug", "prints the currently applied configuration values", cmdDebug},
+		{"version", "prints the revision the binary was built with", cmdVersion},
+		{"help", "prints the usage of the given subcommand", cmdHelp},
+	}
+}
 
+func mainUsage() string {
+	var b strings.Builder
+	b.WriteString("\n\"offen\" makes the following subcommands available:\n\n")
+	for _, s := range subcommands {
+		fmt.Fprintf(&b, "- %q %s\n", s.name, s.description)
+	}
+	b.WriteString(`
 Refer to the -help content of each subcommand for information about how to use
 them. Further documentation is available at
 https://docs.offen.dev/running-offen/using-the-command/
-`
+`)
+	return b.String()
+}
 
-func main() {
-	flag.Usage = func() {
-		fmt.Fprint(flag.CommandLine.Output(), mainUsage)
+func lookupSubcommand(name string) (subcommand, bool) {
+	for _, s := range subcommands {
+		if s.name == name {
+			return s, true
+		}
 	}
-	flag.Parse()
+	return subcommand{}, false
+}
 
-	var subcommand string
+func main() {
+	name := defaultSubcommand
 	var flags []string
 	if len(os.Args) > 1 {
-		subcommand = os.Args[1]
-		flags = os.Args[2:]
-	} else {
-		subcommand = "serve"
-		flags = os.Args[1:]
+		switch arg := os.Args[1]; {
+		case arg == "-h" || arg == "-help" || arg == "--help":
+			fmt.Fprint(flag.CommandLine.Output(), mainUsage())
+			return
+		case strings.HasPrefix(arg, "-"):
+			// flags without a subcommand are passed to the default
+			// subcommand, e.g. `offen -envfile offen.env`
+			flags = os.Args[1:]
+		default:
+			name = arg
+			flags = os.Args[2:]
+		}
 	}
 
-	switch subcommand {
-	case "demo":
-		cmdDemo("demo", flags)
-	case "serve":
-		cmdServe("serve", flags)
-	case "setup":
-		cmdSetup("setup", flags)
-	case "migrate":
-		cmdMigrate("migrate", flags)
-	case "expire":
-		cmdExpire("expire", flags)
-	case "debug":
-		cmdDebug("debug", flags)
-	case "secret":
-		cmdSecret("secret", flags)
-	case "version":
-		cmdVersion("version", flags)
-	default:
-		fmt.Fprintf(flag.CommandLine.Output(), "Error: unknown subcommand \"%s\"\n", os.Args[1])
-		fmt.Fprint(flag.CommandLine.Output(), mainUsage)
+	s, ok := lookupSubcommand(name)
+	if !ok {
+		fmt.Fprintf(flag.CommandLine.Output(), "Error: unknown subcommand \"%s\"\n", name)
+		fmt.Fprint(flag.CommandLine.Output(), mainUsage())
+		os.Exit(1)
+	}
+	s.run(s.name, flags)
+}
+
+func cmdHelp(subcommand string, flags []string) {
+	if len(flags) == 0 {
+		fmt.Fprint(flag.CommandLine.Output(), mainUsage())
+		return
+	}
+	s, ok := lookupSubcommand(flags[0])
+	if !ok || s.name == subcommand {
+		fmt.Fprintf(flag.CommandLine.Output(), "Error: unknown subcommand \"%s\"\n", flags[0])
+		fmt.Fprint(flag.CommandLine.Output(), mainUsage())
 		os.Exit(1)
 	}
+	s.run(s.name, []string{"-help"})
 }
@@ -9,15 +9,31 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/offen/offen/server/geoip"
+	"github.com/offen/offen/server/hibp"
 	"github.com/offen/offen/server/keys"
+	"github.com/offen/offen/server/keys/hsm"
+	"github.com/offen/offen/server/kms"
+	"github.com/offen/offen/server/kms/awskms"
+	"github.com/offen/offen/server/kms/azurekms"
+	"github.com/offen/offen/server/kms/gcpkms"
+	"github.com/offen/offen/server/kms/localkms"
+	"github.com/offen/offen/server/kms/vaultkms"
+	"github.com/offen/offen/server/ldap"
 	"github.com/offen/offen/server/mailer"
 	"github.com/offen/offen/server/mailer/localmailer"
 	"github.com/offen/offen/server/mailer/sendmailmailer"
 	"github.com/offen/offen/server/mailer/smtpmailer"
+	"github.com/offen/offen/server/oidc"
+	"github.com/offen/offen/server/saml"
+	"github.com/offen/offen/server/vault"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 const envFileName = "offen.env"
@@ -53,25 +69,304 @@ func (c *Config) NewMailer() mailer.Mailer {
 	return sendmailmailer.New()
 }
 
+// NewCertManager returns a manager that obtains and renews certificates for
+// the domains configured for AutoTLS using Let's Encrypt. Certificates are
+// persisted in the configured cache directory so they survive restarts. In
+// case AutoTLS is not configured, nil is returned.
+func (c *Config) NewCertManager() (*autocert.Manager, error) {
+	if len(c.Server.AutoTLS) == 0 {
+		return nil, nil
+	}
+	cache := c.Server.CertificateCache.String()
+	if err := os.MkdirAll(cache, 0700); err != nil {
+		return nil, fmt.Errorf("config: error creating certificate cache %s: %w", cache, err)
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(c.Server.AutoTLS...),
+		Cache:      autocert.DirCache(cache),
+		Email:      c.Server.AutoTLSEmail,
+	}, nil
+}
+
+// KDFParams returns the configured parameters for hashing and deriving keys
+// from account user credentials.
+func (c *Config) KDFParams() keys.KDFParams {
+	return keys.KDFParams{
+		Time:       c.KDF.Time,
+		Memory:     c.KDF.Memory,
+		Threads:    c.KDF.Threads,
+		Iterations: c.KDF.Iterations,
+	}
+}
+
+// validateFIPS checks whether the configuration can be used when running
+// in FIPS mode.
+func (c *Config) validateFIPS() error {
+	if c.Signing.Algorithm.String() != keys.SigningAlgorithmES256 {
+		return fmt.Errorf("config: signing algorithm %s is not approved for use in FIPS mode", c.Signing.Algorithm)
+	}
+	return nil
+}
+
+// PreviousSecretBytes returns all secrets that have been rotated out but are
+// still accepted when verifying signed values.
+func (c *Config) PreviousSecretBytes() [][]byte {
+	var result [][]byte
+	for _, secret := range c.PreviousSecrets {
+		result = append(result, secret.Bytes())
+	}
+	return result
+}
+
+// VaultConfigured returns true if a Vault server address is configured
+func (c *Config) VaultConfigured() bool {
+	return c.Vault.Address != ""
+}
+
+// NewVaultClient returns a client for the configured Vault server.
+func (c *Config) NewVaultClient() *vault.Client {
+	return vault.New(c.Vault.Address, c.Vault.Token.String())
+}
+
+// NewBreachChecker returns a client for checking passwords against the
+// Have I Been Pwned range API. In case the check is disabled, nil is returned.
+func (c *Config) NewBreachChecker() *hibp.Client {
+	if !c.HIBP.Enabled {
+		return nil
+	}
+	return hibp.New(c.HIBP.Endpoint, c.HIBP.Timeout)
+}
+
+// LDAPConfigured checks whether passwords are verified against a directory.
+func (c *Config) LDAPConfigured() bool {
+	return c.LDAP.URL != ""
+}
+
+// NewLDAPAuthenticator returns the authenticator used for verifying
+// passwords against a directory. In case no directory is configured, nil is
+// returned.
+func (c *Config) NewLDAPAuthenticator() (*ldap.Authenticator, error) {
+	if !c.LDAPConfigured() {
+		return nil, nil
+	}
+	opts := []ldap.Option{ldap.WithTimeout(c.LDAP.Timeout)}
+	if c.LDAP.SearchBaseDN != "" {
+		opts = append(opts, ldap.WithSearch(
+			c.LDAP.SearchBaseDN, c.LDAP.SearchAttribute,
+			c.LDAP.SearchBindDN, c.LDAP.SearchBindPassword.String(),
+		))
+	} else {
+		opts = append(opts, ldap.WithBindDN(c.LDAP.BindDN))
+	}
+	if c.LDAP.StartTLS {
+		opts = append(opts, ldap.WithStartTLS())
+	}
+	a, err := ldap.New(c.LDAP.URL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("config: error creating ldap authenticator: %w", err)
+	}
+	return a, nil
+}
+
+// OIDCConfigured checks whether single sign-on using an OpenID Connect
+// provider is configured.
+func (c *Config) OIDCConfigured() bool {
+	return c.OIDC.Issuer != "" && c.OIDC.ClientID != ""
+}
+
+// NewOIDCProvider returns the configured OpenID Connect provider. In case
+// single sign-on is not configured, nil is returned.
+func (c *Config) NewOIDCProvider() *oidc.Provider {
+	if !c.OIDCConfigured() {
+		return nil
+	}
+	return oidc.New(
+		c.OIDC.Issuer, c.OIDC.ClientID, c.OIDC.ClientSecret.String(),
+		oidc.WithGroupsClaim(c.OIDC.GroupsClaim),
+	)
+}
+
+// SAMLConfigured checks whether single sign-on using a SAML identity provider
+// is configured.
+func (c *Config) SAMLConfigured() bool {
+	return c.SAML.URL != "" && c.SAML.IDPSSOURL != "" && c.SAML.IDPCertificate != ""
+}
+
+// NewSAMLServiceProvider returns the service provider used for single sign-on
+// using SAML. In case it is not configured, nil is returned.
+func (c *Config) NewSAMLServiceProvider() (*saml.ServiceProvider, error) {
+	if !c.SAMLConfigured() {
+		return nil, nil
+	}
+	certificates, err := saml.ParseCertificates([]byte(c.SAML.IDPCertificate))
+	if err != nil {
+		return nil, fmt.Errorf("config: error parsing identity provider certificate: %w", err)
+	}
+	root := strings.TrimSuffix(c.SAML.URL, "/")
+	return saml.New(
+		root+"/api/saml/metadata", root+"/api/login/saml/acs", c.SAML.IDPSSOURL, certificates,
+		saml.WithIdentityProviderEntityID(c.SAML.IDPEntityID),
+	), nil
+}
+
+// NewGeoIPReader returns the reader used for resolving the country of
+// clients. In case no database is configured, nil is returned.
+func (c *Config) NewGeoIPReader() (*geoip.Reader, error) {
+	if c.GeoIP.Database == "" {
+		return nil, nil
+	}
+	return geoip.Open(c.GeoIP.Database.String())
+}
+
+// NewKMSProvider returns the provider used for wrapping key encryption keys.
+// In case no provider is configured, nil is returned.
+func (c *Config) NewKMSProvider() (kms.Provider, error) {
+	switch c.KMS.Provider {
+	case "local":
+		if c.KMS.LocalKey.IsZero() {
+			return nil, errors.New("config: local kms provider requires a key to be configured")
+		}
+		return localkms.New(c.KMS.LocalKey.Bytes()), nil
+	case "aws":
+		return awskms.New(c.KMS.AWSKeyID, c.KMS.AWSRegion)
+	case "vault":
+		if !c.VaultConfigured() {
+			return nil, errors.New("config: vault kms provider requires a vault address to be configured")
+		}
+		return vaultkms.New(c.NewVaultClient(), c.KMS.VaultTransitKey), nil
+	case "gcp":
+		return gcpkms.New(c.KMS.GCPKeyName)
+	case "azure":
+		return azurekms.New(
+			c.KMS.AzureVaultURL, c.KMS.AzureKeyName, c.KMS.AzureTenantID,
+			c.KMS.AzureClientID, c.KMS.AzureClientSecret.String(),
+		), nil
+	default:
+		return nil, nil
+	}
+}
+
+// NewEmailLookup returns the keyed hash used for looking up account users by
+// email. Hashes created using previous secrets keep matching, so rotating the
+// secret does not lock out account users. In case no key id is configured,
+// nil is returned.
+func (c *Config) NewEmailLookup() *keys.EmailLookup {
+	if c.EmailLookup.KeyID == "" {
+		return nil
+	}
+	return keys.NewEmailLookup(c.Secret.Bytes(), c.EmailLookup.KeyID, c.PreviousSecretBytes()...)
+}
+
+// NewEscrowKey returns the public key used for escrowing the keys of all
+// accounts. In case no key is configured, nil is returned.
+func (c *Config) NewEscrowKey() (*keys.EscrowKey, error) {
+	if c.Escrow.PublicKey == "" {
+		return nil, nil
+	}
+	escrowKey, err := keys.ParseEscrowKey([]byte(c.Escrow.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("config: error parsing escrow key: %w", err)
+	}
+	return escrowKey, nil
+}
+
+// NewLogger returns a logger for the given subsystem, which is added to each
+// entry. Subsystems use the log level configured for them, falling back to
+// the level of the application. Unless configured otherwise, entries are
+// written as JSON, or in a human readable format in development.
+func (c *Config) NewLogger(subsystem string) *logrus.Logger {
+	logger := logrus.New()
+	format := c.App.LogFormat
+	if format == "" {
+		format = LogFormatJSON
+		if c.App.Development {
+			format = LogFormatConsole
+		}
+	}
+	logger.SetFormatter(format.Formatter())
+	level := c.App.LogLevel
+	if subsystemLevel, ok := c.App.LogLevels[subsystem]; ok {
+		level = subsystemLevel
+	}
+	logger.SetLevel(level.LogLevel())
+	if subsystem != "" {
+		logger.AddHook(subsystemHook(subsystem))
+	}
+	return logger
+}
+
+// subsystemHook adds the name of the subsystem to each log entry.
+type subsystemHook string
+
+func (h subsystemHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h subsystemHook) Fire(entry *logrus.Entry) error {
+	entry.Data["subsystem"] = string(h)
+	return nil
+}
+
+// NewSigningKeyring returns the keyring used for signing auth tokens. In case
+// a PKCS#11 module is configured, the signing key is kept in the HSM.
+// Otherwise keys are derived from the configured secret. Additional options
+// are applied after the configured ones.
+func (c *Config) NewSigningKeyring(extra ...keys.SigningKeyringOption) (*keys.SigningKeyring, error) {
+	opts := []keys.SigningKeyringOption{
+		keys.WithSigningAlgorithm(c.Signing.Algorithm.String()),
+		keys.WithPreviousSecrets(c.PreviousSecretBytes()...),
+	}
+	if c.Signing.HSM.Module != "" {
+		if c.Signing.Algorithm.String() != keys.SigningAlgorithmES256 {
+			return nil, errors.New("config: keys stored in a HSM can only be used with ES256")
+		}
+		signer, err := hsm.New(hsm.Config{
+			Module:     c.Signing.HSM.Module,
+			TokenLabel: c.Signing.HSM.TokenLabel,
+			Pin:        c.Signing.HSM.Pin.String(),
+			KeyLabel:   c.Signing.HSM.KeyLabel,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("config: error creating HSM signer: %w", err)
+		}
+		opts = append(opts, keys.WithSigner(signer))
+	}
+	opts = append(opts, extra...)
+	keyring, err := keys.NewSigningKeyring(
+		c.Secret.Bytes(), c.Signing.RotationPeriod, c.Signing.GracePeriod, opts...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("config: error creating signing keyring: %w", err)
+	}
+	return keyring, nil
+}
+
 func walkConfigurationCascade() (string, error) {
 	wd, err := os.Getwd()
 	if err != nil {
 		return "", fmt.Errorf("config: error looking up current working directory: %w", err)
 	}
 	// in case there is an offen.env file next to the binary, it will be loaded
-	// as the env file with the highest precedence
+	// as the env file with the highest precedence. In each location, an
+	// offen.env file takes precedence over an offen.yml file.
 	var cascade []string
 	switch runtime.GOOS {
 	case "windows":
 		cascade = []string{
 			path.Join(wd, envFileName),
+			path.Join(wd, configFileName),
 		}
 	case "darwin", "linux":
 		cascade = []string{
 			path.Join(wd, envFileName),
+			path.Join(wd, configFileName),
 			path.Join(ExpandString("$HOME/.config"), envFileName),
+			path.Join(ExpandString("$HOME/.config"), configFileName),
 			path.Join(ExpandString("$XDG_CONFIG_HOME"), envFileName),
+			path.Join(ExpandString("$XDG_CONFIG_HOME"), configFileName),
 			path.Join("/etc/offen", envFileName),
+			path.Join("/etc/offen", configFileName),
 		}
 	}
 	for _, file := range cascade {
@@ -135,19 +430,42 @@ func New(populateMissing bool, override string) (*Config, error) {
 		}
 	}
 
-	if envFile != "" {
+	if isConfigFile(envFile) {
+		if err := loadConfigFile(envFile); err != nil {
+			return nil, err
+		}
+	} else if envFile != "" {
 		godotenv.Load(envFile)
 	}
 
+	if err := loadSecretFiles("OFFEN_", os.Environ()); err != nil {
+		return nil, fmt.Errorf("config: error loading secret files: %w", err)
+	}
+
 	err := envconfig.Process("offen", &c)
 	if err != nil {
+		var parseErr *envconfig.ParseError
+		if errors.As(err, &parseErr) {
+			return &c, fmt.Errorf("config: invalid value %q for %s: %w", parseErr.Value, parseErr.KeyName, parseErr.Err)
+		}
 		return &c, fmt.Errorf("config: error processing configuration: %w", err)
 	}
 
+	// when a secret path in Vault is configured, the secret is sourced
+	// from Vault instead of the environment
+	if c.VaultConfigured() && c.Vault.SecretPath != "" {
+		if err := applyVaultSecrets(&c); err != nil {
+			return &c, fmt.Errorf("config: error applying secrets from vault: %w", err)
+		}
+	}
+
 	if populateMissing {
 		if envFile == "" {
 			return nil, errors.New("config: unable to find env file to persist settings as no env file could be found")
 		}
+		if isConfigFile(envFile) {
+			return nil, fmt.Errorf("config: unable to persist settings in config file %s, only env files are supported", envFile)
+		}
 		update := map[string]string{}
 		for _, val := range []autopopulatedValue{
 			{"OFFEN_SECRET", c.Secret.IsZero},
@@ -174,6 +492,19 @@ func New(populateMissing bool, override string) (*Config, error) {
 		return result, err
 	}
 
+	if c.App.FIPS {
+		keys.EnableFIPSMode()
+	}
+	if keys.FIPSMode() {
+		if err := c.validateFIPS(); err != nil {
+			return &c, fmt.Errorf("config: refusing to use non-compliant configuration: %w", err)
+		}
+	}
+
+	if err := c.KDFParams().Validate(); err != nil {
+		return &c, fmt.Errorf("config: error validating kdf parameters: %w", err)
+	}
+
 	if c.Secret.IsZero() {
 		cookieSecret, cookieSecretErr := keys.GenerateRandomBytes(keys.DefaultSecretLength)
 		if cookieSecretErr != nil {
@@ -191,5 +522,30 @@ func New(populateMissing bool, override string) (*Config, error) {
 		}
 	}
 
+	if err := c.validate(); err != nil {
+		return &c, err
+	}
 	return &c, nil
 }
+
+func applyVaultSecrets(c *Config) error {
+	secrets, err := c.NewVaultClient().ReadSecret(c.Vault.SecretPath)
+	if err != nil {
+		return fmt.Errorf("config: error reading secret from vault: %w", err)
+	}
+	value, ok := secrets["secret"]
+	if !ok {
+		return fmt.Errorf("config: secret at %s does not contain a value for key `secret`", c.Vault.SecretPath)
+	}
+	if err := c.Secret.Decode(value); err != nil {
+		return fmt.Errorf("config: error decoding secret from vault: %w", err)
+	}
+	// a pepper is optional, but if present it takes precedence over
+	// any value from the environment
+	if pepper, ok := secrets["pepper"]; ok {
+		if err := c.Pepper.Decode(pepper); err != nil {
+			return fmt.Errorf("config: error decoding pepper from vault: %w", err)
+		}
+	}
+	return nil
+}
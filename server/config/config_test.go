@@ -4,8 +4,20 @@
 package config
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 func TestNew(t *testing.T) {
@@ -31,3 +43,358 @@ func TestNew(t *testing.T) {
 		t.Error("Expected app secret to be populated")
 	}
 }
+
+func TestApplyVaultSecrets(t *testing.T) {
+	tests := []struct {
+		name           string
+		response       string
+		expectedSecret Bytes
+		expectedPepper Bytes
+		expectError    bool
+	}{
+		{
+			"ok",
+			`{"data":{"data":{"secret":"c2VjcmV0"}}}`,
+			Bytes("secret"),
+			nil,
+			false,
+		},
+		{
+			"with pepper",
+			`{"data":{"data":{"secret":"c2VjcmV0","pepper":"cGVwcGVy"}}}`,
+			Bytes("secret"),
+			Bytes("pepper"),
+			false,
+		},
+		{
+			"missing key",
+			`{"data":{"data":{"other":"c2VjcmV0"}}}`,
+			nil,
+			nil,
+			true,
+		},
+		{
+			"bad encoding",
+			`{"data":{"data":{"secret":"!!!"}}}`,
+			nil,
+			nil,
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(test.response))
+			}))
+			defer srv.Close()
+
+			c := &Config{}
+			c.Vault.Address = srv.URL
+			c.Vault.SecretPath = "secret/data/offen"
+			err := applyVaultSecrets(c)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if !reflect.DeepEqual(test.expectedSecret, c.Secret) {
+				t.Errorf("Expected %v, got %v", test.expectedSecret, c.Secret)
+			}
+			if !reflect.DeepEqual(test.expectedPepper, c.Pepper) {
+				t.Errorf("Expected %v, got %v", test.expectedPepper, c.Pepper)
+			}
+		})
+	}
+}
+
+func TestConfig_NewKMSProvider(t *testing.T) {
+	tests := []struct {
+		name             string
+		config           func() *Config
+		expectedProvider bool
+		expectError      bool
+	}{
+		{
+			"none",
+			func() *Config { return &Config{} },
+			false,
+			false,
+		},
+		{
+			"local",
+			func() *Config {
+				c := &Config{}
+				c.KMS.Provider = "local"
+				c.KMS.LocalKey = Bytes("0123456789abcdef0123456789abcdef")
+				return c
+			},
+			true,
+			false,
+		},
+		{
+			"local without key",
+			func() *Config {
+				c := &Config{}
+				c.KMS.Provider = "local"
+				return c
+			},
+			false,
+			true,
+		},
+		{
+			"vault without address",
+			func() *Config {
+				c := &Config{}
+				c.KMS.Provider = "vault"
+				return c
+			},
+			false,
+			true,
+		},
+		{
+			"azure",
+			func() *Config {
+				c := &Config{}
+				c.KMS.Provider = "azure"
+				c.KMS.AzureVaultURL = "https://offen.vault.azure.net"
+				return c
+			},
+			true,
+			false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider, err := test.config().NewKMSProvider()
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if (provider != nil) != test.expectedProvider {
+				t.Errorf("Unexpected provider %v", provider)
+			}
+		})
+	}
+}
+
+func TestConfig_NewCertManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "offen-cert-cache")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("not configured", func(t *testing.T) {
+		m, err := (&Config{}).NewCertManager()
+		if err != nil || m != nil {
+			t.Errorf("Unexpected result %v %v", m, err)
+		}
+	})
+	t.Run("ok", func(t *testing.T) {
+		c := &Config{}
+		c.Server.AutoTLS = []string{"offen.example.com"}
+		c.Server.AutoTLSEmail = "ops@example.com"
+		c.Server.CertificateCache = EnvString(filepath.Join(dir, "cache"))
+		m, err := c.NewCertManager()
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if m.Email != "ops@example.com" {
+			t.Errorf("Unexpected email %v", m.Email)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "cache")); err != nil {
+			t.Errorf("Expected cache directory to be created, got %v", err)
+		}
+		if err := m.HostPolicy(context.Background(), "offen.example.com"); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if err := m.HostPolicy(context.Background(), "other.example.com"); err == nil {
+			t.Error("Expected error for unknown host")
+		}
+	})
+}
+
+func TestConfig_NewSigningKeyring(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      func() *Config
+		expectError bool
+	}{
+		{
+			"derived keys",
+			func() *Config {
+				c := &Config{Secret: Bytes("secret")}
+				c.Signing.Algorithm = "EdDSA"
+				c.Signing.RotationPeriod = time.Hour
+				return c
+			},
+			false,
+		},
+		{
+			"hsm with unsupported algorithm",
+			func() *Config {
+				c := &Config{}
+				c.Signing.Algorithm = "EdDSA"
+				c.Signing.HSM.Module = "/usr/lib/softhsm/libsofthsm2.so"
+				return c
+			},
+			true,
+		},
+		{
+			"hsm with bad module",
+			func() *Config {
+				c := &Config{}
+				c.Signing.Algorithm = "ES256"
+				c.Signing.HSM.Module = "/does/not/exist.so"
+				c.Signing.HSM.TokenLabel = "offen"
+				return c
+			},
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keyring, err := test.config().NewSigningKeyring()
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if !test.expectError {
+				if _, err := keyring.SignToken("user", time.Minute); err != nil {
+					t.Errorf("Unexpected error %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestConfig_NewLogger(t *testing.T) {
+	tests := []struct {
+		name              string
+		config            func() *Config
+		subsystem         string
+		expectedLevel     logrus.Level
+		expectedJSON      bool
+		expectedSubsystem string
+	}{
+		{
+			"defaults",
+			func() *Config {
+				c := &Config{}
+				c.App.LogLevel = LogLevel(logrus.InfoLevel)
+				return c
+			},
+			"",
+			logrus.InfoLevel,
+			true,
+			"",
+		},
+		{
+			"subsystem level",
+			func() *Config {
+				c := &Config{}
+				c.App.LogLevel = LogLevel(logrus.InfoLevel)
+				c.App.LogLevels = LogLevels{"router": LogLevel(logrus.DebugLevel)}
+				return c
+			},
+			"router",
+			logrus.DebugLevel,
+			true,
+			"router",
+		},
+		{
+			"fallback level",
+			func() *Config {
+				c := &Config{}
+				c.App.LogLevel = LogLevel(logrus.WarnLevel)
+				c.App.LogLevels = LogLevels{"router": LogLevel(logrus.DebugLevel)}
+				return c
+			},
+			"keys",
+			logrus.WarnLevel,
+			true,
+			"keys",
+		},
+		{
+			"development",
+			func() *Config {
+				c := &Config{}
+				c.App.LogLevel = LogLevel(logrus.InfoLevel)
+				c.App.Development = true
+				return c
+			},
+			"persistence",
+			logrus.InfoLevel,
+			false,
+			"persistence",
+		},
+		{
+			"explicit format",
+			func() *Config {
+				c := &Config{}
+				c.App.LogLevel = LogLevel(logrus.InfoLevel)
+				c.App.Development = true
+				c.App.LogFormat = LogFormatJSON
+				return c
+			},
+			"",
+			logrus.InfoLevel,
+			true,
+			"",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := test.config().NewLogger(test.subsystem)
+			logger.Out = &buf
+			if logger.GetLevel() != test.expectedLevel {
+				t.Errorf("Unexpected level %v", logger.GetLevel())
+			}
+			logger.Warn("message")
+			var entry map[string]interface{}
+			err := json.Unmarshal(buf.Bytes(), &entry)
+			if test.expectedJSON != (err == nil) {
+				t.Errorf("Unexpected output %s", buf.String())
+			}
+			if test.expectedJSON {
+				if subsystem, _ := entry["subsystem"].(string); subsystem != test.expectedSubsystem {
+					t.Errorf("Unexpected subsystem %v", subsystem)
+				}
+			} else if !strings.Contains(buf.String(), "subsystem="+test.expectedSubsystem) {
+				t.Errorf("Expected subsystem in output %s", buf.String())
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateFIPS(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      func() *Config
+		expectError bool
+	}{
+		{
+			"ok",
+			func() *Config {
+				c := &Config{}
+				c.Signing.Algorithm = "ES256"
+				return c
+			},
+			false,
+		},
+		{
+			"eddsa",
+			func() *Config {
+				c := &Config{}
+				c.Signing.Algorithm = "EdDSA"
+				return c
+			},
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.config().validateFIPS()
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@
 
 package config
 
+import "time"
+
 // Config contains all runtime configuration needed for running offen as
 // and also defines the desired defaults. Package envconfig is used to
 // source values from the application environment at runtime.
@@ -12,10 +14,13 @@ type Config struct {
 	Server struct {
 		Port             int  `default:"3000"`
 		ReverseProxy     bool `default:"false"`
+		TrustedProxies   Networks
 		SSLCertificate   EnvString
 		SSLKey           EnvString
 		AutoTLS          []string
-		CertificateCache EnvString `default:"/var/www/.cache"`
+		AutoTLSEmail     string
+		CertificateCache EnvString     `default:"/var/www/.cache"`
+		ShutdownTimeout  time.Duration `default:"30s"`
 	}
 	Database struct {
 		Dialect          Dialect   `default:"sqlite3"`
@@ -24,14 +29,171 @@ type Config struct {
 	App struct {
 		Development  bool     `default:"false"`
 		LogLevel     LogLevel `default:"info"`
-		SingleNode   bool     `default:"true"`
-		Locale       Locale   `default:"en"`
+		LogLevels    LogLevels
+		LogFormat    LogFormat
+		SingleNode   bool   `default:"true"`
+		Locale       Locale `default:"en"`
 		RootAccount  string
 		DemoAccount  string `ignored:"true"`
 		DeployTarget DeployTarget
+		FIPS         bool `default:"false"`
+	}
+	Secret          Bytes
+	PreviousSecrets []Bytes
+	Pepper          Bytes
+	EmailLookup     struct {
+		KeyID string
+	}
+	Escrow struct {
+		PublicKey string
+	}
+	Signing struct {
+		Algorithm      SigningAlgorithm `default:"ES256"`
+		RotationPeriod time.Duration    `default:"168h"`
+		GracePeriod    time.Duration    `default:"24h"`
+		HSM            struct {
+			Module     string
+			TokenLabel string
+			Pin        EnvString
+			KeyLabel   string
+		}
+	}
+	Password struct {
+		MinScore         int `default:"2"`
+		MaxAge           time.Duration
+		MinLength        int
+		CharacterClasses int
+		Banned           []string
+		History          int
+	}
+	Lockout struct {
+		Attempts int           `default:"10"`
+		Duration time.Duration `default:"15m"`
+	}
+	RateLimit struct {
+		Events int           `default:"120"`
+		Login  int           `default:"10"`
+		Period time.Duration `default:"1m"`
+	}
+	BotFilter struct {
+		Enabled    bool `default:"true"`
+		UserAgents []string
+	}
+	Dedupe struct {
+		Window time.Duration `default:"10s"`
+	}
+	Quota struct {
+		Events       int
+		PayloadBytes int
+	}
+	GeoIP struct {
+		Database EnvString
+	}
+	Sampling struct {
+		Enabled   bool `default:"false"`
+		Threshold int  `default:"10000"`
+	}
+	Session struct {
+		TTL        time.Duration `default:"24h"`
+		RefreshTTL time.Duration
+	}
+	Allowlist struct {
+		Networks Networks
+	}
+	Impersonation struct {
+		Admins []string
+		TTL    time.Duration `default:"30m"`
+	}
+	MagicLink struct {
+		Enabled bool
+		TTL     time.Duration `default:"15m"`
+	}
+	LoginNotification struct {
+		Enabled bool
+	}
+	GraphQL struct {
+		Enabled bool
+	}
+	Metrics struct {
+		Enabled bool
+		Token   EnvString
+	}
+	Tracing struct {
+		Endpoint    string
+		SampleRatio float64 `default:"1"`
+		ServiceName string  `default:"offen"`
+	}
+	Headers struct {
+		ContentSecurityPolicy      string        `default:"default-src 'self'; style-src 'self' 'unsafe-inline'"`
+		VaultContentSecurityPolicy string        `default:"default-src 'self'; style-src 'self' 'unsafe-inline'"`
+		FrameAncestors             []string      `default:"'self'"`
+		VaultFrameAncestors        []string      `default:"*"`
+		HSTSMaxAge                 time.Duration `default:"4380h"`
+		HSTSIncludeSubdomains      bool
+		HSTSPreload                bool
+	}
+	WebAuthn struct {
+		RelyingPartyID string
+		Origins        []string
+	}
+	OIDC struct {
+		Issuer        string
+		ClientID      string
+		ClientSecret  EnvString
+		RedirectURL   string
+		GroupsClaim   string `default:"groups"`
+		AccountGroups map[string]string
+	}
+	SAML struct {
+		URL            string
+		IDPSSOURL      string
+		IDPEntityID    string
+		IDPCertificate string
+		EmailAttribute string
+		AdminAttribute string
+		AdminValue     string
+		Provision      bool
+	}
+	LDAP struct {
+		URL                string
+		BindDN             string
+		SearchBaseDN       string
+		SearchAttribute    string `default:"mail"`
+		SearchBindDN       string
+		SearchBindPassword EnvString
+		StartTLS           bool
+		Timeout            time.Duration `default:"10s"`
+	}
+	HIBP struct {
+		Enabled  bool
+		Endpoint string        `default:"https://api.pwnedpasswords.com/range/"`
+		Timeout  time.Duration `default:"3s"`
+	}
+	KDF struct {
+		Time       uint32 `default:"4"`
+		Memory     uint32 `default:"16384"`
+		Threads    uint8  `default:"4"`
+		Iterations uint32 `default:"600000"`
+	}
+	KMS struct {
+		Provider          KMSProvider
+		LocalKey          Bytes
+		AWSKeyID          string
+		AWSRegion         string
+		VaultTransitKey   string
+		GCPKeyName        string
+		AzureVaultURL     string
+		AzureKeyName      string
+		AzureTenantID     string
+		AzureClientID     string
+		AzureClientSecret EnvString
+	}
+	Vault struct {
+		Address    string
+		Token      EnvString
+		SecretPath string
 	}
-	Secret Bytes
-	SMTP   struct {
+	SMTP struct {
 		User     string
 		Password string
 		Host     string
@@ -0,0 +1,136 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const configFileName = "offen.yml"
+
+// isConfigFile returns true in case the given file is expected to be a YAML
+// configuration file instead of an env file.
+func isConfigFile(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yml", ".yaml":
+		return true
+	default:
+		return false
+	}
+}
+
+// loadConfigFile reads the YAML configuration file at the given location and
+// sets the environment variables corresponding to its values. Keys mirror the
+// names of environment variables, so `database: {connectionstring: ...}` sets
+// OFFEN_DATABASE_CONNECTIONSTRING. Underscores, dashes and casing of keys are
+// ignored. Variables that are already set in the environment are not
+// overridden. Unknown keys are reported as errors, so typos do not go
+// unnoticed.
+func loadConfigFile(file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("config: error reading config file: %w", err)
+	}
+	var content map[interface{}]interface{}
+	if err := yaml.Unmarshal(b, &content); err != nil {
+		return fmt.Errorf("config: error parsing config file %s: %w", file, err)
+	}
+	known := knownVariables("OFFEN", reflect.TypeOf(Config{}))
+	values := map[string]string{}
+	if err := flattenConfigFile(content, nil, known, values); err != nil {
+		return fmt.Errorf("config: error reading config file %s: %w", file, err)
+	}
+
+	var unknown []string
+	for key := range values {
+		if _, ok := known[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("config: unknown keys %s in config file %s", strings.Join(unknown, ", "), file)
+	}
+
+	for key, value := range values {
+		variable := known[key]
+		if _, ok := os.LookupEnv(variable); ok {
+			continue
+		}
+		if err := os.Setenv(variable, value); err != nil {
+			return fmt.Errorf("config: error setting %s: %w", variable, err)
+		}
+	}
+	return nil
+}
+
+// flattenConfigFile collects the values of the given content into the given
+// map, keyed by their dotted, lower cased path. Maps are only flattened in
+// case they do not hold the value of a known variable, like log levels do.
+func flattenConfigFile(content map[interface{}]interface{}, path []string, known, values map[string]string) error {
+	for rawKey, rawValue := range content {
+		key := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(fmt.Sprint(rawKey)))
+		keyPath := append(append([]string{}, path...), key)
+		switch value := rawValue.(type) {
+		case nil:
+			continue
+		case map[interface{}]interface{}:
+			if _, ok := known[strings.Join(keyPath, ".")]; ok {
+				var items []string
+				for itemKey, item := range value {
+					items = append(items, fmt.Sprintf("%v:%v", itemKey, item))
+				}
+				sort.Strings(items)
+				values[strings.Join(keyPath, ".")] = strings.Join(items, ",")
+				continue
+			}
+			if err := flattenConfigFile(value, keyPath, known, values); err != nil {
+				return err
+			}
+		case []interface{}:
+			var items []string
+			for _, item := range value {
+				if _, ok := item.(map[interface{}]interface{}); ok {
+					return fmt.Errorf("unexpected nested value in list %s", strings.Join(keyPath, "."))
+				}
+				items = append(items, fmt.Sprint(item))
+			}
+			values[strings.Join(keyPath, ".")] = strings.Join(items, ",")
+		default:
+			values[strings.Join(keyPath, ".")] = fmt.Sprint(value)
+		}
+	}
+	return nil
+}
+
+// knownVariables returns the names of all environment variables that are
+// read into the given struct type, keyed by their dotted, lower cased path.
+func knownVariables(prefix string, t reflect.Type) map[string]string {
+	result := map[string]string{}
+	var walk func(path []string, t reflect.Type)
+	walk = func(path []string, t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Tag.Get("ignored") == "true" {
+				continue
+			}
+			fieldPath := append(append([]string{}, path...), strings.ToLower(field.Name))
+			if field.Type.Kind() == reflect.Struct {
+				walk(fieldPath, field.Type)
+				continue
+			}
+			result[strings.Join(fieldPath, ".")] = strings.ToUpper(prefix + "_" + strings.Join(fieldPath, "_"))
+		}
+	}
+	walk(nil, t)
+	return result
+}
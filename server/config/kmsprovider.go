@@ -0,0 +1,25 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import "fmt"
+
+// KMSProvider identifies an external key management service that is used
+// for wrapping key encryption keys.
+type KMSProvider string
+
+// Decode validates and assigns v.
+func (k *KMSProvider) Decode(v string) error {
+	switch v {
+	case "", "local", "aws", "vault", "gcp", "azure":
+		*k = KMSProvider(v)
+	default:
+		return fmt.Errorf("unknown or unsupported KMS provider %s", v)
+	}
+	return nil
+}
+
+func (k *KMSProvider) String() string {
+	return string(*k)
+}
@@ -0,0 +1,24 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import "testing"
+
+func TestKMSProvider(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var k KMSProvider
+		if err := k.Decode("aws"); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if k.String() != "aws" {
+			t.Errorf("Unexpected value %v", k.String())
+		}
+	})
+	t.Run("error", func(t *testing.T) {
+		var k KMSProvider
+		if err := k.Decode("shoebox"); err == nil {
+			t.Error("Unexpected nil error")
+		}
+	})
+}
@@ -0,0 +1,43 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogFormat defines how log entries are written.
+type LogFormat string
+
+// The supported log formats. Structured logs are written as JSON, console
+// logs are meant to be read by humans during development.
+const (
+	LogFormatJSON    LogFormat = "json"
+	LogFormatConsole LogFormat = "console"
+)
+
+// Decode validates and assigns f.
+func (f *LogFormat) Decode(s string) error {
+	switch LogFormat(s) {
+	case LogFormatJSON, LogFormatConsole:
+		*f = LogFormat(s)
+	default:
+		return fmt.Errorf("unknown or unsupported log format %s", s)
+	}
+	return nil
+}
+
+func (f *LogFormat) String() string {
+	return string(*f)
+}
+
+// Formatter returns the logrus formatter for f.
+func (f *LogFormat) Formatter() logrus.Formatter {
+	if *f == LogFormatConsole {
+		return &logrus.TextFormatter{FullTimestamp: true}
+	}
+	return &logrus.JSONFormatter{}
+}
@@ -0,0 +1,31 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogFormat(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var f LogFormat
+		if err := f.Decode("console"); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if f.String() != "console" {
+			t.Errorf("Unexpected value %v", f.String())
+		}
+		if _, ok := f.Formatter().(*logrus.TextFormatter); !ok {
+			t.Errorf("Unexpected formatter %T", f.Formatter())
+		}
+	})
+	t.Run("error", func(t *testing.T) {
+		var f LogFormat
+		if err := f.Decode("xml"); err == nil {
+			t.Error("Unexpected nil error")
+		}
+	})
+}
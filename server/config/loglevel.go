@@ -4,6 +4,9 @@
 package config
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/sirupsen/logrus"
 )
 
@@ -24,3 +27,45 @@ func (l *LogLevel) Decode(v string) error {
 func (l *LogLevel) LogLevel() logrus.Level {
 	return logrus.Level(*l)
 }
+
+// The subsystems that can be assigned a log level of their own.
+var logSubsystems = []string{"router", "persistence", "keys"}
+
+// LogLevels assigns log levels to subsystems, overriding the level of the
+// application.
+type LogLevels map[string]LogLevel
+
+// Decode parses a comma separated list of subsystem:level pairs into l.
+func (l *LogLevels) Decode(v string) error {
+	levels := LogLevels{}
+	for _, pair := range strings.Split(v, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		chunks := strings.SplitN(pair, ":", 2)
+		if len(chunks) != 2 {
+			return fmt.Errorf("expected subsystem:level, got %s", pair)
+		}
+		subsystem := strings.TrimSpace(chunks[0])
+		if !knownLogSubsystem(subsystem) {
+			return fmt.Errorf("unknown subsystem %s, expected one of %s", subsystem, strings.Join(logSubsystems, ", "))
+		}
+		var level LogLevel
+		if err := level.Decode(strings.TrimSpace(chunks[1])); err != nil {
+			return err
+		}
+		levels[subsystem] = level
+	}
+	*l = levels
+	return nil
+}
+
+func knownLogSubsystem(subsystem string) bool {
+	for _, s := range logSubsystems {
+		if s == subsystem {
+			return true
+		}
+	}
+	return false
+}
@@ -26,3 +26,38 @@ func TestLogLevel(t *testing.T) {
 		}
 	})
 }
+
+func TestLogLevels(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var l LogLevels
+		if err := l.Decode("router:debug, keys:error"); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if len(l) != 2 {
+			t.Errorf("Unexpected levels %v", l)
+		}
+		if level := l["router"]; level.LogLevel() != logrus.DebugLevel {
+			t.Errorf("Unexpected log level %v", level.LogLevel())
+		}
+		if level := l["keys"]; level.LogLevel() != logrus.ErrorLevel {
+			t.Errorf("Unexpected log level %v", level.LogLevel())
+		}
+	})
+	t.Run("empty", func(t *testing.T) {
+		var l LogLevels
+		if err := l.Decode(""); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if len(l) != 0 {
+			t.Errorf("Unexpected levels %v", l)
+		}
+	})
+	t.Run("errors", func(t *testing.T) {
+		for _, value := range []string{"router", "mailer:info", "router:zalgo"} {
+			var l LogLevels
+			if err := l.Decode(value); err == nil {
+				t.Errorf("Unexpected nil error for %s", value)
+			}
+		}
+	})
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Networks is a list of IP networks.
+type Networks []*net.IPNet
+
+// Decode parses a comma separated list of networks in CIDR notation into n.
+// Single IP addresses are accepted as well.
+func (n *Networks) Decode(v string) error {
+	networks, err := ParseNetworks(strings.Split(v, ","))
+	if err != nil {
+		return err
+	}
+	*n = networks
+	return nil
+}
+
+// Allows checks whether the given IP address is contained in any of the
+// networks. An empty list allows all addresses.
+func (n Networks) Allows(ip string) bool {
+	if len(n) == 0 {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range n {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains checks whether the given IP address is contained in any of the
+// networks. Other than Allows, an empty list does not contain any address.
+func (n Networks) Contains(ip string) bool {
+	if len(n) == 0 {
+		return false
+	}
+	return n.Allows(ip)
+}
+
+// ParseNetworks parses the given values in CIDR notation or single IP
+// addresses. Empty values are skipped.
+func ParseNetworks(values []string) (Networks, error) {
+	var result Networks
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("config: invalid ip address %s", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("config: invalid network %s: %w", value, err)
+		}
+		result = append(result, network)
+	}
+	return result, nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import "testing"
+
+func TestNetworks(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var n Networks
+		if err := n.Decode("10.0.0.0/8, 192.168.1.1,2001:db8::/32"); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		for ip, expected := range map[string]bool{
+			"10.1.2.3":      true,
+			"192.168.1.1":   true,
+			"192.168.1.2":   false,
+			"2001:db8::1":   true,
+			"2001:db9::1":   false,
+			"not an ip":     false,
+			"127.0.0.1":     false,
+			"::ffff:10.0.0": false,
+		} {
+			if result := n.Allows(ip); result != expected {
+				t.Errorf("Expected %v for %s, got %v", expected, ip, result)
+			}
+			if result := n.Contains(ip); result != expected {
+				t.Errorf("Expected %v for %s, got %v", expected, ip, result)
+			}
+		}
+	})
+	t.Run("empty", func(t *testing.T) {
+		var n Networks
+		if err := n.Decode(""); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if !n.Allows("127.0.0.1") {
+			t.Error("Expected empty list to allow all addresses")
+		}
+		if n.Contains("127.0.0.1") {
+			t.Error("Expected empty list not to contain any address")
+		}
+	})
+	t.Run("error", func(t *testing.T) {
+		var n Networks
+		if err := n.Decode("10.0.0.0/33"); err == nil {
+			t.Error("Unexpected nil error")
+		}
+		if err := n.Decode("localhost"); err == nil {
+			t.Error("Unexpected nil error")
+		}
+	})
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const secretFileSuffix = "_FILE"
+
+// loadSecretFiles looks for environment variables using the given prefix and
+// the _FILE suffix (e.g. OFFEN_SECRET_FILE) and sets the variable without the
+// suffix to the content of the referenced file. This allows using secrets as
+// provided by Docker or Kubernetes instead of plain environment variables.
+func loadSecretFiles(prefix string, environ []string) error {
+	for _, entry := range environ {
+		pair := strings.SplitN(entry, "=", 2)
+		if len(pair) != 2 || pair[1] == "" {
+			continue
+		}
+		key, location := pair[0], pair[1]
+		if !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, secretFileSuffix) {
+			continue
+		}
+		target := strings.TrimSuffix(key, secretFileSuffix)
+		content, err := ioutil.ReadFile(location)
+		if err != nil {
+			return fmt.Errorf("config: error reading secret file for %s: %w", target, err)
+		}
+		// files created by editors or `echo` will most likely carry a
+		// trailing newline that is not part of the secret
+		secret := strings.TrimRight(string(content), "\r\n")
+		if value, ok := os.LookupEnv(target); ok && value != "" {
+			// the value might have been set by a previous call, in which
+			// case loading the file again is not a conflict
+			if value == secret {
+				continue
+			}
+			return fmt.Errorf("config: both %s and %s are set, use only one of them", target, key)
+		}
+		if err := os.Setenv(target, secret); err != nil {
+			return fmt.Errorf("config: error setting %s from file: %w", target, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSecretFiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "offen-secrets")
+	defer os.RemoveAll(dir)
+	secretFile := filepath.Join(dir, "secret")
+	ioutil.WriteFile(secretFile, []byte("s3cr3t\n"), 0600)
+
+	tests := []struct {
+		name          string
+		environ       []string
+		existing      string
+		expectedValue string
+		expectError   bool
+	}{
+		{
+			"ok",
+			[]string{"OFFEN_TEST_SECRET_FILE=" + secretFile},
+			"",
+			"s3cr3t",
+			false,
+		},
+		{
+			"other prefix",
+			[]string{"OTHER_TEST_SECRET_FILE=" + secretFile},
+			"",
+			"",
+			false,
+		},
+		{
+			"missing file",
+			[]string{"OFFEN_TEST_SECRET_FILE=" + filepath.Join(dir, "missing")},
+			"",
+			"",
+			true,
+		},
+		{
+			"value loaded before",
+			[]string{"OFFEN_TEST_SECRET_FILE=" + secretFile},
+			"s3cr3t",
+			"s3cr3t",
+			false,
+		},
+		{
+			"conflicting values",
+			[]string{"OFFEN_TEST_SECRET_FILE=" + secretFile},
+			"other",
+			"other",
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Setenv("OFFEN_TEST_SECRET", test.existing)
+			defer os.Unsetenv("OFFEN_TEST_SECRET")
+
+			err := loadSecretFiles("OFFEN_", test.environ)
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if value := os.Getenv("OFFEN_TEST_SECRET"); value != test.expectedValue {
+				t.Errorf("Expected %v, got %v", test.expectedValue, value)
+			}
+		})
+	}
+}
+
+func TestNew_SecretFilesPopulateMissing(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "offen-secrets")
+	defer os.RemoveAll(dir)
+	secretFile := filepath.Join(dir, "password")
+	ioutil.WriteFile(secretFile, []byte("s3cr3t\n"), 0600)
+	envFile := filepath.Join(dir, "offen.env")
+	ioutil.WriteFile(envFile, []byte("OFFEN_SERVER_PORT=4000\n"), 0600)
+
+	if value, ok := os.LookupEnv("OFFEN_APP_DEPLOYTARGET"); ok {
+		defer os.Setenv("OFFEN_APP_DEPLOYTARGET", value)
+		os.Unsetenv("OFFEN_APP_DEPLOYTARGET")
+	}
+	os.Setenv("OFFEN_SMTP_PASSWORD_FILE", secretFile)
+	defer os.Unsetenv("OFFEN_SMTP_PASSWORD_FILE")
+	defer os.Unsetenv("OFFEN_SMTP_PASSWORD")
+	defer os.Unsetenv("OFFEN_SECRET")
+	defer os.Unsetenv("OFFEN_SERVER_PORT")
+
+	// populating missing values creates the configuration a second time
+	c, err := New(true, envFile)
+	if err != ErrPopulatedMissing {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if c.SMTP.Password != "s3cr3t" {
+		t.Errorf("Unexpected password %v", c.SMTP.Password)
+	}
+
+	c, err = New(false, envFile)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if c.SMTP.Password != "s3cr3t" {
+		t.Errorf("Unexpected password %v", c.SMTP.Password)
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/offen/offen/server/keys"
+)
+
+// SigningAlgorithm identifies the algorithm used for signing auth tokens.
+type SigningAlgorithm string
+
+// Decode validates and assigns v.
+func (s *SigningAlgorithm) Decode(v string) error {
+	switch v {
+	case keys.SigningAlgorithmES256, keys.SigningAlgorithmEdDSA:
+		*s = SigningAlgorithm(v)
+	default:
+		return fmt.Errorf("unknown or unsupported signing algorithm %s", v)
+	}
+	return nil
+}
+
+func (s *SigningAlgorithm) String() string {
+	return string(*s)
+}
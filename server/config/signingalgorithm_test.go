@@ -0,0 +1,24 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import "testing"
+
+func TestSigningAlgorithm(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var s SigningAlgorithm
+		if err := s.Decode("EdDSA"); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if s.String() != "EdDSA" {
+			t.Errorf("Unexpected value %v", s.String())
+		}
+	})
+	t.Run("error", func(t *testing.T) {
+		var s SigningAlgorithm
+		if err := s.Decode("HS256"); err == nil {
+			t.Error("Unexpected nil error")
+		}
+	})
+}
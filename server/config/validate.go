@@ -0,0 +1,61 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/offen/offen/server/keys"
+)
+
+// ValidationError lists all problems found when validating a configuration,
+// naming the offending keys, so all of them can be fixed at once.
+type ValidationError struct {
+	Problems []string
+}
+
+func (v *ValidationError) Error() string {
+	return fmt.Sprintf("config: invalid configuration: %s", strings.Join(v.Problems, "; "))
+}
+
+// validate checks the configuration for values that would otherwise only
+// fail once they are used, e.g. when connecting to the database.
+func (c *Config) validate() error {
+	var problems []string
+	if c.Database.ConnectionString == "" {
+		problems = append(problems, "database.connectionstring missing (OFFEN_DATABASE_CONNECTIONSTRING)")
+	}
+	if !c.Secret.IsZero() && len(c.Secret) < keys.DefaultSecretLength {
+		problems = append(problems, fmt.Sprintf(
+			"secret too short, expected at least %d bytes, got %d (OFFEN_SECRET)", keys.DefaultSecretLength, len(c.Secret),
+		))
+	}
+	for i, secret := range c.PreviousSecrets {
+		if len(secret) < keys.DefaultSecretLength {
+			problems = append(problems, fmt.Sprintf(
+				"previoussecrets[%d] too short, expected at least %d bytes, got %d (OFFEN_PREVIOUSSECRETS)", i, keys.DefaultSecretLength, len(secret),
+			))
+		}
+	}
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("server.port %d out of range, expected 1-65535 (OFFEN_SERVER_PORT)", c.Server.Port))
+	}
+	if len(c.Allowlist.Networks) != 0 && c.Server.ReverseProxy && len(c.Server.TrustedProxies) == 0 {
+		problems = append(problems, "allowlist.networks requires server.trustedproxies when running behind a reverse proxy (OFFEN_ALLOWLIST_NETWORKS, OFFEN_SERVER_TRUSTEDPROXIES)")
+	}
+	if err := keys.ValidateKeyringTimings(c.Signing.RotationPeriod, c.Signing.GracePeriod); err != nil {
+		problems = append(problems, fmt.Sprintf(
+			"signing.rotationperiod %v and signing.graceperiod %v invalid, expected a period of at least 1s and a shorter grace period (OFFEN_SIGNING_ROTATIONPERIOD, OFFEN_SIGNING_GRACEPERIOD)",
+			c.Signing.RotationPeriod, c.Signing.GracePeriod,
+		))
+	}
+	if (c.Server.SSLCertificate == "") != (c.Server.SSLKey == "") {
+		problems = append(problems, "server.sslcertificate and server.sslkey need to be given together (OFFEN_SERVER_SSLCERTIFICATE, OFFEN_SERVER_SSLKEY)")
+	}
+	if len(problems) != 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
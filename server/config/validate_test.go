@@ -0,0 +1,129 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	valid := func() *Config {
+		c := &Config{}
+		c.Server.Port = 3000
+		c.Database.ConnectionString = "/var/opt/offen/offen.db"
+		c.Secret = Bytes("0123456789abcdef")
+		c.Signing.RotationPeriod = time.Hour * 168
+		c.Signing.GracePeriod = time.Hour * 24
+		return c
+	}
+	tests := []struct {
+		name             string
+		config           func() *Config
+		expectedProblems []string
+	}{
+		{
+			"ok",
+			valid,
+			nil,
+		},
+		{
+			"generated secret",
+			func() *Config {
+				c := valid()
+				c.Secret = nil
+				return c
+			},
+			nil,
+		},
+		{
+			"missing connection string",
+			func() *Config {
+				c := valid()
+				c.Database.ConnectionString = ""
+				return c
+			},
+			[]string{"database.connectionstring missing (OFFEN_DATABASE_CONNECTIONSTRING)"},
+		},
+		{
+			"short secrets",
+			func() *Config {
+				c := valid()
+				c.Secret = Bytes("secret")
+				c.PreviousSecrets = []Bytes{Bytes("0123456789abcdef"), Bytes("old")}
+				return c
+			},
+			[]string{
+				"secret too short, expected at least 16 bytes, got 6 (OFFEN_SECRET)",
+				"previoussecrets[1] too short, expected at least 16 bytes, got 3 (OFFEN_PREVIOUSSECRETS)",
+			},
+		},
+		{
+			"bad server",
+			func() *Config {
+				c := valid()
+				c.Server.Port = 70000
+				c.Server.SSLKey = "/etc/offen/key.pem"
+				return c
+			},
+			[]string{
+				"server.port 70000 out of range, expected 1-65535 (OFFEN_SERVER_PORT)",
+				"server.sslcertificate and server.sslkey need to be given together (OFFEN_SERVER_SSLCERTIFICATE, OFFEN_SERVER_SSLKEY)",
+			},
+		},
+		{
+			"allowlist behind reverse proxy",
+			func() *Config {
+				c := valid()
+				c.Server.ReverseProxy = true
+				c.Allowlist.Networks.Decode("10.0.0.0/8")
+				return c
+			},
+			[]string{"allowlist.networks requires server.trustedproxies when running behind a reverse proxy (OFFEN_ALLOWLIST_NETWORKS, OFFEN_SERVER_TRUSTEDPROXIES)"},
+		},
+		{
+			"allowlist behind trusted proxy",
+			func() *Config {
+				c := valid()
+				c.Server.ReverseProxy = true
+				c.Allowlist.Networks.Decode("10.0.0.0/8")
+				c.Server.TrustedProxies.Decode("192.0.2.1")
+				return c
+			},
+			nil,
+		},
+		{
+			"bad signing timings",
+			func() *Config {
+				c := valid()
+				c.Signing.RotationPeriod = time.Millisecond * 500
+				c.Signing.GracePeriod = time.Second
+				return c
+			},
+			[]string{
+				"signing.rotationperiod 500ms and signing.graceperiod 1s invalid, expected a period of at least 1s and a shorter grace period (OFFEN_SIGNING_ROTATIONPERIOD, OFFEN_SIGNING_GRACEPERIOD)",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.config().validate()
+			if test.expectedProblems == nil {
+				if err != nil {
+					t.Errorf("Unexpected error %v", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Expected validation error, got %v", err)
+			}
+			if !reflect.DeepEqual(test.expectedProblems, validationErr.Problems) {
+				t.Errorf("Expected problems %v, got %v", test.expectedProblems, validationErr.Problems)
+			}
+		})
+	}
+}
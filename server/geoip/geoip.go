@@ -0,0 +1,361 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package geoip resolves the country of IP addresses using databases in the
+// MaxMind DB format, e.g. GeoLite2 Country. Only the ISO code of the country
+// is ever read from the database.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+)
+
+// metadataMarker precedes the metadata section at the end of the file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparator is the number of zero bytes between the search tree
+// and the data section.
+const dataSectionSeparator = 16
+
+// Reader looks up countries in a MaxMind DB. It is safe for concurrent use.
+type Reader struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+// Open reads the database at the given path.
+func Open(path string) (*Reader, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: error reading database: %w", err)
+	}
+	return New(b)
+}
+
+// New creates a Reader for the given database contents.
+func New(b []byte) (*Reader, error) {
+	index := bytes.LastIndex(b, metadataMarker)
+	if index == -1 {
+		return nil, errors.New("geoip: could not find metadata, is this a MaxMind DB file?")
+	}
+	metadataStart := index + len(metadataMarker)
+	value, _, err := decoder{b[metadataStart:]}.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: error decoding metadata: %w", err)
+	}
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("geoip: unexpected metadata type")
+	}
+
+	r := &Reader{buf: b}
+	for key, dst := range map[string]*uint{
+		"node_count":  &r.nodeCount,
+		"record_size": &r.recordSize,
+		"ip_version":  &r.ipVersion,
+	} {
+		v, ok := metadata[key].(uint64)
+		if !ok {
+			return nil, fmt.Errorf("geoip: metadata is missing %s", key)
+		}
+		*dst = uint(v)
+	}
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("geoip: unsupported record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("geoip: unsupported ip version %d", r.ipVersion)
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(index) {
+		return nil, errors.New("geoip: search tree exceeds size of database")
+	}
+	r.data = b[treeSize+dataSectionSeparator : index]
+
+	// IPv4 addresses are stored as IPv4-compatible IPv6 addresses in IPv6
+	// databases, so looking them up starts at the node of ::/96
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country the given IP
+// is located in. An empty string is returned in case the address is not
+// contained in the database.
+func (r *Reader) Country(ip net.IP) (string, error) {
+	record, err := r.lookup(ip)
+	if err != nil || record == nil {
+		return "", err
+	}
+	// country databases use country, city databases might only contain
+	// the registered country for some networks
+	for _, key := range []string{"country", "registered_country"} {
+		country, _ := record[key].(map[string]interface{})
+		if code, ok := country["iso_code"].(string); ok && code != "" {
+			return code, nil
+		}
+	}
+	return "", nil
+}
+
+func (r *Reader) lookup(ip net.IP) (map[string]interface{}, error) {
+	if ip == nil {
+		return nil, errors.New("geoip: invalid ip address")
+	}
+	node := uint(0)
+	bitCount := 128
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+		bitCount = 32
+		node = r.ipv4Start
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < bitCount && node < r.nodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-uint(i&7))) & 1
+		node = r.record(node, bit)
+	}
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, errors.New("geoip: invalid search tree")
+	}
+
+	offset := node - r.nodeCount - dataSectionSeparator
+	if offset >= uint(len(r.data)) {
+		return nil, errors.New("geoip: record points outside of data section")
+	}
+	value, _, err := decoder{r.data}.decode(offset)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: error decoding record: %w", err)
+	}
+	record, _ := value.(map[string]interface{})
+	return record, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of the given node.
+func (r *Reader) record(node, bit uint) uint {
+	size := r.recordSize / 4
+	b := r.buf[node*size : node*size+size]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// Data types of the MaxMind DB format.
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEndMarker = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+var errTruncated = errors.New("unexpected end of data")
+
+type decoder struct {
+	buf []byte
+}
+
+// decode decodes the value at the given offset and returns it along with
+// the offset of the following value.
+func (d decoder) decode(offset uint) (interface{}, uint, error) {
+	typeNum, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typeNum == typePointer {
+		pointer, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer)
+		return value, next, err
+	}
+	return d.value(typeNum, size, offset)
+}
+
+// control reads the control byte(s) at the given offset and returns the
+// type and size of the value as well as the offset of its payload.
+func (d decoder) control(offset uint) (uint, uint, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, errTruncated
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typeNum := uint(ctrl >> 5)
+	if typeNum == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, errTruncated
+		}
+		typeNum = uint(d.buf[offset]) + 7
+		offset++
+	}
+	if typeNum == typePointer {
+		// pointers encode their size differently, which is handled
+		// when reading the pointer
+		return typeNum, uint(ctrl & 0x1f), offset, nil
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return 0, 0, 0, errTruncated
+		}
+		extra := uint(0)
+		for _, b := range d.buf[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+	return typeNum, size, offset, nil
+}
+
+func (d decoder) pointer(bits, offset uint) (uint, uint, error) {
+	n := (bits>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errTruncated
+	}
+	value := uint(0)
+	if n != 4 {
+		value = bits & 0x7
+	}
+	for _, b := range d.buf[offset : offset+n] {
+		value = value<<8 | uint(b)
+	}
+	switch n {
+	case 2:
+		value += 2048
+	case 3:
+		value += 526336
+	}
+	return value, offset + n, nil
+}
+
+func (d decoder) value(typeNum, size, offset uint) (interface{}, uint, error) {
+	switch typeNum {
+	case typeMap:
+		result := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			result[keyString] = value
+			offset = next
+		}
+		return result, offset, nil
+	case typeArray:
+		result := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			result = append(result, value)
+			offset = next
+		}
+		return result, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errTruncated
+	}
+	payload := d.buf[offset : offset+size]
+	next := offset + size
+	switch typeNum {
+	case typeString:
+		return string(payload), next, nil
+	case typeBytes, typeUint128:
+		return append([]byte{}, payload...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid size %d for double", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid size %d for float", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(payload))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("invalid size %d for unsigned integer", size)
+		}
+		value := uint64(0)
+		for _, b := range payload {
+			value = value<<8 | uint64(b)
+		}
+		return value, next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("invalid size %d for int32", size)
+		}
+		value := uint32(0)
+		for _, b := range payload {
+			value = value<<8 | uint32(b)
+		}
+		return int64(int32(value)), next, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", typeNum)
+}
@@ -0,0 +1,193 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+type testNetwork struct {
+	ip     net.IP
+	prefix int
+	record int
+}
+
+// buildDatabase creates an IPv6 database using the given record size where
+// each network points to one of the given data records.
+func buildDatabase(recordSize int, networks []testNetwork, records [][]byte) []byte {
+	// a child of 0 means empty, negative values reference records
+	nodes := [][2]int{{0, 0}}
+	for _, n := range networks {
+		ip := make([]byte, 16)
+		if v4 := n.ip.To4(); v4 != nil {
+			copy(ip[12:], v4)
+		} else {
+			copy(ip, n.ip)
+		}
+		node := 0
+		for i := 0; i < n.prefix; i++ {
+			bit := int(ip[i>>3]>>(7-uint(i&7))) & 1
+			if i == n.prefix-1 {
+				nodes[node][bit] = -(n.record + 1)
+				break
+			}
+			if nodes[node][bit] <= 0 {
+				nodes = append(nodes, [2]int{0, 0})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	var data bytes.Buffer
+	var offsets []int
+	for _, record := range records {
+		offsets = append(offsets, data.Len())
+		data.Write(record)
+	}
+
+	var tree bytes.Buffer
+	nodeCount := len(nodes)
+	for _, node := range nodes {
+		var values [2]uint32
+		for i, child := range node {
+			switch {
+			case child == 0:
+				values[i] = uint32(nodeCount)
+			case child < 0:
+				values[i] = uint32(nodeCount + dataSectionSeparator + offsets[-child-1])
+			default:
+				values[i] = uint32(child)
+			}
+		}
+		switch recordSize {
+		case 24:
+			for _, v := range values {
+				tree.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+			}
+		case 28:
+			tree.Write([]byte{byte(values[0] >> 16), byte(values[0] >> 8), byte(values[0])})
+			tree.WriteByte(byte(values[0]>>24)<<4 | byte(values[1]>>24)&0x0f)
+			tree.Write([]byte{byte(values[1] >> 16), byte(values[1] >> 8), byte(values[1])})
+		case 32:
+			binary.Write(&tree, binary.BigEndian, values)
+		}
+	}
+
+	var result bytes.Buffer
+	result.Write(tree.Bytes())
+	result.Write(make([]byte, dataSectionSeparator))
+	result.Write(data.Bytes())
+	result.Write(metadataMarker)
+	result.Write(encodeMap(
+		"node_count", encodeUint(uint32(nodeCount)),
+		"record_size", encodeUint(uint32(recordSize)),
+		"ip_version", encodeUint(6),
+		"database_type", encodeString("Test-Country"),
+	))
+	return result.Bytes()
+}
+
+func encodeString(s string) []byte {
+	return append([]byte{byte(typeString<<5 | len(s))}, s...)
+}
+
+func encodeUint(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return append([]byte{typeUint32<<5 | 4}, b...)
+}
+
+func encodePointer(offset int) []byte {
+	return []byte{byte(typePointer<<5 | (offset>>8)&0x7), byte(offset)}
+}
+
+func encodeMap(pairs ...interface{}) []byte {
+	result := []byte{byte(typeMap<<5 | len(pairs)/2)}
+	for i := 0; i < len(pairs); i += 2 {
+		result = append(result, encodeString(pairs[i].(string))...)
+		result = append(result, pairs[i+1].([]byte)...)
+	}
+	return result
+}
+
+func TestReader_Country(t *testing.T) {
+	germany := encodeMap("country", encodeMap("iso_code", encodeString("DE")))
+	records := [][]byte{
+		germany,
+		// the record of the second network points to the country of the
+		// first one, which is how databases deduplicate data
+		encodeMap(
+			"country", encodePointer(1+len(encodeString("country"))),
+			"continent", encodeMap("code", encodeString("EU")),
+		),
+		encodeMap("registered_country", encodeMap("iso_code", encodeString("FR"))),
+		encodeMap("location", encodeMap("accuracy_radius", encodeUint(100))),
+	}
+	networks := []testNetwork{
+		{net.ParseIP("81.0.0.0"), 96 + 8, 0},
+		{net.ParseIP("82.16.0.0"), 96 + 12, 1},
+		{net.ParseIP("2001:db8::"), 32, 2},
+		{net.ParseIP("10.0.0.0"), 96 + 8, 3},
+	}
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"81.2.3.4", "DE"},
+		{"82.31.255.255", "DE"},
+		{"82.32.0.1", ""},
+		{"1.1.1.1", ""},
+		{"2001:db8::1", "FR"},
+		{"2a00::1", ""},
+		{"10.1.2.3", ""},
+	}
+	for _, recordSize := range []int{24, 28, 32} {
+		r, err := New(buildDatabase(recordSize, networks, records))
+		if err != nil {
+			t.Fatalf("Unexpected error creating reader with record size %d: %v", recordSize, err)
+		}
+		for _, test := range tests {
+			country, err := r.Country(net.ParseIP(test.ip))
+			if err != nil {
+				t.Errorf("Unexpected error looking up %s: %v", test.ip, err)
+			}
+			if country != test.expected {
+				t.Errorf("Expected %q for %s using record size %d, got %q", test.expected, test.ip, recordSize, country)
+			}
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"no metadata", []byte("o hai")},
+		{"bad metadata", append(append([]byte{}, metadataMarker...), encodeString("metadata")...)},
+		{"missing fields", append(append([]byte{}, metadataMarker...), encodeMap("ip_version", encodeUint(6))...)},
+		{"bad record size", append(append([]byte{}, metadataMarker...), encodeMap(
+			"node_count", encodeUint(0),
+			"record_size", encodeUint(20),
+			"ip_version", encodeUint(6),
+		)...)},
+		{"truncated tree", append(append([]byte{}, metadataMarker...), encodeMap(
+			"node_count", encodeUint(100),
+			"record_size", encodeUint(24),
+			"ip_version", encodeUint(6),
+		)...)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := New(test.data); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
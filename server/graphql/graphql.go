@@ -0,0 +1,648 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package graphql implements executing GraphQL queries against a schema
+// defined in Go. It supports the subset of the language needed for reading
+// data: queries including variables, aliases, fragments and the skip and
+// include directives. Mutations, subscriptions and introspection other than
+// __typename are not supported.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Type is the type of a field or argument.
+type Type interface {
+	String() string
+}
+
+// Scalar is a leaf type. Values are serialized as returned by resolvers
+// after being checked against the kind of the scalar.
+type Scalar struct {
+	Name string
+}
+
+func (s *Scalar) String() string {
+	return s.Name
+}
+
+// The built-in scalar types.
+var (
+	String  = &Scalar{Name: "String"}
+	Int     = &Scalar{Name: "Int"}
+	Float   = &Scalar{Name: "Float"}
+	Boolean = &Scalar{Name: "Boolean"}
+	ID      = &Scalar{Name: "ID"}
+)
+
+// List wraps a type whose values are lists.
+type List struct {
+	OfType Type
+}
+
+func (l *List) String() string {
+	return "[" + l.OfType.String() + "]"
+}
+
+// NonNull wraps a type whose values must not be null.
+type NonNull struct {
+	OfType Type
+}
+
+func (n *NonNull) String() string {
+	return n.OfType.String() + "!"
+}
+
+// Object is a type that has fields.
+type Object struct {
+	Name   string
+	Fields Fields
+}
+
+func (o *Object) String() string {
+	return o.Name
+}
+
+// Fields maps field names to their definitions.
+type Fields map[string]*Field
+
+// Field defines a field of an object.
+type Field struct {
+	Type Type
+	Args map[string]*Argument
+	// Resolve returns the value of the field. In case it is nil, the value is
+	// looked up in the source in case it is a map[string]interface{}.
+	Resolve func(p ResolveParams) (interface{}, error)
+	// Authorize is called before resolving the field. In case it returns an
+	// error, the field is resolved to null and the error is reported.
+	Authorize func(p ResolveParams) error
+}
+
+// Argument defines an argument of a field.
+type Argument struct {
+	Type         Type
+	DefaultValue interface{}
+}
+
+// ResolveParams is passed when resolving or authorizing a field.
+type ResolveParams struct {
+	Context context.Context
+	Source  interface{}
+	Args    map[string]interface{}
+}
+
+// Schema defines the types that can be queried.
+type Schema struct {
+	Query *Object
+}
+
+// Request is a GraphQL request as sent by clients.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Result is the response to a request. Data is omitted in case the request
+// could not be executed at all.
+type Result struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Error is an error that occurred while handling a request. Path is set in
+// case the error is associated with a field.
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Execute runs the given request against the schema.
+func (s *Schema) Execute(ctx context.Context, req Request) *Result {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Result{Errors: []*Error{{Message: err.Error()}}}
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return &Result{Errors: []*Error{{Message: err.Error()}}}
+	}
+	if op.kind != "query" {
+		return &Result{Errors: []*Error{{Message: fmt.Sprintf("graphql: %s operations are not supported", op.kind)}}}
+	}
+	variables, err := coerceVariables(op.variables, req.Variables)
+	if err != nil {
+		return &Result{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	e := &executor{ctx: ctx, doc: doc, variables: variables}
+	if errs := e.validate(s.Query, op.selections, nil, map[string]bool{}); len(errs) != 0 {
+		return &Result{Errors: errs}
+	}
+	data := e.executeSelections(s.Query, nil, op.selections, nil)
+	return &Result{Data: data, Errors: e.errors}
+}
+
+func (d *document) operation(name string) (*operation, error) {
+	if name == "" {
+		if len(d.operations) != 1 {
+			return nil, errors.New("graphql: operation name is required when the document contains multiple operations")
+		}
+		return d.operations[0], nil
+	}
+	for _, op := range d.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("graphql: unknown operation %s", name)
+}
+
+var scalarsByName = map[string]*Scalar{
+	String.Name:  String,
+	Int.Name:     Int,
+	Float.Name:   Float,
+	Boolean.Name: Boolean,
+	ID.Name:      ID,
+}
+
+func coerceVariables(definitions []*variableDefinition, given map[string]interface{}) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	for _, definition := range definitions {
+		scalar, ok := scalarsByName[definition.typeName]
+		if !ok {
+			return nil, fmt.Errorf("graphql: variable $%s has unsupported type %s", definition.name, definition.typeName)
+		}
+		var t Type = scalar
+		if definition.list {
+			t = &List{OfType: t}
+		}
+		value, ok := given[definition.name]
+		if !ok || value == nil {
+			switch {
+			case definition.hasDefault:
+				value = definition.defaultValue
+			case definition.required:
+				return nil, fmt.Errorf("graphql: variable $%s is required", definition.name)
+			default:
+				continue
+			}
+		}
+		coerced, err := coerceInput(t, value)
+		if err != nil {
+			return nil, fmt.Errorf("graphql: invalid value for variable $%s: %w", definition.name, err)
+		}
+		result[definition.name] = coerced
+	}
+	return result, nil
+}
+
+// coerceInput checks the given input value against the given type and
+// converts it where needed, e.g. for numbers decoded from JSON.
+func coerceInput(t Type, value interface{}) (interface{}, error) {
+	if nonNull, ok := t.(*NonNull); ok {
+		if value == nil {
+			return nil, fmt.Errorf("expected non-null value of type %s", nonNull.OfType)
+		}
+		return coerceInput(nonNull.OfType, value)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	switch t := t.(type) {
+	case *List:
+		values, ok := value.([]interface{})
+		if !ok {
+			// single values are treated like lists of one element
+			values = []interface{}{value}
+		}
+		result := make([]interface{}, len(values))
+		for idx, value := range values {
+			coerced, err := coerceInput(t.OfType, value)
+			if err != nil {
+				return nil, err
+			}
+			result[idx] = coerced
+		}
+		return result, nil
+	case *Scalar:
+		switch t {
+		case String:
+			if s, ok := value.(string); ok {
+				return s, nil
+			}
+		case ID:
+			switch v := value.(type) {
+			case string:
+				return v, nil
+			case int:
+				return fmt.Sprintf("%d", v), nil
+			}
+		case Int:
+			switch v := value.(type) {
+			case int:
+				return v, nil
+			case float64:
+				if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32 {
+					return int(v), nil
+				}
+			}
+		case Float:
+			switch v := value.(type) {
+			case int:
+				return float64(v), nil
+			case float64:
+				return v, nil
+			}
+		case Boolean:
+			if b, ok := value.(bool); ok {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("expected value of type %s, got %v", t, value)
+	}
+	return nil, fmt.Errorf("unsupported input type %s", t)
+}
+
+type executor struct {
+	ctx       context.Context
+	doc       *document
+	variables map[string]interface{}
+	errors    []*Error
+}
+
+func (e *executor) addError(err error, path []interface{}) {
+	e.errors = append(e.errors, &Error{
+		Message: err.Error(),
+		Path:    append([]interface{}{}, path...),
+	})
+}
+
+// resolveLiteral replaces variable references in the given literal.
+func (e *executor) resolveLiteral(value interface{}) interface{} {
+	switch v := value.(type) {
+	case variableRef:
+		return e.variables[string(v)]
+	case enumValue:
+		return string(v)
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for idx, item := range v {
+			result[idx] = e.resolveLiteral(item)
+		}
+		return result
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+		for key, item := range v {
+			result[key] = e.resolveLiteral(item)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+func (e *executor) arguments(definition *Field, f *field) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	for name := range f.arguments {
+		if _, ok := definition.Args[name]; !ok {
+			return nil, fmt.Errorf("graphql: unknown argument %s on field %s", name, f.name)
+		}
+	}
+	for name, arg := range definition.Args {
+		value, ok := f.arguments[name]
+		if ok {
+			value = e.resolveLiteral(value)
+		}
+		if value == nil && arg.DefaultValue != nil {
+			value = arg.DefaultValue
+		}
+		coerced, err := coerceInput(arg.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("graphql: invalid value for argument %s on field %s: %w", name, f.name, err)
+		}
+		if coerced != nil {
+			args[name] = coerced
+		}
+	}
+	return args, nil
+}
+
+// included evaluates the skip and include directives.
+func (e *executor) included(directives []*directive) (bool, error) {
+	for _, d := range directives {
+		switch d.name {
+		case "skip", "include":
+			condition, ok := e.resolveLiteral(d.arguments["if"]).(bool)
+			if !ok {
+				return false, fmt.Errorf("graphql: directive @%s requires a boolean argument if", d.name)
+			}
+			if condition == (d.name == "skip") {
+				return false, nil
+			}
+		default:
+			return false, fmt.Errorf("graphql: unknown directive @%s", d.name)
+		}
+	}
+	return true, nil
+}
+
+type collectedField struct {
+	key    string
+	fields []*field
+}
+
+// collectFields flattens the given selections for the given object type,
+// merging fields that share a response key.
+func (e *executor) collectFields(obj *Object, selections []*selection, result []*collectedField, visited map[string]bool) ([]*collectedField, error) {
+	for _, s := range selections {
+		include, err := e.included(s.directives)
+		if err != nil {
+			return nil, err
+		}
+		if !include {
+			continue
+		}
+		switch {
+		case s.field != nil:
+			key := s.field.responseKey()
+			merged := false
+			for _, c := range result {
+				if c.key == key {
+					c.fields = append(c.fields, s.field)
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				result = append(result, &collectedField{key: key, fields: []*field{s.field}})
+			}
+		case s.inline != nil:
+			if s.inline.typeCondition != "" && s.inline.typeCondition != obj.Name {
+				continue
+			}
+			if result, err = e.collectFields(obj, s.inline.selections, result, visited); err != nil {
+				return nil, err
+			}
+		default:
+			if visited[s.fragmentSpread] {
+				continue
+			}
+			visited[s.fragmentSpread] = true
+			f, ok := e.doc.fragments[s.fragmentSpread]
+			if !ok {
+				return nil, fmt.Errorf("graphql: unknown fragment %s", s.fragmentSpread)
+			}
+			if f.typeCondition != obj.Name {
+				continue
+			}
+			if result, err = e.collectFields(obj, f.selections, result, visited); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+func subselections(fields []*field) []*selection {
+	var result []*selection
+	for _, f := range fields {
+		result = append(result, f.selections...)
+	}
+	return result
+}
+
+func namedType(t Type) Type {
+	switch t := t.(type) {
+	case *NonNull:
+		return namedType(t.OfType)
+	case *List:
+		return namedType(t.OfType)
+	default:
+		return t
+	}
+}
+
+// validate checks that all selected fields exist and that selections are
+// given for objects only, so that such errors are reported before any
+// resolver is called.
+func (e *executor) validate(obj *Object, selections []*selection, path []interface{}, visited map[string]bool) []*Error {
+	collected, err := e.collectFields(obj, selections, nil, visited)
+	if err != nil {
+		return []*Error{{Message: err.Error(), Path: path}}
+	}
+	var errs []*Error
+	for _, c := range collected {
+		fieldPath := append(append([]interface{}{}, path...), c.key)
+		name := c.fields[0].name
+		for _, f := range c.fields[1:] {
+			if f.name != name {
+				errs = append(errs, &Error{
+					Message: fmt.Sprintf("graphql: fields %s and %s conflict as both use the response key %s", name, f.name, c.key),
+					Path:    fieldPath,
+				})
+			}
+		}
+		if name == "__typename" {
+			continue
+		}
+		definition, ok := obj.Fields[name]
+		if !ok {
+			errs = append(errs, &Error{
+				Message: fmt.Sprintf("graphql: cannot query field %s on type %s", name, obj.Name),
+				Path:    fieldPath,
+			})
+			continue
+		}
+		sub := subselections(c.fields)
+		if child, isObject := namedType(definition.Type).(*Object); isObject {
+			if len(sub) == 0 {
+				errs = append(errs, &Error{
+					Message: fmt.Sprintf("graphql: field %s of type %s requires a selection of subfields", name, definition.Type),
+					Path:    fieldPath,
+				})
+				continue
+			}
+			errs = append(errs, e.validate(child, sub, fieldPath, map[string]bool{})...)
+		} else if len(sub) != 0 {
+			errs = append(errs, &Error{
+				Message: fmt.Sprintf("graphql: field %s of type %s must not have a selection of subfields", name, definition.Type),
+				Path:    fieldPath,
+			})
+		}
+	}
+	return errs
+}
+
+func (e *executor) executeSelections(obj *Object, source interface{}, selections []*selection, path []interface{}) *orderedMap {
+	result := &orderedMap{values: map[string]interface{}{}}
+	collected, err := e.collectFields(obj, selections, nil, map[string]bool{})
+	if err != nil {
+		e.addError(err, path)
+		return result
+	}
+	for _, c := range collected {
+		fieldPath := append(append([]interface{}{}, path...), c.key)
+		result.set(c.key, e.executeField(obj, source, c.fields, fieldPath))
+	}
+	return result
+}
+
+func (e *executor) executeField(obj *Object, source interface{}, fields []*field, path []interface{}) interface{} {
+	f := fields[0]
+	if f.name == "__typename" {
+		return obj.Name
+	}
+	definition := obj.Fields[f.name]
+	args, err := e.arguments(definition, f)
+	if err != nil {
+		e.addError(err, path)
+		return nil
+	}
+	params := ResolveParams{Context: e.ctx, Source: source, Args: args}
+	if definition.Authorize != nil {
+		if err := definition.Authorize(params); err != nil {
+			e.addError(err, path)
+			return nil
+		}
+	}
+	var value interface{}
+	if definition.Resolve != nil {
+		if value, err = definition.Resolve(params); err != nil {
+			e.addError(err, path)
+			return nil
+		}
+	} else if m, ok := source.(map[string]interface{}); ok {
+		value = m[f.name]
+	}
+	return e.completeValue(definition.Type, fields, value, path)
+}
+
+func (e *executor) completeValue(t Type, fields []*field, value interface{}, path []interface{}) interface{} {
+	if nonNull, ok := t.(*NonNull); ok {
+		completed := e.completeValue(nonNull.OfType, fields, value, path)
+		if completed == nil {
+			e.addError(fmt.Errorf("graphql: non-null field %s resolved to null", fields[0].name), path)
+		}
+		return completed
+	}
+	if value == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		if _, isObject := t.(*Object); !isObject {
+			value = rv.Elem().Interface()
+			rv = rv.Elem()
+		}
+	}
+
+	switch t := t.(type) {
+	case *List:
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			e.addError(fmt.Errorf("graphql: expected list for field %s", fields[0].name), path)
+			return nil
+		}
+		result := make([]interface{}, rv.Len())
+		for idx := range result {
+			result[idx] = e.completeValue(t.OfType, fields, rv.Index(idx).Interface(), append(path, idx))
+		}
+		return result
+	case *Object:
+		return e.executeSelections(t, value, subselections(fields), path)
+	case *Scalar:
+		serialized, err := serializeScalar(t, rv)
+		if err != nil {
+			e.addError(fmt.Errorf("graphql: error serializing field %s: %w", fields[0].name, err), path)
+			return nil
+		}
+		return serialized
+	}
+	e.addError(fmt.Errorf("graphql: unsupported type %s", t), path)
+	return nil
+}
+
+func serializeScalar(t *Scalar, rv reflect.Value) (interface{}, error) {
+	switch t {
+	case Int:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return rv.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return rv.Uint(), nil
+		}
+	case Float:
+		switch rv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return rv.Float(), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(rv.Int()), nil
+		}
+	case Boolean:
+		if rv.Kind() == reflect.Bool {
+			return rv.Bool(), nil
+		}
+	case String, ID:
+		if rv.Kind() == reflect.String {
+			return rv.String(), nil
+		}
+		if s, ok := rv.Interface().(fmt.Stringer); ok {
+			return s.String(), nil
+		}
+	default:
+		// custom scalars are serialized as is
+		return rv.Interface(), nil
+	}
+	return nil, fmt.Errorf("cannot serialize %v as %s", rv.Interface(), t)
+}
+
+// orderedMap is a JSON object that keeps the order of its keys, as results
+// are expected to use the order of the selections in the query.
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *orderedMap) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for idx, key := range o.keys {
+		if idx != 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
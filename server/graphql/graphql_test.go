@@ -0,0 +1,249 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type testItem struct {
+	ID     string
+	Count  int
+	Secret string
+}
+
+var testItemType = &Object{
+	Name: "Item",
+	Fields: Fields{
+		"id": &Field{
+			Type: &NonNull{OfType: ID},
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				return p.Source.(testItem).ID, nil
+			},
+		},
+		"count": &Field{
+			Type: Int,
+			Args: map[string]*Argument{
+				"factor": {Type: Int, DefaultValue: 1},
+			},
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				return p.Source.(testItem).Count * p.Args["factor"].(int), nil
+			},
+		},
+		"secret": &Field{
+			Type: String,
+			Authorize: func(p ResolveParams) error {
+				if p.Context.Value(testContextKey{}) != "admin" {
+					return errors.New("not allowed")
+				}
+				return nil
+			},
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				return p.Source.(testItem).Secret, nil
+			},
+		},
+	},
+}
+
+type testContextKey struct{}
+
+var testSchema = &Schema{
+	Query: &Object{
+		Name: "Query",
+		Fields: Fields{
+			"items": &Field{
+				Type: &List{OfType: testItemType},
+				Args: map[string]*Argument{
+					"ids": {Type: &List{OfType: &NonNull{OfType: ID}}},
+				},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					items := []testItem{
+						{ID: "a", Count: 1, Secret: "x"},
+						{ID: "b", Count: 2, Secret: "y"},
+					}
+					ids, ok := p.Args["ids"].([]interface{})
+					if !ok {
+						return items, nil
+					}
+					var result []testItem
+					for _, item := range items {
+						for _, id := range ids {
+							if item.ID == id {
+								result = append(result, item)
+							}
+						}
+					}
+					return result, nil
+				},
+			},
+			"item": &Field{
+				Type: testItemType,
+				Args: map[string]*Argument{
+					"id": {Type: &NonNull{OfType: ID}},
+				},
+				Resolve: func(p ResolveParams) (interface{}, error) {
+					if p.Args["id"] == "a" {
+						return testItem{ID: "a", Count: 1, Secret: "x"}, nil
+					}
+					return nil, errors.New("not found")
+				},
+			},
+			"version": &Field{Type: String},
+		},
+	},
+}
+
+func TestSchema_Execute(t *testing.T) {
+	tests := []struct {
+		name           string
+		request        Request
+		role           string
+		expectedData   string
+		expectedErrors []string
+	}{
+		{
+			"shorthand query",
+			Request{Query: `{ items { id count } }`},
+			"",
+			`{"items":[{"id":"a","count":1},{"id":"b","count":2}]}`,
+			nil,
+		},
+		{
+			"aliases and arguments",
+			Request{Query: `query { first: item(id: "a") { id double: count(factor: 2) } __typename }`},
+			"",
+			`{"first":{"id":"a","double":2},"__typename":"Query"}`,
+			nil,
+		},
+		{
+			"variables",
+			Request{
+				Query:     `query Items($ids: [ID!], $factor: Int = 3) { items(ids: $ids) { count(factor: $factor) } }`,
+				Variables: map[string]interface{}{"ids": []interface{}{"b"}},
+			},
+			"",
+			`{"items":[{"count":6}]}`,
+			nil,
+		},
+		{
+			"fragments and directives",
+			Request{
+				Query: `
+					query ($withCount: Boolean!) {
+						items { ...itemFields ... on Item { count @include(if: $withCount) } }
+					}
+					fragment itemFields on Item { id }
+				`,
+				Variables: map[string]interface{}{"withCount": false},
+			},
+			"",
+			`{"items":[{"id":"a"},{"id":"b"}]}`,
+			nil,
+		},
+		{
+			"field level authorization",
+			Request{Query: `{ item(id: "a") { id secret } }`},
+			"",
+			`{"item":{"id":"a","secret":null}}`,
+			[]string{"not allowed"},
+		},
+		{
+			"authorized field",
+			Request{Query: `{ item(id: "a") { secret } }`},
+			"admin",
+			`{"item":{"secret":"x"}}`,
+			nil,
+		},
+		{
+			"resolver error",
+			Request{Query: `{ item(id: "z") { id } version }`},
+			"",
+			`{"item":null,"version":null}`,
+			[]string{"not found"},
+		},
+		{
+			"unknown field",
+			Request{Query: `{ items { id unknown } }`},
+			"",
+			"",
+			[]string{"graphql: cannot query field unknown on type Item"},
+		},
+		{
+			"missing subselection",
+			Request{Query: `{ items }`},
+			"",
+			"",
+			[]string{"graphql: field items of type [Item] requires a selection of subfields"},
+		},
+		{
+			"missing variable",
+			Request{Query: `query ($id: ID!) { item(id: $id) { id } }`},
+			"",
+			"",
+			[]string{"graphql: variable $id is required"},
+		},
+		{
+			"mutation",
+			Request{Query: `mutation { items { id } }`},
+			"",
+			"",
+			[]string{"graphql: mutation operations are not supported"},
+		},
+		{
+			"multiple operations",
+			Request{Query: `query A { version } query B { version }`, OperationName: "B"},
+			"",
+			`{"version":null}`,
+			nil,
+		},
+		{
+			"syntax error",
+			Request{Query: `{ items { id }`},
+			"",
+			"",
+			[]string{"graphql: syntax error at position 14: expected name, found end of document"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), testContextKey{}, test.role)
+			result := testSchema.Execute(ctx, test.request)
+			var messages []string
+			for _, err := range result.Errors {
+				messages = append(messages, err.Message)
+			}
+			if strings.Join(messages, ";") != strings.Join(test.expectedErrors, ";") {
+				t.Errorf("Unexpected errors %v", messages)
+			}
+			if test.expectedData == "" {
+				if result.Data != nil {
+					t.Errorf("Unexpected data %v", result.Data)
+				}
+				return
+			}
+			b, err := json.Marshal(result.Data)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if string(b) != test.expectedData {
+				t.Errorf("Unexpected data %s", b)
+			}
+		})
+	}
+}
+
+func TestSchema_Execute_errorPath(t *testing.T) {
+	result := testSchema.Execute(context.Background(), Request{Query: `{ items { secret } }`})
+	if len(result.Errors) != 2 {
+		t.Fatalf("Unexpected errors %v", result.Errors)
+	}
+	b, _ := json.Marshal(result.Errors[1])
+	if string(b) != `{"message":"not allowed","path":["items",1,"secret"]}` {
+		t.Errorf("Unexpected error %s", b)
+	}
+}
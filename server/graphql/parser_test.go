@@ -0,0 +1,144 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package graphql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLexer(t *testing.T) {
+	tests := []struct {
+		name          string
+		src           string
+		expected      []token
+		expectedError bool
+	}{
+		{
+			"punctuators and names",
+			"{ ...on_Item, # comment\n $x: [Int!] }",
+			[]token{
+				{tokenPunctuator, "{", 0},
+				{tokenPunctuator, "...", 2},
+				{tokenName, "on_Item", 5},
+				{tokenPunctuator, "$", 25},
+				{tokenName, "x", 26},
+				{tokenPunctuator, ":", 27},
+				{tokenPunctuator, "[", 29},
+				{tokenName, "Int", 30},
+				{tokenPunctuator, "!", 33},
+				{tokenPunctuator, "]", 34},
+				{tokenPunctuator, "}", 36},
+			},
+			false,
+		},
+		{
+			"numbers",
+			"12 -3 1.5 2e10",
+			[]token{
+				{tokenInt, "12", 0},
+				{tokenInt, "-3", 3},
+				{tokenFloat, "1.5", 6},
+				{tokenFloat, "2e10", 10},
+			},
+			false,
+		},
+		{
+			"strings",
+			`"a\"bä" """block \""" string"""`,
+			[]token{
+				{tokenString, `a"bä`, 0},
+				{tokenString, `block """ string`, 9},
+			},
+			false,
+		},
+		{"unterminated string", `"abc`, nil, true},
+		{"invalid number", "1.", nil, true},
+		{"invalid character", "?", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := &lexer{src: test.src}
+			var tokens []token
+			for {
+				tok, err := l.next()
+				if err != nil {
+					if !test.expectedError {
+						t.Errorf("Unexpected error %v", err)
+					}
+					return
+				}
+				if tok.kind == tokenEOF {
+					break
+				}
+				tokens = append(tokens, tok)
+			}
+			if test.expectedError {
+				t.Error("Expected error, got nil")
+			}
+			if !reflect.DeepEqual(tokens, test.expected) {
+				t.Errorf("Unexpected tokens %v", tokens)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	doc, err := parse(`
+		query Named($a: String = "x", $b: [Int]!) @dir {
+			alias: field(arg: $a, list: [1, 2.5], obj: {key: ENUM, flag: true, none: null}) {
+				...spread @skip(if: false)
+				... on Type { inline }
+			}
+		}
+		fragment spread on Type { id }
+	`)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(doc.operations) != 1 || len(doc.fragments) != 1 {
+		t.Fatalf("Unexpected document %v", doc)
+	}
+	op := doc.operations[0]
+	if op.kind != "query" || op.name != "Named" || len(op.variables) != 2 {
+		t.Errorf("Unexpected operation %v", op)
+	}
+	if v := op.variables[0]; v.typeName != "String" || !v.hasDefault || v.defaultValue != "x" {
+		t.Errorf("Unexpected variable %v", v)
+	}
+	if v := op.variables[1]; v.typeName != "Int" || !v.list || !v.required {
+		t.Errorf("Unexpected variable %v", v)
+	}
+	f := op.selections[0].field
+	if f.alias != "alias" || f.name != "field" {
+		t.Errorf("Unexpected field %v", f)
+	}
+	expectedArgs := map[string]interface{}{
+		"arg":  variableRef("a"),
+		"list": []interface{}{1, 2.5},
+		"obj":  map[string]interface{}{"key": enumValue("ENUM"), "flag": true, "none": nil},
+	}
+	if !reflect.DeepEqual(f.arguments, expectedArgs) {
+		t.Errorf("Unexpected arguments %v", f.arguments)
+	}
+	if s := f.selections[0]; s.fragmentSpread != "spread" || len(s.directives) != 1 {
+		t.Errorf("Unexpected fragment spread %v", s)
+	}
+	if s := f.selections[1]; s.inline == nil || s.inline.typeCondition != "Type" {
+		t.Errorf("Unexpected inline fragment %v", s)
+	}
+
+	for _, src := range []string{
+		"",
+		"{}",
+		"fragment on on Type { a }",
+		"unknown { a }",
+		"query ($a: Int = $b) { a }",
+		"{ a } fragment f on T { a } fragment f on T { b }",
+	} {
+		if _, err := parse(src); err == nil {
+			t.Errorf("Expected error parsing %q", src)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package hibp
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultEndpoint is the public Have I Been Pwned range API.
+const DefaultEndpoint = "https://api.pwnedpasswords.com/range/"
+
+// Client checks passwords against the Have I Been Pwned range API. Only
+// the first five characters of the SHA-1 hash of a password are ever sent
+// to the API (k-anonymity), the password itself never leaves the server.
+type Client struct {
+	endpoint string
+	client   *http.Client
+}
+
+// New creates a client for the range API at the given endpoint. Requests
+// will fail after the given timeout.
+func New(endpoint string, timeout time.Duration) *Client {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	return &Client{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+// Pwned checks whether the given password is known from a data breach.
+func (c *Client) Pwned(password string) (bool, error) {
+	hash := strings.ToUpper(fmt.Sprintf("%x", sha1.Sum([]byte(password))))
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequest(http.MethodGet, c.endpoint+prefix, nil)
+	if err != nil {
+		return false, fmt.Errorf("hibp: error creating request: %w", err)
+	}
+	// padding makes sure the response size does not reveal the prefix
+	req.Header.Set("Add-Padding", "true")
+	res, err := c.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("hibp: error performing request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("hibp: unexpected status code %d", res.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		chunks := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		if len(chunks) != 2 || !strings.EqualFold(chunks[0], suffix) {
+			continue
+		}
+		// padding entries are returned with a count of zero
+		count, err := strconv.Atoi(chunks[1])
+		if err != nil {
+			return false, fmt.Errorf("hibp: error parsing count: %w", err)
+		}
+		return count > 0, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("hibp: error reading response: %w", err)
+	}
+	return false, nil
+}
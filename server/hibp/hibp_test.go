@@ -0,0 +1,74 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package hibp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClient_Pwned(t *testing.T) {
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	tests := []struct {
+		name           string
+		status         int
+		response       string
+		expectedResult bool
+		expectError    bool
+	}{
+		{
+			"pwned",
+			http.StatusOK,
+			"003D68EB55068C33ACE09247EE4C639306B:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\r\n",
+			true,
+			false,
+		},
+		{
+			"padding",
+			http.StatusOK,
+			"1E4C9B93F3F0682250B6CF8331B7EE68FD8:0\r\n",
+			false,
+			false,
+		},
+		{
+			"unknown",
+			http.StatusOK,
+			"003D68EB55068C33ACE09247EE4C639306B:3\r\n",
+			false,
+			false,
+		},
+		{
+			"bad status",
+			http.StatusServiceUnavailable,
+			"",
+			false,
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/range/5BAA6" {
+					t.Errorf("Unexpected path %v", r.URL.Path)
+				}
+				if r.Header.Get("Add-Padding") != "true" {
+					t.Error("Expected padding to be requested")
+				}
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.response))
+			}))
+			defer srv.Close()
+
+			result, err := New(srv.URL+"/range", time.Second).Pwned("password")
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if result != test.expectedResult {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
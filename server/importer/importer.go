@@ -0,0 +1,222 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package importer reads historical data exported from other analytics
+// tools, so it can be imported as daily rollups. Exports are expected to
+// contain a row per day. Only the number of events and users can be
+// imported, as all other metrics are derived from event payloads.
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+// dateLayouts are the formats days are accepted in. Google Analytics uses
+// YYYYMMDD in GA4 exports and M/D/YY in Universal Analytics exports.
+var dateLayouts = []string{"2006-01-02", "20060102", "1/2/06", "1/2/2006"}
+
+// byteOrderMark is prepended to exports by some tools.
+var byteOrderMark = []byte("\ufeff")
+
+// Column names, lower cased, days, events and users are read from. The
+// first match of each list is used.
+var (
+	dateColumns   = []string{"date", "day index", "day", "ga:date"}
+	eventsColumns = []string{"nb_actions", "pageviews", "views", "screen page views", "ga:pageviews", "nb_pageviews", "event count"}
+	usersColumns  = []string{"nb_uniq_visitors", "nb_users", "users", "total users", "active users", "ga:users", "nb_visits"}
+)
+
+// Parse reads the days contained in the export of the given source, sorted
+// by date.
+func Parse(source string, r io.Reader) ([]persistence.ImportedDay, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("importer: error reading export: %w", err)
+	}
+	b = bytes.TrimLeft(bytes.TrimPrefix(b, byteOrderMark), " \t\r\n")
+
+	var days []persistence.ImportedDay
+	switch source {
+	case persistence.ImportSourceMatomo:
+		days, err = parseMatomo(b)
+	case persistence.ImportSourceGoogleAnalytics:
+		days, err = parseCSV(bytes.NewReader(b))
+	default:
+		return nil, fmt.Errorf("importer: unknown source %q", source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Date.Before(days[j].Date)
+	})
+	return days, nil
+}
+
+// parseMatomo reads the result of the VisitsSummary.get API method for a
+// range of days, either formatted as JSON or as CSV.
+func parseMatomo(b []byte) ([]persistence.ImportedDay, error) {
+	if bytes.HasPrefix(b, []byte("{")) {
+		return parseMatomoJSON(bytes.NewReader(b))
+	}
+	return parseCSV(bytes.NewReader(b))
+}
+
+func parseMatomoJSON(r io.Reader) ([]persistence.ImportedDay, error) {
+	var export map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("importer: error decoding export: %w", err)
+	}
+	// failed API requests respond with a result and a message instead of
+	// the requested days
+	if _, ok := export["result"]; ok {
+		var message string
+		json.Unmarshal(export["message"], &message)
+		return nil, fmt.Errorf("importer: export contains an error: %s", message)
+	}
+	var days []persistence.ImportedDay
+	for key, raw := range export {
+		date, err := parseDate(key)
+		if err != nil {
+			return nil, err
+		}
+		// days without any visits are exported as an empty array
+		if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+			continue
+		}
+		var values map[string]json.Number
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return nil, fmt.Errorf("importer: error decoding values of %s: %w", key, err)
+		}
+		record := map[string]string{}
+		for name, value := range values {
+			record[name] = value.String()
+		}
+		day, err := newDay(date, record)
+		if err != nil {
+			return nil, fmt.Errorf("importer: error reading values of %s: %w", key, err)
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// parseCSV reads a CSV export containing a header row. Lines starting with
+// # and rows without a date, e.g. totals, are skipped. Reading stops at the
+// first row that has less columns than the header, as exports might contain
+// further tables that do not contain daily values.
+func parseCSV(r io.Reader) ([]persistence.ImportedDay, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var header []string
+	var days []persistence.ImportedDay
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("importer: error reading export: %w", err)
+		}
+		if header == nil {
+			for _, name := range row {
+				header = append(header, strings.ToLower(strings.TrimSpace(name)))
+			}
+			continue
+		}
+		if len(row) < len(header) {
+			break
+		}
+		record := map[string]string{}
+		for i, name := range header {
+			record[name] = strings.TrimSpace(row[i])
+		}
+		value, ok := lookup(record, dateColumns)
+		if !ok {
+			return nil, errors.New("importer: export does not contain a date column")
+		}
+		if value == "" {
+			continue
+		}
+		date, err := parseDate(value)
+		if err != nil {
+			return nil, err
+		}
+		day, err := newDay(date, record)
+		if err != nil {
+			return nil, fmt.Errorf("importer: error reading values of %s: %w", value, err)
+		}
+		days = append(days, day)
+	}
+	if header == nil {
+		return nil, errors.New("importer: export is empty")
+	}
+	return days, nil
+}
+
+func newDay(date time.Time, record map[string]string) (persistence.ImportedDay, error) {
+	day := persistence.ImportedDay{Date: date}
+	events, ok := lookup(record, eventsColumns)
+	if !ok {
+		return day, errors.New("no column containing events found")
+	}
+	users, ok := lookup(record, usersColumns)
+	if !ok {
+		return day, errors.New("no column containing users found")
+	}
+	var err error
+	if day.Events, err = parseCount(events); err != nil {
+		return day, err
+	}
+	if day.Users, err = parseCount(users); err != nil {
+		return day, err
+	}
+	return day, nil
+}
+
+func lookup(record map[string]string, names []string) (string, bool) {
+	for _, name := range names {
+		if value, ok := record[name]; ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("importer: unable to parse date %q", value)
+}
+
+// parseCount parses a count that might use a comma for separating
+// thousands. Empty values are counted as zero.
+func parseCount(value string) (int, error) {
+	value = strings.ReplaceAll(value, ",", "")
+	if value == "" {
+		return 0, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse count %q: %w", value, err)
+	}
+	return count, nil
+}
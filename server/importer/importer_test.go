@@ -0,0 +1,108 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestParse(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2020, 3, d, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name           string
+		source         string
+		export         string
+		expectError    bool
+		expectedResult []persistence.ImportedDay
+	}{
+		{
+			"matomo json",
+			persistence.ImportSourceMatomo,
+			`{"2020-03-02":{"nb_uniq_visitors":4,"nb_visits":5,"nb_actions":12},"2020-03-01":{"nb_visits":3,"nb_actions":"7"},"2020-03-03":[]}`,
+			false,
+			[]persistence.ImportedDay{{Date: day(1), Events: 7, Users: 3}, {Date: day(2), Events: 12, Users: 4}},
+		},
+		{
+			"matomo csv",
+			persistence.ImportSourceMatomo,
+			"\ufeffdate,nb_uniq_visitors,nb_visits,nb_actions\n2020-03-01,4,5,12\n2020-03-02,,,\n",
+			false,
+			[]persistence.ImportedDay{{Date: day(1), Events: 12, Users: 4}, {Date: day(2)}},
+		},
+		{
+			"matomo error",
+			persistence.ImportSourceMatomo,
+			`{"result":"error","message":"You can't access this resource"}`,
+			true,
+			nil,
+		},
+		{
+			"matomo bad date",
+			persistence.ImportSourceMatomo,
+			`{"last week":{"nb_visits":3,"nb_actions":7}}`,
+			true,
+			nil,
+		},
+		{
+			"universal analytics",
+			persistence.ImportSourceGoogleAnalytics,
+			"# ----------------------------------------\n# All Web Site Data\n# Audience Overview\n# ----------------------------------------\n\nDay Index,Users,Pageviews\n3/1/20,\"1,204\",\"3,001\"\n3/2/20,98,310\n,\"1,302\",\"3,311\"\n\nDay Index,Sessions\n3/1/20,1500\n",
+			false,
+			[]persistence.ImportedDay{{Date: day(1), Events: 3001, Users: 1204}, {Date: day(2), Events: 310, Users: 98}},
+		},
+		{
+			"ga4",
+			persistence.ImportSourceGoogleAnalytics,
+			"# Start date: 20200301\n# End date: 20200302\nDate,Total users,Views\n20200302,4,9\n20200301,2,3\n",
+			false,
+			[]persistence.ImportedDay{{Date: day(1), Events: 3, Users: 2}, {Date: day(2), Events: 9, Users: 4}},
+		},
+		{
+			"missing column",
+			persistence.ImportSourceGoogleAnalytics,
+			"Date,Sessions\n20200301,4\n",
+			true,
+			nil,
+		},
+		{
+			"bad count",
+			persistence.ImportSourceGoogleAnalytics,
+			"Date,Users,Pageviews\n20200301,many,4\n",
+			true,
+			nil,
+		},
+		{
+			"empty",
+			persistence.ImportSourceGoogleAnalytics,
+			"",
+			true,
+			nil,
+		},
+		{
+			"unknown source",
+			"other-tool",
+			"Date,Users,Pageviews\n20200301,1,4\n",
+			true,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := Parse(test.source, strings.NewReader(test.export))
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if !reflect.DeepEqual(test.expectedResult, result) {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+// commonPasswords is a list of frequently used passwords and words, ordered
+// by how common they are. It is used for estimating password strength.
+var commonPasswords = []string{
+	"password", "123456", "123456789", "12345678", "12345", "qwerty", "1234567",
+	"111111", "1234567890", "123123", "abc123", "1234", "password1", "iloveyou",
+	"1q2w3e4r", "000000", "qwerty123", "zaq12wsx", "dragon", "sunshine",
+	"princess", "letmein", "654321", "monkey", "27653", "1qaz2wsx", "123321",
+	"qwertyuiop", "superman", "asdfghjkl", "football", "baseball", "welcome",
+	"admin", "login", "master", "shadow", "michael", "jennifer", "hunter",
+	"ranger", "buster", "soccer", "harley", "batman", "andrew", "tigger",
+	"charlie", "robert", "thomas", "hockey", "killer", "george", "jordan",
+	"trustno1", "daniel", "hannah", "maggie", "starwars", "silver", "william",
+	"dallas", "yankees", "orange", "ginger", "pepper", "summer", "winter",
+	"spring", "autumn", "flower", "freedom", "whatever", "nicole", "jessica",
+	"cheese", "computer", "internet", "secret", "hello", "love", "lovely",
+	"passw0rd", "changeme", "default", "guest", "access", "matrix", "mustang",
+	"cookie", "chocolate", "banana", "chelsea", "liverpool", "arsenal",
+	"barcelona", "google", "facebook", "twitter", "samsung", "apple", "office",
+	"london", "berlin", "paris", "america", "germany", "france", "family",
+	"friends", "forever", "angel", "blessed", "baby", "beautiful", "happy",
+	"smile", "music", "money", "house", "party", "phoenix", "diamond", "golden",
+	"purple", "yellow", "coffee", "pizza", "school", "student", "teacher",
+	"doctor", "test", "testing", "example", "user", "username", "root",
+	"system", "server", "network", "database", "qwertz", "azerty", "abcdef",
+	"abcdefg", "letmein1", "welcome1", "admin123", "password123", "iloveyou1",
+	"princess1", "monkey1", "dragon1", "sunshine1", "offen", "analytics",
+	"privacy",
+}
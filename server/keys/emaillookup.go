@@ -0,0 +1,76 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// EmailLookup creates keyed hashes of email addresses that can be compared
+// cheaply when looking up account users. Each hash is created using a key
+// that is derived from a secret and a key id. Storing the key id alongside
+// the hash allows moving to a new key incrementally by re-hashing the email
+// address of an account user whenever it is known, i.e. on login. Secrets
+// that have been rotated out can be passed as previous secrets, so hashes
+// created using them keep matching until they have been re-hashed.
+type EmailLookup struct {
+	secret   []byte
+	previous [][]byte
+	keyID    string
+}
+
+// NewEmailLookup creates a new EmailLookup that uses the key with the given
+// id for creating new hashes.
+func NewEmailLookup(secret []byte, keyID string, previous ...[]byte) *EmailLookup {
+	return &EmailLookup{secret: secret, previous: previous, keyID: keyID}
+}
+
+// KeyID returns the id of the key that is used for creating new hashes.
+func (e *EmailLookup) KeyID() string {
+	return e.keyID
+}
+
+// Hash hashes the given email address using the current key.
+func (e *EmailLookup) Hash(email string) string {
+	return e.HashWith(e.keyID, email)
+}
+
+// HashWith hashes the given email address using the key with the given id.
+func (e *EmailLookup) HashWith(keyID, email string) string {
+	return lookupHash(e.secret, keyID, email)
+}
+
+func lookupHash(secret []byte, keyID, email string) string {
+	key := make([]byte, DefaultEncryptionKeySize)
+	io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte("offen-email-lookup-"+keyID)), key)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(email))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Matches checks whether the given hash that has been created using the key
+// with the given id matches the email address. Hashes created using the
+// current or any of the previous secrets match.
+func (e *EmailLookup) Matches(email, keyID, hash string) bool {
+	match := hmac.Equal([]byte(e.HashWith(keyID, email)), []byte(hash))
+	// all secrets are checked so the time spent does not depend on which
+	// secret has been used for creating the hash
+	for _, secret := range e.previous {
+		if hmac.Equal([]byte(lookupHash(secret, keyID, email)), []byte(hash)) {
+			match = true
+		}
+	}
+	return match
+}
+
+// Current checks whether the given hash has been created using the current
+// key and secret. Hashes that are not current should be replaced.
+func (e *EmailLookup) Current(email, keyID, hash string) bool {
+	return keyID == e.keyID && hmac.Equal([]byte(e.Hash(email)), []byte(hash))
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import "testing"
+
+func TestEmailLookup(t *testing.T) {
+	previous := NewEmailLookup([]byte("secret"), "1")
+	current := NewEmailLookup([]byte("secret"), "2")
+
+	hash := previous.Hash("develop@offen.dev")
+	if hash == current.Hash("develop@offen.dev") {
+		t.Error("Expected hashes using different keys to differ")
+	}
+	if !current.Matches("develop@offen.dev", previous.KeyID(), hash) {
+		t.Error("Expected hash created using previous key to match")
+	}
+	if current.Matches("other@offen.dev", previous.KeyID(), hash) {
+		t.Error("Unexpected match for other email address")
+	}
+	if current.Matches("develop@offen.dev", current.KeyID(), hash) {
+		t.Error("Unexpected match using wrong key id")
+	}
+	if NewEmailLookup([]byte("other"), "1").Matches("develop@offen.dev", "1", hash) {
+		t.Error("Unexpected match using other secret")
+	}
+}
+
+func TestEmailLookup_PreviousSecrets(t *testing.T) {
+	previous := NewEmailLookup([]byte("old-secret"), "1")
+	hash := previous.Hash("develop@offen.dev")
+
+	rotated := NewEmailLookup([]byte("new-secret"), "1", []byte("old-secret"))
+	if !rotated.Matches("develop@offen.dev", "1", hash) {
+		t.Error("Expected hash created using previous secret to match")
+	}
+	if rotated.Current("develop@offen.dev", "1", hash) {
+		t.Error("Expected hash created using previous secret not to be current")
+	}
+	if !rotated.Current("develop@offen.dev", "1", rotated.Hash("develop@offen.dev")) {
+		t.Error("Expected hash created using current secret to be current")
+	}
+	if rotated.Current("develop@offen.dev", "2", rotated.HashWith("2", "develop@offen.dev")) {
+		t.Error("Expected hash created using other key id not to be current")
+	}
+	if NewEmailLookup([]byte("new-secret"), "1").Matches("develop@offen.dev", "1", hash) {
+		t.Error("Unexpected match without previous secret")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// EscrowKey is an RSA public key that is used for additionally wrapping
+// the key encryption keys of accounts, so that the owner of the matching
+// private key is able to recover accounts whose users have lost all of
+// their credentials.
+type EscrowKey struct {
+	key jwk.Key
+	id  string
+}
+
+// ParseEscrowKey parses the given RSA public key in JWK format.
+func ParseEscrowKey(b []byte) (*EscrowKey, error) {
+	set, err := jwk.ParseBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("keys: error parsing escrow key: %w", err)
+	}
+	if len(set.Keys) != 1 {
+		return nil, fmt.Errorf("keys: expected a single escrow key, got %d", len(set.Keys))
+	}
+	key := set.Keys[0]
+	if _, ok := key.(*jwk.RSAPublicKey); !ok {
+		return nil, errors.New("keys: escrow key is expected to be a RSA public key")
+	}
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("keys: error computing thumbprint of escrow key: %w", err)
+	}
+	return &EscrowKey{
+		key: key,
+		id:  base64.RawURLEncoding.EncodeToString(thumbprint),
+	}, nil
+}
+
+// ID returns the JWK thumbprint of the key. It is stored alongside wrapped
+// values, so it is possible to tell which key can unwrap them.
+func (e *EscrowKey) ID() string {
+	return e.id
+}
+
+// Wrap encrypts the given key encryption key using the escrow key.
+func (e *EscrowKey) Wrap(keyEncryptionKey []byte) (string, error) {
+	cipher, err := EncryptAsymmetricWith(e.key, keyEncryptionKey)
+	if err != nil {
+		return "", fmt.Errorf("keys: error wrapping key using escrow key: %w", err)
+	}
+	return cipher.Marshal(), nil
+}
+
+// UnwrapEscrowed decrypts a value that has been wrapped using an escrow key
+// with the given RSA private key in JWK format.
+func UnwrapEscrowed(privateKey []byte, wrapped string) ([]byte, error) {
+	set, err := jwk.ParseBytes(privateKey)
+	if err != nil || len(set.Keys) != 1 {
+		return nil, fmt.Errorf("keys: error parsing escrow private key: %v", err)
+	}
+	m, err := set.Keys[0].Materialize()
+	if err != nil {
+		return nil, fmt.Errorf("keys: error materializing escrow private key: %w", err)
+	}
+	key, ok := m.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("keys: escrow private key is expected to be a RSA private key")
+	}
+	cipher, err := unmarshalVersionedCipher(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("keys: error parsing wrapped value: %w", err)
+	}
+	result, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, cipher.cipher, nil)
+	if err != nil {
+		return nil, fmt.Errorf("keys: error unwrapping value: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,41 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEscrowKey(t *testing.T) {
+	public, private, _ := GenerateRSAKeypair(2048)
+	escrowKey, err := ParseEscrowKey(public)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if escrowKey.ID() == "" {
+		t.Error("Expected key id to be populated")
+	}
+
+	value := []byte("key-encryption-key")
+	wrapped, err := escrowKey.Wrap(value)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	unwrapped, err := UnwrapEscrowed(private, wrapped)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(value, unwrapped) {
+		t.Errorf("Expected %v, got %v", value, unwrapped)
+	}
+
+	_, otherPrivate, _ := GenerateRSAKeypair(2048)
+	if _, err := UnwrapEscrowed(otherPrivate, wrapped); err == nil {
+		t.Error("Expected error unwrapping with other key")
+	}
+	if _, err := ParseEscrowKey(private); err == nil {
+		t.Error("Expected error parsing private key as escrow key")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// PeerWrappedValue is a value that has been encrypted for the holder of an
+// X25519 private key. The key used for encryption is derived from the shared
+// secret of the peer's key and an ephemeral key pair whose public key is
+// sent alongside the ciphertext.
+type PeerWrappedValue struct {
+	EphemeralPublicKey string `json:"ephemeralPublicKey"`
+	Cipher             string `json:"cipher"`
+}
+
+// GenerateExchangeKeyPair creates a new X25519 key pair.
+func GenerateExchangeKeyPair() (publicKey, privateKey []byte, err error) {
+	if FIPSMode() {
+		return nil, nil, errors.New("keys: X25519 is not approved for use in FIPS mode")
+	}
+	privateKey, err = GenerateRandomBytes(curve25519.ScalarSize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("keys: error creating private key: %w", err)
+	}
+	publicKey, err = curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, fmt.Errorf("keys: error computing public key: %w", err)
+	}
+	return publicKey, privateKey, nil
+}
+
+// WrapForPeer encrypts the given value for the holder of the private key
+// matching the given X25519 public key. A new ephemeral key pair is used
+// for each call.
+func WrapForPeer(peerPublicKey, value []byte) (*PeerWrappedValue, error) {
+	if len(peerPublicKey) != curve25519.PointSize {
+		return nil, fmt.Errorf("keys: expected peer public key of size %d, got %d", curve25519.PointSize, len(peerPublicKey))
+	}
+	ephemeralPublic, ephemeralPrivate, err := GenerateExchangeKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("keys: error creating ephemeral key pair: %w", err)
+	}
+	key, err := deriveExchangeKey(ephemeralPrivate, peerPublicKey, ephemeralPublic, peerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := EncryptWith(key, value)
+	if err != nil {
+		return nil, fmt.Errorf("keys: error encrypting value for peer: %w", err)
+	}
+	return &PeerWrappedValue{
+		EphemeralPublicKey: base64.StdEncoding.EncodeToString(ephemeralPublic),
+		Cipher:             cipher.Marshal(),
+	}, nil
+}
+
+// UnwrapFromPeer decrypts a value that has been created using WrapForPeer
+// with the given X25519 private key.
+func UnwrapFromPeer(privateKey []byte, wrapped *PeerWrappedValue) ([]byte, error) {
+	ephemeralPublic, err := base64.StdEncoding.DecodeString(wrapped.EphemeralPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("keys: error decoding ephemeral public key: %w", err)
+	}
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, fmt.Errorf("keys: error computing public key: %w", err)
+	}
+	key, err := deriveExchangeKey(privateKey, ephemeralPublic, ephemeralPublic, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	value, err := DecryptWith(key, wrapped.Cipher)
+	if err != nil {
+		return nil, fmt.Errorf("keys: error decrypting value from peer: %w", err)
+	}
+	return value, nil
+}
+
+// deriveExchangeKey computes the X25519 shared secret and derives a
+// symmetric key from it. Both public keys are bound to the derived key.
+func deriveExchangeKey(privateKey, otherPublicKey, ephemeralPublic, recipientPublic []byte) ([]byte, error) {
+	if FIPSMode() {
+		return nil, errors.New("keys: X25519 is not approved for use in FIPS mode")
+	}
+	shared, err := curve25519.X25519(privateKey, otherPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("keys: error computing shared secret: %w", err)
+	}
+	salt := append(append([]byte{}, ephemeralPublic...), recipientPublic...)
+	key := make([]byte, DefaultEncryptionKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("offen-key-exchange")), key); err != nil {
+		return nil, fmt.Errorf("keys: error deriving key from shared secret: %w", err)
+	}
+	return key, nil
+}
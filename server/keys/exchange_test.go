@@ -0,0 +1,49 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"testing"
+)
+
+func TestWrapForPeer(t *testing.T) {
+	publicKey, privateKey, err := GenerateExchangeKeyPair()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	wrapped, err := WrapForPeer(publicKey, []byte("key"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	value, err := UnwrapFromPeer(privateKey, wrapped)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if string(value) != "key" {
+		t.Errorf("Unexpected value %v", string(value))
+	}
+
+	t.Run("other key", func(t *testing.T) {
+		_, otherPrivateKey, _ := GenerateExchangeKeyPair()
+		if _, err := UnwrapFromPeer(otherPrivateKey, wrapped); err == nil {
+			t.Error("Expected error unwrapping using other key")
+		}
+	})
+	t.Run("bad public key", func(t *testing.T) {
+		if _, err := WrapForPeer([]byte("short"), []byte("key")); err == nil {
+			t.Error("Expected error wrapping for malformed key")
+		}
+		if _, err := WrapForPeer(make([]byte, 32), []byte("key")); err == nil {
+			t.Error("Expected error wrapping for low order point")
+		}
+	})
+	t.Run("fips mode", func(t *testing.T) {
+		defer func(previous bool) { fipsMode = previous }(fipsMode)
+		fipsMode = true
+		if _, err := WrapForPeer(publicKey, []byte("key")); err == nil {
+			t.Error("Expected error in FIPS mode")
+		}
+	})
+}
@@ -0,0 +1,25 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+// fipsMode restricts the package to primitives approved by FIPS 140-2. This
+// means PBKDF2-HMAC-SHA256 is used for hashing and deriving keys from
+// credentials instead of argon2, and values that have been created using
+// argon2 as well as Ed25519 signatures are rejected. AES-GCM, RSA-OAEP,
+// HMAC-SHA256, HKDF and ECDSA on P-256 are allowed in both modes.
+var fipsMode = fipsBuild
+
+// EnableFIPSMode restricts the package to FIPS approved primitives. Binaries
+// built using the `fips` build tag have this enabled by default. It is
+// supposed to be called once on startup before any other function of
+// this package is used.
+func EnableFIPSMode() {
+	fipsMode = true
+}
+
+// FIPSMode reports whether the package is restricted to FIPS approved
+// primitives.
+func FIPSMode() bool {
+	return fipsMode
+}
@@ -0,0 +1,8 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// +build fips
+
+package keys
+
+const fipsBuild = true
@@ -0,0 +1,79 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFIPSMode(t *testing.T) {
+	params := KDFParams{Time: 1, Memory: 64, Threads: 1, Iterations: minPBKDF2Iterations}
+
+	legacyHash, _ := HashStringWith("secret", params)
+	legacySalt, _ := NewSaltWith(DefaultSaltLength, params)
+
+	defer func(previous bool) { fipsMode = previous }(fipsMode)
+	fipsMode = true
+
+	t.Run("hash and compare", func(t *testing.T) {
+		hash, err := HashStringWith("secret", params)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if !strings.HasPrefix(hash.Marshal(), "{4,,i=1000}") {
+			t.Errorf("Unexpected hash %v", hash.Marshal())
+		}
+		if err := CompareString("secret", hash.Marshal()); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if err := CompareString("other", hash.Marshal()); err == nil {
+			t.Error("Expected error when comparing bad value")
+		}
+		if params.Outdated(hash.Marshal()) {
+			t.Error("Expected hash to be current")
+		}
+	})
+	t.Run("derive key", func(t *testing.T) {
+		salt, err := NewSaltWith(DefaultSaltLength, params)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		key, err := DeriveKey("secret", salt.Marshal())
+		if err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if len(key) != DefaultEncryptionKeySize {
+			t.Errorf("Unexpected key length %d", len(key))
+		}
+	})
+	t.Run("legacy values", func(t *testing.T) {
+		if err := CompareString("secret", legacyHash.Marshal()); err == nil {
+			t.Error("Expected error when comparing argon2 hash")
+		}
+		if _, err := DeriveKey("secret", legacySalt.Marshal()); err == nil {
+			t.Error("Expected error when deriving from argon2 salt")
+		}
+		if !params.Outdated(legacyHash.Marshal()) {
+			t.Error("Expected argon2 hash to be outdated")
+		}
+	})
+	t.Run("invalid iterations", func(t *testing.T) {
+		if err := (KDFParams{Time: 1, Memory: 64, Threads: 1, Iterations: 10}).Validate(); err == nil {
+			t.Error("Expected error validating low iteration count")
+		}
+	})
+	t.Run("signing algorithms", func(t *testing.T) {
+		secret := []byte("secret")
+		edKeyring, _ := NewSigningKeyring(secret, time.Hour, time.Minute, WithSigningAlgorithm(SigningAlgorithmEdDSA))
+		if _, err := edKeyring.SignToken("user", time.Minute); err == nil {
+			t.Error("Expected error signing using EdDSA")
+		}
+		ecKeyring, _ := NewSigningKeyring(secret, time.Hour, time.Minute)
+		if _, err := ecKeyring.SignToken("user", time.Minute); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+	})
+}
@@ -5,11 +5,14 @@ package keys
 
 import (
 	"bytes"
+	"crypto/hmac"
 	"crypto/sha256"
 	"errors"
 	"fmt"
 	"runtime"
+	"time"
 
+	"github.com/offen/offen/server/metrics"
 	"golang.org/x/crypto/argon2"
 )
 
@@ -19,8 +22,23 @@ const (
 	// that is slower, but consumes less memory.
 	passwordAlgoArgon2HighMemoryConsumptionDEPRECATED = 1
 	passwordAlgoArgon2                                = 2
+	// Values using this version carry their argon2 parameters in-band so
+	// these can be changed without invalidating existing credentials.
+	passwordAlgoArgon2Parameterized = 3
+	// Values using this version have been created using PBKDF2-HMAC-SHA256
+	// in FIPS mode and carry their iteration count in-band.
+	passwordAlgoPBKDF2 = 4
 )
 
+// checkApproved returns an error in case the given algo version must not be
+// used in FIPS mode.
+func checkApproved(algoVersion int) error {
+	if FIPSMode() && algoVersion != passwordAlgoPBKDF2 {
+		return fmt.Errorf("keys: algo version %d is not approved for use in FIPS mode", algoVersion)
+	}
+	return nil
+}
+
 // DeriveKey wraps package argon2 in order to derive a symmetric key from the
 // given value (most likely a password) and the given salt.
 func DeriveKey(value, versionedSalt string) ([]byte, error) {
@@ -28,7 +46,16 @@ func DeriveKey(value, versionedSalt string) ([]byte, error) {
 	if saltErr != nil {
 		return nil, fmt.Errorf("keys: error decoding salt into bytes: %w", saltErr)
 	}
+	if err := checkApproved(salt.algoVersion); err != nil {
+		return nil, err
+	}
 	switch salt.algoVersion {
+	case passwordAlgoArgon2Parameterized, passwordAlgoPBKDF2:
+		params, err := parseKDFParamsFor(salt.algoVersion, salt.params)
+		if err != nil {
+			return nil, fmt.Errorf("keys: error reading kdf parameters from salt: %w", err)
+		}
+		return params.derive(salt.algoVersion, []byte(value), salt.cipher, DefaultEncryptionKeySize), nil
 	case passwordAlgoArgon2:
 		key := defaultArgon2Hash([]byte(value), salt.cipher, DefaultEncryptionKeySize)
 		return key, nil
@@ -41,26 +68,99 @@ func DeriveKey(value, versionedSalt string) ([]byte, error) {
 }
 
 // NewSalt creates a new salt value of the default length and wraps it in a
-// versioned cipher using the latest available algo version
+// versioned cipher using the latest available algo version and the default
+// kdf parameters
 func NewSalt(len int) (*VersionedCipher, error) {
+	return NewSaltWith(len, DefaultKDFParams)
+}
+
+// NewSaltWith creates a new salt value of the given length and wraps it in a
+// versioned cipher using the latest available algo version and the given
+// kdf parameters
+func NewSaltWith(len int, params KDFParams) (*VersionedCipher, error) {
+	params = params.OrDefault()
+	if err := params.Validate(); err != nil {
+		return nil, fmt.Errorf("keys: invalid kdf parameters: %w", err)
+	}
 	b, err := GenerateRandomBytes(len)
 	if err != nil {
 		return nil, fmt.Errorf("keys: error generating random salt: %w", err)
 	}
-	return newVersionedCipher(b, passwordAlgoArgon2), nil
+	algoVersion := kdfAlgoVersion()
+	return newVersionedCipher(b, algoVersion).addParams(params.encode(algoVersion)), nil
 }
 
 // HashString hashes the given string using argon2 using the latest configuration
 func HashString(s string) (*VersionedCipher, error) {
+	return HashStringWith(s, DefaultKDFParams)
+}
+
+// HashStringWith hashes the given string using argon2 and the given parameters
+func HashStringWith(s string, params KDFParams) (*VersionedCipher, error) {
 	if s == "" {
 		return nil, errors.New("keys: cannot hash an empty string")
 	}
+	params = params.OrDefault()
+	if err := params.Validate(); err != nil {
+		return nil, fmt.Errorf("keys: invalid kdf parameters: %w", err)
+	}
 	salt, saltErr := GenerateRandomBytes(DefaultSecretLength)
 	if saltErr != nil {
 		return nil, fmt.Errorf("keys: error generating random salt for password hash: %w", saltErr)
 	}
-	hash := defaultArgon2Hash([]byte(s), salt, DefaultPasswordHashSize)
-	return newVersionedCipher(hash, passwordAlgoArgon2).addNonce(salt), nil
+	algoVersion := kdfAlgoVersion()
+	hash := params.derive(algoVersion, []byte(s), salt, DefaultPasswordHashSize)
+	return newVersionedCipher(hash, algoVersion).addNonce(salt).addParams(params.encode(algoVersion)), nil
+}
+
+// pepperKeyVersion is stored as the key version of password hashes that have
+// been created using a pepper.
+const pepperKeyVersion = 1
+
+func applyPepper(password string, pepper []byte) string {
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte(password))
+	return string(mac.Sum(nil))
+}
+
+// HashPassword hashes the given password using argon2 and the given
+// parameters. In case a pepper is given, it is mixed into the password
+// using HMAC-SHA256 before hashing, so that a database dump alone is not
+// sufficient for brute forcing the hash.
+func HashPassword(password string, params KDFParams, pepper []byte) (*VersionedCipher, error) {
+	if len(pepper) == 0 {
+		return HashStringWith(password, params)
+	}
+	if password == "" {
+		return nil, errors.New("keys: cannot hash an empty password")
+	}
+	cipher, err := HashStringWith(applyPepper(password, pepper), params)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.addKeyVersion(pepperKeyVersion), nil
+}
+
+// ComparePassword compares a password with a hash that has been created
+// using HashPassword. Hashes created without a pepper can still be compared
+// when a pepper is given.
+func ComparePassword(password, versionedCipher string, pepper []byte) error {
+	if !Peppered(versionedCipher) {
+		return CompareString(password, versionedCipher)
+	}
+	if len(pepper) == 0 {
+		return errors.New("keys: hash has been created using a pepper, but none was given")
+	}
+	return CompareString(applyPepper(password, pepper), versionedCipher)
+}
+
+// Peppered checks whether the given hash has been created using a pepper.
+func Peppered(versionedCipher string) bool {
+	cipher, err := unmarshalVersionedCipher(versionedCipher)
+	if err != nil {
+		return false
+	}
+	return cipher.keyVersion == pepperKeyVersion
 }
 
 // CompareString compares a string with a stored hash
@@ -72,7 +172,20 @@ func CompareString(s, versionedCipher string) error {
 	if err != nil {
 		return fmt.Errorf("keys: error parsing versioned cipher: %w", err)
 	}
+	if err := checkApproved(cipher.algoVersion); err != nil {
+		return err
+	}
 	switch cipher.algoVersion {
+	case passwordAlgoArgon2Parameterized, passwordAlgoPBKDF2:
+		params, err := parseKDFParamsFor(cipher.algoVersion, cipher.params)
+		if err != nil {
+			return fmt.Errorf("keys: error reading kdf parameters from hash: %w", err)
+		}
+		hashedInput := params.derive(cipher.algoVersion, []byte(s), cipher.nonce, DefaultPasswordHashSize)
+		if bytes.Compare(hashedInput, cipher.cipher) != 0 {
+			return errors.New("keys: could not match passwords")
+		}
+		return nil
 	case passwordAlgoArgon2:
 		hashedInput := defaultArgon2Hash([]byte(s), cipher.nonce, DefaultPasswordHashSize)
 		if bytes.Compare(hashedInput, cipher.cipher) != 0 {
@@ -91,10 +204,12 @@ func CompareString(s, versionedCipher string) error {
 }
 
 func defaultArgon2Hash(val, salt []byte, size uint32) []byte {
+	defer metrics.KDFDuration.ObserveSince(time.Now(), "argon2id")
 	return argon2.IDKey(val, salt, 4, 16*1024, uint8(runtime.NumCPU()), size)
 }
 
 func highMemoryArgon2HashDEPRECATED(val, salt []byte, size uint32) []byte {
+	defer metrics.KDFDuration.ObserveSince(time.Now(), "argon2id")
 	return argon2.IDKey(val, salt, 1, 64*1024, 4, size)
 }
 
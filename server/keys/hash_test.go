@@ -17,3 +17,47 @@ func TestHashString(t *testing.T) {
 		t.Errorf("Comparison unexpectedly passed for wrong password")
 	}
 }
+
+func TestHashPassword(t *testing.T) {
+	params := KDFParams{Time: 1, Memory: 64, Threads: 1}
+	pepper := []byte("pepper")
+
+	t.Run("with pepper", func(t *testing.T) {
+		hash, err := HashPassword("s3cr3t", params, pepper)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if !Peppered(hash.Marshal()) {
+			t.Error("Expected hash to be peppered")
+		}
+		if err := ComparePassword("s3cr3t", hash.Marshal(), pepper); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if err := ComparePassword("s3cr3t", hash.Marshal(), []byte("other")); err == nil {
+			t.Error("Comparison unexpectedly passed for wrong pepper")
+		}
+		if err := ComparePassword("s3cr3t", hash.Marshal(), nil); err == nil {
+			t.Error("Comparison unexpectedly passed for missing pepper")
+		}
+		if err := CompareString("s3cr3t", hash.Marshal()); err == nil {
+			t.Error("Comparison unexpectedly passed without applying pepper")
+		}
+	})
+	t.Run("without pepper", func(t *testing.T) {
+		hash, err := HashPassword("s3cr3t", params, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if Peppered(hash.Marshal()) {
+			t.Error("Expected hash not to be peppered")
+		}
+		if err := ComparePassword("s3cr3t", hash.Marshal(), pepper); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+	})
+	t.Run("empty password", func(t *testing.T) {
+		if _, err := HashPassword("", params, pepper); err == nil {
+			t.Error("Expected error")
+		}
+	})
+}
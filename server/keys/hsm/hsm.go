@@ -0,0 +1,103 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package hsm provides a token signer that keeps its private key in a
+// hardware security module that is accessed using PKCS#11.
+package hsm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/offen/offen/server/keys"
+)
+
+// Config describes how to find the signing key in the HSM.
+type Config struct {
+	// Module is the path to the PKCS#11 library of the HSM vendor.
+	Module     string
+	TokenLabel string
+	Pin        string
+	// KeyLabel identifies the P-256 key pair used for signing. It is also
+	// used as the key id of issued tokens.
+	KeyLabel string
+}
+
+// New opens a session with the configured token and returns a signer that
+// uses the key pair with the given label.
+func New(c Config) (keys.Signer, error) {
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       c.Module,
+		TokenLabel: c.TokenLabel,
+		Pin:        c.Pin,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("hsm: error configuring PKCS#11 module: %w", err)
+	}
+	key, err := ctx.FindKeyPair(nil, []byte(c.KeyLabel))
+	if err != nil {
+		return nil, fmt.Errorf("hsm: error looking up key pair %s: %w", c.KeyLabel, err)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("hsm: no key pair with label %s found", c.KeyLabel)
+	}
+	return NewSigner(c.KeyLabel, key)
+}
+
+// NewSigner wraps the given crypto.Signer so that it can be used for signing
+// tokens. Only P-256 keys are supported.
+func NewSigner(keyID string, s crypto.Signer) (keys.Signer, error) {
+	public, ok := s.Public().(*ecdsa.PublicKey)
+	if !ok || public.Curve != elliptic.P256() {
+		return nil, errors.New("hsm: signing key is expected to be a P-256 key")
+	}
+	return &signer{keyID: keyID, signer: s, public: public}, nil
+}
+
+type signer struct {
+	keyID  string
+	signer crypto.Signer
+	public *ecdsa.PublicKey
+}
+
+func (s *signer) KeyID() string {
+	return s.keyID
+}
+
+func (s *signer) Algorithm() string {
+	return keys.SigningAlgorithmES256
+}
+
+func (s *signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign signs the given payload in the HSM. The ASN.1 encoded signature
+// returned by the module is converted into the R || S format used by JWS.
+func (s *signer) Sign(payload []byte) ([]byte, error) {
+	digest := sha256.Sum256(payload)
+	der, err := s.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("hsm: error signing payload: %w", err)
+	}
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("hsm: error parsing signature: %w", err)
+	}
+	size := (s.public.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	rBytes, sBytes := sig.R.Bytes(), sig.S.Bytes()
+	copy(signature[size-len(rBytes):size], rBytes)
+	copy(signature[2*size-len(sBytes):], sBytes)
+	return signature, nil
+}
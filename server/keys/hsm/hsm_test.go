@@ -0,0 +1,45 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package hsm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/keys"
+)
+
+func TestNewSigner(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		signer, err := NewSigner("hsm-key", key)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		keyring, err := keys.NewSigningKeyring(nil, time.Hour, time.Minute, keys.WithSigner(signer))
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		token, err := keyring.SignToken("user-a", time.Minute)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		subject, err := keyring.VerifyToken(token)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if subject != "user-a" {
+			t.Errorf("Unexpected subject %v", subject)
+		}
+	})
+	t.Run("bad curve", func(t *testing.T) {
+		key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		if _, err := NewSigner("hsm-key", key); err == nil {
+			t.Error("Expected error")
+		}
+	})
+}
@@ -0,0 +1,202 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/offen/offen/server/metrics"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// KDFParams configures the work factors used when deriving keys from or
+// hashing passwords and email addresses. The parameters are stored in-band
+// with each value they have been used for, so operators can raise them over
+// time without invalidating existing credentials.
+type KDFParams struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	// Iterations is only used for PBKDF2, which replaces argon2 when
+	// running in FIPS mode.
+	Iterations uint32
+}
+
+// DefaultKDFParams is used whenever zero value parameters are given.
+var DefaultKDFParams = KDFParams{
+	Time:       4,
+	Memory:     16 * 1024,
+	Threads:    4,
+	Iterations: 600000,
+}
+
+// minPBKDF2Iterations is the lower bound for PBKDF2 iterations as
+// recommended by NIST SP 800-132.
+const minPBKDF2Iterations = 1000
+
+var (
+	parseKDFParamsRE    = regexp.MustCompile(`^m=(\d+);t=(\d+);p=(\d+)$`)
+	parsePBKDF2ParamsRE = regexp.MustCompile(`^i=(\d+)$`)
+)
+
+// IsZero checks whether no parameters have been set on p.
+func (p KDFParams) IsZero() bool {
+	return p == KDFParams{}
+}
+
+// OrDefault returns DefaultKDFParams in case p is the zero value. In case
+// no PBKDF2 iterations are given, the default is used.
+func (p KDFParams) OrDefault() KDFParams {
+	if p.IsZero() {
+		return DefaultKDFParams
+	}
+	if p.Iterations == 0 {
+		p.Iterations = DefaultKDFParams.Iterations
+	}
+	return p
+}
+
+// Validate checks whether the parameters can be used with argon2. In FIPS
+// mode, the PBKDF2 iterations are validated too.
+func (p KDFParams) Validate() error {
+	if err := p.validateArgon2(); err != nil {
+		return err
+	}
+	if FIPSMode() {
+		return p.validatePBKDF2()
+	}
+	return nil
+}
+
+func (p KDFParams) validatePBKDF2() error {
+	if p.Iterations < minPBKDF2Iterations {
+		return fmt.Errorf("keys: kdf iterations parameter must be at least %d, got %d", minPBKDF2Iterations, p.Iterations)
+	}
+	return nil
+}
+
+func (p KDFParams) validateArgon2() error {
+	if p.Time < 1 {
+		return fmt.Errorf("keys: kdf time parameter must be at least 1, got %d", p.Time)
+	}
+	if p.Threads < 1 {
+		return fmt.Errorf("keys: kdf threads parameter must be at least 1, got %d", p.Threads)
+	}
+	if p.Memory < 8*uint32(p.Threads) {
+		return fmt.Errorf("keys: kdf memory parameter must be at least %d, got %d", 8*uint32(p.Threads), p.Memory)
+	}
+	return nil
+}
+
+func (p KDFParams) String() string {
+	return fmt.Sprintf("m=%d;t=%d;p=%d", p.Memory, p.Time, p.Threads)
+}
+
+func (p KDFParams) hash(val, salt []byte, size uint32) []byte {
+	defer metrics.KDFDuration.ObserveSince(time.Now(), "argon2id")
+	return argon2.IDKey(val, salt, p.Time, p.Memory, p.Threads, size)
+}
+
+func (p KDFParams) pbkdf2Hash(val, salt []byte, size uint32) []byte {
+	defer metrics.KDFDuration.ObserveSince(time.Now(), "pbkdf2")
+	return pbkdf2.Key(val, salt, int(p.Iterations), int(size), sha256.New)
+}
+
+// kdfAlgoVersion returns the algo version new salts and hashes are
+// created with.
+func kdfAlgoVersion() int {
+	if FIPSMode() {
+		return passwordAlgoPBKDF2
+	}
+	return passwordAlgoArgon2Parameterized
+}
+
+// encode returns the in-band representation of p for the given algo version.
+func (p KDFParams) encode(algoVersion int) string {
+	if algoVersion == passwordAlgoPBKDF2 {
+		return fmt.Sprintf("i=%d", p.Iterations)
+	}
+	return p.String()
+}
+
+// derive hashes the given value using the algorithm identified by the given
+// algo version.
+func (p KDFParams) derive(algoVersion int, val, salt []byte, size uint32) []byte {
+	if algoVersion == passwordAlgoPBKDF2 {
+		return p.pbkdf2Hash(val, salt, size)
+	}
+	return p.hash(val, salt, size)
+}
+
+// parseKDFParamsFor reads in-band parameters that have been stored for the
+// given algo version.
+func parseKDFParamsFor(algoVersion int, s string) (KDFParams, error) {
+	if algoVersion == passwordAlgoPBKDF2 {
+		return parsePBKDF2Params(s)
+	}
+	return parseKDFParams(s)
+}
+
+func parsePBKDF2Params(s string) (KDFParams, error) {
+	match := parsePBKDF2ParamsRE.FindStringSubmatch(s)
+	if match == nil {
+		return KDFParams{}, fmt.Errorf("keys: could not parse pbkdf2 parameters %s", s)
+	}
+	v, err := strconv.ParseUint(match[1], 10, 32)
+	if err != nil {
+		return KDFParams{}, fmt.Errorf("keys: error parsing pbkdf2 parameter: %w", err)
+	}
+	p := KDFParams{Iterations: uint32(v)}
+	if err := p.validatePBKDF2(); err != nil {
+		return KDFParams{}, fmt.Errorf("keys: stored pbkdf2 parameters are invalid: %w", err)
+	}
+	return p, nil
+}
+
+func parseKDFParams(s string) (KDFParams, error) {
+	match := parseKDFParamsRE.FindStringSubmatch(s)
+	if match == nil {
+		return KDFParams{}, fmt.Errorf("keys: could not parse kdf parameters %s", s)
+	}
+	var values []uint64
+	for idx, bitSize := range []int{32, 32, 8} {
+		v, err := strconv.ParseUint(match[idx+1], 10, bitSize)
+		if err != nil {
+			return KDFParams{}, fmt.Errorf("keys: error parsing kdf parameter: %w", err)
+		}
+		values = append(values, v)
+	}
+	p := KDFParams{Memory: uint32(values[0]), Time: uint32(values[1]), Threads: uint8(values[2])}
+	if err := p.validateArgon2(); err != nil {
+		return KDFParams{}, fmt.Errorf("keys: stored kdf parameters are invalid: %w", err)
+	}
+	return p, nil
+}
+
+// Outdated checks whether the given versioned salt or hash has been created
+// using an algorithm or parameters other than p. Callers that have access to
+// the original plaintext value are expected to re-create such values.
+func (p KDFParams) Outdated(versionedCipher string) bool {
+	v, err := unmarshalVersionedCipher(versionedCipher)
+	if err != nil {
+		return false
+	}
+	if v.algoVersion != kdfAlgoVersion() {
+		return true
+	}
+	stored, err := parseKDFParamsFor(v.algoVersion, v.params)
+	if err != nil {
+		return true
+	}
+	current := p.OrDefault()
+	if v.algoVersion == passwordAlgoPBKDF2 {
+		return stored.Iterations != current.Iterations
+	}
+	return stored.Time != current.Time || stored.Memory != current.Memory || stored.Threads != current.Threads
+}
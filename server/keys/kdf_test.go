@@ -0,0 +1,145 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"testing"
+)
+
+func TestKDFParams_Outdated(t *testing.T) {
+	current := KDFParams{Time: 1, Memory: 64, Threads: 1}
+	tests := []struct {
+		name           string
+		value          func() string
+		expectedResult bool
+	}{
+		{
+			"legacy algo",
+			func() string {
+				return "{2,} YWJj eHl6"
+			},
+			true,
+		},
+		{
+			"other parameters",
+			func() string {
+				v, _ := HashStringWith("pass", KDFParams{Time: 1, Memory: 32, Threads: 1})
+				return v.Marshal()
+			},
+			true,
+		},
+		{
+			"current parameters",
+			func() string {
+				v, _ := HashStringWith("pass", current)
+				return v.Marshal()
+			},
+			false,
+		},
+		{
+			"current parameters salt",
+			func() string {
+				v, _ := NewSaltWith(DefaultSaltLength, current)
+				return v.Marshal()
+			},
+			false,
+		},
+		{
+			"unparsable value",
+			func() string {
+				return "zzz"
+			},
+			false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := current.Outdated(test.value()); result != test.expectedResult {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestParseKDFParams(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		expectedResult KDFParams
+		expectError    bool
+	}{
+		{"ok", "m=16384;t=4;p=2", KDFParams{Memory: 16384, Time: 4, Threads: 2}, false},
+		{"bad format", "m=16384,t=4,p=2", KDFParams{}, true},
+		{"overflow", "m=16384;t=4;p=256", KDFParams{}, true},
+		{"invalid", "m=1;t=4;p=2", KDFParams{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := parseKDFParams(test.input)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if result != test.expectedResult {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestHashStringWith(t *testing.T) {
+	params := KDFParams{Time: 1, Memory: 64, Threads: 2}
+	hash, err := HashStringWith("s3cr3t", params)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := CompareString("s3cr3t", hash.Marshal()); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := CompareString("other", hash.Marshal()); err == nil {
+		t.Error("Comparison unexpectedly passed for wrong password")
+	}
+	if _, err := HashStringWith("s3cr3t", KDFParams{Time: 1}); err == nil {
+		t.Error("Expected error when passing invalid parameters")
+	}
+}
+
+func TestDeriveKey_Params(t *testing.T) {
+	salt, err := NewSaltWith(DefaultSaltLength, KDFParams{Time: 1, Memory: 64, Threads: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	a, err := DeriveKey("pass", salt.Marshal())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	b, _ := DeriveKey("pass", salt.Marshal())
+	if string(a) != string(b) || len(a) != DefaultEncryptionKeySize {
+		t.Errorf("Expected stable key of default size, got %v and %v", a, b)
+	}
+}
+
+func TestParsePBKDF2Params(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		expectedResult KDFParams
+		expectError    bool
+	}{
+		{"ok", "i=600000", KDFParams{Iterations: 600000}, false},
+		{"bad format", "i=600000;t=1", KDFParams{}, true},
+		{"overflow", "i=99999999999", KDFParams{}, true},
+		{"too few iterations", "i=10", KDFParams{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := parsePBKDF2Params(test.input)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if result != test.expectedResult {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
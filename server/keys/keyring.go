@@ -0,0 +1,383 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ed25519"
+)
+
+// SigningKeyring derives the keys used for signing tokens from a secret.
+// Keys are rotated each period, while the key of the previous period stays
+// valid for verifying tokens until the grace period has passed.
+type SigningKeyring struct {
+	secret    []byte
+	previous  [][]byte
+	algorithm string
+	signer    Signer
+	period    time.Duration
+	grace     time.Duration
+	now       func() time.Time
+	logger    *logrus.Logger
+}
+
+// SigningKeyringOption adds a configuration value to a SigningKeyring.
+type SigningKeyringOption func(*SigningKeyring)
+
+// WithSigningAlgorithm sets the algorithm used for signing tokens. It
+// defaults to ES256 in case it is not given.
+func WithSigningAlgorithm(algorithm string) SigningKeyringOption {
+	return func(k *SigningKeyring) {
+		if algorithm != "" {
+			k.algorithm = algorithm
+		}
+	}
+}
+
+// WithPreviousSecrets adds secrets that have been rotated out. Keys derived
+// from these secrets are still accepted when verifying tokens, but are never
+// used for signing, so that rotating the secret does not invalidate
+// all existing tokens at once.
+func WithPreviousSecrets(secrets ...[]byte) SigningKeyringOption {
+	return func(k *SigningKeyring) {
+		for _, secret := range secrets {
+			if len(secret) != 0 {
+				k.previous = append(k.previous, secret)
+			}
+		}
+	}
+}
+
+// WithSigner makes the keyring use the given signer instead of deriving keys
+// from its secret. This is used for keys that are managed externally, e.g.
+// in a hardware security module, in which case rotation is up to the operator.
+func WithSigner(signer Signer) SigningKeyringOption {
+	return func(k *SigningKeyring) {
+		k.signer = signer
+	}
+}
+
+// WithKeyringLogger sets the logger used for reporting on the use of keys,
+// e.g. when tokens signed using a previous secret are still in use.
+func WithKeyringLogger(logger *logrus.Logger) SigningKeyringOption {
+	return func(k *SigningKeyring) {
+		k.logger = logger
+	}
+}
+
+// ValidateKeyringTimings checks whether the given rotation and grace period
+// can be used for a keyring. Keys are rotated at second precision, so the
+// period needs to be at least one second, and the grace period needs to be
+// shorter than the period as only the key of the previous period is kept.
+func ValidateKeyringTimings(period, grace time.Duration) error {
+	if period < time.Second {
+		return fmt.Errorf("keys: rotation period of %v is shorter than one second", period)
+	}
+	if grace < 0 || grace >= period {
+		return fmt.Errorf("keys: grace period of %v needs to be shorter than the rotation period of %v", grace, period)
+	}
+	return nil
+}
+
+// NewSigningKeyring creates a new keyring using the given secret and timings.
+func NewSigningKeyring(secret []byte, period, grace time.Duration, opts ...SigningKeyringOption) (*SigningKeyring, error) {
+	if err := ValidateKeyringTimings(period, grace); err != nil {
+		return nil, err
+	}
+	k := &SigningKeyring{
+		secret:    secret,
+		algorithm: SigningAlgorithmES256,
+		period:    period,
+		grace:     grace,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(k)
+	}
+	return k, nil
+}
+
+func (k *SigningKeyring) epoch() (int64, time.Duration) {
+	now := k.now()
+	seconds := int64(k.period / time.Second)
+	epoch := now.Unix() / seconds
+	elapsed := now.Sub(time.Unix(epoch*seconds, 0))
+	return epoch, elapsed
+}
+
+// secretFingerprint identifies a secret without revealing anything about it
+// so that keys derived from different secrets get distinct key ids.
+func secretFingerprint(secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("offen-signing-key-id"))
+	return hex.EncodeToString(mac.Sum(nil)[:4])
+}
+
+func (k *SigningKeyring) signerFor(secret []byte, epoch int64) (Signer, error) {
+	if k.signer != nil {
+		return k.signer, nil
+	}
+	return deriveSigner(k.algorithm, secret, secretFingerprint(secret)+"-"+strconv.FormatInt(epoch, 10))
+}
+
+// Current returns the signer that is supposed to be used for new tokens.
+func (k *SigningKeyring) Current() (Signer, error) {
+	epoch, _ := k.epoch()
+	return k.signerFor(k.secret, epoch)
+}
+
+// Active returns all signers whose signatures are currently considered valid.
+func (k *SigningKeyring) Active() ([]Signer, error) {
+	if k.signer != nil {
+		return []Signer{k.signer}, nil
+	}
+	epoch, elapsed := k.epoch()
+	epochs := []int64{epoch}
+	if elapsed < k.grace {
+		epochs = append(epochs, epoch-1)
+	}
+	var result []Signer
+	for _, secret := range append([][]byte{k.secret}, k.previous...) {
+		for _, e := range epochs {
+			signer, err := k.signerFor(secret, e)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, signer)
+		}
+	}
+	return result, nil
+}
+
+// receiptKeyID is the suffix of the key id of receipt keys, which are
+// derived without an epoch.
+const receiptKeyID = "receipts"
+
+// Receipts returns the signer used for signing receipts. Receipts need to be
+// verifiable long after they have been issued, so in contrast to token keys
+// this key is never rotated unless the secret itself is.
+func (k *SigningKeyring) Receipts() (Signer, error) {
+	return k.receiptSignerFor(k.secret)
+}
+
+func (k *SigningKeyring) receiptSignerFor(secret []byte) (Signer, error) {
+	if k.signer != nil {
+		return k.signer, nil
+	}
+	return deriveSigner(k.algorithm, secret, secretFingerprint(secret)+"-"+receiptKeyID)
+}
+
+// published returns all signers whose public keys are published, which are
+// the active token keys and the receipt keys of all known secrets.
+func (k *SigningKeyring) published() ([]Signer, error) {
+	result, err := k.Active()
+	if err != nil {
+		return nil, err
+	}
+	if k.signer != nil {
+		return result, nil
+	}
+	for _, secret := range append([][]byte{k.secret}, k.previous...) {
+		signer, err := k.receiptSignerFor(secret)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, signer)
+	}
+	return result, nil
+}
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Type      string `json:"typ"`
+}
+
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+var tokenEncoding = base64.RawURLEncoding
+
+// SignToken creates a JWT in compact serialization for the given subject that
+// expires after the given duration.
+func (k *SigningKeyring) SignToken(subject string, ttl time.Duration) (string, error) {
+	signer, err := k.Current()
+	if err != nil {
+		return "", fmt.Errorf("keys: error looking up current signer: %w", err)
+	}
+	now := k.now()
+	return sign(signer, tokenClaims{
+		Subject:   subject,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+}
+
+// SignReceipt creates a JWS in compact serialization of the given claims
+// using the receipt key, so they can be verified using the published JSON Web
+// Key Set for as long as the secret is known to the keyring. Receipts are
+// never accepted by VerifyToken.
+func (k *SigningKeyring) SignReceipt(claims interface{}) (string, error) {
+	signer, err := k.Receipts()
+	if err != nil {
+		return "", fmt.Errorf("keys: error looking up receipt signer: %w", err)
+	}
+	return sign(signer, claims)
+}
+
+func sign(signer Signer, claims interface{}) (string, error) {
+	header, _ := json.Marshal(tokenHeader{
+		Algorithm: signer.Algorithm(),
+		KeyID:     signer.KeyID(),
+		Type:      "JWT",
+	})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("keys: error encoding claims: %w", err)
+	}
+	signingInput := tokenEncoding.EncodeToString(header) + "." + tokenEncoding.EncodeToString(payload)
+	signature, err := signer.Sign([]byte(signingInput))
+	if err != nil {
+		return "", fmt.Errorf("keys: error signing token: %w", err)
+	}
+	return signingInput + "." + tokenEncoding.EncodeToString(signature), nil
+}
+
+// VerifyToken verifies the given token and returns its subject in case it
+// has been signed by an active key and has not expired yet.
+func (k *SigningKeyring) VerifyToken(token string) (string, error) {
+	msg, err := jws.ParseString(token)
+	if err != nil {
+		return "", fmt.Errorf("keys: error parsing token: %w", err)
+	}
+	signatures := msg.Signatures()
+	if len(signatures) != 1 {
+		return "", errors.New("keys: malformed token")
+	}
+	header := signatures[0].ProtectedHeaders()
+	keyID, _ := header.Get(jws.KeyIDKey)
+
+	signers, err := k.Active()
+	if err != nil {
+		return "", fmt.Errorf("keys: error looking up active signers: %w", err)
+	}
+	var signer Signer
+	for _, s := range signers {
+		if s.KeyID() == keyID && s.Algorithm() == string(header.Algorithm()) {
+			signer = s
+			break
+		}
+	}
+	if signer == nil {
+		return "", fmt.Errorf("keys: token was signed using unknown or expired key %v", keyID)
+	}
+	payload, err := verifyCompact(signer, token)
+	if err != nil {
+		return "", fmt.Errorf("keys: error verifying token: %w", err)
+	}
+
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("keys: error decoding token claims: %w", err)
+	}
+	if k.now().Unix() >= claims.ExpiresAt {
+		return "", errors.New("keys: token has expired")
+	}
+	if k.logger != nil && k.signer == nil && !strings.HasPrefix(signer.KeyID(), secretFingerprint(k.secret)+"-") {
+		// previous secrets can only be removed once this stops happening
+		k.logger.WithField("keyId", signer.KeyID()).Info("Verified token signed using a previous secret")
+	}
+	return claims.Subject, nil
+}
+
+// verifyCompact verifies the compact serialization of a JWS using the public
+// key of the given signer and returns its payload. The jws package does not
+// implement EdDSA, so these signatures are verified separately.
+func verifyCompact(signer Signer, token string) ([]byte, error) {
+	if signer.Algorithm() != SigningAlgorithmEdDSA {
+		return jws.Verify([]byte(token), jwa.SignatureAlgorithm(signer.Algorithm()), signer.Public())
+	}
+	protected, payload, signature, err := jws.SplitCompact(strings.NewReader(token))
+	if err != nil {
+		return nil, err
+	}
+	decodedSignature, err := tokenEncoding.DecodeString(string(signature))
+	if err != nil {
+		return nil, err
+	}
+	signingInput := append(append(protected, '.'), payload...)
+	if err := verifySignature(signer.Algorithm(), signer.Public(), signingInput, decodedSignature); err != nil {
+		return nil, err
+	}
+	return tokenEncoding.DecodeString(string(payload))
+}
+
+// JWKS returns the public keys of all active signers and all receipt
+// signers as a JSON Web Key Set.
+func (k *SigningKeyring) JWKS() ([]byte, error) {
+	signers, err := k.published()
+	if err != nil {
+		return nil, fmt.Errorf("keys: error looking up published signers: %w", err)
+	}
+	set := struct {
+		Keys []interface{} `json:"keys"`
+	}{Keys: []interface{}{}}
+	for _, signer := range signers {
+		key, err := publicJWK(signer)
+		if err != nil {
+			return nil, fmt.Errorf("keys: error wrapping public key as jwk: %w", err)
+		}
+		set.Keys = append(set.Keys, key)
+	}
+	return json.Marshal(set)
+}
+
+// okpKey is the JWK representation of an Ed25519 public key as defined
+// in RFC 8037. It is not supported by the jwk package itself.
+type okpKey struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+}
+
+func publicJWK(signer Signer) (interface{}, error) {
+	if public, ok := signer.Public().(ed25519.PublicKey); ok {
+		return okpKey{
+			KeyType:   "OKP",
+			Curve:     "Ed25519",
+			X:         tokenEncoding.EncodeToString(public),
+			KeyID:     signer.KeyID(),
+			Algorithm: signer.Algorithm(),
+			Use:       string(jwk.ForSignature),
+		}, nil
+	}
+	key, err := jwk.New(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	key.Set(jwk.KeyIDKey, signer.KeyID())
+	key.Set(jwk.AlgorithmKey, signer.Algorithm())
+	key.Set(jwk.KeyUsageKey, string(jwk.ForSignature))
+	return key, nil
+}
@@ -0,0 +1,210 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSigningKeyring(t *testing.T) {
+	start := time.Unix(1000*3600, 0)
+	for _, test := range []struct {
+		algorithm       string
+		expectedKeyType string
+	}{
+		{SigningAlgorithmES256, "EC"},
+		{SigningAlgorithmEdDSA, "OKP"},
+	} {
+		t.Run(test.algorithm, func(t *testing.T) {
+			newKeyring := func(secret string, now time.Time) *SigningKeyring {
+				k, err := NewSigningKeyring([]byte(secret), time.Hour, time.Minute*10, WithSigningAlgorithm(test.algorithm))
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				k.now = func() time.Time { return now }
+				return k
+			}
+
+			t.Run("roundtrip", func(t *testing.T) {
+				k := newKeyring("secret", start)
+				token, err := k.SignToken("user-a", time.Minute)
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				subject, err := k.VerifyToken(token)
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				if subject != "user-a" {
+					t.Errorf("Unexpected subject %v", subject)
+				}
+			})
+			t.Run("other node", func(t *testing.T) {
+				token, _ := newKeyring("secret", start).SignToken("user-a", time.Minute)
+				if _, err := newKeyring("secret", start).VerifyToken(token); err != nil {
+					t.Errorf("Unexpected error verifying token on node sharing the secret: %v", err)
+				}
+				if _, err := newKeyring("other", start).VerifyToken(token); err == nil {
+					t.Error("Expected error verifying token using different secret")
+				}
+			})
+			t.Run("expiry", func(t *testing.T) {
+				token, _ := newKeyring("secret", start).SignToken("user-a", time.Minute)
+				if _, err := newKeyring("secret", start.Add(time.Minute*2)).VerifyToken(token); err == nil {
+					t.Error("Expected error verifying expired token")
+				}
+			})
+			t.Run("rotation", func(t *testing.T) {
+				token, _ := newKeyring("secret", start.Add(time.Minute*59)).SignToken("user-a", time.Hour)
+				if _, err := newKeyring("secret", start.Add(time.Minute*65)).VerifyToken(token); err != nil {
+					t.Errorf("Unexpected error verifying token within grace period: %v", err)
+				}
+				if _, err := newKeyring("secret", start.Add(time.Minute*75)).VerifyToken(token); err == nil {
+					t.Error("Expected error verifying token after grace period")
+				}
+			})
+			t.Run("previous secrets", func(t *testing.T) {
+				token, _ := newKeyring("secret", start).SignToken("user-a", time.Minute)
+
+				var buf bytes.Buffer
+				logger := logrus.New()
+				logger.Out = &buf
+				k := newKeyring("next", start)
+				WithPreviousSecrets([]byte("secret"))(k)
+				WithKeyringLogger(logger)(k)
+				if _, err := k.VerifyToken(token); err != nil {
+					t.Errorf("Unexpected error verifying token signed using previous secret: %v", err)
+				}
+				if !strings.Contains(buf.String(), "previous secret") {
+					t.Errorf("Expected use of previous secret to be logged, got %v", buf.String())
+				}
+				next, _ := k.SignToken("user-a", time.Minute)
+				if _, err := newKeyring("secret", start).VerifyToken(next); err == nil {
+					t.Error("Expected token to be signed using the current secret")
+				}
+				if _, err := newKeyring("next", start).VerifyToken(token); err == nil {
+					t.Error("Expected error verifying token after previous secret has been removed")
+				}
+			})
+			t.Run("tampering", func(t *testing.T) {
+				k := newKeyring("secret", start)
+				token, _ := k.SignToken("user-a", time.Minute)
+				other, _ := k.SignToken("user-b", time.Minute)
+				chunks := strings.Split(token, ".")
+				otherChunks := strings.Split(other, ".")
+				if _, err := k.VerifyToken(chunks[0] + "." + otherChunks[1] + "." + chunks[2]); err == nil {
+					t.Error("Expected error verifying tampered token")
+				}
+				if _, err := k.VerifyToken("abc"); err == nil {
+					t.Error("Expected error verifying malformed token")
+				}
+			})
+			t.Run("receipts", func(t *testing.T) {
+				k := newKeyring("secret", start)
+				signed, err := k.SignReceipt(map[string]interface{}{"sub": "user-a", "removed": 12})
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				var claims map[string]interface{}
+				payload, _ := tokenEncoding.DecodeString(strings.Split(signed, ".")[1])
+				if err := json.Unmarshal(payload, &claims); err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				if claims["removed"] != float64(12) {
+					t.Errorf("Unexpected claims %v", claims)
+				}
+				if _, err := k.VerifyToken(signed); err == nil {
+					t.Error("Expected receipt to be rejected as token")
+				}
+
+				// receipts stay verifiable after token keys have been rotated
+				later := newKeyring("next", start.Add(time.Hour*24*365))
+				WithPreviousSecrets([]byte("secret"))(later)
+				signers, err := later.published()
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				var verified bool
+				for _, signer := range signers {
+					if _, err := verifyCompact(signer, signed); err == nil {
+						verified = true
+					}
+				}
+				if !verified {
+					t.Error("Expected receipt to be verifiable using published keys")
+				}
+			})
+			t.Run("jwks", func(t *testing.T) {
+				for _, set := range []struct {
+					now          time.Time
+					expectedKeys int
+				}{
+					{start.Add(time.Minute * 5), 3},
+					{start.Add(time.Minute * 15), 2},
+				} {
+					b, err := newKeyring("secret", set.now).JWKS()
+					if err != nil {
+						t.Fatalf("Unexpected error %v", err)
+					}
+					var result struct {
+						Keys []map[string]interface{} `json:"keys"`
+					}
+					if err := json.Unmarshal(b, &result); err != nil {
+						t.Fatalf("Unexpected error %v", err)
+					}
+					if len(result.Keys) != set.expectedKeys {
+						t.Errorf("Expected %d keys, got %d", set.expectedKeys, len(result.Keys))
+					}
+					for _, key := range result.Keys {
+						if key["kid"] == nil || key["alg"] != test.algorithm || key["kty"] != test.expectedKeyType || key["d"] != nil {
+							t.Errorf("Unexpected key %v", key)
+						}
+					}
+				}
+			})
+		})
+	}
+	t.Run("unknown algorithm", func(t *testing.T) {
+		k, _ := NewSigningKeyring([]byte("secret"), time.Hour, time.Minute, WithSigningAlgorithm("HS1"))
+		if _, err := k.SignToken("user-a", time.Minute); err == nil {
+			t.Error("Expected error")
+		}
+	})
+}
+
+func TestNewSigningKeyring_Timings(t *testing.T) {
+	tests := []struct {
+		name        string
+		period      time.Duration
+		grace       time.Duration
+		expectError bool
+	}{
+		{"ok", time.Hour, time.Minute, false},
+		{"one second", time.Second, 0, false},
+		{"period below one second", time.Millisecond * 500, 0, true},
+		{"zero period", 0, 0, true},
+		{"grace equal to period", time.Hour, time.Hour, true},
+		{"grace longer than period", time.Hour, time.Hour * 2, true},
+		{"negative grace", time.Hour, -time.Minute, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			k, err := NewSigningKeyring([]byte("secret"), test.period, test.grace)
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if err == nil {
+				if _, err := k.SignToken("user-a", time.Minute); err != nil {
+					t.Errorf("Unexpected error %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// minPRFOutputLength is the minimum number of bytes a PRF output needs to
+// have for being used as key material.
+const minPRFOutputLength = 32
+
+// DeriveKeyFromPRF derives an encryption key from the output of a WebAuthn
+// authenticator's pseudo random function. The output is only known to the
+// holder of the authenticator, so the key can be used to encrypt key material
+// that is released when the authenticator is used for logging in.
+func DeriveKeyFromPRF(prfOutput, credentialID []byte) ([]byte, error) {
+	if len(prfOutput) < minPRFOutputLength {
+		return nil, errors.New("keys: prf output is too short")
+	}
+	key := make([]byte, DefaultEncryptionKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, prfOutput, credentialID, []byte("offen-webauthn-prf")), key); err != nil {
+		return nil, fmt.Errorf("keys: error deriving key from prf output: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,27 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeriveKeyFromPRF(t *testing.T) {
+	prfOutput := bytes.Repeat([]byte("a"), 32)
+	key, err := DeriveKeyFromPRF(prfOutput, []byte("credential-a"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(key) != DefaultEncryptionKeySize {
+		t.Errorf("Unexpected key length %d", len(key))
+	}
+	other, _ := DeriveKeyFromPRF(prfOutput, []byte("credential-b"))
+	if bytes.Equal(key, other) {
+		t.Error("Expected keys to differ for different credentials")
+	}
+	if _, err := DeriveKeyFromPRF([]byte("short"), []byte("credential-a")); err == nil {
+		t.Error("Expected error for short prf output")
+	}
+}
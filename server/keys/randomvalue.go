@@ -4,9 +4,53 @@
 package keys
 
 import (
+	"bytes"
+	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
 )
 
+// randReader is the source of randomness used for all values generated
+// by this package. It is only ever swapped out in tests.
+var randReader io.Reader = rand.Reader
+
+// GenerateRandomBytes generates a slice of random bytes of the given size.
+// Callers that need raw key material should use this instead of decoding
+// the result of GenerateRandomValue.
+func GenerateRandomBytes(size int) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(randReader, b); err != nil {
+		return nil, fmt.Errorf("keys: error reading random bytes: %w", err)
+	}
+	return b, nil
+}
+
+const entropyCheckSampleSize = 32
+
+// CheckEntropySource verifies the system's source of randomness can be read
+// from and does not return obviously broken values. It is supposed to be
+// called on startup before any key material is generated.
+func CheckEntropySource() error {
+	first, err := GenerateRandomBytes(entropyCheckSampleSize)
+	if err != nil {
+		return fmt.Errorf("keys: entropy source cannot be read: %w", err)
+	}
+	second, err := GenerateRandomBytes(entropyCheckSampleSize)
+	if err != nil {
+		return fmt.Errorf("keys: entropy source cannot be read: %w", err)
+	}
+	zero := make([]byte, entropyCheckSampleSize)
+	if bytes.Equal(first, zero) || bytes.Equal(second, zero) {
+		return errors.New("keys: entropy source returned only zero bytes")
+	}
+	if bytes.Equal(first, second) {
+		return errors.New("keys: entropy source returned repeated values")
+	}
+	return nil
+}
+
 // StringEncoder can encode a byte slice into a printable string.
 type StringEncoder interface {
 	EncodeToString([]byte) string
@@ -22,7 +66,8 @@ func randomBytesWithEncoding(length int, e StringEncoder) (string, error) {
 
 // GenerateRandomValue returns a slice of random values encoded as a
 // Base64 string. This means the returned string will likely be longer than
-// the requested length.
+// the requested length. Use GenerateRandomBytes in case the raw bytes are
+// needed.
 func GenerateRandomValue(length int) (string, error) {
 	return randomBytesWithEncoding(length, base64.StdEncoding)
 }
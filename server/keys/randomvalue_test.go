@@ -4,8 +4,10 @@
 package keys
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/hex"
+	"io"
 	"testing"
 )
 
@@ -50,3 +52,91 @@ func TestGenerateRandomValueWith(t *testing.T) {
 		t.Errorf("Unexpected result length %d", len(b))
 	}
 }
+
+func TestGenerateRandomBytes(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		b, err := GenerateRandomBytes(24)
+		if err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if len(b) != 24 {
+			t.Errorf("Unexpected result length %d", len(b))
+		}
+	})
+	t.Run("short read", func(t *testing.T) {
+		defer func(r io.Reader) { randReader = r }(randReader)
+		randReader = bytes.NewReader([]byte{1, 2, 3})
+		if _, err := GenerateRandomBytes(24); err == nil {
+			t.Error("Expected error, got nil")
+		}
+	})
+}
+
+type repeatReader struct {
+	b byte
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.b
+	}
+	return len(p), nil
+}
+
+type counterReader struct {
+	n byte
+}
+
+func (r *counterReader) Read(p []byte) (int, error) {
+	r.n++
+	for i := range p {
+		p[i] = r.n
+	}
+	return len(p), nil
+}
+
+func TestCheckEntropySource(t *testing.T) {
+	tests := []struct {
+		name        string
+		reader      io.Reader
+		expectError bool
+	}{
+		{
+			"system source",
+			nil,
+			false,
+		},
+		{
+			"unreadable",
+			bytes.NewReader(nil),
+			true,
+		},
+		{
+			"zero bytes",
+			&repeatReader{0},
+			true,
+		},
+		{
+			"repeated values",
+			&repeatReader{7},
+			true,
+		},
+		{
+			"changing values",
+			&counterReader{},
+			false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.reader != nil {
+				defer func(r io.Reader) { randReader = r }(randReader)
+				randReader = test.reader
+			}
+			err := CheckEntropySource()
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+		})
+	}
+}
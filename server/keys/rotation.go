@@ -0,0 +1,28 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// DeriveRotationKey derives the key that is used for encrypting key encryption
+// keys while their rotation is pending from the given secret. In contrast to
+// encrypting the next key using the previous one, knowing a key that has been
+// rotated out does not allow deriving the keys that replace it.
+func DeriveRotationKey(secret []byte) ([]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("keys: cannot derive rotation key from empty secret")
+	}
+	key := make([]byte, DefaultEncryptionKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte("offen-key-rotation")), key); err != nil {
+		return nil, fmt.Errorf("keys: error deriving rotation key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Signer creates signatures for tokens issued by the server. Each signer is
+// identified by a key id so that verifiers can pick the matching public key.
+type Signer interface {
+	KeyID() string
+	Algorithm() string
+	Sign(payload []byte) ([]byte, error)
+	Public() crypto.PublicKey
+}
+
+// Algorithms that can be used for signing tokens.
+const (
+	SigningAlgorithmES256 = "ES256"
+	SigningAlgorithmEdDSA = "EdDSA"
+)
+
+type ecdsaSigner struct {
+	keyID string
+	key   *ecdsa.PrivateKey
+}
+
+func (e *ecdsaSigner) KeyID() string {
+	return e.keyID
+}
+
+func (e *ecdsaSigner) Algorithm() string {
+	return SigningAlgorithmES256
+}
+
+func (e *ecdsaSigner) Public() crypto.PublicKey {
+	return &e.key.PublicKey
+}
+
+// Sign returns the signature of the given payload in the fixed size R || S
+// format as defined in RFC 7518.
+func (e *ecdsaSigner) Sign(payload []byte) ([]byte, error) {
+	digest := sha256.Sum256(payload)
+	r, s, err := ecdsa.Sign(rand.Reader, e.key, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("keys: error signing payload: %w", err)
+	}
+	size := (e.key.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	rBytes, sBytes := r.Bytes(), s.Bytes()
+	copy(signature[size-len(rBytes):size], rBytes)
+	copy(signature[2*size-len(sBytes):], sBytes)
+	return signature, nil
+}
+
+// deriveECDSASigner deterministically derives a P-256 key from the given
+// secret, using the key id for domain separation. This allows all nodes that
+// share the same secret to use the same keys without having to store them.
+func deriveECDSASigner(secret []byte, keyID string) (Signer, error) {
+	curve := elliptic.P256()
+	// extra bytes are read so that reducing the value does not introduce
+	// a noticeable bias
+	seed := make([]byte, curve.Params().BitSize/8+8)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte("offen-signing-key-"+keyID)), seed); err != nil {
+		return nil, fmt.Errorf("keys: error deriving signing key: %w", err)
+	}
+	n := new(big.Int).Sub(curve.Params().N, big.NewInt(1))
+	d := new(big.Int).Mod(new(big.Int).SetBytes(seed), n)
+	d.Add(d, big.NewInt(1))
+
+	key := &ecdsa.PrivateKey{D: d}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+	return &ecdsaSigner{keyID: keyID, key: key}, nil
+}
+
+type ed25519Signer struct {
+	keyID string
+	key   ed25519.PrivateKey
+}
+
+func (e *ed25519Signer) KeyID() string {
+	return e.keyID
+}
+
+func (e *ed25519Signer) Algorithm() string {
+	return SigningAlgorithmEdDSA
+}
+
+func (e *ed25519Signer) Public() crypto.PublicKey {
+	return e.key.Public()
+}
+
+func (e *ed25519Signer) Sign(payload []byte) ([]byte, error) {
+	return ed25519.Sign(e.key, payload), nil
+}
+
+// deriveEd25519Signer deterministically derives an Ed25519 key from the given
+// secret in the same fashion deriveECDSASigner does.
+func deriveEd25519Signer(secret []byte, keyID string) (Signer, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte("offen-signing-key-ed25519-"+keyID)), seed); err != nil {
+		return nil, fmt.Errorf("keys: error deriving signing key: %w", err)
+	}
+	return &ed25519Signer{keyID: keyID, key: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+func deriveSigner(algorithm string, secret []byte, keyID string) (Signer, error) {
+	switch algorithm {
+	case SigningAlgorithmES256:
+		return deriveECDSASigner(secret, keyID)
+	case SigningAlgorithmEdDSA:
+		if FIPSMode() {
+			return nil, errors.New("keys: EdDSA signatures are not approved for use in FIPS mode")
+		}
+		return deriveEd25519Signer(secret, keyID)
+	default:
+		return nil, fmt.Errorf("keys: unsupported signing algorithm %s", algorithm)
+	}
+}
+
+func verifySignature(algorithm string, public crypto.PublicKey, payload, signature []byte) error {
+	switch algorithm {
+	case SigningAlgorithmES256:
+		key, ok := public.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("keys: unexpected public key type %T for %s", public, algorithm)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("keys: signature has unexpected length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		digest := sha256.Sum256(payload)
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return errors.New("keys: invalid signature")
+		}
+		return nil
+	case SigningAlgorithmEdDSA:
+		key, ok := public.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("keys: unexpected public key type %T for %s", public, algorithm)
+		}
+		if !ed25519.Verify(key, payload, signature) {
+			return errors.New("keys: invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("keys: unsupported signing algorithm %s", algorithm)
+	}
+}
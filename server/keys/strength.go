@@ -0,0 +1,260 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Feedback messages returned when a password is considered weak.
+const (
+	FeedbackCommonPassword = "Avoid common passwords and single words."
+	FeedbackUserInput      = "Avoid using your email address or parts of it."
+	FeedbackSequence       = "Avoid sequences like abc, 1234 or qwerty."
+	FeedbackRepeat         = "Avoid repeated characters like aaa."
+	FeedbackMoreWords      = "Add another word or two. Uncommon words are better."
+)
+
+// PasswordStrengthError is returned when a password does not meet the
+// required minimum strength. Feedback contains hints on how to choose
+// a stronger password that can be displayed to the user.
+type PasswordStrengthError struct {
+	Score    int
+	MinScore int
+	Feedback []string
+}
+
+func (p *PasswordStrengthError) Error() string {
+	return fmt.Sprintf("keys: password has a strength score of %d, at least %d is required", p.Score, p.MinScore)
+}
+
+// PasswordStrength is the result of estimating how hard it is to guess
+// a password. Score ranges from 0 (too guessable) to 4 (very unguessable)
+// using the same thresholds as zxcvbn.
+type PasswordStrength struct {
+	Score    int
+	Guesses  float64
+	Feedback []string
+}
+
+// ValidatePasswordStrength checks the given password against the password
+// policy and returns a PasswordStrengthError in case its estimated strength
+// is lower than minScore. User inputs like the email address are considered
+// to be easy to guess.
+func ValidatePasswordStrength(pw string, minScore int, userInputs ...string) error {
+	if err := ValidatePassword(pw); err != nil {
+		return err
+	}
+	if minScore <= 0 {
+		return nil
+	}
+	strength := EstimatePasswordStrength(pw, userInputs...)
+	if strength.Score < minScore {
+		return &PasswordStrengthError{
+			Score:    strength.Score,
+			MinScore: minScore,
+			Feedback: strength.Feedback,
+		}
+	}
+	return nil
+}
+
+var leetSubstitutions = strings.NewReplacer(
+	"@", "a", "4", "a", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t", "+", "t",
+)
+
+var keyboardRows = []string{
+	"abcdefghijklmnopqrstuvwxyz",
+	"01234567890",
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm",
+	"qwertzuiop",
+	"yxcvbnm",
+	"azertyuiop",
+}
+
+// EstimatePasswordStrength estimates the number of guesses needed to crack
+// the given password by splitting it into dictionary words, user inputs,
+// sequences, repeats and random characters.
+func EstimatePasswordStrength(pw string, userInputs ...string) PasswordStrength {
+	normalized := leetSubstitutions.Replace(strings.ToLower(pw))
+	feedback := map[string]bool{}
+
+	var dictionary []string
+	for _, input := range userInputs {
+		if len(input) >= 3 {
+			dictionary = append(dictionary, strings.ToLower(input))
+		}
+		for _, token := range strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len(token) >= 3 {
+				dictionary = append(dictionary, token)
+			}
+		}
+	}
+	userInputCount := len(dictionary)
+	dictionary = append(dictionary, commonPasswords...)
+
+	var bits float64
+	// substitutions are always replacing a single character so both
+	// variants can be matched at the same offset
+	lower := []rune(strings.ToLower(pw))
+	remaining := []rune(normalized)
+	for i := 0; i < len(remaining); {
+		length, rank := matchDictionary(lower[i:], dictionary)
+		if l, r := matchDictionary(remaining[i:], dictionary); l > length {
+			length, rank = l, r
+		}
+		if length > 0 {
+			if rank < userInputCount {
+				feedback[FeedbackUserInput] = true
+				bits += 1
+			} else {
+				feedback[FeedbackCommonPassword] = true
+				bits += math.Log2(float64(rank-userInputCount) + 2)
+			}
+			i += length
+			continue
+		}
+		if length := matchRepeat(remaining[i:]); length >= 3 {
+			feedback[FeedbackRepeat] = true
+			bits += math.Log2(charsetSize(pw) * float64(length))
+			i += length
+			continue
+		}
+		if length := matchSequence(remaining[i:]); length >= 3 {
+			feedback[FeedbackSequence] = true
+			bits += math.Log2(float64(len(keyboardRows)) * 2 * float64(length) * 10)
+			i += length
+			continue
+		}
+		bits += math.Log2(charsetSize(pw))
+		i++
+	}
+	if pw != strings.ToLower(pw) && pw != strings.ToUpper(pw) {
+		// mixed case adds little when it's only the first character
+		if len(pw) > 0 && strings.ToLower(pw[1:]) == pw[1:] {
+			bits++
+		} else {
+			bits += 2
+		}
+	}
+
+	guesses := math.Pow(2, bits)
+	score := scoreFromGuesses(guesses)
+	var messages []string
+	for _, message := range []string{FeedbackUserInput, FeedbackCommonPassword, FeedbackSequence, FeedbackRepeat} {
+		if feedback[message] {
+			messages = append(messages, message)
+		}
+	}
+	if score < 3 {
+		messages = append(messages, FeedbackMoreWords)
+	}
+	return PasswordStrength{Score: score, Guesses: guesses, Feedback: messages}
+}
+
+func scoreFromGuesses(guesses float64) int {
+	switch {
+	case guesses < 1e3:
+		return 0
+	case guesses < 1e6:
+		return 1
+	case guesses < 1e8:
+		return 2
+	case guesses < 1e10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func charsetSize(pw string) float64 {
+	var lower, upper, digit, symbol bool
+	for _, r := range pw {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	var size float64
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if symbol {
+		size += 33
+	}
+	return math.Max(size, 10)
+}
+
+// matchDictionary returns the length and the rank of the longest dictionary
+// entry that s starts with.
+func matchDictionary(s []rune, dictionary []string) (int, int) {
+	var length, rank int
+	str := string(s)
+	for idx, word := range dictionary {
+		if len(word) > length && strings.HasPrefix(str, word) {
+			length, rank = len([]rune(word)), idx
+		}
+	}
+	return length, rank
+}
+
+func matchRepeat(s []rune) int {
+	if len(s) == 0 {
+		return 0
+	}
+	length := 1
+	for length < len(s) && s[length] == s[0] {
+		length++
+	}
+	return length
+}
+
+// matchSequence returns the length of the ascending or descending run of
+// characters from a single keyboard row or the alphabet that s starts with.
+func matchSequence(s []rune) int {
+	var longest int
+	for _, row := range keyboardRows {
+		for _, candidate := range []string{row, reverse(row)} {
+			start := strings.IndexRune(candidate, s[0])
+			if start == -1 {
+				continue
+			}
+			length := 1
+			for length < len(s) && start+length < len(candidate) && rune(candidate[start+length]) == s[length] {
+				length++
+			}
+			if length > longest {
+				longest = length
+			}
+		}
+	}
+	return longest
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEstimatePasswordStrength(t *testing.T) {
+	tests := []struct {
+		name             string
+		password         string
+		userInputs       []string
+		expectedScore    int
+		expectedFeedback []string
+	}{
+		{"common password", "password1", nil, 0, []string{FeedbackCommonPassword, FeedbackMoreWords}},
+		{"leet substitutions", "P@ssw0rd!", nil, 0, []string{FeedbackCommonPassword, FeedbackMoreWords}},
+		{"repeat", "aaaaaaaa", nil, 0, []string{FeedbackRepeat, FeedbackMoreWords}},
+		{"keyboard sequence", "zxcvbnm1", nil, 1, []string{FeedbackSequence, FeedbackMoreWords}},
+		{"user input", "develop2020", []string{"develop@offen.dev"}, 2, []string{FeedbackUserInput, FeedbackMoreWords}},
+		{"passphrase", "correct horse battery staple", nil, 4, nil},
+		{"random", "Tr0ub4dor&3", nil, 4, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := EstimatePasswordStrength(test.password, test.userInputs...)
+			if result.Score != test.expectedScore {
+				t.Errorf("Expected score %d, got %d", test.expectedScore, result.Score)
+			}
+			if !reflect.DeepEqual(test.expectedFeedback, result.Feedback) {
+				t.Errorf("Expected feedback %v, got %v", test.expectedFeedback, result.Feedback)
+			}
+		})
+	}
+}
+
+func TestValidatePasswordStrength(t *testing.T) {
+	tests := []struct {
+		name          string
+		password      string
+		minScore      int
+		expectError   bool
+		expectWeakErr bool
+	}{
+		{"too short", "abc", 0, true, false},
+		{"no minimum", "password1", 0, false, false},
+		{"too weak", "password1", 2, true, true},
+		{"ok", "correct horse battery staple", 3, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidatePasswordStrength(test.password, test.minScore)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			var strengthErr *PasswordStrengthError
+			if errors.As(err, &strengthErr) != test.expectWeakErr {
+				t.Errorf("Unexpected error type %T", err)
+			}
+		})
+	}
+}
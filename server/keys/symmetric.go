@@ -6,26 +6,21 @@ package keys
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
 	"fmt"
 )
 
-// GenerateRandomBytes generates a slice of bytes of the given size that is
-// supposed to be used as a symmetric key.
-func GenerateRandomBytes(size int) ([]byte, error) {
-	b := make([]byte, size)
-	_, err := rand.Read(b)
-	if err != nil {
-		return nil, fmt.Errorf("keys: error reading random bytes: %w", err)
-	}
-	return b, nil
-}
-
 const (
 	aesGCMAlgo  = 1
 	rsaOAEPAlgo = 1
 )
 
+// SymmetricAlgoVersions and AsymmetricAlgoVersions list the algorithm
+// versions that can be used by ciphers created by clients.
+var (
+	SymmetricAlgoVersions  = []int{aesGCMAlgo}
+	AsymmetricAlgoVersions = []int{rsaOAEPAlgo}
+)
+
 // EncryptWith encrypts the given value symmetrically using the given key.
 // In case of success it also returns the unique nonce value that has been used
 // for encrypting the value and will be needed for clients that want to decrypt
@@ -65,5 +60,11 @@ func DecryptWith(key []byte, s string) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("keys: error unmarshaling cipher: %w", err)
 	}
+	if v.algoVersion != aesGCMAlgo {
+		return nil, fmt.Errorf("keys: unsupported algorithm version %d for symmetric decryption", v.algoVersion)
+	}
+	if len(v.nonce) != aesgcm.NonceSize() {
+		return nil, fmt.Errorf("keys: expected nonce of size %d, got %d", aesgcm.NonceSize(), len(v.nonce))
+	}
 	return aesgcm.Open(nil, v.nonce, v.cipher, nil)
 }
@@ -47,3 +47,20 @@ func TestSymmetricEncryption(t *testing.T) {
 		})
 	}
 }
+
+func TestDecryptWith_Malformed(t *testing.T) {
+	key, _ := GenerateRandomBytes(DefaultEncryptionKeySize)
+	for name, input := range map[string]string{
+		"missing nonce":   "{1,} YWJj",
+		"unknown algo":    "{9,} YWJj MDEyMzQ1Njc4OWFi",
+		"bad nonce size":  "{1,} YWJj eHl6",
+		"not a cipher":    "abc",
+		"trailing chunks": "{1,} YWJj MDEyMzQ1Njc4OWFi YWJj",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := DecryptWith(key, input); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
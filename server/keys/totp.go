@@ -0,0 +1,132 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPSecretLength is the number of bytes used for TOTP secrets as
+	// recommended by RFC 4226.
+	TOTPSecretLength = 20
+	// TOTPPeriod is the time step a single TOTP code is valid for.
+	TOTPPeriod = 30 * time.Second
+	// TOTPDigits is the number of digits of a TOTP code.
+	TOTPDigits = 6
+	// totpSkew is the number of time steps before and after the current one
+	// that are also accepted in order to account for clock drift.
+	totpSkew = 1
+)
+
+// GenerateTOTPSecret creates a new random secret that can be used for
+// deriving time based one time passwords.
+func GenerateTOTPSecret() ([]byte, error) {
+	secret, err := GenerateRandomBytes(TOTPSecretLength)
+	if err != nil {
+		return nil, fmt.Errorf("keys: error generating totp secret: %w", err)
+	}
+	return secret, nil
+}
+
+// TOTPCode returns the RFC 6238 code for the given secret at the given time.
+func TOTPCode(secret []byte, t time.Time) string {
+	return hotp(secret, uint64(t.Unix()/int64(TOTPPeriod/time.Second)))
+}
+
+// ValidateTOTP checks whether the given code is valid for the given secret at
+// the given time, allowing for a small amount of clock drift.
+func ValidateTOTP(secret []byte, code string, t time.Time) bool {
+	if len(code) != TOTPDigits {
+		return false
+	}
+	step := t.Unix() / int64(TOTPPeriod/time.Second)
+	var match int
+	for offset := -totpSkew; offset <= totpSkew; offset++ {
+		candidate := hotp(secret, uint64(step+int64(offset)))
+		match |= subtle.ConstantTimeCompare([]byte(candidate), []byte(code))
+	}
+	return match == 1
+}
+
+// TOTPSecretString returns the encoding of the given secret that is used by
+// authenticator apps for manual entry.
+func TOTPSecretString(secret []byte) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+}
+
+// TOTPURI returns an otpauth URI for the given secret that can be used for
+// enrolling an authenticator app, e.g. by rendering it as a QR code.
+func TOTPURI(issuer, accountName string, secret []byte) string {
+	v := url.Values{}
+	v.Set("secret", TOTPSecretString(secret))
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	v.Set("period", fmt.Sprintf("%d", int(TOTPPeriod/time.Second)))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + accountName,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// hotp implements the HMAC based one time password algorithm of RFC 4226.
+func hotp(secret []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	var mod uint32 = 1
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod)
+}
+
+// recoveryCodeEncoding is used for recovery codes. Lowercase characters are
+// easier to type and the alphabet does not contain ambiguous characters.
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// recoveryCodeLength is the number of characters of a recovery code,
+// excluding the separator.
+const recoveryCodeLength = 10
+
+// GenerateRecoveryCode creates a random single use code that can be used in
+// place of a TOTP code, e.g. in case the authenticator has been lost.
+func GenerateRecoveryCode() (string, error) {
+	b, err := GenerateRandomBytes(recoveryCodeLength * 5 / 8)
+	if err != nil {
+		return "", fmt.Errorf("keys: error generating recovery code: %w", err)
+	}
+	code := recoveryCodeEncoding.EncodeToString(b)
+	return code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:], nil
+}
+
+// NormalizeRecoveryCode removes separators and whitespace from the given
+// recovery code and reports whether the result looks like a recovery code.
+func NormalizeRecoveryCode(code string) (string, bool) {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(normalized) != recoveryCodeLength {
+		return "", false
+	}
+	if _, err := recoveryCodeEncoding.DecodeString(normalized); err != nil {
+		return "", false
+	}
+	return normalized, true
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// test vectors from RFC 6238, Appendix B, truncated to six digits
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		time         int64
+		expectedCode string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, test := range tests {
+		t.Run(test.expectedCode, func(t *testing.T) {
+			if code := TOTPCode(secret, time.Unix(test.time, 0)); code != test.expectedCode {
+				t.Errorf("Expected %v, got %v", test.expectedCode, code)
+			}
+		})
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111109, 0)
+	tests := []struct {
+		name           string
+		code           string
+		expectedResult bool
+	}{
+		{"current", "081804", true},
+		{"previous step", TOTPCode(secret, now.Add(-TOTPPeriod)), true},
+		{"next step", TOTPCode(secret, now.Add(TOTPPeriod)), true},
+		{"too old", TOTPCode(secret, now.Add(-3*TOTPPeriod)), false},
+		{"bad length", "81804", false},
+		{"bad code", "000000", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := ValidateTOTP(secret, test.code, now); result != test.expectedResult {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Offen", "develop@offen.dev", []byte("12345678901234567890"))
+	if !strings.HasPrefix(uri, "otpauth://totp/Offen:develop@offen.dev?") {
+		t.Errorf("Unexpected URI %v", uri)
+	}
+	if !strings.Contains(uri, "secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ") {
+		t.Errorf("Expected URI to contain encoded secret, got %v", uri)
+	}
+}
+
+func TestRecoveryCode(t *testing.T) {
+	code, err := GenerateRecoveryCode()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(code) != 11 || code[5] != '-' {
+		t.Errorf("Unexpected code format %v", code)
+	}
+	normalized, ok := NormalizeRecoveryCode(" " + strings.ToUpper(code))
+	if !ok {
+		t.Errorf("Expected %v to be normalized", code)
+	}
+	if normalized != strings.Replace(code, "-", "", 1) {
+		t.Errorf("Unexpected normalized code %v", normalized)
+	}
+	for _, invalid := range []string{"123456", "abcde-fghi1", ""} {
+		if _, ok := NormalizeRecoveryCode(invalid); ok {
+			t.Errorf("Expected %v to be rejected", invalid)
+		}
+	}
+}
@@ -9,10 +9,12 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
-	"strings"
 )
 
-var parseCipherRE = regexp.MustCompile(`^{(\d+?),(\d*?)}\s(.+)`)
+// parseCipherRE matches the serialization created by Marshal. Parsing is strict
+// so that truncated or otherwise mangled values are rejected early instead of
+// resulting in confusing errors when decrypting.
+var parseCipherRE = regexp.MustCompile(`^{(\d+),(\d*)(?:,([^}]*))?} ([A-Za-z0-9+/=]+)(?: ([A-Za-z0-9+/=]+))?$`)
 
 // VersionedCipher adds meta information to a ciphertext string.
 type VersionedCipher struct {
@@ -20,6 +22,7 @@ type VersionedCipher struct {
 	nonce       []byte
 	algoVersion int
 	keyVersion  int
+	params      string
 }
 
 func newVersionedCipher(cipher []byte, algoVersion int) *VersionedCipher {
@@ -40,6 +43,21 @@ func (v *VersionedCipher) addKeyVersion(k int) *VersionedCipher {
 	return v
 }
 
+func (v *VersionedCipher) addParams(p string) *VersionedCipher {
+	v.params = p
+	return v
+}
+
+// AlgoVersion returns the version of the algorithm used for creating v.
+func (v *VersionedCipher) AlgoVersion() int {
+	return v.algoVersion
+}
+
+// HasNonce returns whether v has been created using a nonce.
+func (v *VersionedCipher) HasNonce() bool {
+	return v.nonce != nil
+}
+
 // Marshal returns the string representation of v. It can be deserialized again
 // using unmarshalVersionedCipher.
 func (v *VersionedCipher) Marshal() string {
@@ -47,6 +65,9 @@ func (v *VersionedCipher) Marshal() string {
 	if v.keyVersion >= 0 {
 		keyRepr = fmt.Sprintf("%d", v.keyVersion)
 	}
+	if v.params != "" {
+		keyRepr = fmt.Sprintf("%s,%s", keyRepr, v.params)
+	}
 	base := fmt.Sprintf(
 		"{%d,%s} %s",
 		v.algoVersion,
@@ -59,9 +80,15 @@ func (v *VersionedCipher) Marshal() string {
 	return base
 }
 
+// ParseVersionedCipher parses the given serialized cipher without decrypting
+// it, which allows for checking its meta information.
+func ParseVersionedCipher(s string) (*VersionedCipher, error) {
+	return unmarshalVersionedCipher(s)
+}
+
 func unmarshalVersionedCipher(s string) (*VersionedCipher, error) {
 	parseResult := parseCipherRE.FindStringSubmatch(s)
-	if parseResult == nil || len(parseResult) != 4 {
+	if parseResult == nil || len(parseResult) != 6 {
 		return nil, errors.New("keys: could not parse given versioned cipher")
 	}
 
@@ -75,25 +102,23 @@ func unmarshalVersionedCipher(s string) (*VersionedCipher, error) {
 		var keyErr error
 		keyVersion, keyErr = strconv.Atoi(parseResult[2])
 		if keyErr != nil {
-			return nil, fmt.Errorf("keys: error parsing key version to number: %w", algoErr)
+			return nil, fmt.Errorf("keys: error parsing key version to number: %w", keyErr)
 		}
 	}
 
-	chunks := strings.Split(parseResult[3], " ")
-
-	b, decodeErr := base64.StdEncoding.DecodeString(chunks[0])
+	b, decodeErr := base64.StdEncoding.DecodeString(parseResult[4])
 	if decodeErr != nil {
 		return nil, fmt.Errorf("keys: error decoding ciphertext: %w", decodeErr)
 	}
 
 	v := &VersionedCipher{
-		cipher: b, algoVersion: algoVersion, keyVersion: keyVersion,
+		cipher: b, algoVersion: algoVersion, keyVersion: keyVersion, params: parseResult[3],
 	}
 
-	if len(chunks) > 1 {
-		n, decodeErr := base64.StdEncoding.DecodeString(chunks[1])
+	if parseResult[5] != "" {
+		n, decodeErr := base64.StdEncoding.DecodeString(parseResult[5])
 		if decodeErr != nil {
-			return nil, fmt.Errorf("keys: error decoding ciphertext: %w", decodeErr)
+			return nil, fmt.Errorf("keys: error decoding nonce: %w", decodeErr)
 		}
 		v.addNonce(n)
 	}
@@ -43,10 +43,21 @@ func TestNewVersionedCipher(t *testing.T) {
 			nil,
 			"{2,} YWJj eHl6",
 		},
+		{
+			"with params",
+			[]byte("abc"),
+			[]byte("xyz"),
+			3,
+			nil,
+			"{3,,m=8;t=1;p=1} YWJj eHl6",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			v := newVersionedCipher(test.cipher, test.algoVersion)
+			if test.algoVersion == 3 {
+				v.addParams("m=8;t=1;p=1")
+			}
 			if test.keyVersion != nil {
 				v.addKeyVersion(*test.keyVersion)
 			}
@@ -80,6 +91,30 @@ func TestParseVersionedCipher(t *testing.T) {
 			nil,
 			true,
 		},
+		{
+			"trailing chunk",
+			"{1,} YWJj eHl6 eHl6",
+			nil,
+			true,
+		},
+		{
+			"trailing whitespace",
+			"{1,} YWJj ",
+			nil,
+			true,
+		},
+		{
+			"bad ciphertext encoding",
+			"{1,} YW#j",
+			nil,
+			true,
+		},
+		{
+			"bad nonce encoding",
+			"{1,} YWJj eHl",
+			nil,
+			true,
+		},
 		{
 			"no key version",
 			"{1,} YWJj",
@@ -88,6 +123,7 @@ func TestParseVersionedCipher(t *testing.T) {
 				nil,
 				1,
 				-1,
+				"",
 			},
 			false,
 		},
@@ -99,6 +135,7 @@ func TestParseVersionedCipher(t *testing.T) {
 				nil,
 				4,
 				1,
+				"",
 			},
 			false,
 		},
@@ -110,6 +147,19 @@ func TestParseVersionedCipher(t *testing.T) {
 				[]byte("xyz"),
 				4,
 				1,
+				"",
+			},
+			false,
+		},
+		{
+			"with params",
+			"{3,,m=8;t=1;p=1} YWJj eHl6",
+			&VersionedCipher{
+				[]byte("abc"),
+				[]byte("xyz"),
+				3,
+				-1,
+				"m=8;t=1;p=1",
 			},
 			false,
 		},
@@ -117,7 +167,7 @@ func TestParseVersionedCipher(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			v, err := unmarshalVersionedCipher(test.input)
+			v, err := ParseVersionedCipher(test.input)
 
 			if (err != nil) != test.expectError {
 				t.Errorf("Unexpected error value %v", err)
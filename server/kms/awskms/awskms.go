@@ -0,0 +1,57 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package awskms
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	offenkms "github.com/offen/offen/server/kms"
+)
+
+// New creates a Provider that uses the AWS KMS master key of the given id or
+// ARN. Credentials are sourced from the default AWS credentials chain, i.e.
+// the environment, shared credentials files or an attached IAM role.
+func New(keyID, region string) (offenkms.Provider, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("awskms: error creating session: %w", err)
+	}
+	return &awsKMS{
+		client: kms.New(sess),
+		keyID:  keyID,
+	}, nil
+}
+
+type awsKMS struct {
+	client kmsiface.KMSAPI
+	keyID  string
+}
+
+func (a *awsKMS) Wrap(plaintext []byte) ([]byte, error) {
+	out, err := a.client.Encrypt(&kms.EncryptInput{
+		KeyId:     aws.String(a.keyID),
+		Plaintext: plaintext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("awskms: error wrapping value: %w", err)
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (a *awsKMS) Unwrap(ciphertext []byte) ([]byte, error) {
+	out, err := a.client.Decrypt(&kms.DecryptInput{
+		KeyId:          aws.String(a.keyID),
+		CiphertextBlob: ciphertext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("awskms: error unwrapping value: %w", err)
+	}
+	return out.Plaintext, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package awskms
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+)
+
+type mockKMSClient struct {
+	kmsiface.KMSAPI
+	err error
+}
+
+func (m *mockKMSClient) Encrypt(in *kms.EncryptInput) (*kms.EncryptOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &kms.EncryptOutput{
+		CiphertextBlob: append([]byte(*in.KeyId+":"), in.Plaintext...),
+	}, nil
+}
+
+func (m *mockKMSClient) Decrypt(in *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	prefix := []byte(*in.KeyId + ":")
+	return &kms.DecryptOutput{
+		Plaintext: in.CiphertextBlob[len(prefix):],
+	}, nil
+}
+
+func TestAWSKMS(t *testing.T) {
+	tests := []struct {
+		name        string
+		client      *mockKMSClient
+		expectError bool
+	}{
+		{
+			"ok",
+			&mockKMSClient{},
+			false,
+		},
+		{
+			"client error",
+			&mockKMSClient{err: errors.New("did not work")},
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &awsKMS{client: test.client, keyID: "key-a"}
+			wrapped, err := a.Wrap([]byte("value"))
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if test.expectError {
+				return
+			}
+			if !reflect.DeepEqual([]byte("key-a:value"), wrapped) {
+				t.Errorf("Unexpected wrapped value %s", wrapped)
+			}
+			unwrapped, err := a.Unwrap(wrapped)
+			if err != nil {
+				t.Errorf("Unexpected error %v", err)
+			}
+			if !reflect.DeepEqual([]byte("value"), unwrapped) {
+				t.Errorf("Unexpected unwrapped value %s", unwrapped)
+			}
+		})
+	}
+}
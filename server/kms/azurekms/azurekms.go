@@ -0,0 +1,146 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package azurekms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/offen/offen/server/keys"
+	"github.com/offen/offen/server/kms"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	apiVersion     = "7.0"
+	wrapAlgorithm  = "RSA-OAEP-256"
+	keyVaultScope  = "https://vault.azure.net/.default"
+	tokenURLFormat = "https://login.microsoftonline.com/%s/oauth2/v2.0/token"
+)
+
+// New creates a Provider that uses the RSA key of the given name that is
+// stored in the Key Vault at the given URL. The service principal identified
+// by the given tenant, client id and secret is used for authentication.
+// As RSA-OAEP can only wrap a few hundred bytes, values are encrypted using a
+// random data key that is wrapped in Key Vault instead.
+func New(vaultURL, keyName, tenantID, clientID, clientSecret string) kms.Provider {
+	conf := clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     fmt.Sprintf(tokenURLFormat, tenantID),
+		Scopes:       []string{keyVaultScope},
+	}
+	return &azureKMS{
+		client:   conf.Client(context.Background()),
+		vaultURL: strings.TrimSuffix(vaultURL, "/"),
+		keyName:  keyName,
+	}
+}
+
+type azureKMS struct {
+	client   *http.Client
+	vaultURL string
+	keyName  string
+}
+
+type keyOperation struct {
+	KeyID string `json:"kid,omitempty"`
+	Alg   string `json:"alg,omitempty"`
+	Value string `json:"value"`
+}
+
+func (a *azureKMS) call(url string, payload keyOperation) (keyOperation, error) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(payload); err != nil {
+		return keyOperation{}, fmt.Errorf("azurekms: error encoding payload: %w", err)
+	}
+	res, err := a.client.Post(
+		fmt.Sprintf("%s?api-version=%s", url, apiVersion),
+		"application/json",
+		&body,
+	)
+	if err != nil {
+		return keyOperation{}, fmt.Errorf("azurekms: error performing request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return keyOperation{}, fmt.Errorf("azurekms: unexpected status code %d", res.StatusCode)
+	}
+	var result keyOperation
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return keyOperation{}, fmt.Errorf("azurekms: error decoding response: %w", err)
+	}
+	return result, nil
+}
+
+// Wrap encrypts the given value using a random data key that is wrapped
+// using the latest version of the key. The versioned key identifier is
+// prepended to the result so values can still be unwrapped after the key has
+// been rotated in Key Vault.
+func (a *azureKMS) Wrap(plaintext []byte) ([]byte, error) {
+	dataKey, err := keys.GenerateRandomBytes(keys.DefaultEncryptionKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("azurekms: error creating data key: %w", err)
+	}
+	result, err := a.call(
+		fmt.Sprintf("%s/keys/%s/wrapkey", a.vaultURL, a.keyName),
+		keyOperation{Alg: wrapAlgorithm, Value: base64.RawURLEncoding.EncodeToString(dataKey)},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("azurekms: error wrapping data key: %w", err)
+	}
+	if !a.ownsKey(result.KeyID) {
+		return nil, fmt.Errorf("azurekms: key vault responded using unexpected key %s", result.KeyID)
+	}
+	encrypted, err := keys.EncryptWith(dataKey, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("azurekms: error encrypting value: %w", err)
+	}
+	return []byte(fmt.Sprintf("%s %s %s", result.KeyID, result.Value, encrypted.Marshal())), nil
+}
+
+// ownsKey checks whether the given key identifier refers to a version of the
+// configured key. Wrapped values are read from the database, so the key
+// identifier they contain must not be able to direct requests carrying
+// the access token elsewhere.
+func (a *azureKMS) ownsKey(keyID string) bool {
+	return strings.HasPrefix(keyID, fmt.Sprintf("%s/keys/%s/", a.vaultURL, a.keyName))
+}
+
+func (a *azureKMS) Unwrap(ciphertext []byte) ([]byte, error) {
+	// the encrypted value itself contains spaces, so splitting stops after
+	// the key identifier and the wrapped data key. Values wrapped before
+	// envelope encryption has been used consist of these two only.
+	chunks := strings.SplitN(string(ciphertext), " ", 3)
+	if len(chunks) < 2 {
+		return nil, fmt.Errorf("azurekms: unexpected format of wrapped value")
+	}
+	if !a.ownsKey(chunks[0]) {
+		return nil, fmt.Errorf("azurekms: value has been wrapped using unknown key %s", chunks[0])
+	}
+	result, err := a.call(
+		fmt.Sprintf("%s/unwrapkey", chunks[0]),
+		keyOperation{Alg: wrapAlgorithm, Value: chunks[1]},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("azurekms: error unwrapping value: %w", err)
+	}
+	unwrapped, err := base64.RawURLEncoding.DecodeString(result.Value)
+	if err != nil {
+		return nil, fmt.Errorf("azurekms: error decoding unwrapped value: %w", err)
+	}
+	if len(chunks) == 2 {
+		return unwrapped, nil
+	}
+	plaintext, err := keys.DecryptWith(unwrapped, chunks[2])
+	if err != nil {
+		return nil, fmt.Errorf("azurekms: error decrypting value: %w", err)
+	}
+	return plaintext, nil
+}
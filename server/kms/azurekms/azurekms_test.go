@@ -0,0 +1,79 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package azurekms
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAzureKMS(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api-version") != apiVersion {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var payload keyOperation
+		json.NewDecoder(r.Body).Decode(&payload)
+		switch r.URL.Path {
+		case "/keys/offen/wrapkey":
+			json.NewEncoder(w).Encode(keyOperation{
+				KeyID: srv.URL + "/keys/offen/v1",
+				Value: "wrapped-" + payload.Value,
+			})
+		case "/keys/offen/v1/unwrapkey":
+			json.NewEncoder(w).Encode(keyOperation{
+				KeyID: srv.URL + "/keys/offen/v1",
+				Value: strings.TrimPrefix(payload.Value, "wrapped-"),
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	a := &azureKMS{client: srv.Client(), vaultURL: srv.URL, keyName: "offen"}
+	// values exceeding what RSA-OAEP-256 can wrap using a 2048 bit key
+	value := []byte(strings.Repeat("value", 100))
+	wrapped, err := a.Wrap(value)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !strings.HasPrefix(string(wrapped), srv.URL+"/keys/offen/v1 ") {
+		t.Errorf("Expected key id to be prepended, got %s", wrapped)
+	}
+	unwrapped, err := a.Unwrap(wrapped)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if string(unwrapped) != string(value) {
+		t.Errorf("Unexpected unwrapped value %s", unwrapped)
+	}
+
+	legacy := srv.URL + "/keys/offen/v1 wrapped-" + base64.RawURLEncoding.EncodeToString([]byte("value"))
+	if unwrapped, err := a.Unwrap([]byte(legacy)); err != nil || string(unwrapped) != "value" {
+		t.Errorf("Unexpected result unwrapping legacy value %s, %v", unwrapped, err)
+	}
+
+	if _, err := a.Unwrap([]byte("malformed")); err == nil {
+		t.Error("Expected error unwrapping malformed value")
+	}
+	foreign := strings.Replace(string(wrapped), srv.URL, "https://attacker.example", 1)
+	if _, err := a.Unwrap([]byte(foreign)); err == nil {
+		t.Error("Expected error unwrapping value using a foreign key vault")
+	}
+	otherKey := strings.Replace(string(wrapped), "/keys/offen/", "/keys/other/", 1)
+	if _, err := a.Unwrap([]byte(otherKey)); err == nil {
+		t.Error("Expected error unwrapping value using another key")
+	}
+	a.keyName = "other"
+	if _, err := a.Wrap([]byte("value")); err == nil {
+		t.Error("Expected error using unknown key")
+	}
+}
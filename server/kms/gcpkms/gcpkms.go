@@ -0,0 +1,87 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package gcpkms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/offen/offen/server/kms"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	defaultEndpoint = "https://cloudkms.googleapis.com"
+	cloudKMSScope   = "https://www.googleapis.com/auth/cloudkms"
+)
+
+// New creates a Provider that uses the Cloud KMS key of the given resource
+// name, i.e. projects/*/locations/*/keyRings/*/cryptoKeys/*. Credentials are
+// sourced using Google's Application Default Credentials.
+func New(keyName string) (kms.Provider, error) {
+	client, err := google.DefaultClient(context.Background(), cloudKMSScope)
+	if err != nil {
+		return nil, fmt.Errorf("gcpkms: error creating client: %w", err)
+	}
+	return &gcpKMS{
+		client:   client,
+		endpoint: defaultEndpoint,
+		keyName:  keyName,
+	}, nil
+}
+
+type gcpKMS struct {
+	client   *http.Client
+	endpoint string
+	keyName  string
+}
+
+func (g *gcpKMS) call(method string, payload, result interface{}) error {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(payload); err != nil {
+		return fmt.Errorf("gcpkms: error encoding payload: %w", err)
+	}
+	res, err := g.client.Post(
+		fmt.Sprintf("%s/v1/%s:%s", g.endpoint, g.keyName, method),
+		"application/json",
+		&body,
+	)
+	if err != nil {
+		return fmt.Errorf("gcpkms: error performing request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("gcpkms: unexpected status code %d calling %s", res.StatusCode, method)
+	}
+	if err := json.NewDecoder(res.Body).Decode(result); err != nil {
+		return fmt.Errorf("gcpkms: error decoding response: %w", err)
+	}
+	return nil
+}
+
+func (g *gcpKMS) Wrap(plaintext []byte) ([]byte, error) {
+	var result struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	if err := g.call("encrypt", map[string][]byte{"plaintext": plaintext}, &result); err != nil {
+		return nil, fmt.Errorf("gcpkms: error wrapping value: %w", err)
+	}
+	return result.Ciphertext, nil
+}
+
+func (g *gcpKMS) Unwrap(ciphertext []byte) ([]byte, error) {
+	var result struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	if err := g.call("decrypt", map[string]string{
+		"ciphertext": base64.StdEncoding.EncodeToString(ciphertext),
+	}, &result); err != nil {
+		return nil, fmt.Errorf("gcpkms: error unwrapping value: %w", err)
+	}
+	return result.Plaintext, nil
+}
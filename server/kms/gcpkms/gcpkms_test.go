@@ -0,0 +1,56 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package gcpkms
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGCPKMS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string][]byte
+		json.NewDecoder(r.Body).Decode(&payload)
+		switch r.URL.Path {
+		case "/v1/projects/p/locations/l/keyRings/r/cryptoKeys/k:encrypt":
+			json.NewEncoder(w).Encode(map[string][]byte{
+				"ciphertext": append([]byte("wrapped-"), payload["plaintext"]...),
+			})
+		case "/v1/projects/p/locations/l/keyRings/r/cryptoKeys/k:decrypt":
+			json.NewEncoder(w).Encode(map[string][]byte{
+				"plaintext": payload["ciphertext"][len("wrapped-"):],
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	g := &gcpKMS{
+		client:   srv.Client(),
+		endpoint: srv.URL,
+		keyName:  "projects/p/locations/l/keyRings/r/cryptoKeys/k",
+	}
+	wrapped, err := g.Wrap([]byte("value"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if string(wrapped) != "wrapped-value" {
+		t.Errorf("Unexpected wrapped value %s", wrapped)
+	}
+	unwrapped, err := g.Unwrap(wrapped)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if string(unwrapped) != "value" {
+		t.Errorf("Unexpected unwrapped value %s", unwrapped)
+	}
+
+	g.keyName = "projects/p/locations/l/keyRings/r/cryptoKeys/other"
+	if _, err := g.Wrap([]byte("value")); err == nil {
+		t.Error("Expected error using unknown key")
+	}
+}
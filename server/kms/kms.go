@@ -0,0 +1,11 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package kms
+
+// Provider wraps and unwraps key material using a master key that is managed
+// by an external key management service and never leaves it.
+type Provider interface {
+	Wrap(plaintext []byte) ([]byte, error)
+	Unwrap(ciphertext []byte) ([]byte, error)
+}
@@ -0,0 +1,39 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package localkms
+
+import (
+	"fmt"
+
+	"github.com/offen/offen/server/keys"
+	"github.com/offen/offen/server/kms"
+)
+
+// New creates a Provider that wraps values using AES-GCM and the given master
+// key. It is supposed to be used in setups where no external key management
+// service is available but the master key can be stored apart from the
+// database.
+func New(key []byte) kms.Provider {
+	return &localKMS{key: key}
+}
+
+type localKMS struct {
+	key []byte
+}
+
+func (l *localKMS) Wrap(plaintext []byte) ([]byte, error) {
+	cipher, err := keys.EncryptWith(l.key, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("localkms: error wrapping value: %w", err)
+	}
+	return []byte(cipher.Marshal()), nil
+}
+
+func (l *localKMS) Unwrap(ciphertext []byte) ([]byte, error) {
+	plaintext, err := keys.DecryptWith(l.key, string(ciphertext))
+	if err != nil {
+		return nil, fmt.Errorf("localkms: error unwrapping value: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,31 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package localkms
+
+import (
+	"testing"
+)
+
+func TestLocalKMS(t *testing.T) {
+	provider := New([]byte("0123456789abcdef0123456789abcdef"))
+	wrapped, err := provider.Wrap([]byte("value"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	unwrapped, err := provider.Unwrap(wrapped)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if string(unwrapped) != "value" {
+		t.Errorf("Unexpected value %s", unwrapped)
+	}
+
+	other := New([]byte("abcdef0123456789abcdef0123456789"))
+	if _, err := other.Unwrap(wrapped); err == nil {
+		t.Error("Expected error unwrapping with different key")
+	}
+	if _, err := New([]byte("short")).Wrap([]byte("value")); err == nil {
+		t.Error("Expected error wrapping with invalid key")
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package vaultkms
+
+import (
+	"fmt"
+
+	"github.com/offen/offen/server/kms"
+	"github.com/offen/offen/server/vault"
+)
+
+// New creates a Provider that uses the transit secrets engine key of the
+// given name for wrapping values.
+func New(client *vault.Client, keyName string) kms.Provider {
+	return &vaultKMS{client: client, keyName: keyName}
+}
+
+type vaultKMS struct {
+	client  *vault.Client
+	keyName string
+}
+
+func (v *vaultKMS) Wrap(plaintext []byte) ([]byte, error) {
+	ciphertext, err := v.client.Encrypt(v.keyName, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("vaultkms: error wrapping value: %w", err)
+	}
+	return []byte(ciphertext), nil
+}
+
+func (v *vaultKMS) Unwrap(ciphertext []byte) ([]byte, error) {
+	plaintext, err := v.client.Decrypt(v.keyName, string(ciphertext))
+	if err != nil {
+		return nil, fmt.Errorf("vaultkms: error unwrapping value: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Identifiers of the BER encoded elements used by the protocol. Only the
+// subset needed for binding and searching is supported.
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+
+	tagBindRequest      = 0x60
+	tagBindResponse     = 0x61
+	tagUnbindRequest    = 0x42
+	tagSearchRequest    = 0x63
+	tagSearchEntry      = 0x64
+	tagSearchDone       = 0x65
+	tagSearchReference  = 0x73
+	tagExtendedRequest  = 0x77
+	tagExtendedResponse = 0x78
+
+	tagSimpleAuthentication = 0x80
+	tagExtendedRequestName  = 0x80
+	tagFilterAnd            = 0xa0
+	tagFilterEquality       = 0xa3
+)
+
+// maxPacketSize limits the size of responses so a misbehaving server cannot
+// exhaust memory.
+const maxPacketSize = 1 << 20
+
+// packet is a decoded BER element. Children are only populated for
+// constructed elements.
+type packet struct {
+	tag      byte
+	value    []byte
+	children []*packet
+}
+
+func (p *packet) constructed() bool {
+	return p.tag&0x20 != 0
+}
+
+func (p *packet) int() (int, error) {
+	if len(p.value) == 0 || len(p.value) > 4 {
+		return 0, fmt.Errorf("ldap: invalid integer of length %d", len(p.value))
+	}
+	n := int(int8(p.value[0]))
+	for _, b := range p.value[1:] {
+		n = n<<8 | int(b)
+	}
+	return n, nil
+}
+
+// readPacket reads and decodes the next element from the given reader.
+func readPacket(r io.Reader) (*packet, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[0]&0x1f == 0x1f {
+		return nil, errors.New("ldap: multi byte tags are not supported")
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 {
+			return nil, errors.New("ldap: indefinite lengths are not supported")
+		}
+		if n > 4 {
+			return nil, fmt.Errorf("ldap: length of %d bytes is not supported", n)
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		length = 0
+		for _, c := range b {
+			length = length<<8 | int(c)
+		}
+	}
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("ldap: packet of %d bytes exceeds maximum size", length)
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return nil, err
+	}
+	return decodePacket(header[0], value)
+}
+
+func decodePacket(tag byte, value []byte) (*packet, error) {
+	p := &packet{tag: tag, value: value}
+	if !p.constructed() {
+		return p, nil
+	}
+	for len(value) != 0 {
+		child, rest, err := splitPacket(value)
+		if err != nil {
+			return nil, err
+		}
+		p.children = append(p.children, child)
+		value = rest
+	}
+	return p, nil
+}
+
+// splitPacket decodes the first element of b and returns the remainder.
+func splitPacket(b []byte) (*packet, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, errors.New("ldap: truncated packet")
+	}
+	if b[0]&0x1f == 0x1f {
+		return nil, nil, errors.New("ldap: multi byte tags are not supported")
+	}
+	tag, length, offset := b[0], int(b[1]), 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(b) < 2+n {
+			return nil, nil, errors.New("ldap: invalid length")
+		}
+		length = 0
+		for _, c := range b[2 : 2+n] {
+			length = length<<8 | int(c)
+		}
+		offset += n
+	}
+	if length < 0 || len(b)-offset < length {
+		return nil, nil, errors.New("ldap: truncated packet")
+	}
+	p, err := decodePacket(tag, b[offset:offset+length])
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, b[offset+length:], nil
+}
+
+// encode returns the BER encoding of an element with the given tag and
+// content.
+func encode(tag byte, content ...[]byte) []byte {
+	var value []byte
+	for _, c := range content {
+		value = append(value, c...)
+	}
+	var length []byte
+	switch l := len(value); {
+	case l < 0x80:
+		length = []byte{byte(l)}
+	default:
+		for ; l > 0; l >>= 8 {
+			length = append([]byte{byte(l)}, length...)
+		}
+		length = append([]byte{0x80 | byte(len(length))}, length...)
+	}
+	result := append([]byte{tag}, length...)
+	return append(result, value...)
+}
+
+func encodeInt(tag byte, n int) []byte {
+	b := []byte{byte(n)}
+	for n >>= 8; n != 0 && n != -1; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	// a leading bit that does not match the sign requires padding
+	if n == 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return encode(tag, b)
+}
+
+func encodeString(tag byte, s string) []byte {
+	return encode(tag, []byte(s))
+}
+
+func encodeBool(b bool) []byte {
+	if b {
+		return encode(tagBoolean, []byte{0xff})
+	}
+	return encode(tagBoolean, []byte{0x00})
+}
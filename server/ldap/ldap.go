@@ -0,0 +1,384 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ldap verifies passwords of account users against an LDAP directory
+// or Active Directory by performing a simple bind using the given
+// credentials. The distinguished name of a user is either derived from a
+// template or looked up using a search.
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LDAP result codes that are handled explicitly
+const (
+	resultSuccess            = 0
+	resultSizeLimitExceeded  = 4
+	resultInvalidCredentials = 49
+)
+
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// Authenticator performs simple binds against a directory server.
+type Authenticator struct {
+	addr            string
+	useTLS          bool
+	startTLS        bool
+	tlsConfig       *tls.Config
+	bindDN          string
+	searchBaseDN    string
+	searchAttribute string
+	searchBindDN    string
+	searchPassword  string
+	timeout         time.Duration
+}
+
+// Option is used to configure an Authenticator.
+type Option func(*Authenticator)
+
+// WithBindDN sets a template for deriving the distinguished name of a user
+// from their email address, e.g. `uid=%s,ou=people,dc=example,dc=com`. The
+// email address is escaped before being inserted. Active Directory also
+// accepts the user principal name, in which case the template is `%s`.
+func WithBindDN(template string) Option {
+	return func(a *Authenticator) {
+		a.bindDN = template
+	}
+}
+
+// WithSearch looks up the distinguished name of a user by searching below
+// the given base for an entry whose attribute matches the email address.
+// The search is performed after binding with the given service credentials.
+// Leaving the bind DN empty performs the search anonymously.
+func WithSearch(baseDN, attribute, bindDN, password string) Option {
+	return func(a *Authenticator) {
+		a.searchBaseDN = baseDN
+		a.searchAttribute = attribute
+		a.searchBindDN = bindDN
+		a.searchPassword = password
+	}
+}
+
+// WithStartTLS upgrades plain connections using the StartTLS operation
+// before sending any credentials.
+func WithStartTLS() Option {
+	return func(a *Authenticator) {
+		a.startTLS = true
+	}
+}
+
+// WithTLSConfig sets the configuration used for TLS connections.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(a *Authenticator) {
+		a.tlsConfig = config
+	}
+}
+
+// WithTimeout sets the deadline for each authentication.
+func WithTimeout(timeout time.Duration) Option {
+	return func(a *Authenticator) {
+		a.timeout = timeout
+	}
+}
+
+// New creates an Authenticator for the server at the given URL. Both
+// `ldap://` and `ldaps://` URLs are supported.
+func New(rawURL string, opts ...Option) (*Authenticator, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: error parsing url: %w", err)
+	}
+	a := &Authenticator{timeout: 10 * time.Second}
+	var port string
+	switch u.Scheme {
+	case "ldap":
+		port = "389"
+	case "ldaps":
+		port = "636"
+		a.useTLS = true
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme %q", u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	a.addr = net.JoinHostPort(u.Hostname(), port)
+	a.tlsConfig = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.bindDN == "" && a.searchBaseDN == "" {
+		return nil, errors.New("ldap: either a bind dn template or a search base needs to be given")
+	}
+	if a.useTLS && a.startTLS {
+		return nil, errors.New("ldap: starttls cannot be used with ldaps")
+	}
+	return a, nil
+}
+
+// Authenticate checks whether the directory accepts the given password for
+// the user with the given email address. Unknown users and wrong passwords
+// are reported as false, other failures return an error.
+func (a *Authenticator) Authenticate(email, password string) (bool, error) {
+	// an empty password would result in an unauthenticated bind, which most
+	// servers report as successful
+	if email == "" || password == "" {
+		return false, nil
+	}
+
+	c, err := a.connect()
+	if err != nil {
+		return false, err
+	}
+	defer c.close()
+
+	var dn string
+	if a.searchBaseDN != "" {
+		if a.searchBindDN != "" {
+			ok, err := c.bind(a.searchBindDN, a.searchPassword)
+			if err != nil {
+				return false, fmt.Errorf("ldap: error binding service user: %w", err)
+			}
+			if !ok {
+				return false, errors.New("ldap: service user credentials were rejected")
+			}
+		}
+		dns, err := c.search(a.searchBaseDN, a.searchAttribute, email)
+		if err != nil {
+			return false, fmt.Errorf("ldap: error searching for user: %w", err)
+		}
+		switch len(dns) {
+		case 0:
+			return false, nil
+		case 1:
+			dn = dns[0]
+		default:
+			return false, fmt.Errorf("ldap: found %d entries matching %s", len(dns), email)
+		}
+	} else {
+		dn = strings.Replace(a.bindDN, "%s", EscapeDN(email), -1)
+	}
+
+	ok, err := c.bind(dn, password)
+	if err != nil {
+		return false, fmt.Errorf("ldap: error binding user: %w", err)
+	}
+	return ok, nil
+}
+
+func (a *Authenticator) connect() (*conn, error) {
+	dialer := &net.Dialer{Timeout: a.timeout}
+	var nc net.Conn
+	var err error
+	if a.useTLS {
+		nc, err = tls.DialWithDialer(dialer, "tcp", a.addr, a.tlsConfig)
+	} else {
+		nc, err = dialer.Dial("tcp", a.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap: error connecting to server: %w", err)
+	}
+	nc.SetDeadline(time.Now().Add(a.timeout))
+	c := newConn(nc)
+	if a.startTLS {
+		if err := c.startTLS(a.tlsConfig); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+type conn struct {
+	nc        net.Conn
+	r         *bufio.Reader
+	messageID int
+}
+
+func newConn(nc net.Conn) *conn {
+	return &conn{nc: nc, r: bufio.NewReader(nc)}
+}
+
+func (c *conn) close() {
+	c.send(encode(tagUnbindRequest))
+	c.nc.Close()
+}
+
+// send wraps the given operation in a message and writes it to the
+// connection.
+func (c *conn) send(op []byte) (int, error) {
+	c.messageID++
+	if _, err := c.nc.Write(encode(tagSequence, encodeInt(tagInteger, c.messageID), op)); err != nil {
+		return 0, fmt.Errorf("ldap: error writing message: %w", err)
+	}
+	return c.messageID, nil
+}
+
+// receive reads the next message for the given id and returns its
+// protocol operation.
+func (c *conn) receive(id int) (*packet, error) {
+	for {
+		p, err := readPacket(c.r)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: error reading message: %w", err)
+		}
+		if p.tag != tagSequence || len(p.children) < 2 {
+			return nil, errors.New("ldap: malformed message")
+		}
+		messageID, err := p.children[0].int()
+		if err != nil {
+			return nil, err
+		}
+		// unsolicited notifications use an id of 0 and indicate that the
+		// server is about to close the connection
+		if messageID == 0 {
+			return nil, errors.New("ldap: connection has been closed by server")
+		}
+		if messageID != id {
+			continue
+		}
+		return p.children[1], nil
+	}
+}
+
+// result decodes an LDAPResult of the given type.
+func result(p *packet, tag byte) (int, string, error) {
+	if p.tag != tag || len(p.children) < 3 || p.children[0].tag != tagEnumerated {
+		return 0, "", fmt.Errorf("ldap: unexpected response of type %#x", p.tag)
+	}
+	code, err := p.children[0].int()
+	if err != nil {
+		return 0, "", err
+	}
+	return code, string(p.children[2].value), nil
+}
+
+func (c *conn) startTLS(config *tls.Config) error {
+	id, err := c.send(encode(tagExtendedRequest, encodeString(tagExtendedRequestName, startTLSOID)))
+	if err != nil {
+		return err
+	}
+	p, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	code, message, err := result(p, tagExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if code != resultSuccess {
+		return fmt.Errorf("ldap: starttls failed with code %d: %s", code, message)
+	}
+	tlsConn := tls.Client(c.nc, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("ldap: error performing tls handshake: %w", err)
+	}
+	c.nc = tlsConn
+	c.r = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// bind performs a simple bind and reports whether the credentials have been
+// accepted.
+func (c *conn) bind(dn, password string) (bool, error) {
+	id, err := c.send(encode(
+		tagBindRequest,
+		encodeInt(tagInteger, 3),
+		encodeString(tagOctetString, dn),
+		encodeString(tagSimpleAuthentication, password),
+	))
+	if err != nil {
+		return false, err
+	}
+	p, err := c.receive(id)
+	if err != nil {
+		return false, err
+	}
+	code, message, err := result(p, tagBindResponse)
+	if err != nil {
+		return false, err
+	}
+	switch code {
+	case resultSuccess:
+		return true, nil
+	case resultInvalidCredentials:
+		return false, nil
+	default:
+		return false, fmt.Errorf("ldap: bind failed with code %d: %s", code, message)
+	}
+}
+
+// search returns the distinguished names of all entries below baseDN whose
+// attribute equals the given value. At most two entries are requested as
+// callers are only interested in unique matches.
+func (c *conn) search(baseDN, attribute, value string) ([]string, error) {
+	id, err := c.send(encode(
+		tagSearchRequest,
+		encodeString(tagOctetString, baseDN),
+		encodeInt(tagEnumerated, 2), // wholeSubtree
+		encodeInt(tagEnumerated, 0), // neverDerefAliases
+		encodeInt(tagInteger, 2),
+		encodeInt(tagInteger, 0),
+		encodeBool(false),
+		encode(tagFilterEquality, encodeString(tagOctetString, attribute), encodeString(tagOctetString, value)),
+		// no attributes are needed
+		encode(tagSequence, encodeString(tagOctetString, "1.1")),
+	))
+	if err != nil {
+		return nil, err
+	}
+	var dns []string
+	for {
+		p, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch p.tag {
+		case tagSearchEntry:
+			if len(p.children) == 0 {
+				return nil, errors.New("ldap: malformed search entry")
+			}
+			dns = append(dns, string(p.children[0].value))
+		case tagSearchReference:
+			// referrals to other servers are not followed
+		default:
+			code, message, err := result(p, tagSearchDone)
+			if err != nil {
+				return nil, err
+			}
+			// a size limit being exceeded still means the result is ambiguous
+			if code != resultSuccess && code != resultSizeLimitExceeded {
+				return nil, fmt.Errorf("ldap: search failed with code %d: %s", code, message)
+			}
+			return dns, nil
+		}
+	}
+}
+
+// EscapeDN escapes the given value for use as an attribute value in a
+// distinguished name as described in RFC 4514.
+func EscapeDN(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			i == 0 && (r == ' ' || r == '#'),
+			i == len(value)-1 && r == ' ':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
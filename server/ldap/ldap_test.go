@@ -0,0 +1,178 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ldap
+
+import (
+	"bufio"
+	"net"
+	"testing"
+)
+
+type mockDirectory struct {
+	entries map[string]string
+}
+
+// serve answers bind and search requests on the given listener using the
+// entries of the directory, which map distinguished names to passwords and
+// use the email address as their `mail` attribute.
+func (m *mockDirectory) serve(l net.Listener, mails map[string]string) {
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(nc net.Conn) {
+			defer nc.Close()
+			r := bufio.NewReader(nc)
+			for {
+				p, err := readPacket(r)
+				if err != nil {
+					return
+				}
+				id, _ := p.children[0].int()
+				op := p.children[1]
+				reply := func(ops ...[]byte) {
+					for _, op := range ops {
+						nc.Write(encode(tagSequence, encodeInt(tagInteger, id), op))
+					}
+				}
+				ldapResult := func(tag byte, code int) []byte {
+					return encode(tag, encodeInt(tagEnumerated, code), encodeString(tagOctetString, ""), encodeString(tagOctetString, ""))
+				}
+				switch op.tag {
+				case tagBindRequest:
+					dn, password := string(op.children[1].value), string(op.children[2].value)
+					if expected, ok := m.entries[dn]; ok && expected == password {
+						reply(ldapResult(tagBindResponse, resultSuccess))
+					} else {
+						reply(ldapResult(tagBindResponse, resultInvalidCredentials))
+					}
+				case tagSearchRequest:
+					value := string(op.children[6].children[1].value)
+					var ops [][]byte
+					for dn, mail := range mails {
+						if mail == value {
+							ops = append(ops, encode(tagSearchEntry, encodeString(tagOctetString, dn), encode(tagSequence)))
+						}
+					}
+					reply(append(ops, ldapResult(tagSearchDone, resultSuccess))...)
+				case tagUnbindRequest:
+					return
+				}
+			}
+		}(nc)
+	}
+}
+
+func TestAuthenticator_Authenticate(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer l.Close()
+
+	directory := &mockDirectory{
+		entries: map[string]string{
+			"uid=develop@offen.dev,ou=people,dc=offen,dc=dev": "secret",
+			"cn=develop,ou=people,dc=offen,dc=dev":            "other-secret",
+			"cn=offen,dc=offen,dc=dev":                        "service",
+			"cn=a,ou=people,dc=offen,dc=dev":                  "a",
+			"cn=b,ou=people,dc=offen,dc=dev":                  "b",
+		},
+	}
+	go directory.serve(l, map[string]string{
+		"cn=develop,ou=people,dc=offen,dc=dev": "develop@offen.dev",
+		"cn=a,ou=people,dc=offen,dc=dev":       "shared@offen.dev",
+		"cn=b,ou=people,dc=offen,dc=dev":       "shared@offen.dev",
+	})
+	serverURL := "ldap://" + l.Addr().String()
+
+	tests := []struct {
+		name           string
+		options        []Option
+		email          string
+		password       string
+		expectedResult bool
+		expectError    bool
+	}{
+		{"template ok", []Option{WithBindDN("uid=%s,ou=people,dc=offen,dc=dev")}, "develop@offen.dev", "secret", true, false},
+		{"template bad password", []Option{WithBindDN("uid=%s,ou=people,dc=offen,dc=dev")}, "develop@offen.dev", "other-secret", false, false},
+		{"template empty password", []Option{WithBindDN("uid=%s,ou=people,dc=offen,dc=dev")}, "develop@offen.dev", "", false, false},
+		{"template injection", []Option{WithBindDN("uid=%s,ou=people,dc=offen,dc=dev")}, "x,cn=develop", "other-secret", false, false},
+		{"search ok", []Option{WithSearch("dc=offen,dc=dev", "mail", "cn=offen,dc=offen,dc=dev", "service")}, "develop@offen.dev", "other-secret", true, false},
+		{"search bad password", []Option{WithSearch("dc=offen,dc=dev", "mail", "cn=offen,dc=offen,dc=dev", "service")}, "develop@offen.dev", "secret", false, false},
+		{"search unknown user", []Option{WithSearch("dc=offen,dc=dev", "mail", "cn=offen,dc=offen,dc=dev", "service")}, "other@offen.dev", "secret", false, false},
+		{"search ambiguous", []Option{WithSearch("dc=offen,dc=dev", "mail", "cn=offen,dc=offen,dc=dev", "service")}, "shared@offen.dev", "a", false, true},
+		{"search bad service credentials", []Option{WithSearch("dc=offen,dc=dev", "mail", "cn=offen,dc=offen,dc=dev", "nope")}, "develop@offen.dev", "other-secret", false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := New(serverURL, test.options...)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			result, err := a.Authenticate(test.email, test.password)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if result != test.expectedResult {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		options     []Option
+		expectError bool
+	}{
+		{"ldap", "ldap://ldap.offen.dev", []Option{WithBindDN("%s")}, false},
+		{"ldaps", "ldaps://ldap.offen.dev:1636", []Option{WithBindDN("%s")}, false},
+		{"bad scheme", "http://ldap.offen.dev", []Option{WithBindDN("%s")}, true},
+		{"no dn", "ldap://ldap.offen.dev", nil, true},
+		{"ldaps with starttls", "ldaps://ldap.offen.dev", []Option{WithBindDN("%s"), WithStartTLS()}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New(test.url, test.options...)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+		})
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	tests := []struct {
+		value          string
+		expectedResult string
+	}{
+		{"develop@offen.dev", "develop@offen.dev"},
+		{"a,cn=admin", `a\,cn\=admin`},
+		{"#a ", `\#a\ `},
+		{`a+"b"\<>;`, `a\+\"b\"\\\<\>\;`},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			if result := EscapeDN(test.value); result != test.expectedResult {
+				t.Errorf("Expected %s, got %s", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestEncodeInt(t *testing.T) {
+	for _, n := range []int{0, 1, 127, 128, 255, 256, 65535, 1 << 20} {
+		p, _, err := splitPacket(encodeInt(tagInteger, n))
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if result, _ := p.int(); result != n {
+			t.Errorf("Expected %d, got %d", n, result)
+		}
+	}
+}
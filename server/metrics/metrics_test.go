@@ -0,0 +1,81 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounter("requests_total", "Number of\nrequests.", "code")
+	counter.Inc("200")
+	counter.Add(2, "500")
+	counter.Inc("200")
+	plain := r.NewCounter("plain_total", "Plain counter.")
+	plain.Inc()
+	histogram := r.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.1}, "path")
+	histogram.Observe(0.05, `/a"b`)
+	histogram.Observe(0.5, `/a"b`)
+	histogram.Observe(5, `/a"b`)
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("Unexpected number of bytes written %d", n)
+	}
+	expected := strings.Join([]string{
+		`# HELP requests_total Number of\nrequests.`,
+		`# TYPE requests_total counter`,
+		`requests_total{code="200"} 2`,
+		`requests_total{code="500"} 2`,
+		`# HELP plain_total Plain counter.`,
+		`# TYPE plain_total counter`,
+		`plain_total 1`,
+		`# HELP latency_seconds Latency.`,
+		`# TYPE latency_seconds histogram`,
+		`latency_seconds_bucket{path="/a\"b",le="0.1"} 1`,
+		`latency_seconds_bucket{path="/a\"b",le="1"} 2`,
+		`latency_seconds_bucket{path="/a\"b",le="+Inf"} 3`,
+		`latency_seconds_sum{path="/a\"b"} 5.55`,
+		`latency_seconds_count{path="/a\"b"} 3`,
+		``,
+	}, "\n")
+	if buf.String() != expected {
+		t.Errorf("Unexpected output %s", buf.String())
+	}
+}
+
+func TestRegistry_panics(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(r *Registry)
+	}{
+		{"duplicate", func(r *Registry) {
+			r.NewCounter("a", "")
+			r.NewHistogram("a", "", nil)
+		}},
+		{"label count", func(r *Registry) {
+			r.NewCounter("a", "", "code").Inc()
+		}},
+		{"negative counter", func(r *Registry) {
+			r.NewCounter("a", "").Add(-1)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected panic")
+				}
+			}()
+			test.fn(NewRegistry())
+		})
+	}
+}
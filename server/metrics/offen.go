@@ -0,0 +1,68 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+// Default is the registry the metrics of the application are added to.
+var Default = NewRegistry()
+
+// The metrics collected by the application. Usage data is end-to-end
+// encrypted, so no label carries information about users or accounts.
+var (
+	// EventsIngested counts the events received, labeled by whether they
+	// have been sent anonymously.
+	EventsIngested = Default.NewCounter(
+		"offen_events_ingested_total",
+		"Number of events that have been received.",
+		"anonymous",
+	)
+	// EventsDropped counts the events that have been dropped at ingestion
+	// because they have been sent by bots or have been submitted twice,
+	// labeled by the reason.
+	EventsDropped = Default.NewCounter(
+		"offen_events_dropped_total",
+		"Number of events that have been dropped as they have been sent by bots or are duplicates.",
+		"reason",
+	)
+	// EventsRejected counts the events that have been rejected at ingestion
+	// because an account exceeded its quotas, labeled by the quota.
+	EventsRejected = Default.NewCounter(
+		"offen_events_rejected_total",
+		"Number of events that have been rejected as an account exceeded its quotas.",
+		"reason",
+	)
+	// LoginAttempts counts logins, labeled by method and result.
+	LoginAttempts = Default.NewCounter(
+		"offen_login_attempts_total",
+		"Number of login attempts.",
+		"method", "result",
+	)
+	// KDFDuration observes the time spent on deriving keys from and hashing
+	// passwords and email addresses.
+	KDFDuration = Default.NewHistogram(
+		"offen_kdf_duration_seconds",
+		"Time spent on key derivation.",
+		[]float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		"algorithm",
+	)
+	// DBQueryDuration observes the latency of database queries.
+	DBQueryDuration = Default.NewHistogram(
+		"offen_db_query_duration_seconds",
+		"Latency of database queries.",
+		nil,
+		"operation",
+	)
+	// HTTPRequests counts handled requests by method and status code.
+	HTTPRequests = Default.NewCounter(
+		"offen_http_requests_total",
+		"Number of handled HTTP requests.",
+		"method", "code",
+	)
+	// HTTPRequestDuration observes the latency of handled requests.
+	HTTPRequestDuration = Default.NewHistogram(
+		"offen_http_request_duration_seconds",
+		"Latency of handled HTTP requests.",
+		nil,
+		"method",
+	)
+)
@@ -0,0 +1,359 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package oidc implements an OpenID Connect relying party using the
+// authorization code flow. Provider metadata is sourced using discovery and
+// ID tokens are verified against the keys published by the provider.
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/offen/offen/server/keys"
+	"golang.org/x/oauth2"
+)
+
+// DefaultGroupsClaim is the ID token claim that is expected to contain the
+// groups of a user in case no other claim is configured.
+const DefaultGroupsClaim = "groups"
+
+// clockSkew is the leeway applied when checking time based claims.
+const clockSkew = time.Minute
+
+var supportedAlgorithms = map[jwa.SignatureAlgorithm]bool{
+	jwa.RS256: true,
+	jwa.RS384: true,
+	jwa.RS512: true,
+	jwa.ES256: true,
+	jwa.ES384: true,
+	jwa.ES512: true,
+}
+
+// Identity contains the claims of a verified ID token that are relevant
+// for authenticating a user.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Groups        []string
+}
+
+// InGroup checks whether the identity is member of the given group.
+func (i *Identity) InGroup(group string) bool {
+	for _, g := range i.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect provider. Metadata and keys are fetched
+// lazily on first use so that an unreachable provider does not prevent the
+// application from starting.
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	groupsClaim  string
+	client       *http.Client
+	now          func() time.Time
+
+	mu       sync.Mutex
+	metadata *metadata
+	keySet   *jwk.Set
+}
+
+// Option is used to configure a Provider.
+type Option func(*Provider)
+
+// WithGroupsClaim sets the name of the ID token claim containing the groups
+// of a user.
+func WithGroupsClaim(claim string) Option {
+	return func(p *Provider) {
+		if claim != "" {
+			p.groupsClaim = claim
+		}
+	}
+}
+
+// WithHTTPClient sets the client used for requests to the provider.
+func WithHTTPClient(c *http.Client) Option {
+	return func(p *Provider) {
+		p.client = c
+	}
+}
+
+// New creates a provider for the given issuer URL, authenticating as the
+// given client.
+func New(issuer, clientID, clientSecret string, opts ...Option) *Provider {
+	p := &Provider{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		groupsClaim:  DefaultGroupsClaim,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// NewNonce creates a random value that can be used as state or nonce
+// parameter.
+func NewNonce() (string, error) {
+	b, err := keys.GenerateRandomBytes(32)
+	if err != nil {
+		return "", fmt.Errorf("oidc: error creating nonce: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (p *Provider) discover() (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	res, err := p.client.Get(p.issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("oidc: error requesting provider metadata: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: unexpected status code %d requesting provider metadata", res.StatusCode)
+	}
+	var m metadata
+	if err := json.NewDecoder(res.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("oidc: error decoding provider metadata: %w", err)
+	}
+	if strings.TrimSuffix(m.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("oidc: provider metadata is issued for %s, expected %s", m.Issuer, p.issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("oidc: provider metadata is incomplete")
+	}
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+func (p *Provider) oauth2Config(redirectURL string) (*oauth2.Config, error) {
+	m, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Config{
+		ClientID:     p.clientID,
+		ClientSecret: p.clientSecret,
+		RedirectURL:  redirectURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  m.AuthorizationEndpoint,
+			TokenURL: m.TokenEndpoint,
+		},
+		Scopes: []string{"openid", "email", "profile"},
+	}, nil
+}
+
+// AuthCodeURL returns the URL a user needs to be redirected to for
+// authenticating with the provider.
+func (p *Provider) AuthCodeURL(redirectURL, state, nonce string) (string, error) {
+	conf, err := p.oauth2Config(redirectURL)
+	if err != nil {
+		return "", err
+	}
+	return conf.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce)), nil
+}
+
+// Exchange redeems the given authorization code and returns the identity
+// contained in the ID token issued by the provider.
+func (p *Provider) Exchange(ctx context.Context, redirectURL, code, nonce string) (*Identity, error) {
+	conf, err := p.oauth2Config(redirectURL)
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := conf.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: error exchanging authorization code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, errors.New("oidc: token response did not contain an id token")
+	}
+	return p.Verify(rawIDToken, nonce)
+}
+
+type tokenHeader struct {
+	Algorithm jwa.SignatureAlgorithm `json:"alg"`
+	KeyID     string                 `json:"kid"`
+}
+
+// Verify checks the signature and the claims of the given ID token and
+// returns the identity it contains.
+func (p *Provider) Verify(rawIDToken, nonce string) (*Identity, error) {
+	chunks := strings.Split(rawIDToken, ".")
+	if len(chunks) != 3 {
+		return nil, errors.New("oidc: malformed id token")
+	}
+	var header tokenHeader
+	if err := decodeSegment(chunks[0], &header); err != nil {
+		return nil, fmt.Errorf("oidc: error decoding id token header: %w", err)
+	}
+	if !supportedAlgorithms[header.Algorithm] {
+		return nil, fmt.Errorf("oidc: unsupported signing algorithm %s", header.Algorithm)
+	}
+
+	key, err := p.lookupKey(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := jws.Verify([]byte(rawIDToken), header.Algorithm, key)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: error verifying id token signature: %w", err)
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("oidc: error decoding id token claims: %w", err)
+	}
+	if err := p.validateClaims(claims, nonce); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	switch groups := claims[p.groupsClaim].(type) {
+	case string:
+		identity.Groups = []string{groups}
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, s)
+			}
+		}
+	}
+	return identity, nil
+}
+
+func (p *Provider) validateClaims(claims map[string]interface{}, nonce string) error {
+	m, err := p.discover()
+	if err != nil {
+		return err
+	}
+	if iss, _ := claims["iss"].(string); iss != m.Issuer {
+		return fmt.Errorf("oidc: unexpected issuer %s", iss)
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return errors.New("oidc: id token is missing subject")
+	}
+
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+	var audienceMatch bool
+	for _, a := range audiences {
+		if a == p.clientID {
+			audienceMatch = true
+			break
+		}
+	}
+	if !audienceMatch {
+		return errors.New("oidc: id token has not been issued for this client")
+	}
+
+	now := p.now()
+	exp, _ := claims["exp"].(float64)
+	if now.Add(-clockSkew).After(time.Unix(int64(exp), 0)) {
+		return errors.New("oidc: id token is expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(iat), 0)) {
+		return errors.New("oidc: id token is issued in the future")
+	}
+	if n, _ := claims["nonce"].(string); nonce != "" && n != nonce {
+		return errors.New("oidc: id token nonce did not match")
+	}
+	return nil
+}
+
+// lookupKey returns the public key of the given id. The key set of the
+// provider is refreshed once in case the key is unknown as providers might
+// have rotated their keys.
+func (p *Provider) lookupKey(keyID string) (interface{}, error) {
+	for _, refresh := range []bool{false, true} {
+		set, err := p.fetchKeySet(refresh)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range set.Keys {
+			if keyID != "" && key.KeyID() != keyID {
+				continue
+			}
+			if use := key.KeyUsage(); use != "" && use != "sig" {
+				continue
+			}
+			materialized, err := key.Materialize()
+			if err != nil {
+				return nil, fmt.Errorf("oidc: error materializing key: %w", err)
+			}
+			return materialized, nil
+		}
+	}
+	return nil, fmt.Errorf("oidc: no key found for key id %s", keyID)
+}
+
+func (p *Provider) fetchKeySet(refresh bool) (*jwk.Set, error) {
+	m, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keySet != nil && !refresh {
+		return p.keySet, nil
+	}
+	set, err := jwk.FetchHTTP(m.JWKSURI, jwk.WithHTTPClient(p.client))
+	if err != nil {
+		return nil, fmt.Errorf("oidc: error fetching provider keys: %w", err)
+	}
+	p.keySet = set
+	return p.keySet, nil
+}
+
+func decodeSegment(segment string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
@@ -0,0 +1,227 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+)
+
+type mockProvider struct {
+	*httptest.Server
+	key    *ecdsa.PrivateKey
+	claims map[string]interface{}
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	m := &mockProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		pub, _ := jwk.New(&m.key.PublicKey)
+		pub.Set(jwk.KeyIDKey, "key-a")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []interface{}{pub},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     m.sign(t, m.claims),
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	return m
+}
+
+func (m *mockProvider) sign(t *testing.T, claims map[string]interface{}) string {
+	payload, _ := json.Marshal(claims)
+	headers := &jws.StandardHeaders{}
+	headers.Set(jws.KeyIDKey, "key-a")
+	token, err := jws.Sign(payload, jwa.ES256, m.key, jws.WithHeaders(headers))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return string(token)
+}
+
+func (m *mockProvider) defaultClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            m.URL,
+		"sub":            "subject",
+		"aud":            "client",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          "nonce",
+		"email":          "develop@offen.dev",
+		"email_verified": true,
+		"groups":         []string{"admins", "developers"},
+	}
+}
+
+func TestProvider_AuthCodeURL(t *testing.T) {
+	m := newMockProvider(t)
+	defer m.Close()
+	p := New(m.URL, "client", "secret")
+	result, err := p.AuthCodeURL("https://offen.example.com/callback", "state", "nonce")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	u, _ := url.Parse(result)
+	if !strings.HasPrefix(result, m.URL+"/authorize") {
+		t.Errorf("Unexpected URL %s", result)
+	}
+	for key, value := range map[string]string{
+		"client_id":     "client",
+		"state":         "state",
+		"nonce":         "nonce",
+		"response_type": "code",
+		"redirect_uri":  "https://offen.example.com/callback",
+	} {
+		if u.Query().Get(key) != value {
+			t.Errorf("Expected %s to be %s, got %s", key, value, u.Query().Get(key))
+		}
+	}
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	tests := []struct {
+		name           string
+		modify         func(map[string]interface{})
+		code           string
+		expectError    bool
+		expectedResult *Identity
+	}{
+		{
+			"ok",
+			func(map[string]interface{}) {},
+			"code",
+			false,
+			&Identity{Subject: "subject", Email: "develop@offen.dev", EmailVerified: true, Groups: []string{"admins", "developers"}},
+		},
+		{
+			"audience list",
+			func(c map[string]interface{}) {
+				c["aud"] = []string{"other", "client"}
+				delete(c, "groups")
+			},
+			"code",
+			false,
+			&Identity{Subject: "subject", Email: "develop@offen.dev", EmailVerified: true},
+		},
+		{
+			"bad code",
+			func(map[string]interface{}) {},
+			"other",
+			true,
+			nil,
+		},
+		{
+			"other audience",
+			func(c map[string]interface{}) { c["aud"] = "other" },
+			"code",
+			true,
+			nil,
+		},
+		{
+			"other issuer",
+			func(c map[string]interface{}) { c["iss"] = "https://idp.example.com" },
+			"code",
+			true,
+			nil,
+		},
+		{
+			"expired",
+			func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+			"code",
+			true,
+			nil,
+		},
+		{
+			"bad nonce",
+			func(c map[string]interface{}) { c["nonce"] = "other" },
+			"code",
+			true,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			defer m.Close()
+			m.claims = m.defaultClaims()
+			test.modify(m.claims)
+
+			p := New(m.URL, "client", "secret")
+			result, err := p.Exchange(context.Background(), "https://offen.example.com/callback", test.code, "nonce")
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if !reflect.DeepEqual(test.expectedResult, result) {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestProvider_Verify(t *testing.T) {
+	m := newMockProvider(t)
+	defer m.Close()
+	p := New(m.URL, "client", "secret", WithGroupsClaim("roles"))
+
+	claims := m.defaultClaims()
+	claims["roles"] = "operators"
+	identity, err := p.Verify(m.sign(t, claims), "nonce")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !identity.InGroup("operators") || identity.InGroup("admins") {
+		t.Errorf("Unexpected groups %v", identity.Groups)
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	payload, _ := json.Marshal(claims)
+	headers := &jws.StandardHeaders{}
+	headers.Set(jws.KeyIDKey, "key-a")
+	forged, _ := jws.Sign(payload, jwa.ES256, other, jws.WithHeaders(headers))
+	if _, err := p.Verify(string(forged), "nonce"); err == nil {
+		t.Error("Expected error verifying token signed by unknown key")
+	}
+
+	unsigned, _ := jws.Sign(payload, jwa.HS256, []byte("client"), jws.WithHeaders(headers))
+	if _, err := p.Verify(string(unsigned), "nonce"); err == nil {
+		t.Error("Expected error verifying token using symmetric algorithm")
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	uuid "github.com/gofrs/uuid"
+	"github.com/offen/offen/server/keys"
+)
+
+// Scopes that can be granted to access tokens.
+const (
+	// AccessTokenScopeRead allows reading data.
+	AccessTokenScopeRead = "read"
+	// AccessTokenScopeWrite allows modifying data.
+	AccessTokenScopeWrite = "write"
+	// AccessTokenScopeStats allows reading aggregated metrics only. Tokens
+	// with this scope are meant to be embedded in public pages, so they
+	// cannot be granted any other scope and need to be restricted to
+	// accounts.
+	AccessTokenScopeStats = "stats"
+)
+
+const accessTokenSecretLength = 32
+
+func (a *AccessToken) export() AccessTokenResult {
+	return AccessTokenResult{
+		TokenID:       a.TokenID,
+		AccountUserID: a.AccountUserID,
+		Name:          a.Name,
+		Scopes:        a.Scopes,
+		AccountIDs:    a.AccountIDs,
+		Created:       a.Created,
+		Expires:       a.Expires,
+	}
+}
+
+// CreateAccessToken creates a token for the given account user. The token
+// is returned only once as it cannot be recovered from the stored hash.
+func (p *persistenceLayer) CreateAccessToken(userID, name string, scopes, accountIDs []string, expires *time.Time) (AccessTokenResult, error) {
+	if name == "" {
+		return AccessTokenResult{}, errors.New("persistence: access tokens need to be given a name")
+	}
+	if len(scopes) == 0 {
+		return AccessTokenResult{}, errors.New("persistence: access tokens need to be granted at least one scope")
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case AccessTokenScopeRead, AccessTokenScopeWrite:
+		case AccessTokenScopeStats:
+			if len(scopes) != 1 {
+				return AccessTokenResult{}, errors.New("persistence: the stats scope cannot be combined with other scopes")
+			}
+			if len(accountIDs) == 0 {
+				return AccessTokenResult{}, errors.New("persistence: access tokens with the stats scope need to be restricted to accounts")
+			}
+		default:
+			return AccessTokenResult{}, fmt.Errorf("persistence: unknown scope %q", scope)
+		}
+	}
+	if expires != nil && expires.Before(time.Now()) {
+		return AccessTokenResult{}, errors.New("persistence: expiry of access token is in the past")
+	}
+
+	accountUser, err := p.dal.FindAccountUser(
+		FindAccountUserQueryByAccountUserIDIncludeRelationships(userID),
+	)
+	if err != nil {
+		return AccessTokenResult{}, fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	for _, accountID := range accountIDs {
+		var found bool
+		for _, relationship := range accountUser.Relationships {
+			if relationship.AccountID == accountID && relationship.PasswordEncryptedKeyEncryptionKey != "" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return AccessTokenResult{}, ErrPermissionDenied
+		}
+	}
+
+	tokenID, err := uuid.NewV4()
+	if err != nil {
+		return AccessTokenResult{}, fmt.Errorf("persistence: error creating token id: %w", err)
+	}
+	secret, err := keys.GenerateRandomValueWith(accessTokenSecretLength, base64.RawURLEncoding)
+	if err != nil {
+		return AccessTokenResult{}, fmt.Errorf("persistence: error creating token secret: %w", err)
+	}
+	salt, err := keys.NewFastSalt(keys.DefaultSecretLength)
+	if err != nil {
+		return AccessTokenResult{}, fmt.Errorf("persistence: error creating salt: %w", err)
+	}
+	// the secret is random, so a fast hash is sufficient and keeps
+	// authenticating requests cheap
+	hashedSecret, err := keys.HashFast(secret, salt.Marshal())
+	if err != nil {
+		return AccessTokenResult{}, fmt.Errorf("persistence: error hashing token secret: %w", err)
+	}
+
+	token := &AccessToken{
+		TokenID:       tokenID.String(),
+		AccountUserID: accountUser.AccountUserID,
+		Name:          name,
+		HashedSecret:  hashedSecret,
+		Salt:          salt.Marshal(),
+		Scopes:        scopes,
+		AccountIDs:    accountIDs,
+		Created:       time.Now(),
+		Expires:       expires,
+	}
+	if err := p.dal.CreateAccessToken(token); err != nil {
+		return AccessTokenResult{}, fmt.Errorf("persistence: error persisting access token: %w", err)
+	}
+	result := token.export()
+	result.Token = token.TokenID + "." + secret
+	return result, nil
+}
+
+// LookupAccessToken returns the access token matching the given value.
+// ErrInvalidAccessToken is returned in case no such token exists or it has
+// expired.
+func (p *persistenceLayer) LookupAccessToken(value string) (AccessTokenResult, error) {
+	chunks := strings.SplitN(value, ".", 2)
+	if len(chunks) != 2 {
+		return AccessTokenResult{}, ErrInvalidAccessToken
+	}
+	token, err := p.dal.FindAccessToken(FindAccessTokenQueryByID(chunks[0]))
+	if err != nil {
+		return AccessTokenResult{}, ErrInvalidAccessToken
+	}
+	hashedSecret, err := keys.HashFast(chunks[1], token.Salt)
+	if err != nil {
+		return AccessTokenResult{}, fmt.Errorf("persistence: error hashing token secret: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(hashedSecret), []byte(token.HashedSecret)) != 1 {
+		return AccessTokenResult{}, ErrInvalidAccessToken
+	}
+	if token.Expires != nil && time.Now().After(*token.Expires) {
+		return AccessTokenResult{}, ErrInvalidAccessToken
+	}
+	return token.export(), nil
+}
+
+// ListAccessTokens returns all access tokens of the given account user,
+// including ones that have expired.
+func (p *persistenceLayer) ListAccessTokens(userID string) ([]AccessTokenResult, error) {
+	tokens, err := p.dal.FindAccessTokens(FindAccessTokensQueryByAccountUserID(userID))
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up access tokens: %w", err)
+	}
+	result := []AccessTokenResult{}
+	for _, token := range tokens {
+		result = append(result, token.export())
+	}
+	return result, nil
+}
+
+func (p *persistenceLayer) RevokeAccessToken(userID, tokenID string) error {
+	if err := p.dal.DeleteAccessTokens(DeleteAccessTokensQueryByID{
+		TokenID:       tokenID,
+		AccountUserID: userID,
+	}); err != nil {
+		return fmt.Errorf("persistence: error deleting access token: %w", err)
+	}
+	return nil
+}
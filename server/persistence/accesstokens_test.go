@@ -0,0 +1,117 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type mockAccessTokensDatabase struct {
+	DataAccessLayer
+	tokens []AccessToken
+}
+
+func (m *mockAccessTokensDatabase) FindAccountUser(interface{}) (AccountUser, error) {
+	return AccountUser{
+		AccountUserID: "user-a",
+		Relationships: []AccountUserRelationship{
+			{AccountID: "account-a", PasswordEncryptedKeyEncryptionKey: "key"},
+			{AccountID: "account-b"},
+		},
+	}, nil
+}
+
+func (m *mockAccessTokensDatabase) CreateAccessToken(a *AccessToken) error {
+	m.tokens = append(m.tokens, *a)
+	return nil
+}
+
+func (m *mockAccessTokensDatabase) FindAccessToken(q interface{}) (AccessToken, error) {
+	for _, token := range m.tokens {
+		if token.TokenID == string(q.(FindAccessTokenQueryByID)) {
+			return token, nil
+		}
+	}
+	return AccessToken{}, errors.New("not found")
+}
+
+func TestPersistenceLayer_CreateAccessToken(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name        string
+		tokenName   string
+		scopes      []string
+		accountIDs  []string
+		expires     *time.Time
+		expectedErr error
+		expectError bool
+	}{
+		{"ok", "script", []string{AccessTokenScopeRead}, nil, nil, nil, false},
+		{"restricted", "script", []string{AccessTokenScopeRead, AccessTokenScopeWrite}, []string{"account-a"}, nil, nil, false},
+		{"stats", "counter", []string{AccessTokenScopeStats}, []string{"account-a"}, nil, nil, false},
+		{"stats without accounts", "counter", []string{AccessTokenScopeStats}, nil, nil, nil, true},
+		{"stats combined", "counter", []string{AccessTokenScopeStats, AccessTokenScopeRead}, []string{"account-a"}, nil, nil, true},
+		{"no name", "", []string{AccessTokenScopeRead}, nil, nil, nil, true},
+		{"no scopes", "script", nil, nil, nil, nil, true},
+		{"unknown scope", "script", []string{"admin"}, nil, nil, nil, true},
+		{"expired", "script", []string{AccessTokenScopeRead}, nil, &past, nil, true},
+		{"other account", "script", []string{AccessTokenScopeRead}, []string{"account-z"}, nil, ErrPermissionDenied, true},
+		{"pending invitation", "script", []string{AccessTokenScopeRead}, []string{"account-b"}, nil, ErrPermissionDenied, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &persistenceLayer{dal: &mockAccessTokensDatabase{}}
+			result, err := p.CreateAccessToken("user-a", test.tokenName, test.scopes, test.accountIDs, test.expires)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if test.expectedErr != nil && !errors.Is(err, test.expectedErr) {
+				t.Errorf("Expected %v, got %v", test.expectedErr, err)
+			}
+			if err == nil && result.Token == "" {
+				t.Error("Expected token to be returned")
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_LookupAccessToken(t *testing.T) {
+	db := &mockAccessTokensDatabase{}
+	p := &persistenceLayer{dal: db}
+	expires := time.Now().Add(time.Hour)
+	created, err := p.CreateAccessToken("user-a", "script", []string{AccessTokenScopeRead}, nil, &expires)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	result, err := p.LookupAccessToken(created.Token)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if result.AccountUserID != "user-a" || result.Token != "" || !result.HasScope(AccessTokenScopeRead) {
+		t.Errorf("Unexpected result %v", result)
+	}
+
+	for name, value := range map[string]string{
+		"malformed":    "token",
+		"unknown id":   "unknown." + created.Token[len(created.TokenID)+1:],
+		"wrong secret": created.TokenID + ".secret",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := p.LookupAccessToken(value); err != ErrInvalidAccessToken {
+				t.Errorf("Expected invalid access token error, got %v", err)
+			}
+		})
+	}
+
+	t.Run("expired", func(t *testing.T) {
+		past := time.Now().Add(-time.Minute)
+		db.tokens[0].Expires = &past
+		if _, err := p.LookupAccessToken(created.Token); err != ErrInvalidAccessToken {
+			t.Errorf("Expected invalid access token error, got %v", err)
+		}
+	})
+}
@@ -12,15 +12,18 @@ import (
 )
 
 func (p *persistenceLayer) GetAccount(accountID string, includeEvents bool, eventsSince string) (AccountResult, error) {
+	dal, span := p.startSpan("GetAccount")
+	defer span.End()
+
 	var account Account
 	var err error
 	if includeEvents {
-		account, err = p.dal.FindAccount(FindAccountQueryIncludeEvents{
+		account, err = dal.FindAccount(FindAccountQueryIncludeEvents{
 			AccountID: accountID,
 			Since:     eventsSince,
 		})
 	} else {
-		account, err = p.dal.FindAccount(FindAccountQueryActiveByID(accountID))
+		account, err = dal.FindAccount(FindAccountQueryActiveByID(accountID))
 	}
 	if err != nil {
 		return AccountResult{}, fmt.Errorf("persistence: error looking up account data: %w", err)
@@ -41,6 +44,15 @@ func (p *persistenceLayer) GetAccount(accountID string, includeEvents bool, even
 		return result, nil
 	}
 	result.EncryptedPrivateKey = account.EncryptedPrivateKey
+	if result.AllowedOrigins, err = account.allowedOrigins(); err != nil {
+		return AccountResult{}, err
+	}
+	result.PrivacySignals = account.privacySignals()
+	result.Timezone = account.timezone()
+	result.AnomalySensitivity = account.anomalySensitivity()
+	if result.Domains, err = account.domains(); err != nil {
+		return AccountResult{}, err
+	}
 
 	eventResults := EventsByAccountID{}
 	secrets := EncryptedSecretsByID{}
@@ -66,7 +78,7 @@ func (p *persistenceLayer) GetAccount(accountID string, includeEvents bool, even
 	}
 
 	if eventsSince != "" {
-		pruned, err := p.dal.FindTombstones(FindTombstonesQueryByAccounts{
+		pruned, err := dal.FindTombstones(FindTombstonesQueryByAccounts{
 			AccountIDs: []string{accountID},
 			Since:      eventsSince,
 		})
@@ -200,62 +212,103 @@ func (p *persistenceLayer) AssociateUserSecret(accountID, userID, encryptedUserS
 	return nil
 }
 
-func (p *persistenceLayer) CreateAccount(name, emailAddress, password string) error {
+// CreateAccount creates an account of the given name and generates its key
+// material. The account user of the given credentials is made the account's
+// admin.
+func (p *persistenceLayer) CreateAccount(name, emailAddress, password string) (AccountResult, error) {
 	accountUsers, err := p.dal.FindAccountUsers(FindAccountUsersQueryAllAccountUsers{true, false})
 	if err != nil {
-		return fmt.Errorf("persistence: error looking up account users: %w", err)
+		return AccountResult{}, fmt.Errorf("persistence: error looking up account users: %w", err)
 	}
-	match, err := selectAccountUser(accountUsers, emailAddress)
+	match, err := p.selectAccountUser(accountUsers, emailAddress)
 	if err != nil {
-		return fmt.Errorf("persistence: error looking up account user %s: %w", emailAddress, err)
+		return AccountResult{}, fmt.Errorf("persistence: error looking up account user %s: %w", emailAddress, err)
 	}
 
-	if err := keys.CompareString(password, match.HashedPassword); err != nil {
-		return fmt.Errorf("persistence: passwords did not match: %w", err)
+	if err := keys.ComparePassword(password, match.HashedPassword, p.pepper); err != nil {
+		return AccountResult{}, fmt.Errorf("persistence: passwords did not match: %w", err)
 	}
 
 	allAccounts, allAccountsErr := p.dal.FindAccounts(FindAccountsQueryAllAccounts{})
 	if allAccountsErr != nil {
-		return fmt.Errorf("persistence: error looking up all existing accounts: %w", err)
+		return AccountResult{}, fmt.Errorf("persistence: error looking up all existing accounts: %w", allAccountsErr)
 	}
 	for _, account := range allAccounts {
 		if account.Name == name {
-			return fmt.Errorf("persistence: account named %s already exists", name)
+			return AccountResult{}, fmt.Errorf("persistence: account named %s already exists", name)
 		}
 	}
 
 	account, key, err := newAccount(name, "")
 	if err != nil {
-		return fmt.Errorf("persistence: error creating account: %w", err)
+		return AccountResult{}, fmt.Errorf("persistence: error creating account: %w", err)
 	}
 	relationship, err := newAccountUserRelationship(match.AccountUserID, account.AccountID)
 	if err != nil {
-		return fmt.Errorf("persistence: error creating relationship: %w", err)
+		return AccountResult{}, fmt.Errorf("persistence: error creating relationship: %w", err)
 	}
-	if err := relationship.addEmailEncryptedKey(key, match.Salt, emailAddress); err != nil {
-		return fmt.Errorf("persistence: error adding email encrypted key: %w", err)
+	relationship.Role = AccountUserRoleAdmin
+	if err := relationship.addEmailEncryptedKey(key, match.emailSalt(), emailAddress); err != nil {
+		return AccountResult{}, fmt.Errorf("persistence: error adding email encrypted key: %w", err)
 	}
 	if err := relationship.addPasswordEncryptedKey(key, match.Salt, password); err != nil {
-		return fmt.Errorf("persistence: error adding password encrypted key: %w", err)
+		return AccountResult{}, fmt.Errorf("persistence: error adding password encrypted key: %w", err)
+	}
+	if _, err := account.escrow(p.escrowKey, key); err != nil {
+		return AccountResult{}, fmt.Errorf("persistence: error escrowing key: %w", err)
 	}
 
 	txn, err := p.dal.Transaction()
 	if err != nil {
-		return fmt.Errorf("persistence: error creating transaction: %w", err)
+		return AccountResult{}, fmt.Errorf("persistence: error creating transaction: %w", err)
 	}
 	if err := txn.CreateAccount(account); err != nil {
 		txn.Rollback()
-		return fmt.Errorf("persistence: error persisting account: %w", err)
+		return AccountResult{}, fmt.Errorf("persistence: error persisting account: %w", err)
 	}
 	if err := txn.CreateAccountUserRelationship(relationship); err != nil {
 		txn.Rollback()
-		return fmt.Errorf("persistence: error persisting relationship: %w", err)
+		return AccountResult{}, fmt.Errorf("persistence: error persisting relationship: %w", err)
 	}
 	if err := txn.Commit(); err != nil {
-		return fmt.Errorf("persistence: error committing transaction: %w", err)
+		return AccountResult{}, fmt.Errorf("persistence: error committing transaction: %w", err)
 	}
 
-	return nil
+	return AccountResult{
+		AccountID: account.AccountID,
+		Name:      account.Name,
+		Created:   account.Created,
+	}, nil
+}
+
+// RenameAccount changes the name of the given account. Names need to be
+// unique across all accounts.
+func (p *persistenceLayer) RenameAccount(accountID, name string) (AccountResult, error) {
+	if name == "" {
+		return AccountResult{}, errors.New("persistence: account name must not be empty")
+	}
+	account, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID))
+	if err != nil {
+		return AccountResult{}, fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+	}
+	allAccounts, err := p.dal.FindAccounts(FindAccountsQueryAllAccounts{})
+	if err != nil {
+		return AccountResult{}, fmt.Errorf("persistence: error looking up all existing accounts: %w", err)
+	}
+	for _, other := range allAccounts {
+		if other.Name == name && other.AccountID != accountID {
+			return AccountResult{}, fmt.Errorf("persistence: account named %s already exists", name)
+		}
+	}
+	account.Name = name
+	if err := p.dal.UpdateAccount(&account); err != nil {
+		return AccountResult{}, fmt.Errorf("persistence: error renaming account %s: %w", accountID, err)
+	}
+	return AccountResult{
+		AccountID: account.AccountID,
+		Name:      account.Name,
+		Created:   account.Created,
+	}, nil
 }
 
 func (p *persistenceLayer) RetireAccount(accountID string) error {
@@ -285,3 +338,115 @@ func (p *persistenceLayer) RetireAccount(accountID string) error {
 	}
 	return nil
 }
+
+// RotateAccountKeys replaces the key encryption key of the given account with
+// a newly generated one and re-encrypts the account's private key. As the
+// server is only able to access the envelopes of the account user whose
+// credentials are given, the relationships of all other account users will be
+// migrated to the new key on their next login. Until then, the new key is
+// stored encrypted using the previous one.
+func (p *persistenceLayer) RotateAccountKeys(accountID, emailAddress, password string) error {
+	accountUser, err := p.findAccountUser(emailAddress, true, false)
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	if err := p.verifyPassword(accountUser, emailAddress, password); err != nil {
+		return fmt.Errorf("persistence: error verifying password: %w", err)
+	}
+
+	var requesterRelationship *AccountUserRelationship
+	for _, relationship := range accountUser.Relationships {
+		if relationship.AccountID == accountID {
+			r := relationship
+			requesterRelationship = &r
+			break
+		}
+	}
+	if requesterRelationship == nil {
+		return fmt.Errorf("persistence: account user is not allowed to access account %s", accountID)
+	}
+	if !accountUser.canManageAccount(accountID) {
+		return ErrPermissionDenied
+	}
+
+	passwordDerivedKey, deriveErr := keys.DeriveKey(password, accountUser.Salt)
+	if deriveErr != nil {
+		return fmt.Errorf("persistence: error deriving key from password: %w", deriveErr)
+	}
+	envelopeKey, decryptErr := keys.DecryptWith(passwordDerivedKey, requesterRelationship.PasswordEncryptedKeyEncryptionKey)
+	if decryptErr != nil {
+		return fmt.Errorf("persistence: error decrypting key encryption key: %w", ErrInvalidCredentials)
+	}
+	currentKey, resolveErr := requesterRelationship.resolveKeyEncryptionKey(envelopeKey, p.rotationKeys...)
+	if resolveErr != nil {
+		return fmt.Errorf("persistence: error resolving current key encryption key: %w", resolveErr)
+	}
+
+	account, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID))
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up account: %w", err)
+	}
+	privateKey, err := keys.DecryptWith(currentKey, account.EncryptedPrivateKey)
+	if err != nil {
+		return fmt.Errorf("persistence: error decrypting private key of account: %w", err)
+	}
+
+	nextKey, err := keys.GenerateRandomBytes(keys.DefaultEncryptionKeySize)
+	if err != nil {
+		return fmt.Errorf("persistence: error creating key encryption key: %w", err)
+	}
+	encryptedPrivateKey, err := keys.EncryptWith(nextKey, privateKey)
+	if err != nil {
+		return fmt.Errorf("persistence: error encrypting private key of account: %w", err)
+	}
+	account.EncryptedPrivateKey = encryptedPrivateKey.Marshal()
+	// a value wrapped using the escrow key is outdated now and needs to be
+	// replaced
+	account.EscrowEncryptedKeyEncryptionKey, account.EscrowKeyID = "", ""
+	if _, err := account.escrow(p.escrowKey, nextKey); err != nil {
+		return fmt.Errorf("persistence: error escrowing key: %w", err)
+	}
+
+	relationships, err := p.dal.FindAccountUserRelationships(FindAccountUserRelationshipsQueryByAccountID(accountID))
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up relationships for account: %w", err)
+	}
+
+	for idx, relationship := range relationships {
+		if relationship.RelationshipID != requesterRelationship.RelationshipID {
+			if err := relationship.appendKeyRotation(currentKey, nextKey, p.rotationKeys...); err != nil {
+				return fmt.Errorf("persistence: error adding key rotation to relationship: %w", err)
+			}
+			relationships[idx] = relationship
+			continue
+		}
+		if err := relationship.addPasswordEncryptedKey(nextKey, accountUser.Salt, password); err != nil {
+			return fmt.Errorf("persistence: error adding password encrypted key: %w", err)
+		}
+		if err := relationship.addEmailEncryptedKey(nextKey, accountUser.emailSalt(), emailAddress); err != nil {
+			return fmt.Errorf("persistence: error adding email encrypted key: %w", err)
+		}
+		relationship.clearOneTimeKey()
+		relationship.KeyEncryptionKeyRotations = ""
+		relationships[idx] = relationship
+	}
+
+	txn, err := p.dal.Transaction()
+	if err != nil {
+		return fmt.Errorf("persistence: error creating transaction: %w", err)
+	}
+	if err := txn.UpdateAccount(&account); err != nil {
+		txn.Rollback()
+		return fmt.Errorf("persistence: error updating account: %w", err)
+	}
+	for _, relationship := range relationships {
+		if err := txn.UpdateAccountUserRelationship(&relationship); err != nil {
+			txn.Rollback()
+			return fmt.Errorf("persistence: error updating relationship: %w", err)
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return fmt.Errorf("persistence: error committing transaction: %w", err)
+	}
+	return nil
+}
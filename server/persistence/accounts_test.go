@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/offen/offen/server/keys"
 )
 
 var publicKey = `
@@ -106,6 +107,9 @@ func TestPersistenceLayer_GetAccount(t *testing.T) {
 					"hashed-user-a": "aaaaa",
 					"hashed-user-b": "bbbbb",
 				},
+				PrivacySignals:     PrivacySignalsIgnore,
+				Timezone:           "UTC",
+				AnomalySensitivity: AnomalySensitivityOff,
 			},
 			false,
 			[]assertion{
@@ -290,7 +294,7 @@ func TestPersistenceLayer_RetireAccount(t *testing.T) {
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			p := persistenceLayer{test.db}
+			p := persistenceLayer{dal: test.db}
 			err := p.RetireAccount("account-a")
 			if test.expectError != (err != nil) {
 				t.Errorf("Unexpected error value: %v", err)
@@ -298,3 +302,196 @@ func TestPersistenceLayer_RetireAccount(t *testing.T) {
 		})
 	}
 }
+
+type mockRenameAccountDatabase struct {
+	DataAccessLayer
+	accounts  []Account
+	findErr   error
+	updateErr error
+	updated   *Account
+}
+
+func (m *mockRenameAccountDatabase) FindAccount(q interface{}) (Account, error) {
+	if m.findErr != nil {
+		return Account{}, m.findErr
+	}
+	for _, account := range m.accounts {
+		if account.AccountID == string(q.(FindAccountQueryActiveByID)) {
+			return account, nil
+		}
+	}
+	return Account{}, ErrUnknownAccount("not found")
+}
+
+func (m *mockRenameAccountDatabase) FindAccounts(interface{}) ([]Account, error) {
+	return m.accounts, nil
+}
+
+func (m *mockRenameAccountDatabase) UpdateAccount(a *Account) error {
+	m.updated = a
+	return m.updateErr
+}
+
+func TestPersistenceLayer_RenameAccount(t *testing.T) {
+	accounts := []Account{
+		{AccountID: "account-a", Name: "a"},
+		{AccountID: "account-b", Name: "b"},
+	}
+	tests := []struct {
+		name           string
+		db             *mockRenameAccountDatabase
+		accountID      string
+		accountName    string
+		expectError    bool
+		expectedResult AccountResult
+	}{
+		{"empty name", &mockRenameAccountDatabase{accounts: accounts}, "account-a", "", true, AccountResult{}},
+		{"unknown account", &mockRenameAccountDatabase{accounts: accounts}, "account-z", "z", true, AccountResult{}},
+		{"lookup error", &mockRenameAccountDatabase{findErr: errors.New("did not work")}, "account-a", "z", true, AccountResult{}},
+		{"name taken", &mockRenameAccountDatabase{accounts: accounts}, "account-a", "b", true, AccountResult{}},
+		{"update error", &mockRenameAccountDatabase{accounts: accounts, updateErr: errors.New("did not work")}, "account-a", "z", true, AccountResult{}},
+		{"same name", &mockRenameAccountDatabase{accounts: accounts}, "account-a", "a", false, AccountResult{AccountID: "account-a", Name: "a"}},
+		{"ok", &mockRenameAccountDatabase{accounts: accounts}, "account-a", "z", false, AccountResult{AccountID: "account-a", Name: "z"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := persistenceLayer{dal: test.db}
+			result, err := p.RenameAccount(test.accountID, test.accountName)
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value: %v", err)
+			}
+			if !reflect.DeepEqual(test.expectedResult, result) {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+type mockRotateAccountKeysDatabase struct {
+	DataAccessLayer
+	accountUsers  []AccountUser
+	account       Account
+	relationships map[string]AccountUserRelationship
+}
+
+func (m *mockRotateAccountKeysDatabase) FindAccountUsers(interface{}) ([]AccountUser, error) {
+	var result []AccountUser
+	for _, user := range m.accountUsers {
+		user.Relationships = nil
+		for _, relationship := range m.relationships {
+			if relationship.AccountUserID == user.AccountUserID {
+				user.Relationships = append(user.Relationships, relationship)
+			}
+		}
+		result = append(result, user)
+	}
+	return result, nil
+}
+
+func (m *mockRotateAccountKeysDatabase) FindAccount(interface{}) (Account, error) {
+	return m.account, nil
+}
+
+func (m *mockRotateAccountKeysDatabase) FindAccountUserRelationships(interface{}) ([]AccountUserRelationship, error) {
+	var result []AccountUserRelationship
+	for _, relationship := range m.relationships {
+		result = append(result, relationship)
+	}
+	return result, nil
+}
+
+func (m *mockRotateAccountKeysDatabase) UpdateAccount(a *Account) error {
+	m.account = *a
+	return nil
+}
+
+func (m *mockRotateAccountKeysDatabase) UpdateAccountUserRelationship(r *AccountUserRelationship) error {
+	m.relationships[r.RelationshipID] = *r
+	return nil
+}
+
+func (m *mockRotateAccountKeysDatabase) UpdateAccountUser(*AccountUser) error {
+	return nil
+}
+
+func (m *mockRotateAccountKeysDatabase) Transaction() (Transaction, error) {
+	return m, nil
+}
+
+func (m *mockRotateAccountKeysDatabase) Commit() error {
+	return nil
+}
+
+func (m *mockRotateAccountKeysDatabase) Rollback() error {
+	return nil
+}
+
+func TestPersistenceLayer_RotateAccountKeys(t *testing.T) {
+	account, key, _ := newAccount("name", "")
+	db := &mockRotateAccountKeysDatabase{
+		account:       *account,
+		relationships: map[string]AccountUserRelationship{},
+	}
+	for _, credentials := range [][]string{{"develop@offen.dev", "develop"}, {"other@offen.dev", "other"}} {
+		accountUser, _ := newAccountUser(credentials[0], credentials[1], AccountUserAdminLevelSuperAdmin, keys.DefaultKDFParams, nil)
+		relationship, _ := newAccountUserRelationship(accountUser.AccountUserID, account.AccountID)
+		relationship.addPasswordEncryptedKey(key, accountUser.Salt, credentials[1])
+		relationship.addEmailEncryptedKey(key, accountUser.Salt, credentials[0])
+		db.accountUsers = append(db.accountUsers, *accountUser)
+		db.relationships[relationship.RelationshipID] = *relationship
+	}
+	p := &persistenceLayer{dal: db, kdfParams: keys.DefaultKDFParams}
+
+	if err := p.RotateAccountKeys("account-b", "develop@offen.dev", "develop"); err == nil {
+		t.Error("Expected error rotating keys of inaccessible account")
+	}
+	if err := p.RotateAccountKeys(account.AccountID, "develop@offen.dev", "other"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected invalid credentials rotating keys using bad password, got %v", err)
+	}
+
+	if err := p.RotateAccountKeys(account.AccountID, "develop@offen.dev", "develop"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, err := keys.DecryptWith(key, db.account.EncryptedPrivateKey); err == nil {
+		t.Error("Expected private key not to be encrypted with previous key anymore")
+	}
+	// rotating once more extends the chain of pending rotations that can only
+	// be resolved using the key in the relationship's envelopes
+	if err := p.RotateAccountKeys(account.AccountID, "develop@offen.dev", "develop"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	for _, relationship := range db.relationships {
+		if relationship.KeyEncryptionKeyRotations == "" {
+			continue
+		}
+		if _, err := relationship.resolveKeyEncryptionKey([]byte("other-key-other-key-other-key-ot")); err == nil {
+			t.Error("Expected pending rotation not to be resolvable using an unrelated key")
+		}
+		currentKey, err := relationship.resolveKeyEncryptionKey(key)
+		if err != nil {
+			t.Fatalf("Unexpected error resolving pending rotation: %v", err)
+		}
+		if _, err := keys.DecryptWith(currentKey, db.account.EncryptedPrivateKey); err != nil {
+			t.Errorf("Unexpected error decrypting private key with resolved key: %v", err)
+		}
+	}
+
+	for _, credentials := range [][]string{{"develop@offen.dev", "develop"}, {"other@offen.dev", "other"}} {
+		result, err := p.Login(credentials[0], credentials[1])
+		if err != nil {
+			t.Fatalf("Unexpected error logging in after rotation: %v", err)
+		}
+		nextKey, ok := result.Accounts[0].KeyEncryptionKey.(*jwk.SymmetricKey)
+		if !ok {
+			t.Fatalf("Unexpected key %v", result.Accounts[0].KeyEncryptionKey)
+		}
+		if _, err := keys.DecryptWith(nextKey.Octets(), db.account.EncryptedPrivateKey); err != nil {
+			t.Errorf("Unexpected error decrypting private key with rotated key: %v", err)
+		}
+	}
+	for _, relationship := range db.relationships {
+		if relationship.KeyEncryptionKeyRotations != "" {
+			t.Errorf("Expected pending rotations to be completed, got %v", relationship.KeyEncryptionKeyRotations)
+		}
+	}
+}
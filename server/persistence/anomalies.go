@@ -0,0 +1,81 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"fmt"
+)
+
+// Sensitivities of the detection of traffic anomalies.
+const (
+	// AnomalySensitivityOff disables the detection of anomalies.
+	AnomalySensitivityOff = "off"
+	// AnomalySensitivityLow only alerts on very large deviations.
+	AnomalySensitivityLow = "low"
+	// AnomalySensitivityMedium alerts on large deviations.
+	AnomalySensitivityMedium = "medium"
+	// AnomalySensitivityHigh also alerts on moderate deviations.
+	AnomalySensitivityHigh = "high"
+)
+
+// AnomalySensitivities contains all supported sensitivities.
+var AnomalySensitivities = []string{
+	AnomalySensitivityOff,
+	AnomalySensitivityLow,
+	AnomalySensitivityMedium,
+	AnomalySensitivityHigh,
+}
+
+func (a *Account) anomalySensitivity() string {
+	if a.AnomalySensitivity == "" {
+		return AnomalySensitivityOff
+	}
+	return a.AnomalySensitivity
+}
+
+// SetAnomalySensitivity sets how much the traffic of the account with the
+// given id has to deviate from its baseline for alerts to be sent.
+func (p *persistenceLayer) SetAnomalySensitivity(accountID, sensitivity string) error {
+	var valid bool
+	for _, s := range AnomalySensitivities {
+		if s == sensitivity {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("persistence: unknown anomaly sensitivity %q", sensitivity)
+	}
+	account, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID))
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+	}
+	account.AnomalySensitivity = sensitivity
+	if err := p.dal.UpdateAccount(&account); err != nil {
+		return fmt.Errorf("persistence: error updating account: %w", err)
+	}
+	return nil
+}
+
+// LookupAnomalyDetection returns all accounts that have the detection of
+// traffic anomalies enabled. Retired accounts are skipped.
+func (p *persistenceLayer) LookupAnomalyDetection() ([]AnomalyDetectionResult, error) {
+	accounts, err := p.dal.FindAccounts(FindAccountsQueryAllAccounts{})
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up accounts: %w", err)
+	}
+	result := []AnomalyDetectionResult{}
+	for _, account := range accounts {
+		if account.Retired || account.anomalySensitivity() == AnomalySensitivityOff {
+			continue
+		}
+		result = append(result, AnomalyDetectionResult{
+			AccountID:   account.AccountID,
+			Name:        account.Name,
+			Sensitivity: account.anomalySensitivity(),
+			Created:     account.Created,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPersistenceLayer_SetAnomalySensitivity(t *testing.T) {
+	tests := []struct {
+		name        string
+		db          *mockOriginsDatabase
+		sensitivity string
+		expectError bool
+	}{
+		{"unknown sensitivity", &mockOriginsDatabase{}, "extreme", true},
+		{"lookup error", &mockOriginsDatabase{findAccountErr: ErrUnknownAccount("did not work")}, AnomalySensitivityLow, true},
+		{"update error", &mockOriginsDatabase{updateErr: errors.New("did not work")}, AnomalySensitivityLow, true},
+		{"ok", &mockOriginsDatabase{}, AnomalySensitivityHigh, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := persistenceLayer{dal: test.db}
+			err := p.SetAnomalySensitivity("account-a", test.sensitivity)
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if err == nil && test.db.updated.AnomalySensitivity != test.sensitivity {
+				t.Errorf("Expected stored value %v, got %v", test.sensitivity, test.db.updated.AnomalySensitivity)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_LookupAnomalyDetection(t *testing.T) {
+	created := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name           string
+		db             *mockOriginsDatabase
+		expectedResult []AnomalyDetectionResult
+		expectError    bool
+	}{
+		{
+			"lookup error",
+			&mockOriginsDatabase{findAccountsErr: errors.New("did not work")},
+			nil,
+			true,
+		},
+		{
+			"ok",
+			&mockOriginsDatabase{findAccountsResult: []Account{
+				{AccountID: "account-a"},
+				{AccountID: "account-b", Name: "b", AnomalySensitivity: AnomalySensitivityMedium, Created: created},
+				{AccountID: "account-c", AnomalySensitivity: AnomalySensitivityOff},
+				{AccountID: "account-d", AnomalySensitivity: AnomalySensitivityHigh, Retired: true},
+			}},
+			[]AnomalyDetectionResult{
+				{AccountID: "account-b", Name: "b", Sensitivity: AnomalySensitivityMedium, Created: created},
+			},
+			false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := persistenceLayer{dal: test.db}
+			result, err := p.LookupAnomalyDetection()
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if !reflect.DeepEqual(test.expectedResult, result) {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
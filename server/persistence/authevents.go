@@ -0,0 +1,132 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"fmt"
+	"time"
+)
+
+// Types of authentication events that are recorded.
+const (
+	AuthEventLoginSucceeded           = "login_succeeded"
+	AuthEventLoginFailed              = "login_failed"
+	AuthEventSecondFactorFailed       = "second_factor_failed"
+	AuthEventPasswordResetRequested   = "password_reset_requested"
+	AuthEventSecondFactorEnrolled     = "second_factor_enrolled"
+	AuthEventSecondFactorEnabled      = "second_factor_enabled"
+	AuthEventSecondFactorDisabled     = "second_factor_disabled"
+	AuthEventRecoveryCodesRegenerated = "recovery_codes_regenerated"
+	AuthEventImpersonationStarted     = "impersonation_started"
+)
+
+// Limits applied when listing authentication events.
+const (
+	defaultAuthEventsLimit = 50
+	maxAuthEventsLimit     = 500
+)
+
+func (a *AuthEvent) export() AuthEventResult {
+	return AuthEventResult{
+		EventID:   a.EventID,
+		Type:      a.Type,
+		IPAddress: a.IPAddress,
+		UserAgent: a.UserAgent,
+		Created:   a.Created,
+	}
+}
+
+// RecordAuthEvent persists an authentication event for the given account
+// user. In case no user id is given, the account user is looked up using
+// the given email address, which allows recording failed logins. Events for
+// email addresses that do not belong to any account user are dropped.
+func (p *persistenceLayer) RecordAuthEvent(userID, emailAddress, eventType, ipAddress, userAgent string) error {
+	if userID == "" {
+		accountUser, _, err := p.findAccountUserByAnyEmail(emailAddress, false)
+		if err != nil {
+			return nil
+		}
+		userID = accountUser.AccountUserID
+	}
+	return p.recordAuthEvent(userID, eventType, ipAddress, userAgent)
+}
+
+func (p *persistenceLayer) recordAuthEvent(userID, eventType, ipAddress, userAgent string) error {
+	now := time.Now()
+	eventID, err := EventIDAt(now)
+	if err != nil {
+		return fmt.Errorf("persistence: error creating event id: %w", err)
+	}
+	if err := p.dal.CreateAuthEvent(&AuthEvent{
+		EventID:       eventID,
+		AccountUserID: userID,
+		Type:          eventType,
+		IPAddress:     ipAddress,
+		UserAgent:     userAgent,
+		Created:       now,
+	}); err != nil {
+		return fmt.Errorf("persistence: error persisting auth event: %w", err)
+	}
+	return nil
+}
+
+// ListAuthEvents returns a page of authentication events of the given account
+// user, most recent first. Passing the Next value of a previous result as
+// before returns the following page.
+func (p *persistenceLayer) ListAuthEvents(userID, before string, limit int) (AuthEventsResult, error) {
+	if limit <= 0 {
+		limit = defaultAuthEventsLimit
+	}
+	if limit > maxAuthEventsLimit {
+		limit = maxAuthEventsLimit
+	}
+	// an additional event is requested to tell whether another page exists
+	events, err := p.dal.FindAuthEvents(FindAuthEventsQueryByAccountUserID{
+		AccountUserID: userID,
+		Before:        before,
+		Limit:         limit + 1,
+	})
+	if err != nil {
+		return AuthEventsResult{}, fmt.Errorf("persistence: error looking up auth events: %w", err)
+	}
+	result := AuthEventsResult{Events: []AuthEventResult{}}
+	if len(events) > limit {
+		events = events[:limit]
+		result.Next = events[limit-1].EventID
+	}
+	for _, event := range events {
+		result.Events = append(result.Events, event.export())
+	}
+	return result, nil
+}
+
+// IsNewLoginFingerprint checks whether the account user has never logged in
+// successfully using the given combination of IP address and user agent
+// before. In case the account user has not logged in at all yet, the
+// fingerprint is not considered new as there is nothing it could differ from.
+// It needs to be called before the current login is recorded.
+func (p *persistenceLayer) IsNewLoginFingerprint(userID, ipAddress, userAgent string) (bool, error) {
+	previous, err := p.dal.FindAuthEvents(FindAuthEventsQueryByType{
+		AccountUserID: userID,
+		Type:          AuthEventLoginSucceeded,
+		Limit:         1,
+	})
+	if err != nil {
+		return false, fmt.Errorf("persistence: error looking up previous logins: %w", err)
+	}
+	if len(previous) == 0 {
+		return false, nil
+	}
+	matching, err := p.dal.FindAuthEvents(FindAuthEventsQueryByType{
+		AccountUserID: userID,
+		Type:          AuthEventLoginSucceeded,
+		IPAddress:     ipAddress,
+		UserAgent:     userAgent,
+		Limit:         1,
+	})
+	if err != nil {
+		return false, fmt.Errorf("persistence: error looking up matching logins: %w", err)
+	}
+	return len(matching) == 0, nil
+}
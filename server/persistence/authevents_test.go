@@ -0,0 +1,151 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/offen/offen/server/keys"
+)
+
+type mockAuthEventsDatabase struct {
+	mockLoginDatabase
+	events []AuthEvent
+}
+
+func (m *mockAuthEventsDatabase) CreateAuthEvent(a *AuthEvent) error {
+	m.events = append(m.events, *a)
+	return nil
+}
+
+func (m *mockAuthEventsDatabase) FindAuthEvents(q interface{}) ([]AuthEvent, error) {
+	var result []AuthEvent
+	if query, ok := q.(FindAuthEventsQueryByType); ok {
+		for _, event := range m.events {
+			if event.AccountUserID == query.AccountUserID && event.Type == query.Type &&
+				(query.IPAddress == "" || event.IPAddress == query.IPAddress) &&
+				(query.UserAgent == "" || event.UserAgent == query.UserAgent) {
+				result = append(result, event)
+			}
+		}
+		return result, nil
+	}
+	query := q.(FindAuthEventsQueryByAccountUserID)
+	for i := len(m.events) - 1; i >= 0 && len(result) < query.Limit; i-- {
+		event := m.events[i]
+		if event.AccountUserID != query.AccountUserID {
+			continue
+		}
+		if query.Before != "" && event.EventID >= query.Before {
+			continue
+		}
+		result = append(result, event)
+	}
+	return result, nil
+}
+
+func TestPersistenceLayer_RecordAuthEvent(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	accountUser, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, params, nil)
+	db := &mockAuthEventsDatabase{
+		mockLoginDatabase: mockLoginDatabase{accountUsers: []AccountUser{*accountUser}},
+	}
+	p := &persistenceLayer{dal: db, kdfParams: params}
+
+	if err := p.RecordAuthEvent(accountUser.AccountUserID, "", AuthEventLoginSucceeded, "127.0.0.1", "curl"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := p.RecordAuthEvent("", "develop@offen.dev", AuthEventLoginFailed, "127.0.0.1", "curl"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := p.RecordAuthEvent("", "other@offen.dev", AuthEventLoginFailed, "127.0.0.1", "curl"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(db.events) != 2 {
+		t.Fatalf("Expected two events to be recorded, got %v", db.events)
+	}
+	for _, event := range db.events {
+		if event.AccountUserID != accountUser.AccountUserID || event.IPAddress != "127.0.0.1" || event.UserAgent != "curl" {
+			t.Errorf("Unexpected event %v", event)
+		}
+	}
+}
+
+func TestPersistenceLayer_ListAuthEvents(t *testing.T) {
+	db := &mockAuthEventsDatabase{}
+	for i := 0; i < 5; i++ {
+		db.events = append(db.events, AuthEvent{
+			EventID:       fmt.Sprintf("event-%d", i),
+			AccountUserID: "user-a",
+			Type:          AuthEventLoginSucceeded,
+		})
+	}
+	db.events = append(db.events, AuthEvent{EventID: "event-5", AccountUserID: "user-b"})
+	p := &persistenceLayer{dal: db}
+
+	page, err := p.ListAuthEvents("user-a", "", 2)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(page.Events) != 2 || page.Events[0].EventID != "event-4" || page.Next != "event-3" {
+		t.Errorf("Unexpected first page %v", page)
+	}
+
+	page, err = p.ListAuthEvents("user-a", page.Next, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(page.Events) != 2 || page.Events[0].EventID != "event-2" || page.Next != "event-1" {
+		t.Errorf("Unexpected second page %v", page)
+	}
+
+	page, err = p.ListAuthEvents("user-a", page.Next, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(page.Events) != 1 || page.Events[0].EventID != "event-0" || page.Next != "" {
+		t.Errorf("Unexpected last page %v", page)
+	}
+
+	page, err = p.ListAuthEvents("user-c", "", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if page.Events == nil || len(page.Events) != 0 {
+		t.Errorf("Expected empty list, got %v", page)
+	}
+}
+
+func TestPersistenceLayer_IsNewLoginFingerprint(t *testing.T) {
+	tests := []struct {
+		name           string
+		events         []AuthEvent
+		ipAddress      string
+		userAgent      string
+		expectedResult bool
+	}{
+		{"first login", nil, "127.0.0.1", "curl", false},
+		{"only failed logins", []AuthEvent{{AccountUserID: "user-a", Type: AuthEventLoginFailed, IPAddress: "10.0.0.1", UserAgent: "curl"}}, "127.0.0.1", "curl", false},
+		{"known", []AuthEvent{{AccountUserID: "user-a", Type: AuthEventLoginSucceeded, IPAddress: "127.0.0.1", UserAgent: "curl"}}, "127.0.0.1", "curl", false},
+		{"new ip", []AuthEvent{{AccountUserID: "user-a", Type: AuthEventLoginSucceeded, IPAddress: "127.0.0.1", UserAgent: "curl"}}, "10.0.0.1", "curl", true},
+		{"new user agent", []AuthEvent{{AccountUserID: "user-a", Type: AuthEventLoginSucceeded, IPAddress: "127.0.0.1", UserAgent: "curl"}}, "127.0.0.1", "wget", true},
+		{"other user", []AuthEvent{
+			{AccountUserID: "user-a", Type: AuthEventLoginSucceeded, IPAddress: "10.0.0.1", UserAgent: "wget"},
+			{AccountUserID: "user-b", Type: AuthEventLoginSucceeded, IPAddress: "127.0.0.1", UserAgent: "curl"},
+		}, "127.0.0.1", "curl", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &persistenceLayer{dal: &mockAuthEventsDatabase{events: test.events}}
+			result, err := p.IsNewLoginFingerprint("user-a", test.ipAddress, test.userAgent)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if result != test.expectedResult {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
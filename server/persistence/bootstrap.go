@@ -54,7 +54,7 @@ func (p *persistenceLayer) Bootstrap(config BootstrapConfig) error {
 		if user.AllowInsecurePassword {
 			continue
 		}
-		if err := keys.ValidatePassword(user.Password); err != nil {
+		if err := p.validatePassword(user.Password, user.Email); err != nil {
 			return fmt.Errorf("persistence: error validating password for user %s: %w", user.Email, err)
 		}
 	}
@@ -77,7 +77,7 @@ func (p *persistenceLayer) Bootstrap(config BootstrapConfig) error {
 		return fmt.Errorf("persistence: error applying initial migrations: %w", err)
 	}
 
-	accounts, accountUsers, relationships, err := bootstrapAccounts(&config)
+	accounts, accountUsers, relationships, err := bootstrapAccounts(&config, p.kdfParams, p.pepper, p.emailLookup)
 	if err != nil {
 		txn.Rollback()
 		return fmt.Errorf("persistence: error creating seed data: %w", err)
@@ -106,7 +106,7 @@ func (p *persistenceLayer) Bootstrap(config BootstrapConfig) error {
 	return nil
 }
 
-func bootstrapAccounts(config *BootstrapConfig) ([]Account, []AccountUser, []AccountUserRelationship, error) {
+func bootstrapAccounts(config *BootstrapConfig, params keys.KDFParams, pepper []byte, lookup *keys.EmailLookup) ([]Account, []AccountUser, []AccountUserRelationship, error) {
 	accountCreations := []accountCreation{}
 	for _, account := range config.Accounts {
 		record, encryptionKey, err := newAccount(account.Name, account.AccountID)
@@ -123,10 +123,11 @@ func bootstrapAccounts(config *BootstrapConfig) ([]Account, []AccountUser, []Acc
 	relationshipCreations := []AccountUserRelationship{}
 
 	for _, accountUserData := range config.AccountUsers {
-		accountUser, err := newAccountUser(accountUserData.Email, accountUserData.Password, accountUserData.AdminLevel)
+		accountUser, err := newAccountUser(accountUserData.Email, accountUserData.Password, accountUserData.AdminLevel, params, pepper)
 		if err != nil {
 			return nil, nil, nil, err
 		}
+		accountUser.setEmailLookupHash(lookup, accountUserData.Email)
 		accountUserCreations = append(accountUserCreations, *accountUser)
 
 		for _, accountID := range accountUserData.Accounts {
@@ -148,7 +149,7 @@ func bootstrapAccounts(config *BootstrapConfig) ([]Account, []AccountUser, []Acc
 			if err := r.addPasswordEncryptedKey(encryptionKey, accountUser.Salt, accountUserData.Password); err != nil {
 				return nil, nil, nil, fmt.Errorf("persistence: error adding password encrypted key: %w", err)
 			}
-			if err := r.addEmailEncryptedKey(encryptionKey, accountUser.Salt, accountUserData.Email); err != nil {
+			if err := r.addEmailEncryptedKey(encryptionKey, accountUser.EmailSalt, accountUserData.Email); err != nil {
 				return nil, nil, nil, fmt.Errorf("persistence: error adding email encrypted key: %w", err)
 			}
 
@@ -162,7 +163,7 @@ func bootstrapAccounts(config *BootstrapConfig) ([]Account, []AccountUser, []Acc
 	return accounts, accountUserCreations, relationshipCreations, nil
 }
 
-func newAccountUser(email, password string, adminLevel interface{}) (*AccountUser, error) {
+func newAccountUser(email, password string, adminLevel interface{}, params keys.KDFParams, pepper []byte) (*AccountUser, error) {
 	var level AccountUserAdminLevel
 	switch c := adminLevel.(type) {
 	case int:
@@ -177,27 +178,34 @@ func newAccountUser(email, password string, adminLevel interface{}) (*AccountUse
 	if idErr != nil {
 		return nil, idErr
 	}
-	hashedEmail, hashedEmailErr := keys.HashString(email)
+	hashedEmail, hashedEmailErr := keys.HashStringWith(email, params)
 	if hashedEmailErr != nil {
 		return nil, hashedEmailErr
 	}
-	salt, saltErr := keys.NewSalt(keys.DefaultSaltLength)
+	salt, saltErr := keys.NewSaltWith(keys.DefaultSaltLength, params)
+	if saltErr != nil {
+		return nil, saltErr
+	}
+	emailSalt, saltErr := keys.NewSaltWith(keys.DefaultSaltLength, params)
 	if saltErr != nil {
 		return nil, saltErr
 	}
 	a := &AccountUser{
 		AccountUserID: accountUserID.String(),
 		Salt:          salt.Marshal(),
+		EmailSalt:     emailSalt.Marshal(),
 		AdminLevel:    level,
 		HashedEmail:   hashedEmail.Marshal(),
 	}
 
 	if password != "" {
-		hashedPw, hashedPwErr := keys.HashString(password)
+		hashedPw, hashedPwErr := keys.HashPassword(password, params, pepper)
 		if hashedPwErr != nil {
 			return nil, hashedPwErr
 		}
 		a.HashedPassword = hashedPw.Marshal()
+		now := time.Now()
+		a.PasswordChanged = &now
 	}
 
 	return a, nil
@@ -6,6 +6,8 @@ package persistence
 import (
 	"strings"
 	"testing"
+
+	"github.com/offen/offen/server/keys"
 )
 
 type mockProbeDatabase struct {
@@ -18,7 +20,7 @@ func (m *mockProbeDatabase) ProbeEmpty() bool {
 }
 
 func TestProbeEmpty(t *testing.T) {
-	p := persistenceLayer{&mockProbeDatabase{result: true}}
+	p := persistenceLayer{dal: &mockProbeDatabase{result: true}}
 	result := p.ProbeEmpty()
 	if result != true {
 		t.Errorf("Expected true, got %v", result)
@@ -49,7 +51,7 @@ func TestBootstrapAccounts(t *testing.T) {
 			},
 		},
 	}
-	accounts, accountUsers, relationships, err := bootstrapAccounts(&config)
+	accounts, accountUsers, relationships, err := bootstrapAccounts(&config, keys.DefaultKDFParams, nil, nil)
 
 	if err != nil {
 		t.Fatalf("Unexpected error %v", err)
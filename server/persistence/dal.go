@@ -3,6 +3,8 @@
 
 package persistence
 
+import "time"
+
 // DataAccessLayer provides a database agnostic interface for storing data. All
 // query methods expect certain types to be passed. In case a unknown query is
 // passed, an error can be returned early.
@@ -25,10 +27,53 @@ type DataAccessLayer interface {
 	UpdateAccountUserRelationship(*AccountUserRelationship) error
 	FindAccountUserRelationships(interface{}) ([]AccountUserRelationship, error)
 	DeleteAccountUserRelationships(interface{}) error
+	CreateWebAuthnCredential(*WebAuthnCredential) error
+	FindWebAuthnCredential(interface{}) (WebAuthnCredential, error)
+	UpdateWebAuthnCredential(*WebAuthnCredential) error
+	DeleteWebAuthnCredentials(interface{}) error
+	CreateAccessToken(*AccessToken) error
+	FindAccessToken(interface{}) (AccessToken, error)
+	FindAccessTokens(interface{}) ([]AccessToken, error)
+	DeleteAccessTokens(interface{}) error
+	CreateWebhook(*Webhook) error
+	FindWebhooks(interface{}) ([]Webhook, error)
+	DeleteWebhooks(interface{}) error
+	CreateWebhookDelivery(*WebhookDelivery) error
+	UpdateWebhookDelivery(*WebhookDelivery) error
+	FindWebhookDeliveries(interface{}) ([]WebhookDelivery, error)
+	DeleteWebhookDeliveries(interface{}) error
+	SaveRollup(*Rollup) error
+	CreateFunnel(*Funnel) error
+	FindFunnels(interface{}) ([]Funnel, error)
+	DeleteFunnels(interface{}) error
+	CreateEventType(*EventType) error
+	FindEventTypes(interface{}) ([]EventType, error)
+	DeleteEventTypes(interface{}) error
+	CreateGoal(*Goal) error
+	FindGoals(interface{}) ([]Goal, error)
+	DeleteGoals(interface{}) error
+	SaveReportSubscription(*ReportSubscription) error
+	FindReportSubscriptions(interface{}) ([]ReportSubscription, error)
+	DeleteReportSubscriptions(interface{}) error
+	FindRollups(interface{}) ([]Rollup, error)
+	DeleteRollups(interface{}) error
+	CreateServiceAccount(*ServiceAccount) error
+	FindServiceAccount(interface{}) (ServiceAccount, error)
+	FindServiceAccounts(interface{}) ([]ServiceAccount, error)
+	DeleteServiceAccounts(interface{}) error
+	CreateSession(*Session) error
+	FindSession(interface{}) (Session, error)
+	FindSessions(interface{}) ([]Session, error)
+	UpdateSession(*Session) error
+	RotateSession(*Session, string) (int64, error)
+	DeleteSessions(interface{}) error
+	CreateAuthEvent(*AuthEvent) error
+	FindAuthEvents(interface{}) ([]AuthEvent, error)
 	CreateTombstone(*Tombstone) error
 	FindTombstones(interface{}) ([]Tombstone, error)
 	Transaction() (Transaction, error)
 	ApplyMigrations() error
+	PendingMigrations() ([]string, error)
 	DropAll() error
 	ProbeEmpty() bool
 	Ping() error
@@ -36,10 +81,25 @@ type DataAccessLayer interface {
 
 // FindEventsQueryForSecretIDs requests all events that match the list of
 // secret identifiers. In case the Since value is non-zero it will be used to request
-// only events that are newer than the given ULID.
+// only events that are newer than the given ULID. After and Until limit the
+// result to events with identifiers in the given range, AccountIDs limits
+// the result to the given accounts. In case Limit is non-zero, at most Limit
+// events are returned, ordered by their identifier.
 type FindEventsQueryForSecretIDs struct {
-	SecretIDs []string
-	Since     string
+	SecretIDs  []string
+	Since      string
+	After      string
+	Until      string
+	AccountIDs []string
+	Limit      int
+}
+
+// FindEventsQueryByAccountID requests all events of the given account with
+// identifiers in the given range. From is inclusive, Until is exclusive.
+type FindEventsQueryByAccountID struct {
+	AccountID string
+	From      string
+	Until     string
 }
 
 // FindEventsQueryByEventIDs requests all events that match the given list of
@@ -61,6 +121,13 @@ type DeleteEventsQueryByEventIDs []string
 // given deadline
 type DeleteEventsQueryOlderThan string
 
+// DeleteEventsQueryByAccountIDOlderThan requests deletion of all events of
+// the given account that are older than the given deadline.
+type DeleteEventsQueryByAccountIDOlderThan struct {
+	AccountID string
+	Deadline  string
+}
+
 // DeleteSecretQueryBySecretID requests deletion of the secret record with the given
 // secret id.
 type DeleteSecretQueryBySecretID string
@@ -93,10 +160,31 @@ type FindAccountUserQueryByAccountUserIDIncludeRelationships string
 // with the given account user ID.
 type FindAccountUserRelationshipsQueryByAccountUserID string
 
+// FindAccountUserRelationshipsQueryByAccountID requests all relationships for
+// the account with the given id, including pending invitations.
+type FindAccountUserRelationshipsQueryByAccountID string
+
+// FindAccountUserRelationshipsQueryBatch requests at most Limit relationships
+// ordered by their id, starting after the given relationship id. An empty
+// value for After starts at the beginning.
+type FindAccountUserRelationshipsQueryBatch struct {
+	After string
+	Limit int
+}
+
+// FindAccountUserRelationshipsQueryStaleOneTimeKeys requests all relationships
+// holding a one time key that has expired before the given time or that does
+// not carry an expiry at all.
+type FindAccountUserRelationshipsQueryStaleOneTimeKeys time.Time
+
 // DeleteAccountUserRelationshipsQueryByAccountID requests deletion of all relationships
 // with the given account id.
 type DeleteAccountUserRelationshipsQueryByAccountID string
 
+// DeleteAccountUserRelationshipsQueryByAccountUserID requests deletion of all
+// relationships with the given account user id.
+type DeleteAccountUserRelationshipsQueryByAccountUserID string
+
 // FindAccountUsersQueryAllAccountUsers requests all account users.
 type FindAccountUsersQueryAllAccountUsers struct {
 	IncludeRelationships bool
@@ -106,6 +194,180 @@ type FindAccountUsersQueryAllAccountUsers struct {
 // RetireAccountQueryByID requests the account of the given id to be retired.
 type RetireAccountQueryByID string
 
+// FindWebAuthnCredentialQueryByID requests the WebAuthn credential of the
+// given id.
+type FindWebAuthnCredentialQueryByID string
+
+// DeleteWebAuthnCredentialsQueryByID requests deletion of the WebAuthn
+// credential of the given id in case it belongs to the given account user.
+type DeleteWebAuthnCredentialsQueryByID struct {
+	CredentialID  string
+	AccountUserID string
+}
+
+// FindAccessTokenQueryByID requests the access token of the given id.
+type FindAccessTokenQueryByID string
+
+// FindAccessTokensQueryByAccountUserID requests all access tokens of the
+// account user with the given id.
+type FindAccessTokensQueryByAccountUserID string
+
+// DeleteAccessTokensQueryByID requests deletion of the access token of the
+// given id in case it belongs to the given account user.
+type DeleteAccessTokensQueryByID struct {
+	TokenID       string
+	AccountUserID string
+}
+
+// FindWebhooksQueryByAccountID requests all webhooks of the account with the
+// given id.
+type FindWebhooksQueryByAccountID string
+
+// FindWebhooksQueryAllWebhooks requests all webhooks of all accounts.
+type FindWebhooksQueryAllWebhooks struct{}
+
+// DeleteWebhooksQueryByID requests deletion of the webhook of the given id in
+// case it belongs to the given account.
+type DeleteWebhooksQueryByID struct {
+	WebhookID string
+	AccountID string
+}
+
+// FindWebhookDeliveriesQueryByWebhookID requests the latest deliveries of
+// the webhook with the given id, newest first. In case Limit is non-zero, at
+// most Limit deliveries are returned.
+type FindWebhookDeliveriesQueryByWebhookID struct {
+	WebhookID string
+	Limit     int
+}
+
+// DeleteWebhookDeliveriesQueryByWebhookID requests deletion of all
+// deliveries of the webhook with the given id.
+type DeleteWebhookDeliveriesQueryByWebhookID string
+
+// DeleteWebhookDeliveriesQueryOlderThan requests deletion of all deliveries
+// that have been created before the given time.
+type DeleteWebhookDeliveriesQueryOlderThan time.Time
+
+// FindRollupsQueryByAccountID requests the rollups of the given account and
+// period that start in the given range. From is inclusive, Until is
+// exclusive.
+type FindRollupsQueryByAccountID struct {
+	AccountID string
+	Period    string
+	From      time.Time
+	Until     time.Time
+}
+
+// DeleteRollupsQueryByAccountID requests deletion of the rollups of the
+// given account and period that start at or after the given time.
+type DeleteRollupsQueryByAccountID struct {
+	AccountID string
+	Period    string
+	From      time.Time
+}
+
+// FindFunnelsQueryByAccountID requests all funnels of the account with the
+// given id.
+type FindFunnelsQueryByAccountID string
+
+// DeleteFunnelsQueryByID requests deletion of the funnel of the given id in
+// case it belongs to the given account.
+type DeleteFunnelsQueryByID struct {
+	FunnelID  string
+	AccountID string
+}
+
+// FindEventTypesQueryByAccountID requests all event types of the account
+// with the given id.
+type FindEventTypesQueryByAccountID string
+
+// DeleteEventTypesQueryByID requests deletion of the event type of the given
+// id in case it belongs to the given account.
+type DeleteEventTypesQueryByID struct {
+	EventTypeID string
+	AccountID   string
+}
+
+// FindReportSubscriptionsQueryByAccountUserID requests all report
+// subscriptions of the account user with the given id.
+type FindReportSubscriptionsQueryByAccountUserID string
+
+// FindReportSubscriptionsQueryByFrequency requests all report subscriptions
+// of the given frequency.
+type FindReportSubscriptionsQueryByFrequency string
+
+// DeleteReportSubscriptionsQueryByAccountID requests deletion of the
+// subscription of the given account user to the reports of the given account.
+type DeleteReportSubscriptionsQueryByAccountID struct {
+	AccountUserID string
+	AccountID     string
+}
+
+// FindGoalsQueryByAccountID requests all goals of the account with the
+// given id.
+type FindGoalsQueryByAccountID string
+
+// DeleteGoalsQueryByID requests deletion of the goal of the given id in case
+// it belongs to the given account.
+type DeleteGoalsQueryByID struct {
+	GoalID    string
+	AccountID string
+}
+
+// FindServiceAccountQueryByID requests the service account of the given id.
+type FindServiceAccountQueryByID string
+
+// FindServiceAccountsQueryAllServiceAccounts requests all service accounts.
+type FindServiceAccountsQueryAllServiceAccounts struct{}
+
+// DeleteServiceAccountsQueryByID requests deletion of the service account of
+// the given id.
+type DeleteServiceAccountsQueryByID string
+
+// FindSessionQueryByID requests the session of the given id.
+type FindSessionQueryByID string
+
+// FindSessionsQueryByAccountUserID requests all sessions of the account user
+// with the given id.
+type FindSessionsQueryByAccountUserID string
+
+// DeleteSessionsQueryByID requests deletion of the session of the given id
+// in case it belongs to the given account user.
+type DeleteSessionsQueryByID struct {
+	SessionID     string
+	AccountUserID string
+}
+
+// DeleteSessionsQueryByAccountUserID requests deletion of all sessions of the
+// account user with the given id.
+type DeleteSessionsQueryByAccountUserID string
+
+// DeleteSessionsQueryExpired requests deletion of all sessions that have
+// expired before the given time and cannot be refreshed anymore.
+type DeleteSessionsQueryExpired time.Time
+
+// FindAuthEventsQueryByAccountUserID requests at most Limit authentication
+// events of the given account user ordered by their id, most recent first.
+// In case Before is non-zero, only events older than the given id are
+// returned.
+type FindAuthEventsQueryByAccountUserID struct {
+	AccountUserID string
+	Before        string
+	Limit         int
+}
+
+// FindAuthEventsQueryByType requests at most Limit authentication events of
+// the given type for the given account user, most recent first. IPAddress and
+// UserAgent are only matched in case they are non-zero.
+type FindAuthEventsQueryByType struct {
+	AccountUserID string
+	Type          string
+	IPAddress     string
+	UserAgent     string
+	Limit         int
+}
+
 // FindTombstonesQueryByAccounts requests all tombstones for an account id that are
 // newer than the given sequence
 type FindTombstonesQueryByAccounts struct {
@@ -0,0 +1,213 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/offen/offen/server/keys"
+)
+
+// deviceKeyLength is the number of random bytes used for device keys.
+const deviceKeyLength = 32
+
+func deriveDeviceEnvelopeKey(deviceKey []byte, accountUserID string) ([]byte, error) {
+	// device keys are random values of the same size as PRF outputs, so the
+	// same derivation can be used
+	key, err := keys.DeriveKeyFromPRF(deviceKey, []byte(accountUserID))
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error deriving key from device key: %w", err)
+	}
+	return key, nil
+}
+
+// CreateDeviceKey creates a random device key and stores envelopes for all
+// key encryption keys of the account user that can be unlocked using it.
+// The device key itself is not persisted and is expected to be stored by the
+// client. Creating a new device key invalidates all previous ones.
+func (p *persistenceLayer) CreateDeviceKey(userID, emailAddress, password string) ([]byte, error) {
+	accountUser, err := p.findAccountUser(emailAddress, true, false)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	if accountUser.AccountUserID != userID {
+		return nil, errors.New("persistence: email did not match requester credentials")
+	}
+	if err := keys.ComparePassword(password, accountUser.HashedPassword, p.pepper); err != nil {
+		return nil, fmt.Errorf("persistence: passwords did not match: %w", err)
+	}
+
+	pwDerivedKey, err := keys.DeriveKey(password, accountUser.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error deriving key from password: %w", err)
+	}
+	keyEncryptionKeys := map[string][]byte{}
+	for _, relationship := range accountUser.Relationships {
+		envelope, err := keys.DecryptWith(pwDerivedKey, relationship.PasswordEncryptedKeyEncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error decrypting key encryption key: %w", err)
+		}
+		key, err := relationship.resolveKeyEncryptionKey(envelope, p.rotationKeys...)
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error resolving key encryption key: %w", err)
+		}
+		keyEncryptionKeys[relationship.RelationshipID] = key
+	}
+
+	deviceKey, err := keys.GenerateRandomBytes(deviceKeyLength)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error creating device key: %w", err)
+	}
+	envelopeKey, err := deriveDeviceEnvelopeKey(deviceKey, accountUser.AccountUserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := accountUser.setDeviceKeyEncryptionKeys(envelopeKey, keyEncryptionKeys); err != nil {
+		return nil, err
+	}
+	if err := p.dal.UpdateAccountUser(accountUser); err != nil {
+		return nil, fmt.Errorf("persistence: error updating account user: %w", err)
+	}
+	return deviceKey, nil
+}
+
+// LookupIdentity looks up the account user with the given email address after
+// it has been asserted by an external identity provider. No keys are
+// released, which requires calling UnlockDeviceKey afterwards.
+func (p *persistenceLayer) LookupIdentity(email string) (LoginResult, error) {
+	accountUser, err := p.findAccountUser(email, true, false)
+	if err != nil {
+		return LoginResult{}, ErrInvalidCredentials
+	}
+	if accountUser.Suspended {
+		return LoginResult{}, ErrAccountUserSuspended
+	}
+	result := LoginResult{
+		AccountUserID: accountUser.AccountUserID,
+		AdminLevel:    accountUser.AdminLevel,
+		Accounts:      []LoginAccountResult{},
+	}
+	for _, relationship := range accountUser.Relationships {
+		result.Accounts = append(result.Accounts, LoginAccountResult{
+			AccountID: relationship.AccountID,
+			Role:      relationship.Role,
+		})
+	}
+	return result, nil
+}
+
+// UnlockDeviceKey releases the key encryption keys of the given account user
+// using the given device key. In case accountIDs is not nil, only keys for the
+// given accounts are released. Accounts that have been shared with the
+// account user or whose keys have been rotated after the device key was
+// created are not contained in the result.
+func (p *persistenceLayer) UnlockDeviceKey(userID string, deviceKey []byte, accountIDs []string) (LoginResult, error) {
+	accountUser, err := p.dal.FindAccountUser(FindAccountUserQueryByAccountUserIDIncludeRelationships(userID))
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	if accountUser.Suspended {
+		return LoginResult{}, ErrAccountUserSuspended
+	}
+	envelopes, err := accountUser.deviceKeyEncryptionKeys()
+	if err != nil {
+		return LoginResult{}, err
+	}
+	envelopeKey, err := deriveDeviceEnvelopeKey(deviceKey, accountUser.AccountUserID)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	allowed := func(accountID string) bool {
+		if accountIDs == nil {
+			return true
+		}
+		for _, id := range accountIDs {
+			if id == accountID {
+				return true
+			}
+		}
+		return false
+	}
+
+	var results []LoginAccountResult
+	for _, relationship := range accountUser.Relationships {
+		envelope, ok := envelopes[relationship.RelationshipID]
+		if !ok || !allowed(relationship.AccountID) {
+			continue
+		}
+		decryptedKey, err := keys.DecryptWith(envelopeKey, envelope)
+		if err != nil {
+			return LoginResult{}, ErrInvalidCredentials
+		}
+		decryptedKey, err = relationship.resolveKeyEncryptionKey(decryptedKey, p.rotationKeys...)
+		if err != nil {
+			return LoginResult{}, fmt.Errorf(`persistence: error resolving key for account "%s": %w`, relationship.AccountID, err)
+		}
+		result, err := p.loginAccountResult(relationship, decryptedKey)
+		if err != nil {
+			if errors.Is(err, errStaleKeyEncryptionKey) {
+				continue
+			}
+			return LoginResult{}, err
+		}
+		results = append(results, result)
+	}
+
+	return LoginResult{
+		AccountUserID: accountUser.AccountUserID,
+		AdminLevel:    accountUser.AdminLevel,
+		Accounts:      results,
+	}, nil
+}
+
+// ProvisionIdentity looks up the account user with the given email address
+// after it has been asserted by an external identity provider. Account users
+// that do not exist yet are created without access to any account, so that
+// accounts can be shared with them later on. In case adminLevel is not nil,
+// the admin level of the account user is updated to match.
+func (p *persistenceLayer) ProvisionIdentity(email string, adminLevel *AccountUserAdminLevel) (LoginResult, error) {
+	accountUsers, err := p.dal.FindAccountUsers(FindAccountUsersQueryAllAccountUsers{
+		IncludeRelationships: true,
+	})
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("persistence: error looking up account users: %w", err)
+	}
+	accountUser, err := p.selectAccountUser(accountUsers, email)
+	if err != nil {
+		var level AccountUserAdminLevel
+		if adminLevel != nil {
+			level = *adminLevel
+		}
+		accountUser, err = newAccountUser(email, "", level, p.kdfParams, p.pepper)
+		if err != nil {
+			return LoginResult{}, fmt.Errorf("persistence: error creating account user: %w", err)
+		}
+		accountUser.setEmailLookupHash(p.emailLookup, email)
+		if err := p.dal.CreateAccountUser(accountUser); err != nil {
+			return LoginResult{}, fmt.Errorf("persistence: error persisting account user: %w", err)
+		}
+	} else if accountUser.Suspended {
+		return LoginResult{}, ErrAccountUserSuspended
+	} else if adminLevel != nil && accountUser.AdminLevel != *adminLevel {
+		accountUser.AdminLevel = *adminLevel
+		if err := p.dal.UpdateAccountUser(accountUser); err != nil {
+			return LoginResult{}, fmt.Errorf("persistence: error updating account user: %w", err)
+		}
+	}
+
+	result := LoginResult{
+		AccountUserID: accountUser.AccountUserID,
+		AdminLevel:    accountUser.AdminLevel,
+		Accounts:      []LoginAccountResult{},
+	}
+	for _, relationship := range accountUser.Relationships {
+		result.Accounts = append(result.Accounts, LoginAccountResult{
+			AccountID: relationship.AccountID,
+			Role:      relationship.Role,
+		})
+	}
+	return result, nil
+}
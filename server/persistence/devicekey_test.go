@@ -0,0 +1,129 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/offen/offen/server/keys"
+)
+
+type mockDeviceKeyDatabase struct {
+	mockWebAuthnDatabase
+}
+
+func (m *mockDeviceKeyDatabase) UpdateAccountUser(a *AccountUser) error {
+	m.accountUser = *a
+	return nil
+}
+
+func TestPersistenceLayer_DeviceKey(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	keyEncryptionKey, _ := keys.GenerateRandomBytes(keys.DefaultEncryptionKeySize)
+	encryptedPrivateKey, _ := keys.EncryptWith(keyEncryptionKey, []byte("private-key"))
+
+	accountUser, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, params, nil)
+	relationship, _ := newAccountUserRelationship(accountUser.AccountUserID, "account-a")
+	relationship.addPasswordEncryptedKey(keyEncryptionKey, accountUser.Salt, "develop")
+	accountUser.Relationships = []AccountUserRelationship{*relationship}
+
+	db := &mockDeviceKeyDatabase{mockWebAuthnDatabase{
+		accountUser: *accountUser,
+		account:     Account{AccountID: "account-a", Name: "name", EncryptedPrivateKey: encryptedPrivateKey.Marshal()},
+	}}
+	p := &persistenceLayer{dal: db, kdfParams: params}
+
+	if _, err := p.CreateDeviceKey("other-user", "develop@offen.dev", "develop"); err == nil {
+		t.Error("Expected error when creating device key for other user")
+	}
+	if _, err := p.CreateDeviceKey(accountUser.AccountUserID, "develop@offen.dev", "other"); err == nil {
+		t.Error("Expected error when creating device key with bad password")
+	}
+	deviceKey, err := p.CreateDeviceKey(accountUser.AccountUserID, "develop@offen.dev", "develop")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	identity, err := p.LookupIdentity("develop@offen.dev")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if identity.AccountUserID != accountUser.AccountUserID || len(identity.Accounts) != 1 || identity.Accounts[0].KeyEncryptionKey != nil {
+		t.Errorf("Unexpected identity %v", identity)
+	}
+	if _, err := p.LookupIdentity("other@offen.dev"); err != ErrInvalidCredentials {
+		t.Errorf("Expected invalid credentials, got %v", err)
+	}
+
+	result, err := p.UnlockDeviceKey(accountUser.AccountUserID, deviceKey, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result.Accounts) != 1 || result.Accounts[0].AccountID != "account-a" {
+		t.Errorf("Unexpected result %v", result)
+	}
+
+	result, err = p.UnlockDeviceKey(accountUser.AccountUserID, deviceKey, []string{"account-b"})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result.Accounts) != 0 {
+		t.Errorf("Expected accounts to be filtered, got %v", result)
+	}
+
+	if _, err := p.UnlockDeviceKey(accountUser.AccountUserID, bytes.Repeat([]byte("x"), 32), nil); err != ErrInvalidCredentials {
+		t.Errorf("Expected invalid credentials, got %v", err)
+	}
+}
+
+type mockProvisionDatabase struct {
+	mockDeviceKeyDatabase
+	created *AccountUser
+}
+
+func (m *mockProvisionDatabase) CreateAccountUser(a *AccountUser) error {
+	m.created = a
+	return nil
+}
+
+func TestPersistenceLayer_ProvisionIdentity(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	accountUser, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevel(0), params, nil)
+	relationship, _ := newAccountUserRelationship(accountUser.AccountUserID, "account-a")
+	accountUser.Relationships = []AccountUserRelationship{*relationship}
+
+	db := &mockProvisionDatabase{
+		mockDeviceKeyDatabase: mockDeviceKeyDatabase{mockWebAuthnDatabase{accountUser: *accountUser}},
+	}
+	p := &persistenceLayer{dal: db, kdfParams: params}
+
+	result, err := p.ProvisionIdentity("develop@offen.dev", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if result.AccountUserID != accountUser.AccountUserID || len(result.Accounts) != 1 || result.AdminLevel != AccountUserAdminLevel(0) {
+		t.Errorf("Unexpected result %v", result)
+	}
+
+	level := AccountUserAdminLevelSuperAdmin
+	result, err = p.ProvisionIdentity("develop@offen.dev", &level)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if result.AdminLevel != AccountUserAdminLevelSuperAdmin || db.accountUser.AdminLevel != AccountUserAdminLevelSuperAdmin {
+		t.Errorf("Expected admin level to be updated, got %v", result)
+	}
+
+	result, err = p.ProvisionIdentity("other@offen.dev", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if db.created == nil || result.AccountUserID != db.created.AccountUserID || len(result.Accounts) != 0 {
+		t.Errorf("Expected account user to be created, got %v", result)
+	}
+	if db.created.HashedPassword != "" {
+		t.Errorf("Expected provisioned account user not to have a password")
+	}
+}
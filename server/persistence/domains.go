@@ -0,0 +1,107 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var hostnamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// NormalizeDomain returns the lowercase host name of the given domain. The
+// domain can also be given as an origin or URL, in which case scheme, port
+// and path are discarded.
+func NormalizeDomain(domain string) (string, error) {
+	value := strings.ToLower(strings.TrimSpace(domain))
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("persistence: invalid domain %s: %w", domain, err)
+	}
+	host := strings.TrimSuffix(u.Hostname(), ".")
+	if u.User != nil || !hostnamePattern.MatchString(host) || len(host) > 253 {
+		return "", fmt.Errorf("persistence: domain %s is expected to be a valid host name", domain)
+	}
+	return host, nil
+}
+
+func (a *Account) domains() ([]string, error) {
+	var domains []string
+	if a.Domains == "" {
+		return domains, nil
+	}
+	if err := json.Unmarshal([]byte(a.Domains), &domains); err != nil {
+		return nil, fmt.Errorf("persistence: error decoding domains: %w", err)
+	}
+	return domains, nil
+}
+
+// SetDomains sets the domains the account collects data on. Passing an
+// empty list removes all domains, which allows collecting data on any
+// domain. The normalized list of domains is returned.
+func (p *persistenceLayer) SetDomains(accountID string, domains []string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, domain := range domains {
+		value, err := NormalizeDomain(domain)
+		if err != nil {
+			return nil, err
+		}
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		normalized = append(normalized, value)
+	}
+
+	account, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID))
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+	}
+	account.Domains = ""
+	if len(normalized) != 0 {
+		b, err := json.Marshal(normalized)
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error encoding domains: %w", err)
+		}
+		account.Domains = string(b)
+	}
+	if err := p.dal.UpdateAccount(&account); err != nil {
+		return nil, fmt.Errorf("persistence: error updating account: %w", err)
+	}
+	return normalized, nil
+}
+
+// IsDomainAllowed checks whether the account with the given id collects data
+// on pages of the given domain. Accounts that have not registered any
+// domains collect data on all domains.
+func (p *persistenceLayer) IsDomainAllowed(accountID, domain string) (bool, error) {
+	account, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID))
+	if err != nil {
+		return false, fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+	}
+	domains, err := account.domains()
+	if err != nil {
+		return false, err
+	}
+	if len(domains) == 0 {
+		return true, nil
+	}
+	domain, err = NormalizeDomain(domain)
+	if err != nil {
+		return false, nil
+	}
+	for _, value := range domains {
+		if value == domain {
+			return true, nil
+		}
+	}
+	return false, nil
+}
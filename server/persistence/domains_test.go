@@ -0,0 +1,106 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		domain         string
+		expectedResult string
+		expectError    bool
+	}{
+		{"www.offen.dev", "www.offen.dev", false},
+		{" WWW.Offen.dev. ", "www.offen.dev", false},
+		{"https://shop.offen.dev:8443/", "shop.offen.dev", false},
+		{"localhost:8080", "localhost", false},
+		{"", "", true},
+		{"offen dev", "", true},
+		{"-offen.dev", "", true},
+		{"https://user@offen.dev", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.domain, func(t *testing.T) {
+			result, err := NormalizeDomain(test.domain)
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if result != test.expectedResult {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_SetDomains(t *testing.T) {
+	tests := []struct {
+		name           string
+		db             *mockOriginsDatabase
+		domains        []string
+		expectedResult []string
+		expectedStored string
+		expectError    bool
+	}{
+		{
+			"ok",
+			&mockOriginsDatabase{},
+			[]string{"www.offen.dev", "https://WWW.offen.dev", "shop.offen.dev"},
+			[]string{"www.offen.dev", "shop.offen.dev"},
+			`["www.offen.dev","shop.offen.dev"]`,
+			false,
+		},
+		{"empty", &mockOriginsDatabase{findAccountResult: Account{Domains: `["www.offen.dev"]`}}, nil, []string{}, "", false},
+		{"bad domain", &mockOriginsDatabase{}, []string{"offen dev"}, nil, "", true},
+		{"lookup error", &mockOriginsDatabase{findAccountErr: ErrUnknownAccount("did not work")}, []string{"www.offen.dev"}, nil, "", true},
+		{"update error", &mockOriginsDatabase{updateErr: errors.New("did not work")}, []string{"www.offen.dev"}, nil, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := persistenceLayer{dal: test.db}
+			result, err := p.SetDomains("account-a", test.domains)
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if !reflect.DeepEqual(test.expectedResult, result) {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+			if err == nil && test.db.updated.Domains != test.expectedStored {
+				t.Errorf("Expected stored value %v, got %v", test.expectedStored, test.db.updated.Domains)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_IsDomainAllowed(t *testing.T) {
+	registered := Account{Domains: `["www.offen.dev","shop.offen.dev"]`}
+	tests := []struct {
+		name           string
+		db             *mockOriginsDatabase
+		domain         string
+		expectedResult bool
+		expectError    bool
+	}{
+		{"no domains", &mockOriginsDatabase{}, "www.example.net", true, false},
+		{"registered", &mockOriginsDatabase{findAccountResult: registered}, "SHOP.offen.dev", true, false},
+		{"other domain", &mockOriginsDatabase{findAccountResult: registered}, "offen.dev", false, false},
+		{"bad domain", &mockOriginsDatabase{findAccountResult: registered}, "offen dev", false, false},
+		{"lookup error", &mockOriginsDatabase{findAccountErr: ErrUnknownAccount("did not work")}, "www.offen.dev", false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := persistenceLayer{dal: test.db}
+			result, err := p.IsDomainAllowed("account-a", test.domain)
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if result != test.expectedResult {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
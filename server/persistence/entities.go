@@ -4,8 +4,10 @@
 package persistence
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,6 +25,9 @@ type Event struct {
 	SecretID *string
 	Payload  string
 	Secret   Secret
+	// Weight is the number of events the event represents in case it has
+	// been stored while sampling. Unsampled events have a weight of 1 or 0.
+	Weight int
 }
 
 // A Tombstone replaces an event on its deletion
@@ -51,15 +56,377 @@ const (
 	AccountUserAdminLevelSuperAdmin AccountUserAdminLevel = 1
 )
 
+// AccountUserRole describes the privileges an account user has been granted
+// for a single account. SuperAdmins are granted all privileges for each of
+// their accounts, regardless of their role.
+type AccountUserRole int
+
+const (
+	// AccountUserRoleViewer can view the data of an account.
+	AccountUserRoleViewer AccountUserRole = iota
+	// AccountUserRoleAdmin can additionally rotate the keys of an account,
+	// invite other account users and delete the account.
+	AccountUserRoleAdmin
+)
+
 // AccountUser is a person that can log in and access data related to all
 // associated accounts.
 type AccountUser struct {
-	AccountUserID  string
-	HashedEmail    string
-	HashedPassword string
-	Salt           string
-	AdminLevel     AccountUserAdminLevel
-	Relationships  []AccountUserRelationship
+	AccountUserID    string
+	HashedEmail      string
+	EmailLookupHash  string
+	EmailLookupKeyID string
+	HashedPassword   string
+	// PasswordChanged is the time at which the password has last been set.
+	// It is nil for account users that have not set a password yet.
+	PasswordChanged *time.Time
+	// PasswordHistory is a JSON encoded list of hashes of previous
+	// passwords, most recent first.
+	PasswordHistory string
+	Salt            string
+	EmailSalt       string
+	AdminLevel      AccountUserAdminLevel
+	// EncryptedSecondFactor is the TOTP secret of the account user, encrypted
+	// using the key derived from its email address. It is only checked on
+	// login once SecondFactorEnabled is set.
+	EncryptedSecondFactor string
+	SecondFactorEnabled   bool
+	// SecondFactorRecoveryCodes is a JSON encoded list of hashed single use
+	// codes that can be used in place of a TOTP code.
+	SecondFactorRecoveryCodes string
+	// DeviceEncryptedKeyEncryptionKeys is a JSON encoded map of relationship
+	// ids and key encryption key envelopes that are encrypted using a key
+	// derived from a device key. The device key itself is only stored by
+	// the client and is used for releasing keys after logging in with an
+	// external identity provider.
+	DeviceEncryptedKeyEncryptionKeys string
+	// PendingEmailChange is a JSON encoded change of the email address that
+	// is applied once the account user has confirmed it.
+	PendingEmailChange string
+	// Suspended account users cannot log in and their existing sessions
+	// and access tokens are rejected.
+	Suspended bool
+	// AllowedNetworks is a JSON encoded list of networks in CIDR notation the
+	// account user is allowed to use the application from. An empty value
+	// does not restrict access.
+	AllowedNetworks string
+	// SecondaryEmails is a JSON encoded list of additional email addresses
+	// that can be used for resetting the password.
+	SecondaryEmails string
+	Relationships   []AccountUserRelationship
+}
+
+// canManageAccount checks whether the account user is allowed to perform
+// administrative tasks for the account of the given id.
+func (a *AccountUser) canManageAccount(accountID string) bool {
+	for _, relationship := range a.Relationships {
+		if relationship.AccountID == accountID {
+			return a.AdminLevel == AccountUserAdminLevelSuperAdmin || relationship.Role == AccountUserRoleAdmin
+		}
+	}
+	return false
+}
+
+// canAccessAccount checks whether the account user is allowed to view the
+// account of the given id.
+func (a *AccountUser) canAccessAccount(accountID string) bool {
+	if a.AdminLevel == AccountUserAdminLevelSuperAdmin {
+		return true
+	}
+	for _, relationship := range a.Relationships {
+		if relationship.AccountID == accountID {
+			return true
+		}
+	}
+	return false
+}
+
+// emailSalt returns the salt used for deriving keys from the account user's
+// email address. Account users that have been created before salts were
+// issued per purpose use the same salt for password and email.
+func (a *AccountUser) emailSalt() string {
+	if a.EmailSalt != "" {
+		return a.EmailSalt
+	}
+	return a.Salt
+}
+
+// setSecondFactorSecret encrypts the given TOTP secret using the given email
+// derived key.
+func (a *AccountUser) setSecondFactorSecret(emailDerivedKey, secret []byte) error {
+	encryptedSecret, err := keys.EncryptWith(emailDerivedKey, secret)
+	if err != nil {
+		return fmt.Errorf("persistence: error encrypting second factor: %w", err)
+	}
+	a.EncryptedSecondFactor = encryptedSecret.Marshal()
+	return nil
+}
+
+// verifySecondFactor checks the given code against the TOTP secret of the
+// account user.
+func (a *AccountUser) verifySecondFactor(emailDerivedKey []byte, code string, now time.Time) error {
+	if a.EncryptedSecondFactor == "" {
+		return errors.New("persistence: account user has not enrolled a second factor")
+	}
+	secret, err := keys.DecryptWith(emailDerivedKey, a.EncryptedSecondFactor)
+	if err != nil {
+		return fmt.Errorf("persistence: error decrypting second factor: %w", err)
+	}
+	if !keys.ValidateTOTP(secret, code, now) {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// recoveryCode is a hashed recovery code. Consumed codes are kept so account
+// users can tell when their codes have been used.
+type recoveryCode struct {
+	Hash     string     `json:"hash"`
+	Consumed *time.Time `json:"consumed,omitempty"`
+}
+
+func (a *AccountUser) recoveryCodes() ([]recoveryCode, error) {
+	var codes []recoveryCode
+	if a.SecondFactorRecoveryCodes == "" {
+		return codes, nil
+	}
+	if err := json.Unmarshal([]byte(a.SecondFactorRecoveryCodes), &codes); err != nil {
+		return nil, fmt.Errorf("persistence: error decoding recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+func (a *AccountUser) saveRecoveryCodes(codes []recoveryCode) error {
+	b, err := json.Marshal(codes)
+	if err != nil {
+		return fmt.Errorf("persistence: error encoding recovery codes: %w", err)
+	}
+	a.SecondFactorRecoveryCodes = string(b)
+	return nil
+}
+
+// pendingEmailChange contains all values that are updated once an account
+// user confirms a change of its email address. Key envelopes are already
+// encrypted using a key derived from the new email address.
+type pendingEmailChange struct {
+	HashedToken           string            `json:"hashedToken"`
+	Expires               time.Time         `json:"expires"`
+	HashedEmail           string            `json:"hashedEmail"`
+	EmailEncryptedKeys    map[string]string `json:"emailEncryptedKeys"`
+	EncryptedSecondFactor string            `json:"encryptedSecondFactor,omitempty"`
+	PreviousSecondFactor  string            `json:"previousSecondFactor,omitempty"`
+}
+
+func (a *AccountUser) pendingEmailChange() (*pendingEmailChange, error) {
+	if a.PendingEmailChange == "" {
+		return nil, nil
+	}
+	var change pendingEmailChange
+	if err := json.Unmarshal([]byte(a.PendingEmailChange), &change); err != nil {
+		return nil, fmt.Errorf("persistence: error decoding pending email change: %w", err)
+	}
+	return &change, nil
+}
+
+func (a *AccountUser) savePendingEmailChange(change *pendingEmailChange) error {
+	if change == nil {
+		a.PendingEmailChange = ""
+		return nil
+	}
+	b, err := json.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("persistence: error encoding pending email change: %w", err)
+	}
+	a.PendingEmailChange = string(b)
+	return nil
+}
+
+func (a *AccountUser) allowedNetworks() ([]string, error) {
+	var networks []string
+	if a.AllowedNetworks == "" {
+		return networks, nil
+	}
+	if err := json.Unmarshal([]byte(a.AllowedNetworks), &networks); err != nil {
+		return nil, fmt.Errorf("persistence: error decoding allowed networks: %w", err)
+	}
+	return networks, nil
+}
+
+func (a *Account) allowedOrigins() ([]string, error) {
+	var origins []string
+	if a.AllowedOrigins == "" {
+		return origins, nil
+	}
+	if err := json.Unmarshal([]byte(a.AllowedOrigins), &origins); err != nil {
+		return nil, fmt.Errorf("persistence: error decoding allowed origins: %w", err)
+	}
+	return origins, nil
+}
+
+func (a *AccountUser) passwordHistory() ([]string, error) {
+	var hashes []string
+	if a.PasswordHistory == "" {
+		return hashes, nil
+	}
+	if err := json.Unmarshal([]byte(a.PasswordHistory), &hashes); err != nil {
+		return nil, fmt.Errorf("persistence: error decoding password history: %w", err)
+	}
+	return hashes, nil
+}
+
+// reusesPassword checks whether the given password matches one of the last
+// depth passwords of the account user, including the current one.
+func (a *AccountUser) reusesPassword(password string, depth int, pepper []byte) (bool, error) {
+	if depth <= 0 {
+		return false, nil
+	}
+	history, err := a.passwordHistory()
+	if err != nil {
+		return false, err
+	}
+	hashes := append([]string{a.HashedPassword}, history...)
+	if len(hashes) > depth {
+		hashes = hashes[:depth]
+	}
+	for _, hash := range hashes {
+		if hash == "" {
+			continue
+		}
+		if err := keys.ComparePassword(password, hash, pepper); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// setPassword replaces the hashed password of the account user and moves
+// the previous hash into its history, keeping as many entries as needed
+// for checking the given depth.
+func (a *AccountUser) setPassword(hashedPassword string, depth int, now time.Time) error {
+	var history []string
+	if depth > 1 {
+		previous, err := a.passwordHistory()
+		if err != nil {
+			return err
+		}
+		if a.HashedPassword != "" {
+			previous = append([]string{a.HashedPassword}, previous...)
+		}
+		if len(previous) > depth-1 {
+			previous = previous[:depth-1]
+		}
+		history = previous
+	}
+	a.PasswordHistory = ""
+	if len(history) != 0 {
+		b, err := json.Marshal(history)
+		if err != nil {
+			return fmt.Errorf("persistence: error encoding password history: %w", err)
+		}
+		a.PasswordHistory = string(b)
+	}
+	a.HashedPassword = hashedPassword
+	a.PasswordChanged = &now
+	return nil
+}
+
+func (a *AccountUser) setDeviceKeyEncryptionKeys(envelopeKey []byte, keyEncryptionKeys map[string][]byte) error {
+	envelopes := map[string]string{}
+	for relationshipID, keyEncryptionKey := range keyEncryptionKeys {
+		encryptedKey, err := keys.EncryptWith(envelopeKey, keyEncryptionKey)
+		if err != nil {
+			return fmt.Errorf("persistence: error encrypting key for device: %w", err)
+		}
+		envelopes[relationshipID] = encryptedKey.Marshal()
+	}
+	b, err := json.Marshal(envelopes)
+	if err != nil {
+		return fmt.Errorf("persistence: error encoding device envelopes: %w", err)
+	}
+	a.DeviceEncryptedKeyEncryptionKeys = string(b)
+	return nil
+}
+
+func (a *AccountUser) deviceKeyEncryptionKeys() (map[string]string, error) {
+	envelopes := map[string]string{}
+	if a.DeviceEncryptedKeyEncryptionKeys == "" {
+		return envelopes, nil
+	}
+	if err := json.Unmarshal([]byte(a.DeviceEncryptedKeyEncryptionKeys), &envelopes); err != nil {
+		return nil, fmt.Errorf("persistence: error decoding device envelopes: %w", err)
+	}
+	return envelopes, nil
+}
+
+// generateRecoveryCodes replaces all recovery codes of the account user with
+// the given number of new codes and returns their plaintext values.
+func (a *AccountUser) generateRecoveryCodes(count int, params keys.KDFParams) ([]string, error) {
+	var plaintext []string
+	var codes []recoveryCode
+	for i := 0; i < count; i++ {
+		code, err := keys.GenerateRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error creating recovery code: %w", err)
+		}
+		normalized, _ := keys.NormalizeRecoveryCode(code)
+		hash, err := keys.HashStringWith(normalized, params)
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error hashing recovery code: %w", err)
+		}
+		plaintext = append(plaintext, code)
+		codes = append(codes, recoveryCode{Hash: hash.Marshal()})
+	}
+	if err := a.saveRecoveryCodes(codes); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// consumeRecoveryCode marks the given recovery code as used. An error is
+// returned in case the code is unknown or has been used before.
+func (a *AccountUser) consumeRecoveryCode(code string, now time.Time) error {
+	normalized, ok := keys.NormalizeRecoveryCode(code)
+	if !ok {
+		return ErrInvalidCredentials
+	}
+	codes, err := a.recoveryCodes()
+	if err != nil {
+		return err
+	}
+	for idx, candidate := range codes {
+		if candidate.Consumed != nil {
+			continue
+		}
+		if err := keys.CompareString(normalized, candidate.Hash); err != nil {
+			continue
+		}
+		codes[idx].Consumed = &now
+		return a.saveRecoveryCodes(codes)
+	}
+	return ErrInvalidCredentials
+}
+
+// rewrapSecondFactor re-encrypts the TOTP secret of the account user after
+// the key derived from its email address has changed.
+func (a *AccountUser) rewrapSecondFactor(currentKey, nextKey []byte) error {
+	if a.EncryptedSecondFactor == "" {
+		return nil
+	}
+	secret, err := keys.DecryptWith(currentKey, a.EncryptedSecondFactor)
+	if err != nil {
+		return fmt.Errorf("persistence: error decrypting second factor: %w", err)
+	}
+	return a.setSecondFactorSecret(nextKey, secret)
+}
+
+// setEmailLookupHash hashes the given email address using the current
+// lookup key. In case no lookup is given, nothing happens.
+func (a *AccountUser) setEmailLookupHash(lookup *keys.EmailLookup, email string) {
+	if lookup == nil {
+		return
+	}
+	a.EmailLookupHash = lookup.Hash(email)
+	a.EmailLookupKeyID = lookup.KeyID()
 }
 
 // AccountUserRelationship contains the encrypted KeyEncryptionKeys needed for
@@ -71,6 +438,16 @@ type AccountUserRelationship struct {
 	PasswordEncryptedKeyEncryptionKey string
 	EmailEncryptedKeyEncryptionKey    string
 	OneTimeEncryptedKeyEncryptionKey  string
+	// OneTimeKeyExpires is the time after which the one time key cannot be
+	// used anymore, OneTimeKeyConsumed signals it has already been used.
+	OneTimeKeyExpires  *time.Time
+	OneTimeKeyConsumed bool
+	// In case the account's key encryption key has been rotated by another
+	// account user, the relationship's envelopes still contain the previous
+	// key. This field then contains the chain of rotations that needs to be
+	// applied to get to the currently used key.
+	KeyEncryptionKeyRotations string
+	Role                      AccountUserRole
 	// this cache is used to prevent deriving the same email or password based
 	// key over and over again when updating a large number of relationships
 	keyCache     map[string][]byte
@@ -107,15 +484,43 @@ func (a *AccountUserRelationship) setCacheItem(key string, value []byte) {
 	a.keyCache[key] = value
 }
 
-func (a *AccountUserRelationship) addOneTimeEncryptedKey(encryptionKey, oneTimeKey []byte) error {
+func (a *AccountUserRelationship) addOneTimeEncryptedKey(encryptionKey, oneTimeKey []byte, expires time.Time) error {
 	oneTimeEncryptedKey, encryptErr := keys.EncryptWith(oneTimeKey, encryptionKey)
 	if encryptErr != nil {
 		return fmt.Errorf("persistence: error adding one time key to relationship %w", encryptErr)
 	}
 	a.OneTimeEncryptedKeyEncryptionKey = oneTimeEncryptedKey.Marshal()
+	a.OneTimeKeyExpires = &expires
+	a.OneTimeKeyConsumed = false
 	return nil
 }
 
+// consumeOneTimeKey decrypts the key encryption key using the given one time
+// key and removes the one time key from the relationship, so it cannot be
+// used again. Expired and already consumed keys are rejected.
+func (a *AccountUserRelationship) consumeOneTimeKey(oneTimeKey []byte, now time.Time) ([]byte, error) {
+	if a.OneTimeKeyConsumed {
+		return nil, ErrInvalidOneTimeKey("persistence: one time key has already been used")
+	}
+	// one time keys that have been created before expiry was introduced
+	// do not carry an expiry and are considered expired
+	if a.OneTimeKeyExpires == nil || now.After(*a.OneTimeKeyExpires) {
+		return nil, ErrInvalidOneTimeKey("persistence: one time key has expired")
+	}
+	key, err := keys.DecryptWith(oneTimeKey, a.OneTimeEncryptedKeyEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error decrypting key encryption key: %w", err)
+	}
+	a.clearOneTimeKey()
+	a.OneTimeKeyConsumed = true
+	return key, nil
+}
+
+func (a *AccountUserRelationship) clearOneTimeKey() {
+	a.OneTimeEncryptedKeyEncryptionKey = ""
+	a.OneTimeKeyExpires = nil
+}
+
 func (a *AccountUserRelationship) addEmailEncryptedKey(encryptionKey []byte, versionedSalt, emailAddress string) error {
 	emailDerivedKey := a.getCacheItem(emailAddress + versionedSalt)
 	if emailDerivedKey == nil {
@@ -152,16 +557,195 @@ func (a *AccountUserRelationship) addPasswordEncryptedKey(encryptionKey []byte,
 	return nil
 }
 
+// appendKeyRotation appends a rotation from the given previous key to the
+// given next key to the relationship's chain of pending rotations, so the
+// next key can only be derived by holders of the relationship's envelopes.
+// A pending rotation that has been encrypted using a rotation key cannot be
+// extended as the key in the relationship's envelopes is unknown. It is
+// replaced using the first of the given rotation keys instead.
+func (a *AccountUserRelationship) appendKeyRotation(previousKey, nextKey []byte, rotationKeys ...[]byte) error {
+	if a.KeyEncryptionKeyRotations != "" && !strings.HasPrefix(a.KeyEncryptionKeyRotations, "[") {
+		if len(rotationKeys) == 0 {
+			return errors.New("persistence: replacing pending key rotation requires a rotation secret to be configured")
+		}
+		return a.addKeyRotation(rotationKeys[0], nextKey)
+	}
+	var chain []string
+	if a.KeyEncryptionKeyRotations != "" {
+		if err := json.Unmarshal([]byte(a.KeyEncryptionKeyRotations), &chain); err != nil {
+			return fmt.Errorf("persistence: error decoding pending key rotations: %w", err)
+		}
+	}
+	rotation, err := keys.EncryptWith(previousKey, nextKey)
+	if err != nil {
+		return fmt.Errorf("persistence: error encrypting rotated key: %w", err)
+	}
+	chain = append(chain, rotation.Marshal())
+	b, _ := json.Marshal(chain)
+	a.KeyEncryptionKeyRotations = string(b)
+	return nil
+}
+
+// addKeyRotation sets the given next key as the pending key encryption key
+// of the relationship, encrypted using the given rotation key. Any pending
+// rotation is replaced.
+func (a *AccountUserRelationship) addKeyRotation(rotationKey, nextKey []byte) error {
+	rotation, err := keys.EncryptWith(rotationKey, nextKey)
+	if err != nil {
+		return fmt.Errorf("persistence: error encrypting rotated key: %w", err)
+	}
+	a.KeyEncryptionKeyRotations = rotation.Marshal()
+	return nil
+}
+
+// resolveKeyEncryptionKey returns the key encryption key that is currently
+// in use for the account in case a rotation is pending. Otherwise the given
+// key, which has been decrypted from one of the relationship's envelopes, is
+// returned. Pending rotations are decrypted using any of the given rotation
+// keys.
+func (a *AccountUserRelationship) resolveKeyEncryptionKey(key []byte, rotationKeys ...[]byte) ([]byte, error) {
+	if a.KeyEncryptionKeyRotations == "" {
+		return key, nil
+	}
+	if strings.HasPrefix(a.KeyEncryptionKeyRotations, "[") {
+		return a.resolveKeyRotationChain(key)
+	}
+	for _, rotationKey := range rotationKeys {
+		if next, err := keys.DecryptWith(rotationKey, a.KeyEncryptionKeyRotations); err == nil {
+			return next, nil
+		}
+	}
+	return nil, errors.New("persistence: unable to decrypt pending key rotation using any of the rotation keys")
+}
+
+// rewrapKeyRotation re-encrypts a pending rotation that has been encrypted
+// using a previous rotation key using the first of the given keys. It
+// returns whether the relationship has been changed.
+func (a *AccountUserRelationship) rewrapKeyRotation(rotationKeys ...[]byte) (bool, error) {
+	if a.KeyEncryptionKeyRotations == "" || strings.HasPrefix(a.KeyEncryptionKeyRotations, "[") || len(rotationKeys) == 0 {
+		return false, nil
+	}
+	if _, err := keys.DecryptWith(rotationKeys[0], a.KeyEncryptionKeyRotations); err == nil {
+		return false, nil
+	}
+	next, err := a.resolveKeyEncryptionKey(nil, rotationKeys[1:]...)
+	if err != nil {
+		return false, err
+	}
+	if err := a.addKeyRotation(rotationKeys[0], next); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// resolveKeyRotationChain applies a chain of pending rotations. Each element
+// contains the next key encrypted using the previous one.
+func (a *AccountUserRelationship) resolveKeyRotationChain(key []byte) ([]byte, error) {
+	var chain []string
+	if err := json.Unmarshal([]byte(a.KeyEncryptionKeyRotations), &chain); err != nil {
+		return nil, fmt.Errorf("persistence: error decoding pending key rotations: %w", err)
+	}
+	for _, rotation := range chain {
+		next, err := keys.DecryptWith(key, rotation)
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error applying key rotation: %w", err)
+		}
+		key = next
+	}
+	return key, nil
+}
+
+// WebAuthnCredential is a public key credential an account user can use for
+// logging in instead of a password. Key encryption keys are released using
+// envelopes that are encrypted using a key derived from the output of the
+// authenticator's pseudo random function.
+type WebAuthnCredential struct {
+	CredentialID  string
+	AccountUserID string
+	PublicKey     string
+	SignCount     uint32
+	// EncryptedKeyEncryptionKeys is a JSON encoded map of relationship ids
+	// and the key encryption key envelopes for the respective relationship.
+	EncryptedKeyEncryptionKeys string
+	Created                    time.Time
+}
+
+func (w *WebAuthnCredential) addKeyEncryptionKey(envelopeKey []byte, relationshipID string, keyEncryptionKey []byte) error {
+	envelopes := map[string]string{}
+	if w.EncryptedKeyEncryptionKeys != "" {
+		if err := json.Unmarshal([]byte(w.EncryptedKeyEncryptionKeys), &envelopes); err != nil {
+			return fmt.Errorf("persistence: error decoding credential envelopes: %w", err)
+		}
+	}
+	encryptedKey, err := keys.EncryptWith(envelopeKey, keyEncryptionKey)
+	if err != nil {
+		return fmt.Errorf("persistence: error encrypting key for credential: %w", err)
+	}
+	envelopes[relationshipID] = encryptedKey.Marshal()
+	b, err := json.Marshal(envelopes)
+	if err != nil {
+		return fmt.Errorf("persistence: error encoding credential envelopes: %w", err)
+	}
+	w.EncryptedKeyEncryptionKeys = string(b)
+	return nil
+}
+
+func (w *WebAuthnCredential) keyEncryptionKeys() (map[string]string, error) {
+	envelopes := map[string]string{}
+	if w.EncryptedKeyEncryptionKeys == "" {
+		return envelopes, nil
+	}
+	if err := json.Unmarshal([]byte(w.EncryptedKeyEncryptionKeys), &envelopes); err != nil {
+		return nil, fmt.Errorf("persistence: error decoding credential envelopes: %w", err)
+	}
+	return envelopes, nil
+}
+
 // Account stores information about an account.
 type Account struct {
-	AccountID           string
-	Name                string
-	PublicKey           string
-	EncryptedPrivateKey string
-	UserSalt            string
-	Retired             bool
-	Created             time.Time
-	Events              []Event
+	AccountID                       string
+	Name                            string
+	PublicKey                       string
+	EncryptedPrivateKey             string
+	EscrowEncryptedKeyEncryptionKey string
+	EscrowKeyID                     string
+	UserSalt                        string
+	Retired                         bool
+	// AllowedOrigins is a JSON encoded list of origins other than the one of
+	// the instance that are allowed to submit events for the account.
+	AllowedOrigins string
+	// PrivacySignals defines how events sent by browsers that signal
+	// Do Not Track or Global Privacy Control are handled. An empty value
+	// is treated like PrivacySignalsIgnore.
+	PrivacySignals string
+	// Timezone is the name of the IANA time zone days of rollups are aligned
+	// to. An empty value is treated like UTC.
+	Timezone string
+	// AnomalySensitivity defines how much the traffic of the account has to
+	// deviate from its baseline for alerts to be sent. An empty value is
+	// treated like AnomalySensitivityOff.
+	AnomalySensitivity string
+	// Domains is a JSON encoded list of host names the account collects
+	// data on. An empty value allows collecting data on any domain.
+	Domains string
+	Created time.Time
+	Events  []Event
+}
+
+// escrow wraps the given key encryption key using the escrow key in case
+// the account does not yet contain a value wrapped using this key. It
+// returns whether the account has been changed.
+func (a *Account) escrow(escrowKey *keys.EscrowKey, keyEncryptionKey []byte) (bool, error) {
+	if escrowKey == nil || a.EscrowKeyID == escrowKey.ID() {
+		return false, nil
+	}
+	wrapped, err := escrowKey.Wrap(keyEncryptionKey)
+	if err != nil {
+		return false, fmt.Errorf("persistence: error wrapping key encryption key for escrow: %w", err)
+	}
+	a.EscrowEncryptedKeyEncryptionKey = wrapped
+	a.EscrowKeyID = escrowKey.ID()
+	return true, nil
 }
 
 // HashUserID uses the account's `UserSalt` to create a hashed version of a
@@ -183,3 +767,164 @@ func (a *Account) WrapPublicKey() (jwk.Key, error) {
 	}
 	return s.Keys[0], nil
 }
+
+// AccessToken is a long lived credential that allows an account user to make
+// API requests without logging in. Only a hash of its secret is stored.
+type AccessToken struct {
+	TokenID       string
+	AccountUserID string
+	Name          string
+	HashedSecret  string
+	Salt          string
+	Scopes        []string
+	// AccountIDs restricts the token to the given accounts. In case it is
+	// empty, all accounts of the account user can be accessed.
+	AccountIDs []string
+	Created    time.Time
+	Expires    *time.Time
+}
+
+// Webhook is a URL that is notified about events concerning an account.
+// Payloads are signed using the secret, so it needs to be stored as is.
+type Webhook struct {
+	WebhookID string
+	AccountID string
+	URL       string
+	Secret    string
+	Events    []string
+	CreatedBy string
+	Created   time.Time
+}
+
+// An EventType is a custom event type defined by the operators of an
+// account, e.g. signups or downloads. Events carry the type in their
+// encrypted payload, so aggregating them is done by clients.
+type EventType struct {
+	EventTypeID string
+	AccountID   string
+	Name        string
+	Aggregation string
+	CreatedBy   string
+	Created     time.Time
+}
+
+// A ReportSubscription requests summaries of an account to be emailed to an
+// account user periodically. As email addresses of account users are only
+// stored as hashes, the address reports are sent to is stored in plain text
+// after the account user has opted in.
+type ReportSubscription struct {
+	ReportSubscriptionID string
+	AccountUserID        string
+	AccountID            string
+	EmailAddress         string
+	Frequency            string
+	Created              time.Time
+}
+
+// A Goal is reached by users visiting a page or sending a custom event.
+// Conversions are counted by clients, as matching events against goals
+// requires access to the encrypted event payloads.
+type Goal struct {
+	GoalID    string
+	AccountID string
+	Name      string
+	Type      string
+	Value     string
+	CreatedBy string
+	Created   time.Time
+}
+
+// WebhookDelivery records the delivery of a single payload to a webhook,
+// including all attempts that have been made.
+type WebhookDelivery struct {
+	DeliveryID string
+	WebhookID  string
+	Event      string
+	Payload    string
+	Attempts   int
+	StatusCode int
+	Error      string
+	Delivered  bool
+	Created    time.Time
+	Updated    time.Time
+}
+
+// AuthEvent records an authentication related action of an account user for
+// auditing purposes.
+type AuthEvent struct {
+	EventID       string
+	AccountUserID string
+	Type          string
+	IPAddress     string
+	UserAgent     string
+	Created       time.Time
+}
+
+// ServiceAccount is a non-interactive account user used for automation. It
+// holds read only envelopes for key encryption keys that are stored as
+// account user relationships and are wrapped using a key derived from a
+// machine secret. Only a hash of the secret is stored.
+type ServiceAccount struct {
+	ServiceAccountID string
+	Name             string
+	HashedSecret     string
+	Salt             string
+	CreatedBy        string
+	Created          time.Time
+	Relationships    []AccountUserRelationship
+}
+
+// Session is a login of an account user. Tokens issued to account users refer
+// to a session so they can be revoked before they expire.
+type Session struct {
+	SessionID     string
+	AccountUserID string
+	IPAddress     string
+	UserAgent     string
+	Created       time.Time
+	Expires       time.Time
+	// ImpersonatedBy is the id of the super admin that has opened the session
+	// for impersonating the account user. Such sessions are read-only.
+	ImpersonatedBy string
+	// HashedRefreshToken is the hash of the token that can be used for
+	// extending the session. It is replaced each time it is used.
+	HashedRefreshToken string
+	// RefreshExpires is the time after which the session cannot be
+	// extended anymore.
+	RefreshExpires time.Time
+}
+
+// A Rollup contains the aggregated metrics of an account for a single period.
+// As event payloads are encrypted, the server can only count events and
+// users. Aggregates that are derived from payloads, like pageviews, top
+// pages or referrers, are computed by clients and stored encrypted using the
+// public key of the account. SamplingRate is the share of the events of the
+// period that has been stored. Counts are estimated from sampled events, so
+// they are exact only in case the rate is 1. Rollups that have been imported
+// from another analytics tool name the tool in ImportSource and are never
+// computed again.
+type Rollup struct {
+	RollupID         string
+	AccountID        string
+	Period           string
+	Start            time.Time
+	Events           int
+	Users            int
+	AnonymousEvents  int
+	SamplingRate     float64
+	EncryptedPayload string
+	ImportSource     string
+	Updated          time.Time
+}
+
+// A Funnel is an ordered sequence of steps whose conversion rates are
+// computed by clients, as matching events against steps requires access to
+// the encrypted event payloads.
+type Funnel struct {
+	FunnelID  string
+	AccountID string
+	Name      string
+	Steps     []FunnelStep
+	CreatedBy string
+	Created   time.Time
+}
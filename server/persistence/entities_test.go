@@ -3,7 +3,14 @@
 
 package persistence
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/keys"
+)
 
 func TestAccount_HashUserID(t *testing.T) {
 	t.Run("default", func(t *testing.T) {
@@ -84,3 +91,184 @@ Vpq1p+9l/4NNxIc1rH4nEcDNRkG4vdSBKWiG8Pto4JL3zOONxoofJ1gpi0kB4I0/
 		}
 	})
 }
+
+func TestAccountUserRelationship_KeyRotations(t *testing.T) {
+	t.Run("no rotations", func(t *testing.T) {
+		r := AccountUserRelationship{}
+		key := []byte("0123456789abcdef0123456789abcdef")
+		result, err := r.resolveKeyEncryptionKey(key)
+		if err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if !bytes.Equal(key, result) {
+			t.Errorf("Expected key to be returned as is, got %v", result)
+		}
+	})
+	t.Run("chain", func(t *testing.T) {
+		r := AccountUserRelationship{}
+		first := []byte("0123456789abcdef0123456789abcdef")
+		second := []byte("abcdef0123456789abcdef0123456789")
+		third := []byte("fedcba9876543210fedcba9876543210")
+		if err := r.appendKeyRotation(first, second); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if err := r.appendKeyRotation(second, third); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		result, err := r.resolveKeyEncryptionKey(first)
+		if err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if !bytes.Equal(third, result) {
+			t.Errorf("Expected latest key, got %v", result)
+		}
+		if _, err := r.resolveKeyEncryptionKey(third); err == nil {
+			t.Error("Expected error when resolving from unknown key")
+		}
+	})
+	t.Run("replace rotation encrypted using rotation key", func(t *testing.T) {
+		first := []byte("0123456789abcdef0123456789abcdef")
+		second := []byte("abcdef0123456789abcdef0123456789")
+		third := []byte("fedcba9876543210fedcba9876543210")
+		rotationKey, _ := keys.DeriveRotationKey([]byte("secret"))
+		r := AccountUserRelationship{}
+		if err := r.addKeyRotation(rotationKey, second); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if err := r.appendKeyRotation(second, third); err == nil {
+			t.Error("Expected error replacing rotation without rotation key")
+		}
+		if err := r.appendKeyRotation(second, third, rotationKey); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		result, err := r.resolveKeyEncryptionKey(first, rotationKey)
+		if err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if !bytes.Equal(third, result) {
+			t.Errorf("Expected latest key, got %v", result)
+		}
+	})
+	t.Run("rotation encrypted using rotation key", func(t *testing.T) {
+		r := AccountUserRelationship{}
+		first := []byte("0123456789abcdef0123456789abcdef")
+		second := []byte("abcdef0123456789abcdef0123456789")
+		third := []byte("fedcba9876543210fedcba9876543210")
+		rotationKey, _ := keys.DeriveRotationKey([]byte("secret"))
+		previousRotationKey, _ := keys.DeriveRotationKey([]byte("previous-secret"))
+		if err := r.addKeyRotation(previousRotationKey, second); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if err := r.addKeyRotation(rotationKey, third); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		result, err := r.resolveKeyEncryptionKey(first, rotationKey, previousRotationKey)
+		if err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if !bytes.Equal(third, result) {
+			t.Errorf("Expected latest key, got %v", result)
+		}
+		if _, err := r.resolveKeyEncryptionKey(first, previousRotationKey); err == nil {
+			t.Error("Expected error when resolving using replaced rotation")
+		}
+		if _, err := r.resolveKeyEncryptionKey(first); err == nil {
+			t.Error("Expected error when resolving without rotation key")
+		}
+	})
+	t.Run("chain written as is", func(t *testing.T) {
+		first := []byte("0123456789abcdef0123456789abcdef")
+		second := []byte("abcdef0123456789abcdef0123456789")
+		third := []byte("fedcba9876543210fedcba9876543210")
+		a, _ := keys.EncryptWith(first, second)
+		b, _ := keys.EncryptWith(second, third)
+		chain, _ := json.Marshal([]string{a.Marshal(), b.Marshal()})
+		r := AccountUserRelationship{KeyEncryptionKeyRotations: string(chain)}
+		result, err := r.resolveKeyEncryptionKey(first)
+		if err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if !bytes.Equal(third, result) {
+			t.Errorf("Expected latest key, got %v", result)
+		}
+		if _, err := r.resolveKeyEncryptionKey(second); err == nil {
+			t.Error("Expected error when resolving from unknown key")
+		}
+	})
+	t.Run("bad chain", func(t *testing.T) {
+		r := AccountUserRelationship{KeyEncryptionKeyRotations: "[{"}
+		if _, err := r.resolveKeyEncryptionKey([]byte("key")); err == nil {
+			t.Error("Expected error")
+		}
+		if err := r.appendKeyRotation([]byte("a"), []byte("b")); err == nil {
+			t.Error("Expected error")
+		}
+	})
+}
+
+func TestAccountUserRelationship_ConsumeOneTimeKey(t *testing.T) {
+	key := []byte("key-encryption-key")
+	oneTimeKey, _ := keys.GenerateRandomBytes(keys.DefaultEncryptionKeySize)
+	now := time.Now()
+	tests := []struct {
+		name         string
+		relationship func() *AccountUserRelationship
+		expectError  bool
+	}{
+		{
+			"ok",
+			func() *AccountUserRelationship {
+				r := &AccountUserRelationship{}
+				r.addOneTimeEncryptedKey(key, oneTimeKey, now.Add(time.Hour))
+				return r
+			},
+			false,
+		},
+		{
+			"expired",
+			func() *AccountUserRelationship {
+				r := &AccountUserRelationship{}
+				r.addOneTimeEncryptedKey(key, oneTimeKey, now.Add(-time.Hour))
+				return r
+			},
+			true,
+		},
+		{
+			"no expiry",
+			func() *AccountUserRelationship {
+				r := &AccountUserRelationship{}
+				r.addOneTimeEncryptedKey(key, oneTimeKey, now)
+				r.OneTimeKeyExpires = nil
+				return r
+			},
+			true,
+		},
+		{
+			"consumed",
+			func() *AccountUserRelationship {
+				r := &AccountUserRelationship{}
+				r.addOneTimeEncryptedKey(key, oneTimeKey, now.Add(time.Hour))
+				r.consumeOneTimeKey(oneTimeKey, now)
+				return r
+			},
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := test.relationship()
+			result, err := r.consumeOneTimeKey(oneTimeKey, now)
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if !test.expectError {
+				if string(result) != string(key) {
+					t.Errorf("Unexpected result %v", result)
+				}
+				if !r.OneTimeKeyConsumed || r.OneTimeEncryptedKeyEncryptionKey != "" {
+					t.Errorf("Expected one time key to be consumed, got %v", r)
+				}
+			}
+		})
+	}
+}
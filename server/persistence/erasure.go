@@ -0,0 +1,35 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EraseUser deletes all events of the given user in all accounts. It is used
+// by operators for handling erasure requests of users that cannot purge
+// their data themselves, e.g. because they have lost access to the device
+// they have used.
+func (p *persistenceLayer) EraseUser(userID string) (ErasureResult, error) {
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return ErasureResult{}, errors.New("persistence: a user id is required for erasing data")
+	}
+	removedByAccountID, err := p.purge("EraseUser", userID)
+	if err != nil {
+		return ErasureResult{}, fmt.Errorf("persistence: error erasing data of user: %w", err)
+	}
+	result := ErasureResult{
+		UserID:             userID,
+		RemovedByAccountID: removedByAccountID,
+		Erased:             time.Now().UTC(),
+	}
+	for _, removed := range removedByAccountID {
+		result.Removed += removed
+	}
+	return result, nil
+}
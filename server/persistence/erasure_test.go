@@ -0,0 +1,96 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type mockEraseUserDatabase struct {
+	mockPurgeEventsDatabase
+	events     []Event
+	tombstones []Tombstone
+}
+
+func (m *mockEraseUserDatabase) Transaction() (Transaction, error) {
+	return m, nil
+}
+
+func (m *mockEraseUserDatabase) FindEvents(q interface{}) ([]Event, error) {
+	return m.events, nil
+}
+
+func (m *mockEraseUserDatabase) CreateTombstone(t *Tombstone) error {
+	m.tombstones = append(m.tombstones, *t)
+	return nil
+}
+
+func TestPersistenceLayer_EraseUser(t *testing.T) {
+	tests := []struct {
+		name           string
+		db             *mockEraseUserDatabase
+		userID         string
+		expectError    bool
+		expectedResult map[string]int
+	}{
+		{
+			"ok",
+			&mockEraseUserDatabase{
+				mockPurgeEventsDatabase: mockPurgeEventsDatabase{
+					findAccountsResult: []Account{{AccountID: "account-a", UserSalt: "JF+rNeViJeJb0jth6ZheWg=="}, {AccountID: "account-b", UserSalt: "D6xdWYfRqbuWrkg4OWVgGQ=="}},
+				},
+				events: []Event{{EventID: "event-a", AccountID: "account-a"}, {EventID: "event-b", AccountID: "account-a"}, {EventID: "event-c", AccountID: "account-b"}},
+			},
+			"user-id",
+			false,
+			map[string]int{"account-a": 2, "account-b": 1},
+		},
+		{
+			"no events",
+			&mockEraseUserDatabase{},
+			"user-id",
+			false,
+			map[string]int{},
+		},
+		{
+			"empty user id",
+			&mockEraseUserDatabase{},
+			" ",
+			true,
+			nil,
+		},
+		{
+			"database error",
+			&mockEraseUserDatabase{
+				mockPurgeEventsDatabase: mockPurgeEventsDatabase{deleteEventsErr: errors.New("did not work")},
+			},
+			"user-id",
+			true,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &persistenceLayer{dal: test.db}
+			result, err := p.EraseUser(test.userID)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(result.RemovedByAccountID, test.expectedResult) {
+				t.Errorf("Unexpected result %v", result.RemovedByAccountID)
+			}
+			if result.Removed != len(test.db.events) || len(test.db.tombstones) != len(test.db.events) {
+				t.Errorf("Expected tombstones for all %d events, got %v", len(test.db.events), result)
+			}
+			if result.UserID != test.userID || result.Erased.IsZero() {
+				t.Errorf("Unexpected result %v", result)
+			}
+		})
+	}
+}
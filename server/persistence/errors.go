@@ -3,7 +3,10 @@
 
 package persistence
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // ErrUnknownAccount will be returned when an insert call tries to create an
 // event for an account ID that does not exist in the database
@@ -21,5 +24,90 @@ func (e ErrUnknownSecret) Error() string {
 	return string(e)
 }
 
+// ErrInvalidOneTimeKey is returned when a one time key has expired or has
+// already been used.
+type ErrInvalidOneTimeKey string
+
+func (e ErrInvalidOneTimeKey) Error() string {
+	return string(e)
+}
+
 // ErrBadQuery is returned when a DAL method cannot handle the given query
 var ErrBadQuery = errors.New("persistence: could not match query")
+
+// ErrInvalidCredentials is returned when the credentials of an account user
+// cannot be verified. It is returned for both unknown email addresses and
+// wrong passwords so callers cannot tell these cases apart.
+var ErrInvalidCredentials = errors.New("persistence: invalid credentials")
+
+// ErrInvalidSecondFactor is returned when the password of an account user
+// is valid, but the second factor is not. It wraps ErrInvalidCredentials so
+// callers that are not interested in the distinction can treat both alike.
+var ErrInvalidSecondFactor = fmt.Errorf("%w: invalid second factor", ErrInvalidCredentials)
+
+// ErrPasswordBreached is returned when a new password is known from a data
+// breach and must not be used.
+var ErrPasswordBreached = errors.New("persistence: password has been exposed in a data breach")
+
+// ErrSecondFactorRequired is returned when an account user that has enabled
+// a second factor tries to log in without giving a code.
+var ErrSecondFactorRequired = errors.New("persistence: second factor required")
+
+// ErrPasswordOutOfSync is returned when an external directory has accepted a
+// password that does not match the one used for wrapping the keys of the
+// account user, e.g. because it has been changed in the directory. Keys can
+// be wrapped using the new password by resetting the password.
+var ErrPasswordOutOfSync = errors.New("persistence: password has been changed in directory")
+
+// ErrPasswordManagedExternally is returned when trying to change a password
+// that is managed by an external directory.
+var ErrPasswordManagedExternally = errors.New("persistence: password is managed by directory")
+
+// ErrPasswordExpired is returned when the password of an account user is
+// older than the configured maximum age. The password has been verified when
+// this error is returned, and needs to be changed before logging in.
+var ErrPasswordExpired = errors.New("persistence: password has expired")
+
+// ErrPermissionDenied is returned when the role of an account user does not
+// allow to perform the requested action.
+var ErrPermissionDenied = errors.New("persistence: account user is not allowed to perform this action")
+
+// ErrAccountUserSuspended is returned when an account user that has been
+// suspended by an admin tries to log in.
+var ErrAccountUserSuspended = errors.New("persistence: account user has been suspended")
+
+// ErrInvalidAccessToken is returned when an access token is unknown, has
+// expired or does not match.
+var ErrInvalidAccessToken = errors.New("persistence: invalid access token")
+
+// ErrInvalidRefreshToken is returned when a refresh token is malformed,
+// has expired or does not match the session it refers to.
+var ErrInvalidRefreshToken = errors.New("persistence: invalid refresh token")
+
+// ErrInvalidServiceAccountCredential is returned when a service account
+// credential is malformed or does not match any service account.
+var ErrInvalidServiceAccountCredential = errors.New("persistence: invalid service account credential")
+
+// ErrUnknownWebhook is returned when a webhook does not exist or belongs to
+// another account.
+var ErrUnknownWebhook = errors.New("persistence: unknown webhook")
+
+// ErrUnknownRollup is returned when no rollup exists for the requested
+// account and period.
+var ErrUnknownRollup = errors.New("persistence: unknown rollup")
+
+// ErrUnknownFunnel is returned when a funnel does not exist or belongs to
+// another account.
+var ErrUnknownFunnel = errors.New("persistence: unknown funnel")
+
+// ErrUnknownEventType is returned when an event type does not exist or
+// belongs to another account.
+var ErrUnknownEventType = errors.New("persistence: unknown event type")
+
+// ErrUnknownReportSubscription is returned when an account user is not
+// subscribed to the reports of an account.
+var ErrUnknownReportSubscription = errors.New("persistence: unknown report subscription")
+
+// ErrUnknownGoal is returned when a goal does not exist or belongs to
+// another account.
+var ErrUnknownGoal = errors.New("persistence: unknown goal")
@@ -0,0 +1,53 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"fmt"
+
+	"github.com/offen/offen/server/keys"
+)
+
+func (p *persistenceLayer) RecoverAccount(accountID, emailAddress string, escrowPrivateKey []byte) error {
+	account, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID))
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up account: %w", err)
+	}
+	if account.EscrowEncryptedKeyEncryptionKey == "" {
+		return fmt.Errorf("persistence: account %s has not been escrowed", accountID)
+	}
+	key, err := keys.UnwrapEscrowed(escrowPrivateKey, account.EscrowEncryptedKeyEncryptionKey)
+	if err != nil {
+		return fmt.Errorf("persistence: error unwrapping escrowed key: %w", err)
+	}
+	// the unwrapped key is checked before handing it out to anyone
+	if _, err := keys.DecryptWith(key, account.EncryptedPrivateKey); err != nil {
+		return fmt.Errorf("persistence: escrowed key for account %s is outdated: %w", accountID, err)
+	}
+
+	accountUser, err := p.findAccountUser(emailAddress, true, true)
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	for _, relationship := range accountUser.Relationships {
+		if relationship.AccountID == accountID {
+			return fmt.Errorf("persistence: account user already has access to account %s", accountID)
+		}
+	}
+
+	// the recovered key is shared with the account user the same way an
+	// invitation does, i.e. it will be accepted on their next login
+	relationship, err := newAccountUserRelationship(accountUser.AccountUserID, accountID)
+	if err != nil {
+		return fmt.Errorf("persistence: error creating relationship: %w", err)
+	}
+	relationship.Role = AccountUserRoleAdmin
+	if err := relationship.addEmailEncryptedKey(key, accountUser.emailSalt(), emailAddress); err != nil {
+		return fmt.Errorf("persistence: error adding email encrypted key: %w", err)
+	}
+	if err := p.dal.CreateAccountUserRelationship(relationship); err != nil {
+		return fmt.Errorf("persistence: error persisting relationship: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/offen/offen/server/keys"
+)
+
+type mockRecoverAccountDatabase struct {
+	*mockRotateAccountKeysDatabase
+}
+
+func (m *mockRecoverAccountDatabase) CreateAccountUserRelationship(r *AccountUserRelationship) error {
+	m.relationships[r.RelationshipID] = *r
+	return nil
+}
+
+func TestPersistenceLayer_RecoverAccount(t *testing.T) {
+	account, key, _ := newAccount("name", "")
+	db := &mockRecoverAccountDatabase{&mockRotateAccountKeysDatabase{
+		account:       *account,
+		relationships: map[string]AccountUserRelationship{},
+	}}
+	for _, credentials := range [][]string{{"develop@offen.dev", "develop"}, {"other@offen.dev", "other"}} {
+		accountUser, _ := newAccountUser(credentials[0], credentials[1], AccountUserAdminLevelSuperAdmin, keys.DefaultKDFParams, nil)
+		db.accountUsers = append(db.accountUsers, *accountUser)
+	}
+	// only the first user has access to the account
+	relationship, _ := newAccountUserRelationship(db.accountUsers[0].AccountUserID, account.AccountID)
+	relationship.addPasswordEncryptedKey(key, db.accountUsers[0].Salt, "develop")
+	relationship.addEmailEncryptedKey(key, db.accountUsers[0].Salt, "develop@offen.dev")
+	db.relationships[relationship.RelationshipID] = *relationship
+
+	public, private, _ := keys.GenerateRSAKeypair(keys.RSAKeyLength)
+	escrowKey, _ := keys.ParseEscrowKey(public)
+	p := &persistenceLayer{dal: db, kdfParams: keys.DefaultKDFParams, escrowKey: escrowKey}
+
+	if err := p.RecoverAccount(account.AccountID, "other@offen.dev", private); err == nil {
+		t.Error("Expected error recovering account that has not been escrowed yet")
+	}
+
+	if _, err := p.Login("develop@offen.dev", "develop"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if db.account.EscrowKeyID != escrowKey.ID() {
+		t.Fatalf("Expected account to be escrowed on login, got %v", db.account)
+	}
+	if err := p.RotateAccountKeys(account.AccountID, "develop@offen.dev", "develop"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	_, otherPrivate, _ := keys.GenerateRSAKeypair(keys.RSAKeyLength)
+	if err := p.RecoverAccount(account.AccountID, "other@offen.dev", otherPrivate); err == nil {
+		t.Error("Expected error recovering account using wrong key")
+	}
+	if err := p.RecoverAccount(account.AccountID, "other@offen.dev", private); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := p.RecoverAccount(account.AccountID, "other@offen.dev", private); err == nil {
+		t.Error("Expected error recovering account for user with access")
+	}
+
+	result, err := p.Login("other@offen.dev", "other")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result.Accounts) != 1 {
+		t.Fatalf("Expected recovered account in login result, got %v", result)
+	}
+	recoveredKey, _ := result.Accounts[0].KeyEncryptionKey.(*jwk.SymmetricKey)
+	if _, err := keys.DecryptWith(recoveredKey.Octets(), db.account.EncryptedPrivateKey); err != nil {
+		t.Errorf("Unexpected error decrypting private key with recovered key: %v", err)
+	}
+}
@@ -6,9 +6,13 @@ package persistence
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 func (p *persistenceLayer) Insert(userID, accountID, payload string, idOverride *string) error {
+	dal, span := p.startSpan("Insert")
+	defer span.End()
+
 	var eventID string
 	if idOverride == nil {
 		var err error
@@ -20,7 +24,7 @@ func (p *persistenceLayer) Insert(userID, accountID, payload string, idOverride
 		eventID = *idOverride
 	}
 
-	account, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID))
+	account, err := dal.FindAccount(FindAccountQueryActiveByID(accountID))
 	if err != nil {
 		return fmt.Errorf("persistence: error looking up matching account for given event: %w", err)
 	}
@@ -37,22 +41,45 @@ func (p *persistenceLayer) Insert(userID, accountID, payload string, idOverride
 	// in case the event is not anonymous, we need to check that the user
 	// already exists for the account so events can be decrypted lateron
 	if hashedUserID != nil {
-		if _, err := p.dal.FindSecret(FindSecretQueryBySecretID(*hashedUserID)); err != nil {
+		if _, err := dal.FindSecret(FindSecretQueryBySecretID(*hashedUserID)); err != nil {
 			return fmt.Errorf("persistence: error finding secret for given event: %w", err)
 		}
 	}
 
+	// events of high traffic accounts might be sampled, in which case
+	// events that are not sampled are skipped without signaling an error
+	weight := p.sampler.weight(accountID, time.Now())
+	if !sampled(weight, samplingKey(hashedUserID, eventID)) {
+		span.SetAttribute("sampled", false)
+		return nil
+	}
+
+	// event ids that are passed by callers allow clients to safely retry
+	// submitting an event, so an event that exists already is not inserted
+	// again
+	if idOverride != nil {
+		existing, err := dal.FindEvents(FindEventsQueryByEventIDs{eventID})
+		if err != nil {
+			return fmt.Errorf("persistence: error looking up existing events: %w", err)
+		}
+		if len(existing) != 0 {
+			span.SetAttribute("duplicate", true)
+			return nil
+		}
+	}
+
 	sequence, seqErr := NewULID()
 	if seqErr != nil {
 		return fmt.Errorf("persistence: error creating sequence number: %w", seqErr)
 	}
 
-	insertErr := p.dal.CreateEvent(&Event{
+	insertErr := dal.CreateEvent(&Event{
 		AccountID: accountID,
 		SecretID:  hashedUserID,
 		Payload:   payload,
 		EventID:   eventID,
 		Sequence:  sequence,
+		Weight:    weight,
 	})
 	if insertErr != nil {
 		return fmt.Errorf("persistence: error inserting event: %w", insertErr)
@@ -60,23 +87,144 @@ func (p *persistenceLayer) Insert(userID, accountID, payload string, idOverride
 	return nil
 }
 
+// BatchEvent is a single event that is inserted using InsertBatch. In case
+// EventID is empty, a new identifier is created.
+type BatchEvent struct {
+	AccountID string
+	Payload   string
+	EventID   string
+}
+
+// InsertBatch inserts all given events of the user with the given id in a
+// single transaction, so either all of them are persisted or none. Events
+// with an id that exists already are skipped.
+func (p *persistenceLayer) InsertBatch(userID string, events []BatchEvent) error {
+	dal, span := p.startSpan("InsertBatch")
+	defer span.End()
+	span.SetAttribute("events", len(events))
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	// accounts and users are looked up once per account, no matter how many
+	// events are submitted for it
+	hashedUserIDs := map[string]*string{}
+	var records []*Event
+	var clientIDs []string
+	for _, event := range events {
+		hashedUserID, ok := hashedUserIDs[event.AccountID]
+		if !ok {
+			account, err := dal.FindAccount(FindAccountQueryActiveByID(event.AccountID))
+			if err != nil {
+				return fmt.Errorf("persistence: error looking up matching account for given event: %w", err)
+			}
+			if userID != "" {
+				hash, err := account.HashUserID(userID)
+				if err != nil {
+					return fmt.Errorf("persistence: error hashing user id: %w", err)
+				}
+				if _, err := dal.FindSecret(FindSecretQueryBySecretID(hash)); err != nil {
+					return fmt.Errorf("persistence: error finding secret for given event: %w", err)
+				}
+				hashedUserID = &hash
+			}
+			hashedUserIDs[event.AccountID] = hashedUserID
+		}
+
+		eventID := event.EventID
+		if eventID == "" {
+			var err error
+			eventID, err = NewULID()
+			if err != nil {
+				return fmt.Errorf("persistence: error creating new event identifier: %w", err)
+			}
+		} else {
+			clientIDs = append(clientIDs, eventID)
+		}
+		weight := p.sampler.weight(event.AccountID, time.Now())
+		if !sampled(weight, samplingKey(hashedUserID, eventID)) {
+			continue
+		}
+		records = append(records, &Event{
+			AccountID: event.AccountID,
+			SecretID:  hashedUserID,
+			Payload:   event.Payload,
+			EventID:   eventID,
+			Weight:    weight,
+		})
+	}
+
+	skip := map[string]bool{}
+	if len(clientIDs) != 0 {
+		existing, err := dal.FindEvents(FindEventsQueryByEventIDs(clientIDs))
+		if err != nil {
+			return fmt.Errorf("persistence: error looking up existing events: %w", err)
+		}
+		for _, event := range existing {
+			skip[event.EventID] = true
+		}
+		span.SetAttribute("duplicates", len(existing))
+	}
+
+	sequence, err := NewULID()
+	if err != nil {
+		return fmt.Errorf("persistence: error creating sequence number: %w", err)
+	}
+
+	txn, err := dal.Transaction()
+	if err != nil {
+		return fmt.Errorf("persistence: error creating transaction: %w", err)
+	}
+	for _, record := range records {
+		// ids might also be repeated within the batch itself
+		if skip[record.EventID] {
+			continue
+		}
+		skip[record.EventID] = true
+		record.Sequence = sequence
+		if err := txn.CreateEvent(record); err != nil {
+			txn.Rollback()
+			return fmt.Errorf("persistence: error inserting event: %w", err)
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return fmt.Errorf("persistence: error committing transaction: %w", err)
+	}
+	return nil
+}
+
 // Query defines a set of filters to limit the set of results to be returned
-// In case a field has the zero value, its filter will not be applied.
+// In case a field has the zero value, its filter will not be applied. Since
+// is a sequence as returned by a previous query, After and Until are event
+// ids. In case Limit is set, events are returned ordered by their id and
+// the result contains a cursor for requesting the next page.
 type Query struct {
-	UserID string
-	Since  string
+	UserID     string
+	Since      string
+	After      string
+	Until      string
+	AccountIDs []string
+	Limit      int
 }
 
 func (p *persistenceLayer) Query(query Query) (EventsResult, error) {
+	dal, span := p.startSpan("Query")
+	defer span.End()
+
 	var accounts []Account
-	accounts, err := p.dal.FindAccounts(FindAccountsQueryAllAccounts{})
+	accounts, err := dal.FindAccounts(FindAccountsQueryAllAccounts{})
 	if err != nil {
 		return EventsResult{}, fmt.Errorf("persistence: error looking up all accounts: %v", err)
 	}
 
-	results, err := p.dal.FindEvents(FindEventsQueryForSecretIDs{
-		SecretIDs: hashUserIDForAccounts(query.UserID, accounts),
-		Since:     query.Since,
+	results, err := dal.FindEvents(FindEventsQueryForSecretIDs{
+		SecretIDs:  hashUserIDForAccounts(query.UserID, accounts),
+		Since:      query.Since,
+		After:      query.After,
+		Until:      query.Until,
+		AccountIDs: query.AccountIDs,
+		Limit:      query.Limit,
 	})
 	if err != nil {
 		return EventsResult{}, fmt.Errorf("persistence: error looking up events: %w", err)
@@ -95,7 +243,7 @@ func (p *persistenceLayer) Query(query Query) (EventsResult, error) {
 	out.Events = &eventResults
 
 	if query.Since != "" {
-		pruned, err := p.dal.FindTombstones(FindTombstonesQueryBySecrets{
+		pruned, err := dal.FindTombstones(FindTombstonesQueryBySecrets{
 			SecretIDs: hashUserIDForAccounts(query.UserID, accounts),
 			Since:     query.Since,
 		})
@@ -111,25 +259,41 @@ func (p *persistenceLayer) Query(query Query) (EventsResult, error) {
 		out.DeletedEvents = prunedIDs
 	}
 
+	// clients are expected to use the sequence as the starting point of the
+	// next query, which is only safe once all pages have been consumed
+	if query.Limit > 0 && len(results) == query.Limit {
+		out.NextCursor = results[len(results)-1].EventID
+		return out, nil
+	}
 	out.Sequence = getLatestSeq(seqs)
 	return out, nil
 }
 
 func (p *persistenceLayer) Purge(userID string) error {
+	_, err := p.purge("Purge", userID)
+	return err
+}
+
+// purge deletes all events of the given user in all accounts and returns
+// the number of deleted events by account id.
+func (p *persistenceLayer) purge(operation, userID string) (map[string]int, error) {
+	dal, span := p.startSpan(operation)
+	defer span.End()
+
 	sequence, err := NewULID()
 	if err != nil {
-		return fmt.Errorf("persistence: error creating sequence number: %w", err)
+		return nil, fmt.Errorf("persistence: error creating sequence number: %w", err)
 	}
 
-	txn, err := p.dal.Transaction()
+	txn, err := dal.Transaction()
 	if err != nil {
-		return fmt.Errorf("persistence: error creating transaction: %w", err)
+		return nil, fmt.Errorf("persistence: error creating transaction: %w", err)
 	}
 
 	accounts, err := txn.FindAccounts(FindAccountsQueryAllAccounts{})
 	if err != nil {
 		txn.Rollback()
-		return fmt.Errorf("persistence: error retrieving available accounts: %w", err)
+		return nil, fmt.Errorf("persistence: error retrieving available accounts: %w", err)
 	}
 
 	hashedUserIDs := hashUserIDForAccounts(userID, accounts)
@@ -137,9 +301,11 @@ func (p *persistenceLayer) Purge(userID string) error {
 	affectedEvents, err := txn.FindEvents(FindEventsQueryForSecretIDs{SecretIDs: hashedUserIDs})
 	if err != nil {
 		txn.Rollback()
-		return fmt.Errorf("persistence: error looking up events to purge: %w", err)
+		return nil, fmt.Errorf("persistence: error looking up events to purge: %w", err)
 	}
+	purged := map[string]int{}
 	for _, evt := range affectedEvents {
+		purged[evt.AccountID]++
 		if err := txn.CreateTombstone(&Tombstone{
 			EventID:   evt.EventID,
 			AccountID: evt.AccountID,
@@ -147,19 +313,19 @@ func (p *persistenceLayer) Purge(userID string) error {
 			Sequence:  sequence,
 		}); err != nil {
 			txn.Rollback()
-			return fmt.Errorf("persistence: error creating tombstone for purged event: %w", err)
+			return nil, fmt.Errorf("persistence: error creating tombstone for purged event: %w", err)
 		}
 	}
 
 	if _, err := txn.DeleteEvents(DeleteEventsQueryBySecretIDs(hashedUserIDs)); err != nil {
 		txn.Rollback()
-		return fmt.Errorf("persistence: error purging events: %w", err)
+		return nil, fmt.Errorf("persistence: error purging events: %w", err)
 	}
 
 	if err := txn.Commit(); err != nil {
-		return fmt.Errorf("persistence: error committing pruning of events: %w", err)
+		return nil, fmt.Errorf("persistence: error committing pruning of events: %w", err)
 	}
-	return nil
+	return purged, nil
 }
 
 func hashUserIDForAccounts(userID string, accounts []Account) []string {
@@ -188,6 +354,15 @@ func hashUserIDForAccounts(userID string, accounts []Account) []string {
 	return hashedUserIDs
 }
 
+// samplingKey returns the key the sampling decision for an event is derived
+// from. Anonymous events are sampled by their id.
+func samplingKey(hashedUserID *string, eventID string) string {
+	if hashedUserID != nil {
+		return *hashedUserID
+	}
+	return eventID
+}
+
 func getLatestSeq(s []string) string {
 	var latestSeq string
 	for _, seq := range s {
@@ -232,6 +232,213 @@ func TestPersistenceLayer_Insert(t *testing.T) {
 	}
 }
 
+type mockInsertBatchDatabase struct {
+	DataAccessLayer
+	findAccountErr error
+	findSecretErr  error
+	createEventErr error
+	txnErr         error
+	commitErr      error
+	existingEvents []Event
+	findEventsErr  error
+	accountLookups int
+	secretLookups  int
+	created        []*Event
+	committed      bool
+}
+
+func (m *mockInsertBatchDatabase) FindAccount(q interface{}) (Account, error) {
+	m.accountLookups++
+	return Account{
+		AccountID: string(q.(FindAccountQueryActiveByID)),
+		UserSalt:  "{1,} CaHVhk78uhoPmf5wanA0vg==",
+	}, m.findAccountErr
+}
+
+func (m *mockInsertBatchDatabase) FindSecret(interface{}) (Secret, error) {
+	m.secretLookups++
+	return Secret{}, m.findSecretErr
+}
+
+func (m *mockInsertBatchDatabase) FindEvents(interface{}) ([]Event, error) {
+	return m.existingEvents, m.findEventsErr
+}
+
+func (m *mockInsertBatchDatabase) CreateEvent(e *Event) error {
+	if m.createEventErr != nil {
+		return m.createEventErr
+	}
+	m.created = append(m.created, e)
+	return nil
+}
+
+func (m *mockInsertBatchDatabase) Transaction() (Transaction, error) {
+	return m, m.txnErr
+}
+
+func (m *mockInsertBatchDatabase) Commit() error {
+	m.committed = m.commitErr == nil
+	return m.commitErr
+}
+
+func (m *mockInsertBatchDatabase) Rollback() error {
+	return nil
+}
+
+func TestPersistenceLayer_InsertBatch(t *testing.T) {
+	events := []BatchEvent{
+		{AccountID: "account-a", Payload: "payload-1"},
+		{AccountID: "account-b", Payload: "payload-2"},
+		{AccountID: "account-a", Payload: "payload-3"},
+	}
+	tests := []struct {
+		name                   string
+		userID                 string
+		events                 []BatchEvent
+		db                     *mockInsertBatchDatabase
+		expectError            bool
+		expectedCreated        int
+		expectedAccountLookups int
+		expectedSecretLookups  int
+	}{
+		{"empty", "user-id", nil, &mockInsertBatchDatabase{}, false, 0, 0, 0},
+		{"account lookup error", "user-id", events, &mockInsertBatchDatabase{findAccountErr: errors.New("did not work")}, true, 0, 1, 0},
+		{"user lookup error", "user-id", events, &mockInsertBatchDatabase{findSecretErr: errors.New("did not work")}, true, 0, 1, 1},
+		{"transaction error", "user-id", events, &mockInsertBatchDatabase{txnErr: errors.New("did not work")}, true, 0, 2, 2},
+		{"insert error", "user-id", events, &mockInsertBatchDatabase{createEventErr: errors.New("did not work")}, true, 0, 2, 2},
+		{"commit error", "user-id", events, &mockInsertBatchDatabase{commitErr: errors.New("did not work")}, true, 3, 2, 2},
+		{"ok", "user-id", events, &mockInsertBatchDatabase{}, false, 3, 2, 2},
+		{"anonymous", "", events, &mockInsertBatchDatabase{findSecretErr: errors.New("did not work")}, false, 3, 2, 0},
+		{
+			"client ids",
+			"user-id",
+			[]BatchEvent{
+				{AccountID: "account-a", Payload: "payload-1", EventID: "event-a"},
+				{AccountID: "account-a", Payload: "payload-2", EventID: "event-b"},
+			},
+			&mockInsertBatchDatabase{},
+			false,
+			2,
+			1,
+			1,
+		},
+		{
+			"existing lookup error",
+			"user-id",
+			[]BatchEvent{{AccountID: "account-a", Payload: "payload-1", EventID: "event-a"}},
+			&mockInsertBatchDatabase{findEventsErr: errors.New("did not work")},
+			true,
+			0,
+			1,
+			1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &persistenceLayer{dal: test.db}
+			err := p.InsertBatch(test.userID, test.events)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if len(test.db.created) != test.expectedCreated {
+				t.Errorf("Expected %d events to be created, got %d", test.expectedCreated, len(test.db.created))
+			}
+			if test.db.accountLookups != test.expectedAccountLookups {
+				t.Errorf("Expected %d account lookups, got %d", test.expectedAccountLookups, test.db.accountLookups)
+			}
+			if test.db.secretLookups != test.expectedSecretLookups {
+				t.Errorf("Expected %d secret lookups, got %d", test.expectedSecretLookups, test.db.secretLookups)
+			}
+			if test.expectError == test.db.committed && test.expectedCreated != 0 {
+				t.Errorf("Unexpected commit state %v", test.db.committed)
+			}
+			for i, event := range test.db.created {
+				if event.Payload != test.events[i].Payload || event.AccountID != test.events[i].AccountID {
+					t.Errorf("Unexpected event %v", event)
+				}
+				if event.Sequence == "" || event.Sequence != test.db.created[0].Sequence {
+					t.Errorf("Expected events to share a sequence, got %v", event.Sequence)
+				}
+				if (test.userID == "") != (event.SecretID == nil) {
+					t.Errorf("Unexpected secret id %v", event.SecretID)
+				}
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_InsertBatch_Duplicates(t *testing.T) {
+	db := &mockInsertBatchDatabase{
+		existingEvents: []Event{{EventID: "event-a"}},
+	}
+	p := &persistenceLayer{dal: db}
+	err := p.InsertBatch("user-id", []BatchEvent{
+		{AccountID: "account-a", Payload: "payload-1", EventID: "event-a"},
+		{AccountID: "account-a", Payload: "payload-2", EventID: "event-b"},
+		{AccountID: "account-a", Payload: "payload-2", EventID: "event-b"},
+		{AccountID: "account-a", Payload: "payload-3"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(db.created) != 2 {
+		t.Fatalf("Expected 2 events to be created, got %d", len(db.created))
+	}
+	if db.created[0].EventID != "event-b" || db.created[1].EventID == "" {
+		t.Errorf("Unexpected events %v", db.created)
+	}
+}
+
+func TestPersistenceLayer_InsertBatch_Sampled(t *testing.T) {
+	db := &mockInsertBatchDatabase{}
+	p := &persistenceLayer{dal: db}
+	WithSampling(1)(p)
+	err := p.InsertBatch("", []BatchEvent{
+		{AccountID: "account-a", Payload: "payload-1", EventID: "event-a"},
+		{AccountID: "account-a", Payload: "payload-2", EventID: "event-c"},
+		{AccountID: "account-a", Payload: "payload-3", EventID: "event-b"},
+		{AccountID: "account-a", Payload: "payload-4", EventID: "event-d"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(db.created) != 2 {
+		t.Fatalf("Expected 2 events to be created, got %d", len(db.created))
+	}
+	if db.created[0].EventID != "event-a" || db.created[0].Weight != 1 {
+		t.Errorf("Unexpected first event %v", db.created[0])
+	}
+	if db.created[1].EventID != "event-b" || db.created[1].Weight != 4 {
+		t.Errorf("Unexpected second event %v", db.created[1])
+	}
+}
+
+func TestPersistenceLayer_Insert_Duplicate(t *testing.T) {
+	tests := []struct {
+		name            string
+		db              *mockInsertBatchDatabase
+		expectError     bool
+		expectedCreated int
+	}{
+		{"new event", &mockInsertBatchDatabase{}, false, 1},
+		{"duplicate event", &mockInsertBatchDatabase{existingEvents: []Event{{EventID: "event-a"}}}, false, 0},
+		{"lookup error", &mockInsertBatchDatabase{findEventsErr: errors.New("did not work")}, true, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &persistenceLayer{dal: test.db}
+			eventID := "event-a"
+			err := p.Insert("user-id", "account-a", "payload", &eventID)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if len(test.db.created) != test.expectedCreated {
+				t.Errorf("Expected %d events to be created, got %d", test.expectedCreated, len(test.db.created))
+			}
+		})
+	}
+}
+
 type mockPurgeEventsDatabase struct {
 	DataAccessLayer
 	findAccountsResult []Account
@@ -529,6 +736,81 @@ func TestPersistenceLayer_Query(t *testing.T) {
 	}
 }
 
+func TestPersistenceLayer_Query_Pagination(t *testing.T) {
+	tests := []struct {
+		name               string
+		limit              int
+		events             []Event
+		expectedCursor     string
+		expectedSequence   string
+		expectedLimitOnDAL int
+	}{
+		{
+			"more pages",
+			2,
+			[]Event{
+				{AccountID: "account-a", EventID: "event-a", Sequence: "seq-b"},
+				{AccountID: "account-a", EventID: "event-b", Sequence: "seq-a"},
+			},
+			"event-b",
+			"",
+			2,
+		},
+		{
+			"last page",
+			3,
+			[]Event{
+				{AccountID: "account-a", EventID: "event-a", Sequence: "seq-b"},
+				{AccountID: "account-a", EventID: "event-b", Sequence: "seq-a"},
+			},
+			"",
+			"seq-b",
+			3,
+		},
+		{
+			"no limit",
+			0,
+			[]Event{
+				{AccountID: "account-a", EventID: "event-a", Sequence: "seq-b"},
+			},
+			"",
+			"seq-b",
+			0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &mockQueryEventDatabase{
+				findAccountsResult: []Account{
+					{AccountID: "account-a", UserSalt: "LEWtq55DKObqPK+XEQbnZA=="},
+				},
+				findEventsResult: test.events,
+			}
+			p := &persistenceLayer{dal: db}
+			result, err := p.Query(Query{
+				UserID:     "user-id",
+				After:      "event-0",
+				Until:      "event-z",
+				AccountIDs: []string{"account-a"},
+				Limit:      test.limit,
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if result.NextCursor != test.expectedCursor {
+				t.Errorf("Expected cursor %v, got %v", test.expectedCursor, result.NextCursor)
+			}
+			if result.Sequence != test.expectedSequence {
+				t.Errorf("Expected sequence %v, got %v", test.expectedSequence, result.Sequence)
+			}
+			query := db.methodArgs[1].(FindEventsQueryForSecretIDs)
+			if query.Limit != test.expectedLimitOnDAL || query.After != "event-0" || query.Until != "event-z" || !reflect.DeepEqual(query.AccountIDs, []string{"account-a"}) {
+				t.Errorf("Unexpected query %v", query)
+			}
+		})
+	}
+}
+
 func TestGetLatestSeq(t *testing.T) {
 	result := getLatestSeq([]string{"x", "0", "z", "a", "x", "1", "0"})
 	if result != "z" {
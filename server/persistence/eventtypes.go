@@ -0,0 +1,146 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	uuid "github.com/gofrs/uuid"
+)
+
+// Aggregations of custom event types.
+const (
+	// EventTypeAggregationCount counts the events of the type.
+	EventTypeAggregationCount = "count"
+	// EventTypeAggregationUsers counts the unique users that have sent an
+	// event of the type.
+	EventTypeAggregationUsers = "users"
+	// EventTypeAggregationSum adds up the values of the events of the type.
+	EventTypeAggregationSum = "sum"
+	// EventTypeAggregationAverage averages the values of the events of the
+	// type.
+	EventTypeAggregationAverage = "average"
+)
+
+// EventTypeAggregations contains all supported aggregations.
+var EventTypeAggregations = []string{
+	EventTypeAggregationCount,
+	EventTypeAggregationUsers,
+	EventTypeAggregationSum,
+	EventTypeAggregationAverage,
+}
+
+const maxEventTypes = 50
+
+// eventTypeName matches names that can be passed by the script, e.g.
+// "signup" or "file_download".
+var eventTypeName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+func (e *EventType) export() EventTypeResult {
+	return EventTypeResult{
+		EventTypeID: e.EventTypeID,
+		AccountID:   e.AccountID,
+		Name:        e.Name,
+		Aggregation: e.Aggregation,
+		CreatedBy:   e.CreatedBy,
+		Created:     e.Created,
+	}
+}
+
+func validEventTypeAggregation(aggregation string) bool {
+	for _, a := range EventTypeAggregations {
+		if a == aggregation {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateEventType defines a custom event type for the account. In case no
+// aggregation is given, events are counted.
+func (p *persistenceLayer) CreateEventType(userID, accountID, name, aggregation string) (EventTypeResult, error) {
+	if !eventTypeName.MatchString(name) {
+		return EventTypeResult{}, fmt.Errorf("persistence: %q is not a valid event type name, use up to 64 lowercase letters, digits, - and _", name)
+	}
+	if aggregation == "" {
+		aggregation = EventTypeAggregationCount
+	}
+	if !validEventTypeAggregation(aggregation) {
+		return EventTypeResult{}, fmt.Errorf("persistence: unknown aggregation %q", aggregation)
+	}
+	if _, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID)); err != nil {
+		return EventTypeResult{}, fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+	}
+
+	existing, err := p.dal.FindEventTypes(FindEventTypesQueryByAccountID(accountID))
+	if err != nil {
+		return EventTypeResult{}, fmt.Errorf("persistence: error looking up event types: %w", err)
+	}
+	if len(existing) >= maxEventTypes {
+		return EventTypeResult{}, fmt.Errorf("persistence: accounts cannot define more than %d event types", maxEventTypes)
+	}
+	for _, eventType := range existing {
+		if eventType.Name == name {
+			return EventTypeResult{}, fmt.Errorf("persistence: event type %q already exists", name)
+		}
+	}
+
+	eventTypeID, err := uuid.NewV4()
+	if err != nil {
+		return EventTypeResult{}, fmt.Errorf("persistence: error creating event type id: %w", err)
+	}
+	eventType := &EventType{
+		EventTypeID: eventTypeID.String(),
+		AccountID:   accountID,
+		Name:        name,
+		Aggregation: aggregation,
+		CreatedBy:   userID,
+		Created:     time.Now(),
+	}
+	if err := p.dal.CreateEventType(eventType); err != nil {
+		return EventTypeResult{}, fmt.Errorf("persistence: error persisting event type: %w", err)
+	}
+	return eventType.export(), nil
+}
+
+// ListEventTypes returns all custom event types of the given account.
+func (p *persistenceLayer) ListEventTypes(accountID string) ([]EventTypeResult, error) {
+	eventTypes, err := p.dal.FindEventTypes(FindEventTypesQueryByAccountID(accountID))
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up event types: %w", err)
+	}
+	result := []EventTypeResult{}
+	for _, eventType := range eventTypes {
+		result = append(result, eventType.export())
+	}
+	return result, nil
+}
+
+// DeleteEventType deletes the given event type of the account. Events that
+// have already been recorded are kept.
+func (p *persistenceLayer) DeleteEventType(accountID, eventTypeID string) error {
+	eventTypes, err := p.dal.FindEventTypes(FindEventTypesQueryByAccountID(accountID))
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up event types: %w", err)
+	}
+	var found bool
+	for _, eventType := range eventTypes {
+		if eventType.EventTypeID == eventTypeID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrUnknownEventType
+	}
+	if err := p.dal.DeleteEventTypes(DeleteEventTypesQueryByID{
+		EventTypeID: eventTypeID,
+		AccountID:   accountID,
+	}); err != nil {
+		return fmt.Errorf("persistence: error deleting event type: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"testing"
+)
+
+type mockEventTypesDatabase struct {
+	DataAccessLayer
+	eventTypes []EventType
+	deleted    []interface{}
+}
+
+func (m *mockEventTypesDatabase) FindAccount(q interface{}) (Account, error) {
+	if string(q.(FindAccountQueryActiveByID)) != "account-a" {
+		return Account{}, errors.New("not found")
+	}
+	return Account{AccountID: "account-a"}, nil
+}
+
+func (m *mockEventTypesDatabase) CreateEventType(e *EventType) error {
+	m.eventTypes = append(m.eventTypes, *e)
+	return nil
+}
+
+func (m *mockEventTypesDatabase) FindEventTypes(q interface{}) ([]EventType, error) {
+	var result []EventType
+	for _, eventType := range m.eventTypes {
+		if eventType.AccountID == string(q.(FindEventTypesQueryByAccountID)) {
+			result = append(result, eventType)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockEventTypesDatabase) DeleteEventTypes(q interface{}) error {
+	m.deleted = append(m.deleted, q)
+	return nil
+}
+
+func TestPersistenceLayer_CreateEventType(t *testing.T) {
+	tests := []struct {
+		name                string
+		accountID           string
+		eventTypeName       string
+		aggregation         string
+		expectError         bool
+		expectedAggregation string
+	}{
+		{"ok", "account-a", "file_download", EventTypeAggregationSum, false, EventTypeAggregationSum},
+		{"default aggregation", "account-a", "signup", "", false, EventTypeAggregationCount},
+		{"duplicate", "account-a", "newsletter", "", true, ""},
+		{"bad name", "account-a", "Sign Up", "", true, ""},
+		{"too long", "account-a", "a1234567890123456789012345678901234567890123456789012345678901234", "", true, ""},
+		{"unknown aggregation", "account-a", "signup", "median", true, ""},
+		{"unknown account", "account-z", "signup", "", true, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &persistenceLayer{dal: &mockEventTypesDatabase{
+				eventTypes: []EventType{{EventTypeID: "type-a", AccountID: "account-a", Name: "newsletter"}},
+			}}
+			result, err := p.CreateEventType("user-a", test.accountID, test.eventTypeName, test.aggregation)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if err == nil && (result.EventTypeID == "" || result.Aggregation != test.expectedAggregation) {
+				t.Errorf("Unexpected result %v", result)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_EventTypes(t *testing.T) {
+	db := &mockEventTypesDatabase{
+		eventTypes: []EventType{
+			{EventTypeID: "type-a", AccountID: "account-a"},
+			{EventTypeID: "type-b", AccountID: "account-b"},
+		},
+	}
+	p := &persistenceLayer{dal: db}
+
+	listed, err := p.ListEventTypes("account-a")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(listed) != 1 || listed[0].EventTypeID != "type-a" {
+		t.Errorf("Unexpected event types %v", listed)
+	}
+
+	if err := p.DeleteEventType("account-a", "type-b"); !errors.Is(err, ErrUnknownEventType) {
+		t.Errorf("Expected unknown event type error, got %v", err)
+	}
+	if err := p.DeleteEventType("account-a", "type-a"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(db.deleted) != 1 {
+		t.Errorf("Unexpected deletions %v", db.deleted)
+	}
+}
@@ -9,30 +9,33 @@ import (
 )
 
 // Expire deletes all events in the give database that are older than the given
-// retention threshold.
-func (p *persistenceLayer) Expire(retention time.Duration) (int, error) {
+// retention threshold. Expired sessions and webhook deliveries are deleted as
+// well.
+func (p *persistenceLayer) Expire(retention time.Duration) (ExpireResult, error) {
 	limit := time.Now().Add(-retention)
 	deadline, deadlineErr := EventIDAt(limit)
 	if deadlineErr != nil {
-		return 0, fmt.Errorf("persistence: error determing deadline for expiring events: %w", deadlineErr)
+		return ExpireResult{}, fmt.Errorf("persistence: error determing deadline for expiring events: %w", deadlineErr)
 	}
 
 	sequence, seqErr := NewULID()
 	if seqErr != nil {
-		return 0, fmt.Errorf("persistence: error creating sequence number: %w", seqErr)
+		return ExpireResult{}, fmt.Errorf("persistence: error creating sequence number: %w", seqErr)
 	}
 
 	txn, err := p.dal.Transaction()
 	if err != nil {
-		return 0, fmt.Errorf("persistence: error creating transaction: %w", err)
+		return ExpireResult{}, fmt.Errorf("persistence: error creating transaction: %w", err)
 	}
 	expiredEvents, err := txn.FindEvents(FindEventsQueryOlderThan(deadline))
 	if err != nil {
 		txn.Rollback()
-		return 0, fmt.Errorf("persistence: error looking up expired events: %w", err)
+		return ExpireResult{}, fmt.Errorf("persistence: error looking up expired events: %w", err)
 	}
 
+	removedByAccountID := map[string]int{}
 	for _, evt := range expiredEvents {
+		removedByAccountID[evt.AccountID]++
 		if err := txn.CreateTombstone(&Tombstone{
 			AccountID: evt.AccountID,
 			EventID:   evt.EventID,
@@ -40,18 +43,46 @@ func (p *persistenceLayer) Expire(retention time.Duration) (int, error) {
 			Sequence:  sequence,
 		}); err != nil {
 			txn.Rollback()
-			return 0, fmt.Errorf("persistence: error creating tombstone: %w", err)
+			return ExpireResult{}, fmt.Errorf("persistence: error creating tombstone: %w", err)
 		}
 	}
 
 	eventsAffected, err := txn.DeleteEvents(DeleteEventsQueryOlderThan(deadline))
 	if err != nil {
 		txn.Rollback()
-		return 0, fmt.Errorf("persistence: error deleting expired events: %w", err)
+		return ExpireResult{}, fmt.Errorf("persistence: error deleting expired events: %w", err)
+	}
+
+	staleRelationships, err := txn.FindAccountUserRelationships(FindAccountUserRelationshipsQueryStaleOneTimeKeys(time.Now()))
+	if err != nil {
+		txn.Rollback()
+		return ExpireResult{}, fmt.Errorf("persistence: error looking up stale one time keys: %w", err)
+	}
+	for _, relationship := range staleRelationships {
+		relationship.clearOneTimeKey()
+		if err := txn.UpdateAccountUserRelationship(&relationship); err != nil {
+			txn.Rollback()
+			return ExpireResult{}, fmt.Errorf("persistence: error removing stale one time key: %w", err)
+		}
+	}
+
+	if err := txn.DeleteSessions(DeleteSessionsQueryExpired(time.Now())); err != nil {
+		txn.Rollback()
+		return ExpireResult{}, fmt.Errorf("persistence: error deleting expired sessions: %w", err)
+	}
+
+	if err := txn.DeleteWebhookDeliveries(
+		DeleteWebhookDeliveriesQueryOlderThan(time.Now().Add(-WebhookDeliveryRetention)),
+	); err != nil {
+		txn.Rollback()
+		return ExpireResult{}, fmt.Errorf("persistence: error deleting expired webhook deliveries: %w", err)
 	}
 
 	if err := txn.Commit(); err != nil {
-		return 0, fmt.Errorf("persistence: error expiring events: %w", err)
+		return ExpireResult{}, fmt.Errorf("persistence: error expiring events: %w", err)
 	}
-	return int(eventsAffected), nil
+	return ExpireResult{
+		Removed:            int(eventsAffected),
+		RemovedByAccountID: removedByAccountID,
+	}, nil
 }
@@ -5,14 +5,29 @@ package persistence
 
 import (
 	"errors"
+	"reflect"
 	"testing"
 	"time"
 )
 
 type mockExpireDatabase struct {
 	DataAccessLayer
-	err      error
-	affected int64
+	err             error
+	affected        int64
+	relationships   []AccountUserRelationship
+	updated         []AccountUserRelationship
+	sessionsQuery   interface{}
+	events          []Event
+	deliveriesQuery interface{}
+}
+
+func (m *mockExpireDatabase) FindAccountUserRelationships(q interface{}) ([]AccountUserRelationship, error) {
+	return m.relationships, m.err
+}
+
+func (m *mockExpireDatabase) UpdateAccountUserRelationship(r *AccountUserRelationship) error {
+	m.updated = append(m.updated, *r)
+	return m.err
 }
 
 func (m *mockExpireDatabase) DeleteEvents(q interface{}) (int64, error) {
@@ -24,7 +39,21 @@ func (m *mockExpireDatabase) FindTombstones(q interface{}) ([]Tombstone, error)
 }
 
 func (m *mockExpireDatabase) FindEvents(q interface{}) ([]Event, error) {
-	return nil, m.err
+	return m.events, m.err
+}
+
+func (m *mockExpireDatabase) CreateTombstone(*Tombstone) error {
+	return m.err
+}
+
+func (m *mockExpireDatabase) DeleteWebhookDeliveries(q interface{}) error {
+	m.deliveriesQuery = q
+	return m.err
+}
+
+func (m *mockExpireDatabase) DeleteSessions(q interface{}) error {
+	m.sessionsQuery = q
+	return m.err
 }
 
 func (m *mockExpireDatabase) Commit() error {
@@ -41,18 +70,38 @@ func (m *mockExpireDatabase) Transaction() (Transaction, error) {
 
 func TestPersistenceLayer_Expire(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
-		r := &persistenceLayer{
-			dal: &mockExpireDatabase{
-				err:      nil,
-				affected: 9876,
+		expired := time.Now().Add(-time.Hour)
+		db := &mockExpireDatabase{
+			err:      nil,
+			affected: 9876,
+			events: []Event{
+				{EventID: "a", AccountID: "account-a"},
+				{EventID: "b", AccountID: "account-a"},
+				{EventID: "c", AccountID: "account-b"},
+			},
+			relationships: []AccountUserRelationship{
+				{RelationshipID: "a", OneTimeEncryptedKeyEncryptionKey: "{1,} abc def", OneTimeKeyExpires: &expired},
 			},
 		}
+		r := &persistenceLayer{dal: db}
 		affected, err := r.Expire(time.Second)
 		if err != nil {
 			t.Errorf("Unexpected error %v", err)
 		}
-		if affected != 9876 {
-			t.Errorf("Expected %d, got %d", 9876, affected)
+		if affected.Removed != 9876 {
+			t.Errorf("Expected %d, got %d", 9876, affected.Removed)
+		}
+		if !reflect.DeepEqual(affected.RemovedByAccountID, map[string]int{"account-a": 2, "account-b": 1}) {
+			t.Errorf("Unexpected removed events by account %v", affected.RemovedByAccountID)
+		}
+		if _, ok := db.deliveriesQuery.(DeleteWebhookDeliveriesQueryOlderThan); !ok {
+			t.Errorf("Expected expired webhook deliveries to be deleted, got %v", db.deliveriesQuery)
+		}
+		if len(db.updated) != 1 || db.updated[0].OneTimeEncryptedKeyEncryptionKey != "" || db.updated[0].OneTimeKeyExpires != nil {
+			t.Errorf("Expected stale one time key to be removed, got %v", db.updated)
+		}
+		if _, ok := db.sessionsQuery.(DeleteSessionsQueryExpired); !ok {
+			t.Errorf("Expected expired sessions to be deleted, got %v", db.sessionsQuery)
 		}
 	})
 	t.Run("error", func(t *testing.T) {
@@ -65,8 +114,8 @@ func TestPersistenceLayer_Expire(t *testing.T) {
 		if err == nil {
 			t.Errorf("Unexpected error value %v", err)
 		}
-		if affected != 0 {
-			t.Errorf("Expected %d, got %d", 0, affected)
+		if affected.Removed != 0 {
+			t.Errorf("Expected %d, got %d", 0, affected.Removed)
 		}
 	})
 }
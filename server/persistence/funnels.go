@@ -0,0 +1,130 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	uuid "github.com/gofrs/uuid"
+)
+
+// Types of funnel steps.
+const (
+	// FunnelStepURL matches pageviews of the given URL. Query and fragment
+	// are ignored when matching.
+	FunnelStepURL = "url"
+	// FunnelStepEvent matches events of the given type.
+	FunnelStepEvent = "event"
+)
+
+const (
+	minFunnelSteps = 2
+	maxFunnelSteps = 10
+)
+
+func (f *Funnel) export() FunnelResult {
+	return FunnelResult{
+		FunnelID:  f.FunnelID,
+		AccountID: f.AccountID,
+		Name:      f.Name,
+		Steps:     f.Steps,
+		CreatedBy: f.CreatedBy,
+		Created:   f.Created,
+	}
+}
+
+func validateFunnelSteps(steps []FunnelStep) error {
+	if len(steps) < minFunnelSteps || len(steps) > maxFunnelSteps {
+		return fmt.Errorf("persistence: funnels need to have between %d and %d steps", minFunnelSteps, maxFunnelSteps)
+	}
+	for idx, step := range steps {
+		switch step.Type {
+		case FunnelStepURL:
+			u, err := url.Parse(step.Value)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return fmt.Errorf("persistence: step %d: %q is not a valid url", idx, step.Value)
+			}
+		case FunnelStepEvent:
+			if strings.TrimSpace(step.Value) == "" {
+				return fmt.Errorf("persistence: step %d: event type cannot be empty", idx)
+			}
+		default:
+			return fmt.Errorf("persistence: step %d: unknown step type %q", idx, step.Type)
+		}
+	}
+	return nil
+}
+
+// CreateFunnel defines a funnel consisting of the given steps for the
+// account.
+func (p *persistenceLayer) CreateFunnel(userID, accountID, name string, steps []FunnelStep) (FunnelResult, error) {
+	if strings.TrimSpace(name) == "" {
+		return FunnelResult{}, errors.New("persistence: funnels need to have a name")
+	}
+	if err := validateFunnelSteps(steps); err != nil {
+		return FunnelResult{}, err
+	}
+	if _, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID)); err != nil {
+		return FunnelResult{}, fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+	}
+
+	funnelID, err := uuid.NewV4()
+	if err != nil {
+		return FunnelResult{}, fmt.Errorf("persistence: error creating funnel id: %w", err)
+	}
+	funnel := &Funnel{
+		FunnelID:  funnelID.String(),
+		AccountID: accountID,
+		Name:      name,
+		Steps:     steps,
+		CreatedBy: userID,
+		Created:   time.Now(),
+	}
+	if err := p.dal.CreateFunnel(funnel); err != nil {
+		return FunnelResult{}, fmt.Errorf("persistence: error persisting funnel: %w", err)
+	}
+	return funnel.export(), nil
+}
+
+// ListFunnels returns all funnels of the given account.
+func (p *persistenceLayer) ListFunnels(accountID string) ([]FunnelResult, error) {
+	funnels, err := p.dal.FindFunnels(FindFunnelsQueryByAccountID(accountID))
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up funnels: %w", err)
+	}
+	result := []FunnelResult{}
+	for _, funnel := range funnels {
+		result = append(result, funnel.export())
+	}
+	return result, nil
+}
+
+// DeleteFunnel deletes the given funnel of the account.
+func (p *persistenceLayer) DeleteFunnel(accountID, funnelID string) error {
+	funnels, err := p.dal.FindFunnels(FindFunnelsQueryByAccountID(accountID))
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up funnels: %w", err)
+	}
+	var found bool
+	for _, funnel := range funnels {
+		if funnel.FunnelID == funnelID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrUnknownFunnel
+	}
+	if err := p.dal.DeleteFunnels(DeleteFunnelsQueryByID{
+		FunnelID:  funnelID,
+		AccountID: accountID,
+	}); err != nil {
+		return fmt.Errorf("persistence: error deleting funnel: %w", err)
+	}
+	return nil
+}
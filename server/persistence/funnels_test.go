@@ -0,0 +1,105 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"testing"
+)
+
+type mockFunnelsDatabase struct {
+	DataAccessLayer
+	funnels []Funnel
+	deleted []interface{}
+}
+
+func (m *mockFunnelsDatabase) FindAccount(q interface{}) (Account, error) {
+	if string(q.(FindAccountQueryActiveByID)) != "account-a" {
+		return Account{}, errors.New("not found")
+	}
+	return Account{AccountID: "account-a"}, nil
+}
+
+func (m *mockFunnelsDatabase) CreateFunnel(f *Funnel) error {
+	m.funnels = append(m.funnels, *f)
+	return nil
+}
+
+func (m *mockFunnelsDatabase) FindFunnels(q interface{}) ([]Funnel, error) {
+	var result []Funnel
+	for _, funnel := range m.funnels {
+		if funnel.AccountID == string(q.(FindFunnelsQueryByAccountID)) {
+			result = append(result, funnel)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockFunnelsDatabase) DeleteFunnels(q interface{}) error {
+	m.deleted = append(m.deleted, q)
+	return nil
+}
+
+func TestPersistenceLayer_CreateFunnel(t *testing.T) {
+	validSteps := []FunnelStep{
+		{Type: FunnelStepURL, Value: "https://www.example.com/pricing"},
+		{Type: FunnelStepURL, Value: "https://www.example.com/signup"},
+	}
+	tests := []struct {
+		name        string
+		accountID   string
+		funnelName  string
+		steps       []FunnelStep
+		expectError bool
+	}{
+		{"ok", "account-a", "Signup", validSteps, false},
+		{"event step", "account-a", "Signup", []FunnelStep{validSteps[0], {Type: FunnelStepEvent, Value: "PAGEVIEW"}}, false},
+		{"no name", "account-a", " ", validSteps, true},
+		{"single step", "account-a", "Signup", validSteps[:1], true},
+		{"relative url", "account-a", "Signup", []FunnelStep{validSteps[0], {Type: FunnelStepURL, Value: "/signup"}}, true},
+		{"empty event", "account-a", "Signup", []FunnelStep{validSteps[0], {Type: FunnelStepEvent}}, true},
+		{"unknown type", "account-a", "Signup", []FunnelStep{validSteps[0], {Type: "click", Value: "button"}}, true},
+		{"unknown account", "account-z", "Signup", validSteps, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &persistenceLayer{dal: &mockFunnelsDatabase{}}
+			result, err := p.CreateFunnel("user-a", test.accountID, test.funnelName, test.steps)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if err == nil && (result.FunnelID == "" || len(result.Steps) != len(test.steps)) {
+				t.Errorf("Unexpected result %v", result)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_Funnels(t *testing.T) {
+	db := &mockFunnelsDatabase{
+		funnels: []Funnel{
+			{FunnelID: "funnel-a", AccountID: "account-a"},
+			{FunnelID: "funnel-b", AccountID: "account-b"},
+		},
+	}
+	p := &persistenceLayer{dal: db}
+
+	listed, err := p.ListFunnels("account-a")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(listed) != 1 || listed[0].FunnelID != "funnel-a" {
+		t.Errorf("Unexpected funnels %v", listed)
+	}
+
+	if err := p.DeleteFunnel("account-a", "funnel-b"); !errors.Is(err, ErrUnknownFunnel) {
+		t.Errorf("Expected unknown funnel error, got %v", err)
+	}
+	if err := p.DeleteFunnel("account-a", "funnel-a"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(db.deleted) != 1 {
+		t.Errorf("Unexpected deletions %v", db.deleted)
+	}
+}
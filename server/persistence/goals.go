@@ -0,0 +1,150 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	uuid "github.com/gofrs/uuid"
+)
+
+// Types of goals.
+const (
+	// GoalTypeURL goals are reached by visiting a page matching a URL
+	// pattern. Patterns can contain `*` as a wildcard, e.g.
+	// "https://www.example.net/checkout/*/thanks".
+	GoalTypeURL = "url"
+	// GoalTypeEvent goals are reached by sending a custom event of the given
+	// name.
+	GoalTypeEvent = "event"
+)
+
+const (
+	maxGoals            = 50
+	maxGoalNameLength   = 128
+	maxGoalValueLength  = 2048
+	goalPatternWildcard = "*"
+)
+
+func (g *Goal) export() GoalResult {
+	return GoalResult{
+		GoalID:    g.GoalID,
+		AccountID: g.AccountID,
+		Name:      g.Name,
+		Type:      g.Type,
+		Value:     g.Value,
+		CreatedBy: g.CreatedBy,
+		Created:   g.Created,
+	}
+}
+
+func validateGoal(name, goalType, value string) error {
+	if name == "" || len(name) > maxGoalNameLength {
+		return fmt.Errorf("persistence: goal names must be between 1 and %d characters long", maxGoalNameLength)
+	}
+	switch goalType {
+	case GoalTypeURL:
+		if len(value) > maxGoalValueLength {
+			return fmt.Errorf("persistence: url patterns cannot be longer than %d characters", maxGoalValueLength)
+		}
+		// wildcards are replaced so patterns like "https://*.example.net/"
+		// can be parsed
+		u, err := url.Parse(strings.Replace(value, goalPatternWildcard, "x", -1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("persistence: %q is not a valid url pattern", value)
+		}
+	case GoalTypeEvent:
+		if !eventTypeName.MatchString(value) {
+			return fmt.Errorf("persistence: %q is not a valid event name", value)
+		}
+	default:
+		return fmt.Errorf("persistence: unknown goal type %q", goalType)
+	}
+	return nil
+}
+
+// CreateGoal defines a goal for the account. URL goals use a pattern that
+// is matched against the URLs of pageviews, event goals use the name of a
+// custom event.
+func (p *persistenceLayer) CreateGoal(userID, accountID, name, goalType, value string) (GoalResult, error) {
+	name = strings.TrimSpace(name)
+	if err := validateGoal(name, goalType, value); err != nil {
+		return GoalResult{}, err
+	}
+	if _, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID)); err != nil {
+		return GoalResult{}, fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+	}
+
+	existing, err := p.dal.FindGoals(FindGoalsQueryByAccountID(accountID))
+	if err != nil {
+		return GoalResult{}, fmt.Errorf("persistence: error looking up goals: %w", err)
+	}
+	if len(existing) >= maxGoals {
+		return GoalResult{}, fmt.Errorf("persistence: accounts cannot define more than %d goals", maxGoals)
+	}
+	for _, goal := range existing {
+		if goal.Name == name {
+			return GoalResult{}, fmt.Errorf("persistence: goal %q already exists", name)
+		}
+	}
+
+	goalID, err := uuid.NewV4()
+	if err != nil {
+		return GoalResult{}, fmt.Errorf("persistence: error creating goal id: %w", err)
+	}
+	goal := &Goal{
+		GoalID:    goalID.String(),
+		AccountID: accountID,
+		Name:      name,
+		Type:      goalType,
+		Value:     value,
+		CreatedBy: userID,
+		Created:   time.Now(),
+	}
+	if err := p.dal.CreateGoal(goal); err != nil {
+		return GoalResult{}, fmt.Errorf("persistence: error persisting goal: %w", err)
+	}
+	return goal.export(), nil
+}
+
+// ListGoals returns all goals of the given account.
+func (p *persistenceLayer) ListGoals(accountID string) ([]GoalResult, error) {
+	goals, err := p.dal.FindGoals(FindGoalsQueryByAccountID(accountID))
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up goals: %w", err)
+	}
+	result := []GoalResult{}
+	for _, goal := range goals {
+		result = append(result, goal.export())
+	}
+	return result, nil
+}
+
+// DeleteGoal deletes the given goal of the account.
+func (p *persistenceLayer) DeleteGoal(accountID, goalID string) error {
+	goals, err := p.dal.FindGoals(FindGoalsQueryByAccountID(accountID))
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up goals: %w", err)
+	}
+	var found bool
+	for _, goal := range goals {
+		if goal.GoalID == goalID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrUnknownGoal
+	}
+	if err := p.dal.DeleteGoals(DeleteGoalsQueryByID{
+		GoalID:    goalID,
+		AccountID: accountID,
+	}); err != nil {
+		return fmt.Errorf("persistence: error deleting goal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"testing"
+)
+
+type mockGoalsDatabase struct {
+	DataAccessLayer
+	goals   []Goal
+	deleted []interface{}
+}
+
+func (m *mockGoalsDatabase) FindAccount(q interface{}) (Account, error) {
+	if string(q.(FindAccountQueryActiveByID)) != "account-a" {
+		return Account{}, errors.New("not found")
+	}
+	return Account{AccountID: "account-a"}, nil
+}
+
+func (m *mockGoalsDatabase) CreateGoal(g *Goal) error {
+	m.goals = append(m.goals, *g)
+	return nil
+}
+
+func (m *mockGoalsDatabase) FindGoals(q interface{}) ([]Goal, error) {
+	var result []Goal
+	for _, goal := range m.goals {
+		if goal.AccountID == string(q.(FindGoalsQueryByAccountID)) {
+			result = append(result, goal)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockGoalsDatabase) DeleteGoals(q interface{}) error {
+	m.deleted = append(m.deleted, q)
+	return nil
+}
+
+func TestPersistenceLayer_CreateGoal(t *testing.T) {
+	tests := []struct {
+		name        string
+		accountID   string
+		goalName    string
+		goalType    string
+		value       string
+		expectError bool
+	}{
+		{"url", "account-a", "Checkout", GoalTypeURL, "https://www.offen.dev/checkout/*/thanks", false},
+		{"wildcard host", "account-a", "Docs", GoalTypeURL, "https://*.offen.dev/docs/*", false},
+		{"event", "account-a", "Signup", GoalTypeEvent, "signup", false},
+		{"duplicate", "account-a", "Newsletter", GoalTypeEvent, "signup", true},
+		{"empty name", "account-a", " ", GoalTypeEvent, "signup", true},
+		{"relative url", "account-a", "Checkout", GoalTypeURL, "/checkout", true},
+		{"bad scheme", "account-a", "Checkout", GoalTypeURL, "ftp://www.offen.dev", true},
+		{"bad event", "account-a", "Signup", GoalTypeEvent, "Sign Up", true},
+		{"unknown type", "account-a", "Signup", "duration", "10", true},
+		{"unknown account", "account-z", "Signup", GoalTypeEvent, "signup", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &persistenceLayer{dal: &mockGoalsDatabase{
+				goals: []Goal{{GoalID: "goal-a", AccountID: "account-a", Name: "Newsletter"}},
+			}}
+			result, err := p.CreateGoal("user-a", test.accountID, test.goalName, test.goalType, test.value)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if err == nil && (result.GoalID == "" || result.Value != test.value) {
+				t.Errorf("Unexpected result %v", result)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_Goals(t *testing.T) {
+	db := &mockGoalsDatabase{
+		goals: []Goal{
+			{GoalID: "goal-a", AccountID: "account-a"},
+			{GoalID: "goal-b", AccountID: "account-b"},
+		},
+	}
+	p := &persistenceLayer{dal: db}
+
+	listed, err := p.ListGoals("account-a")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(listed) != 1 || listed[0].GoalID != "goal-a" {
+		t.Errorf("Unexpected goals %v", listed)
+	}
+
+	if err := p.DeleteGoal("account-a", "goal-b"); !errors.Is(err, ErrUnknownGoal) {
+		t.Errorf("Expected unknown goal error, got %v", err)
+	}
+	if err := p.DeleteGoal("account-a", "goal-a"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(db.deleted) != 1 {
+		t.Errorf("Unexpected deletions %v", db.deleted)
+	}
+}
@@ -3,7 +3,25 @@
 
 package persistence
 
+import (
+	"fmt"
+	"strings"
+)
+
 // CheckHealth returns an error when the database connection is not working.
 func (p *persistenceLayer) CheckHealth() error {
 	return p.dal.Ping()
 }
+
+// CheckMigrations returns an error when the database schema is missing
+// migrations that are known to the application.
+func (p *persistenceLayer) CheckMigrations() error {
+	pending, err := p.dal.PendingMigrations()
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up pending migrations: %w", err)
+	}
+	if len(pending) != 0 {
+		return fmt.Errorf("persistence: %d migrations have not been applied: %s", len(pending), strings.Join(pending, ", "))
+	}
+	return nil
+}
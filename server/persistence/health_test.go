@@ -17,6 +17,16 @@ func (m *mockPingDatabase) Ping() error {
 	return m.err
 }
 
+type mockPendingMigrationsDatabase struct {
+	DataAccessLayer
+	pending []string
+	err     error
+}
+
+func (m *mockPendingMigrationsDatabase) PendingMigrations() ([]string, error) {
+	return m.pending, m.err
+}
+
 func TestPersistenceLayer_CheckHealth(t *testing.T) {
 	t.Run("error", func(t *testing.T) {
 		r := &persistenceLayer{dal: &mockPingDatabase{err: errors.New("did not work")}}
@@ -25,3 +35,35 @@ func TestPersistenceLayer_CheckHealth(t *testing.T) {
 		}
 	})
 }
+
+func TestPersistenceLayer_CheckMigrations(t *testing.T) {
+	tests := []struct {
+		name        string
+		dal         DataAccessLayer
+		expectError bool
+	}{
+		{
+			"ok",
+			&mockPendingMigrationsDatabase{},
+			false,
+		},
+		{
+			"pending",
+			&mockPendingMigrationsDatabase{pending: []string{"001_introduce_admin_level"}},
+			true,
+		},
+		{
+			"database error",
+			&mockPendingMigrationsDatabase{err: errors.New("did not work")},
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &persistenceLayer{dal: test.dal}
+			if err := r.CheckMigrations(); (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	uuid "github.com/gofrs/uuid"
+)
+
+// Impersonate opens a session for the account user with the given id on
+// behalf of the given super admin. The impersonation is recorded as an
+// authentication event of the account user so it can be reviewed by them.
+func (p *persistenceLayer) Impersonate(adminUserID, accountUserID, ipAddress, userAgent string, ttl time.Duration) (SessionResult, error) {
+	if adminUserID == accountUserID {
+		return SessionResult{}, errors.New("persistence: account users cannot impersonate themselves")
+	}
+	admin, err := p.dal.FindAccountUser(FindAccountUserQueryByAccountUserIDIncludeRelationships(adminUserID))
+	if err != nil {
+		return SessionResult{}, fmt.Errorf("persistence: error looking up admin: %w", err)
+	}
+	if admin.AdminLevel != AccountUserAdminLevelSuperAdmin || admin.Suspended {
+		return SessionResult{}, ErrPermissionDenied
+	}
+	accountUser, err := p.dal.FindAccountUser(FindAccountUserQueryByAccountUserIDIncludeRelationships(accountUserID))
+	if err != nil {
+		return SessionResult{}, fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	if accountUser.Suspended {
+		return SessionResult{}, ErrAccountUserSuspended
+	}
+
+	sessionID, err := uuid.NewV4()
+	if err != nil {
+		return SessionResult{}, fmt.Errorf("persistence: error creating session id: %w", err)
+	}
+	now := time.Now()
+	session := &Session{
+		SessionID:      sessionID.String(),
+		AccountUserID:  accountUserID,
+		IPAddress:      ipAddress,
+		UserAgent:      userAgent,
+		Created:        now,
+		Expires:        now.Add(ttl),
+		ImpersonatedBy: adminUserID,
+	}
+	// the event is recorded first so there is no impersonation that the
+	// account user cannot learn about
+	if err := p.recordAuthEvent(accountUserID, AuthEventImpersonationStarted, ipAddress, userAgent); err != nil {
+		return SessionResult{}, err
+	}
+	if err := p.dal.CreateSession(session); err != nil {
+		return SessionResult{}, fmt.Errorf("persistence: error persisting session: %w", err)
+	}
+	return session.export(), nil
+}
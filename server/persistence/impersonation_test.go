@@ -0,0 +1,74 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+type mockImpersonateDatabase struct {
+	mockSuspendDatabase
+	sessions   []Session
+	authEvents []AuthEvent
+}
+
+func (m *mockImpersonateDatabase) CreateSession(s *Session) error {
+	m.sessions = append(m.sessions, *s)
+	return nil
+}
+
+func (m *mockImpersonateDatabase) CreateAuthEvent(a *AuthEvent) error {
+	m.authEvents = append(m.authEvents, *a)
+	return nil
+}
+
+func TestPersistenceLayer_Impersonate(t *testing.T) {
+	tests := []struct {
+		name          string
+		adminUserID   string
+		accountUserID string
+		expectError   bool
+	}{
+		{"ok", "super-admin", "user", false},
+		{"self", "super-admin", "super-admin", true},
+		{"not a super admin", "admin", "user", true},
+		{"suspended user", "super-admin", "suspended-user", true},
+		{"unknown user", "super-admin", "unknown", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &mockImpersonateDatabase{
+				mockSuspendDatabase: mockSuspendDatabase{
+					accountUsers: map[string]AccountUser{
+						"super-admin":    {AccountUserID: "super-admin", AdminLevel: AccountUserAdminLevelSuperAdmin},
+						"admin":          {AccountUserID: "admin"},
+						"user":           {AccountUserID: "user"},
+						"suspended-user": {AccountUserID: "suspended-user", Suspended: true},
+					},
+				},
+			}
+			p := &persistenceLayer{dal: db}
+			result, err := p.Impersonate(test.adminUserID, test.accountUserID, "127.0.0.1", "curl", time.Minute)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if err != nil {
+				if len(db.sessions) != 0 {
+					t.Errorf("Unexpected sessions %v", db.sessions)
+				}
+				return
+			}
+			if result.AccountUserID != test.accountUserID || result.ImpersonatedBy != test.adminUserID {
+				t.Errorf("Unexpected result %v", result)
+			}
+			if time.Until(result.Expires) > time.Minute {
+				t.Errorf("Unexpected expiry %v", result.Expires)
+			}
+			if len(db.authEvents) != 1 || db.authEvents[0].AccountUserID != test.accountUserID || db.authEvents[0].Type != AuthEventImpersonationStarted {
+				t.Errorf("Unexpected auth events %v", db.authEvents)
+			}
+		})
+	}
+}
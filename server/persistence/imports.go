@@ -0,0 +1,137 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"fmt"
+	"time"
+)
+
+// Sources historical data can be imported from.
+const (
+	ImportSourceMatomo          = "matomo"
+	ImportSourceGoogleAnalytics = "google-analytics"
+)
+
+// ImportSources contains all tools historical data can be imported from.
+var ImportSources = []string{
+	ImportSourceMatomo,
+	ImportSourceGoogleAnalytics,
+}
+
+func validImportSource(source string) bool {
+	for _, s := range ImportSources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportRollups stores the given days as daily rollups of the account with
+// the given id, flagged as imported from the given source. Imported rollups
+// only contain the number of events and users, and are never computed again.
+// Days that already have a rollup computed from events are skipped, while
+// rollups imported before are replaced, so imports can be repeated. Days
+// that have not ended yet cannot be imported. In case dryRun is set, nothing
+// is saved.
+func (p *persistenceLayer) ImportRollups(accountID, source string, days []ImportedDay, dryRun bool) (ImportRollupsResult, error) {
+	result := ImportRollupsResult{AccountID: accountID, Source: source, DryRun: dryRun}
+	if !validImportSource(source) {
+		return result, fmt.Errorf("persistence: unknown import source %q", source)
+	}
+	account, err := p.dal.FindAccount(FindAccountQueryByID(accountID))
+	if err != nil {
+		return result, fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+	}
+	loc, err := account.location()
+	if err != nil {
+		return result, fmt.Errorf("persistence: error loading time zone of account %s: %w", accountID, err)
+	}
+
+	now := time.Now()
+	today := startOfPeriod(now, RollupPeriodDay, loc)
+	rollups := map[time.Time]*Rollup{}
+	var from, until time.Time
+	for _, day := range days {
+		start := time.Date(day.Date.Year(), day.Date.Month(), day.Date.Day(), 0, 0, 0, 0, loc)
+		if !start.Before(today) {
+			return result, fmt.Errorf("persistence: cannot import %s as the day has not ended yet", start.Format("2006-01-02"))
+		}
+		if day.Events < 0 || day.Users < 0 {
+			return result, fmt.Errorf("persistence: unexpected negative counts for %s", start.Format("2006-01-02"))
+		}
+		start = start.UTC()
+		if _, ok := rollups[start]; ok {
+			return result, fmt.Errorf("persistence: received duplicate data for %s", day.Date.Format("2006-01-02"))
+		}
+		rollups[start] = &Rollup{
+			RollupID:     rollupID(accountID, RollupPeriodDay, start),
+			AccountID:    accountID,
+			Period:       RollupPeriodDay,
+			Start:        start,
+			Events:       day.Events,
+			Users:        day.Users,
+			SamplingRate: 1,
+			ImportSource: source,
+			Updated:      now,
+		}
+		if from.IsZero() || start.Before(from) {
+			from = start
+		}
+		if end := nextPeriod(start.In(loc), RollupPeriodDay).UTC(); end.After(until) {
+			until = end
+		}
+	}
+	if len(rollups) == 0 {
+		return result, nil
+	}
+
+	existing, err := p.dal.FindRollups(FindRollupsQueryByAccountID{
+		AccountID: accountID,
+		Period:    RollupPeriodDay,
+		From:      from,
+		Until:     until,
+	})
+	if err != nil {
+		return result, fmt.Errorf("persistence: error looking up existing rollups: %w", err)
+	}
+	for _, rollup := range existing {
+		if rollup.ImportSource != "" {
+			continue
+		}
+		if _, ok := rollups[rollup.Start.UTC()]; ok {
+			delete(rollups, rollup.Start.UTC())
+			result.Skipped++
+		}
+	}
+	result.Imported = len(rollups)
+	for start := range rollups {
+		local := start.In(loc)
+		if result.From == nil || local.Before(*result.From) {
+			result.From = &local
+		}
+		if result.Until == nil || local.After(*result.Until) {
+			result.Until = &local
+		}
+	}
+	if dryRun || len(rollups) == 0 {
+		return result, nil
+	}
+
+	txn, err := p.dal.Transaction()
+	if err != nil {
+		return result, fmt.Errorf("persistence: error creating transaction: %w", err)
+	}
+	for _, rollup := range rollups {
+		if err := txn.SaveRollup(rollup); err != nil {
+			txn.Rollback()
+			return result, fmt.Errorf("persistence: error saving rollup: %w", err)
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return result, fmt.Errorf("persistence: error committing transaction: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPersistenceLayer_ImportRollups(t *testing.T) {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	tests := []struct {
+		name             string
+		db               *mockTimezonesDatabase
+		source           string
+		days             []ImportedDay
+		dryRun           bool
+		expectError      bool
+		expectedImported int
+		expectedSkipped  int
+		expectedSaved    []Rollup
+	}{
+		{
+			"unknown source",
+			&mockTimezonesDatabase{mockRollupsDatabase: mockRollupsDatabase{accounts: []Account{{AccountID: "account-a"}}}},
+			"other-tool",
+			[]ImportedDay{{Date: day(2020, 3, 1), Events: 12, Users: 4}},
+			false,
+			true,
+			0,
+			0,
+			nil,
+		},
+		{
+			"account lookup error",
+			&mockTimezonesDatabase{mockRollupsDatabase: mockRollupsDatabase{accounts: []Account{{}}, err: errors.New("did not work")}},
+			ImportSourceMatomo,
+			[]ImportedDay{{Date: day(2020, 3, 1), Events: 12, Users: 4}},
+			false,
+			true,
+			0,
+			0,
+			nil,
+		},
+		{
+			"day not ended",
+			&mockTimezonesDatabase{mockRollupsDatabase: mockRollupsDatabase{accounts: []Account{{AccountID: "account-a"}}}},
+			ImportSourceMatomo,
+			[]ImportedDay{{Date: time.Now().AddDate(0, 0, 1), Events: 12, Users: 4}},
+			false,
+			true,
+			0,
+			0,
+			nil,
+		},
+		{
+			"duplicate day",
+			&mockTimezonesDatabase{mockRollupsDatabase: mockRollupsDatabase{accounts: []Account{{AccountID: "account-a"}}}},
+			ImportSourceMatomo,
+			[]ImportedDay{{Date: day(2020, 3, 1), Events: 12, Users: 4}, {Date: day(2020, 3, 1), Events: 1, Users: 1}},
+			false,
+			true,
+			0,
+			0,
+			nil,
+		},
+		{
+			"ok",
+			&mockTimezonesDatabase{mockRollupsDatabase: mockRollupsDatabase{
+				accounts: []Account{{AccountID: "account-a", Timezone: "Europe/Berlin"}},
+				rollups: []Rollup{
+					{AccountID: "account-a", Period: RollupPeriodDay, Start: time.Date(2020, 3, 2, 0, 0, 0, 0, berlin).UTC(), Events: 3},
+					{AccountID: "account-a", Period: RollupPeriodDay, Start: time.Date(2020, 3, 3, 0, 0, 0, 0, berlin).UTC(), Events: 8, ImportSource: ImportSourceMatomo},
+				},
+			}},
+			ImportSourceGoogleAnalytics,
+			[]ImportedDay{
+				{Date: day(2020, 3, 1), Events: 12, Users: 4},
+				{Date: day(2020, 3, 2), Events: 1, Users: 1},
+				{Date: day(2020, 3, 3), Events: 7, Users: 2},
+			},
+			false,
+			false,
+			2,
+			1,
+			[]Rollup{
+				{
+					RollupID: rollupID("account-a", RollupPeriodDay, time.Date(2020, 3, 1, 0, 0, 0, 0, berlin)), AccountID: "account-a",
+					Period: RollupPeriodDay, Start: time.Date(2020, 3, 1, 0, 0, 0, 0, berlin).UTC(), Events: 12, Users: 4, SamplingRate: 1, ImportSource: ImportSourceGoogleAnalytics,
+				},
+				{
+					RollupID: rollupID("account-a", RollupPeriodDay, time.Date(2020, 3, 3, 0, 0, 0, 0, berlin)), AccountID: "account-a",
+					Period: RollupPeriodDay, Start: time.Date(2020, 3, 3, 0, 0, 0, 0, berlin).UTC(), Events: 7, Users: 2, SamplingRate: 1, ImportSource: ImportSourceGoogleAnalytics,
+				},
+			},
+		},
+		{
+			"dry run",
+			&mockTimezonesDatabase{mockRollupsDatabase: mockRollupsDatabase{accounts: []Account{{AccountID: "account-a"}}}},
+			ImportSourceMatomo,
+			[]ImportedDay{{Date: day(2020, 3, 1), Events: 12, Users: 4}},
+			true,
+			false,
+			1,
+			0,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &persistenceLayer{dal: test.db}
+			result, err := p.ImportRollups("account-a", test.source, test.days, test.dryRun)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if result.Imported != test.expectedImported || result.Skipped != test.expectedSkipped {
+				t.Errorf("Unexpected result %v", result)
+			}
+			if len(test.db.saved) != len(test.expectedSaved) {
+				t.Fatalf("Expected %d saved rollups, got %v", len(test.expectedSaved), test.db.saved)
+			}
+			for _, expected := range test.expectedSaved {
+				var match *Rollup
+				for i, saved := range test.db.saved {
+					if saved.RollupID == expected.RollupID {
+						match = &test.db.saved[i]
+					}
+				}
+				if match == nil {
+					t.Errorf("Expected rollup %v to be saved", expected)
+					continue
+				}
+				match.Updated = time.Time{}
+				if *match != expected {
+					t.Errorf("Expected %v, got %v", expected, *match)
+				}
+			}
+		})
+	}
+}
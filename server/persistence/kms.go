@@ -0,0 +1,279 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/offen/offen/server/kms"
+)
+
+// wrappedEnvelopePrefix marks envelope values that have been wrapped using
+// a KMS provider. Values without this prefix have been written before a
+// provider was configured and will be wrapped the next time they are saved.
+const wrappedEnvelopePrefix = "kms:"
+
+// kmsDAL wraps all key encryption key envelopes using the given provider
+// before they are written to the underlying data access layer and unwraps
+// them when they are read. Next to the envelopes of account user
+// relationships, this covers the envelopes for device keys, secondary email
+// addresses, pending email changes, account recovery and passkeys.
+type kmsDAL struct {
+	DataAccessLayer
+	provider kms.Provider
+}
+
+func newKMSDAL(dal DataAccessLayer, provider kms.Provider) *kmsDAL {
+	return &kmsDAL{DataAccessLayer: dal, provider: provider}
+}
+
+func (k *kmsDAL) wrap(value string) (string, error) {
+	if value == "" || strings.HasPrefix(value, wrappedEnvelopePrefix) {
+		return value, nil
+	}
+	wrapped, err := k.provider.Wrap([]byte(value))
+	if err != nil {
+		return "", fmt.Errorf("persistence: error wrapping envelope: %w", err)
+	}
+	return wrappedEnvelopePrefix + base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+func (k *kmsDAL) unwrap(value string) (string, error) {
+	if !strings.HasPrefix(value, wrappedEnvelopePrefix) {
+		return value, nil
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, wrappedEnvelopePrefix))
+	if err != nil {
+		return "", fmt.Errorf("persistence: error decoding wrapped envelope: %w", err)
+	}
+	unwrapped, err := k.provider.Unwrap(wrapped)
+	if err != nil {
+		return "", fmt.Errorf("persistence: error unwrapping envelope: %w", err)
+	}
+	return string(unwrapped), nil
+}
+
+func applyFields(fn func(string) (string, error), fields ...*string) error {
+	for _, field := range fields {
+		value, err := fn(*field)
+		if err != nil {
+			return err
+		}
+		*field = value
+	}
+	return nil
+}
+
+func (k *kmsDAL) apply(r *AccountUserRelationship, fn func(string) (string, error)) error {
+	return applyFields(
+		fn,
+		&r.PasswordEncryptedKeyEncryptionKey,
+		&r.EmailEncryptedKeyEncryptionKey,
+		&r.OneTimeEncryptedKeyEncryptionKey,
+		&r.KeyEncryptionKeyRotations,
+	)
+}
+
+// applyAccountUser applies the given func to all fields of the account user
+// that contain envelopes. These are JSON encoded, so they are wrapped as
+// a whole.
+func (k *kmsDAL) applyAccountUser(a *AccountUser, fn func(string) (string, error)) error {
+	return applyFields(
+		fn,
+		&a.DeviceEncryptedKeyEncryptionKeys,
+		&a.SecondaryEmails,
+		&a.PendingEmailChange,
+	)
+}
+
+// wrapAccountUser returns a copy of the given account user so callers can
+// keep using the unwrapped values after writing.
+func (k *kmsDAL) wrapAccountUser(a *AccountUser) (*AccountUser, error) {
+	wrapped := *a
+	if err := k.applyAccountUser(&wrapped, k.wrap); err != nil {
+		return nil, err
+	}
+	wrapped.Relationships = nil
+	for _, relationship := range a.Relationships {
+		if err := k.apply(&relationship, k.wrap); err != nil {
+			return nil, err
+		}
+		wrapped.Relationships = append(wrapped.Relationships, relationship)
+	}
+	return &wrapped, nil
+}
+
+func (k *kmsDAL) unwrapAccountUser(a *AccountUser) error {
+	if err := k.applyAccountUser(a, k.unwrap); err != nil {
+		return err
+	}
+	for idx := range a.Relationships {
+		if err := k.apply(&a.Relationships[idx], k.unwrap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *kmsDAL) CreateAccountUser(a *AccountUser) error {
+	wrapped, err := k.wrapAccountUser(a)
+	if err != nil {
+		return err
+	}
+	return k.DataAccessLayer.CreateAccountUser(wrapped)
+}
+
+func (k *kmsDAL) UpdateAccountUser(a *AccountUser) error {
+	wrapped, err := k.wrapAccountUser(a)
+	if err != nil {
+		return err
+	}
+	return k.DataAccessLayer.UpdateAccountUser(wrapped)
+}
+
+func (k *kmsDAL) FindAccountUser(q interface{}) (AccountUser, error) {
+	accountUser, err := k.DataAccessLayer.FindAccountUser(q)
+	if err != nil {
+		return accountUser, err
+	}
+	if err := k.unwrapAccountUser(&accountUser); err != nil {
+		return AccountUser{}, err
+	}
+	return accountUser, nil
+}
+
+func (k *kmsDAL) FindAccountUsers(q interface{}) ([]AccountUser, error) {
+	accountUsers, err := k.DataAccessLayer.FindAccountUsers(q)
+	if err != nil {
+		return accountUsers, err
+	}
+	for idx := range accountUsers {
+		if err := k.unwrapAccountUser(&accountUsers[idx]); err != nil {
+			return nil, err
+		}
+	}
+	return accountUsers, nil
+}
+
+func (k *kmsDAL) CreateAccountUserRelationship(r *AccountUserRelationship) error {
+	wrapped := *r
+	if err := k.apply(&wrapped, k.wrap); err != nil {
+		return err
+	}
+	return k.DataAccessLayer.CreateAccountUserRelationship(&wrapped)
+}
+
+func (k *kmsDAL) UpdateAccountUserRelationship(r *AccountUserRelationship) error {
+	wrapped := *r
+	if err := k.apply(&wrapped, k.wrap); err != nil {
+		return err
+	}
+	return k.DataAccessLayer.UpdateAccountUserRelationship(&wrapped)
+}
+
+func (k *kmsDAL) FindAccountUserRelationships(q interface{}) ([]AccountUserRelationship, error) {
+	relationships, err := k.DataAccessLayer.FindAccountUserRelationships(q)
+	if err != nil {
+		return relationships, err
+	}
+	for idx := range relationships {
+		if err := k.apply(&relationships[idx], k.unwrap); err != nil {
+			return nil, err
+		}
+	}
+	return relationships, nil
+}
+
+func (k *kmsDAL) CreateAccount(a *Account) error {
+	wrapped := *a
+	if err := applyFields(k.wrap, &wrapped.EscrowEncryptedKeyEncryptionKey); err != nil {
+		return err
+	}
+	return k.DataAccessLayer.CreateAccount(&wrapped)
+}
+
+func (k *kmsDAL) UpdateAccount(a *Account) error {
+	wrapped := *a
+	if err := applyFields(k.wrap, &wrapped.EscrowEncryptedKeyEncryptionKey); err != nil {
+		return err
+	}
+	return k.DataAccessLayer.UpdateAccount(&wrapped)
+}
+
+func (k *kmsDAL) FindAccount(q interface{}) (Account, error) {
+	account, err := k.DataAccessLayer.FindAccount(q)
+	if err != nil {
+		return account, err
+	}
+	if err := applyFields(k.unwrap, &account.EscrowEncryptedKeyEncryptionKey); err != nil {
+		return Account{}, err
+	}
+	return account, nil
+}
+
+func (k *kmsDAL) FindAccounts(q interface{}) ([]Account, error) {
+	accounts, err := k.DataAccessLayer.FindAccounts(q)
+	if err != nil {
+		return accounts, err
+	}
+	for idx := range accounts {
+		if err := applyFields(k.unwrap, &accounts[idx].EscrowEncryptedKeyEncryptionKey); err != nil {
+			return nil, err
+		}
+	}
+	return accounts, nil
+}
+
+func (k *kmsDAL) CreateWebAuthnCredential(w *WebAuthnCredential) error {
+	wrapped := *w
+	if err := applyFields(k.wrap, &wrapped.EncryptedKeyEncryptionKeys); err != nil {
+		return err
+	}
+	return k.DataAccessLayer.CreateWebAuthnCredential(&wrapped)
+}
+
+func (k *kmsDAL) UpdateWebAuthnCredential(w *WebAuthnCredential) error {
+	wrapped := *w
+	if err := applyFields(k.wrap, &wrapped.EncryptedKeyEncryptionKeys); err != nil {
+		return err
+	}
+	return k.DataAccessLayer.UpdateWebAuthnCredential(&wrapped)
+}
+
+func (k *kmsDAL) FindWebAuthnCredential(q interface{}) (WebAuthnCredential, error) {
+	credential, err := k.DataAccessLayer.FindWebAuthnCredential(q)
+	if err != nil {
+		return credential, err
+	}
+	if err := applyFields(k.unwrap, &credential.EncryptedKeyEncryptionKeys); err != nil {
+		return WebAuthnCredential{}, err
+	}
+	return credential, nil
+}
+
+func (k *kmsDAL) Transaction() (Transaction, error) {
+	txn, err := k.DataAccessLayer.Transaction()
+	if err != nil {
+		return nil, err
+	}
+	return &kmsTransaction{
+		kmsDAL: newKMSDAL(txn, k.provider),
+		txn:    txn,
+	}, nil
+}
+
+type kmsTransaction struct {
+	*kmsDAL
+	txn Transaction
+}
+
+func (k *kmsTransaction) Commit() error {
+	return k.txn.Commit()
+}
+
+func (k *kmsTransaction) Rollback() error {
+	return k.txn.Rollback()
+}
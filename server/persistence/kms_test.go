@@ -0,0 +1,232 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type mockKMSProvider struct {
+	err error
+}
+
+func (m *mockKMSProvider) Wrap(plaintext []byte) ([]byte, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return append([]byte("wrapped-"), plaintext...), nil
+}
+
+func (m *mockKMSProvider) Unwrap(ciphertext []byte) ([]byte, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return []byte(strings.TrimPrefix(string(ciphertext), "wrapped-")), nil
+}
+
+type mockKMSDatabase struct {
+	DataAccessLayer
+	relationships []AccountUserRelationship
+	accountUsers  []AccountUser
+	accounts      []Account
+	credentials   []WebAuthnCredential
+	committed     bool
+}
+
+func (m *mockKMSDatabase) UpdateAccountUser(a *AccountUser) error {
+	m.accountUsers = append(m.accountUsers, *a)
+	return nil
+}
+
+func (m *mockKMSDatabase) FindAccountUser(interface{}) (AccountUser, error) {
+	return m.accountUsers[len(m.accountUsers)-1], nil
+}
+
+func (m *mockKMSDatabase) UpdateAccount(a *Account) error {
+	m.accounts = append(m.accounts, *a)
+	return nil
+}
+
+func (m *mockKMSDatabase) FindAccount(interface{}) (Account, error) {
+	return m.accounts[len(m.accounts)-1], nil
+}
+
+func (m *mockKMSDatabase) UpdateWebAuthnCredential(w *WebAuthnCredential) error {
+	m.credentials = append(m.credentials, *w)
+	return nil
+}
+
+func (m *mockKMSDatabase) FindWebAuthnCredential(interface{}) (WebAuthnCredential, error) {
+	return m.credentials[len(m.credentials)-1], nil
+}
+
+func (m *mockKMSDatabase) UpdateAccountUserRelationship(r *AccountUserRelationship) error {
+	m.relationships = append(m.relationships, *r)
+	return nil
+}
+
+func (m *mockKMSDatabase) FindAccountUserRelationships(interface{}) ([]AccountUserRelationship, error) {
+	return m.relationships, nil
+}
+
+func (m *mockKMSDatabase) Transaction() (Transaction, error) {
+	return m, nil
+}
+
+func (m *mockKMSDatabase) Commit() error {
+	m.committed = true
+	return nil
+}
+
+func (m *mockKMSDatabase) Rollback() error {
+	return nil
+}
+
+func TestKMSDAL(t *testing.T) {
+	t.Run("roundtrip", func(t *testing.T) {
+		db := &mockKMSDatabase{}
+		dal := newKMSDAL(db, &mockKMSProvider{})
+
+		relationship := &AccountUserRelationship{
+			RelationshipID:                    "relationship-a",
+			PasswordEncryptedKeyEncryptionKey: "{1,} password",
+			EmailEncryptedKeyEncryptionKey:    "{1,} email",
+		}
+		if err := dal.UpdateAccountUserRelationship(relationship); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if relationship.PasswordEncryptedKeyEncryptionKey != "{1,} password" {
+			t.Errorf("Expected caller's value to be left untouched, got %v", relationship.PasswordEncryptedKeyEncryptionKey)
+		}
+		stored := db.relationships[0]
+		if !strings.HasPrefix(stored.PasswordEncryptedKeyEncryptionKey, wrappedEnvelopePrefix) {
+			t.Errorf("Expected stored value to be wrapped, got %v", stored.PasswordEncryptedKeyEncryptionKey)
+		}
+		if stored.OneTimeEncryptedKeyEncryptionKey != "" {
+			t.Errorf("Expected empty value to stay empty, got %v", stored.OneTimeEncryptedKeyEncryptionKey)
+		}
+
+		result, err := dal.FindAccountUserRelationships(nil)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if result[0].PasswordEncryptedKeyEncryptionKey != "{1,} password" || result[0].EmailEncryptedKeyEncryptionKey != "{1,} email" {
+			t.Errorf("Unexpected result %v", result[0])
+		}
+	})
+	t.Run("all envelopes", func(t *testing.T) {
+		db := &mockKMSDatabase{}
+		dal := newKMSDAL(db, &mockKMSProvider{})
+
+		accountUser := AccountUser{
+			AccountUserID:                    "user-a",
+			DeviceEncryptedKeyEncryptionKeys: `{"relationship-a":"{1,} device"}`,
+			SecondaryEmails:                  `[{"emailEncryptedKeys":{"relationship-a":"{1,} secondary"}}]`,
+			PendingEmailChange:               `{"emailEncryptedKeys":{"relationship-a":"{1,} pending"}}`,
+			Relationships: []AccountUserRelationship{
+				{RelationshipID: "relationship-a", PasswordEncryptedKeyEncryptionKey: "{1,} password"},
+			},
+		}
+		account := Account{AccountID: "account-a", EscrowEncryptedKeyEncryptionKey: "escrow"}
+		credential := WebAuthnCredential{CredentialID: "credential-a", EncryptedKeyEncryptionKeys: `{"relationship-a":"{1,} passkey"}`}
+
+		if err := dal.UpdateAccountUser(&accountUser); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if err := dal.UpdateAccount(&account); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if err := dal.UpdateWebAuthnCredential(&credential); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		storedUser, storedAccount, storedCredential := db.accountUsers[0], db.accounts[0], db.credentials[0]
+		for name, value := range map[string]string{
+			"device":      storedUser.DeviceEncryptedKeyEncryptionKeys,
+			"secondary":   storedUser.SecondaryEmails,
+			"pending":     storedUser.PendingEmailChange,
+			"password":    storedUser.Relationships[0].PasswordEncryptedKeyEncryptionKey,
+			"escrow":      storedAccount.EscrowEncryptedKeyEncryptionKey,
+			"credentials": storedCredential.EncryptedKeyEncryptionKeys,
+		} {
+			if !strings.HasPrefix(value, wrappedEnvelopePrefix) {
+				t.Errorf("Expected %s envelope to be wrapped, got %v", name, value)
+			}
+		}
+
+		readUser, err := dal.FindAccountUser(nil)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if !reflect.DeepEqual(readUser, accountUser) {
+			t.Errorf("Unexpected account user %v", readUser)
+		}
+		readAccount, err := dal.FindAccount(nil)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if !reflect.DeepEqual(readAccount, account) {
+			t.Errorf("Unexpected account %v", readAccount)
+		}
+		readCredential, err := dal.FindWebAuthnCredential(nil)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if !reflect.DeepEqual(readCredential, credential) {
+			t.Errorf("Unexpected credential %v", readCredential)
+		}
+	})
+	t.Run("legacy values", func(t *testing.T) {
+		db := &mockKMSDatabase{
+			relationships: []AccountUserRelationship{
+				{PasswordEncryptedKeyEncryptionKey: "{1,} password"},
+			},
+		}
+		dal := newKMSDAL(db, &mockKMSProvider{})
+		result, err := dal.FindAccountUserRelationships(nil)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if result[0].PasswordEncryptedKeyEncryptionKey != "{1,} password" {
+			t.Errorf("Unexpected result %v", result[0])
+		}
+	})
+	t.Run("transaction", func(t *testing.T) {
+		db := &mockKMSDatabase{}
+		dal := newKMSDAL(db, &mockKMSProvider{})
+		txn, err := dal.Transaction()
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if err := txn.UpdateAccountUserRelationship(&AccountUserRelationship{EmailEncryptedKeyEncryptionKey: "{1,} email"}); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if err := txn.Commit(); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if !db.committed {
+			t.Error("Expected transaction to be committed")
+		}
+		if !strings.HasPrefix(db.relationships[0].EmailEncryptedKeyEncryptionKey, wrappedEnvelopePrefix) {
+			t.Errorf("Expected stored value to be wrapped, got %v", db.relationships[0].EmailEncryptedKeyEncryptionKey)
+		}
+	})
+	t.Run("provider error", func(t *testing.T) {
+		db := &mockKMSDatabase{
+			relationships: []AccountUserRelationship{
+				{PasswordEncryptedKeyEncryptionKey: wrappedEnvelopePrefix + "d3JhcHBlZA=="},
+			},
+		}
+		dal := newKMSDAL(db, &mockKMSProvider{err: errors.New("did not work")})
+		if err := dal.UpdateAccountUserRelationship(&AccountUserRelationship{EmailEncryptedKeyEncryptionKey: "{1,} email"}); err == nil {
+			t.Error("Expected error when wrapping")
+		}
+		if _, err := dal.FindAccountUserRelationships(nil); err == nil {
+			t.Error("Expected error when unwrapping")
+		}
+	})
+}
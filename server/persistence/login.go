@@ -14,17 +14,61 @@ import (
 	"github.com/offen/offen/server/keys"
 )
 
+// Login verifies the given credentials and returns the keys of the account
+// user. It does not check a second factor and is meant for confirming the
+// credentials of account users that are already authenticated. New sessions
+// are expected to use LoginWithSecondFactor.
 func (p *persistenceLayer) Login(email, password string) (LoginResult, error) {
+	return p.login(email, password, false, "")
+}
+
+// LoginWithSecondFactor verifies the given credentials and - in case the
+// account user has enabled a second factor - the given TOTP or recovery code
+// before releasing any key material. ErrSecondFactorRequired is returned when
+// no code is given for such account users.
+func (p *persistenceLayer) LoginWithSecondFactor(email, password, code string) (LoginResult, error) {
+	return p.login(email, password, true, code)
+}
+
+func (p *persistenceLayer) login(email, password string, checkSecondFactor bool, code string) (LoginResult, error) {
 	accountUser, err := p.findAccountUser(email, true, true)
 	if err != nil {
-		return LoginResult{}, fmt.Errorf("persistence: error looking up account user: %w", err)
+		// the work of comparing the password and deriving a key is performed
+		// for unknown users too, so that the response time does not reveal
+		// whether an email address is in use
+		p.performDummyLogin(password)
+		return LoginResult{}, ErrInvalidCredentials
+	}
+
+	if err := p.verifyPassword(accountUser, email, password); err != nil {
+		// a key is derived for wrong passwords too, so that the work
+		// performed equals the one for unknown users
+		p.hasher().DeriveKey(password, accountUser.Salt)
+		return LoginResult{}, err
+	}
+
+	// suspension is only revealed to callers that know the password
+	if accountUser.Suspended {
+		return LoginResult{}, ErrAccountUserSuspended
+	}
+
+	if checkSecondFactor && accountUser.SecondFactorEnabled {
+		if code == "" {
+			return LoginResult{}, ErrSecondFactorRequired
+		}
+		if err := p.checkSecondFactor(accountUser, email, code); err != nil {
+			if !errors.Is(err, ErrInvalidCredentials) {
+				return LoginResult{}, fmt.Errorf("persistence: error verifying second factor: %w", err)
+			}
+			return LoginResult{}, ErrInvalidSecondFactor
+		}
 	}
 
-	if err := keys.CompareString(password, accountUser.HashedPassword); err != nil {
-		return LoginResult{}, fmt.Errorf("persistence: error comparing passwords: %w", err)
+	if p.passwordExpired(accountUser, time.Now()) {
+		return LoginResult{}, ErrPasswordExpired
 	}
 
-	pwDerivedKey, pwDerivedKeyErr := keys.DeriveKey(password, accountUser.Salt)
+	pwDerivedKey, pwDerivedKeyErr := p.hasher().DeriveKey(password, accountUser.Salt)
 	if pwDerivedKeyErr != nil {
 		return LoginResult{}, fmt.Errorf("persistence: error deriving key from password: %w", pwDerivedKeyErr)
 	}
@@ -33,12 +77,14 @@ func (p *persistenceLayer) Login(email, password string) (LoginResult, error) {
 	// populate with proper password encrypted keys now
 	var emailDerivedKey []byte
 	for idx, relationship := range accountUser.Relationships {
-		if relationship.PasswordEncryptedKeyEncryptionKey != "" {
+		// invitations created using a token are accepted using
+		// AcceptInvitation instead
+		if relationship.PasswordEncryptedKeyEncryptionKey != "" || relationship.EmailEncryptedKeyEncryptionKey == "" {
 			continue
 		}
 		if emailDerivedKey == nil {
 			var err error
-			emailDerivedKey, err = keys.DeriveKey(email, accountUser.Salt)
+			emailDerivedKey, err = keys.DeriveKey(email, accountUser.emailSalt())
 			if err != nil {
 				return LoginResult{}, fmt.Errorf("persistence: error deriving key from email: %w", err)
 			}
@@ -56,12 +102,28 @@ func (p *persistenceLayer) Login(email, password string) (LoginResult, error) {
 		accountUser.Relationships[idx] = relationship
 	}
 
+	pwDerivedKey, err = p.upgradeCredentials(accountUser, email, password, pwDerivedKey)
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("persistence: error upgrading outdated credentials: %w", err)
+	}
+
 	var results []LoginAccountResult
 	for _, relationship := range accountUser.Relationships {
+		if relationship.PasswordEncryptedKeyEncryptionKey == "" {
+			continue
+		}
 		decryptedKey, decryptedKeyErr := keys.DecryptWith(pwDerivedKey, relationship.PasswordEncryptedKeyEncryptionKey)
 		if decryptedKeyErr != nil {
 			return LoginResult{}, fmt.Errorf(`persistence: failed decrypting key encryption key for account "%s": %w`, relationship.AccountID, decryptedKeyErr)
 		}
+		// the account's key might have been rotated by another account user
+		// in which case the rotation is completed for this relationship now
+		if relationship.KeyEncryptionKeyRotations != "" {
+			decryptedKey, err = p.completeKeyRotation(&relationship, decryptedKey, accountUser, email, password)
+			if err != nil {
+				return LoginResult{}, fmt.Errorf(`persistence: error completing key rotation for account "%s": %w`, relationship.AccountID, err)
+			}
+		}
 		k, kErr := jwk.New(decryptedKey)
 		if kErr != nil {
 			return LoginResult{}, kErr
@@ -71,23 +133,169 @@ func (p *persistenceLayer) Login(email, password string) (LoginResult, error) {
 		if err != nil {
 			return LoginResult{}, fmt.Errorf(`persistence: error looking up account with id "%s": %w`, relationship.AccountID, err)
 		}
+		// accounts that have been created before key escrow has been enabled
+		// are escrowed as soon as the key encryption key is available
+		if changed, err := account.escrow(p.escrowKey, decryptedKey); err != nil {
+			return LoginResult{}, fmt.Errorf(`persistence: error escrowing key for account "%s": %w`, relationship.AccountID, err)
+		} else if changed {
+			if err := p.dal.UpdateAccount(&account); err != nil {
+				return LoginResult{}, fmt.Errorf(`persistence: error updating account "%s": %w`, relationship.AccountID, err)
+			}
+		}
 
 		result := LoginAccountResult{
 			AccountName:      account.Name,
 			AccountID:        relationship.AccountID,
 			Created:          account.Created,
 			KeyEncryptionKey: k,
+			Role:             relationship.Role,
 		}
 		results = append(results, result)
 	}
 
+	allowedNetworks, err := accountUser.allowedNetworks()
+	if err != nil {
+		return LoginResult{}, err
+	}
+
 	return LoginResult{
-		AccountUserID: accountUser.AccountUserID,
-		AdminLevel:    accountUser.AdminLevel,
-		Accounts:      results,
+		AccountUserID:   accountUser.AccountUserID,
+		AdminLevel:      accountUser.AdminLevel,
+		Accounts:        results,
+		AllowedNetworks: allowedNetworks,
 	}, nil
 }
 
+// completeKeyRotation applies all pending key rotations of the given
+// relationship and re-encrypts its envelopes using the current key.
+func (p *persistenceLayer) completeKeyRotation(relationship *AccountUserRelationship, envelopeKey []byte, accountUser *AccountUser, email, password string) ([]byte, error) {
+	key, err := relationship.resolveKeyEncryptionKey(envelopeKey, p.rotationKeys...)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error resolving key encryption key: %w", err)
+	}
+	if err := relationship.addPasswordEncryptedKey(key, accountUser.Salt, password); err != nil {
+		return nil, fmt.Errorf("persistence: error adding password encrypted key: %w", err)
+	}
+	if err := relationship.addEmailEncryptedKey(key, accountUser.emailSalt(), email); err != nil {
+		return nil, fmt.Errorf("persistence: error adding email encrypted key: %w", err)
+	}
+	// a pending one time key still contains the previous key and would
+	// be invalid after the rotations have been dropped
+	relationship.clearOneTimeKey()
+	relationship.KeyEncryptionKeyRotations = ""
+	if err := p.dal.UpdateAccountUserRelationship(relationship); err != nil {
+		return nil, fmt.Errorf("persistence: error updating relationship: %w", err)
+	}
+	return key, nil
+}
+
+// upgradeCredentials re-creates the password hash, the email hash and the salts
+// of the given account user in case any of these values has been created
+// using outdated kdf parameters. The email lookup hash is re-created in case
+// it has been created using a key other than the current one. Password
+// hashes are also re-created in case a pepper is configured that has not
+// been used yet. Account users that still share a single salt for password
+// and email receive a separate email salt. As changing the salt requires all
+// relationships to be re-encrypted, the key derived from the password is
+// returned for further usage by the caller.
+func (p *persistenceLayer) upgradeCredentials(accountUser *AccountUser, email, password string, pwDerivedKey []byte) ([]byte, error) {
+	params := p.kdfParams.OrDefault()
+	var dirty bool
+
+	if params.Outdated(accountUser.HashedPassword) || (len(p.pepper) != 0 && !keys.Peppered(accountUser.HashedPassword)) {
+		hashedPassword, err := keys.HashPassword(password, params, p.pepper)
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error hashing password: %w", err)
+		}
+		accountUser.HashedPassword = hashedPassword.Marshal()
+		dirty = true
+	}
+
+	if params.Outdated(accountUser.HashedEmail) {
+		hashedEmail, err := keys.HashStringWith(email, params)
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error hashing email: %w", err)
+		}
+		accountUser.HashedEmail = hashedEmail.Marshal()
+		dirty = true
+	}
+
+	if p.emailLookup != nil && !p.emailLookup.Current(email, accountUser.EmailLookupKeyID, accountUser.EmailLookupHash) {
+		accountUser.setEmailLookupHash(p.emailLookup, email)
+		dirty = true
+	}
+
+	if accountUser.EmailSalt == "" || params.Outdated(accountUser.EmailSalt) {
+		emailDerivedKey, err := keys.DeriveKey(email, accountUser.emailSalt())
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error deriving key from email: %w", err)
+		}
+		salt, err := keys.NewSaltWith(keys.DefaultSaltLength, params)
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error creating email salt: %w", err)
+		}
+		nextSalt := salt.Marshal()
+		if accountUser.EncryptedSecondFactor != "" {
+			nextEmailDerivedKey, err := keys.DeriveKey(email, nextSalt)
+			if err != nil {
+				return nil, fmt.Errorf("persistence: error deriving key from email: %w", err)
+			}
+			if err := accountUser.rewrapSecondFactor(emailDerivedKey, nextEmailDerivedKey); err != nil {
+				return nil, fmt.Errorf("persistence: error re-encrypting second factor: %w", err)
+			}
+		}
+		for idx, relationship := range accountUser.Relationships {
+			if relationship.EmailEncryptedKeyEncryptionKey == "" {
+				continue
+			}
+			emailKey, err := keys.DecryptWith(emailDerivedKey, relationship.EmailEncryptedKeyEncryptionKey)
+			if err != nil {
+				return nil, fmt.Errorf("persistence: error decrypting email encrypted key: %w", err)
+			}
+			if err := relationship.addEmailEncryptedKey(emailKey, nextSalt, email); err != nil {
+				return nil, fmt.Errorf("persistence: error re-encrypting email encrypted key: %w", err)
+			}
+			accountUser.Relationships[idx] = relationship
+		}
+		accountUser.EmailSalt = nextSalt
+		dirty = true
+	}
+
+	if params.Outdated(accountUser.Salt) {
+		salt, err := keys.NewSaltWith(keys.DefaultSaltLength, params)
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error creating salt: %w", err)
+		}
+		nextSalt := salt.Marshal()
+		for idx, relationship := range accountUser.Relationships {
+			if relationship.PasswordEncryptedKeyEncryptionKey == "" {
+				continue
+			}
+			passwordKey, err := keys.DecryptWith(pwDerivedKey, relationship.PasswordEncryptedKeyEncryptionKey)
+			if err != nil {
+				return nil, fmt.Errorf("persistence: error decrypting password encrypted key: %w", err)
+			}
+			if err := relationship.addPasswordEncryptedKey(passwordKey, nextSalt, password); err != nil {
+				return nil, fmt.Errorf("persistence: error re-encrypting password encrypted key: %w", err)
+			}
+			accountUser.Relationships[idx] = relationship
+		}
+		accountUser.Salt = nextSalt
+		pwDerivedKey, err = keys.DeriveKey(password, nextSalt)
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error deriving key from password: %w", err)
+		}
+		dirty = true
+	}
+
+	if dirty {
+		if err := p.dal.UpdateAccountUser(accountUser); err != nil {
+			return nil, fmt.Errorf("persistence: error persisting upgraded credentials: %w", err)
+		}
+	}
+	return pwDerivedKey, nil
+}
+
 func (p *persistenceLayer) LookupAccountUser(accountUserID string) (LoginResult, error) {
 	accountUser, err := p.dal.FindAccountUser(
 		FindAccountUserQueryByAccountUserIDIncludeRelationships(accountUserID),
@@ -95,14 +303,23 @@ func (p *persistenceLayer) LookupAccountUser(accountUserID string) (LoginResult,
 	if err != nil {
 		return LoginResult{}, fmt.Errorf("persistence: error looking up account user: %w", err)
 	}
+	if accountUser.Suspended {
+		return LoginResult{}, ErrAccountUserSuspended
+	}
+	allowedNetworks, err := accountUser.allowedNetworks()
+	if err != nil {
+		return LoginResult{}, err
+	}
 	result := LoginResult{
-		AccountUserID: accountUser.AccountUserID,
-		AdminLevel:    accountUser.AdminLevel,
-		Accounts:      []LoginAccountResult{},
+		AccountUserID:   accountUser.AccountUserID,
+		AdminLevel:      accountUser.AdminLevel,
+		Accounts:        []LoginAccountResult{},
+		AllowedNetworks: allowedNetworks,
 	}
 	for _, relationship := range accountUser.Relationships {
 		result.Accounts = append(result.Accounts, LoginAccountResult{
 			AccountID: relationship.AccountID,
+			Role:      relationship.Role,
 		})
 	}
 	return result, nil
@@ -116,19 +333,33 @@ func (p *persistenceLayer) ChangePassword(userID, currentPassword, changedPasswo
 		return fmt.Errorf("persistence: error looking up account user: %w", err)
 	}
 
-	if err := keys.CompareString(currentPassword, accountUser.HashedPassword); err != nil {
+	// passwords managed by a directory need to be changed there, keys are
+	// wrapped using the new password by resetting the password afterwards
+	if p.authenticator != nil {
+		return ErrPasswordManagedExternally
+	}
+
+	if err := keys.ComparePassword(currentPassword, accountUser.HashedPassword, p.pepper); err != nil {
 		return fmt.Errorf("persistence: current password did not match: %w", err)
 	}
 
-	if err := keys.ValidatePassword(changedPassword); err != nil {
+	if err := p.validatePassword(changedPassword); err != nil {
+		return fmt.Errorf("persistence: error validating new password: %w", err)
+	}
+	if err := p.passwordPolicy.checkHistory(&accountUser, changedPassword, p.pepper); err != nil {
+		return fmt.Errorf("persistence: error validating new password: %w", err)
+	}
+	if err := p.checkBreached(changedPassword); err != nil {
 		return fmt.Errorf("persistence: error validating new password: %w", err)
 	}
 
-	newPasswordHash, hashErr := keys.HashString(changedPassword)
+	newPasswordHash, hashErr := keys.HashPassword(changedPassword, p.kdfParams, p.pepper)
 	if hashErr != nil {
 		return fmt.Errorf("persistence: error hashing new password: %w", hashErr)
 	}
-	accountUser.HashedPassword = newPasswordHash.Marshal()
+	if err := accountUser.setPassword(newPasswordHash.Marshal(), p.passwordPolicy.HistoryDepth, time.Now()); err != nil {
+		return fmt.Errorf("persistence: error updating password: %w", err)
+	}
 	keyFromCurrentPassword, keyErr := keys.DeriveKey(currentPassword, accountUser.Salt)
 	if keyErr != nil {
 		return fmt.Errorf("persistence: error deriving key from current password: %w", keyErr)
@@ -150,113 +381,269 @@ func (p *persistenceLayer) ChangePassword(userID, currentPassword, changedPasswo
 	return nil
 }
 
-func (p *persistenceLayer) ResetPassword(emailAddress, password string, oneTimeKey []byte) error {
-	accountUser, err := p.findAccountUser(emailAddress, true, false)
+func (p *persistenceLayer) ResetPassword(emailAddress, password string, oneTimeKey []byte) (string, error) {
+	accountUser, _, err := p.findAccountUserByAnyEmail(emailAddress, true)
 	if err != nil {
-		return fmt.Errorf("persistence: error looking up account user: %w", err)
+		return "", fmt.Errorf("persistence: error looking up account user: %w", err)
 	}
 
-	if err := keys.ValidatePassword(password); err != nil {
-		return fmt.Errorf("persistence: error validating new password: %w", err)
+	if err := p.validateNewPassword(emailAddress, password); err != nil {
+		return "", fmt.Errorf("persistence: error validating new password: %w", err)
+	}
+	if p.authenticator == nil {
+		if err := p.passwordPolicy.checkHistory(accountUser, password, p.pepper); err != nil {
+			return "", fmt.Errorf("persistence: error validating new password: %w", err)
+		}
+	}
+	if err := p.checkBreached(password); err != nil {
+		return "", fmt.Errorf("persistence: error validating new password: %w", err)
 	}
 
+	now := time.Now()
 	for index, relationship := range accountUser.Relationships {
-		keyEncryptionKey, decryptionErr := keys.DecryptWith(oneTimeKey, relationship.OneTimeEncryptedKeyEncryptionKey)
-		if decryptionErr != nil {
-			return fmt.Errorf("persistence: error decrypting key encryption key: %w", decryptionErr)
+		keyEncryptionKey, consumeErr := relationship.consumeOneTimeKey(oneTimeKey, now)
+		if consumeErr != nil {
+			return "", fmt.Errorf("persistence: error using one time key: %w", consumeErr)
 		}
 		if err := relationship.addPasswordEncryptedKey(keyEncryptionKey, accountUser.Salt, password); err != nil {
-			return fmt.Errorf("persistence: error adding password encrypted key to relationship: %w", err)
+			return "", fmt.Errorf("persistence: error adding password encrypted key to relationship: %w", err)
 		}
-		relationship.OneTimeEncryptedKeyEncryptionKey = ""
 		accountUser.Relationships[index] = relationship
 	}
-	passwordHash, hashErr := keys.HashString(password)
+	passwordHash, hashErr := keys.HashPassword(password, p.kdfParams, p.pepper)
 	if hashErr != nil {
-		return fmt.Errorf("persistence: error hashing password: %w", hashErr)
+		return "", fmt.Errorf("persistence: error hashing password: %w", hashErr)
+	}
+	if err := accountUser.setPassword(passwordHash.Marshal(), p.passwordPolicy.HistoryDepth, now); err != nil {
+		return "", fmt.Errorf("persistence: error updating password: %w", err)
 	}
-	accountUser.HashedPassword = passwordHash.Marshal()
 	if err := p.dal.UpdateAccountUser(accountUser); err != nil {
-		return fmt.Errorf("persistence: error updating password on account user: %w", err)
+		return "", fmt.Errorf("persistence: error updating password on account user: %w", err)
 	}
-	return nil
+	return accountUser.AccountUserID, nil
 }
 
-func (p *persistenceLayer) ChangeEmail(userID, newEmailAddress, currentEmailAddress, password string) error {
+// ChangeExpiredPassword changes the password of an account user that cannot
+// log in because its password has expired. As no session exists, the
+// credentials including a possible second factor are verified instead.
+func (p *persistenceLayer) ChangeExpiredPassword(email, currentPassword, changedPassword, code string) error {
+	accountUser, err := p.findAccountUser(email, false, false)
+	if err != nil {
+		p.performDummyLogin(currentPassword)
+		return ErrInvalidCredentials
+	}
+	if err := p.verifyPassword(accountUser, email, currentPassword); err != nil {
+		p.hasher().DeriveKey(currentPassword, accountUser.Salt)
+		return err
+	}
+	if accountUser.SecondFactorEnabled {
+		if code == "" {
+			return ErrSecondFactorRequired
+		}
+		if err := p.checkSecondFactor(accountUser, email, code); err != nil {
+			if !errors.Is(err, ErrInvalidCredentials) {
+				return fmt.Errorf("persistence: error verifying second factor: %w", err)
+			}
+			return ErrInvalidCredentials
+		}
+	}
+	if changedPassword == currentPassword {
+		return errors.New("persistence: new password must differ from expired password")
+	}
+	return p.ChangePassword(accountUser.AccountUserID, currentPassword, changedPassword)
+}
+
+// EmailChangeTTL is the duration after which a requested change of an
+// email address that has not been confirmed expires.
+const EmailChangeTTL = time.Hour * 24
+
+// ChangeEmail prepares changing the email address of the account user and
+// returns a token that needs to be passed to ConfirmEmailChange. Until the
+// change is confirmed, the current email address stays in use.
+func (p *persistenceLayer) ChangeEmail(userID, newEmailAddress, currentEmailAddress, password string) ([]byte, error) {
 	accountUser, err := p.findAccountUser(currentEmailAddress, true, true)
 	if err != nil {
-		return fmt.Errorf("persistence: error looking up account user: %w", err)
+		return nil, fmt.Errorf("persistence: error looking up account user: %w", err)
 	}
 
 	if accountUser.AccountUserID != userID {
-		return errors.New("persistence: current email did not match requester credentials")
+		return nil, errors.New("persistence: current email did not match requester credentials")
 	}
 
-	if err := keys.CompareString(password, accountUser.HashedPassword); err != nil {
-		return fmt.Errorf("persistence: passwords did not match: %w", err)
+	if err := keys.ComparePassword(password, accountUser.HashedPassword, p.pepper); err != nil {
+		return nil, fmt.Errorf("persistence: passwords did not match: %w", err)
 	}
 
 	if err := keys.CompareString(currentEmailAddress, accountUser.HashedEmail); err != nil {
-		return fmt.Errorf("persistence: current email did not match: %w", err)
+		return nil, fmt.Errorf("persistence: current email did not match: %w", err)
 	}
 
 	existing, _ := p.findAccountUser(newEmailAddress, false, false)
 	if existing != nil && existing.AccountUserID != userID {
-		return fmt.Errorf("persistence: given email %s is already in use", newEmailAddress)
+		return nil, fmt.Errorf("persistence: given email %s is already in use", newEmailAddress)
 	}
 
-	keyFromCurrentEmail, keyErr := keys.DeriveKey(currentEmailAddress, accountUser.Salt)
+	keyFromCurrentEmail, keyErr := keys.DeriveKey(currentEmailAddress, accountUser.emailSalt())
 	if keyErr != nil {
-		return fmt.Errorf("persistence: error deriving key from email: %w", keyErr)
+		return nil, fmt.Errorf("persistence: error deriving key from email: %w", keyErr)
 	}
 
-	hashedEmail, hashErr := keys.HashString(newEmailAddress)
+	hashedEmail, hashErr := keys.HashStringWith(newEmailAddress, p.kdfParams)
 	if hashErr != nil {
-		return fmt.Errorf("persistence: error hashing updated email address: %w", hashErr)
+		return nil, fmt.Errorf("persistence: error hashing updated email address: %w", hashErr)
 	}
 
-	accountUser.HashedEmail = hashedEmail.Marshal()
-	for index, relationship := range accountUser.Relationships {
+	token, err := keys.GenerateRandomBytes(keys.DefaultEncryptionKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error creating token: %w", err)
+	}
+	hashedToken, err := keys.HashStringWith(base64.StdEncoding.EncodeToString(token), p.kdfParams)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error hashing token: %w", err)
+	}
+
+	change := pendingEmailChange{
+		HashedToken:        hashedToken.Marshal(),
+		Expires:            time.Now().Add(EmailChangeTTL),
+		HashedEmail:        hashedEmail.Marshal(),
+		EmailEncryptedKeys: map[string]string{},
+	}
+	for _, relationship := range accountUser.Relationships {
+		// pending invitations created using a token do not depend on the
+		// email address
+		if relationship.EmailEncryptedKeyEncryptionKey == "" {
+			continue
+		}
 		decryptedKey, decryptionErr := keys.DecryptWith(keyFromCurrentEmail, relationship.EmailEncryptedKeyEncryptionKey)
 		if decryptionErr != nil {
-			return decryptionErr
+			return nil, decryptionErr
 		}
-		if err := relationship.addEmailEncryptedKey(decryptedKey, accountUser.Salt, newEmailAddress); err != nil {
-			return fmt.Errorf("persistence: error adding email key to relationship: %w", err)
+		if err := relationship.addEmailEncryptedKey(decryptedKey, accountUser.emailSalt(), newEmailAddress); err != nil {
+			return nil, fmt.Errorf("persistence: error adding email key to relationship: %w", err)
 		}
-		accountUser.Relationships[index] = relationship
+		change.EmailEncryptedKeys[relationship.RelationshipID] = relationship.EmailEncryptedKeyEncryptionKey
+	}
+	if accountUser.EncryptedSecondFactor != "" {
+		keyFromNewEmail, err := keys.DeriveKey(newEmailAddress, accountUser.emailSalt())
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error deriving key from email: %w", err)
+		}
+		change.PreviousSecondFactor = accountUser.EncryptedSecondFactor
+		rewrapped := *accountUser
+		if err := rewrapped.rewrapSecondFactor(keyFromCurrentEmail, keyFromNewEmail); err != nil {
+			return nil, fmt.Errorf("persistence: error re-encrypting second factor: %w", err)
+		}
+		change.EncryptedSecondFactor = rewrapped.EncryptedSecondFactor
+	}
+	if err := accountUser.savePendingEmailChange(&change); err != nil {
+		return nil, err
 	}
 	if err := p.dal.UpdateAccountUser(accountUser); err != nil {
+		return nil, fmt.Errorf("persistence: error saving pending email change: %w", err)
+	}
+	return token, nil
+}
+
+// ConfirmEmailChange commits the pending change of the email address of the
+// given account user in case the token matches.
+func (p *persistenceLayer) ConfirmEmailChange(userID, newEmailAddress string, token []byte) error {
+	accountUser, err := p.dal.FindAccountUser(
+		FindAccountUserQueryByAccountUserIDIncludeRelationships(userID),
+	)
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	change, err := accountUser.pendingEmailChange()
+	if err != nil {
+		return err
+	}
+	if change == nil || time.Now().After(change.Expires) {
+		return ErrInvalidOneTimeKey("persistence: no pending email change found")
+	}
+	if err := keys.CompareString(base64.StdEncoding.EncodeToString(token), change.HashedToken); err != nil {
+		return ErrInvalidOneTimeKey("persistence: token did not match")
+	}
+	if err := keys.CompareString(newEmailAddress, change.HashedEmail); err != nil {
+		return ErrInvalidOneTimeKey("persistence: email address did not match")
+	}
+
+	existing, _ := p.findAccountUser(newEmailAddress, false, false)
+	if existing != nil && existing.AccountUserID != userID {
+		return fmt.Errorf("persistence: given email %s is already in use", newEmailAddress)
+	}
+
+	// envelopes have been created when the change was requested, so the
+	// change cannot be applied in case the keys have changed in the meantime
+	for index, relationship := range accountUser.Relationships {
+		if relationship.EmailEncryptedKeyEncryptionKey == "" {
+			continue
+		}
+		envelope, ok := change.EmailEncryptedKeys[relationship.RelationshipID]
+		if !ok {
+			return errors.New("persistence: accounts have changed since requesting the change, request a new one")
+		}
+		relationship.EmailEncryptedKeyEncryptionKey = envelope
+		accountUser.Relationships[index] = relationship
+	}
+	if accountUser.EncryptedSecondFactor != change.PreviousSecondFactor {
+		return errors.New("persistence: second factor has changed since requesting the change, request a new one")
+	}
+	accountUser.EncryptedSecondFactor = change.EncryptedSecondFactor
+
+	accountUser.HashedEmail = change.HashedEmail
+	accountUser.EmailLookupHash, accountUser.EmailLookupKeyID = "", ""
+	accountUser.setEmailLookupHash(p.emailLookup, newEmailAddress)
+	if err := accountUser.savePendingEmailChange(nil); err != nil {
+		return err
+	}
+	if err := p.dal.UpdateAccountUser(&accountUser); err != nil {
 		return fmt.Errorf("persistence: error updating hashed email on account user: %w", err)
 	}
 	return nil
 }
 
+// OneTimeKeyTTL is the duration after which a one time key that has been
+// created for resetting a password expires.
+const OneTimeKeyTTL = time.Hour * 24
+
+// GenerateOneTimeKey creates a key that can be used for resetting the
+// password of the account user using the given primary or verified
+// secondary email address.
 func (p *persistenceLayer) GenerateOneTimeKey(emailAddress string) ([]byte, error) {
-	accountUser, err := p.findAccountUser(emailAddress, true, false)
+	accountUser, secondary, err := p.findAccountUserByAnyEmail(emailAddress, true)
 	if err != nil {
 		return nil, fmt.Errorf("persistence: error looking up account user: %w", err)
 	}
+	if secondary != nil && secondary.outdated(accountUser.Relationships) {
+		return nil, errors.New("persistence: secondary email does not cover all accounts, add it again")
+	}
 
-	emailDerivedKey, deriveErr := keys.DeriveKey(emailAddress, accountUser.Salt)
+	emailDerivedKey, deriveErr := keys.DeriveKey(emailAddress, accountUser.emailSalt())
 	if deriveErr != nil {
 		return nil, fmt.Errorf("error deriving key from email address: %w", deriveErr)
 	}
 
-	oneTimeKey, _ := keys.GenerateRandomValue(keys.DefaultEncryptionKeySize)
-	oneTimeKeyBytes, _ := base64.StdEncoding.DecodeString(oneTimeKey)
+	oneTimeKeyBytes, err := keys.GenerateRandomBytes(keys.DefaultEncryptionKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error creating one time key: %w", err)
+	}
 
+	expires := time.Now().Add(OneTimeKeyTTL)
 	txn, err := p.dal.Transaction()
 	if err != nil {
 		return nil, fmt.Errorf("persistence: error creating transaction: %w", err)
 	}
 	for _, relationship := range accountUser.Relationships {
-		decryptedKey, decryptErr := keys.DecryptWith(emailDerivedKey, relationship.EmailEncryptedKeyEncryptionKey)
+		envelope := relationship.EmailEncryptedKeyEncryptionKey
+		if secondary != nil {
+			envelope = secondary.EmailEncryptedKeys[relationship.RelationshipID]
+		}
+		decryptedKey, decryptErr := keys.DecryptWith(emailDerivedKey, envelope)
 		if decryptErr != nil {
 			txn.Rollback()
 			return nil, fmt.Errorf("persistence: error decrypting email encrypted key: %w", decryptErr)
 		}
-		if err := relationship.addOneTimeEncryptedKey(decryptedKey, oneTimeKeyBytes); err != nil {
+		if err := relationship.addOneTimeEncryptedKey(decryptedKey, oneTimeKeyBytes, expires); err != nil {
 			txn.Rollback()
 			return nil, fmt.Errorf("persistence: erro adding one time key to relationship: %w", err)
 		}
@@ -279,25 +666,120 @@ func (p *persistenceLayer) findAccountUser(emailAddress string, includeRelations
 	if err != nil {
 		return nil, fmt.Errorf("persistence: error looking up account users: %w", err)
 	}
-	match, err := selectAccountUser(accountUsers, emailAddress)
+	match, err := p.selectAccountUser(accountUsers, emailAddress)
 	if err != nil {
 		return nil, fmt.Errorf("persistence: could not find user with email %s: %w", emailAddress, err)
 	}
 	return match, nil
 }
 
-func selectAccountUser(available []AccountUser, email string) (*AccountUser, error) {
+func (p *persistenceLayer) selectAccountUser(available []AccountUser, email string) (*AccountUser, error) {
+	// keyed lookup hashes are cheap to compare, so all of them are compared,
+	// even after a match has been found
+	var match *AccountUser
+	var unknown []AccountUser
+	for _, user := range available {
+		matches, known := p.matchesLookupHash(user.EmailLookupKeyID, user.EmailLookupHash, email)
+		if !known {
+			unknown = append(unknown, user)
+			continue
+		}
+		if matches && match == nil {
+			// a copy is returned as callers might shuffle the slice again
+			// when looking up another user
+			u := user
+			match = &u
+		}
+	}
+	if match != nil {
+		return match, nil
+	}
+
 	// this is so that users that have signed up at a later point in time
 	// also get decent login times
 	rand.Seed(time.Now().UnixNano())
-	rand.Shuffle(len(available), func(i, j int) {
-		available[i], available[j] = available[j], available[i]
+	rand.Shuffle(len(unknown), func(i, j int) {
+		unknown[i], unknown[j] = unknown[j], unknown[i]
 	})
-
-	for _, user := range available {
-		if err := keys.CompareString(email, user.HashedEmail); err == nil {
-			return &user, nil
+	for _, user := range unknown {
+		if p.hasher().CompareString(email, user.HashedEmail) == nil {
+			u := user
+			return &u, nil
 		}
 	}
 	return nil, fmt.Errorf("persistence: no account user found for %s", email)
 }
+
+// matchesLookupHash checks the given email address against a keyed lookup
+// hash. known is false in case the result cannot be relied on, so the
+// argon2 hash needs to be checked instead. This is the case for values
+// without a lookup hash, and for hashes that have been created using a key
+// other than the current one, as the secret used for creating them might not
+// be configured anymore. A mismatch of a hash created using the current key
+// is final, so looking up an account user does not cause an argon2
+// comparison for each other account user.
+func (p *persistenceLayer) matchesLookupHash(keyID, hash, email string) (matches bool, known bool) {
+	if p.emailLookup == nil || keyID == "" {
+		return false, false
+	}
+	if p.emailLookup.Matches(email, keyID, hash) {
+		return true, true
+	}
+	return false, keyID == p.emailLookup.KeyID()
+}
+
+// matchesEmail checks the given email address against the lookup hash of the
+// account user, falling back to its argon2 hash in case the lookup hash
+// cannot be relied on.
+func (p *persistenceLayer) matchesEmail(accountUser *AccountUser, email string) bool {
+	if matches, known := p.matchesLookupHash(accountUser.EmailLookupKeyID, accountUser.EmailLookupHash, email); known {
+		return matches
+	}
+	return p.hasher().CompareString(email, accountUser.HashedEmail) == nil
+}
+
+// performDummyLogin hashes the given password and derives a key from it
+// using throwaway values, mimicking the work performed for a known user.
+func (p *persistenceLayer) performDummyLogin(password string) {
+	dummy := p.dummy
+	if dummy == nil {
+		dummy = &dummyLogin{}
+	}
+	dummy.once.Do(func() {
+		hash, _ := keys.HashPassword("offen", p.kdfParams, p.pepper)
+		salt, _ := keys.NewSaltWith(keys.DefaultSaltLength, p.kdfParams)
+		if hash != nil && salt != nil {
+			dummy.hash, dummy.salt = hash.Marshal(), salt.Marshal()
+		}
+	})
+	p.hasher().ComparePassword(password, dummy.hash, p.pepper)
+	p.hasher().DeriveKey(password, dummy.salt)
+}
+
+// credentialHasher performs the argon2 operations of verifying credentials.
+type credentialHasher interface {
+	ComparePassword(password, hash string, pepper []byte) error
+	CompareString(value, hash string) error
+	DeriveKey(value, salt string) ([]byte, error)
+}
+
+type argon2Hasher struct{}
+
+func (argon2Hasher) CompareString(value, hash string) error {
+	return keys.CompareString(value, hash)
+}
+
+func (argon2Hasher) ComparePassword(password, hash string, pepper []byte) error {
+	return keys.ComparePassword(password, hash, pepper)
+}
+
+func (argon2Hasher) DeriveKey(value, salt string) ([]byte, error) {
+	return keys.DeriveKey(value, salt)
+}
+
+func (p *persistenceLayer) hasher() credentialHasher {
+	if p.credentials == nil {
+		return argon2Hasher{}
+	}
+	return p.credentials
+}
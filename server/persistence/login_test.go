@@ -0,0 +1,551 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/keys"
+)
+
+type mockLoginDatabase struct {
+	DataAccessLayer
+	accountUsers []AccountUser
+	updated      *AccountUser
+}
+
+func (m *mockLoginDatabase) FindAccountUsers(interface{}) ([]AccountUser, error) {
+	return m.accountUsers, nil
+}
+
+func (m *mockLoginDatabase) FindAccount(q interface{}) (Account, error) {
+	return Account{AccountID: string(q.(FindAccountQueryByID)), Name: "name"}, nil
+}
+
+func (m *mockLoginDatabase) UpdateAccountUser(a *AccountUser) error {
+	m.updated = a
+	return nil
+}
+
+func TestPersistenceLayer_Login_UpgradeCredentials(t *testing.T) {
+	legacyParams := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	currentParams := keys.KDFParams{Time: 1, Memory: 128, Threads: 1}
+
+	createUser := func() AccountUser {
+		accountUser, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, legacyParams, nil)
+		relationship, _ := newAccountUserRelationship(accountUser.AccountUserID, "account-a")
+		relationship.addPasswordEncryptedKey([]byte("{\"kty\":\"oct\"}"), accountUser.Salt, "develop")
+		relationship.addEmailEncryptedKey([]byte("{\"kty\":\"oct\"}"), accountUser.EmailSalt, "develop@offen.dev")
+		accountUser.Relationships = []AccountUserRelationship{*relationship}
+		return *accountUser
+	}
+
+	t.Run("outdated", func(t *testing.T) {
+		db := &mockLoginDatabase{accountUsers: []AccountUser{createUser()}}
+		p := &persistenceLayer{dal: db, kdfParams: currentParams}
+		result, err := p.Login("develop@offen.dev", "develop")
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if len(result.Accounts) != 1 {
+			t.Errorf("Unexpected result %v", result)
+		}
+		if db.updated == nil {
+			t.Fatal("Expected account user to be updated")
+		}
+		for _, value := range []string{db.updated.Salt, db.updated.EmailSalt, db.updated.HashedEmail, db.updated.HashedPassword} {
+			if currentParams.Outdated(value) {
+				t.Errorf("Expected value %s to be upgraded", value)
+			}
+		}
+		pwKey, _ := keys.DeriveKey("develop", db.updated.Salt)
+		if _, err := keys.DecryptWith(pwKey, db.updated.Relationships[0].PasswordEncryptedKeyEncryptionKey); err != nil {
+			t.Errorf("Unexpected error decrypting upgraded relationship: %v", err)
+		}
+		emailKey, _ := keys.DeriveKey("develop@offen.dev", db.updated.EmailSalt)
+		if _, err := keys.DecryptWith(emailKey, db.updated.Relationships[0].EmailEncryptedKeyEncryptionKey); err != nil {
+			t.Errorf("Unexpected error decrypting upgraded relationship: %v", err)
+		}
+
+		db.accountUsers = []AccountUser{*db.updated}
+		db.updated = nil
+		if _, err := p.Login("develop@offen.dev", "develop"); err != nil {
+			t.Fatalf("Unexpected error logging in with upgraded credentials: %v", err)
+		}
+		if db.updated != nil {
+			t.Error("Unexpected second upgrade of credentials")
+		}
+	})
+	t.Run("current", func(t *testing.T) {
+		db := &mockLoginDatabase{accountUsers: []AccountUser{createUser()}}
+		p := &persistenceLayer{dal: db, kdfParams: legacyParams}
+		if _, err := p.Login("develop@offen.dev", "develop"); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if db.updated != nil {
+			t.Error("Unexpected upgrade of current credentials")
+		}
+	})
+	t.Run("shared salt", func(t *testing.T) {
+		user := createUser()
+		user.EmailSalt = ""
+		user.Relationships[0].addEmailEncryptedKey([]byte("{\"kty\":\"oct\"}"), user.Salt, "develop@offen.dev")
+		db := &mockLoginDatabase{accountUsers: []AccountUser{user}}
+		p := &persistenceLayer{dal: db, kdfParams: legacyParams}
+		if _, err := p.Login("develop@offen.dev", "develop"); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if db.updated == nil {
+			t.Fatal("Expected account user to be updated")
+		}
+		if db.updated.EmailSalt == "" || db.updated.EmailSalt == db.updated.Salt {
+			t.Errorf("Expected separate email salt to be issued, got %v", db.updated)
+		}
+		if db.updated.Salt != user.Salt {
+			t.Error("Unexpected change of password salt")
+		}
+		emailKey, _ := keys.DeriveKey("develop@offen.dev", db.updated.EmailSalt)
+		if _, err := keys.DecryptWith(emailKey, db.updated.Relationships[0].EmailEncryptedKeyEncryptionKey); err != nil {
+			t.Errorf("Unexpected error decrypting migrated relationship: %v", err)
+		}
+		pwKey, _ := keys.DeriveKey("develop", db.updated.Salt)
+		if _, err := keys.DecryptWith(pwKey, db.updated.Relationships[0].PasswordEncryptedKeyEncryptionKey); err != nil {
+			t.Errorf("Unexpected error decrypting migrated relationship: %v", err)
+		}
+	})
+	t.Run("pepper added", func(t *testing.T) {
+		db := &mockLoginDatabase{accountUsers: []AccountUser{createUser()}}
+		p := &persistenceLayer{dal: db, kdfParams: legacyParams, pepper: []byte("pepper")}
+		if _, err := p.Login("develop@offen.dev", "develop"); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if db.updated == nil {
+			t.Fatal("Expected account user to be updated")
+		}
+		if !keys.Peppered(db.updated.HashedPassword) {
+			t.Error("Expected password hash to be peppered")
+		}
+		db.accountUsers = []AccountUser{*db.updated}
+		if _, err := p.Login("develop@offen.dev", "develop"); err != nil {
+			t.Fatalf("Unexpected error logging in with peppered hash: %v", err)
+		}
+		p.pepper = nil
+		if _, err := p.Login("develop@offen.dev", "develop"); err == nil {
+			t.Error("Expected error logging in without pepper")
+		}
+	})
+	t.Run("email lookup key rotated", func(t *testing.T) {
+		user := createUser()
+		user.setEmailLookupHash(keys.NewEmailLookup([]byte("secret"), "1"), "develop@offen.dev")
+		db := &mockLoginDatabase{accountUsers: []AccountUser{user}}
+		current := keys.NewEmailLookup([]byte("secret"), "2")
+		p := &persistenceLayer{dal: db, kdfParams: legacyParams, emailLookup: current}
+		if _, err := p.Login("develop@offen.dev", "develop"); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if db.updated == nil {
+			t.Fatal("Expected account user to be updated")
+		}
+		if db.updated.EmailLookupKeyID != "2" || !current.Matches("develop@offen.dev", "2", db.updated.EmailLookupHash) {
+			t.Errorf("Expected lookup hash to be migrated, got %v", db.updated)
+		}
+	})
+	t.Run("secret rotated", func(t *testing.T) {
+		user := createUser()
+		user.setEmailLookupHash(keys.NewEmailLookup([]byte("old-secret"), "1"), "develop@offen.dev")
+		db := &mockLoginDatabase{accountUsers: []AccountUser{user}}
+		rotated := keys.NewEmailLookup([]byte("new-secret"), "1", []byte("old-secret"))
+		p := &persistenceLayer{dal: db, kdfParams: legacyParams, emailLookup: rotated}
+		if _, err := p.Login("develop@offen.dev", "develop"); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if db.updated == nil || !rotated.Current("develop@offen.dev", "1", db.updated.EmailLookupHash) {
+			t.Fatalf("Expected lookup hash to be re-hashed using the new secret, got %v", db.updated)
+		}
+
+		// once the previous secret has been dropped, the re-hashed user
+		// still matches
+		db.accountUsers = []AccountUser{*db.updated}
+		db.updated = nil
+		p.emailLookup = keys.NewEmailLookup([]byte("new-secret"), "1")
+		if _, err := p.Login("develop@offen.dev", "develop"); err != nil {
+			t.Fatalf("Unexpected error logging in after dropping previous secret: %v", err)
+		}
+	})
+	t.Run("secret unknown", func(t *testing.T) {
+		user := createUser()
+		user.setEmailLookupHash(keys.NewEmailLookup([]byte("lost-secret"), "1"), "develop@offen.dev")
+		db := &mockLoginDatabase{accountUsers: []AccountUser{user}}
+		p := &persistenceLayer{dal: db, kdfParams: legacyParams, emailLookup: keys.NewEmailLookup([]byte("new-secret"), "1")}
+		if _, err := p.Login("develop@offen.dev", "develop"); err == nil {
+			t.Fatal("Expected mismatch of hash created using the current key to be final")
+		}
+
+		// changing the key id makes all lookup hashes fall back to argon2
+		current := keys.NewEmailLookup([]byte("new-secret"), "2")
+		p.emailLookup = current
+		if _, err := p.Login("develop@offen.dev", "develop"); err != nil {
+			t.Fatalf("Expected fallback to argon2 hash, got %v", err)
+		}
+		if db.updated == nil || !current.Current("develop@offen.dev", "2", db.updated.EmailLookupHash) {
+			t.Errorf("Expected lookup hash to be re-hashed, got %v", db.updated)
+		}
+	})
+}
+
+func TestPersistenceLayer_Login_InvalidCredentials(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	accountUser, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, params, nil)
+	db := &mockLoginDatabase{accountUsers: []AccountUser{*accountUser}}
+	p := &persistenceLayer{dal: db, kdfParams: params}
+
+	for name, credentials := range map[string][]string{
+		"unknown user":   {"other@offen.dev", "develop"},
+		"wrong password": {"develop@offen.dev", "other"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := p.Login(credentials[0], credentials[1])
+			if err != ErrInvalidCredentials {
+				t.Errorf("Expected invalid credentials error, got %v", err)
+			}
+		})
+	}
+}
+
+type countingHasher struct {
+	argon2Hasher
+	calls []string
+}
+
+func (c *countingHasher) ComparePassword(password, hash string, pepper []byte) error {
+	c.calls = append(c.calls, "compare")
+	return c.argon2Hasher.ComparePassword(password, hash, pepper)
+}
+
+func (c *countingHasher) CompareString(value, hash string) error {
+	c.calls = append(c.calls, "email")
+	return c.argon2Hasher.CompareString(value, hash)
+}
+
+func (c *countingHasher) DeriveKey(value, salt string) ([]byte, error) {
+	c.calls = append(c.calls, "derive")
+	return c.argon2Hasher.DeriveKey(value, salt)
+}
+
+func TestPersistenceLayer_Login_TimingParity(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	accountUser, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, params, nil)
+	db := &mockLoginDatabase{accountUsers: []AccountUser{*accountUser}}
+
+	calls := map[string][]string{}
+	for name, credentials := range map[string][]string{
+		"unknown user":   {"other@offen.dev", "develop"},
+		"wrong password": {"develop@offen.dev", "other"},
+	} {
+		hasher := &countingHasher{}
+		p := &persistenceLayer{dal: db, kdfParams: params, credentials: hasher}
+		if _, err := p.Login(credentials[0], credentials[1]); err != ErrInvalidCredentials {
+			t.Fatalf("Expected invalid credentials error, got %v", err)
+		}
+		calls[name] = hasher.calls
+	}
+	if !reflect.DeepEqual(calls["unknown user"], calls["wrong password"]) {
+		t.Errorf("Expected both paths to perform the same work, got %v", calls)
+	}
+	if !reflect.DeepEqual(calls["unknown user"], []string{"email", "compare", "derive"}) {
+		t.Errorf("Unexpected work performed %v", calls["unknown user"])
+	}
+}
+
+func TestPersistenceLayer_Login_PasswordExpired(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	accountUser, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, params, nil)
+	changed := time.Now().Add(-100 * 24 * time.Hour)
+	accountUser.PasswordChanged = &changed
+	db := &mockLoginDatabase{accountUsers: []AccountUser{*accountUser}}
+	p := &persistenceLayer{dal: db, kdfParams: params, passwordMaxAge: 90 * 24 * time.Hour}
+
+	if _, err := p.Login("develop@offen.dev", "develop"); err != ErrPasswordExpired {
+		t.Errorf("Expected password expired error, got %v", err)
+	}
+	if _, err := p.Login("develop@offen.dev", "other"); err != ErrInvalidCredentials {
+		t.Errorf("Expected invalid credentials error, got %v", err)
+	}
+}
+
+func TestPersistenceLayer_Login_Suspended(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	accountUser, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, params, nil)
+	accountUser.Suspended = true
+	db := &mockLoginDatabase{accountUsers: []AccountUser{*accountUser}}
+	p := &persistenceLayer{dal: db, kdfParams: params}
+
+	if _, err := p.Login("develop@offen.dev", "develop"); err != ErrAccountUserSuspended {
+		t.Errorf("Expected suspended error, got %v", err)
+	}
+	if _, err := p.Login("develop@offen.dev", "other"); err != ErrInvalidCredentials {
+		t.Errorf("Expected invalid credentials error, got %v", err)
+	}
+}
+
+func TestPersistenceLayer_passwordExpired(t *testing.T) {
+	now := time.Now()
+	recent := now.Add(-24 * time.Hour)
+	old := now.Add(-100 * 24 * time.Hour)
+	tests := []struct {
+		name           string
+		maxAge         time.Duration
+		authenticator  PasswordAuthenticator
+		changed        *time.Time
+		expectedResult bool
+	}{
+		{"disabled", 0, nil, &old, false},
+		{"recent", 90 * 24 * time.Hour, nil, &recent, false},
+		{"expired", 90 * 24 * time.Hour, nil, &old, true},
+		{"unknown", 90 * 24 * time.Hour, nil, nil, false},
+		{"directory", 90 * 24 * time.Hour, &mockPasswordAuthenticator{}, &old, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &persistenceLayer{passwordMaxAge: test.maxAge, authenticator: test.authenticator}
+			result := p.passwordExpired(&AccountUser{PasswordChanged: test.changed}, now)
+			if result != test.expectedResult {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_ChangeEmail(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	accountUser, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, params, nil)
+	relationship, _ := newAccountUserRelationship(accountUser.AccountUserID, "account-a")
+	relationship.addPasswordEncryptedKey([]byte("{\"kty\":\"oct\"}"), accountUser.Salt, "develop")
+	relationship.addEmailEncryptedKey([]byte("{\"kty\":\"oct\"}"), accountUser.EmailSalt, "develop@offen.dev")
+	accountUser.Relationships = []AccountUserRelationship{*relationship}
+
+	db := &mockSecondFactorDatabase{mockLoginDatabase: mockLoginDatabase{accountUsers: []AccountUser{*accountUser}}}
+	p := &persistenceLayer{dal: db, kdfParams: params}
+
+	if _, err := p.ChangeEmail(accountUser.AccountUserID, "other@offen.dev", "develop@offen.dev", "other"); err == nil {
+		t.Error("Expected error when using bad password")
+	}
+	token, err := p.ChangeEmail(accountUser.AccountUserID, "other@offen.dev", "develop@offen.dev", "develop")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// the current address stays in use until the change is confirmed
+	if _, err := p.Login("develop@offen.dev", "develop"); err != nil {
+		t.Errorf("Unexpected error logging in with current address %v", err)
+	}
+	if _, err := p.Login("other@offen.dev", "develop"); err != ErrInvalidCredentials {
+		t.Errorf("Expected invalid credentials using new address, got %v", err)
+	}
+
+	var oneTimeKeyErr ErrInvalidOneTimeKey
+	if err := p.ConfirmEmailChange(accountUser.AccountUserID, "other@offen.dev", []byte("token")); !errors.As(err, &oneTimeKeyErr) {
+		t.Errorf("Expected invalid one time key error for bad token, got %v", err)
+	}
+	if err := p.ConfirmEmailChange(accountUser.AccountUserID, "third@offen.dev", token); !errors.As(err, &oneTimeKeyErr) {
+		t.Errorf("Expected invalid one time key error for bad address, got %v", err)
+	}
+	if err := p.ConfirmEmailChange(accountUser.AccountUserID, "other@offen.dev", token); err != nil {
+		t.Fatalf("Unexpected error confirming change %v", err)
+	}
+
+	if _, err := p.Login("other@offen.dev", "develop"); err != nil {
+		t.Errorf("Unexpected error logging in with new address %v", err)
+	}
+	if _, err := p.Login("develop@offen.dev", "develop"); err != ErrInvalidCredentials {
+		t.Errorf("Expected invalid credentials using previous address, got %v", err)
+	}
+	if err := p.ConfirmEmailChange(accountUser.AccountUserID, "other@offen.dev", token); !errors.As(err, &oneTimeKeyErr) {
+		t.Errorf("Expected token to be consumed, got %v", err)
+	}
+}
+
+func TestSelectAccountUser(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	previous := keys.NewEmailLookup([]byte("secret"), "1")
+	current := keys.NewEmailLookup([]byte("secret"), "2")
+
+	var available []AccountUser
+	for idx, email := range []string{"a@offen.dev", "b@offen.dev", "c@offen.dev", "d@offen.dev"} {
+		a, _ := newAccountUser(email, "develop", AccountUserAdminLevelSuperAdmin, params, nil)
+		// users are using a mix of no lookup hash, the previous and the
+		// current lookup key
+		switch idx % 3 {
+		case 1:
+			a.setEmailLookupHash(previous, email)
+		case 2:
+			a.setEmailLookupHash(current, email)
+		}
+		available = append(available, *a)
+	}
+
+	for _, lookup := range []*keys.EmailLookup{nil, current} {
+		p := &persistenceLayer{emailLookup: lookup}
+		for _, email := range []string{"a@offen.dev", "b@offen.dev", "c@offen.dev", "d@offen.dev"} {
+			match, err := p.selectAccountUser(available, email)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if keys.CompareString(email, match.HashedEmail) != nil {
+				t.Errorf("Unexpected match %v", match)
+			}
+		}
+		if _, err := p.selectAccountUser(available, "z@offen.dev"); err == nil {
+			t.Error("Expected error for unknown user")
+		}
+	}
+}
+
+func TestSelectAccountUser_LookupHashes(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	current := keys.NewEmailLookup([]byte("secret"), "2")
+
+	var available []AccountUser
+	for _, email := range []string{"a@offen.dev", "b@offen.dev", "c@offen.dev", "d@offen.dev"} {
+		a, _ := newAccountUser(email, "develop", AccountUserAdminLevelSuperAdmin, params, nil)
+		a.setEmailLookupHash(current, email)
+		available = append(available, *a)
+	}
+	legacy, _ := newAccountUser("e@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, params, nil)
+	available = append(available, *legacy)
+
+	for _, test := range []struct {
+		email         string
+		expectMatch   bool
+		expectedCalls int
+	}{
+		{"b@offen.dev", true, 0},
+		{"e@offen.dev", true, 1},
+		{"z@offen.dev", false, 1},
+	} {
+		t.Run(test.email, func(t *testing.T) {
+			hasher := &countingHasher{}
+			p := &persistenceLayer{emailLookup: current, credentials: hasher}
+			match, err := p.selectAccountUser(available, test.email)
+			if (err == nil) != test.expectMatch {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if err == nil && keys.CompareString(test.email, match.HashedEmail) != nil {
+				t.Errorf("Unexpected match %v", match)
+			}
+			// only the account user without a lookup hash is compared
+			// using argon2, and only in case no lookup hash matches
+			if len(hasher.calls) != test.expectedCalls {
+				t.Errorf("Expected %d argon2 comparisons, got %v", test.expectedCalls, hasher.calls)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_validatePassword(t *testing.T) {
+	p := &persistenceLayer{passwordMinScore: 2}
+	var strengthErr *keys.PasswordStrengthError
+	if err := p.validatePassword("foo@bar.com123", "foo@bar.com"); !errors.As(err, &strengthErr) {
+		t.Errorf("Expected strength error, got %v", err)
+	}
+	if err := p.validatePassword("correct horse battery staple", "foo@bar.com"); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	p.passwordMinScore = 0
+	if err := p.validatePassword("foo@bar.com123", "foo@bar.com"); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+type mockBreachChecker struct {
+	pwned bool
+	err   error
+}
+
+func (m *mockBreachChecker) Pwned(string) (bool, error) {
+	return m.pwned, m.err
+}
+
+func TestPersistenceLayer_checkBreached(t *testing.T) {
+	tests := []struct {
+		name        string
+		checker     BreachChecker
+		expectedErr error
+	}{
+		{"no checker", nil, nil},
+		{"not pwned", &mockBreachChecker{}, nil},
+		{"pwned", &mockBreachChecker{pwned: true}, ErrPasswordBreached},
+		{"unreachable", &mockBreachChecker{pwned: true, err: errors.New("did not work")}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &persistenceLayer{breachChecker: test.checker}
+			if err := p.checkBreached("secret"); err != test.expectedErr {
+				t.Errorf("Expected %v, got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+type mockPasswordAuthenticator struct {
+	passwords map[string]string
+	err       error
+}
+
+func (m *mockPasswordAuthenticator) Authenticate(email, password string) (bool, error) {
+	if m.err != nil {
+		return false, m.err
+	}
+	return m.passwords[email] == password, nil
+}
+
+func TestPersistenceLayer_verifyPassword(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	accountUser, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, params, nil)
+	tests := []struct {
+		name          string
+		authenticator PasswordAuthenticator
+		password      string
+		expectedErr   error
+		expectError   bool
+	}{
+		{"local ok", nil, "develop", nil, false},
+		{"local bad password", nil, "other", ErrInvalidCredentials, true},
+		{"directory ok", &mockPasswordAuthenticator{passwords: map[string]string{"develop@offen.dev": "develop"}}, "develop", nil, false},
+		{"directory rejected", &mockPasswordAuthenticator{passwords: map[string]string{"develop@offen.dev": "other"}}, "develop", ErrInvalidCredentials, true},
+		{"directory changed", &mockPasswordAuthenticator{passwords: map[string]string{"develop@offen.dev": "other"}}, "other", ErrPasswordOutOfSync, true},
+		{"directory error", &mockPasswordAuthenticator{err: errors.New("did not work")}, "develop", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &persistenceLayer{authenticator: test.authenticator}
+			err := p.verifyPassword(accountUser, "develop@offen.dev", test.password)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if test.expectedErr != nil && err != test.expectedErr {
+				t.Errorf("Expected %v, got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_validateNewPassword(t *testing.T) {
+	p := &persistenceLayer{
+		passwordMinScore: 4,
+		authenticator:    &mockPasswordAuthenticator{passwords: map[string]string{"develop@offen.dev": "develop"}},
+	}
+	if err := p.validateNewPassword("develop@offen.dev", "develop"); err != nil {
+		t.Errorf("Expected directory password to be accepted, got %v", err)
+	}
+	if err := p.validateNewPassword("develop@offen.dev", "correct horse battery staple"); err != ErrInvalidCredentials {
+		t.Errorf("Expected invalid credentials, got %v", err)
+	}
+	if err := (&persistenceLayer{authenticator: p.authenticator, breachChecker: &mockBreachChecker{pwned: true}}).checkBreached("develop"); err != nil {
+		t.Errorf("Expected breach check to be skipped, got %v", err)
+	}
+}
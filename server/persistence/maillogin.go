@@ -0,0 +1,110 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/offen/offen/server/keys"
+)
+
+// errStaleKeyEncryptionKey is returned when a key encryption key cannot be
+// used for decrypting the private key of its account anymore.
+var errStaleKeyEncryptionKey = errors.New("persistence: key encryption key is outdated")
+
+// ProbeAccountUser checks whether an account user with the given email
+// address exists.
+func (p *persistenceLayer) ProbeAccountUser(email string) bool {
+	accountUser, err := p.findAccountUser(email, false, false)
+	return err == nil && accountUser != nil
+}
+
+// LoginWithEmail releases the key encryption keys of the account user with
+// the given email address using the email encrypted envelopes. Callers are
+// expected to have verified the account user controls the email address,
+// e.g. by sending a signed link. Account users that have enabled a second
+// factor need to pass a valid code.
+func (p *persistenceLayer) LoginWithEmail(email, code string) (LoginResult, error) {
+	accountUser, err := p.findAccountUser(email, true, false)
+	if err != nil {
+		return LoginResult{}, ErrInvalidCredentials
+	}
+	if accountUser.Suspended {
+		return LoginResult{}, ErrAccountUserSuspended
+	}
+
+	if accountUser.SecondFactorEnabled {
+		if code == "" {
+			return LoginResult{}, ErrSecondFactorRequired
+		}
+		if err := p.checkSecondFactor(accountUser, email, code); err != nil {
+			if !errors.Is(err, ErrInvalidCredentials) {
+				return LoginResult{}, fmt.Errorf("persistence: error verifying second factor: %w", err)
+			}
+			return LoginResult{}, ErrInvalidSecondFactor
+		}
+	}
+
+	emailDerivedKey, err := keys.DeriveKey(email, accountUser.emailSalt())
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("persistence: error deriving key from email: %w", err)
+	}
+
+	var results []LoginAccountResult
+	for _, relationship := range accountUser.Relationships {
+		decryptedKey, err := keys.DecryptWith(emailDerivedKey, relationship.EmailEncryptedKeyEncryptionKey)
+		if err != nil {
+			return LoginResult{}, fmt.Errorf(`persistence: failed decrypting key encryption key for account "%s": %w`, relationship.AccountID, err)
+		}
+		// pending rotations are applied, but only completed the next time
+		// the account user logs in using the password
+		decryptedKey, err = relationship.resolveKeyEncryptionKey(decryptedKey, p.rotationKeys...)
+		if err != nil {
+			return LoginResult{}, fmt.Errorf(`persistence: error resolving key for account "%s": %w`, relationship.AccountID, err)
+		}
+		result, err := p.loginAccountResult(relationship, decryptedKey)
+		if err != nil {
+			return LoginResult{}, err
+		}
+		results = append(results, result)
+	}
+
+	allowedNetworks, err := accountUser.allowedNetworks()
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	return LoginResult{
+		AccountUserID:   accountUser.AccountUserID,
+		AdminLevel:      accountUser.AdminLevel,
+		Accounts:        results,
+		AllowedNetworks: allowedNetworks,
+	}, nil
+}
+
+// loginAccountResult looks up the account of the given relationship and checks
+// the given key encryption key can be used for decrypting its private key.
+func (p *persistenceLayer) loginAccountResult(relationship AccountUserRelationship, keyEncryptionKey []byte) (LoginAccountResult, error) {
+	accountID := relationship.AccountID
+	account, err := p.dal.FindAccount(FindAccountQueryByID(accountID))
+	if err != nil {
+		return LoginAccountResult{}, fmt.Errorf(`persistence: error looking up account with id "%s": %w`, accountID, err)
+	}
+	if _, err := keys.DecryptWith(keyEncryptionKey, account.EncryptedPrivateKey); err != nil {
+		return LoginAccountResult{}, errStaleKeyEncryptionKey
+	}
+	k, err := jwk.New(keyEncryptionKey)
+	if err != nil {
+		return LoginAccountResult{}, fmt.Errorf("persistence: error creating key: %w", err)
+	}
+	return LoginAccountResult{
+		AccountName:      account.Name,
+		AccountID:        accountID,
+		Created:          account.Created,
+		KeyEncryptionKey: k,
+		Role:             relationship.Role,
+	}, nil
+}
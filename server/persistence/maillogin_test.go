@@ -0,0 +1,62 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/offen/offen/server/keys"
+)
+
+func TestPersistenceLayer_LoginWithEmail(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	keyEncryptionKey, _ := keys.GenerateRandomBytes(keys.DefaultEncryptionKeySize)
+	encryptedPrivateKey, _ := keys.EncryptWith(keyEncryptionKey, []byte("private-key"))
+
+	createUser := func(secondFactor bool) AccountUser {
+		accountUser, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, params, nil)
+		relationship, _ := newAccountUserRelationship(accountUser.AccountUserID, "account-a")
+		relationship.addPasswordEncryptedKey(keyEncryptionKey, accountUser.Salt, "develop")
+		relationship.addEmailEncryptedKey(keyEncryptionKey, accountUser.EmailSalt, "develop@offen.dev")
+		accountUser.Relationships = []AccountUserRelationship{*relationship}
+		accountUser.SecondFactorEnabled = secondFactor
+		accountUser.AllowedNetworks = `["10.0.0.0/8"]`
+		return *accountUser
+	}
+
+	tests := []struct {
+		name          string
+		accountUser   AccountUser
+		email         string
+		code          string
+		expectedError error
+		expectedCount int
+	}{
+		{"ok", createUser(false), "develop@offen.dev", "", nil, 1},
+		{"unknown user", createUser(false), "other@offen.dev", "", ErrInvalidCredentials, 0},
+		{"second factor required", createUser(true), "develop@offen.dev", "", ErrSecondFactorRequired, 0},
+		{"bad second factor", createUser(true), "develop@offen.dev", "abcde-fghij", ErrInvalidCredentials, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &mockWebAuthnDatabase{
+				accountUser: test.accountUser,
+				account:     Account{AccountID: "account-a", EncryptedPrivateKey: encryptedPrivateKey.Marshal()},
+			}
+			p := &persistenceLayer{dal: db, kdfParams: params}
+			result, err := p.LoginWithEmail(test.email, test.code)
+			if !errors.Is(err, test.expectedError) {
+				t.Errorf("Expected error %v, got %v", test.expectedError, err)
+			}
+			if len(result.Accounts) != test.expectedCount {
+				t.Errorf("Expected %d accounts, got %v", test.expectedCount, result)
+			}
+			if err == nil && !reflect.DeepEqual(result.AllowedNetworks, []string{"10.0.0.0/8"}) {
+				t.Errorf("Unexpected allowed networks %v", result.AllowedNetworks)
+			}
+		})
+	}
+}
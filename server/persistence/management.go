@@ -4,12 +4,17 @@
 package persistence
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"strings"
+	"time"
 
 	"github.com/offen/offen/server/keys"
 )
 
-func (p *persistenceLayer) ShareAccount(inviteeEmailAddress, providerEmailAddress, providerPassword, accountID string, grantAdminPrivileges bool) (ShareAccountResult, error) {
+func (p *persistenceLayer) ShareAccount(inviteeEmailAddress, providerEmailAddress, providerPassword, accountID string, grantAdminPrivileges bool, role AccountUserRole) (ShareAccountResult, error) {
 	var result ShareAccountResult
 	var invitedAccountUser *AccountUser
 
@@ -19,36 +24,51 @@ func (p *persistenceLayer) ShareAccount(inviteeEmailAddress, providerEmailAddres
 	}
 
 	// First, we need to check if the provider has given valid credentials
-	provider, findErr := selectAccountUser(accountUsers, providerEmailAddress)
+	provider, findErr := p.selectAccountUser(accountUsers, providerEmailAddress)
 	if findErr != nil {
 		return result, fmt.Errorf("persistence: error looking up account user: %w", findErr)
 	}
-	if err := keys.CompareString(providerPassword, provider.HashedPassword); err != nil {
+	if err := keys.ComparePassword(providerPassword, provider.HashedPassword, p.pepper); err != nil {
 		return result, fmt.Errorf("persistence: error comparing passwords: %w", err)
 	}
 
+	if role != AccountUserRoleViewer && role != AccountUserRoleAdmin {
+		return result, fmt.Errorf("persistence: unknown role %d", role)
+	}
+	providerIsSuperAdmin := provider.AdminLevel == AccountUserAdminLevelSuperAdmin
+	if accountID != "" && !provider.canManageAccount(accountID) {
+		return result, ErrPermissionDenied
+	}
+
 	var targetAdminLevel AccountUserAdminLevel
 	if grantAdminPrivileges {
+		// only SuperAdmins can make other account users SuperAdmins
+		if !providerIsSuperAdmin {
+			return result, ErrPermissionDenied
+		}
 		targetAdminLevel = AccountUserAdminLevelSuperAdmin
 	}
 	// Next, we need to check whether the given address is already associated
 	// with an existing account.
-	if match, err := selectAccountUser(accountUsers, inviteeEmailAddress); err == nil {
+	if match, err := p.selectAccountUser(accountUsers, inviteeEmailAddress); err == nil {
 		if match.HashedPassword != "" {
 			result.UserExistsWithPassword = true
 		}
 		invitedAccountUser = match
-		if match.AdminLevel != targetAdminLevel {
+		// account admins are not allowed to change the admin level of
+		// existing account users
+		if providerIsSuperAdmin && match.AdminLevel != targetAdminLevel {
 			invitedAccountUser.AdminLevel = targetAdminLevel
 			if err := p.dal.UpdateAccountUser(invitedAccountUser); err != nil {
 				return result, fmt.Errorf("persistence: error updating admin level on previously non-admin user: %w", err)
 			}
 		}
 	} else {
-		newAccountUserRecord, err := newAccountUser(inviteeEmailAddress, "", targetAdminLevel)
+		newAccountUserRecord, err := newAccountUser(inviteeEmailAddress, "", targetAdminLevel, p.kdfParams, p.pepper)
 		if err != nil {
 			return result, fmt.Errorf("persistence: error creating new account user for invitee: %w", err)
 		}
+		newAccountUserRecord.setEmailLookupHash(p.emailLookup, inviteeEmailAddress)
 		invitedAccountUser = newAccountUserRecord
 		if err := p.dal.CreateAccountUser(invitedAccountUser); err != nil {
 			return result, fmt.Errorf("persistence: error persisting new account user for invitee: %w", err)
@@ -70,14 +90,18 @@ outer:
 				continue outer
 			}
 		}
+		if !provider.canManageAccount(relationship.AccountID) {
+			continue
+		}
 		if accountID == "" || relationship.AccountID == accountID {
-			// with no filter given, the invitee inherits all relationships from
-			// the provider
+			// with no filter given, the invitee inherits all relationships the
+			// provider is allowed to manage
 			account, accountErr := p.dal.FindAccount(FindAccountQueryByID(relationship.AccountID))
 			if accountErr != nil {
 				return result, fmt.Errorf("persistence: error looking up account info for relationship %s: %w", relationship.RelationshipID, err)
 			}
 			result.AccountNames = append(result.AccountNames, account.Name)
+			result.AccountIDs = append(result.AccountIDs, account.AccountID)
 			eligibleRelationships = append(eligibleRelationships, relationship)
 		}
 	}
@@ -94,14 +118,22 @@ outer:
 			txn.Rollback()
 			return result, fmt.Errorf("persistence: error creating account user relationship: %w", err)
 		}
+		inviteeRelationship.Role = role
 
 		decryptedKey, decryptErr := keys.DecryptWith(providerKey, providerRelationship.PasswordEncryptedKeyEncryptionKey)
 		if decryptErr != nil {
 			txn.Rollback()
 			return result, fmt.Errorf("persistence: error decrypting email encrypted key: %w", decryptErr)
 		}
+		// the invitee's relationship does not inherit any pending rotations
+		// so the current key needs to be shared
+		decryptedKey, decryptErr = providerRelationship.resolveKeyEncryptionKey(decryptedKey, p.rotationKeys...)
+		if decryptErr != nil {
+			txn.Rollback()
+			return result, fmt.Errorf("persistence: error resolving key encryption key: %w", decryptErr)
+		}
 
-		if err := inviteeRelationship.addEmailEncryptedKey(decryptedKey, invitedAccountUser.Salt, inviteeEmailAddress); err != nil {
+		if err := inviteeRelationship.addEmailEncryptedKey(decryptedKey, invitedAccountUser.emailSalt(), inviteeEmailAddress); err != nil {
 			txn.Rollback()
 			return result, fmt.Errorf("persistence: error adding email encrypted key: %w", err)
 		}
@@ -126,22 +158,29 @@ func (p *persistenceLayer) Join(emailAddress, password string) error {
 		return fmt.Errorf("persistence: user with email %s has already joined before", emailAddress)
 	}
 
-	if err := keys.ValidatePassword(password); err != nil {
+	if err := p.validateNewPassword(emailAddress, password); err != nil {
 		return fmt.Errorf("persistence: error validating password: %w", err)
 	}
 
-	cipher, err := keys.HashString(password)
+	cipher, err := keys.HashPassword(password, p.kdfParams, p.pepper)
 	if err != nil {
 		return fmt.Errorf("persistence: hashing given password: %w", err)
 	}
 	match.HashedPassword = cipher.Marshal()
+	now := time.Now()
+	match.PasswordChanged = &now
 
-	emailDerivedKey, deriveErr := keys.DeriveKey(emailAddress, match.Salt)
+	emailDerivedKey, deriveErr := keys.DeriveKey(emailAddress, match.emailSalt())
 	if deriveErr != nil {
 		return fmt.Errorf("persistence: error deriving key from email: %w", deriveErr)
 	}
 
 	for index, relationship := range match.Relationships {
+		// invitations created using a token are accepted using
+		// AcceptInvitation
+		if relationship.EmailEncryptedKeyEncryptionKey == "" {
+			continue
+		}
 		key, keyErr := keys.DecryptWith(emailDerivedKey, relationship.EmailEncryptedKeyEncryptionKey)
 		if keyErr != nil {
 			return fmt.Errorf("persistence: error decrypting email encrypted key: %w", keyErr)
@@ -158,3 +197,255 @@ func (p *persistenceLayer) Join(emailAddress, password string) error {
 	}
 	return nil
 }
+
+// InvitationTTL is the duration after which an invitation that has been
+// created using Invite expires.
+const InvitationTTL = time.Hour * 24 * 7
+
+// Invite creates pending relationships to the given accounts for the invitee.
+// Instead of encrypting the key encryption keys using the invitee's email
+// address, they are encrypted using a random token that is returned to the
+// caller and is expected to be handed to the invitee only. Passing an empty
+// list of account ids shares all accounts the provider is allowed to manage.
+func (p *persistenceLayer) Invite(inviteeEmailAddress, providerEmailAddress, providerPassword string, accountIDs []string, role AccountUserRole) (InviteResult, error) {
+	var result InviteResult
+	if role != AccountUserRoleViewer && role != AccountUserRoleAdmin {
+		return result, fmt.Errorf("persistence: unknown role %d", role)
+	}
+
+	provider, err := p.findAccountUser(providerEmailAddress, true, false)
+	if err != nil {
+		return result, fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	if err := keys.ComparePassword(providerPassword, provider.HashedPassword, p.pepper); err != nil {
+		return result, fmt.Errorf("persistence: error comparing passwords: %w", err)
+	}
+	requested := map[string]bool{}
+	for _, accountID := range accountIDs {
+		if !provider.canManageAccount(accountID) {
+			return result, ErrPermissionDenied
+		}
+		requested[accountID] = true
+	}
+
+	// pending invitations are included so that no account is shared twice
+	invitee, err := p.findAccountUser(inviteeEmailAddress, true, true)
+	if err != nil {
+		invitee, err = newAccountUser(inviteeEmailAddress, "", 0, p.kdfParams, p.pepper)
+		if err != nil {
+			return result, fmt.Errorf("persistence: error creating new account user for invitee: %w", err)
+		}
+		invitee.setEmailLookupHash(p.emailLookup, inviteeEmailAddress)
+		if err := p.dal.CreateAccountUser(invitee); err != nil {
+			return result, fmt.Errorf("persistence: error persisting new account user for invitee: %w", err)
+		}
+	}
+	result.UserExistsWithPassword = invitee.HashedPassword != ""
+
+	providerKey, err := keys.DeriveKey(providerPassword, provider.Salt)
+	if err != nil {
+		return result, fmt.Errorf("persistence: error deriving key from password: %w", err)
+	}
+
+	token, err := keys.GenerateRandomBytes(keys.DefaultEncryptionKeySize)
+	if err != nil {
+		return result, fmt.Errorf("persistence: error creating invitation token: %w", err)
+	}
+	expires := time.Now().Add(InvitationTTL)
+
+	txn, err := p.dal.Transaction()
+	if err != nil {
+		return result, fmt.Errorf("persistence: error creating transaction: %w", err)
+	}
+outer:
+	for _, providerRelationship := range provider.Relationships {
+		if !provider.canManageAccount(providerRelationship.AccountID) {
+			continue
+		}
+		if len(requested) != 0 && !requested[providerRelationship.AccountID] {
+			continue
+		}
+		for _, existingRelationship := range invitee.Relationships {
+			if existingRelationship.AccountID == providerRelationship.AccountID {
+				continue outer
+			}
+		}
+
+		account, err := p.dal.FindAccount(FindAccountQueryByID(providerRelationship.AccountID))
+		if err != nil {
+			txn.Rollback()
+			return result, fmt.Errorf("persistence: error looking up account info for relationship %s: %w", providerRelationship.RelationshipID, err)
+		}
+
+		inviteeRelationship, err := newAccountUserRelationship(invitee.AccountUserID, providerRelationship.AccountID)
+		if err != nil {
+			txn.Rollback()
+			return result, fmt.Errorf("persistence: error creating account user relationship: %w", err)
+		}
+		inviteeRelationship.Role = role
+
+		decryptedKey, err := keys.DecryptWith(providerKey, providerRelationship.PasswordEncryptedKeyEncryptionKey)
+		if err != nil {
+			txn.Rollback()
+			return result, fmt.Errorf("persistence: error decrypting password encrypted key: %w", err)
+		}
+		decryptedKey, err = providerRelationship.resolveKeyEncryptionKey(decryptedKey, p.rotationKeys...)
+		if err != nil {
+			txn.Rollback()
+			return result, fmt.Errorf("persistence: error resolving key encryption key: %w", err)
+		}
+		if err := inviteeRelationship.addOneTimeEncryptedKey(decryptedKey, token, expires); err != nil {
+			txn.Rollback()
+			return result, fmt.Errorf("persistence: error adding invitation key: %w", err)
+		}
+		if err := txn.CreateAccountUserRelationship(inviteeRelationship); err != nil {
+			txn.Rollback()
+			return result, fmt.Errorf("persistence: error persisting account user relationship: %w", err)
+		}
+		result.AccountNames = append(result.AccountNames, account.Name)
+		result.AccountIDs = append(result.AccountIDs, account.AccountID)
+	}
+	if err := txn.Commit(); err != nil {
+		return result, fmt.Errorf("persistence: error committing transaction: %w", err)
+	}
+	result.Token = token
+	return result, nil
+}
+
+// AcceptInvitation uses the given token for accepting all pending invitations
+// that have been created using Invite. Account users that have not joined
+// yet set their password, others are required to confirm their current one.
+func (p *persistenceLayer) AcceptInvitation(emailAddress, password string, token []byte) error {
+	accountUser, err := p.findAccountUser(emailAddress, true, true)
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+
+	if accountUser.HashedPassword == "" {
+		if err := p.validateNewPassword(emailAddress, password); err != nil {
+			return fmt.Errorf("persistence: error validating password: %w", err)
+		}
+		cipher, err := keys.HashPassword(password, p.kdfParams, p.pepper)
+		if err != nil {
+			return fmt.Errorf("persistence: error hashing password: %w", err)
+		}
+		accountUser.HashedPassword = cipher.Marshal()
+		now := time.Now()
+		accountUser.PasswordChanged = &now
+	} else if err := p.verifyPassword(accountUser, emailAddress, password); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var accepted int
+	for index, relationship := range accountUser.Relationships {
+		if relationship.PasswordEncryptedKeyEncryptionKey != "" || relationship.OneTimeEncryptedKeyEncryptionKey == "" {
+			continue
+		}
+		// the account user might have pending invitations using other tokens
+		key, err := relationship.consumeOneTimeKey(token, now)
+		if err != nil {
+			continue
+		}
+		if err := relationship.addPasswordEncryptedKey(key, accountUser.Salt, password); err != nil {
+			return fmt.Errorf("persistence: error adding password encrypted key: %w", err)
+		}
+		if err := relationship.addEmailEncryptedKey(key, accountUser.emailSalt(), emailAddress); err != nil {
+			return fmt.Errorf("persistence: error adding email encrypted key: %w", err)
+		}
+		accountUser.Relationships[index] = relationship
+		accepted++
+	}
+	if accepted == 0 {
+		return ErrInvalidOneTimeKey("persistence: no pending invitation found for token")
+	}
+
+	if err := p.dal.UpdateAccountUser(accountUser); err != nil {
+		return fmt.Errorf("persistence: error updating account user: %w", err)
+	}
+	return nil
+}
+
+// SuspendAccountUser sets or clears the suspension of the account user with
+// the given id. Only super admins are allowed to do so and they cannot
+// suspend themselves. Sessions of suspended account users are revoked.
+func (p *persistenceLayer) SuspendAccountUser(adminUserID, accountUserID string, suspended bool) error {
+	if adminUserID == accountUserID {
+		return errors.New("persistence: account users cannot change their own suspension")
+	}
+	admin, err := p.dal.FindAccountUser(FindAccountUserQueryByAccountUserIDIncludeRelationships(adminUserID))
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up admin: %w", err)
+	}
+	if admin.AdminLevel != AccountUserAdminLevelSuperAdmin || admin.Suspended {
+		return ErrPermissionDenied
+	}
+	accountUser, err := p.dal.FindAccountUser(FindAccountUserQueryByAccountUserIDIncludeRelationships(accountUserID))
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	if accountUser.Suspended == suspended {
+		return nil
+	}
+	accountUser.Suspended = suspended
+	if err := p.dal.UpdateAccountUser(&accountUser); err != nil {
+		return fmt.Errorf("persistence: error updating account user: %w", err)
+	}
+	if suspended {
+		if err := p.dal.DeleteSessions(DeleteSessionsQueryByAccountUserID(accountUserID)); err != nil {
+			return fmt.Errorf("persistence: error revoking sessions: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetAllowedNetworks restricts the networks the account user with the given
+// id can use the application from. Networks are given in CIDR notation, single
+// IP addresses are accepted as well. Passing an empty list removes the
+// restriction. Only super admins are allowed to do so.
+func (p *persistenceLayer) SetAllowedNetworks(adminUserID, accountUserID string, networks []string) error {
+	admin, err := p.dal.FindAccountUser(FindAccountUserQueryByAccountUserIDIncludeRelationships(adminUserID))
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up admin: %w", err)
+	}
+	if admin.AdminLevel != AccountUserAdminLevelSuperAdmin || admin.Suspended {
+		return ErrPermissionDenied
+	}
+
+	var normalized []string
+	for _, network := range networks {
+		if !strings.Contains(network, "/") {
+			ip := net.ParseIP(network)
+			if ip == nil {
+				return fmt.Errorf("persistence: invalid ip address %s", network)
+			}
+			if ip.To4() != nil {
+				network += "/32"
+			} else {
+				network += "/128"
+			}
+		}
+		_, parsed, err := net.ParseCIDR(network)
+		if err != nil {
+			return fmt.Errorf("persistence: invalid network %s: %w", network, err)
+		}
+		normalized = append(normalized, parsed.String())
+	}
+
+	accountUser, err := p.dal.FindAccountUser(FindAccountUserQueryByAccountUserIDIncludeRelationships(accountUserID))
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	accountUser.AllowedNetworks = ""
+	if len(normalized) != 0 {
+		b, err := json.Marshal(normalized)
+		if err != nil {
+			return fmt.Errorf("persistence: error encoding allowed networks: %w", err)
+		}
+		accountUser.AllowedNetworks = string(b)
+	}
+	if err := p.dal.UpdateAccountUser(&accountUser); err != nil {
+		return fmt.Errorf("persistence: error updating account user: %w", err)
+	}
+	return nil
+}
@@ -77,7 +77,7 @@ func TestPersistenceLayer_ShareAccount(t *testing.T) {
 			&mockShareAccountDatabase{
 				findAcccountUsersResult: []AccountUser{
 					(func() AccountUser {
-						a, _ := newAccountUser("hioffen@offen.dev", "develop", AccountUserAdminLevelSuperAdmin)
+						a, _ := newAccountUser("hioffen@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, keys.DefaultKDFParams, nil)
 						return *a
 					})(),
 				},
@@ -94,7 +94,7 @@ func TestPersistenceLayer_ShareAccount(t *testing.T) {
 			&mockShareAccountDatabase{
 				findAcccountUsersResult: []AccountUser{
 					(func() AccountUser {
-						a, _ := newAccountUser("develop@offen.dev", "d3v3lop", AccountUserAdminLevelSuperAdmin)
+						a, _ := newAccountUser("develop@offen.dev", "d3v3lop", AccountUserAdminLevelSuperAdmin, keys.DefaultKDFParams, nil)
 						return *a
 					})(),
 				},
@@ -111,7 +111,7 @@ func TestPersistenceLayer_ShareAccount(t *testing.T) {
 			&mockShareAccountDatabase{
 				findAcccountUsersResult: []AccountUser{
 					(func() AccountUser {
-						a, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin)
+						a, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, keys.DefaultKDFParams, nil)
 						return *a
 					})(),
 				},
@@ -129,7 +129,7 @@ func TestPersistenceLayer_ShareAccount(t *testing.T) {
 			&mockShareAccountDatabase{
 				findAcccountUsersResult: []AccountUser{
 					(func() AccountUser {
-						a, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin)
+						a, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, keys.DefaultKDFParams, nil)
 
 						emailDerivedKey, _ := keys.DeriveKey("develop@offen.dev", a.Salt)
 						passwordDerivedKey, _ := keys.DeriveKey("develop", a.Salt)
@@ -149,7 +149,7 @@ func TestPersistenceLayer_ShareAccount(t *testing.T) {
 						return *a
 					})(),
 					(func() AccountUser {
-						a, _ := newAccountUser("invitee@offen.dev", "develop", AccountUserAdminLevelSuperAdmin)
+						a, _ := newAccountUser("invitee@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, keys.DefaultKDFParams, nil)
 						return *a
 					})(),
 				},
@@ -161,6 +161,7 @@ func TestPersistenceLayer_ShareAccount(t *testing.T) {
 			ShareAccountResult{
 				UserExistsWithPassword: true,
 				AccountNames:           []string{"account-name"},
+				AccountIDs:             []string{"account-id"},
 			},
 			false,
 		},
@@ -169,7 +170,7 @@ func TestPersistenceLayer_ShareAccount(t *testing.T) {
 			&mockShareAccountDatabase{
 				findAcccountUsersResult: []AccountUser{
 					(func() AccountUser {
-						a, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin)
+						a, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, keys.DefaultKDFParams, nil)
 
 						emailDerivedKey, _ := keys.DeriveKey("develop@offen.dev", a.Salt)
 						passwordDerivedKey, _ := keys.DeriveKey("develop", a.Salt)
@@ -197,14 +198,15 @@ func TestPersistenceLayer_ShareAccount(t *testing.T) {
 			ShareAccountResult{
 				UserExistsWithPassword: false,
 				AccountNames:           []string{"account-name"},
+				AccountIDs:             []string{"account-id"},
 			},
 			false,
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			p := persistenceLayer{test.dal}
-			result, err := p.ShareAccount(test.invitee, test.email, test.password, test.accountID, true)
+			p := persistenceLayer{dal: test.dal}
+			result, err := p.ShareAccount(test.invitee, test.email, test.password, test.accountID, true, AccountUserRoleViewer)
 
 			if test.expectErr != (err != nil) {
 				t.Errorf("Unexpected error value %v", err)
@@ -217,6 +219,46 @@ func TestPersistenceLayer_ShareAccount(t *testing.T) {
 	}
 }
 
+func TestPersistenceLayer_ShareAccount_Roles(t *testing.T) {
+	newProvider := func(adminLevel AccountUserAdminLevel, role AccountUserRole) AccountUser {
+		a, _ := newAccountUser("develop@offen.dev", "develop", adminLevel, keys.DefaultKDFParams, nil)
+		passwordDerivedKey, _ := keys.DeriveKey("develop", a.Salt)
+		p, _ := keys.EncryptWith(passwordDerivedKey, []byte("key"))
+		a.Relationships = []AccountUserRelationship{
+			{
+				AccountID:                         "account-id",
+				AccountUserID:                     a.AccountUserID,
+				PasswordEncryptedKeyEncryptionKey: p.Marshal(),
+				Role:                              role,
+			},
+		}
+		return *a
+	}
+	tests := []struct {
+		name                 string
+		provider             AccountUser
+		grantAdminPrivileges bool
+		role                 AccountUserRole
+		expectedErr          error
+	}{
+		{"superadmin", newProvider(AccountUserAdminLevelSuperAdmin, AccountUserRoleViewer), true, AccountUserRoleAdmin, nil},
+		{"account admin", newProvider(0, AccountUserRoleAdmin), false, AccountUserRoleAdmin, nil},
+		{"account admin granting superadmin", newProvider(0, AccountUserRoleAdmin), true, AccountUserRoleViewer, ErrPermissionDenied},
+		{"viewer", newProvider(0, AccountUserRoleViewer), false, AccountUserRoleViewer, ErrPermissionDenied},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := persistenceLayer{dal: &mockShareAccountDatabase{
+				findAcccountUsersResult: []AccountUser{test.provider},
+			}}
+			_, err := p.ShareAccount("invitee@offen.dev", "develop@offen.dev", "develop", "account-id", test.grantAdminPrivileges, test.role)
+			if err != test.expectedErr {
+				t.Errorf("Expected %v, got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
 type mockJoinDatabase struct {
 	DataAccessLayer
 	findAccountUsersResult []AccountUser
@@ -282,7 +324,7 @@ func TestPersistenceLayer_Join(t *testing.T) {
 			&mockJoinDatabase{
 				findAccountUsersResult: []AccountUser{
 					(func() AccountUser {
-						a, _ := newAccountUser("foo@bar.com", "s3cret", 0)
+						a, _ := newAccountUser("foo@bar.com", "s3cret", 0, keys.DefaultKDFParams, nil)
 						return *a
 					})(),
 				},
@@ -296,7 +338,7 @@ func TestPersistenceLayer_Join(t *testing.T) {
 			&mockJoinDatabase{
 				findAccountUsersResult: []AccountUser{
 					(func() AccountUser {
-						a, _ := newAccountUser("foo@bar.com", "secret", 0)
+						a, _ := newAccountUser("foo@bar.com", "secret", 0, keys.DefaultKDFParams, nil)
 						return *a
 					})(),
 				},
@@ -311,8 +353,8 @@ func TestPersistenceLayer_Join(t *testing.T) {
 			&mockJoinDatabase{
 				findAccountUsersResult: []AccountUser{
 					(func() AccountUser {
-						a, _ := newAccountUser("foo@bar.com", "secret", 0)
-						emailDerivedKey, _ := keys.DeriveKey("foo@bar.com", a.Salt)
+						a, _ := newAccountUser("foo@bar.com", "secret", 0, keys.DefaultKDFParams, nil)
+						emailDerivedKey, _ := keys.DeriveKey("foo@bar.com", a.EmailSalt)
 
 						key := []byte("key")
 						c, _ := keys.EncryptWith(emailDerivedKey, key)
@@ -338,8 +380,8 @@ func TestPersistenceLayer_Join(t *testing.T) {
 			&mockJoinDatabase{
 				findAccountUsersResult: []AccountUser{
 					(func() AccountUser {
-						a, _ := newAccountUser("foo@bar.com", "secret", 0)
-						emailDerivedKey, _ := keys.DeriveKey("foo@bar.com", a.Salt)
+						a, _ := newAccountUser("foo@bar.com", "secret", 0, keys.DefaultKDFParams, nil)
+						emailDerivedKey, _ := keys.DeriveKey("foo@bar.com", a.EmailSalt)
 
 						key := []byte("key")
 						c, _ := keys.EncryptWith(emailDerivedKey, key)
@@ -365,8 +407,8 @@ func TestPersistenceLayer_Join(t *testing.T) {
 			&mockJoinDatabase{
 				findAccountUsersResult: []AccountUser{
 					(func() AccountUser {
-						a, _ := newAccountUser("foo@bar.com", "secret", 0)
-						emailDerivedKey, _ := keys.DeriveKey("foo@bar.com", a.Salt)
+						a, _ := newAccountUser("foo@bar.com", "secret", 0, keys.DefaultKDFParams, nil)
+						emailDerivedKey, _ := keys.DeriveKey("foo@bar.com", a.EmailSalt)
 
 						key := []byte("key")
 						c, _ := keys.EncryptWith(emailDerivedKey, key)
@@ -391,9 +433,9 @@ func TestPersistenceLayer_Join(t *testing.T) {
 			&mockJoinDatabase{
 				findAccountUsersResult: []AccountUser{
 					(func() AccountUser {
-						a, _ := newAccountUser("foo@bar.com", "secretsecretsosecret", 0)
+						a, _ := newAccountUser("foo@bar.com", "secretsecretsosecret", 0, keys.DefaultKDFParams, nil)
 						a.HashedPassword = ""
-						emailDerivedKey, _ := keys.DeriveKey("foo@bar.com", a.Salt)
+						emailDerivedKey, _ := keys.DeriveKey("foo@bar.com", a.EmailSalt)
 
 						key := []byte("key")
 						c, _ := keys.EncryptWith(emailDerivedKey, key)
@@ -416,7 +458,7 @@ func TestPersistenceLayer_Join(t *testing.T) {
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			p := &persistenceLayer{test.dal}
+			p := &persistenceLayer{dal: test.dal}
 			err := p.Join(test.emailArg, test.pwArg)
 			if test.expectError != (err != nil) {
 				t.Errorf("Unexpected error value: %v", err)
@@ -424,3 +466,246 @@ func TestPersistenceLayer_Join(t *testing.T) {
 		})
 	}
 }
+
+type mockInviteDatabase struct {
+	DataAccessLayer
+	accountUsers  []AccountUser
+	relationships []AccountUserRelationship
+	updated       *AccountUser
+}
+
+func (m *mockInviteDatabase) FindAccountUsers(interface{}) ([]AccountUser, error) {
+	return append([]AccountUser{}, m.accountUsers...), nil
+}
+
+func (m *mockInviteDatabase) CreateAccountUser(a *AccountUser) error {
+	m.accountUsers = append(m.accountUsers, *a)
+	return nil
+}
+
+func (m *mockInviteDatabase) CreateAccountUserRelationship(r *AccountUserRelationship) error {
+	m.relationships = append(m.relationships, *r)
+	return nil
+}
+
+func (m *mockInviteDatabase) UpdateAccountUser(a *AccountUser) error {
+	m.updated = a
+	return nil
+}
+
+func (m *mockInviteDatabase) FindAccount(interface{}) (Account, error) {
+	return Account{Name: "name"}, nil
+}
+
+func (m *mockInviteDatabase) Transaction() (Transaction, error) {
+	return m, nil
+}
+
+func (m *mockInviteDatabase) Commit() error {
+	return nil
+}
+
+func (m *mockInviteDatabase) Rollback() error {
+	return nil
+}
+
+func TestPersistenceLayer_Invite(t *testing.T) {
+	newProvider := func(role AccountUserRole) AccountUser {
+		a, _ := newAccountUser("develop@offen.dev", "develop", 0, keys.DefaultKDFParams, nil)
+		passwordDerivedKey, _ := keys.DeriveKey("develop", a.Salt)
+		p, _ := keys.EncryptWith(passwordDerivedKey, []byte("key"))
+		a.Relationships = []AccountUserRelationship{
+			{
+				AccountID:                         "account-a",
+				AccountUserID:                     a.AccountUserID,
+				PasswordEncryptedKeyEncryptionKey: p.Marshal(),
+				Role:                              role,
+			},
+			{
+				AccountID:                         "account-b",
+				AccountUserID:                     a.AccountUserID,
+				PasswordEncryptedKeyEncryptionKey: p.Marshal(),
+				Role:                              role,
+			},
+		}
+		return *a
+	}
+
+	t.Run("viewer", func(t *testing.T) {
+		p := persistenceLayer{dal: &mockInviteDatabase{
+			accountUsers: []AccountUser{newProvider(AccountUserRoleViewer)},
+		}}
+		_, err := p.Invite("invitee@offen.dev", "develop@offen.dev", "develop", []string{"account-a"}, AccountUserRoleViewer)
+		if err != ErrPermissionDenied {
+			t.Errorf("Expected %v, got %v", ErrPermissionDenied, err)
+		}
+	})
+
+	t.Run("bad password", func(t *testing.T) {
+		p := persistenceLayer{dal: &mockInviteDatabase{
+			accountUsers: []AccountUser{newProvider(AccountUserRoleAdmin)},
+		}}
+		if _, err := p.Invite("invitee@offen.dev", "develop@offen.dev", "other", []string{"account-a"}, AccountUserRoleViewer); err == nil {
+			t.Error("Expected error, got nil")
+		}
+	})
+
+	t.Run("invite and accept", func(t *testing.T) {
+		dal := &mockInviteDatabase{
+			accountUsers: []AccountUser{newProvider(AccountUserRoleAdmin)},
+		}
+		p := persistenceLayer{dal: dal}
+		result, err := p.Invite("invitee@offen.dev", "develop@offen.dev", "develop", []string{"account-a"}, AccountUserRoleViewer)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if result.UserExistsWithPassword {
+			t.Error("Expected new user")
+		}
+		if !reflect.DeepEqual(result.AccountNames, []string{"name"}) {
+			t.Errorf("Unexpected account names %v", result.AccountNames)
+		}
+		if len(dal.relationships) != 1 {
+			t.Fatalf("Expected one relationship, got %d", len(dal.relationships))
+		}
+		relationship := dal.relationships[0]
+		if relationship.AccountID != "account-a" || relationship.Role != AccountUserRoleViewer {
+			t.Errorf("Unexpected relationship %v", relationship)
+		}
+		if relationship.PasswordEncryptedKeyEncryptionKey != "" || relationship.EmailEncryptedKeyEncryptionKey != "" {
+			t.Error("Expected key to be encrypted using the token only")
+		}
+
+		invitee := dal.accountUsers[1]
+		invitee.Relationships = dal.relationships
+		dal.accountUsers[1] = invitee
+
+		if err := p.AcceptInvitation("invitee@offen.dev", "correct horse battery staple", []byte("other-token-other-token-other-to")); err == nil {
+			t.Error("Expected error when using bad token")
+		}
+		if err := p.AcceptInvitation("invitee@offen.dev", "correct horse battery staple", result.Token); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		if dal.updated.HashedPassword == "" {
+			t.Error("Expected password to be set")
+		}
+		passwordDerivedKey, _ := keys.DeriveKey("correct horse battery staple", dal.updated.Salt)
+		key, err := keys.DecryptWith(passwordDerivedKey, dal.updated.Relationships[0].PasswordEncryptedKeyEncryptionKey)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if string(key) != "key" {
+			t.Errorf("Unexpected key %s", key)
+		}
+		if !dal.updated.Relationships[0].OneTimeKeyConsumed {
+			t.Error("Expected invitation to be consumed")
+		}
+	})
+}
+
+type mockSuspendDatabase struct {
+	DataAccessLayer
+	accountUsers    map[string]AccountUser
+	revokedSessions string
+}
+
+func (m *mockSuspendDatabase) FindAccountUser(q interface{}) (AccountUser, error) {
+	if a, ok := m.accountUsers[string(q.(FindAccountUserQueryByAccountUserIDIncludeRelationships))]; ok {
+		return a, nil
+	}
+	return AccountUser{}, errors.New("not found")
+}
+
+func (m *mockSuspendDatabase) UpdateAccountUser(a *AccountUser) error {
+	m.accountUsers[a.AccountUserID] = *a
+	return nil
+}
+
+func (m *mockSuspendDatabase) DeleteSessions(q interface{}) error {
+	m.revokedSessions = string(q.(DeleteSessionsQueryByAccountUserID))
+	return nil
+}
+
+func TestPersistenceLayer_SuspendAccountUser(t *testing.T) {
+	tests := []struct {
+		name              string
+		adminUserID       string
+		accountUserID     string
+		suspended         bool
+		expectError       bool
+		expectedSuspended bool
+		expectRevocation  bool
+	}{
+		{"suspend", "super-admin", "user", true, false, true, true},
+		{"unsuspend", "super-admin", "suspended-user", false, false, false, false},
+		{"self", "super-admin", "super-admin", true, true, false, false},
+		{"not a super admin", "admin", "user", true, true, false, false},
+		{"unknown admin", "unknown", "user", true, true, false, false},
+		{"unknown user", "super-admin", "unknown", true, true, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &mockSuspendDatabase{
+				accountUsers: map[string]AccountUser{
+					"super-admin":    {AccountUserID: "super-admin", AdminLevel: AccountUserAdminLevelSuperAdmin},
+					"admin":          {AccountUserID: "admin"},
+					"user":           {AccountUserID: "user"},
+					"suspended-user": {AccountUserID: "suspended-user", Suspended: true},
+				},
+			}
+			p := &persistenceLayer{dal: db}
+			err := p.SuspendAccountUser(test.adminUserID, test.accountUserID, test.suspended)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if db.accountUsers[test.accountUserID].Suspended != test.expectedSuspended {
+				t.Errorf("Expected suspended to be %v", test.expectedSuspended)
+			}
+			if (db.revokedSessions != "") != test.expectRevocation {
+				t.Errorf("Unexpected revocation of sessions %v", db.revokedSessions)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_SetAllowedNetworks(t *testing.T) {
+	tests := []struct {
+		name            string
+		adminUserID     string
+		networks        []string
+		expectError     bool
+		expectedNetwork string
+	}{
+		{"ok", "super-admin", []string{"10.0.0.0/8", "192.168.1.1", "2001:db8::1"}, false, `["10.0.0.0/8","192.168.1.1/32","2001:db8::1/128"]`},
+		{"clear", "super-admin", nil, false, ""},
+		{"invalid network", "super-admin", []string{"10.0.0.0/33"}, true, ""},
+		{"invalid ip", "super-admin", []string{"localhost"}, true, ""},
+		{"not a super admin", "admin", []string{"10.0.0.0/8"}, true, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &mockSuspendDatabase{
+				accountUsers: map[string]AccountUser{
+					"super-admin": {AccountUserID: "super-admin", AdminLevel: AccountUserAdminLevelSuperAdmin},
+					"admin":       {AccountUserID: "admin"},
+					"user":        {AccountUserID: "user", AllowedNetworks: `["127.0.0.1/32"]`},
+				},
+			}
+			p := &persistenceLayer{dal: db}
+			err := p.SetAllowedNetworks(test.adminUserID, "user", test.networks)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if db.accountUsers["user"].AllowedNetworks != test.expectedNetwork {
+				t.Errorf("Expected %v, got %v", test.expectedNetwork, db.accountUsers["user"].AllowedNetworks)
+			}
+		})
+	}
+}
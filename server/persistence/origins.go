@@ -0,0 +1,113 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// NormalizeOrigin returns the serialization of the given origin that is
+// used by browsers in the Origin header, i.e. lowercase scheme and host and
+// no default port.
+func NormalizeOrigin(origin string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(origin))
+	if err != nil {
+		return "", fmt.Errorf("persistence: invalid origin %s: %w", origin, err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return "", fmt.Errorf("persistence: origin %s does not use http or https", origin)
+	}
+	if u.Host == "" || u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("persistence: origin %s is expected to consist of scheme, host and port only", origin)
+	}
+	host := strings.ToLower(u.Host)
+	if port := u.Port(); (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		host = strings.TrimSuffix(host, ":"+port)
+	}
+	return scheme + "://" + host, nil
+}
+
+// SetAllowedOrigins sets the origins that are allowed to submit events for
+// the account with the given id in addition to the origin of the instance
+// itself. Passing an empty list removes all origins. The normalized list of
+// origins is returned.
+func (p *persistenceLayer) SetAllowedOrigins(accountID string, origins []string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, origin := range origins {
+		value, err := NormalizeOrigin(origin)
+		if err != nil {
+			return nil, err
+		}
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		normalized = append(normalized, value)
+	}
+
+	account, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID))
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+	}
+	account.AllowedOrigins = ""
+	if len(normalized) != 0 {
+		b, err := json.Marshal(normalized)
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error encoding allowed origins: %w", err)
+		}
+		account.AllowedOrigins = string(b)
+	}
+	if err := p.dal.UpdateAccount(&account); err != nil {
+		return nil, fmt.Errorf("persistence: error updating account: %w", err)
+	}
+	return normalized, nil
+}
+
+// IsOriginAllowed checks whether the given origin is allowed to submit
+// events for the account with the given id. In case no account id is
+// given, it checks whether any active account allows the origin, which is
+// needed for answering preflight requests.
+func (p *persistenceLayer) IsOriginAllowed(accountID, origin string) (bool, error) {
+	origin, err := NormalizeOrigin(origin)
+	if err != nil {
+		return false, nil
+	}
+
+	var accounts []Account
+	if accountID != "" {
+		account, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID))
+		if err != nil {
+			return false, fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+		}
+		accounts = append(accounts, account)
+	} else {
+		all, err := p.dal.FindAccounts(FindAccountsQueryAllAccounts{})
+		if err != nil {
+			return false, fmt.Errorf("persistence: error looking up accounts: %w", err)
+		}
+		for _, account := range all {
+			if !account.Retired {
+				accounts = append(accounts, account)
+			}
+		}
+	}
+
+	for _, account := range accounts {
+		allowed, err := account.allowedOrigins()
+		if err != nil {
+			return false, err
+		}
+		for _, value := range allowed {
+			if value == origin {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
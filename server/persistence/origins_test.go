@@ -0,0 +1,219 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeOrigin(t *testing.T) {
+	tests := []struct {
+		name           string
+		origin         string
+		expectedResult string
+		expectError    bool
+	}{
+		{"plain", "https://www.offen.dev", "https://www.offen.dev", false},
+		{"trailing slash", "https://www.offen.dev/", "https://www.offen.dev", false},
+		{"uppercase", "HTTPS://WWW.Offen.dev", "https://www.offen.dev", false},
+		{"default port", "https://www.offen.dev:443", "https://www.offen.dev", false},
+		{"default http port", "http://localhost:80", "http://localhost", false},
+		{"custom port", "http://localhost:8080", "http://localhost:8080", false},
+		{"ipv6", "https://[::1]:443", "https://[::1]", false},
+		{"path", "https://www.offen.dev/blog", "", true},
+		{"query", "https://www.offen.dev?a=b", "", true},
+		{"userinfo", "https://user@www.offen.dev", "", true},
+		{"bad scheme", "ftp://www.offen.dev", "", true},
+		{"no host", "www.offen.dev", "", true},
+		{"empty", "", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := NormalizeOrigin(test.origin)
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if result != test.expectedResult {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+type mockOriginsDatabase struct {
+	DataAccessLayer
+	findAccountResult  Account
+	findAccountErr     error
+	findAccountsResult []Account
+	findAccountsErr    error
+	updateErr          error
+	updated            *Account
+}
+
+func (m *mockOriginsDatabase) FindAccount(interface{}) (Account, error) {
+	return m.findAccountResult, m.findAccountErr
+}
+
+func (m *mockOriginsDatabase) FindAccounts(interface{}) ([]Account, error) {
+	return m.findAccountsResult, m.findAccountsErr
+}
+
+func (m *mockOriginsDatabase) UpdateAccount(a *Account) error {
+	m.updated = a
+	return m.updateErr
+}
+
+func TestPersistenceLayer_SetAllowedOrigins(t *testing.T) {
+	tests := []struct {
+		name           string
+		db             *mockOriginsDatabase
+		origins        []string
+		expectedResult []string
+		expectedValue  string
+		expectError    bool
+	}{
+		{
+			"bad origin",
+			&mockOriginsDatabase{},
+			[]string{"https://www.offen.dev/blog"},
+			nil,
+			"",
+			true,
+		},
+		{
+			"lookup error",
+			&mockOriginsDatabase{findAccountErr: ErrUnknownAccount("did not work")},
+			[]string{"https://www.offen.dev"},
+			nil,
+			"",
+			true,
+		},
+		{
+			"update error",
+			&mockOriginsDatabase{updateErr: errors.New("did not work")},
+			[]string{"https://www.offen.dev"},
+			nil,
+			"",
+			true,
+		},
+		{
+			"ok",
+			&mockOriginsDatabase{},
+			[]string{"https://www.offen.dev", "HTTPS://www.offen.dev:443/", "http://localhost:8080"},
+			[]string{"https://www.offen.dev", "http://localhost:8080"},
+			`["https://www.offen.dev","http://localhost:8080"]`,
+			false,
+		},
+		{
+			"clear",
+			&mockOriginsDatabase{findAccountResult: Account{AllowedOrigins: `["https://www.offen.dev"]`}},
+			nil,
+			[]string{},
+			"",
+			false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := persistenceLayer{dal: test.db}
+			result, err := p.SetAllowedOrigins("account-a", test.origins)
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if !reflect.DeepEqual(test.expectedResult, result) {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+			if err == nil && test.db.updated.AllowedOrigins != test.expectedValue {
+				t.Errorf("Expected stored value %v, got %v", test.expectedValue, test.db.updated.AllowedOrigins)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_IsOriginAllowed(t *testing.T) {
+	tests := []struct {
+		name           string
+		db             *mockOriginsDatabase
+		accountID      string
+		origin         string
+		expectedResult bool
+		expectError    bool
+	}{
+		{
+			"lookup error",
+			&mockOriginsDatabase{findAccountErr: errors.New("did not work")},
+			"account-a",
+			"https://www.offen.dev",
+			false,
+			true,
+		},
+		{
+			"allowed",
+			&mockOriginsDatabase{findAccountResult: Account{AllowedOrigins: `["https://www.offen.dev"]`}},
+			"account-a",
+			"https://www.offen.dev:443",
+			true,
+			false,
+		},
+		{
+			"not allowed",
+			&mockOriginsDatabase{findAccountResult: Account{AllowedOrigins: `["https://www.offen.dev"]`}},
+			"account-a",
+			"https://evil.example.com",
+			false,
+			false,
+		},
+		{
+			"no origins",
+			&mockOriginsDatabase{},
+			"account-a",
+			"https://www.offen.dev",
+			false,
+			false,
+		},
+		{
+			"malformed origin",
+			&mockOriginsDatabase{findAccountResult: Account{AllowedOrigins: `["https://www.offen.dev"]`}},
+			"account-a",
+			"null",
+			false,
+			false,
+		},
+		{
+			"any account",
+			&mockOriginsDatabase{findAccountsResult: []Account{
+				{AllowedOrigins: `["https://other.offen.dev"]`},
+				{AllowedOrigins: `["https://www.offen.dev"]`},
+			}},
+			"",
+			"https://www.offen.dev",
+			true,
+			false,
+		},
+		{
+			"retired account",
+			&mockOriginsDatabase{findAccountsResult: []Account{
+				{AllowedOrigins: `["https://www.offen.dev"]`, Retired: true},
+			}},
+			"",
+			"https://www.offen.dev",
+			false,
+			false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := persistenceLayer{dal: test.db}
+			result, err := p.IsOriginAllowed(test.accountID, test.origin)
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if result != test.expectedResult {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
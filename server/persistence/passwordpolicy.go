@@ -0,0 +1,114 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Feedback messages returned when a password violates the password policy.
+const (
+	FeedbackMinLength        = "Use a longer password."
+	FeedbackCharacterClasses = "Mix lowercase and uppercase letters, digits and symbols."
+	FeedbackBanned           = "Avoid words that are not allowed by your organization."
+	FeedbackReused           = "Choose a password you have not used recently."
+)
+
+// PasswordPolicy contains requirements new passwords need to meet in
+// addition to the minimum strength. The zero value does not add any
+// requirements.
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters. Passwords always need
+	// to have between 8 and 64 characters.
+	MinLength int
+	// CharacterClasses is the number of classes out of lowercase letters,
+	// uppercase letters, digits and symbols a password needs to contain.
+	CharacterClasses int
+	// Banned contains words that passwords must not contain. Matching
+	// ignores case.
+	Banned []string
+	// HistoryDepth is the number of most recent passwords, including the
+	// current one, that cannot be used again.
+	HistoryDepth int
+}
+
+// PasswordPolicyError is returned when a password does not meet the
+// requirements of the password policy. Feedback contains hints that can be
+// displayed to the user.
+type PasswordPolicyError struct {
+	Feedback []string
+}
+
+func (p *PasswordPolicyError) Error() string {
+	return fmt.Sprintf("persistence: password violates password policy: %s", strings.Join(p.Feedback, " "))
+}
+
+// WithPasswordPolicy sets the policy new passwords are checked against when
+// creating account users or changing and resetting passwords.
+func WithPasswordPolicy(policy PasswordPolicy) Config {
+	return func(p *persistenceLayer) {
+		p.passwordPolicy = policy
+	}
+}
+
+// validate checks the given password against all requirements except its
+// history, which depends on the account user.
+func (p PasswordPolicy) validate(password string) error {
+	var feedback []string
+	if len([]rune(password)) < p.MinLength {
+		feedback = append(feedback, FeedbackMinLength)
+	}
+	if p.CharacterClasses > 0 && countCharacterClasses(password) < p.CharacterClasses {
+		feedback = append(feedback, FeedbackCharacterClasses)
+	}
+	lower := strings.ToLower(password)
+	for _, word := range p.Banned {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" && strings.Contains(lower, word) {
+			feedback = append(feedback, FeedbackBanned)
+			break
+		}
+	}
+	if len(feedback) != 0 {
+		return &PasswordPolicyError{Feedback: feedback}
+	}
+	return nil
+}
+
+// checkHistory returns a PasswordPolicyError in case the given password
+// matches one of the most recent passwords of the account user.
+func (p PasswordPolicy) checkHistory(accountUser *AccountUser, password string, pepper []byte) error {
+	reused, err := accountUser.reusesPassword(password, p.HistoryDepth, pepper)
+	if err != nil {
+		return err
+	}
+	if reused {
+		return &PasswordPolicyError{Feedback: []string{FeedbackReused}}
+	}
+	return nil
+}
+
+func countCharacterClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	var count int
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
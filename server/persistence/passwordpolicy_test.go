@@ -0,0 +1,93 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/keys"
+)
+
+func TestPasswordPolicy_validate(t *testing.T) {
+	tests := []struct {
+		name             string
+		policy           PasswordPolicy
+		password         string
+		expectedFeedback []string
+	}{
+		{"zero value", PasswordPolicy{}, "develop", nil},
+		{"length ok", PasswordPolicy{MinLength: 12}, "development!", nil},
+		{"too short", PasswordPolicy{MinLength: 12}, "develop!", []string{FeedbackMinLength}},
+		{"classes ok", PasswordPolicy{CharacterClasses: 3}, "Develop1", nil},
+		{"missing classes", PasswordPolicy{CharacterClasses: 3}, "develop1", []string{FeedbackCharacterClasses}},
+		{"banned", PasswordPolicy{Banned: []string{"offen"}}, "MyOffenPassword", []string{FeedbackBanned}},
+		{"banned blank", PasswordPolicy{Banned: []string{" "}}, "my password", nil},
+		{"multiple", PasswordPolicy{MinLength: 20, CharacterClasses: 4, Banned: []string{"offen"}}, "offen", []string{FeedbackMinLength, FeedbackCharacterClasses, FeedbackBanned}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.policy.validate(test.password)
+			if test.expectedFeedback == nil {
+				if err != nil {
+					t.Errorf("Unexpected error %v", err)
+				}
+				return
+			}
+			var policyErr *PasswordPolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("Expected policy error, got %v", err)
+			}
+			if !reflect.DeepEqual(test.expectedFeedback, policyErr.Feedback) {
+				t.Errorf("Expected %v, got %v", test.expectedFeedback, policyErr.Feedback)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicy_checkHistory(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	accountUser, _ := newAccountUser("develop@offen.dev", "password-1", AccountUserAdminLevelSuperAdmin, params, nil)
+	policy := PasswordPolicy{HistoryDepth: 3}
+	for _, password := range []string{"password-2", "password-3", "password-4"} {
+		hash, _ := keys.HashPassword(password, params, nil)
+		if err := accountUser.setPassword(hash.Marshal(), policy.HistoryDepth, time.Now()); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	tests := []struct {
+		password    string
+		expectError bool
+	}{
+		{"password-1", false},
+		{"password-2", true},
+		{"password-3", true},
+		{"password-4", true},
+		{"password-5", false},
+	}
+	for _, test := range tests {
+		t.Run(test.password, func(t *testing.T) {
+			err := policy.checkHistory(accountUser, test.password, nil)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		if err := (PasswordPolicy{}).checkHistory(accountUser, "password-4", nil); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		hash, _ := keys.HashPassword("password-5", params, nil)
+		if err := accountUser.setPassword(hash.Marshal(), 0, time.Now()); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if accountUser.PasswordHistory != "" {
+			t.Errorf("Expected history to be cleared, got %v", accountUser.PasswordHistory)
+		}
+	})
+}
@@ -4,7 +4,14 @@
 package persistence
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"time"
+
+	"github.com/offen/offen/server/keys"
+	"github.com/offen/offen/server/kms"
+	"github.com/sirupsen/logrus"
 )
 
 // Service is a backend-agnostic wrapper for interacting with a persistence
@@ -12,35 +19,153 @@ import (
 // and stored.
 type Service interface {
 	Insert(userID, accountID, payload string, eventID *string) error
+	InsertBatch(userID string, events []BatchEvent) error
 	Query(Query) (EventsResult, error)
 	GetAccount(accountID string, events bool, eventsSince string) (AccountResult, error)
-	CreateAccount(name, creatorEmailAddress, creatorPassword string) error
+	CreateAccount(name, creatorEmailAddress, creatorPassword string) (AccountResult, error)
+	RenameAccount(accountID, name string) (AccountResult, error)
 	RetireAccount(accountID string) error
+	RotateAccountKeys(accountID, emailAddress, password string) error
+	RecoverAccount(accountID, emailAddress string, escrowPrivateKey []byte) error
 	AssociateUserSecret(accountID, userID, encryptedUserSecret string) error
 	Purge(userID string) error
+	EraseUser(userID string) (ErasureResult, error)
 	Login(email, password string) (LoginResult, error)
+	LoginWithSecondFactor(email, password, code string) (LoginResult, error)
+	LoginWithEmail(email, code string) (LoginResult, error)
 	LookupAccountUser(userID string) (LoginResult, error)
 	ChangePassword(userID, currentPassword, changedPassword string) error
-	ChangeEmail(userID, emailAddress, emailCurrent, password string) error
+	ChangeExpiredPassword(emailAddress, currentPassword, changedPassword, code string) error
+	ChangeEmail(userID, emailAddress, emailCurrent, password string) ([]byte, error)
+	ConfirmEmailChange(userID, emailAddress string, token []byte) error
+	AddSecondaryEmail(userID, emailAddress, currentEmailAddress, password string) ([]byte, error)
+	ConfirmSecondaryEmail(userID, emailAddress string, token []byte) error
+	ListSecondaryEmails(userID string) ([]SecondaryEmailResult, error)
+	RemoveSecondaryEmail(userID, secondaryEmailID string) error
 	GenerateOneTimeKey(emailAddress string) ([]byte, error)
-	ResetPassword(emailAddress, password string, oneTimeKey []byte) error
-	ShareAccount(inviteeEmailAddress, providerEmailAddress, providerPassword, accountID string, grantAdminPrivileges bool) (ShareAccountResult, error)
+	ResetPassword(emailAddress, password string, oneTimeKey []byte) (string, error)
+	ShareAccount(inviteeEmailAddress, providerEmailAddress, providerPassword, accountID string, grantAdminPrivileges bool, role AccountUserRole) (ShareAccountResult, error)
 	Join(emailAddress, password string) error
-	Expire(retention time.Duration) (int, error)
+	Invite(inviteeEmailAddress, providerEmailAddress, providerPassword string, accountIDs []string, role AccountUserRole) (InviteResult, error)
+	AcceptInvitation(emailAddress, password string, token []byte) error
+	SuspendAccountUser(adminUserID, accountUserID string, suspended bool) error
+	SetAllowedNetworks(adminUserID, accountUserID string, networks []string) error
+	SetAllowedOrigins(accountID string, origins []string) ([]string, error)
+	SetDomains(accountID string, domains []string) ([]string, error)
+	IsDomainAllowed(accountID, domain string) (bool, error)
+	SetPrivacySignals(accountID, handling string) error
+	LookupPrivacySignals(accountID string) (string, error)
+	SetTimezone(accountID, timezone string) error
+	SetAnomalySensitivity(accountID, sensitivity string) error
+	LookupAnomalyDetection() ([]AnomalyDetectionResult, error)
+	IsOriginAllowed(accountID, origin string) (bool, error)
+	Impersonate(adminUserID, accountUserID, ipAddress, userAgent string, ttl time.Duration) (SessionResult, error)
+	EnrollSecondFactor(userID, emailAddress, password string) (SecondFactorEnrollmentResult, error)
+	EnableSecondFactor(userID, emailAddress, code string) error
+	DisableSecondFactor(userID, emailAddress, password, code string) error
+	RegenerateRecoveryCodes(userID, emailAddress, password string) ([]string, error)
+	LookupSecondFactor(userID string) (SecondFactorResult, error)
+	RegisterWebAuthnCredential(userID, emailAddress, password string, credential WebAuthnCredentialResult, prfOutput []byte) error
+	LookupWebAuthnCredential(credentialID []byte) (WebAuthnCredentialResult, error)
+	LoginWithWebAuthn(credentialID []byte, signCount uint32, prfOutput []byte) (LoginResult, error)
+	DeleteWebAuthnCredential(userID string, credentialID []byte) error
+	CreateAccessToken(userID, name string, scopes, accountIDs []string, expires *time.Time) (AccessTokenResult, error)
+	LookupAccessToken(token string) (AccessTokenResult, error)
+	ListAccessTokens(userID string) ([]AccessTokenResult, error)
+	RevokeAccessToken(userID, tokenID string) error
+	CreateWebhook(userID, accountID, url string, events []string) (WebhookResult, error)
+	ListWebhooks(accountID string) ([]WebhookResult, error)
+	LookupWebhooks(accountID, event string) ([]WebhookResult, error)
+	DeleteWebhook(accountID, webhookID string) error
+	RecordWebhookDelivery(delivery WebhookDeliveryResult) (WebhookDeliveryResult, error)
+	ListWebhookDeliveries(accountID, webhookID string, limit int) ([]WebhookDeliveryResult, error)
+	ComputeRollups(from, until time.Time) (int, error)
+	RebuildRollups(options RebuildOptions) (RebuildProgress, error)
+	GetRollups(accountID, period string, from, until time.Time) ([]RollupResult, error)
+	UpdateRollupPayload(accountID, period string, start time.Time, encryptedPayload string) (RollupResult, error)
+	ImportRollups(accountID, source string, days []ImportedDay, dryRun bool) (ImportRollupsResult, error)
+	CreateFunnel(userID, accountID, name string, steps []FunnelStep) (FunnelResult, error)
+	ListFunnels(accountID string) ([]FunnelResult, error)
+	DeleteFunnel(accountID, funnelID string) error
+	CreateEventType(userID, accountID, name, aggregation string) (EventTypeResult, error)
+	ListEventTypes(accountID string) ([]EventTypeResult, error)
+	DeleteEventType(accountID, eventTypeID string) error
+	CreateGoal(userID, accountID, name, goalType, value string) (GoalResult, error)
+	ListGoals(accountID string) ([]GoalResult, error)
+	DeleteGoal(accountID, goalID string) error
+	SubscribeToReports(accountUserID, accountID, emailAddress, frequency string) (ReportSubscriptionResult, error)
+	ListReportSubscriptions(accountUserID string) ([]ReportSubscriptionResult, error)
+	UnsubscribeFromReports(accountUserID, accountID string) error
+	LookupReportSubscriptions(frequency string) ([]ReportSubscriptionResult, error)
+	CreateServiceAccount(userID, name string, accountIDs []string, emailAddress, password string) (ServiceAccountResult, error)
+	LookupServiceAccount(credential string) (LoginResult, error)
+	LoginServiceAccount(credential string) (LoginResult, error)
+	ListServiceAccounts(userID string) ([]ServiceAccountResult, error)
+	DeleteServiceAccount(userID, serviceAccountID string) error
+	CreateSession(userID, ipAddress, userAgent string, ttl, refreshTTL time.Duration) (SessionResult, error)
+	LookupSession(sessionID string) (SessionResult, error)
+	RefreshSession(refreshToken string, ttl, refreshTTL time.Duration) (SessionResult, error)
+	ListSessions(userID string) ([]SessionResult, error)
+	RevokeSession(userID, sessionID string) error
+	RevokeSessions(userID string) error
+	RecordAuthEvent(userID, emailAddress, eventType, ipAddress, userAgent string) error
+	ListAuthEvents(userID, before string, limit int) (AuthEventsResult, error)
+	IsNewLoginFingerprint(userID, ipAddress, userAgent string) (bool, error)
+	CreateDeviceKey(userID, emailAddress, password string) ([]byte, error)
+	LookupIdentity(email string) (LoginResult, error)
+	ProvisionIdentity(email string, adminLevel *AccountUserAdminLevel) (LoginResult, error)
+	UnlockDeviceKey(userID string, deviceKey []byte, accountIDs []string) (LoginResult, error)
+	Expire(retention time.Duration) (ExpireResult, error)
+	PurgeEvents(accountID string, before time.Time, dryRun bool) (PurgeEventsResult, error)
+	RewrapKeys(options RewrapOptions) (RewrapProgress, error)
 	Bootstrap(data BootstrapConfig) error
 	ProbeEmpty() bool
+	ProbeAccountUser(email string) bool
 	CheckHealth() error
+	CheckMigrations() error
 	Migrate() error
 }
 
 type persistenceLayer struct {
-	dal DataAccessLayer
+	dal       DataAccessLayer
+	kdfParams keys.KDFParams
+	pepper    []byte
+	// emailLookup is used for keyed hashes of email addresses, in case
+	// it is nil, all lookups compare against the argon2 hashes
+	emailLookup *keys.EmailLookup
+	// rotationKeys are used for decrypting pending key rotations that have
+	// been encrypted using a key derived from the secret, the first one is
+	// used when such rotations are replaced
+	rotationKeys     [][]byte
+	escrowKey        *keys.EscrowKey
+	passwordMinScore int
+	passwordMaxAge   time.Duration
+	passwordPolicy   PasswordPolicy
+	breachChecker    BreachChecker
+	authenticator    PasswordAuthenticator
+	logger           *logrus.Logger
+	// sampler is nil unless sampling of events has been enabled
+	sampler *sampler
+	// dummy is used for equalizing the time spent on logins of unknown users
+	dummy *dummyLogin
+	// credentials performs the argon2 operations of logins and defaults
+	// to argon2Hasher when nil
+	credentials credentialHasher
+	// ctx is set on services returned by WithContext and used as the parent
+	// of spans
+	ctx context.Context
+}
+
+type dummyLogin struct {
+	once sync.Once
+	hash string
+	salt string
 }
 
 // New creates a persistence service that connects to any database using
 // the given access layer.
 func New(dal DataAccessLayer, configs ...Config) (Service, error) {
-	db := persistenceLayer{dal: dal}
+	db := persistenceLayer{dal: dal, dummy: &dummyLogin{}}
 	for _, config := range configs {
 		config(&db)
 	}
@@ -49,3 +174,207 @@ func New(dal DataAccessLayer, configs ...Config) (Service, error) {
 
 // Config is a function that adds a configuration option to the constructor
 type Config func(*persistenceLayer)
+
+// WithKDFParams sets the parameters used for hashing and deriving keys from
+// credentials of account users. Existing credentials that have been created
+// using different parameters will be upgraded on login.
+func WithKDFParams(params keys.KDFParams) Config {
+	return func(p *persistenceLayer) {
+		p.kdfParams = params
+	}
+}
+
+// WithEmailLookup sets the keyed hash used for looking up account users by
+// their email address. Account users whose lookup hash has been created
+// using a different key are migrated to the current key on login.
+func WithEmailLookup(lookup *keys.EmailLookup) Config {
+	return func(p *persistenceLayer) {
+		p.emailLookup = lookup
+	}
+}
+
+// WithRotationSecret sets the secret the key used for pending key rotations
+// that have not been encrypted using the previous key encryption key is
+// derived from. Keys derived from the previous secrets are still used for
+// decrypting such rotations.
+func WithRotationSecret(secret []byte, previous ...[]byte) Config {
+	return func(p *persistenceLayer) {
+		for _, s := range append([][]byte{secret}, previous...) {
+			if key, err := keys.DeriveRotationKey(s); err == nil {
+				p.rotationKeys = append(p.rotationKeys, key)
+			}
+		}
+	}
+}
+
+// WithEscrowKey enables key escrow. The key encryption key of each account
+// is additionally wrapped using the given public key when the account is
+// created or its key is rotated. Existing accounts are escrowed on the next
+// login of any of their users.
+func WithEscrowKey(escrowKey *keys.EscrowKey) Config {
+	return func(p *persistenceLayer) {
+		p.escrowKey = escrowKey
+	}
+}
+
+// WithPasswordMinScore sets the minimum estimated strength score (0-4) new
+// passwords are required to have. Passwords that are too weak are rejected
+// with a *keys.PasswordStrengthError.
+func WithPasswordMinScore(score int) Config {
+	return func(p *persistenceLayer) {
+		p.passwordMinScore = score
+	}
+}
+
+// WithPasswordMaxAge requires account users to change their password after
+// the given duration has passed since setting it. Passing zero disables
+// expiry. Passwords verified by an external directory do not expire.
+func WithPasswordMaxAge(maxAge time.Duration) Config {
+	return func(p *persistenceLayer) {
+		p.passwordMaxAge = maxAge
+	}
+}
+
+// passwordExpired checks whether the password of the given account user is
+// older than the configured maximum age.
+func (p *persistenceLayer) passwordExpired(accountUser *AccountUser, now time.Time) bool {
+	if p.passwordMaxAge <= 0 || p.authenticator != nil || accountUser.PasswordChanged == nil {
+		return false
+	}
+	return now.After(accountUser.PasswordChanged.Add(p.passwordMaxAge))
+}
+
+// validatePassword checks the given password against the password policy
+// and the configured minimum strength.
+func (p *persistenceLayer) validatePassword(password string, userInputs ...string) error {
+	if err := keys.ValidatePassword(password); err != nil {
+		return err
+	}
+	if err := p.passwordPolicy.validate(password); err != nil {
+		return err
+	}
+	return keys.ValidatePasswordStrength(password, p.passwordMinScore, userInputs...)
+}
+
+// BreachChecker is used to check whether a password is known from a data
+// breach.
+type BreachChecker interface {
+	Pwned(password string) (bool, error)
+}
+
+// WithLogger sets the logger used for reporting errors that do not cause
+// an operation to fail.
+func WithLogger(logger *logrus.Logger) Config {
+	return func(p *persistenceLayer) {
+		p.logger = logger
+	}
+}
+
+// WithBreachChecker rejects new passwords that are known from data breaches
+// when changing or resetting passwords. In case the checker fails, the
+// password is accepted. Passing nil is a no-op.
+func WithBreachChecker(checker BreachChecker) Config {
+	return func(p *persistenceLayer) {
+		p.breachChecker = checker
+	}
+}
+
+// checkBreached returns ErrPasswordBreached in case the configured breach
+// checker reports the given password. Errors of the checker itself are
+// ignored so an unreachable service does not lock out users. Passwords
+// managed by a directory are not checked as they cannot be chosen here.
+func (p *persistenceLayer) checkBreached(password string) error {
+	if p.breachChecker == nil || p.authenticator != nil {
+		return nil
+	}
+	pwned, err := p.breachChecker.Pwned(password)
+	if err != nil {
+		if p.logger != nil {
+			p.logger.WithError(err).Warn("Error checking password against breached passwords, accepting password")
+		}
+		return nil
+	}
+	if pwned {
+		return ErrPasswordBreached
+	}
+	return nil
+}
+
+// PasswordAuthenticator verifies the password of an account user against an
+// external directory.
+type PasswordAuthenticator interface {
+	Authenticate(email, password string) (bool, error)
+}
+
+// WithPasswordAuthenticator makes the given authenticator the source of truth
+// for passwords of account users. Key encryption keys are still wrapped
+// using a key derived from the password, which means the password stored
+// locally needs to match the one known to the directory. Passing nil is a
+// no-op.
+func WithPasswordAuthenticator(authenticator PasswordAuthenticator) Config {
+	return func(p *persistenceLayer) {
+		p.authenticator = authenticator
+	}
+}
+
+// verifyPassword checks the given password of the account user. In case an
+// authenticator is configured, the password needs to be accepted by the
+// directory first.
+func (p *persistenceLayer) verifyPassword(accountUser *AccountUser, email, password string) error {
+	if p.authenticator == nil {
+		if err := p.hasher().ComparePassword(password, accountUser.HashedPassword, p.pepper); err != nil {
+			return ErrInvalidCredentials
+		}
+		return nil
+	}
+	ok, err := p.authenticator.Authenticate(email, password)
+	if err != nil {
+		return fmt.Errorf("persistence: error authenticating with directory: %w", err)
+	}
+	if !ok {
+		return ErrInvalidCredentials
+	}
+	// the directory has accepted the password, but the key encryption keys
+	// can only be unwrapped in case it has not been changed since
+	if err := p.hasher().ComparePassword(password, accountUser.HashedPassword, p.pepper); err != nil {
+		return ErrPasswordOutOfSync
+	}
+	return nil
+}
+
+// validateNewPassword checks a password an account user wants to use from
+// now on. Passwords managed by a directory need to be accepted by it and are
+// not subject to the local password policy.
+func (p *persistenceLayer) validateNewPassword(email, password string) error {
+	if p.authenticator != nil {
+		ok, err := p.authenticator.Authenticate(email, password)
+		if err != nil {
+			return fmt.Errorf("persistence: error authenticating with directory: %w", err)
+		}
+		if !ok {
+			return ErrInvalidCredentials
+		}
+		return nil
+	}
+	return p.validatePassword(password, email)
+}
+
+// WithPepper sets a secret value that is mixed into all password hashes. It
+// needs to be stored separately from the database. Existing hashes that have
+// been created without a pepper are upgraded on login.
+func WithPepper(pepper []byte) Config {
+	return func(p *persistenceLayer) {
+		p.pepper = pepper
+	}
+}
+
+// WithKMSProvider additionally wraps all stored key encryption key envelopes
+// using the given provider. Existing envelopes are wrapped the next time they
+// are written. Passing nil is a no-op.
+func WithKMSProvider(provider kms.Provider) Config {
+	return func(p *persistenceLayer) {
+		if provider != nil {
+			p.dal = newKMSDAL(p.dal, provider)
+		}
+	}
+}
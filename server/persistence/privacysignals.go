@@ -0,0 +1,71 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"fmt"
+)
+
+// Ways of handling events sent by browsers that signal Do Not Track or
+// Global Privacy Control.
+const (
+	// PrivacySignalsIgnore collects events regardless of the signal, as
+	// users have opted in explicitly before any data is collected.
+	PrivacySignalsIgnore = "ignore"
+	// PrivacySignalsOptOut drops events as if the user had opted out.
+	PrivacySignalsOptOut = "opt_out"
+	// PrivacySignalsAnonymous collects events anonymously, i.e. without
+	// linking them to the user and without keeping their payload.
+	PrivacySignalsAnonymous = "anonymous"
+)
+
+// PrivacySignalHandlings contains all supported ways of handling privacy
+// signals.
+var PrivacySignalHandlings = []string{
+	PrivacySignalsIgnore,
+	PrivacySignalsOptOut,
+	PrivacySignalsAnonymous,
+}
+
+func (a *Account) privacySignals() string {
+	if a.PrivacySignals == "" {
+		return PrivacySignalsIgnore
+	}
+	return a.PrivacySignals
+}
+
+// SetPrivacySignals sets how events sent by browsers signaling Do Not Track
+// or Global Privacy Control are handled for the account with the given id.
+func (p *persistenceLayer) SetPrivacySignals(accountID, handling string) error {
+	var valid bool
+	for _, h := range PrivacySignalHandlings {
+		if h == handling {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("persistence: unknown handling of privacy signals %q", handling)
+	}
+	account, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID))
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+	}
+	account.PrivacySignals = handling
+	if err := p.dal.UpdateAccount(&account); err != nil {
+		return fmt.Errorf("persistence: error updating account: %w", err)
+	}
+	return nil
+}
+
+// LookupPrivacySignals returns how events sent by browsers signaling Do Not
+// Track or Global Privacy Control are handled for the account with the
+// given id.
+func (p *persistenceLayer) LookupPrivacySignals(accountID string) (string, error) {
+	account, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID))
+	if err != nil {
+		return "", fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+	}
+	return account.privacySignals(), nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPersistenceLayer_SetPrivacySignals(t *testing.T) {
+	tests := []struct {
+		name        string
+		db          *mockOriginsDatabase
+		handling    string
+		expectError bool
+	}{
+		{"unknown handling", &mockOriginsDatabase{}, "respect", true},
+		{"lookup error", &mockOriginsDatabase{findAccountErr: ErrUnknownAccount("did not work")}, PrivacySignalsOptOut, true},
+		{"update error", &mockOriginsDatabase{updateErr: errors.New("did not work")}, PrivacySignalsOptOut, true},
+		{"ok", &mockOriginsDatabase{}, PrivacySignalsAnonymous, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := persistenceLayer{dal: test.db}
+			err := p.SetPrivacySignals("account-a", test.handling)
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if err == nil && test.db.updated.PrivacySignals != test.handling {
+				t.Errorf("Expected stored value %v, got %v", test.handling, test.db.updated.PrivacySignals)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_LookupPrivacySignals(t *testing.T) {
+	tests := []struct {
+		name           string
+		db             *mockOriginsDatabase
+		expectedResult string
+		expectError    bool
+	}{
+		{"default", &mockOriginsDatabase{}, PrivacySignalsIgnore, false},
+		{"set", &mockOriginsDatabase{findAccountResult: Account{PrivacySignals: PrivacySignalsOptOut}}, PrivacySignalsOptOut, false},
+		{"lookup error", &mockOriginsDatabase{findAccountErr: ErrUnknownAccount("did not work")}, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := persistenceLayer{dal: test.db}
+			result, err := p.LookupPrivacySignals("account-a")
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if result != test.expectedResult {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
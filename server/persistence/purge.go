@@ -0,0 +1,94 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// PurgeEvents deletes all events of the account with the given id that are
+// older than the given time, leaving tombstones the same way expiring events
+// does. Retired accounts can be purged too. Rollups are kept, as they are
+// when events expire. In case dryRun is set, matching events are reported
+// but not deleted.
+func (p *persistenceLayer) PurgeEvents(accountID string, before time.Time, dryRun bool) (PurgeEventsResult, error) {
+	result := PurgeEventsResult{AccountID: accountID, Before: before, DryRun: dryRun}
+	if _, err := p.dal.FindAccount(FindAccountQueryByID(accountID)); err != nil {
+		return result, fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+	}
+	deadline, err := EventIDAt(before)
+	if err != nil {
+		return result, fmt.Errorf("persistence: error determining deadline for purging events: %w", err)
+	}
+
+	txn, err := p.dal.Transaction()
+	if err != nil {
+		return result, fmt.Errorf("persistence: error creating transaction: %w", err)
+	}
+	events, err := txn.FindEvents(FindEventsQueryByAccountID{
+		AccountID: accountID,
+		Until:     deadline,
+	})
+	if err != nil {
+		txn.Rollback()
+		return result, fmt.Errorf("persistence: error looking up events to purge: %w", err)
+	}
+
+	users := map[string]bool{}
+	for _, evt := range events {
+		if evt.SecretID != nil {
+			users[*evt.SecretID] = true
+		}
+		id, err := ulid.Parse(evt.EventID)
+		if err != nil {
+			continue
+		}
+		t := ulid.Time(id.Time()).UTC()
+		if result.Oldest == nil || t.Before(*result.Oldest) {
+			result.Oldest = &t
+		}
+		if result.Newest == nil || t.After(*result.Newest) {
+			result.Newest = &t
+		}
+	}
+	result.Events = len(events)
+	result.Users = len(users)
+	if dryRun || len(events) == 0 {
+		txn.Rollback()
+		return result, nil
+	}
+
+	sequence, err := NewULID()
+	if err != nil {
+		txn.Rollback()
+		return result, fmt.Errorf("persistence: error creating sequence number: %w", err)
+	}
+	for _, evt := range events {
+		if err := txn.CreateTombstone(&Tombstone{
+			AccountID: evt.AccountID,
+			EventID:   evt.EventID,
+			SecretID:  evt.SecretID,
+			Sequence:  sequence,
+		}); err != nil {
+			txn.Rollback()
+			return result, fmt.Errorf("persistence: error creating tombstone: %w", err)
+		}
+	}
+	affected, err := txn.DeleteEvents(DeleteEventsQueryByAccountIDOlderThan{
+		AccountID: accountID,
+		Deadline:  deadline,
+	})
+	if err != nil {
+		txn.Rollback()
+		return result, fmt.Errorf("persistence: error deleting events: %w", err)
+	}
+	if err := txn.Commit(); err != nil {
+		return result, fmt.Errorf("persistence: error committing purge of events: %w", err)
+	}
+	result.Events = int(affected)
+	return result, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+type mockPurgeAccountEventsDatabase struct {
+	mockExpireDatabase
+	findAccountErr error
+	tombstones     int
+	deleted        interface{}
+	committed      bool
+}
+
+func (m *mockPurgeAccountEventsDatabase) FindAccount(q interface{}) (Account, error) {
+	return Account{AccountID: "account-a"}, m.findAccountErr
+}
+
+func (m *mockPurgeAccountEventsDatabase) CreateTombstone(*Tombstone) error {
+	m.tombstones++
+	return m.err
+}
+
+func (m *mockPurgeAccountEventsDatabase) DeleteEvents(q interface{}) (int64, error) {
+	m.deleted = q
+	return int64(len(m.events)), m.err
+}
+
+func (m *mockPurgeAccountEventsDatabase) Commit() error {
+	m.committed = true
+	return nil
+}
+
+func (m *mockPurgeAccountEventsDatabase) Transaction() (Transaction, error) {
+	return m, nil
+}
+
+func TestPersistenceLayer_PurgeEvents(t *testing.T) {
+	before := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	user := func(s string) *string { return &s }
+	events := []Event{
+		eventAt("account-a", before.Add(-time.Hour*48), user("user-a")),
+		eventAt("account-a", before.Add(-time.Hour*24), user("user-b")),
+		eventAt("account-a", before.Add(-time.Hour), user("user-a")),
+		eventAt("account-a", before.Add(-time.Minute), nil),
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		db := &mockPurgeAccountEventsDatabase{mockExpireDatabase: mockExpireDatabase{events: events}}
+		result, err := (&persistenceLayer{dal: db}).PurgeEvents("account-a", before, true)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if result.Events != 4 || result.Users != 2 || !result.DryRun {
+			t.Errorf("Unexpected result %v", result)
+		}
+		if !result.Oldest.Equal(before.Add(-time.Hour*48)) || !result.Newest.Equal(before.Add(-time.Minute)) {
+			t.Errorf("Unexpected range %v %v", result.Oldest, result.Newest)
+		}
+		if db.tombstones != 0 || db.deleted != nil || db.committed {
+			t.Error("Expected dry run not to delete events")
+		}
+	})
+	t.Run("ok", func(t *testing.T) {
+		db := &mockPurgeAccountEventsDatabase{mockExpireDatabase: mockExpireDatabase{events: events}}
+		result, err := (&persistenceLayer{dal: db}).PurgeEvents("account-a", before, false)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if result.Events != 4 || result.DryRun {
+			t.Errorf("Unexpected result %v", result)
+		}
+		query, ok := db.deleted.(DeleteEventsQueryByAccountIDOlderThan)
+		if !ok || query.AccountID != "account-a" || db.tombstones != 4 || !db.committed {
+			t.Fatalf("Unexpected deletion %v %v", db.tombstones, db.deleted)
+		}
+		if deadline, err := ulid.Parse(query.Deadline); err != nil || !ulid.Time(deadline.Time()).Equal(before) {
+			t.Errorf("Unexpected deadline %v", query.Deadline)
+		}
+	})
+	t.Run("no events", func(t *testing.T) {
+		db := &mockPurgeAccountEventsDatabase{}
+		result, err := (&persistenceLayer{dal: db}).PurgeEvents("account-a", before, false)
+		if err != nil || result.Events != 0 || result.Oldest != nil {
+			t.Errorf("Unexpected result %v %v", result, err)
+		}
+		if db.committed {
+			t.Error("Expected nothing to be committed")
+		}
+	})
+	t.Run("unknown account", func(t *testing.T) {
+		db := &mockPurgeAccountEventsDatabase{findAccountErr: ErrUnknownAccount("did not work")}
+		if _, err := (&persistenceLayer{dal: db}).PurgeEvents("account-z", before, false); err == nil {
+			t.Error("Expected error, got nil")
+		}
+	})
+	t.Run("database error", func(t *testing.T) {
+		db := &mockPurgeAccountEventsDatabase{mockExpireDatabase: mockExpireDatabase{err: errors.New("did not work")}}
+		if _, err := (&persistenceLayer{dal: db}).PurgeEvents("account-a", before, false); err == nil {
+			t.Error("Expected error, got nil")
+		}
+	})
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RebuildOptions configures a run of RebuildRollups.
+type RebuildOptions struct {
+	// AccountID limits the run to a single account. Otherwise, all accounts
+	// that are not retired are processed.
+	AccountID string
+	From      time.Time
+	Until     time.Time
+	// After can be set to the cursor of a previous, unfinished run in order
+	// to resume it.
+	After string
+	// Pause is waited for in between days in order to limit the load on
+	// the database.
+	Pause    time.Duration
+	Progress func(RebuildProgress)
+	// Done can be closed for stopping the run before the next day is
+	// processed. RebuildRollups then returns ErrRebuildInterrupted alongside
+	// the progress that can be used for resuming.
+	Done <-chan struct{}
+}
+
+// ErrRebuildInterrupted is returned when a run of RebuildRollups has been
+// stopped before all days have been processed.
+var ErrRebuildInterrupted = errors.New("persistence: rebuilding rollups has been interrupted")
+
+// RebuildProgress reports the state of a run of RebuildRollups. Cursor
+// identifies the last day that has been processed.
+type RebuildProgress struct {
+	Cursor    string
+	AccountID string
+	Day       time.Time
+	Days      int
+	Rollups   int
+}
+
+// rebuildCursor formats the cursor for the given account and day. Days use
+// the time zone of the account.
+func rebuildCursor(accountID string, day time.Time) string {
+	return fmt.Sprintf("%s/%s", accountID, day.Format("2006-01-02"))
+}
+
+func parseRebuildCursor(cursor string) (string, time.Time, error) {
+	parts := strings.SplitN(cursor, "/", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", time.Time{}, fmt.Errorf("persistence: malformed cursor %q", cursor)
+	}
+	day, err := time.Parse("2006-01-02", parts[1])
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("persistence: malformed cursor %q: %w", cursor, err)
+	}
+	return parts[0], day, nil
+}
+
+// RebuildRollups computes the rollups of all days in the given range again
+// from the stored events, e.g. after the way events are aggregated has
+// changed. Accounts are processed one by one, each in the order of its days,
+// and each day is saved on its own. Encrypted payloads and imported rollups
+// are kept the same way they are when computing rollups. Days of which
+// events have expired already are reset to empty rollups.
+func (p *persistenceLayer) RebuildRollups(options RebuildOptions) (RebuildProgress, error) {
+	progress := RebuildProgress{Cursor: options.After}
+	if options.Until.IsZero() {
+		options.Until = time.Now()
+	}
+	if !options.From.Before(options.Until) {
+		return progress, fmt.Errorf("persistence: expected range start %v to be before its end %v", options.From, options.Until)
+	}
+	var afterAccountID string
+	var afterDay time.Time
+	if options.After != "" {
+		var err error
+		if afterAccountID, afterDay, err = parseRebuildCursor(options.After); err != nil {
+			return progress, err
+		}
+	}
+
+	var accounts []Account
+	if options.AccountID != "" {
+		account, err := p.dal.FindAccount(FindAccountQueryByID(options.AccountID))
+		if err != nil {
+			return progress, fmt.Errorf("persistence: error looking up account %s: %w", options.AccountID, err)
+		}
+		accounts = append(accounts, account)
+	} else {
+		all, err := p.dal.FindAccounts(FindAccountsQueryAllAccounts{})
+		if err != nil {
+			return progress, fmt.Errorf("persistence: error looking up accounts: %w", err)
+		}
+		for _, account := range all {
+			if !account.Retired {
+				accounts = append(accounts, account)
+			}
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].AccountID < accounts[j].AccountID
+	})
+
+	for _, account := range accounts {
+		if account.AccountID < afterAccountID {
+			continue
+		}
+		loc, err := account.location()
+		if err != nil {
+			return progress, fmt.Errorf("persistence: error loading time zone of account %s: %w", account.AccountID, err)
+		}
+		day := startOfPeriod(options.From, RollupPeriodDay, loc)
+		if account.AccountID == afterAccountID {
+			resumeAt := time.Date(afterDay.Year(), afterDay.Month(), afterDay.Day()+1, 0, 0, 0, 0, loc)
+			if resumeAt.After(day) {
+				day = resumeAt
+			}
+		}
+		end := startOfPeriod(options.Until, RollupPeriodHour, loc)
+		for day.Before(end) {
+			select {
+			case <-options.Done:
+				return progress, ErrRebuildInterrupted
+			default:
+			}
+			next := nextPeriod(day, RollupPeriodDay)
+			until := next
+			if until.After(end) {
+				until = end
+			}
+			rollups, err := p.computeAccountRollups(account, day, until, time.Now())
+			if err != nil {
+				return progress, err
+			}
+			saved, err := p.saveRollups(rollups)
+			if err != nil {
+				return progress, err
+			}
+			progress.Cursor = rebuildCursor(account.AccountID, day)
+			progress.AccountID = account.AccountID
+			progress.Day = day
+			progress.Days++
+			progress.Rollups += saved
+			if options.Progress != nil {
+				options.Progress(progress)
+			}
+			day = next
+			if options.Pause > 0 && day.Before(end) {
+				select {
+				case <-options.Done:
+				case <-time.After(options.Pause):
+				}
+			}
+		}
+	}
+	return progress, nil
+}
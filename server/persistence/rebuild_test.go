@@ -0,0 +1,117 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPersistenceLayer_RebuildRollups(t *testing.T) {
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	user := func(s string) *string { return &s }
+	createDB := func() *mockTimezonesDatabase {
+		return &mockTimezonesDatabase{mockRollupsDatabase: mockRollupsDatabase{
+			accounts: []Account{
+				{AccountID: "account-b"},
+				{AccountID: "account-a"},
+				{AccountID: "account-z", Retired: true},
+			},
+			events: []Event{
+				eventAt("account-a", day.Add(time.Minute), user("user-a")),
+				eventAt("account-a", day.Add(time.Hour*25), user("user-a")),
+				eventAt("account-b", day.Add(time.Hour*26), nil),
+				eventAt("account-z", day, user("user-z")),
+			},
+		}}
+	}
+	closed := make(chan struct{})
+	close(closed)
+	tests := []struct {
+		name             string
+		db               *mockTimezonesDatabase
+		options          RebuildOptions
+		expectError      bool
+		expectedProgress RebuildProgress
+		expectedCursors  []string
+	}{
+		{
+			"bad range",
+			createDB(),
+			RebuildOptions{From: day, Until: day},
+			true,
+			RebuildProgress{},
+			nil,
+		},
+		{
+			"bad cursor",
+			createDB(),
+			RebuildOptions{From: day, Until: day.AddDate(0, 0, 2), After: "account-a"},
+			true,
+			RebuildProgress{Cursor: "account-a"},
+			nil,
+		},
+		{
+			"database error",
+			&mockTimezonesDatabase{mockRollupsDatabase: mockRollupsDatabase{err: errors.New("did not work")}},
+			RebuildOptions{From: day, Until: day.AddDate(0, 0, 2)},
+			true,
+			RebuildProgress{},
+			nil,
+		},
+		{
+			"all accounts",
+			createDB(),
+			RebuildOptions{From: day, Until: day.AddDate(0, 0, 2)},
+			false,
+			RebuildProgress{Cursor: "account-b/2020-03-02", AccountID: "account-b", Day: day.AddDate(0, 0, 1), Days: 4, Rollups: 6},
+			[]string{"account-a/2020-03-01", "account-a/2020-03-02", "account-b/2020-03-01", "account-b/2020-03-02"},
+		},
+		{
+			"resume",
+			createDB(),
+			RebuildOptions{From: day, Until: day.AddDate(0, 0, 2), After: "account-a/2020-03-01"},
+			false,
+			RebuildProgress{Cursor: "account-b/2020-03-02", AccountID: "account-b", Day: day.AddDate(0, 0, 1), Days: 3, Rollups: 4},
+			[]string{"account-a/2020-03-02", "account-b/2020-03-01", "account-b/2020-03-02"},
+		},
+		{
+			"single account",
+			createDB(),
+			RebuildOptions{AccountID: "account-b", From: day.Add(time.Hour), Until: day.AddDate(0, 0, 1).Add(time.Hour * 3)},
+			false,
+			RebuildProgress{Cursor: "account-b/2020-03-02", AccountID: "account-b", Day: day.AddDate(0, 0, 1), Days: 2, Rollups: 1},
+			[]string{"account-b/2020-03-01", "account-b/2020-03-02"},
+		},
+		{
+			"interrupted",
+			createDB(),
+			RebuildOptions{From: day, Until: day.AddDate(0, 0, 2), After: "account-a/2020-03-01", Done: closed},
+			true,
+			RebuildProgress{Cursor: "account-a/2020-03-01"},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var cursors []string
+			test.options.Progress = func(p RebuildProgress) {
+				cursors = append(cursors, p.Cursor)
+			}
+			p := &persistenceLayer{dal: test.db}
+			progress, err := p.RebuildRollups(test.options)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if !reflect.DeepEqual(test.expectedProgress, progress) {
+				t.Errorf("Expected progress %v, got %v", test.expectedProgress, progress)
+			}
+			if !reflect.DeepEqual(test.expectedCursors, cursors) {
+				t.Errorf("Expected cursors %v, got %v", test.expectedCursors, cursors)
+			}
+		})
+	}
+}
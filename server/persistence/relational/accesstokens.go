@@ -0,0 +1,63 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"fmt"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func (r *relationalDAL) CreateAccessToken(a *persistence.AccessToken) error {
+	local := importAccessToken(a)
+	if err := r.db.Create(&local).Error; err != nil {
+		return fmt.Errorf("relational: error creating access token: %w", err)
+	}
+	return nil
+}
+
+func (r *relationalDAL) FindAccessToken(q interface{}) (persistence.AccessToken, error) {
+	var token AccessToken
+	switch query := q.(type) {
+	case persistence.FindAccessTokenQueryByID:
+		if err := r.db.Where("token_id = ?", string(query)).First(&token).Error; err != nil {
+			return token.export(), fmt.Errorf("relational: error looking up access token: %w", err)
+		}
+		return token.export(), nil
+	default:
+		return token.export(), persistence.ErrBadQuery
+	}
+}
+
+func (r *relationalDAL) FindAccessTokens(q interface{}) ([]persistence.AccessToken, error) {
+	var tokens []AccessToken
+	switch query := q.(type) {
+	case persistence.FindAccessTokensQueryByAccountUserID:
+		if err := r.db.Where("account_user_id = ?", string(query)).Order("created DESC").Find(&tokens).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up access tokens: %w", err)
+		}
+		var result []persistence.AccessToken
+		for _, token := range tokens {
+			result = append(result, token.export())
+		}
+		return result, nil
+	default:
+		return nil, persistence.ErrBadQuery
+	}
+}
+
+func (r *relationalDAL) DeleteAccessTokens(q interface{}) error {
+	switch query := q.(type) {
+	case persistence.DeleteAccessTokensQueryByID:
+		if err := r.db.Where(
+			"token_id = ? AND account_user_id = ?",
+			query.TokenID, query.AccountUserID,
+		).Delete(&AccessToken{}).Error; err != nil {
+			return fmt.Errorf("relational: error deleting access token: %w", err)
+		}
+		return nil
+	default:
+		return persistence.ErrBadQuery
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestRelationalDAL_AccessTokens(t *testing.T) {
+	db, closeDB := createTestDatabase()
+	defer closeDB()
+	dal := NewRelationalDAL(db)
+
+	now := time.Now()
+	for _, token := range []persistence.AccessToken{
+		{TokenID: "token-a", AccountUserID: "user-a", Scopes: []string{"read"}, Created: now.Add(-time.Hour)},
+		{TokenID: "token-b", AccountUserID: "user-a", Scopes: []string{"read", "write"}, AccountIDs: []string{"account-a", "account-b"}, Created: now},
+		{TokenID: "token-c", AccountUserID: "user-b", Scopes: []string{"read"}, Created: now},
+	} {
+		if err := dal.CreateAccessToken(&token); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	if _, err := dal.FindAccessToken(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+	token, err := dal.FindAccessToken(persistence.FindAccessTokenQueryByID("token-b"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(token.Scopes, []string{"read", "write"}) || !reflect.DeepEqual(token.AccountIDs, []string{"account-a", "account-b"}) {
+		t.Errorf("Unexpected token %v", token)
+	}
+
+	tokens, err := dal.FindAccessTokens(persistence.FindAccessTokensQueryByAccountUserID("user-a"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(tokens) != 2 || tokens[0].TokenID != "token-b" {
+		t.Errorf("Unexpected tokens %v", tokens)
+	}
+
+	// tokens of other account users are not deleted
+	if err := dal.DeleteAccessTokens(persistence.DeleteAccessTokensQueryByID{
+		TokenID: "token-c", AccountUserID: "user-a",
+	}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, err := dal.FindAccessToken(persistence.FindAccessTokenQueryByID("token-c")); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := dal.DeleteAccessTokens(persistence.DeleteAccessTokensQueryByID{
+		TokenID: "token-a", AccountUserID: "user-a",
+	}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, err := dal.FindAccessToken(persistence.FindAccessTokenQueryByID("token-a")); err == nil {
+		t.Error("Expected token to be deleted")
+	}
+}
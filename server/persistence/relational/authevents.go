@@ -0,0 +1,50 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"fmt"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func (r *relationalDAL) CreateAuthEvent(a *persistence.AuthEvent) error {
+	local := importAuthEvent(a)
+	if err := r.db.Create(&local).Error; err != nil {
+		return fmt.Errorf("relational: error creating auth event: %w", err)
+	}
+	return nil
+}
+
+func (r *relationalDAL) FindAuthEvents(q interface{}) ([]persistence.AuthEvent, error) {
+	var events []AuthEvent
+	switch query := q.(type) {
+	case persistence.FindAuthEventsQueryByAccountUserID:
+		db := r.db.Where("account_user_id = ?", query.AccountUserID)
+		if query.Before != "" {
+			db = db.Where("event_id < ?", query.Before)
+		}
+		if err := db.Order("event_id DESC").Limit(query.Limit).Find(&events).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up auth events: %w", err)
+		}
+	case persistence.FindAuthEventsQueryByType:
+		db := r.db.Where("account_user_id = ? AND type = ?", query.AccountUserID, query.Type)
+		if query.IPAddress != "" {
+			db = db.Where("ip_address = ?", query.IPAddress)
+		}
+		if query.UserAgent != "" {
+			db = db.Where("user_agent = ?", query.UserAgent)
+		}
+		if err := db.Order("event_id DESC").Limit(query.Limit).Find(&events).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up auth events: %w", err)
+		}
+	default:
+		return nil, persistence.ErrBadQuery
+	}
+	var result []persistence.AuthEvent
+	for _, event := range events {
+		result = append(result, event.export())
+	}
+	return result, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestRelationalDAL_AuthEvents(t *testing.T) {
+	db, closeDB := createTestDatabase()
+	defer closeDB()
+	dal := NewRelationalDAL(db)
+
+	now := time.Now()
+	for _, event := range []persistence.AuthEvent{
+		{EventID: "event-a", AccountUserID: "user-a", Type: "login_failed", Created: now},
+		{EventID: "event-b", AccountUserID: "user-a", Type: "login_succeeded", Created: now},
+		{EventID: "event-c", AccountUserID: "user-b", Type: "login_succeeded", Created: now},
+		{EventID: "event-d", AccountUserID: "user-a", Type: "login_succeeded", IPAddress: "127.0.0.1", Created: now},
+	} {
+		if err := dal.CreateAuthEvent(&event); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	if _, err := dal.FindAuthEvents(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+
+	events, err := dal.FindAuthEvents(persistence.FindAuthEventsQueryByAccountUserID{
+		AccountUserID: "user-a",
+		Limit:         2,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(events) != 2 || events[0].EventID != "event-d" || events[0].IPAddress != "127.0.0.1" || events[1].EventID != "event-b" {
+		t.Errorf("Unexpected events %v", events)
+	}
+
+	events, err = dal.FindAuthEvents(persistence.FindAuthEventsQueryByAccountUserID{
+		AccountUserID: "user-a",
+		Before:        "event-b",
+		Limit:         2,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(events) != 1 || events[0].EventID != "event-a" {
+		t.Errorf("Unexpected events %v", events)
+	}
+
+	events, err = dal.FindAuthEvents(persistence.FindAuthEventsQueryByType{
+		AccountUserID: "user-a",
+		Type:          "login_succeeded",
+		IPAddress:     "127.0.0.1",
+		Limit:         1,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(events) != 1 || events[0].EventID != "event-d" {
+		t.Errorf("Unexpected events %v", events)
+	}
+
+	events, err = dal.FindAuthEvents(persistence.FindAuthEventsQueryByType{
+		AccountUserID: "user-a",
+		Type:          "login_succeeded",
+		IPAddress:     "10.0.0.1",
+		Limit:         1,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Unexpected events %v", events)
+	}
+}
@@ -34,23 +34,34 @@ func (r *relationalDAL) FindEvents(q interface{}) ([]persistence.Event, error) {
 		}
 		return exportEvents(events), nil
 	case persistence.FindEventsQueryForSecretIDs:
-		var eventConditions []interface{}
+		queryDB := r.db.Where("secret_id in (?)", query.SecretIDs)
 		if query.Since != "" {
-			eventConditions = []interface{}{
-				"sequence > ? AND secret_id in (?)",
-				query.Since,
-				query.SecretIDs,
-			}
-		} else {
-			eventConditions = []interface{}{
-				"secret_id in (?)", query.SecretIDs,
-			}
+			queryDB = queryDB.Where("sequence > ?", query.Since)
 		}
-
-		if err := r.db.Find(&events, eventConditions...).Error; err != nil {
+		if query.After != "" {
+			queryDB = queryDB.Where("event_id > ?", query.After)
+		}
+		if query.Until != "" {
+			queryDB = queryDB.Where("event_id < ?", query.Until)
+		}
+		if len(query.AccountIDs) != 0 {
+			queryDB = queryDB.Where("account_id in (?)", query.AccountIDs)
+		}
+		if query.Limit > 0 {
+			queryDB = queryDB.Order("event_id").Limit(query.Limit)
+		}
+		if err := queryDB.Find(&events).Error; err != nil {
 			return nil, fmt.Errorf("default: error looking up events: %w", err)
 		}
 		return exportEvents(events), nil
+	case persistence.FindEventsQueryByAccountID:
+		if err := r.db.Where(
+			"account_id = ? AND event_id >= ? AND event_id < ?",
+			query.AccountID, query.From, query.Until,
+		).Find(&events).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up events by account id: %w", err)
+		}
+		return exportEvents(events), nil
 	case persistence.FindEventsQueryByEventIDs:
 		var limit int64 = 500
 		var offset int64
@@ -100,6 +111,15 @@ func (r *relationalDAL) DeleteEvents(q interface{}) (int64, error) {
 			return 0, fmt.Errorf("relational: error deleting events: %w", err)
 		}
 		return deletion.RowsAffected, nil
+	case persistence.DeleteEventsQueryByAccountIDOlderThan:
+		deletion := r.db.Where(
+			"account_id = ? AND event_id < ?",
+			query.AccountID, query.Deadline,
+		).Delete(&Event{})
+		if err := deletion.Error; err != nil {
+			return 0, fmt.Errorf("relational: error deleting events of account: %w", err)
+		}
+		return deletion.RowsAffected, nil
 	default:
 		return 0, persistence.ErrBadQuery
 	}
@@ -88,6 +88,31 @@ func TestRelationalDAL_FindEvents(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"by account id",
+			func(db *gorm.DB) error {
+				for _, event := range []Event{
+					{EventID: "event-a", AccountID: "account-a"},
+					{EventID: "event-b", AccountID: "account-a"},
+					{EventID: "event-c", AccountID: "account-b"},
+					{EventID: "event-d", AccountID: "account-a"},
+				} {
+					if err := db.Save(&event).Error; err != nil {
+						return fmt.Errorf("error saving fixture data: %v", err)
+					}
+				}
+				return nil
+			},
+			persistence.FindEventsQueryByAccountID{
+				AccountID: "account-a",
+				From:      "event-b",
+				Until:     "event-d",
+			},
+			[]persistence.Event{
+				{EventID: "event-b", AccountID: "account-a"},
+			},
+			false,
+		},
 		{
 			"by secret id - all events",
 			func(db *gorm.DB) error {
@@ -133,6 +158,36 @@ func TestRelationalDAL_FindEvents(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"by secret id - using range, accounts and limit",
+			func(db *gorm.DB) error {
+				for _, token := range []string{"a", "b", "c", "d", "e"} {
+					accountID := "account-a"
+					if token == "c" {
+						accountID = "account-b"
+					}
+					if err := db.Save(&Event{
+						EventID:   fmt.Sprintf("event-%s", token),
+						AccountID: accountID,
+						SecretID:  strptr("hashed-user-id-a"),
+					}).Error; err != nil {
+						return fmt.Errorf("error saving fixture data: %v", err)
+					}
+				}
+				return nil
+			},
+			persistence.FindEventsQueryForSecretIDs{
+				SecretIDs:  []string{"hashed-user-id-a"},
+				After:      "event-a",
+				Until:      "event-e",
+				AccountIDs: []string{"account-a"},
+				Limit:      1,
+			},
+			[]persistence.Event{
+				{EventID: "event-b", AccountID: "account-a", SecretID: strptr("hashed-user-id-a")},
+			},
+			false,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -228,6 +283,34 @@ func TestRelationalDAL_DeleteEvents(t *testing.T) {
 				return nil
 			},
 		},
+		{
+			"by account id older than",
+			func(db *gorm.DB) error {
+				for _, event := range []Event{
+					{EventID: "event-a", AccountID: "account-a"},
+					{EventID: "event-b", AccountID: "account-b"},
+					{EventID: "event-c", AccountID: "account-a"},
+				} {
+					if err := db.Save(&event).Error; err != nil {
+						return fmt.Errorf("error creating fixture record: %v", err)
+					}
+				}
+				return nil
+			},
+			persistence.DeleteEventsQueryByAccountIDOlderThan{AccountID: "account-a", Deadline: "event-c"},
+			1,
+			false,
+			func(db *gorm.DB) error {
+				var count int
+				if err := db.Table("events").Count(&count).Error; err != nil {
+					return fmt.Errorf("error counting event rows: %v", err)
+				}
+				if count != 2 {
+					return fmt.Errorf("error counting event rows, got %d", count)
+				}
+				return nil
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
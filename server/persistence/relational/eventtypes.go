@@ -0,0 +1,50 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"fmt"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func (r *relationalDAL) CreateEventType(e *persistence.EventType) error {
+	local := importEventType(e)
+	if err := r.db.Create(&local).Error; err != nil {
+		return fmt.Errorf("relational: error creating event type: %w", err)
+	}
+	return nil
+}
+
+func (r *relationalDAL) FindEventTypes(q interface{}) ([]persistence.EventType, error) {
+	var eventTypes []EventType
+	switch query := q.(type) {
+	case persistence.FindEventTypesQueryByAccountID:
+		if err := r.db.Where("account_id = ?", string(query)).Order("created").Find(&eventTypes).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up event types: %w", err)
+		}
+	default:
+		return nil, persistence.ErrBadQuery
+	}
+	var result []persistence.EventType
+	for _, eventType := range eventTypes {
+		result = append(result, eventType.export())
+	}
+	return result, nil
+}
+
+func (r *relationalDAL) DeleteEventTypes(q interface{}) error {
+	switch query := q.(type) {
+	case persistence.DeleteEventTypesQueryByID:
+		if err := r.db.Where(
+			"event_type_id = ? AND account_id = ?",
+			query.EventTypeID, query.AccountID,
+		).Delete(&EventType{}).Error; err != nil {
+			return fmt.Errorf("relational: error deleting event type: %w", err)
+		}
+		return nil
+	default:
+		return persistence.ErrBadQuery
+	}
+}
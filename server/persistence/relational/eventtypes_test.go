@@ -0,0 +1,57 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestRelationalDAL_EventTypes(t *testing.T) {
+	db, closeDB := createTestDatabase()
+	defer closeDB()
+	dal := NewRelationalDAL(db)
+
+	now := time.Now()
+	for _, eventType := range []persistence.EventType{
+		{EventTypeID: "type-a", AccountID: "account-a", Name: "signup", Aggregation: "count", Created: now.Add(-time.Hour)},
+		{EventTypeID: "type-b", AccountID: "account-a", Name: "download", Aggregation: "sum", Created: now},
+		{EventTypeID: "type-c", AccountID: "account-b", Name: "signup", Aggregation: "users", Created: now},
+	} {
+		if err := dal.CreateEventType(&eventType); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	if _, err := dal.FindEventTypes(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+	eventTypes, err := dal.FindEventTypes(persistence.FindEventTypesQueryByAccountID("account-a"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(eventTypes) != 2 || eventTypes[0].EventTypeID != "type-a" || eventTypes[1].Aggregation != "sum" {
+		t.Errorf("Unexpected event types %v", eventTypes)
+	}
+
+	if err := dal.DeleteEventTypes(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+	if err := dal.DeleteEventTypes(persistence.DeleteEventTypesQueryByID{EventTypeID: "type-c", AccountID: "account-a"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := dal.DeleteEventTypes(persistence.DeleteEventTypesQueryByID{EventTypeID: "type-a", AccountID: "account-a"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	eventTypes, _ = dal.FindEventTypes(persistence.FindEventTypesQueryByAccountID("account-a"))
+	if len(eventTypes) != 1 || eventTypes[0].EventTypeID != "type-b" {
+		t.Errorf("Unexpected event types %v", eventTypes)
+	}
+	eventTypes, _ = dal.FindEventTypes(persistence.FindEventTypesQueryByAccountID("account-b"))
+	if len(eventTypes) != 1 {
+		t.Errorf("Expected event type of other account to be kept, got %v", eventTypes)
+	}
+}
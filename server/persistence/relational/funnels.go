@@ -0,0 +1,50 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"fmt"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func (r *relationalDAL) CreateFunnel(f *persistence.Funnel) error {
+	local := importFunnel(f)
+	if err := r.db.Create(&local).Error; err != nil {
+		return fmt.Errorf("relational: error creating funnel: %w", err)
+	}
+	return nil
+}
+
+func (r *relationalDAL) FindFunnels(q interface{}) ([]persistence.Funnel, error) {
+	var funnels []Funnel
+	switch query := q.(type) {
+	case persistence.FindFunnelsQueryByAccountID:
+		if err := r.db.Where("account_id = ?", string(query)).Order("created").Find(&funnels).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up funnels: %w", err)
+		}
+	default:
+		return nil, persistence.ErrBadQuery
+	}
+	var result []persistence.Funnel
+	for _, funnel := range funnels {
+		result = append(result, funnel.export())
+	}
+	return result, nil
+}
+
+func (r *relationalDAL) DeleteFunnels(q interface{}) error {
+	switch query := q.(type) {
+	case persistence.DeleteFunnelsQueryByID:
+		if err := r.db.Where(
+			"funnel_id = ? AND account_id = ?",
+			query.FunnelID, query.AccountID,
+		).Delete(&Funnel{}).Error; err != nil {
+			return fmt.Errorf("relational: error deleting funnel: %w", err)
+		}
+		return nil
+	default:
+		return persistence.ErrBadQuery
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestRelationalDAL_Funnels(t *testing.T) {
+	db, closeDB := createTestDatabase()
+	defer closeDB()
+	dal := NewRelationalDAL(db)
+
+	now := time.Now()
+	steps := []persistence.FunnelStep{
+		{Type: "url", Value: "https://www.example.com/pricing,plans"},
+		{Type: "event", Value: "PAGEVIEW"},
+	}
+	for _, funnel := range []persistence.Funnel{
+		{FunnelID: "funnel-a", AccountID: "account-a", Name: "a", Steps: steps, Created: now.Add(-time.Hour)},
+		{FunnelID: "funnel-b", AccountID: "account-a", Name: "b", Steps: steps, Created: now},
+		{FunnelID: "funnel-c", AccountID: "account-b", Name: "c", Steps: steps, Created: now},
+	} {
+		if err := dal.CreateFunnel(&funnel); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	if _, err := dal.FindFunnels(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+	funnels, err := dal.FindFunnels(persistence.FindFunnelsQueryByAccountID("account-a"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(funnels) != 2 || funnels[0].FunnelID != "funnel-a" || !reflect.DeepEqual(funnels[0].Steps, steps) {
+		t.Errorf("Unexpected funnels %v", funnels)
+	}
+
+	if err := dal.DeleteFunnels(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+	if err := dal.DeleteFunnels(persistence.DeleteFunnelsQueryByID{FunnelID: "funnel-c", AccountID: "account-a"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := dal.DeleteFunnels(persistence.DeleteFunnelsQueryByID{FunnelID: "funnel-a", AccountID: "account-a"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	funnels, _ = dal.FindFunnels(persistence.FindFunnelsQueryByAccountID("account-a"))
+	if len(funnels) != 1 || funnels[0].FunnelID != "funnel-b" {
+		t.Errorf("Unexpected funnels %v", funnels)
+	}
+	funnels, _ = dal.FindFunnels(persistence.FindFunnelsQueryByAccountID("account-b"))
+	if len(funnels) != 1 {
+		t.Errorf("Expected funnel of other account to be kept, got %v", funnels)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"fmt"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func (r *relationalDAL) CreateGoal(g *persistence.Goal) error {
+	local := importGoal(g)
+	if err := r.db.Create(&local).Error; err != nil {
+		return fmt.Errorf("relational: error creating goal: %w", err)
+	}
+	return nil
+}
+
+func (r *relationalDAL) FindGoals(q interface{}) ([]persistence.Goal, error) {
+	var goals []Goal
+	switch query := q.(type) {
+	case persistence.FindGoalsQueryByAccountID:
+		if err := r.db.Where("account_id = ?", string(query)).Order("created").Find(&goals).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up goals: %w", err)
+		}
+	default:
+		return nil, persistence.ErrBadQuery
+	}
+	var result []persistence.Goal
+	for _, goal := range goals {
+		result = append(result, goal.export())
+	}
+	return result, nil
+}
+
+func (r *relationalDAL) DeleteGoals(q interface{}) error {
+	switch query := q.(type) {
+	case persistence.DeleteGoalsQueryByID:
+		if err := r.db.Where(
+			"goal_id = ? AND account_id = ?",
+			query.GoalID, query.AccountID,
+		).Delete(&Goal{}).Error; err != nil {
+			return fmt.Errorf("relational: error deleting goal: %w", err)
+		}
+		return nil
+	default:
+		return persistence.ErrBadQuery
+	}
+}
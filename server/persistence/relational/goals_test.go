@@ -0,0 +1,57 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestRelationalDAL_Goals(t *testing.T) {
+	db, closeDB := createTestDatabase()
+	defer closeDB()
+	dal := NewRelationalDAL(db)
+
+	now := time.Now()
+	for _, goal := range []persistence.Goal{
+		{GoalID: "goal-a", AccountID: "account-a", Name: "Signup", Type: "event", Value: "signup", Created: now.Add(-time.Hour)},
+		{GoalID: "goal-b", AccountID: "account-a", Name: "Checkout", Type: "url", Value: "https://www.offen.dev/thanks", Created: now},
+		{GoalID: "goal-c", AccountID: "account-b", Name: "Signup", Type: "event", Value: "signup", Created: now},
+	} {
+		if err := dal.CreateGoal(&goal); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	if _, err := dal.FindGoals(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+	goals, err := dal.FindGoals(persistence.FindGoalsQueryByAccountID("account-a"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(goals) != 2 || goals[0].GoalID != "goal-a" || goals[1].Value != "https://www.offen.dev/thanks" {
+		t.Errorf("Unexpected goals %v", goals)
+	}
+
+	if err := dal.DeleteGoals(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+	if err := dal.DeleteGoals(persistence.DeleteGoalsQueryByID{GoalID: "goal-c", AccountID: "account-a"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := dal.DeleteGoals(persistence.DeleteGoalsQueryByID{GoalID: "goal-a", AccountID: "account-a"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	goals, _ = dal.FindGoals(persistence.FindGoalsQueryByAccountID("account-a"))
+	if len(goals) != 1 || goals[0].GoalID != "goal-b" {
+		t.Errorf("Unexpected goals %v", goals)
+	}
+	goals, _ = dal.FindGoals(persistence.FindGoalsQueryByAccountID("account-b"))
+	if len(goals) != 1 {
+		t.Errorf("Expected goal of other account to be kept, got %v", goals)
+	}
+}
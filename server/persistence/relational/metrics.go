@@ -0,0 +1,41 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/offen/offen/server/metrics"
+)
+
+const queryStartKey = "offen:query_start"
+
+// ObserveQueries registers callbacks on the given database that observe the
+// latency of all queries using metrics.DBQueryDuration.
+func ObserveQueries(db *gorm.DB) {
+	start := func(scope *gorm.Scope) {
+		scope.Set(queryStartKey, time.Now())
+	}
+	observe := func(operation string) func(*gorm.Scope) {
+		return func(scope *gorm.Scope) {
+			if value, ok := scope.Get(queryStartKey); ok {
+				if started, ok := value.(time.Time); ok {
+					metrics.DBQueryDuration.ObserveSince(started, operation)
+				}
+			}
+		}
+	}
+	callbacks := db.Callback()
+	callbacks.Create().Before("gorm:create").Register("offen:start_create", start)
+	callbacks.Create().After("gorm:create").Register("offen:observe_create", observe("create"))
+	callbacks.Query().Before("gorm:query").Register("offen:start_query", start)
+	callbacks.Query().After("gorm:query").Register("offen:observe_query", observe("query"))
+	callbacks.Update().Before("gorm:update").Register("offen:start_update", start)
+	callbacks.Update().After("gorm:update").Register("offen:observe_update", observe("update"))
+	callbacks.Delete().Before("gorm:delete").Register("offen:start_delete", start)
+	callbacks.Delete().After("gorm:delete").Register("offen:observe_delete", observe("delete"))
+	callbacks.RowQuery().Before("gorm:row_query").Register("offen:start_row_query", start)
+	callbacks.RowQuery().After("gorm:row_query").Register("offen:observe_row_query", observe("row_query"))
+}
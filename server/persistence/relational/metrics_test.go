@@ -0,0 +1,47 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/offen/offen/server/metrics"
+)
+
+var queryCountRE = regexp.MustCompile(`offen_db_query_duration_seconds_count\{operation="(create|query)"\} (\d+)`)
+
+func observedQueries() map[string]int {
+	var buf bytes.Buffer
+	metrics.Default.WriteTo(&buf)
+	result := map[string]int{}
+	for _, match := range queryCountRE.FindAllStringSubmatch(buf.String(), -1) {
+		result[match[1]], _ = strconv.Atoi(match[2])
+	}
+	return result
+}
+
+func TestObserveQueries(t *testing.T) {
+	db, closeDB := createTestDatabase()
+	defer closeDB()
+	ObserveQueries(db)
+
+	before := observedQueries()
+	if err := db.Create(&Account{AccountID: "account-a"}).Error; err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	var accounts []Account
+	if err := db.Find(&accounts).Error; err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	after := observedQueries()
+	if after["create"] != before["create"]+1 {
+		t.Errorf("Unexpected number of observed create queries %d", after["create"])
+	}
+	if after["query"] != before["query"]+1 {
+		t.Errorf("Unexpected number of observed queries %d", after["query"])
+	}
+}
@@ -14,264 +14,1135 @@ import (
 )
 
 func (r *relationalDAL) ApplyMigrations() error {
-	m := gormigrate.New(r.db, gormigrate.DefaultOptions, []*gormigrate.Migration{
-		{
-			ID: "001_introduce_admin_level",
-			Migrate: func(db *gorm.DB) error {
-				type AccountUser struct {
-					AccountUserID  string `gorm:"primary_key"`
-					HashedEmail    string
-					HashedPassword string
-					Salt           string
-					AdminLevel     int
-					Relationships  []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
-				}
-				if err := db.AutoMigrate(&AccountUser{}).Error; err != nil {
-					return err
-				}
-				return db.Model(&AccountUser{}).UpdateColumn("admin_level", 1).Error
-			},
-			Rollback: func(db *gorm.DB) error {
-				type AccountUser struct {
-					AccountUserID  string `gorm:"primary_key"`
-					HashedEmail    string
-					HashedPassword string
-					Salt           string
-					Relationships  []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
-				}
-				return db.AutoMigrate(&AccountUser{}).Error
-			},
-		},
-		{
-			ID: "002_mysql_set_column_sizes",
-			Migrate: func(db *gorm.DB) error {
-				type Account struct {
-					AccountID           string `gorm:"primary_key"`
-					Name                string
-					PublicKey           string `gorm:"type:text"`
-					EncryptedPrivateKey string `gorm:"type:text"`
-					UserSalt            string
-					Retired             bool
-					Created             time.Time
-					Events              []Event `gorm:"foreignkey:AccountID;association_foreignkey:AccountID"`
-				}
-				type AccountUserRelationship struct {
-					RelationshipID                    string `gorm:"primary_key"`
-					AccountUserID                     string
-					AccountID                         string
-					PasswordEncryptedKeyEncryptionKey string `gorm:"type:text"`
-					EmailEncryptedKeyEncryptionKey    string `gorm:"type:text"`
-					OneTimeEncryptedKeyEncryptionKey  string `gorm:"type:text"`
-				}
-				type Event struct {
-					EventID   string `gorm:"primary_key"`
-					AccountID string
-					// the secret id is nullable for anonymous events
-					SecretID *string
-					Payload  string `gorm:"type:text"`
-					Secret   Secret `gorm:"foreignkey:SecretID;association_foreignkey:SecretID"`
-				}
+	m := gormigrate.New(r.db, gormigrate.DefaultOptions, migrations)
 
-				return db.AutoMigrate(&Account{}, &AccountUserRelationship{}, &Event{}).Error
-			},
-			Rollback: func(db *gorm.DB) error {
-				type Account struct {
-					AccountID           string `gorm:"primary_key"`
-					Name                string
-					PublicKey           string
-					EncryptedPrivateKey string
-					UserSalt            string
-					Retired             bool
-					Created             time.Time
-					Events              []Event `gorm:"foreignkey:AccountID;association_foreignkey:AccountID"`
-				}
-				type AccountUserRelationship struct {
-					RelationshipID                    string `gorm:"primary_key"`
-					AccountUserID                     string
-					AccountID                         string
-					PasswordEncryptedKeyEncryptionKey string
-					EmailEncryptedKeyEncryptionKey    string
-					OneTimeEncryptedKeyEncryptionKey  string
-				}
-				type Event struct {
-					EventID   string `gorm:"primary_key"`
-					AccountID string
-					// the secret id is nullable for anonymous events
-					SecretID *string
-					Payload  string
-					Secret   Secret `gorm:"foreignkey:SecretID;association_foreignkey:SecretID"`
-				}
-				return db.AutoMigrate(&Account{}, &AccountUserRelationship{}, &Event{}).Error
-			},
-		},
-		{
-			ID: "003_version_salts",
-			Migrate: func(db *gorm.DB) error {
-				type AccountUser struct {
-					AccountUserID  string `gorm:"primary_key"`
-					HashedEmail    string
-					HashedPassword string
-					Salt           string
-					AdminLevel     int
-					Relationships  []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
-				}
-				type Account struct {
-					AccountID           string `gorm:"primary_key"`
-					Name                string
-					PublicKey           string `gorm:"type:text"`
-					EncryptedPrivateKey string `gorm:"type:text"`
-					UserSalt            string
-					Retired             bool
-					Created             time.Time
-					Events              []Event `gorm:"foreignkey:AccountID;association_foreignkey:AccountID"`
-				}
+	m.InitSchema(func(db *gorm.DB) error {
+		return db.AutoMigrate(knownTables...).Error
+	})
 
-				var allUsers []*AccountUser
-				if err := db.Find(&allUsers).Error; err != nil {
-					return err
-				}
-				var allAccounts []*Account
-				if err := db.Find(&allAccounts).Error; err != nil {
-					return err
-				}
+	return m.Migrate()
+}
 
-				txn := db.Begin()
+func (r *relationalDAL) PendingMigrations() ([]string, error) {
+	var applied []string
+	// the table does not exist before migrations have been applied for the
+	// first time
+	if r.db.HasTable(gormigrate.DefaultOptions.TableName) {
+		if err := r.db.Table(gormigrate.DefaultOptions.TableName).Pluck(gormigrate.DefaultOptions.IDColumnName, &applied).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up applied migrations: %w", err)
+		}
+	}
+	ran := map[string]bool{}
+	for _, id := range applied {
+		ran[id] = true
+	}
+	var pending []string
+	for _, migration := range migrations {
+		if !ran[migration.ID] {
+			pending = append(pending, migration.ID)
+		}
+	}
+	return pending, nil
+}
 
-				for _, user := range allUsers {
-					user.Salt = fmt.Sprintf("{1,} %s", user.Salt)
-					if err := txn.Save(user).Error; err != nil {
-						txn.Rollback()
-						return err
-					}
-				}
+// migrations are applied in order and must never be changed or removed
+// once they have been released.
+var migrations = []*gormigrate.Migration{
+	{
+		ID: "001_introduce_admin_level",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUser struct {
+				AccountUserID  string `gorm:"primary_key"`
+				HashedEmail    string
+				HashedPassword string
+				Salt           string
+				AdminLevel     int
+				Relationships  []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+			}
+			if err := db.AutoMigrate(&AccountUser{}).Error; err != nil {
+				return err
+			}
+			return db.Model(&AccountUser{}).UpdateColumn("admin_level", 1).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			type AccountUser struct {
+				AccountUserID  string `gorm:"primary_key"`
+				HashedEmail    string
+				HashedPassword string
+				Salt           string
+				Relationships  []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+			}
+			return db.AutoMigrate(&AccountUser{}).Error
+		},
+	},
+	{
+		ID: "002_mysql_set_column_sizes",
+		Migrate: func(db *gorm.DB) error {
+			type Account struct {
+				AccountID           string `gorm:"primary_key"`
+				Name                string
+				PublicKey           string `gorm:"type:text"`
+				EncryptedPrivateKey string `gorm:"type:text"`
+				UserSalt            string
+				Retired             bool
+				Created             time.Time
+				Events              []Event `gorm:"foreignkey:AccountID;association_foreignkey:AccountID"`
+			}
+			type AccountUserRelationship struct {
+				RelationshipID                    string `gorm:"primary_key"`
+				AccountUserID                     string
+				AccountID                         string
+				PasswordEncryptedKeyEncryptionKey string `gorm:"type:text"`
+				EmailEncryptedKeyEncryptionKey    string `gorm:"type:text"`
+				OneTimeEncryptedKeyEncryptionKey  string `gorm:"type:text"`
+			}
+			type Event struct {
+				EventID   string `gorm:"primary_key"`
+				AccountID string
+				// the secret id is nullable for anonymous events
+				SecretID *string
+				Payload  string `gorm:"type:text"`
+				Secret   Secret `gorm:"foreignkey:SecretID;association_foreignkey:SecretID"`
+			}
 
-				for _, account := range allAccounts {
-					account.UserSalt = fmt.Sprintf("{1,} %s", account.UserSalt)
-					if err := txn.Save(account).Error; err != nil {
-						txn.Rollback()
-						return err
-					}
-				}
+			return db.AutoMigrate(&Account{}, &AccountUserRelationship{}, &Event{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			type Account struct {
+				AccountID           string `gorm:"primary_key"`
+				Name                string
+				PublicKey           string
+				EncryptedPrivateKey string
+				UserSalt            string
+				Retired             bool
+				Created             time.Time
+				Events              []Event `gorm:"foreignkey:AccountID;association_foreignkey:AccountID"`
+			}
+			type AccountUserRelationship struct {
+				RelationshipID                    string `gorm:"primary_key"`
+				AccountUserID                     string
+				AccountID                         string
+				PasswordEncryptedKeyEncryptionKey string
+				EmailEncryptedKeyEncryptionKey    string
+				OneTimeEncryptedKeyEncryptionKey  string
+			}
+			type Event struct {
+				EventID   string `gorm:"primary_key"`
+				AccountID string
+				// the secret id is nullable for anonymous events
+				SecretID *string
+				Payload  string
+				Secret   Secret `gorm:"foreignkey:SecretID;association_foreignkey:SecretID"`
+			}
+			return db.AutoMigrate(&Account{}, &AccountUserRelationship{}, &Event{}).Error
+		},
+	},
+	{
+		ID: "003_version_salts",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUser struct {
+				AccountUserID  string `gorm:"primary_key"`
+				HashedEmail    string
+				HashedPassword string
+				Salt           string
+				AdminLevel     int
+				Relationships  []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+			}
+			type Account struct {
+				AccountID           string `gorm:"primary_key"`
+				Name                string
+				PublicKey           string `gorm:"type:text"`
+				EncryptedPrivateKey string `gorm:"type:text"`
+				UserSalt            string
+				Retired             bool
+				Created             time.Time
+				Events              []Event `gorm:"foreignkey:AccountID;association_foreignkey:AccountID"`
+			}
 
-				return txn.Commit().Error
-			},
-			Rollback: func(db *gorm.DB) error {
-				type AccountUser struct {
-					AccountUserID  string `gorm:"primary_key"`
-					HashedEmail    string
-					HashedPassword string
-					Salt           string
-					AdminLevel     int
-					Relationships  []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
-				}
-				type Account struct {
-					AccountID           string `gorm:"primary_key"`
-					Name                string
-					PublicKey           string `gorm:"type:text"`
-					EncryptedPrivateKey string `gorm:"type:text"`
-					UserSalt            string
-					Retired             bool
-					Created             time.Time
-					Events              []Event `gorm:"foreignkey:AccountID;association_foreignkey:AccountID"`
-				}
+			var allUsers []*AccountUser
+			if err := db.Find(&allUsers).Error; err != nil {
+				return err
+			}
+			var allAccounts []*Account
+			if err := db.Find(&allAccounts).Error; err != nil {
+				return err
+			}
 
-				var allUsers []*AccountUser
-				if err := db.Find(&allUsers).Error; err != nil {
-					return err
-				}
-				var allAccounts []*Account
-				if err := db.Find(&allAccounts).Error; err != nil {
+			txn := db.Begin()
+
+			for _, user := range allUsers {
+				user.Salt = fmt.Sprintf("{1,} %s", user.Salt)
+				if err := txn.Save(user).Error; err != nil {
+					txn.Rollback()
 					return err
 				}
+			}
 
-				txn := db.Begin()
-				for _, user := range allUsers {
-					chunks := strings.Split(user.Salt, " ")
-					user.Salt = chunks[1]
-					if err := txn.Save(user).Error; err != nil {
-						txn.Rollback()
-						return err
-					}
-				}
-				for _, account := range allAccounts {
-					chunks := strings.Split(account.UserSalt, " ")
-					account.UserSalt = chunks[1]
-					if err := txn.Save(account).Error; err != nil {
-						txn.Rollback()
-						return err
-					}
+			for _, account := range allAccounts {
+				account.UserSalt = fmt.Sprintf("{1,} %s", account.UserSalt)
+				if err := txn.Save(account).Error; err != nil {
+					txn.Rollback()
+					return err
 				}
+			}
 
-				return txn.Commit().Error
-			},
-		},
-		{
-			ID: "004_add_tombstones_event_revs",
-			Migrate: func(db *gorm.DB) error {
-				type Tombstone struct {
-					EventID   string `gorm:"primary_key"`
-					AccountID string
-					SecretID  string
-					Sequence  string
-				}
+			return txn.Commit().Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			type AccountUser struct {
+				AccountUserID  string `gorm:"primary_key"`
+				HashedEmail    string
+				HashedPassword string
+				Salt           string
+				AdminLevel     int
+				Relationships  []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+			}
+			type Account struct {
+				AccountID           string `gorm:"primary_key"`
+				Name                string
+				PublicKey           string `gorm:"type:text"`
+				EncryptedPrivateKey string `gorm:"type:text"`
+				UserSalt            string
+				Retired             bool
+				Created             time.Time
+				Events              []Event `gorm:"foreignkey:AccountID;association_foreignkey:AccountID"`
+			}
 
-				type Event struct {
-					EventID   string `gorm:"primary_key"`
-					Sequence  string
-					AccountID string
-					SecretID  *string
-					Payload   string `gorm:"type:text"`
-					Secret    Secret `gorm:"foreignkey:SecretID;association_foreignkey:SecretID"`
-				}
+			var allUsers []*AccountUser
+			if err := db.Find(&allUsers).Error; err != nil {
+				return err
+			}
+			var allAccounts []*Account
+			if err := db.Find(&allAccounts).Error; err != nil {
+				return err
+			}
 
-				if err := db.AutoMigrate(&Tombstone{}, &Event{}).Error; err != nil {
+			txn := db.Begin()
+			for _, user := range allUsers {
+				chunks := strings.Split(user.Salt, " ")
+				user.Salt = chunks[1]
+				if err := txn.Save(user).Error; err != nil {
+					txn.Rollback()
 					return err
 				}
-
-				seq, err := persistence.NewULID()
-				if err != nil {
+			}
+			for _, account := range allAccounts {
+				chunks := strings.Split(account.UserSalt, " ")
+				account.UserSalt = chunks[1]
+				if err := txn.Save(account).Error; err != nil {
+					txn.Rollback()
 					return err
 				}
+			}
 
-				if err := db.Table("events").Update("sequence", seq).Error; err != nil {
-					return err
-				}
-				return nil
-			},
-			Rollback: func(db *gorm.DB) error {
-				// we cannot drop the sequence column on the events table
-				// because this is not supported by SQLite
-				return db.DropTable("tombstones").Error
-			},
-		},
-		{
-			ID: "005_update_secrets_table",
-			Migrate: func(db *gorm.DB) error {
-				type Secret struct {
-					SecretID        string `gorm:"primary_key"`
-					EncryptedSecret string `gorm:"type:text"`
-				}
-				if db.Dialect().GetName() == "mysql" {
-					return db.Exec("ALTER TABLE secrets MODIFY COLUMN encrypted_secret TEXT").Error
-				}
-				return nil
-			},
-			Rollback: func(db *gorm.DB) error {
-				type Secret struct {
-					SecretID        string `gorm:"primary_key"`
-					EncryptedSecret string
-				}
-				if db.Dialect().GetName() == "mysql" {
-					return db.Exec("ALTER TABLE secrets MODIFY COLUMN encrypted_secret VARCHAR").Error
-				}
-				return nil
-			},
+			return txn.Commit().Error
 		},
-	})
+	},
+	{
+		ID: "004_add_tombstones_event_revs",
+		Migrate: func(db *gorm.DB) error {
+			type Tombstone struct {
+				EventID   string `gorm:"primary_key"`
+				AccountID string
+				SecretID  string
+				Sequence  string
+			}
 
-	m.InitSchema(func(db *gorm.DB) error {
-		return db.AutoMigrate(knownTables...).Error
-	})
+			type Event struct {
+				EventID   string `gorm:"primary_key"`
+				Sequence  string
+				AccountID string
+				SecretID  *string
+				Payload   string `gorm:"type:text"`
+				Secret    Secret `gorm:"foreignkey:SecretID;association_foreignkey:SecretID"`
+			}
 
-	return m.Migrate()
+			if err := db.AutoMigrate(&Tombstone{}, &Event{}).Error; err != nil {
+				return err
+			}
+
+			seq, err := persistence.NewULID()
+			if err != nil {
+				return err
+			}
+
+			if err := db.Table("events").Update("sequence", seq).Error; err != nil {
+				return err
+			}
+			return nil
+		},
+		Rollback: func(db *gorm.DB) error {
+			// we cannot drop the sequence column on the events table
+			// because this is not supported by SQLite
+			return db.DropTable("tombstones").Error
+		},
+	},
+	{
+		ID: "005_update_secrets_table",
+		Migrate: func(db *gorm.DB) error {
+			type Secret struct {
+				SecretID        string `gorm:"primary_key"`
+				EncryptedSecret string `gorm:"type:text"`
+			}
+			if db.Dialect().GetName() == "mysql" {
+				return db.Exec("ALTER TABLE secrets MODIFY COLUMN encrypted_secret TEXT").Error
+			}
+			return nil
+		},
+		Rollback: func(db *gorm.DB) error {
+			type Secret struct {
+				SecretID        string `gorm:"primary_key"`
+				EncryptedSecret string
+			}
+			if db.Dialect().GetName() == "mysql" {
+				return db.Exec("ALTER TABLE secrets MODIFY COLUMN encrypted_secret VARCHAR").Error
+			}
+			return nil
+		},
+	},
+	{
+		ID: "006_add_key_rotations",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUserRelationship struct {
+				RelationshipID                    string `gorm:"primary_key"`
+				AccountUserID                     string
+				AccountID                         string
+				PasswordEncryptedKeyEncryptionKey string `gorm:"type:text"`
+				EmailEncryptedKeyEncryptionKey    string `gorm:"type:text"`
+				OneTimeEncryptedKeyEncryptionKey  string `gorm:"type:text"`
+				KeyEncryptionKeyRotations         string `gorm:"type:text"`
+			}
+			return db.AutoMigrate(&AccountUserRelationship{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// we cannot drop the key_encryption_key_rotations column
+			// because this is not supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "007_add_email_lookup_hashes",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUser struct {
+				AccountUserID    string `gorm:"primary_key"`
+				HashedEmail      string
+				EmailLookupHash  string
+				EmailLookupKeyID string
+				HashedPassword   string
+				Salt             string
+				AdminLevel       int
+				Relationships    []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+			}
+			return db.AutoMigrate(&AccountUser{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added columns cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "008_add_account_escrow",
+		Migrate: func(db *gorm.DB) error {
+			type Account struct {
+				AccountID                       string `gorm:"primary_key"`
+				Name                            string
+				PublicKey                       string `gorm:"type:text"`
+				EncryptedPrivateKey             string `gorm:"type:text"`
+				EscrowEncryptedKeyEncryptionKey string `gorm:"type:text"`
+				EscrowKeyID                     string
+				UserSalt                        string
+				Retired                         bool
+				Created                         time.Time
+			}
+			return db.AutoMigrate(&Account{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added columns cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "009_add_one_time_key_expiry",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUserRelationship struct {
+				RelationshipID                    string `gorm:"primary_key"`
+				AccountUserID                     string
+				AccountID                         string
+				PasswordEncryptedKeyEncryptionKey string `gorm:"type:text"`
+				EmailEncryptedKeyEncryptionKey    string `gorm:"type:text"`
+				OneTimeEncryptedKeyEncryptionKey  string `gorm:"type:text"`
+				OneTimeKeyExpires                 *time.Time
+				OneTimeKeyConsumed                bool
+				KeyEncryptionKeyRotations         string `gorm:"type:text"`
+			}
+			return db.AutoMigrate(&AccountUserRelationship{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added columns cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "010_add_email_salts",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUser struct {
+				AccountUserID    string `gorm:"primary_key"`
+				HashedEmail      string
+				EmailLookupHash  string
+				EmailLookupKeyID string
+				HashedPassword   string
+				Salt             string
+				EmailSalt        string
+				AdminLevel       int
+				Relationships    []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+			}
+			return db.AutoMigrate(&AccountUser{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added columns cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "011_add_second_factor",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUser struct {
+				AccountUserID         string `gorm:"primary_key"`
+				HashedEmail           string
+				EmailLookupHash       string
+				EmailLookupKeyID      string
+				HashedPassword        string
+				Salt                  string
+				EmailSalt             string
+				AdminLevel            int
+				EncryptedSecondFactor string `gorm:"type:text"`
+				SecondFactorEnabled   bool
+				Relationships         []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+			}
+			return db.AutoMigrate(&AccountUser{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added columns cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "012_add_webauthn_credentials",
+		Migrate: func(db *gorm.DB) error {
+			type WebAuthnCredential struct {
+				CredentialID               string `gorm:"primary_key"`
+				AccountUserID              string
+				PublicKey                  string `gorm:"type:text"`
+				SignCount                  uint32
+				EncryptedKeyEncryptionKeys string `gorm:"type:text"`
+				Created                    time.Time
+			}
+			return db.AutoMigrate(&WebAuthnCredential{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			return db.DropTableIfExists("web_authn_credentials").Error
+		},
+	},
+	{
+		ID: "013_add_recovery_codes",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUser struct {
+				AccountUserID             string `gorm:"primary_key"`
+				HashedEmail               string
+				EmailLookupHash           string
+				EmailLookupKeyID          string
+				HashedPassword            string
+				Salt                      string
+				EmailSalt                 string
+				AdminLevel                int
+				EncryptedSecondFactor     string `gorm:"type:text"`
+				SecondFactorEnabled       bool
+				SecondFactorRecoveryCodes string                    `gorm:"type:text"`
+				Relationships             []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+			}
+			return db.AutoMigrate(&AccountUser{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added columns cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "014_add_device_keys",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUser struct {
+				AccountUserID                    string `gorm:"primary_key"`
+				HashedEmail                      string
+				EmailLookupHash                  string
+				EmailLookupKeyID                 string
+				HashedPassword                   string
+				Salt                             string
+				EmailSalt                        string
+				AdminLevel                       int
+				EncryptedSecondFactor            string `gorm:"type:text"`
+				SecondFactorEnabled              bool
+				SecondFactorRecoveryCodes        string                    `gorm:"type:text"`
+				DeviceEncryptedKeyEncryptionKeys string                    `gorm:"type:text"`
+				Relationships                    []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+			}
+			return db.AutoMigrate(&AccountUser{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added column cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "015_add_relationship_roles",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUserRelationship struct {
+				RelationshipID                    string `gorm:"primary_key"`
+				AccountUserID                     string
+				AccountID                         string
+				PasswordEncryptedKeyEncryptionKey string `gorm:"type:text"`
+				EmailEncryptedKeyEncryptionKey    string `gorm:"type:text"`
+				OneTimeEncryptedKeyEncryptionKey  string `gorm:"type:text"`
+				OneTimeKeyExpires                 *time.Time
+				OneTimeKeyConsumed                bool
+				KeyEncryptionKeyRotations         string `gorm:"type:text"`
+				Role                              int
+			}
+			// existing relationships are migrated to the viewer role,
+			// which matches the privileges non-admin users had before
+			return db.AutoMigrate(&AccountUserRelationship{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added column cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "016_add_sessions",
+		Migrate: func(db *gorm.DB) error {
+			type Session struct {
+				SessionID     string `gorm:"primary_key"`
+				AccountUserID string `gorm:"index"`
+				IPAddress     string
+				UserAgent     string `gorm:"type:text"`
+				Created       time.Time
+				Expires       time.Time
+			}
+			return db.AutoMigrate(&Session{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			return db.DropTableIfExists("sessions").Error
+		},
+	},
+	{
+		ID: "017_add_password_changed",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUser struct {
+				AccountUserID                    string `gorm:"primary_key"`
+				HashedEmail                      string
+				EmailLookupHash                  string
+				EmailLookupKeyID                 string
+				HashedPassword                   string
+				PasswordChanged                  *time.Time
+				Salt                             string
+				EmailSalt                        string
+				AdminLevel                       int
+				EncryptedSecondFactor            string `gorm:"type:text"`
+				SecondFactorEnabled              bool
+				SecondFactorRecoveryCodes        string                    `gorm:"type:text"`
+				DeviceEncryptedKeyEncryptionKeys string                    `gorm:"type:text"`
+				Relationships                    []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+			}
+			if err := db.AutoMigrate(&AccountUser{}).Error; err != nil {
+				return err
+			}
+			// the age of existing passwords is unknown, so they are
+			// considered to have been set when migrating
+			return db.Model(&AccountUser{}).
+				Where("hashed_password <> ?", "").
+				Update("password_changed", time.Now()).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added column cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "018_add_password_history",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUser struct {
+				AccountUserID                    string `gorm:"primary_key"`
+				HashedEmail                      string
+				EmailLookupHash                  string
+				EmailLookupKeyID                 string
+				HashedPassword                   string
+				PasswordChanged                  *time.Time
+				PasswordHistory                  string `gorm:"type:text"`
+				Salt                             string
+				EmailSalt                        string
+				AdminLevel                       int
+				EncryptedSecondFactor            string `gorm:"type:text"`
+				SecondFactorEnabled              bool
+				SecondFactorRecoveryCodes        string                    `gorm:"type:text"`
+				DeviceEncryptedKeyEncryptionKeys string                    `gorm:"type:text"`
+				Relationships                    []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+			}
+			return db.AutoMigrate(&AccountUser{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added column cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "019_add_pending_email_change",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUser struct {
+				AccountUserID                    string `gorm:"primary_key"`
+				HashedEmail                      string
+				EmailLookupHash                  string
+				EmailLookupKeyID                 string
+				HashedPassword                   string
+				PasswordChanged                  *time.Time
+				PasswordHistory                  string `gorm:"type:text"`
+				Salt                             string
+				EmailSalt                        string
+				AdminLevel                       int
+				EncryptedSecondFactor            string `gorm:"type:text"`
+				SecondFactorEnabled              bool
+				SecondFactorRecoveryCodes        string                    `gorm:"type:text"`
+				DeviceEncryptedKeyEncryptionKeys string                    `gorm:"type:text"`
+				PendingEmailChange               string                    `gorm:"type:text"`
+				Relationships                    []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+			}
+			return db.AutoMigrate(&AccountUser{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added column cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "020_add_access_tokens",
+		Migrate: func(db *gorm.DB) error {
+			type AccessToken struct {
+				TokenID       string `gorm:"primary_key"`
+				AccountUserID string `gorm:"index"`
+				Name          string
+				HashedSecret  string
+				Salt          string
+				Scopes        string
+				AccountIDs    string `gorm:"type:text"`
+				Created       time.Time
+				Expires       *time.Time
+			}
+			return db.AutoMigrate(&AccessToken{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			return db.DropTableIfExists("access_tokens").Error
+		},
+	},
+	{
+		ID: "021_add_service_accounts",
+		Migrate: func(db *gorm.DB) error {
+			type ServiceAccount struct {
+				ServiceAccountID string `gorm:"primary_key"`
+				Name             string
+				HashedSecret     string
+				Salt             string
+				CreatedBy        string
+				Created          time.Time
+			}
+			return db.AutoMigrate(&ServiceAccount{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			return db.DropTableIfExists("service_accounts").Error
+		},
+	},
+	{
+		ID: "022_add_account_user_suspended",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUser struct {
+				AccountUserID                    string `gorm:"primary_key"`
+				HashedEmail                      string
+				EmailLookupHash                  string
+				EmailLookupKeyID                 string
+				HashedPassword                   string
+				PasswordChanged                  *time.Time
+				PasswordHistory                  string `gorm:"type:text"`
+				Salt                             string
+				EmailSalt                        string
+				AdminLevel                       int
+				EncryptedSecondFactor            string `gorm:"type:text"`
+				SecondFactorEnabled              bool
+				SecondFactorRecoveryCodes        string `gorm:"type:text"`
+				DeviceEncryptedKeyEncryptionKeys string `gorm:"type:text"`
+				PendingEmailChange               string `gorm:"type:text"`
+				Suspended                        bool
+				Relationships                    []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+			}
+			return db.AutoMigrate(&AccountUser{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added column cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "023_add_auth_events",
+		Migrate: func(db *gorm.DB) error {
+			type AuthEvent struct {
+				EventID       string `gorm:"primary_key"`
+				AccountUserID string `gorm:"index"`
+				Type          string
+				IPAddress     string
+				UserAgent     string `gorm:"type:text"`
+				Created       time.Time
+			}
+			return db.AutoMigrate(&AuthEvent{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			return db.DropTableIfExists("auth_events").Error
+		},
+	},
+	{
+		ID: "024_add_account_user_allowed_networks",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUser struct {
+				AccountUserID                    string `gorm:"primary_key"`
+				HashedEmail                      string
+				EmailLookupHash                  string
+				EmailLookupKeyID                 string
+				HashedPassword                   string
+				PasswordChanged                  *time.Time
+				PasswordHistory                  string `gorm:"type:text"`
+				Salt                             string
+				EmailSalt                        string
+				AdminLevel                       int
+				EncryptedSecondFactor            string `gorm:"type:text"`
+				SecondFactorEnabled              bool
+				SecondFactorRecoveryCodes        string `gorm:"type:text"`
+				DeviceEncryptedKeyEncryptionKeys string `gorm:"type:text"`
+				PendingEmailChange               string `gorm:"type:text"`
+				Suspended                        bool
+				AllowedNetworks                  string                    `gorm:"type:text"`
+				Relationships                    []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+			}
+			return db.AutoMigrate(&AccountUser{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added column cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "025_add_session_impersonated_by",
+		Migrate: func(db *gorm.DB) error {
+			type Session struct {
+				SessionID      string `gorm:"primary_key"`
+				AccountUserID  string `gorm:"index"`
+				IPAddress      string
+				UserAgent      string `gorm:"type:text"`
+				Created        time.Time
+				Expires        time.Time
+				ImpersonatedBy string
+			}
+			return db.AutoMigrate(&Session{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added column cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "026_add_account_user_secondary_emails",
+		Migrate: func(db *gorm.DB) error {
+			type AccountUser struct {
+				AccountUserID                    string `gorm:"primary_key"`
+				HashedEmail                      string
+				EmailLookupHash                  string
+				EmailLookupKeyID                 string
+				HashedPassword                   string
+				PasswordChanged                  *time.Time
+				PasswordHistory                  string `gorm:"type:text"`
+				Salt                             string
+				EmailSalt                        string
+				AdminLevel                       int
+				EncryptedSecondFactor            string `gorm:"type:text"`
+				SecondFactorEnabled              bool
+				SecondFactorRecoveryCodes        string `gorm:"type:text"`
+				DeviceEncryptedKeyEncryptionKeys string `gorm:"type:text"`
+				PendingEmailChange               string `gorm:"type:text"`
+				Suspended                        bool
+				AllowedNetworks                  string                    `gorm:"type:text"`
+				SecondaryEmails                  string                    `gorm:"type:text"`
+				Relationships                    []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+			}
+			return db.AutoMigrate(&AccountUser{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added column cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "027_add_session_refresh_token",
+		Migrate: func(db *gorm.DB) error {
+			type Session struct {
+				SessionID          string `gorm:"primary_key"`
+				AccountUserID      string `gorm:"index"`
+				IPAddress          string
+				UserAgent          string `gorm:"type:text"`
+				Created            time.Time
+				Expires            time.Time
+				ImpersonatedBy     string
+				HashedRefreshToken string
+				RefreshExpires     *time.Time
+			}
+			return db.AutoMigrate(&Session{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added columns cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "028_add_account_allowed_origins",
+		Migrate: func(db *gorm.DB) error {
+			type Account struct {
+				AccountID                       string `gorm:"primary_key"`
+				Name                            string
+				PublicKey                       string `gorm:"type:text"`
+				EncryptedPrivateKey             string `gorm:"type:text"`
+				EscrowEncryptedKeyEncryptionKey string `gorm:"type:text"`
+				EscrowKeyID                     string
+				UserSalt                        string
+				Retired                         bool
+				AllowedOrigins                  string `gorm:"type:text"`
+				Created                         time.Time
+			}
+			return db.AutoMigrate(&Account{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added column cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "029_add_events_lookup_indexes",
+		Migrate: func(db *gorm.DB) error {
+			type Event struct {
+				EventID   string `gorm:"primary_key"`
+				Sequence  string
+				AccountID string
+				SecretID  *string
+				Payload   string `gorm:"type:text"`
+			}
+			if err := db.Model(&Event{}).AddIndex("idx_events_secret_id_event_id", "secret_id", "event_id").Error; err != nil {
+				return err
+			}
+			return db.Model(&Event{}).AddIndex("idx_events_account_id_event_id", "account_id", "event_id").Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			type Event struct {
+				EventID string `gorm:"primary_key"`
+			}
+			if err := db.Model(&Event{}).RemoveIndex("idx_events_secret_id_event_id").Error; err != nil {
+				return err
+			}
+			return db.Model(&Event{}).RemoveIndex("idx_events_account_id_event_id").Error
+		},
+	},
+	{
+		ID: "030_add_webhooks_tables",
+		Migrate: func(db *gorm.DB) error {
+			type Webhook struct {
+				WebhookID string `gorm:"primary_key"`
+				AccountID string `gorm:"index"`
+				URL       string `gorm:"type:text"`
+				Secret    string
+				Events    string
+				CreatedBy string
+				Created   time.Time
+			}
+			type WebhookDelivery struct {
+				DeliveryID string `gorm:"primary_key"`
+				WebhookID  string `gorm:"index"`
+				Event      string
+				Payload    string `gorm:"type:text"`
+				Attempts   int
+				StatusCode int
+				Error      string `gorm:"type:text"`
+				Delivered  bool
+				Created    time.Time
+				Updated    time.Time
+			}
+			return db.AutoMigrate(&Webhook{}, &WebhookDelivery{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			return db.DropTableIfExists("webhooks", "webhook_deliveries").Error
+		},
+	},
+	{
+		ID: "031_add_rollups_table",
+		Migrate: func(db *gorm.DB) error {
+			type Rollup struct {
+				RollupID         string    `gorm:"primary_key"`
+				AccountID        string    `gorm:"index:idx_rollups_account_id_period_start"`
+				Period           string    `gorm:"index:idx_rollups_account_id_period_start"`
+				Start            time.Time `gorm:"index:idx_rollups_account_id_period_start"`
+				Events           int
+				Users            int
+				AnonymousEvents  int
+				EncryptedPayload string `gorm:"type:text"`
+				Updated          time.Time
+			}
+			return db.AutoMigrate(&Rollup{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			return db.DropTableIfExists("rollups").Error
+		},
+	},
+	{
+		ID: "032_add_funnels_table",
+		Migrate: func(db *gorm.DB) error {
+			type Funnel struct {
+				FunnelID  string `gorm:"primary_key"`
+				AccountID string `gorm:"index"`
+				Name      string
+				Steps     string `gorm:"type:text"`
+				CreatedBy string
+				Created   time.Time
+			}
+			return db.AutoMigrate(&Funnel{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			return db.DropTableIfExists("funnels").Error
+		},
+	},
+	{
+		ID: "033_add_event_types_table",
+		Migrate: func(db *gorm.DB) error {
+			type EventType struct {
+				EventTypeID string `gorm:"primary_key"`
+				AccountID   string `gorm:"index"`
+				Name        string
+				Aggregation string
+				CreatedBy   string
+				Created     time.Time
+			}
+			return db.AutoMigrate(&EventType{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			return db.DropTableIfExists("event_types").Error
+		},
+	},
+	{
+		ID: "034_add_goals_table",
+		Migrate: func(db *gorm.DB) error {
+			type Goal struct {
+				GoalID    string `gorm:"primary_key"`
+				AccountID string `gorm:"index"`
+				Name      string
+				Type      string
+				Value     string
+				CreatedBy string
+				Created   time.Time
+			}
+			return db.AutoMigrate(&Goal{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			return db.DropTableIfExists("goals").Error
+		},
+	},
+	{
+		ID: "035_add_sampling_columns",
+		Migrate: func(db *gorm.DB) error {
+			type Event struct {
+				EventID   string `gorm:"primary_key"`
+				Sequence  string
+				AccountID string
+				SecretID  *string
+				Payload   string `gorm:"type:text"`
+				Weight    int
+			}
+			type Rollup struct {
+				RollupID         string    `gorm:"primary_key"`
+				AccountID        string    `gorm:"index:idx_rollups_account_id_period_start"`
+				Period           string    `gorm:"index:idx_rollups_account_id_period_start"`
+				Start            time.Time `gorm:"index:idx_rollups_account_id_period_start"`
+				Events           int
+				Users            int
+				AnonymousEvents  int
+				SamplingRate     float64
+				EncryptedPayload string `gorm:"type:text"`
+				Updated          time.Time
+			}
+			return db.AutoMigrate(&Event{}, &Rollup{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added columns cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "036_add_report_subscriptions_table",
+		Migrate: func(db *gorm.DB) error {
+			type ReportSubscription struct {
+				ReportSubscriptionID string `gorm:"primary_key"`
+				AccountUserID        string `gorm:"index"`
+				AccountID            string
+				EmailAddress         string
+				Frequency            string
+				Created              time.Time
+			}
+			return db.AutoMigrate(&ReportSubscription{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			return db.DropTableIfExists("report_subscriptions").Error
+		},
+	},
+	{
+		ID: "037_add_account_privacy_signals",
+		Migrate: func(db *gorm.DB) error {
+			type Account struct {
+				AccountID                       string `gorm:"primary_key"`
+				Name                            string
+				PublicKey                       string `gorm:"type:text"`
+				EncryptedPrivateKey             string `gorm:"type:text"`
+				EscrowEncryptedKeyEncryptionKey string `gorm:"type:text"`
+				EscrowKeyID                     string
+				UserSalt                        string
+				Retired                         bool
+				AllowedOrigins                  string `gorm:"type:text"`
+				PrivacySignals                  string
+				Created                         time.Time
+			}
+			return db.AutoMigrate(&Account{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added column cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "038_add_account_timezone",
+		Migrate: func(db *gorm.DB) error {
+			type Account struct {
+				AccountID                       string `gorm:"primary_key"`
+				Name                            string
+				PublicKey                       string `gorm:"type:text"`
+				EncryptedPrivateKey             string `gorm:"type:text"`
+				EscrowEncryptedKeyEncryptionKey string `gorm:"type:text"`
+				EscrowKeyID                     string
+				UserSalt                        string
+				Retired                         bool
+				AllowedOrigins                  string `gorm:"type:text"`
+				PrivacySignals                  string
+				Timezone                        string
+				Created                         time.Time
+			}
+			return db.AutoMigrate(&Account{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added column cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "039_add_account_anomaly_sensitivity",
+		Migrate: func(db *gorm.DB) error {
+			type Account struct {
+				AccountID                       string `gorm:"primary_key"`
+				Name                            string
+				PublicKey                       string `gorm:"type:text"`
+				EncryptedPrivateKey             string `gorm:"type:text"`
+				EscrowEncryptedKeyEncryptionKey string `gorm:"type:text"`
+				EscrowKeyID                     string
+				UserSalt                        string
+				Retired                         bool
+				AllowedOrigins                  string `gorm:"type:text"`
+				PrivacySignals                  string
+				Timezone                        string
+				AnomalySensitivity              string
+				Created                         time.Time
+			}
+			return db.AutoMigrate(&Account{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added column cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "040_add_account_domains",
+		Migrate: func(db *gorm.DB) error {
+			type Account struct {
+				AccountID                       string `gorm:"primary_key"`
+				Name                            string
+				PublicKey                       string `gorm:"type:text"`
+				EncryptedPrivateKey             string `gorm:"type:text"`
+				EscrowEncryptedKeyEncryptionKey string `gorm:"type:text"`
+				EscrowKeyID                     string
+				UserSalt                        string
+				Retired                         bool
+				AllowedOrigins                  string `gorm:"type:text"`
+				PrivacySignals                  string
+				Timezone                        string
+				AnomalySensitivity              string
+				Domains                         string `gorm:"type:text"`
+				Created                         time.Time
+			}
+			return db.AutoMigrate(&Account{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added column cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
+	{
+		ID: "041_add_rollup_import_source",
+		Migrate: func(db *gorm.DB) error {
+			type Rollup struct {
+				RollupID         string    `gorm:"primary_key"`
+				AccountID        string    `gorm:"index:idx_rollups_account_id_period_start"`
+				Period           string    `gorm:"index:idx_rollups_account_id_period_start"`
+				Start            time.Time `gorm:"index:idx_rollups_account_id_period_start"`
+				Events           int
+				Users            int
+				AnonymousEvents  int
+				SamplingRate     float64
+				EncryptedPayload string `gorm:"type:text"`
+				ImportSource     string
+				Updated          time.Time
+			}
+			return db.AutoMigrate(&Rollup{}).Error
+		},
+		Rollback: func(db *gorm.DB) error {
+			// the added column cannot be dropped because this is not
+			// supported by SQLite
+			return nil
+		},
+	},
 }
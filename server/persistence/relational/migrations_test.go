@@ -0,0 +1,49 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestRelationalDAL_PendingMigrations(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer db.Close()
+	dal := &relationalDAL{db: db}
+
+	pending, err := dal.PendingMigrations()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(pending) != len(migrations) {
+		t.Errorf("Expected all migrations to be pending, got %v", pending)
+	}
+
+	if err := dal.ApplyMigrations(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	pending, err = dal.PendingMigrations()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("Unexpected pending migrations %v", pending)
+	}
+
+	if err := db.Exec("DELETE FROM migrations WHERE id = ?", migrations[len(migrations)-1].ID).Error; err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	pending, err = dal.PendingMigrations()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(pending) != 1 || pending[0] != migrations[len(migrations)-1].ID {
+		t.Errorf("Unexpected pending migrations %v", pending)
+	}
+}
@@ -4,6 +4,8 @@
 package relational
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/offen/offen/server/persistence"
@@ -19,6 +21,7 @@ type Event struct {
 	SecretID *string
 	Payload  string `gorm:"type:text"`
 	Secret   Secret `gorm:"foreignkey:SecretID;association_foreignkey:SecretID"`
+	Weight   int
 }
 
 func (e *Event) export() persistence.Event {
@@ -29,6 +32,7 @@ func (e *Event) export() persistence.Event {
 		Payload:   e.Payload,
 		Secret:    e.Secret.export(),
 		Sequence:  e.Sequence,
+		Weight:    e.Weight,
 	}
 }
 
@@ -40,6 +44,7 @@ func importEvent(e *persistence.Event) Event {
 		Payload:   e.Payload,
 		Secret:    importSecret(&e.Secret),
 		Sequence:  e.Sequence,
+		Weight:    e.Weight,
 	}
 }
 
@@ -94,12 +99,25 @@ func importSecret(s *persistence.Secret) Secret {
 // AccountUser is a person that can log in and access data related to all
 // associated accounts.
 type AccountUser struct {
-	AccountUserID  string `gorm:"primary_key"`
-	HashedEmail    string
-	HashedPassword string
-	Salt           string
-	AdminLevel     int
-	Relationships  []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
+	AccountUserID                    string `gorm:"primary_key"`
+	HashedEmail                      string
+	EmailLookupHash                  string
+	EmailLookupKeyID                 string
+	HashedPassword                   string
+	PasswordChanged                  *time.Time
+	PasswordHistory                  string `gorm:"type:text"`
+	Salt                             string
+	EmailSalt                        string
+	AdminLevel                       int
+	EncryptedSecondFactor            string `gorm:"type:text"`
+	SecondFactorEnabled              bool
+	SecondFactorRecoveryCodes        string `gorm:"type:text"`
+	DeviceEncryptedKeyEncryptionKeys string `gorm:"type:text"`
+	PendingEmailChange               string `gorm:"type:text"`
+	Suspended                        bool
+	AllowedNetworks                  string                    `gorm:"type:text"`
+	SecondaryEmails                  string                    `gorm:"type:text"`
+	Relationships                    []AccountUserRelationship `gorm:"foreignkey:AccountUserID;association_foreignkey:AccountUserID"`
 }
 
 func (a *AccountUser) export() persistence.AccountUser {
@@ -108,12 +126,25 @@ func (a *AccountUser) export() persistence.AccountUser {
 		relationships = append(relationships, r.export())
 	}
 	return persistence.AccountUser{
-		AccountUserID:  a.AccountUserID,
-		HashedEmail:    a.HashedEmail,
-		HashedPassword: a.HashedPassword,
-		Salt:           a.Salt,
-		AdminLevel:     persistence.AccountUserAdminLevel(a.AdminLevel),
-		Relationships:  relationships,
+		AccountUserID:                    a.AccountUserID,
+		HashedEmail:                      a.HashedEmail,
+		EmailLookupHash:                  a.EmailLookupHash,
+		EmailLookupKeyID:                 a.EmailLookupKeyID,
+		HashedPassword:                   a.HashedPassword,
+		PasswordChanged:                  a.PasswordChanged,
+		PasswordHistory:                  a.PasswordHistory,
+		Salt:                             a.Salt,
+		EmailSalt:                        a.EmailSalt,
+		AdminLevel:                       persistence.AccountUserAdminLevel(a.AdminLevel),
+		EncryptedSecondFactor:            a.EncryptedSecondFactor,
+		SecondFactorEnabled:              a.SecondFactorEnabled,
+		SecondFactorRecoveryCodes:        a.SecondFactorRecoveryCodes,
+		DeviceEncryptedKeyEncryptionKeys: a.DeviceEncryptedKeyEncryptionKeys,
+		PendingEmailChange:               a.PendingEmailChange,
+		Suspended:                        a.Suspended,
+		AllowedNetworks:                  a.AllowedNetworks,
+		SecondaryEmails:                  a.SecondaryEmails,
+		Relationships:                    relationships,
 	}
 }
 
@@ -123,12 +154,25 @@ func importAccountUser(a *persistence.AccountUser) AccountUser {
 		relationships = append(relationships, importAccountUserRelationship(&r))
 	}
 	return AccountUser{
-		AccountUserID:  a.AccountUserID,
-		HashedEmail:    a.HashedEmail,
-		HashedPassword: a.HashedPassword,
-		Salt:           a.Salt,
-		AdminLevel:     int(a.AdminLevel),
-		Relationships:  relationships,
+		AccountUserID:                    a.AccountUserID,
+		HashedEmail:                      a.HashedEmail,
+		EmailLookupHash:                  a.EmailLookupHash,
+		EmailLookupKeyID:                 a.EmailLookupKeyID,
+		HashedPassword:                   a.HashedPassword,
+		PasswordChanged:                  a.PasswordChanged,
+		PasswordHistory:                  a.PasswordHistory,
+		Salt:                             a.Salt,
+		EmailSalt:                        a.EmailSalt,
+		AdminLevel:                       int(a.AdminLevel),
+		EncryptedSecondFactor:            a.EncryptedSecondFactor,
+		SecondFactorEnabled:              a.SecondFactorEnabled,
+		SecondFactorRecoveryCodes:        a.SecondFactorRecoveryCodes,
+		DeviceEncryptedKeyEncryptionKeys: a.DeviceEncryptedKeyEncryptionKeys,
+		PendingEmailChange:               a.PendingEmailChange,
+		Suspended:                        a.Suspended,
+		AllowedNetworks:                  a.AllowedNetworks,
+		SecondaryEmails:                  a.SecondaryEmails,
+		Relationships:                    relationships,
 	}
 }
 
@@ -141,6 +185,10 @@ type AccountUserRelationship struct {
 	PasswordEncryptedKeyEncryptionKey string `gorm:"type:text"`
 	EmailEncryptedKeyEncryptionKey    string `gorm:"type:text"`
 	OneTimeEncryptedKeyEncryptionKey  string `gorm:"type:text"`
+	OneTimeKeyExpires                 *time.Time
+	OneTimeKeyConsumed                bool
+	KeyEncryptionKeyRotations         string `gorm:"type:text"`
+	Role                              int
 }
 
 func (a *AccountUserRelationship) export() persistence.AccountUserRelationship {
@@ -151,6 +199,10 @@ func (a *AccountUserRelationship) export() persistence.AccountUserRelationship {
 		PasswordEncryptedKeyEncryptionKey: a.PasswordEncryptedKeyEncryptionKey,
 		EmailEncryptedKeyEncryptionKey:    a.EmailEncryptedKeyEncryptionKey,
 		OneTimeEncryptedKeyEncryptionKey:  a.OneTimeEncryptedKeyEncryptionKey,
+		OneTimeKeyExpires:                 a.OneTimeKeyExpires,
+		OneTimeKeyConsumed:                a.OneTimeKeyConsumed,
+		KeyEncryptionKeyRotations:         a.KeyEncryptionKeyRotations,
+		Role:                              persistence.AccountUserRole(a.Role),
 	}
 }
 
@@ -162,19 +214,454 @@ func importAccountUserRelationship(a *persistence.AccountUserRelationship) Accou
 		PasswordEncryptedKeyEncryptionKey: a.PasswordEncryptedKeyEncryptionKey,
 		EmailEncryptedKeyEncryptionKey:    a.EmailEncryptedKeyEncryptionKey,
 		OneTimeEncryptedKeyEncryptionKey:  a.OneTimeEncryptedKeyEncryptionKey,
+		OneTimeKeyExpires:                 a.OneTimeKeyExpires,
+		OneTimeKeyConsumed:                a.OneTimeKeyConsumed,
+		KeyEncryptionKeyRotations:         a.KeyEncryptionKeyRotations,
+		Role:                              int(a.Role),
 	}
 }
 
+// WebAuthnCredential is a public key credential that can be used by an
+// account user for logging in.
+type WebAuthnCredential struct {
+	CredentialID               string `gorm:"primary_key"`
+	AccountUserID              string
+	PublicKey                  string `gorm:"type:text"`
+	SignCount                  uint32
+	EncryptedKeyEncryptionKeys string `gorm:"type:text"`
+	Created                    time.Time
+}
+
+func (w *WebAuthnCredential) export() persistence.WebAuthnCredential {
+	return persistence.WebAuthnCredential{
+		CredentialID:               w.CredentialID,
+		AccountUserID:              w.AccountUserID,
+		PublicKey:                  w.PublicKey,
+		SignCount:                  w.SignCount,
+		EncryptedKeyEncryptionKeys: w.EncryptedKeyEncryptionKeys,
+		Created:                    w.Created,
+	}
+}
+
+func importWebAuthnCredential(w *persistence.WebAuthnCredential) WebAuthnCredential {
+	return WebAuthnCredential{
+		CredentialID:               w.CredentialID,
+		AccountUserID:              w.AccountUserID,
+		PublicKey:                  w.PublicKey,
+		SignCount:                  w.SignCount,
+		EncryptedKeyEncryptionKeys: w.EncryptedKeyEncryptionKeys,
+		Created:                    w.Created,
+	}
+}
+
+// AccessToken is a credential an account user can use for API requests.
+type AccessToken struct {
+	TokenID       string `gorm:"primary_key"`
+	AccountUserID string `gorm:"index"`
+	Name          string
+	HashedSecret  string
+	Salt          string
+	Scopes        string
+	AccountIDs    string `gorm:"type:text"`
+	Created       time.Time
+	Expires       *time.Time
+}
+
+func (a *AccessToken) export() persistence.AccessToken {
+	return persistence.AccessToken{
+		TokenID:       a.TokenID,
+		AccountUserID: a.AccountUserID,
+		Name:          a.Name,
+		HashedSecret:  a.HashedSecret,
+		Salt:          a.Salt,
+		Scopes:        splitList(a.Scopes),
+		AccountIDs:    splitList(a.AccountIDs),
+		Created:       a.Created,
+		Expires:       a.Expires,
+	}
+}
+
+func importAccessToken(a *persistence.AccessToken) AccessToken {
+	return AccessToken{
+		TokenID:       a.TokenID,
+		AccountUserID: a.AccountUserID,
+		Name:          a.Name,
+		HashedSecret:  a.HashedSecret,
+		Salt:          a.Salt,
+		Scopes:        strings.Join(a.Scopes, ","),
+		AccountIDs:    strings.Join(a.AccountIDs, ","),
+		Created:       a.Created,
+		Expires:       a.Expires,
+	}
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// Webhook is a URL that is notified about events concerning an account.
+type Webhook struct {
+	WebhookID string `gorm:"primary_key"`
+	AccountID string `gorm:"index"`
+	URL       string `gorm:"type:text"`
+	Secret    string
+	Events    string
+	CreatedBy string
+	Created   time.Time
+}
+
+func (w *Webhook) export() persistence.Webhook {
+	return persistence.Webhook{
+		WebhookID: w.WebhookID,
+		AccountID: w.AccountID,
+		URL:       w.URL,
+		Secret:    w.Secret,
+		Events:    splitList(w.Events),
+		CreatedBy: w.CreatedBy,
+		Created:   w.Created,
+	}
+}
+
+func importWebhook(w *persistence.Webhook) Webhook {
+	return Webhook{
+		WebhookID: w.WebhookID,
+		AccountID: w.AccountID,
+		URL:       w.URL,
+		Secret:    w.Secret,
+		Events:    strings.Join(w.Events, ","),
+		CreatedBy: w.CreatedBy,
+		Created:   w.Created,
+	}
+}
+
+// WebhookDelivery records the delivery of a payload to a webhook.
+type WebhookDelivery struct {
+	DeliveryID string `gorm:"primary_key"`
+	WebhookID  string `gorm:"index"`
+	Event      string
+	Payload    string `gorm:"type:text"`
+	Attempts   int
+	StatusCode int
+	Error      string `gorm:"type:text"`
+	Delivered  bool
+	Created    time.Time
+	Updated    time.Time
+}
+
+func (w *WebhookDelivery) export() persistence.WebhookDelivery {
+	return persistence.WebhookDelivery{
+		DeliveryID: w.DeliveryID,
+		WebhookID:  w.WebhookID,
+		Event:      w.Event,
+		Payload:    w.Payload,
+		Attempts:   w.Attempts,
+		StatusCode: w.StatusCode,
+		Error:      w.Error,
+		Delivered:  w.Delivered,
+		Created:    w.Created,
+		Updated:    w.Updated,
+	}
+}
+
+func importWebhookDelivery(w *persistence.WebhookDelivery) WebhookDelivery {
+	return WebhookDelivery{
+		DeliveryID: w.DeliveryID,
+		WebhookID:  w.WebhookID,
+		Event:      w.Event,
+		Payload:    w.Payload,
+		Attempts:   w.Attempts,
+		StatusCode: w.StatusCode,
+		Error:      w.Error,
+		Delivered:  w.Delivered,
+		Created:    w.Created,
+		Updated:    w.Updated,
+	}
+}
+
+// Rollup contains the aggregated metrics of an account for a single period.
+type Rollup struct {
+	RollupID         string    `gorm:"primary_key"`
+	AccountID        string    `gorm:"index:idx_rollups_account_id_period_start"`
+	Period           string    `gorm:"index:idx_rollups_account_id_period_start"`
+	Start            time.Time `gorm:"index:idx_rollups_account_id_period_start"`
+	Events           int
+	Users            int
+	AnonymousEvents  int
+	SamplingRate     float64
+	EncryptedPayload string `gorm:"type:text"`
+	ImportSource     string
+	Updated          time.Time
+}
+
+func (r *Rollup) export() persistence.Rollup {
+	return persistence.Rollup{
+		RollupID:         r.RollupID,
+		AccountID:        r.AccountID,
+		Period:           r.Period,
+		Start:            r.Start,
+		Events:           r.Events,
+		Users:            r.Users,
+		AnonymousEvents:  r.AnonymousEvents,
+		SamplingRate:     r.SamplingRate,
+		EncryptedPayload: r.EncryptedPayload,
+		ImportSource:     r.ImportSource,
+		Updated:          r.Updated,
+	}
+}
+
+func importRollup(r *persistence.Rollup) Rollup {
+	return Rollup{
+		RollupID:         r.RollupID,
+		AccountID:        r.AccountID,
+		Period:           r.Period,
+		Start:            r.Start,
+		Events:           r.Events,
+		Users:            r.Users,
+		AnonymousEvents:  r.AnonymousEvents,
+		SamplingRate:     r.SamplingRate,
+		EncryptedPayload: r.EncryptedPayload,
+		ImportSource:     r.ImportSource,
+		Updated:          r.Updated,
+	}
+}
+
+// Funnel is an ordered sequence of steps defined for an account. Steps are
+// stored as JSON.
+type Funnel struct {
+	FunnelID  string `gorm:"primary_key"`
+	AccountID string `gorm:"index"`
+	Name      string
+	Steps     string `gorm:"type:text"`
+	CreatedBy string
+	Created   time.Time
+}
+
+func (f *Funnel) export() persistence.Funnel {
+	var steps []persistence.FunnelStep
+	json.Unmarshal([]byte(f.Steps), &steps)
+	return persistence.Funnel{
+		FunnelID:  f.FunnelID,
+		AccountID: f.AccountID,
+		Name:      f.Name,
+		Steps:     steps,
+		CreatedBy: f.CreatedBy,
+		Created:   f.Created,
+	}
+}
+
+func importFunnel(f *persistence.Funnel) Funnel {
+	// steps consist of strings only, so encoding cannot fail
+	steps, _ := json.Marshal(f.Steps)
+	return Funnel{
+		FunnelID:  f.FunnelID,
+		AccountID: f.AccountID,
+		Name:      f.Name,
+		Steps:     string(steps),
+		CreatedBy: f.CreatedBy,
+		Created:   f.Created,
+	}
+}
+
+// EventType is a custom event type defined for an account.
+type EventType struct {
+	EventTypeID string `gorm:"primary_key"`
+	AccountID   string `gorm:"index"`
+	Name        string
+	Aggregation string
+	CreatedBy   string
+	Created     time.Time
+}
+
+func (e *EventType) export() persistence.EventType {
+	return persistence.EventType{
+		EventTypeID: e.EventTypeID,
+		AccountID:   e.AccountID,
+		Name:        e.Name,
+		Aggregation: e.Aggregation,
+		CreatedBy:   e.CreatedBy,
+		Created:     e.Created,
+	}
+}
+
+func importEventType(e *persistence.EventType) EventType {
+	return EventType{
+		EventTypeID: e.EventTypeID,
+		AccountID:   e.AccountID,
+		Name:        e.Name,
+		Aggregation: e.Aggregation,
+		CreatedBy:   e.CreatedBy,
+		Created:     e.Created,
+	}
+}
+
+// Goal is a conversion goal defined for an account.
+type Goal struct {
+	GoalID    string `gorm:"primary_key"`
+	AccountID string `gorm:"index"`
+	Name      string
+	Type      string
+	Value     string
+	CreatedBy string
+	Created   time.Time
+}
+
+func (g *Goal) export() persistence.Goal {
+	return persistence.Goal{
+		GoalID:    g.GoalID,
+		AccountID: g.AccountID,
+		Name:      g.Name,
+		Type:      g.Type,
+		Value:     g.Value,
+		CreatedBy: g.CreatedBy,
+		Created:   g.Created,
+	}
+}
+
+func importGoal(g *persistence.Goal) Goal {
+	return Goal{
+		GoalID:    g.GoalID,
+		AccountID: g.AccountID,
+		Name:      g.Name,
+		Type:      g.Type,
+		Value:     g.Value,
+		CreatedBy: g.CreatedBy,
+		Created:   g.Created,
+	}
+}
+
+// ReportSubscription requests periodic reports of an account to be emailed
+// to an account user.
+type ReportSubscription struct {
+	ReportSubscriptionID string `gorm:"primary_key"`
+	AccountUserID        string `gorm:"index"`
+	AccountID            string
+	EmailAddress         string
+	Frequency            string
+	Created              time.Time
+}
+
+func (r *ReportSubscription) export() persistence.ReportSubscription {
+	return persistence.ReportSubscription{
+		ReportSubscriptionID: r.ReportSubscriptionID,
+		AccountUserID:        r.AccountUserID,
+		AccountID:            r.AccountID,
+		EmailAddress:         r.EmailAddress,
+		Frequency:            r.Frequency,
+		Created:              r.Created,
+	}
+}
+
+func importReportSubscription(r *persistence.ReportSubscription) ReportSubscription {
+	return ReportSubscription{
+		ReportSubscriptionID: r.ReportSubscriptionID,
+		AccountUserID:        r.AccountUserID,
+		AccountID:            r.AccountID,
+		EmailAddress:         r.EmailAddress,
+		Frequency:            r.Frequency,
+		Created:              r.Created,
+	}
+}
+
+// ServiceAccount is a non-interactive account user used for automation.
+type ServiceAccount struct {
+	ServiceAccountID string `gorm:"primary_key"`
+	Name             string
+	HashedSecret     string
+	Salt             string
+	CreatedBy        string
+	Created          time.Time
+}
+
+func (s *ServiceAccount) export() persistence.ServiceAccount {
+	return persistence.ServiceAccount{
+		ServiceAccountID: s.ServiceAccountID,
+		Name:             s.Name,
+		HashedSecret:     s.HashedSecret,
+		Salt:             s.Salt,
+		CreatedBy:        s.CreatedBy,
+		Created:          s.Created,
+	}
+}
+
+func importServiceAccount(s *persistence.ServiceAccount) ServiceAccount {
+	return ServiceAccount{
+		ServiceAccountID: s.ServiceAccountID,
+		Name:             s.Name,
+		HashedSecret:     s.HashedSecret,
+		Salt:             s.Salt,
+		CreatedBy:        s.CreatedBy,
+		Created:          s.Created,
+	}
+}
+
+// Session is a login of an account user.
+type Session struct {
+	SessionID          string `gorm:"primary_key"`
+	AccountUserID      string `gorm:"index"`
+	IPAddress          string
+	UserAgent          string `gorm:"type:text"`
+	Created            time.Time
+	Expires            time.Time
+	ImpersonatedBy     string
+	HashedRefreshToken string
+	RefreshExpires     *time.Time
+}
+
+func (s *Session) export() persistence.Session {
+	result := persistence.Session{
+		SessionID:          s.SessionID,
+		AccountUserID:      s.AccountUserID,
+		IPAddress:          s.IPAddress,
+		UserAgent:          s.UserAgent,
+		Created:            s.Created,
+		Expires:            s.Expires,
+		ImpersonatedBy:     s.ImpersonatedBy,
+		HashedRefreshToken: s.HashedRefreshToken,
+	}
+	if s.RefreshExpires != nil {
+		result.RefreshExpires = *s.RefreshExpires
+	}
+	return result
+}
+
+func importSession(s *persistence.Session) Session {
+	result := Session{
+		SessionID:      s.SessionID,
+		AccountUserID:  s.AccountUserID,
+		IPAddress:      s.IPAddress,
+		UserAgent:      s.UserAgent,
+		Created:        s.Created,
+		Expires:        s.Expires,
+		ImpersonatedBy: s.ImpersonatedBy,
+	}
+	if s.HashedRefreshToken != "" {
+		result.HashedRefreshToken = s.HashedRefreshToken
+		refreshExpires := s.RefreshExpires
+		result.RefreshExpires = &refreshExpires
+	}
+	return result
+}
+
 // Account stores information about an account.
 type Account struct {
-	AccountID           string `gorm:"primary_key"`
-	Name                string
-	PublicKey           string `gorm:"type:text"`
-	EncryptedPrivateKey string `gorm:"type:text"`
-	UserSalt            string
-	Retired             bool
-	Created             time.Time
-	Events              []Event `gorm:"foreignkey:AccountID;association_foreignkey:AccountID"`
+	AccountID                       string `gorm:"primary_key"`
+	Name                            string
+	PublicKey                       string `gorm:"type:text"`
+	EncryptedPrivateKey             string `gorm:"type:text"`
+	EscrowEncryptedKeyEncryptionKey string `gorm:"type:text"`
+	EscrowKeyID                     string
+	UserSalt                        string
+	Retired                         bool
+	AllowedOrigins                  string `gorm:"type:text"`
+	PrivacySignals                  string
+	Timezone                        string
+	AnomalySensitivity              string
+	Domains                         string `gorm:"type:text"`
+	Created                         time.Time
+	Events                          []Event `gorm:"foreignkey:AccountID;association_foreignkey:AccountID"`
 }
 
 func (a *Account) export() persistence.Account {
@@ -183,14 +670,21 @@ func (a *Account) export() persistence.Account {
 		events = append(events, e.export())
 	}
 	return persistence.Account{
-		AccountID:           a.AccountID,
-		Name:                a.Name,
-		PublicKey:           a.PublicKey,
-		EncryptedPrivateKey: a.EncryptedPrivateKey,
-		UserSalt:            a.UserSalt,
-		Retired:             a.Retired,
-		Created:             a.Created,
-		Events:              events,
+		AccountID:                       a.AccountID,
+		Name:                            a.Name,
+		PublicKey:                       a.PublicKey,
+		EncryptedPrivateKey:             a.EncryptedPrivateKey,
+		EscrowEncryptedKeyEncryptionKey: a.EscrowEncryptedKeyEncryptionKey,
+		EscrowKeyID:                     a.EscrowKeyID,
+		UserSalt:                        a.UserSalt,
+		Retired:                         a.Retired,
+		AllowedOrigins:                  a.AllowedOrigins,
+		PrivacySignals:                  a.PrivacySignals,
+		Timezone:                        a.Timezone,
+		AnomalySensitivity:              a.AnomalySensitivity,
+		Domains:                         a.Domains,
+		Created:                         a.Created,
+		Events:                          events,
 	}
 }
 
@@ -200,13 +694,52 @@ func importAccount(a *persistence.Account) Account {
 		events = append(events, importEvent(&e))
 	}
 	return Account{
-		AccountID:           a.AccountID,
-		Name:                a.Name,
-		PublicKey:           a.PublicKey,
-		EncryptedPrivateKey: a.EncryptedPrivateKey,
-		UserSalt:            a.UserSalt,
-		Retired:             a.Retired,
-		Created:             a.Created,
-		Events:              events,
+		AccountID:                       a.AccountID,
+		Name:                            a.Name,
+		PublicKey:                       a.PublicKey,
+		EncryptedPrivateKey:             a.EncryptedPrivateKey,
+		EscrowEncryptedKeyEncryptionKey: a.EscrowEncryptedKeyEncryptionKey,
+		EscrowKeyID:                     a.EscrowKeyID,
+		UserSalt:                        a.UserSalt,
+		Retired:                         a.Retired,
+		AllowedOrigins:                  a.AllowedOrigins,
+		PrivacySignals:                  a.PrivacySignals,
+		Timezone:                        a.Timezone,
+		AnomalySensitivity:              a.AnomalySensitivity,
+		Domains:                         a.Domains,
+		Created:                         a.Created,
+		Events:                          events,
+	}
+}
+
+// AuthEvent records an authentication related action of an account user.
+type AuthEvent struct {
+	EventID       string `gorm:"primary_key"`
+	AccountUserID string `gorm:"index"`
+	Type          string
+	IPAddress     string
+	UserAgent     string `gorm:"type:text"`
+	Created       time.Time
+}
+
+func (a *AuthEvent) export() persistence.AuthEvent {
+	return persistence.AuthEvent{
+		EventID:       a.EventID,
+		AccountUserID: a.AccountUserID,
+		Type:          a.Type,
+		IPAddress:     a.IPAddress,
+		UserAgent:     a.UserAgent,
+		Created:       a.Created,
+	}
+}
+
+func importAuthEvent(a *persistence.AuthEvent) AuthEvent {
+	return AuthEvent{
+		EventID:       a.EventID,
+		AccountUserID: a.AccountUserID,
+		Type:          a.Type,
+		IPAddress:     a.IPAddress,
+		UserAgent:     a.UserAgent,
+		Created:       a.Created,
 	}
 }
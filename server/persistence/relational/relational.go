@@ -43,6 +43,18 @@ var knownTables = []interface{}{
 	&AccountUser{},
 	&AccountUserRelationship{},
 	&Tombstone{},
+	&WebAuthnCredential{},
+	&Session{},
+	&AccessToken{},
+	&ServiceAccount{},
+	&AuthEvent{},
+	&Webhook{},
+	&WebhookDelivery{},
+	&Rollup{},
+	&Funnel{},
+	&EventType{},
+	&Goal{},
+	&ReportSubscription{},
 }
 
 func (r *relationalDAL) ProbeEmpty() bool {
@@ -69,6 +81,18 @@ func (r *relationalDAL) DropAll() error {
 		&Secret{},
 		&AccountUser{},
 		&AccountUserRelationship{},
+		&WebAuthnCredential{},
+		&Session{},
+		&AccessToken{},
+		&ServiceAccount{},
+		&AuthEvent{},
+		&Webhook{},
+		&WebhookDelivery{},
+		&Rollup{},
+		&Funnel{},
+		&EventType{},
+		&Goal{},
+		&ReportSubscription{},
 		"migrations",
 	).Error; err != nil {
 		return fmt.Errorf("relational: error dropping tables: %w,", err)
@@ -15,7 +15,7 @@ func createTestDatabase() (*gorm.DB, func() error) {
 	if err != nil {
 		panic(err)
 	}
-	if err := db.AutoMigrate(&Event{}, &Account{}, &Secret{}, &AccountUser{}, &AccountUserRelationship{}, &Tombstone{}).Error; err != nil {
+	if err := db.AutoMigrate(&Event{}, &Account{}, &Secret{}, &AccountUser{}, &AccountUserRelationship{}, &Tombstone{}, &WebAuthnCredential{}, &Session{}, &AccessToken{}, &ServiceAccount{}, &AuthEvent{}, &Webhook{}, &WebhookDelivery{}, &Rollup{}, &Funnel{}, &EventType{}, &Goal{}, &ReportSubscription{}).Error; err != nil {
 		panic(err)
 	}
 	return db, db.Close
@@ -5,6 +5,7 @@ package relational
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/offen/offen/server/persistence"
 )
@@ -24,6 +25,11 @@ func (r *relationalDAL) DeleteAccountUserRelationships(q interface{}) error {
 			return fmt.Errorf("relational: error deleting relationships for account %s: %w", query, err)
 		}
 		return nil
+	case persistence.DeleteAccountUserRelationshipsQueryByAccountUserID:
+		if err := r.db.Where("account_user_id = ?", query).Delete(&AccountUserRelationship{}).Error; err != nil {
+			return fmt.Errorf("relational: error deleting relationships for account user %s: %w", query, err)
+		}
+		return nil
 	default:
 		return persistence.ErrBadQuery
 	}
@@ -41,6 +47,36 @@ func (r *relationalDAL) FindAccountUserRelationships(q interface{}) ([]persisten
 			result = append(result, r.export())
 		}
 		return result, nil
+	case persistence.FindAccountUserRelationshipsQueryByAccountID:
+		if err := r.db.Where("account_id = ?", string(query)).Find(&relationships).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up relationships for account: %w", err)
+		}
+		result := []persistence.AccountUserRelationship{}
+		for _, r := range relationships {
+			result = append(result, r.export())
+		}
+		return result, nil
+	case persistence.FindAccountUserRelationshipsQueryBatch:
+		if err := r.db.Where("relationship_id > ?", query.After).Order("relationship_id").Limit(query.Limit).Find(&relationships).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up batch of relationships: %w", err)
+		}
+		result := []persistence.AccountUserRelationship{}
+		for _, r := range relationships {
+			result = append(result, r.export())
+		}
+		return result, nil
+	case persistence.FindAccountUserRelationshipsQueryStaleOneTimeKeys:
+		if err := r.db.Where(
+			"one_time_encrypted_key_encryption_key <> ? AND (one_time_key_expires IS NULL OR one_time_key_expires < ?)",
+			"", time.Time(query),
+		).Find(&relationships).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up relationships with stale one time keys: %w", err)
+		}
+		result := []persistence.AccountUserRelationship{}
+		for _, r := range relationships {
+			result = append(result, r.export())
+		}
+		return result, nil
 	default:
 		return nil, persistence.ErrBadQuery
 	}
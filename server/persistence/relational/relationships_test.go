@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/offen/offen/server/persistence"
@@ -95,6 +96,82 @@ func TestRelationalDAL_FindAccountUserRelationships(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"batch",
+			func(db *gorm.DB) error {
+				for _, id := range []string{"relationship-c", "relationship-a", "relationship-d", "relationship-b"} {
+					if err := db.Save(&AccountUserRelationship{
+						RelationshipID: id,
+					}).Error; err != nil {
+						return fmt.Errorf("error saving fixtures: %w", err)
+					}
+				}
+				return nil
+			},
+			persistence.FindAccountUserRelationshipsQueryBatch{After: "relationship-a", Limit: 2},
+			[]persistence.AccountUserRelationship{
+				{RelationshipID: "relationship-b"},
+				{RelationshipID: "relationship-c"},
+			},
+			false,
+		},
+		{
+			"stale one time keys",
+			func(db *gorm.DB) error {
+				past := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+				future := time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)
+				for _, r := range []AccountUserRelationship{
+					{RelationshipID: "relationship-a", OneTimeEncryptedKeyEncryptionKey: "key", OneTimeKeyExpires: &past},
+					{RelationshipID: "relationship-b", OneTimeEncryptedKeyEncryptionKey: "key", OneTimeKeyExpires: &future},
+					{RelationshipID: "relationship-c", OneTimeEncryptedKeyEncryptionKey: "key"},
+					{RelationshipID: "relationship-d"},
+				} {
+					if err := db.Save(&r).Error; err != nil {
+						return fmt.Errorf("error saving fixtures: %w", err)
+					}
+				}
+				return nil
+			},
+			persistence.FindAccountUserRelationshipsQueryStaleOneTimeKeys(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)),
+			[]persistence.AccountUserRelationship{
+				{
+					RelationshipID:                   "relationship-a",
+					OneTimeEncryptedKeyEncryptionKey: "key",
+					OneTimeKeyExpires: func() *time.Time {
+						t := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+						return &t
+					}(),
+				},
+				{RelationshipID: "relationship-c", OneTimeEncryptedKeyEncryptionKey: "key"},
+			},
+			false,
+		},
+		{
+			"by account id",
+			func(db *gorm.DB) error {
+				for _, id := range []string{"relationship-a", "relationship-b"} {
+					if err := db.Save(&AccountUserRelationship{
+						RelationshipID: id,
+						AccountID:      "account-a",
+					}).Error; err != nil {
+						return fmt.Errorf("error saving fixtures: %w", err)
+					}
+				}
+				if err := db.Save(&AccountUserRelationship{
+					RelationshipID: "relationship-z",
+					AccountID:      "account-b",
+				}).Error; err != nil {
+					return fmt.Errorf("error saving fixtures: %w", err)
+				}
+				return nil
+			},
+			persistence.FindAccountUserRelationshipsQueryByAccountID("account-a"),
+			[]persistence.AccountUserRelationship{
+				{RelationshipID: "relationship-a", AccountID: "account-a"},
+				{RelationshipID: "relationship-b", AccountID: "account-a"},
+			},
+			false,
+		},
 	}
 
 	for _, test := range tests {
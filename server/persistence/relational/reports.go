@@ -0,0 +1,54 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"fmt"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func (r *relationalDAL) SaveReportSubscription(s *persistence.ReportSubscription) error {
+	local := importReportSubscription(s)
+	if err := r.db.Save(&local).Error; err != nil {
+		return fmt.Errorf("relational: error saving report subscription: %w", err)
+	}
+	return nil
+}
+
+func (r *relationalDAL) FindReportSubscriptions(q interface{}) ([]persistence.ReportSubscription, error) {
+	var subscriptions []ReportSubscription
+	switch query := q.(type) {
+	case persistence.FindReportSubscriptionsQueryByAccountUserID:
+		if err := r.db.Where("account_user_id = ?", string(query)).Order("created").Find(&subscriptions).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up report subscriptions: %w", err)
+		}
+	case persistence.FindReportSubscriptionsQueryByFrequency:
+		if err := r.db.Where("frequency = ?", string(query)).Order("created").Find(&subscriptions).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up report subscriptions: %w", err)
+		}
+	default:
+		return nil, persistence.ErrBadQuery
+	}
+	var result []persistence.ReportSubscription
+	for _, subscription := range subscriptions {
+		result = append(result, subscription.export())
+	}
+	return result, nil
+}
+
+func (r *relationalDAL) DeleteReportSubscriptions(q interface{}) error {
+	switch query := q.(type) {
+	case persistence.DeleteReportSubscriptionsQueryByAccountID:
+		if err := r.db.Where(
+			"account_user_id = ? AND account_id = ?",
+			query.AccountUserID, query.AccountID,
+		).Delete(&ReportSubscription{}).Error; err != nil {
+			return fmt.Errorf("relational: error deleting report subscription: %w", err)
+		}
+		return nil
+	default:
+		return persistence.ErrBadQuery
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestRelationalDAL_ReportSubscriptions(t *testing.T) {
+	db, closeDB := createTestDatabase()
+	defer closeDB()
+	dal := NewRelationalDAL(db)
+
+	now := time.Now()
+	for _, subscription := range []persistence.ReportSubscription{
+		{ReportSubscriptionID: "subscription-a", AccountUserID: "user-a", AccountID: "account-a", EmailAddress: "develop@offen.dev", Frequency: "weekly", Created: now.Add(-time.Hour)},
+		{ReportSubscriptionID: "subscription-b", AccountUserID: "user-a", AccountID: "account-b", EmailAddress: "develop@offen.dev", Frequency: "monthly", Created: now},
+		{ReportSubscriptionID: "subscription-c", AccountUserID: "user-b", AccountID: "account-a", EmailAddress: "other@offen.dev", Frequency: "weekly", Created: now},
+	} {
+		if err := dal.SaveReportSubscription(&subscription); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	if err := dal.SaveReportSubscription(&persistence.ReportSubscription{
+		ReportSubscriptionID: "subscription-b", AccountUserID: "user-a", AccountID: "account-b", EmailAddress: "develop@offen.dev", Frequency: "weekly", Created: now,
+	}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if _, err := dal.FindReportSubscriptions(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+	subscriptions, err := dal.FindReportSubscriptions(persistence.FindReportSubscriptionsQueryByAccountUserID("user-a"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(subscriptions) != 2 || subscriptions[0].ReportSubscriptionID != "subscription-a" || subscriptions[1].Frequency != "weekly" {
+		t.Errorf("Unexpected subscriptions %v", subscriptions)
+	}
+	subscriptions, err = dal.FindReportSubscriptions(persistence.FindReportSubscriptionsQueryByFrequency("weekly"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(subscriptions) != 3 {
+		t.Errorf("Unexpected subscriptions %v", subscriptions)
+	}
+
+	if err := dal.DeleteReportSubscriptions(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+	if err := dal.DeleteReportSubscriptions(persistence.DeleteReportSubscriptionsQueryByAccountID{
+		AccountUserID: "user-a", AccountID: "account-a",
+	}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	subscriptions, err = dal.FindReportSubscriptions(persistence.FindReportSubscriptionsQueryByAccountUserID("user-a"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(subscriptions) != 1 || subscriptions[0].ReportSubscriptionID != "subscription-b" {
+		t.Errorf("Unexpected subscriptions %v", subscriptions)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"fmt"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func (r *relationalDAL) SaveRollup(rollup *persistence.Rollup) error {
+	local := importRollup(rollup)
+	if err := r.db.Save(&local).Error; err != nil {
+		return fmt.Errorf("relational: error saving rollup: %w", err)
+	}
+	return nil
+}
+
+func (r *relationalDAL) FindRollups(q interface{}) ([]persistence.Rollup, error) {
+	var rollups []Rollup
+	switch query := q.(type) {
+	case persistence.FindRollupsQueryByAccountID:
+		if err := r.db.Where(
+			"account_id = ? AND period = ? AND start >= ? AND start < ?",
+			query.AccountID, query.Period, query.From, query.Until,
+		).Order("start").Find(&rollups).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up rollups: %w", err)
+		}
+	default:
+		return nil, persistence.ErrBadQuery
+	}
+	var result []persistence.Rollup
+	for _, rollup := range rollups {
+		result = append(result, rollup.export())
+	}
+	return result, nil
+}
+
+func (r *relationalDAL) DeleteRollups(q interface{}) error {
+	switch query := q.(type) {
+	case persistence.DeleteRollupsQueryByAccountID:
+		if err := r.db.Where(
+			"account_id = ? AND period = ? AND start >= ?",
+			query.AccountID, query.Period, query.From,
+		).Delete(&Rollup{}).Error; err != nil {
+			return fmt.Errorf("relational: error deleting rollups: %w", err)
+		}
+		return nil
+	default:
+		return persistence.ErrBadQuery
+	}
+}
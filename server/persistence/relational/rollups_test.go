@@ -0,0 +1,86 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestRelationalDAL_Rollups(t *testing.T) {
+	db, closeDB := createTestDatabase()
+	defer closeDB()
+	dal := NewRelationalDAL(db)
+
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, rollup := range []persistence.Rollup{
+		{RollupID: "rollup-a", AccountID: "account-a", Period: "hour", Start: day, Events: 1},
+		{RollupID: "rollup-b", AccountID: "account-a", Period: "hour", Start: day.Add(time.Hour), Events: 2},
+		{RollupID: "rollup-c", AccountID: "account-a", Period: "day", Start: day, Events: 3},
+		{RollupID: "rollup-d", AccountID: "account-b", Period: "hour", Start: day, Events: 4},
+	} {
+		if err := dal.SaveRollup(&rollup); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	if err := dal.SaveRollup(&persistence.Rollup{
+		RollupID: "rollup-b", AccountID: "account-a", Period: "hour", Start: day.Add(time.Hour),
+		Events: 5, SamplingRate: 0.5, EncryptedPayload: "payload",
+	}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if _, err := dal.FindRollups(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+	rollups, err := dal.FindRollups(persistence.FindRollupsQueryByAccountID{
+		AccountID: "account-a",
+		Period:    "hour",
+		From:      day,
+		Until:     day.Add(time.Hour * 2),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(rollups) != 2 || rollups[0].RollupID != "rollup-a" || rollups[1].Events != 5 || rollups[1].SamplingRate != 0.5 || rollups[1].EncryptedPayload != "payload" {
+		t.Errorf("Unexpected rollups %v", rollups)
+	}
+	rollups, err = dal.FindRollups(persistence.FindRollupsQueryByAccountID{
+		AccountID: "account-a",
+		Period:    "hour",
+		From:      day.Add(time.Hour),
+		Until:     day.Add(time.Hour * 2),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(rollups) != 1 || rollups[0].RollupID != "rollup-b" {
+		t.Errorf("Unexpected rollups %v", rollups)
+	}
+
+	if err := dal.DeleteRollups(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+	if err := dal.DeleteRollups(persistence.DeleteRollupsQueryByAccountID{
+		AccountID: "account-a",
+		Period:    "hour",
+		From:      day.Add(time.Hour),
+	}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	rollups, err = dal.FindRollups(persistence.FindRollupsQueryByAccountID{
+		AccountID: "account-a",
+		Period:    "hour",
+		From:      day,
+		Until:     day.AddDate(0, 0, 1),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(rollups) != 1 || rollups[0].RollupID != "rollup-a" {
+		t.Errorf("Unexpected rollups %v", rollups)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"fmt"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func (r *relationalDAL) CreateServiceAccount(s *persistence.ServiceAccount) error {
+	local := importServiceAccount(s)
+	if err := r.db.Create(&local).Error; err != nil {
+		return fmt.Errorf("relational: error creating service account: %w", err)
+	}
+	return nil
+}
+
+func (r *relationalDAL) FindServiceAccount(q interface{}) (persistence.ServiceAccount, error) {
+	var serviceAccount ServiceAccount
+	switch query := q.(type) {
+	case persistence.FindServiceAccountQueryByID:
+		if err := r.db.Where("service_account_id = ?", string(query)).First(&serviceAccount).Error; err != nil {
+			return serviceAccount.export(), fmt.Errorf("relational: error looking up service account: %w", err)
+		}
+		return serviceAccount.export(), nil
+	default:
+		return serviceAccount.export(), persistence.ErrBadQuery
+	}
+}
+
+func (r *relationalDAL) FindServiceAccounts(q interface{}) ([]persistence.ServiceAccount, error) {
+	var serviceAccounts []ServiceAccount
+	switch q.(type) {
+	case persistence.FindServiceAccountsQueryAllServiceAccounts:
+		if err := r.db.Order("created DESC").Find(&serviceAccounts).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up service accounts: %w", err)
+		}
+		var result []persistence.ServiceAccount
+		for _, serviceAccount := range serviceAccounts {
+			result = append(result, serviceAccount.export())
+		}
+		return result, nil
+	default:
+		return nil, persistence.ErrBadQuery
+	}
+}
+
+func (r *relationalDAL) DeleteServiceAccounts(q interface{}) error {
+	switch query := q.(type) {
+	case persistence.DeleteServiceAccountsQueryByID:
+		if err := r.db.Where("service_account_id = ?", string(query)).Delete(&ServiceAccount{}).Error; err != nil {
+			return fmt.Errorf("relational: error deleting service account: %w", err)
+		}
+		return nil
+	default:
+		return persistence.ErrBadQuery
+	}
+}
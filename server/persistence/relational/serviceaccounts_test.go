@@ -0,0 +1,56 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestRelationalDAL_ServiceAccounts(t *testing.T) {
+	db, closeDB := createTestDatabase()
+	defer closeDB()
+	dal := NewRelationalDAL(db)
+
+	now := time.Now()
+	for _, serviceAccount := range []persistence.ServiceAccount{
+		{ServiceAccountID: "service-a", Name: "ci", CreatedBy: "user-a", Created: now.Add(-time.Hour)},
+		{ServiceAccountID: "service-b", Name: "export", CreatedBy: "user-b", Created: now},
+	} {
+		if err := dal.CreateServiceAccount(&serviceAccount); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	if _, err := dal.FindServiceAccount(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+	serviceAccount, err := dal.FindServiceAccount(persistence.FindServiceAccountQueryByID("service-a"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if serviceAccount.Name != "ci" || serviceAccount.CreatedBy != "user-a" {
+		t.Errorf("Unexpected service account %v", serviceAccount)
+	}
+
+	serviceAccounts, err := dal.FindServiceAccounts(persistence.FindServiceAccountsQueryAllServiceAccounts{})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(serviceAccounts) != 2 || serviceAccounts[0].ServiceAccountID != "service-b" {
+		t.Errorf("Unexpected service accounts %v", serviceAccounts)
+	}
+
+	if err := dal.DeleteServiceAccounts(persistence.DeleteServiceAccountsQueryByID("service-a")); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, err := dal.FindServiceAccount(persistence.FindServiceAccountQueryByID("service-a")); err == nil {
+		t.Error("Expected service account to be deleted")
+	}
+	if _, err := dal.FindServiceAccount(persistence.FindServiceAccountQueryByID("service-b")); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
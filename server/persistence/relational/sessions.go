@@ -0,0 +1,105 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func (r *relationalDAL) CreateSession(s *persistence.Session) error {
+	local := importSession(s)
+	if err := r.db.Create(&local).Error; err != nil {
+		return fmt.Errorf("relational: error creating session: %w", err)
+	}
+	return nil
+}
+
+func (r *relationalDAL) FindSession(q interface{}) (persistence.Session, error) {
+	var session Session
+	switch query := q.(type) {
+	case persistence.FindSessionQueryByID:
+		if err := r.db.Where("session_id = ?", string(query)).First(&session).Error; err != nil {
+			return session.export(), fmt.Errorf("relational: error looking up session: %w", err)
+		}
+		return session.export(), nil
+	default:
+		return session.export(), persistence.ErrBadQuery
+	}
+}
+
+func (r *relationalDAL) FindSessions(q interface{}) ([]persistence.Session, error) {
+	var sessions []Session
+	switch query := q.(type) {
+	case persistence.FindSessionsQueryByAccountUserID:
+		if err := r.db.Where("account_user_id = ?", string(query)).Order("created DESC").Find(&sessions).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up sessions: %w", err)
+		}
+		var result []persistence.Session
+		for _, session := range sessions {
+			result = append(result, session.export())
+		}
+		return result, nil
+	default:
+		return nil, persistence.ErrBadQuery
+	}
+}
+
+func (r *relationalDAL) UpdateSession(s *persistence.Session) error {
+	local := importSession(s)
+	exists := r.db.Where("session_id = ?", local.SessionID).First(&Session{}).Error
+	if exists != nil {
+		return fmt.Errorf("relational: error looking up session for update: %w", exists)
+	}
+	if err := r.db.Save(&local).Error; err != nil {
+		return fmt.Errorf("relational: error updating session: %w", err)
+	}
+	return nil
+}
+
+func (r *relationalDAL) RotateSession(s *persistence.Session, hashedRefreshToken string) (int64, error) {
+	local := importSession(s)
+	update := r.db.Model(&Session{}).Where(
+		"session_id = ? AND hashed_refresh_token = ?",
+		local.SessionID, hashedRefreshToken,
+	).Updates(map[string]interface{}{
+		"expires":              local.Expires,
+		"hashed_refresh_token": local.HashedRefreshToken,
+		"refresh_expires":      local.RefreshExpires,
+	})
+	if update.Error != nil {
+		return 0, fmt.Errorf("relational: error rotating session: %w", update.Error)
+	}
+	return update.RowsAffected, nil
+}
+
+func (r *relationalDAL) DeleteSessions(q interface{}) error {
+	switch query := q.(type) {
+	case persistence.DeleteSessionsQueryByID:
+		if err := r.db.Where(
+			"session_id = ? AND account_user_id = ?",
+			query.SessionID, query.AccountUserID,
+		).Delete(&Session{}).Error; err != nil {
+			return fmt.Errorf("relational: error deleting session: %w", err)
+		}
+		return nil
+	case persistence.DeleteSessionsQueryByAccountUserID:
+		if err := r.db.Where("account_user_id = ?", string(query)).Delete(&Session{}).Error; err != nil {
+			return fmt.Errorf("relational: error deleting sessions: %w", err)
+		}
+		return nil
+	case persistence.DeleteSessionsQueryExpired:
+		if err := r.db.Where(
+			"expires < ? AND (refresh_expires IS NULL OR refresh_expires < ?)",
+			time.Time(query), time.Time(query),
+		).Delete(&Session{}).Error; err != nil {
+			return fmt.Errorf("relational: error deleting expired sessions: %w", err)
+		}
+		return nil
+	default:
+		return persistence.ErrBadQuery
+	}
+}
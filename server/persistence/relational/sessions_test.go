@@ -0,0 +1,103 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestRelationalDAL_Sessions(t *testing.T) {
+	db, closeDB := createTestDatabase()
+	defer closeDB()
+	dal := NewRelationalDAL(db)
+
+	now := time.Now()
+	for _, session := range []persistence.Session{
+		{SessionID: "session-a", AccountUserID: "user-a", Created: now.Add(-time.Hour), Expires: now.Add(time.Hour)},
+		{SessionID: "session-b", AccountUserID: "user-a", Created: now, Expires: now.Add(time.Hour)},
+		{SessionID: "session-c", AccountUserID: "user-a", Created: now.Add(-time.Hour * 48), Expires: now.Add(-time.Hour * 24)},
+		{SessionID: "session-d", AccountUserID: "user-b", Created: now, Expires: now.Add(time.Hour)},
+		{SessionID: "session-e", AccountUserID: "user-b", Created: now.Add(-time.Hour * 48), Expires: now.Add(-time.Hour * 24), HashedRefreshToken: "hashed-token", RefreshExpires: now.Add(time.Hour)},
+	} {
+		if err := dal.CreateSession(&session); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	if _, err := dal.FindSession(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+	session, err := dal.FindSession(persistence.FindSessionQueryByID("session-d"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if session.AccountUserID != "user-b" {
+		t.Errorf("Unexpected session %v", session)
+	}
+
+	sessions, err := dal.FindSessions(persistence.FindSessionsQueryByAccountUserID("user-a"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(sessions) != 3 || sessions[0].SessionID != "session-b" {
+		t.Errorf("Unexpected sessions %v", sessions)
+	}
+
+	if err := dal.DeleteSessions(persistence.DeleteSessionsQueryExpired(now)); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, err := dal.FindSession(persistence.FindSessionQueryByID("session-c")); err == nil {
+		t.Error("Expected expired session to be deleted")
+	}
+	if _, err := dal.FindSession(persistence.FindSessionQueryByID("session-e")); err != nil {
+		t.Errorf("Expected refreshable session to be retained, got %v", err)
+	}
+
+	session.HashedRefreshToken, session.RefreshExpires = "other-token", now.Add(time.Hour)
+	if err := dal.UpdateSession(&session); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if session, _ := dal.FindSession(persistence.FindSessionQueryByID("session-d")); session.HashedRefreshToken != "other-token" || session.RefreshExpires.IsZero() {
+		t.Errorf("Unexpected updated session %v", session)
+	}
+	if err := dal.UpdateSession(&persistence.Session{SessionID: "session-z"}); err == nil {
+		t.Error("Expected error when updating unknown session")
+	}
+
+	// the token can only be replaced once
+	session.HashedRefreshToken = "next-token"
+	for _, expectedUpdates := range []int64{1, 0} {
+		updated, err := dal.RotateSession(&session, "other-token")
+		if err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if updated != expectedUpdates {
+			t.Errorf("Expected %d updated sessions, got %d", expectedUpdates, updated)
+		}
+	}
+	if session, _ := dal.FindSession(persistence.FindSessionQueryByID("session-d")); session.HashedRefreshToken != "next-token" {
+		t.Errorf("Unexpected rotated session %v", session)
+	}
+
+	// sessions of other account users are not deleted
+	if err := dal.DeleteSessions(persistence.DeleteSessionsQueryByID{
+		SessionID: "session-d", AccountUserID: "user-a",
+	}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, err := dal.FindSession(persistence.FindSessionQueryByID("session-d")); err != nil {
+		t.Errorf("Expected session to be retained, got %v", err)
+	}
+
+	if err := dal.DeleteSessions(persistence.DeleteSessionsQueryByAccountUserID("user-a")); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	sessions, _ = dal.FindSessions(persistence.FindSessionsQueryByAccountUserID("user-a"))
+	if len(sessions) != 0 {
+		t.Errorf("Expected all sessions to be deleted, got %v", sessions)
+	}
+}
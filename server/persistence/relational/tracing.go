@@ -0,0 +1,75 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/tracing"
+)
+
+const (
+	traceContextKey = "offen:trace_context"
+	traceSpanKey    = "offen:trace_span"
+)
+
+// WithContext returns a data access layer that creates the spans for its
+// queries as children of the span found in the given context.
+func (r *relationalDAL) WithContext(ctx context.Context) persistence.DataAccessLayer {
+	return &relationalDAL{r.db.Set(traceContextKey, ctx)}
+}
+
+// TraceQueries registers callbacks on the given database that create a
+// span for each query that is run using a data access layer that has been
+// given a context.
+func TraceQueries(db *gorm.DB) {
+	start := func(operation string) func(*gorm.Scope) {
+		return func(scope *gorm.Scope) {
+			value, ok := scope.Get(traceContextKey)
+			if !ok {
+				return
+			}
+			ctx, ok := value.(context.Context)
+			if !ok {
+				return
+			}
+			_, span := tracing.Start(ctx, "gorm."+operation, tracing.SpanKindClient)
+			span.SetAttribute("db.system", scope.Dialect().GetName())
+			span.SetAttribute("db.operation", operation)
+			if operation != "row_query" {
+				span.SetAttribute("db.sql.table", scope.TableName())
+			}
+			scope.Set(traceSpanKey, span)
+		}
+	}
+	end := func(scope *gorm.Scope) {
+		value, ok := scope.Get(traceSpanKey)
+		if !ok {
+			return
+		}
+		span, ok := value.(*tracing.Span)
+		if !ok {
+			return
+		}
+		// the statement only contains placeholders, values are never added
+		span.SetAttribute("db.statement", scope.SQL)
+		if err := scope.DB().Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+			span.RecordError(err)
+		}
+		span.End()
+	}
+	callbacks := db.Callback()
+	callbacks.Create().Before("gorm:create").Register("offen:trace_start_create", start("create"))
+	callbacks.Create().After("gorm:create").Register("offen:trace_end_create", end)
+	callbacks.Query().Before("gorm:query").Register("offen:trace_start_query", start("query"))
+	callbacks.Query().After("gorm:query").Register("offen:trace_end_query", end)
+	callbacks.Update().Before("gorm:update").Register("offen:trace_start_update", start("update"))
+	callbacks.Update().After("gorm:update").Register("offen:trace_end_update", end)
+	callbacks.Delete().Before("gorm:delete").Register("offen:trace_start_delete", start("delete"))
+	callbacks.Delete().After("gorm:delete").Register("offen:trace_end_delete", end)
+	callbacks.RowQuery().Before("gorm:row_query").Register("offen:trace_start_row_query", start("row_query"))
+	callbacks.RowQuery().After("gorm:row_query").Register("offen:trace_end_row_query", end)
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"context"
+	"testing"
+
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/tracing"
+)
+
+type mockExporter struct {
+	spans []tracing.SpanData
+}
+
+func (m *mockExporter) Export(spans []tracing.SpanData) error {
+	m.spans = append(m.spans, spans...)
+	return nil
+}
+
+func TestTraceQueries(t *testing.T) {
+	db, closeDB := createTestDatabase()
+	defer closeDB()
+	TraceQueries(db)
+
+	exporter := &mockExporter{}
+	tracer := tracing.New(exporter)
+	tracing.SetDefault(tracer)
+	defer tracing.SetDefault(nil)
+
+	dal := NewRelationalDAL(db)
+	// queries without a context are not traced
+	if _, err := dal.FindAccounts(persistence.FindAccountsQueryAllAccounts{}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	ctx, parent := tracing.Start(context.Background(), "parent", tracing.SpanKindServer)
+	traced := dal.(*relationalDAL).WithContext(ctx)
+	if err := traced.CreateAccount(&persistence.Account{AccountID: "account-a"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, err := traced.FindAccounts(persistence.FindAccountsQueryAllAccounts{}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	parent.End()
+	tracer.Shutdown(context.Background())
+
+	if len(exporter.spans) != 3 {
+		t.Fatalf("Unexpected number of spans %d", len(exporter.spans))
+	}
+	for i, name := range []string{"gorm.create", "gorm.query"} {
+		span := exporter.spans[i]
+		if span.Name != name {
+			t.Errorf("Expected span %s, got %s", name, span.Name)
+		}
+		if span.ParentSpanID != parent.Context().SpanID {
+			t.Errorf("Unexpected parent span id %v", span.ParentSpanID)
+		}
+		if span.Attributes["db.sql.table"] != "accounts" {
+			t.Errorf("Unexpected attributes %v", span.Attributes)
+		}
+		if span.Attributes["db.statement"] == "" {
+			t.Errorf("Expected statement to be recorded")
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"fmt"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func (r *relationalDAL) CreateWebAuthnCredential(w *persistence.WebAuthnCredential) error {
+	local := importWebAuthnCredential(w)
+	if err := r.db.Create(&local).Error; err != nil {
+		return fmt.Errorf("relational: error creating webauthn credential: %w", err)
+	}
+	return nil
+}
+
+func (r *relationalDAL) FindWebAuthnCredential(q interface{}) (persistence.WebAuthnCredential, error) {
+	var credential WebAuthnCredential
+	switch query := q.(type) {
+	case persistence.FindWebAuthnCredentialQueryByID:
+		if err := r.db.Where("credential_id = ?", string(query)).First(&credential).Error; err != nil {
+			return credential.export(), fmt.Errorf("relational: error looking up webauthn credential: %w", err)
+		}
+		return credential.export(), nil
+	default:
+		return credential.export(), persistence.ErrBadQuery
+	}
+}
+
+func (r *relationalDAL) UpdateWebAuthnCredential(w *persistence.WebAuthnCredential) error {
+	local := importWebAuthnCredential(w)
+	exists := r.db.Where("credential_id = ?", local.CredentialID).First(&WebAuthnCredential{}).Error
+	if exists != nil {
+		return fmt.Errorf("relational: error looking up webauthn credential for update: %w", exists)
+	}
+	if err := r.db.Save(&local).Error; err != nil {
+		return fmt.Errorf("relational: error updating webauthn credential: %w", err)
+	}
+	return nil
+}
+
+func (r *relationalDAL) DeleteWebAuthnCredentials(q interface{}) error {
+	switch query := q.(type) {
+	case persistence.DeleteWebAuthnCredentialsQueryByID:
+		if err := r.db.Where(
+			"credential_id = ? AND account_user_id = ?",
+			query.CredentialID, query.AccountUserID,
+		).Delete(&WebAuthnCredential{}).Error; err != nil {
+			return fmt.Errorf("relational: error deleting webauthn credential: %w", err)
+		}
+		return nil
+	default:
+		return persistence.ErrBadQuery
+	}
+}
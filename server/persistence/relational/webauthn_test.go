@@ -0,0 +1,62 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"testing"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestRelationalDAL_WebAuthnCredential(t *testing.T) {
+	db, closeDB := createTestDatabase()
+	defer closeDB()
+	dal := NewRelationalDAL(db)
+
+	if err := dal.CreateWebAuthnCredential(&persistence.WebAuthnCredential{
+		CredentialID:  "credential-a",
+		AccountUserID: "user-a",
+		SignCount:     1,
+	}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if _, err := dal.FindWebAuthnCredential(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+	credential, err := dal.FindWebAuthnCredential(persistence.FindWebAuthnCredentialQueryByID("credential-a"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	credential.SignCount = 2
+	if err := dal.UpdateWebAuthnCredential(&credential); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := dal.UpdateWebAuthnCredential(&persistence.WebAuthnCredential{CredentialID: "unknown"}); err == nil {
+		t.Error("Expected error when updating unknown credential")
+	}
+	credential, _ = dal.FindWebAuthnCredential(persistence.FindWebAuthnCredentialQueryByID("credential-a"))
+	if credential.SignCount != 2 {
+		t.Errorf("Expected sign count to be updated, got %v", credential)
+	}
+
+	// credentials of other account users are not deleted
+	if err := dal.DeleteWebAuthnCredentials(persistence.DeleteWebAuthnCredentialsQueryByID{
+		CredentialID: "credential-a", AccountUserID: "user-b",
+	}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, err := dal.FindWebAuthnCredential(persistence.FindWebAuthnCredentialQueryByID("credential-a")); err != nil {
+		t.Errorf("Expected credential to be retained, got %v", err)
+	}
+	if err := dal.DeleteWebAuthnCredentials(persistence.DeleteWebAuthnCredentialsQueryByID{
+		CredentialID: "credential-a", AccountUserID: "user-a",
+	}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, err := dal.FindWebAuthnCredential(persistence.FindWebAuthnCredentialQueryByID("credential-a")); err == nil {
+		t.Error("Expected credential to be deleted")
+	}
+}
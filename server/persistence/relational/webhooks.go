@@ -0,0 +1,109 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func (r *relationalDAL) CreateWebhook(w *persistence.Webhook) error {
+	local := importWebhook(w)
+	if err := r.db.Create(&local).Error; err != nil {
+		return fmt.Errorf("relational: error creating webhook: %w", err)
+	}
+	return nil
+}
+
+func (r *relationalDAL) FindWebhooks(q interface{}) ([]persistence.Webhook, error) {
+	var webhooks []Webhook
+	switch query := q.(type) {
+	case persistence.FindWebhooksQueryByAccountID:
+		if err := r.db.Where("account_id = ?", string(query)).Order("created").Find(&webhooks).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up webhooks: %w", err)
+		}
+	case persistence.FindWebhooksQueryAllWebhooks:
+		if err := r.db.Order("created").Find(&webhooks).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up webhooks: %w", err)
+		}
+	default:
+		return nil, persistence.ErrBadQuery
+	}
+	var result []persistence.Webhook
+	for _, webhook := range webhooks {
+		result = append(result, webhook.export())
+	}
+	return result, nil
+}
+
+func (r *relationalDAL) DeleteWebhooks(q interface{}) error {
+	switch query := q.(type) {
+	case persistence.DeleteWebhooksQueryByID:
+		if err := r.db.Where(
+			"webhook_id = ? AND account_id = ?",
+			query.WebhookID, query.AccountID,
+		).Delete(&Webhook{}).Error; err != nil {
+			return fmt.Errorf("relational: error deleting webhook: %w", err)
+		}
+		return nil
+	default:
+		return persistence.ErrBadQuery
+	}
+}
+
+func (r *relationalDAL) CreateWebhookDelivery(w *persistence.WebhookDelivery) error {
+	local := importWebhookDelivery(w)
+	if err := r.db.Create(&local).Error; err != nil {
+		return fmt.Errorf("relational: error creating webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func (r *relationalDAL) UpdateWebhookDelivery(w *persistence.WebhookDelivery) error {
+	local := importWebhookDelivery(w)
+	if err := r.db.Save(&local).Error; err != nil {
+		return fmt.Errorf("relational: error updating webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func (r *relationalDAL) FindWebhookDeliveries(q interface{}) ([]persistence.WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	switch query := q.(type) {
+	case persistence.FindWebhookDeliveriesQueryByWebhookID:
+		db := r.db.Where("webhook_id = ?", query.WebhookID).Order("created DESC")
+		if query.Limit > 0 {
+			db = db.Limit(query.Limit)
+		}
+		if err := db.Find(&deliveries).Error; err != nil {
+			return nil, fmt.Errorf("relational: error looking up webhook deliveries: %w", err)
+		}
+		var result []persistence.WebhookDelivery
+		for _, delivery := range deliveries {
+			result = append(result, delivery.export())
+		}
+		return result, nil
+	default:
+		return nil, persistence.ErrBadQuery
+	}
+}
+
+func (r *relationalDAL) DeleteWebhookDeliveries(q interface{}) error {
+	switch query := q.(type) {
+	case persistence.DeleteWebhookDeliveriesQueryByWebhookID:
+		if err := r.db.Where("webhook_id = ?", string(query)).Delete(&WebhookDelivery{}).Error; err != nil {
+			return fmt.Errorf("relational: error deleting webhook deliveries: %w", err)
+		}
+		return nil
+	case persistence.DeleteWebhookDeliveriesQueryOlderThan:
+		if err := r.db.Where("created < ?", time.Time(query)).Delete(&WebhookDelivery{}).Error; err != nil {
+			return fmt.Errorf("relational: error deleting webhook deliveries: %w", err)
+		}
+		return nil
+	default:
+		return persistence.ErrBadQuery
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package relational
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestRelationalDAL_Webhooks(t *testing.T) {
+	db, closeDB := createTestDatabase()
+	defer closeDB()
+	dal := NewRelationalDAL(db)
+
+	now := time.Now()
+	for _, webhook := range []persistence.Webhook{
+		{WebhookID: "webhook-a", AccountID: "account-a", URL: "https://a.example.com", Events: []string{"account_user.added"}, Created: now.Add(-time.Hour)},
+		{WebhookID: "webhook-b", AccountID: "account-a", URL: "https://b.example.com", Events: []string{"account_user.added", "weekly_aggregate.ready"}, Created: now},
+		{WebhookID: "webhook-c", AccountID: "account-b", URL: "https://c.example.com", Events: []string{"retention_purge.completed"}, Created: now},
+	} {
+		if err := dal.CreateWebhook(&webhook); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	if _, err := dal.FindWebhooks(99); err != persistence.ErrBadQuery {
+		t.Errorf("Expected bad query error, got %v", err)
+	}
+	webhooks, err := dal.FindWebhooks(persistence.FindWebhooksQueryByAccountID("account-a"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(webhooks) != 2 || webhooks[1].WebhookID != "webhook-b" || !reflect.DeepEqual(webhooks[1].Events, []string{"account_user.added", "weekly_aggregate.ready"}) {
+		t.Errorf("Unexpected webhooks %v", webhooks)
+	}
+	webhooks, err = dal.FindWebhooks(persistence.FindWebhooksQueryAllWebhooks{})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(webhooks) != 3 {
+		t.Errorf("Unexpected webhooks %v", webhooks)
+	}
+
+	for _, delivery := range []persistence.WebhookDelivery{
+		{DeliveryID: "delivery-a", WebhookID: "webhook-a", Event: "account_user.added", Created: now.Add(-time.Hour * 24 * 60)},
+		{DeliveryID: "delivery-b", WebhookID: "webhook-a", Event: "account_user.added", Created: now.Add(-time.Minute)},
+		{DeliveryID: "delivery-c", WebhookID: "webhook-a", Event: "account_user.added", Created: now},
+		{DeliveryID: "delivery-d", WebhookID: "webhook-b", Event: "account_user.added", Created: now},
+	} {
+		if err := dal.CreateWebhookDelivery(&delivery); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	if err := dal.UpdateWebhookDelivery(&persistence.WebhookDelivery{
+		DeliveryID: "delivery-c", WebhookID: "webhook-a", Event: "account_user.added",
+		Attempts: 2, StatusCode: 200, Delivered: true, Created: now,
+	}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	deliveries, err := dal.FindWebhookDeliveries(persistence.FindWebhookDeliveriesQueryByWebhookID{WebhookID: "webhook-a", Limit: 2})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(deliveries) != 2 || deliveries[0].DeliveryID != "delivery-c" || !deliveries[0].Delivered || deliveries[0].Attempts != 2 {
+		t.Errorf("Unexpected deliveries %v", deliveries)
+	}
+
+	if err := dal.DeleteWebhookDeliveries(persistence.DeleteWebhookDeliveriesQueryOlderThan(now.Add(-time.Hour * 24 * 30))); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	deliveries, _ = dal.FindWebhookDeliveries(persistence.FindWebhookDeliveriesQueryByWebhookID{WebhookID: "webhook-a"})
+	if len(deliveries) != 2 {
+		t.Errorf("Expected expired delivery to be deleted, got %v", deliveries)
+	}
+
+	if err := dal.DeleteWebhookDeliveries(persistence.DeleteWebhookDeliveriesQueryByWebhookID("webhook-a")); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	// webhooks of other accounts are not deleted
+	if err := dal.DeleteWebhooks(persistence.DeleteWebhooksQueryByID{WebhookID: "webhook-a", AccountID: "account-b"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := dal.DeleteWebhooks(persistence.DeleteWebhooksQueryByID{WebhookID: "webhook-b", AccountID: "account-a"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	webhooks, _ = dal.FindWebhooks(persistence.FindWebhooksQueryByAccountID("account-a"))
+	if len(webhooks) != 1 || webhooks[0].WebhookID != "webhook-a" {
+		t.Errorf("Unexpected webhooks %v", webhooks)
+	}
+	deliveries, _ = dal.FindWebhookDeliveries(persistence.FindWebhookDeliveriesQueryByWebhookID{WebhookID: "webhook-b"})
+	if len(deliveries) != 1 {
+		t.Errorf("Unexpected deliveries %v", deliveries)
+	}
+}
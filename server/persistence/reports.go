@@ -0,0 +1,191 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	uuid "github.com/gofrs/uuid"
+)
+
+// Frequencies reports can be sent with.
+const (
+	// ReportFrequencyWeekly reports cover the previous week and are sent on
+	// Mondays.
+	ReportFrequencyWeekly = "weekly"
+	// ReportFrequencyMonthly reports cover the previous month and are sent
+	// on the first day of each month.
+	ReportFrequencyMonthly = "monthly"
+)
+
+// ReportFrequencies contains all supported frequencies.
+var ReportFrequencies = []string{
+	ReportFrequencyWeekly,
+	ReportFrequencyMonthly,
+}
+
+func (r *ReportSubscription) export() ReportSubscriptionResult {
+	return ReportSubscriptionResult{
+		ReportSubscriptionID: r.ReportSubscriptionID,
+		AccountUserID:        r.AccountUserID,
+		AccountID:            r.AccountID,
+		EmailAddress:         r.EmailAddress,
+		Frequency:            r.Frequency,
+		Created:              r.Created,
+	}
+}
+
+func validReportFrequency(frequency string) bool {
+	for _, f := range ReportFrequencies {
+		if f == frequency {
+			return true
+		}
+	}
+	return false
+}
+
+// ownsEmail checks whether the given address is the primary or a verified
+// secondary email address of the account user.
+func (p *persistenceLayer) ownsEmail(accountUser *AccountUser, email string) (bool, error) {
+	if p.matchesEmail(accountUser, email) {
+		return true, nil
+	}
+	emails, err := accountUser.secondaryEmails()
+	if err != nil {
+		return false, err
+	}
+	for i := range emails {
+		if emails[i].Verified && p.matchesSecondaryEmail(&emails[i], email) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SubscribeToReports subscribes the account user to reports of the given
+// account. Reports can only be sent to email addresses of the account user.
+// An existing subscription to the account is updated.
+func (p *persistenceLayer) SubscribeToReports(accountUserID, accountID, emailAddress, frequency string) (ReportSubscriptionResult, error) {
+	if !validReportFrequency(frequency) {
+		return ReportSubscriptionResult{}, fmt.Errorf("persistence: unknown report frequency %q", frequency)
+	}
+	emailAddress = strings.TrimSpace(emailAddress)
+	if emailAddress == "" {
+		return ReportSubscriptionResult{}, fmt.Errorf("persistence: an email address is required for subscribing to reports")
+	}
+
+	accountUser, err := p.dal.FindAccountUser(FindAccountUserQueryByAccountUserIDIncludeRelationships(accountUserID))
+	if err != nil {
+		return ReportSubscriptionResult{}, fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	if !accountUser.canAccessAccount(accountID) {
+		return ReportSubscriptionResult{}, fmt.Errorf("persistence: account user cannot access account %s: %w", accountID, ErrPermissionDenied)
+	}
+	owned, err := p.ownsEmail(&accountUser, emailAddress)
+	if err != nil {
+		return ReportSubscriptionResult{}, fmt.Errorf("persistence: error checking email address: %w", err)
+	}
+	if !owned {
+		return ReportSubscriptionResult{}, fmt.Errorf("persistence: reports can only be sent to the email addresses of the account user: %w", ErrPermissionDenied)
+	}
+
+	existing, err := p.dal.FindReportSubscriptions(FindReportSubscriptionsQueryByAccountUserID(accountUserID))
+	if err != nil {
+		return ReportSubscriptionResult{}, fmt.Errorf("persistence: error looking up report subscriptions: %w", err)
+	}
+	var subscription *ReportSubscription
+	for i := range existing {
+		if existing[i].AccountID == accountID {
+			subscription = &existing[i]
+			break
+		}
+	}
+	if subscription == nil {
+		subscriptionID, err := uuid.NewV4()
+		if err != nil {
+			return ReportSubscriptionResult{}, fmt.Errorf("persistence: error creating report subscription id: %w", err)
+		}
+		subscription = &ReportSubscription{
+			ReportSubscriptionID: subscriptionID.String(),
+			AccountUserID:        accountUserID,
+			AccountID:            accountID,
+			Created:              time.Now(),
+		}
+	}
+	subscription.EmailAddress = emailAddress
+	subscription.Frequency = frequency
+	if err := p.dal.SaveReportSubscription(subscription); err != nil {
+		return ReportSubscriptionResult{}, fmt.Errorf("persistence: error saving report subscription: %w", err)
+	}
+	return subscription.export(), nil
+}
+
+// ListReportSubscriptions returns all report subscriptions of the given
+// account user.
+func (p *persistenceLayer) ListReportSubscriptions(accountUserID string) ([]ReportSubscriptionResult, error) {
+	subscriptions, err := p.dal.FindReportSubscriptions(FindReportSubscriptionsQueryByAccountUserID(accountUserID))
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up report subscriptions: %w", err)
+	}
+	result := []ReportSubscriptionResult{}
+	for _, subscription := range subscriptions {
+		result = append(result, subscription.export())
+	}
+	return result, nil
+}
+
+// UnsubscribeFromReports removes the subscription of the account user to
+// the reports of the given account, including the stored email address.
+func (p *persistenceLayer) UnsubscribeFromReports(accountUserID, accountID string) error {
+	subscriptions, err := p.dal.FindReportSubscriptions(FindReportSubscriptionsQueryByAccountUserID(accountUserID))
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up report subscriptions: %w", err)
+	}
+	var found bool
+	for _, subscription := range subscriptions {
+		if subscription.AccountID == accountID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrUnknownReportSubscription
+	}
+	if err := p.dal.DeleteReportSubscriptions(DeleteReportSubscriptionsQueryByAccountID{
+		AccountUserID: accountUserID,
+		AccountID:     accountID,
+	}); err != nil {
+		return fmt.Errorf("persistence: error deleting report subscription: %w", err)
+	}
+	return nil
+}
+
+// LookupReportSubscriptions returns all subscriptions of the given frequency
+// that reports are to be sent for. Subscriptions of account users that have
+// been deleted, suspended or cannot access the account anymore are skipped.
+func (p *persistenceLayer) LookupReportSubscriptions(frequency string) ([]ReportSubscriptionResult, error) {
+	subscriptions, err := p.dal.FindReportSubscriptions(FindReportSubscriptionsQueryByFrequency(frequency))
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up report subscriptions: %w", err)
+	}
+	accountUsers := map[string]*AccountUser{}
+	result := []ReportSubscriptionResult{}
+	for _, subscription := range subscriptions {
+		accountUser, ok := accountUsers[subscription.AccountUserID]
+		if !ok {
+			// account users that cannot be found have been deleted
+			if match, err := p.dal.FindAccountUser(FindAccountUserQueryByAccountUserIDIncludeRelationships(subscription.AccountUserID)); err == nil {
+				accountUser = &match
+			}
+			accountUsers[subscription.AccountUserID] = accountUser
+		}
+		if accountUser == nil || accountUser.Suspended || !accountUser.canAccessAccount(subscription.AccountID) {
+			continue
+		}
+		result = append(result, subscription.export())
+	}
+	return result, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/offen/offen/server/keys"
+)
+
+type mockReportsDatabase struct {
+	DataAccessLayer
+	accountUsers  []AccountUser
+	subscriptions []ReportSubscription
+}
+
+func (m *mockReportsDatabase) FindAccountUser(q interface{}) (AccountUser, error) {
+	for _, accountUser := range m.accountUsers {
+		if accountUser.AccountUserID == string(q.(FindAccountUserQueryByAccountUserIDIncludeRelationships)) {
+			return accountUser, nil
+		}
+	}
+	return AccountUser{}, errors.New("not found")
+}
+
+func (m *mockReportsDatabase) SaveReportSubscription(s *ReportSubscription) error {
+	for i, subscription := range m.subscriptions {
+		if subscription.ReportSubscriptionID == s.ReportSubscriptionID {
+			m.subscriptions[i] = *s
+			return nil
+		}
+	}
+	m.subscriptions = append(m.subscriptions, *s)
+	return nil
+}
+
+func (m *mockReportsDatabase) FindReportSubscriptions(q interface{}) ([]ReportSubscription, error) {
+	var result []ReportSubscription
+	for _, subscription := range m.subscriptions {
+		switch query := q.(type) {
+		case FindReportSubscriptionsQueryByAccountUserID:
+			if subscription.AccountUserID == string(query) {
+				result = append(result, subscription)
+			}
+		case FindReportSubscriptionsQueryByFrequency:
+			if subscription.Frequency == string(query) {
+				result = append(result, subscription)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (m *mockReportsDatabase) DeleteReportSubscriptions(q interface{}) error {
+	query := q.(DeleteReportSubscriptionsQueryByAccountID)
+	var remaining []ReportSubscription
+	for _, subscription := range m.subscriptions {
+		if subscription.AccountUserID != query.AccountUserID || subscription.AccountID != query.AccountID {
+			remaining = append(remaining, subscription)
+		}
+	}
+	m.subscriptions = remaining
+	return nil
+}
+
+func TestPersistenceLayer_SubscribeToReports(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	hashedEmail, _ := keys.HashStringWith("develop@offen.dev", params)
+	tests := []struct {
+		name         string
+		accountID    string
+		emailAddress string
+		frequency    string
+		expectError  bool
+	}{
+		{"weekly", "account-a", "develop@offen.dev", ReportFrequencyWeekly, false},
+		{"monthly", "account-a", " develop@offen.dev ", ReportFrequencyMonthly, false},
+		{"bad frequency", "account-a", "develop@offen.dev", "daily", true},
+		{"empty email", "account-a", " ", ReportFrequencyWeekly, true},
+		{"other email", "account-a", "someone@offen.dev", ReportFrequencyWeekly, true},
+		{"other account", "account-z", "develop@offen.dev", ReportFrequencyWeekly, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &mockReportsDatabase{
+				accountUsers: []AccountUser{
+					{
+						AccountUserID: "user-a",
+						HashedEmail:   hashedEmail.Marshal(),
+						Relationships: []AccountUserRelationship{{AccountID: "account-a"}},
+					},
+				},
+				subscriptions: []ReportSubscription{
+					{ReportSubscriptionID: "subscription-a", AccountUserID: "user-a", AccountID: "account-a", Frequency: ReportFrequencyWeekly},
+				},
+			}
+			p := &persistenceLayer{dal: db}
+			result, err := p.SubscribeToReports("user-a", test.accountID, test.emailAddress, test.frequency)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if result.ReportSubscriptionID != "subscription-a" || result.Frequency != test.frequency || result.EmailAddress != "develop@offen.dev" {
+				t.Errorf("Unexpected result %v", result)
+			}
+			if len(db.subscriptions) != 1 {
+				t.Errorf("Expected existing subscription to be updated, got %v", db.subscriptions)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_UnsubscribeFromReports(t *testing.T) {
+	db := &mockReportsDatabase{
+		subscriptions: []ReportSubscription{
+			{ReportSubscriptionID: "subscription-a", AccountUserID: "user-a", AccountID: "account-a"},
+			{ReportSubscriptionID: "subscription-b", AccountUserID: "user-a", AccountID: "account-b"},
+		},
+	}
+	p := &persistenceLayer{dal: db}
+	if err := p.UnsubscribeFromReports("user-a", "account-z"); !errors.Is(err, ErrUnknownReportSubscription) {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := p.UnsubscribeFromReports("user-a", "account-a"); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	result, err := p.ListReportSubscriptions("user-a")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result) != 1 || result[0].AccountID != "account-b" {
+		t.Errorf("Unexpected result %v", result)
+	}
+}
+
+func TestPersistenceLayer_LookupReportSubscriptions(t *testing.T) {
+	db := &mockReportsDatabase{
+		accountUsers: []AccountUser{
+			{AccountUserID: "user-a", Relationships: []AccountUserRelationship{{AccountID: "account-a"}}},
+			{AccountUserID: "user-b", Suspended: true, Relationships: []AccountUserRelationship{{AccountID: "account-a"}}},
+		},
+		subscriptions: []ReportSubscription{
+			{ReportSubscriptionID: "subscription-a", AccountUserID: "user-a", AccountID: "account-a", Frequency: ReportFrequencyWeekly},
+			{ReportSubscriptionID: "subscription-b", AccountUserID: "user-a", AccountID: "account-b", Frequency: ReportFrequencyWeekly},
+			{ReportSubscriptionID: "subscription-c", AccountUserID: "user-b", AccountID: "account-a", Frequency: ReportFrequencyWeekly},
+			{ReportSubscriptionID: "subscription-d", AccountUserID: "user-z", AccountID: "account-a", Frequency: ReportFrequencyWeekly},
+			{ReportSubscriptionID: "subscription-e", AccountUserID: "user-a", AccountID: "account-a", Frequency: ReportFrequencyMonthly},
+		},
+	}
+	p := &persistenceLayer{dal: db}
+	result, err := p.LookupReportSubscriptions(ReportFrequencyWeekly)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result) != 1 || result[0].ReportSubscriptionID != "subscription-a" {
+		t.Errorf("Unexpected result %v", result)
+	}
+}
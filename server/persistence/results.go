@@ -3,7 +3,13 @@
 
 package persistence
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/offen/offen/server/keys"
+)
 
 // SecretResult contains information about a single secret record
 type SecretResult struct {
@@ -17,6 +23,7 @@ type EventsResult struct {
 	Events        *EventsByAccountID `json:"events,omitempty"`
 	DeletedEvents []string           `json:"deletedEvents,omitempty"`
 	Sequence      string             `json:"sequence,omitempty"`
+	NextCursor    string             `json:"nextCursor,omitempty"`
 }
 
 // EventResult is an element returned from a query. It contains all data that
@@ -46,12 +53,283 @@ type AccountResult struct {
 	Sequence            string                `json:"sequence,omitempty"`
 	Secrets             *EncryptedSecretsByID `json:"secrets,omitempty"`
 	Created             time.Time             `json:"created,omitempty"`
+	AllowedOrigins      []string              `json:"allowedOrigins,omitempty"`
+	PrivacySignals      string                `json:"privacySignals,omitempty"`
+	Timezone            string                `json:"timezone,omitempty"`
+	AnomalySensitivity  string                `json:"anomalySensitivity,omitempty"`
+	Domains             []string              `json:"domains,omitempty"`
+}
+
+// AnomalyDetectionResult describes an account whose traffic is checked for
+// anomalies.
+type AnomalyDetectionResult struct {
+	AccountID   string
+	Name        string
+	Sensitivity string
+	Created     time.Time
+}
+
+// ExpireResult contains the number of events that have been removed when
+// expiring events.
+type ExpireResult struct {
+	Removed            int
+	RemovedByAccountID map[string]int
+}
+
+// PurgeEventsResult describes the events of an account that are older than
+// the given deadline and have been deleted, or would be deleted in case of a
+// dry run.
+type PurgeEventsResult struct {
+	AccountID string
+	Before    time.Time
+	DryRun    bool
+	Events    int
+	Users     int
+	Oldest    *time.Time
+	Newest    *time.Time
+}
+
+// ErasureResult contains the number of events that have been deleted when
+// erasing the data of a user on request.
+type ErasureResult struct {
+	UserID             string         `json:"userId"`
+	Removed            int            `json:"removed"`
+	RemovedByAccountID map[string]int `json:"removedByAccountId"`
+	Erased             time.Time      `json:"erased"`
 }
 
 // ShareAccountResult is a successful invitation of a user
 type ShareAccountResult struct {
 	UserExistsWithPassword bool
 	AccountNames           []string
+	AccountIDs             []string
+}
+
+// InviteResult contains the token that is needed for accepting an invitation
+// created using Invite.
+type InviteResult struct {
+	Token                  []byte
+	UserExistsWithPassword bool
+	AccountNames           []string
+	AccountIDs             []string
+}
+
+// SecondFactorEnrollmentResult contains the TOTP secret of an account user
+// that is enrolling a second factor.
+type SecondFactorEnrollmentResult struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// SecondFactorResult describes the second factor of an account user. The
+// times at which recovery codes have been consumed are kept for auditing.
+type SecondFactorResult struct {
+	Enabled                bool        `json:"enabled"`
+	RecoveryCodesRemaining int         `json:"recoveryCodesRemaining"`
+	RecoveryCodesConsumed  []time.Time `json:"recoveryCodesConsumed,omitempty"`
+}
+
+// WebAuthnCredentialResult contains the public part of a WebAuthn credential.
+type WebAuthnCredentialResult struct {
+	CredentialID []byte `json:"credentialId"`
+	PublicKey    []byte `json:"publicKey"`
+	SignCount    uint32 `json:"signCount"`
+}
+
+// AccessTokenResult describes an access token of an account user. The token
+// itself is only populated when the token has been created.
+type AccessTokenResult struct {
+	TokenID       string     `json:"tokenId"`
+	AccountUserID string     `json:"-"`
+	Name          string     `json:"name"`
+	Token         string     `json:"token,omitempty"`
+	Scopes        []string   `json:"scopes"`
+	AccountIDs    []string   `json:"accountIds"`
+	Created       time.Time  `json:"created"`
+	Expires       *time.Time `json:"expires"`
+}
+
+// HasScope checks whether the access token has been granted the given scope.
+func (a *AccessTokenResult) HasScope(scope string) bool {
+	for _, s := range a.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookResult describes a webhook of an account. The secret used for
+// signing payloads is only populated when the webhook has been created or is
+// looked up for delivering payloads.
+type WebhookResult struct {
+	WebhookID string    `json:"webhookId"`
+	AccountID string    `json:"accountId"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedBy string    `json:"-"`
+	Created   time.Time `json:"created"`
+}
+
+// WebhookDeliveryResult describes the delivery of a payload to a webhook.
+type WebhookDeliveryResult struct {
+	DeliveryID string    `json:"deliveryId"`
+	WebhookID  string    `json:"webhookId"`
+	Event      string    `json:"event"`
+	Payload    string    `json:"payload"`
+	Attempts   int       `json:"attempts"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Delivered  bool      `json:"delivered"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+}
+
+// RollupResult contains the aggregated metrics of an account for a single
+// period.
+type RollupResult struct {
+	AccountID        string    `json:"accountId"`
+	Period           string    `json:"period"`
+	Start            time.Time `json:"start"`
+	Events           int       `json:"events"`
+	Users            int       `json:"users"`
+	AnonymousEvents  int       `json:"anonymousEvents"`
+	SamplingRate     float64   `json:"samplingRate"`
+	EncryptedPayload string    `json:"encryptedPayload,omitempty"`
+	Imported         bool      `json:"imported"`
+	ImportSource     string    `json:"importSource,omitempty"`
+	Updated          time.Time `json:"updated"`
+}
+
+// ImportedDay contains the metrics of a single day as exported by another
+// analytics tool. Only the year, month and day of Date are used, the day is
+// aligned to the time zone of the account it is imported for.
+type ImportedDay struct {
+	Date   time.Time
+	Events int
+	Users  int
+}
+
+// ImportRollupsResult describes the daily rollups that have been imported
+// for an account, or would be imported in case of a dry run. Days that
+// already have a rollup computed from events are skipped.
+type ImportRollupsResult struct {
+	AccountID string
+	Source    string
+	DryRun    bool
+	Imported  int
+	Skipped   int
+	From      *time.Time
+	Until     *time.Time
+}
+
+// FunnelStep is a single step of a funnel. Depending on its type, value is
+// either a URL or the type of an event.
+type FunnelStep struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// FunnelResult describes a funnel of an account.
+type FunnelResult struct {
+	FunnelID  string       `json:"funnelId"`
+	AccountID string       `json:"accountId"`
+	Name      string       `json:"name"`
+	Steps     []FunnelStep `json:"steps"`
+	CreatedBy string       `json:"-"`
+	Created   time.Time    `json:"created"`
+}
+
+// EventTypeResult describes a custom event type of an account.
+type EventTypeResult struct {
+	EventTypeID string    `json:"eventTypeId"`
+	AccountID   string    `json:"accountId"`
+	Name        string    `json:"name"`
+	Aggregation string    `json:"aggregation"`
+	CreatedBy   string    `json:"-"`
+	Created     time.Time `json:"created"`
+}
+
+// ReportSubscriptionResult describes the subscription of an account user to
+// the reports of an account.
+type ReportSubscriptionResult struct {
+	ReportSubscriptionID string    `json:"reportSubscriptionId"`
+	AccountUserID        string    `json:"-"`
+	AccountID            string    `json:"accountId"`
+	EmailAddress         string    `json:"emailAddress"`
+	Frequency            string    `json:"frequency"`
+	Created              time.Time `json:"created"`
+}
+
+// GoalResult describes a goal of an account.
+type GoalResult struct {
+	GoalID    string    `json:"goalId"`
+	AccountID string    `json:"accountId"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Value     string    `json:"value"`
+	CreatedBy string    `json:"-"`
+	Created   time.Time `json:"created"`
+}
+
+// ServiceAccountResult describes a service account. The credential is only
+// populated when the service account has been created.
+type ServiceAccountResult struct {
+	ServiceAccountID string    `json:"serviceAccountId"`
+	Name             string    `json:"name"`
+	Credential       string    `json:"credential,omitempty"`
+	AccountIDs       []string  `json:"accountIds"`
+	CreatedBy        string    `json:"createdBy"`
+	Created          time.Time `json:"created"`
+}
+
+// AuthEventResult describes an authentication related action of an account
+// user. IPAddress and UserAgent are empty for events that have not been
+// caused by a HTTP request.
+type AuthEventResult struct {
+	EventID   string    `json:"eventId"`
+	Type      string    `json:"type"`
+	IPAddress string    `json:"ipAddress,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// AuthEventsResult is a page of authentication events. In case more events
+// exist, Next contains the value to pass for requesting the next page.
+type AuthEventsResult struct {
+	Events []AuthEventResult `json:"events"`
+	Next   string            `json:"next,omitempty"`
+}
+
+// SessionResult describes an active session of an account user.
+type SessionResult struct {
+	SessionID     string    `json:"sessionId"`
+	AccountUserID string    `json:"-"`
+	IPAddress     string    `json:"ipAddress"`
+	UserAgent     string    `json:"userAgent"`
+	Created       time.Time `json:"created"`
+	Expires       time.Time `json:"expires"`
+	Current       bool      `json:"current"`
+	// ImpersonatedBy is set for sessions that have been opened by a super
+	// admin for impersonating the account user.
+	ImpersonatedBy string `json:"impersonatedBy,omitempty"`
+	// RefreshExpires is set for sessions that can be refreshed and is the
+	// time after which this is not possible anymore.
+	RefreshExpires *time.Time `json:"refreshExpires,omitempty"`
+	// RefreshToken is only populated right after it has been issued.
+	RefreshToken string `json:"-"`
+}
+
+// SecondaryEmailResult is a secondary email address of an account user.
+// Outdated addresses do not cover all accounts of the account user and need
+// to be added again for being used when resetting the password.
+type SecondaryEmailResult struct {
+	SecondaryEmailID string    `json:"secondaryEmailId"`
+	Verified         bool      `json:"verified"`
+	Outdated         bool      `json:"outdated"`
+	Created          time.Time `json:"created"`
 }
 
 // LoginResult is a successful account user authentication response.
@@ -59,6 +337,13 @@ type LoginResult struct {
 	AccountUserID string                `json:"accountUserId"`
 	AdminLevel    AccountUserAdminLevel `json:"adminLevel"`
 	Accounts      []LoginAccountResult  `json:"accounts"`
+	// AllowedNetworks restricts the networks the account user can use the
+	// application from. It is only populated when logging in using a
+	// password and when looking up an account user.
+	AllowedNetworks []string `json:"allowedNetworks,omitempty"`
+	// ImpersonatedBy is set when the account user is being impersonated by
+	// the super admin of the given id.
+	ImpersonatedBy string `json:"impersonatedBy,omitempty"`
 }
 
 // CanAccessAccount checks whether the login result is allowed to access the
@@ -72,16 +357,53 @@ func (l *LoginResult) CanAccessAccount(accountID string) bool {
 	return false
 }
 
+// WrapKeys encrypts all key encryption keys contained in the result for the
+// holder of the given X25519 public key, so that they are not sent to the
+// client in plaintext.
+func (l *LoginResult) WrapKeys(peerPublicKey []byte) error {
+	for idx, account := range l.Accounts {
+		if account.KeyEncryptionKey == nil {
+			continue
+		}
+		b, err := json.Marshal(account.KeyEncryptionKey)
+		if err != nil {
+			return fmt.Errorf("persistence: error marshaling key encryption key: %w", err)
+		}
+		wrapped, err := keys.WrapForPeer(peerPublicKey, b)
+		if err != nil {
+			return fmt.Errorf("persistence: error wrapping key encryption key: %w", err)
+		}
+		account.KeyEncryptionKey = nil
+		account.WrappedKeyEncryptionKey = wrapped
+		l.Accounts[idx] = account
+	}
+	return nil
+}
+
 // IsSuperAdmin checks whether the login result is a SuperAdmin.
 func (l *LoginResult) IsSuperAdmin() bool {
 	return l.AdminLevel == AccountUserAdminLevelSuperAdmin
 }
 
+// CanManageAccount checks whether the login result is allowed to rotate the
+// keys of, invite account users to or delete the account of the given
+// identifier.
+func (l *LoginResult) CanManageAccount(accountID string) bool {
+	for _, account := range l.Accounts {
+		if accountID == account.AccountID {
+			return l.IsSuperAdmin() || account.Role == AccountUserRoleAdmin
+		}
+	}
+	return false
+}
+
 // LoginAccountResult contains information for the client to handle an account
 // in the client at runtime.
 type LoginAccountResult struct {
-	AccountName      string      `json:"accountName"`
-	AccountID        string      `json:"accountId"`
-	KeyEncryptionKey interface{} `json:"keyEncryptionKey"`
-	Created          time.Time   `json:"created"`
+	AccountName             string                 `json:"accountName"`
+	AccountID               string                 `json:"accountId"`
+	KeyEncryptionKey        interface{}            `json:"keyEncryptionKey"`
+	WrappedKeyEncryptionKey *keys.PeerWrappedValue `json:"wrappedKeyEncryptionKey,omitempty"`
+	Created                 time.Time              `json:"created"`
+	Role                    AccountUserRole        `json:"role"`
 }
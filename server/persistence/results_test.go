@@ -0,0 +1,73 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"testing"
+
+	"github.com/offen/offen/server/keys"
+)
+
+func TestLoginResult_WrapKeys(t *testing.T) {
+	publicKey, privateKey, _ := keys.GenerateExchangeKeyPair()
+	result := LoginResult{
+		Accounts: []LoginAccountResult{
+			{AccountID: "account-a", KeyEncryptionKey: map[string]string{"kty": "oct"}},
+		},
+	}
+	if err := result.WrapKeys(publicKey); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	account := result.Accounts[0]
+	if account.KeyEncryptionKey != nil || account.WrappedKeyEncryptionKey == nil {
+		t.Fatalf("Expected key to be wrapped, got %v", account)
+	}
+	value, err := keys.UnwrapFromPeer(privateKey, account.WrappedKeyEncryptionKey)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if string(value) != `{"kty":"oct"}` {
+		t.Errorf("Unexpected value %s", value)
+	}
+
+	if err := (&LoginResult{Accounts: []LoginAccountResult{{KeyEncryptionKey: "key"}}}).WrapKeys([]byte("short")); err == nil {
+		t.Error("Expected error wrapping for malformed key")
+	}
+}
+
+func TestLoginResult_CanManageAccount(t *testing.T) {
+	tests := []struct {
+		name           string
+		result         LoginResult
+		expectedResult bool
+	}{
+		{
+			"superadmin",
+			LoginResult{AdminLevel: AccountUserAdminLevelSuperAdmin, Accounts: []LoginAccountResult{{AccountID: "account-a"}}},
+			true,
+		},
+		{
+			"account admin",
+			LoginResult{Accounts: []LoginAccountResult{{AccountID: "account-a", Role: AccountUserRoleAdmin}}},
+			true,
+		},
+		{
+			"viewer",
+			LoginResult{Accounts: []LoginAccountResult{{AccountID: "account-a", Role: AccountUserRoleViewer}}},
+			false,
+		},
+		{
+			"admin of other account",
+			LoginResult{AdminLevel: AccountUserAdminLevelSuperAdmin, Accounts: []LoginAccountResult{{AccountID: "account-b", Role: AccountUserRoleAdmin}}},
+			false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := test.result.CanManageAccount("account-a"); result != test.expectedResult {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RewrapOptions configures a run of RewrapKeys.
+type RewrapOptions struct {
+	// After can be set to the last relationship id of a previous, unfinished
+	// run in order to resume it.
+	After     string
+	BatchSize int
+	// Pause is waited for in between batches in order to limit the load
+	// on the KMS provider and the database.
+	Pause    time.Duration
+	Progress func(RewrapProgress)
+	// Done can be closed for stopping the run before the next batch is
+	// processed. RewrapKeys then returns ErrRewrapInterrupted alongside
+	// the progress that can be used for resuming.
+	Done <-chan struct{}
+}
+
+// ErrRewrapInterrupted is returned when a run of RewrapKeys has been stopped
+// before all relationships have been processed.
+var ErrRewrapInterrupted = errors.New("persistence: rewrapping keys has been interrupted")
+
+// RewrapProgress reports the state of a run of RewrapKeys.
+type RewrapProgress struct {
+	LastRelationshipID string
+	Processed          int
+	Rewrapped          int
+}
+
+// RewrapKeys walks all account user relationships and wraps all envelopes
+// that have not yet been wrapped by the configured KMS provider. Envelopes
+// stored with account users, accounts and passkeys are wrapped the next time
+// they are written. Pending key rotations that have been encrypted using a
+// key derived from a previous secret are encrypted using the current one, so
+// the previous secret can be removed afterwards. Upgrades of envelopes that
+// are encrypted using keys derived from the credentials of the account user
+// (e.g. changed KDF parameters) cannot be performed here and are applied
+// on login instead.
+func (p *persistenceLayer) RewrapKeys(options RewrapOptions) (RewrapProgress, error) {
+	k, wrapping := p.dal.(*kmsDAL)
+	if !wrapping && len(p.rotationKeys) < 2 {
+		return RewrapProgress{}, errors.New("persistence: neither a kms provider nor previous secrets are configured, nothing to rewrap")
+	}
+	if options.BatchSize < 1 {
+		return RewrapProgress{}, fmt.Errorf("persistence: batch size must be at least 1, got %d", options.BatchSize)
+	}
+	// relationships are read from the underlying data access layer as the
+	// raw values are needed to tell whether they are wrapped already
+	source := p.dal
+	if wrapping {
+		source = k.DataAccessLayer
+	}
+
+	progress := RewrapProgress{LastRelationshipID: options.After}
+	for {
+		select {
+		case <-options.Done:
+			return progress, ErrRewrapInterrupted
+		default:
+		}
+		batch, err := source.FindAccountUserRelationships(FindAccountUserRelationshipsQueryBatch{
+			After: progress.LastRelationshipID,
+			Limit: options.BatchSize,
+		})
+		if err != nil {
+			return progress, fmt.Errorf("persistence: error looking up batch of relationships: %w", err)
+		}
+		if len(batch) == 0 {
+			return progress, nil
+		}
+		for _, relationship := range batch {
+			dirty := wrapping && needsWrapping(&relationship)
+			if wrapping {
+				if err := k.apply(&relationship, k.unwrap); err != nil {
+					return progress, fmt.Errorf("persistence: error unwrapping relationship %s: %w", relationship.RelationshipID, err)
+				}
+			}
+			rotated, err := relationship.rewrapKeyRotation(p.rotationKeys...)
+			if err != nil {
+				return progress, fmt.Errorf("persistence: error rewrapping pending key rotation of relationship %s: %w", relationship.RelationshipID, err)
+			}
+			if dirty || rotated {
+				if err := p.dal.UpdateAccountUserRelationship(&relationship); err != nil {
+					return progress, fmt.Errorf("persistence: error rewrapping relationship %s: %w", relationship.RelationshipID, err)
+				}
+				progress.Rewrapped++
+			}
+			progress.Processed++
+			progress.LastRelationshipID = relationship.RelationshipID
+		}
+		if options.Progress != nil {
+			options.Progress(progress)
+		}
+		if len(batch) < options.BatchSize {
+			return progress, nil
+		}
+		select {
+		case <-options.Done:
+		case <-time.After(options.Pause):
+		}
+	}
+}
+
+func needsWrapping(r *AccountUserRelationship) bool {
+	for _, value := range []string{
+		r.PasswordEncryptedKeyEncryptionKey,
+		r.EmailEncryptedKeyEncryptionKey,
+		r.OneTimeEncryptedKeyEncryptionKey,
+		r.KeyEncryptionKeyRotations,
+	} {
+		if value != "" && !strings.HasPrefix(value, wrappedEnvelopePrefix) {
+			return true
+		}
+	}
+	return false
+}
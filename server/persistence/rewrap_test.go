@@ -0,0 +1,189 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/offen/offen/server/keys"
+)
+
+type mockRewrapDatabase struct {
+	DataAccessLayer
+	relationships map[string]AccountUserRelationship
+	findErr       error
+}
+
+func (m *mockRewrapDatabase) FindAccountUserRelationships(q interface{}) ([]AccountUserRelationship, error) {
+	if m.findErr != nil {
+		return nil, m.findErr
+	}
+	query := q.(FindAccountUserRelationshipsQueryBatch)
+	var ids []string
+	for id := range m.relationships {
+		if id > query.After {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	var result []AccountUserRelationship
+	for _, id := range ids {
+		if len(result) == query.Limit {
+			break
+		}
+		result = append(result, m.relationships[id])
+	}
+	return result, nil
+}
+
+func (m *mockRewrapDatabase) UpdateAccountUserRelationship(r *AccountUserRelationship) error {
+	m.relationships[r.RelationshipID] = *r
+	return nil
+}
+
+func TestPersistenceLayer_RewrapKeys(t *testing.T) {
+	createDB := func() *mockRewrapDatabase {
+		return &mockRewrapDatabase{
+			relationships: map[string]AccountUserRelationship{
+				"a": {RelationshipID: "a", PasswordEncryptedKeyEncryptionKey: "{1,} a"},
+				"b": {RelationshipID: "b", PasswordEncryptedKeyEncryptionKey: wrappedEnvelopePrefix + "Yg=="},
+				"c": {RelationshipID: "c", EmailEncryptedKeyEncryptionKey: "{1,} c"},
+			},
+		}
+	}
+	closed := make(chan struct{})
+	close(closed)
+	tests := []struct {
+		name             string
+		db               *mockRewrapDatabase
+		provider         bool
+		options          RewrapOptions
+		expectedProgress RewrapProgress
+		expectError      bool
+	}{
+		{
+			"no provider",
+			createDB(),
+			false,
+			RewrapOptions{BatchSize: 2},
+			RewrapProgress{},
+			true,
+		},
+		{
+			"bad batch size",
+			createDB(),
+			true,
+			RewrapOptions{},
+			RewrapProgress{},
+			true,
+		},
+		{
+			"database error",
+			&mockRewrapDatabase{findErr: errors.New("did not work")},
+			true,
+			RewrapOptions{BatchSize: 2},
+			RewrapProgress{},
+			true,
+		},
+		{
+			"ok",
+			createDB(),
+			true,
+			RewrapOptions{BatchSize: 2},
+			RewrapProgress{LastRelationshipID: "c", Processed: 3, Rewrapped: 2},
+			false,
+		},
+		{
+			"resume",
+			createDB(),
+			true,
+			RewrapOptions{BatchSize: 2, After: "a"},
+			RewrapProgress{LastRelationshipID: "c", Processed: 2, Rewrapped: 1},
+			false,
+		},
+		{
+			"interrupted",
+			createDB(),
+			true,
+			RewrapOptions{BatchSize: 2, After: "a", Done: closed},
+			RewrapProgress{LastRelationshipID: "a"},
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var p *persistenceLayer
+			if test.provider {
+				p = &persistenceLayer{dal: newKMSDAL(test.db, &mockKMSProvider{})}
+			} else {
+				p = &persistenceLayer{dal: test.db}
+			}
+			var reports []RewrapProgress
+			test.options.Progress = func(r RewrapProgress) {
+				reports = append(reports, r)
+			}
+			progress, err := p.RewrapKeys(test.options)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if !reflect.DeepEqual(test.expectedProgress, progress) {
+				t.Errorf("Expected %v, got %v", test.expectedProgress, progress)
+			}
+			if test.expectError {
+				return
+			}
+			if len(reports) == 0 || !reflect.DeepEqual(reports[len(reports)-1], progress) {
+				t.Errorf("Unexpected progress reports %v", reports)
+			}
+			for id, relationship := range test.db.relationships {
+				if id > test.options.After && needsWrapping(&relationship) {
+					t.Errorf("Expected relationship %s to be wrapped", id)
+				}
+			}
+			if test.db.relationships["b"].PasswordEncryptedKeyEncryptionKey != wrappedEnvelopePrefix+"Yg==" {
+				t.Error("Unexpected change to already wrapped relationship")
+			}
+		})
+	}
+	t.Run("previous secret", func(t *testing.T) {
+		current, _ := keys.DeriveRotationKey([]byte("current"))
+		previous, _ := keys.DeriveRotationKey([]byte("previous"))
+		pending := func(key []byte) string {
+			r := AccountUserRelationship{}
+			r.addKeyRotation(key, []byte("next-key"))
+			return r.KeyEncryptionKeyRotations
+		}
+		db := &mockRewrapDatabase{
+			relationships: map[string]AccountUserRelationship{
+				"a": {RelationshipID: "a", KeyEncryptionKeyRotations: pending(previous)},
+				"b": {RelationshipID: "b", KeyEncryptionKeyRotations: pending(current)},
+				"c": {RelationshipID: "c", KeyEncryptionKeyRotations: `["{1,} abc"]`},
+				"d": {RelationshipID: "d"},
+			},
+		}
+		unchanged := db.relationships["b"].KeyEncryptionKeyRotations
+		p := &persistenceLayer{dal: db, rotationKeys: [][]byte{current, previous}}
+		progress, err := p.RewrapKeys(RewrapOptions{BatchSize: 10})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if !reflect.DeepEqual(RewrapProgress{LastRelationshipID: "d", Processed: 4, Rewrapped: 1}, progress) {
+			t.Errorf("Unexpected progress %v", progress)
+		}
+		rewrapped := db.relationships["a"]
+		next, err := rewrapped.resolveKeyEncryptionKey(nil, current)
+		if err != nil {
+			t.Fatalf("Expected rotation to be decryptable using the current key, got %v", err)
+		}
+		if string(next) != "next-key" {
+			t.Errorf("Unexpected key %s", next)
+		}
+		if db.relationships["b"].KeyEncryptionKeyRotations != unchanged {
+			t.Error("Unexpected change to rotation using the current key")
+		}
+	})
+}
@@ -0,0 +1,322 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// Periods rollups are computed for. Periods are aligned to the time zone of
+// the account, which defaults to UTC.
+const (
+	RollupPeriodHour = "hour"
+	RollupPeriodDay  = "day"
+)
+
+// RollupPeriods contains all periods rollups are computed for.
+var RollupPeriods = []string{
+	RollupPeriodHour,
+	RollupPeriodDay,
+}
+
+func (r *Rollup) export() RollupResult {
+	return RollupResult{
+		AccountID:        r.AccountID,
+		Period:           r.Period,
+		Start:            r.Start,
+		Events:           r.Events,
+		Users:            r.Users,
+		AnonymousEvents:  r.AnonymousEvents,
+		SamplingRate:     r.samplingRate(),
+		EncryptedPayload: r.EncryptedPayload,
+		Imported:         r.ImportSource != "",
+		ImportSource:     r.ImportSource,
+		Updated:          r.Updated,
+	}
+}
+
+// samplingRate returns the sampling rate of the rollup. Rollups computed
+// before sampling has been introduced do not have a rate, but cannot contain
+// sampled events either.
+func (r *Rollup) samplingRate() float64 {
+	if r.SamplingRate <= 0 {
+		return 1
+	}
+	return r.SamplingRate
+}
+
+// ValidRollupPeriod checks whether rollups are computed for the given period.
+func ValidRollupPeriod(period string) bool {
+	for _, p := range RollupPeriods {
+		if p == period {
+			return true
+		}
+	}
+	return false
+}
+
+// startOfPeriod returns the beginning of the period the given time is in
+// when using the given location.
+func startOfPeriod(t time.Time, period string, loc *time.Location) time.Time {
+	t = t.In(loc)
+	if period == RollupPeriodDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+	// hours are not created using time.Date as the hour repeated when
+	// daylight saving time ends would be ambiguous
+	return t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+}
+
+// nextPeriod returns the beginning of the period following the one
+// starting at the given time.
+func nextPeriod(start time.Time, period string) time.Time {
+	if period == RollupPeriodDay {
+		return start.AddDate(0, 0, 1)
+	}
+	return start.Add(time.Hour)
+}
+
+func rollupID(accountID, period string, start time.Time) string {
+	return fmt.Sprintf("%s-%s-%d", accountID, period, start.Unix())
+}
+
+// boundaryEventID returns the smallest possible event id for the given time,
+// so it can be used for selecting events created at or after it.
+func boundaryEventID(t time.Time) string {
+	var id ulid.ULID
+	id.SetTime(ulid.Timestamp(t))
+	return id.String()
+}
+
+// aggregateEvents counts the given events per period, using periods aligned
+// to the given location. Users are counted once per period, no matter how
+// many events they have sent. Sampled events are scaled by their weight. As
+// users are sampled as a whole, each user is scaled by the lowest weight of
+// their events in the period. The result is keyed by the start of each
+// period in UTC.
+func aggregateEvents(accountID, period string, loc *time.Location, events []Event) map[time.Time]*Rollup {
+	result := map[time.Time]*Rollup{}
+	users := map[time.Time]map[string]int{}
+	stored := map[time.Time]int{}
+	for _, event := range events {
+		id, err := ulid.Parse(event.EventID)
+		if err != nil {
+			continue
+		}
+		start := startOfPeriod(ulid.Time(id.Time()), period, loc).UTC()
+		rollup, ok := result[start]
+		if !ok {
+			rollup = &Rollup{
+				RollupID:  rollupID(accountID, period, start),
+				AccountID: accountID,
+				Period:    period,
+				Start:     start,
+			}
+			result[start] = rollup
+			users[start] = map[string]int{}
+		}
+		weight := weightOf(event)
+		stored[start]++
+		rollup.Events += weight
+		if event.SecretID == nil {
+			rollup.AnonymousEvents += weight
+			continue
+		}
+		if current, ok := users[start][*event.SecretID]; !ok || weight < current {
+			users[start][*event.SecretID] = weight
+		}
+	}
+	for start, rollup := range result {
+		for _, weight := range users[start] {
+			rollup.Users += weight
+		}
+		rollup.SamplingRate = float64(stored[start]) / float64(rollup.Events)
+	}
+	return result
+}
+
+// ComputeRollups computes the rollups of all accounts for all periods that
+// have started at or after the beginning of the period the given from is in
+// and have ended before the given until. Encrypted payloads that have
+// already been stored by clients are kept. It returns the number of rollups
+// that have been saved.
+func (p *persistenceLayer) ComputeRollups(from, until time.Time) (int, error) {
+	accounts, err := p.dal.FindAccounts(FindAccountsQueryAllAccounts{})
+	if err != nil {
+		return 0, fmt.Errorf("persistence: error looking up accounts: %w", err)
+	}
+
+	var rollups []*Rollup
+	now := time.Now()
+	for _, account := range accounts {
+		if account.Retired {
+			continue
+		}
+		accountRollups, err := p.computeAccountRollups(account, from, until, now)
+		if err != nil {
+			return 0, err
+		}
+		rollups = append(rollups, accountRollups...)
+	}
+	return p.saveRollups(rollups)
+}
+
+// computeAccountRollups computes the rollups of the given account for all
+// periods that have started at or after the beginning of the period the
+// given from is in and have ended before the given until. Rollups that have
+// been imported are left untouched.
+func (p *persistenceLayer) computeAccountRollups(account Account, from, until, now time.Time) ([]*Rollup, error) {
+	loc, err := account.location()
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error loading time zone of account %s: %w", account.AccountID, err)
+	}
+	rangeFrom := startOfPeriod(from, RollupPeriodDay, loc)
+	rangeUntil := startOfPeriod(until, RollupPeriodHour, loc)
+	if !rangeFrom.Before(rangeUntil) {
+		return nil, nil
+	}
+	events, err := p.dal.FindEvents(FindEventsQueryByAccountID{
+		AccountID: account.AccountID,
+		From:      boundaryEventID(rangeFrom),
+		Until:     boundaryEventID(rangeUntil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up events for account %s: %w", account.AccountID, err)
+	}
+	var rollups []*Rollup
+	for _, period := range RollupPeriods {
+		periodFrom := startOfPeriod(from, period, loc)
+		periodUntil := startOfPeriod(until, period, loc)
+		if !periodFrom.Before(periodUntil) {
+			continue
+		}
+		existing, err := p.dal.FindRollups(FindRollupsQueryByAccountID{
+			AccountID: account.AccountID,
+			Period:    period,
+			From:      periodFrom,
+			Until:     periodUntil,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error looking up existing rollups: %w", err)
+		}
+		aggregated := aggregateEvents(account.AccountID, period, loc, events)
+		for _, rollup := range existing {
+			if rollup.ImportSource != "" {
+				// imported rollups are kept as is, even if events
+				// have been recorded in the same period
+				delete(aggregated, rollup.Start.UTC())
+				continue
+			}
+			if match, ok := aggregated[rollup.Start.UTC()]; ok {
+				match.EncryptedPayload = rollup.EncryptedPayload
+				continue
+			}
+			// events might have been deleted since the rollup has been
+			// computed, so it needs to be reset
+			reset := rollup
+			reset.Events, reset.Users, reset.AnonymousEvents = 0, 0, 0
+			reset.SamplingRate = 1
+			aggregated[rollup.Start.UTC()] = &reset
+		}
+		for start, rollup := range aggregated {
+			if start.Before(periodFrom) || !start.Before(periodUntil) {
+				continue
+			}
+			rollup.Updated = now
+			rollups = append(rollups, rollup)
+		}
+	}
+	return rollups, nil
+}
+
+// saveRollups saves the given rollups in a single transaction and returns
+// the number of rollups that have been saved.
+func (p *persistenceLayer) saveRollups(rollups []*Rollup) (int, error) {
+	if len(rollups) == 0 {
+		return 0, nil
+	}
+	txn, err := p.dal.Transaction()
+	if err != nil {
+		return 0, fmt.Errorf("persistence: error creating transaction: %w", err)
+	}
+	for _, rollup := range rollups {
+		if err := txn.SaveRollup(rollup); err != nil {
+			txn.Rollback()
+			return 0, fmt.Errorf("persistence: error saving rollup: %w", err)
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return 0, fmt.Errorf("persistence: error committing transaction: %w", err)
+	}
+	return len(rollups), nil
+}
+
+// GetRollups returns the rollups of the given account and period that start
+// in the given range, oldest first. Starts use the time zone of the account.
+func (p *persistenceLayer) GetRollups(accountID, period string, from, until time.Time) ([]RollupResult, error) {
+	if !ValidRollupPeriod(period) {
+		return nil, fmt.Errorf("persistence: unknown rollup period %q", period)
+	}
+	account, err := p.dal.FindAccount(FindAccountQueryByID(accountID))
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+	}
+	loc, err := account.location()
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error loading time zone of account %s: %w", accountID, err)
+	}
+	rollups, err := p.dal.FindRollups(FindRollupsQueryByAccountID{
+		AccountID: accountID,
+		Period:    period,
+		From:      from,
+		Until:     until,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up rollups: %w", err)
+	}
+	result := []RollupResult{}
+	for _, rollup := range rollups {
+		exported := rollup.export()
+		exported.Start = exported.Start.In(loc)
+		result = append(result, exported)
+	}
+	return result, nil
+}
+
+// UpdateRollupPayload stores the given payload with the rollup of the
+// account and period starting at the given time. The payload is expected to
+// be encrypted using the public key of the account.
+func (p *persistenceLayer) UpdateRollupPayload(accountID, period string, start time.Time, encryptedPayload string) (RollupResult, error) {
+	if !ValidRollupPeriod(period) {
+		return RollupResult{}, fmt.Errorf("persistence: unknown rollup period %q", period)
+	}
+	rollups, err := p.dal.FindRollups(FindRollupsQueryByAccountID{
+		AccountID: accountID,
+		Period:    period,
+		From:      start,
+		Until:     nextPeriod(start, period),
+	})
+	if err != nil {
+		return RollupResult{}, fmt.Errorf("persistence: error looking up rollups: %w", err)
+	}
+	var match *Rollup
+	for i, rollup := range rollups {
+		if rollup.Start.Equal(start) {
+			match = &rollups[i]
+			break
+		}
+	}
+	if match == nil {
+		return RollupResult{}, ErrUnknownRollup
+	}
+	match.EncryptedPayload = encryptedPayload
+	match.Updated = time.Now()
+	if err := p.dal.SaveRollup(match); err != nil {
+		return RollupResult{}, fmt.Errorf("persistence: error saving rollup: %w", err)
+	}
+	return match.export(), nil
+}
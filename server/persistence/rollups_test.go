@@ -0,0 +1,190 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type mockRollupsDatabase struct {
+	DataAccessLayer
+	accounts []Account
+	events   []Event
+	rollups  []Rollup
+	saved    []Rollup
+	err      error
+}
+
+func (m *mockRollupsDatabase) FindAccounts(q interface{}) ([]Account, error) {
+	return m.accounts, m.err
+}
+
+func (m *mockRollupsDatabase) FindEvents(q interface{}) ([]Event, error) {
+	query := q.(FindEventsQueryByAccountID)
+	var result []Event
+	for _, event := range m.events {
+		if event.AccountID == query.AccountID && event.EventID >= query.From && event.EventID < query.Until {
+			result = append(result, event)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockRollupsDatabase) FindRollups(q interface{}) ([]Rollup, error) {
+	query := q.(FindRollupsQueryByAccountID)
+	var result []Rollup
+	for _, rollup := range m.rollups {
+		if rollup.AccountID == query.AccountID && rollup.Period == query.Period &&
+			!rollup.Start.Before(query.From) && rollup.Start.Before(query.Until) {
+			result = append(result, rollup)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockRollupsDatabase) SaveRollup(r *Rollup) error {
+	m.saved = append(m.saved, *r)
+	return nil
+}
+
+func (m *mockRollupsDatabase) Transaction() (Transaction, error) {
+	return m, nil
+}
+
+func (m *mockRollupsDatabase) Commit() error {
+	return nil
+}
+
+func (m *mockRollupsDatabase) Rollback() error {
+	return nil
+}
+
+func eventAt(accountID string, t time.Time, secretID *string) Event {
+	eventID, _ := EventIDAt(t)
+	return Event{EventID: eventID, AccountID: accountID, SecretID: secretID}
+}
+
+func TestPersistenceLayer_ComputeRollups(t *testing.T) {
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	user := func(s string) *string { return &s }
+	db := &mockRollupsDatabase{
+		accounts: []Account{
+			{AccountID: "account-a"},
+			{AccountID: "account-b", Retired: true},
+		},
+		events: []Event{
+			eventAt("account-a", day.Add(-time.Minute), user("user-a")),
+			eventAt("account-a", day, user("user-a")),
+			eventAt("account-a", day.Add(time.Minute), user("user-a")),
+			eventAt("account-a", day.Add(time.Minute*90), user("user-b")),
+			eventAt("account-a", day.Add(time.Minute*91), nil),
+			eventAt("account-a", day.Add(time.Hour*25), user("user-c")),
+			eventAt("account-b", day, user("user-z")),
+		},
+		rollups: []Rollup{
+			{RollupID: rollupID("account-a", RollupPeriodHour, day), AccountID: "account-a", Period: RollupPeriodHour, Start: day, Events: 1, EncryptedPayload: "payload"},
+			{RollupID: rollupID("account-a", RollupPeriodHour, day.Add(time.Hour*2)), AccountID: "account-a", Period: RollupPeriodHour, Start: day.Add(time.Hour * 2), Events: 9, ImportSource: ImportSourceMatomo},
+			{RollupID: rollupID("account-a", RollupPeriodHour, day.Add(time.Hour*3)), AccountID: "account-a", Period: RollupPeriodHour, Start: day.Add(time.Hour * 3), Events: 7},
+		},
+	}
+	p := &persistenceLayer{dal: db}
+	count, err := p.ComputeRollups(day.Add(time.Minute), day.Add(time.Hour*24+time.Minute*30))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if count != len(db.saved) || count != 4 {
+		t.Fatalf("Unexpected rollups %v", db.saved)
+	}
+
+	expected := map[string]Rollup{
+		rollupID("account-a", RollupPeriodHour, day):                  {Events: 2, Users: 1, SamplingRate: 1, EncryptedPayload: "payload"},
+		rollupID("account-a", RollupPeriodHour, day.Add(time.Hour)):   {Events: 2, Users: 1, AnonymousEvents: 1, SamplingRate: 1},
+		rollupID("account-a", RollupPeriodHour, day.Add(time.Hour*3)): {SamplingRate: 1},
+		rollupID("account-a", RollupPeriodDay, day):                   {Events: 4, Users: 2, AnonymousEvents: 1, SamplingRate: 1},
+	}
+	for _, rollup := range db.saved {
+		match, ok := expected[rollup.RollupID]
+		if !ok {
+			t.Errorf("Unexpected rollup %v", rollup)
+			continue
+		}
+		if rollup.Events != match.Events || rollup.Users != match.Users ||
+			rollup.AnonymousEvents != match.AnonymousEvents || rollup.SamplingRate != match.SamplingRate ||
+			rollup.EncryptedPayload != match.EncryptedPayload {
+			t.Errorf("Expected %v, got %v", match, rollup)
+		}
+	}
+
+	if _, err := (&persistenceLayer{dal: &mockRollupsDatabase{err: errors.New("did not work")}}).ComputeRollups(day, day.AddDate(0, 0, 1)); err == nil {
+		t.Error("Expected error looking up accounts")
+	}
+}
+
+func TestAggregateEvents_Sampled(t *testing.T) {
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	user := func(s string) *string { return &s }
+	weighted := func(e Event, weight int) Event {
+		e.Weight = weight
+		return e
+	}
+	result := aggregateEvents("account-a", RollupPeriodDay, time.UTC, []Event{
+		eventAt("account-a", day, user("user-a")),
+		weighted(eventAt("account-a", day.Add(time.Hour), user("user-a")), 2),
+		weighted(eventAt("account-a", day.Add(time.Hour*2), user("user-b")), 4),
+		weighted(eventAt("account-a", day.Add(time.Hour*3), user("user-b")), 4),
+		weighted(eventAt("account-a", day.Add(time.Hour*4), nil), 4),
+	})
+	rollup := result[day]
+	if rollup == nil {
+		t.Fatalf("Expected rollup for %v, got %v", day, result)
+	}
+	if rollup.Events != 15 || rollup.Users != 5 || rollup.AnonymousEvents != 4 {
+		t.Errorf("Unexpected counts %v", rollup)
+	}
+	if rollup.SamplingRate != 5.0/15.0 {
+		t.Errorf("Unexpected sampling rate %v", rollup.SamplingRate)
+	}
+	if exported := (&Rollup{}).export(); exported.SamplingRate != 1 {
+		t.Errorf("Expected rollups without rate to be exported as unsampled, got %v", exported.SamplingRate)
+	}
+}
+
+func TestPersistenceLayer_UpdateRollupPayload(t *testing.T) {
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		period        string
+		start         time.Time
+		expectedError error
+	}{
+		{"ok", RollupPeriodDay, day, nil},
+		{"unknown", RollupPeriodDay, day.AddDate(0, 0, 1), ErrUnknownRollup},
+		{"other period", RollupPeriodHour, day, ErrUnknownRollup},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &mockRollupsDatabase{
+				rollups: []Rollup{
+					{RollupID: "rollup-a", AccountID: "account-a", Period: RollupPeriodDay, Start: day, Events: 12},
+				},
+			}
+			p := &persistenceLayer{dal: db}
+			result, err := p.UpdateRollupPayload("account-a", test.period, test.start, "payload")
+			if !errors.Is(err, test.expectedError) {
+				t.Errorf("Unexpected error %v", err)
+			}
+			if test.expectedError != nil {
+				return
+			}
+			if result.EncryptedPayload != "payload" || result.Events != 12 || len(db.saved) != 1 {
+				t.Errorf("Unexpected result %v", result)
+			}
+		})
+	}
+	if _, err := (&persistenceLayer{dal: &mockRollupsDatabase{}}).UpdateRollupPayload("account-a", "week", day, "payload"); err == nil {
+		t.Error("Expected error for unknown period")
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// maxSamplingWeight limits how many events a single stored event can
+// represent.
+const maxSamplingWeight = 1024
+
+// WithSampling enables sampling of events for accounts that receive more
+// than the given number of events per hour. Once the threshold has been
+// exceeded, only a subset of events is stored, each of them weighted with
+// the number of events it represents. The weight doubles each time the
+// number of received events doubles. Passing zero disables sampling.
+func WithSampling(threshold int) Config {
+	return func(p *persistenceLayer) {
+		if threshold <= 0 {
+			p.sampler = nil
+			return
+		}
+		p.sampler = &sampler{
+			threshold: threshold,
+			received:  map[string]int{},
+		}
+	}
+}
+
+// sampler counts the events received per account in the current hour. As
+// counts are kept in memory, they only cover the events received by a
+// single instance.
+type sampler struct {
+	threshold int
+	lock      sync.Mutex
+	window    time.Time
+	received  map[string]int
+}
+
+// weight records an event for the given account and returns the weight
+// events received at this point are sampled with. A weight of 1 means all
+// events are stored. A nil sampler never samples.
+func (s *sampler) weight(accountID string, now time.Time) int {
+	if s == nil {
+		return 1
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if window := now.UTC().Truncate(time.Hour); !window.Equal(s.window) {
+		s.window = window
+		s.received = map[string]int{}
+	}
+	s.received[accountID]++
+	weight := 1
+	for s.received[accountID] > s.threshold*weight && weight < maxSamplingWeight {
+		weight *= 2
+	}
+	return weight
+}
+
+// sampled decides whether an event with the given weight is stored. The
+// decision is derived from the given key, which is the hashed user id for
+// events that are not anonymous, so either all or none of the events a user
+// sends at the same weight are stored. As weights are powers of two, users
+// that are stored at a certain weight are also stored at all lower weights.
+func sampled(weight int, key string) bool {
+	if weight <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()%uint32(weight) == 0
+}
+
+// weightOf returns the number of events the given stored event represents.
+// Events stored before sampling has been introduced have no weight.
+func weightOf(e Event) int {
+	if e.Weight < 1 {
+		return 1
+	}
+	return e.Weight
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSampler_weight(t *testing.T) {
+	now := time.Date(2020, 3, 1, 12, 30, 0, 0, time.UTC)
+	s := &sampler{threshold: 10, received: map[string]int{}}
+
+	var weights []int
+	for i := 0; i < 41; i++ {
+		weights = append(weights, s.weight("account-a", now))
+	}
+	for i, expected := range map[int]int{0: 1, 9: 1, 10: 2, 19: 2, 20: 4, 39: 4, 40: 8} {
+		if weights[i] != expected {
+			t.Errorf("Expected weight %d for event %d, got %d", expected, i+1, weights[i])
+		}
+	}
+	if w := s.weight("account-b", now); w != 1 {
+		t.Errorf("Expected other accounts to be counted separately, got %d", w)
+	}
+	if w := s.weight("account-a", now.Add(time.Hour)); w != 1 {
+		t.Errorf("Expected counts to be reset in the next hour, got %d", w)
+	}
+
+	s.threshold = 1
+	for i := 0; i < 5000; i++ {
+		s.weight("account-c", now.Add(time.Hour))
+	}
+	if w := s.weight("account-c", now.Add(time.Hour)); w != maxSamplingWeight {
+		t.Errorf("Expected weight to be capped, got %d", w)
+	}
+
+	var disabled *sampler
+	if w := disabled.weight("account-a", now); w != 1 {
+		t.Errorf("Expected nil sampler not to sample, got %d", w)
+	}
+}
+
+func TestSampled(t *testing.T) {
+	if !sampled(1, "user-a") || !sampled(0, "user-a") {
+		t.Error("Expected unweighted events to always be sampled")
+	}
+	var kept int
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		if sampled(4, key) {
+			kept++
+			// users sampled at a weight are also sampled at lower weights
+			if !sampled(2, key) {
+				t.Errorf("Expected %s to be sampled at weight 2", key)
+			}
+		}
+		if sampled(4, key) != sampled(4, key) {
+			t.Errorf("Expected decision for %s to be stable", key)
+		}
+	}
+	if kept < 2000 || kept > 3000 {
+		t.Errorf("Expected about a quarter of users to be kept, got %d", kept)
+	}
+}
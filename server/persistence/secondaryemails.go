@@ -0,0 +1,298 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	uuid "github.com/gofrs/uuid"
+	"github.com/offen/offen/server/keys"
+)
+
+// secondaryEmail is an additional email address of an account user that can
+// be used for resetting the password in case the primary address cannot be
+// accessed anymore. Like the primary address, it is only stored as a hash
+// and carries its own set of email encrypted key encryption keys. As the
+// plain address is not known, security notifications cannot be sent to it.
+type secondaryEmail struct {
+	SecondaryEmailID string `json:"secondaryEmailId"`
+	HashedEmail      string `json:"hashedEmail"`
+	EmailLookupHash  string `json:"emailLookupHash,omitempty"`
+	EmailLookupKeyID string `json:"emailLookupKeyId,omitempty"`
+	// EmailEncryptedKeys maps relationship ids to key encryption keys
+	// encrypted using a key derived from the secondary email address.
+	EmailEncryptedKeys map[string]string `json:"emailEncryptedKeys"`
+	// HashedToken is the token needed for confirming the address. It is
+	// removed once the address has been confirmed.
+	HashedToken string    `json:"hashedToken,omitempty"`
+	Expires     time.Time `json:"expires,omitempty"`
+	Verified    bool      `json:"verified"`
+	Created     time.Time `json:"created"`
+}
+
+func (a *AccountUser) secondaryEmails() ([]secondaryEmail, error) {
+	var emails []secondaryEmail
+	if a.SecondaryEmails == "" {
+		return emails, nil
+	}
+	if err := json.Unmarshal([]byte(a.SecondaryEmails), &emails); err != nil {
+		return nil, fmt.Errorf("persistence: error decoding secondary emails: %w", err)
+	}
+	return emails, nil
+}
+
+func (a *AccountUser) saveSecondaryEmails(emails []secondaryEmail) error {
+	a.SecondaryEmails = ""
+	if len(emails) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(emails)
+	if err != nil {
+		return fmt.Errorf("persistence: error encoding secondary emails: %w", err)
+	}
+	a.SecondaryEmails = string(b)
+	return nil
+}
+
+// outdated checks whether the secondary email lacks envelopes for any of the
+// given relationships, which happens when the account user has been added
+// to accounts after adding the address.
+func (s *secondaryEmail) outdated(relationships []AccountUserRelationship) bool {
+	for _, relationship := range relationships {
+		if relationship.EmailEncryptedKeyEncryptionKey == "" {
+			continue
+		}
+		if _, ok := s.EmailEncryptedKeys[relationship.RelationshipID]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *secondaryEmail) export(relationships []AccountUserRelationship) SecondaryEmailResult {
+	return SecondaryEmailResult{
+		SecondaryEmailID: s.SecondaryEmailID,
+		Verified:         s.Verified,
+		Outdated:         s.outdated(relationships),
+		Created:          s.Created,
+	}
+}
+
+func (p *persistenceLayer) matchesSecondaryEmail(s *secondaryEmail, email string) bool {
+	if matches, known := p.matchesLookupHash(s.EmailLookupKeyID, s.EmailLookupHash, email); known {
+		return matches
+	}
+	return p.hasher().CompareString(email, s.HashedEmail) == nil
+}
+
+// AddSecondaryEmail adds an unverified secondary email address to the account
+// user and returns a token that needs to be passed to ConfirmSecondaryEmail.
+// Key encryption keys are encrypted for the new address using the current
+// email address, which also needs to be given.
+func (p *persistenceLayer) AddSecondaryEmail(userID, emailAddress, currentEmailAddress, password string) ([]byte, error) {
+	accountUser, err := p.findAccountUser(currentEmailAddress, true, true)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	if accountUser.AccountUserID != userID {
+		return nil, errors.New("persistence: current email did not match requester credentials")
+	}
+	if err := keys.ComparePassword(password, accountUser.HashedPassword, p.pepper); err != nil {
+		return nil, fmt.Errorf("persistence: passwords did not match: %w", err)
+	}
+	if emailAddress == currentEmailAddress {
+		return nil, errors.New("persistence: secondary email must differ from primary email")
+	}
+	if existing, _ := p.findAccountUser(emailAddress, false, false); existing != nil {
+		return nil, fmt.Errorf("persistence: given email %s is already in use", emailAddress)
+	}
+
+	emails, err := accountUser.secondaryEmails()
+	if err != nil {
+		return nil, err
+	}
+	for _, email := range emails {
+		if p.matchesSecondaryEmail(&email, emailAddress) {
+			return nil, fmt.Errorf("persistence: given email %s has already been added", emailAddress)
+		}
+	}
+
+	keyFromCurrentEmail, err := keys.DeriveKey(currentEmailAddress, accountUser.emailSalt())
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error deriving key from email: %w", err)
+	}
+	hashedEmail, err := keys.HashStringWith(emailAddress, p.kdfParams)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error hashing secondary email address: %w", err)
+	}
+	token, err := keys.GenerateRandomBytes(keys.DefaultEncryptionKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error creating token: %w", err)
+	}
+	hashedToken, err := keys.HashStringWith(base64.StdEncoding.EncodeToString(token), p.kdfParams)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error hashing token: %w", err)
+	}
+	secondaryEmailID, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error creating secondary email id: %w", err)
+	}
+
+	now := time.Now()
+	email := secondaryEmail{
+		SecondaryEmailID:   secondaryEmailID.String(),
+		HashedEmail:        hashedEmail.Marshal(),
+		EmailEncryptedKeys: map[string]string{},
+		HashedToken:        hashedToken.Marshal(),
+		Expires:            now.Add(EmailChangeTTL),
+		Created:            now,
+	}
+	if p.emailLookup != nil {
+		email.EmailLookupHash, email.EmailLookupKeyID = p.emailLookup.Hash(emailAddress), p.emailLookup.KeyID()
+	}
+	for _, relationship := range accountUser.Relationships {
+		// pending invitations created using a token do not depend on the
+		// email address
+		if relationship.EmailEncryptedKeyEncryptionKey == "" {
+			continue
+		}
+		decryptedKey, err := keys.DecryptWith(keyFromCurrentEmail, relationship.EmailEncryptedKeyEncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error decrypting email encrypted key: %w", err)
+		}
+		if err := relationship.addEmailEncryptedKey(decryptedKey, accountUser.emailSalt(), emailAddress); err != nil {
+			return nil, fmt.Errorf("persistence: error adding email key to relationship: %w", err)
+		}
+		email.EmailEncryptedKeys[relationship.RelationshipID] = relationship.EmailEncryptedKeyEncryptionKey
+	}
+
+	if err := accountUser.saveSecondaryEmails(append(emails, email)); err != nil {
+		return nil, err
+	}
+	if err := p.dal.UpdateAccountUser(accountUser); err != nil {
+		return nil, fmt.Errorf("persistence: error saving secondary email: %w", err)
+	}
+	return token, nil
+}
+
+// ConfirmSecondaryEmail verifies the secondary email address of the given
+// account user in case the token matches.
+func (p *persistenceLayer) ConfirmSecondaryEmail(userID, emailAddress string, token []byte) error {
+	accountUser, err := p.dal.FindAccountUser(
+		FindAccountUserQueryByAccountUserIDIncludeRelationships(userID),
+	)
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	emails, err := accountUser.secondaryEmails()
+	if err != nil {
+		return err
+	}
+	for index, email := range emails {
+		if email.Verified || !p.matchesSecondaryEmail(&email, emailAddress) {
+			continue
+		}
+		if time.Now().After(email.Expires) {
+			return ErrInvalidOneTimeKey("persistence: secondary email confirmation has expired")
+		}
+		if err := keys.CompareString(base64.StdEncoding.EncodeToString(token), email.HashedToken); err != nil {
+			return ErrInvalidOneTimeKey("persistence: token did not match")
+		}
+		email.Verified = true
+		email.HashedToken = ""
+		emails[index] = email
+		if err := accountUser.saveSecondaryEmails(emails); err != nil {
+			return err
+		}
+		if err := p.dal.UpdateAccountUser(&accountUser); err != nil {
+			return fmt.Errorf("persistence: error updating account user: %w", err)
+		}
+		return nil
+	}
+	return ErrInvalidOneTimeKey("persistence: no pending secondary email found")
+}
+
+// ListSecondaryEmails returns the secondary email addresses of the given
+// account user. As addresses are only stored as hashes, they are identified
+// by their id.
+func (p *persistenceLayer) ListSecondaryEmails(userID string) ([]SecondaryEmailResult, error) {
+	accountUser, err := p.dal.FindAccountUser(
+		FindAccountUserQueryByAccountUserIDIncludeRelationships(userID),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	emails, err := accountUser.secondaryEmails()
+	if err != nil {
+		return nil, err
+	}
+	result := []SecondaryEmailResult{}
+	for _, email := range emails {
+		result = append(result, email.export(accountUser.Relationships))
+	}
+	return result, nil
+}
+
+// RemoveSecondaryEmail removes the secondary email address of the given id
+// from the account user.
+func (p *persistenceLayer) RemoveSecondaryEmail(userID, secondaryEmailID string) error {
+	accountUser, err := p.dal.FindAccountUser(
+		FindAccountUserQueryByAccountUserIDIncludeRelationships(userID),
+	)
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	emails, err := accountUser.secondaryEmails()
+	if err != nil {
+		return err
+	}
+	var remaining []secondaryEmail
+	for _, email := range emails {
+		if email.SecondaryEmailID != secondaryEmailID {
+			remaining = append(remaining, email)
+		}
+	}
+	if len(remaining) == len(emails) {
+		return fmt.Errorf("persistence: secondary email %s not found", secondaryEmailID)
+	}
+	if err := accountUser.saveSecondaryEmails(remaining); err != nil {
+		return err
+	}
+	if err := p.dal.UpdateAccountUser(&accountUser); err != nil {
+		return fmt.Errorf("persistence: error updating account user: %w", err)
+	}
+	return nil
+}
+
+// findAccountUserByAnyEmail looks up the account user using the given
+// address as its primary or as a verified secondary address. In case a
+// secondary address matches, it is returned as well.
+func (p *persistenceLayer) findAccountUserByAnyEmail(emailAddress string, includeRelationships bool) (*AccountUser, *secondaryEmail, error) {
+	if accountUser, err := p.findAccountUser(emailAddress, includeRelationships, false); err == nil {
+		return accountUser, nil, nil
+	}
+	accountUsers, err := p.dal.FindAccountUsers(FindAccountUsersQueryAllAccountUsers{
+		IncludeRelationships: includeRelationships,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("persistence: error looking up account users: %w", err)
+	}
+	for _, accountUser := range accountUsers {
+		emails, err := accountUser.secondaryEmails()
+		if err != nil {
+			continue
+		}
+		for _, email := range emails {
+			if email.Verified && p.matchesSecondaryEmail(&email, emailAddress) {
+				match, matchEmail := accountUser, email
+				return &match, &matchEmail, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("persistence: no account user found for %s", emailAddress)
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/offen/offen/server/keys"
+)
+
+type mockSecondaryEmailsDatabase struct {
+	mockSecondFactorDatabase
+}
+
+func (m *mockSecondaryEmailsDatabase) UpdateAccountUserRelationship(r *AccountUserRelationship) error {
+	for index, relationship := range m.accountUsers[0].Relationships {
+		if relationship.RelationshipID == r.RelationshipID {
+			m.accountUsers[0].Relationships[index] = *r
+		}
+	}
+	return nil
+}
+
+func (m *mockSecondaryEmailsDatabase) Transaction() (Transaction, error) {
+	return m, nil
+}
+
+func (m *mockSecondaryEmailsDatabase) Commit() error {
+	return nil
+}
+
+func (m *mockSecondaryEmailsDatabase) Rollback() error {
+	return nil
+}
+
+func TestPersistenceLayer_SecondaryEmails(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	accountUser, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, params, nil)
+	relationship, _ := newAccountUserRelationship(accountUser.AccountUserID, "account-a")
+	relationship.addPasswordEncryptedKey([]byte("{\"kty\":\"oct\"}"), accountUser.Salt, "develop")
+	relationship.addEmailEncryptedKey([]byte("{\"kty\":\"oct\"}"), accountUser.EmailSalt, "develop@offen.dev")
+	accountUser.Relationships = []AccountUserRelationship{*relationship}
+
+	db := &mockSecondaryEmailsDatabase{mockSecondFactorDatabase{mockLoginDatabase: mockLoginDatabase{accountUsers: []AccountUser{*accountUser}}}}
+	p := &persistenceLayer{dal: db, kdfParams: params}
+
+	if _, err := p.AddSecondaryEmail(accountUser.AccountUserID, "backup@offen.dev", "develop@offen.dev", "other"); err == nil {
+		t.Error("Expected error when using bad password")
+	}
+	if _, err := p.AddSecondaryEmail(accountUser.AccountUserID, "develop@offen.dev", "develop@offen.dev", "develop"); err == nil {
+		t.Error("Expected error when adding primary address")
+	}
+	token, err := p.AddSecondaryEmail(accountUser.AccountUserID, "backup@offen.dev", "develop@offen.dev", "develop")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, err := p.AddSecondaryEmail(accountUser.AccountUserID, "backup@offen.dev", "develop@offen.dev", "develop"); err == nil {
+		t.Error("Expected error when adding address twice")
+	}
+
+	// unverified addresses cannot be used for resetting the password
+	if _, err := p.GenerateOneTimeKey("backup@offen.dev"); err == nil {
+		t.Error("Expected error using unverified address")
+	}
+
+	var oneTimeKeyErr ErrInvalidOneTimeKey
+	if err := p.ConfirmSecondaryEmail(accountUser.AccountUserID, "backup@offen.dev", []byte("token")); !errors.As(err, &oneTimeKeyErr) {
+		t.Errorf("Expected invalid one time key error for bad token, got %v", err)
+	}
+	if err := p.ConfirmSecondaryEmail(accountUser.AccountUserID, "backup@offen.dev", token); err != nil {
+		t.Fatalf("Unexpected error confirming address %v", err)
+	}
+
+	emails, err := p.ListSecondaryEmails(accountUser.AccountUserID)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(emails) != 1 || !emails[0].Verified || emails[0].Outdated {
+		t.Errorf("Unexpected secondary emails %v", emails)
+	}
+
+	oneTimeKey, err := p.GenerateOneTimeKey("backup@offen.dev")
+	if err != nil {
+		t.Fatalf("Unexpected error generating one time key %v", err)
+	}
+	if _, err := p.ResetPassword("backup@offen.dev", "new-password", oneTimeKey); err != nil {
+		t.Fatalf("Unexpected error resetting password %v", err)
+	}
+	result, err := p.Login("develop@offen.dev", "new-password")
+	if err != nil {
+		t.Fatalf("Unexpected error logging in after reset %v", err)
+	}
+	if len(result.Accounts) != 1 {
+		t.Errorf("Unexpected result %v", result)
+	}
+	// secondary addresses cannot be used for logging in
+	if _, err := p.Login("backup@offen.dev", "new-password"); err != ErrInvalidCredentials {
+		t.Errorf("Expected invalid credentials, got %v", err)
+	}
+
+	if err := p.RemoveSecondaryEmail(accountUser.AccountUserID, "unknown"); err == nil {
+		t.Error("Expected error removing unknown address")
+	}
+	if err := p.RemoveSecondaryEmail(accountUser.AccountUserID, emails[0].SecondaryEmailID); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, err := p.GenerateOneTimeKey("backup@offen.dev"); err == nil {
+		t.Error("Expected error using removed address")
+	}
+}
+
+func TestSecondaryEmail_outdated(t *testing.T) {
+	email := secondaryEmail{EmailEncryptedKeys: map[string]string{"a": "envelope"}}
+	if email.outdated([]AccountUserRelationship{{RelationshipID: "a", EmailEncryptedKeyEncryptionKey: "x"}, {RelationshipID: "b"}}) {
+		t.Error("Expected address not to be outdated")
+	}
+	if !email.outdated([]AccountUserRelationship{{RelationshipID: "a", EmailEncryptedKeyEncryptionKey: "x"}, {RelationshipID: "b", EmailEncryptedKeyEncryptionKey: "y"}}) {
+		t.Error("Expected address to be outdated")
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/offen/offen/server/keys"
+)
+
+// secondFactorIssuer is the name authenticator apps display for enrolled
+// secrets.
+const secondFactorIssuer = "Offen"
+
+// recoveryCodeCount is the number of recovery codes that is issued on
+// enrollment or regeneration.
+const recoveryCodeCount = 10
+
+func (p *persistenceLayer) EnrollSecondFactor(userID, emailAddress, password string) (SecondFactorEnrollmentResult, error) {
+	accountUser, err := p.findSecondFactorUser(userID, emailAddress)
+	if err != nil {
+		return SecondFactorEnrollmentResult{}, err
+	}
+	if err := keys.ComparePassword(password, accountUser.HashedPassword, p.pepper); err != nil {
+		return SecondFactorEnrollmentResult{}, fmt.Errorf("persistence: passwords did not match: %w", err)
+	}
+	if accountUser.SecondFactorEnabled {
+		return SecondFactorEnrollmentResult{}, errors.New("persistence: second factor is already enabled")
+	}
+
+	secret, err := keys.GenerateTOTPSecret()
+	if err != nil {
+		return SecondFactorEnrollmentResult{}, fmt.Errorf("persistence: error creating second factor: %w", err)
+	}
+	emailDerivedKey, err := keys.DeriveKey(emailAddress, accountUser.emailSalt())
+	if err != nil {
+		return SecondFactorEnrollmentResult{}, fmt.Errorf("persistence: error deriving key from email: %w", err)
+	}
+	// the secret is stored right away, but will only be required on login
+	// after the account user has confirmed being able to create codes
+	if err := accountUser.setSecondFactorSecret(emailDerivedKey, secret); err != nil {
+		return SecondFactorEnrollmentResult{}, err
+	}
+	recoveryCodes, err := accountUser.generateRecoveryCodes(recoveryCodeCount, p.kdfParams)
+	if err != nil {
+		return SecondFactorEnrollmentResult{}, err
+	}
+	if err := p.dal.UpdateAccountUser(accountUser); err != nil {
+		return SecondFactorEnrollmentResult{}, fmt.Errorf("persistence: error saving second factor: %w", err)
+	}
+	if err := p.recordAuthEvent(accountUser.AccountUserID, AuthEventSecondFactorEnrolled, "", ""); err != nil {
+		return SecondFactorEnrollmentResult{}, err
+	}
+	return SecondFactorEnrollmentResult{
+		Secret:        keys.TOTPSecretString(secret),
+		URI:           keys.TOTPURI(secondFactorIssuer, emailAddress, secret),
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (p *persistenceLayer) EnableSecondFactor(userID, emailAddress, code string) error {
+	accountUser, err := p.findSecondFactorUser(userID, emailAddress)
+	if err != nil {
+		return err
+	}
+	emailDerivedKey, err := keys.DeriveKey(emailAddress, accountUser.emailSalt())
+	if err != nil {
+		return fmt.Errorf("persistence: error deriving key from email: %w", err)
+	}
+	if err := accountUser.verifySecondFactor(emailDerivedKey, code, time.Now()); err != nil {
+		return fmt.Errorf("persistence: error verifying second factor: %w", err)
+	}
+	accountUser.SecondFactorEnabled = true
+	if err := p.dal.UpdateAccountUser(accountUser); err != nil {
+		return fmt.Errorf("persistence: error enabling second factor: %w", err)
+	}
+	return p.recordAuthEvent(accountUser.AccountUserID, AuthEventSecondFactorEnabled, "", "")
+}
+
+func (p *persistenceLayer) DisableSecondFactor(userID, emailAddress, password, code string) error {
+	accountUser, err := p.findSecondFactorUser(userID, emailAddress)
+	if err != nil {
+		return err
+	}
+	if err := keys.ComparePassword(password, accountUser.HashedPassword, p.pepper); err != nil {
+		return fmt.Errorf("persistence: passwords did not match: %w", err)
+	}
+	if !accountUser.SecondFactorEnabled {
+		return errors.New("persistence: second factor is not enabled")
+	}
+	if err := p.checkSecondFactor(accountUser, emailAddress, code); err != nil {
+		return fmt.Errorf("persistence: error verifying second factor: %w", err)
+	}
+	accountUser.EncryptedSecondFactor = ""
+	accountUser.SecondFactorRecoveryCodes = ""
+	accountUser.SecondFactorEnabled = false
+	if err := p.dal.UpdateAccountUser(accountUser); err != nil {
+		return fmt.Errorf("persistence: error disabling second factor: %w", err)
+	}
+	return p.recordAuthEvent(accountUser.AccountUserID, AuthEventSecondFactorDisabled, "", "")
+}
+
+func (p *persistenceLayer) RegenerateRecoveryCodes(userID, emailAddress, password string) ([]string, error) {
+	accountUser, err := p.findSecondFactorUser(userID, emailAddress)
+	if err != nil {
+		return nil, err
+	}
+	if err := keys.ComparePassword(password, accountUser.HashedPassword, p.pepper); err != nil {
+		return nil, fmt.Errorf("persistence: passwords did not match: %w", err)
+	}
+	if !accountUser.SecondFactorEnabled {
+		return nil, errors.New("persistence: second factor is not enabled")
+	}
+	recoveryCodes, err := accountUser.generateRecoveryCodes(recoveryCodeCount, p.kdfParams)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.dal.UpdateAccountUser(accountUser); err != nil {
+		return nil, fmt.Errorf("persistence: error saving recovery codes: %w", err)
+	}
+	if err := p.recordAuthEvent(accountUser.AccountUserID, AuthEventRecoveryCodesRegenerated, "", ""); err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+func (p *persistenceLayer) LookupSecondFactor(userID string) (SecondFactorResult, error) {
+	accountUser, err := p.dal.FindAccountUser(FindAccountUserQueryByAccountUserIDIncludeRelationships(userID))
+	if err != nil {
+		return SecondFactorResult{}, fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	codes, err := accountUser.recoveryCodes()
+	if err != nil {
+		return SecondFactorResult{}, err
+	}
+	result := SecondFactorResult{Enabled: accountUser.SecondFactorEnabled}
+	for _, code := range codes {
+		if code.Consumed == nil {
+			result.RecoveryCodesRemaining++
+			continue
+		}
+		result.RecoveryCodesConsumed = append(result.RecoveryCodesConsumed, *code.Consumed)
+	}
+	return result, nil
+}
+
+// checkSecondFactor verifies the given code, which is either a TOTP code or
+// a recovery code. Recovery codes are consumed and persisted right away so
+// they cannot be used again.
+func (p *persistenceLayer) checkSecondFactor(accountUser *AccountUser, emailAddress, code string) error {
+	if _, ok := keys.NormalizeRecoveryCode(code); ok {
+		if err := accountUser.consumeRecoveryCode(code, time.Now()); err != nil {
+			return err
+		}
+		if err := p.dal.UpdateAccountUser(accountUser); err != nil {
+			return fmt.Errorf("persistence: error consuming recovery code: %w", err)
+		}
+		return nil
+	}
+	emailDerivedKey, err := keys.DeriveKey(emailAddress, accountUser.emailSalt())
+	if err != nil {
+		return fmt.Errorf("persistence: error deriving key from email: %w", err)
+	}
+	return accountUser.verifySecondFactor(emailDerivedKey, code, time.Now())
+}
+
+// findSecondFactorUser looks up the account user for the given email address
+// and ensures it matches the requesting account user.
+func (p *persistenceLayer) findSecondFactorUser(userID, emailAddress string) (*AccountUser, error) {
+	accountUser, err := p.findAccountUser(emailAddress, false, false)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	if accountUser.AccountUserID != userID {
+		return nil, errors.New("persistence: email did not match requester credentials")
+	}
+	return accountUser, nil
+}
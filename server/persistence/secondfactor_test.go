@@ -0,0 +1,143 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"encoding/base32"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/keys"
+)
+
+type mockSecondFactorDatabase struct {
+	mockLoginDatabase
+	authEvents []string
+}
+
+func (m *mockSecondFactorDatabase) CreateAuthEvent(a *AuthEvent) error {
+	m.authEvents = append(m.authEvents, a.Type)
+	return nil
+}
+
+func (m *mockSecondFactorDatabase) UpdateAccountUser(a *AccountUser) error {
+	m.accountUsers = []AccountUser{*a}
+	return nil
+}
+
+func (m *mockSecondFactorDatabase) FindAccountUser(interface{}) (AccountUser, error) {
+	return m.accountUsers[0], nil
+}
+
+func TestPersistenceLayer_SecondFactor(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	accountUser, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, params, nil)
+	relationship, _ := newAccountUserRelationship(accountUser.AccountUserID, "account-a")
+	relationship.addPasswordEncryptedKey([]byte("{\"kty\":\"oct\"}"), accountUser.Salt, "develop")
+	relationship.addEmailEncryptedKey([]byte("{\"kty\":\"oct\"}"), accountUser.EmailSalt, "develop@offen.dev")
+	accountUser.Relationships = []AccountUserRelationship{*relationship}
+
+	db := &mockSecondFactorDatabase{mockLoginDatabase: mockLoginDatabase{accountUsers: []AccountUser{*accountUser}}}
+	p := &persistenceLayer{dal: db, kdfParams: params}
+
+	if _, err := p.EnrollSecondFactor("other-user", "develop@offen.dev", "develop"); err == nil {
+		t.Error("Expected error when enrolling for other user")
+	}
+	if _, err := p.EnrollSecondFactor(accountUser.AccountUserID, "develop@offen.dev", "other"); err == nil {
+		t.Error("Expected error when enrolling with bad password")
+	}
+	enrollment, err := p.EnrollSecondFactor(accountUser.AccountUserID, "develop@offen.dev", "develop")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("Unexpected error decoding secret %v", err)
+	}
+
+	// pending enrollments are not checked on login
+	if _, err := p.LoginWithSecondFactor("develop@offen.dev", "develop", ""); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	if err := p.EnableSecondFactor(accountUser.AccountUserID, "develop@offen.dev", "000000"); err == nil {
+		t.Error("Expected error when enabling with bad code")
+	}
+	if err := p.EnableSecondFactor(accountUser.AccountUserID, "develop@offen.dev", keys.TOTPCode(secret, time.Now())); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if _, err := p.LoginWithSecondFactor("develop@offen.dev", "develop", ""); !errors.Is(err, ErrSecondFactorRequired) {
+		t.Errorf("Expected second factor to be required, got %v", err)
+	}
+	if _, err := p.LoginWithSecondFactor("develop@offen.dev", "develop", "000000"); !errors.Is(err, ErrInvalidSecondFactor) {
+		t.Errorf("Expected invalid credentials, got %v", err)
+	}
+	if _, err := p.LoginWithSecondFactor("develop@offen.dev", "develop", keys.TOTPCode(secret, time.Now())); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, err := p.Login("develop@offen.dev", "develop"); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	if len(enrollment.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("Expected %d recovery codes, got %v", recoveryCodeCount, enrollment.RecoveryCodes)
+	}
+	if _, err := p.LoginWithSecondFactor("develop@offen.dev", "develop", enrollment.RecoveryCodes[0]); err != nil {
+		t.Errorf("Unexpected error using recovery code %v", err)
+	}
+	if _, err := p.LoginWithSecondFactor("develop@offen.dev", "develop", enrollment.RecoveryCodes[0]); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected consumed recovery code to be rejected, got %v", err)
+	}
+	status, err := p.LookupSecondFactor(accountUser.AccountUserID)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !status.Enabled || status.RecoveryCodesRemaining != recoveryCodeCount-1 || len(status.RecoveryCodesConsumed) != 1 {
+		t.Errorf("Unexpected second factor status %v", status)
+	}
+	if _, err := p.RegenerateRecoveryCodes(accountUser.AccountUserID, "develop@offen.dev", "other"); err == nil {
+		t.Error("Expected error when regenerating with bad password")
+	}
+	regenerated, err := p.RegenerateRecoveryCodes(accountUser.AccountUserID, "develop@offen.dev", "develop")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, err := p.LoginWithSecondFactor("develop@offen.dev", "develop", enrollment.RecoveryCodes[1]); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected previous recovery codes to be invalidated, got %v", err)
+	}
+
+	token, err := p.ChangeEmail(accountUser.AccountUserID, "other@offen.dev", "develop@offen.dev", "develop")
+	if err != nil {
+		t.Fatalf("Unexpected error changing email %v", err)
+	}
+	if err := p.ConfirmEmailChange(accountUser.AccountUserID, "other@offen.dev", token); err != nil {
+		t.Fatalf("Unexpected error confirming email change %v", err)
+	}
+	if _, err := p.LoginWithSecondFactor("other@offen.dev", "develop", keys.TOTPCode(secret, time.Now())); err != nil {
+		t.Errorf("Unexpected error after changing email %v", err)
+	}
+
+	if err := p.DisableSecondFactor(accountUser.AccountUserID, "other@offen.dev", "develop", "000000"); err == nil {
+		t.Error("Expected error when disabling with bad code")
+	}
+	if err := p.DisableSecondFactor(accountUser.AccountUserID, "other@offen.dev", "develop", regenerated[0]); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, err := p.LoginWithSecondFactor("other@offen.dev", "develop", ""); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	expectedEvents := []string{
+		AuthEventSecondFactorEnrolled,
+		AuthEventSecondFactorEnabled,
+		AuthEventRecoveryCodesRegenerated,
+		AuthEventSecondFactorDisabled,
+	}
+	if !reflect.DeepEqual(db.authEvents, expectedEvents) {
+		t.Errorf("Expected auth events %v, got %v", expectedEvents, db.authEvents)
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	uuid "github.com/gofrs/uuid"
+	"github.com/offen/offen/server/keys"
+)
+
+// ServiceAccountCredentialPrefix is prepended to all service account
+// credentials so they can be told apart from access tokens.
+const ServiceAccountCredentialPrefix = "sa."
+
+const serviceAccountSecretLength = 32
+
+func deriveServiceAccountKey(secret []byte, serviceAccountID string) ([]byte, error) {
+	// secrets are random values of the same size as PRF outputs, so the
+	// same derivation can be used
+	key, err := keys.DeriveKeyFromPRF(secret, []byte(serviceAccountID))
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error deriving key from service account secret: %w", err)
+	}
+	return key, nil
+}
+
+func (s *ServiceAccount) export() ServiceAccountResult {
+	accountIDs := []string{}
+	for _, relationship := range s.Relationships {
+		accountIDs = append(accountIDs, relationship.AccountID)
+	}
+	return ServiceAccountResult{
+		ServiceAccountID: s.ServiceAccountID,
+		Name:             s.Name,
+		AccountIDs:       accountIDs,
+		CreatedBy:        s.CreatedBy,
+		Created:          s.Created,
+	}
+}
+
+// canBeManagedBy checks whether the given account user is allowed to manage
+// all accounts the service account has access to.
+func (s *ServiceAccount) canBeManagedBy(accountUser *AccountUser) bool {
+	for _, relationship := range s.Relationships {
+		if !accountUser.canManageAccount(relationship.AccountID) {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateServiceAccount creates a service account that can read the given
+// accounts. The requesting account user needs to be allowed to manage all of
+// the given accounts. The credential is returned only once as it cannot be
+// recovered from the stored hash.
+func (p *persistenceLayer) CreateServiceAccount(userID, name string, accountIDs []string, emailAddress, password string) (ServiceAccountResult, error) {
+	if name == "" {
+		return ServiceAccountResult{}, errors.New("persistence: service accounts need to be given a name")
+	}
+	if len(accountIDs) == 0 {
+		return ServiceAccountResult{}, errors.New("persistence: service accounts need to be granted access to at least one account")
+	}
+
+	creator, err := p.findAccountUser(emailAddress, true, false)
+	if err != nil {
+		return ServiceAccountResult{}, fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	if creator.AccountUserID != userID {
+		return ServiceAccountResult{}, errors.New("persistence: email did not match requester credentials")
+	}
+	if err := keys.ComparePassword(password, creator.HashedPassword, p.pepper); err != nil {
+		return ServiceAccountResult{}, fmt.Errorf("persistence: passwords did not match: %w", err)
+	}
+	for _, accountID := range accountIDs {
+		if !creator.canManageAccount(accountID) {
+			return ServiceAccountResult{}, ErrPermissionDenied
+		}
+	}
+
+	serviceAccountID, err := uuid.NewV4()
+	if err != nil {
+		return ServiceAccountResult{}, fmt.Errorf("persistence: error creating service account id: %w", err)
+	}
+	secret, err := keys.GenerateRandomBytes(serviceAccountSecretLength)
+	if err != nil {
+		return ServiceAccountResult{}, fmt.Errorf("persistence: error creating service account secret: %w", err)
+	}
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+	salt, err := keys.NewFastSalt(keys.DefaultSecretLength)
+	if err != nil {
+		return ServiceAccountResult{}, fmt.Errorf("persistence: error creating salt: %w", err)
+	}
+	// the secret is random, so a fast hash is sufficient and keeps
+	// authenticating requests cheap
+	hashedSecret, err := keys.HashFast(encodedSecret, salt.Marshal())
+	if err != nil {
+		return ServiceAccountResult{}, fmt.Errorf("persistence: error hashing service account secret: %w", err)
+	}
+	envelopeKey, err := deriveServiceAccountKey(secret, serviceAccountID.String())
+	if err != nil {
+		return ServiceAccountResult{}, err
+	}
+
+	pwDerivedKey, err := keys.DeriveKey(password, creator.Salt)
+	if err != nil {
+		return ServiceAccountResult{}, fmt.Errorf("persistence: error deriving key from password: %w", err)
+	}
+
+	serviceAccount := &ServiceAccount{
+		ServiceAccountID: serviceAccountID.String(),
+		Name:             name,
+		HashedSecret:     hashedSecret,
+		Salt:             salt.Marshal(),
+		CreatedBy:        creator.AccountUserID,
+		Created:          time.Now(),
+	}
+	for _, accountID := range accountIDs {
+		var creatorRelationship *AccountUserRelationship
+		for idx := range creator.Relationships {
+			if creator.Relationships[idx].AccountID == accountID {
+				creatorRelationship = &creator.Relationships[idx]
+				break
+			}
+		}
+		decryptedKey, err := keys.DecryptWith(pwDerivedKey, creatorRelationship.PasswordEncryptedKeyEncryptionKey)
+		if err != nil {
+			return ServiceAccountResult{}, fmt.Errorf("persistence: error decrypting key encryption key: %w", err)
+		}
+		decryptedKey, err = creatorRelationship.resolveKeyEncryptionKey(decryptedKey, p.rotationKeys...)
+		if err != nil {
+			return ServiceAccountResult{}, fmt.Errorf("persistence: error resolving key encryption key: %w", err)
+		}
+
+		relationship, err := newAccountUserRelationship(serviceAccount.ServiceAccountID, accountID)
+		if err != nil {
+			return ServiceAccountResult{}, fmt.Errorf("persistence: error creating account user relationship: %w", err)
+		}
+		relationship.Role = AccountUserRoleViewer
+		envelope, err := keys.EncryptWith(envelopeKey, decryptedKey)
+		if err != nil {
+			return ServiceAccountResult{}, fmt.Errorf("persistence: error encrypting key encryption key: %w", err)
+		}
+		relationship.PasswordEncryptedKeyEncryptionKey = envelope.Marshal()
+		serviceAccount.Relationships = append(serviceAccount.Relationships, *relationship)
+	}
+
+	txn, err := p.dal.Transaction()
+	if err != nil {
+		return ServiceAccountResult{}, fmt.Errorf("persistence: error creating transaction: %w", err)
+	}
+	if err := txn.CreateServiceAccount(serviceAccount); err != nil {
+		txn.Rollback()
+		return ServiceAccountResult{}, fmt.Errorf("persistence: error persisting service account: %w", err)
+	}
+	for idx := range serviceAccount.Relationships {
+		if err := txn.CreateAccountUserRelationship(&serviceAccount.Relationships[idx]); err != nil {
+			txn.Rollback()
+			return ServiceAccountResult{}, fmt.Errorf("persistence: error persisting account user relationship: %w", err)
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return ServiceAccountResult{}, fmt.Errorf("persistence: error committing transaction: %w", err)
+	}
+
+	result := serviceAccount.export()
+	result.Credential = ServiceAccountCredentialPrefix + serviceAccount.ServiceAccountID + "." + encodedSecret
+	return result, nil
+}
+
+// findServiceAccount looks up the service account matching the given
+// credential including its relationships and returns the decoded secret.
+func (p *persistenceLayer) findServiceAccount(credential string) (*ServiceAccount, []byte, error) {
+	chunks := strings.SplitN(strings.TrimPrefix(credential, ServiceAccountCredentialPrefix), ".", 2)
+	if !strings.HasPrefix(credential, ServiceAccountCredentialPrefix) || len(chunks) != 2 {
+		return nil, nil, ErrInvalidServiceAccountCredential
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(chunks[1])
+	if err != nil {
+		return nil, nil, ErrInvalidServiceAccountCredential
+	}
+	serviceAccount, err := p.dal.FindServiceAccount(FindServiceAccountQueryByID(chunks[0]))
+	if err != nil {
+		return nil, nil, ErrInvalidServiceAccountCredential
+	}
+	hashedSecret, err := keys.HashFast(chunks[1], serviceAccount.Salt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("persistence: error hashing service account secret: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(hashedSecret), []byte(serviceAccount.HashedSecret)) != 1 {
+		return nil, nil, ErrInvalidServiceAccountCredential
+	}
+	relationships, err := p.dal.FindAccountUserRelationships(
+		FindAccountUserRelationshipsQueryByAccountUserID(serviceAccount.ServiceAccountID),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("persistence: error looking up relationships of service account: %w", err)
+	}
+	serviceAccount.Relationships = relationships
+	return &serviceAccount, secret, nil
+}
+
+// LookupServiceAccount returns the accounts the service account matching the
+// given credential can access. No keys are released, which requires calling
+// LoginServiceAccount instead.
+func (p *persistenceLayer) LookupServiceAccount(credential string) (LoginResult, error) {
+	serviceAccount, _, err := p.findServiceAccount(credential)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	result := LoginResult{
+		AccountUserID: serviceAccount.ServiceAccountID,
+		Accounts:      []LoginAccountResult{},
+	}
+	for _, relationship := range serviceAccount.Relationships {
+		result.Accounts = append(result.Accounts, LoginAccountResult{
+			AccountID: relationship.AccountID,
+			Role:      relationship.Role,
+		})
+	}
+	return result, nil
+}
+
+// LoginServiceAccount releases the key encryption keys the service account
+// matching the given credential has been granted.
+func (p *persistenceLayer) LoginServiceAccount(credential string) (LoginResult, error) {
+	serviceAccount, secret, err := p.findServiceAccount(credential)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	envelopeKey, err := deriveServiceAccountKey(secret, serviceAccount.ServiceAccountID)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	results := []LoginAccountResult{}
+	for _, relationship := range serviceAccount.Relationships {
+		decryptedKey, err := keys.DecryptWith(envelopeKey, relationship.PasswordEncryptedKeyEncryptionKey)
+		if err != nil {
+			return LoginResult{}, ErrInvalidServiceAccountCredential
+		}
+		decryptedKey, err = relationship.resolveKeyEncryptionKey(decryptedKey, p.rotationKeys...)
+		if err != nil {
+			return LoginResult{}, fmt.Errorf(`persistence: error resolving key for account "%s": %w`, relationship.AccountID, err)
+		}
+		result, err := p.loginAccountResult(relationship, decryptedKey)
+		if err != nil {
+			if errors.Is(err, errStaleKeyEncryptionKey) {
+				continue
+			}
+			return LoginResult{}, err
+		}
+		results = append(results, result)
+	}
+	return LoginResult{
+		AccountUserID: serviceAccount.ServiceAccountID,
+		Accounts:      results,
+	}, nil
+}
+
+// managedServiceAccounts returns all service accounts the given account user
+// is allowed to manage.
+func (p *persistenceLayer) managedServiceAccounts(userID string) ([]ServiceAccount, error) {
+	accountUser, err := p.dal.FindAccountUser(
+		FindAccountUserQueryByAccountUserIDIncludeRelationships(userID),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	serviceAccounts, err := p.dal.FindServiceAccounts(FindServiceAccountsQueryAllServiceAccounts{})
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up service accounts: %w", err)
+	}
+	var result []ServiceAccount
+	for _, serviceAccount := range serviceAccounts {
+		relationships, err := p.dal.FindAccountUserRelationships(
+			FindAccountUserRelationshipsQueryByAccountUserID(serviceAccount.ServiceAccountID),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("persistence: error looking up relationships of service account: %w", err)
+		}
+		serviceAccount.Relationships = relationships
+		// service accounts whose accounts have all been retired can only
+		// be managed by their creator
+		if len(relationships) == 0 && serviceAccount.CreatedBy != accountUser.AccountUserID {
+			continue
+		}
+		if serviceAccount.canBeManagedBy(&accountUser) {
+			result = append(result, serviceAccount)
+		}
+	}
+	return result, nil
+}
+
+// ListServiceAccounts returns all service accounts the given account user is
+// allowed to manage, i.e. service accounts that only have access to accounts
+// the account user is an admin of.
+func (p *persistenceLayer) ListServiceAccounts(userID string) ([]ServiceAccountResult, error) {
+	serviceAccounts, err := p.managedServiceAccounts(userID)
+	if err != nil {
+		return nil, err
+	}
+	result := []ServiceAccountResult{}
+	for _, serviceAccount := range serviceAccounts {
+		result = append(result, serviceAccount.export())
+	}
+	return result, nil
+}
+
+// DeleteServiceAccount deletes the given service account and all of its
+// envelopes in case the given account user is allowed to manage it.
+func (p *persistenceLayer) DeleteServiceAccount(userID, serviceAccountID string) error {
+	serviceAccounts, err := p.managedServiceAccounts(userID)
+	if err != nil {
+		return err
+	}
+	var found bool
+	for _, serviceAccount := range serviceAccounts {
+		if serviceAccount.ServiceAccountID == serviceAccountID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrPermissionDenied
+	}
+
+	txn, err := p.dal.Transaction()
+	if err != nil {
+		return fmt.Errorf("persistence: error creating transaction: %w", err)
+	}
+	if err := txn.DeleteAccountUserRelationships(DeleteAccountUserRelationshipsQueryByAccountUserID(serviceAccountID)); err != nil {
+		txn.Rollback()
+		return fmt.Errorf("persistence: error deleting relationships of service account: %w", err)
+	}
+	if err := txn.DeleteServiceAccounts(DeleteServiceAccountsQueryByID(serviceAccountID)); err != nil {
+		txn.Rollback()
+		return fmt.Errorf("persistence: error deleting service account: %w", err)
+	}
+	if err := txn.Commit(); err != nil {
+		return fmt.Errorf("persistence: error committing transaction: %w", err)
+	}
+	return nil
+}
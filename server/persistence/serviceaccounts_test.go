@@ -0,0 +1,193 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/offen/offen/server/keys"
+)
+
+type mockServiceAccountsDatabase struct {
+	mockWebAuthnDatabase
+	serviceAccounts []ServiceAccount
+	relationships   []AccountUserRelationship
+}
+
+func (m *mockServiceAccountsDatabase) CreateServiceAccount(s *ServiceAccount) error {
+	m.serviceAccounts = append(m.serviceAccounts, *s)
+	return nil
+}
+
+func (m *mockServiceAccountsDatabase) FindServiceAccount(q interface{}) (ServiceAccount, error) {
+	for _, serviceAccount := range m.serviceAccounts {
+		if serviceAccount.ServiceAccountID == string(q.(FindServiceAccountQueryByID)) {
+			return serviceAccount, nil
+		}
+	}
+	return ServiceAccount{}, errors.New("not found")
+}
+
+func (m *mockServiceAccountsDatabase) FindServiceAccounts(interface{}) ([]ServiceAccount, error) {
+	return m.serviceAccounts, nil
+}
+
+func (m *mockServiceAccountsDatabase) DeleteServiceAccounts(q interface{}) error {
+	var remaining []ServiceAccount
+	for _, serviceAccount := range m.serviceAccounts {
+		if serviceAccount.ServiceAccountID != string(q.(DeleteServiceAccountsQueryByID)) {
+			remaining = append(remaining, serviceAccount)
+		}
+	}
+	m.serviceAccounts = remaining
+	return nil
+}
+
+func (m *mockServiceAccountsDatabase) CreateAccountUserRelationship(r *AccountUserRelationship) error {
+	m.relationships = append(m.relationships, *r)
+	return nil
+}
+
+func (m *mockServiceAccountsDatabase) FindAccountUserRelationships(q interface{}) ([]AccountUserRelationship, error) {
+	var result []AccountUserRelationship
+	for _, relationship := range m.relationships {
+		if relationship.AccountUserID == string(q.(FindAccountUserRelationshipsQueryByAccountUserID)) {
+			result = append(result, relationship)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockServiceAccountsDatabase) DeleteAccountUserRelationships(q interface{}) error {
+	var remaining []AccountUserRelationship
+	for _, relationship := range m.relationships {
+		if relationship.AccountUserID != string(q.(DeleteAccountUserRelationshipsQueryByAccountUserID)) {
+			remaining = append(remaining, relationship)
+		}
+	}
+	m.relationships = remaining
+	return nil
+}
+
+func (m *mockServiceAccountsDatabase) Transaction() (Transaction, error) {
+	return m, nil
+}
+
+func (m *mockServiceAccountsDatabase) Commit() error {
+	return nil
+}
+
+func (m *mockServiceAccountsDatabase) Rollback() error {
+	return nil
+}
+
+func TestPersistenceLayer_ServiceAccounts(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	keyEncryptionKey, _ := keys.GenerateRandomBytes(keys.DefaultEncryptionKeySize)
+	encryptedPrivateKey, _ := keys.EncryptWith(keyEncryptionKey, []byte("private-key"))
+
+	accountUser, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevel(0), params, nil)
+	adminRelationship, _ := newAccountUserRelationship(accountUser.AccountUserID, "account-a")
+	adminRelationship.Role = AccountUserRoleAdmin
+	adminRelationship.addPasswordEncryptedKey(keyEncryptionKey, accountUser.Salt, "develop")
+	viewerRelationship, _ := newAccountUserRelationship(accountUser.AccountUserID, "account-b")
+	viewerRelationship.Role = AccountUserRoleViewer
+	viewerRelationship.addPasswordEncryptedKey(keyEncryptionKey, accountUser.Salt, "develop")
+	accountUser.Relationships = []AccountUserRelationship{*adminRelationship, *viewerRelationship}
+
+	db := &mockServiceAccountsDatabase{
+		mockWebAuthnDatabase: mockWebAuthnDatabase{
+			accountUser: *accountUser,
+			account:     Account{AccountID: "account-a", Name: "name", EncryptedPrivateKey: encryptedPrivateKey.Marshal()},
+		},
+	}
+	p := &persistenceLayer{dal: db, kdfParams: params}
+
+	t.Run("bad input", func(t *testing.T) {
+		for name, call := range map[string]func() error{
+			"no name": func() error {
+				_, err := p.CreateServiceAccount(accountUser.AccountUserID, "", []string{"account-a"}, "develop@offen.dev", "develop")
+				return err
+			},
+			"no accounts": func() error {
+				_, err := p.CreateServiceAccount(accountUser.AccountUserID, "ci", nil, "develop@offen.dev", "develop")
+				return err
+			},
+			"bad password": func() error {
+				_, err := p.CreateServiceAccount(accountUser.AccountUserID, "ci", []string{"account-a"}, "develop@offen.dev", "other")
+				return err
+			},
+			"other user": func() error {
+				_, err := p.CreateServiceAccount("other-user", "ci", []string{"account-a"}, "develop@offen.dev", "develop")
+				return err
+			},
+		} {
+			if err := call(); err == nil {
+				t.Errorf("%s: expected error", name)
+			}
+		}
+		if _, err := p.CreateServiceAccount(accountUser.AccountUserID, "ci", []string{"account-b"}, "develop@offen.dev", "develop"); err != ErrPermissionDenied {
+			t.Errorf("Expected permission denied, got %v", err)
+		}
+	})
+
+	created, err := p.CreateServiceAccount(accountUser.AccountUserID, "ci", []string{"account-a"}, "develop@offen.dev", "develop")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if created.Credential == "" || len(db.relationships) != 1 || db.relationships[0].Role != AccountUserRoleViewer {
+		t.Errorf("Unexpected result %v", created)
+	}
+
+	lookup, err := p.LookupServiceAccount(created.Credential)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if lookup.AccountUserID != created.ServiceAccountID || len(lookup.Accounts) != 1 || lookup.Accounts[0].KeyEncryptionKey != nil {
+		t.Errorf("Unexpected lookup result %v", lookup)
+	}
+
+	login, err := p.LoginServiceAccount(created.Credential)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(login.Accounts) != 1 || login.Accounts[0].AccountID != "account-a" || login.Accounts[0].KeyEncryptionKey == nil {
+		t.Errorf("Unexpected login result %v", login)
+	}
+
+	for name, value := range map[string]string{
+		"no prefix":    created.Credential[len(ServiceAccountCredentialPrefix):],
+		"malformed":    ServiceAccountCredentialPrefix + "service",
+		"wrong secret": ServiceAccountCredentialPrefix + created.ServiceAccountID + ".c2VjcmV0",
+		"unknown id":   ServiceAccountCredentialPrefix + "unknown" + created.Credential[len(ServiceAccountCredentialPrefix)+len(created.ServiceAccountID):],
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := p.LoginServiceAccount(value); err != ErrInvalidServiceAccountCredential {
+				t.Errorf("Expected invalid credential error, got %v", err)
+			}
+		})
+	}
+
+	listed, err := p.ListServiceAccounts(accountUser.AccountUserID)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(listed) != 1 || listed[0].Credential != "" || listed[0].AccountIDs[0] != "account-a" {
+		t.Errorf("Unexpected service accounts %v", listed)
+	}
+
+	if err := p.DeleteServiceAccount(accountUser.AccountUserID, "unknown"); err != ErrPermissionDenied {
+		t.Errorf("Expected permission denied, got %v", err)
+	}
+	if err := p.DeleteServiceAccount(accountUser.AccountUserID, created.ServiceAccountID); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if len(db.serviceAccounts) != 0 || len(db.relationships) != 0 {
+		t.Errorf("Expected service account to be deleted, got %v and %v", db.serviceAccounts, db.relationships)
+	}
+	if _, err := p.LookupServiceAccount(created.Credential); err != ErrInvalidServiceAccountCredential {
+		t.Errorf("Expected invalid credential error, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	uuid "github.com/gofrs/uuid"
+	"github.com/offen/offen/server/keys"
+)
+
+const refreshTokenSecretLength = 32
+
+func (s *Session) export() SessionResult {
+	result := SessionResult{
+		SessionID:      s.SessionID,
+		AccountUserID:  s.AccountUserID,
+		IPAddress:      s.IPAddress,
+		UserAgent:      s.UserAgent,
+		Created:        s.Created,
+		Expires:        s.Expires,
+		ImpersonatedBy: s.ImpersonatedBy,
+	}
+	if s.HashedRefreshToken != "" {
+		refreshExpires := s.RefreshExpires
+		result.RefreshExpires = &refreshExpires
+	}
+	return result
+}
+
+// rotateRefreshToken replaces the refresh token of the session and returns
+// the new token.
+func (s *Session) rotateRefreshToken(expires time.Time) (string, error) {
+	secret, err := keys.GenerateRandomValueWith(refreshTokenSecretLength, base64.RawURLEncoding)
+	if err != nil {
+		return "", fmt.Errorf("persistence: error creating refresh token: %w", err)
+	}
+	s.HashedRefreshToken = hashRefreshToken(secret)
+	s.RefreshExpires = expires
+	return s.SessionID + "." + secret, nil
+}
+
+// hashRefreshToken hashes the secret part of a refresh token. The secret is
+// a random value of sufficient length, so it does not need to be stretched
+// using a key derivation function.
+func hashRefreshToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func refreshTokenMatches(secret, hashedSecret string) bool {
+	// sessions created before hashing using SHA-256 still store a versioned
+	// hash that is replaced the next time the session is refreshed
+	if strings.HasPrefix(hashedSecret, "{") {
+		return keys.CompareString(secret, hashedSecret) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(hashRefreshToken(secret)), []byte(hashedSecret)) == 1
+}
+
+// CreateSession creates a session for the given account user that expires
+// after the given ttl. In case refreshTTL is non-zero, a refresh token that
+// can be used for extending the session is returned as part of the result.
+func (p *persistenceLayer) CreateSession(userID, ipAddress, userAgent string, ttl, refreshTTL time.Duration) (SessionResult, error) {
+	sessionID, err := uuid.NewV4()
+	if err != nil {
+		return SessionResult{}, fmt.Errorf("persistence: error creating session id: %w", err)
+	}
+	now := time.Now()
+	session := &Session{
+		SessionID:     sessionID.String(),
+		AccountUserID: userID,
+		IPAddress:     ipAddress,
+		UserAgent:     userAgent,
+		Created:       now,
+		Expires:       now.Add(ttl),
+	}
+	var refreshToken string
+	if refreshTTL > 0 {
+		if refreshToken, err = session.rotateRefreshToken(now.Add(refreshTTL)); err != nil {
+			return SessionResult{}, err
+		}
+	}
+	if err := p.dal.CreateSession(session); err != nil {
+		return SessionResult{}, fmt.Errorf("persistence: error persisting session: %w", err)
+	}
+	result := session.export()
+	result.RefreshToken = refreshToken
+	return result, nil
+}
+
+// LookupSession returns the session of the given id. Sessions that have
+// expired or have been revoked are reported as an error.
+func (p *persistenceLayer) LookupSession(sessionID string) (SessionResult, error) {
+	session, err := p.dal.FindSession(FindSessionQueryByID(sessionID))
+	if err != nil {
+		return SessionResult{}, fmt.Errorf("persistence: error looking up session: %w", err)
+	}
+	if time.Now().After(session.Expires) {
+		return SessionResult{}, errors.New("persistence: session has expired")
+	}
+	return session.export(), nil
+}
+
+// RefreshSession extends the session the given refresh token refers to by
+// the given durations and rotates its refresh token. The new token is
+// returned as part of the result. As refresh tokens can only be used once,
+// presenting a token that has already been replaced revokes the session.
+func (p *persistenceLayer) RefreshSession(refreshToken string, ttl, refreshTTL time.Duration) (SessionResult, error) {
+	chunks := strings.SplitN(refreshToken, ".", 2)
+	if len(chunks) != 2 {
+		return SessionResult{}, ErrInvalidRefreshToken
+	}
+	session, err := p.dal.FindSession(FindSessionQueryByID(chunks[0]))
+	if err != nil || session.HashedRefreshToken == "" {
+		return SessionResult{}, ErrInvalidRefreshToken
+	}
+	now := time.Now()
+	if now.After(session.RefreshExpires) {
+		return SessionResult{}, ErrInvalidRefreshToken
+	}
+	if !refreshTokenMatches(chunks[1], session.HashedRefreshToken) {
+		if err := p.RevokeSession(session.AccountUserID, session.SessionID); err != nil {
+			return SessionResult{}, err
+		}
+		return SessionResult{}, ErrInvalidRefreshToken
+	}
+
+	accountUser, err := p.dal.FindAccountUser(FindAccountUserQueryByAccountUserIDIncludeRelationships(session.AccountUserID))
+	if err != nil {
+		return SessionResult{}, fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	if accountUser.Suspended {
+		if err := p.RevokeSession(session.AccountUserID, session.SessionID); err != nil {
+			return SessionResult{}, err
+		}
+		return SessionResult{}, ErrAccountUserSuspended
+	}
+
+	previousHash := session.HashedRefreshToken
+	session.Expires = now.Add(ttl)
+	token, err := session.rotateRefreshToken(now.Add(refreshTTL))
+	if err != nil {
+		return SessionResult{}, err
+	}
+	// in case the token has been used concurrently, only one of the requests
+	// can replace it, and the token is treated like it had been reused
+	updated, err := p.dal.RotateSession(&session, previousHash)
+	if err != nil {
+		return SessionResult{}, fmt.Errorf("persistence: error updating session: %w", err)
+	}
+	if updated != 1 {
+		if err := p.RevokeSession(session.AccountUserID, session.SessionID); err != nil {
+			return SessionResult{}, err
+		}
+		return SessionResult{}, ErrInvalidRefreshToken
+	}
+	result := session.export()
+	result.RefreshToken = token
+	return result, nil
+}
+
+// ListSessions returns all active sessions of the given account user, most
+// recent ones first. Sessions that have expired but can still be refreshed
+// are considered active.
+func (p *persistenceLayer) ListSessions(userID string) ([]SessionResult, error) {
+	sessions, err := p.dal.FindSessions(FindSessionsQueryByAccountUserID(userID))
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up sessions: %w", err)
+	}
+	now := time.Now()
+	result := []SessionResult{}
+	for _, session := range sessions {
+		if now.After(session.Expires) && (session.HashedRefreshToken == "" || now.After(session.RefreshExpires)) {
+			continue
+		}
+		result = append(result, session.export())
+	}
+	return result, nil
+}
+
+func (p *persistenceLayer) RevokeSession(userID, sessionID string) error {
+	if err := p.dal.DeleteSessions(DeleteSessionsQueryByID{
+		SessionID:     sessionID,
+		AccountUserID: userID,
+	}); err != nil {
+		return fmt.Errorf("persistence: error deleting session: %w", err)
+	}
+	return nil
+}
+
+func (p *persistenceLayer) RevokeSessions(userID string) error {
+	if err := p.dal.DeleteSessions(DeleteSessionsQueryByAccountUserID(userID)); err != nil {
+		return fmt.Errorf("persistence: error deleting sessions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/keys"
+)
+
+type mockSessionsDatabase struct {
+	DataAccessLayer
+	sessions    []Session
+	accountUser AccountUser
+	updated     *Session
+	stale       bool
+	revoked     string
+	err         error
+}
+
+func (m *mockSessionsDatabase) CreateSession(s *Session) error {
+	m.sessions = append(m.sessions, *s)
+	return m.err
+}
+
+func (m *mockSessionsDatabase) UpdateSession(s *Session) error {
+	m.updated = s
+	return m.err
+}
+
+func (m *mockSessionsDatabase) RotateSession(s *Session, hashedRefreshToken string) (int64, error) {
+	if m.stale {
+		return 0, m.err
+	}
+	m.updated = s
+	return 1, m.err
+}
+
+func (m *mockSessionsDatabase) DeleteSessions(q interface{}) error {
+	m.revoked = q.(DeleteSessionsQueryByID).SessionID
+	return m.err
+}
+
+func (m *mockSessionsDatabase) FindAccountUser(q interface{}) (AccountUser, error) {
+	return m.accountUser, m.err
+}
+
+func (m *mockSessionsDatabase) FindSession(q interface{}) (Session, error) {
+	for _, session := range m.sessions {
+		if session.SessionID == string(q.(FindSessionQueryByID)) {
+			return session, nil
+		}
+	}
+	return Session{}, errors.New("not found")
+}
+
+func (m *mockSessionsDatabase) FindSessions(q interface{}) ([]Session, error) {
+	return m.sessions, m.err
+}
+
+func TestPersistenceLayer_LookupSession(t *testing.T) {
+	now := time.Now()
+	p := &persistenceLayer{dal: &mockSessionsDatabase{
+		sessions: []Session{
+			{SessionID: "active", AccountUserID: "user-a", Expires: now.Add(time.Hour)},
+			{SessionID: "expired", AccountUserID: "user-a", Expires: now.Add(-time.Hour)},
+		},
+	}}
+	tests := []struct {
+		sessionID   string
+		expectError bool
+	}{
+		{"active", false},
+		{"expired", true},
+		{"unknown", true},
+	}
+	for _, test := range tests {
+		t.Run(test.sessionID, func(t *testing.T) {
+			result, err := p.LookupSession(test.sessionID)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if err == nil && result.AccountUserID != "user-a" {
+				t.Errorf("Unexpected result %v", result)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_ListSessions(t *testing.T) {
+	now := time.Now()
+	t.Run("ok", func(t *testing.T) {
+		p := &persistenceLayer{dal: &mockSessionsDatabase{
+			sessions: []Session{
+				{SessionID: "active", Expires: now.Add(time.Hour)},
+				{SessionID: "expired", Expires: now.Add(-time.Hour)},
+				{SessionID: "refreshable", Expires: now.Add(-time.Hour), HashedRefreshToken: "token", RefreshExpires: now.Add(time.Hour)},
+				{SessionID: "stale", Expires: now.Add(-time.Hour), HashedRefreshToken: "token", RefreshExpires: now.Add(-time.Minute)},
+			},
+		}}
+		result, err := p.ListSessions("user-a")
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if len(result) != 2 || result[0].SessionID != "active" || result[1].RefreshExpires == nil {
+			t.Errorf("Unexpected result %v", result)
+		}
+	})
+	t.Run("error", func(t *testing.T) {
+		p := &persistenceLayer{dal: &mockSessionsDatabase{err: errors.New("did not work")}}
+		if _, err := p.ListSessions("user-a"); err == nil {
+			t.Error("Expected error, got nil")
+		}
+	})
+}
+
+func TestPersistenceLayer_RefreshSession(t *testing.T) {
+	p := &persistenceLayer{dal: &mockSessionsDatabase{
+		accountUser: AccountUser{AccountUserID: "user-a"},
+	}}
+	withoutRefresh, err := p.CreateSession("user-a", "127.0.0.1", "agent", time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if withoutRefresh.RefreshToken != "" || withoutRefresh.RefreshExpires != nil {
+		t.Errorf("Unexpected refresh token in %v", withoutRefresh)
+	}
+
+	session, err := p.CreateSession("user-a", "127.0.0.1", "agent", time.Hour, time.Hour*24)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if session.RefreshToken == "" || session.RefreshExpires == nil {
+		t.Fatalf("Expected refresh token in %v", session)
+	}
+
+	for _, token := range []string{"", "xyz", withoutRefresh.SessionID + ".xyz", "unknown.xyz"} {
+		if _, err := p.RefreshSession(token, time.Hour, time.Hour*24); err != ErrInvalidRefreshToken {
+			t.Errorf("Expected invalid refresh token error for %q, got %v", token, err)
+		}
+	}
+
+	refreshed, err := p.RefreshSession(session.RefreshToken, time.Hour*2, time.Hour*48)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if refreshed.SessionID != session.SessionID || refreshed.RefreshToken == session.RefreshToken {
+		t.Errorf("Expected token of session to be rotated, got %v", refreshed)
+	}
+	db := p.dal.(*mockSessionsDatabase)
+	if db.updated == nil || !db.updated.Expires.After(session.Expires) || !db.updated.RefreshExpires.After(*session.RefreshExpires) {
+		t.Errorf("Expected session to be extended, got %v", db.updated)
+	}
+
+	// the previous token has been replaced and using it revokes the session
+	db.sessions[1] = *db.updated
+	if _, err := p.RefreshSession(session.RefreshToken, time.Hour, time.Hour*24); err != ErrInvalidRefreshToken {
+		t.Errorf("Expected invalid refresh token error, got %v", err)
+	}
+	if db.revoked != session.SessionID {
+		t.Errorf("Expected session to be revoked, got %v", db.revoked)
+	}
+
+	t.Run("expired", func(t *testing.T) {
+		db.sessions[1].RefreshExpires = time.Now().Add(-time.Hour)
+		if _, err := p.RefreshSession(refreshed.RefreshToken, time.Hour, time.Hour*24); err != ErrInvalidRefreshToken {
+			t.Errorf("Expected invalid refresh token error, got %v", err)
+		}
+	})
+	t.Run("concurrent use", func(t *testing.T) {
+		db.sessions[1] = *db.updated
+		db.stale = true
+		db.revoked = ""
+		defer func() { db.stale = false }()
+		if _, err := p.RefreshSession(refreshed.RefreshToken, time.Hour, time.Hour*24); err != ErrInvalidRefreshToken {
+			t.Errorf("Expected invalid refresh token error, got %v", err)
+		}
+		if db.revoked != session.SessionID {
+			t.Errorf("Expected session to be revoked, got %v", db.revoked)
+		}
+	})
+	t.Run("legacy hash", func(t *testing.T) {
+		hashed, _ := keys.HashStringWith("legacy", keys.DefaultKDFParams)
+		if !refreshTokenMatches("legacy", hashed.Marshal()) {
+			t.Error("Expected legacy hash to match")
+		}
+		if refreshTokenMatches("other", hashed.Marshal()) {
+			t.Error("Expected legacy hash not to match other secret")
+		}
+		if !refreshTokenMatches("secret", hashRefreshToken("secret")) || refreshTokenMatches("other", hashRefreshToken("secret")) {
+			t.Error("Unexpected result comparing hashes")
+		}
+	})
+	t.Run("suspended", func(t *testing.T) {
+		db.sessions[1].RefreshExpires = time.Now().Add(time.Hour)
+		db.accountUser.Suspended = true
+		db.revoked = ""
+		if _, err := p.RefreshSession(refreshed.RefreshToken, time.Hour, time.Hour*24); err != ErrAccountUserSuspended {
+			t.Errorf("Expected suspended error, got %v", err)
+		}
+		if db.revoked != session.SessionID {
+			t.Errorf("Expected session to be revoked, got %v", db.revoked)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+func (a *Account) timezone() string {
+	if a.Timezone == "" {
+		return time.UTC.String()
+	}
+	return a.Timezone
+}
+
+// location returns the location periods of the account are aligned to.
+func (a *Account) location() (*time.Location, error) {
+	return loadTimezone(a.timezone())
+}
+
+// loadTimezone loads the location of the given IANA time zone name. The
+// local time zone of the host is not accepted as it might change when
+// moving the installation.
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("persistence: %q is not a valid time zone", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error loading time zone %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// SetTimezone sets the IANA time zone name days of rollups are aligned to
+// for the account with the given id. As daily rollups that have been
+// computed before are aligned to the previous time zone, all daily rollups
+// covering events that are still stored are deleted and computed again.
+// Daily rollups older than the oldest stored event cannot be recomputed and
+// are kept as is.
+func (p *persistenceLayer) SetTimezone(accountID, timezone string) error {
+	loc, err := loadTimezone(strings.TrimSpace(timezone))
+	if err != nil {
+		return err
+	}
+	account, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID))
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+	}
+	if account.timezone() == loc.String() {
+		return nil
+	}
+	previous, err := account.location()
+	if err != nil {
+		// the previous time zone might not be available on this host
+		// anymore, in which case UTC is the best possible guess
+		previous = time.UTC
+	}
+	account.Timezone = loc.String()
+
+	now := time.Now()
+	events, err := p.dal.FindEvents(FindEventsQueryByAccountID{
+		AccountID: accountID,
+		Until:     boundaryEventID(now),
+	})
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up events for account %s: %w", accountID, err)
+	}
+	var oldest *time.Time
+	for _, event := range events {
+		id, err := ulid.Parse(event.EventID)
+		if err != nil {
+			continue
+		}
+		if t := ulid.Time(id.Time()); oldest == nil || t.Before(*oldest) {
+			oldest = &t
+		}
+	}
+
+	var rollups []*Rollup
+	if oldest != nil {
+		until := startOfPeriod(now, RollupPeriodDay, loc)
+		for start, rollup := range aggregateEvents(accountID, RollupPeriodDay, loc, events) {
+			if !start.Before(until) {
+				continue
+			}
+			rollup.Updated = now
+			rollups = append(rollups, rollup)
+		}
+	}
+
+	txn, err := p.dal.Transaction()
+	if err != nil {
+		return fmt.Errorf("persistence: error creating transaction: %w", err)
+	}
+	if err := txn.UpdateAccount(&account); err != nil {
+		txn.Rollback()
+		return fmt.Errorf("persistence: error updating account: %w", err)
+	}
+	if oldest != nil {
+		if err := txn.DeleteRollups(DeleteRollupsQueryByAccountID{
+			AccountID: accountID,
+			Period:    RollupPeriodDay,
+			From:      startOfPeriod(*oldest, RollupPeriodDay, previous),
+		}); err != nil {
+			txn.Rollback()
+			return fmt.Errorf("persistence: error deleting rollups: %w", err)
+		}
+	}
+	for _, rollup := range rollups {
+		if err := txn.SaveRollup(rollup); err != nil {
+			txn.Rollback()
+			return fmt.Errorf("persistence: error saving rollup: %w", err)
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return fmt.Errorf("persistence: error committing transaction: %w", err)
+	}
+	return nil
+}
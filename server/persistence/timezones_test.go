@@ -0,0 +1,144 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+type mockTimezonesDatabase struct {
+	mockRollupsDatabase
+	updated []Account
+	deleted []DeleteRollupsQueryByAccountID
+}
+
+func (m *mockTimezonesDatabase) FindAccount(q interface{}) (Account, error) {
+	return m.accounts[0], m.err
+}
+
+func (m *mockTimezonesDatabase) UpdateAccount(a *Account) error {
+	m.updated = append(m.updated, *a)
+	return nil
+}
+
+func (m *mockTimezonesDatabase) DeleteRollups(q interface{}) error {
+	m.deleted = append(m.deleted, q.(DeleteRollupsQueryByAccountID))
+	return nil
+}
+
+func (m *mockTimezonesDatabase) Transaction() (Transaction, error) {
+	return m, nil
+}
+
+func TestStartOfPeriod(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	kathmandu, _ := time.LoadLocation("Asia/Kathmandu")
+	tests := []struct {
+		name     string
+		t        time.Time
+		period   string
+		loc      *time.Location
+		expected time.Time
+	}{
+		{
+			"utc day",
+			time.Date(2020, 3, 1, 23, 30, 0, 0, time.UTC),
+			RollupPeriodDay,
+			time.UTC,
+			time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			"day in other zone",
+			time.Date(2020, 3, 1, 23, 30, 0, 0, time.UTC),
+			RollupPeriodDay,
+			berlin,
+			time.Date(2020, 3, 1, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			"hour with offset",
+			time.Date(2020, 3, 1, 10, 10, 0, 0, time.UTC),
+			RollupPeriodHour,
+			kathmandu,
+			time.Date(2020, 3, 1, 9, 15, 0, 0, time.UTC),
+		},
+		{
+			"repeated hour",
+			time.Date(2020, 10, 25, 1, 30, 0, 0, time.UTC),
+			RollupPeriodHour,
+			berlin,
+			time.Date(2020, 10, 25, 1, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := startOfPeriod(test.t, test.period, test.loc)
+			if !result.Equal(test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_SetTimezone(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	day := time.Now().UTC().AddDate(0, 0, -3).Truncate(time.Hour * 24)
+	user := func(s string) *string { return &s }
+
+	t.Run("bad zone", func(t *testing.T) {
+		for _, zone := range []string{"", "Local", "Europe/Nowhere"} {
+			db := &mockTimezonesDatabase{}
+			if err := (&persistenceLayer{dal: db}).SetTimezone("account-a", zone); err == nil {
+				t.Errorf("Expected error for %q", zone)
+			}
+		}
+	})
+	t.Run("unchanged", func(t *testing.T) {
+		db := &mockTimezonesDatabase{
+			mockRollupsDatabase: mockRollupsDatabase{
+				accounts: []Account{{AccountID: "account-a", Timezone: "Europe/Berlin"}},
+			},
+		}
+		if err := (&persistenceLayer{dal: db}).SetTimezone("account-a", "Europe/Berlin"); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if len(db.updated) != 0 || len(db.deleted) != 0 {
+			t.Errorf("Unexpected update %v", db.updated)
+		}
+	})
+	t.Run("changed", func(t *testing.T) {
+		db := &mockTimezonesDatabase{
+			mockRollupsDatabase: mockRollupsDatabase{
+				accounts: []Account{{AccountID: "account-a"}},
+				events: []Event{
+					eventAt("account-a", day.Add(time.Hour*12), user("user-a")),
+					eventAt("account-a", day.Add(time.Hour*23), user("user-b")),
+					eventAt("account-a", day.Add(time.Hour*24), user("user-a")),
+				},
+			},
+		}
+		if err := (&persistenceLayer{dal: db}).SetTimezone("account-a", " Europe/Berlin "); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if len(db.updated) != 1 || db.updated[0].Timezone != "Europe/Berlin" {
+			t.Errorf("Unexpected update %v", db.updated)
+		}
+		if len(db.deleted) != 1 || !db.deleted[0].From.Equal(day) || db.deleted[0].Period != RollupPeriodDay {
+			t.Errorf("Unexpected deletion %v", db.deleted)
+		}
+		// the first day starts the day before in UTC
+		expected := map[time.Time]int{
+			startOfPeriod(day, RollupPeriodDay, berlin).UTC():                   1,
+			startOfPeriod(day.Add(time.Hour*23), RollupPeriodDay, berlin).UTC(): 2,
+		}
+		if len(db.saved) != len(expected) {
+			t.Fatalf("Unexpected rollups %v", db.saved)
+		}
+		for _, rollup := range db.saved {
+			if count, ok := expected[rollup.Start]; !ok || count != rollup.Events || rollup.Period != RollupPeriodDay {
+				t.Errorf("Unexpected rollup %v", rollup)
+			}
+		}
+	})
+}
@@ -0,0 +1,42 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"context"
+
+	"github.com/offen/offen/server/tracing"
+)
+
+// contextualDAL is implemented by data access layers that can relate the
+// queries they run to the span found in a context.
+type contextualDAL interface {
+	WithContext(ctx context.Context) DataAccessLayer
+}
+
+// WithContext returns a service that traces its calls as children of the
+// span found in the given context. Services that do not support tracing are
+// returned as is.
+func WithContext(ctx context.Context, s Service) Service {
+	p, ok := s.(*persistenceLayer)
+	if !ok {
+		return s
+	}
+	traced := *p
+	traced.ctx = ctx
+	return &traced
+}
+
+// startSpan starts a span for the given method and returns the data access
+// layer that is expected to be used while the span is active.
+func (p *persistenceLayer) startSpan(method string) (DataAccessLayer, *tracing.Span) {
+	if p.ctx == nil {
+		return p.dal, nil
+	}
+	ctx, span := tracing.Start(p.ctx, "persistence."+method, tracing.SpanKindInternal)
+	if dal, ok := p.dal.(contextualDAL); ok {
+		return dal.WithContext(ctx), span
+	}
+	return p.dal, span
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"context"
+	"testing"
+
+	"github.com/offen/offen/server/tracing"
+)
+
+type mockContextualDatabase struct {
+	DataAccessLayer
+	ctx context.Context
+}
+
+func (m *mockContextualDatabase) WithContext(ctx context.Context) DataAccessLayer {
+	return &mockContextualDatabase{ctx: ctx}
+}
+
+type mockExporter struct {
+	spans []tracing.SpanData
+}
+
+func (m *mockExporter) Export(spans []tracing.SpanData) error {
+	m.spans = append(m.spans, spans...)
+	return nil
+}
+
+func TestWithContext(t *testing.T) {
+	exporter := &mockExporter{}
+	tracer := tracing.New(exporter)
+	tracing.SetDefault(tracer)
+	defer tracing.SetDefault(nil)
+
+	dal := &mockContextualDatabase{}
+	service, _ := New(dal)
+
+	untracedDAL, span := service.(*persistenceLayer).startSpan("Method")
+	if untracedDAL != dal {
+		t.Errorf("Expected data access layer to be returned as is")
+	}
+	if span != nil {
+		t.Errorf("Unexpected span %v", span)
+	}
+
+	ctx, parent := tracing.Start(context.Background(), "parent", tracing.SpanKindServer)
+	traced := WithContext(ctx, service).(*persistenceLayer)
+	if service.(*persistenceLayer).ctx != nil {
+		t.Errorf("Expected original service to be unchanged")
+	}
+	tracedDAL, span := traced.startSpan("Method")
+	span.End()
+	parent.End()
+	tracer.Shutdown(context.Background())
+
+	if tracing.SpanFromContext(tracedDAL.(*mockContextualDatabase).ctx) != span {
+		t.Errorf("Expected data access layer to receive context of span")
+	}
+	if len(exporter.spans) != 2 {
+		t.Fatalf("Unexpected number of spans %d", len(exporter.spans))
+	}
+	if exporter.spans[0].Name != "persistence.Method" || exporter.spans[0].ParentSpanID != parent.Context().SpanID {
+		t.Errorf("Unexpected span %v", exporter.spans[0])
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/offen/offen/server/keys"
+)
+
+func encodeCredentialID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+func (p *persistenceLayer) RegisterWebAuthnCredential(userID, emailAddress, password string, credential WebAuthnCredentialResult, prfOutput []byte) error {
+	accountUser, err := p.findAccountUser(emailAddress, true, false)
+	if err != nil {
+		return fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	if accountUser.AccountUserID != userID {
+		return errors.New("persistence: email did not match requester credentials")
+	}
+	if err := keys.ComparePassword(password, accountUser.HashedPassword, p.pepper); err != nil {
+		return fmt.Errorf("persistence: passwords did not match: %w", err)
+	}
+
+	envelopeKey, err := keys.DeriveKeyFromPRF(prfOutput, credential.CredentialID)
+	if err != nil {
+		return fmt.Errorf("persistence: error deriving key for credential: %w", err)
+	}
+	pwDerivedKey, err := keys.DeriveKey(password, accountUser.Salt)
+	if err != nil {
+		return fmt.Errorf("persistence: error deriving key from password: %w", err)
+	}
+
+	record := &WebAuthnCredential{
+		CredentialID:  encodeCredentialID(credential.CredentialID),
+		AccountUserID: accountUser.AccountUserID,
+		PublicKey:     base64.StdEncoding.EncodeToString(credential.PublicKey),
+		SignCount:     credential.SignCount,
+		Created:       time.Now(),
+	}
+	for _, relationship := range accountUser.Relationships {
+		envelope, err := keys.DecryptWith(pwDerivedKey, relationship.PasswordEncryptedKeyEncryptionKey)
+		if err != nil {
+			return fmt.Errorf("persistence: error decrypting key encryption key: %w", err)
+		}
+		key, err := relationship.resolveKeyEncryptionKey(envelope, p.rotationKeys...)
+		if err != nil {
+			return fmt.Errorf("persistence: error resolving key encryption key: %w", err)
+		}
+		if err := record.addKeyEncryptionKey(envelopeKey, relationship.RelationshipID, key); err != nil {
+			return err
+		}
+	}
+
+	if err := p.dal.CreateWebAuthnCredential(record); err != nil {
+		return fmt.Errorf("persistence: error saving credential: %w", err)
+	}
+	return nil
+}
+
+func (p *persistenceLayer) LookupWebAuthnCredential(credentialID []byte) (WebAuthnCredentialResult, error) {
+	record, err := p.dal.FindWebAuthnCredential(FindWebAuthnCredentialQueryByID(encodeCredentialID(credentialID)))
+	if err != nil {
+		return WebAuthnCredentialResult{}, fmt.Errorf("persistence: error looking up credential: %w", err)
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(record.PublicKey)
+	if err != nil {
+		return WebAuthnCredentialResult{}, fmt.Errorf("persistence: error decoding public key: %w", err)
+	}
+	return WebAuthnCredentialResult{
+		CredentialID: credentialID,
+		PublicKey:    publicKey,
+		SignCount:    record.SignCount,
+	}, nil
+}
+
+// LoginWithWebAuthn releases the key encryption keys that have been stored
+// for the given credential. Callers are expected to have verified an assertion
+// of the credential before. Accounts that have been shared with the account
+// user or whose keys have been rotated after the credential was registered
+// are not contained in the result.
+func (p *persistenceLayer) LoginWithWebAuthn(credentialID []byte, signCount uint32, prfOutput []byte) (LoginResult, error) {
+	record, err := p.dal.FindWebAuthnCredential(FindWebAuthnCredentialQueryByID(encodeCredentialID(credentialID)))
+	if err != nil {
+		return LoginResult{}, ErrInvalidCredentials
+	}
+	envelopeKey, err := keys.DeriveKeyFromPRF(prfOutput, credentialID)
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("persistence: error deriving key for credential: %w", err)
+	}
+	envelopes, err := record.keyEncryptionKeys()
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	accountUser, err := p.dal.FindAccountUser(FindAccountUserQueryByAccountUserIDIncludeRelationships(record.AccountUserID))
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("persistence: error looking up account user: %w", err)
+	}
+	if accountUser.Suspended {
+		return LoginResult{}, ErrAccountUserSuspended
+	}
+
+	var results []LoginAccountResult
+	for _, relationship := range accountUser.Relationships {
+		envelope, ok := envelopes[relationship.RelationshipID]
+		if !ok {
+			continue
+		}
+		decryptedKey, err := keys.DecryptWith(envelopeKey, envelope)
+		if err != nil {
+			return LoginResult{}, ErrInvalidCredentials
+		}
+		decryptedKey, err = relationship.resolveKeyEncryptionKey(decryptedKey, p.rotationKeys...)
+		if err != nil {
+			return LoginResult{}, fmt.Errorf(`persistence: error resolving key for account "%s": %w`, relationship.AccountID, err)
+		}
+		result, err := p.loginAccountResult(relationship, decryptedKey)
+		if err != nil {
+			// in case the key rotation has been completed since registering
+			// the credential, the stored key cannot be used anymore
+			if errors.Is(err, errStaleKeyEncryptionKey) {
+				continue
+			}
+			return LoginResult{}, err
+		}
+		results = append(results, result)
+	}
+
+	allowedNetworks, err := accountUser.allowedNetworks()
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	record.SignCount = signCount
+	if err := p.dal.UpdateWebAuthnCredential(&record); err != nil {
+		return LoginResult{}, fmt.Errorf("persistence: error updating credential: %w", err)
+	}
+
+	return LoginResult{
+		AccountUserID:   accountUser.AccountUserID,
+		AdminLevel:      accountUser.AdminLevel,
+		Accounts:        results,
+		AllowedNetworks: allowedNetworks,
+	}, nil
+}
+
+func (p *persistenceLayer) DeleteWebAuthnCredential(userID string, credentialID []byte) error {
+	if err := p.dal.DeleteWebAuthnCredentials(DeleteWebAuthnCredentialsQueryByID{
+		CredentialID:  encodeCredentialID(credentialID),
+		AccountUserID: userID,
+	}); err != nil {
+		return fmt.Errorf("persistence: error deleting credential: %w", err)
+	}
+	return nil
+}
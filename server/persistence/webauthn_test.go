@@ -0,0 +1,118 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/offen/offen/server/keys"
+)
+
+type mockWebAuthnDatabase struct {
+	DataAccessLayer
+	accountUser AccountUser
+	account     Account
+	credential  *WebAuthnCredential
+}
+
+func (m *mockWebAuthnDatabase) FindAccountUsers(interface{}) ([]AccountUser, error) {
+	return []AccountUser{m.accountUser}, nil
+}
+
+func (m *mockWebAuthnDatabase) FindAccountUser(interface{}) (AccountUser, error) {
+	return m.accountUser, nil
+}
+
+func (m *mockWebAuthnDatabase) FindAccount(interface{}) (Account, error) {
+	return m.account, nil
+}
+
+func (m *mockWebAuthnDatabase) CreateWebAuthnCredential(w *WebAuthnCredential) error {
+	m.credential = w
+	return nil
+}
+
+func (m *mockWebAuthnDatabase) FindWebAuthnCredential(interface{}) (WebAuthnCredential, error) {
+	if m.credential == nil {
+		return WebAuthnCredential{}, errors.New("not found")
+	}
+	return *m.credential, nil
+}
+
+func (m *mockWebAuthnDatabase) UpdateWebAuthnCredential(w *WebAuthnCredential) error {
+	m.credential = w
+	return nil
+}
+
+func TestPersistenceLayer_WebAuthn(t *testing.T) {
+	params := keys.KDFParams{Time: 1, Memory: 64, Threads: 1}
+	keyEncryptionKey, _ := keys.GenerateRandomBytes(keys.DefaultEncryptionKeySize)
+	encryptedPrivateKey, _ := keys.EncryptWith(keyEncryptionKey, []byte("private-key"))
+
+	accountUser, _ := newAccountUser("develop@offen.dev", "develop", AccountUserAdminLevelSuperAdmin, params, nil)
+	relationship, _ := newAccountUserRelationship(accountUser.AccountUserID, "account-a")
+	relationship.addPasswordEncryptedKey(keyEncryptionKey, accountUser.Salt, "develop")
+	accountUser.Relationships = []AccountUserRelationship{*relationship}
+	accountUser.AllowedNetworks = `["10.0.0.0/8"]`
+
+	db := &mockWebAuthnDatabase{
+		accountUser: *accountUser,
+		account:     Account{AccountID: "account-a", Name: "name", EncryptedPrivateKey: encryptedPrivateKey.Marshal()},
+	}
+	p := &persistenceLayer{dal: db, kdfParams: params}
+
+	prfOutput := bytes.Repeat([]byte("p"), 32)
+	credential := WebAuthnCredentialResult{CredentialID: []byte("credential-a"), PublicKey: []byte("public-key")}
+
+	if err := p.RegisterWebAuthnCredential("other-user", "develop@offen.dev", "develop", credential, prfOutput); err == nil {
+		t.Error("Expected error when registering for other user")
+	}
+	if err := p.RegisterWebAuthnCredential(accountUser.AccountUserID, "develop@offen.dev", "other", credential, prfOutput); err == nil {
+		t.Error("Expected error when registering with bad password")
+	}
+	if err := p.RegisterWebAuthnCredential(accountUser.AccountUserID, "develop@offen.dev", "develop", credential, prfOutput); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	lookup, err := p.LookupWebAuthnCredential([]byte("credential-a"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !bytes.Equal(lookup.PublicKey, []byte("public-key")) {
+		t.Errorf("Unexpected public key %v", lookup.PublicKey)
+	}
+
+	if _, err := p.LoginWithWebAuthn([]byte("credential-a"), 1, bytes.Repeat([]byte("x"), 32)); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected invalid credentials for bad prf output, got %v", err)
+	}
+
+	result, err := p.LoginWithWebAuthn([]byte("credential-a"), 1, prfOutput)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result.Accounts) != 1 || result.Accounts[0].AccountID != "account-a" {
+		t.Errorf("Unexpected result %v", result)
+	}
+	if !reflect.DeepEqual(result.AllowedNetworks, []string{"10.0.0.0/8"}) {
+		t.Errorf("Unexpected allowed networks %v", result.AllowedNetworks)
+	}
+	if db.credential.SignCount != 1 {
+		t.Errorf("Expected sign count to be updated, got %d", db.credential.SignCount)
+	}
+
+	// after the account key has been rotated, the stored key is skipped
+	rotatedKey, _ := keys.GenerateRandomBytes(keys.DefaultEncryptionKeySize)
+	rotatedPrivateKey, _ := keys.EncryptWith(rotatedKey, []byte("private-key"))
+	db.account.EncryptedPrivateKey = rotatedPrivateKey.Marshal()
+	result, err = p.LoginWithWebAuthn([]byte("credential-a"), 2, prfOutput)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result.Accounts) != 0 {
+		t.Errorf("Expected stale key to be skipped, got %v", result)
+	}
+}
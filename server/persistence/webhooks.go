@@ -0,0 +1,261 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	uuid "github.com/gofrs/uuid"
+	"github.com/offen/offen/server/keys"
+)
+
+// Events webhooks can subscribe to.
+const (
+	// WebhookEventWeeklyAggregate is sent when the metrics of the past week
+	// have been aggregated.
+	WebhookEventWeeklyAggregate = "weekly_aggregate.ready"
+	// WebhookEventRetentionPurge is sent when events of an account have been
+	// deleted because they exceeded the retention period.
+	WebhookEventRetentionPurge = "retention_purge.completed"
+	// WebhookEventAccountUserAdded is sent when an account user has been
+	// given access to an account.
+	WebhookEventAccountUserAdded = "account_user.added"
+	// WebhookEventTrafficAnomaly is sent when the traffic of an account has
+	// deviated from its baseline.
+	WebhookEventTrafficAnomaly = "traffic_anomaly.detected"
+)
+
+// WebhookEvents contains all events webhooks can subscribe to.
+var WebhookEvents = []string{
+	WebhookEventWeeklyAggregate,
+	WebhookEventRetentionPurge,
+	WebhookEventAccountUserAdded,
+	WebhookEventTrafficAnomaly,
+}
+
+// WebhookDeliveryRetention is the duration delivery logs are kept for.
+const WebhookDeliveryRetention = time.Hour * 24 * 30
+
+const webhookSecretLength = 32
+
+func (w *Webhook) export() WebhookResult {
+	return WebhookResult{
+		WebhookID: w.WebhookID,
+		AccountID: w.AccountID,
+		URL:       w.URL,
+		Events:    w.Events,
+		CreatedBy: w.CreatedBy,
+		Created:   w.Created,
+	}
+}
+
+func (w *Webhook) subscribes(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *WebhookDelivery) export() WebhookDeliveryResult {
+	return WebhookDeliveryResult{
+		DeliveryID: w.DeliveryID,
+		WebhookID:  w.WebhookID,
+		Event:      w.Event,
+		Payload:    w.Payload,
+		Attempts:   w.Attempts,
+		StatusCode: w.StatusCode,
+		Error:      w.Error,
+		Delivered:  w.Delivered,
+		Created:    w.Created,
+		Updated:    w.Updated,
+	}
+}
+
+// CreateWebhook registers a webhook for the given account. The secret used
+// for signing payloads is returned only once.
+func (p *persistenceLayer) CreateWebhook(userID, accountID, webhookURL string, events []string) (WebhookResult, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return WebhookResult{}, fmt.Errorf("persistence: %q is not a valid webhook url", webhookURL)
+	}
+	if len(events) == 0 {
+		return WebhookResult{}, errors.New("persistence: webhooks need to subscribe to at least one event")
+	}
+	for _, event := range events {
+		var known bool
+		for _, e := range WebhookEvents {
+			if e == event {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return WebhookResult{}, fmt.Errorf("persistence: unknown event %q", event)
+		}
+	}
+	if _, err := p.dal.FindAccount(FindAccountQueryActiveByID(accountID)); err != nil {
+		return WebhookResult{}, fmt.Errorf("persistence: error looking up account %s: %w", accountID, err)
+	}
+
+	webhookID, err := uuid.NewV4()
+	if err != nil {
+		return WebhookResult{}, fmt.Errorf("persistence: error creating webhook id: %w", err)
+	}
+	secret, err := keys.GenerateRandomValueWith(webhookSecretLength, base64.RawURLEncoding)
+	if err != nil {
+		return WebhookResult{}, fmt.Errorf("persistence: error creating webhook secret: %w", err)
+	}
+	webhook := &Webhook{
+		WebhookID: webhookID.String(),
+		AccountID: accountID,
+		URL:       u.String(),
+		Secret:    secret,
+		Events:    events,
+		CreatedBy: userID,
+		Created:   time.Now(),
+	}
+	if err := p.dal.CreateWebhook(webhook); err != nil {
+		return WebhookResult{}, fmt.Errorf("persistence: error persisting webhook: %w", err)
+	}
+	result := webhook.export()
+	result.Secret = webhook.Secret
+	return result, nil
+}
+
+// ListWebhooks returns all webhooks of the given account.
+func (p *persistenceLayer) ListWebhooks(accountID string) ([]WebhookResult, error) {
+	webhooks, err := p.dal.FindWebhooks(FindWebhooksQueryByAccountID(accountID))
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up webhooks: %w", err)
+	}
+	result := []WebhookResult{}
+	for _, webhook := range webhooks {
+		result = append(result, webhook.export())
+	}
+	return result, nil
+}
+
+// LookupWebhooks returns all webhooks subscribed to the given event
+// including their secrets. In case no account id is given, webhooks of all
+// accounts are returned.
+func (p *persistenceLayer) LookupWebhooks(accountID, event string) ([]WebhookResult, error) {
+	var query interface{} = FindWebhooksQueryAllWebhooks{}
+	if accountID != "" {
+		query = FindWebhooksQueryByAccountID(accountID)
+	}
+	webhooks, err := p.dal.FindWebhooks(query)
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up webhooks: %w", err)
+	}
+	var result []WebhookResult
+	for _, webhook := range webhooks {
+		if !webhook.subscribes(event) {
+			continue
+		}
+		r := webhook.export()
+		r.Secret = webhook.Secret
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// DeleteWebhook deletes the given webhook of the account and its delivery
+// logs.
+func (p *persistenceLayer) DeleteWebhook(accountID, webhookID string) error {
+	if _, err := p.findWebhook(accountID, webhookID); err != nil {
+		return err
+	}
+	txn, err := p.dal.Transaction()
+	if err != nil {
+		return fmt.Errorf("persistence: error creating transaction: %w", err)
+	}
+	if err := txn.DeleteWebhookDeliveries(DeleteWebhookDeliveriesQueryByWebhookID(webhookID)); err != nil {
+		txn.Rollback()
+		return fmt.Errorf("persistence: error deleting webhook deliveries: %w", err)
+	}
+	if err := txn.DeleteWebhooks(DeleteWebhooksQueryByID{
+		WebhookID: webhookID,
+		AccountID: accountID,
+	}); err != nil {
+		txn.Rollback()
+		return fmt.Errorf("persistence: error deleting webhook: %w", err)
+	}
+	if err := txn.Commit(); err != nil {
+		return fmt.Errorf("persistence: error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// RecordWebhookDelivery persists the given delivery. Deliveries without an
+// id are created, all others are updated.
+func (p *persistenceLayer) RecordWebhookDelivery(delivery WebhookDeliveryResult) (WebhookDeliveryResult, error) {
+	record := &WebhookDelivery{
+		DeliveryID: delivery.DeliveryID,
+		WebhookID:  delivery.WebhookID,
+		Event:      delivery.Event,
+		Payload:    delivery.Payload,
+		Attempts:   delivery.Attempts,
+		StatusCode: delivery.StatusCode,
+		Error:      delivery.Error,
+		Delivered:  delivery.Delivered,
+		Created:    delivery.Created,
+		Updated:    time.Now(),
+	}
+	if record.DeliveryID != "" {
+		if err := p.dal.UpdateWebhookDelivery(record); err != nil {
+			return WebhookDeliveryResult{}, fmt.Errorf("persistence: error updating webhook delivery: %w", err)
+		}
+		return record.export(), nil
+	}
+
+	deliveryID, err := uuid.NewV4()
+	if err != nil {
+		return WebhookDeliveryResult{}, fmt.Errorf("persistence: error creating delivery id: %w", err)
+	}
+	record.DeliveryID = deliveryID.String()
+	record.Created = record.Updated
+	if err := p.dal.CreateWebhookDelivery(record); err != nil {
+		return WebhookDeliveryResult{}, fmt.Errorf("persistence: error persisting webhook delivery: %w", err)
+	}
+	return record.export(), nil
+}
+
+// ListWebhookDeliveries returns the latest deliveries of the given webhook
+// of the account, newest first.
+func (p *persistenceLayer) ListWebhookDeliveries(accountID, webhookID string, limit int) ([]WebhookDeliveryResult, error) {
+	if _, err := p.findWebhook(accountID, webhookID); err != nil {
+		return nil, err
+	}
+	deliveries, err := p.dal.FindWebhookDeliveries(FindWebhookDeliveriesQueryByWebhookID{
+		WebhookID: webhookID,
+		Limit:     limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("persistence: error looking up webhook deliveries: %w", err)
+	}
+	result := []WebhookDeliveryResult{}
+	for _, delivery := range deliveries {
+		result = append(result, delivery.export())
+	}
+	return result, nil
+}
+
+func (p *persistenceLayer) findWebhook(accountID, webhookID string) (Webhook, error) {
+	webhooks, err := p.dal.FindWebhooks(FindWebhooksQueryByAccountID(accountID))
+	if err != nil {
+		return Webhook{}, fmt.Errorf("persistence: error looking up webhooks: %w", err)
+	}
+	for _, webhook := range webhooks {
+		if webhook.WebhookID == webhookID {
+			return webhook, nil
+		}
+	}
+	return Webhook{}, ErrUnknownWebhook
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package persistence
+
+import (
+	"errors"
+	"testing"
+)
+
+type mockWebhooksDatabase struct {
+	DataAccessLayer
+	webhooks   []Webhook
+	deliveries []WebhookDelivery
+	updated    []WebhookDelivery
+	deleted    []interface{}
+}
+
+func (m *mockWebhooksDatabase) FindAccount(q interface{}) (Account, error) {
+	if string(q.(FindAccountQueryActiveByID)) != "account-a" {
+		return Account{}, errors.New("not found")
+	}
+	return Account{AccountID: "account-a"}, nil
+}
+
+func (m *mockWebhooksDatabase) CreateWebhook(w *Webhook) error {
+	m.webhooks = append(m.webhooks, *w)
+	return nil
+}
+
+func (m *mockWebhooksDatabase) FindWebhooks(q interface{}) ([]Webhook, error) {
+	var result []Webhook
+	for _, webhook := range m.webhooks {
+		if accountID, ok := q.(FindWebhooksQueryByAccountID); ok && webhook.AccountID != string(accountID) {
+			continue
+		}
+		result = append(result, webhook)
+	}
+	return result, nil
+}
+
+func (m *mockWebhooksDatabase) CreateWebhookDelivery(w *WebhookDelivery) error {
+	m.deliveries = append(m.deliveries, *w)
+	return nil
+}
+
+func (m *mockWebhooksDatabase) UpdateWebhookDelivery(w *WebhookDelivery) error {
+	m.updated = append(m.updated, *w)
+	return nil
+}
+
+func (m *mockWebhooksDatabase) FindWebhookDeliveries(q interface{}) ([]WebhookDelivery, error) {
+	return m.deliveries, nil
+}
+
+func (m *mockWebhooksDatabase) DeleteWebhooks(q interface{}) error {
+	m.deleted = append(m.deleted, q)
+	return nil
+}
+
+func (m *mockWebhooksDatabase) DeleteWebhookDeliveries(q interface{}) error {
+	m.deleted = append(m.deleted, q)
+	return nil
+}
+
+func (m *mockWebhooksDatabase) Transaction() (Transaction, error) {
+	return m, nil
+}
+
+func (m *mockWebhooksDatabase) Commit() error {
+	return nil
+}
+
+func (m *mockWebhooksDatabase) Rollback() error {
+	return nil
+}
+
+func TestPersistenceLayer_CreateWebhook(t *testing.T) {
+	tests := []struct {
+		name        string
+		accountID   string
+		url         string
+		events      []string
+		expectError bool
+	}{
+		{"ok", "account-a", "https://www.example.com/hook", []string{WebhookEventAccountUserAdded}, false},
+		{"relative url", "account-a", "/hook", []string{WebhookEventAccountUserAdded}, true},
+		{"bad scheme", "account-a", "ftp://www.example.com/hook", []string{WebhookEventAccountUserAdded}, true},
+		{"no events", "account-a", "https://www.example.com/hook", nil, true},
+		{"unknown event", "account-a", "https://www.example.com/hook", []string{"account.deleted"}, true},
+		{"unknown account", "account-z", "https://www.example.com/hook", []string{WebhookEventAccountUserAdded}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &persistenceLayer{dal: &mockWebhooksDatabase{}}
+			result, err := p.CreateWebhook("user-a", test.accountID, test.url, test.events)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if err == nil && (result.Secret == "" || result.WebhookID == "") {
+				t.Errorf("Unexpected result %v", result)
+			}
+		})
+	}
+}
+
+func TestPersistenceLayer_Webhooks(t *testing.T) {
+	db := &mockWebhooksDatabase{}
+	p := &persistenceLayer{dal: db}
+
+	created, err := p.CreateWebhook("user-a", "account-a", "https://www.example.com/hook", []string{WebhookEventAccountUserAdded})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	db.webhooks = append(db.webhooks, Webhook{WebhookID: "other", AccountID: "account-b", Events: []string{WebhookEventAccountUserAdded}})
+
+	listed, err := p.ListWebhooks("account-a")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(listed) != 1 || listed[0].Secret != "" {
+		t.Errorf("Unexpected webhooks %v", listed)
+	}
+
+	lookedUp, err := p.LookupWebhooks("", WebhookEventAccountUserAdded)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(lookedUp) != 2 || lookedUp[0].Secret != created.Secret {
+		t.Errorf("Unexpected webhooks %v", lookedUp)
+	}
+	lookedUp, _ = p.LookupWebhooks("account-a", WebhookEventRetentionPurge)
+	if len(lookedUp) != 0 {
+		t.Errorf("Unexpected webhooks %v", lookedUp)
+	}
+
+	delivery, err := p.RecordWebhookDelivery(WebhookDeliveryResult{WebhookID: created.WebhookID, Event: WebhookEventAccountUserAdded})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if delivery.DeliveryID == "" || len(db.deliveries) != 1 {
+		t.Errorf("Expected delivery to be created, got %v", delivery)
+	}
+	delivery.Attempts = 1
+	delivery.Delivered = true
+	if _, err := p.RecordWebhookDelivery(delivery); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(db.updated) != 1 || !db.updated[0].Delivered || !db.updated[0].Created.Equal(delivery.Created) {
+		t.Errorf("Expected delivery to be updated, got %v", db.updated)
+	}
+
+	if _, err := p.ListWebhookDeliveries("account-a", "other", 10); !errors.Is(err, ErrUnknownWebhook) {
+		t.Errorf("Expected unknown webhook error, got %v", err)
+	}
+	deliveries, err := p.ListWebhookDeliveries("account-a", created.WebhookID, 10)
+	if err != nil || len(deliveries) != 1 {
+		t.Errorf("Unexpected result %v %v", deliveries, err)
+	}
+
+	if err := p.DeleteWebhook("account-a", "other"); !errors.Is(err, ErrUnknownWebhook) {
+		t.Errorf("Expected unknown webhook error, got %v", err)
+	}
+	if err := p.DeleteWebhook("account-a", created.WebhookID); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(db.deleted) != 2 {
+		t.Errorf("Expected webhook and deliveries to be deleted, got %v", db.deleted)
+	}
+}
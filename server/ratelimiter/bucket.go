@@ -0,0 +1,96 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ratelimiter
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Bucket limits the number of operations per identifier using a token
+// bucket. Each identifier can perform up to limit operations at once,
+// tokens are refilled evenly over the given period.
+type Bucket struct {
+	limit  int
+	period time.Duration
+	cache  GetSetter
+	salt   []byte
+	lock   sync.Mutex
+}
+
+type bucketItem struct {
+	tokens  float64
+	updated time.Time
+}
+
+// BucketResult describes the state of the bucket of an identifier after
+// taking a token.
+type BucketResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the duration until the bucket has been refilled completely.
+	Reset time.Duration
+	// RetryAfter is the duration until the next token is available. It is
+	// only set when the operation is not allowed.
+	RetryAfter time.Duration
+}
+
+// NewBucket creates a Bucket allowing the given number of operations per
+// period. Passing a limit or period of zero disables limiting.
+func NewBucket(limit int, period time.Duration, cache GetSetter) *Bucket {
+	salt, err := randomBytes(16)
+	if err != nil {
+		panic("cannot initialize bucket")
+	}
+	return &Bucket{
+		limit:  limit,
+		period: period,
+		cache:  cache,
+		salt:   salt,
+	}
+}
+
+func (b *Bucket) hash(s string) string {
+	joined := append([]byte(s), b.salt...)
+	return fmt.Sprintf("%x", sha256.Sum256(joined))
+}
+
+// Take takes a token from the bucket of the given identifier.
+func (b *Bucket) Take(identifier string) BucketResult {
+	if b.limit <= 0 || b.period <= 0 {
+		return BucketResult{Allowed: true}
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	hashedIdentifier := b.hash(identifier)
+	item := bucketItem{tokens: float64(b.limit), updated: now}
+	if value, found := b.cache.Get(hashedIdentifier); found {
+		if cached, ok := value.(bucketItem); ok {
+			item = cached
+		}
+	}
+
+	// tokens per nanosecond
+	rate := float64(b.limit) / float64(b.period)
+	item.tokens = math.Min(float64(b.limit), item.tokens+float64(now.Sub(item.updated))*rate)
+	item.updated = now
+
+	result := BucketResult{Limit: b.limit}
+	if item.tokens >= 1 {
+		item.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((1 - item.tokens) / rate))
+	}
+	result.Remaining = int(math.Floor(item.tokens))
+	result.Reset = time.Duration(math.Ceil((float64(b.limit) - item.tokens) / rate))
+	b.cache.Set(hashedIdentifier, item, result.Reset)
+	return result
+}
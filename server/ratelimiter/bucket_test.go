@@ -0,0 +1,60 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	t.Run("limit", func(t *testing.T) {
+		b := NewBucket(3, time.Hour, &mockGetSetter{})
+		for i := 0; i < 3; i++ {
+			result := b.Take("a")
+			if !result.Allowed {
+				t.Fatalf("Unexpected result %v", result)
+			}
+			if result.Remaining != 2-i {
+				t.Errorf("Unexpected remaining tokens %d", result.Remaining)
+			}
+		}
+		result := b.Take("a")
+		if result.Allowed {
+			t.Errorf("Expected operation to be limited")
+		}
+		if result.Limit != 3 || result.Remaining != 0 {
+			t.Errorf("Unexpected result %v", result)
+		}
+		if result.RetryAfter <= 0 || result.RetryAfter > time.Minute*20 {
+			t.Errorf("Unexpected retry after %v", result.RetryAfter)
+		}
+		if result.Reset <= time.Minute*59 || result.Reset > time.Hour {
+			t.Errorf("Unexpected reset %v", result.Reset)
+		}
+		if result := b.Take("b"); !result.Allowed {
+			t.Errorf("Unexpected result for other identifier %v", result)
+		}
+	})
+	t.Run("refill", func(t *testing.T) {
+		b := NewBucket(2, time.Millisecond*20, &mockGetSetter{})
+		b.Take("a")
+		b.Take("a")
+		if result := b.Take("a"); result.Allowed {
+			t.Fatal("Expected operation to be limited")
+		}
+		time.Sleep(time.Millisecond * 15)
+		if result := b.Take("a"); !result.Allowed {
+			t.Errorf("Expected token to be refilled, got %v", result)
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		b := NewBucket(0, time.Hour, &mockGetSetter{})
+		for i := 0; i < 10; i++ {
+			if result := b.Take("a"); !result.Allowed {
+				t.Errorf("Unexpected result %v", result)
+			}
+		}
+	})
+}
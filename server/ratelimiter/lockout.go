@@ -0,0 +1,117 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ratelimiter
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrLockedOut is returned when an identifier has been locked out after too
+// many failed attempts.
+type ErrLockedOut struct {
+	Until time.Time
+}
+
+func (e ErrLockedOut) Error() string {
+	return fmt.Sprintf("ratelimiter: locked out until %s", e.Until.Format(time.RFC3339))
+}
+
+// Lockout counts failed attempts per identifier and locks out identifiers
+// that have failed too often within the configured duration.
+type Lockout struct {
+	attempts int
+	duration time.Duration
+	cache    GetSetter
+	salt     []byte
+	lock     sync.Mutex
+}
+
+type lockoutItem struct {
+	failures    int
+	lockedUntil time.Time
+}
+
+// NewLockout creates a Lockout that locks out identifiers for the given
+// duration after the given number of consecutive failures. Passing zero
+// attempts disables locking out.
+func NewLockout(attempts int, duration time.Duration, cache GetSetter) *Lockout {
+	salt, err := randomBytes(16)
+	if err != nil {
+		panic("cannot initialize lockout")
+	}
+	return &Lockout{
+		attempts: attempts,
+		duration: duration,
+		cache:    cache,
+		salt:     salt,
+	}
+}
+
+func (l *Lockout) hash(s string) string {
+	joined := append([]byte(s), l.salt...)
+	return fmt.Sprintf("%x", sha256.Sum256(joined))
+}
+
+func (l *Lockout) item(hashedIdentifier string) lockoutItem {
+	if value, found := l.cache.Get(hashedIdentifier); found {
+		if item, ok := value.(lockoutItem); ok {
+			return item
+		}
+	}
+	return lockoutItem{}
+}
+
+// Check returns ErrLockedOut in case the given identifier is currently
+// locked out.
+func (l *Lockout) Check(identifier string) error {
+	if l.attempts <= 0 {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	item := l.item(l.hash(identifier))
+	if time.Now().Before(item.lockedUntil) {
+		return ErrLockedOut{Until: item.lockedUntil}
+	}
+	return nil
+}
+
+// Fail records a failed attempt for the given identifier. In case the
+// failure causes the identifier to be locked out, ErrLockedOut is returned.
+// Failures are forgotten after the lockout duration has passed without any
+// further failure.
+func (l *Lockout) Fail(identifier string) error {
+	if l.attempts <= 0 {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	hashedIdentifier := l.hash(identifier)
+	item := l.item(hashedIdentifier)
+	item.failures++
+	if item.failures >= l.attempts {
+		item = lockoutItem{lockedUntil: time.Now().Add(l.duration)}
+		l.cache.Set(hashedIdentifier, item, l.duration)
+		return ErrLockedOut{Until: item.lockedUntil}
+	}
+	l.cache.Set(hashedIdentifier, item, l.duration)
+	return nil
+}
+
+// Reset forgets all failed attempts for the given identifier.
+func (l *Lockout) Reset(identifier string) {
+	if l.attempts <= 0 {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	hashedIdentifier := l.hash(identifier)
+	if item := l.item(hashedIdentifier); time.Now().Before(item.lockedUntil) {
+		return
+	}
+	l.cache.Set(hashedIdentifier, lockoutItem{}, l.duration)
+}
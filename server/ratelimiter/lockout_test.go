@@ -0,0 +1,68 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ratelimiter
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLockout(t *testing.T) {
+	t.Run("lock out", func(t *testing.T) {
+		l := NewLockout(3, time.Hour, &mockGetSetter{})
+		for i := 0; i < 2; i++ {
+			if err := l.Fail("a"); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+		}
+		if err := l.Check("a"); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		var locked ErrLockedOut
+		if err := l.Fail("a"); !errors.As(err, &locked) {
+			t.Fatalf("Expected lockout, got %v", err)
+		}
+		if err := l.Check("a"); err != locked {
+			t.Errorf("Expected %v, got %v", locked, err)
+		}
+		if err := l.Check("b"); err != nil {
+			t.Errorf("Unexpected error for other identifier %v", err)
+		}
+		// a successful attempt does not lift an active lockout
+		l.Reset("a")
+		if err := l.Check("a"); err == nil {
+			t.Error("Expected lockout to persist")
+		}
+	})
+	t.Run("reset", func(t *testing.T) {
+		l := NewLockout(2, time.Hour, &mockGetSetter{})
+		l.Fail("a")
+		l.Reset("a")
+		if err := l.Fail("a"); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+	})
+	t.Run("expiry", func(t *testing.T) {
+		l := NewLockout(1, time.Millisecond*10, &mockGetSetter{})
+		if err := l.Fail("a"); err == nil {
+			t.Fatal("Expected lockout")
+		}
+		time.Sleep(time.Millisecond * 20)
+		if err := l.Check("a"); err != nil {
+			t.Errorf("Expected lockout to be lifted, got %v", err)
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		l := NewLockout(0, time.Hour, &mockGetSetter{})
+		for i := 0; i < 10; i++ {
+			if err := l.Fail("a"); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+		}
+		if err := l.Check("a"); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+	})
+}
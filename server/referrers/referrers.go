@@ -0,0 +1,84 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package referrers contains the ruleset clients use for classifying the
+// referrers of pageviews. Referrers are part of the encrypted event payload,
+// so the server cannot classify them itself and serves the ruleset instead.
+package referrers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Categories referrers are classified into. Direct and internal traffic is
+// detected by clients without consulting the ruleset.
+const (
+	CategorySearch   = "search"
+	CategorySocial   = "social"
+	CategoryDirect   = "direct"
+	CategoryInternal = "internal"
+	CategoryOther    = "other"
+)
+
+// A Rule assigns referrers whose host matches the pattern to a named source
+// and a category. Patterns are regular expressions that are compatible with
+// both Go and JavaScript. Referrers of Android apps use the id of the app as
+// their host.
+type Rule struct {
+	Pattern  string `json:"pattern"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+}
+
+// Ruleset is the versioned list of rules that is served to clients. Rules
+// are evaluated in order, the first match wins.
+type Ruleset struct {
+	Version string `json:"version"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Default returns the ruleset maintained with this version of Offen. Its
+// version is derived from its rules, so clients can cache it.
+func Default() Ruleset {
+	b, _ := json.Marshal(rules)
+	sum := sha256.Sum256(b)
+	return Ruleset{
+		Version: hex.EncodeToString(sum[:8]),
+		Rules:   rules,
+	}
+}
+
+var rules = []Rule{
+	{`^(www\.)?google\.[a-z]{2,3}(\.[a-z]{2})?$`, "Google", CategorySearch},
+	{`^com\.google\.android\.googlequicksearchbox$`, "Google", CategorySearch},
+	{`^(www\.|cn\.)?bing\.com$`, "Bing", CategorySearch},
+	{`^(www\.|html\.)?duckduckgo\.com$`, "DuckDuckGo", CategorySearch},
+	{`^([a-z]+\.)?search\.yahoo\.com$`, "Yahoo", CategorySearch},
+	{`^(www\.|m\.)?baidu\.com$`, "Baidu", CategorySearch},
+	{`^(www\.)?yandex\.[a-z]{2,3}$`, "Yandex", CategorySearch},
+	{`^(www\.)?ecosia\.org$`, "Ecosia", CategorySearch},
+	{`^(www\.|lite\.)?qwant\.com$`, "Qwant", CategorySearch},
+	{`^(www\.)?startpage\.com$`, "Startpage", CategorySearch},
+	{`^search\.brave\.com$`, "Brave Search", CategorySearch},
+	{`^(l|lm|m|www)\.facebook\.com$`, "Facebook", CategorySocial},
+	{`^com\.facebook\.katana$`, "Facebook", CategorySocial},
+	{`^(l\.|www\.)?instagram\.com$`, "Instagram", CategorySocial},
+	{`^t\.co$`, "Twitter", CategorySocial},
+	{`^(mobile\.)?twitter\.com$`, "Twitter", CategorySocial},
+	{`^(www\.)?linkedin\.com$`, "LinkedIn", CategorySocial},
+	{`^lnkd\.in$`, "LinkedIn", CategorySocial},
+	{`^com\.linkedin\.`, "LinkedIn", CategorySocial},
+	{`^(old\.|www\.|out\.)?reddit\.com$`, "Reddit", CategorySocial},
+	{`^com\.laurencedawson\.reddit_sync`, "Reddit", CategorySocial},
+	{`^news\.ycombinator\.com$`, "Hacker News", CategorySocial},
+	{`^([a-z]+\.)?pinterest\.[a-z]{2,3}$`, "Pinterest", CategorySocial},
+	{`^(www\.|m\.)?youtube\.com$`, "YouTube", CategorySocial},
+	{`^(www\.)?xing\.com$`, "XING", CategorySocial},
+	{`^(m\.)?vk\.com$`, "VK", CategorySocial},
+	{`^web\.telegram\.org$`, "Telegram", CategorySocial},
+	{`^org\.telegram\.`, "Telegram", CategorySocial},
+	{`^com\.slack$`, "Slack", CategorySocial},
+	{`^(web\.)?whatsapp\.com$`, "WhatsApp", CategorySocial},
+}
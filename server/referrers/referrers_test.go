@@ -0,0 +1,63 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package referrers
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestDefault(t *testing.T) {
+	ruleset := Default()
+	if ruleset.Version == "" || ruleset.Version != Default().Version {
+		t.Errorf("Expected stable version, got %s", ruleset.Version)
+	}
+	seen := map[string]bool{}
+	for _, rule := range ruleset.Rules {
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			t.Errorf("Unexpected error compiling pattern %s: %v", rule.Pattern, err)
+		}
+		if rule.Category != CategorySearch && rule.Category != CategorySocial {
+			t.Errorf("Unexpected category %s for pattern %s", rule.Category, rule.Pattern)
+		}
+		if seen[rule.Pattern] {
+			t.Errorf("Duplicate pattern %s", rule.Pattern)
+		}
+		seen[rule.Pattern] = true
+	}
+}
+
+func TestDefault_Matches(t *testing.T) {
+	tests := []struct {
+		host         string
+		expectedName string
+	}{
+		{"www.google.com", "Google"},
+		{"www.google.co.uk", "Google"},
+		{"google.de", "Google"},
+		{"duckduckgo.com", "DuckDuckGo"},
+		{"t.co", "Twitter"},
+		{"l.facebook.com", "Facebook"},
+		{"com.slack", "Slack"},
+		{"org.telegram.messenger", "Telegram"},
+		{"news.ycombinator.com", "Hacker News"},
+		{"www.google.com.evil.example", ""},
+		{"notgoogle.com", ""},
+		{"www.example.com", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			var name string
+			for _, rule := range Default().Rules {
+				if regexp.MustCompile(rule.Pattern).MatchString(test.host) {
+					name = rule.Name
+					break
+				}
+			}
+			if name != test.expectedName {
+				t.Errorf("Expected %q, got %q", test.expectedName, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package reports emails periodic summaries of accounts to the account users
+// that have subscribed to them.
+package reports
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"time"
+
+	"github.com/offen/offen/server/mailer"
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/webhooks"
+	"github.com/oklog/ulid"
+	"github.com/sirupsen/logrus"
+)
+
+// Store is used for looking up subscriptions and the events of accounts.
+type Store interface {
+	LookupReportSubscriptions(frequency string) ([]persistence.ReportSubscriptionResult, error)
+	GetAccount(accountID string, events bool, eventsSince string) (persistence.AccountResult, error)
+}
+
+// Report summarizes the events an account has received in the given period.
+// Usage data is end-to-end encrypted, so it only contains what can be derived
+// from event metadata.
+type Report struct {
+	AccountName     string
+	From            time.Time
+	Until           time.Time
+	Events          int
+	Users           int
+	AnonymousEvents int
+}
+
+// Sender renders reports and sends them using the given mailer.
+type Sender struct {
+	store  Store
+	mailer mailer.Mailer
+	emails *template.Template
+	from   string
+	logger *logrus.Logger
+}
+
+// Option is used for configuring a Sender.
+type Option func(*Sender)
+
+// WithLogger sets the logger used for reporting errors.
+func WithLogger(logger *logrus.Logger) Option {
+	return func(s *Sender) {
+		s.logger = logger
+	}
+}
+
+// New creates a Sender that sends reports from the given address. emails
+// is expected to define the subject_report and body_report templates.
+func New(store Store, m mailer.Mailer, emails *template.Template, from string, opts ...Option) *Sender {
+	s := &Sender{
+		store:  store,
+		mailer: m,
+		emails: emails,
+		from:   from,
+		logger: logrus.New(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// StartOfMonth returns the beginning of the month the given time is in,
+// in UTC.
+func StartOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Period returns the period the report of the given frequency covers when
+// sent at the given time. Weekly reports cover the previous week, monthly
+// reports the previous month.
+func Period(frequency string, now time.Time) (time.Time, time.Time, error) {
+	switch frequency {
+	case persistence.ReportFrequencyWeekly:
+		until := webhooks.StartOfWeek(now)
+		return until.AddDate(0, 0, -7), until, nil
+	case persistence.ReportFrequencyMonthly:
+		until := StartOfMonth(now)
+		return until.AddDate(0, -1, 0), until, nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("reports: unknown frequency %q", frequency)
+	}
+}
+
+// Send sends the reports of the given frequency to all subscribers. Reports
+// that cannot be sent are logged and skipped, so a single bad address does
+// not keep other subscribers from receiving theirs. It returns the number of
+// reports sent. Calling Send on a nil Sender is a no-op.
+func (s *Sender) Send(frequency string, now time.Time) (int, error) {
+	if s == nil {
+		return 0, nil
+	}
+	from, until, err := Period(frequency, now)
+	if err != nil {
+		return 0, err
+	}
+	subscriptions, err := s.store.LookupReportSubscriptions(frequency)
+	if err != nil {
+		return 0, fmt.Errorf("reports: error looking up subscriptions: %w", err)
+	}
+	var sent int
+	reports := map[string]Report{}
+	for _, subscription := range subscriptions {
+		report, ok := reports[subscription.AccountID]
+		if !ok {
+			report, err = s.report(subscription.AccountID, from, until)
+			if err != nil {
+				s.logger.WithError(err).WithField("accountId", subscription.AccountID).Error("Error creating report")
+				continue
+			}
+			reports[subscription.AccountID] = report
+		}
+		if err := s.send(subscription, report); err != nil {
+			s.logger.WithError(err).WithField("subscriptionId", subscription.ReportSubscriptionID).Error("Error sending report")
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+func (s *Sender) send(subscription persistence.ReportSubscriptionResult, report Report) error {
+	data := map[string]interface{}{
+		"frequency":       subscription.Frequency,
+		"accountName":     report.AccountName,
+		"from":            report.From.Format("2006-01-02"),
+		"until":           report.Until.AddDate(0, 0, -1).Format("2006-01-02"),
+		"events":          report.Events,
+		"users":           report.Users,
+		"anonymousEvents": report.AnonymousEvents,
+	}
+	subject, body := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	if err := s.emails.ExecuteTemplate(subject, "subject_report", data); err != nil {
+		return fmt.Errorf("reports: error rendering email subject: %w", err)
+	}
+	if err := s.emails.ExecuteTemplate(body, "body_report", data); err != nil {
+		return fmt.Errorf("reports: error rendering email body: %w", err)
+	}
+	if err := s.mailer.Send(s.from, subscription.EmailAddress, subject.String(), body.String()); err != nil {
+		return fmt.Errorf("reports: error sending email: %w", err)
+	}
+	return nil
+}
+
+func (s *Sender) report(accountID string, from, until time.Time) (Report, error) {
+	since, err := persistence.EventIDAt(from)
+	if err != nil {
+		return Report{}, fmt.Errorf("reports: error creating event id: %w", err)
+	}
+	account, err := s.store.GetAccount(accountID, true, since)
+	if err != nil {
+		return Report{}, fmt.Errorf("reports: error looking up account %s: %w", accountID, err)
+	}
+	result := Report{AccountName: account.Name, From: from, Until: until}
+	if account.Events == nil {
+		return result, nil
+	}
+	users := map[string]bool{}
+	for _, event := range (*account.Events)[accountID] {
+		id, err := ulid.Parse(event.EventID)
+		if err != nil || !ulid.Time(id.Time()).Before(until) {
+			continue
+		}
+		result.Events++
+		if event.SecretID == nil {
+			result.AnonymousEvents++
+			continue
+		}
+		users[*event.SecretID] = true
+	}
+	result.Users = len(users)
+	return result, nil
+}
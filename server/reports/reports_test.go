@@ -0,0 +1,158 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package reports
+
+import (
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+	"github.com/sirupsen/logrus"
+)
+
+func TestPeriod(t *testing.T) {
+	tests := []struct {
+		name          string
+		frequency     string
+		now           time.Time
+		expectedFrom  time.Time
+		expectedUntil time.Time
+		expectError   bool
+	}{
+		{"weekly", persistence.ReportFrequencyWeekly, time.Date(2020, 6, 3, 13, 0, 0, 0, time.UTC), time.Date(2020, 5, 25, 0, 0, 0, 0, time.UTC), time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), false},
+		{"monthly", persistence.ReportFrequencyMonthly, time.Date(2020, 3, 1, 1, 0, 0, 0, time.UTC), time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), false},
+		{"monthly new year", persistence.ReportFrequencyMonthly, time.Date(2020, 1, 20, 1, 0, 0, 0, time.UTC), time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"unknown", "daily", time.Now(), time.Time{}, time.Time{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			from, until, err := Period(test.frequency, test.now)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if !from.Equal(test.expectedFrom) || !until.Equal(test.expectedUntil) {
+				t.Errorf("Unexpected period %v - %v", from, until)
+			}
+		})
+	}
+}
+
+type mockStore struct {
+	subscriptions []persistence.ReportSubscriptionResult
+	events        []persistence.EventResult
+}
+
+func (m *mockStore) LookupReportSubscriptions(frequency string) ([]persistence.ReportSubscriptionResult, error) {
+	return m.subscriptions, nil
+}
+
+func (m *mockStore) GetAccount(accountID string, events bool, eventsSince string) (persistence.AccountResult, error) {
+	if accountID != "account-a" {
+		return persistence.AccountResult{}, errors.New("not found")
+	}
+	var matching []persistence.EventResult
+	for _, event := range m.events {
+		if event.EventID >= eventsSince {
+			matching = append(matching, event)
+		}
+	}
+	return persistence.AccountResult{
+		AccountID: accountID,
+		Name:      "Offen",
+		Events:    &persistence.EventsByAccountID{accountID: matching},
+	}, nil
+}
+
+type mockMailer struct {
+	sent []string
+	err  error
+}
+
+func (m *mockMailer) Send(from, to, subject, body string) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, strings.Join([]string{from, to, subject, body}, "|"))
+	return nil
+}
+
+func mustEventID(t time.Time) string {
+	id, err := persistence.EventIDAt(t)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func TestSender_Send(t *testing.T) {
+	emails := template.Must(template.New("emails").Parse(
+		`{{ define "subject_report" }}{{ .frequency }} report{{ end }}` +
+			`{{ define "body_report" }}{{ .accountName }} {{ .from }} {{ .until }} {{ .users }} {{ .events }} {{ .anonymousEvents }}{{ end }}`,
+	))
+	secretA, secretB := "secret-a", "secret-b"
+	store := &mockStore{
+		subscriptions: []persistence.ReportSubscriptionResult{
+			{ReportSubscriptionID: "subscription-a", AccountID: "account-a", EmailAddress: "develop@offen.dev", Frequency: persistence.ReportFrequencyWeekly},
+			{ReportSubscriptionID: "subscription-b", AccountID: "account-a", EmailAddress: "other@offen.dev", Frequency: persistence.ReportFrequencyWeekly},
+			{ReportSubscriptionID: "subscription-c", AccountID: "account-z", EmailAddress: "develop@offen.dev", Frequency: persistence.ReportFrequencyWeekly},
+		},
+		events: []persistence.EventResult{
+			// events of the week before are not included
+			{EventID: mustEventID(time.Date(2020, 5, 24, 12, 0, 0, 0, time.UTC)), SecretID: &secretA},
+			{EventID: mustEventID(time.Date(2020, 5, 25, 12, 0, 0, 0, time.UTC)), SecretID: &secretA},
+			{EventID: mustEventID(time.Date(2020, 5, 26, 12, 0, 0, 0, time.UTC)), SecretID: &secretA},
+			{EventID: mustEventID(time.Date(2020, 5, 27, 12, 0, 0, 0, time.UTC)), SecretID: &secretB},
+			{EventID: mustEventID(time.Date(2020, 5, 28, 12, 0, 0, 0, time.UTC))},
+			// events of the current week are not included
+			{EventID: mustEventID(time.Date(2020, 6, 1, 0, 30, 0, 0, time.UTC)), SecretID: &secretB},
+		},
+	}
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	t.Run("ok", func(t *testing.T) {
+		m := &mockMailer{}
+		s := New(store, m, emails, "reports@offen.dev", WithLogger(logger))
+		sent, err := s.Send(persistence.ReportFrequencyWeekly, time.Date(2020, 6, 1, 1, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if sent != 2 {
+			t.Errorf("Expected 2 reports to be sent, got %d", sent)
+		}
+		expected := []string{
+			"reports@offen.dev|develop@offen.dev|weekly report|Offen 2020-05-25 2020-05-31 2 4 1",
+			"reports@offen.dev|other@offen.dev|weekly report|Offen 2020-05-25 2020-05-31 2 4 1",
+		}
+		if strings.Join(m.sent, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Unexpected emails %v", m.sent)
+		}
+	})
+	t.Run("mailer error", func(t *testing.T) {
+		s := New(store, &mockMailer{err: errors.New("did not work")}, emails, "reports@offen.dev", WithLogger(logger))
+		sent, err := s.Send(persistence.ReportFrequencyWeekly, time.Date(2020, 6, 1, 1, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if sent != 0 {
+			t.Errorf("Expected no reports to be sent, got %d", sent)
+		}
+	})
+	t.Run("bad frequency", func(t *testing.T) {
+		s := New(store, &mockMailer{}, emails, "reports@offen.dev", WithLogger(logger))
+		if _, err := s.Send("daily", time.Now()); err == nil {
+			t.Error("Expected error, got nil")
+		}
+	})
+	t.Run("nil sender", func(t *testing.T) {
+		var s *Sender
+		if sent, err := s.Send(persistence.ReportFrequencyWeekly, time.Now()); sent != 0 || err != nil {
+			t.Errorf("Unexpected result %v %v", sent, err)
+		}
+	})
+}
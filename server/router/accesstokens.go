@@ -0,0 +1,97 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+func (rt *router) getAccessTokens(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	tokens, err := rt.db.ListAccessTokens(accountUser.AccountUserID)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error looking up access tokens: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{"accessTokens": tokens})
+}
+
+type createAccessTokenRequest struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	AccountIDs []string   `json:"accountIds"`
+	Expires    *time.Time `json:"expires"`
+}
+
+func (rt *router) postAccessToken(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	var req createAccessTokenRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	result, err := rt.db.CreateAccessToken(accountUser.AccountUserID, req.Name, req.Scopes, req.AccountIDs, req.Expires)
+	if err != nil {
+		if errors.Is(err, persistence.ErrPermissionDenied) {
+			newJSONError(
+				errors.New("router: access tokens can only be granted access to your own accounts"),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error creating access token: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusCreated, result)
+}
+
+func (rt *router) deleteAccessToken(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	if err := rt.db.RevokeAccessToken(accountUser.AccountUserID, c.Param("tokenID")); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error revoking access token: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
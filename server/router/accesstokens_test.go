@@ -0,0 +1,78 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockAccessTokensDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockAccessTokensDatabase) CreateAccessToken(userID, name string, scopes, accountIDs []string, expires *time.Time) (persistence.AccessTokenResult, error) {
+	return persistence.AccessTokenResult{TokenID: "token-a", Token: "token-a.secret"}, m.err
+}
+
+func TestRouter_postAccessToken(t *testing.T) {
+	tests := []struct {
+		name           string
+		db             mockAccessTokensDatabase
+		body           io.Reader
+		expectedStatus int
+	}{
+		{
+			"bad payload",
+			mockAccessTokensDatabase{},
+			strings.NewReader("891ä##"),
+			http.StatusBadRequest,
+		},
+		{
+			"other account",
+			mockAccessTokensDatabase{err: persistence.ErrPermissionDenied},
+			strings.NewReader(`{"name":"script","scopes":["read"],"accountIds":["account-z"]}`),
+			http.StatusForbidden,
+		},
+		{
+			"database error",
+			mockAccessTokensDatabase{err: errors.New("did not work")},
+			strings.NewReader(`{"name":"script","scopes":["read"]}`),
+			http.StatusBadRequest,
+		},
+		{
+			"ok",
+			mockAccessTokensDatabase{},
+			strings.NewReader(`{"name":"script","scopes":["read"],"expires":"2030-01-01T00:00:00Z"}`),
+			http.StatusCreated,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: &test.db}
+			m := gin.New()
+			m.POST("/", func(c *gin.Context) {
+				c.Set(contextKeyAuth, persistence.LoginResult{AccountUserID: "user-a"})
+			}, rt.postAccessToken)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", test.body))
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if w.Code == http.StatusCreated && !strings.Contains(w.Body.String(), `"token":"token-a.secret"`) {
+				t.Errorf("Expected token in response, got %v", w.Body.String())
+			}
+		})
+	}
+}
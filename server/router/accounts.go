@@ -39,12 +39,12 @@ func (rt *router) getAccount(c *gin.Context) {
 		return
 	}
 
-	result, err := rt.db.GetAccount(accountID, true, c.Query("since"))
+	result, err := rt.tracedDB(c.Request.Context()).GetAccount(accountID, true, c.Query("since"))
 	if err != nil {
 		var errUnknown persistence.ErrUnknownAccount
 		if errors.As(err, &errUnknown) {
 			newJSONError(
-				fmt.Errorf("router: account %s not found", accountID),
+				fmt.Errorf("router: account %s not found: %w", accountID, err),
 				http.StatusNotFound,
 			).Pipe(c)
 			return
@@ -78,7 +78,7 @@ func (rt *router) deleteAccount(c *gin.Context) {
 		return
 	}
 
-	if ok := accountUser.CanAccessAccount(accountID) && accountUser.IsSuperAdmin(); !ok {
+	if ok := accountUser.CanManageAccount(accountID); !ok {
 		newJSONError(
 			fmt.Errorf("router: account user does not have permissions to delete account %s", accountID),
 			http.StatusForbidden,
@@ -91,7 +91,7 @@ func (rt *router) deleteAccount(c *gin.Context) {
 		var errUnknown persistence.ErrUnknownAccount
 		if errors.As(err, &errUnknown) {
 			newJSONError(
-				fmt.Errorf("router: account %s not found", accountID),
+				fmt.Errorf("router: account %s not found: %w", accountID, err),
 				http.StatusNotFound,
 			).Pipe(c)
 			return
@@ -164,12 +164,256 @@ func (rt *router) postAccount(c *gin.Context) {
 		return
 	}
 
-	if err := rt.db.CreateAccount(rt.sanitizer.Sanitize(req.AccountName), req.EmailAddress, req.Password); err != nil {
+	result, err := rt.db.CreateAccount(rt.sanitizer.Sanitize(req.AccountName), req.EmailAddress, req.Password)
+	if err != nil {
 		newJSONError(
 			fmt.Errorf("router: error creating account %s: %w", req.AccountName, err),
 			http.StatusInternalServerError,
 		).Pipe(c)
 		return
 	}
-	c.JSON(http.StatusCreated, nil)
+	if rt.logger != nil {
+		rt.logger.
+			WithField("audit", "accounts").
+			WithField("accountId", result.AccountID).
+			WithField("by", accountUser.AccountUserID).
+			Info("Created account")
+	}
+	c.JSON(http.StatusCreated, result)
+}
+
+type accountSummary struct {
+	AccountID   string                      `json:"accountId"`
+	AccountName string                      `json:"accountName"`
+	Role        persistence.AccountUserRole `json:"role"`
+	Created     time.Time                   `json:"created"`
+}
+
+type accountsResponse struct {
+	Accounts []accountSummary `json:"accounts"`
+}
+
+// getAccounts lists all accounts the requester is allowed to access.
+func (rt *router) getAccounts(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: could not find account user object in request context"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+	result := accountsResponse{Accounts: []accountSummary{}}
+	for _, account := range accountUser.Accounts {
+		result.Accounts = append(result.Accounts, accountSummary{
+			AccountID:   account.AccountID,
+			AccountName: account.AccountName,
+			Role:        account.Role,
+			Created:     account.Created,
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+type updateAccountRequest struct {
+	AccountName string `json:"accountName"`
+}
+
+// patchAccount renames the given account.
+func (rt *router) patchAccount(c *gin.Context) {
+	accountID := c.Param("accountID")
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: could not find account user object in request context"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+
+	if ok := accountUser.CanManageAccount(accountID); !ok {
+		newJSONError(
+			fmt.Errorf("router: account user does not have permissions to update account %s", accountID),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	var req updateAccountRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	result, err := rt.db.RenameAccount(accountID, rt.sanitizer.Sanitize(req.AccountName))
+	if err != nil {
+		var errUnknown persistence.ErrUnknownAccount
+		if errors.As(err, &errUnknown) {
+			newJSONError(
+				fmt.Errorf("router: account %s not found: %w", accountID, err),
+				http.StatusNotFound,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error renaming account %s: %w", accountID, err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if rt.logger != nil {
+		rt.logger.
+			WithField("audit", "accounts").
+			WithField("accountId", accountID).
+			WithField("by", accountUser.AccountUserID).
+			Info("Renamed account")
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+type rotateAccountKeysRequest struct {
+	EmailAddress string `json:"emailAddress"`
+	Password     string `json:"password"`
+}
+
+func (rt *router) postRotateAccountKeys(c *gin.Context) {
+	accountID := c.Param("accountID")
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: could not find account user object in request context"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+
+	if ok := accountUser.CanManageAccount(accountID); !ok {
+		newJSONError(
+			fmt.Errorf("router: account user does not have permissions to rotate keys of account %s", accountID),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	var req rotateAccountKeysRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if l := <-rt.getLimiter().LinearThrottle(time.Second*5, fmt.Sprintf("postRotateAccountKeys-%s", accountID)); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error rate limiting request: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
+	accountInRequest, err := rt.db.Login(req.EmailAddress, req.Password)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error validating given credentials: %w", err),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+	// the given credentials might be valid, but belong to a different user
+	// than the one who is calling this
+	if accountInRequest.AccountUserID != accountUser.AccountUserID {
+		newJSONError(
+			fmt.Errorf("router: given credentials belong to user other than requester with id %s", accountUser.AccountUserID),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if err := rt.db.RotateAccountKeys(accountID, req.EmailAddress, req.Password); err != nil {
+		var errUnknown persistence.ErrUnknownAccount
+		if errors.As(err, &errUnknown) {
+			newJSONError(
+				fmt.Errorf("router: account %s not found: %w", accountID, err),
+				http.StatusNotFound,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error rotating keys of account %s: %w", accountID, err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type allowedOriginsRequest struct {
+	Origins []string `json:"origins"`
+}
+
+type allowedOriginsResponse struct {
+	Origins []string `json:"origins"`
+}
+
+// postAllowedOrigins replaces the list of origins other than the instance's
+// own that are allowed to submit events for the given account.
+func (rt *router) postAllowedOrigins(c *gin.Context) {
+	accountID := c.Param("accountID")
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: could not find account user object in request context"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+
+	if ok := accountUser.CanManageAccount(accountID); !ok {
+		newJSONError(
+			fmt.Errorf("router: account user does not have permissions to change allowed origins of account %s", accountID),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	var req allowedOriginsRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	origins, err := rt.db.SetAllowedOrigins(accountID, req.Origins)
+	if err != nil {
+		var errUnknown persistence.ErrUnknownAccount
+		if errors.As(err, &errUnknown) {
+			newJSONError(
+				fmt.Errorf("router: account %s not found: %w", accountID, err),
+				http.StatusNotFound,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error setting allowed origins of account: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if rt.logger != nil {
+		rt.logger.
+			WithField("audit", "allowed_origins").
+			WithField("accountId", accountID).
+			WithField("origins", origins).
+			WithField("by", accountUser.AccountUserID).
+			Info("Changed allowed origins of account")
+	}
+	c.JSON(http.StatusOK, allowedOriginsResponse{Origins: origins})
 }
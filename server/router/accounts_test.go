@@ -173,8 +173,8 @@ func (m *mockPostAccountDatabase) Login(string, string) (persistence.LoginResult
 	return m.loginResult, m.loginErr
 }
 
-func (m *mockPostAccountDatabase) CreateAccount(string, string, string) error {
-	return m.createAccountErr
+func (m *mockPostAccountDatabase) CreateAccount(name, emailAddress, password string) (persistence.AccountResult, error) {
+	return persistence.AccountResult{AccountID: "account-z", Name: name}, m.createAccountErr
 }
 
 func TestRouter_postAccount(t *testing.T) {
@@ -308,3 +308,332 @@ func TestRouter_postAccount(t *testing.T) {
 		})
 	}
 }
+
+func TestRouter_getAccounts(t *testing.T) {
+	tests := []struct {
+		name               string
+		userContext        interface{}
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			"bad user context",
+			40,
+			http.StatusUnauthorized,
+			"",
+		},
+		{
+			"no accounts",
+			persistence.LoginResult{AccountUserID: "user-a"},
+			http.StatusOK,
+			`{"accounts":[]}`,
+		},
+		{
+			"ok",
+			persistence.LoginResult{
+				AccountUserID: "user-a",
+				Accounts: []persistence.LoginAccountResult{
+					{AccountID: "account-a", AccountName: "a", Role: persistence.AccountUserRoleAdmin, KeyEncryptionKey: "secret"},
+				},
+			},
+			http.StatusOK,
+			`{"accounts":[{"accountId":"account-a","accountName":"a","role":1,"created":"0001-01-01T00:00:00Z"}]}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{}
+			m := gin.New()
+			m.GET("/", func(c *gin.Context) {
+				c.Set(contextKeyAuth, test.userContext)
+			}, rt.getAccounts)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if test.expectedBody != "" && strings.TrimSpace(w.Body.String()) != test.expectedBody {
+				t.Errorf("Unexpected body %v", w.Body.String())
+			}
+		})
+	}
+}
+
+type mockPatchAccountDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockPatchAccountDatabase) RenameAccount(accountID, name string) (persistence.AccountResult, error) {
+	return persistence.AccountResult{AccountID: accountID, Name: name}, m.err
+}
+
+func TestRouter_patchAccount(t *testing.T) {
+	accountUser := persistence.LoginResult{
+		AccountUserID: "user-a",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a", Role: persistence.AccountUserRoleAdmin},
+			{AccountID: "account-b", Role: persistence.AccountUserRoleViewer},
+		},
+	}
+	tests := []struct {
+		name               string
+		db                 *mockPatchAccountDatabase
+		accountID          string
+		body               string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{"ok", &mockPatchAccountDatabase{}, "account-a", `{"accountName":"<b>new</b>"}`, http.StatusOK, `"name":"new"`},
+		{"viewer", &mockPatchAccountDatabase{}, "account-b", `{"accountName":"new"}`, http.StatusForbidden, ""},
+		{"other account", &mockPatchAccountDatabase{}, "account-z", `{"accountName":"new"}`, http.StatusForbidden, ""},
+		{"bad payload", &mockPatchAccountDatabase{}, "account-a", `{"accountName":`, http.StatusBadRequest, ""},
+		{"unknown account", &mockPatchAccountDatabase{err: persistence.ErrUnknownAccount("did not work")}, "account-a", `{"accountName":"new"}`, http.StatusNotFound, ""},
+		{"invalid name", &mockPatchAccountDatabase{err: errors.New("did not work")}, "account-a", `{"accountName":""}`, http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{
+				db:        test.db,
+				sanitizer: bluemonday.StrictPolicy(),
+			}
+			m := gin.New()
+			m.PATCH("/:accountID", func(c *gin.Context) {
+				c.Set(contextKeyAuth, accountUser)
+			}, rt.patchAccount)
+
+			r := httptest.NewRequest(http.MethodPatch, "/"+test.accountID, strings.NewReader(test.body))
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if !strings.Contains(w.Body.String(), test.expectedBody) {
+				t.Errorf("Unexpected body %v", w.Body.String())
+			}
+		})
+	}
+}
+
+type mockPostRotateAccountKeysDatabase struct {
+	persistence.Service
+	loginResult persistence.LoginResult
+	loginErr    error
+	rotateErr   error
+}
+
+func (m *mockPostRotateAccountKeysDatabase) Login(string, string) (persistence.LoginResult, error) {
+	return m.loginResult, m.loginErr
+}
+
+func (m *mockPostRotateAccountKeysDatabase) RotateAccountKeys(string, string, string) error {
+	return m.rotateErr
+}
+
+func TestRouter_postRotateAccountKeys(t *testing.T) {
+	admin := persistence.LoginResult{
+		AccountUserID: "user-a",
+		AdminLevel:    persistence.AccountUserAdminLevelSuperAdmin,
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a"},
+		},
+	}
+	tests := []struct {
+		name               string
+		db                 mockPostRotateAccountKeysDatabase
+		userContext        interface{}
+		body               io.Reader
+		expectedStatusCode int
+	}{
+		{
+			"bad user context",
+			mockPostRotateAccountKeysDatabase{},
+			12,
+			strings.NewReader(`{"emailAddress":"hioffen@posteo.de","password":"pass"}`),
+			http.StatusUnauthorized,
+		},
+		{
+			"missing permissions",
+			mockPostRotateAccountKeysDatabase{},
+			persistence.LoginResult{
+				AccountUserID: "user-a",
+				Accounts: []persistence.LoginAccountResult{
+					{AccountID: "account-a"},
+				},
+			},
+			strings.NewReader(`{"emailAddress":"hioffen@posteo.de","password":"pass"}`),
+			http.StatusForbidden,
+		},
+		{
+			"bad payload",
+			mockPostRotateAccountKeysDatabase{},
+			admin,
+			strings.NewReader(`{{`),
+			http.StatusBadRequest,
+		},
+		{
+			"bad credentials",
+			mockPostRotateAccountKeysDatabase{loginErr: errors.New("did not work")},
+			admin,
+			strings.NewReader(`{"emailAddress":"hioffen@posteo.de","password":"pass"}`),
+			http.StatusUnauthorized,
+		},
+		{
+			"account user mismatch",
+			mockPostRotateAccountKeysDatabase{loginResult: persistence.LoginResult{AccountUserID: "user-b"}},
+			admin,
+			strings.NewReader(`{"emailAddress":"hioffen@posteo.de","password":"pass"}`),
+			http.StatusBadRequest,
+		},
+		{
+			"unknown account",
+			mockPostRotateAccountKeysDatabase{loginResult: admin, rotateErr: persistence.ErrUnknownAccount("did not work")},
+			admin,
+			strings.NewReader(`{"emailAddress":"hioffen@posteo.de","password":"pass"}`),
+			http.StatusNotFound,
+		},
+		{
+			"database error",
+			mockPostRotateAccountKeysDatabase{loginResult: admin, rotateErr: errors.New("did not work")},
+			admin,
+			strings.NewReader(`{"emailAddress":"hioffen@posteo.de","password":"pass"}`),
+			http.StatusInternalServerError,
+		},
+		{
+			"ok",
+			mockPostRotateAccountKeysDatabase{loginResult: admin},
+			admin,
+			strings.NewReader(`{"emailAddress":"hioffen@posteo.de","password":"pass"}`),
+			http.StatusNoContent,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{
+				db: &test.db,
+			}
+
+			m := gin.New()
+			m.POST("/:accountID", func(c *gin.Context) {
+				c.Set(contextKeyAuth, test.userContext)
+			}, rt.postRotateAccountKeys)
+
+			r := httptest.NewRequest(http.MethodPost, "/account-a", test.body)
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+		})
+	}
+}
+
+type mockPostAllowedOriginsDatabase struct {
+	persistence.Service
+	result []string
+	err    error
+}
+
+func (m *mockPostAllowedOriginsDatabase) SetAllowedOrigins(string, []string) ([]string, error) {
+	return m.result, m.err
+}
+
+func TestRouter_postAllowedOrigins(t *testing.T) {
+	admin := persistence.LoginResult{
+		AccountUserID: "user-a",
+		AdminLevel:    persistence.AccountUserAdminLevelSuperAdmin,
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a"},
+		},
+	}
+	tests := []struct {
+		name               string
+		db                 mockPostAllowedOriginsDatabase
+		userContext        interface{}
+		body               io.Reader
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			"bad user context",
+			mockPostAllowedOriginsDatabase{},
+			12,
+			strings.NewReader(`{"origins":["https://www.offen.dev"]}`),
+			http.StatusUnauthorized,
+			"",
+		},
+		{
+			"missing permissions",
+			mockPostAllowedOriginsDatabase{},
+			persistence.LoginResult{
+				AccountUserID: "user-a",
+				Accounts: []persistence.LoginAccountResult{
+					{AccountID: "account-a"},
+				},
+			},
+			strings.NewReader(`{"origins":["https://www.offen.dev"]}`),
+			http.StatusForbidden,
+			"",
+		},
+		{
+			"bad payload",
+			mockPostAllowedOriginsDatabase{},
+			admin,
+			strings.NewReader(`{{`),
+			http.StatusBadRequest,
+			"",
+		},
+		{
+			"unknown account",
+			mockPostAllowedOriginsDatabase{err: persistence.ErrUnknownAccount("did not work")},
+			admin,
+			strings.NewReader(`{"origins":["https://www.offen.dev"]}`),
+			http.StatusNotFound,
+			"",
+		},
+		{
+			"invalid origin",
+			mockPostAllowedOriginsDatabase{err: errors.New("did not work")},
+			admin,
+			strings.NewReader(`{"origins":["https://www.offen.dev/blog"]}`),
+			http.StatusBadRequest,
+			"",
+		},
+		{
+			"ok",
+			mockPostAllowedOriginsDatabase{result: []string{"https://www.offen.dev"}},
+			admin,
+			strings.NewReader(`{"origins":["HTTPS://www.offen.dev/"]}`),
+			http.StatusOK,
+			`{"origins":["https://www.offen.dev"]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{
+				db: &test.db,
+			}
+
+			m := gin.New()
+			m.POST("/:accountID", func(c *gin.Context) {
+				c.Set(contextKeyAuth, test.userContext)
+			}, rt.postAllowedOrigins)
+
+			r := httptest.NewRequest(http.MethodPost, "/account-a", test.body)
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if test.expectedBody != "" && w.Body.String() != test.expectedBody {
+				t.Errorf("Unexpected body %v", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"hash/fnv"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// activeUsersWindow is the duration a user is considered active after
+// sending an event.
+const activeUsersWindow = time.Minute * 5
+
+// activeUsers keeps track of the users that have recently sent events per
+// account. Users are identified by a hash of their user id, so the raw id
+// is not kept in memory. As events are counted in memory, only events
+// received by this instance are known.
+type activeUsers struct {
+	window time.Duration
+	lock   sync.Mutex
+	seen   map[string]map[uint64]time.Time
+	pruned map[string]time.Time
+}
+
+func newActiveUsers(window time.Duration) *activeUsers {
+	return &activeUsers{
+		window: window,
+		seen:   map[string]map[uint64]time.Time{},
+		pruned: map[string]time.Time{},
+	}
+}
+
+// record marks the given user as active in the account. Anonymous events
+// cannot be attributed to users and are skipped. Calling record on a nil
+// value is a no-op.
+func (a *activeUsers) record(accountID, userID string, now time.Time) {
+	if a == nil || userID == "" {
+		return
+	}
+	h := fnv.New64a()
+	h.Write([]byte(userID))
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.seen[accountID] == nil {
+		a.seen[accountID] = map[uint64]time.Time{}
+	}
+	a.seen[accountID][h.Sum64()] = now
+	// users that have become inactive are removed once per window so
+	// accounts that are never queried do not grow without bounds
+	if now.Sub(a.pruned[accountID]) > a.window {
+		a.prune(accountID, now)
+	}
+}
+
+// count returns the number of users that have sent an event to the account
+// within the window.
+func (a *activeUsers) count(accountID string, now time.Time) int {
+	if a == nil {
+		return 0
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.prune(accountID, now)
+	return len(a.seen[accountID])
+}
+
+func (a *activeUsers) prune(accountID string, now time.Time) {
+	for user, lastSeen := range a.seen[accountID] {
+		if now.Sub(lastSeen) > a.window {
+			delete(a.seen[accountID], user)
+		}
+	}
+	if len(a.seen[accountID]) == 0 {
+		delete(a.seen, accountID)
+		delete(a.pruned, accountID)
+		return
+	}
+	a.pruned[accountID] = now
+}
+
+type activeUsersResponse struct {
+	AccountID     string `json:"accountId"`
+	ActiveUsers   int    `json:"activeUsers"`
+	WindowSeconds int    `json:"windowSeconds"`
+}
+
+func (rt *router) getActiveUsers(c *gin.Context) {
+	accountID := c.Param("accountID")
+	if !rt.accessibleAccount(c, accountID) {
+		return
+	}
+	c.JSON(http.StatusOK, activeUsersResponse{
+		AccountID:     accountID,
+		ActiveUsers:   rt.active.count(accountID, time.Now()),
+		WindowSeconds: int(activeUsersWindow / time.Second),
+	})
+}
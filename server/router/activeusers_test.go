@@ -0,0 +1,80 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestActiveUsers(t *testing.T) {
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	a := newActiveUsers(time.Minute * 5)
+
+	a.record("account-a", "user-a", now.Add(-time.Minute*10))
+	a.record("account-a", "user-b", now.Add(-time.Minute*4))
+	a.record("account-a", "user-b", now.Add(-time.Minute*2))
+	a.record("account-a", "user-c", now)
+	a.record("account-a", "", now)
+	a.record("account-b", "user-a", now)
+
+	if count := a.count("account-a", now); count != 2 {
+		t.Errorf("Expected 2 active users, got %d", count)
+	}
+	if count := a.count("account-b", now); count != 1 {
+		t.Errorf("Expected 1 active user, got %d", count)
+	}
+	if count := a.count("account-a", now.Add(time.Minute*4)); count != 1 {
+		t.Errorf("Expected 1 active user, got %d", count)
+	}
+	if count := a.count("account-a", now.Add(time.Minute*10)); count != 0 {
+		t.Errorf("Expected no active users, got %d", count)
+	}
+	if len(a.seen) != 1 {
+		t.Errorf("Expected inactive accounts to be removed, got %v", a.seen)
+	}
+
+	var disabled *activeUsers
+	disabled.record("account-a", "user-a", now)
+	if count := disabled.count("account-a", now); count != 0 {
+		t.Errorf("Expected nil value to count nothing, got %d", count)
+	}
+}
+
+func TestRouter_getActiveUsers(t *testing.T) {
+	rt := router{active: newActiveUsers(activeUsersWindow)}
+	rt.active.record("account-a", "user-a", time.Now())
+	rt.active.record("account-a", "user-b", time.Now())
+
+	m := gin.New()
+	m.Use(func(c *gin.Context) {
+		c.Set(contextKeyAuth, persistence.LoginResult{
+			Accounts: []persistence.LoginAccountResult{
+				{AccountID: "account-a", Role: persistence.AccountUserRoleViewer},
+			},
+		})
+	})
+	m.GET("/accounts/:accountID/active-users", rt.getActiveUsers)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/accounts/account-a/active-users", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Unexpected status code %v", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"activeUsers":2,"windowSeconds":300`) {
+		t.Errorf("Unexpected body %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/accounts/account-b/active-users", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code %v", w.Code)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type anomalyDetectionRequest struct {
+	Sensitivity string `json:"sensitivity"`
+}
+
+type anomalyDetectionResponse struct {
+	Sensitivity string `json:"sensitivity"`
+}
+
+// postAnomalyDetection sets the sensitivity of the detection of traffic
+// anomalies of the given account.
+func (rt *router) postAnomalyDetection(c *gin.Context) {
+	accountID := c.Param("accountID")
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: could not find account user object in request context"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+
+	if ok := accountUser.CanManageAccount(accountID); !ok {
+		newJSONError(
+			fmt.Errorf("router: account user does not have permissions to change anomaly detection of account %s", accountID),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	var req anomalyDetectionRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if err := rt.db.SetAnomalySensitivity(accountID, req.Sensitivity); err != nil {
+		var errUnknown persistence.ErrUnknownAccount
+		if errors.As(err, &errUnknown) {
+			newJSONError(
+				fmt.Errorf("router: account %s not found: %w", accountID, err),
+				http.StatusNotFound,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error setting anomaly detection of account: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if rt.logger != nil {
+		rt.logger.
+			WithField("audit", "anomaly_detection").
+			WithField("accountId", accountID).
+			WithField("sensitivity", req.Sensitivity).
+			WithField("by", accountUser.AccountUserID).
+			Info("Changed anomaly detection of account")
+	}
+	c.JSON(http.StatusOK, anomalyDetectionResponse{Sensitivity: req.Sensitivity})
+}
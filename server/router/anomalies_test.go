@@ -0,0 +1,63 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockAnomalyDetectionDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockAnomalyDetectionDatabase) SetAnomalySensitivity(accountID, sensitivity string) error {
+	return m.err
+}
+
+func TestRouter_postAnomalyDetection(t *testing.T) {
+	accountUser := persistence.LoginResult{
+		AccountUserID: "user-a",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a", Role: persistence.AccountUserRoleAdmin},
+			{AccountID: "account-b", Role: persistence.AccountUserRoleViewer},
+		},
+	}
+	tests := []struct {
+		name           string
+		db             *mockAnomalyDetectionDatabase
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"ok", &mockAnomalyDetectionDatabase{}, "/accounts/account-a/anomaly-detection", `{"sensitivity":"medium"}`, http.StatusOK},
+		{"viewer", &mockAnomalyDetectionDatabase{}, "/accounts/account-b/anomaly-detection", `{"sensitivity":"medium"}`, http.StatusForbidden},
+		{"bad payload", &mockAnomalyDetectionDatabase{}, "/accounts/account-a/anomaly-detection", `{"sensitivity":`, http.StatusBadRequest},
+		{"invalid", &mockAnomalyDetectionDatabase{err: errors.New("did not work")}, "/accounts/account-a/anomaly-detection", `{"sensitivity":"extreme"}`, http.StatusBadRequest},
+		{"unknown account", &mockAnomalyDetectionDatabase{err: persistence.ErrUnknownAccount("did not work")}, "/accounts/account-a/anomaly-detection", `{"sensitivity":"medium"}`, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: test.db}
+			m := gin.New()
+			m.Use(func(c *gin.Context) {
+				c.Set(contextKeyAuth, accountUser)
+			})
+			m.POST("/accounts/:accountID/anomaly-detection", rt.postAnomalyDetection)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body)))
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+		})
+	}
+}
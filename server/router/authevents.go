@@ -0,0 +1,89 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+// recordAuthEvent adds an entry to the authentication event log of the
+// given account user, or the account user with the given email address.
+// Failing to do so is logged but never fails the request.
+func (rt *router) recordAuthEvent(c *gin.Context, accountUserID, emailAddress, eventType string) {
+	if err := rt.db.RecordAuthEvent(accountUserID, emailAddress, eventType, rt.clientIP(c), c.Request.UserAgent()); err != nil {
+		rt.logError(c, err, "error recording authentication event")
+	}
+}
+
+// notifyNewDeviceLogin sends an email to the account user in case the
+// current request uses a fingerprint that has not been seen in a successful
+// login before. Errors are logged as they must not prevent logging in.
+func (rt *router) notifyNewDeviceLogin(c *gin.Context, accountUserID, emailAddress string) {
+	isNew, err := rt.db.IsNewLoginFingerprint(accountUserID, rt.clientIP(c), c.Request.UserAgent())
+	if err != nil {
+		rt.logError(c, err, "error checking login fingerprint")
+		return
+	}
+	if !isNew {
+		return
+	}
+	subject, body := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	if err := rt.emails.ExecuteTemplate(subject, "subject_new_device_login", nil); err != nil {
+		rt.logError(c, err, "error rendering email subject")
+		return
+	}
+	if err := rt.emails.ExecuteTemplate(body, "body_new_device_login", map[string]string{
+		"ipAddress": rt.clientIP(c),
+		"userAgent": c.Request.UserAgent(),
+		"time":      time.Now().UTC().Format(time.RFC1123),
+	}); err != nil {
+		rt.logError(c, err, "error rendering email body")
+		return
+	}
+	if err := rt.mailer.Send(rt.config.SMTP.Sender, emailAddress, subject.String(), body.String()); err != nil {
+		rt.logError(c, err, "error sending login notification")
+	}
+}
+
+func (rt *router) getAuthEvents(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	var limit int
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			newJSONError(
+				fmt.Errorf("router: invalid limit %q", l),
+				http.StatusBadRequest,
+			).Pipe(c)
+			return
+		}
+		limit = n
+	}
+
+	result, err := rt.db.ListAuthEvents(accountUser.AccountUserID, c.Query("before"), limit)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error looking up authentication events: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
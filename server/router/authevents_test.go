@@ -0,0 +1,55 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockAuthEventsDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockAuthEventsDatabase) ListAuthEvents(userID, before string, limit int) (persistence.AuthEventsResult, error) {
+	if userID != "user-a" || before != "event-z" || limit != 10 {
+		return persistence.AuthEventsResult{}, errors.New("unexpected arguments")
+	}
+	return persistence.AuthEventsResult{Events: []persistence.AuthEventResult{}}, m.err
+}
+
+func TestRouter_getAuthEvents(t *testing.T) {
+	tests := []struct {
+		name               string
+		db                 mockAuthEventsDatabase
+		query              string
+		expectedStatusCode int
+	}{
+		{"ok", mockAuthEventsDatabase{}, "?before=event-z&limit=10", http.StatusOK},
+		{"bad limit", mockAuthEventsDatabase{}, "?before=event-z&limit=ten", http.StatusBadRequest},
+		{"negative limit", mockAuthEventsDatabase{}, "?before=event-z&limit=-1", http.StatusBadRequest},
+		{"database error", mockAuthEventsDatabase{err: errors.New("did not work")}, "?before=event-z&limit=10", http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: &test.db}
+			m := gin.New()
+			m.GET("/", func(c *gin.Context) {
+				c.Set(contextKeyAuth, persistence.LoginResult{AccountUserID: "user-a"})
+			}, rt.getAuthEvents)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+test.query, nil))
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+		})
+	}
+}
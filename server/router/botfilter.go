@@ -0,0 +1,134 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/metrics"
+)
+
+// Reasons for dropping an event at ingestion.
+const (
+	botReasonUserAgent = "user_agent"
+	botReasonHeadless  = "headless"
+	botReasonHoneypot  = "honeypot"
+)
+
+// defaultBotUserAgents contains lowercased fragments of user agents sent by
+// crawlers, monitoring services and HTTP libraries.
+var defaultBotUserAgents = []string{
+	"bot", "crawl", "spider", "slurp", "archiver", "facebookexternalhit",
+	"mediapartners", "lighthouse", "pingdom", "uptime", "statuscake",
+	"curl", "wget", "python-requests", "python-urllib", "go-http-client",
+	"java/", "okhttp", "axios", "node-fetch", "libwww-perl", "httpclient",
+}
+
+// headlessUserAgents contains lowercased fragments of user agents sent by
+// browsers that are remote controlled without a user.
+var headlessUserAgents = []string{
+	"headlesschrome", "phantomjs", "slimerjs", "puppeteer", "playwright",
+	"selenium", "webdriver",
+}
+
+// botFilter drops events sent by bots before they are persisted and counts
+// the dropped events per account. Counts only cover events received by this
+// instance since it has been started.
+type botFilter struct {
+	userAgents []string
+	lock       sync.RWMutex
+	dropped    map[string]map[string]int64
+}
+
+func newBotFilter(userAgents []string) *botFilter {
+	b := &botFilter{dropped: map[string]map[string]int64{}}
+	for _, ua := range append(append([]string{}, defaultBotUserAgents...), userAgents...) {
+		if ua = strings.ToLower(strings.TrimSpace(ua)); ua != "" {
+			b.userAgents = append(b.userAgents, ua)
+		}
+	}
+	return b
+}
+
+// match returns the reason for dropping an event sent in the given request.
+// An empty string means the event is to be kept. A nil filter keeps all
+// events.
+func (b *botFilter) match(r *http.Request, honeypot bool) string {
+	if b == nil {
+		return ""
+	}
+	if honeypot {
+		return botReasonHoneypot
+	}
+	ua := strings.ToLower(r.UserAgent())
+	for _, fragment := range headlessUserAgents {
+		if strings.Contains(ua, fragment) {
+			return botReasonHeadless
+		}
+	}
+	// browsers always send both headers, automated clients often omit them
+	if ua == "" || r.Header.Get("Accept-Language") == "" {
+		return botReasonHeadless
+	}
+	for _, fragment := range b.userAgents {
+		if strings.Contains(ua, fragment) {
+			return botReasonUserAgent
+		}
+	}
+	return ""
+}
+
+// drop records an event of the given account that has been dropped.
+func (b *botFilter) drop(accountID, reason string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.dropped[accountID] == nil {
+		b.dropped[accountID] = map[string]int64{}
+	}
+	b.dropped[accountID][reason]++
+	metrics.EventsDropped.Inc(reason)
+}
+
+// count returns the number of dropped events of the given account by
+// reason.
+func (b *botFilter) count(accountID string) map[string]int64 {
+	result := map[string]int64{
+		botReasonUserAgent: 0,
+		botReasonHeadless:  0,
+		botReasonHoneypot:  0,
+	}
+	if b == nil {
+		return result
+	}
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for reason, count := range b.dropped[accountID] {
+		result[reason] = count
+	}
+	return result
+}
+
+type droppedEventsResponse struct {
+	AccountID string           `json:"accountId"`
+	Total     int64            `json:"total"`
+	Reasons   map[string]int64 `json:"reasons"`
+}
+
+func (rt *router) getDroppedEvents(c *gin.Context) {
+	accountID := c.Param("accountID")
+	if !rt.accessibleAccount(c, accountID) {
+		return
+	}
+	response := droppedEventsResponse{
+		AccountID: accountID,
+		Reasons:   rt.bots.count(accountID),
+	}
+	for _, count := range response.Reasons {
+		response.Total += count
+	}
+	c.JSON(http.StatusOK, response)
+}
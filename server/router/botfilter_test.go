@@ -0,0 +1,124 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/persistence"
+)
+
+const browserUserAgent = "Mozilla/5.0 (X11; Linux x86_64; rv:78.0) Gecko/20100101 Firefox/78.0"
+
+func TestBotFilter_match(t *testing.T) {
+	tests := []struct {
+		name           string
+		userAgent      string
+		acceptLanguage string
+		honeypot       bool
+		expectedReason string
+	}{
+		{"browser", browserUserAgent, "en-US", false, ""},
+		{"honeypot", browserUserAgent, "en-US", true, botReasonHoneypot},
+		{"crawler", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "en-US", false, botReasonUserAgent},
+		{"http library", "curl/7.68.0", "en-US", false, botReasonUserAgent},
+		{"configured", "Mozilla/5.0 InternalMonitor/1.0", "en-US", false, botReasonUserAgent},
+		{"headless", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/84.0.4147.0 Safari/537.36", "en-US", false, botReasonHeadless},
+		{"no user agent", "", "en-US", false, botReasonHeadless},
+		{"no accept language", browserUserAgent, "", false, botReasonHeadless},
+	}
+	b := newBotFilter([]string{" InternalMonitor ", ""})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.Header.Set("User-Agent", test.userAgent)
+			if test.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", test.acceptLanguage)
+			}
+			if reason := b.match(r, test.honeypot); reason != test.expectedReason {
+				t.Errorf("Expected %q, got %q", test.expectedReason, reason)
+			}
+		})
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		var b *botFilter
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		if reason := b.match(r, true); reason != "" {
+			t.Errorf("Unexpected reason %q", reason)
+		}
+	})
+}
+
+func TestRouter_droppedEvents(t *testing.T) {
+	rt := router{
+		// inserting an event fails, so the test fails in case an event
+		// that is expected to be dropped is passed to the database
+		db:     &mockPostEventsService{err: errors.New("did not work")},
+		config: &config.Config{},
+		bots:   newBotFilter(nil),
+	}
+	m := gin.New()
+	m.Use(func(c *gin.Context) {
+		c.Set(contextKeyAuth, persistence.LoginResult{
+			Accounts: []persistence.LoginAccountResult{
+				{AccountID: "account-a", Role: persistence.AccountUserRoleViewer},
+			},
+		})
+	})
+	m.POST("/events", rt.postEvents)
+	m.POST("/events/batch", rt.postEventsBatch)
+	m.GET("/accounts/:accountID/dropped-events", rt.getDroppedEvents)
+
+	requests := []struct {
+		path      string
+		userAgent string
+		body      string
+	}{
+		{"/events", "Googlebot/2.1", `{"accountId":"account-a","payload":"payload"}`},
+		{"/events", browserUserAgent, `{"accountId":"account-a","payload":"payload","honeypot":true}`},
+		{"/events/batch", browserUserAgent, `{"events":[{"accountId":"account-a","payload":"payload","honeypot":true},{"accountId":"account-b","payload":"payload","honeypot":true}]}`},
+	}
+	for _, req := range requests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, req.path, strings.NewReader(req.body))
+		r.Header.Set("User-Agent", req.userAgent)
+		r.Header.Set("Accept-Language", "en-US")
+		m.ServeHTTP(w, r)
+		if w.Code != http.StatusNoContent {
+			t.Errorf("Unexpected status code %v for %s", w.Code, req.body)
+		}
+	}
+
+	expected := map[string]int64{
+		botReasonUserAgent: 1,
+		botReasonHeadless:  0,
+		botReasonHoneypot:  2,
+	}
+	if counts := rt.bots.count("account-a"); !reflect.DeepEqual(expected, counts) {
+		t.Errorf("Expected %v, got %v", expected, counts)
+	}
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/accounts/account-a/dropped-events", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Unexpected status code %v", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"total":3`) {
+		t.Errorf("Unexpected body %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/accounts/account-b/dropped-events", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code %v", w.Code)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressionMinSize is the size a response needs to have for being
+// compressed. Smaller responses usually fit into a single packet anyways.
+const compressionMinSize = 1400
+
+// incompressibleTypes are content types that are compressed already, so
+// compressing them again would only cost time.
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/font-woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/pdf",
+	// streams would be held back until enough data has been written
+	"text/event-stream",
+}
+
+// precompressedExtensions maps encodings to the file extensions of static
+// assets that have been compressed at build time.
+var precompressedExtensions = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	},
+}
+
+// negotiateEncoding returns the encoding out of the supported ones that is
+// preferred by the client sending the given Accept-Encoding header. In case
+// the client does not accept any of the encodings, an empty string is
+// returned. Ties are resolved using the order of the supported encodings.
+func negotiateEncoding(acceptEncoding string, supported ...string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					quality = value
+				}
+			}
+		}
+		qualities[name] = quality
+	}
+
+	var match string
+	var matchQuality float64
+	for _, encoding := range supported {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > matchQuality {
+			match, matchQuality = encoding, quality
+		}
+	}
+	return match
+}
+
+// compressHandler compresses responses using gzip in case the client
+// supports it. Responses that are small, compressed already or that have
+// set a Content-Encoding themselves are passed through as is.
+func compressHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" || negotiateEncoding(r.Header.Get("Accept-Encoding"), "gzip") == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter buffers the beginning of a response until it can decide
+// whether the response is to be compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.gz != nil {
+		return c.gz.Write(p)
+	}
+	if c.decided {
+		return c.ResponseWriter.Write(p)
+	}
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= compressionMinSize {
+		if err := c.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush commits to the decision that can be made given the data written so
+// far and sends all buffered data to the client.
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		c.decide()
+	}
+	if c.gz != nil {
+		c.gz.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *compressWriter) shouldCompress(size int) bool {
+	if size < compressionMinSize {
+		return false
+	}
+	switch c.status {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	header := c.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := strings.ToLower(header.Get("Content-Type"))
+	for _, t := range incompressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *compressWriter) decide() error {
+	c.decided = true
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	header := c.Header()
+	if header.Get("Content-Type") == "" && len(c.buf) != 0 {
+		header.Set("Content-Type", http.DetectContentType(c.buf))
+	}
+	buf := c.buf
+	c.buf = nil
+	if !c.shouldCompress(len(buf)) {
+		c.ResponseWriter.WriteHeader(c.status)
+		_, err := c.ResponseWriter.Write(buf)
+		return err
+	}
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", "gzip")
+	// the compressed response is not byte-for-byte identical to the
+	// representation a strong ETag has been computed for
+	if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		header.Set("ETag", "W/"+etag)
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	c.gz = gzipWriterPool.Get().(*gzip.Writer)
+	c.gz.Reset(c.ResponseWriter)
+	_, err := c.gz.Write(buf)
+	return err
+}
+
+func (c *compressWriter) close() {
+	if !c.decided {
+		if c.status == 0 && len(c.buf) == 0 {
+			// nothing has been written, the default status is sent by the
+			// server
+			return
+		}
+		c.decide()
+	}
+	if c.gz != nil {
+		c.gz.Close()
+		gzipWriterPool.Put(c.gz)
+		c.gz = nil
+	}
+}
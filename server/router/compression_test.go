@@ -0,0 +1,173 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		supported      []string
+		expectedResult string
+	}{
+		{"empty", "", []string{"br", "gzip"}, ""},
+		{"single", "gzip", []string{"br", "gzip"}, "gzip"},
+		{"order of supported", "gzip, deflate, br", []string{"br", "gzip"}, "br"},
+		{"quality", "br;q=0.5, gzip", []string{"br", "gzip"}, "gzip"},
+		{"refused", "gzip;q=0", []string{"gzip"}, ""},
+		{"wildcard", "*", []string{"br", "gzip"}, "br"},
+		{"wildcard with refusal", "br;q=0, *;q=0.1", []string{"br", "gzip"}, "gzip"},
+		{"unsupported", "deflate", []string{"br", "gzip"}, ""},
+		{"case", "GZIP", []string{"gzip"}, "gzip"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := negotiateEncoding(test.acceptEncoding, test.supported...); result != test.expectedResult {
+				t.Errorf("Expected %q, got %q", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestCompressHandler(t *testing.T) {
+	large := strings.Repeat(`{"accountId":"9b63c4d8-65c0-438c-9d30-cc4b01173393"}`, 100)
+	tests := []struct {
+		name             string
+		method           string
+		acceptEncoding   string
+		handler          http.HandlerFunc
+		expectCompressed bool
+		expectedStatus   int
+	}{
+		{
+			"large json",
+			http.MethodGet,
+			"gzip, br",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(large[:1000]))
+				w.Write([]byte(large[1000:]))
+			},
+			true,
+			http.StatusCreated,
+		},
+		{
+			"client not accepting gzip",
+			http.MethodGet,
+			"br",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(large))
+			},
+			false,
+			http.StatusOK,
+		},
+		{
+			"small response",
+			http.MethodGet,
+			"gzip",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"ok":true}`))
+			},
+			false,
+			http.StatusOK,
+		},
+		{
+			"image",
+			http.MethodGet,
+			"gzip",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				w.Write([]byte(large))
+			},
+			false,
+			http.StatusOK,
+		},
+		{
+			"encoded already",
+			http.MethodGet,
+			"gzip",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "br")
+				w.Write([]byte(large))
+			},
+			false,
+			http.StatusOK,
+		},
+		{
+			"head",
+			http.MethodHead,
+			"gzip",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(large))
+			},
+			false,
+			http.StatusOK,
+		},
+		{
+			"no content",
+			http.MethodGet,
+			"gzip",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			false,
+			http.StatusNoContent,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(test.method, "/", nil)
+			r.Header.Set("Accept-Encoding", test.acceptEncoding)
+			compressHandler(test.handler).ServeHTTP(w, r)
+
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if w.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Unexpected Vary header %v", w.Header().Get("Vary"))
+			}
+			if (w.Header().Get("Content-Encoding") == "gzip") != test.expectCompressed {
+				t.Errorf("Unexpected Content-Encoding %v", w.Header().Get("Content-Encoding"))
+			}
+			if !test.expectCompressed {
+				return
+			}
+			reader, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			body, _ := ioutil.ReadAll(reader)
+			if string(body) != large {
+				t.Errorf("Unexpected body %q", string(body))
+			}
+		})
+	}
+}
+
+func TestCompressHandler_ETag(t *testing.T) {
+	large := strings.Repeat("console.log('Hello test!')\n", 100)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	compressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		w.Write([]byte(large))
+	})).ServeHTTP(w, r)
+	if w.Header().Get("ETag") != `W/"abc"` {
+		t.Errorf("Unexpected ETag %v", w.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// dropReasonDuplicate is the reason for dropping an event that has been
+// submitted before within the dedupe window.
+const dropReasonDuplicate = "duplicate"
+
+// dedupe keeps track of the events that have recently been persisted, so
+// that identical submissions caused by clients retrying requests or handlers
+// firing twice are dropped instead of inflating counts. Events are
+// identified by a hash of account, user and encrypted payload, so neither
+// is kept in memory. Payloads are encrypted using a random nonce, so events
+// that have been encrypted again are never considered identical. Only
+// events received by this instance are known.
+type dedupe struct {
+	window time.Duration
+	lock   sync.Mutex
+	seen   map[uint64]time.Time
+	pruned time.Time
+}
+
+func newDedupe(window time.Duration) *dedupe {
+	return &dedupe{
+		window: window,
+		seen:   map[uint64]time.Time{},
+	}
+}
+
+func dedupeKey(accountID, userID, payload string) uint64 {
+	h := fnv.New64a()
+	for _, s := range []string{accountID, userID, payload} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// duplicate returns true in case an identical event has been recorded within
+// the window. Events without a payload cannot be told apart and are never
+// considered duplicates. Calling duplicate on a nil value returns false.
+func (d *dedupe) duplicate(accountID, userID, payload string, now time.Time) bool {
+	if d == nil || payload == "" {
+		return false
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	recorded, ok := d.seen[dedupeKey(accountID, userID, payload)]
+	return ok && now.Sub(recorded) <= d.window
+}
+
+// record marks the given event as persisted. It is expected to be called
+// only after the event has been persisted, so that requests that failed can
+// be retried. Calling record on a nil value is a no-op.
+func (d *dedupe) record(accountID, userID, payload string, now time.Time) {
+	if d == nil || payload == "" {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.seen[dedupeKey(accountID, userID, payload)] = now
+	// events are removed once per window so memory does not grow without
+	// bounds
+	if now.Sub(d.pruned) > d.window {
+		for key, recorded := range d.seen {
+			if now.Sub(recorded) > d.window {
+				delete(d.seen, key)
+			}
+		}
+		d.pruned = now
+	}
+}
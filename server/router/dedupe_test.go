@@ -0,0 +1,115 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/config"
+)
+
+func TestDedupe(t *testing.T) {
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	d := newDedupe(time.Second * 10)
+
+	d.record("account-a", "user-a", "payload-a", now.Add(-time.Second*20))
+	d.record("account-a", "user-a", "payload-b", now.Add(-time.Second*5))
+	d.record("account-a", "", "", now)
+
+	tests := []struct {
+		name      string
+		accountID string
+		userID    string
+		payload   string
+		expected  bool
+	}{
+		{"recent", "account-a", "user-a", "payload-b", true},
+		{"expired", "account-a", "user-a", "payload-a", false},
+		{"other user", "account-a", "user-b", "payload-b", false},
+		{"other account", "account-b", "user-a", "payload-b", false},
+		{"no payload", "account-a", "", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := d.duplicate(test.accountID, test.userID, test.payload, now); result != test.expected {
+				t.Errorf("Expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+
+	d.record("account-a", "user-a", "payload-c", now.Add(time.Minute))
+	if len(d.seen) != 1 {
+		t.Errorf("Expected expired events to be removed, got %v", d.seen)
+	}
+
+	var disabled *dedupe
+	disabled.record("account-a", "user-a", "payload-a", now)
+	if disabled.duplicate("account-a", "user-a", "payload-a", now) {
+		t.Error("Expected nil value to report no duplicates")
+	}
+}
+
+func TestRouter_postEvents_Dedupe(t *testing.T) {
+	db := &mockPostEventsService{}
+	rt := router{
+		db:     db,
+		config: &config.Config{},
+		dedupe: newDedupe(time.Minute),
+	}
+	m := gin.New()
+	m.POST("/", func(c *gin.Context) {
+		c.Set(contextKeyCookie, "user-id")
+		c.Set(contextKeySecureContext, false)
+		c.Next()
+	}, rt.postEvents)
+
+	for _, expectedStatus := range []int{http.StatusCreated, http.StatusNoContent} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"accountId":"account-a","payload":"some-payload"}`))
+		m.ServeHTTP(w, r)
+		if w.Code != expectedStatus {
+			t.Errorf("Expected status code %d, got %d", expectedStatus, w.Code)
+		}
+	}
+}
+
+func TestRouter_postEventsBatch_Dedupe(t *testing.T) {
+	db := &mockPostEventsBatchService{}
+	rt := router{
+		db:     db,
+		config: &config.Config{},
+		dedupe: newDedupe(time.Minute),
+	}
+	m := gin.New()
+	m.POST("/", func(c *gin.Context) {
+		c.Set(contextKeyCookie, "user-id")
+		c.Set(contextKeySecureContext, false)
+		c.Next()
+	}, rt.postEventsBatch)
+
+	body := `{"events":[{"accountId":"account-a","payload":"payload-a"},{"accountId":"account-a","payload":"payload-a"},{"accountId":"account-a","payload":"payload-b"}]}`
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected status code %d, got %d", http.StatusCreated, w.Code)
+	}
+	if len(db.events) != 2 {
+		t.Errorf("Expected duplicates in batch to be dropped, got %v", db.events)
+	}
+
+	db.events = nil
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if len(db.events) != 0 {
+		t.Errorf("Expected retried batch to be dropped, got %v", db.events)
+	}
+}
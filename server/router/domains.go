@@ -0,0 +1,100 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+// checkDomain returns an error response in case the given account does not
+// collect data on the given domain. Events that do not carry the domain
+// they have been recorded on, e.g. when being sent by custom clients, are
+// only checked against the allowed origins of the account.
+func (rt *router) checkDomain(accountID, domain string) *errorResponse {
+	if domain == "" {
+		return nil
+	}
+	allowed, err := rt.db.IsDomainAllowed(accountID, domain)
+	if err != nil {
+		return newInsertEventError(err)
+	}
+	if !allowed {
+		return newJSONError(
+			fmt.Errorf("router: account %s does not collect data on domain %s", accountID, domain),
+			http.StatusForbidden,
+		)
+	}
+	return nil
+}
+
+type domainsRequest struct {
+	Domains []string `json:"domains"`
+}
+
+type domainsResponse struct {
+	Domains []string `json:"domains"`
+}
+
+// postDomains replaces the list of domains the given account collects data
+// on.
+func (rt *router) postDomains(c *gin.Context) {
+	accountID := c.Param("accountID")
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: could not find account user object in request context"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+
+	if ok := accountUser.CanManageAccount(accountID); !ok {
+		newJSONError(
+			fmt.Errorf("router: account user does not have permissions to change domains of account %s", accountID),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	var req domainsRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	domains, err := rt.db.SetDomains(accountID, req.Domains)
+	if err != nil {
+		var errUnknown persistence.ErrUnknownAccount
+		if errors.As(err, &errUnknown) {
+			newJSONError(
+				fmt.Errorf("router: account %s not found: %w", accountID, err),
+				http.StatusNotFound,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error setting domains of account: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if rt.logger != nil {
+		rt.logger.
+			WithField("audit", "domains").
+			WithField("accountId", accountID).
+			WithField("domains", domains).
+			WithField("by", accountUser.AccountUserID).
+			Info("Changed domains of account")
+	}
+	c.JSON(http.StatusOK, domainsResponse{Domains: domains})
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockDomainsDatabase struct {
+	persistence.Service
+	err      error
+	domains  map[string][]string
+	inserted int
+}
+
+func (m *mockDomainsDatabase) SetDomains(accountID string, domains []string) ([]string, error) {
+	return domains, m.err
+}
+
+func (m *mockDomainsDatabase) IsDomainAllowed(accountID, domain string) (bool, error) {
+	if m.err != nil {
+		return false, m.err
+	}
+	if len(m.domains[accountID]) == 0 {
+		return true, nil
+	}
+	for _, value := range m.domains[accountID] {
+		if value == domain {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockDomainsDatabase) Insert(userID, accountID, payload string, eventID *string) error {
+	m.inserted++
+	return nil
+}
+
+func (m *mockDomainsDatabase) InsertBatch(userID string, events []persistence.BatchEvent) error {
+	m.inserted += len(events)
+	return nil
+}
+
+func TestRouter_postDomains(t *testing.T) {
+	accountUser := persistence.LoginResult{
+		AccountUserID: "user-a",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a", Role: persistence.AccountUserRoleAdmin},
+			{AccountID: "account-b", Role: persistence.AccountUserRoleViewer},
+		},
+	}
+	tests := []struct {
+		name           string
+		db             *mockDomainsDatabase
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"ok", &mockDomainsDatabase{}, "/accounts/account-a/domains", `{"domains":["www.offen.dev"]}`, http.StatusOK, `{"domains":["www.offen.dev"]}`},
+		{"viewer", &mockDomainsDatabase{}, "/accounts/account-b/domains", `{"domains":["www.offen.dev"]}`, http.StatusForbidden, ""},
+		{"bad payload", &mockDomainsDatabase{}, "/accounts/account-a/domains", `{"domains":`, http.StatusBadRequest, ""},
+		{"invalid", &mockDomainsDatabase{err: errors.New("did not work")}, "/accounts/account-a/domains", `{"domains":["offen dev"]}`, http.StatusBadRequest, ""},
+		{"unknown account", &mockDomainsDatabase{err: persistence.ErrUnknownAccount("did not work")}, "/accounts/account-a/domains", `{"domains":[]}`, http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: test.db}
+			m := gin.New()
+			m.Use(func(c *gin.Context) {
+				c.Set(contextKeyAuth, accountUser)
+			})
+			m.POST("/accounts/:accountID/domains", rt.postDomains)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body)))
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if test.expectedBody != "" && strings.TrimSpace(w.Body.String()) != test.expectedBody {
+				t.Errorf("Expected body %s, got %s", test.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRouter_domainsCollection(t *testing.T) {
+	domains := map[string][]string{
+		"account-a": {"www.offen.dev", "shop.offen.dev"},
+	}
+	tests := []struct {
+		name             string
+		db               *mockDomainsDatabase
+		path             string
+		body             string
+		expectedStatus   int
+		expectedInserted int
+	}{
+		{"registered", &mockDomainsDatabase{domains: domains}, "/events", `{"accountId":"account-a","payload":"payload","domain":"shop.offen.dev"}`, http.StatusCreated, 1},
+		{"other domain", &mockDomainsDatabase{domains: domains}, "/events", `{"accountId":"account-a","payload":"payload","domain":"www.example.net"}`, http.StatusForbidden, 0},
+		{"no domains registered", &mockDomainsDatabase{domains: domains}, "/events", `{"accountId":"account-b","payload":"payload","domain":"www.example.net"}`, http.StatusCreated, 1},
+		{"no domain given", &mockDomainsDatabase{domains: domains}, "/events", `{"accountId":"account-a","payload":"payload"}`, http.StatusCreated, 1},
+		{"unknown account", &mockDomainsDatabase{err: persistence.ErrUnknownAccount("did not work")}, "/events", `{"accountId":"account-z","payload":"payload","domain":"www.offen.dev"}`, http.StatusNotFound, 0},
+		{
+			"batch",
+			&mockDomainsDatabase{domains: domains},
+			"/events/batch",
+			`{"events":[{"accountId":"account-a","payload":"payload-a","domain":"www.offen.dev"},{"accountId":"account-b","payload":"payload-b","domain":"www.example.net"}]}`,
+			http.StatusCreated,
+			2,
+		},
+		{
+			"batch other domain",
+			&mockDomainsDatabase{domains: domains},
+			"/events/batch",
+			`{"events":[{"accountId":"account-a","payload":"payload-a","domain":"www.offen.dev"},{"accountId":"account-a","payload":"payload-b","domain":"www.example.net"}]}`,
+			http.StatusForbidden,
+			0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: test.db, config: &config.Config{}}
+			m := gin.New()
+			m.Use(func(c *gin.Context) {
+				c.Set(contextKeyCookie, "user-id")
+				c.Set(contextKeySecureContext, false)
+			})
+			m.POST("/events", rt.postEvents)
+			m.POST("/events/batch", rt.postEventsBatch)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body)))
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if test.db.inserted != test.expectedInserted {
+				t.Errorf("Expected %d events to be inserted, got %d", test.expectedInserted, test.db.inserted)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	uuid "github.com/gofrs/uuid"
+	"github.com/offen/offen/server/persistence"
+)
+
+type erasureRequest struct {
+	UserID string `json:"userId"`
+}
+
+// erasureReceipt are the claims of a deletion receipt. It is signed using
+// the receipt key of the keyring, which is not rotated like the keys used
+// for auth tokens, and does not expire.
+type erasureReceipt struct {
+	ReceiptID          string         `json:"jti"`
+	UserID             string         `json:"sub"`
+	IssuedAt           int64          `json:"iat"`
+	Removed            int            `json:"removed"`
+	RemovedByAccountID map[string]int `json:"removedByAccountId"`
+}
+
+type erasureResponse struct {
+	ReceiptID          string         `json:"receiptId"`
+	UserID             string         `json:"userId"`
+	Removed            int            `json:"removed"`
+	RemovedByAccountID map[string]int `json:"removedByAccountId"`
+	Erased             time.Time      `json:"erased"`
+	Receipt            string         `json:"receipt"`
+}
+
+func (rt *router) postErasure(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	// data is erased in all accounts, so this cannot be scoped to the
+	// accounts the requester has access to
+	if !accountUser.IsSuperAdmin() {
+		newJSONError(
+			errors.New("router: only super admins are allowed to erase the data of users"),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	var req erasureRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	result, err := rt.db.EraseUser(req.UserID)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error erasing data of user: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	receiptID, err := uuid.NewV4()
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: data has been erased, but creating a receipt id failed: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	// the user id is pseudonymous, but it is not needed for auditing as
+	// the receipt id refers to the receipt handed out to the user
+	if rt.logger != nil {
+		rt.logger.
+			WithField("audit", "erasure").
+			WithField("receiptId", receiptID.String()).
+			WithField("removed", result.Removed).
+			WithField("by", accountUser.AccountUserID).
+			Info("Erased data of user on request")
+	}
+
+	receipt, err := rt.signingKeys.SignReceipt(erasureReceipt{
+		ReceiptID:          receiptID.String(),
+		UserID:             result.UserID,
+		IssuedAt:           result.Erased.Unix(),
+		Removed:            result.Removed,
+		RemovedByAccountID: result.RemovedByAccountID,
+	})
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: data has been erased, but signing the receipt failed: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusCreated, erasureResponse{
+		ReceiptID:          receiptID.String(),
+		UserID:             result.UserID,
+		Removed:            result.Removed,
+		RemovedByAccountID: result.RemovedByAccountID,
+		Erased:             result.Erased,
+		Receipt:            receipt,
+	})
+}
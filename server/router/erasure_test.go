@@ -0,0 +1,96 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+	"github.com/sirupsen/logrus"
+)
+
+type mockErasureDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockErasureDatabase) EraseUser(userID string) (persistence.ErasureResult, error) {
+	return persistence.ErasureResult{
+		UserID:             userID,
+		Removed:            3,
+		RemovedByAccountID: map[string]int{"account-a": 2, "account-b": 1},
+		Erased:             time.Now(),
+	}, m.err
+}
+
+func TestRouter_postErasure(t *testing.T) {
+	tests := []struct {
+		name           string
+		db             *mockErasureDatabase
+		adminLevel     persistence.AccountUserAdminLevel
+		body           string
+		expectedStatus int
+		expectAudit    bool
+	}{
+		{"ok", &mockErasureDatabase{}, persistence.AccountUserAdminLevelSuperAdmin, `{"userId":"user-z"}`, http.StatusCreated, true},
+		{"not super admin", &mockErasureDatabase{}, 0, `{"userId":"user-z"}`, http.StatusForbidden, false},
+		{"bad payload", &mockErasureDatabase{}, persistence.AccountUserAdminLevelSuperAdmin, `{"userId":`, http.StatusBadRequest, false},
+		{"database error", &mockErasureDatabase{err: errors.New("did not work")}, persistence.AccountUserAdminLevelSuperAdmin, `{"userId":"user-z"}`, http.StatusBadRequest, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger := logrus.New()
+			logger.SetOutput(&logs)
+			rt := router{
+				db:          test.db,
+				logger:      logger,
+				signingKeys: mustSigningKeyring("secret"),
+			}
+			m := gin.New()
+			m.Use(func(c *gin.Context) {
+				c.Set(contextKeyAuth, persistence.LoginResult{AccountUserID: "user-a", AdminLevel: test.adminLevel})
+			})
+			m.POST("/erasures", rt.postErasure)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/erasures", strings.NewReader(test.body)))
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if audit := strings.Contains(logs.String(), "audit=erasure"); audit != test.expectAudit {
+				t.Errorf("Unexpected audit log %v", logs.String())
+			}
+			if strings.Contains(logs.String(), "user-z") {
+				t.Errorf("Expected user id not to be logged, got %v", logs.String())
+			}
+			if w.Code != http.StatusCreated {
+				return
+			}
+			var response erasureResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if response.Removed != 3 || response.ReceiptID == "" || len(strings.Split(response.Receipt, ".")) != 3 {
+				t.Errorf("Unexpected response %v", response)
+			}
+			header, _ := base64.RawURLEncoding.DecodeString(strings.Split(response.Receipt, ".")[0])
+			if !strings.Contains(string(header), "-receipts") {
+				t.Errorf("Expected receipt to be signed using receipt key, got %s", header)
+			}
+			if !strings.Contains(logs.String(), response.ReceiptID) {
+				t.Errorf("Expected receipt id to be logged, got %v", logs.String())
+			}
+		})
+	}
+}
@@ -3,20 +3,151 @@
 
 package router
 
-import "github.com/gin-gonic/gin"
+import (
+	"errors"
+	"net/http"
+	"strings"
 
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/keys"
+	"github.com/offen/offen/server/persistence"
+)
+
+// problemContentType is the media type of error responses as defined in
+// RFC 7807.
+const problemContentType = "application/problem+json"
+
+// problemTypePrefix is prepended to the code of a problem to form its type.
+const problemTypePrefix = "urn:offen:problem:"
+
+// errorResponse is a problem details object as defined in RFC 7807. Code
+// is a stable identifier of the problem that clients can branch on, while
+// Detail is meant for humans and might change.
 type errorResponse struct {
-	Error  string `json:"error"`
-	Status int    `json:"status"`
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+	// Error contains the same value as Detail and is kept for clients
+	// written before error responses were problem details.
+	Error string `json:"error"`
+	// Feedback contains hints on how to choose a stronger password in case
+	// the error has been caused by a weak password or a password that violates
+	// the password policy.
+	Feedback []string `json:"feedback,omitempty"`
+	// Violations lists the reasons for rejecting a request payload in case
+	// it did not match the shape expected by the route.
+	Violations []violation `json:"violations,omitempty"`
+	// RequestID can be used by users to reference the failed request when
+	// reporting issues.
+	RequestID string `json:"requestId,omitempty"`
+	err       error
 }
 
+// Pipe writes the error response and attaches the underlying error to the
+// request, so it can be logged along with the request id.
 func (e *errorResponse) Pipe(c *gin.Context) {
+	e.RequestID = c.GetString(contextKeyRequestID)
+	e.Instance = c.Request.URL.Path
+	if e.err != nil {
+		c.Error(e.err)
+	}
+	c.Header("Content-Type", problemContentType)
 	c.AbortWithStatusJSON(e.Status, e)
 }
 
 func newJSONError(err error, status int) *errorResponse {
-	return &errorResponse{
-		Error:  err.Error(),
+	code := problemCode(err, status)
+	response := &errorResponse{
+		Type:   problemTypePrefix + code,
+		Title:  http.StatusText(status),
 		Status: status,
+		Detail: err.Error(),
+		Code:   code,
+		Error:  err.Error(),
+		err:    err,
+	}
+	var strengthErr *keys.PasswordStrengthError
+	if errors.As(err, &strengthErr) {
+		response.Feedback = strengthErr.Feedback
+	}
+	var policyErr *persistence.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		response.Feedback = policyErr.Feedback
+	}
+	var validationErr *validationError
+	if errors.As(err, &validationErr) {
+		response.Violations = validationErr.violations
+	}
+	return response
+}
+
+// problemCodes maps errors returned by other packages to stable codes.
+// Errors wrapping other errors of this list need to be listed first.
+var problemCodes = []struct {
+	err  error
+	code string
+}{
+	{persistence.ErrInvalidSecondFactor, "invalid_second_factor"},
+	{persistence.ErrInvalidCredentials, "invalid_credentials"},
+	{persistence.ErrSecondFactorRequired, "second_factor_required"},
+	{persistence.ErrPasswordBreached, "password_breached"},
+	{persistence.ErrPasswordOutOfSync, "password_out_of_sync"},
+	{persistence.ErrPasswordManagedExternally, "password_managed_externally"},
+	{persistence.ErrPasswordExpired, "password_expired"},
+	{persistence.ErrPermissionDenied, "permission_denied"},
+	{persistence.ErrAccountUserSuspended, "account_user_suspended"},
+	{persistence.ErrInvalidAccessToken, "invalid_access_token"},
+	{persistence.ErrInvalidRefreshToken, "invalid_refresh_token"},
+	{persistence.ErrInvalidServiceAccountCredential, "invalid_service_account_credential"},
+	{persistence.ErrUnknownWebhook, "unknown_webhook"},
+	{persistence.ErrUnknownRollup, "unknown_rollup"},
+	{persistence.ErrUnknownFunnel, "unknown_funnel"},
+	{persistence.ErrUnknownEventType, "unknown_event_type"},
+	{persistence.ErrUnknownGoal, "unknown_goal"},
+	{persistence.ErrUnknownReportSubscription, "unknown_report_subscription"},
+}
+
+// problemCode returns the code for the given error. In case the error is
+// not known, the code is derived from the status.
+func problemCode(err error, status int) string {
+	for _, item := range problemCodes {
+		if errors.Is(err, item.err) {
+			return item.code
+		}
+	}
+	var (
+		unknownAccountErr persistence.ErrUnknownAccount
+		unknownSecretErr  persistence.ErrUnknownSecret
+		oneTimeKeyErr     persistence.ErrInvalidOneTimeKey
+		policyErr         *persistence.PasswordPolicyError
+		strengthErr       *keys.PasswordStrengthError
+		validationErr     *validationError
+	)
+	switch {
+	case errors.As(err, &unknownAccountErr):
+		return "unknown_account"
+	case errors.As(err, &unknownSecretErr):
+		return "unknown_secret"
+	case errors.As(err, &oneTimeKeyErr):
+		return "invalid_one_time_key"
+	case errors.As(err, &policyErr):
+		return "password_policy_violated"
+	case errors.As(err, &strengthErr):
+		return "password_too_weak"
+	case errors.As(err, &validationErr):
+		return "invalid_payload"
+	}
+	switch status {
+	case http.StatusTooManyRequests:
+		return "rate_limited"
+	case http.StatusInternalServerError:
+		return "internal_error"
+	}
+	if text := http.StatusText(status); text != "" {
+		return strings.ReplaceAll(strings.ToLower(text), " ", "_")
 	}
+	return "unknown"
 }
@@ -5,11 +5,15 @@ package router
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/keys"
+	"github.com/offen/offen/server/persistence"
 )
 
 func TestJSONError(t *testing.T) {
@@ -26,7 +30,72 @@ func TestJSONError(t *testing.T) {
 	if w.Code != http.StatusInternalServerError {
 		t.Errorf("Unexpected status code %d", w.Code)
 	}
-	if w.Body.String() != `{"error":"does not work","status":500}` {
+	if w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("Unexpected content type %s", w.Header().Get("Content-Type"))
+	}
+	if w.Body.String() != `{"type":"urn:offen:problem:internal_error","title":"Internal Server Error","status":500,"detail":"does not work","instance":"/","code":"internal_error","error":"does not work"}` {
+		t.Errorf("Unexpected response body %s", w.Body.String())
+	}
+}
+
+func TestProblemCode(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		status       int
+		expectedCode string
+	}{
+		{"wrapped sentinel", fmt.Errorf("router: error logging in: %w", persistence.ErrInvalidCredentials), http.StatusUnauthorized, "invalid_credentials"},
+		{"sentinel wrapping other sentinel", persistence.ErrInvalidSecondFactor, http.StatusUnauthorized, "invalid_second_factor"},
+		{"typed error", fmt.Errorf("router: account not found: %w", persistence.ErrUnknownAccount("unknown")), http.StatusNotFound, "unknown_account"},
+		{"validation error", &validationError{}, http.StatusBadRequest, "invalid_payload"},
+		{"rate limited", errors.New("did not work"), http.StatusTooManyRequests, "rate_limited"},
+		{"derived from status", errors.New("did not work"), http.StatusRequestEntityTooLarge, "request_entity_too_large"},
+		{"unknown status", errors.New("did not work"), 999, "unknown"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := problemCode(test.err, test.status); code != test.expectedCode {
+				t.Errorf("Expected %v, got %v", test.expectedCode, code)
+			}
+		})
+	}
+}
+
+func TestJSONError_PasswordFeedback(t *testing.T) {
+	m := gin.New()
+	m.GET("/", func(c *gin.Context) {
+		newJSONError(
+			fmt.Errorf("router: error changing password: %w", &keys.PasswordStrengthError{
+				Score:    0,
+				MinScore: 2,
+				Feedback: []string{"Add another word or two."},
+			}),
+			http.StatusBadRequest,
+		).Pipe(c)
+	})
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	m.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `"feedback":["Add another word or two."]`) {
+		t.Errorf("Unexpected response body %s", w.Body.String())
+	}
+}
+
+func TestJSONError_PolicyFeedback(t *testing.T) {
+	m := gin.New()
+	m.GET("/", func(c *gin.Context) {
+		newJSONError(
+			fmt.Errorf("router: error changing password: %w", &persistence.PasswordPolicyError{
+				Feedback: []string{persistence.FeedbackReused},
+			}),
+			http.StatusBadRequest,
+		).Pipe(c)
+	})
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	m.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `"feedback":["Choose a password you have not used recently."]`) {
 		t.Errorf("Unexpected response body %s", w.Body.String())
 	}
 }
@@ -7,15 +7,28 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/metrics"
 	"github.com/offen/offen/server/persistence"
+	"github.com/oklog/ulid"
 )
 
 type inboundEventPayload struct {
 	AccountID string `json:"accountId"`
 	Payload   string `json:"payload"`
+	EventID   string `json:"eventId,omitempty"`
+	// Honeypot is set by the script in case it detects that it is being
+	// run by a browser that is remote controlled.
+	Honeypot bool `json:"honeypot,omitempty"`
+	// SchemaVersion is the version of the payload schema used by the
+	// client. It is checked by the validation middleware only.
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+	// Domain is the host name of the page the event has been recorded on,
+	// as seen by the vault.
+	Domain string `json:"domain,omitempty"`
 }
 
 type ackResponse struct {
@@ -42,32 +55,71 @@ func (rt *router) postEvents(c *gin.Context) {
 		return
 	}
 
-	if err := rt.db.Insert(userID, evt.AccountID, evt.Payload, nil); err != nil {
-		var unknownAccountErr persistence.ErrUnknownAccount
-		if errors.As(err, &unknownAccountErr) {
-			newJSONError(
-				fmt.Errorf("router: error inserting event: %w", unknownAccountErr),
-				http.StatusNotFound,
-			).Pipe(c)
-			return
-		}
+	if reason := rt.bots.match(c.Request, evt.Honeypot); reason != "" {
+		rt.bots.drop(evt.AccountID, reason)
+		c.Status(http.StatusNoContent)
+		return
+	}
 
-		var unknownSecretErr persistence.ErrUnknownSecret
-		if errors.As(err, &unknownSecretErr) {
-			newJSONError(
-				fmt.Errorf("router: error inserting event: %w", unknownSecretErr),
-				http.StatusBadRequest,
-			).Pipe(c)
-			return
-		}
+	if errResponse := rt.checkDomain(evt.AccountID, evt.Domain); errResponse != nil {
+		errResponse.Pipe(c)
+		return
+	}
 
-		newJSONError(
-			fmt.Errorf("router: error persisting event: %v", err),
-			http.StatusInternalServerError,
-		).Pipe(c)
+	handling, err := rt.privacySignals(c.Request, evt.AccountID)
+	if err != nil {
+		newInsertEventError(err).Pipe(c)
+		return
+	}
+	switch handling {
+	case persistence.PrivacySignalsOptOut:
+		c.Status(http.StatusNoContent)
+		return
+	case persistence.PrivacySignalsAnonymous:
+		// the payload is encrypted using the user's secret, so it would
+		// still be linked to the user when kept
+		userID = ""
+		evt.Payload = ""
+	}
+
+	if rt.dedupe.duplicate(evt.AccountID, userID, evt.Payload, time.Now()) {
+		metrics.EventsDropped.Inc(dropReasonDuplicate)
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if err := rt.quotas.take(map[string][]string{evt.AccountID: {evt.Payload}}, time.Now()); err != nil {
+		err.pipe(c)
 		return
 	}
 
+	eventIDs, err := rt.eventIDs(userID, c.GetHeader(idempotencyKeyHeader), []inboundEventPayload{evt})
+	if err != nil {
+		newJSONError(err, http.StatusBadRequest).Pipe(c)
+		return
+	}
+	var eventID *string
+	if eventIDs[0] != "" {
+		eventID = &eventIDs[0]
+	}
+
+	if err := rt.tracedDB(c.Request.Context()).Insert(userID, evt.AccountID, evt.Payload, eventID); err != nil {
+		newInsertEventError(err).Pipe(c)
+		return
+	}
+
+	metrics.EventsIngested.Inc(strconv.FormatBool(userID == ""))
+	received := time.Now()
+	rt.dedupe.record(evt.AccountID, userID, evt.Payload, received)
+	rt.active.record(evt.AccountID, userID, received)
+	rt.live.publish(liveEvent{
+		AccountID: evt.AccountID,
+		EventID:   eventIDs[0],
+		Payload:   evt.Payload,
+		Anonymous: userID == "",
+		Received:  received,
+	})
+
 	// this handler might be called without a cookie / i.e. receiving an
 	// anonymous event, in which case it is important **NOT** to re-issue
 	// the user cookie.
@@ -80,6 +132,184 @@ func (rt *router) postEvents(c *gin.Context) {
 	c.JSON(http.StatusCreated, ackResponse{true})
 }
 
+// maxBatchSize is the maximum number of events that can be submitted in a
+// single batch.
+const maxBatchSize = 100
+
+type inboundEventsBatchPayload struct {
+	Events []inboundEventPayload `json:"events"`
+}
+
+// postEventsBatch inserts multiple events at once, which allows clients to
+// flush events that have been queued while offline in a single request.
+func (rt *router) postEventsBatch(c *gin.Context) {
+	userID := c.GetString(contextKeyCookie)
+	if l := <-rt.getLimiter().LinearThrottle(time.Second/2, fmt.Sprintf("postEventsBatch-%s", userID)); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error rate limiting request: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+	batch := inboundEventsBatchPayload{}
+	if err := c.BindJSON(&batch); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %v", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	if len(batch.Events) == 0 {
+		newJSONError(
+			errors.New("router: received empty batch of events"),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	if len(batch.Events) > maxBatchSize {
+		newJSONError(
+			fmt.Errorf("router: received %d events, batches are limited to %d events", len(batch.Events), maxBatchSize),
+			http.StatusRequestEntityTooLarge,
+		).Pipe(c)
+		return
+	}
+
+	// the honeypot flag is set per event, so events of the same batch
+	// might be dropped for different reasons
+	var kept []inboundEventPayload
+	handlings := map[string]string{}
+	// duplicates might also be contained in the batch itself
+	inBatch := map[uint64]bool{}
+	now := time.Now()
+	for _, evt := range batch.Events {
+		if reason := rt.bots.match(c.Request, evt.Honeypot); reason != "" {
+			rt.bots.drop(evt.AccountID, reason)
+			continue
+		}
+		handling, ok := handlings[evt.AccountID]
+		if !ok {
+			var err error
+			if handling, err = rt.privacySignals(c.Request, evt.AccountID); err != nil {
+				newInsertEventError(err).Pipe(c)
+				return
+			}
+			handlings[evt.AccountID] = handling
+		}
+		if handling == persistence.PrivacySignalsOptOut {
+			continue
+		}
+		if errResponse := rt.checkDomain(evt.AccountID, evt.Domain); errResponse != nil {
+			errResponse.Pipe(c)
+			return
+		}
+		if handling != persistence.PrivacySignalsAnonymous && evt.Payload != "" {
+			key := dedupeKey(evt.AccountID, userID, evt.Payload)
+			if inBatch[key] || rt.dedupe.duplicate(evt.AccountID, userID, evt.Payload, now) {
+				metrics.EventsDropped.Inc(dropReasonDuplicate)
+				continue
+			}
+			inBatch[key] = true
+		}
+		kept = append(kept, evt)
+	}
+	if len(kept) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	batch.Events = kept
+
+	payloads := map[string][]string{}
+	for _, evt := range batch.Events {
+		payloads[evt.AccountID] = append(payloads[evt.AccountID], evt.Payload)
+	}
+	if err := rt.quotas.take(payloads, now); err != nil {
+		err.pipe(c)
+		return
+	}
+
+	eventIDs, err := rt.eventIDs(userID, c.GetHeader(idempotencyKeyHeader), batch.Events)
+	if err != nil {
+		newJSONError(err, http.StatusBadRequest).Pipe(c)
+		return
+	}
+	// events of accounts that collect anonymously when receiving privacy
+	// signals cannot be inserted along with the events of the user
+	var events, anonymousEvents []persistence.BatchEvent
+	for i, evt := range batch.Events {
+		event := persistence.BatchEvent{
+			AccountID: evt.AccountID,
+			Payload:   evt.Payload,
+			EventID:   eventIDs[i],
+		}
+		if handlings[evt.AccountID] == persistence.PrivacySignalsAnonymous {
+			event.Payload = ""
+			anonymousEvents = append(anonymousEvents, event)
+			continue
+		}
+		events = append(events, event)
+	}
+	for _, insert := range []struct {
+		userID string
+		events []persistence.BatchEvent
+	}{
+		{userID, events},
+		{"", anonymousEvents},
+	} {
+		if len(insert.events) == 0 {
+			continue
+		}
+		if err := rt.tracedDB(c.Request.Context()).InsertBatch(insert.userID, insert.events); err != nil {
+			newInsertEventError(err).Pipe(c)
+			return
+		}
+
+		metrics.EventsIngested.Add(float64(len(insert.events)), strconv.FormatBool(insert.userID == ""))
+		received := time.Now()
+		for _, evt := range insert.events {
+			rt.dedupe.record(evt.AccountID, insert.userID, evt.Payload, received)
+			rt.active.record(evt.AccountID, insert.userID, received)
+			rt.live.publish(liveEvent{
+				AccountID: evt.AccountID,
+				EventID:   evt.EventID,
+				Payload:   evt.Payload,
+				Anonymous: insert.userID == "",
+				Received:  received,
+			})
+		}
+	}
+
+	if userID != "" && len(events) != 0 {
+		http.SetCookie(
+			c.Writer,
+			rt.userCookie(userID, c.GetBool(contextKeySecureContext)),
+		)
+	}
+	c.JSON(http.StatusCreated, ackResponse{true})
+}
+
+func newInsertEventError(err error) *errorResponse {
+	var unknownAccountErr persistence.ErrUnknownAccount
+	if errors.As(err, &unknownAccountErr) {
+		return newJSONError(
+			fmt.Errorf("router: error inserting event: %w", unknownAccountErr),
+			http.StatusNotFound,
+		)
+	}
+
+	var unknownSecretErr persistence.ErrUnknownSecret
+	if errors.As(err, &unknownSecretErr) {
+		return newJSONError(
+			fmt.Errorf("router: error inserting event: %w", unknownSecretErr),
+			http.StatusBadRequest,
+		)
+	}
+
+	return newJSONError(
+		fmt.Errorf("router: error persisting event: %v", err),
+		http.StatusInternalServerError,
+	)
+}
+
 func (rt *router) getEvents(c *gin.Context) {
 	userID := c.GetString(contextKeyCookie)
 	if l := <-rt.getLimiter().LinearThrottle(time.Second, fmt.Sprintf("getEvents-%s", userID)); l.Error != nil {
@@ -89,10 +319,13 @@ func (rt *router) getEvents(c *gin.Context) {
 		).Pipe(c)
 		return
 	}
-	result, err := rt.db.Query(persistence.Query{
-		UserID: userID,
-		Since:  c.Query("since"),
-	})
+	query, err := parseEventsQuery(c)
+	if err != nil {
+		newJSONError(err, http.StatusBadRequest).Pipe(c)
+		return
+	}
+	query.UserID = userID
+	result, err := rt.tracedDB(c.Request.Context()).Query(query)
 	if err != nil {
 		newJSONError(
 			fmt.Errorf("router: error performing event query: %v", err),
@@ -103,6 +336,57 @@ func (rt *router) getEvents(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
+// maxEventsLimit is the maximum number of events that can be requested per
+// page when querying events.
+const maxEventsLimit = 1000
+
+// parseEventsQuery reads the filters of an events query from the query
+// string. For compatibility with existing clients, since is interpreted as
+// the sequence returned by a previous query unless it is a RFC 3339
+// timestamp. Filtering by event type is not supported as the type is part of
+// the encrypted payload.
+func parseEventsQuery(c *gin.Context) (persistence.Query, error) {
+	var query persistence.Query
+	if since := c.Query("since"); since != "" {
+		if sinceTime, err := time.Parse(time.RFC3339, since); err == nil {
+			if query.After, err = persistence.EventIDAt(sinceTime); err != nil {
+				return query, fmt.Errorf("router: error creating event id: %w", err)
+			}
+		} else {
+			query.Since = since
+		}
+	}
+	if until := c.Query("until"); until != "" {
+		untilTime, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return query, fmt.Errorf("router: invalid value %s for until, expected RFC 3339 timestamp", until)
+		}
+		if query.Until, err = persistence.EventIDAt(untilTime); err != nil {
+			return query, fmt.Errorf("router: error creating event id: %w", err)
+		}
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		id, err := ulid.ParseStrict(cursor)
+		if err != nil {
+			return query, fmt.Errorf("router: invalid value %s for cursor: %w", cursor, err)
+		}
+		if id.String() > query.After {
+			query.After = id.String()
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 1 || value > maxEventsLimit {
+			return query, fmt.Errorf("router: invalid value %s for limit, expected number between 1 and %d", limit, maxEventsLimit)
+		}
+		query.Limit = value
+	}
+	if accountIDs := c.QueryArray("accountId"); len(accountIDs) != 0 {
+		query.AccountIDs = accountIDs
+	}
+	return query, nil
+}
+
 func (rt *router) purgeEvents(c *gin.Context) {
 	userID := c.GetString(contextKeyCookie)
 	if l := <-rt.getLimiter().LinearThrottle(time.Second, fmt.Sprintf("purgeEvents-%s", userID)); l.Error != nil {
@@ -112,7 +396,7 @@ func (rt *router) purgeEvents(c *gin.Context) {
 		).Pipe(c)
 		return
 	}
-	if err := rt.db.Purge(userID); err != nil {
+	if err := rt.tracedDB(c.Request.Context()).Purge(userID); err != nil {
 		newJSONError(
 			fmt.Errorf("router: error purging user events: %v", err),
 			http.StatusInternalServerError,
@@ -5,10 +5,13 @@ package router
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/offen/offen/server/config"
@@ -81,6 +84,81 @@ func (m *mockGetEventsService) Query(persistence.Query) (persistence.EventsResul
 	return m.result, m.err
 }
 
+func TestParseEventsQuery(t *testing.T) {
+	cursor, _ := persistence.EventIDAt(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name          string
+		queryString   string
+		expectError   bool
+		expectedQuery func(persistence.Query) error
+	}{
+		{
+			"empty",
+			"",
+			false,
+			func(q persistence.Query) error {
+				if !reflect.DeepEqual(persistence.Query{}, q) {
+					return fmt.Errorf("unexpected query %v", q)
+				}
+				return nil
+			},
+		},
+		{
+			"sequence",
+			"since=sequence-a",
+			false,
+			func(q persistence.Query) error {
+				if q.Since != "sequence-a" || q.After != "" {
+					return fmt.Errorf("unexpected query %v", q)
+				}
+				return nil
+			},
+		},
+		{
+			"time range",
+			"since=2020-01-01T00:00:00Z&until=2020-02-01T00:00:00Z",
+			false,
+			func(q persistence.Query) error {
+				if q.Since != "" || q.After == "" || q.Until == "" || q.After >= q.Until {
+					return fmt.Errorf("unexpected query %v", q)
+				}
+				return nil
+			},
+		},
+		{
+			"cursor after since",
+			"since=2020-01-01T00:00:00Z&cursor=" + cursor + "&limit=10&accountId=account-a&accountId=account-b",
+			false,
+			func(q persistence.Query) error {
+				if q.After != cursor || q.Limit != 10 || !reflect.DeepEqual(q.AccountIDs, []string{"account-a", "account-b"}) {
+					return fmt.Errorf("unexpected query %v", q)
+				}
+				return nil
+			},
+		},
+		{"bad until", "until=yesterday", true, nil},
+		{"bad cursor", "cursor=event-a", true, nil},
+		{"bad limit", "limit=abc", true, nil},
+		{"limit too large", "limit=1001", true, nil},
+		{"zero limit", "limit=0", true, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/?"+test.queryString, nil)
+			query, err := parseEventsQuery(c)
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if test.expectedQuery != nil {
+				if err := test.expectedQuery(query); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
 func TestRouter_getEvents(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -165,6 +243,13 @@ func TestRouter_postEvents(t *testing.T) {
 			http.StatusBadRequest,
 			"",
 		},
+		{
+			"bad event id",
+			&mockPostEventsService{},
+			`{"accountId":"account-a","payload":"some-payload","eventId":"event-a"}`,
+			http.StatusBadRequest,
+			"",
+		},
 		{
 			"database error",
 			&mockPostEventsService{
@@ -231,3 +316,97 @@ func TestRouter_postEvents(t *testing.T) {
 		})
 	}
 }
+
+type mockPostEventsBatchService struct {
+	persistence.Service
+	err    error
+	events []persistence.BatchEvent
+}
+
+func (m *mockPostEventsBatchService) InsertBatch(userID string, events []persistence.BatchEvent) error {
+	m.events = events
+	return m.err
+}
+
+func TestRouter_postEventsBatch(t *testing.T) {
+	tests := []struct {
+		name           string
+		db             *mockPostEventsBatchService
+		body           string
+		expectedStatus int
+		expectedEvents int
+	}{
+		{
+			"bad payload",
+			&mockPostEventsBatchService{},
+			"o hai!",
+			http.StatusBadRequest,
+			0,
+		},
+		{
+			"empty batch",
+			&mockPostEventsBatchService{},
+			`{"events":[]}`,
+			http.StatusBadRequest,
+			0,
+		},
+		{
+			"batch too large",
+			&mockPostEventsBatchService{},
+			`{"events":[` + strings.Repeat(`{"accountId":"account-a","payload":"some-payload"},`, maxBatchSize) + `{"accountId":"account-a","payload":"some-payload"}]}`,
+			http.StatusRequestEntityTooLarge,
+			0,
+		},
+		{
+			"database error",
+			&mockPostEventsBatchService{err: errors.New("did not work")},
+			`{"events":[{"accountId":"account-a","payload":"some-payload"}]}`,
+			http.StatusInternalServerError,
+			1,
+		},
+		{
+			"unknown account",
+			&mockPostEventsBatchService{err: persistence.ErrUnknownAccount("unknown account")},
+			`{"events":[{"accountId":"account-a","payload":"some-payload"}]}`,
+			http.StatusNotFound,
+			1,
+		},
+		{
+			"ok",
+			&mockPostEventsBatchService{},
+			`{"events":[{"accountId":"account-a","payload":"some-payload"},{"accountId":"account-b","payload":"other-payload"}]}`,
+			http.StatusCreated,
+			2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := gin.New()
+			rt := router{
+				db:     test.db,
+				config: &config.Config{},
+			}
+			m.POST("/", func(c *gin.Context) {
+				c.Set(contextKeyCookie, "user-id")
+				c.Set(contextKeySecureContext, false)
+				c.Next()
+			}, rt.postEventsBatch)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+
+			m.ServeHTTP(w, r)
+
+			if w.Code != test.expectedStatus {
+				t.Errorf("Expected status code %d, got %d", test.expectedStatus, w.Code)
+			}
+			if len(test.db.events) != test.expectedEvents {
+				t.Errorf("Expected %d events to be inserted, got %d", test.expectedEvents, len(test.db.events))
+			}
+			if w.Code == http.StatusCreated && w.Header().Get("Set-Cookie") == "" {
+				t.Error("Expected user cookie to be renewed")
+			}
+		})
+	}
+}
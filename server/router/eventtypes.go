@@ -0,0 +1,81 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type createEventTypeRequest struct {
+	Name        string `json:"name"`
+	Aggregation string `json:"aggregation"`
+}
+
+func (rt *router) getEventTypes(c *gin.Context) {
+	accountID := c.Param("accountID")
+	if !rt.accessibleAccount(c, accountID) {
+		return
+	}
+	result, err := rt.db.ListEventTypes(accountID)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error looking up event types: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{"eventTypes": result})
+}
+
+func (rt *router) postEventType(c *gin.Context) {
+	accountID := c.Param("accountID")
+	accountUser, ok := rt.manageableAccount(c, accountID)
+	if !ok {
+		return
+	}
+	var req createEventTypeRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	result, err := rt.db.CreateEventType(accountUser.AccountUserID, accountID, req.Name, req.Aggregation)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating event type: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusCreated, result)
+}
+
+func (rt *router) deleteEventType(c *gin.Context) {
+	accountID := c.Param("accountID")
+	if _, ok := rt.manageableAccount(c, accountID); !ok {
+		return
+	}
+	if err := rt.db.DeleteEventType(accountID, c.Param("eventTypeID")); err != nil {
+		if errors.Is(err, persistence.ErrUnknownEventType) {
+			newJSONError(
+				fmt.Errorf("router: event type %s not found: %w", c.Param("eventTypeID"), err),
+				http.StatusNotFound,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error deleting event type: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockEventTypesDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockEventTypesDatabase) ListEventTypes(accountID string) ([]persistence.EventTypeResult, error) {
+	return []persistence.EventTypeResult{{EventTypeID: "type-a", AccountID: accountID}}, m.err
+}
+
+func (m *mockEventTypesDatabase) CreateEventType(userID, accountID, name, aggregation string) (persistence.EventTypeResult, error) {
+	return persistence.EventTypeResult{EventTypeID: "type-a", AccountID: accountID, Name: name, Aggregation: aggregation}, m.err
+}
+
+func (m *mockEventTypesDatabase) DeleteEventType(accountID, eventTypeID string) error {
+	return m.err
+}
+
+func TestRouter_eventTypes(t *testing.T) {
+	accountUser := persistence.LoginResult{
+		AccountUserID: "user-a",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a", Role: persistence.AccountUserRoleAdmin},
+			{AccountID: "account-b", Role: persistence.AccountUserRoleViewer},
+		},
+	}
+	tests := []struct {
+		name           string
+		db             *mockEventTypesDatabase
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"list", &mockEventTypesDatabase{}, http.MethodGet, "/accounts/account-a/event-types", "", http.StatusOK, `"eventTypeId":"type-a"`},
+		{"list viewer", &mockEventTypesDatabase{}, http.MethodGet, "/accounts/account-b/event-types", "", http.StatusOK, `"eventTypeId":"type-a"`},
+		{"list other account", &mockEventTypesDatabase{}, http.MethodGet, "/accounts/account-z/event-types", "", http.StatusForbidden, ""},
+		{"list error", &mockEventTypesDatabase{err: errors.New("did not work")}, http.MethodGet, "/accounts/account-a/event-types", "", http.StatusInternalServerError, ""},
+		{"create", &mockEventTypesDatabase{}, http.MethodPost, "/accounts/account-a/event-types", `{"name":"signup","aggregation":"users"}`, http.StatusCreated, `"name":"signup","aggregation":"users"`},
+		{"create viewer", &mockEventTypesDatabase{}, http.MethodPost, "/accounts/account-b/event-types", `{}`, http.StatusForbidden, ""},
+		{"create bad payload", &mockEventTypesDatabase{}, http.MethodPost, "/accounts/account-a/event-types", `{"name":`, http.StatusBadRequest, ""},
+		{"create invalid", &mockEventTypesDatabase{err: errors.New("did not work")}, http.MethodPost, "/accounts/account-a/event-types", `{}`, http.StatusBadRequest, ""},
+		{"delete", &mockEventTypesDatabase{}, http.MethodDelete, "/accounts/account-a/event-types/type-a", "", http.StatusNoContent, ""},
+		{"delete unknown", &mockEventTypesDatabase{err: persistence.ErrUnknownEventType}, http.MethodDelete, "/accounts/account-a/event-types/type-z", "", http.StatusNotFound, `"code":"unknown_event_type"`},
+		{"delete viewer", &mockEventTypesDatabase{}, http.MethodDelete, "/accounts/account-b/event-types/type-a", "", http.StatusForbidden, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: test.db}
+			m := gin.New()
+			m.Use(func(c *gin.Context) {
+				c.Set(contextKeyAuth, accountUser)
+			})
+			m.GET("/accounts/:accountID/event-types", rt.getEventTypes)
+			m.POST("/accounts/:accountID/event-types", rt.postEventType)
+			m.DELETE("/accounts/:accountID/event-types/:eventTypeID", rt.deleteEventType)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if !strings.Contains(w.Body.String(), test.expectedBody) {
+				t.Errorf("Unexpected body %v", w.Body.String())
+			}
+		})
+	}
+}
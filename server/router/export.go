@@ -0,0 +1,144 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+// Formats statistics can be exported in.
+const (
+	exportFormatCSV  = "csv"
+	exportFormatJSON = "json"
+)
+
+// exportColumns are the columns of CSV exports, in order.
+var exportColumns = []string{"start", "period", "events", "users", "anonymous_events", "sampling_rate", "import_source"}
+
+// exportRow is a single period of an export. Starts use the time zone of the
+// account. Encrypted payloads cannot be read by the server and are not
+// exported. Rows of rollups imported from another analytics tool name the
+// tool in ImportSource.
+type exportRow struct {
+	Start           time.Time `json:"start"`
+	Period          string    `json:"period"`
+	Events          int       `json:"events"`
+	Users           int       `json:"users"`
+	AnonymousEvents int       `json:"anonymousEvents"`
+	SamplingRate    float64   `json:"samplingRate"`
+	ImportSource    string    `json:"importSource,omitempty"`
+}
+
+func (r exportRow) record() []string {
+	return []string{
+		r.Start.Format(time.RFC3339),
+		r.Period,
+		strconv.Itoa(r.Events),
+		strconv.Itoa(r.Users),
+		strconv.Itoa(r.AnonymousEvents),
+		strconv.FormatFloat(r.SamplingRate, 'f', -1, 64),
+		r.ImportSource,
+	}
+}
+
+type exportResponse struct {
+	AccountID string      `json:"accountId"`
+	Period    string      `json:"period"`
+	From      time.Time   `json:"from"`
+	Until     time.Time   `json:"until"`
+	Rows      []exportRow `json:"rows"`
+}
+
+func (rt *router) getExport(c *gin.Context) {
+	accountID := c.Param("accountID")
+	if !rt.accessibleAccount(c, accountID) {
+		return
+	}
+	format := c.DefaultQuery("format", exportFormatCSV)
+	if format != exportFormatCSV && format != exportFormatJSON {
+		newJSONError(
+			fmt.Errorf("router: unknown export format %q", format),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	period, from, until, ok := rollupRange(c)
+	if !ok {
+		return
+	}
+
+	rollups, err := rt.db.GetRollups(accountID, period, from, until)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error looking up rollups: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	filename := fmt.Sprintf(
+		"offen-%s-%s-%s-%s.%s",
+		accountID, period, from.UTC().Format("20060102T150405Z"), until.UTC().Format("20060102T150405Z"), format,
+	)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if format == exportFormatJSON {
+		response := exportResponse{
+			AccountID: accountID,
+			Period:    period,
+			From:      from,
+			Until:     until,
+			Rows:      []exportRow{},
+		}
+		for _, rollup := range rollups {
+			response.Rows = append(response.Rows, newExportRow(rollup))
+		}
+		c.Header("Content-Type", "application/json; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := json.NewEncoder(c.Writer).Encode(response); err != nil {
+			rt.logError(c, err, "error writing export")
+		}
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	// rows are written one by one so large exports do not need to be
+	// buffered in full
+	w := csv.NewWriter(c.Writer)
+	if err := w.Write(exportColumns); err != nil {
+		rt.logError(c, err, "error writing export")
+		return
+	}
+	for _, rollup := range rollups {
+		if err := w.Write(newExportRow(rollup).record()); err != nil {
+			rt.logError(c, err, "error writing export")
+			return
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		rt.logError(c, err, "error writing export")
+	}
+}
+
+func newExportRow(r persistence.RollupResult) exportRow {
+	return exportRow{
+		Start:           r.Start,
+		Period:          r.Period,
+		Events:          r.Events,
+		Users:           r.Users,
+		AnonymousEvents: r.AnonymousEvents,
+		SamplingRate:    r.SamplingRate,
+		ImportSource:    r.ImportSource,
+	}
+}
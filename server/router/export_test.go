@@ -0,0 +1,107 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockExportDatabase struct {
+	persistence.Service
+	loc *time.Location
+	err error
+}
+
+func (m *mockExportDatabase) GetRollups(accountID, period string, from, until time.Time) ([]persistence.RollupResult, error) {
+	if m.loc != nil {
+		from = from.In(m.loc)
+	}
+	return []persistence.RollupResult{
+		{AccountID: accountID, Period: period, Start: from, Events: 12, Users: 4, AnonymousEvents: 2, SamplingRate: 1, Imported: true, ImportSource: persistence.ImportSourceMatomo},
+		{AccountID: accountID, Period: period, Start: from.AddDate(0, 0, 1), Events: 40, Users: 9, SamplingRate: 0.25, EncryptedPayload: "payload"},
+	}, m.err
+}
+
+func TestRouter_getExport(t *testing.T) {
+	accountUser := persistence.LoginResult{
+		AccountUserID: "user-a",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a", Role: persistence.AccountUserRoleViewer},
+		},
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	tests := []struct {
+		name                string
+		db                  *mockExportDatabase
+		path                string
+		expectedStatus      int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			"csv",
+			&mockExportDatabase{},
+			"/accounts/account-a/export?from=2020-03-01T00:00:00Z&until=2020-03-03T00:00:00Z",
+			http.StatusOK,
+			"text/csv",
+			"start,period,events,users,anonymous_events,sampling_rate,import_source\n2020-03-01T00:00:00Z,day,12,4,2,1,matomo\n2020-03-02T00:00:00Z,day,40,9,0,0.25,\n",
+		},
+		{
+			"csv in time zone",
+			&mockExportDatabase{loc: berlin},
+			"/accounts/account-a/export?from=2020-02-29T23:00:00Z&until=2020-03-02T23:00:00Z",
+			http.StatusOK,
+			"text/csv",
+			"2020-03-01T00:00:00+01:00,day,12,4,2,1,matomo\n2020-03-02T00:00:00+01:00,day,40,9,0,0.25,\n",
+		},
+		{
+			"json",
+			&mockExportDatabase{},
+			"/accounts/account-a/export?format=json&period=hour&from=2020-03-01T00:00:00Z&until=2020-03-03T00:00:00Z",
+			http.StatusOK,
+			"application/json",
+			`"rows":[{"start":"2020-03-01T00:00:00Z","period":"hour","events":12,"users":4,"anonymousEvents":2,"samplingRate":1,"importSource":"matomo"}`,
+		},
+		{"bad format", &mockExportDatabase{}, "/accounts/account-a/export?format=xml", http.StatusBadRequest, "", ""},
+		{"bad range", &mockExportDatabase{}, "/accounts/account-a/export?from=yesterday", http.StatusBadRequest, "", ""},
+		{"other account", &mockExportDatabase{}, "/accounts/account-z/export", http.StatusForbidden, "", ""},
+		{"database error", &mockExportDatabase{err: errors.New("did not work")}, "/accounts/account-a/export", http.StatusInternalServerError, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: test.db}
+			m := gin.New()
+			m.Use(func(c *gin.Context) {
+				c.Set(contextKeyAuth, accountUser)
+			})
+			m.GET("/accounts/:accountID/export", rt.getExport)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if !strings.HasPrefix(w.Header().Get("Content-Type"), test.expectedContentType) {
+				t.Errorf("Unexpected content type %v", w.Header().Get("Content-Type"))
+			}
+			if !strings.Contains(w.Body.String(), test.expectedBody) {
+				t.Errorf("Unexpected body %v", w.Body.String())
+			}
+			if strings.Contains(w.Body.String(), "payload") {
+				t.Errorf("Expected encrypted payloads to be omitted, got %v", w.Body.String())
+			}
+			if test.expectedStatus == http.StatusOK && !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment; filename=") {
+				t.Errorf("Unexpected content disposition %v", w.Header().Get("Content-Disposition"))
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type createFunnelRequest struct {
+	Name  string                   `json:"name"`
+	Steps []persistence.FunnelStep `json:"steps"`
+}
+
+func (rt *router) getFunnels(c *gin.Context) {
+	accountID := c.Param("accountID")
+	if !rt.accessibleAccount(c, accountID) {
+		return
+	}
+	result, err := rt.db.ListFunnels(accountID)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error looking up funnels: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{"funnels": result})
+}
+
+func (rt *router) postFunnel(c *gin.Context) {
+	accountID := c.Param("accountID")
+	accountUser, ok := rt.manageableAccount(c, accountID)
+	if !ok {
+		return
+	}
+	var req createFunnelRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	result, err := rt.db.CreateFunnel(accountUser.AccountUserID, accountID, rt.sanitizer.Sanitize(req.Name), req.Steps)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating funnel: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusCreated, result)
+}
+
+func (rt *router) deleteFunnel(c *gin.Context) {
+	accountID := c.Param("accountID")
+	if _, ok := rt.manageableAccount(c, accountID); !ok {
+		return
+	}
+	if err := rt.db.DeleteFunnel(accountID, c.Param("funnelID")); err != nil {
+		if errors.Is(err, persistence.ErrUnknownFunnel) {
+			newJSONError(
+				fmt.Errorf("router: funnel %s not found: %w", c.Param("funnelID"), err),
+				http.StatusNotFound,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error deleting funnel: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
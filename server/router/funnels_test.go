@@ -0,0 +1,86 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockFunnelsDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockFunnelsDatabase) ListFunnels(accountID string) ([]persistence.FunnelResult, error) {
+	return []persistence.FunnelResult{{FunnelID: "funnel-a", AccountID: accountID}}, m.err
+}
+
+func (m *mockFunnelsDatabase) CreateFunnel(userID, accountID, name string, steps []persistence.FunnelStep) (persistence.FunnelResult, error) {
+	return persistence.FunnelResult{FunnelID: "funnel-a", AccountID: accountID, Name: name, Steps: steps}, m.err
+}
+
+func (m *mockFunnelsDatabase) DeleteFunnel(accountID, funnelID string) error {
+	return m.err
+}
+
+func TestRouter_funnels(t *testing.T) {
+	accountUser := persistence.LoginResult{
+		AccountUserID: "user-a",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a", Role: persistence.AccountUserRoleAdmin},
+			{AccountID: "account-b", Role: persistence.AccountUserRoleViewer},
+		},
+	}
+	tests := []struct {
+		name           string
+		db             *mockFunnelsDatabase
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"list", &mockFunnelsDatabase{}, http.MethodGet, "/accounts/account-a/funnels", "", http.StatusOK, `"funnelId":"funnel-a"`},
+		{"list viewer", &mockFunnelsDatabase{}, http.MethodGet, "/accounts/account-b/funnels", "", http.StatusOK, `"funnelId":"funnel-a"`},
+		{"list other account", &mockFunnelsDatabase{}, http.MethodGet, "/accounts/account-z/funnels", "", http.StatusForbidden, ""},
+		{"list error", &mockFunnelsDatabase{err: errors.New("did not work")}, http.MethodGet, "/accounts/account-a/funnels", "", http.StatusInternalServerError, ""},
+		{"create", &mockFunnelsDatabase{}, http.MethodPost, "/accounts/account-a/funnels", `{"name":"<b>Signup</b>","steps":[{"type":"url","value":"https://www.example.com/"}]}`, http.StatusCreated, `"name":"Signup","steps":[{"type":"url"`},
+		{"create viewer", &mockFunnelsDatabase{}, http.MethodPost, "/accounts/account-b/funnels", `{}`, http.StatusForbidden, ""},
+		{"create bad payload", &mockFunnelsDatabase{}, http.MethodPost, "/accounts/account-a/funnels", `{"name":`, http.StatusBadRequest, ""},
+		{"create invalid", &mockFunnelsDatabase{err: errors.New("did not work")}, http.MethodPost, "/accounts/account-a/funnels", `{}`, http.StatusBadRequest, ""},
+		{"delete", &mockFunnelsDatabase{}, http.MethodDelete, "/accounts/account-a/funnels/funnel-a", "", http.StatusNoContent, ""},
+		{"delete unknown", &mockFunnelsDatabase{err: persistence.ErrUnknownFunnel}, http.MethodDelete, "/accounts/account-a/funnels/funnel-z", "", http.StatusNotFound, `"code":"unknown_funnel"`},
+		{"delete viewer", &mockFunnelsDatabase{}, http.MethodDelete, "/accounts/account-b/funnels/funnel-a", "", http.StatusForbidden, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: test.db, sanitizer: bluemonday.StrictPolicy()}
+			m := gin.New()
+			m.Use(func(c *gin.Context) {
+				c.Set(contextKeyAuth, accountUser)
+			})
+			m.GET("/accounts/:accountID/funnels", rt.getFunnels)
+			m.POST("/accounts/:accountID/funnels", rt.postFunnel)
+			m.DELETE("/accounts/:accountID/funnels/:funnelID", rt.deleteFunnel)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if !strings.Contains(w.Body.String(), test.expectedBody) {
+				t.Errorf("Unexpected body %v", w.Body.String())
+			}
+		})
+	}
+}
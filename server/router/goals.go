@@ -0,0 +1,82 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type createGoalRequest struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+func (rt *router) getGoals(c *gin.Context) {
+	accountID := c.Param("accountID")
+	if !rt.accessibleAccount(c, accountID) {
+		return
+	}
+	result, err := rt.db.ListGoals(accountID)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error looking up goals: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{"goals": result})
+}
+
+func (rt *router) postGoal(c *gin.Context) {
+	accountID := c.Param("accountID")
+	accountUser, ok := rt.manageableAccount(c, accountID)
+	if !ok {
+		return
+	}
+	var req createGoalRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	result, err := rt.db.CreateGoal(accountUser.AccountUserID, accountID, req.Name, req.Type, req.Value)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating goal: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusCreated, result)
+}
+
+func (rt *router) deleteGoal(c *gin.Context) {
+	accountID := c.Param("accountID")
+	if _, ok := rt.manageableAccount(c, accountID); !ok {
+		return
+	}
+	if err := rt.db.DeleteGoal(accountID, c.Param("goalID")); err != nil {
+		if errors.Is(err, persistence.ErrUnknownGoal) {
+			newJSONError(
+				fmt.Errorf("router: goal %s not found: %w", c.Param("goalID"), err),
+				http.StatusNotFound,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error deleting goal: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
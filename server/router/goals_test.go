@@ -0,0 +1,85 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockGoalsDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockGoalsDatabase) ListGoals(accountID string) ([]persistence.GoalResult, error) {
+	return []persistence.GoalResult{{GoalID: "goal-a", AccountID: accountID}}, m.err
+}
+
+func (m *mockGoalsDatabase) CreateGoal(userID, accountID, name, goalType, value string) (persistence.GoalResult, error) {
+	return persistence.GoalResult{GoalID: "goal-a", AccountID: accountID, Name: name, Type: goalType, Value: value}, m.err
+}
+
+func (m *mockGoalsDatabase) DeleteGoal(accountID, goalID string) error {
+	return m.err
+}
+
+func TestRouter_goals(t *testing.T) {
+	accountUser := persistence.LoginResult{
+		AccountUserID: "user-a",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a", Role: persistence.AccountUserRoleAdmin},
+			{AccountID: "account-b", Role: persistence.AccountUserRoleViewer},
+		},
+	}
+	tests := []struct {
+		name           string
+		db             *mockGoalsDatabase
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"list", &mockGoalsDatabase{}, http.MethodGet, "/accounts/account-a/goals", "", http.StatusOK, `"goalId":"goal-a"`},
+		{"list viewer", &mockGoalsDatabase{}, http.MethodGet, "/accounts/account-b/goals", "", http.StatusOK, `"goalId":"goal-a"`},
+		{"list other account", &mockGoalsDatabase{}, http.MethodGet, "/accounts/account-z/goals", "", http.StatusForbidden, ""},
+		{"list error", &mockGoalsDatabase{err: errors.New("did not work")}, http.MethodGet, "/accounts/account-a/goals", "", http.StatusInternalServerError, ""},
+		{"create", &mockGoalsDatabase{}, http.MethodPost, "/accounts/account-a/goals", `{"name":"Signup","type":"event","value":"signup"}`, http.StatusCreated, `"name":"Signup","type":"event","value":"signup"`},
+		{"create viewer", &mockGoalsDatabase{}, http.MethodPost, "/accounts/account-b/goals", `{}`, http.StatusForbidden, ""},
+		{"create bad payload", &mockGoalsDatabase{}, http.MethodPost, "/accounts/account-a/goals", `{"name":`, http.StatusBadRequest, ""},
+		{"create invalid", &mockGoalsDatabase{err: errors.New("did not work")}, http.MethodPost, "/accounts/account-a/goals", `{}`, http.StatusBadRequest, ""},
+		{"delete", &mockGoalsDatabase{}, http.MethodDelete, "/accounts/account-a/goals/goal-a", "", http.StatusNoContent, ""},
+		{"delete unknown", &mockGoalsDatabase{err: persistence.ErrUnknownGoal}, http.MethodDelete, "/accounts/account-a/goals/goal-z", "", http.StatusNotFound, `"code":"unknown_goal"`},
+		{"delete viewer", &mockGoalsDatabase{}, http.MethodDelete, "/accounts/account-b/goals/goal-a", "", http.StatusForbidden, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: test.db}
+			m := gin.New()
+			m.Use(func(c *gin.Context) {
+				c.Set(contextKeyAuth, accountUser)
+			})
+			m.GET("/accounts/:accountID/goals", rt.getGoals)
+			m.POST("/accounts/:accountID/goals", rt.postGoal)
+			m.DELETE("/accounts/:accountID/goals/:goalID", rt.deleteGoal)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if !strings.Contains(w.Body.String(), test.expectedBody) {
+				t.Errorf("Unexpected body %v", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,435 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/graphql"
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/webhooks"
+	"github.com/oklog/ulid"
+)
+
+// graphQLAccount is the source value of the Account type.
+type graphQLAccount struct {
+	account persistence.LoginAccountResult
+	// events are looked up lazily and shared by all fields of the account
+	events map[string][]persistence.EventResult
+}
+
+var graphQLDayType = &graphql.Object{
+	Name: "Day",
+	Fields: graphql.Fields{
+		"date":   &graphql.Field{Type: &graphql.NonNull{OfType: graphql.String}},
+		"events": &graphql.Field{Type: &graphql.NonNull{OfType: graphql.Int}},
+		"users":  &graphql.Field{Type: &graphql.NonNull{OfType: graphql.Int}},
+	},
+}
+
+var graphQLMetricsType = &graphql.Object{
+	Name: "Metrics",
+	Fields: graphql.Fields{
+		"events":          &graphql.Field{Type: &graphql.NonNull{OfType: graphql.Int}},
+		"users":           &graphql.Field{Type: &graphql.NonNull{OfType: graphql.Int}},
+		"anonymousEvents": &graphql.Field{Type: &graphql.NonNull{OfType: graphql.Int}},
+		"days":            &graphql.Field{Type: &graphql.List{OfType: graphQLDayType}},
+	},
+}
+
+var graphQLCohortType = &graphql.Object{
+	Name: "Cohort",
+	Fields: graphql.Fields{
+		"start":    &graphql.Field{Type: &graphql.NonNull{OfType: graphql.String}},
+		"users":    &graphql.Field{Type: &graphql.NonNull{OfType: graphql.Int}},
+		"retained": &graphql.Field{Type: &graphql.NonNull{OfType: &graphql.List{OfType: &graphql.NonNull{OfType: graphql.Int}}}},
+		"rates":    &graphql.Field{Type: &graphql.NonNull{OfType: &graphql.List{OfType: &graphql.NonNull{OfType: graphql.Float}}}},
+	},
+}
+
+const maxGraphQLCohorts = 24
+
+var graphQLEventType = &graphql.Object{
+	Name: "Event",
+	Fields: graphql.Fields{
+		"id":       &graphql.Field{Type: &graphql.NonNull{OfType: graphql.ID}},
+		"time":     &graphql.Field{Type: &graphql.NonNull{OfType: graphql.String}},
+		"secretId": &graphql.Field{Type: graphql.String},
+		"payload":  &graphql.Field{Type: &graphql.NonNull{OfType: graphql.String}},
+	},
+}
+
+// graphQLSchema returns the schema used for handling GraphQL queries of
+// the given account user. Usage data is end-to-end encrypted, so metrics
+// are limited to what can be derived from event metadata. The payload of
+// events is returned encrypted and can only be queried by admins of an
+// account.
+func (rt *router) graphQLSchema(accountUser persistence.LoginResult) *graphql.Schema {
+	lookupEvents := func(ctx context.Context, source *graphQLAccount, since interface{}) ([]persistence.EventResult, error) {
+		var sinceID string
+		if s, ok := since.(string); ok {
+			sinceTime, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, fmt.Errorf("router: invalid value %s for since, expected RFC 3339 timestamp", s)
+			}
+			sinceID, err = persistence.EventIDAt(sinceTime)
+			if err != nil {
+				return nil, fmt.Errorf("router: error creating event id: %w", err)
+			}
+		}
+		if events, ok := source.events[sinceID]; ok {
+			return events, nil
+		}
+		result, err := rt.tracedDB(ctx).GetAccount(source.account.AccountID, true, sinceID)
+		if err != nil {
+			return nil, fmt.Errorf("router: error looking up account: %w", err)
+		}
+		var events []persistence.EventResult
+		if result.Events != nil {
+			events = (*result.Events)[source.account.AccountID]
+		}
+		source.events[sinceID] = events
+		return events, nil
+	}
+
+	accountType := &graphql.Object{
+		Name: "Account",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: &graphql.NonNull{OfType: graphql.ID},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*graphQLAccount).account.AccountID, nil
+				},
+			},
+			"name": &graphql.Field{
+				Type: &graphql.NonNull{OfType: graphql.String},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*graphQLAccount).account.AccountName, nil
+				},
+			},
+			"role": &graphql.Field{
+				Type: &graphql.NonNull{OfType: graphql.String},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if accountUser.CanManageAccount(p.Source.(*graphQLAccount).account.AccountID) {
+						return "admin", nil
+					}
+					return "viewer", nil
+				},
+			},
+			"created": &graphql.Field{
+				Type: &graphql.NonNull{OfType: graphql.String},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*graphQLAccount).account.Created.UTC().Format(time.RFC3339), nil
+				},
+			},
+			"metrics": &graphql.Field{
+				Type: graphQLMetricsType,
+				Args: map[string]*graphql.Argument{
+					"since": {Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					events, err := lookupEvents(p.Context, p.Source.(*graphQLAccount), p.Args["since"])
+					if err != nil {
+						return nil, err
+					}
+					return graphQLMetrics(events), nil
+				},
+			},
+			"retention": &graphql.Field{
+				Type: &graphql.List{OfType: &graphql.NonNull{OfType: graphQLCohortType}},
+				Args: map[string]*graphql.Argument{
+					"period":  {Type: graphql.String, DefaultValue: "week"},
+					"cohorts": {Type: graphql.Int, DefaultValue: 4},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					period := p.Args["period"].(string)
+					if period != "week" && period != "month" {
+						return nil, fmt.Errorf("router: invalid value %s for period, expected week or month", period)
+					}
+					cohorts := p.Args["cohorts"].(int)
+					if cohorts < 1 || cohorts > maxGraphQLCohorts {
+						return nil, fmt.Errorf("router: invalid value %d for cohorts, expected a number between 1 and %d", cohorts, maxGraphQLCohorts)
+					}
+					// the first visit of a user can only be determined when
+					// looking at all events
+					events, err := lookupEvents(p.Context, p.Source.(*graphQLAccount), nil)
+					if err != nil {
+						return nil, err
+					}
+					return graphQLRetention(events, period, cohorts, time.Now()), nil
+				},
+			},
+			"events": &graphql.Field{
+				Type: &graphql.List{OfType: &graphql.NonNull{OfType: graphQLEventType}},
+				Args: map[string]*graphql.Argument{
+					"since": {Type: graphql.String},
+					"limit": {Type: graphql.Int, DefaultValue: 100},
+				},
+				Authorize: func(p graphql.ResolveParams) error {
+					accountID := p.Source.(*graphQLAccount).account.AccountID
+					if !accountUser.CanManageAccount(accountID) {
+						return fmt.Errorf("router: account user is not allowed to query events of account %s", accountID)
+					}
+					return nil
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					events, err := lookupEvents(p.Context, p.Source.(*graphQLAccount), p.Args["since"])
+					if err != nil {
+						return nil, err
+					}
+					if limit := p.Args["limit"].(int); limit >= 0 && len(events) > limit {
+						events = events[:limit]
+					}
+					result := []interface{}{}
+					for _, event := range events {
+						eventTime, _ := graphQLEventTime(event.EventID)
+						value := map[string]interface{}{
+							"id":       event.EventID,
+							"time":     eventTime.Format(time.RFC3339),
+							"secretId": nil,
+							"payload":  event.Payload,
+						}
+						if event.SecretID != nil {
+							value["secretId"] = *event.SecretID
+						}
+						result = append(result, value)
+					}
+					return result, nil
+				},
+			},
+		},
+	}
+
+	return &graphql.Schema{
+		Query: &graphql.Object{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"accounts": &graphql.Field{
+					Type: &graphql.NonNull{OfType: &graphql.List{OfType: &graphql.NonNull{OfType: accountType}}},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						result := []interface{}{}
+						for _, account := range accountUser.Accounts {
+							result = append(result, &graphQLAccount{account: account, events: map[string][]persistence.EventResult{}})
+						}
+						return result, nil
+					},
+				},
+				"account": &graphql.Field{
+					Type: accountType,
+					Args: map[string]*graphql.Argument{
+						"id": {Type: &graphql.NonNull{OfType: graphql.ID}},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						accountID := p.Args["id"].(string)
+						for _, account := range accountUser.Accounts {
+							if account.AccountID == accountID {
+								return &graphQLAccount{account: account, events: map[string][]persistence.EventResult{}}, nil
+							}
+						}
+						return nil, fmt.Errorf("router: account user does not have permissions to access account %s", accountID)
+					},
+				},
+			},
+		},
+	}
+}
+
+func graphQLEventTime(eventID string) (time.Time, error) {
+	id, err := ulid.Parse(eventID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("router: error parsing event id %s: %w", eventID, err)
+	}
+	return ulid.Time(id.Time()).UTC(), nil
+}
+
+// graphQLMetrics aggregates the given events into the value of the Metrics
+// type. Events without a secret id have been collected anonymously and are
+// not counted as users.
+func graphQLMetrics(events []persistence.EventResult) map[string]interface{} {
+	users := map[string]bool{}
+	anonymous := 0
+	type day struct {
+		events int
+		users  map[string]bool
+	}
+	days := map[string]*day{}
+	for _, event := range events {
+		eventTime, err := graphQLEventTime(event.EventID)
+		if err != nil {
+			continue
+		}
+		date := eventTime.Format("2006-01-02")
+		d, ok := days[date]
+		if !ok {
+			d = &day{users: map[string]bool{}}
+			days[date] = d
+		}
+		d.events++
+		if event.SecretID == nil {
+			anonymous++
+			continue
+		}
+		users[*event.SecretID] = true
+		d.users[*event.SecretID] = true
+	}
+
+	var dates []string
+	for date := range days {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	dayValues := []interface{}{}
+	for _, date := range dates {
+		dayValues = append(dayValues, map[string]interface{}{
+			"date":   date,
+			"events": days[date].events,
+			"users":  len(days[date].users),
+		})
+	}
+	return map[string]interface{}{
+		"events":          len(events),
+		"users":           len(users),
+		"anonymousEvents": anonymous,
+		"days":            dayValues,
+	}
+}
+
+// graphQLPeriod returns the beginning of the week or month the given time
+// is in and a function for advancing it by the given number of periods.
+func graphQLPeriod(t time.Time, period string) (time.Time, func(time.Time, int) time.Time) {
+	t = t.UTC()
+	if period == "month" {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), func(start time.Time, n int) time.Time {
+			return start.AddDate(0, n, 0)
+		}
+	}
+	return webhooks.StartOfWeek(t), func(start time.Time, n int) time.Time {
+		return start.AddDate(0, 0, 7*n)
+	}
+}
+
+// graphQLRetention groups users into cohorts by the period of their first
+// event and counts how many of them have returned in each of the following
+// periods up to the one the given time is in. The given number of cohorts
+// is returned, the oldest first. Anonymous events cannot be attributed to a
+// user and are skipped.
+func graphQLRetention(events []persistence.EventResult, period string, cohorts int, now time.Time) []interface{} {
+	current, advance := graphQLPeriod(now, period)
+	firstSeen := map[string]time.Time{}
+	active := map[string]map[time.Time]bool{}
+	for _, event := range events {
+		if event.SecretID == nil {
+			continue
+		}
+		eventTime, err := graphQLEventTime(event.EventID)
+		if err != nil {
+			continue
+		}
+		start, _ := graphQLPeriod(eventTime, period)
+		secretID := *event.SecretID
+		if first, ok := firstSeen[secretID]; !ok || start.Before(first) {
+			firstSeen[secretID] = start
+		}
+		if active[secretID] == nil {
+			active[secretID] = map[time.Time]bool{}
+		}
+		active[secretID][start] = true
+	}
+
+	result := []interface{}{}
+	for i := cohorts - 1; i >= 0; i-- {
+		start := advance(current, -i)
+		var members []string
+		for secretID, first := range firstSeen {
+			if first.Equal(start) {
+				members = append(members, secretID)
+			}
+		}
+		retained := []int{}
+		rates := []float64{}
+		for n := 0; !advance(start, n).After(current); n++ {
+			count := 0
+			for _, secretID := range members {
+				if active[secretID][advance(start, n)] {
+					count++
+				}
+			}
+			retained = append(retained, count)
+			rate := 0.0
+			if len(members) != 0 {
+				rate = float64(count) / float64(len(members))
+			}
+			rates = append(rates, rate)
+		}
+		result = append(result, map[string]interface{}{
+			"start":    start.Format("2006-01-02"),
+			"users":    len(members),
+			"retained": retained,
+			"rates":    rates,
+		})
+	}
+	return result
+}
+
+func (rt *router) getGraphQL(c *gin.Context) {
+	req := graphql.Request{
+		Query:         c.Query("query"),
+		OperationName: c.Query("operationName"),
+	}
+	if variables := c.Query("variables"); variables != "" {
+		if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+			newJSONError(
+				fmt.Errorf("router: error decoding variables: %w", err),
+				http.StatusBadRequest,
+			).Pipe(c)
+			return
+		}
+	}
+	rt.handleGraphQL(c, req)
+}
+
+func (rt *router) postGraphQL(c *gin.Context) {
+	var req graphql.Request
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	rt.handleGraphQL(c, req)
+}
+
+func (rt *router) handleGraphQL(c *gin.Context, req graphql.Request) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: could not find account user object in request context"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+	if l := <-rt.getLimiter().LinearThrottle(time.Second/2, fmt.Sprintf("graphQL-%s", accountUser.AccountUserID)); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error rate limiting request: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
+	result := rt.graphQLSchema(accountUser).Execute(c.Request.Context(), req)
+	if result.Data == nil {
+		// the request could not be executed at all
+		c.JSON(http.StatusBadRequest, result)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,204 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockGraphQLDatabase struct {
+	persistence.Service
+	err    error
+	events []persistence.EventResult
+}
+
+func (m *mockGraphQLDatabase) GetAccount(accountID string, includeEvents bool, since string) (persistence.AccountResult, error) {
+	if m.err != nil {
+		return persistence.AccountResult{}, m.err
+	}
+	return persistence.AccountResult{
+		AccountID: accountID,
+		Events:    &persistence.EventsByAccountID{accountID: m.events},
+	}, nil
+}
+
+func mustEventID(t time.Time) string {
+	eventID, err := persistence.EventIDAt(t)
+	if err != nil {
+		panic(err)
+	}
+	return eventID
+}
+
+func TestRouter_graphQL(t *testing.T) {
+	secretA, secretB := "secret-a", "secret-b"
+	dayA := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	dayB := time.Date(2020, 6, 2, 12, 0, 0, 0, time.UTC)
+	events := []persistence.EventResult{
+		{EventID: mustEventID(dayA), SecretID: &secretA, Payload: "payload-a"},
+		{EventID: mustEventID(dayA.Add(time.Minute)), SecretID: &secretA, Payload: "payload-b"},
+		{EventID: mustEventID(dayB), SecretID: &secretB, Payload: "payload-c"},
+		{EventID: mustEventID(dayB.Add(time.Minute)), Payload: "payload-d"},
+	}
+	accountUser := persistence.LoginResult{
+		AccountUserID: "user-a",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a", AccountName: "a", Role: persistence.AccountUserRoleAdmin},
+			{AccountID: "account-b", AccountName: "b", Role: persistence.AccountUserRoleViewer},
+		},
+	}
+	tests := []struct {
+		name               string
+		db                 mockGraphQLDatabase
+		method             string
+		query              string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			"accounts",
+			mockGraphQLDatabase{},
+			http.MethodPost,
+			`{"query":"{ accounts { id name role } }"}`,
+			http.StatusOK,
+			`{"data":{"accounts":[{"id":"account-a","name":"a","role":"admin"},{"id":"account-b","name":"b","role":"viewer"}]}}`,
+		},
+		{
+			"metrics",
+			mockGraphQLDatabase{events: events},
+			http.MethodPost,
+			`{"query":"query ($id: ID!) { account(id: $id) { metrics { events users anonymousEvents days { date events users } } } }","variables":{"id":"account-b"}}`,
+			http.StatusOK,
+			`{"data":{"account":{"metrics":{"events":4,"users":2,"anonymousEvents":1,"days":[{"date":"2020-06-01","events":2,"users":1},{"date":"2020-06-02","events":2,"users":1}]}}}}`,
+		},
+		{
+			"events",
+			mockGraphQLDatabase{events: events},
+			http.MethodGet,
+			`{ account(id: "account-a") { events(limit: 1) { time secretId payload } } }`,
+			http.StatusOK,
+			`{"data":{"account":{"events":[{"time":"2020-06-01T12:00:00Z","secretId":"secret-a","payload":"payload-a"}]}}}`,
+		},
+		{
+			"events of viewer",
+			mockGraphQLDatabase{events: events},
+			http.MethodPost,
+			`{"query":"{ account(id: \"account-b\") { id events { id } } }"}`,
+			http.StatusOK,
+			`{"data":{"account":{"id":"account-b","events":null}},"errors":[{"message":"router: account user is not allowed to query events of account account-b","path":["account","events"]}]}`,
+		},
+		{
+			"retention with invalid period",
+			mockGraphQLDatabase{events: events},
+			http.MethodPost,
+			`{"query":"{ account(id: \"account-a\") { retention(period: \"day\") { start } } }"}`,
+			http.StatusOK,
+			`{"data":{"account":{"retention":null}},"errors":[{"message":"router: invalid value day for period, expected week or month","path":["account","retention"]}]}`,
+		},
+		{
+			"unknown account",
+			mockGraphQLDatabase{},
+			http.MethodPost,
+			`{"query":"{ account(id: \"account-z\") { id } }"}`,
+			http.StatusOK,
+			`{"data":{"account":null},"errors":[{"message":"router: account user does not have permissions to access account account-z","path":["account"]}]}`,
+		},
+		{
+			"database error",
+			mockGraphQLDatabase{err: errors.New("did not work")},
+			http.MethodPost,
+			`{"query":"{ accounts { metrics { events } } }"}`,
+			http.StatusOK,
+			`{"data":{"accounts":[{"metrics":null},{"metrics":null}]},"errors":[{"message":"router: error looking up account: did not work","path":["accounts",0,"metrics"]},{"message":"router: error looking up account: did not work","path":["accounts",1,"metrics"]}]}`,
+		},
+		{
+			"invalid query",
+			mockGraphQLDatabase{},
+			http.MethodPost,
+			`{"query":"{ accounts { secret } }"}`,
+			http.StatusBadRequest,
+			`{"errors":[{"message":"graphql: cannot query field secret on type Account","path":["accounts","secret"]}]}`,
+		},
+		{
+			"bad payload",
+			mockGraphQLDatabase{},
+			http.MethodPost,
+			`{"query":`,
+			http.StatusBadRequest,
+			"",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: &test.db}
+			m := gin.New()
+			setUser := func(c *gin.Context) {
+				c.Set(contextKeyAuth, accountUser)
+			}
+			m.GET("/", setUser, rt.getGraphQL)
+			m.POST("/", setUser, rt.postGraphQL)
+
+			var r *http.Request
+			if test.method == http.MethodGet {
+				r = httptest.NewRequest(test.method, "/?query="+url.QueryEscape(test.query), nil)
+			} else {
+				r = httptest.NewRequest(test.method, "/", strings.NewReader(test.query))
+			}
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if test.expectedBody != "" && w.Body.String() != test.expectedBody {
+				t.Errorf("Unexpected body %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestGraphQLRetention(t *testing.T) {
+	secretA, secretB, secretC := "secret-a", "secret-b", "secret-c"
+	// 2020-06-01 is a Monday
+	weekA := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	weekB := weekA.AddDate(0, 0, 7)
+	weekC := weekA.AddDate(0, 0, 14)
+	events := []persistence.EventResult{
+		{EventID: mustEventID(weekA.AddDate(0, 0, -7)), Payload: "anonymous"},
+		{EventID: mustEventID(weekA), SecretID: &secretA},
+		{EventID: mustEventID(weekA.Add(time.Hour)), SecretID: &secretB},
+		{EventID: mustEventID(weekB), SecretID: &secretA},
+		{EventID: mustEventID(weekB.Add(time.Hour)), SecretID: &secretC},
+		{EventID: mustEventID(weekC), SecretID: &secretB},
+		{EventID: mustEventID(weekC.Add(time.Hour)), SecretID: &secretC},
+		{EventID: "not-a-ulid", SecretID: &secretC},
+	}
+
+	result := graphQLRetention(events, "week", 3, weekC.Add(time.Hour*24))
+	expected := []interface{}{
+		map[string]interface{}{"start": "2020-06-01", "users": 2, "retained": []int{2, 1, 1}, "rates": []float64{1, 0.5, 0.5}},
+		map[string]interface{}{"start": "2020-06-08", "users": 1, "retained": []int{1, 1}, "rates": []float64{1, 1}},
+		map[string]interface{}{"start": "2020-06-15", "users": 0, "retained": []int{0}, "rates": []float64{0}},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+
+	result = graphQLRetention(events, "month", 1, weekC)
+	expected = []interface{}{
+		map[string]interface{}{"start": "2020-06-01", "users": 3, "retained": []int{3}, "rates": []float64{1}},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/config"
+)
+
+const (
+	defaultCSP        = "default-src 'self'; style-src 'self' 'unsafe-inline'"
+	defaultHSTSMaxAge = time.Hour * 4380
+	// responses of the API are never rendered, so they do not need to load
+	// any resources at all
+	apiCSP = "default-src 'none'"
+)
+
+// securityPolicy contains the values of security related headers that are
+// sent with the responses of a group of routes.
+type securityPolicy struct {
+	contentSecurityPolicy string
+	frameAncestors        []string
+	strictTransport       string
+}
+
+func (s securityPolicy) csp() string {
+	directives := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s.contentSecurityPolicy), ";"))
+	if len(s.frameAncestors) == 0 || strings.Contains(directives, "frame-ancestors") {
+		return directives
+	}
+	frameAncestors := "frame-ancestors " + strings.Join(s.frameAncestors, " ")
+	if directives == "" {
+		return frameAncestors
+	}
+	return directives + "; " + frameAncestors
+}
+
+// frameOptions returns the value of the X-Frame-Options header for browsers
+// that do not support frame-ancestors. The header cannot express a list of
+// origins, so it is only set when framing is restricted to the same origin
+// or disabled entirely.
+func (s securityPolicy) frameOptions() string {
+	if len(s.frameAncestors) != 1 {
+		return ""
+	}
+	switch s.frameAncestors[0] {
+	case "'none'":
+		return "DENY"
+	case "'self'":
+		return "SAMEORIGIN"
+	}
+	return ""
+}
+
+// apply sets the headers defined by the policy. Strict-Transport-Security
+// is only sent when the request has been made in a secure context.
+func (s securityPolicy) apply(header http.Header, secureContext bool) {
+	if csp := s.csp(); csp != "" {
+		header.Set("Content-Security-Policy", csp)
+	}
+	if frameOptions := s.frameOptions(); frameOptions != "" {
+		header.Set("X-Frame-Options", frameOptions)
+	}
+	header.Set("X-Content-Type-Options", "nosniff")
+	if secureContext && s.strictTransport != "" {
+		header.Set("Strict-Transport-Security", s.strictTransport)
+	}
+}
+
+// securityHeaders contains the policies for all groups of routes. The vault
+// is embedded on the sites using Offen, so it has different requirements
+// than the Auditorium and the rest of the operator UI.
+type securityHeaders struct {
+	ui    securityPolicy
+	vault securityPolicy
+	api   securityPolicy
+}
+
+func newSecurityHeaders(cfg *config.Config) securityHeaders {
+	if cfg == nil {
+		sts := strictTransportValue(defaultHSTSMaxAge, false, false)
+		return securityHeaders{
+			ui:    securityPolicy{defaultCSP, []string{"'self'"}, sts},
+			vault: securityPolicy{defaultCSP, []string{"*"}, sts},
+			api:   securityPolicy{apiCSP, []string{"'none'"}, sts},
+		}
+	}
+	sts := strictTransportValue(cfg.Headers.HSTSMaxAge, cfg.Headers.HSTSIncludeSubdomains, cfg.Headers.HSTSPreload)
+	return securityHeaders{
+		ui:    securityPolicy{cfg.Headers.ContentSecurityPolicy, cfg.Headers.FrameAncestors, sts},
+		vault: securityPolicy{cfg.Headers.VaultContentSecurityPolicy, cfg.Headers.VaultFrameAncestors, sts},
+		api:   securityPolicy{apiCSP, []string{"'none'"}, sts},
+	}
+}
+
+// forPath returns the policy for the static asset or page at the given path.
+func (s securityHeaders) forPath(p string) securityPolicy {
+	if p == "/vault" || strings.HasPrefix(p, "/vault/") {
+		return s.vault
+	}
+	return s.ui
+}
+
+func strictTransportValue(maxAge time.Duration, includeSubdomains, preload bool) string {
+	if maxAge <= 0 {
+		return ""
+	}
+	value := fmt.Sprintf("max-age=%d", int64(maxAge/time.Second))
+	if includeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if preload {
+		value += "; preload"
+	}
+	return value
+}
+
+func securityHeadersMiddleware(policy securityPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy.apply(c.Writer.Header(), c.GetBool(contextKeySecureContext))
+		c.Next()
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/config"
+)
+
+func TestSecurityPolicy_Apply(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        securityPolicy
+		secureContext bool
+		expected      map[string]string
+	}{
+		{
+			"defaults",
+			newSecurityHeaders(nil).ui,
+			true,
+			map[string]string{
+				"Content-Security-Policy":   "default-src 'self'; style-src 'self' 'unsafe-inline'; frame-ancestors 'self'",
+				"X-Frame-Options":           "SAMEORIGIN",
+				"X-Content-Type-Options":    "nosniff",
+				"Strict-Transport-Security": "max-age=15768000",
+			},
+		},
+		{
+			"vault defaults",
+			newSecurityHeaders(nil).vault,
+			true,
+			map[string]string{
+				"Content-Security-Policy":   "default-src 'self'; style-src 'self' 'unsafe-inline'; frame-ancestors *",
+				"X-Frame-Options":           "",
+				"X-Content-Type-Options":    "nosniff",
+				"Strict-Transport-Security": "max-age=15768000",
+			},
+		},
+		{
+			"insecure context",
+			securityPolicy{"default-src 'none';", []string{"'none'"}, "max-age=60"},
+			false,
+			map[string]string{
+				"Content-Security-Policy":   "default-src 'none'; frame-ancestors 'none'",
+				"X-Frame-Options":           "DENY",
+				"Strict-Transport-Security": "",
+			},
+		},
+		{
+			"explicit frame ancestors",
+			securityPolicy{"default-src 'self'; frame-ancestors https://www.offen.dev", []string{"'self'"}, ""},
+			true,
+			map[string]string{
+				"Content-Security-Policy":   "default-src 'self'; frame-ancestors https://www.offen.dev",
+				"Strict-Transport-Security": "",
+			},
+		},
+		{
+			"frame ancestors only",
+			securityPolicy{"", []string{"https://www.offen.dev", "https://shop.offen.dev"}, ""},
+			true,
+			map[string]string{
+				"Content-Security-Policy": "frame-ancestors https://www.offen.dev https://shop.offen.dev",
+				"X-Frame-Options":         "",
+			},
+		},
+		{
+			"empty",
+			securityPolicy{},
+			true,
+			map[string]string{
+				"Content-Security-Policy": "",
+				"X-Frame-Options":         "",
+				"X-Content-Type-Options":  "nosniff",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			test.policy.apply(header, test.secureContext)
+			for key, value := range test.expected {
+				if header.Get(key) != value {
+					t.Errorf("Expected %s to be %q, got %q", key, value, header.Get(key))
+				}
+			}
+		})
+	}
+}
+
+func TestNewSecurityHeaders(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Headers.ContentSecurityPolicy = "default-src 'self'"
+	cfg.Headers.VaultContentSecurityPolicy = "default-src 'self'; script-src 'self'"
+	cfg.Headers.FrameAncestors = []string{"'none'"}
+	cfg.Headers.VaultFrameAncestors = []string{"https://www.offen.dev"}
+	cfg.Headers.HSTSMaxAge = time.Hour * 24 * 365
+	cfg.Headers.HSTSIncludeSubdomains = true
+	cfg.Headers.HSTSPreload = true
+
+	headers := newSecurityHeaders(cfg)
+	if csp := headers.forPath("/auditorium/").csp(); csp != "default-src 'self'; frame-ancestors 'none'" {
+		t.Errorf("Unexpected ui CSP %v", csp)
+	}
+	if csp := headers.forPath("/vault/").csp(); csp != "default-src 'self'; script-src 'self'; frame-ancestors https://www.offen.dev" {
+		t.Errorf("Unexpected vault CSP %v", csp)
+	}
+	if csp := headers.forPath("/vaultkeeper/").csp(); csp != "default-src 'self'; frame-ancestors 'none'" {
+		t.Errorf("Unexpected CSP for path with vault prefix %v", csp)
+	}
+	if csp := headers.api.csp(); csp != "default-src 'none'; frame-ancestors 'none'" {
+		t.Errorf("Unexpected api CSP %v", csp)
+	}
+	if sts := headers.vault.strictTransport; sts != "max-age=31536000; includeSubDomains; preload" {
+		t.Errorf("Unexpected HSTS value %v", sts)
+	}
+
+	cfg.Headers.HSTSMaxAge = 0
+	if sts := newSecurityHeaders(cfg).ui.strictTransport; sts != "" {
+		t.Errorf("Expected HSTS to be disabled, got %v", sts)
+	}
+}
+
+func TestSecurityHeadersMiddleware(t *testing.T) {
+	m := gin.New()
+	m.GET("/", func(c *gin.Context) {
+		c.Set(contextKeySecureContext, true)
+	}, securityHeadersMiddleware(newSecurityHeaders(nil).api), func(c *gin.Context) {
+		c.JSON(http.StatusOK, map[string]bool{"ok": true})
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	m.ServeHTTP(w, r)
+
+	if csp := w.Header().Get("Content-Security-Policy"); csp != "default-src 'none'; frame-ancestors 'none'" {
+		t.Errorf("Unexpected CSP %v", csp)
+	}
+	if sts := w.Header().Get("Strict-Transport-Security"); sts != "max-age=15768000" {
+		t.Errorf("Unexpected HSTS value %v", sts)
+	}
+}
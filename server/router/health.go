@@ -4,19 +4,69 @@
 package router
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
+// getHealth signals the process is up and able to handle requests. It does
+// not check any dependencies so that orchestrators do not restart the
+// application when e.g. the database is unavailable for a short time.
 func (rt *router) getHealth(c *gin.Context) {
-	if err := rt.db.CheckHealth(); err != nil {
-		newJSONError(
-			fmt.Errorf("router: failed checking health of connected persistence layer: %v", err),
-			http.StatusBadGateway,
-		).Pipe(c)
-		return
-	}
 	c.JSON(http.StatusOK, map[string]bool{"ok": true})
 }
+
+type dependencyCheck struct {
+	OK       bool   `json:"ok"`
+	Optional bool   `json:"optional,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type readinessResponse struct {
+	OK     bool                       `json:"ok"`
+	Checks map[string]dependencyCheck `json:"checks"`
+}
+
+// getReadiness checks whether all dependencies of the application are
+// available. Failing optional dependencies are reported but do not cause
+// the instance to be considered not ready. Errors of required dependencies
+// are logged instead of being returned as they might contain details about
+// the infrastructure.
+func (rt *router) getReadiness(c *gin.Context) {
+	response := readinessResponse{OK: true, Checks: map[string]dependencyCheck{}}
+	check := func(name string, optional bool, err error, message string) {
+		if err == nil {
+			response.Checks[name] = dependencyCheck{OK: true, Optional: optional}
+			return
+		}
+		response.Checks[name] = dependencyCheck{Optional: optional, Error: message}
+		if !optional {
+			rt.logError(c, err, message)
+			response.OK = false
+		}
+	}
+
+	dbErr := rt.db.CheckHealth()
+	check("database", false, dbErr, "database is not reachable")
+	if dbErr == nil {
+		check("migrations", false, rt.db.CheckMigrations(), "database migrations have not been applied")
+	} else {
+		response.Checks["migrations"] = dependencyCheck{Error: "database is not reachable"}
+	}
+
+	switch {
+	case rt.mailer == nil:
+		check("mailer", true, errors.New("router: no mailer"), "no mailer configured")
+	case rt.config != nil && !rt.config.SMTPConfigured() && !rt.config.App.Development:
+		check("mailer", true, errors.New("router: no smtp server"), "no SMTP server configured, using sendmail")
+	default:
+		check("mailer", true, nil, "")
+	}
+
+	status := http.StatusOK
+	if !response.OK {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, response)
+}
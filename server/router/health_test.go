@@ -4,51 +4,140 @@
 package router
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/mailer"
 	"github.com/offen/offen/server/persistence"
 )
 
 type mockHealthChecker struct {
 	persistence.Service
-	err error
+	err           error
+	migrationsErr error
 }
 
 func (m *mockHealthChecker) CheckHealth() error {
 	return m.err
 }
 
+func (m *mockHealthChecker) CheckMigrations() error {
+	return m.migrationsErr
+}
+
 func TestRouter_getHealth(t *testing.T) {
-	t.Run("ok", func(t *testing.T) {
-		rt := router{
-			db: &mockHealthChecker{},
-		}
-		m := gin.New()
-		m.GET("/", rt.getHealth)
-		w := httptest.NewRecorder()
-		r := httptest.NewRequest(http.MethodGet, "/", nil)
-		m.ServeHTTP(w, r)
-		if w.Code != http.StatusOK {
-			t.Errorf("Unexpected status code %v", w.Code)
-		}
-	})
-	t.Run("ping error", func(t *testing.T) {
-		rt := router{
-			db: &mockHealthChecker{
-				err: errors.New("did not work"),
+	rt := router{
+		db: &mockHealthChecker{
+			err: errors.New("did not work"),
+		},
+	}
+	m := gin.New()
+	m.GET("/", rt.getHealth)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	m.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Unexpected status code %v", w.Code)
+	}
+}
+
+func TestRouter_getReadiness(t *testing.T) {
+	tests := []struct {
+		name               string
+		db                 persistence.Service
+		mailer             mailer.Mailer
+		smtpHost           string
+		expectedStatusCode int
+		expectedResponse   readinessResponse
+	}{
+		{
+			"ok",
+			&mockHealthChecker{},
+			&mockMailer{},
+			"smtp.offen.dev",
+			http.StatusOK,
+			readinessResponse{
+				OK: true,
+				Checks: map[string]dependencyCheck{
+					"database":   {OK: true},
+					"migrations": {OK: true},
+					"mailer":     {OK: true, Optional: true},
+				},
+			},
+		},
+		{
+			"sendmail",
+			&mockHealthChecker{},
+			&mockMailer{},
+			"",
+			http.StatusOK,
+			readinessResponse{
+				OK: true,
+				Checks: map[string]dependencyCheck{
+					"database":   {OK: true},
+					"migrations": {OK: true},
+					"mailer":     {Optional: true, Error: "no SMTP server configured, using sendmail"},
+				},
+			},
+		},
+		{
+			"pending migrations",
+			&mockHealthChecker{migrationsErr: errors.New("did not work")},
+			&mockMailer{},
+			"smtp.offen.dev",
+			http.StatusServiceUnavailable,
+			readinessResponse{
+				Checks: map[string]dependencyCheck{
+					"database":   {OK: true},
+					"migrations": {Error: "database migrations have not been applied"},
+					"mailer":     {OK: true, Optional: true},
+				},
+			},
+		},
+		{
+			"database error",
+			&mockHealthChecker{err: errors.New("did not work")},
+			nil,
+			"",
+			http.StatusServiceUnavailable,
+			readinessResponse{
+				Checks: map[string]dependencyCheck{
+					"database":   {Error: "database is not reachable"},
+					"migrations": {Error: "database is not reachable"},
+					"mailer":     {Optional: true, Error: "no mailer configured"},
+				},
 			},
-		}
-		m := gin.New()
-		m.GET("/", rt.getHealth)
-		w := httptest.NewRecorder()
-		r := httptest.NewRequest(http.MethodGet, "/", nil)
-		m.ServeHTTP(w, r)
-		if w.Code != http.StatusBadGateway {
-			t.Errorf("Unexpected status code %v", w.Code)
-		}
-	})
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{
+				db:     test.db,
+				mailer: test.mailer,
+				config: &config.Config{},
+			}
+			rt.config.SMTP.Host = test.smtpHost
+			m := gin.New()
+			m.GET("/", rt.getReadiness)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			var response readinessResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(response, test.expectedResponse) {
+				t.Errorf("Expected %v, got %v", test.expectedResponse, response)
+			}
+		})
+	}
 }
@@ -0,0 +1,32 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hintsResponse contains information about the client that is only known to
+// the server. Clients add hints to event payloads before encrypting them, so
+// the server never stores them.
+type hintsResponse struct {
+	Country string `json:"country,omitempty"`
+}
+
+func (rt *router) getHints(c *gin.Context) {
+	var response hintsResponse
+	if rt.geoIP != nil {
+		// the IP is only used for the lookup and is neither logged nor
+		// stored
+		country, err := rt.geoIP.Country(net.ParseIP(rt.clientIP(c)))
+		if err != nil {
+			rt.logError(c, err, "error looking up country")
+		}
+		response.Country = country
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,27 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRouter_getHints(t *testing.T) {
+	rt := router{}
+	m := gin.New()
+	m.GET("/", rt.getHints)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Unexpected status code %v", w.Code)
+	}
+	if w.Body.String() != "{}" {
+		t.Errorf("Unexpected body %s", w.Body.String())
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+	"github.com/oklog/ulid"
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	idempotencyKeyTTL    = time.Hour * 24
+	maxIdempotencyKeyLen = 255
+	// events queued by clients while being offline are submitted with the
+	// time they have been recorded, but events cannot be backdated any
+	// further than this
+	maxEventIDAge  = time.Hour * 24 * 7
+	maxEventIDSkew = time.Minute * 5
+)
+
+func (rt *router) getIdempotencyKeys() *cache.Cache {
+	if rt.idempotencyKeys == nil {
+		rt.idempotencyKeys = cache.New(idempotencyKeyTTL, time.Hour)
+	}
+	return rt.idempotencyKeys
+}
+
+// validateEventID checks whether the given client generated event id is a
+// ULID that has been created within the accepted time window and returns
+// its canonical representation.
+func validateEventID(eventID string, now time.Time) (string, error) {
+	id, err := ulid.ParseStrict(eventID)
+	if err != nil {
+		return "", fmt.Errorf("router: event id %s is not a valid ULID: %w", eventID, err)
+	}
+	created := ulid.Time(id.Time())
+	if created.Before(now.Add(-maxEventIDAge)) || created.After(now.Add(maxEventIDSkew)) {
+		return "", fmt.Errorf("router: event id %s has been created outside of the accepted time window", eventID)
+	}
+	return id.String(), nil
+}
+
+// eventIDs returns the ids the given events are to be inserted with. Ids
+// passed by the client are used as is. In case the request carries an
+// Idempotency-Key header, ids for all other events are created once per key
+// and reused when the request is retried. Persisting an event with an id
+// that exists already is a no-op, so retries do not create duplicate
+// events. Empty ids are returned when neither is given.
+func (rt *router) eventIDs(userID, idempotencyKey string, events []inboundEventPayload) ([]string, error) {
+	now := time.Now()
+	result := make([]string, len(events))
+	missing := 0
+	for i, evt := range events {
+		if evt.EventID == "" {
+			missing++
+			continue
+		}
+		id, err := validateEventID(evt.EventID, now)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = id
+	}
+	if idempotencyKey == "" || missing == 0 {
+		return result, nil
+	}
+	if len(idempotencyKey) > maxIdempotencyKeyLen {
+		return nil, fmt.Errorf("router: idempotency key exceeds maximum length of %d", maxIdempotencyKeyLen)
+	}
+
+	// keys are scoped to the user so that keys chosen by different users
+	// cannot collide
+	cacheKey := fmt.Sprintf("%s-%d-%s", userID, len(events), idempotencyKey)
+	var generated []string
+	if value, ok := rt.getIdempotencyKeys().Get(cacheKey); ok {
+		generated = value.([]string)
+	} else {
+		for i := 0; i < missing; i++ {
+			id, err := persistence.NewULID()
+			if err != nil {
+				return nil, fmt.Errorf("router: error creating event id: %w", err)
+			}
+			generated = append(generated, id)
+		}
+		rt.getIdempotencyKeys().Set(cacheKey, generated, cache.DefaultExpiration)
+	}
+	for i := range result {
+		if result[i] == "" {
+			result[i], generated = generated[0], generated[1:]
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestValidateEventID(t *testing.T) {
+	now := time.Now()
+	mustID := func(t time.Time) string {
+		id, err := persistence.EventIDAt(t)
+		if err != nil {
+			panic(err)
+		}
+		return id
+	}
+	recent := mustID(now.Add(-time.Hour))
+	tests := []struct {
+		name           string
+		eventID        string
+		expectedResult string
+		expectError    bool
+	}{
+		{"ok", recent, recent, false},
+		{"lowercase", strings.ToLower(recent), recent, false},
+		{"offline", mustID(now.Add(-time.Hour * 24 * 6)), "", false},
+		{"too old", mustID(now.Add(-time.Hour * 24 * 8)), "", true},
+		{"future", mustID(now.Add(time.Hour)), "", true},
+		{"malformed", "event-a", "", true},
+		{"empty", "", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := validateEventID(test.eventID, now)
+			if test.expectError != (err != nil) {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if test.expectedResult != "" && result != test.expectedResult {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestRouter_eventIDs(t *testing.T) {
+	clientID, _ := persistence.NewULID()
+	events := []inboundEventPayload{
+		{AccountID: "account-a"},
+		{AccountID: "account-a", EventID: clientID},
+		{AccountID: "account-b"},
+	}
+
+	t.Run("no key", func(t *testing.T) {
+		rt := router{}
+		result, err := rt.eventIDs("user-a", "", events)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if !reflect.DeepEqual([]string{"", clientID, ""}, result) {
+			t.Errorf("Unexpected result %v", result)
+		}
+	})
+	t.Run("retry", func(t *testing.T) {
+		rt := router{}
+		first, err := rt.eventIDs("user-a", "key-a", events)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if first[0] == "" || first[2] == "" || first[0] == first[2] || first[1] != clientID {
+			t.Errorf("Unexpected result %v", first)
+		}
+		second, _ := rt.eventIDs("user-a", "key-a", events)
+		if !reflect.DeepEqual(first, second) {
+			t.Errorf("Expected retry to yield %v, got %v", first, second)
+		}
+		other, _ := rt.eventIDs("user-b", "key-a", events)
+		if reflect.DeepEqual(first, other) {
+			t.Error("Expected keys of different users not to collide")
+		}
+		otherKey, _ := rt.eventIDs("user-a", "key-b", events)
+		if reflect.DeepEqual(first, otherKey) {
+			t.Error("Expected different keys to yield different ids")
+		}
+	})
+	t.Run("bad client id", func(t *testing.T) {
+		rt := router{}
+		if _, err := rt.eventIDs("user-a", "key-a", []inboundEventPayload{{EventID: "event-a"}}); err == nil {
+			t.Error("Expected error")
+		}
+	})
+	t.Run("key too long", func(t *testing.T) {
+		rt := router{}
+		if _, err := rt.eventIDs("user-a", strings.Repeat("a", 256), events); err == nil {
+			t.Error("Expected error")
+		}
+	})
+}
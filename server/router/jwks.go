@@ -0,0 +1,27 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (rt *router) getJWKS(c *gin.Context) {
+	set, err := rt.signingKeys.JWKS()
+	if err != nil {
+		rt.logError(c, err, "error creating key set")
+		newJSONError(
+			fmt.Errorf("router: error creating key set: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	// keys of the previous period stay in the set for the grace period,
+	// so caching for a short duration is safe
+	c.Header("Cache-Control", "public, max-age=300")
+	c.Data(http.StatusOK, "application/json", set)
+}
@@ -0,0 +1,39 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRouter_getJWKS(t *testing.T) {
+	rt := router{
+		signingKeys: mustSigningKeyring("secret"),
+	}
+	m := gin.New()
+	m.GET("/", rt.getJWKS)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Unexpected status code %v", w.Code)
+	}
+	var set struct {
+		Keys []struct {
+			KeyID string `json:"kid"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(set.Keys) == 0 || set.Keys[0].KeyID == "" {
+		t.Errorf("Unexpected key set %v", set)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+const (
+	liveKeepAlive  = time.Second * 15
+	liveBufferSize = 64
+)
+
+// liveEvent is an event that has just been ingested. The payload is
+// encrypted just like when querying events, so it can only be read by
+// clients holding the private key of the account.
+type liveEvent struct {
+	AccountID string    `json:"accountId"`
+	EventID   string    `json:"eventId,omitempty"`
+	Payload   string    `json:"payload"`
+	Anonymous bool      `json:"anonymous"`
+	Received  time.Time `json:"received"`
+}
+
+// liveBroker passes ingested events to all clients that are subscribed to
+// the account. It only knows about events received by this instance.
+type liveBroker struct {
+	lock        sync.RWMutex
+	subscribers map[string]map[chan liveEvent]struct{}
+}
+
+func newLiveBroker() *liveBroker {
+	return &liveBroker{subscribers: map[string]map[chan liveEvent]struct{}{}}
+}
+
+// subscribe returns a channel receiving all events of the given account
+// and a func for cancelling the subscription.
+func (l *liveBroker) subscribe(accountID string) (<-chan liveEvent, func()) {
+	ch := make(chan liveEvent, liveBufferSize)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.subscribers[accountID] == nil {
+		l.subscribers[accountID] = map[chan liveEvent]struct{}{}
+	}
+	l.subscribers[accountID][ch] = struct{}{}
+	return ch, func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		delete(l.subscribers[accountID], ch)
+		if len(l.subscribers[accountID]) == 0 {
+			delete(l.subscribers, accountID)
+		}
+	}
+}
+
+// publish passes the given events to all subscribers of their accounts.
+// Subscribers that cannot keep up miss events instead of blocking
+// ingestion. Calling publish on a nil broker is a no-op.
+func (l *liveBroker) publish(events ...liveEvent) {
+	if l == nil {
+		return
+	}
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	for _, evt := range events {
+		for ch := range l.subscribers[evt.AccountID] {
+			select {
+			case ch <- evt:
+			default:
+			}
+		}
+	}
+}
+
+// getLiveEvents streams the events ingested for the given account using
+// server-sent events until the client disconnects or the server shuts
+// down.
+func (rt *router) getLiveEvents(c *gin.Context) {
+	accountID := c.Param("accountID")
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: could not find account user object in request context"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+	if !accountUser.CanAccessAccount(accountID) {
+		newJSONError(
+			fmt.Errorf("router: account user does not have permissions to access account %s", accountID),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+	if rt.live == nil {
+		newJSONError(
+			errors.New("router: live events are not available"),
+			http.StatusNotFound,
+		).Pipe(c)
+		return
+	}
+
+	events, unsubscribe := rt.live.subscribe(accountID)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-store")
+	// proxies like nginx would otherwise buffer the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	// gin's Stream is not used as it requires the response writer to
+	// implement the deprecated http.CloseNotifier
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case evt := <-events:
+			c.SSEvent("event", evt)
+		case <-keepAlive.C:
+			io.WriteString(c.Writer, ": keep-alive\n\n")
+		case <-rt.shutdown:
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestLiveBroker(t *testing.T) {
+	b := newLiveBroker()
+	a1, cancelA1 := b.subscribe("account-a")
+	a2, cancelA2 := b.subscribe("account-a")
+	defer cancelA2()
+	other, cancelOther := b.subscribe("account-b")
+	defer cancelOther()
+
+	b.publish(liveEvent{AccountID: "account-a", Payload: "payload-1"})
+	for _, ch := range []<-chan liveEvent{a1, a2} {
+		select {
+		case evt := <-ch:
+			if evt.Payload != "payload-1" {
+				t.Errorf("Unexpected event %v", evt)
+			}
+		default:
+			t.Error("Expected event to be published")
+		}
+	}
+	select {
+	case evt := <-other:
+		t.Errorf("Unexpected event for other account %v", evt)
+	default:
+	}
+
+	cancelA1()
+	b.publish(liveEvent{AccountID: "account-a", Payload: "payload-2"})
+	select {
+	case evt := <-a1:
+		t.Errorf("Unexpected event after cancelling %v", evt)
+	default:
+	}
+	if len(a2) != 1 {
+		t.Errorf("Expected remaining subscriber to receive event")
+	}
+
+	// slow subscribers do not block publishing
+	for i := 0; i < liveBufferSize*2; i++ {
+		b.publish(liveEvent{AccountID: "account-b"})
+	}
+	if len(other) != liveBufferSize {
+		t.Errorf("Unexpected number of buffered events %d", len(other))
+	}
+
+	var nilBroker *liveBroker
+	nilBroker.publish(liveEvent{AccountID: "account-a"})
+}
+
+func TestRouter_getLiveEvents(t *testing.T) {
+	viewer := persistence.LoginResult{
+		AccountUserID: "user-a",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a"},
+		},
+	}
+	tests := []struct {
+		name               string
+		userContext        interface{}
+		accountID          string
+		expectedStatusCode int
+	}{
+		{"bad user context", 12, "account-a", http.StatusUnauthorized},
+		{"missing permissions", viewer, "account-b", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{live: newLiveBroker()}
+			m := gin.New()
+			m.GET("/:accountID", func(c *gin.Context) {
+				c.Set(contextKeyAuth, test.userContext)
+			}, rt.getLiveEvents)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/"+test.accountID, nil)
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+		})
+	}
+
+	t.Run("stream", func(t *testing.T) {
+		shutdown := make(chan struct{})
+		rt := router{live: newLiveBroker(), shutdown: shutdown}
+		m := gin.New()
+		m.GET("/:accountID", func(c *gin.Context) {
+			c.Set(contextKeyAuth, viewer)
+		}, rt.getLiveEvents)
+		server := httptest.NewServer(compressHandler(m))
+		defer server.Close()
+
+		res, err := http.Get(server.URL + "/account-a")
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("Unexpected status code %v", res.StatusCode)
+		}
+		if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+			t.Errorf("Unexpected content type %v", contentType)
+		}
+
+		// the response headers are sent after subscribing
+		rt.live.publish(liveEvent{AccountID: "account-a", Payload: "payload-1"})
+		lines := make(chan string)
+		go func() {
+			scanner := bufio.NewScanner(res.Body)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+			close(lines)
+		}()
+
+		var data string
+		timeout := time.After(time.Second * 5)
+		for data == "" {
+			select {
+			case line := <-lines:
+				if strings.HasPrefix(line, "data:") {
+					data = line
+				}
+			case <-timeout:
+				t.Fatal("Timed out waiting for event")
+			}
+		}
+		if !strings.Contains(data, `"payload":"payload-1"`) {
+			t.Errorf("Unexpected data %v", data)
+		}
+
+		close(shutdown)
+		select {
+		case _, ok := <-lines:
+			for ok {
+				_, ok = <-lines
+			}
+		case <-time.After(time.Second * 5):
+			t.Error("Expected stream to end on shutdown")
+		}
+	})
+}
@@ -5,22 +5,43 @@ package router
 
 import (
 	"bytes"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/ratelimiter"
 )
 
 type loginCredentials struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// PublicKey is an optional Base64 encoded X25519 public key. In case it
+	// is given, key encryption keys are wrapped for its holder.
+	PublicKey string `json:"publicKey"`
+	// SecondFactor is the TOTP code of account users that have enabled
+	// two-factor authentication.
+	SecondFactor string `json:"secondFactor"`
 }
 
 func (rt *router) postLogout(c *gin.Context) {
+	// the session is revoked so that the token cannot be used anymore, even
+	// if it has been copied before
+	if current, err := c.Request.Cookie(authKey); err == nil {
+		if sessionID, err := rt.signingKeys.VerifyToken(current.Value); err == nil {
+			if session, err := rt.db.LookupSession(sessionID); err == nil {
+				if err := rt.db.RevokeSession(session.AccountUserID, session.SessionID); err != nil {
+					rt.logError(c, err, "error revoking session")
+				}
+			}
+		}
+	}
+
 	authCookie, authCookieErr := rt.authCookie("", c.GetBool(contextKeySecureContext))
 	if authCookieErr != nil {
 		newJSONError(
@@ -31,6 +52,7 @@ func (rt *router) postLogout(c *gin.Context) {
 	}
 
 	http.SetCookie(c.Writer, authCookie)
+	http.SetCookie(c.Writer, rt.refreshCookie(persistence.SessionResult{}, c.GetBool(contextKeySecureContext)))
 	c.JSON(http.StatusNoContent, nil)
 }
 
@@ -52,6 +74,14 @@ func (rt *router) postLogin(c *gin.Context) {
 		return
 	}
 
+	if l := <-rt.getLimiter().ExponentialThrottle(time.Second, fmt.Sprintf("postLogin-ip-%s", rt.clientIP(c))); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error applying rate limit: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
 	// we rate limit this twice to prevent flooding with arbitrary emails
 	if l := <-rt.getLimiter().LinearThrottle(time.Second/2, "postLogin-*"); l.Error != nil {
 		newJSONError(
@@ -61,16 +91,132 @@ func (rt *router) postLogin(c *gin.Context) {
 		return
 	}
 
-	result, err := rt.db.Login(credentials.Username, credentials.Password)
+	lockoutIdentifiers := map[string]string{
+		"email": credentials.Username,
+		"ip":    rt.clientIP(c),
+	}
+	for kind, value := range lockoutIdentifiers {
+		if err := rt.getLockout().Check(kind + "-" + value); err != nil {
+			var locked ratelimiter.ErrLockedOut
+			if errors.As(err, &locked) {
+				c.Header("Retry-After", strconv.Itoa(int(time.Until(locked.Until)/time.Second)+1))
+			}
+			newJSONError(
+				errors.New("router: too many failed login attempts, try again later"),
+				http.StatusTooManyRequests,
+			).Pipe(c)
+			return
+		}
+	}
+
+	var peerPublicKey []byte
+	if credentials.PublicKey != "" {
+		b, err := base64.StdEncoding.DecodeString(credentials.PublicKey)
+		if err != nil {
+			newJSONError(
+				fmt.Errorf("router: error decoding public key: %w", err),
+				http.StatusBadRequest,
+			).Pipe(c)
+			return
+		}
+		peerPublicKey = b
+	}
+
+	result, err := rt.db.LoginWithSecondFactor(credentials.Username, credentials.Password, credentials.SecondFactor)
+	observeLogin("password", err)
 	if err != nil {
+		switch {
+		case errors.Is(err, persistence.ErrSecondFactorRequired):
+		case errors.Is(err, persistence.ErrInvalidSecondFactor):
+			rt.recordAuthEvent(c, "", credentials.Username, persistence.AuthEventSecondFactorFailed)
+		default:
+			rt.recordAuthEvent(c, "", credentials.Username, persistence.AuthEventLoginFailed)
+		}
+		// the password has been verified at this point, so it is fine to tell
+		// the client that a second factor is needed
+		if errors.Is(err, persistence.ErrSecondFactorRequired) {
+			newJSONError(
+				errors.New("router: second factor required"),
+				http.StatusUnauthorized,
+			).Pipe(c)
+			return
+		}
+		if errors.Is(err, persistence.ErrPasswordExpired) {
+			newJSONError(
+				errors.New("router: password has expired, change your password to continue"),
+				http.StatusUnauthorized,
+			).Pipe(c)
+			return
+		}
+		if errors.Is(err, persistence.ErrAccountUserSuspended) {
+			newJSONError(
+				errors.New("router: account user has been suspended"),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
+		if errors.Is(err, persistence.ErrPasswordOutOfSync) {
+			newJSONError(
+				errors.New("router: password has been changed in directory, reset your password to continue"),
+				http.StatusUnauthorized,
+			).Pipe(c)
+			return
+		}
+		// the underlying error is not exposed as it might allow to tell
+		// whether an account user for the given email exists
+		if !errors.Is(err, persistence.ErrInvalidCredentials) {
+			rt.logError(c, err, "error logging in")
+		} else {
+			for kind, value := range lockoutIdentifiers {
+				var locked ratelimiter.ErrLockedOut
+				if err := rt.getLockout().Fail(kind + "-" + value); errors.As(err, &locked) && rt.logger != nil {
+					// email addresses and ip addresses are not logged, but
+					// the lookup hash allows to find the account user
+					entry := rt.logger.
+						WithField("audit", "lockout").
+						WithField("identifier", kind).
+						WithField("until", locked.Until)
+					if lookup := rt.config.NewEmailLookup(); kind == "email" && lookup != nil {
+						entry = entry.
+							WithField("emailLookupHash", lookup.Hash(value)).
+							WithField("emailLookupKeyId", lookup.KeyID())
+					}
+					entry.Warn("Locked out after too many failed login attempts")
+				}
+			}
+		}
 		newJSONError(
-			fmt.Errorf("router: error logging in: %w", err),
+			errors.New("router: invalid credentials"),
 			http.StatusUnauthorized,
 		).Pipe(c)
 		return
 	}
 
-	authCookie, authCookieErr := rt.authCookie(result.AccountUserID, c.GetBool(contextKeySecureContext))
+	if !rt.networkAllowed(c, result.AllowedNetworks) {
+		newJSONError(
+			errors.New("router: access from this network is not allowed"),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	rt.getLockout().Reset("email-" + credentials.Username)
+	if rt.config.LoginNotification.Enabled {
+		rt.notifyNewDeviceLogin(c, result.AccountUserID, credentials.Username)
+	}
+	rt.recordAuthEvent(c, result.AccountUserID, "", persistence.AuthEventLoginSucceeded)
+
+	if peerPublicKey != nil {
+		if err := result.WrapKeys(peerPublicKey); err != nil {
+			newJSONError(
+				fmt.Errorf("router: error wrapping keys for client: %w", err),
+				http.StatusBadRequest,
+			).Pipe(c)
+			return
+		}
+	}
+
+	authCookie, authCookieErr := rt.sessionCookie(c, result.AccountUserID)
 	if authCookieErr != nil {
 		newJSONError(
 			fmt.Errorf("router: error creating auth cookie: %w", authCookieErr),
@@ -135,8 +281,73 @@ func (rt *router) postChangePassword(c *gin.Context) {
 		).Pipe(c)
 		return
 	}
+	// sessions on other devices are not supposed to outlive the password
+	if err := rt.db.RevokeSessions(user.AccountUserID); err != nil {
+		rt.logError(c, err, "error revoking sessions")
+	}
 	cookie, _ := rt.authCookie("", c.GetBool(contextKeySecureContext))
 	http.SetCookie(c.Writer, cookie)
+	http.SetCookie(c.Writer, rt.refreshCookie(persistence.SessionResult{}, c.GetBool(contextKeySecureContext)))
+	c.Status(http.StatusNoContent)
+}
+
+type changeExpiredPasswordRequest struct {
+	EmailAddress    string `json:"emailAddress"`
+	CurrentPassword string `json:"currentPassword"`
+	ChangedPassword string `json:"changedPassword"`
+	SecondFactor    string `json:"secondFactor"`
+}
+
+func (rt *router) postChangeExpiredPassword(c *gin.Context) {
+	var req changeExpiredPasswordRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if l := <-rt.getLimiter().ExponentialThrottle(time.Second, fmt.Sprintf("postChangeExpiredPassword-%s", req.EmailAddress)); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error applying rate limit: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
+	// the endpoint verifies credentials, so it shares the lockout state
+	// of logins
+	if err := rt.getLockout().Check("email-" + req.EmailAddress); err != nil {
+		newJSONError(
+			errors.New("router: too many failed login attempts, try again later"),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
+	if err := rt.db.ChangeExpiredPassword(req.EmailAddress, req.CurrentPassword, req.ChangedPassword, req.SecondFactor); err != nil {
+		if errors.Is(err, persistence.ErrSecondFactorRequired) {
+			newJSONError(
+				errors.New("router: second factor required"),
+				http.StatusUnauthorized,
+			).Pipe(c)
+			return
+		}
+		if errors.Is(err, persistence.ErrInvalidCredentials) {
+			rt.getLockout().Fail("email-" + req.EmailAddress)
+			newJSONError(
+				errors.New("router: invalid credentials"),
+				http.StatusUnauthorized,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error changing password: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
 	c.Status(http.StatusNoContent)
 }
 
@@ -144,6 +355,13 @@ type changeEmailRequest struct {
 	EmailAddress string `json:"emailAddress"`
 	EmailCurrent string `json:"emailCurrent"`
 	Password     string `json:"password"`
+	URLTemplate  string `json:"urlTemplate"`
+}
+
+type changeEmailCredentials struct {
+	Token         []byte
+	AccountUserID string
+	EmailAddress  string
 }
 
 func (rt *router) postChangeEmail(c *gin.Context) {
@@ -172,15 +390,116 @@ func (rt *router) postChangeEmail(c *gin.Context) {
 		).Pipe(c)
 		return
 	}
-	if err := rt.db.ChangeEmail(accountUser.AccountUserID, req.EmailAddress, req.EmailCurrent, req.Password); err != nil {
+	token, err := rt.db.ChangeEmail(accountUser.AccountUserID, req.EmailAddress, req.EmailCurrent, req.Password)
+	if err != nil {
 		newJSONError(
 			fmt.Errorf("router: error changing email address: %v", err),
 			http.StatusBadRequest,
 		).Pipe(c)
 		return
 	}
+
+	signedCredentials, err := rt.cookieSigner.MaxAge(int(persistence.EmailChangeTTL/time.Second)).Encode("credentials", changeEmailCredentials{
+		Token:         token,
+		AccountUserID: accountUser.AccountUserID,
+		EmailAddress:  req.EmailAddress,
+	})
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error signing token: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	confirmURL := strings.Replace(req.URLTemplate, "{token}", signedCredentials, -1)
+
+	// the confirmation is sent to the new address, the current address is
+	// notified so a hijacked session cannot silently take over the account
+	messages := []struct {
+		to       string
+		template string
+		data     map[string]string
+	}{
+		{req.EmailAddress, "confirm_email", map[string]string{"url": confirmURL}},
+		{req.EmailCurrent, "email_change_requested", map[string]string{"emailAddress": req.EmailAddress}},
+	}
+	for _, message := range messages {
+		subject, body := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+		subjectErr := rt.emails.ExecuteTemplate(subject, "subject_"+message.template, nil)
+		bodyErr := rt.emails.ExecuteTemplate(body, "body_"+message.template, message.data)
+		for _, err := range []error{subjectErr, bodyErr} {
+			if err != nil {
+				newJSONError(
+					fmt.Errorf("router: error rendering email message: %v", err),
+					http.StatusInternalServerError,
+				).Pipe(c)
+				return
+			}
+		}
+		if err := rt.mailer.Send(rt.config.SMTP.Sender, message.to, subject.String(), body.String()); err != nil {
+			newJSONError(
+				fmt.Errorf("router: error sending email message: %v", err),
+				http.StatusInternalServerError,
+			).Pipe(c)
+			return
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type confirmEmailChangeRequest struct {
+	Token string `json:"token"`
+}
+
+func (rt *router) postConfirmEmailChange(c *gin.Context) {
+	var req confirmEmailChangeRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	var credentials changeEmailCredentials
+	if err := rt.decodeSigned("credentials", req.Token, &credentials); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding signed token: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if l := <-rt.getLimiter().ExponentialThrottle(time.Second*5, fmt.Sprintf("postConfirmEmailChange-%s", credentials.AccountUserID)); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error applying rate limit: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
+	if err := rt.db.ConfirmEmailChange(credentials.AccountUserID, credentials.EmailAddress, credentials.Token); err != nil {
+		var oneTimeKeyErr persistence.ErrInvalidOneTimeKey
+		if errors.As(err, &oneTimeKeyErr) {
+			newJSONError(
+				errors.New("router: email change has expired or has already been confirmed"),
+				http.StatusBadRequest,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error confirming email change: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	// existing sessions have been established using the previous address
+	if err := rt.db.RevokeSessions(credentials.AccountUserID); err != nil {
+		rt.logError(c, err, "error revoking sessions after changing email")
+	}
 	cookie, _ := rt.authCookie("", c.GetBool(contextKeySecureContext))
 	http.SetCookie(c.Writer, cookie)
+	http.SetCookie(c.Writer, rt.refreshCookie(persistence.SessionResult{}, c.GetBool(contextKeySecureContext)))
 	c.Status(http.StatusNoContent)
 }
 
@@ -224,18 +543,20 @@ func (rt *router) postForgotPassword(c *gin.Context) {
 		return
 	}
 
+	rt.recordAuthEvent(c, "", req.EmailAddress, persistence.AuthEventPasswordResetRequested)
+
 	token, err := rt.db.GenerateOneTimeKey(req.EmailAddress)
 	if err != nil {
-		rt.logError(err, "error generating one time key")
+		rt.logError(c, err, "error generating one time key")
 		c.Status(http.StatusNoContent)
 		return
 	}
-	signedCredentials, signErr := rt.cookieSigner.MaxAge(24*60*60).Encode("credentials", forgotPasswordCredentials{
+	signedCredentials, signErr := rt.cookieSigner.MaxAge(int(persistence.OneTimeKeyTTL/time.Second)).Encode("credentials", forgotPasswordCredentials{
 		Token:        token,
 		EmailAddress: req.EmailAddress,
 	})
 	if signErr != nil {
-		rt.logError(signErr, "error signing token")
+		rt.logError(c, signErr, "error signing token")
 		c.Status(http.StatusNoContent)
 		return
 	}
@@ -284,7 +605,7 @@ func (rt *router) postResetPassword(c *gin.Context) {
 		return
 	}
 	var credentials forgotPasswordCredentials
-	if err := rt.cookieSigner.Decode("credentials", req.Token, &credentials); err != nil {
+	if err := rt.decodeSigned("credentials", req.Token, &credentials); err != nil {
 		newJSONError(
 			fmt.Errorf("error decoding signed token: %w", err),
 			http.StatusBadRequest,
@@ -308,10 +629,18 @@ func (rt *router) postResetPassword(c *gin.Context) {
 		return
 	}
 
-	if err := rt.db.ResetPassword(req.EmailAddress, req.Password, credentials.Token); err != nil {
+	accountUserID, err := rt.db.ResetPassword(req.EmailAddress, req.Password, credentials.Token)
+	if err != nil {
 		// on error a successful status is sent in order not to leak information
 		// to attackers
-		rt.logError(err, "error resetting password")
+		rt.logError(c, err, "error resetting password")
+		c.Status(http.StatusNoContent)
+		return
+	}
+	// a reset password is likely to be compromised, so sessions established
+	// using it are not supposed to outlive the reset
+	if err := rt.db.RevokeSessions(accountUserID); err != nil {
+		rt.logError(c, err, "error revoking sessions after resetting password")
 	}
 	c.Status(http.StatusNoContent)
 }
@@ -4,12 +4,15 @@
 package router
 
 import (
+	"bytes"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -17,7 +20,10 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/securecookie"
 	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/keys"
 	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/ratelimiter"
+	"github.com/sirupsen/logrus"
 )
 
 func TestRouter_postLogout(t *testing.T) {
@@ -36,8 +42,11 @@ func TestRouter_postLogout(t *testing.T) {
 
 	m.ServeHTTP(w, r)
 	cookies := w.Result().Cookies()
-	if len(cookies) != 1 {
-		t.Errorf("Unexpected additional cookies in response: %v", cookies)
+	if len(cookies) != 2 {
+		t.Fatalf("Unexpected cookies in response: %v", cookies)
+	}
+	if refreshCookie := cookies[1]; refreshCookie.Name != "refresh" || refreshCookie.Value != "" {
+		t.Errorf("Expected refresh cookie to be cleared, got %v", refreshCookie)
 	}
 	authCookie := cookies[0]
 	if authCookie.Name != "auth" {
@@ -53,14 +62,30 @@ func TestRouter_postLogout(t *testing.T) {
 
 type mockPostLoginDatabase struct {
 	persistence.Service
-	result persistence.LoginResult
-	err    error
+	result         persistence.LoginResult
+	err            error
+	authEvents     []string
+	newFingerprint bool
 }
 
-func (m *mockPostLoginDatabase) Login(string, string) (persistence.LoginResult, error) {
+func (m *mockPostLoginDatabase) IsNewLoginFingerprint(string, string, string) (bool, error) {
+	return m.newFingerprint, nil
+}
+
+func (m *mockPostLoginDatabase) RecordAuthEvent(accountUserID, emailAddress, eventType, ipAddress, userAgent string) error {
+	m.authEvents = append(m.authEvents, eventType)
+	return nil
+}
+
+func (m *mockPostLoginDatabase) LoginWithSecondFactor(string, string, string) (persistence.LoginResult, error) {
 	return m.result, m.err
 }
+
+func (m *mockPostLoginDatabase) CreateSession(string, string, string, time.Duration, time.Duration) (persistence.SessionResult, error) {
+	return persistence.SessionResult{SessionID: "session-id"}, nil
+}
 func TestRouter_postLogin(t *testing.T) {
+	clientPublicKey, _, _ := keys.GenerateExchangeKeyPair()
 	tests := []struct {
 		name               string
 		db                 mockPostLoginDatabase
@@ -84,6 +109,24 @@ func TestRouter_postLogin(t *testing.T) {
 			http.StatusUnauthorized,
 			false,
 		},
+		{
+			"invalid credentials",
+			mockPostLoginDatabase{
+				err: persistence.ErrInvalidCredentials,
+			},
+			strings.NewReader(`{"username":"mail@offen.dev","password":"secret!"}`),
+			http.StatusUnauthorized,
+			false,
+		},
+		{
+			"second factor required",
+			mockPostLoginDatabase{
+				err: persistence.ErrSecondFactorRequired,
+			},
+			strings.NewReader(`{"username":"mail@offen.dev","password":"secret!"}`),
+			http.StatusUnauthorized,
+			false,
+		},
 		{
 			"ok",
 			mockPostLoginDatabase{
@@ -95,6 +138,30 @@ func TestRouter_postLogin(t *testing.T) {
 			http.StatusOK,
 			true,
 		},
+		{
+			"bad public key",
+			mockPostLoginDatabase{},
+			strings.NewReader(`{"username":"mail@offen.dev","password":"secret!","publicKey":"!!!"}`),
+			http.StatusBadRequest,
+			false,
+		},
+		{
+			"ok with public key",
+			mockPostLoginDatabase{
+				result: persistence.LoginResult{
+					AccountUserID: "user-a",
+					Accounts: []persistence.LoginAccountResult{
+						{AccountID: "account-a", KeyEncryptionKey: map[string]string{"kty": "oct"}},
+					},
+				},
+			},
+			strings.NewReader(fmt.Sprintf(
+				`{"username":"mail@offen.dev","password":"secret!","publicKey":"%s"}`,
+				base64.StdEncoding.EncodeToString(clientPublicKey),
+			)),
+			http.StatusOK,
+			true,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -103,6 +170,7 @@ func TestRouter_postLogin(t *testing.T) {
 				config:       &config.Config{},
 				db:           &test.db,
 				cookieSigner: securecookie.New([]byte("abc"), nil),
+				signingKeys:  mustSigningKeyring("abc"),
 			}
 			m.POST("/", rt.postLogin)
 			r := httptest.NewRequest(http.MethodPost, "/", test.body)
@@ -112,6 +180,9 @@ func TestRouter_postLogin(t *testing.T) {
 			if w.Code != test.expectedStatusCode {
 				t.Errorf("Unexpected status code %v", w.Code)
 			}
+			if w.Code == http.StatusUnauthorized && !errors.Is(test.db.err, persistence.ErrSecondFactorRequired) && !strings.Contains(w.Body.String(), `"router: invalid credentials"`) {
+				t.Errorf("Expected uniform error message, got %v", w.Body.String())
+			}
 
 			cookies := w.Result().Cookies()
 			if test.expectCookie {
@@ -134,6 +205,162 @@ func TestRouter_postLogin(t *testing.T) {
 	}
 }
 
+func TestRouter_postLogin_Lockout(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Lockout.Attempts = 2
+	cfg.Lockout.Duration = time.Hour
+	cfg.EmailLookup.KeyID = "key-a"
+	var logs bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&logs)
+	rt := router{
+		config:  cfg,
+		db:      &mockPostLoginDatabase{err: persistence.ErrInvalidCredentials},
+		limiter: ratelimiter.NewNoopRateLimiter(),
+		logger:  logger,
+	}
+	m := gin.New()
+	m.POST("/", rt.postLogin)
+
+	for _, expectedStatusCode := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"username":"develop@offen.dev","password":"develop"}`)))
+		if w.Code != expectedStatusCode {
+			t.Errorf("Expected status code %v, got %v", expectedStatusCode, w.Code)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Error("Expected Retry-After header to be set")
+		}
+	}
+
+	// the ip address of the client is locked out as well
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"username":"other@offen.dev","password":"develop"}`)))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Unexpected status code %v", w.Code)
+	}
+
+	if !strings.Contains(logs.String(), "audit=lockout") || !strings.Contains(logs.String(), cfg.NewEmailLookup().Hash("develop@offen.dev")) {
+		t.Errorf("Expected lockout to be logged using the lookup hash, got %v", logs.String())
+	}
+	if strings.Contains(logs.String(), "develop@offen.dev") || strings.Contains(logs.String(), "192.0.2.1") {
+		t.Errorf("Expected email and ip address not to be logged, got %v", logs.String())
+	}
+}
+
+func TestRouter_postLogin_LockoutSpoofedHeader(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.ReverseProxy = true
+	cfg.Lockout.Attempts = 2
+	cfg.Lockout.Duration = time.Hour
+	rt := router{
+		config:  cfg,
+		db:      &mockPostLoginDatabase{err: persistence.ErrInvalidCredentials},
+		limiter: ratelimiter.NewNoopRateLimiter(),
+	}
+	m := gin.New()
+	m.POST("/", rt.postLogin)
+
+	// each request uses another email address and prepends another made up
+	// address, but the address appended by the proxy stays the same
+	for i, expectedStatusCode := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"username":"user-%d@offen.dev","password":"develop"}`, i)))
+		r.Header.Set("X-Forwarded-For", fmt.Sprintf("10.0.0.%d, 203.0.113.1", i))
+		m.ServeHTTP(w, r)
+		if w.Code != expectedStatusCode {
+			t.Errorf("Expected status code %v, got %v", expectedStatusCode, w.Code)
+		}
+	}
+}
+
+func TestRouter_postLogin_AuthEvents(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedEvents []string
+	}{
+		{"ok", nil, []string{persistence.AuthEventLoginSucceeded}},
+		{"invalid credentials", persistence.ErrInvalidCredentials, []string{persistence.AuthEventLoginFailed}},
+		{"invalid second factor", persistence.ErrInvalidSecondFactor, []string{persistence.AuthEventSecondFactorFailed}},
+		{"second factor required", persistence.ErrSecondFactorRequired, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &mockPostLoginDatabase{
+				result: persistence.LoginResult{AccountUserID: "user-a"},
+				err:    test.err,
+			}
+			rt := router{
+				config:       &config.Config{},
+				db:           db,
+				cookieSigner: securecookie.New([]byte("abc"), nil),
+				signingKeys:  mustSigningKeyring("abc"),
+			}
+			m := gin.New()
+			m.POST("/", rt.postLogin)
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"username":"develop@offen.dev","password":"develop"}`)))
+			if !reflect.DeepEqual(db.authEvents, test.expectedEvents) {
+				t.Errorf("Expected events %v, got %v", test.expectedEvents, db.authEvents)
+			}
+		})
+	}
+}
+
+type mockRecordingMailer struct {
+	recipients []string
+}
+
+func (m *mockRecordingMailer) Send(from, to, subject, body string) error {
+	m.recipients = append(m.recipients, to)
+	return nil
+}
+
+func TestRouter_postLogin_LoginNotification(t *testing.T) {
+	tests := []struct {
+		name               string
+		enabled            bool
+		newFingerprint     bool
+		expectedRecipients []string
+	}{
+		{"disabled", false, true, nil},
+		{"known fingerprint", true, false, nil},
+		{"new fingerprint", true, true, []string{"develop@offen.dev"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.LoginNotification.Enabled = test.enabled
+			mailer := &mockRecordingMailer{}
+			rt := router{
+				config: cfg,
+				db: &mockPostLoginDatabase{
+					result:         persistence.LoginResult{AccountUserID: "user-a"},
+					newFingerprint: test.newFingerprint,
+				},
+				cookieSigner: securecookie.New([]byte("abc"), nil),
+				signingKeys:  mustSigningKeyring("abc"),
+				mailer:       mailer,
+				emails: template.Must(template.New("emails").Parse(`
+{{ define "subject_new_device_login" }}subject{{ end }}
+{{ define "body_new_device_login" }}{{ .ipAddress }} {{ .userAgent }}{{ end }}
+				`)),
+			}
+			m := gin.New()
+			m.POST("/", rt.postLogin)
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"username":"develop@offen.dev","password":"develop"}`)))
+			if w.Code != http.StatusOK {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if !reflect.DeepEqual(mailer.recipients, test.expectedRecipients) {
+				t.Errorf("Expected notifications to %v, got %v", test.expectedRecipients, mailer.recipients)
+			}
+		})
+	}
+}
+
 func TestRouter_getLogin(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		m := gin.New()
@@ -174,6 +401,10 @@ func (m *mockPostChangePasswordDatabase) ChangePassword(string, string, string)
 	return m.err
 }
 
+func (m *mockPostChangePasswordDatabase) RevokeSessions(string) error {
+	return nil
+}
+
 func TestRouter_postChangePassword(t *testing.T) {
 	tests := []struct {
 		name                string
@@ -262,63 +493,141 @@ func TestRouter_postChangePassword(t *testing.T) {
 	}
 }
 
-type mockPostChangeEmailDatabase struct {
+type mockPostChangeExpiredPasswordDatabase struct {
 	persistence.Service
 	err error
 }
 
-func (m *mockPostChangeEmailDatabase) ChangeEmail(string, string, string, string) error {
+func (m *mockPostChangeExpiredPasswordDatabase) ChangeExpiredPassword(string, string, string, string) error {
 	return m.err
 }
 
+func TestRouter_postChangeExpiredPassword(t *testing.T) {
+	tests := []struct {
+		name           string
+		db             mockPostChangeExpiredPasswordDatabase
+		body           io.Reader
+		expectedStatus int
+	}{
+		{
+			"bad payload",
+			mockPostChangeExpiredPasswordDatabase{},
+			strings.NewReader("88kao122ä#"),
+			http.StatusBadRequest,
+		},
+		{
+			"invalid credentials",
+			mockPostChangeExpiredPasswordDatabase{err: persistence.ErrInvalidCredentials},
+			strings.NewReader(`{"emailAddress":"develop@offen.dev","currentPassword":"secret","changedPassword":"update"}`),
+			http.StatusUnauthorized,
+		},
+		{
+			"second factor required",
+			mockPostChangeExpiredPasswordDatabase{err: persistence.ErrSecondFactorRequired},
+			strings.NewReader(`{"emailAddress":"develop@offen.dev","currentPassword":"secret","changedPassword":"update"}`),
+			http.StatusUnauthorized,
+		},
+		{
+			"database error",
+			mockPostChangeExpiredPasswordDatabase{err: errors.New("did not work")},
+			strings.NewReader(`{"emailAddress":"develop@offen.dev","currentPassword":"secret","changedPassword":"update"}`),
+			http.StatusBadRequest,
+		},
+		{
+			"ok",
+			mockPostChangeExpiredPasswordDatabase{},
+			strings.NewReader(`{"emailAddress":"develop@offen.dev","currentPassword":"secret","changedPassword":"update"}`),
+			http.StatusNoContent,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := gin.New()
+			rt := router{
+				config:  &config.Config{},
+				db:      &test.db,
+				limiter: ratelimiter.NewNoopRateLimiter(),
+			}
+			m.POST("/", rt.postChangeExpiredPassword)
+			r := httptest.NewRequest(http.MethodPost, "/", test.body)
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+		})
+	}
+}
+
+type mockPostChangeEmailDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockPostChangeEmailDatabase) ChangeEmail(string, string, string, string) ([]byte, error) {
+	return []byte("token"), m.err
+}
+
 func TestRouter_postChangeEmail(t *testing.T) {
 	tests := []struct {
-		name                string
-		db                  mockPostChangeEmailDatabase
-		body                io.Reader
-		userContext         interface{}
-		expectedStatus      int
-		expectClearedCookie bool
+		name           string
+		db             mockPostChangeEmailDatabase
+		mailer         mockMailer
+		body           io.Reader
+		userContext    interface{}
+		expectedStatus int
 	}{
 		{
 			"bad user context",
 			mockPostChangeEmailDatabase{},
-			strings.NewReader(`{"emailAddress":"new@me.net","emailCurrent":"old@me.net","password":"secret-sauce"}`),
+			mockMailer{},
+			strings.NewReader(`{"emailAddress":"new@me.net","emailCurrent":"old@me.net","password":"secret-sauce","urlTemplate":"/{token}/"}`),
 			1999,
 			http.StatusInternalServerError,
-			false,
 		},
 		{
 			"bad payload",
 			mockPostChangeEmailDatabase{},
+			mockMailer{},
 			strings.NewReader("891ä##"),
 			persistence.LoginResult{
 				AccountUserID: "account-user",
 			},
 			http.StatusBadRequest,
-			false,
 		},
 		{
 			"db error",
 			mockPostChangeEmailDatabase{
 				err: errors.New("did not work"),
 			},
-			strings.NewReader(`{"emailAddress":"new@me.net","emailCurrent":"old@me.net","password":"secret-sauce"}`),
+			mockMailer{},
+			strings.NewReader(`{"emailAddress":"new@me.net","emailCurrent":"old@me.net","password":"secret-sauce","urlTemplate":"/{token}/"}`),
 			persistence.LoginResult{
 				AccountUserID: "account-user",
 			},
 			http.StatusBadRequest,
-			false,
+		},
+		{
+			"error sending email",
+			mockPostChangeEmailDatabase{},
+			mockMailer{
+				err: errors.New("did not work"),
+			},
+			strings.NewReader(`{"emailAddress":"new@me.net","emailCurrent":"old@me.net","password":"secret-sauce","urlTemplate":"/{token}/"}`),
+			persistence.LoginResult{
+				AccountUserID: "account-user",
+			},
+			http.StatusInternalServerError,
 		},
 		{
 			"ok",
 			mockPostChangeEmailDatabase{},
-			strings.NewReader(`{"emailAddress":"new@me.net","emailCurrent":"old@me.net","password":"secret-sauce"}`),
+			mockMailer{},
+			strings.NewReader(`{"emailAddress":"new@me.net","emailCurrent":"old@me.net","password":"secret-sauce","urlTemplate":"/{token}/"}`),
 			persistence.LoginResult{
 				AccountUserID: "account-user",
 			},
 			http.StatusNoContent,
-			true,
 		},
 	}
 
@@ -326,8 +635,20 @@ func TestRouter_postChangeEmail(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			m := gin.New()
 			rt := router{
-				config: &config.Config{},
-				db:     &test.db,
+				config:       &config.Config{},
+				db:           &test.db,
+				cookieSigner: securecookie.New([]byte("abc"), nil),
+				mailer:       &test.mailer,
+				emails: func() *template.Template {
+					t := template.New("emails")
+					t, _ = t.Parse(`
+{{ define "subject_confirm_email" }}subject{{ end }}
+{{ define "body_confirm_email" }}body{{ end }}
+{{ define "subject_email_change_requested" }}subject{{ end }}
+{{ define "body_email_change_requested" }}body{{ end }}
+					`)
+					return t
+				}(),
 			}
 			m.POST("/", func(c *gin.Context) {
 				c.Set(contextKeyAuth, test.userContext)
@@ -339,21 +660,95 @@ func TestRouter_postChangeEmail(t *testing.T) {
 			if w.Code != test.expectedStatus {
 				t.Errorf("Unexpected status code %v", w.Code)
 			}
+			// the change only takes effect after confirming it
+			if cookies := w.Result().Cookies(); len(cookies) != 0 {
+				t.Errorf("Unexpected cookie values in response %v", cookies)
+			}
+		})
+	}
+}
 
-			cookies := w.Result().Cookies()
-			if test.expectClearedCookie {
-				authCookie := cookies[0]
-				if authCookie.Name != "auth" {
-					t.Errorf("Unexpected cookie name %v", authCookie.Name)
-				}
-				if authCookie.Value != "" {
-					t.Errorf("Unexpected non-empty cookie value %v", authCookie.Value)
-				}
+type mockPostConfirmEmailChangeDatabase struct {
+	persistence.Service
+	err     error
+	revoked string
+}
 
-			} else {
-				if len(cookies) != 0 {
-					t.Errorf("Unexpected cookie values in response %v", cookies)
-				}
+func (m *mockPostConfirmEmailChangeDatabase) ConfirmEmailChange(string, string, []byte) error {
+	return m.err
+}
+
+func (m *mockPostConfirmEmailChangeDatabase) RevokeSessions(accountUserID string) error {
+	m.revoked = accountUserID
+	return nil
+}
+
+func TestRouter_postConfirmEmailChange(t *testing.T) {
+	signer := securecookie.New([]byte("abc"), nil)
+	token, _ := signer.Encode("credentials", changeEmailCredentials{
+		Token:         []byte("token"),
+		AccountUserID: "account-user",
+		EmailAddress:  "new@me.net",
+	})
+	tests := []struct {
+		name           string
+		db             mockPostConfirmEmailChangeDatabase
+		body           io.Reader
+		expectedStatus int
+	}{
+		{
+			"bad payload",
+			mockPostConfirmEmailChangeDatabase{},
+			strings.NewReader("891ä##"),
+			http.StatusBadRequest,
+		},
+		{
+			"bad token",
+			mockPostConfirmEmailChangeDatabase{},
+			strings.NewReader(`{"token":"abc"}`),
+			http.StatusBadRequest,
+		},
+		{
+			"expired",
+			mockPostConfirmEmailChangeDatabase{
+				err: persistence.ErrInvalidOneTimeKey("did not work"),
+			},
+			strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, token)),
+			http.StatusBadRequest,
+		},
+		{
+			"ok",
+			mockPostConfirmEmailChangeDatabase{},
+			strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, token)),
+			http.StatusNoContent,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := gin.New()
+			rt := router{
+				config:       &config.Config{},
+				db:           &test.db,
+				cookieSigner: signer,
+			}
+			m.POST("/", rt.postConfirmEmailChange)
+			r := httptest.NewRequest(http.MethodPost, "/", test.body)
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if test.expectedStatus != http.StatusNoContent {
+				return
+			}
+			if test.db.revoked != "account-user" {
+				t.Errorf("Expected sessions to be revoked, got %v", test.db.revoked)
+			}
+			cookies := w.Result().Cookies()
+			if len(cookies) != 2 || cookies[0].Name != "auth" || cookies[0].Value != "" || cookies[1].Name != "refresh" || cookies[1].Value != "" {
+				t.Errorf("Expected auth and refresh cookies to be cleared, got %v", cookies)
 			}
 		})
 	}
@@ -361,11 +756,20 @@ func TestRouter_postChangeEmail(t *testing.T) {
 
 type mockPostResetPasswordDatabase struct {
 	persistence.Service
-	err error
+	err     error
+	revoked string
 }
 
-func (m *mockPostResetPasswordDatabase) ResetPassword(string, string, []byte) error {
-	return m.err
+func (m *mockPostResetPasswordDatabase) ResetPassword(string, string, []byte) (string, error) {
+	if m.err != nil {
+		return "", m.err
+	}
+	return "account-user", nil
+}
+
+func (m *mockPostResetPasswordDatabase) RevokeSessions(accountUserID string) error {
+	m.revoked = accountUserID
+	return nil
 }
 
 func TestRouter_postResetPassword(t *testing.T) {
@@ -375,18 +779,21 @@ func TestRouter_postResetPassword(t *testing.T) {
 		body               io.Reader
 		db                 mockPostResetPasswordDatabase
 		expectedStatusCode int
+		expectedRevoked    string
 	}{
 		{
 			"bad payload",
 			strings.NewReader(",,,....##äö"),
 			mockPostResetPasswordDatabase{},
 			http.StatusBadRequest,
+			"",
 		},
 		{
 			"bad token",
 			strings.NewReader(`{"emailAddress":"hioffen@posteo.de","password":"new","token":"made up token"}`),
 			mockPostResetPasswordDatabase{},
 			http.StatusBadRequest,
+			"",
 		},
 		{
 			"token mismatch",
@@ -402,6 +809,7 @@ func TestRouter_postResetPassword(t *testing.T) {
 			}(),
 			mockPostResetPasswordDatabase{},
 			http.StatusBadRequest,
+			"",
 		},
 		{
 			"db error",
@@ -419,6 +827,7 @@ func TestRouter_postResetPassword(t *testing.T) {
 				err: errors.New("did not work"),
 			},
 			http.StatusNoContent,
+			"",
 		},
 		{
 			"ok",
@@ -434,6 +843,7 @@ func TestRouter_postResetPassword(t *testing.T) {
 			}(),
 			mockPostResetPasswordDatabase{},
 			http.StatusNoContent,
+			"account-user",
 		},
 	}
 
@@ -453,6 +863,9 @@ func TestRouter_postResetPassword(t *testing.T) {
 			if w.Code != test.expectedStatusCode {
 				t.Errorf("Unexpected status code %v", w.Code)
 			}
+			if test.db.revoked != test.expectedRevoked {
+				t.Errorf("Expected sessions of %q to be revoked, got %q", test.expectedRevoked, test.db.revoked)
+			}
 		})
 	}
 }
@@ -467,6 +880,10 @@ func (m *mockPostForgotPasswordDatabase) GenerateOneTimeKey(string) ([]byte, err
 	return m.result, m.err
 }
 
+func (m *mockPostForgotPasswordDatabase) RecordAuthEvent(string, string, string, string, string) error {
+	return nil
+}
+
 type mockMailer struct {
 	err error
 }
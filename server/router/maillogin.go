@@ -0,0 +1,236 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/keys"
+	"github.com/offen/offen/server/persistence"
+	"github.com/patrickmn/go-cache"
+)
+
+type magicLinkRequest struct {
+	EmailAddress string `json:"emailAddress"`
+	URLTemplate  string `json:"urlTemplate"`
+}
+
+type magicLinkCredentials struct {
+	EmailAddress string
+	Nonce        []byte
+	Expires      time.Time
+}
+
+// getConsumedTokens returns the cache of single use tokens that have already
+// been used.
+func (rt *router) getConsumedTokens() *cache.Cache {
+	if rt.consumedTokens == nil {
+		rt.consumedTokens = cache.New(time.Hour, time.Hour)
+	}
+	return rt.consumedTokens
+}
+
+func (rt *router) postMagicLink(c *gin.Context) {
+	if !rt.config.MagicLink.Enabled {
+		newJSONError(
+			errors.New("router: logging in using email is not enabled"),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	// this route responds to erroneous requests with 204 status codes on
+	// purpose in order not to leak information about existing accounts
+	var req magicLinkRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request body: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if l := <-rt.getLimiter().ExponentialThrottle(time.Second*5, fmt.Sprintf("postMagicLink-%s", req.EmailAddress)); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error applying rate limit: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
+	// we rate limit this twice to prevent floodding with arbitrary emails
+	if l := <-rt.getLimiter().LinearThrottle(time.Second, "postMagicLink-*"); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error applying rate limit: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
+	if !rt.db.ProbeAccountUser(req.EmailAddress) {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	nonce, err := keys.GenerateRandomBytes(keys.DefaultSecretLength)
+	if err != nil {
+		rt.logError(c, err, "error generating nonce")
+		c.Status(http.StatusNoContent)
+		return
+	}
+	signedCredentials, signErr := rt.cookieSigner.Encode("magic-link", magicLinkCredentials{
+		EmailAddress: req.EmailAddress,
+		Nonce:        nonce,
+		Expires:      time.Now().Add(rt.config.MagicLink.TTL),
+	})
+	if signErr != nil {
+		rt.logError(c, signErr, "error signing token")
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	loginURL := strings.Replace(req.URLTemplate, "{token}", signedCredentials, -1)
+
+	subject, body := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	if err := rt.emails.ExecuteTemplate(subject, "subject_magic_link", nil); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error rendering email subject: %v", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	if err := rt.emails.ExecuteTemplate(body, "body_magic_link", map[string]string{"url": loginURL}); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error rendering email body: %v", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	if err := rt.mailer.Send(rt.config.SMTP.Sender, req.EmailAddress, subject.String(), body.String()); err != nil {
+		newJSONError(
+			fmt.Errorf("error sending email message: %v", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type loginMagicLinkRequest struct {
+	Token        string `json:"token"`
+	SecondFactor string `json:"secondFactor"`
+}
+
+func (rt *router) postLoginMagicLink(c *gin.Context) {
+	if !rt.config.MagicLink.Enabled {
+		newJSONError(
+			errors.New("router: logging in using email is not enabled"),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	var req loginMagicLinkRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request body: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	var credentials magicLinkCredentials
+	if err := rt.decodeSigned("magic-link", req.Token, &credentials); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding signed token: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	if time.Now().After(credentials.Expires) {
+		newJSONError(
+			errors.New("router: token has expired"),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if l := <-rt.getLimiter().ExponentialThrottle(time.Second, fmt.Sprintf("postLoginMagicLink-%s", credentials.EmailAddress)); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error applying rate limit: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
+	result, err := rt.db.LoginWithEmail(credentials.EmailAddress, req.SecondFactor)
+	observeLogin("magic_link", err)
+	if err != nil {
+		switch {
+		case errors.Is(err, persistence.ErrSecondFactorRequired):
+		case errors.Is(err, persistence.ErrInvalidSecondFactor):
+			rt.recordAuthEvent(c, "", credentials.EmailAddress, persistence.AuthEventSecondFactorFailed)
+		default:
+			rt.recordAuthEvent(c, "", credentials.EmailAddress, persistence.AuthEventLoginFailed)
+		}
+		// the token is not consumed in case a second factor is missing so
+		// the client can retry the request including a code
+		if errors.Is(err, persistence.ErrSecondFactorRequired) {
+			newJSONError(
+				errors.New("router: second factor required"),
+				http.StatusUnauthorized,
+			).Pipe(c)
+			return
+		}
+		if !errors.Is(err, persistence.ErrInvalidCredentials) {
+			rt.logError(c, err, "error logging in using email")
+		}
+		newJSONError(
+			errors.New("router: invalid credentials"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+
+	// tokens can only be used once, so the nonce is stored until the token
+	// would have expired anyways
+	if err := rt.getConsumedTokens().Add(
+		hex.EncodeToString(credentials.Nonce), true, time.Until(credentials.Expires),
+	); err != nil {
+		newJSONError(
+			errors.New("router: token has already been used"),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if !rt.networkAllowed(c, result.AllowedNetworks) {
+		newJSONError(
+			errors.New("router: access from this network is not allowed"),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+	rt.recordAuthEvent(c, result.AccountUserID, "", persistence.AuthEventLoginSucceeded)
+
+	authCookie, authCookieErr := rt.sessionCookie(c, result.AccountUserID)
+	if authCookieErr != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating auth cookie: %w", authCookieErr),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	http.SetCookie(c.Writer, authCookie)
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockLoginMagicLinkDatabase struct {
+	persistence.Service
+	err        error
+	networks   []string
+	authEvents []string
+}
+
+func (m *mockLoginMagicLinkDatabase) LoginWithEmail(email, code string) (persistence.LoginResult, error) {
+	return persistence.LoginResult{AccountUserID: "user-a", AllowedNetworks: m.networks}, m.err
+}
+
+func (m *mockLoginMagicLinkDatabase) RecordAuthEvent(accountUserID, emailAddress, eventType, ipAddress, userAgent string) error {
+	m.authEvents = append(m.authEvents, eventType)
+	return nil
+}
+
+func (m *mockLoginMagicLinkDatabase) CreateSession(string, string, string, time.Duration, time.Duration) (persistence.SessionResult, error) {
+	return persistence.SessionResult{SessionID: "session-id"}, nil
+}
+
+func TestRouter_postLoginMagicLink(t *testing.T) {
+	signer := securecookie.New([]byte("abc"), nil)
+	sign := func(expires time.Time) string {
+		token, _ := signer.Encode("magic-link", magicLinkCredentials{
+			EmailAddress: "develop@offen.dev",
+			Nonce:        []byte(expires.String()),
+			Expires:      expires,
+		})
+		return token
+	}
+	validToken := sign(time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name               string
+		enabled            bool
+		err                error
+		networks           []string
+		token              string
+		expectedStatusCode int
+	}{
+		{"disabled", false, nil, nil, validToken, http.StatusForbidden},
+		{"bad token", true, nil, nil, "abc", http.StatusBadRequest},
+		{"expired", true, nil, nil, sign(time.Now().Add(-time.Hour)), http.StatusBadRequest},
+		{"second factor required", true, persistence.ErrSecondFactorRequired, nil, validToken, http.StatusUnauthorized},
+		{"bad login", true, persistence.ErrInvalidCredentials, nil, validToken, http.StatusUnauthorized},
+		{"network not allowed", true, nil, []string{"10.0.0.0/8"}, sign(time.Now().Add(2 * time.Hour)), http.StatusForbidden},
+		{"network allowed", true, nil, []string{"192.0.2.0/24"}, sign(time.Now().Add(3 * time.Hour)), http.StatusOK},
+		{"ok", true, nil, nil, validToken, http.StatusOK},
+		{"reused", true, nil, nil, validToken, http.StatusBadRequest},
+	}
+
+	cfg := &config.Config{}
+	rt := router{
+		config:       cfg,
+		cookieSigner: signer,
+		signingKeys:  mustSigningKeyring("abc"),
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg.MagicLink.Enabled = test.enabled
+			rt.db = &mockLoginMagicLinkDatabase{err: test.err, networks: test.networks}
+			m := gin.New()
+			m.POST("/", rt.postLoginMagicLink)
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, test.token)))
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if cookies := w.Result().Cookies(); (len(cookies) == 1) != (w.Code == http.StatusOK) {
+				t.Errorf("Unexpected cookies %v", cookies)
+			}
+		})
+	}
+}
+
+func TestRouter_postLoginMagicLink_AuthEvents(t *testing.T) {
+	signer := securecookie.New([]byte("abc"), nil)
+	tests := []struct {
+		name           string
+		err            error
+		expectedEvents []string
+	}{
+		{"ok", nil, []string{persistence.AuthEventLoginSucceeded}},
+		{"invalid credentials", persistence.ErrInvalidCredentials, []string{persistence.AuthEventLoginFailed}},
+		{"invalid second factor", persistence.ErrInvalidSecondFactor, []string{persistence.AuthEventSecondFactorFailed}},
+		{"second factor required", persistence.ErrSecondFactorRequired, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.MagicLink.Enabled = true
+			db := &mockLoginMagicLinkDatabase{err: test.err}
+			rt := router{
+				config:       cfg,
+				db:           db,
+				cookieSigner: signer,
+				signingKeys:  mustSigningKeyring("abc"),
+			}
+			token, _ := signer.Encode("magic-link", magicLinkCredentials{
+				EmailAddress: "develop@offen.dev",
+				Nonce:        []byte(test.name),
+				Expires:      time.Now().Add(time.Hour),
+			})
+			m := gin.New()
+			m.POST("/", rt.postLoginMagicLink)
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, token))))
+			if !reflect.DeepEqual(db.authEvents, test.expectedEvents) {
+				t.Errorf("Expected events %v, got %v", test.expectedEvents, db.authEvents)
+			}
+		})
+	}
+}
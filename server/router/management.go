@@ -21,6 +21,8 @@ type shareAccountRequest struct {
 	ProviderPassword     string `json:"password"`
 	URLTemplate          string `json:"urlTemplate"`
 	GrantAdminPrivileges bool   `json:"grantAdminPrivileges"`
+	// Role is the role the invitee is granted for the shared accounts
+	Role persistence.AccountUserRole `json:"role"`
 }
 
 func (rt *router) postShareAccount(c *gin.Context) {
@@ -51,6 +53,13 @@ func (rt *router) postShareAccount(c *gin.Context) {
 			).Pipe(c)
 			return
 		}
+		if !accountUser.CanManageAccount(accountID) {
+			newJSONError(
+				fmt.Errorf("router: user is not allowed to share account %s", accountID),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
 	}
 
 	if l := <-rt.getLimiter().ExponentialThrottle(time.Second, fmt.Sprintf("postShareAccount-%s", accountUser.AccountUserID)); l.Error != nil {
@@ -81,16 +90,23 @@ func (rt *router) postShareAccount(c *gin.Context) {
 		return
 	}
 
-	if !accountInRequest.IsSuperAdmin() {
+	if req.GrantAdminPrivileges && !accountInRequest.IsSuperAdmin() {
 		newJSONError(
-			errors.New("router: given credentials are not allowed to share accounts"),
-			http.StatusBadRequest,
+			errors.New("router: given credentials are not allowed to grant admin privileges"),
+			http.StatusForbidden,
 		).Pipe(c)
 		return
 	}
 
-	result, err := rt.db.ShareAccount(req.InviteeEmailAddress, req.ProviderEmailAddress, req.ProviderPassword, c.Param("accountID"), req.GrantAdminPrivileges)
+	result, err := rt.db.ShareAccount(req.InviteeEmailAddress, req.ProviderEmailAddress, req.ProviderPassword, c.Param("accountID"), req.GrantAdminPrivileges, req.Role)
 	if err != nil {
+		if errors.Is(err, persistence.ErrPermissionDenied) {
+			newJSONError(
+				errors.New("router: given credentials are not allowed to share accounts"),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
 		newJSONError(
 			fmt.Errorf("router: error inviting user: %w", err),
 			http.StatusBadRequest,
@@ -107,6 +123,7 @@ func (rt *router) postShareAccount(c *gin.Context) {
 		).Pipe(c)
 		return
 	}
+	rt.dispatchAccountUserAdded(c, result.AccountIDs, req.Role)
 
 	var bodyErr error
 	var subjectErr error
@@ -117,7 +134,7 @@ func (rt *router) postShareAccount(c *gin.Context) {
 	} else {
 		signedCredentials, signErr := rt.cookieSigner.MaxAge(7*24*60*60).Encode("credentials", req.InviteeEmailAddress)
 		if signErr != nil {
-			rt.logError(signErr, "error signing token")
+			rt.logError(c, signErr, "error signing token")
 			c.Status(http.StatusNoContent)
 			return
 		}
@@ -161,7 +178,7 @@ func (rt *router) postJoin(c *gin.Context) {
 		return
 	}
 	var email string
-	if err := rt.cookieSigner.Decode("credentials", req.Token, &email); err != nil {
+	if err := rt.decodeSigned("credentials", req.Token, &email); err != nil {
 		newJSONError(
 			fmt.Errorf("error decoding signed token: %w", err),
 			http.StatusBadRequest,
@@ -194,7 +211,370 @@ func (rt *router) postJoin(c *gin.Context) {
 	}
 
 	if err := rt.db.Join(req.EmailAddress, req.Password); err != nil {
-		rt.logError(err, "error joining")
+		rt.logError(c, err, "error joining")
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type inviteRequest struct {
+	InviteeEmailAddress  string   `json:"invitee"`
+	ProviderEmailAddress string   `json:"emailAddress"`
+	ProviderPassword     string   `json:"password"`
+	URLTemplate          string   `json:"urlTemplate"`
+	AccountIDs           []string `json:"accountIds"`
+	// Role is the role the invitee is granted for the given accounts
+	Role persistence.AccountUserRole `json:"role"`
+}
+
+type invitationCredentials struct {
+	Token        []byte
+	EmailAddress string
+}
+
+func (rt *router) postInvite(c *gin.Context) {
+	var req inviteRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding response body: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: could not find account user object in request context"),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	for _, accountID := range req.AccountIDs {
+		if !accountUser.CanManageAccount(accountID) {
+			newJSONError(
+				fmt.Errorf("router: user is not allowed to invite users to account %s", accountID),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
+	}
+
+	if l := <-rt.getLimiter().ExponentialThrottle(time.Second, fmt.Sprintf("postInvite-%s", accountUser.AccountUserID)); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error rate limiting request: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
+	// the given credentials might not be valid
+	accountInRequest, err := rt.db.Login(req.ProviderEmailAddress, req.ProviderPassword)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error validating given credentials: %w", err),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+
+	// the given credentials might be valid, but belong to a different user
+	// than the one who is calling this
+	if accountInRequest.AccountUserID != accountUser.AccountUserID {
+		newJSONError(
+			fmt.Errorf("router: given credentials belong to user other than requester with id %s", accountUser.AccountUserID),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	result, err := rt.db.Invite(req.InviteeEmailAddress, req.ProviderEmailAddress, req.ProviderPassword, req.AccountIDs, req.Role)
+	if err != nil {
+		if errors.Is(err, persistence.ErrPermissionDenied) {
+			newJSONError(
+				errors.New("router: given credentials are not allowed to invite users"),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error inviting user: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if len(result.AccountNames) == 0 {
+		newJSONError(
+			fmt.Errorf("router: user already has access to all requested accounts"),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	rt.dispatchAccountUserAdded(c, result.AccountIDs, req.Role)
+
+	// the token is only ever sent to the invitee, so the provider does not
+	// learn anything that could be used for accessing the invitee's keys
+	signedCredentials, err := rt.cookieSigner.MaxAge(int(persistence.InvitationTTL/time.Second)).Encode("credentials", invitationCredentials{
+		Token:        result.Token,
+		EmailAddress: req.InviteeEmailAddress,
+	})
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error signing token: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	acceptURL := strings.Replace(req.URLTemplate, "{token}", signedCredentials, -1)
+
+	body, subject := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	bodyErr := rt.emails.ExecuteTemplate(body, "body_invitation", map[string]interface{}{"url": acceptURL, "accountNames": result.AccountNames})
+	subjectErr := rt.emails.ExecuteTemplate(subject, "subject_invitation", nil)
+	for _, err := range []error{bodyErr, subjectErr} {
+		if err != nil {
+			newJSONError(
+				fmt.Errorf("router: error rendering email message: %v", err),
+				http.StatusInternalServerError,
+			).Pipe(c)
+			return
+		}
+	}
+	if err := rt.mailer.Send(rt.config.SMTP.Sender, req.InviteeEmailAddress, subject.String(), body.String()); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error sending email message: %v", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type acceptInvitationRequest struct {
+	EmailAddress string `json:"emailAddress"`
+	Password     string `json:"password"`
+	Token        string `json:"token"`
+}
+
+func (rt *router) postAcceptInvitation(c *gin.Context) {
+	var req acceptInvitationRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding response body: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	var credentials invitationCredentials
+	if err := rt.decodeSigned("credentials", req.Token, &credentials); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding signed token: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	if credentials.EmailAddress != req.EmailAddress {
+		newJSONError(
+			errors.New("router: given email address did not match token"),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if l := <-rt.getLimiter().ExponentialThrottle(time.Second, fmt.Sprintf("postAcceptInvitation-%s", req.EmailAddress)); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error rate limiting request: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
+	if err := rt.db.AcceptInvitation(req.EmailAddress, req.Password, credentials.Token); err != nil {
+		var invalidKey persistence.ErrInvalidOneTimeKey
+		if errors.As(err, &invalidKey) {
+			newJSONError(
+				errors.New("router: invitation has expired or has already been accepted"),
+				http.StatusBadRequest,
+			).Pipe(c)
+			return
+		}
+		if errors.Is(err, persistence.ErrInvalidCredentials) {
+			newJSONError(
+				errors.New("router: invalid credentials"),
+				http.StatusUnauthorized,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error accepting invitation: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type suspensionRequest struct {
+	Suspended bool `json:"suspended"`
+}
+
+func (rt *router) postSuspension(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	var req suspensionRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	accountUserID := c.Param("accountUserID")
+	if err := rt.db.SuspendAccountUser(accountUser.AccountUserID, accountUserID, req.Suspended); err != nil {
+		if errors.Is(err, persistence.ErrPermissionDenied) {
+			newJSONError(
+				errors.New("router: only super admins are allowed to suspend account users"),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error changing suspension of account user: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if rt.logger != nil {
+		rt.logger.
+			WithField("audit", "suspension").
+			WithField("accountUserId", accountUserID).
+			WithField("suspended", req.Suspended).
+			WithField("by", accountUser.AccountUserID).
+			Info("Changed suspension of account user")
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type allowedNetworksRequest struct {
+	Networks []string `json:"networks"`
+}
+
+func (rt *router) postAllowedNetworks(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	var req allowedNetworksRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	accountUserID := c.Param("accountUserID")
+	if err := rt.db.SetAllowedNetworks(accountUser.AccountUserID, accountUserID, req.Networks); err != nil {
+		if errors.Is(err, persistence.ErrPermissionDenied) {
+			newJSONError(
+				errors.New("router: only super admins are allowed to restrict networks of account users"),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error setting allowed networks of account user: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if rt.logger != nil {
+		rt.logger.
+			WithField("audit", "allowed_networks").
+			WithField("accountUserId", accountUserID).
+			WithField("networks", req.Networks).
+			WithField("by", accountUser.AccountUserID).
+			Info("Changed allowed networks of account user")
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// postImpersonation opens a read-only session for the given account user on
+// behalf of the requesting super admin, replacing the admin's own session.
+func (rt *router) postImpersonation(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	var designated bool
+	for _, id := range rt.config.Impersonation.Admins {
+		if id == accountUser.AccountUserID {
+			designated = true
+			break
+		}
+	}
+	if !designated {
+		newJSONError(
+			errors.New("router: not allowed to impersonate account users"),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	accountUserID := c.Param("accountUserID")
+	session, err := rt.db.Impersonate(accountUser.AccountUserID, accountUserID, rt.clientIP(c), c.Request.UserAgent(), rt.config.Impersonation.TTL)
+	if err != nil {
+		if errors.Is(err, persistence.ErrPermissionDenied) {
+			newJSONError(
+				errors.New("router: not allowed to impersonate account users"),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error impersonating account user: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	authCookie, err := rt.authCookie(session.SessionID, c.GetBool(contextKeySecureContext))
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating auth cookie: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	if rt.logger != nil {
+		rt.logger.
+			WithField("audit", "impersonation").
+			WithField("accountUserId", accountUserID).
+			WithField("by", accountUser.AccountUserID).
+			WithField("expires", session.Expires).
+			Info("Started impersonation of account user")
 	}
+	http.SetCookie(c.Writer, authCookie)
 	c.Status(http.StatusNoContent)
 }
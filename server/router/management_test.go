@@ -4,6 +4,7 @@
 package router
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"html/template"
@@ -12,11 +13,13 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/securecookie"
 	"github.com/offen/offen/server/config"
 	"github.com/offen/offen/server/persistence"
+	"github.com/sirupsen/logrus"
 )
 
 type mockPostShareAccountDatabase struct {
@@ -27,7 +30,7 @@ type mockPostShareAccountDatabase struct {
 	loginErr           error
 }
 
-func (m *mockPostShareAccountDatabase) ShareAccount(string, string, string, string, bool) (persistence.ShareAccountResult, error) {
+func (m *mockPostShareAccountDatabase) ShareAccount(string, string, string, string, bool, persistence.AccountUserRole) (persistence.ShareAccountResult, error) {
 	return m.shareAccountResult, m.shareAccountErr
 }
 
@@ -150,7 +153,7 @@ func TestRouter_postShareAccount(t *testing.T) {
 			http.StatusBadRequest,
 		},
 		{
-			"requester is not super admin",
+			"requester is viewer",
 			"account-a-id",
 			mockPostShareAccountDatabase{
 				loginResult: persistence.LoginResult{
@@ -168,7 +171,53 @@ func TestRouter_postShareAccount(t *testing.T) {
 			},
 			strings.NewReader(`{"invitee":"mail@offen.dev","emailAddress":"hioffen@posteo.de","password":"ok","urlTemplate":"/join/{token}","grantAdminPrivileges":false}`),
 			mockMailer{},
-			http.StatusBadRequest,
+			http.StatusForbidden,
+		},
+		{
+			"account admin granting admin privileges",
+			"account-a-id",
+			mockPostShareAccountDatabase{
+				loginResult: persistence.LoginResult{
+					AccountUserID: "account-user-id",
+					Accounts: []persistence.LoginAccountResult{
+						{AccountID: "account-a-id", Role: persistence.AccountUserRoleAdmin},
+					},
+				},
+			},
+			persistence.LoginResult{
+				AccountUserID: "account-user-id",
+				Accounts: []persistence.LoginAccountResult{
+					{AccountID: "account-a-id", Role: persistence.AccountUserRoleAdmin},
+				},
+			},
+			strings.NewReader(`{"invitee":"mail@offen.dev","emailAddress":"hioffen@posteo.de","password":"ok","urlTemplate":"/join/{token}","grantAdminPrivileges":true}`),
+			mockMailer{},
+			http.StatusForbidden,
+		},
+		{
+			"ok account admin",
+			"account-a-id",
+			mockPostShareAccountDatabase{
+				loginResult: persistence.LoginResult{
+					AccountUserID: "account-user-id",
+					Accounts: []persistence.LoginAccountResult{
+						{AccountID: "account-a-id", Role: persistence.AccountUserRoleAdmin},
+					},
+				},
+				shareAccountResult: persistence.ShareAccountResult{
+					UserExistsWithPassword: true,
+					AccountNames:           []string{"Account A"},
+				},
+			},
+			persistence.LoginResult{
+				AccountUserID: "account-user-id",
+				Accounts: []persistence.LoginAccountResult{
+					{AccountID: "account-a-id", Role: persistence.AccountUserRoleAdmin},
+				},
+			},
+			strings.NewReader(`{"invitee":"mail@offen.dev","emailAddress":"hioffen@posteo.de","password":"ok","urlTemplate":"/join/{token}","grantAdminPrivileges":false,"role":1}`),
+			mockMailer{},
+			http.StatusNoContent,
 		},
 		{
 			"share error",
@@ -436,3 +485,289 @@ func TestRouter_postJoin(t *testing.T) {
 		})
 	}
 }
+
+type mockPostAcceptInvitationDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockPostAcceptInvitationDatabase) AcceptInvitation(string, string, []byte) error {
+	return m.err
+}
+
+func TestRouter_postAcceptInvitation(t *testing.T) {
+	signer := securecookie.New([]byte("abc"), nil)
+	validToken := func(email string) io.Reader {
+		token, _ := signer.Encode("credentials", invitationCredentials{
+			Token:        []byte("token"),
+			EmailAddress: email,
+		})
+		return strings.NewReader(
+			fmt.Sprintf(
+				`{"emailAddress":"hioffen@posteo.de","password":"pass","token":"%s"}`,
+				token,
+			),
+		)
+	}
+	tests := []struct {
+		name               string
+		db                 mockPostAcceptInvitationDatabase
+		body               io.Reader
+		expectedStatusCode int
+	}{
+		{
+			"bad payload",
+			mockPostAcceptInvitationDatabase{},
+			strings.NewReader("xxx"),
+			http.StatusBadRequest,
+		},
+		{
+			"bad token",
+			mockPostAcceptInvitationDatabase{},
+			strings.NewReader(`{"emailAddress":"hioffen@posteo.de","password":"pass","token":"something something"}`),
+			http.StatusBadRequest,
+		},
+		{
+			"email mismatch",
+			mockPostAcceptInvitationDatabase{},
+			validToken("mail@offen.dev"),
+			http.StatusBadRequest,
+		},
+		{
+			"bad credentials",
+			mockPostAcceptInvitationDatabase{
+				err: persistence.ErrInvalidCredentials,
+			},
+			validToken("hioffen@posteo.de"),
+			http.StatusUnauthorized,
+		},
+		{
+			"consumed invitation",
+			mockPostAcceptInvitationDatabase{
+				err: fmt.Errorf("wrapped: %w", persistence.ErrInvalidOneTimeKey("used")),
+			},
+			validToken("hioffen@posteo.de"),
+			http.StatusBadRequest,
+		},
+		{
+			"ok",
+			mockPostAcceptInvitationDatabase{},
+			validToken("hioffen@posteo.de"),
+			http.StatusNoContent,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{
+				db:           &test.db,
+				cookieSigner: signer,
+			}
+
+			m := gin.New()
+			m.POST("/", rt.postAcceptInvitation)
+
+			r := httptest.NewRequest(http.MethodPost, "/", test.body)
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+		})
+	}
+}
+
+type mockSuspensionDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockSuspensionDatabase) SuspendAccountUser(adminUserID, accountUserID string, suspended bool) error {
+	return m.err
+}
+
+func TestRouter_postSuspension(t *testing.T) {
+	tests := []struct {
+		name               string
+		db                 mockSuspensionDatabase
+		body               io.Reader
+		expectedStatusCode int
+		expectAuditLog     bool
+	}{
+		{
+			"bad payload",
+			mockSuspensionDatabase{},
+			strings.NewReader("xxx"),
+			http.StatusBadRequest,
+			false,
+		},
+		{
+			"not a super admin",
+			mockSuspensionDatabase{err: persistence.ErrPermissionDenied},
+			strings.NewReader(`{"suspended":true}`),
+			http.StatusForbidden,
+			false,
+		},
+		{
+			"database error",
+			mockSuspensionDatabase{err: errors.New("did not work")},
+			strings.NewReader(`{"suspended":true}`),
+			http.StatusBadRequest,
+			false,
+		},
+		{
+			"ok",
+			mockSuspensionDatabase{},
+			strings.NewReader(`{"suspended":true}`),
+			http.StatusNoContent,
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := logrus.New()
+			logger.Out = &buf
+			rt := router{db: &test.db, logger: logger}
+			m := gin.New()
+			m.POST("/:accountUserID", func(c *gin.Context) {
+				c.Set(contextKeyAuth, persistence.LoginResult{AccountUserID: "admin"})
+			}, rt.postSuspension)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/user", test.body))
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if strings.Contains(buf.String(), "audit=suspension") != test.expectAuditLog {
+				t.Errorf("Unexpected audit log %v", buf.String())
+			}
+		})
+	}
+}
+
+type mockAllowedNetworksDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockAllowedNetworksDatabase) SetAllowedNetworks(adminUserID, accountUserID string, networks []string) error {
+	return m.err
+}
+
+func TestRouter_postAllowedNetworks(t *testing.T) {
+	tests := []struct {
+		name               string
+		db                 mockAllowedNetworksDatabase
+		body               io.Reader
+		expectedStatusCode int
+		expectAuditLog     bool
+	}{
+		{
+			"bad payload",
+			mockAllowedNetworksDatabase{},
+			strings.NewReader("xxx"),
+			http.StatusBadRequest,
+			false,
+		},
+		{
+			"not a super admin",
+			mockAllowedNetworksDatabase{err: persistence.ErrPermissionDenied},
+			strings.NewReader(`{"networks":["10.0.0.0/8"]}`),
+			http.StatusForbidden,
+			false,
+		},
+		{
+			"invalid network",
+			mockAllowedNetworksDatabase{err: errors.New("invalid network")},
+			strings.NewReader(`{"networks":["10.0.0.0/33"]}`),
+			http.StatusBadRequest,
+			false,
+		},
+		{
+			"ok",
+			mockAllowedNetworksDatabase{},
+			strings.NewReader(`{"networks":["10.0.0.0/8"]}`),
+			http.StatusNoContent,
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := logrus.New()
+			logger.Out = &buf
+			rt := router{db: &test.db, logger: logger}
+			m := gin.New()
+			m.POST("/:accountUserID", func(c *gin.Context) {
+				c.Set(contextKeyAuth, persistence.LoginResult{AccountUserID: "admin"})
+			}, rt.postAllowedNetworks)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/user", test.body))
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if strings.Contains(buf.String(), "audit=allowed_networks") != test.expectAuditLog {
+				t.Errorf("Unexpected audit log %v", buf.String())
+			}
+		})
+	}
+}
+
+type mockImpersonationDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockImpersonationDatabase) Impersonate(adminUserID, accountUserID, ipAddress, userAgent string, ttl time.Duration) (persistence.SessionResult, error) {
+	return persistence.SessionResult{SessionID: "session-a", AccountUserID: accountUserID, ImpersonatedBy: adminUserID}, m.err
+}
+
+func TestRouter_postImpersonation(t *testing.T) {
+	tests := []struct {
+		name               string
+		db                 mockImpersonationDatabase
+		admins             []string
+		expectedStatusCode int
+		expectCookie       bool
+	}{
+		{"not designated", mockImpersonationDatabase{}, []string{"other"}, http.StatusForbidden, false},
+		{"not a super admin", mockImpersonationDatabase{err: persistence.ErrPermissionDenied}, []string{"admin"}, http.StatusForbidden, false},
+		{"database error", mockImpersonationDatabase{err: errors.New("did not work")}, []string{"admin"}, http.StatusBadRequest, false},
+		{"ok", mockImpersonationDatabase{}, []string{"other", "admin"}, http.StatusNoContent, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := logrus.New()
+			logger.Out = &buf
+			cfg := &config.Config{}
+			cfg.Impersonation.Admins = test.admins
+			cfg.Impersonation.TTL = time.Minute
+			rt := router{
+				config:      cfg,
+				db:          &test.db,
+				logger:      logger,
+				signingKeys: mustSigningKeyring("abc"),
+			}
+			m := gin.New()
+			m.POST("/:accountUserID", func(c *gin.Context) {
+				c.Set(contextKeyAuth, persistence.LoginResult{AccountUserID: "admin"})
+			}, rt.postImpersonation)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/user", nil))
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if (len(w.Result().Cookies()) == 1) != test.expectCookie {
+				t.Errorf("Unexpected cookies %v", w.Result().Cookies())
+			}
+			if strings.Contains(buf.String(), "audit=impersonation") != test.expectCookie {
+				t.Errorf("Unexpected audit log %v", buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/metrics"
+	"github.com/offen/offen/server/persistence"
+)
+
+// observeLogin counts a login attempt using the given method.
+func observeLogin(method string, err error) {
+	result := "success"
+	switch {
+	case errors.Is(err, persistence.ErrSecondFactorRequired):
+		result = "second_factor_required"
+	case err != nil:
+		result = "failure"
+	}
+	metrics.LoginAttempts.Inc(method, result)
+}
+
+// metricsMiddleware counts all handled requests and observes their latency.
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		metrics.HTTPRequests.Inc(c.Request.Method, strconv.Itoa(c.Writer.Status()))
+		metrics.HTTPRequestDuration.ObserveSince(start, c.Request.Method)
+	}
+}
+
+// getMetrics exposes the metrics of the application using the Prometheus
+// text format. In case a token is configured, it is required to be sent as
+// a bearer token.
+func (rt *router) getMetrics(c *gin.Context) {
+	if token := rt.config.Metrics.Token.String(); token != "" {
+		header := c.GetHeader("Authorization")
+		given := strings.TrimPrefix(header, "Bearer ")
+		if !strings.HasPrefix(header, "Bearer ") || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", "Bearer")
+			newJSONError(
+				errors.New("router: invalid metrics token"),
+				http.StatusUnauthorized,
+			).Pipe(c)
+			return
+		}
+	}
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	if _, err := metrics.Default.WriteTo(c.Writer); err != nil {
+		rt.logError(c, err, "error writing metrics")
+	}
+}
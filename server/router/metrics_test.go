@@ -0,0 +1,69 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestRouter_getMetrics(t *testing.T) {
+	tests := []struct {
+		name               string
+		token              string
+		header             string
+		expectedStatusCode int
+	}{
+		{"no token", "", "", http.StatusOK},
+		{"token", "secret", "Bearer secret", http.StatusOK},
+		{"bad token", "secret", "Bearer other", http.StatusUnauthorized},
+		{"missing scheme", "secret", "secret", http.StatusUnauthorized},
+		{"missing header", "secret", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{config: &config.Config{}}
+			rt.config.Metrics.Token = config.EnvString(test.token)
+			m := gin.New()
+			m.Use(metricsMiddleware())
+			m.GET("/", rt.getMetrics)
+			m.GET("/login", func(c *gin.Context) {
+				observeLogin("password", persistence.ErrInvalidCredentials)
+				c.Status(http.StatusUnauthorized)
+			})
+			m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/login", nil))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.header != "" {
+				r.Header.Set("Authorization", test.header)
+			}
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if test.expectedStatusCode != http.StatusOK {
+				return
+			}
+			if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+				t.Errorf("Unexpected content type %v", w.Header().Get("Content-Type"))
+			}
+			for _, expected := range []string{
+				`offen_login_attempts_total{method="password",result="failure"}`,
+				`offen_http_requests_total{method="GET",code="401"}`,
+				`offen_http_request_duration_seconds_count{method="GET"}`,
+			} {
+				if !strings.Contains(w.Body.String(), expected) {
+					t.Errorf("Expected %s in body %s", expected, w.Body.String())
+				}
+			}
+		})
+	}
+}
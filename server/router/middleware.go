@@ -6,13 +6,24 @@ package router
 import (
 	"bytes"
 	"crypto/md5"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-contrib/location"
 	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/ratelimiter"
+	"github.com/sirupsen/logrus"
 )
 
 func secureContextMiddleware(contextKey string, isDevelopment bool) gin.HandlerFunc {
@@ -23,6 +34,69 @@ func secureContextMiddleware(contextKey string, isDevelopment bool) gin.HandlerF
 	}
 }
 
+const requestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,128}$`)
+
+// requestIDMiddleware assigns an id to each request, honoring ids passed by
+// clients or proxies as long as they are safe to be logged. The id is
+// returned in the response and added to log entries and error responses.
+// Errors that caused a server error are logged once the request has been
+// handled.
+func (rt *router) requestIDMiddleware(contextKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			id, err := uuid.NewV4()
+			if err != nil {
+				newJSONError(
+					fmt.Errorf("router: error creating request id: %w", err),
+					http.StatusInternalServerError,
+				).Pipe(c)
+				return
+			}
+			requestID = id.String()
+		}
+		c.Set(contextKey, requestID)
+		c.Header(requestIDHeader, requestID)
+		c.Next()
+
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			for _, err := range c.Errors {
+				rt.logError(c, err.Err, "error handling request")
+			}
+		}
+	}
+}
+
+// accessLogMiddleware logs each request along with its latency. Status codes
+// are anonymized and no information about the client is logged.
+func (rt *router) accessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		if rt.logger == nil {
+			return
+		}
+		fields := logrus.Fields{
+			"method":    c.Request.Method,
+			"path":      c.Request.URL.Path,
+			"status":    anonymizeStatusCode(c.Writer.Status()),
+			"latencyMs": float64(time.Since(start)) / float64(time.Millisecond),
+		}
+		if requestID := c.GetString(contextKeyRequestID); requestID != "" {
+			fields["requestId"] = requestID
+		}
+		if accountID := c.Param("accountID"); accountID != "" {
+			fields["accountId"] = accountID
+		}
+		if accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult); ok {
+			fields["accountUserId"] = accountUser.AccountUserID
+		}
+		rt.logger.WithFields(fields).Info("Handled request")
+	}
+}
+
 // optinMiddleware drops all requests to the given handler that are missing
 // a consent cookie
 func optinMiddleware(cookieName, passWhen string) gin.HandlerFunc {
@@ -54,6 +128,111 @@ func userCookieMiddleware(cookieKey, contextKey string) gin.HandlerFunc {
 	}
 }
 
+// allowlistMiddleware rejects requests from clients that are not contained
+// in the networks configured for the instance.
+func (rt *router) allowlistMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !rt.networkAllowed(c, nil) {
+			newJSONError(
+				errors.New("router: access from this network is not allowed"),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// clientIP returns the IP address of the client. Forwarding headers can be
+// set by any client, so they are only honored when the request has been
+// received from one of the configured trusted proxies. Instances that run
+// behind a reverse proxy without configuring trusted proxies only use the
+// rightmost X-Forwarded-For hop, which has been appended by the proxy in
+// front of the instance.
+func (rt *router) clientIP(c *gin.Context) string {
+	remoteIP, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		remoteIP = c.Request.RemoteAddr
+	}
+	if rt.config == nil {
+		return remoteIP
+	}
+	if len(rt.config.Server.TrustedProxies) != 0 {
+		return forwardedClientIP(remoteIP, c.Request.Header, rt.config.Server.TrustedProxies)
+	}
+	if rt.config.Server.ReverseProxy {
+		return lastForwardedIP(remoteIP, c.Request.Header)
+	}
+	return remoteIP
+}
+
+// lastForwardedIP returns the rightmost hop of X-Forwarded-For, falling back
+// to the remote address. All hops left of it, as well as X-Real-IP, might
+// have been sent by the client itself.
+func lastForwardedIP(remoteIP string, header http.Header) string {
+	values := header["X-Forwarded-For"]
+	if len(values) == 0 {
+		return remoteIP
+	}
+	hops := strings.Split(values[len(values)-1], ",")
+	if hop := strings.TrimSpace(hops[len(hops)-1]); net.ParseIP(hop) != nil {
+		return hop
+	}
+	return remoteIP
+}
+
+// forwardedClientIP resolves the client IP of a request that has been
+// received from the given remote address. X-Forwarded-For is walked from
+// right to left, skipping all trusted proxies, so that values prepended by
+// clients are never used. X-Real-IP is only considered in case no
+// X-Forwarded-For header is present.
+func forwardedClientIP(remoteIP string, header http.Header, trusted config.Networks) string {
+	if !trusted.Contains(remoteIP) {
+		return remoteIP
+	}
+	var hops []string
+	for _, value := range header["X-Forwarded-For"] {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	if len(hops) == 0 {
+		if realIP := strings.TrimSpace(header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+			return realIP
+		}
+		return remoteIP
+	}
+	client := remoteIP
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			break
+		}
+		client = hops[i]
+		if !trusted.Contains(client) {
+			break
+		}
+	}
+	return client
+}
+
+// networkAllowed checks whether the client is contained in the networks
+// configured for the instance as well as in the given networks of the
+// account user.
+func (rt *router) networkAllowed(c *gin.Context, userNetworks []string) bool {
+	ip := rt.clientIP(c)
+	if rt.config != nil && !rt.config.Allowlist.Networks.Allows(ip) {
+		return false
+	}
+	networks, err := config.ParseNetworks(userNetworks)
+	if err != nil {
+		rt.logError(c, err, "error parsing allowed networks of account user")
+		return false
+	}
+	return networks.Allows(ip)
+}
+
 func (rt *router) accountUserMiddleware(cookieKey, contextKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authCookie, authCookieErr := c.Request.Cookie(cookieKey)
@@ -65,8 +244,8 @@ func (rt *router) accountUserMiddleware(cookieKey, contextKey string) gin.Handle
 			return
 		}
 
-		var userID string
-		if err := rt.cookieSigner.Decode(authKey, authCookie.Value, &userID); err != nil {
+		sessionID, err := rt.signingKeys.VerifyToken(authCookie.Value)
+		if err != nil {
 			authCookie, _ = rt.authCookie("", c.GetBool(contextKeySecureContext))
 			http.SetCookie(c.Writer, authCookie)
 			newJSONError(
@@ -76,17 +255,345 @@ func (rt *router) accountUserMiddleware(cookieKey, contextKey string) gin.Handle
 			return
 		}
 
-		user, userErr := rt.db.LookupAccountUser(userID)
+		// the session might have been revoked before the token expired
+		session, sessionErr := rt.db.LookupSession(sessionID)
+		if sessionErr != nil {
+			authCookie, _ = rt.authCookie("", c.GetBool(contextKeySecureContext))
+			http.SetCookie(c.Writer, authCookie)
+			newJSONError(
+				fmt.Errorf("session with id %s is not active: %v", sessionID, sessionErr),
+				http.StatusUnauthorized,
+			).Pipe(c)
+			return
+		}
+
+		user, userErr := rt.db.LookupAccountUser(session.AccountUserID)
 		if userErr != nil {
 			authCookie, _ = rt.authCookie("", c.GetBool(contextKeySecureContext))
 			http.SetCookie(c.Writer, authCookie)
 			newJSONError(
-				fmt.Errorf("user with id %s does not exist: %v", userID, userErr),
+				fmt.Errorf("user with id %s does not exist: %v", session.AccountUserID, userErr),
 				http.StatusUnauthorized,
 			).Pipe(c)
 			return
 		}
+		if !rt.networkAllowed(c, user.AllowedNetworks) {
+			newJSONError(
+				errors.New("router: access from this network is not allowed"),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
+
+		// impersonating super admins are only allowed to read data, ending
+		// the impersonation is done by logging out
+		if session.ImpersonatedBy != "" {
+			if !readsData(c) {
+				newJSONError(
+					errors.New("router: impersonation sessions are only allowed to read data"),
+					http.StatusForbidden,
+				).Pipe(c)
+				return
+			}
+			user.ImpersonatedBy = session.ImpersonatedBy
+		}
 		c.Set(contextKey, user)
+		c.Set(contextKeySession, session.SessionID)
+		c.Next()
+	}
+}
+
+// accessTokenMiddleware authenticates requests that send an access token or
+// a service account credential as a bearer token. Service accounts are only
+// allowed to read data. Requests without an Authorization header are passed
+// to the given fallback handler instead.
+func (rt *router) accessTokenMiddleware(contextKey string, fallback gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if header == "" {
+			fallback(c)
+			return
+		}
+		if !strings.HasPrefix(header, "Bearer ") {
+			newJSONError(
+				errors.New("router: unsupported authorization scheme"),
+				http.StatusUnauthorized,
+			).Pipe(c)
+			return
+		}
+
+		value := strings.TrimPrefix(header, "Bearer ")
+		if strings.HasPrefix(value, persistence.ServiceAccountCredentialPrefix) {
+			if !readsData(c) {
+				newJSONError(
+					errors.New("router: service accounts are only allowed to read data"),
+					http.StatusForbidden,
+				).Pipe(c)
+				return
+			}
+			if !rt.networkAllowed(c, nil) {
+				newJSONError(
+					errors.New("router: access from this network is not allowed"),
+					http.StatusForbidden,
+				).Pipe(c)
+				return
+			}
+			serviceAccount, err := rt.db.LookupServiceAccount(value)
+			if err != nil {
+				if !errors.Is(err, persistence.ErrInvalidServiceAccountCredential) {
+					rt.logError(c, err, "error looking up service account")
+				}
+				newJSONError(
+					errors.New("router: invalid service account credential"),
+					http.StatusUnauthorized,
+				).Pipe(c)
+				return
+			}
+			c.Set(contextKey, serviceAccount)
+			c.Next()
+			return
+		}
+
+		token, err := rt.db.LookupAccessToken(value)
+		if err != nil {
+			if !errors.Is(err, persistence.ErrInvalidAccessToken) {
+				rt.logError(c, err, "error looking up access token")
+			}
+			newJSONError(
+				errors.New("router: invalid access token"),
+				http.StatusUnauthorized,
+			).Pipe(c)
+			return
+		}
+
+		allowed := token.HasScope(persistence.AccessTokenScopeWrite)
+		if readsData(c) {
+			allowed = allowed || token.HasScope(persistence.AccessTokenScopeRead)
+		}
+		if !allowed {
+			newJSONError(
+				errors.New("router: access token has not been granted the required scope"),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
+
+		user, err := rt.db.LookupAccountUser(token.AccountUserID)
+		if err != nil {
+			newJSONError(
+				fmt.Errorf("router: user with id %s does not exist: %v", token.AccountUserID, err),
+				http.StatusUnauthorized,
+			).Pipe(c)
+			return
+		}
+		if !rt.networkAllowed(c, user.AllowedNetworks) {
+			newJSONError(
+				errors.New("router: access from this network is not allowed"),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
+		if len(token.AccountIDs) != 0 {
+			var accounts []persistence.LoginAccountResult
+			for _, account := range user.Accounts {
+				for _, accountID := range token.AccountIDs {
+					if account.AccountID == accountID {
+						accounts = append(accounts, account)
+					}
+				}
+			}
+			user.Accounts = accounts
+		}
+		c.Set(contextKey, user)
+		c.Next()
+	}
+}
+
+// readOnlyMiddleware marks requests as only reading data regardless of
+// their method. It needs to be used for routes that accept queries in the
+// request body before adding authentication.
+func readOnlyMiddleware(contextKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(contextKey, true)
+		c.Next()
+	}
+}
+
+// readsData checks whether the request is only reading data, so it can be
+// made by impersonation sessions, service accounts and read-only access
+// tokens.
+func readsData(c *gin.Context) bool {
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		return true
+	}
+	return c.GetBool(contextKeyReadOnly)
+}
+
+// rateLimitMiddleware limits requests using the given bucket. Tokens are
+// taken for the IP of the client and, if present, for the value of the given
+// field in the JSON request body. The most restrictive result is exposed
+// using RateLimit headers.
+func (rt *router) rateLimitMiddleware(bucket *ratelimiter.Bucket, bodyField string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identifiers := []string{"ip-" + rt.clientIP(c)}
+		if value := peekBodyField(c, bodyField); value != "" {
+			identifiers = append(identifiers, bodyField+"-"+value)
+		}
+
+		var result *ratelimiter.BucketResult
+		for _, identifier := range identifiers {
+			next := bucket.Take(identifier)
+			if result == nil || (result.Allowed && !next.Allowed) || (result.Allowed == next.Allowed && next.Remaining < result.Remaining) {
+				result = &next
+			}
+		}
+
+		if result.Limit > 0 {
+			c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+			c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		}
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			newJSONError(
+				errors.New("router: rate limit exceeded"),
+				http.StatusTooManyRequests,
+			).Pipe(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// peekBodyField returns the value of the given string field in a JSON
+// request body without consuming the body.
+func peekBodyField(c *gin.Context, field string) string {
+	if field == "" || c.Request.Body == nil {
+		return ""
+	}
+	b, err := ioutil.ReadAll(c.Request.Body)
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return ""
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return ""
+	}
+	value, _ := payload[field].(string)
+	return value
+}
+
+// originMiddleware checks the Origin header of cross-origin requests against
+// the origins the account referenced in the request body allows events to be
+// submitted from. Requests without an Origin header or from the instance's
+// own origin are passed through. Preflight requests do not carry a body, so
+// they are answered as long as any account allows the origin.
+func (rt *router) originMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || isSameOrigin(c, origin) {
+			c.Next()
+			return
+		}
+
+		accountIDs := []string{""}
+		if c.Request.Method != http.MethodOptions {
+			if ids := peekAccountIDs(c); len(ids) != 0 {
+				accountIDs = ids
+			}
+		}
+		for _, accountID := range accountIDs {
+			allowed, err := rt.db.IsOriginAllowed(accountID, origin)
+			if err != nil {
+				var errUnknown persistence.ErrUnknownAccount
+				if !errors.As(err, &errUnknown) {
+					newJSONError(
+						fmt.Errorf("router: error checking origin: %w", err),
+						http.StatusInternalServerError,
+					).Pipe(c)
+					return
+				}
+			}
+			if !allowed {
+				newJSONError(
+					fmt.Errorf("router: origin %s is not allowed to submit events", origin),
+					http.StatusForbidden,
+				).Pipe(c)
+				return
+			}
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
+		c.Header("Access-Control-Allow-Credentials", "true")
+		c.Header("Vary", "Origin")
+		if c.Request.Method == http.MethodOptions {
+			c.Header("Access-Control-Allow-Methods", http.MethodPost)
+			c.Header("Access-Control-Allow-Headers", "Content-Type, "+idempotencyKeyHeader)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
+
+// peekAccountIDs returns the ids of all accounts referenced by the single
+// event or the batch of events in the request body without consuming it.
+func peekAccountIDs(c *gin.Context) []string {
+	if c.Request.Body == nil {
+		return nil
+	}
+	b, err := ioutil.ReadAll(c.Request.Body)
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return nil
+	}
+	var payload struct {
+		inboundEventPayload
+		inboundEventsBatchPayload
+	}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return nil
+	}
+
+	var result []string
+	seen := map[string]bool{}
+	for _, evt := range append([]inboundEventPayload{payload.inboundEventPayload}, payload.Events...) {
+		if evt.AccountID == "" || seen[evt.AccountID] {
+			continue
+		}
+		seen[evt.AccountID] = true
+		result = append(result, evt.AccountID)
+	}
+	return result
+}
+
+func isSameOrigin(c *gin.Context, origin string) bool {
+	u := location.Get(c)
+	if u == nil {
+		return false
+	}
+	own, err := persistence.NormalizeOrigin(u.Scheme + "://" + u.Host)
+	if err != nil {
+		return false
+	}
+	value, err := persistence.NormalizeOrigin(origin)
+	return err == nil && value == own
+}
+
+// deprecationMiddleware marks responses as deprecated and links to the
+// successor of the requested route, which is expected to be served at the
+// same path below successorPrefix.
+func deprecationMiddleware(legacyPrefix, successorPrefix string, deprecated, sunset time.Time) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", fmt.Sprintf("@%d", deprecated.Unix()))
+		c.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+		successor := successorPrefix + strings.TrimPrefix(c.Request.URL.Path, legacyPrefix)
+		c.Header("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
 		c.Next()
 	}
 }
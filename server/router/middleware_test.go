@@ -4,6 +4,9 @@
 package router
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -11,11 +14,107 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gin-contrib/location"
 	"github.com/gin-gonic/gin"
-	"github.com/gorilla/securecookie"
+	"github.com/offen/offen/server/config"
 	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/ratelimiter"
+	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
 )
 
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		header         string
+		status         int
+		expectRequest  string
+		expectLogEntry bool
+	}{
+		{"generated", "", http.StatusOK, "", false},
+		{"honored", "abc-123", http.StatusOK, "abc-123", false},
+		{"invalid", "abc\n123", http.StatusOK, "", false},
+		{"server error", "abc-123", http.StatusInternalServerError, "abc-123", true},
+		{"client error", "abc-123", http.StatusBadRequest, "abc-123", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := logrus.New()
+			logger.Out = &buf
+			rt := router{logger: logger}
+			m := gin.New()
+			m.GET("/", rt.requestIDMiddleware(contextKeyRequestID), func(c *gin.Context) {
+				if test.status != http.StatusOK {
+					newJSONError(errors.New("did not work"), test.status).Pipe(c)
+					return
+				}
+				c.String(http.StatusOK, c.GetString(contextKeyRequestID))
+			})
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.header != "" {
+				r.Header.Set("X-Request-ID", test.header)
+			}
+			m.ServeHTTP(w, r)
+
+			requestID := w.Header().Get("X-Request-ID")
+			if test.expectRequest != "" && requestID != test.expectRequest {
+				t.Errorf("Unexpected request id %v", requestID)
+			}
+			if requestID == "" || requestID == test.header && test.expectRequest == "" {
+				t.Errorf("Expected request id to be generated, got %v", requestID)
+			}
+			if test.status == http.StatusOK && w.Body.String() != requestID {
+				t.Errorf("Unexpected request id in context %v", w.Body.String())
+			}
+			if test.status != http.StatusOK && !strings.Contains(w.Body.String(), fmt.Sprintf(`"requestId":"%s"`, requestID)) {
+				t.Errorf("Unexpected response body %v", w.Body.String())
+			}
+			logged := strings.Contains(buf.String(), "requestId="+requestID)
+			if logged != test.expectLogEntry {
+				t.Errorf("Unexpected log output %v", buf.String())
+			}
+		})
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &buf
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	rt := router{logger: logger}
+	m := gin.New()
+	m.GET("/accounts/:accountID", rt.requestIDMiddleware(contextKeyRequestID), rt.accessLogMiddleware(), func(c *gin.Context) {
+		c.Set(contextKeyAuth, persistence.LoginResult{AccountUserID: "user-a"})
+		c.Status(http.StatusNoContent)
+	})
+	r := httptest.NewRequest(http.MethodGet, "/accounts/account-a?secret=value", nil)
+	r.Header.Set("X-Request-ID", "request-a")
+	m.ServeHTTP(httptest.NewRecorder(), r)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	for key, value := range map[string]interface{}{
+		"method":        "GET",
+		"path":          "/accounts/account-a",
+		"status":        float64(http.StatusOK),
+		"requestId":     "request-a",
+		"accountId":     "account-a",
+		"accountUserId": "user-a",
+	} {
+		if entry[key] != value {
+			t.Errorf("Unexpected value %v for %s", entry[key], key)
+		}
+	}
+	if _, ok := entry["latencyMs"].(float64); !ok {
+		t.Errorf("Unexpected latency %v", entry["latencyMs"])
+	}
+}
+
 func TestOptinMiddleware(t *testing.T) {
 	m := gin.New()
 	m.GET("/", optinMiddleware("consent", "allow"), func(c *gin.Context) {
@@ -125,20 +224,41 @@ type mockUserLookupDatabase struct {
 	persistence.Service
 }
 
+func (*mockUserLookupDatabase) LookupSession(sessionID string) (persistence.SessionResult, error) {
+	switch sessionID {
+	case "session-id-1":
+		return persistence.SessionResult{SessionID: sessionID, AccountUserID: "account-user-id-1"}, nil
+	case "session-id-2":
+		return persistence.SessionResult{SessionID: sessionID, AccountUserID: "account-user-id-2"}, nil
+	case "session-id-4":
+		return persistence.SessionResult{SessionID: sessionID, AccountUserID: "account-user-id-4"}, nil
+	case "session-id-5":
+		return persistence.SessionResult{SessionID: sessionID, AccountUserID: "account-user-id-1", ImpersonatedBy: "super-admin"}, nil
+	default:
+		return persistence.SessionResult{}, fmt.Errorf("session with id %s not found", sessionID)
+	}
+}
+
 func (*mockUserLookupDatabase) LookupAccountUser(accountUserID string) (persistence.LoginResult, error) {
-	if accountUserID == "account-user-id-1" {
+	switch accountUserID {
+	case "account-user-id-1":
 		return persistence.LoginResult{
 			AccountUserID: "account-user-id-1",
 		}, nil
+	case "account-user-id-4":
+		return persistence.LoginResult{
+			AccountUserID:   "account-user-id-4",
+			AllowedNetworks: []string{"10.0.0.0/8"},
+		}, nil
 	}
 	return persistence.LoginResult{}, fmt.Errorf("account user with id %s not found", accountUserID)
 }
 
 func TestAccountUserMiddleware(t *testing.T) {
-	cookieSigner := securecookie.New([]byte("keyboard cat"), nil)
+	signingKeys := mustSigningKeyring("keyboard cat")
 	rt := router{
-		cookieSigner: cookieSigner,
-		db:           &mockUserLookupDatabase{},
+		signingKeys: signingKeys,
+		db:          &mockUserLookupDatabase{},
 	}
 	m := gin.New()
 	m.GET("/", rt.accountUserMiddleware("auth", "1"), func(c *gin.Context) {
@@ -168,10 +288,24 @@ func TestAccountUserMiddleware(t *testing.T) {
 		}
 	})
 
+	t.Run("revoked session", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		cookieValue, _ := signingKeys.SignToken("session-id-3", time.Hour)
+		r.AddCookie(&http.Cookie{
+			Name:  "auth",
+			Value: cookieValue,
+		})
+		m.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Unexpected status code %v", w.Code)
+		}
+	})
+
 	t.Run("bad db lookup", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		r := httptest.NewRequest(http.MethodGet, "/", nil)
-		cookieValue, _ := cookieSigner.Encode("auth", "account-user-id-2")
+		cookieValue, _ := signingKeys.SignToken("session-id-2", time.Hour)
 		r.AddCookie(&http.Cookie{
 			Name:  "auth",
 			Value: cookieValue,
@@ -182,10 +316,52 @@ func TestAccountUserMiddleware(t *testing.T) {
 		}
 	})
 
+	t.Run("impersonation", func(t *testing.T) {
+		m := gin.New()
+		m.Any("/", rt.accountUserMiddleware("auth", "1"), func(c *gin.Context) {
+			user, _ := c.Value("1").(persistence.LoginResult)
+			c.String(http.StatusOK, "impersonated by %v", user.ImpersonatedBy)
+		})
+		cookieValue, _ := signingKeys.SignToken("session-id-5", time.Hour)
+		for method, expectedStatusCode := range map[string]int{
+			http.MethodGet:    http.StatusOK,
+			http.MethodPost:   http.StatusForbidden,
+			http.MethodDelete: http.StatusForbidden,
+		} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(method, "/", nil)
+			r.AddCookie(&http.Cookie{
+				Name:  "auth",
+				Value: cookieValue,
+			})
+			m.ServeHTTP(w, r)
+			if w.Code != expectedStatusCode {
+				t.Errorf("Unexpected status code %v for %s", w.Code, method)
+			}
+			if w.Code == http.StatusOK && w.Body.String() != "impersonated by super-admin" {
+				t.Errorf("Unexpected body %v", w.Body.String())
+			}
+		}
+	})
+
+	t.Run("network not allowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		cookieValue, _ := signingKeys.SignToken("session-id-4", time.Hour)
+		r.AddCookie(&http.Cookie{
+			Name:  "auth",
+			Value: cookieValue,
+		})
+		m.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Unexpected status code %v", w.Code)
+		}
+	})
+
 	t.Run("ok", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		r := httptest.NewRequest(http.MethodGet, "/", nil)
-		cookieValue, _ := cookieSigner.Encode("auth", "account-user-id-1")
+		cookieValue, _ := signingKeys.SignToken("session-id-1", time.Hour)
 		r.AddCookie(&http.Cookie{
 			Name:  "auth",
 			Value: cookieValue,
@@ -197,6 +373,216 @@ func TestAccountUserMiddleware(t *testing.T) {
 	})
 }
 
+func TestAllowlistMiddleware(t *testing.T) {
+	tests := []struct {
+		name               string
+		networks           string
+		reverseProxy       bool
+		trustedProxies     string
+		remoteAddr         string
+		forwardedFor       string
+		expectedStatusCode int
+	}{
+		{"no allowlist", "", false, "", "192.0.2.1:1234", "", http.StatusOK},
+		{"allowed", "192.0.2.0/24", false, "", "192.0.2.1:1234", "", http.StatusOK},
+		{"not allowed", "10.0.0.0/8", false, "", "192.0.2.1:1234", "", http.StatusForbidden},
+		{"spoofed header", "10.0.0.0/8", false, "", "192.0.2.1:1234", "10.0.0.1", http.StatusForbidden},
+		{"reverse proxy", "10.0.0.0/8", true, "", "192.0.2.1:1234", "10.0.0.1", http.StatusOK},
+		{"reverse proxy spoofed header", "10.0.0.0/8", true, "", "192.0.2.1:1234", "10.0.0.1, 198.51.100.1", http.StatusForbidden},
+		{"trusted proxy", "10.0.0.0/8", true, "192.0.2.1", "192.0.2.1:1234", "10.0.0.1", http.StatusOK},
+		{"untrusted proxy", "10.0.0.0/8", true, "192.0.2.2", "192.0.2.1:1234", "10.0.0.1", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Server.ReverseProxy = test.reverseProxy
+			if err := cfg.Allowlist.Networks.Decode(test.networks); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if err := cfg.Server.TrustedProxies.Decode(test.trustedProxies); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			rt := router{config: cfg}
+			m := gin.New()
+			m.GET("/", rt.allowlistMiddleware(), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			if test.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", test.forwardedFor)
+			}
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+		})
+	}
+}
+
+func TestForwardedClientIP(t *testing.T) {
+	trusted, err := config.ParseNetworks([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	tests := []struct {
+		name       string
+		remoteIP   string
+		header     http.Header
+		expectedIP string
+	}{
+		{"untrusted peer", "198.51.100.1", http.Header{"X-Forwarded-For": []string{"203.0.113.1"}}, "198.51.100.1"},
+		{"single proxy", "192.0.2.1", http.Header{"X-Forwarded-For": []string{"203.0.113.1"}}, "203.0.113.1"},
+		{"chained proxies", "192.0.2.1", http.Header{"X-Forwarded-For": []string{"203.0.113.1, 10.0.0.2"}}, "203.0.113.1"},
+		{"multiple headers", "192.0.2.1", http.Header{"X-Forwarded-For": []string{"203.0.113.1", "10.0.0.2"}}, "203.0.113.1"},
+		{"spoofed by client", "192.0.2.1", http.Header{"X-Forwarded-For": []string{"10.0.0.3, 203.0.113.1"}}, "203.0.113.1"},
+		{"only proxies", "192.0.2.1", http.Header{"X-Forwarded-For": []string{"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"invalid hop", "192.0.2.1", http.Header{"X-Forwarded-For": []string{"203.0.113.1, garbage"}}, "192.0.2.1"},
+		{"real ip", "192.0.2.1", http.Header{"X-Real-Ip": []string{"203.0.113.1"}}, "203.0.113.1"},
+		{"invalid real ip", "192.0.2.1", http.Header{"X-Real-Ip": []string{"garbage"}}, "192.0.2.1"},
+		{"no headers", "192.0.2.1", http.Header{}, "192.0.2.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ip := forwardedClientIP(test.remoteIP, test.header, trusted); ip != test.expectedIP {
+				t.Errorf("Expected %v, got %v", test.expectedIP, ip)
+			}
+		})
+	}
+}
+
+func TestRouter_clientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		reverseProxy   bool
+		trustedProxies string
+		header         http.Header
+		expectedIP     string
+	}{
+		{"no proxy", false, "", http.Header{"X-Forwarded-For": []string{"203.0.113.1"}}, "192.0.2.1"},
+		{"reverse proxy", true, "", http.Header{"X-Forwarded-For": []string{"203.0.113.1"}}, "203.0.113.1"},
+		{"reverse proxy spoofed hop", true, "", http.Header{"X-Forwarded-For": []string{"10.0.0.1, 203.0.113.1"}}, "203.0.113.1"},
+		{"reverse proxy spoofed header", true, "", http.Header{"X-Forwarded-For": []string{"10.0.0.1", "203.0.113.1"}}, "203.0.113.1"},
+		{"reverse proxy real ip", true, "", http.Header{"X-Real-Ip": []string{"10.0.0.1"}}, "192.0.2.1"},
+		{"reverse proxy invalid hop", true, "", http.Header{"X-Forwarded-For": []string{"garbage"}}, "192.0.2.1"},
+		{"trusted proxy", true, "192.0.2.1", http.Header{"X-Forwarded-For": []string{"10.0.0.1, 203.0.113.1"}}, "203.0.113.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Server.ReverseProxy = test.reverseProxy
+			if err := cfg.Server.TrustedProxies.Decode(test.trustedProxies); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			rt := router{config: cfg}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			r.Header = test.header
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = r
+			if ip := rt.clientIP(c); ip != test.expectedIP {
+				t.Errorf("Expected %v, got %v", test.expectedIP, ip)
+			}
+		})
+	}
+}
+
+type mockAccessTokenLookupDatabase struct {
+	persistence.Service
+}
+
+func (*mockAccessTokenLookupDatabase) LookupAccessToken(token string) (persistence.AccessTokenResult, error) {
+	switch token {
+	case "read-token":
+		return persistence.AccessTokenResult{AccountUserID: "account-user-id-1", Scopes: []string{persistence.AccessTokenScopeRead}}, nil
+	case "stats-token":
+		return persistence.AccessTokenResult{AccountUserID: "account-user-id-1", Scopes: []string{persistence.AccessTokenScopeStats}, AccountIDs: []string{"account-b"}}, nil
+	case "restricted-token":
+		return persistence.AccessTokenResult{AccountUserID: "account-user-id-1", Scopes: []string{persistence.AccessTokenScopeWrite}, AccountIDs: []string{"account-b"}}, nil
+	default:
+		return persistence.AccessTokenResult{}, persistence.ErrInvalidAccessToken
+	}
+}
+
+func (*mockAccessTokenLookupDatabase) LookupAccountUser(accountUserID string) (persistence.LoginResult, error) {
+	return persistence.LoginResult{
+		AccountUserID: accountUserID,
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a"},
+			{AccountID: "account-b"},
+		},
+	}, nil
+}
+
+func (*mockAccessTokenLookupDatabase) LookupServiceAccount(credential string) (persistence.LoginResult, error) {
+	if credential != "sa.service.secret" {
+		return persistence.LoginResult{}, persistence.ErrInvalidServiceAccountCredential
+	}
+	return persistence.LoginResult{
+		AccountUserID: "service",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a", Role: persistence.AccountUserRoleViewer},
+		},
+	}, nil
+}
+
+func TestAccessTokenMiddleware(t *testing.T) {
+	rt := router{
+		db: &mockAccessTokenLookupDatabase{},
+	}
+	fallback := func(c *gin.Context) {
+		c.String(http.StatusTeapot, "fallback")
+		c.Abort()
+	}
+	handler := func(c *gin.Context) {
+		user, _ := c.Value("auth").(persistence.LoginResult)
+		c.String(http.StatusOK, "%d accounts", len(user.Accounts))
+	}
+	m := gin.New()
+	m.GET("/", rt.accessTokenMiddleware("auth", fallback), handler)
+	m.POST("/", rt.accessTokenMiddleware("auth", fallback), handler)
+	m.PUT("/", readOnlyMiddleware(contextKeyReadOnly), rt.accessTokenMiddleware("auth", fallback), handler)
+
+	tests := []struct {
+		name           string
+		method         string
+		header         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"no header", http.MethodGet, "", http.StatusTeapot, "fallback"},
+		{"bad scheme", http.MethodGet, "Basic abc", http.StatusUnauthorized, ""},
+		{"unknown token", http.MethodGet, "Bearer other-token", http.StatusUnauthorized, ""},
+		{"read", http.MethodGet, "Bearer read-token", http.StatusOK, "2 accounts"},
+		{"read only", http.MethodPost, "Bearer read-token", http.StatusForbidden, ""},
+		{"read only route", http.MethodPut, "Bearer read-token", http.StatusOK, "2 accounts"},
+		{"restricted", http.MethodGet, "Bearer restricted-token", http.StatusOK, "1 accounts"},
+		{"write", http.MethodPost, "Bearer restricted-token", http.StatusOK, "1 accounts"},
+		{"stats", http.MethodGet, "Bearer stats-token", http.StatusForbidden, ""},
+		{"service account", http.MethodGet, "Bearer sa.service.secret", http.StatusOK, "1 accounts"},
+		{"service account write", http.MethodPost, "Bearer sa.service.secret", http.StatusForbidden, ""},
+		{"service account read only route", http.MethodPut, "Bearer sa.service.secret", http.StatusOK, "1 accounts"},
+		{"unknown service account", http.MethodGet, "Bearer sa.service.other", http.StatusUnauthorized, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(test.method, "/", nil)
+			if test.header != "" {
+				r.Header.Set("Authorization", test.header)
+			}
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if test.expectedBody != "" && w.Body.String() != test.expectedBody {
+				t.Errorf("Unexpected body %v", w.Body.String())
+			}
+		})
+	}
+}
+
 func TestHeaderMiddleware(t *testing.T) {
 	m := gin.New()
 	m.GET("/", headerMiddleware(map[string]func() string{
@@ -239,6 +625,103 @@ func TestHeaderMiddleware(t *testing.T) {
 	}
 }
 
+func TestDeprecationMiddleware(t *testing.T) {
+	deprecated := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+	m := gin.New()
+	m.GET("/api/accounts/:accountID", deprecationMiddleware("/api", "/api/v1", deprecated, sunset), func(c *gin.Context) {
+		c.String(http.StatusOK, "OK!")
+	})
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/accounts/account-a?since=abc", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Unexpected status code %v", w.Code)
+	}
+	if v := w.Header().Get("Deprecation"); v != "@1577836800" {
+		t.Errorf("Unexpected deprecation header %v", v)
+	}
+	if v := w.Header().Get("Sunset"); v != "Fri, 01 Jan 2021 00:00:00 GMT" {
+		t.Errorf("Unexpected sunset header %v", v)
+	}
+	if v := w.Header().Get("Link"); v != `</api/v1/accounts/account-a>; rel="successor-version"` {
+		t.Errorf("Unexpected link header %v", v)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	rt := router{}
+	m := gin.New()
+	m.POST("/", rt.rateLimitMiddleware(ratelimiter.NewBucket(2, time.Hour, cache.New(time.Hour, time.Hour)), "accountId"), func(c *gin.Context) {
+		var payload inboundEventPayload
+		if err := c.BindJSON(&payload); err != nil {
+			return
+		}
+		c.String(http.StatusOK, payload.AccountID)
+	})
+
+	tests := []struct {
+		name              string
+		remoteAddr        string
+		body              string
+		expectedStatus    int
+		expectedRemaining string
+	}{
+		{"first", "192.0.2.1:1234", `{"accountId":"account-a"}`, http.StatusOK, "1"},
+		{"second", "192.0.2.1:1234", `{"accountId":"account-a"}`, http.StatusOK, "0"},
+		{"ip exceeded", "192.0.2.1:1234", `{"accountId":"account-b"}`, http.StatusTooManyRequests, "0"},
+		{"other ip", "192.0.2.2:1234", `{"accountId":"account-b"}`, http.StatusOK, "0"},
+		{"account exceeded", "192.0.2.3:1234", `{"accountId":"account-a"}`, http.StatusTooManyRequests, "0"},
+		{"no account", "192.0.2.4:1234", `{}`, http.StatusOK, "1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+			r.RemoteAddr = test.remoteAddr
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if v := w.Header().Get("RateLimit-Limit"); v != "2" {
+				t.Errorf("Unexpected limit header %v", v)
+			}
+			if v := w.Header().Get("RateLimit-Remaining"); v != test.expectedRemaining {
+				t.Errorf("Unexpected remaining header %v", v)
+			}
+			if w.Header().Get("RateLimit-Reset") == "" {
+				t.Error("Expected reset header")
+			}
+			retryAfter := w.Header().Get("Retry-After")
+			if (test.expectedStatus == http.StatusTooManyRequests) != (retryAfter != "") {
+				t.Errorf("Unexpected retry after header %v", retryAfter)
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddleware_SpoofedHeader(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.ReverseProxy = true
+	rt := router{config: cfg}
+	m := gin.New()
+	m.POST("/", rt.rateLimitMiddleware(ratelimiter.NewBucket(1, time.Hour, cache.New(time.Hour, time.Hour)), ""), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	// a client prepending made up addresses is still limited by the address
+	// its proxy has appended
+	for i, expectedStatus := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("X-Forwarded-For", fmt.Sprintf("10.0.0.%d, 203.0.113.1", i))
+		m.ServeHTTP(w, r)
+		if w.Code != expectedStatus {
+			t.Errorf("Unexpected status code %v for request %d", w.Code, i)
+		}
+	}
+}
+
 func TestEtagMiddleware(t *testing.T) {
 	m := gin.New()
 	m.GET("/", etagMiddleware(), func(c *gin.Context) {
@@ -273,3 +756,64 @@ func TestEtagMiddleware(t *testing.T) {
 		t.Errorf("Unexpected status code %v", w2.Code)
 	}
 }
+
+type mockOriginDatabase struct {
+	persistence.Service
+}
+
+func (*mockOriginDatabase) IsOriginAllowed(accountID, origin string) (bool, error) {
+	switch accountID {
+	case "account-a", "":
+		return origin == "https://www.offen.dev", nil
+	case "account-z":
+		return false, persistence.ErrUnknownAccount("did not work")
+	default:
+		return false, errors.New("did not work")
+	}
+}
+
+func TestOriginMiddleware(t *testing.T) {
+	rt := router{db: &mockOriginDatabase{}}
+	m := gin.New()
+	m.Use(location.Default())
+	m.OPTIONS("/", rt.originMiddleware())
+	m.POST("/", rt.originMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	tests := []struct {
+		name                string
+		method              string
+		origin              string
+		body                string
+		expectedStatus      int
+		expectedAllowOrigin string
+	}{
+		{"no origin", http.MethodPost, "", `{"accountId":"account-b"}`, http.StatusCreated, ""},
+		{"same origin", http.MethodPost, "http://offen.example.com", `{"accountId":"account-b"}`, http.StatusCreated, ""},
+		{"allowed", http.MethodPost, "https://www.offen.dev", `{"accountId":"account-a"}`, http.StatusCreated, "https://www.offen.dev"},
+		{"not allowed", http.MethodPost, "https://evil.example.com", `{"accountId":"account-a"}`, http.StatusForbidden, ""},
+		{"unknown account", http.MethodPost, "https://www.offen.dev", `{"accountId":"account-z"}`, http.StatusForbidden, ""},
+		{"database error", http.MethodPost, "https://www.offen.dev", `{"accountId":"account-b"}`, http.StatusInternalServerError, ""},
+		{"allowed batch", http.MethodPost, "https://www.offen.dev", `{"events":[{"accountId":"account-a"},{"accountId":"account-a"}]}`, http.StatusCreated, "https://www.offen.dev"},
+		{"batch with unknown account", http.MethodPost, "https://www.offen.dev", `{"events":[{"accountId":"account-a"},{"accountId":"account-z"}]}`, http.StatusForbidden, ""},
+		{"preflight", http.MethodOptions, "https://www.offen.dev", "", http.StatusNoContent, "https://www.offen.dev"},
+		{"bad preflight", http.MethodOptions, "https://evil.example.com", "", http.StatusForbidden, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(test.method, "http://offen.example.com/", strings.NewReader(test.body))
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if v := w.Header().Get("Access-Control-Allow-Origin"); v != test.expectedAllowOrigin {
+				t.Errorf("Unexpected allow origin header %v", v)
+			}
+		})
+	}
+}
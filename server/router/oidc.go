@@ -0,0 +1,188 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/location"
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/oidc"
+	"github.com/offen/offen/server/persistence"
+)
+
+const (
+	oidcStateKey    = "oidc"
+	oidcStateTTL    = 10 * time.Minute
+	oidcCallbackURL = "/api/login/oidc/callback"
+)
+
+// oidcState is stored in a signed cookie while the user authenticates
+// with the identity provider.
+type oidcState struct {
+	State   string
+	Nonce   string
+	Expires time.Time
+}
+
+func (rt *router) oidcRedirectURL(c *gin.Context) string {
+	if rt.config.OIDC.RedirectURL != "" {
+		return rt.config.OIDC.RedirectURL
+	}
+	u := location.Get(c)
+	return fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, oidcCallbackURL)
+}
+
+// oidcAccountIDs returns the ids of the accounts the given identity is allowed
+// to access based on the configured group mapping. In case no mapping is
+// configured, nil is returned.
+func (rt *router) oidcAccountIDs(identity *oidc.Identity, login persistence.LoginResult) []string {
+	mapping := rt.config.OIDC.AccountGroups
+	if len(mapping) == 0 {
+		return nil
+	}
+	accountIDs := []string{}
+	for _, account := range login.Accounts {
+		if group, ok := mapping[account.AccountID]; ok && identity.InGroup(group) {
+			accountIDs = append(accountIDs, account.AccountID)
+		}
+	}
+	return accountIDs
+}
+
+func (rt *router) getLoginOIDC(c *gin.Context) {
+	if rt.oidc == nil {
+		newJSONError(
+			errors.New("router: single sign-on is not configured"),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	state, err := oidc.NewNonce()
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating state: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	nonce, err := oidc.NewNonce()
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating nonce: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	redirect, err := rt.oidc.AuthCodeURL(rt.oidcRedirectURL(c), state, nonce)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating authorization url: %w", err),
+			http.StatusBadGateway,
+		).Pipe(c)
+		return
+	}
+
+	token, err := rt.cookieSigner.Encode(oidcStateKey, oidcState{
+		State:   state,
+		Nonce:   nonce,
+		Expires: time.Now().Add(oidcStateTTL),
+	})
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error signing state: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	http.SetCookie(c.Writer, rt.ssoCookie(oidcStateKey, token, oidcCallbackURL, oidcStateTTL, c.GetBool(contextKeySecureContext)))
+	c.Redirect(http.StatusFound, redirect)
+}
+
+func (rt *router) getLoginOIDCCallback(c *gin.Context) {
+	if rt.oidc == nil {
+		newJSONError(
+			errors.New("router: single sign-on is not configured"),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	secure := c.GetBool(contextKeySecureContext)
+	// the state is single use, so it is dropped no matter the outcome
+	http.SetCookie(c.Writer, rt.ssoCookie(oidcStateKey, "", oidcCallbackURL, 0, secure))
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		newJSONError(
+			fmt.Errorf("router: identity provider returned error: %s", providerErr),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+
+	stateCookie, err := c.Request.Cookie(oidcStateKey)
+	if err != nil {
+		newJSONError(
+			errors.New("router: missing state"),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	var state oidcState
+	if err := rt.decodeSigned(oidcStateKey, stateCookie.Value, &state); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding state: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	if state.State != c.Query("state") || time.Now().After(state.Expires) {
+		newJSONError(
+			errors.New("router: state did not match"),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	identity, err := rt.oidc.Exchange(c.Request.Context(), rt.oidcRedirectURL(c), c.Query("code"), state.Nonce)
+	if err != nil {
+		rt.logError(c, err, "error exchanging authorization code")
+		newJSONError(
+			errors.New("router: could not authenticate with identity provider"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+	if identity.Email == "" || !identity.EmailVerified {
+		newJSONError(
+			errors.New("router: identity provider did not assert a verified email address"),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	login, err := rt.db.LookupIdentity(identity.Email)
+	if err != nil {
+		newJSONError(
+			errors.New("router: no account user found for identity"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+	accountIDs := rt.oidcAccountIDs(identity, login)
+	if accountIDs != nil && len(accountIDs) == 0 {
+		newJSONError(
+			errors.New("router: identity is not allowed to access any account"),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	rt.startSSOSession(c, login.AccountUserID, accountIDs)
+}
@@ -0,0 +1,45 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/oidc"
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestRouter_oidcAccountIDs(t *testing.T) {
+	login := persistence.LoginResult{
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a"},
+			{AccountID: "account-b"},
+			{AccountID: "account-c"},
+		},
+	}
+	tests := []struct {
+		name           string
+		mapping        map[string]string
+		groups         []string
+		expectedResult []string
+	}{
+		{"no mapping", nil, []string{"admins"}, nil},
+		{"match", map[string]string{"account-a": "admins", "account-b": "developers"}, []string{"admins"}, []string{"account-a"}},
+		{"multiple", map[string]string{"account-a": "admins", "account-b": "admins"}, []string{"admins"}, []string{"account-a", "account-b"}},
+		{"no match", map[string]string{"account-a": "admins"}, []string{"developers"}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.OIDC.AccountGroups = test.mapping
+			rt := router{config: cfg}
+			result := rt.oidcAccountIDs(&oidc.Identity{Groups: test.groups}, login)
+			if !reflect.DeepEqual(test.expectedResult, result) {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,751 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/referrers"
+)
+
+// Security schemes that can be required by API operations.
+const (
+	securityUserCookie = "userCookie"
+	securityAuthCookie = "authCookie"
+	securityBearer     = "bearerToken"
+)
+
+// apiOperation documents a route of the API. Request and response schemas are
+// derived from the given values, so they stay in sync with the types the
+// handlers use.
+type apiOperation struct {
+	method      string
+	path        string
+	tag         string
+	summary     string
+	security    []string
+	query       []string
+	request     interface{}
+	status      int
+	response    interface{}
+	description string
+}
+
+// apiOperations lists the documented routes. Paths use the same syntax as
+// when registering the routes.
+var apiOperations = []apiOperation{
+	{
+		method:      http.MethodGet,
+		path:        "/api/v1/events",
+		tag:         "events",
+		summary:     "Retrieve the events of the user",
+		security:    []string{securityUserCookie},
+		query:       []string{"since", "until", "accountId", "limit", "cursor"},
+		status:      http.StatusOK,
+		response:    persistence.EventsResult{},
+		description: "since is either the sequence returned by a previous request or a RFC 3339 timestamp, until is a RFC 3339 timestamp. In case limit is given, the nextCursor of the response is passed as cursor for requesting the next page. The sequence is only returned with the last page.",
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/api/v1/events",
+		tag:         "events",
+		summary:     "Record an event for the user",
+		security:    []string{securityUserCookie},
+		request:     inboundEventPayload{},
+		status:      http.StatusCreated,
+		response:    ackResponse{},
+		description: "Retries can be made safe by passing a ULID as eventId or by sending an Idempotency-Key header. Events that have been recorded already are acknowledged without being recorded again. Events sent by bots are dropped and answered with status 204.",
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/api/v1/events/anonymous",
+		tag:         "events",
+		summary:     "Record an anonymous event",
+		request:     inboundEventPayload{},
+		status:      http.StatusCreated,
+		response:    ackResponse{},
+		description: "Events sent by bots are dropped and answered with status 204.",
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/api/v1/events/batch",
+		tag:         "events",
+		summary:     "Record multiple events for the user",
+		security:    []string{securityUserCookie},
+		request:     inboundEventsBatchPayload{},
+		status:      http.StatusCreated,
+		response:    ackResponse{},
+		description: "Either all events are recorded or none. A batch is limited to 100 events. Retries are handled like for single events. Events sent by bots are dropped, in case no event is left the request is answered with status 204.",
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/api/v1/purge",
+		tag:         "events",
+		summary:     "Delete all events of the user",
+		security:    []string{securityUserCookie},
+		query:       []string{"user"},
+		status:      http.StatusNoContent,
+		description: "In case the user parameter is set, the user cookie is deleted as well.",
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/api/v1/erasures",
+		tag:         "events",
+		summary:     "Delete all events of a user on their request",
+		security:    []string{securityAuthCookie},
+		request:     erasureRequest{},
+		status:      http.StatusCreated,
+		response:    erasureResponse{},
+		description: "Only super admins can erase data, as events are deleted in all accounts. receipt is a JWS signed using the receipt key published at /.well-known/jwks.json, which is not rotated.",
+	},
+	{
+		method:   http.MethodGet,
+		path:     "/api/v1/exchange",
+		tag:      "events",
+		summary:  "Retrieve the public key of an account",
+		query:    []string{"accountId"},
+		status:   http.StatusOK,
+		response: persistence.AccountResult{},
+	},
+	{
+		method:  http.MethodPost,
+		path:    "/api/v1/exchange",
+		tag:     "events",
+		summary: "Store the encrypted secret of the user for an account",
+		request: userSecretPayload{},
+		status:  http.StatusNoContent,
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/api/v1/referrer-rules",
+		tag:         "events",
+		summary:     "Retrieve the ruleset for classifying referrers",
+		status:      http.StatusOK,
+		response:    referrers.Ruleset{},
+		description: "Referrers are encrypted, so clients classify them as search or social traffic using these rules. Responses carry an ETag and can be revalidated.",
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/api/v1/hints",
+		tag:         "events",
+		summary:     "Retrieve hints about the client that are added to event payloads",
+		status:      http.StatusOK,
+		response:    hintsResponse{},
+		description: "country is the ISO 3166-1 alpha-2 code of the country of the client and is only given when a GeoIP database is configured. The IP address of the client is discarded after the lookup.",
+	},
+	{
+		method:   http.MethodGet,
+		path:     "/api/v1/accounts",
+		tag:      "accounts",
+		summary:  "List the accounts the requester can access",
+		security: []string{securityAuthCookie, securityBearer},
+		status:   http.StatusOK,
+		response: accountsResponse{},
+	},
+	{
+		method:   http.MethodGet,
+		path:     "/api/v1/accounts/:accountID",
+		tag:      "accounts",
+		summary:  "Retrieve an account and its events",
+		security: []string{securityAuthCookie, securityBearer},
+		query:    []string{"since"},
+		status:   http.StatusOK,
+		response: persistence.AccountResult{},
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/api/v1/accounts",
+		tag:      "accounts",
+		summary:  "Create an account",
+		security: []string{securityAuthCookie, securityBearer},
+		request:  createAccountRequest{},
+		status:   http.StatusCreated,
+		response: persistence.AccountResult{},
+	},
+	{
+		method:   http.MethodPatch,
+		path:     "/api/v1/accounts/:accountID",
+		tag:      "accounts",
+		summary:  "Rename an account",
+		security: []string{securityAuthCookie, securityBearer},
+		request:  updateAccountRequest{},
+		status:   http.StatusOK,
+		response: persistence.AccountResult{},
+	},
+	{
+		method:   http.MethodDelete,
+		path:     "/api/v1/accounts/:accountID",
+		tag:      "accounts",
+		summary:  "Retire an account",
+		security: []string{securityAuthCookie, securityBearer},
+		status:   http.StatusNoContent,
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/api/v1/accounts/:accountID/live",
+		tag:         "accounts",
+		summary:     "Stream events of an account as they are received",
+		security:    []string{securityAuthCookie, securityBearer},
+		status:      http.StatusOK,
+		response:    liveEvent{},
+		description: "Events are sent as server-sent events of type event until the client disconnects. Only events received by the instance serving the request are streamed.",
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/api/v1/accounts/:accountID/dropped-events",
+		tag:         "accounts",
+		summary:     "Retrieve the number of events of an account that have been dropped as they have been sent by bots",
+		security:    []string{securityAuthCookie, securityBearer},
+		status:      http.StatusOK,
+		response:    droppedEventsResponse{},
+		description: "Reasons are user_agent, headless and honeypot. Only events dropped by the instance serving the request since it has been started are counted.",
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/api/v1/accounts/:accountID/active-users",
+		tag:         "accounts",
+		summary:     "Retrieve the number of users that are currently active in an account",
+		security:    []string{securityAuthCookie, securityBearer},
+		status:      http.StatusOK,
+		response:    activeUsersResponse{},
+		description: "Users are active for windowSeconds after sending an event. Anonymous events are not counted and only events received by the instance serving the request are known.",
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/api/v1/accounts/:accountID/quota",
+		tag:         "accounts",
+		summary:     "Retrieve the usage of the quotas of an account",
+		security:    []string{securityAuthCookie, securityBearer},
+		status:      http.StatusOK,
+		response:    quotaResponse{},
+		description: "Limits of 0 mean no quota applies. Rejection reasons are events and payload_bytes. Only events received by the instance serving the request since it has been started are counted.",
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/api/v1/accounts/:accountID/public-stats",
+		tag:         "accounts",
+		summary:     "Retrieve aggregated metrics of an account using a public token",
+		query:       []string{"token", "metrics", "since"},
+		status:      http.StatusOK,
+		response:    publicStatsResponse{},
+		description: "token is an access token that has been granted the stats scope for the account and can also be sent as a bearer token. metrics is a comma separated list out of events, users, anonymousEvents and days, defaulting to all of them. since is a RFC 3339 timestamp.",
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/api/v1/accounts/:accountID/webhooks",
+		tag:         "accounts",
+		summary:     "Register a webhook for an account",
+		security:    []string{securityAuthCookie},
+		request:     createWebhookRequest{},
+		status:      http.StatusCreated,
+		response:    persistence.WebhookResult{},
+		description: "Events are weekly_aggregate.ready, retention_purge.completed, account_user.added and traffic_anomaly.detected. The secret for verifying the X-Offen-Signature header of deliveries is only returned once.",
+	},
+	{
+		method:   http.MethodGet,
+		path:     "/api/v1/accounts/:accountID/webhooks/:webhookID/deliveries",
+		tag:      "accounts",
+		summary:  "Retrieve the latest deliveries of a webhook",
+		security: []string{securityAuthCookie},
+		query:    []string{"limit"},
+		status:   http.StatusOK,
+		response: persistence.WebhookDeliveryResult{},
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/api/v1/accounts/:accountID/rollups",
+		tag:         "accounts",
+		summary:     "Retrieve precomputed rollups of an account",
+		security:    []string{securityAuthCookie, securityBearer},
+		query:       []string{"period", "from", "until"},
+		status:      http.StatusOK,
+		response:    persistence.RollupResult{},
+		description: "Rollups contain the number of events and users per hour or day. Aggregates derived from event payloads are stored encrypted by clients. Daily rollups imported from another analytics tool are flagged as imported.",
+	},
+	{
+		method:   http.MethodPut,
+		path:     "/api/v1/accounts/:accountID/rollups/:period/:start",
+		tag:      "accounts",
+		summary:  "Store the encrypted aggregates of a rollup",
+		security: []string{securityAuthCookie, securityBearer},
+		request:  updateRollupRequest{},
+		status:   http.StatusOK,
+		response: persistence.RollupResult{},
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/api/v1/accounts/:accountID/export",
+		tag:         "accounts",
+		summary:     "Export aggregated metrics of an account as CSV or JSON",
+		security:    []string{securityAuthCookie, securityBearer},
+		query:       []string{"format", "period", "from", "until"},
+		status:      http.StatusOK,
+		response:    exportResponse{},
+		description: "format is either csv or json and defaults to csv. The export contains a row per rollup in the given range, periods without events are omitted. Aggregates that are encrypted by clients are not exported. Rows of daily rollups imported from another analytics tool contain the tool as import_source.",
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/api/v1/accounts/:accountID/funnels",
+		tag:         "accounts",
+		summary:     "List the funnels of an account",
+		security:    []string{securityAuthCookie, securityBearer},
+		status:      http.StatusOK,
+		response:    persistence.FunnelResult{},
+		description: "Conversion rates of funnels are computed by clients, as matching events against steps requires decrypting event payloads.",
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/api/v1/accounts/:accountID/funnels",
+		tag:      "accounts",
+		summary:  "Define a funnel for an account",
+		security: []string{securityAuthCookie},
+		request:  createFunnelRequest{},
+		status:   http.StatusCreated,
+		response: persistence.FunnelResult{},
+	},
+	{
+		method:   http.MethodDelete,
+		path:     "/api/v1/accounts/:accountID/funnels/:funnelID",
+		tag:      "accounts",
+		summary:  "Delete a funnel",
+		security: []string{securityAuthCookie},
+		status:   http.StatusNoContent,
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/api/v1/accounts/:accountID/event-types",
+		tag:         "accounts",
+		summary:     "List the custom event types of an account",
+		security:    []string{securityAuthCookie, securityBearer},
+		status:      http.StatusOK,
+		response:    persistence.EventTypeResult{},
+		description: "Custom events are aggregated by clients, as their type is part of the encrypted event payload.",
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/api/v1/accounts/:accountID/event-types",
+		tag:         "accounts",
+		summary:     "Define a custom event type for an account",
+		security:    []string{securityAuthCookie},
+		request:     createEventTypeRequest{},
+		status:      http.StatusCreated,
+		response:    persistence.EventTypeResult{},
+		description: "name consists of up to 64 lowercase letters, digits, - and _. aggregation is one of count, users, sum and average, defaulting to count.",
+	},
+	{
+		method:   http.MethodDelete,
+		path:     "/api/v1/accounts/:accountID/event-types/:eventTypeID",
+		tag:      "accounts",
+		summary:  "Delete a custom event type",
+		security: []string{securityAuthCookie},
+		status:   http.StatusNoContent,
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/api/v1/accounts/:accountID/goals",
+		tag:         "accounts",
+		summary:     "List the goals of an account",
+		security:    []string{securityAuthCookie, securityBearer},
+		status:      http.StatusOK,
+		response:    persistence.GoalResult{},
+		description: "Conversions are counted by clients and reported as goals in the aggregated stats.",
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/api/v1/accounts/:accountID/goals",
+		tag:         "accounts",
+		summary:     "Define a goal for an account",
+		security:    []string{securityAuthCookie},
+		request:     createGoalRequest{},
+		status:      http.StatusCreated,
+		response:    persistence.GoalResult{},
+		description: "type is either url or event. url goals use an absolute URL as value that may contain * as a wildcard, event goals use the name of a custom event.",
+	},
+	{
+		method:   http.MethodDelete,
+		path:     "/api/v1/accounts/:accountID/goals/:goalID",
+		tag:      "accounts",
+		summary:  "Delete a goal",
+		security: []string{securityAuthCookie},
+		status:   http.StatusNoContent,
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/api/v1/accounts/:accountID/domains",
+		tag:         "accounts",
+		summary:     "Replace the domains an account collects data on",
+		security:    []string{securityAuthCookie},
+		request:     domainsRequest{},
+		status:      http.StatusOK,
+		response:    domainsResponse{},
+		description: "Domains are host names like shop.mysite.org. Events recorded on pages of other domains are rejected. An empty list allows collecting data on any domain.",
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/api/v1/accounts/:accountID/privacy-signals",
+		tag:         "accounts",
+		summary:     "Set how Do Not Track and Global Privacy Control signals are handled",
+		security:    []string{securityAuthCookie},
+		request:     privacySignalsRequest{},
+		status:      http.StatusOK,
+		response:    privacySignalsResponse{},
+		description: "handling is one of ignore, opt_out and anonymous.",
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/api/v1/accounts/:accountID/timezone",
+		tag:         "accounts",
+		summary:     "Set the time zone days of rollups and exports are aligned to",
+		security:    []string{securityAuthCookie},
+		request:     timezoneRequest{},
+		status:      http.StatusOK,
+		response:    timezoneResponse{},
+		description: "timezone is an IANA time zone name like Europe/Berlin and defaults to UTC. Daily rollups covering events that are still stored are computed again.",
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/api/v1/accounts/:accountID/anomaly-detection",
+		tag:         "accounts",
+		summary:     "Set how sensitive alerts on unusual traffic are",
+		security:    []string{securityAuthCookie},
+		request:     anomalyDetectionRequest{},
+		status:      http.StatusOK,
+		response:    anomalyDetectionResponse{},
+		description: "sensitivity is one of off, low, medium and high and defaults to off. Alerts are sent to webhooks subscribed to traffic_anomaly.detected and to account users subscribed to reports.",
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/api/v1/accounts/:accountID/rotate-keys",
+		tag:      "accounts",
+		summary:  "Rotate the keys of an account",
+		security: []string{securityAuthCookie},
+		request:  rotateAccountKeysRequest{},
+		status:   http.StatusNoContent,
+	},
+	{
+		method:   http.MethodGet,
+		path:     "/api/v1/login",
+		tag:      "login",
+		summary:  "Retrieve the current login",
+		security: []string{securityAuthCookie, securityBearer},
+		status:   http.StatusOK,
+		response: persistence.LoginResult{},
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/api/v1/login",
+		tag:         "login",
+		summary:     "Log in using email and password",
+		request:     loginCredentials{},
+		status:      http.StatusOK,
+		response:    persistence.LoginResult{},
+		description: "On success, the auth cookie is set.",
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/api/v1/login/refresh",
+		tag:         "login",
+		summary:     "Refresh the current login",
+		status:      http.StatusOK,
+		response:    persistence.SessionResult{},
+		description: "Uses the refresh cookie that is set on login when refresh tokens are enabled.",
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/api/v1/login/service-account",
+		tag:      "login",
+		summary:  "Retrieve the keys of a service account",
+		request:  serviceAccountCredentials{},
+		status:   http.StatusOK,
+		response: persistence.LoginResult{},
+	},
+	{
+		method:  http.MethodPost,
+		path:    "/api/v1/logout",
+		tag:     "login",
+		summary: "Log out and revoke the current session",
+		status:  http.StatusNoContent,
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/api/v1/change-password",
+		tag:      "login",
+		summary:  "Change the password",
+		security: []string{securityAuthCookie},
+		request:  changePasswordRequest{},
+		status:   http.StatusNoContent,
+	},
+	{
+		method:  http.MethodPost,
+		path:    "/api/v1/forgot-password",
+		tag:     "login",
+		summary: "Request an email for resetting the password",
+		request: forgotPasswordRequest{},
+		status:  http.StatusNoContent,
+	},
+	{
+		method:  http.MethodPost,
+		path:    "/api/v1/reset-password",
+		tag:     "login",
+		summary: "Reset the password",
+		request: resetPasswordRequest{},
+		status:  http.StatusNoContent,
+	},
+	{
+		method:   http.MethodGet,
+		path:     "/api/v1/report-subscriptions",
+		tag:      "login",
+		summary:  "List the report subscriptions of the current account user",
+		security: []string{securityAuthCookie},
+		status:   http.StatusOK,
+		response: persistence.ReportSubscriptionResult{},
+	},
+	{
+		method:      http.MethodPut,
+		path:        "/api/v1/report-subscriptions/:accountID",
+		tag:         "login",
+		summary:     "Subscribe to reports of an account",
+		security:    []string{securityAuthCookie},
+		request:     reportSubscriptionRequest{},
+		status:      http.StatusOK,
+		response:    persistence.ReportSubscriptionResult{},
+		description: "frequency is either weekly or monthly. emailAddress must be the primary or a verified secondary email address of the account user.",
+	},
+	{
+		method:   http.MethodDelete,
+		path:     "/api/v1/report-subscriptions/:accountID",
+		tag:      "login",
+		summary:  "Unsubscribe from reports of an account",
+		security: []string{securityAuthCookie},
+		status:   http.StatusNoContent,
+	},
+}
+
+// newOpenAPISpec creates an OpenAPI 3 document describing the given
+// operations.
+func newOpenAPISpec(operations []apiOperation) map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]map[string]interface{}{}
+	for _, op := range operations {
+		path, params := openAPIPath(op.path)
+		for _, name := range op.query {
+			params = append(params, map[string]interface{}{
+				"name":   name,
+				"in":     "query",
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		operation := map[string]interface{}{
+			"tags":    []string{op.tag},
+			"summary": op.summary,
+		}
+		if op.description != "" {
+			operation["description"] = op.description
+		}
+		if len(params) != 0 {
+			operation["parameters"] = params
+		}
+		if len(op.security) != 0 {
+			var security []map[string][]string
+			for _, scheme := range op.security {
+				security = append(security, map[string][]string{scheme: {}})
+			}
+			operation["security"] = security
+		}
+		if op.request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": openAPISchema(reflect.TypeOf(op.request), schemas),
+					},
+				},
+			}
+		}
+		success := map[string]interface{}{
+			"description": http.StatusText(op.status),
+		}
+		if op.response != nil {
+			success["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": openAPISchema(reflect.TypeOf(op.response), schemas),
+				},
+			}
+		}
+		errorSchema := openAPISchema(reflect.TypeOf(errorResponse{}), schemas)
+		operation["responses"] = map[string]interface{}{
+			strconv.Itoa(op.status): success,
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content": map[string]interface{}{
+					problemContentType: map[string]interface{}{
+						"schema": errorSchema,
+					},
+				},
+			},
+		}
+		if _, ok := paths[path]; !ok {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(op.method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Offen API",
+			"version": config.Revision,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				securityUserCookie: map[string]interface{}{
+					"type": "apiKey",
+					"in":   "cookie",
+					"name": cookieKey,
+				},
+				securityAuthCookie: map[string]interface{}{
+					"type": "apiKey",
+					"in":   "cookie",
+					"name": authKey,
+				},
+				securityBearer: map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "An access token or the credential of a service account.",
+				},
+			},
+		},
+	}
+}
+
+// openAPIPath converts a route path to OpenAPI syntax and returns the
+// parameters it contains.
+func openAPIPath(routePath string) (string, []map[string]interface{}) {
+	var params []map[string]interface{}
+	segments := strings.Split(routePath, "/")
+	for idx, segment := range segments {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+		name := strings.TrimPrefix(segment, ":")
+		segments[idx] = "{" + name + "}"
+		params = append(params, map[string]interface{}{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	return strings.Join(segments, "/"), params
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// openAPISchema returns the schema for values of the given type. Named
+// structs are added to schemas and referenced.
+func openAPISchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		schema := openAPISchema(t.Elem(), schemas)
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{
+			"type":  "array",
+			"items": openAPISchema(t.Elem(), schemas),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": openAPISchema(t.Elem(), schemas),
+		}
+	case reflect.Struct:
+		name := t.Name()
+		if name != "" {
+			if _, ok := schemas[name]; !ok {
+				// the placeholder prevents endless recursion for types
+				// referring to themselves
+				schemas[name] = map[string]interface{}{}
+				schemas[name] = openAPIStructSchema(t, schemas)
+			}
+			return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+		}
+		return openAPIStructSchema(t, schemas)
+	default:
+		// interface values can hold anything
+		return map[string]interface{}{}
+	}
+}
+
+func openAPIStructSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		omitEmpty := false
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			chunks := strings.Split(tag, ",")
+			if chunks[0] == "-" {
+				continue
+			}
+			if chunks[0] != "" {
+				name = chunks[0]
+			}
+			for _, option := range chunks[1:] {
+				omitEmpty = omitEmpty || option == "omitempty"
+			}
+		}
+		properties[name] = openAPISchema(field.Type, schemas)
+		if !omitEmpty && field.Type.Kind() != reflect.Ptr {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) != 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (rt *router) getSpec(c *gin.Context) {
+	c.JSON(http.StatusOK, newOpenAPISpec(apiOperations))
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/config"
+)
+
+func TestAPIOperations(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.ReverseProxy = true
+	app, ok := New(
+		WithDatabase(&mockDatabase{}),
+		WithConfig(cfg),
+		WithTemplate(template.New("a test")),
+	).(*gin.Engine)
+	if !ok {
+		t.Fatal("Expected router to be a gin engine")
+	}
+	registered := map[string]bool{}
+	for _, route := range app.Routes() {
+		registered[route.Method+" "+route.Path] = true
+	}
+	for _, op := range apiOperations {
+		if !registered[op.method+" "+op.path] {
+			t.Errorf("Documented operation %s %s is not registered", op.method, op.path)
+		}
+	}
+}
+
+func TestRouter_getSpec(t *testing.T) {
+	rt := router{}
+	m := gin.New()
+	m.GET("/", rt.getSpec)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %v", w.Code)
+	}
+
+	var spec struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("Unexpected version %v", spec.OpenAPI)
+	}
+	if _, ok := spec.Paths["/api/v1/accounts/{accountID}"]["get"]; !ok {
+		t.Errorf("Expected path parameters to be converted, got %v", spec.Paths)
+	}
+	for _, name := range []string{"LoginResult", "loginCredentials", "EventsResult", "errorResponse"} {
+		if _, ok := spec.Components.Schemas[name]; !ok {
+			t.Errorf("Expected schema %s to be defined", name)
+		}
+	}
+	for _, ref := range strings.Split(w.Body.String(), `"$ref":"#/components/schemas/`)[1:] {
+		name := ref[:strings.Index(ref, `"`)]
+		if _, ok := spec.Components.Schemas[name]; !ok {
+			t.Errorf("Unresolved reference to %s", name)
+		}
+	}
+}
+
+func TestOpenAPISchema(t *testing.T) {
+	type nested struct {
+		Value string `json:"value"`
+	}
+	type example struct {
+		Name     string            `json:"name"`
+		Optional string            `json:"optional,omitempty"`
+		Hidden   string            `json:"-"`
+		Data     []byte            `json:"data"`
+		Items    []nested          `json:"items"`
+		Labels   map[string]string `json:"labels"`
+		Pointer  *nested           `json:"pointer"`
+		internal string
+	}
+	schemas := map[string]interface{}{}
+	ref := openAPISchema(reflect.TypeOf(example{}), schemas)
+	if ref["$ref"] != "#/components/schemas/example" {
+		t.Errorf("Unexpected reference %v", ref)
+	}
+	schema := schemas["example"].(map[string]interface{})
+	properties := schema["properties"].(map[string]interface{})
+	if len(properties) != 6 {
+		t.Errorf("Unexpected properties %v", properties)
+	}
+	if format := properties["data"].(map[string]interface{})["format"]; format != "byte" {
+		t.Errorf("Unexpected format for bytes %v", format)
+	}
+	required := schema["required"].([]string)
+	if strings.Join(required, ",") != "data,items,labels,name" {
+		t.Errorf("Unexpected required properties %v", required)
+	}
+	if _, ok := schemas["nested"]; !ok {
+		t.Error("Expected nested schema to be defined")
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+// hasPrivacySignal checks whether the browser sending the request signals
+// Do Not Track or Global Privacy Control.
+func hasPrivacySignal(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
+
+// privacySignals returns how events for the given account are to be handled
+// for the request. Accounts are only looked up for requests that carry a
+// signal, so other requests do not cause an additional query.
+func (rt *router) privacySignals(r *http.Request, accountID string) (string, error) {
+	if !hasPrivacySignal(r) {
+		return persistence.PrivacySignalsIgnore, nil
+	}
+	return rt.db.LookupPrivacySignals(accountID)
+}
+
+type privacySignalsRequest struct {
+	Handling string `json:"handling"`
+}
+
+type privacySignalsResponse struct {
+	Handling string `json:"handling"`
+}
+
+// postPrivacySignals sets how events sent by browsers signaling Do Not Track
+// or Global Privacy Control are handled for the given account.
+func (rt *router) postPrivacySignals(c *gin.Context) {
+	accountID := c.Param("accountID")
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: could not find account user object in request context"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+
+	if ok := accountUser.CanManageAccount(accountID); !ok {
+		newJSONError(
+			fmt.Errorf("router: account user does not have permissions to change handling of privacy signals of account %s", accountID),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	var req privacySignalsRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if err := rt.db.SetPrivacySignals(accountID, req.Handling); err != nil {
+		var errUnknown persistence.ErrUnknownAccount
+		if errors.As(err, &errUnknown) {
+			newJSONError(
+				fmt.Errorf("router: account %s not found: %w", accountID, err),
+				http.StatusNotFound,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error setting handling of privacy signals of account: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if rt.logger != nil {
+		rt.logger.
+			WithField("audit", "privacy_signals").
+			WithField("accountId", accountID).
+			WithField("handling", req.Handling).
+			WithField("by", accountUser.AccountUserID).
+			Info("Changed handling of privacy signals of account")
+	}
+	c.JSON(http.StatusOK, privacySignalsResponse{Handling: req.Handling})
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestHasPrivacySignal(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected bool
+	}{
+		{"none", nil, false},
+		{"dnt", map[string]string{"DNT": "1"}, true},
+		{"dnt unset", map[string]string{"DNT": "0"}, false},
+		{"gpc", map[string]string{"Sec-GPC": "1"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			for key, value := range test.headers {
+				r.Header.Set(key, value)
+			}
+			if result := hasPrivacySignal(r); result != test.expected {
+				t.Errorf("Expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+type mockPrivacySignalsDatabase struct {
+	persistence.Service
+	err      error
+	handling map[string]string
+	inserted map[string][]persistence.BatchEvent
+}
+
+func (m *mockPrivacySignalsDatabase) SetPrivacySignals(accountID, handling string) error {
+	return m.err
+}
+
+func (m *mockPrivacySignalsDatabase) LookupPrivacySignals(accountID string) (string, error) {
+	return m.handling[accountID], m.err
+}
+
+func (m *mockPrivacySignalsDatabase) Insert(userID, accountID, payload string, eventID *string) error {
+	m.inserted[userID] = append(m.inserted[userID], persistence.BatchEvent{AccountID: accountID, Payload: payload})
+	return nil
+}
+
+func (m *mockPrivacySignalsDatabase) InsertBatch(userID string, events []persistence.BatchEvent) error {
+	m.inserted[userID] = append(m.inserted[userID], events...)
+	return nil
+}
+
+func TestRouter_postPrivacySignals(t *testing.T) {
+	accountUser := persistence.LoginResult{
+		AccountUserID: "user-a",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a", Role: persistence.AccountUserRoleAdmin},
+			{AccountID: "account-b", Role: persistence.AccountUserRoleViewer},
+		},
+	}
+	tests := []struct {
+		name           string
+		db             *mockPrivacySignalsDatabase
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"ok", &mockPrivacySignalsDatabase{}, "/accounts/account-a/privacy-signals", `{"handling":"opt_out"}`, http.StatusOK},
+		{"viewer", &mockPrivacySignalsDatabase{}, "/accounts/account-b/privacy-signals", `{"handling":"opt_out"}`, http.StatusForbidden},
+		{"bad payload", &mockPrivacySignalsDatabase{}, "/accounts/account-a/privacy-signals", `{"handling":`, http.StatusBadRequest},
+		{"invalid", &mockPrivacySignalsDatabase{err: errors.New("did not work")}, "/accounts/account-a/privacy-signals", `{"handling":"respect"}`, http.StatusBadRequest},
+		{"unknown account", &mockPrivacySignalsDatabase{err: persistence.ErrUnknownAccount("did not work")}, "/accounts/account-a/privacy-signals", `{"handling":"opt_out"}`, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: test.db}
+			m := gin.New()
+			m.Use(func(c *gin.Context) {
+				c.Set(contextKeyAuth, accountUser)
+			})
+			m.POST("/accounts/:accountID/privacy-signals", rt.postPrivacySignals)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body)))
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+		})
+	}
+}
+
+func TestRouter_privacySignalsCollection(t *testing.T) {
+	handling := map[string]string{
+		"account-a": persistence.PrivacySignalsIgnore,
+		"account-b": persistence.PrivacySignalsOptOut,
+		"account-c": persistence.PrivacySignalsAnonymous,
+	}
+	tests := []struct {
+		name              string
+		path              string
+		body              string
+		signal            bool
+		expectedStatus    int
+		expectedUser      int
+		expectedAnonymous int
+		expectCookie      bool
+	}{
+		{"no signal", "/events", `{"accountId":"account-b","payload":"payload"}`, false, http.StatusCreated, 1, 0, true},
+		{"ignore", "/events", `{"accountId":"account-a","payload":"payload"}`, true, http.StatusCreated, 1, 0, true},
+		{"opt out", "/events", `{"accountId":"account-b","payload":"payload"}`, true, http.StatusNoContent, 0, 0, false},
+		{"anonymous", "/events", `{"accountId":"account-c","payload":"payload"}`, true, http.StatusCreated, 0, 1, false},
+		{
+			"batch",
+			"/events/batch",
+			`{"events":[{"accountId":"account-a","payload":"payload"},{"accountId":"account-b","payload":"payload"},{"accountId":"account-c","payload":"payload"}]}`,
+			true,
+			http.StatusCreated,
+			1,
+			1,
+			true,
+		},
+		{"batch opt out", "/events/batch", `{"events":[{"accountId":"account-b","payload":"payload"}]}`, true, http.StatusNoContent, 0, 0, false},
+		{"batch anonymous", "/events/batch", `{"events":[{"accountId":"account-c","payload":"payload"}]}`, true, http.StatusCreated, 0, 1, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &mockPrivacySignalsDatabase{handling: handling, inserted: map[string][]persistence.BatchEvent{}}
+			rt := router{db: db, config: &config.Config{}}
+			m := gin.New()
+			m.Use(func(c *gin.Context) {
+				c.Set(contextKeyCookie, "user-id")
+				c.Set(contextKeySecureContext, false)
+			})
+			m.POST("/events", rt.postEvents)
+			m.POST("/events/batch", rt.postEventsBatch)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body))
+			if test.signal {
+				r.Header.Set("Sec-GPC", "1")
+			}
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if len(db.inserted["user-id"]) != test.expectedUser {
+				t.Errorf("Expected %d events of the user, got %v", test.expectedUser, db.inserted["user-id"])
+			}
+			if len(db.inserted[""]) != test.expectedAnonymous {
+				t.Errorf("Expected %d anonymous events, got %v", test.expectedAnonymous, db.inserted[""])
+			}
+			for _, evt := range db.inserted[""] {
+				if evt.Payload != "" {
+					t.Errorf("Expected payload of anonymized event to be dropped, got %v", evt.Payload)
+				}
+			}
+			if cookie := w.Header().Get("Set-Cookie") != ""; cookie != test.expectCookie {
+				t.Errorf("Unexpected cookie header %v", w.Header().Get("Set-Cookie"))
+			}
+		})
+	}
+}
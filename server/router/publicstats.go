@@ -0,0 +1,204 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+// publicStatsMetrics are the metrics that can be requested from the public
+// stats endpoint. Only metadata is aggregated, so the values are the same
+// that are available through the GraphQL API.
+var publicStatsMetrics = []string{"events", "users", "anonymousEvents", "days"}
+
+type publicStatsDay struct {
+	Date   string `json:"date"`
+	Events int    `json:"events"`
+	Users  int    `json:"users"`
+}
+
+type publicStatsResponse struct {
+	AccountID       string           `json:"accountId"`
+	Events          *int             `json:"events,omitempty"`
+	Users           *int             `json:"users,omitempty"`
+	AnonymousEvents *int             `json:"anonymousEvents,omitempty"`
+	Days            []publicStatsDay `json:"days,omitempty"`
+}
+
+// parsePublicStatsMetrics returns the set of metrics requested in the given
+// comma separated list. All metrics are returned in case the list is empty.
+func parsePublicStatsMetrics(value string) (map[string]bool, error) {
+	result := map[string]bool{}
+	if value == "" {
+		for _, metric := range publicStatsMetrics {
+			result[metric] = true
+		}
+		return result, nil
+	}
+	for _, metric := range strings.Split(value, ",") {
+		metric = strings.TrimSpace(metric)
+		var known bool
+		for _, m := range publicStatsMetrics {
+			if m == metric {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("router: unknown metric %q", metric)
+		}
+		result[metric] = true
+	}
+	return result, nil
+}
+
+// getPublicStats serves aggregated metrics of an account to anyone holding
+// a token with the stats scope. As the token is meant to be embedded in
+// public pages, it can also be passed using the token query parameter and
+// responses can be read from any origin.
+func (rt *router) getPublicStats(c *gin.Context) {
+	c.Header("Access-Control-Allow-Origin", "*")
+	accountID := c.Param("accountID")
+
+	value := c.Query("token")
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		value = strings.TrimPrefix(header, "Bearer ")
+	}
+	if value == "" {
+		newJSONError(
+			errors.New("router: no token given"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+	token, err := rt.db.LookupAccessToken(value)
+	if err != nil {
+		if !errors.Is(err, persistence.ErrInvalidAccessToken) {
+			rt.logError(c, err, "error looking up access token")
+		}
+		newJSONError(
+			errors.New("router: invalid token"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+	if !token.HasScope(persistence.AccessTokenScopeStats) {
+		newJSONError(
+			errors.New("router: token has not been granted the stats scope"),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+	var granted bool
+	for _, id := range token.AccountIDs {
+		if id == accountID {
+			granted = true
+			break
+		}
+	}
+	if !granted {
+		newJSONError(
+			fmt.Errorf("router: token has not been granted access to account %s", accountID),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+	// tokens keep working only as long as their owner can access the account
+	accountUser, err := rt.db.LookupAccountUser(token.AccountUserID)
+	if err != nil || !accountUser.CanAccessAccount(accountID) {
+		newJSONError(
+			fmt.Errorf("router: token has not been granted access to account %s", accountID),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	metrics, err := parsePublicStatsMetrics(c.Query("metrics"))
+	if err != nil {
+		newJSONError(err, http.StatusBadRequest).Pipe(c)
+		return
+	}
+	var sinceID string
+	if since := c.Query("since"); since != "" {
+		sinceTime, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			newJSONError(
+				fmt.Errorf("router: invalid value %s for since, expected RFC 3339 timestamp", since),
+				http.StatusBadRequest,
+			).Pipe(c)
+			return
+		}
+		sinceID, err = persistence.EventIDAt(sinceTime)
+		if err != nil {
+			newJSONError(
+				fmt.Errorf("router: error creating event id: %w", err),
+				http.StatusInternalServerError,
+			).Pipe(c)
+			return
+		}
+	}
+
+	if l := <-rt.getLimiter().LinearThrottle(time.Second/2, fmt.Sprintf("publicStats-%s", token.TokenID)); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error rate limiting request: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
+	account, err := rt.tracedDB(c.Request.Context()).GetAccount(accountID, true, sinceID)
+	if err != nil {
+		var errUnknown persistence.ErrUnknownAccount
+		if errors.As(err, &errUnknown) {
+			newJSONError(
+				fmt.Errorf("router: account %s not found: %w", accountID, err),
+				http.StatusNotFound,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error looking up account: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	var events []persistence.EventResult
+	if account.Events != nil {
+		events = (*account.Events)[accountID]
+	}
+
+	aggregated := graphQLMetrics(events)
+	result := publicStatsResponse{AccountID: accountID}
+	if metrics["events"] {
+		value := aggregated["events"].(int)
+		result.Events = &value
+	}
+	if metrics["users"] {
+		value := aggregated["users"].(int)
+		result.Users = &value
+	}
+	if metrics["anonymousEvents"] {
+		value := aggregated["anonymousEvents"].(int)
+		result.AnonymousEvents = &value
+	}
+	if metrics["days"] {
+		result.Days = []publicStatsDay{}
+		for _, day := range aggregated["days"].([]interface{}) {
+			d := day.(map[string]interface{})
+			result.Days = append(result.Days, publicStatsDay{
+				Date:   d["date"].(string),
+				Events: d["events"].(int),
+				Users:  d["users"].(int),
+			})
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
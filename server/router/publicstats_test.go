@@ -0,0 +1,193 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockPublicStatsDatabase struct {
+	persistence.Service
+	events []persistence.EventResult
+	err    error
+}
+
+func (*mockPublicStatsDatabase) LookupAccessToken(token string) (persistence.AccessTokenResult, error) {
+	switch token {
+	case "stats-token":
+		return persistence.AccessTokenResult{TokenID: "stats", AccountUserID: "user-a", Scopes: []string{persistence.AccessTokenScopeStats}, AccountIDs: []string{"account-a", "account-z"}}, nil
+	case "read-token":
+		return persistence.AccessTokenResult{TokenID: "read", AccountUserID: "user-a", Scopes: []string{persistence.AccessTokenScopeRead}}, nil
+	default:
+		return persistence.AccessTokenResult{}, persistence.ErrInvalidAccessToken
+	}
+}
+
+func (*mockPublicStatsDatabase) LookupAccountUser(accountUserID string) (persistence.LoginResult, error) {
+	return persistence.LoginResult{
+		AccountUserID: accountUserID,
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a"},
+		},
+	}, nil
+}
+
+func (m *mockPublicStatsDatabase) GetAccount(accountID string, includeEvents bool, since string) (persistence.AccountResult, error) {
+	if m.err != nil {
+		return persistence.AccountResult{}, m.err
+	}
+	return persistence.AccountResult{
+		AccountID: accountID,
+		Events:    &persistence.EventsByAccountID{accountID: m.events},
+	}, nil
+}
+
+func TestRouter_getPublicStats(t *testing.T) {
+	secretA := "secret-a"
+	day := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	events := []persistence.EventResult{
+		{EventID: mustEventID(day), SecretID: &secretA},
+		{EventID: mustEventID(day.Add(time.Minute)), SecretID: &secretA},
+		{EventID: mustEventID(day.Add(time.Hour))},
+	}
+	tests := []struct {
+		name           string
+		db             *mockPublicStatsDatabase
+		path           string
+		header         string
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			"ok",
+			&mockPublicStatsDatabase{events: events},
+			"/accounts/account-a/public-stats?token=stats-token",
+			"",
+			http.StatusOK,
+			map[string]interface{}{
+				"accountId":       "account-a",
+				"events":          3.0,
+				"users":           1.0,
+				"anonymousEvents": 1.0,
+				"days": []interface{}{
+					map[string]interface{}{"date": "2020-06-01", "events": 3.0, "users": 1.0},
+				},
+			},
+		},
+		{
+			"selected metrics",
+			&mockPublicStatsDatabase{events: events},
+			"/accounts/account-a/public-stats?metrics=users,events",
+			"Bearer stats-token",
+			http.StatusOK,
+			map[string]interface{}{
+				"accountId": "account-a",
+				"events":    3.0,
+				"users":     1.0,
+			},
+		},
+		{
+			"unknown metric",
+			&mockPublicStatsDatabase{events: events},
+			"/accounts/account-a/public-stats?token=stats-token&metrics=payload",
+			"",
+			http.StatusBadRequest,
+			nil,
+		},
+		{
+			"bad since",
+			&mockPublicStatsDatabase{events: events},
+			"/accounts/account-a/public-stats?token=stats-token&since=yesterday",
+			"",
+			http.StatusBadRequest,
+			nil,
+		},
+		{
+			"no token",
+			&mockPublicStatsDatabase{},
+			"/accounts/account-a/public-stats",
+			"",
+			http.StatusUnauthorized,
+			nil,
+		},
+		{
+			"unknown token",
+			&mockPublicStatsDatabase{},
+			"/accounts/account-a/public-stats?token=other-token",
+			"",
+			http.StatusUnauthorized,
+			nil,
+		},
+		{
+			"read token",
+			&mockPublicStatsDatabase{},
+			"/accounts/account-a/public-stats?token=read-token",
+			"",
+			http.StatusForbidden,
+			nil,
+		},
+		{
+			"other account",
+			&mockPublicStatsDatabase{},
+			"/accounts/account-b/public-stats?token=stats-token",
+			"",
+			http.StatusForbidden,
+			nil,
+		},
+		{
+			"access revoked",
+			&mockPublicStatsDatabase{},
+			"/accounts/account-z/public-stats?token=stats-token",
+			"",
+			http.StatusForbidden,
+			nil,
+		},
+		{
+			"database error",
+			&mockPublicStatsDatabase{err: errors.New("did not work")},
+			"/accounts/account-a/public-stats?token=stats-token",
+			"",
+			http.StatusInternalServerError,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: test.db}
+			m := gin.New()
+			m.GET("/accounts/:accountID/public-stats", rt.getPublicStats)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.header != "" {
+				r.Header.Set("Authorization", test.header)
+			}
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+				t.Errorf("Unexpected CORS header %v", w.Header().Get("Access-Control-Allow-Origin"))
+			}
+			if test.expectedBody == nil {
+				return
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(body, test.expectedBody) {
+				t.Errorf("Unexpected body %v", body)
+			}
+		})
+	}
+}
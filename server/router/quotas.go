@@ -0,0 +1,190 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/metrics"
+)
+
+// quotaWindow is the period the events quota of an account applies to.
+const quotaWindow = time.Minute
+
+// Reasons for rejecting events of an account that exceeds its quotas.
+const (
+	quotaReasonEvents       = "events"
+	quotaReasonPayloadBytes = "payload_bytes"
+)
+
+// quotas limits the number of events each account can submit per window
+// and the size of their payloads, so that a single account cannot exhaust
+// the resources of an instance that is shared by many accounts. Usage is
+// counted in memory, so quotas apply to each instance separately.
+type quotas struct {
+	events       int
+	payloadBytes int
+	lock         sync.Mutex
+	usage        map[string]*quotaUsage
+	pruned       time.Time
+}
+
+type quotaUsage struct {
+	window   time.Time
+	events   int
+	rejected map[string]int64
+}
+
+func newQuotas(events, payloadBytes int) *quotas {
+	return &quotas{
+		events:       events,
+		payloadBytes: payloadBytes,
+		usage:        map[string]*quotaUsage{},
+	}
+}
+
+// quotaError describes events that have been rejected as an account exceeds
+// its quotas.
+type quotaError struct {
+	accountID  string
+	reason     string
+	retryAfter time.Duration
+}
+
+func (q *quotaError) pipe(c *gin.Context) {
+	if q.reason == quotaReasonPayloadBytes {
+		newJSONError(
+			fmt.Errorf("router: payload exceeds the quota of account %s", q.accountID),
+			http.StatusRequestEntityTooLarge,
+		).Pipe(c)
+		return
+	}
+	c.Header("Retry-After", strconv.Itoa(ceilSeconds(q.retryAfter)))
+	newJSONError(
+		fmt.Errorf("router: account %s has exceeded its quota of events", q.accountID),
+		http.StatusTooManyRequests,
+	).Pipe(c)
+}
+
+// take counts the given payloads, keyed by the account they are submitted
+// to. In case any account would exceed its quotas, nothing is counted and
+// an error describing the first violation is returned. Calling take on a nil
+// value accepts all events.
+func (q *quotas) take(payloads map[string][]string, now time.Time) *quotaError {
+	if q == nil {
+		return nil
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	window := now.Truncate(quotaWindow)
+	for accountID, accountPayloads := range payloads {
+		if q.payloadBytes > 0 {
+			for _, payload := range accountPayloads {
+				if len(payload) > q.payloadBytes {
+					return q.reject(accountID, quotaReasonPayloadBytes, len(accountPayloads), 0)
+				}
+			}
+		}
+		if q.events <= 0 {
+			continue
+		}
+		used := 0
+		if usage, ok := q.usage[accountID]; ok && usage.window.Equal(window) {
+			used = usage.events
+		}
+		if used+len(accountPayloads) > q.events {
+			return q.reject(accountID, quotaReasonEvents, len(accountPayloads), window.Add(quotaWindow).Sub(now))
+		}
+	}
+
+	for accountID, accountPayloads := range payloads {
+		usage := q.get(accountID)
+		if !usage.window.Equal(window) {
+			usage.window = window
+			usage.events = 0
+		}
+		usage.events += len(accountPayloads)
+	}
+	// accounts that have not submitted events in the last window are
+	// removed so unknown account ids do not grow the map without bounds
+	if now.Sub(q.pruned) >= quotaWindow {
+		for accountID, usage := range q.usage {
+			if usage.window.Before(window) && len(usage.rejected) == 0 {
+				delete(q.usage, accountID)
+			}
+		}
+		q.pruned = now
+	}
+	return nil
+}
+
+func (q *quotas) get(accountID string) *quotaUsage {
+	usage, ok := q.usage[accountID]
+	if !ok {
+		usage = &quotaUsage{rejected: map[string]int64{}}
+		q.usage[accountID] = usage
+	}
+	return usage
+}
+
+func (q *quotas) reject(accountID, reason string, count int, retryAfter time.Duration) *quotaError {
+	q.get(accountID).rejected[reason] += int64(count)
+	metrics.EventsRejected.Add(float64(count), reason)
+	return &quotaError{accountID: accountID, reason: reason, retryAfter: retryAfter}
+}
+
+type quotaResponse struct {
+	AccountID         string           `json:"accountId"`
+	EventsLimit       int              `json:"eventsLimit"`
+	EventsUsed        int              `json:"eventsUsed"`
+	PayloadBytesLimit int              `json:"payloadBytesLimit"`
+	WindowSeconds     int              `json:"windowSeconds"`
+	ResetSeconds      int              `json:"resetSeconds"`
+	Rejected          map[string]int64 `json:"rejected"`
+}
+
+// report returns the usage of the quotas of the given account. Calling
+// report on a nil value reports no limits.
+func (q *quotas) report(accountID string, now time.Time) quotaResponse {
+	window := now.Truncate(quotaWindow)
+	result := quotaResponse{
+		AccountID:     accountID,
+		WindowSeconds: int(quotaWindow / time.Second),
+		ResetSeconds:  ceilSeconds(window.Add(quotaWindow).Sub(now)),
+		Rejected: map[string]int64{
+			quotaReasonEvents:       0,
+			quotaReasonPayloadBytes: 0,
+		},
+	}
+	if q == nil {
+		return result
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	result.EventsLimit = q.events
+	result.PayloadBytesLimit = q.payloadBytes
+	if usage, ok := q.usage[accountID]; ok {
+		if usage.window.Equal(window) {
+			result.EventsUsed = usage.events
+		}
+		for reason, count := range usage.rejected {
+			result.Rejected[reason] = count
+		}
+	}
+	return result
+}
+
+func (rt *router) getQuota(c *gin.Context) {
+	accountID := c.Param("accountID")
+	if !rt.accessibleAccount(c, accountID) {
+		return
+	}
+	c.JSON(http.StatusOK, rt.quotas.report(accountID, time.Now()))
+}
@@ -0,0 +1,144 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestQuotas(t *testing.T) {
+	now := time.Date(2020, 3, 1, 12, 0, 15, 0, time.UTC)
+	q := newQuotas(3, 8)
+
+	if err := q.take(map[string][]string{"account-a": {"a", "b"}, "account-b": {"c"}}, now); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := q.take(map[string][]string{"account-a": {"payload-too-large"}}, now); err == nil || err.reason != quotaReasonPayloadBytes {
+		t.Errorf("Expected payload to be rejected, got %v", err)
+	}
+	// batches are rejected as a whole
+	err := q.take(map[string][]string{"account-a": {"d", "e"}, "account-b": {"f"}}, now.Add(time.Second*30))
+	if err == nil || err.reason != quotaReasonEvents || err.accountID != "account-a" {
+		t.Fatalf("Expected events to be rejected, got %v", err)
+	}
+	if err.retryAfter != time.Second*15 {
+		t.Errorf("Expected retry after 15s, got %v", err.retryAfter)
+	}
+	if err := q.take(map[string][]string{"account-a": {"d"}}, now.Add(time.Second*30)); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	expected := quotaResponse{
+		AccountID:         "account-a",
+		EventsLimit:       3,
+		EventsUsed:        3,
+		PayloadBytesLimit: 8,
+		WindowSeconds:     60,
+		ResetSeconds:      15,
+		Rejected:          map[string]int64{quotaReasonEvents: 2, quotaReasonPayloadBytes: 1},
+	}
+	if report := q.report("account-a", now.Add(time.Second*30)); !reflect.DeepEqual(expected, report) {
+		t.Errorf("Expected %v, got %v", expected, report)
+	}
+	if report := q.report("account-b", now.Add(time.Minute)); report.EventsUsed != 0 {
+		t.Errorf("Expected usage to be reset in the next window, got %v", report)
+	}
+
+	// the next window accepts events again and removes accounts that are
+	// not in use anymore
+	if err := q.take(map[string][]string{"account-a": {"a", "b", "c"}}, now.Add(time.Minute)); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, ok := q.usage["account-b"]; ok {
+		t.Errorf("Expected unused account to be removed, got %v", q.usage)
+	}
+
+	var disabled *quotas
+	if err := disabled.take(map[string][]string{"account-a": {"payload-too-large"}}, now); err != nil {
+		t.Errorf("Expected nil value to accept all events, got %v", err)
+	}
+	if report := disabled.report("account-a", now); report.EventsLimit != 0 || report.Rejected[quotaReasonEvents] != 0 {
+		t.Errorf("Unexpected report %v", report)
+	}
+}
+
+func TestRouter_quotasCollection(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		expectedStatus int
+		expectRetry    bool
+	}{
+		{"ok", "/events", `{"accountId":"account-a","payload":"a"}`, http.StatusCreated, false},
+		{"payload too large", "/events", `{"accountId":"account-a","payload":"payload-too-large"}`, http.StatusRequestEntityTooLarge, false},
+		{"batch ok", "/events/batch", `{"events":[{"accountId":"account-a","payload":"a"},{"accountId":"account-a","payload":"b"}]}`, http.StatusCreated, false},
+		{"batch exceeds quota", "/events/batch", `{"events":[{"accountId":"account-a","payload":"a"},{"accountId":"account-a","payload":"b"},{"accountId":"account-a","payload":"c"}]}`, http.StatusTooManyRequests, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{
+				db:     &mockDomainsDatabase{},
+				config: &config.Config{},
+				quotas: newQuotas(2, 8),
+			}
+			m := gin.New()
+			m.Use(func(c *gin.Context) {
+				c.Set(contextKeyCookie, "user-id")
+				c.Set(contextKeySecureContext, false)
+			})
+			m.POST("/events", rt.postEvents)
+			m.POST("/events/batch", rt.postEventsBatch)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body)))
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if retry := w.Header().Get("Retry-After") != ""; retry != test.expectRetry {
+				t.Errorf("Unexpected Retry-After header %v", w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestRouter_getQuota(t *testing.T) {
+	accountUser := persistence.LoginResult{
+		AccountUserID: "user-a",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a", Role: persistence.AccountUserRoleViewer},
+		},
+	}
+	rt := router{quotas: newQuotas(10, 0)}
+	rt.quotas.take(map[string][]string{"account-a": {"a", "b"}}, time.Now())
+	m := gin.New()
+	m.Use(func(c *gin.Context) {
+		c.Set(contextKeyAuth, accountUser)
+	})
+	m.GET("/accounts/:accountID/quota", rt.getQuota)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/accounts/account-a/quota", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Unexpected status code %v", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"eventsLimit":10`) {
+		t.Errorf("Unexpected response body %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/accounts/account-b/quota", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code %v", w.Code)
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/referrers"
+)
+
+// getReferrerRules serves the ruleset clients use for classifying the
+// referrers of decrypted events.
+func (rt *router) getReferrerRules(c *gin.Context) {
+	c.JSON(http.StatusOK, referrers.Default())
+}
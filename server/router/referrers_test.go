@@ -0,0 +1,41 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/referrers"
+)
+
+func TestRouter_getReferrerRules(t *testing.T) {
+	rt := router{}
+	m := gin.New()
+	m.GET("/", etagMiddleware(), rt.getReferrerRules)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %v", w.Code)
+	}
+	var ruleset referrers.Ruleset
+	if err := json.Unmarshal(w.Body.Bytes(), &ruleset); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if ruleset.Version != referrers.Default().Version || len(ruleset.Rules) == 0 {
+		t.Errorf("Unexpected ruleset %v", ruleset)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", w.Header().Get("Etag"))
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Unexpected status code %v", w.Code)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+func (rt *router) getReportSubscriptions(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	subscriptions, err := rt.db.ListReportSubscriptions(accountUser.AccountUserID)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error looking up report subscriptions: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{"reportSubscriptions": subscriptions})
+}
+
+type reportSubscriptionRequest struct {
+	EmailAddress string `json:"emailAddress"`
+	Frequency    string `json:"frequency"`
+}
+
+func (rt *router) putReportSubscription(c *gin.Context) {
+	accountID := c.Param("accountID")
+	if !rt.accessibleAccount(c, accountID) {
+		return
+	}
+	accountUser := c.Value(contextKeyAuth).(persistence.LoginResult)
+
+	var req reportSubscriptionRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	result, err := rt.db.SubscribeToReports(accountUser.AccountUserID, accountID, req.EmailAddress, req.Frequency)
+	if err != nil {
+		if errors.Is(err, persistence.ErrPermissionDenied) {
+			newJSONError(
+				fmt.Errorf("router: error subscribing to reports: %w", err),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error subscribing to reports: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (rt *router) deleteReportSubscription(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	if err := rt.db.UnsubscribeFromReports(accountUser.AccountUserID, c.Param("accountID")); err != nil {
+		if errors.Is(err, persistence.ErrUnknownReportSubscription) {
+			newJSONError(
+				fmt.Errorf("router: no report subscription found for account %s: %w", c.Param("accountID"), err),
+				http.StatusNotFound,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error deleting report subscription: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockReportsDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockReportsDatabase) ListReportSubscriptions(accountUserID string) ([]persistence.ReportSubscriptionResult, error) {
+	return []persistence.ReportSubscriptionResult{{ReportSubscriptionID: "subscription-a", AccountUserID: accountUserID, AccountID: "account-a"}}, m.err
+}
+
+func (m *mockReportsDatabase) SubscribeToReports(accountUserID, accountID, emailAddress, frequency string) (persistence.ReportSubscriptionResult, error) {
+	return persistence.ReportSubscriptionResult{ReportSubscriptionID: "subscription-a", AccountUserID: accountUserID, AccountID: accountID, EmailAddress: emailAddress, Frequency: frequency}, m.err
+}
+
+func (m *mockReportsDatabase) UnsubscribeFromReports(accountUserID, accountID string) error {
+	return m.err
+}
+
+func TestRouter_reportSubscriptions(t *testing.T) {
+	accountUser := persistence.LoginResult{
+		AccountUserID: "user-a",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a", Role: persistence.AccountUserRoleViewer},
+		},
+	}
+	tests := []struct {
+		name           string
+		db             *mockReportsDatabase
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"list", &mockReportsDatabase{}, http.MethodGet, "/report-subscriptions", "", http.StatusOK, `"reportSubscriptionId":"subscription-a"`},
+		{"list error", &mockReportsDatabase{err: errors.New("did not work")}, http.MethodGet, "/report-subscriptions", "", http.StatusInternalServerError, ""},
+		{"subscribe", &mockReportsDatabase{}, http.MethodPut, "/report-subscriptions/account-a", `{"emailAddress":"develop@offen.dev","frequency":"weekly"}`, http.StatusOK, `"emailAddress":"develop@offen.dev","frequency":"weekly"`},
+		{"subscribe other account", &mockReportsDatabase{}, http.MethodPut, "/report-subscriptions/account-z", `{}`, http.StatusForbidden, ""},
+		{"subscribe bad payload", &mockReportsDatabase{}, http.MethodPut, "/report-subscriptions/account-a", `{"frequency":`, http.StatusBadRequest, ""},
+		{"subscribe other email", &mockReportsDatabase{err: persistence.ErrPermissionDenied}, http.MethodPut, "/report-subscriptions/account-a", `{}`, http.StatusForbidden, ""},
+		{"subscribe invalid", &mockReportsDatabase{err: errors.New("did not work")}, http.MethodPut, "/report-subscriptions/account-a", `{}`, http.StatusBadRequest, ""},
+		{"unsubscribe", &mockReportsDatabase{}, http.MethodDelete, "/report-subscriptions/account-a", "", http.StatusNoContent, ""},
+		{"unsubscribe unknown", &mockReportsDatabase{err: persistence.ErrUnknownReportSubscription}, http.MethodDelete, "/report-subscriptions/account-z", "", http.StatusNotFound, `"code":"unknown_report_subscription"`},
+		{"unsubscribe error", &mockReportsDatabase{err: errors.New("did not work")}, http.MethodDelete, "/report-subscriptions/account-a", "", http.StatusInternalServerError, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: test.db}
+			m := gin.New()
+			m.Use(func(c *gin.Context) {
+				c.Set(contextKeyAuth, accountUser)
+			})
+			m.GET("/report-subscriptions", rt.getReportSubscriptions)
+			m.PUT("/report-subscriptions/:accountID", rt.putReportSubscription)
+			m.DELETE("/report-subscriptions/:accountID", rt.deleteReportSubscription)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if !strings.Contains(w.Body.String(), test.expectedBody) {
+				t.Errorf("Unexpected body %v", w.Body.String())
+			}
+		})
+	}
+}
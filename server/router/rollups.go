@@ -0,0 +1,167 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+const (
+	defaultRollupRange = time.Hour * 24 * 30
+	maxRollupRange     = time.Hour * 24 * 366
+)
+
+type updateRollupRequest struct {
+	EncryptedPayload string `json:"encryptedPayload"`
+}
+
+// accessibleAccount checks whether the account user of the request is
+// allowed to access the account of the given id. Otherwise an error is piped
+// to the client.
+func (rt *router) accessibleAccount(c *gin.Context, accountID string) bool {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: could not find account user object in request context"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return false
+	}
+	if !accountUser.CanAccessAccount(accountID) {
+		newJSONError(
+			fmt.Errorf("router: account user does not have permissions to access account %s", accountID),
+			http.StatusForbidden,
+		).Pipe(c)
+		return false
+	}
+	return true
+}
+
+// rollupRange parses the period and range of rollups requested using the
+// period, from and until query parameters. In case the parameters are
+// invalid, an error is piped to the client.
+func rollupRange(c *gin.Context) (string, time.Time, time.Time, bool) {
+	period := c.DefaultQuery("period", persistence.RollupPeriodDay)
+	if !persistence.ValidRollupPeriod(period) {
+		newJSONError(
+			fmt.Errorf("router: unknown rollup period %q", period),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return "", time.Time{}, time.Time{}, false
+	}
+
+	until := time.Now()
+	if value := c.Query("until"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			newJSONError(
+				fmt.Errorf("router: error parsing until parameter: %w", err),
+				http.StatusBadRequest,
+			).Pipe(c)
+			return "", time.Time{}, time.Time{}, false
+		}
+		until = parsed
+	}
+	from := until.Add(-defaultRollupRange)
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			newJSONError(
+				fmt.Errorf("router: error parsing from parameter: %w", err),
+				http.StatusBadRequest,
+			).Pipe(c)
+			return "", time.Time{}, time.Time{}, false
+		}
+		from = parsed
+	}
+	if !from.Before(until) || until.Sub(from) > maxRollupRange {
+		newJSONError(
+			fmt.Errorf("router: from needs to be before until and the range cannot exceed %v", maxRollupRange),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return "", time.Time{}, time.Time{}, false
+	}
+	return period, from, until, true
+}
+
+func (rt *router) getRollups(c *gin.Context) {
+	accountID := c.Param("accountID")
+	if !rt.accessibleAccount(c, accountID) {
+		return
+	}
+	period, from, until, ok := rollupRange(c)
+	if !ok {
+		return
+	}
+
+	result, err := rt.db.GetRollups(accountID, period, from, until)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error looking up rollups: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{"rollups": result})
+}
+
+func (rt *router) putRollup(c *gin.Context) {
+	accountID := c.Param("accountID")
+	if _, ok := rt.manageableAccount(c, accountID); !ok {
+		return
+	}
+	period := c.Param("period")
+	if !persistence.ValidRollupPeriod(period) {
+		newJSONError(
+			fmt.Errorf("router: unknown rollup period %q", period),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	start, err := time.Parse(time.RFC3339, c.Param("start"))
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error parsing start of rollup: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	var req updateRollupRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	if req.EncryptedPayload == "" {
+		newJSONError(
+			errors.New("router: encrypted payload is required"),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	result, err := rt.db.UpdateRollupPayload(accountID, period, start, req.EncryptedPayload)
+	if err != nil {
+		if errors.Is(err, persistence.ErrUnknownRollup) {
+			newJSONError(
+				fmt.Errorf("router: rollup starting at %s not found: %w", c.Param("start"), err),
+				http.StatusNotFound,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error updating rollup: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
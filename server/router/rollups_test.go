@@ -0,0 +1,89 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockRollupsDatabase struct {
+	persistence.Service
+	err      error
+	from     time.Time
+	until    time.Time
+	received string
+}
+
+func (m *mockRollupsDatabase) GetRollups(accountID, period string, from, until time.Time) ([]persistence.RollupResult, error) {
+	m.from, m.until = from, until
+	return []persistence.RollupResult{{AccountID: accountID, Period: period, Events: 12}}, m.err
+}
+
+func (m *mockRollupsDatabase) UpdateRollupPayload(accountID, period string, start time.Time, encryptedPayload string) (persistence.RollupResult, error) {
+	m.received = encryptedPayload
+	return persistence.RollupResult{AccountID: accountID, Period: period, Start: start, EncryptedPayload: encryptedPayload}, m.err
+}
+
+func TestRouter_rollups(t *testing.T) {
+	accountUser := persistence.LoginResult{
+		AccountUserID: "user-a",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a", Role: persistence.AccountUserRoleAdmin},
+			{AccountID: "account-b", Role: persistence.AccountUserRoleViewer},
+		},
+	}
+	tests := []struct {
+		name           string
+		db             *mockRollupsDatabase
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"list", &mockRollupsDatabase{}, http.MethodGet, "/accounts/account-a/rollups?period=hour&from=2020-03-01T00:00:00Z&until=2020-03-02T00:00:00Z", "", http.StatusOK, `"period":"hour","start"`},
+		{"list default period", &mockRollupsDatabase{}, http.MethodGet, "/accounts/account-a/rollups", "", http.StatusOK, `"period":"day"`},
+		{"list as viewer", &mockRollupsDatabase{}, http.MethodGet, "/accounts/account-b/rollups", "", http.StatusOK, `"period":"day"`},
+		{"list other account", &mockRollupsDatabase{}, http.MethodGet, "/accounts/account-z/rollups", "", http.StatusForbidden, ""},
+		{"list bad period", &mockRollupsDatabase{}, http.MethodGet, "/accounts/account-a/rollups?period=week", "", http.StatusBadRequest, ""},
+		{"list bad time", &mockRollupsDatabase{}, http.MethodGet, "/accounts/account-a/rollups?from=yesterday", "", http.StatusBadRequest, ""},
+		{"list bad range", &mockRollupsDatabase{}, http.MethodGet, "/accounts/account-a/rollups?from=2020-03-02T00:00:00Z&until=2020-03-01T00:00:00Z", "", http.StatusBadRequest, ""},
+		{"list error", &mockRollupsDatabase{err: errors.New("did not work")}, http.MethodGet, "/accounts/account-a/rollups", "", http.StatusInternalServerError, ""},
+		{"update", &mockRollupsDatabase{}, http.MethodPut, "/accounts/account-a/rollups/day/2020-03-01T00:00:00Z", `{"encryptedPayload":"payload"}`, http.StatusOK, `"encryptedPayload":"payload"`},
+		{"update empty", &mockRollupsDatabase{}, http.MethodPut, "/accounts/account-a/rollups/day/2020-03-01T00:00:00Z", `{}`, http.StatusBadRequest, ""},
+		{"update bad start", &mockRollupsDatabase{}, http.MethodPut, "/accounts/account-a/rollups/day/yesterday", `{"encryptedPayload":"payload"}`, http.StatusBadRequest, ""},
+		{"update unknown", &mockRollupsDatabase{err: persistence.ErrUnknownRollup}, http.MethodPut, "/accounts/account-a/rollups/day/2020-03-01T00:00:00Z", `{"encryptedPayload":"payload"}`, http.StatusNotFound, `"code":"unknown_rollup"`},
+		{"update other account", &mockRollupsDatabase{}, http.MethodPut, "/accounts/account-z/rollups/day/2020-03-01T00:00:00Z", `{"encryptedPayload":"payload"}`, http.StatusForbidden, ""},
+		{"update as viewer", &mockRollupsDatabase{}, http.MethodPut, "/accounts/account-b/rollups/day/2020-03-01T00:00:00Z", `{"encryptedPayload":"payload"}`, http.StatusForbidden, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: test.db}
+			m := gin.New()
+			m.Use(func(c *gin.Context) {
+				c.Set(contextKeyAuth, accountUser)
+			})
+			m.GET("/accounts/:accountID/rollups", rt.getRollups)
+			m.PUT("/accounts/:accountID/rollups/:period/:start", rt.putRollup)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if !strings.Contains(w.Body.String(), test.expectedBody) {
+				t.Errorf("Unexpected body %v", w.Body.String())
+			}
+		})
+	}
+}
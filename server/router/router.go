@@ -9,48 +9,88 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/NYTimes/gziphandler"
-	"github.com/felixge/httpsnoop"
 	"github.com/gin-contrib/location"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/securecookie"
 	"github.com/microcosm-cc/bluemonday"
 	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/geoip"
+	"github.com/offen/offen/server/keys"
 	"github.com/offen/offen/server/mailer"
+	"github.com/offen/offen/server/oidc"
 	"github.com/offen/offen/server/persistence"
 	ratelimiter "github.com/offen/offen/server/ratelimiter"
+	"github.com/offen/offen/server/saml"
+	"github.com/offen/offen/server/webhooks"
 	"github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
 )
 
 type router struct {
-	db           persistence.Service
-	mailer       mailer.Mailer
-	fs           http.FileSystem
-	logger       *logrus.Logger
-	cookieSigner *securecookie.SecureCookie
-	template     *template.Template
-	emails       *template.Template
-	config       *config.Config
-	sanitizer    *bluemonday.Policy
-	limiter      ratelimiter.Throttler
+	db              persistence.Service
+	mailer          mailer.Mailer
+	fs              http.FileSystem
+	logger          *logrus.Logger
+	cookieSigner    *securecookie.SecureCookie
+	cookieVerifiers []securecookie.Codec
+	signingKeys     *keys.SigningKeyring
+	template        *template.Template
+	emails          *template.Template
+	config          *config.Config
+	sanitizer       *bluemonday.Policy
+	limiter         ratelimiter.Throttler
+	lockout         *ratelimiter.Lockout
+	consumedTokens  *cache.Cache
+	idempotencyKeys *cache.Cache
+	live            *liveBroker
+	active          *activeUsers
+	dedupe          *dedupe
+	quotas          *quotas
+	bots            *botFilter
+	geoIP           *geoip.Reader
+	webhooks        *webhooks.Dispatcher
+	shutdown        <-chan struct{}
+	oidc            *oidc.Provider
+	saml            *saml.ServiceProvider
+}
+
+// decodeSigned decodes a value that has been signed using either the
+// current or one of the previous secrets.
+func (rt *router) decodeSigned(name, value string, dst interface{}) error {
+	codecs := append([]securecookie.Codec{rt.cookieSigner}, rt.cookieVerifiers...)
+	return securecookie.DecodeMulti(name, value, dst, codecs...)
 }
 
 func (rt *router) getLimiter() ratelimiter.Throttler {
 	if rt.limiter == nil {
-		if rt.config != nil && rt.config.Server.ReverseProxy {
-			rt.limiter = ratelimiter.NewNoopRateLimiter()
-		} else {
-			rt.limiter = ratelimiter.New(time.Second*30, cache.New(time.Minute, time.Minute*2))
-		}
+		rt.limiter = ratelimiter.New(time.Second*30, cache.New(time.Minute, time.Minute*2))
 	}
 	return rt.limiter
 }
 
-func (rt *router) logError(err error, message string) {
-	if rt.logger != nil {
-		rt.logger.WithError(err).Error(message)
+func (rt *router) getLockout() *ratelimiter.Lockout {
+	if rt.lockout == nil {
+		var attempts int
+		duration := time.Minute * 15
+		if rt.config != nil {
+			attempts, duration = rt.config.Lockout.Attempts, rt.config.Lockout.Duration
+		}
+		rt.lockout = ratelimiter.NewLockout(attempts, duration, cache.New(duration, time.Minute*2))
+	}
+	return rt.lockout
+}
+
+// logError logs the given error, adding the id of the request to the entry
+// in case one has been assigned.
+func (rt *router) logError(c *gin.Context, err error, message string) {
+	if rt.logger == nil {
+		return
+	}
+	entry := rt.logger.WithError(err)
+	if requestID := c.GetString(contextKeyRequestID); requestID != "" {
+		entry = entry.WithField("requestId", requestID)
 	}
+	entry.Error(message)
 }
 
 const (
@@ -58,9 +98,13 @@ const (
 	optinKey                = "consent"
 	optinValue              = "allow"
 	authKey                 = "auth"
+	refreshKey              = "refresh"
 	contextKeyCookie        = "contextKeyCookie"
 	contextKeyAuth          = "contextKeyAuth"
+	contextKeySession       = "contextKeySession"
 	contextKeySecureContext = "contextKeySecure"
+	contextKeyReadOnly      = "contextKeyReadOnly"
+	contextKeyRequestID     = "contextKeyRequestID"
 )
 
 func (rt *router) userCookie(userID string, secure bool) *http.Cookie {
@@ -84,7 +128,69 @@ func (rt *router) userCookie(userID string, secure bool) *http.Cookie {
 	return c
 }
 
-func (rt *router) authCookie(userID string, secure bool) (*http.Cookie, error) {
+// Unversioned API routes are deprecated in favor of the ones below /api/v1
+// and will be removed after the sunset date.
+var (
+	legacyAPIDeprecation = time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	legacyAPISunset      = time.Date(2027, time.October, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// defaultSessionTTL is the duration after which sessions of account users
+// expire in case no other value is configured
+const defaultSessionTTL = time.Hour * 24
+
+func (rt *router) sessionTTL() time.Duration {
+	if rt.config == nil || rt.config.Session.TTL == 0 {
+		return defaultSessionTTL
+	}
+	return rt.config.Session.TTL
+}
+
+// refreshTTL returns the duration after which unused refresh tokens expire.
+// Refresh tokens are not issued in case it is zero.
+func (rt *router) refreshTTL() time.Duration {
+	if rt.config == nil {
+		return 0
+	}
+	return rt.config.Session.RefreshTTL
+}
+
+// sessionCookie creates a new session for the given account user and returns
+// the auth cookie referring to it. In case refresh tokens are enabled, the
+// cookie carrying the refresh token is set on the response.
+func (rt *router) sessionCookie(c *gin.Context, accountUserID string) (*http.Cookie, error) {
+	session, err := rt.db.CreateSession(accountUserID, rt.clientIP(c), c.Request.UserAgent(), rt.sessionTTL(), rt.refreshTTL())
+	if err != nil {
+		return nil, fmt.Errorf("router: error creating session: %w", err)
+	}
+	if session.RefreshToken != "" {
+		http.SetCookie(c.Writer, rt.refreshCookie(session, c.GetBool(contextKeySecureContext)))
+	}
+	return rt.authCookie(session.SessionID, c.GetBool(contextKeySecureContext))
+}
+
+// refreshCookie returns the cookie carrying the refresh token of the given
+// session. In case the session does not carry a refresh token, the cookie is
+// expired.
+func (rt *router) refreshCookie(session persistence.SessionResult, secure bool) *http.Cookie {
+	c := &http.Cookie{
+		Name:     refreshKey,
+		Value:    session.RefreshToken,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Secure:   secure,
+		Path:     "/api",
+		Expires:  time.Unix(0, 0),
+	}
+	if session.RefreshToken != "" && session.RefreshExpires != nil {
+		c.Expires = *session.RefreshExpires
+	}
+	return c
+}
+
+// authCookie returns the cookie carrying a token for the given session. In
+// case the session id is empty, the cookie is expired.
+func (rt *router) authCookie(sessionID string, secure bool) (*http.Cookie, error) {
 	c := http.Cookie{
 		Name:     authKey,
 		HttpOnly: true,
@@ -92,10 +198,10 @@ func (rt *router) authCookie(userID string, secure bool) (*http.Cookie, error) {
 		Secure:   secure,
 		Path:     "/api",
 	}
-	if userID == "" {
+	if sessionID == "" {
 		c.Expires = time.Unix(0, 0)
 	} else {
-		value, err := rt.cookieSigner.MaxAge(24*60*60).Encode(authKey, userID)
+		value, err := rt.signingKeys.SignToken(sessionID, rt.sessionTTL())
 		if err != nil {
 			return nil, err
 		}
@@ -159,6 +265,54 @@ func WithMailer(m mailer.Mailer) Config {
 	}
 }
 
+// WithSigningKeyring sets the keyring used for signing auth tokens. In case
+// it is not given, keys are derived from the configured secret.
+func WithSigningKeyring(k *keys.SigningKeyring) Config {
+	return func(r *router) {
+		r.signingKeys = k
+	}
+}
+
+// WithOIDCProvider enables single sign-on using the given OpenID Connect
+// provider.
+func WithOIDCProvider(p *oidc.Provider) Config {
+	return func(r *router) {
+		r.oidc = p
+	}
+}
+
+// WithSAMLServiceProvider enables single sign-on using the given SAML
+// service provider.
+func WithSAMLServiceProvider(s *saml.ServiceProvider) Config {
+	return func(r *router) {
+		r.saml = s
+	}
+}
+
+// WithShutdown sets a channel that is closed when the server is shutting
+// down, which ends all long-lived responses like streams of live events.
+func WithShutdown(shutdown <-chan struct{}) Config {
+	return func(r *router) {
+		r.shutdown = shutdown
+	}
+}
+
+// WithWebhooks sets the dispatcher used for notifying the webhooks of
+// accounts.
+func WithWebhooks(d *webhooks.Dispatcher) Config {
+	return func(r *router) {
+		r.webhooks = d
+	}
+}
+
+// WithGeoIP sets the reader used for resolving the country of clients. In
+// case it is nil, no country hints are given.
+func WithGeoIP(g *geoip.Reader) Config {
+	return func(r *router) {
+		r.geoIP = g
+	}
+}
+
 // New creates a new application router that reads and writes data
 // to the given database implementation. In the context of the application
 // this expects to be the only top level router in charge of handling all
@@ -169,23 +323,61 @@ func New(opts ...Config) http.Handler {
 		opt(&rt)
 	}
 
+	rt.live = newLiveBroker()
+	rt.active = newActiveUsers(activeUsersWindow)
+	if rt.config.Dedupe.Window > 0 {
+		rt.dedupe = newDedupe(rt.config.Dedupe.Window)
+	}
+	if rt.config.Quota.Events > 0 || rt.config.Quota.PayloadBytes > 0 {
+		rt.quotas = newQuotas(rt.config.Quota.Events, rt.config.Quota.PayloadBytes)
+	}
+	if rt.config.BotFilter.Enabled {
+		rt.bots = newBotFilter(rt.config.BotFilter.UserAgents)
+	}
 	rt.sanitizer = bluemonday.StrictPolicy()
 	rt.cookieSigner = securecookie.New(rt.config.Secret.Bytes(), nil)
+	for _, secret := range rt.config.PreviousSecretBytes() {
+		// invitations are the longest lived values signed by the router
+		rt.cookieVerifiers = append(rt.cookieVerifiers, securecookie.New(secret, nil).MaxAge(7*24*60*60))
+	}
+	if rt.signingKeys == nil {
+		signingKeys, err := rt.config.NewSigningKeyring()
+		if err != nil && rt.logger != nil {
+			rt.logger.WithError(err).Error("Unable to create signing keyring, tokens cannot be issued")
+		}
+		rt.signingKeys = signingKeys
+	}
 
 	optin := optinMiddleware(optinKey, optinValue)
 	userCookie := userCookieMiddleware(cookieKey, contextKeyCookie)
+	// routes using accountAuth or tokenAuth check the allowed networks
+	// themselves, all other routes accessed by operators use allowlist
+	allowlist := rt.allowlistMiddleware()
 	accountAuth := rt.accountUserMiddleware(authKey, contextKeyAuth)
+	// routes using tokenAuth can also be used by scripts using an access
+	// token or a service account, all other routes require a login
+	tokenAuth := rt.accessTokenMiddleware(contextKeyAuth, accountAuth)
+	// buckets are created once so that limits are shared between versioned
+	// and legacy routes
+	rateLimitPeriod := rt.config.RateLimit.Period
+	eventsLimit := rt.rateLimitMiddleware(ratelimiter.NewBucket(
+		rt.config.RateLimit.Events, rateLimitPeriod, cache.New(rateLimitPeriod, time.Minute*2),
+	), "accountId")
+	loginLimit := rt.rateLimitMiddleware(ratelimiter.NewBucket(
+		rt.config.RateLimit.Login, rateLimitPeriod, cache.New(rateLimitPeriod, time.Minute*2),
+	), "username")
 	noStore := headerMiddleware(map[string]func() string{
 		"Cache-Control": func() string {
 			return "no-store"
 		},
 	})
-	csp := headerMiddleware(map[string]func() string{
-		"Content-Security-Policy": func() string {
-			return defaultCSP
-		},
-	})
+	headers := newSecurityHeaders(rt.config)
+	apiHeaders := securityHeadersMiddleware(headers.api)
 	etag := etagMiddleware()
+	origins := rt.originMiddleware()
+	// payloads are validated before any other middleware reads the body
+	eventPayload := validationMiddleware(maxEventBodySize, eventShape)
+	anonymousEventPayload := validationMiddleware(maxEventBodySize, anonymousEventShape)
 
 	if !rt.config.App.Development {
 		gin.SetMode(gin.ReleaseMode)
@@ -196,71 +388,175 @@ func New(opts ...Config) http.Handler {
 		gin.Recovery(),
 		location.Default(),
 		secureContextMiddleware(contextKeySecureContext, rt.config.App.Development),
+		rt.requestIDMiddleware(contextKeyRequestID),
 	)
+	if rt.config.Metrics.Enabled {
+		app.Use(metricsMiddleware())
+	}
+	if rt.config.Tracing.Endpoint != "" {
+		app.Use(tracingMiddleware())
+	}
+	// HTTP logging is only added when the reverse proxy setting is not
+	// enabled
+	if !rt.config.Server.ReverseProxy {
+		app.Use(rt.accessLogMiddleware())
+	}
 
 	root := gin.New()
 	root.SetHTMLTemplate(rt.template)
-	root.GET("/*any", etag, csp, rt.getIndex)
+	root.Use(
+		location.Default(),
+		secureContextMiddleware(contextKeySecureContext, rt.config.App.Development),
+	)
+	root.GET("/*any", etag, securityHeadersMiddleware(headers.ui), rt.getIndex)
 	app.GET("/", gin.WrapH(root))
 
 	app.Any("/healthz", noStore, rt.getHealth)
+	app.GET("/readyz", noStore, rt.getReadiness)
 	app.GET("/versionz", noStore, rt.getVersion)
-	{
-		api := app.Group("/api")
-		api.Use(noStore)
+	app.GET("/.well-known/jwks.json", rt.getJWKS)
+	if rt.config.Metrics.Enabled {
+		app.GET("/metrics", noStore, rt.getMetrics)
+	}
+	// routes registered by registerAPI are served below /api/v1 and, for
+	// compatibility with existing clients, below /api where responses are
+	// marked as deprecated
+	registerAPI := func(api *gin.RouterGroup) {
+		api.GET("/spec", rt.getSpec)
 		api.GET("/exchange", rt.getPublicKey)
+		api.GET("/referrer-rules", etag, rt.getReferrerRules)
+		api.GET("/hints", noStore, rt.getHints)
 		api.POST("/exchange", rt.postUserSecret)
 
-		api.GET("/accounts/:accountID", accountAuth, rt.getAccount)
-		api.DELETE("/accounts/:accountID", accountAuth, rt.deleteAccount)
-		api.POST("/accounts", accountAuth, rt.postAccount)
+		api.GET("/accounts", tokenAuth, rt.getAccounts)
+		api.GET("/accounts/:accountID", tokenAuth, rt.getAccount)
+		api.PATCH("/accounts/:accountID", tokenAuth, rt.patchAccount)
+		api.DELETE("/accounts/:accountID", tokenAuth, rt.deleteAccount)
+		api.POST("/accounts", tokenAuth, rt.postAccount)
+		api.POST("/accounts/:accountID/rotate-keys", accountAuth, rt.postRotateAccountKeys)
+		api.GET("/accounts/:accountID/live", tokenAuth, rt.getLiveEvents)
+		api.GET("/accounts/:accountID/dropped-events", tokenAuth, rt.getDroppedEvents)
+		api.GET("/accounts/:accountID/active-users", noStore, tokenAuth, rt.getActiveUsers)
+		api.GET("/accounts/:accountID/quota", noStore, tokenAuth, rt.getQuota)
+		api.POST("/accounts/:accountID/allowed-origins", accountAuth, rt.postAllowedOrigins)
+		api.POST("/accounts/:accountID/domains", accountAuth, rt.postDomains)
+		api.POST("/accounts/:accountID/privacy-signals", accountAuth, rt.postPrivacySignals)
+		api.POST("/accounts/:accountID/timezone", accountAuth, rt.postTimezone)
+		api.POST("/accounts/:accountID/anomaly-detection", accountAuth, rt.postAnomalyDetection)
+		api.GET("/accounts/:accountID/public-stats", rt.getPublicStats)
+		api.GET("/accounts/:accountID/webhooks", accountAuth, rt.getWebhooks)
+		api.POST("/accounts/:accountID/webhooks", accountAuth, rt.postWebhook)
+		api.DELETE("/accounts/:accountID/webhooks/:webhookID", accountAuth, rt.deleteWebhook)
+		api.GET("/accounts/:accountID/webhooks/:webhookID/deliveries", accountAuth, rt.getWebhookDeliveries)
+		api.GET("/accounts/:accountID/rollups", tokenAuth, rt.getRollups)
+		api.PUT("/accounts/:accountID/rollups/:period/:start", tokenAuth, rt.putRollup)
+		api.GET("/accounts/:accountID/export", tokenAuth, rt.getExport)
+		api.GET("/accounts/:accountID/funnels", tokenAuth, rt.getFunnels)
+		api.POST("/accounts/:accountID/funnels", accountAuth, rt.postFunnel)
+		api.DELETE("/accounts/:accountID/funnels/:funnelID", accountAuth, rt.deleteFunnel)
+		api.GET("/accounts/:accountID/event-types", tokenAuth, rt.getEventTypes)
+		api.POST("/accounts/:accountID/event-types", accountAuth, rt.postEventType)
+		api.DELETE("/accounts/:accountID/event-types/:eventTypeID", accountAuth, rt.deleteEventType)
+		api.GET("/accounts/:accountID/goals", tokenAuth, rt.getGoals)
+		api.POST("/accounts/:accountID/goals", accountAuth, rt.postGoal)
+		api.DELETE("/accounts/:accountID/goals/:goalID", accountAuth, rt.deleteGoal)
 
 		api.POST("/purge", userCookie, rt.purgeEvents)
+		api.POST("/erasures", accountAuth, rt.postErasure)
 
-		api.GET("/login", accountAuth, rt.getLogin)
-		api.POST("/login", rt.postLogin)
+		api.GET("/login", tokenAuth, rt.getLogin)
+		api.POST("/login", allowlist, validationMiddleware(maxAuthBodySize, loginShape), loginLimit, rt.postLogin)
+		api.POST("/login/refresh", allowlist, rt.postRefreshLogin)
+		api.POST("/login/service-account", allowlist, validationMiddleware(maxAuthBodySize, serviceAccountLoginShape), loginLimit, rt.postLoginServiceAccount)
 		api.POST("/logout", rt.postLogout)
-
-		api.POST("/change-password", accountAuth, rt.postChangePassword)
+		api.POST("/magic-link", allowlist, validationMiddleware(maxAuthBodySize, magicLinkShape), rt.postMagicLink)
+		api.POST("/login/magic-link", allowlist, validationMiddleware(maxAuthBodySize, loginMagicLinkShape), loginLimit, rt.postLoginMagicLink)
+		api.GET("/login/oidc", allowlist, rt.getLoginOIDC)
+		api.GET("/login/saml", allowlist, rt.getLoginSAML)
+		api.POST("/device-key", accountAuth, rt.postDeviceKey)
+		api.POST("/device-key/unlock", accountAuth, rt.postUnlockDeviceKey)
+
+		api.GET("/webauthn/register", accountAuth, rt.getWebAuthnRegister)
+		api.POST("/webauthn/register", accountAuth, rt.postWebAuthnRegister)
+		api.GET("/webauthn/login", allowlist, rt.getWebAuthnLogin)
+		api.POST("/webauthn/login", allowlist, validationMiddleware(maxAuthBodySize, nil), loginLimit, rt.postWebAuthnLogin)
+		api.DELETE("/webauthn/credentials/:credentialID", accountAuth, rt.deleteWebAuthnCredential)
+
+		api.GET("/auth-events", accountAuth, rt.getAuthEvents)
+
+		api.GET("/sessions", accountAuth, rt.getSessions)
+		api.DELETE("/sessions", accountAuth, rt.deleteSessions)
+		api.DELETE("/sessions/:sessionID", accountAuth, rt.deleteSession)
+
+		api.GET("/access-tokens", accountAuth, rt.getAccessTokens)
+		api.POST("/access-tokens", accountAuth, rt.postAccessToken)
+		api.DELETE("/access-tokens/:tokenID", accountAuth, rt.deleteAccessToken)
+
+		api.GET("/service-accounts", accountAuth, rt.getServiceAccounts)
+		api.POST("/service-accounts", accountAuth, rt.postServiceAccount)
+		api.DELETE("/service-accounts/:serviceAccountID", accountAuth, rt.deleteServiceAccount)
+
+		api.POST("/change-password", accountAuth, validationMiddleware(maxAuthBodySize, changePasswordShape), rt.postChangePassword)
+		api.POST("/change-expired-password", allowlist, validationMiddleware(maxAuthBodySize, changeExpiredPasswordShape), rt.postChangeExpiredPassword)
 		api.POST("/change-email", accountAuth, rt.postChangeEmail)
-		api.POST("/forgot-password", rt.postForgotPassword)
-		api.POST("/reset-password", rt.postResetPassword)
+		api.POST("/change-email/confirm", allowlist, rt.postConfirmEmailChange)
+		api.GET("/secondary-emails", accountAuth, rt.getSecondaryEmails)
+		api.POST("/secondary-emails", accountAuth, rt.postSecondaryEmail)
+		api.POST("/secondary-emails/confirm", allowlist, rt.postConfirmSecondaryEmail)
+		api.DELETE("/secondary-emails/:secondaryEmailID", accountAuth, rt.deleteSecondaryEmail)
+		api.GET("/report-subscriptions", accountAuth, rt.getReportSubscriptions)
+		api.PUT("/report-subscriptions/:accountID", accountAuth, rt.putReportSubscription)
+		api.DELETE("/report-subscriptions/:accountID", accountAuth, rt.deleteReportSubscription)
+		api.POST("/forgot-password", allowlist, validationMiddleware(maxAuthBodySize, forgotPasswordShape), rt.postForgotPassword)
+		api.POST("/reset-password", allowlist, validationMiddleware(maxAuthBodySize, resetPasswordShape), rt.postResetPassword)
 		api.POST("/share-account/:accountID", accountAuth, rt.postShareAccount)
 		api.POST("/share-account", accountAuth, rt.postShareAccount)
-		api.POST("/join", rt.postJoin)
-		api.GET("/setup", rt.getSetup)
-		api.POST("/setup", rt.postSetup)
+		api.POST("/join", allowlist, rt.postJoin)
+		api.POST("/invite", accountAuth, rt.postInvite)
+		api.POST("/account-users/:accountUserID/suspension", accountAuth, rt.postSuspension)
+		api.POST("/account-users/:accountUserID/allowed-networks", accountAuth, rt.postAllowedNetworks)
+		api.POST("/account-users/:accountUserID/impersonation", accountAuth, rt.postImpersonation)
+		api.POST("/invite/accept", allowlist, rt.postAcceptInvitation)
+		api.GET("/setup", allowlist, rt.getSetup)
+		api.POST("/setup", allowlist, rt.postSetup)
+
+		if rt.config.GraphQL.Enabled {
+			readOnly := readOnlyMiddleware(contextKeyReadOnly)
+			api.GET("/graphql", tokenAuth, rt.getGraphQL)
+			api.POST("/graphql", readOnly, tokenAuth, rt.postGraphQL)
+		}
 
 		api.GET("/events", userCookie, rt.getEvents)
-		api.POST("/events/anonymous", rt.postEvents)
-		api.POST("/events", optin, userCookie, rt.postEvents)
+		api.OPTIONS("/events/anonymous", origins)
+		api.POST("/events/anonymous", anonymousEventPayload, origins, eventsLimit, rt.postEvents)
+		api.OPTIONS("/events", origins)
+		api.POST("/events", eventPayload, origins, optin, userCookie, eventsLimit, rt.postEvents)
+		api.OPTIONS("/events/batch", origins)
+		api.POST("/events/batch", validationMiddleware(maxEventBatchBodySize, eventBatchShape), origins, optin, userCookie, eventsLimit, rt.postEventsBatch)
+	}
+	registerAPI(app.Group("/api/v1", noStore, apiHeaders))
+	registerAPI(app.Group(
+		"/api", noStore, apiHeaders,
+		deprecationMiddleware("/api", "/api/v1", legacyAPIDeprecation, legacyAPISunset),
+	))
+	{
+		// these routes are registered with identity providers, so they are
+		// not versioned
+		api := app.Group("/api", noStore, apiHeaders)
+		api.GET("/login/oidc/callback", allowlist, rt.getLoginOIDCCallback)
+		api.POST("/login/saml/acs", allowlist, rt.postLoginSAMLACS)
+		api.GET("/saml/metadata", rt.getSAMLMetadata)
 	}
 
-	fileServer := http.FileServer(rt.fs)
-	app.Use(staticMiddleware(fileServer, root))
+	app.Use(staticMiddleware(rt.fs, root, headers))
 
+	// compression is expected to be handled by the reverse proxy if one is
+	// used
 	if rt.config.Server.ReverseProxy {
 		return app
 	}
 
-	withGzip := gziphandler.GzipHandler(app)
-	// HTTP logging is only added when the reverse proxy setting is not
-	// enabled
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		metrics := httpsnoop.CaptureMetrics(withGzip, w, r)
-		fmt.Printf(
-			"%s %s %s [%s] \"%s %s %s\" %d %s\n",
-			"-",
-			"-",
-			"-",
-			time.Now().Format("02/Jan/2006:15:04:05 -0700"),
-			r.Method,
-			r.RequestURI,
-			r.Proto,
-			anonymizeStatusCode(metrics.Code),
-			"-",
-		)
-	})
+	return compressHandler(app)
 }
 
 // anonymizeStatusCode turns all non-error status codes into http.StatusOK
@@ -5,11 +5,16 @@ package router
 
 import (
 	"html/template"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
 	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/keys"
 	"github.com/offen/offen/server/persistence"
 )
 
@@ -17,6 +22,14 @@ type mockDatabase struct {
 	persistence.Service
 }
 
+func mustSigningKeyring(secret string) *keys.SigningKeyring {
+	k, err := keys.NewSigningKeyring([]byte(secret), time.Hour, time.Minute)
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
+
 func TestMain(m *testing.M) {
 	gin.SetMode(gin.ReleaseMode)
 	os.Exit(m.Run())
@@ -29,3 +42,45 @@ func TestNew(t *testing.T) {
 		WithTemplate(template.New("a test")),
 	)
 }
+
+func TestRouter_decodeSigned(t *testing.T) {
+	rt := router{
+		cookieSigner:    securecookie.New([]byte("current"), nil),
+		cookieVerifiers: []securecookie.Codec{securecookie.New([]byte("previous"), nil)},
+	}
+	for _, secret := range []string{"current", "previous"} {
+		signed, _ := securecookie.New([]byte(secret), nil).Encode("credentials", "value")
+		var result string
+		if err := rt.decodeSigned("credentials", signed, &result); err != nil {
+			t.Errorf("Unexpected error decoding value signed using %s secret: %v", secret, err)
+		}
+		if result != "value" {
+			t.Errorf("Unexpected result %v", result)
+		}
+	}
+	signed, _ := securecookie.New([]byte("unknown"), nil).Encode("credentials", "value")
+	var result string
+	if err := rt.decodeSigned("credentials", signed, &result); err == nil {
+		t.Error("Expected error decoding value signed using unknown secret")
+	}
+}
+
+func TestNew_versionedRoutes(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.ReverseProxy = true
+	handler := New(
+		WithDatabase(&mockDatabase{}),
+		WithConfig(cfg),
+		WithTemplate(template.New("a test")),
+	)
+	for _, path := range []string{"/api/v1/spec", "/api/spec"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("Unexpected status code %v for %s", w.Code, path)
+		}
+		if deprecated := w.Header().Get("Deprecation") != ""; deprecated != (path == "/api/spec") {
+			t.Errorf("Unexpected deprecation header for %s: %v", path, w.Header())
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/saml"
+)
+
+const (
+	samlRequestKey = "saml"
+	samlRequestTTL = 10 * time.Minute
+)
+
+// samlRequest is passed to the identity provider as relay state. The
+// response is posted by the identity provider across sites, so a cookie
+// cannot be used for storing the id of the request.
+type samlRequest struct {
+	ID      string
+	Expires time.Time
+}
+
+func (rt *router) getSAMLMetadata(c *gin.Context) {
+	if rt.saml == nil {
+		newJSONError(
+			errors.New("router: single sign-on is not configured"),
+			http.StatusNotFound,
+		).Pipe(c)
+		return
+	}
+	b, err := rt.saml.Metadata()
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating metadata: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.Data(http.StatusOK, "application/samlmetadata+xml", b)
+}
+
+func (rt *router) getLoginSAML(c *gin.Context) {
+	if rt.saml == nil {
+		newJSONError(
+			errors.New("router: single sign-on is not configured"),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	requestID, err := saml.NewRequestID()
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating request id: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	relayState, err := rt.cookieSigner.Encode(samlRequestKey, samlRequest{
+		ID:      requestID,
+		Expires: time.Now().Add(samlRequestTTL),
+	})
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error signing relay state: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	redirect, err := rt.saml.AuthnRequestURL(requestID, relayState)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating authentication request: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.Redirect(http.StatusFound, redirect)
+}
+
+func (rt *router) postLoginSAMLACS(c *gin.Context) {
+	if rt.saml == nil {
+		newJSONError(
+			errors.New("router: single sign-on is not configured"),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	var req samlRequest
+	if err := rt.decodeSigned(samlRequestKey, c.PostForm("RelayState"), &req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding relay state: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	if time.Now().After(req.Expires) {
+		newJSONError(
+			errors.New("router: authentication request has expired"),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	if err := rt.getConsumedTokens().Add(req.ID, true, samlRequestTTL); err != nil {
+		newJSONError(
+			errors.New("router: response has already been used"),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	assertion, err := rt.saml.ParseResponse(c.PostForm("SAMLResponse"), req.ID)
+	if err != nil {
+		rt.logError(c, err, "error parsing saml response")
+		newJSONError(
+			errors.New("router: could not authenticate with identity provider"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+
+	email := assertion.NameID
+	if attr := rt.config.SAML.EmailAttribute; attr != "" {
+		email = assertion.Attribute(attr)
+	}
+	if email == "" {
+		newJSONError(
+			errors.New("router: identity provider did not assert an email address"),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	var login persistence.LoginResult
+	if rt.config.SAML.Provision {
+		login, err = rt.db.ProvisionIdentity(email, rt.samlAdminLevel(assertion))
+	} else {
+		login, err = rt.db.LookupIdentity(email)
+	}
+	if err != nil {
+		if !errors.Is(err, persistence.ErrInvalidCredentials) {
+			rt.logError(c, err, "error looking up saml identity")
+		}
+		newJSONError(
+			errors.New("router: no account user found for identity"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+	rt.startSSOSession(c, login.AccountUserID, nil)
+}
+
+// samlAdminLevel returns the admin level that is granted by the attributes
+// of the given assertion. In case no admin attribute is configured, nil is
+// returned.
+func (rt *router) samlAdminLevel(assertion *saml.Assertion) *persistence.AccountUserAdminLevel {
+	if rt.config.SAML.AdminAttribute == "" {
+		return nil
+	}
+	var level persistence.AccountUserAdminLevel
+	if assertion.HasAttributeValue(rt.config.SAML.AdminAttribute, rt.config.SAML.AdminValue) {
+		level = persistence.AccountUserAdminLevelSuperAdmin
+	}
+	return &level
+}
@@ -0,0 +1,42 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/saml"
+)
+
+func TestRouter_samlAdminLevel(t *testing.T) {
+	superAdmin := persistence.AccountUserAdminLevelSuperAdmin
+	var none persistence.AccountUserAdminLevel
+	tests := []struct {
+		name           string
+		attribute      string
+		value          string
+		attributes     map[string][]string
+		expectedResult *persistence.AccountUserAdminLevel
+	}{
+		{"not configured", "", "", map[string][]string{"role": {"admin"}}, nil},
+		{"match", "role", "admin", map[string][]string{"role": {"viewer", "admin"}}, &superAdmin},
+		{"no match", "role", "admin", map[string][]string{"role": {"viewer"}}, &none},
+		{"missing attribute", "role", "admin", nil, &none},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.SAML.AdminAttribute = test.attribute
+			cfg.SAML.AdminValue = test.value
+			rt := router{config: cfg}
+			result := rt.samlAdminLevel(&saml.Assertion{Attributes: test.attributes})
+			if !reflect.DeepEqual(test.expectedResult, result) {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+func (rt *router) getSecondaryEmails(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	emails, err := rt.db.ListSecondaryEmails(accountUser.AccountUserID)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error looking up secondary emails: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{"secondaryEmails": emails})
+}
+
+type secondaryEmailRequest struct {
+	EmailAddress string `json:"emailAddress"`
+	EmailCurrent string `json:"emailCurrent"`
+	Password     string `json:"password"`
+	URLTemplate  string `json:"urlTemplate"`
+}
+
+type secondaryEmailCredentials struct {
+	Token         []byte
+	AccountUserID string
+	EmailAddress  string
+}
+
+func (rt *router) postSecondaryEmail(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	if l := <-rt.getLimiter().LinearThrottle(time.Second*5, fmt.Sprintf("postSecondaryEmail-%s", accountUser.AccountUserID)); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error applying rate limit: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
+	var req secondaryEmailRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	token, err := rt.db.AddSecondaryEmail(accountUser.AccountUserID, req.EmailAddress, req.EmailCurrent, req.Password)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error adding secondary email: %v", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	signedCredentials, err := rt.cookieSigner.MaxAge(int(persistence.EmailChangeTTL/time.Second)).Encode("credentials", secondaryEmailCredentials{
+		Token:         token,
+		AccountUserID: accountUser.AccountUserID,
+		EmailAddress:  req.EmailAddress,
+	})
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error signing token: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	confirmURL := strings.Replace(req.URLTemplate, "{token}", signedCredentials, -1)
+
+	subject, body := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	subjectErr := rt.emails.ExecuteTemplate(subject, "subject_confirm_secondary_email", nil)
+	bodyErr := rt.emails.ExecuteTemplate(body, "body_confirm_secondary_email", map[string]string{"url": confirmURL})
+	for _, err := range []error{subjectErr, bodyErr} {
+		if err != nil {
+			newJSONError(
+				fmt.Errorf("router: error rendering email message: %v", err),
+				http.StatusInternalServerError,
+			).Pipe(c)
+			return
+		}
+	}
+	if err := rt.mailer.Send(rt.config.SMTP.Sender, req.EmailAddress, subject.String(), body.String()); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error sending email message: %v", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type confirmSecondaryEmailRequest struct {
+	Token string `json:"token"`
+}
+
+func (rt *router) postConfirmSecondaryEmail(c *gin.Context) {
+	var req confirmSecondaryEmailRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	var credentials secondaryEmailCredentials
+	if err := rt.decodeSigned("credentials", req.Token, &credentials); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding signed token: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if l := <-rt.getLimiter().ExponentialThrottle(time.Second*5, fmt.Sprintf("postConfirmSecondaryEmail-%s", credentials.AccountUserID)); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error applying rate limit: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
+	if err := rt.db.ConfirmSecondaryEmail(credentials.AccountUserID, credentials.EmailAddress, credentials.Token); err != nil {
+		var oneTimeKeyErr persistence.ErrInvalidOneTimeKey
+		if errors.As(err, &oneTimeKeyErr) {
+			newJSONError(
+				errors.New("router: confirmation has expired or has already been used"),
+				http.StatusBadRequest,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error confirming secondary email: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (rt *router) deleteSecondaryEmail(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	if err := rt.db.RemoveSecondaryEmail(accountUser.AccountUserID, c.Param("secondaryEmailID")); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error removing secondary email: %w", err),
+			http.StatusNotFound,
+		).Pipe(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockSecondaryEmailsDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockSecondaryEmailsDatabase) AddSecondaryEmail(string, string, string, string) ([]byte, error) {
+	return []byte("token"), m.err
+}
+
+func (m *mockSecondaryEmailsDatabase) ConfirmSecondaryEmail(userID, emailAddress string, token []byte) error {
+	if userID != "account-user" || emailAddress != "backup@offen.dev" || string(token) != "token" {
+		return errors.New("unexpected arguments")
+	}
+	return m.err
+}
+
+func TestRouter_postSecondaryEmail(t *testing.T) {
+	tests := []struct {
+		name           string
+		db             mockSecondaryEmailsDatabase
+		mailer         mockMailer
+		body           io.Reader
+		expectedStatus int
+	}{
+		{
+			"bad payload",
+			mockSecondaryEmailsDatabase{},
+			mockMailer{},
+			strings.NewReader("xxx"),
+			http.StatusBadRequest,
+		},
+		{
+			"database error",
+			mockSecondaryEmailsDatabase{err: errors.New("did not work")},
+			mockMailer{},
+			strings.NewReader(`{"emailAddress":"backup@offen.dev","emailCurrent":"develop@offen.dev","password":"develop"}`),
+			http.StatusBadRequest,
+		},
+		{
+			"mailer error",
+			mockSecondaryEmailsDatabase{},
+			mockMailer{err: errors.New("did not work")},
+			strings.NewReader(`{"emailAddress":"backup@offen.dev","emailCurrent":"develop@offen.dev","password":"develop"}`),
+			http.StatusInternalServerError,
+		},
+		{
+			"ok",
+			mockSecondaryEmailsDatabase{},
+			mockMailer{},
+			strings.NewReader(`{"emailAddress":"backup@offen.dev","emailCurrent":"develop@offen.dev","password":"develop"}`),
+			http.StatusNoContent,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{
+				config:       &config.Config{},
+				db:           &test.db,
+				cookieSigner: securecookie.New([]byte("abc"), nil),
+				mailer:       &test.mailer,
+				emails: template.Must(template.New("emails").Parse(`
+{{ define "subject_confirm_secondary_email" }}subject{{ end }}
+{{ define "body_confirm_secondary_email" }}{{ .url }}{{ end }}
+				`)),
+			}
+			m := gin.New()
+			m.POST("/", func(c *gin.Context) {
+				c.Set(contextKeyAuth, persistence.LoginResult{AccountUserID: "account-user"})
+			}, rt.postSecondaryEmail)
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", test.body))
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+		})
+	}
+}
+
+func TestRouter_postConfirmSecondaryEmail(t *testing.T) {
+	signer := securecookie.New([]byte("abc"), nil)
+	token, _ := signer.Encode("credentials", secondaryEmailCredentials{
+		Token:         []byte("token"),
+		AccountUserID: "account-user",
+		EmailAddress:  "backup@offen.dev",
+	})
+	tests := []struct {
+		name           string
+		db             mockSecondaryEmailsDatabase
+		body           io.Reader
+		expectedStatus int
+	}{
+		{
+			"bad payload",
+			mockSecondaryEmailsDatabase{},
+			strings.NewReader("xxx"),
+			http.StatusBadRequest,
+		},
+		{
+			"bad token",
+			mockSecondaryEmailsDatabase{},
+			strings.NewReader(`{"token":"abc"}`),
+			http.StatusBadRequest,
+		},
+		{
+			"expired",
+			mockSecondaryEmailsDatabase{err: persistence.ErrInvalidOneTimeKey("did not work")},
+			strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, token)),
+			http.StatusBadRequest,
+		},
+		{
+			"ok",
+			mockSecondaryEmailsDatabase{},
+			strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, token)),
+			http.StatusNoContent,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{
+				config:       &config.Config{},
+				db:           &test.db,
+				cookieSigner: signer,
+			}
+			m := gin.New()
+			m.POST("/", rt.postConfirmSecondaryEmail)
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", test.body))
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+func (rt *router) getServiceAccounts(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	serviceAccounts, err := rt.db.ListServiceAccounts(accountUser.AccountUserID)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error looking up service accounts: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{"serviceAccounts": serviceAccounts})
+}
+
+type createServiceAccountRequest struct {
+	Name         string   `json:"name"`
+	AccountIDs   []string `json:"accountIds"`
+	EmailAddress string   `json:"emailAddress"`
+	Password     string   `json:"password"`
+}
+
+func (rt *router) postServiceAccount(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	var req createServiceAccountRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	result, err := rt.db.CreateServiceAccount(accountUser.AccountUserID, req.Name, req.AccountIDs, req.EmailAddress, req.Password)
+	if err != nil {
+		if errors.Is(err, persistence.ErrPermissionDenied) {
+			newJSONError(
+				errors.New("router: service accounts can only be granted access to accounts you are an admin of"),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error creating service account: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusCreated, result)
+}
+
+func (rt *router) deleteServiceAccount(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	if err := rt.db.DeleteServiceAccount(accountUser.AccountUserID, c.Param("serviceAccountID")); err != nil {
+		if errors.Is(err, persistence.ErrPermissionDenied) {
+			newJSONError(
+				errors.New("router: not allowed to delete this service account"),
+				http.StatusForbidden,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error deleting service account: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type serviceAccountCredentials struct {
+	Credential string `json:"credential"`
+	// PublicKey is an optional Base64 encoded X25519 public key. In case it
+	// is given, key encryption keys are wrapped for its holder.
+	PublicKey string `json:"publicKey"`
+}
+
+// postLoginServiceAccount releases the key encryption keys of a service
+// account. In contrast to logging in account users, no cookie is set as
+// service accounts are expected to send their credential with each request.
+func (rt *router) postLoginServiceAccount(c *gin.Context) {
+	var credentials serviceAccountCredentials
+	if err := c.BindJSON(&credentials); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if l := <-rt.getLimiter().ExponentialThrottle(time.Second, fmt.Sprintf("postLoginServiceAccount-ip-%s", rt.clientIP(c))); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error applying rate limit: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
+	var peerPublicKey []byte
+	if credentials.PublicKey != "" {
+		b, err := base64.StdEncoding.DecodeString(credentials.PublicKey)
+		if err != nil {
+			newJSONError(
+				fmt.Errorf("router: error decoding public key: %w", err),
+				http.StatusBadRequest,
+			).Pipe(c)
+			return
+		}
+		peerPublicKey = b
+	}
+
+	result, err := rt.db.LoginServiceAccount(credentials.Credential)
+	observeLogin("service_account", err)
+	if err != nil {
+		if !errors.Is(err, persistence.ErrInvalidServiceAccountCredential) {
+			rt.logError(c, err, "error logging in service account")
+		}
+		newJSONError(
+			errors.New("router: invalid service account credential"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+
+	if peerPublicKey != nil {
+		if err := result.WrapKeys(peerPublicKey); err != nil {
+			newJSONError(
+				fmt.Errorf("router: error wrapping keys for client: %w", err),
+				http.StatusBadRequest,
+			).Pipe(c)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockServiceAccountsDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockServiceAccountsDatabase) CreateServiceAccount(userID, name string, accountIDs []string, emailAddress, password string) (persistence.ServiceAccountResult, error) {
+	return persistence.ServiceAccountResult{ServiceAccountID: "service-a", Credential: "sa.service-a.secret"}, m.err
+}
+
+func (m *mockServiceAccountsDatabase) DeleteServiceAccount(userID, serviceAccountID string) error {
+	return m.err
+}
+
+func (m *mockServiceAccountsDatabase) LoginServiceAccount(credential string) (persistence.LoginResult, error) {
+	if m.err != nil {
+		return persistence.LoginResult{}, m.err
+	}
+	return persistence.LoginResult{
+		AccountUserID: "service-a",
+		Accounts:      []persistence.LoginAccountResult{{AccountID: "account-a"}},
+	}, nil
+}
+
+func TestRouter_postServiceAccount(t *testing.T) {
+	tests := []struct {
+		name           string
+		db             mockServiceAccountsDatabase
+		body           io.Reader
+		expectedStatus int
+	}{
+		{
+			"bad payload",
+			mockServiceAccountsDatabase{},
+			strings.NewReader("891ä##"),
+			http.StatusBadRequest,
+		},
+		{
+			"not an admin",
+			mockServiceAccountsDatabase{err: persistence.ErrPermissionDenied},
+			strings.NewReader(`{"name":"ci","accountIds":["account-z"],"emailAddress":"develop@offen.dev","password":"develop"}`),
+			http.StatusForbidden,
+		},
+		{
+			"database error",
+			mockServiceAccountsDatabase{err: errors.New("did not work")},
+			strings.NewReader(`{"name":"ci","accountIds":["account-a"],"emailAddress":"develop@offen.dev","password":"develop"}`),
+			http.StatusBadRequest,
+		},
+		{
+			"ok",
+			mockServiceAccountsDatabase{},
+			strings.NewReader(`{"name":"ci","accountIds":["account-a"],"emailAddress":"develop@offen.dev","password":"develop"}`),
+			http.StatusCreated,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: &test.db}
+			m := gin.New()
+			m.POST("/", func(c *gin.Context) {
+				c.Set(contextKeyAuth, persistence.LoginResult{AccountUserID: "user-a"})
+			}, rt.postServiceAccount)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", test.body))
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if w.Code == http.StatusCreated && !strings.Contains(w.Body.String(), `"credential":"sa.service-a.secret"`) {
+				t.Errorf("Expected credential in response, got %v", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRouter_deleteServiceAccount(t *testing.T) {
+	tests := []struct {
+		name           string
+		db             mockServiceAccountsDatabase
+		expectedStatus int
+	}{
+		{"not allowed", mockServiceAccountsDatabase{err: persistence.ErrPermissionDenied}, http.StatusForbidden},
+		{"database error", mockServiceAccountsDatabase{err: errors.New("did not work")}, http.StatusInternalServerError},
+		{"ok", mockServiceAccountsDatabase{}, http.StatusNoContent},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: &test.db}
+			m := gin.New()
+			m.DELETE("/:serviceAccountID", func(c *gin.Context) {
+				c.Set(contextKeyAuth, persistence.LoginResult{AccountUserID: "user-a"})
+			}, rt.deleteServiceAccount)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/service-a", nil))
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+		})
+	}
+}
+
+func TestRouter_postLoginServiceAccount(t *testing.T) {
+	tests := []struct {
+		name           string
+		db             mockServiceAccountsDatabase
+		body           io.Reader
+		expectedStatus int
+	}{
+		{
+			"bad payload",
+			mockServiceAccountsDatabase{},
+			strings.NewReader("891ä##"),
+			http.StatusBadRequest,
+		},
+		{
+			"bad public key",
+			mockServiceAccountsDatabase{},
+			strings.NewReader(`{"credential":"sa.service-a.secret","publicKey":"###"}`),
+			http.StatusBadRequest,
+		},
+		{
+			"bad credential",
+			mockServiceAccountsDatabase{err: persistence.ErrInvalidServiceAccountCredential},
+			strings.NewReader(`{"credential":"sa.service-a.other"}`),
+			http.StatusUnauthorized,
+		},
+		{
+			"ok",
+			mockServiceAccountsDatabase{},
+			strings.NewReader(`{"credential":"sa.service-a.secret"}`),
+			http.StatusOK,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: &test.db}
+			m := gin.New()
+			m.POST("/", rt.postLoginServiceAccount)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", test.body))
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if len(w.Result().Cookies()) != 0 {
+				t.Errorf("Unexpected cookies %v", w.Result().Cookies())
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+func (rt *router) getSessions(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	sessions, err := rt.db.ListSessions(accountUser.AccountUserID)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error looking up sessions: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	current := c.GetString(contextKeySession)
+	for idx := range sessions {
+		sessions[idx].Current = sessions[idx].SessionID == current
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{"sessions": sessions})
+}
+
+func (rt *router) deleteSession(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	sessionID := c.Param("sessionID")
+	if err := rt.db.RevokeSession(accountUser.AccountUserID, sessionID); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error revoking session: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	if sessionID == c.GetString(contextKeySession) {
+		cookie, _ := rt.authCookie("", c.GetBool(contextKeySecureContext))
+		http.SetCookie(c.Writer, cookie)
+		http.SetCookie(c.Writer, rt.refreshCookie(persistence.SessionResult{}, c.GetBool(contextKeySecureContext)))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (rt *router) deleteSessions(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	if err := rt.db.RevokeSessions(accountUser.AccountUserID); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error revoking sessions: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	cookie, _ := rt.authCookie("", c.GetBool(contextKeySecureContext))
+	http.SetCookie(c.Writer, cookie)
+	http.SetCookie(c.Writer, rt.refreshCookie(persistence.SessionResult{}, c.GetBool(contextKeySecureContext)))
+	c.Status(http.StatusNoContent)
+}
+
+// postRefreshLogin extends the session referred to by the refresh cookie and
+// rotates its refresh token. Clients are expected to call this when the auth
+// cookie has expired instead of asking the account user to log in again.
+func (rt *router) postRefreshLogin(c *gin.Context) {
+	if rt.refreshTTL() == 0 {
+		newJSONError(
+			errors.New("router: refreshing sessions is not enabled"),
+			http.StatusNotFound,
+		).Pipe(c)
+		return
+	}
+	secure := c.GetBool(contextKeySecureContext)
+	refreshCookie, err := c.Request.Cookie(refreshKey)
+	if err != nil {
+		newJSONError(
+			errors.New("router: no refresh token given"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+
+	session, err := rt.db.RefreshSession(refreshCookie.Value, rt.sessionTTL(), rt.refreshTTL())
+	if err != nil {
+		http.SetCookie(c.Writer, rt.refreshCookie(persistence.SessionResult{}, secure))
+		if errors.Is(err, persistence.ErrInvalidRefreshToken) || errors.Is(err, persistence.ErrAccountUserSuspended) {
+			newJSONError(
+				fmt.Errorf("router: error refreshing session: %w", err),
+				http.StatusUnauthorized,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error refreshing session: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	authCookie, err := rt.authCookie(session.SessionID, secure)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating auth cookie: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	http.SetCookie(c.Writer, authCookie)
+	http.SetCookie(c.Writer, rt.refreshCookie(session, secure))
+	c.JSON(http.StatusOK, session)
+}
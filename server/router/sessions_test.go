@@ -0,0 +1,228 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockSessionsDatabase struct {
+	persistence.Service
+	err     error
+	revoked []string
+}
+
+func (m *mockSessionsDatabase) ListSessions(userID string) ([]persistence.SessionResult, error) {
+	return []persistence.SessionResult{
+		{SessionID: "session-a"},
+		{SessionID: "session-b"},
+	}, m.err
+}
+
+func (m *mockSessionsDatabase) RevokeSession(userID, sessionID string) error {
+	m.revoked = append(m.revoked, sessionID)
+	return m.err
+}
+
+func (m *mockSessionsDatabase) RefreshSession(token string, ttl, refreshTTL time.Duration) (persistence.SessionResult, error) {
+	if m.err != nil {
+		return persistence.SessionResult{}, m.err
+	}
+	if token != "session-a.token" {
+		return persistence.SessionResult{}, persistence.ErrInvalidRefreshToken
+	}
+	refreshExpires := time.Now().Add(refreshTTL)
+	return persistence.SessionResult{
+		SessionID:      "session-a",
+		Expires:        time.Now().Add(ttl),
+		RefreshExpires: &refreshExpires,
+		RefreshToken:   "session-a.rotated",
+	}, nil
+}
+
+func TestRouter_getSessions(t *testing.T) {
+	tests := []struct {
+		name               string
+		db                 mockSessionsDatabase
+		expectedStatusCode int
+		expectedCurrent    []bool
+	}{
+		{
+			"database error",
+			mockSessionsDatabase{err: errors.New("did not work")},
+			http.StatusInternalServerError,
+			nil,
+		},
+		{
+			"ok",
+			mockSessionsDatabase{},
+			http.StatusOK,
+			[]bool{false, true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: &test.db}
+			m := gin.New()
+			m.GET("/", func(c *gin.Context) {
+				c.Set(contextKeyAuth, persistence.LoginResult{AccountUserID: "user-a"})
+				c.Set(contextKeySession, "session-b")
+			}, rt.getSessions)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if test.expectedCurrent == nil {
+				return
+			}
+			var result struct {
+				Sessions []persistence.SessionResult `json:"sessions"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			for idx, session := range result.Sessions {
+				if session.Current != test.expectedCurrent[idx] {
+					t.Errorf("Unexpected value for current in %v", session)
+				}
+			}
+		})
+	}
+}
+
+func TestRouter_deleteSession(t *testing.T) {
+	tests := []struct {
+		name               string
+		sessionID          string
+		expectedStatusCode int
+		expectCookie       bool
+	}{
+		{"other session", "session-a", http.StatusNoContent, false},
+		{"current session", "session-b", http.StatusNoContent, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &mockSessionsDatabase{}
+			rt := router{db: db}
+			m := gin.New()
+			m.DELETE("/:sessionID", func(c *gin.Context) {
+				c.Set(contextKeyAuth, persistence.LoginResult{AccountUserID: "user-a"})
+				c.Set(contextKeySession, "session-b")
+			}, rt.deleteSession)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/"+test.sessionID, nil))
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if len(db.revoked) != 1 || db.revoked[0] != test.sessionID {
+				t.Errorf("Unexpected revocations %v", db.revoked)
+			}
+			if hasCookie := w.Header().Get("Set-Cookie") != ""; hasCookie != test.expectCookie {
+				t.Errorf("Expected cookie to be %v, got %v", test.expectCookie, hasCookie)
+			}
+		})
+	}
+}
+
+func TestRouter_postRefreshLogin(t *testing.T) {
+	tests := []struct {
+		name               string
+		db                 mockSessionsDatabase
+		refreshTTL         time.Duration
+		cookie             *http.Cookie
+		expectedStatusCode int
+		expectedRefresh    string
+	}{
+		{
+			"not enabled",
+			mockSessionsDatabase{},
+			0,
+			&http.Cookie{Name: refreshKey, Value: "session-a.token"},
+			http.StatusNotFound,
+			"",
+		},
+		{
+			"no cookie",
+			mockSessionsDatabase{},
+			time.Hour,
+			nil,
+			http.StatusUnauthorized,
+			"",
+		},
+		{
+			"invalid token",
+			mockSessionsDatabase{},
+			time.Hour,
+			&http.Cookie{Name: refreshKey, Value: "session-a.other"},
+			http.StatusUnauthorized,
+			"",
+		},
+		{
+			"database error",
+			mockSessionsDatabase{err: errors.New("did not work")},
+			time.Hour,
+			&http.Cookie{Name: refreshKey, Value: "session-a.token"},
+			http.StatusInternalServerError,
+			"",
+		},
+		{
+			"ok",
+			mockSessionsDatabase{},
+			time.Hour,
+			&http.Cookie{Name: refreshKey, Value: "session-a.token"},
+			http.StatusOK,
+			"session-a.rotated",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Session.RefreshTTL = test.refreshTTL
+			rt := router{
+				db:          &test.db,
+				config:      cfg,
+				signingKeys: mustSigningKeyring("abc"),
+			}
+			m := gin.New()
+			m.POST("/", rt.postRefreshLogin)
+
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			if test.cookie != nil {
+				r.AddCookie(test.cookie)
+			}
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			var refresh, auth string
+			for _, cookie := range w.Result().Cookies() {
+				switch cookie.Name {
+				case refreshKey:
+					refresh = cookie.Value
+				case authKey:
+					auth = cookie.Value
+				}
+			}
+			if refresh != test.expectedRefresh {
+				t.Errorf("Unexpected refresh cookie value %v", refresh)
+			}
+			if (auth != "") != (test.expectedRefresh != "") {
+				t.Errorf("Unexpected auth cookie value %v", auth)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+const (
+	ssoSessionKey = "sso"
+	ssoSessionTTL = 24 * time.Hour
+	// ssoLoginURL is where the client is redirected after single sign-on
+	// has succeeded, so that it can unlock the account user's keys using
+	// the locally stored device key.
+	ssoLoginURL = "/login/?sso=1"
+)
+
+// ssoSession is stored in a signed cookie after single sign-on has
+// succeeded and restricts which keys can be unlocked by the session. In
+// case AccountIDs is nil, keys for all accounts can be unlocked.
+type ssoSession struct {
+	AccountUserID string
+	AccountIDs    []string
+	Expires       time.Time
+}
+
+func (rt *router) ssoCookie(name, value string, path string, ttl time.Duration, secure bool) *http.Cookie {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   secure,
+		Path:     path,
+	}
+	if value == "" {
+		c.Expires = time.Unix(0, 0)
+	} else {
+		c.Expires = time.Now().Add(ttl)
+	}
+	return c
+}
+
+// startSSOSession logs in the given account user after it has been
+// authenticated by an external identity provider and redirects the client
+// to the login view for unlocking its keys.
+func (rt *router) startSSOSession(c *gin.Context, accountUserID string, accountIDs []string) {
+	secure := c.GetBool(contextKeySecureContext)
+	session, err := rt.cookieSigner.Encode(ssoSessionKey, ssoSession{
+		AccountUserID: accountUserID,
+		AccountIDs:    accountIDs,
+		Expires:       time.Now().Add(ssoSessionTTL),
+	})
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error signing session: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	authCookie, err := rt.sessionCookie(c, accountUserID)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating auth cookie: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	http.SetCookie(c.Writer, authCookie)
+	http.SetCookie(c.Writer, rt.ssoCookie(ssoSessionKey, session, "/api", ssoSessionTTL, secure))
+	c.Redirect(http.StatusFound, ssoLoginURL)
+}
+
+type unlockDeviceKeyRequest struct {
+	DeviceKey string `json:"deviceKey"`
+}
+
+func (rt *router) postUnlockDeviceKey(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	var req unlockDeviceKeyRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	deviceKey, err := base64.StdEncoding.DecodeString(req.DeviceKey)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding device key: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	sessionCookie, err := c.Request.Cookie(ssoSessionKey)
+	if err != nil {
+		newJSONError(
+			errors.New("router: missing single sign-on session"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+	var session ssoSession
+	if err := rt.decodeSigned(ssoSessionKey, sessionCookie.Value, &session); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding single sign-on session: %w", err),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+	if session.AccountUserID != accountUser.AccountUserID || time.Now().After(session.Expires) {
+		newJSONError(
+			errors.New("router: single sign-on session is not valid"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+
+	result, err := rt.db.UnlockDeviceKey(accountUser.AccountUserID, deviceKey, session.AccountIDs)
+	if err != nil {
+		if !errors.Is(err, persistence.ErrInvalidCredentials) {
+			rt.logError(c, err, "error unlocking device key")
+		}
+		newJSONError(
+			errors.New("router: invalid device key"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+type createDeviceKeyRequest struct {
+	EmailAddress string `json:"emailAddress"`
+	Password     string `json:"password"`
+}
+
+type createDeviceKeyResponse struct {
+	DeviceKey string `json:"deviceKey"`
+}
+
+func (rt *router) postDeviceKey(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	var req createDeviceKeyRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	deviceKey, err := rt.db.CreateDeviceKey(accountUser.AccountUserID, req.EmailAddress, req.Password)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating device key: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, createDeviceKeyResponse{
+		DeviceKey: base64.StdEncoding.EncodeToString(deviceKey),
+	})
+}
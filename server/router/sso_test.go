@@ -0,0 +1,86 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockUnlockDeviceKeyDatabase struct {
+	persistence.Service
+	err        error
+	accountIDs []string
+}
+
+func (m *mockUnlockDeviceKeyDatabase) UnlockDeviceKey(userID string, deviceKey []byte, accountIDs []string) (persistence.LoginResult, error) {
+	m.accountIDs = accountIDs
+	return persistence.LoginResult{AccountUserID: userID}, m.err
+}
+
+func TestRouter_postUnlockDeviceKey(t *testing.T) {
+	signer := securecookie.New([]byte("abc"), nil)
+	sign := func(userID string, expires time.Time) string {
+		token, _ := signer.Encode(ssoSessionKey, ssoSession{
+			AccountUserID: userID,
+			AccountIDs:    []string{"account-a"},
+			Expires:       expires,
+		})
+		return token
+	}
+	deviceKey := base64.StdEncoding.EncodeToString([]byte("device-key"))
+
+	tests := []struct {
+		name               string
+		session            string
+		deviceKey          string
+		err                error
+		expectedStatusCode int
+	}{
+		{"no session", "", deviceKey, nil, http.StatusUnauthorized},
+		{"bad session", "abc", deviceKey, nil, http.StatusUnauthorized},
+		{"other user", sign("user-b", time.Now().Add(time.Hour)), deviceKey, nil, http.StatusUnauthorized},
+		{"expired", sign("user-a", time.Now().Add(-time.Hour)), deviceKey, nil, http.StatusUnauthorized},
+		{"bad device key", sign("user-a", time.Now().Add(time.Hour)), "%%%", nil, http.StatusBadRequest},
+		{"wrong device key", sign("user-a", time.Now().Add(time.Hour)), deviceKey, persistence.ErrInvalidCredentials, http.StatusUnauthorized},
+		{"ok", sign("user-a", time.Now().Add(time.Hour)), deviceKey, nil, http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &mockUnlockDeviceKeyDatabase{err: test.err}
+			rt := router{
+				config:       &config.Config{},
+				cookieSigner: signer,
+				db:           db,
+			}
+			m := gin.New()
+			m.POST("/", func(c *gin.Context) {
+				c.Set(contextKeyAuth, persistence.LoginResult{AccountUserID: "user-a"})
+			}, rt.postUnlockDeviceKey)
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"deviceKey":"%s"}`, test.deviceKey)))
+			if test.session != "" {
+				r.AddCookie(&http.Cookie{Name: ssoSessionKey, Value: test.session})
+			}
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if w.Code == http.StatusOK && !reflect.DeepEqual(db.accountIDs, []string{"account-a"}) {
+				t.Errorf("Expected unlocking to be restricted, got %v", db.accountIDs)
+			}
+		})
+	}
+}
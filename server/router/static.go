@@ -5,20 +5,24 @@ package router
 
 import (
 	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"net/http/httptest"
+	"path"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 var (
-	defaultCSP             = "default-src 'self'; style-src 'self' 'unsafe-inline'"
-	defaultSTS             = "max-age=15768000"
 	revisionedJSRe         = regexp.MustCompile("-[0-9a-z]{10}\\.js$")
 	webfontRe              = regexp.MustCompile("\\.(woff|woff2|ttf)$")
 	scriptRe               = regexp.MustCompile("script\\.js$")
@@ -26,7 +30,7 @@ var (
 	assetRe                = regexp.MustCompile("\\.svg$")
 	defaultResponseHeaders = map[string]string{
 		"Referrer-Policy":        "origin-when-cross-origin",
-		"X-Content-Type-Options": "no-sniff",
+		"X-Content-Type-Options": "nosniff",
 		"X-XSS-Protection":       "1; mode=block",
 	}
 )
@@ -39,7 +43,9 @@ func muteRequest(r *http.Request) *http.Request {
 	}))
 }
 
-func staticMiddleware(fileServer, fallback http.Handler) gin.HandlerFunc {
+func staticMiddleware(fs http.FileSystem, fallback http.Handler, headers securityHeaders) gin.HandlerFunc {
+	fileServer := http.FileServer(fs)
+	etags := &etagCache{values: map[string]string{}}
 	tryStatic := func(method, url string) (int, string) {
 		r := httptest.NewRequest(method, url, nil)
 		w := httptest.NewRecorder()
@@ -58,12 +64,10 @@ func staticMiddleware(fileServer, fallback http.Handler) gin.HandlerFunc {
 			return
 		}
 
+		policy := headers.forPath(c.Request.URL.Path)
 		if strings.HasPrefix(contentType, "text/html") {
 			c.Header("Cache-Control", "no-cache")
-			c.Header("Content-Security-Policy", defaultCSP)
-			if secureContext {
-				c.Header("Strict-Transport-Security", defaultSTS)
-			}
+			policy.apply(c.Writer.Header(), secureContext)
 		}
 
 		switch uri := c.Request.URL.Path; {
@@ -74,14 +78,107 @@ func staticMiddleware(fileServer, fallback http.Handler) gin.HandlerFunc {
 			c.Header("Cache-Control", "no-cache")
 		case scriptRe.MatchString(uri):
 			c.Header("Cache-Control", "no-cache")
-			if secureContext {
-				c.Header("Strict-Transport-Security", defaultSTS)
+			if secureContext && policy.strictTransport != "" {
+				c.Header("Strict-Transport-Security", policy.strictTransport)
 			}
 		}
 
 		for key, value := range defaultResponseHeaders {
 			c.Header(key, value)
 		}
+		if servePrecompressed(c.Writer, muteRequest(c.Request), fs, etags) {
+			return
+		}
+		// the file server responds with 304 in case the ETag matches the
+		// If-None-Match header of the request
+		if etag, err := etags.lookup(fs, c.Request.URL.Path); err == nil {
+			c.Header("ETag", etag)
+		}
 		fileServer.ServeHTTP(c.Writer, muteRequest(c.Request))
 	}
 }
+
+// etagCache computes strong ETags from the content of static assets. Values
+// are cached for as long as size and modification time of a file do not
+// change.
+type etagCache struct {
+	lock   sync.Mutex
+	values map[string]string
+}
+
+func (e *etagCache) lookup(fs http.FileSystem, location string) (string, error) {
+	f, err := fs.Open(path.Clean("/" + location))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if stat.IsDir() {
+		// directories are served using their index file
+		return e.lookup(fs, path.Join(location, "index.html"))
+	}
+
+	key := fmt.Sprintf("%s-%d-%d", location, stat.Size(), stat.ModTime().UnixNano())
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if etag, ok := e.values[key]; ok {
+		return etag, nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	etag := fmt.Sprintf(`"%x"`, hash.Sum(nil)[:16])
+	e.values[key] = etag
+	return etag, nil
+}
+
+// servePrecompressed serves a variant of the requested asset that has been
+// compressed at build time in case the client accepts its encoding. It
+// returns false when no such variant exists.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, fs http.FileSystem, etags *etagCache) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if strings.HasSuffix(r.URL.Path, "/") {
+		return false
+	}
+	location := path.Clean(r.URL.Path)
+	contentType := mime.TypeByExtension(path.Ext(location))
+	if contentType == "" {
+		return false
+	}
+	acceptEncoding := r.Header.Get("Accept-Encoding")
+	for _, encoding := range []string{"br", "gzip"} {
+		if negotiateEncoding(acceptEncoding, encoding) == "" {
+			continue
+		}
+		variant := location + precompressedExtensions[encoding]
+		f, err := fs.Open(variant)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		stat, err := f.Stat()
+		if err != nil || stat.IsDir() {
+			continue
+		}
+		header := w.Header()
+		// each encoding is a different representation of the asset, which is
+		// why the ETag is derived from the compressed content
+		if etag, err := etags.lookup(fs, variant); err == nil {
+			header.Set("ETag", etag)
+		}
+		header.Set("Content-Type", contentType)
+		header.Set("Content-Encoding", encoding)
+		if !strings.Contains(strings.Join(header.Values("Vary"), ","), "Accept-Encoding") {
+			header.Add("Vary", "Accept-Encoding")
+		}
+		http.ServeContent(w, r, location, stat.ModTime(), f)
+		return true
+	}
+	return false
+}
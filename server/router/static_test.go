@@ -4,6 +4,8 @@
 package router
 
 import (
+	"compress/gzip"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -14,13 +16,11 @@ import (
 
 func TestStaticMiddleware(t *testing.T) {
 	m := gin.New()
-	fileServer := http.FileServer(http.Dir("./testdata"))
-
-	middleware := staticMiddleware(fileServer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	middleware := staticMiddleware(http.Dir("./testdata"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
-	}))
+	}), newSecurityHeaders(nil))
 
 	m.Use(middleware)
 
@@ -113,4 +113,73 @@ func TestStaticMiddleware(t *testing.T) {
 			t.Errorf("Unexpected Content-Type %v", w.Header().Get("Content-Type"))
 		}
 	}
+	{
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/script.js", nil)
+		r.Header.Set("Accept-Encoding", "br, gzip")
+
+		m.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Unexpected status code %v", w.Code)
+		}
+
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			t.Errorf("Unexpected Content-Encoding %v", w.Header().Get("Content-Encoding"))
+		}
+
+		if !strings.Contains(w.Header().Get("Content-Type"), "javascript") {
+			t.Errorf("Unexpected Content-Type %v", w.Header().Get("Content-Type"))
+		}
+
+		reader, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		body, _ := ioutil.ReadAll(reader)
+		if string(body) != "console.log('Hello test!')\n" {
+			t.Errorf("Unexpected body %q", string(body))
+		}
+	}
+	{
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/script.js", nil)
+
+		m.ServeHTTP(w, r)
+
+		etag := w.Header().Get("ETag")
+		if !strings.HasPrefix(etag, `"`) {
+			t.Fatalf("Unexpected ETag %v", etag)
+		}
+		if w.Header().Get("Last-Modified") == "" {
+			t.Error("Unexpected empty Last-Modified header")
+		}
+
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, "/script.js", nil)
+		r.Header.Set("If-None-Match", etag)
+
+		m.ServeHTTP(w, r)
+
+		if w.Code != http.StatusNotModified {
+			t.Errorf("Unexpected status code %v", w.Code)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("Unexpected body %v", w.Body.String())
+		}
+
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, "/script.js", nil)
+		r.Header.Set("If-None-Match", etag)
+		r.Header.Set("Accept-Encoding", "gzip")
+
+		m.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected precompressed variant not to match ETag of original, got %v", w.Code)
+		}
+		if w.Header().Get("ETag") == etag || w.Header().Get("ETag") == "" {
+			t.Errorf("Unexpected ETag %v", w.Header().Get("ETag"))
+		}
+	}
 }
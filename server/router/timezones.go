@@ -0,0 +1,78 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type timezoneRequest struct {
+	Timezone string `json:"timezone"`
+}
+
+type timezoneResponse struct {
+	Timezone string `json:"timezone"`
+}
+
+// postTimezone sets the time zone days of rollups and exports of the given
+// account are aligned to.
+func (rt *router) postTimezone(c *gin.Context) {
+	accountID := c.Param("accountID")
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: could not find account user object in request context"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+
+	if ok := accountUser.CanManageAccount(accountID); !ok {
+		newJSONError(
+			fmt.Errorf("router: account user does not have permissions to change time zone of account %s", accountID),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	var req timezoneRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if err := rt.db.SetTimezone(accountID, req.Timezone); err != nil {
+		var errUnknown persistence.ErrUnknownAccount
+		if errors.As(err, &errUnknown) {
+			newJSONError(
+				fmt.Errorf("router: account %s not found: %w", accountID, err),
+				http.StatusNotFound,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error setting time zone of account: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if rt.logger != nil {
+		rt.logger.
+			WithField("audit", "timezone").
+			WithField("accountId", accountID).
+			WithField("timezone", req.Timezone).
+			WithField("by", accountUser.AccountUserID).
+			Info("Changed time zone of account")
+	}
+	c.JSON(http.StatusOK, timezoneResponse{Timezone: req.Timezone})
+}
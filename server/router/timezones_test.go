@@ -0,0 +1,63 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockTimezoneDatabase struct {
+	persistence.Service
+	err error
+}
+
+func (m *mockTimezoneDatabase) SetTimezone(accountID, timezone string) error {
+	return m.err
+}
+
+func TestRouter_postTimezone(t *testing.T) {
+	accountUser := persistence.LoginResult{
+		AccountUserID: "user-a",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a", Role: persistence.AccountUserRoleAdmin},
+			{AccountID: "account-b", Role: persistence.AccountUserRoleViewer},
+		},
+	}
+	tests := []struct {
+		name           string
+		db             *mockTimezoneDatabase
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"ok", &mockTimezoneDatabase{}, "/accounts/account-a/timezone", `{"timezone":"Europe/Berlin"}`, http.StatusOK},
+		{"viewer", &mockTimezoneDatabase{}, "/accounts/account-b/timezone", `{"timezone":"Europe/Berlin"}`, http.StatusForbidden},
+		{"bad payload", &mockTimezoneDatabase{}, "/accounts/account-a/timezone", `{"timezone":`, http.StatusBadRequest},
+		{"invalid", &mockTimezoneDatabase{err: errors.New("did not work")}, "/accounts/account-a/timezone", `{"timezone":"Mars/Olympus"}`, http.StatusBadRequest},
+		{"unknown account", &mockTimezoneDatabase{err: persistence.ErrUnknownAccount("did not work")}, "/accounts/account-a/timezone", `{"timezone":"Europe/Berlin"}`, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: test.db}
+			m := gin.New()
+			m.Use(func(c *gin.Context) {
+				c.Set(contextKeyAuth, accountUser)
+			})
+			m.POST("/accounts/:accountID/timezone", rt.postTimezone)
+
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body)))
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+		})
+	}
+}
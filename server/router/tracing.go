@@ -0,0 +1,45 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/tracing"
+)
+
+// tracingMiddleware starts a span for each request, continuing a trace
+// that has been propagated by the client. The span is added to the context
+// of the request so handlers can trace their calls as children.
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := tracing.Extract(c.Request.Context(), c.Request.Header)
+		ctx, span := tracing.Start(ctx, fmt.Sprintf("HTTP %s", c.Request.Method), tracing.SpanKindServer)
+		defer span.End()
+		span.SetAttribute("http.method", c.Request.Method)
+		span.SetAttribute("http.target", c.Request.URL.Path)
+		if requestID := c.GetString(contextKeyRequestID); requestID != "" {
+			span.SetAttribute("http.request_id", requestID)
+		}
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttribute("http.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.RecordError(fmt.Errorf("router: responded with status %d", status))
+		}
+	}
+}
+
+// tracedDB returns the persistence service of the router, tracing its
+// calls as children of the span found in the given context.
+func (rt *router) tracedDB(ctx context.Context) persistence.Service {
+	return persistence.WithContext(ctx, rt.db)
+}
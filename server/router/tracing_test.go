@@ -0,0 +1,104 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/tracing"
+)
+
+type mockExporter struct {
+	spans []tracing.SpanData
+}
+
+func (m *mockExporter) Export(spans []tracing.SpanData) error {
+	m.spans = append(m.spans, spans...)
+	return nil
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	tests := []struct {
+		name               string
+		traceparent        string
+		status             int
+		expectedTraceID    string
+		expectError        bool
+		expectParentSpanID string
+	}{
+		{
+			"new trace",
+			"",
+			http.StatusOK,
+			"",
+			false,
+			"",
+		},
+		{
+			"continued trace",
+			"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+			http.StatusCreated,
+			"0af7651916cd43dd8448eb211c80319c",
+			false,
+			"b7ad6b7169203331",
+		},
+		{
+			"server error",
+			"",
+			http.StatusInternalServerError,
+			"",
+			true,
+			"",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exporter := &mockExporter{}
+			tracer := tracing.New(exporter)
+			tracing.SetDefault(tracer)
+			defer tracing.SetDefault(nil)
+
+			var handlerSpan *tracing.Span
+			m := gin.New()
+			m.Use(tracingMiddleware())
+			m.GET("/", func(c *gin.Context) {
+				handlerSpan = tracing.SpanFromContext(c.Request.Context())
+				c.Status(test.status)
+			})
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.traceparent != "" {
+				r.Header.Set("traceparent", test.traceparent)
+			}
+			m.ServeHTTP(httptest.NewRecorder(), r)
+			tracer.Shutdown(context.Background())
+
+			if len(exporter.spans) != 1 {
+				t.Fatalf("Unexpected number of spans %d", len(exporter.spans))
+			}
+			span := exporter.spans[0]
+			if handlerSpan == nil || handlerSpan.Context() != span.Context {
+				t.Errorf("Expected span to be passed to handler")
+			}
+			if span.Name != "HTTP GET" || span.Kind != tracing.SpanKindServer {
+				t.Errorf("Unexpected span %v", span)
+			}
+			if span.Attributes["http.status_code"] != test.status {
+				t.Errorf("Unexpected attributes %v", span.Attributes)
+			}
+			if test.expectedTraceID != "" && span.Context.TraceID.String() != test.expectedTraceID {
+				t.Errorf("Unexpected trace id %v", span.Context.TraceID)
+			}
+			if test.expectParentSpanID != "" && span.ParentSpanID.String() != test.expectParentSpanID {
+				t.Errorf("Unexpected parent span id %v", span.ParentSpanID)
+			}
+			if (span.Err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", span.Err)
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/keys"
+)
+
+// Maximum sizes of request bodies accepted by routes using
+// validationMiddleware.
+const (
+	maxEventBodySize      = 64 << 10
+	maxEventBatchBodySize = 1 << 20
+	maxAuthBodySize       = 16 << 10
+)
+
+// jsonKind is the expected type of a value in a JSON payload.
+type jsonKind string
+
+const (
+	jsonString jsonKind = "string"
+	jsonNumber jsonKind = "number"
+	jsonBool   jsonKind = "boolean"
+	jsonArray  jsonKind = "array"
+	jsonObject jsonKind = "object"
+)
+
+// payloadField describes a single field of a JSON payload. In case Items is
+// given for an array, each of its elements is expected to be an object of
+// the given shape. In case Check is given, it is called with values of the
+// expected kind and returns the reason for rejecting the value, if any.
+type payloadField struct {
+	Kind     jsonKind
+	Required bool
+	Items    payloadShape
+	Check    func(value json.RawMessage) string
+}
+
+// payloadShape describes the fields of a JSON object. Fields that are not
+// part of the shape are passed through to the handler, which ignores them.
+type payloadShape map[string]payloadField
+
+var (
+	eventShape = payloadShape{
+		"accountId":     {Kind: jsonString, Required: true},
+		"payload":       {Kind: jsonString, Required: true, Check: symmetricEnvelope.check},
+		"eventId":       {Kind: jsonString},
+		"honeypot":      {Kind: jsonBool},
+		"schemaVersion": {Kind: jsonNumber, Check: checkEventSchemaVersion},
+		"domain":        {Kind: jsonString},
+	}
+	anonymousEventShape = payloadShape{
+		"accountId":     {Kind: jsonString, Required: true},
+		"payload":       {Kind: jsonString, Required: true, Check: asymmetricEnvelope.check},
+		"eventId":       {Kind: jsonString},
+		"honeypot":      {Kind: jsonBool},
+		"schemaVersion": {Kind: jsonNumber, Check: checkEventSchemaVersion},
+		"domain":        {Kind: jsonString},
+	}
+	eventBatchShape = payloadShape{
+		"events": {Kind: jsonArray, Required: true, Items: eventShape},
+	}
+	loginShape = payloadShape{
+		"username":     {Kind: jsonString, Required: true},
+		"password":     {Kind: jsonString, Required: true},
+		"publicKey":    {Kind: jsonString},
+		"secondFactor": {Kind: jsonString},
+	}
+	serviceAccountLoginShape = payloadShape{
+		"credential": {Kind: jsonString, Required: true},
+		"publicKey":  {Kind: jsonString},
+	}
+	magicLinkShape = payloadShape{
+		"emailAddress": {Kind: jsonString, Required: true},
+		"urlTemplate":  {Kind: jsonString},
+	}
+	loginMagicLinkShape = payloadShape{
+		"token":        {Kind: jsonString, Required: true},
+		"secondFactor": {Kind: jsonString},
+	}
+	forgotPasswordShape = payloadShape{
+		"emailAddress": {Kind: jsonString, Required: true},
+		"urlTemplate":  {Kind: jsonString},
+	}
+	resetPasswordShape = payloadShape{
+		"emailAddress": {Kind: jsonString, Required: true},
+		"password":     {Kind: jsonString, Required: true},
+		"token":        {Kind: jsonString, Required: true},
+	}
+	changePasswordShape = payloadShape{
+		"currentPassword": {Kind: jsonString, Required: true},
+		"changedPassword": {Kind: jsonString, Required: true},
+	}
+	changeExpiredPasswordShape = payloadShape{
+		"emailAddress":    {Kind: jsonString, Required: true},
+		"currentPassword": {Kind: jsonString, Required: true},
+		"changedPassword": {Kind: jsonString, Required: true},
+		"secondFactor":    {Kind: jsonString},
+	}
+)
+
+// violation describes a single reason for rejecting a request payload.
+type violation struct {
+	Field  string `json:"field,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// validationError is returned when a request payload does not match the
+// shape expected by a route.
+type validationError struct {
+	violations []violation
+}
+
+func (v *validationError) Error() string {
+	var reasons []string
+	for _, item := range v.violations {
+		if item.Field == "" {
+			reasons = append(reasons, item.Reason)
+			continue
+		}
+		reasons = append(reasons, fmt.Sprintf("%s: %s", item.Field, item.Reason))
+	}
+	return fmt.Sprintf("router: invalid request payload: %s", strings.Join(reasons, ", "))
+}
+
+// validate checks whether the given body is a JSON object matching the
+// shape. Violations are returned in a stable order.
+func (s payloadShape) validate(body []byte) error {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil || payload == nil {
+		return &validationError{[]violation{{Reason: "expected a JSON object"}}}
+	}
+	if violations := s.check("", payload); len(violations) != 0 {
+		return &validationError{violations}
+	}
+	return nil
+}
+
+func (s payloadShape) check(prefix string, payload map[string]json.RawMessage) []violation {
+	var names []string
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var violations []violation
+	for _, name := range names {
+		field := s[name]
+		path := prefix + name
+		value, ok := payload[name]
+		if !ok || kindOf(value) == "" {
+			if field.Required {
+				violations = append(violations, violation{Field: path, Reason: "is required"})
+			}
+			continue
+		}
+		if kind := kindOf(value); kind != field.Kind {
+			violations = append(violations, violation{
+				Field:  path,
+				Reason: fmt.Sprintf("expected %s, got %s", field.Kind, kind),
+			})
+			continue
+		}
+		if field.Check != nil {
+			if reason := field.Check(value); reason != "" {
+				violations = append(violations, violation{Field: path, Reason: reason})
+				continue
+			}
+		}
+		if field.Kind != jsonArray || field.Items == nil {
+			continue
+		}
+		var items []json.RawMessage
+		json.Unmarshal(value, &items)
+		for idx, item := range items {
+			itemPath := fmt.Sprintf("%s[%d]", path, idx)
+			var object map[string]json.RawMessage
+			if kindOf(item) != jsonObject || json.Unmarshal(item, &object) != nil {
+				violations = append(violations, violation{Field: itemPath, Reason: "expected object"})
+				continue
+			}
+			violations = append(violations, field.Items.check(itemPath+".", object)...)
+		}
+	}
+	return violations
+}
+
+// Versions of the schema of event payloads. Clients that do not send a
+// schema version are expected to use the initial version.
+const (
+	eventSchemaVersion1       = 1
+	currentEventSchemaVersion = eventSchemaVersion1
+	minEventSchemaVersion     = eventSchemaVersion1
+)
+
+func checkEventSchemaVersion(value json.RawMessage) string {
+	version, err := strconv.Atoi(string(bytes.TrimSpace(value)))
+	switch {
+	case err != nil:
+		return "expected integer schema version"
+	case version > currentEventSchemaVersion:
+		return fmt.Sprintf(
+			"schema version %d is not supported yet, the latest supported version is %d",
+			version, currentEventSchemaVersion,
+		)
+	case version < minEventSchemaVersion:
+		return fmt.Sprintf(
+			"schema version %d is outdated, the oldest supported version is %d, reload the page to update the client",
+			version, minEventSchemaVersion,
+		)
+	}
+	return ""
+}
+
+// cipherEnvelope describes the meta information of encrypted event payloads
+// that can be checked without decrypting them.
+type cipherEnvelope struct {
+	name         string
+	algoVersions []int
+	nonce        bool
+}
+
+var (
+	// events of users are encrypted using the user's secret
+	symmetricEnvelope = cipherEnvelope{
+		name:         "symmetric",
+		algoVersions: keys.SymmetricAlgoVersions,
+		nonce:        true,
+	}
+	// anonymous events are encrypted using the account's public key
+	asymmetricEnvelope = cipherEnvelope{
+		name:         "asymmetric",
+		algoVersions: keys.AsymmetricAlgoVersions,
+	}
+)
+
+func (e cipherEnvelope) check(value json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return "expected string"
+	}
+	cipher, err := keys.ParseVersionedCipher(s)
+	if err != nil {
+		return "expected encrypted value of format {algoVersion,keyVersion} cipher [nonce]"
+	}
+	var supported bool
+	var versions []string
+	for _, version := range e.algoVersions {
+		supported = supported || version == cipher.AlgoVersion()
+		versions = append(versions, strconv.Itoa(version))
+	}
+	if !supported {
+		return fmt.Sprintf(
+			"algorithm version %d is not supported for %s encryption, supported versions are %s",
+			cipher.AlgoVersion(), e.name, strings.Join(versions, ", "),
+		)
+	}
+	if e.nonce && !cipher.HasNonce() {
+		return fmt.Sprintf("expected nonce for %s encryption", e.name)
+	}
+	if !e.nonce && cipher.HasNonce() {
+		return fmt.Sprintf("unexpected nonce for %s encryption", e.name)
+	}
+	return ""
+}
+
+// kindOf returns the kind of the given JSON value. null values return an
+// empty kind.
+func kindOf(value json.RawMessage) jsonKind {
+	trimmed := bytes.TrimSpace(value)
+	if len(trimmed) == 0 {
+		return ""
+	}
+	switch trimmed[0] {
+	case '"':
+		return jsonString
+	case '[':
+		return jsonArray
+	case '{':
+		return jsonObject
+	case 't', 'f':
+		return jsonBool
+	case 'n':
+		return ""
+	default:
+		return jsonNumber
+	}
+}
+
+// acceptedContentType checks whether a request body of the given content
+// type is accepted. Browsers send JSON payloads that are posted using
+// fetch without setting a header as text/plain, so this is accepted too.
+func acceptedContentType(contentType string) bool {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/plain"
+}
+
+// validationMiddleware rejects requests whose body exceeds the given size,
+// uses a content type other than JSON or does not match the given shape
+// before they are passed to the handler. In case shape is nil, only size and
+// content type are checked.
+func validationMiddleware(maxBodySize int64, shape payloadShape) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if contentType := c.GetHeader("Content-Type"); contentType != "" && !acceptedContentType(contentType) {
+			newJSONError(
+				&validationError{[]violation{{Reason: fmt.Sprintf("content type %s is not supported", contentType)}}},
+				http.StatusUnsupportedMediaType,
+			).Pipe(c)
+			return
+		}
+		if c.Request.ContentLength > maxBodySize {
+			newJSONError(
+				&validationError{[]violation{{Reason: fmt.Sprintf("body exceeds maximum size of %d bytes", maxBodySize)}}},
+				http.StatusRequestEntityTooLarge,
+			).Pipe(c)
+			return
+		}
+		var body []byte
+		if c.Request.Body != nil {
+			b, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxBodySize+1))
+			if err != nil {
+				newJSONError(
+					fmt.Errorf("router: error reading request body: %w", err),
+					http.StatusBadRequest,
+				).Pipe(c)
+				return
+			}
+			body = b
+		}
+		if int64(len(body)) > maxBodySize {
+			newJSONError(
+				&validationError{[]violation{{Reason: fmt.Sprintf("body exceeds maximum size of %d bytes", maxBodySize)}}},
+				http.StatusRequestEntityTooLarge,
+			).Pipe(c)
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if shape != nil {
+			if err := shape.validate(body); err != nil {
+				newJSONError(err, http.StatusBadRequest).Pipe(c)
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidationMiddleware(t *testing.T) {
+	tests := []struct {
+		name               string
+		shape              payloadShape
+		contentType        string
+		body               string
+		expectedStatusCode int
+		expectedViolations []violation
+	}{
+		{
+			"ok",
+			eventShape,
+			"application/json",
+			`{"accountId":"a","payload":"{1,} YWJj eHl6","other":12}`,
+			http.StatusOK,
+			nil,
+		},
+		{
+			"text plain",
+			eventShape,
+			"text/plain;charset=UTF-8",
+			`{"accountId":"a","payload":"{1,} YWJj eHl6"}`,
+			http.StatusOK,
+			nil,
+		},
+		{
+			"no content type",
+			eventShape,
+			"",
+			`{"accountId":"a","payload":"{1,} YWJj eHl6"}`,
+			http.StatusOK,
+			nil,
+		},
+		{
+			"unknown content type",
+			eventShape,
+			"application/x-www-form-urlencoded",
+			`{"accountId":"a","payload":"{1,} YWJj eHl6"}`,
+			http.StatusUnsupportedMediaType,
+			[]violation{{Reason: "content type application/x-www-form-urlencoded is not supported"}},
+		},
+		{
+			"unknown charset",
+			eventShape,
+			"application/json; charset=latin1",
+			`{"accountId":"a","payload":"{1,} YWJj eHl6"}`,
+			http.StatusUnsupportedMediaType,
+			[]violation{{Reason: "content type application/json; charset=latin1 is not supported"}},
+		},
+		{
+			"too large",
+			eventShape,
+			"application/json",
+			`{"accountId":"account-a","payload":"` + strings.Repeat("x", 64) + `"}`,
+			http.StatusRequestEntityTooLarge,
+			[]violation{{Reason: "body exceeds maximum size of 64 bytes"}},
+		},
+		{
+			"not an object",
+			eventShape,
+			"application/json",
+			`["account-a"]`,
+			http.StatusBadRequest,
+			[]violation{{Reason: "expected a JSON object"}},
+		},
+		{
+			"missing and mistyped fields",
+			eventShape,
+			"application/json",
+			`{"accountId":12,"payload":null}`,
+			http.StatusBadRequest,
+			[]violation{
+				{Field: "accountId", Reason: "expected string, got number"},
+				{Field: "payload", Reason: "is required"},
+			},
+		},
+		{
+			"anonymous",
+			anonymousEventShape,
+			"application/json",
+			`{"accountId":"a","payload":"{1,} YWJj","schemaVersion":1}`,
+			http.StatusOK,
+			nil,
+		},
+		{
+			"malformed envelope",
+			eventShape,
+			"application/json",
+			`{"accountId":"a","payload":"{\"type\":\"PAGEVIEW\"}"}`,
+			http.StatusBadRequest,
+			[]violation{
+				{Field: "payload", Reason: "expected encrypted value of format {algoVersion,keyVersion} cipher [nonce]"},
+			},
+		},
+		{
+			"unsupported algorithm",
+			eventShape,
+			"application/json",
+			`{"accountId":"a","payload":"{7,} YWJj eHl6"}`,
+			http.StatusBadRequest,
+			[]violation{
+				{Field: "payload", Reason: "algorithm version 7 is not supported for symmetric encryption, supported versions are 1"},
+			},
+		},
+		{
+			"missing nonce",
+			eventShape,
+			"application/json",
+			`{"accountId":"a","payload":"{1,} YWJj"}`,
+			http.StatusBadRequest,
+			[]violation{{Field: "payload", Reason: "expected nonce for symmetric encryption"}},
+		},
+		{
+			"unexpected nonce",
+			anonymousEventShape,
+			"application/json",
+			`{"accountId":"a","payload":"{1,} YWJj eHl6"}`,
+			http.StatusBadRequest,
+			[]violation{{Field: "payload", Reason: "unexpected nonce for asymmetric encryption"}},
+		},
+		{
+			"outdated schema version",
+			eventShape,
+			"application/json",
+			`{"accountId":"a","payload":"{1,} YWJj eHl6","schemaVersion":0}`,
+			http.StatusBadRequest,
+			[]violation{{Field: "schemaVersion", Reason: "schema version 0 is outdated, the oldest supported version is 1, reload the page to update the client"}},
+		},
+		{
+			"future schema version",
+			eventShape,
+			"application/json",
+			`{"accountId":"a","payload":"{1,} YWJj eHl6","schemaVersion":2}`,
+			http.StatusBadRequest,
+			[]violation{{Field: "schemaVersion", Reason: "schema version 2 is not supported yet, the latest supported version is 1"}},
+		},
+		{
+			"batch",
+			eventBatchShape,
+			"application/json",
+			`{"events":[{"accountId":"a","payload":"{1,} YWJj"},1,{}]}`,
+			http.StatusBadRequest,
+			[]violation{
+				{Field: "events[0].payload", Reason: "expected nonce for symmetric encryption"},
+				{Field: "events[1]", Reason: "expected object"},
+				{Field: "events[2].accountId", Reason: "is required"},
+				{Field: "events[2].payload", Reason: "is required"},
+			},
+		},
+		{
+			"no shape",
+			nil,
+			"application/json",
+			`"anything"`,
+			http.StatusOK,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := gin.New()
+			m.POST("/", validationMiddleware(64, test.shape), func(c *gin.Context) {
+				b, _ := ioutil.ReadAll(c.Request.Body)
+				if string(b) != test.body {
+					t.Errorf("Unexpected body passed to handler %s", b)
+				}
+				c.Status(http.StatusOK)
+			})
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+			if test.contentType != "" {
+				r.Header.Set("Content-Type", test.contentType)
+			}
+			// the body size is also checked when the request does not
+			// announce its length
+			r.ContentLength = -1
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if test.expectedViolations == nil {
+				return
+			}
+			var response errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(test.expectedViolations, response.Violations) {
+				t.Errorf("Expected %v, got %v", test.expectedViolations, response.Violations)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/webauthn"
+)
+
+const (
+	webAuthnCeremonyRegister = "register"
+	webAuthnCeremonyLogin    = "login"
+	webAuthnChallengeTTL     = 5 * time.Minute
+)
+
+// webAuthnChallenge is signed and handed to the client so that the challenge
+// does not need to be stored on the server in between the two requests of a
+// ceremony.
+type webAuthnChallenge struct {
+	Challenge     []byte
+	Ceremony      string
+	AccountUserID string
+	Expires       time.Time
+}
+
+// relyingParty returns the WebAuthn relying party for the given request. In
+// case no relying party id is configured, the host of the request is used.
+func (rt *router) relyingParty(c *gin.Context) *webauthn.RelyingParty {
+	id := rt.config.WebAuthn.RelyingPartyID
+	if id == "" {
+		id = c.Request.Host
+		if host, _, err := net.SplitHostPort(id); err == nil {
+			id = host
+		}
+	}
+	return webauthn.New(id, rt.config.WebAuthn.Origins...)
+}
+
+type webAuthnChallengeResponse struct {
+	Challenge      string `json:"challenge"`
+	Token          string `json:"token"`
+	RelyingPartyID string `json:"rpId"`
+	UserID         string `json:"userId,omitempty"`
+}
+
+func (rt *router) issueWebAuthnChallenge(c *gin.Context, ceremony, accountUserID string) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating challenge: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	token, err := rt.cookieSigner.Encode("webauthn", webAuthnChallenge{
+		Challenge:     challenge,
+		Ceremony:      ceremony,
+		AccountUserID: accountUserID,
+		Expires:       time.Now().Add(webAuthnChallengeTTL),
+	})
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error signing challenge: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, webAuthnChallengeResponse{
+		Challenge:      base64.RawURLEncoding.EncodeToString(challenge),
+		Token:          token,
+		RelyingPartyID: rt.relyingParty(c).ID(),
+		UserID:         accountUserID,
+	})
+}
+
+func (rt *router) decodeWebAuthnChallenge(token, ceremony, accountUserID string) ([]byte, error) {
+	var challenge webAuthnChallenge
+	if err := rt.decodeSigned("webauthn", token, &challenge); err != nil {
+		return nil, fmt.Errorf("router: error decoding challenge: %w", err)
+	}
+	if challenge.Ceremony != ceremony || challenge.AccountUserID != accountUserID {
+		return nil, errors.New("router: challenge has been issued for another ceremony")
+	}
+	if time.Now().After(challenge.Expires) {
+		return nil, errors.New("router: challenge has expired")
+	}
+	// challenges can only be used once, so they are stored until they
+	// would have expired anyways
+	if err := rt.getConsumedTokens().Add(
+		"webauthn-"+hex.EncodeToString(challenge.Challenge), true, time.Until(challenge.Expires),
+	); err != nil {
+		return nil, errors.New("router: challenge has already been used")
+	}
+	return challenge.Challenge, nil
+}
+
+func (rt *router) getWebAuthnRegister(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	rt.issueWebAuthnChallenge(c, webAuthnCeremonyRegister, accountUser.AccountUserID)
+}
+
+type webAuthnRegisterRequest struct {
+	EmailAddress      string `json:"emailAddress"`
+	Password          string `json:"password"`
+	Token             string `json:"token"`
+	ClientDataJSON    []byte `json:"clientDataJSON"`
+	AttestationObject []byte `json:"attestationObject"`
+	PRFOutput         []byte `json:"prfOutput"`
+}
+
+func (rt *router) postWebAuthnRegister(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	var req webAuthnRegisterRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	challenge, err := rt.decodeWebAuthnChallenge(req.Token, webAuthnCeremonyRegister, accountUser.AccountUserID)
+	if err != nil {
+		newJSONError(err, http.StatusBadRequest).Pipe(c)
+		return
+	}
+
+	credential, err := rt.relyingParty(c).VerifyRegistration(challenge, req.ClientDataJSON, req.AttestationObject)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error verifying registration: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if err := rt.db.RegisterWebAuthnCredential(
+		accountUser.AccountUserID, req.EmailAddress, req.Password,
+		persistence.WebAuthnCredentialResult{
+			CredentialID: credential.ID,
+			PublicKey:    credential.PublicKey,
+			SignCount:    credential.SignCount,
+		},
+		req.PRFOutput,
+	); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error registering credential: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (rt *router) getWebAuthnLogin(c *gin.Context) {
+	rt.issueWebAuthnChallenge(c, webAuthnCeremonyLogin, "")
+}
+
+type webAuthnLoginRequest struct {
+	Token             string `json:"token"`
+	CredentialID      []byte `json:"credentialId"`
+	ClientDataJSON    []byte `json:"clientDataJSON"`
+	AuthenticatorData []byte `json:"authenticatorData"`
+	Signature         []byte `json:"signature"`
+	PRFOutput         []byte `json:"prfOutput"`
+}
+
+func (rt *router) postWebAuthnLogin(c *gin.Context) {
+	var req webAuthnLoginRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+
+	if l := <-rt.getLimiter().ExponentialThrottle(time.Second, fmt.Sprintf("postWebAuthnLogin-%x", req.CredentialID)); l.Error != nil {
+		newJSONError(
+			fmt.Errorf("router: error applying rate limit: %w", l.Error),
+			http.StatusTooManyRequests,
+		).Pipe(c)
+		return
+	}
+
+	challenge, err := rt.decodeWebAuthnChallenge(req.Token, webAuthnCeremonyLogin, "")
+	if err != nil {
+		newJSONError(err, http.StatusBadRequest).Pipe(c)
+		return
+	}
+
+	result, err := rt.loginWithWebAuthn(c, challenge, req)
+	observeLogin("webauthn", err)
+	if err != nil {
+		// the underlying error is not exposed as it might allow to tell
+		// whether a credential is known
+		if !errors.Is(err, persistence.ErrInvalidCredentials) {
+			rt.logError(c, err, "error logging in using webauthn")
+		}
+		newJSONError(
+			errors.New("router: invalid credentials"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return
+	}
+
+	if !rt.networkAllowed(c, result.AllowedNetworks) {
+		newJSONError(
+			errors.New("router: access from this network is not allowed"),
+			http.StatusForbidden,
+		).Pipe(c)
+		return
+	}
+
+	authCookie, authCookieErr := rt.sessionCookie(c, result.AccountUserID)
+	if authCookieErr != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating auth cookie: %w", authCookieErr),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+
+	http.SetCookie(c.Writer, authCookie)
+	c.JSON(http.StatusOK, result)
+}
+
+func (rt *router) loginWithWebAuthn(c *gin.Context, challenge []byte, req webAuthnLoginRequest) (persistence.LoginResult, error) {
+	stored, err := rt.db.LookupWebAuthnCredential(req.CredentialID)
+	if err != nil {
+		return persistence.LoginResult{}, persistence.ErrInvalidCredentials
+	}
+	signCount, err := rt.relyingParty(c).VerifyAssertion(
+		&webauthn.Credential{
+			ID:        stored.CredentialID,
+			PublicKey: stored.PublicKey,
+			SignCount: stored.SignCount,
+		},
+		challenge, req.ClientDataJSON, req.AuthenticatorData, req.Signature,
+	)
+	if err != nil {
+		return persistence.LoginResult{}, persistence.ErrInvalidCredentials
+	}
+	return rt.db.LoginWithWebAuthn(req.CredentialID, signCount, req.PRFOutput)
+}
+
+func (rt *router) deleteWebAuthnCredential(c *gin.Context) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: account user object not found on request context"),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	credentialID, err := base64.RawURLEncoding.DecodeString(c.Param("credentialID"))
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding credential id: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	if err := rt.db.DeleteWebAuthnCredential(accountUser.AccountUserID, credentialID); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error deleting credential: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,216 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	"github.com/offen/offen/server/config"
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestRouter_decodeWebAuthnChallenge(t *testing.T) {
+	rt := router{cookieSigner: securecookie.New([]byte("abc"), nil)}
+	sign := func(c webAuthnChallenge) string {
+		token, _ := rt.cookieSigner.Encode("webauthn", c)
+		return token
+	}
+	tests := []struct {
+		name        string
+		token       string
+		expectError bool
+	}{
+		{
+			"ok",
+			sign(webAuthnChallenge{Challenge: []byte("x"), Ceremony: webAuthnCeremonyRegister, AccountUserID: "user-a", Expires: time.Now().Add(time.Minute)}),
+			false,
+		},
+		{
+			"other ceremony",
+			sign(webAuthnChallenge{Challenge: []byte("x"), Ceremony: webAuthnCeremonyLogin, AccountUserID: "user-a", Expires: time.Now().Add(time.Minute)}),
+			true,
+		},
+		{
+			"other user",
+			sign(webAuthnChallenge{Challenge: []byte("x"), Ceremony: webAuthnCeremonyRegister, AccountUserID: "user-b", Expires: time.Now().Add(time.Minute)}),
+			true,
+		},
+		{
+			"expired",
+			sign(webAuthnChallenge{Challenge: []byte("x"), Ceremony: webAuthnCeremonyRegister, AccountUserID: "user-a", Expires: time.Now().Add(-time.Minute)}),
+			true,
+		},
+		{
+			"bad token",
+			"abc",
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := rt.decodeWebAuthnChallenge(test.token, webAuthnCeremonyRegister, "user-a")
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+		})
+	}
+	t.Run("replayed", func(t *testing.T) {
+		token := sign(webAuthnChallenge{Challenge: []byte("y"), Ceremony: webAuthnCeremonyRegister, AccountUserID: "user-a", Expires: time.Now().Add(time.Minute)})
+		if _, err := rt.decodeWebAuthnChallenge(token, webAuthnCeremonyRegister, "user-a"); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if _, err := rt.decodeWebAuthnChallenge(token, webAuthnCeremonyRegister, "user-a"); err == nil {
+			t.Error("Expected error when using challenge twice")
+		}
+	})
+}
+
+type mockWebAuthnLoginDatabase struct {
+	persistence.Service
+}
+
+func (m *mockWebAuthnLoginDatabase) LookupWebAuthnCredential([]byte) (persistence.WebAuthnCredentialResult, error) {
+	return persistence.WebAuthnCredentialResult{}, errors.New("not found")
+}
+
+func TestRouter_postWebAuthnLogin(t *testing.T) {
+	m := gin.New()
+	rt := router{
+		config:       &config.Config{},
+		db:           &mockWebAuthnLoginDatabase{},
+		cookieSigner: securecookie.New([]byte("abc"), nil),
+	}
+	m.GET("/", rt.getWebAuthnLogin)
+	m.POST("/", rt.postWebAuthnLogin)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %v", w.Code)
+	}
+	var challenge webAuthnChallengeResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &challenge); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if challenge.RelyingPartyID != "example.com" {
+		t.Errorf("Unexpected relying party id %v", challenge.RelyingPartyID)
+	}
+
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"token":"abc"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status code %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, challenge.Token))))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Unexpected status code %v", w.Code)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("Unexpected cookies %v", w.Result().Cookies())
+	}
+
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, challenge.Token))))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status code %v when replaying challenge", w.Code)
+	}
+}
+
+type mockWebAuthnAllowedNetworksDatabase struct {
+	persistence.Service
+	publicKey []byte
+	networks  []string
+}
+
+func (m *mockWebAuthnAllowedNetworksDatabase) LookupWebAuthnCredential(credentialID []byte) (persistence.WebAuthnCredentialResult, error) {
+	return persistence.WebAuthnCredentialResult{CredentialID: credentialID, PublicKey: m.publicKey}, nil
+}
+
+func (m *mockWebAuthnAllowedNetworksDatabase) LoginWithWebAuthn([]byte, uint32, []byte) (persistence.LoginResult, error) {
+	return persistence.LoginResult{AccountUserID: "user-a", AllowedNetworks: m.networks}, nil
+}
+
+func (m *mockWebAuthnAllowedNetworksDatabase) CreateSession(string, string, string, time.Duration, time.Duration) (persistence.SessionResult, error) {
+	return persistence.SessionResult{SessionID: "session-id"}, nil
+}
+
+func TestRouter_postWebAuthnLogin_AllowedNetworks(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	publicKey, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	tests := []struct {
+		name               string
+		networks           []string
+		expectedStatusCode int
+	}{
+		{"no restrictions", nil, http.StatusOK},
+		{"network allowed", []string{"192.0.2.0/24"}, http.StatusOK},
+		{"network not allowed", []string{"10.0.0.0/8"}, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{
+				config:       &config.Config{},
+				db:           &mockWebAuthnAllowedNetworksDatabase{publicKey: publicKey, networks: test.networks},
+				cookieSigner: securecookie.New([]byte("abc"), nil),
+				signingKeys:  mustSigningKeyring("abc"),
+			}
+			m := gin.New()
+			m.POST("/", rt.postWebAuthnLogin)
+
+			challenge := []byte(test.name)
+			token, _ := rt.cookieSigner.Encode("webauthn", webAuthnChallenge{
+				Challenge: challenge,
+				Ceremony:  webAuthnCeremonyLogin,
+				Expires:   time.Now().Add(time.Minute),
+			})
+			clientDataJSON := []byte(fmt.Sprintf(
+				`{"type":"webauthn.get","challenge":"%s","origin":"https://example.com"}`,
+				base64.RawURLEncoding.EncodeToString(challenge),
+			))
+			rpIDHash := sha256.Sum256([]byte("example.com"))
+			authenticatorData := append(rpIDHash[:], 0x01, 0, 0, 0, 0)
+			clientDataHash := sha256.Sum256(clientDataJSON)
+			digest := sha256.Sum256(append(append([]byte{}, authenticatorData...), clientDataHash[:]...))
+			r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+			signature, _ := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+
+			body, _ := json.Marshal(webAuthnLoginRequest{
+				Token:             token,
+				CredentialID:      []byte("credential-a"),
+				ClientDataJSON:    clientDataJSON,
+				AuthenticatorData: authenticatorData,
+				Signature:         signature,
+			})
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+			if w.Code != test.expectedStatusCode {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if cookies := w.Result().Cookies(); (len(cookies) == 1) != (w.Code == http.StatusOK) {
+				t.Errorf("Unexpected cookies %v", cookies)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+	"github.com/offen/offen/server/webhooks"
+)
+
+const (
+	defaultWebhookDeliveries = 50
+	maxWebhookDeliveries     = 500
+)
+
+type createWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// manageableAccount returns the account user of the request in case they are
+// allowed to manage the account of the given id. Otherwise an error is
+// piped to the client.
+func (rt *router) manageableAccount(c *gin.Context, accountID string) (persistence.LoginResult, bool) {
+	accountUser, ok := c.Value(contextKeyAuth).(persistence.LoginResult)
+	if !ok {
+		newJSONError(
+			errors.New("router: could not find account user object in request context"),
+			http.StatusUnauthorized,
+		).Pipe(c)
+		return accountUser, false
+	}
+	if !accountUser.CanManageAccount(accountID) {
+		newJSONError(
+			fmt.Errorf("router: account user does not have permissions to manage account %s", accountID),
+			http.StatusForbidden,
+		).Pipe(c)
+		return accountUser, false
+	}
+	return accountUser, true
+}
+
+func (rt *router) getWebhooks(c *gin.Context) {
+	accountID := c.Param("accountID")
+	if _, ok := rt.manageableAccount(c, accountID); !ok {
+		return
+	}
+	result, err := rt.db.ListWebhooks(accountID)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error looking up webhooks: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{"webhooks": result})
+}
+
+func (rt *router) postWebhook(c *gin.Context) {
+	accountID := c.Param("accountID")
+	accountUser, ok := rt.manageableAccount(c, accountID)
+	if !ok {
+		return
+	}
+	var req createWebhookRequest
+	if err := c.BindJSON(&req); err != nil {
+		newJSONError(
+			fmt.Errorf("router: error decoding request payload: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	result, err := rt.db.CreateWebhook(accountUser.AccountUserID, accountID, req.URL, req.Events)
+	if err != nil {
+		newJSONError(
+			fmt.Errorf("router: error creating webhook: %w", err),
+			http.StatusBadRequest,
+		).Pipe(c)
+		return
+	}
+	if rt.logger != nil {
+		rt.logger.
+			WithField("audit", "webhooks").
+			WithField("accountId", accountID).
+			WithField("webhookId", result.WebhookID).
+			WithField("by", accountUser.AccountUserID).
+			Info("Created webhook")
+	}
+	c.JSON(http.StatusCreated, result)
+}
+
+func (rt *router) deleteWebhook(c *gin.Context) {
+	accountID := c.Param("accountID")
+	accountUser, ok := rt.manageableAccount(c, accountID)
+	if !ok {
+		return
+	}
+	if err := rt.db.DeleteWebhook(accountID, c.Param("webhookID")); err != nil {
+		if errors.Is(err, persistence.ErrUnknownWebhook) {
+			newJSONError(
+				fmt.Errorf("router: webhook %s not found: %w", c.Param("webhookID"), err),
+				http.StatusNotFound,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error deleting webhook: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	if rt.logger != nil {
+		rt.logger.
+			WithField("audit", "webhooks").
+			WithField("accountId", accountID).
+			WithField("webhookId", c.Param("webhookID")).
+			WithField("by", accountUser.AccountUserID).
+			Info("Deleted webhook")
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (rt *router) getWebhookDeliveries(c *gin.Context) {
+	accountID := c.Param("accountID")
+	if _, ok := rt.manageableAccount(c, accountID); !ok {
+		return
+	}
+	limit := defaultWebhookDeliveries
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxWebhookDeliveries {
+			newJSONError(
+				fmt.Errorf("router: limit needs to be a number between 1 and %d", maxWebhookDeliveries),
+				http.StatusBadRequest,
+			).Pipe(c)
+			return
+		}
+		limit = parsed
+	}
+	result, err := rt.db.ListWebhookDeliveries(accountID, c.Param("webhookID"), limit)
+	if err != nil {
+		if errors.Is(err, persistence.ErrUnknownWebhook) {
+			newJSONError(
+				fmt.Errorf("router: webhook %s not found: %w", c.Param("webhookID"), err),
+				http.StatusNotFound,
+			).Pipe(c)
+			return
+		}
+		newJSONError(
+			fmt.Errorf("router: error looking up webhook deliveries: %w", err),
+			http.StatusInternalServerError,
+		).Pipe(c)
+		return
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{"deliveries": result})
+}
+
+// dispatchAccountUserAdded notifies the webhooks of the given accounts about
+// an account user that has been granted access. Failing to do so does not
+// fail the request.
+func (rt *router) dispatchAccountUserAdded(c *gin.Context, accountIDs []string, role persistence.AccountUserRole) {
+	for _, accountID := range accountIDs {
+		if err := rt.webhooks.Dispatch(accountID, persistence.WebhookEventAccountUserAdded, webhooks.NewAccountUserAdded(role)); err != nil {
+			rt.logError(c, err, "error dispatching webhooks")
+		}
+	}
+}
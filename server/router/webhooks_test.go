@@ -0,0 +1,93 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockWebhooksDatabase struct {
+	persistence.Service
+	err   error
+	limit int
+}
+
+func (m *mockWebhooksDatabase) ListWebhooks(accountID string) ([]persistence.WebhookResult, error) {
+	return []persistence.WebhookResult{{WebhookID: "webhook-a", AccountID: accountID}}, m.err
+}
+
+func (m *mockWebhooksDatabase) CreateWebhook(userID, accountID, url string, events []string) (persistence.WebhookResult, error) {
+	return persistence.WebhookResult{WebhookID: "webhook-a", AccountID: accountID, URL: url, Secret: "secret", Events: events}, m.err
+}
+
+func (m *mockWebhooksDatabase) DeleteWebhook(accountID, webhookID string) error {
+	return m.err
+}
+
+func (m *mockWebhooksDatabase) ListWebhookDeliveries(accountID, webhookID string, limit int) ([]persistence.WebhookDeliveryResult, error) {
+	m.limit = limit
+	return []persistence.WebhookDeliveryResult{{DeliveryID: "delivery-a", WebhookID: webhookID}}, m.err
+}
+
+func TestRouter_webhooks(t *testing.T) {
+	accountUser := persistence.LoginResult{
+		AccountUserID: "user-a",
+		Accounts: []persistence.LoginAccountResult{
+			{AccountID: "account-a", Role: persistence.AccountUserRoleAdmin},
+			{AccountID: "account-b", Role: persistence.AccountUserRoleViewer},
+		},
+	}
+	tests := []struct {
+		name           string
+		db             *mockWebhooksDatabase
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"list", &mockWebhooksDatabase{}, http.MethodGet, "/accounts/account-a/webhooks", "", http.StatusOK, `"webhookId":"webhook-a"`},
+		{"list viewer", &mockWebhooksDatabase{}, http.MethodGet, "/accounts/account-b/webhooks", "", http.StatusForbidden, ""},
+		{"list error", &mockWebhooksDatabase{err: errors.New("did not work")}, http.MethodGet, "/accounts/account-a/webhooks", "", http.StatusInternalServerError, ""},
+		{"create", &mockWebhooksDatabase{}, http.MethodPost, "/accounts/account-a/webhooks", `{"url":"https://www.example.com","events":["account_user.added"]}`, http.StatusCreated, `"secret":"secret"`},
+		{"create bad payload", &mockWebhooksDatabase{}, http.MethodPost, "/accounts/account-a/webhooks", `{"url":`, http.StatusBadRequest, ""},
+		{"create invalid", &mockWebhooksDatabase{err: errors.New("did not work")}, http.MethodPost, "/accounts/account-a/webhooks", `{}`, http.StatusBadRequest, ""},
+		{"create other account", &mockWebhooksDatabase{}, http.MethodPost, "/accounts/account-z/webhooks", `{}`, http.StatusForbidden, ""},
+		{"delete", &mockWebhooksDatabase{}, http.MethodDelete, "/accounts/account-a/webhooks/webhook-a", "", http.StatusNoContent, ""},
+		{"delete unknown", &mockWebhooksDatabase{err: persistence.ErrUnknownWebhook}, http.MethodDelete, "/accounts/account-a/webhooks/webhook-z", "", http.StatusNotFound, ""},
+		{"deliveries", &mockWebhooksDatabase{}, http.MethodGet, "/accounts/account-a/webhooks/webhook-a/deliveries?limit=10", "", http.StatusOK, `"deliveryId":"delivery-a"`},
+		{"deliveries bad limit", &mockWebhooksDatabase{}, http.MethodGet, "/accounts/account-a/webhooks/webhook-a/deliveries?limit=0", "", http.StatusBadRequest, ""},
+		{"deliveries unknown", &mockWebhooksDatabase{err: persistence.ErrUnknownWebhook}, http.MethodGet, "/accounts/account-a/webhooks/webhook-z/deliveries", "", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := router{db: test.db}
+			m := gin.New()
+			m.Use(func(c *gin.Context) {
+				c.Set(contextKeyAuth, accountUser)
+			})
+			m.GET("/accounts/:accountID/webhooks", rt.getWebhooks)
+			m.POST("/accounts/:accountID/webhooks", rt.postWebhook)
+			m.DELETE("/accounts/:accountID/webhooks/:webhookID", rt.deleteWebhook)
+			m.GET("/accounts/:accountID/webhooks/:webhookID/deliveries", rt.getWebhookDeliveries)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			m.ServeHTTP(w, r)
+			if w.Code != test.expectedStatus {
+				t.Errorf("Unexpected status code %v", w.Code)
+			}
+			if !strings.Contains(w.Body.String(), test.expectedBody) {
+				t.Errorf("Unexpected body %v", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"sort"
+	"strings"
+)
+
+// canonicalize returns the exclusive canonical form (without comments) of
+// the given element as defined in https://www.w3.org/TR/xml-exc-c14n/. The
+// excluded element and its descendants are omitted from the output, which
+// implements the enveloped signature transform. Namespace prefixes given in
+// inclusivePrefixes are treated as defined by the InclusiveNamespaces
+// PrefixList.
+func canonicalize(e *element, inclusivePrefixes []string, exclude *element) []byte {
+	var buf bytes.Buffer
+	writeCanonical(&buf, e, map[string]string{}, inclusivePrefixes, exclude)
+	return buf.Bytes()
+}
+
+type canonicalAttr struct {
+	namespace string
+	local     string
+	name      string
+	value     string
+}
+
+func qualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+func writeCanonical(buf *bytes.Buffer, e *element, rendered map[string]string, inclusivePrefixes []string, exclude *element) {
+	utilized := map[string]bool{e.prefix: true}
+	var attrs []canonicalAttr
+	for _, a := range e.attrs {
+		if isNamespaceDeclaration(a) {
+			continue
+		}
+		attr := canonicalAttr{
+			local: a.Name.Local,
+			name:  qualifiedName(a.Name.Space, a.Name.Local),
+			value: a.Value,
+		}
+		if a.Name.Space != "" {
+			utilized[a.Name.Space] = true
+			attr.namespace, _ = e.lookupNamespace(a.Name.Space)
+		}
+		attrs = append(attrs, attr)
+	}
+	for _, prefix := range inclusivePrefixes {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		if _, ok := e.lookupNamespace(prefix); ok {
+			utilized[prefix] = true
+		}
+	}
+	delete(utilized, "xml")
+
+	next := map[string]string{}
+	for prefix, uri := range rendered {
+		next[prefix] = uri
+	}
+	var declarations []xml.Attr
+	for prefix := range utilized {
+		uri, ok := e.lookupNamespace(prefix)
+		if !ok {
+			continue
+		}
+		previous, wasRendered := rendered[prefix]
+		if wasRendered && previous == uri {
+			continue
+		}
+		if !wasRendered && prefix == "" && uri == "" {
+			continue
+		}
+		declarations = append(declarations, xml.Attr{Name: xml.Name{Local: prefix}, Value: uri})
+		next[prefix] = uri
+	}
+	sort.Slice(declarations, func(i, j int) bool {
+		return declarations[i].Name.Local < declarations[j].Name.Local
+	})
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].namespace != attrs[j].namespace {
+			return attrs[i].namespace < attrs[j].namespace
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	name := qualifiedName(e.prefix, e.local)
+	buf.WriteString("<" + name)
+	for _, d := range declarations {
+		if d.Name.Local == "" {
+			buf.WriteString(` xmlns="`)
+		} else {
+			buf.WriteString(` xmlns:` + d.Name.Local + `="`)
+		}
+		buf.WriteString(attrReplacer.Replace(d.Value))
+		buf.WriteString(`"`)
+	}
+	for _, a := range attrs {
+		buf.WriteString(" " + a.name + `="`)
+		buf.WriteString(attrReplacer.Replace(a.value))
+		buf.WriteString(`"`)
+	}
+	buf.WriteString(">")
+	for _, c := range e.children {
+		switch child := c.(type) {
+		case string:
+			buf.WriteString(textReplacer.Replace(child))
+		case *element:
+			if child == exclude {
+				continue
+			}
+			writeCanonical(buf, child, next, inclusivePrefixes, exclude)
+		}
+	}
+	buf.WriteString("</" + name + ">")
+}
+
+var textReplacer = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	"\r", "&#xD;",
+)
+
+var attrReplacer = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	`"`, "&quot;",
+	"\t", "&#x9;",
+	"\n", "&#xA;",
+	"\r", "&#xD;",
+)
@@ -0,0 +1,78 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package saml
+
+import (
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name              string
+		document          string
+		id                string
+		inclusivePrefixes []string
+		expectedResult    string
+	}{
+		{
+			"spec example",
+			`<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 ID="x" xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"/></n1:elem2></n0:local>`,
+			"x",
+			nil,
+			`<n1:elem2 xmlns:n1="http://example.net" ID="x" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"></n3:stuff></n1:elem2>`,
+		},
+		{
+			"inherited namespace",
+			`<p:root xmlns:p="urn:p" xmlns:q="urn:q"><p:a ID="x"><q:b/></p:a></p:root>`,
+			"x",
+			nil,
+			`<p:a xmlns:p="urn:p" ID="x"><q:b xmlns:q="urn:q"></q:b></p:a>`,
+		},
+		{
+			"unused namespace",
+			`<p:a ID="x" xmlns:p="urn:p" xmlns:q="urn:q"><p:b/></p:a>`,
+			"x",
+			nil,
+			`<p:a xmlns:p="urn:p" ID="x"><p:b></p:b></p:a>`,
+		},
+		{
+			"inclusive prefix",
+			`<p:a ID="x" xmlns:p="urn:p" xmlns:q="urn:q"><p:b/></p:a>`,
+			"x",
+			[]string{"q"},
+			`<p:a xmlns:p="urn:p" xmlns:q="urn:q" ID="x"><p:b></p:b></p:a>`,
+		},
+		{
+			"attributes and escaping",
+			"<a xmlns=\"urn:x\" ID=\"x\" b=\"2\" a=\"1&amp;&quot;\" xmlns:z=\"urn:z\" z:c=\"3\"><!-- comment --><c xmlns=\"\">t&gt;<![CDATA[<]]></c></a>",
+			"x",
+			nil,
+			`<a xmlns="urn:x" xmlns:z="urn:z" ID="x" a="1&amp;&quot;" b="2" z:c="3"><c xmlns="">t&gt;&lt;</c></a>`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root, err := parseXML([]byte(test.document))
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			var target *element
+			root.walk(func(el *element) {
+				if el.attr("ID") == test.id {
+					target = el
+				}
+			})
+			result := string(canonicalize(target, test.inclusivePrefixes, nil))
+			if result != test.expectedResult {
+				t.Errorf("Expected %s, got %s", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestParseXML_Directive(t *testing.T) {
+	if _, err := parseXML([]byte(`<!DOCTYPE a [<!ENTITY b "c">]><a>&b;</a>`)); err == nil {
+		t.Error("Expected error parsing document with directive")
+	}
+}
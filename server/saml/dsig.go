@@ -0,0 +1,162 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package saml
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	// hash functions need to be linked for being available via crypto.Hash
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+const (
+	dsigNamespace               = "http://www.w3.org/2000/09/xmldsig#"
+	exclusiveC14NAlgorithm      = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	envelopedSignatureAlgorithm = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+)
+
+var signatureMethods = map[string]crypto.Hash{
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256":   crypto.SHA256,
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha512":   crypto.SHA512,
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256": crypto.SHA256,
+}
+
+var digestMethods = map[string]crypto.Hash{
+	"http://www.w3.org/2001/04/xmlenc#sha256": crypto.SHA256,
+	"http://www.w3.org/2001/04/xmlenc#sha512": crypto.SHA512,
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
+
+func inclusivePrefixes(algorithm *element) []string {
+	for _, c := range algorithm.children {
+		if el, ok := c.(*element); ok && el.is(exclusiveC14NAlgorithm, "InclusiveNamespaces") {
+			return strings.Fields(el.attr("PrefixList"))
+		}
+	}
+	return nil
+}
+
+// verifySignature checks the enveloped signature that is a direct child of
+// the given element. The signature is required to reference the element
+// itself and must have been created by one of the given certificates.
+// Certificates embedded in the signature are ignored.
+func verifySignature(el *element, certificates []*x509.Certificate) error {
+	signatures := el.childrenNamed(dsigNamespace, "Signature")
+	if len(signatures) != 1 {
+		return fmt.Errorf("saml: expected exactly one signature, found %d", len(signatures))
+	}
+	signature := signatures[0]
+
+	signedInfo := signature.child(dsigNamespace, "SignedInfo")
+	if signedInfo == nil {
+		return errors.New("saml: signature is missing signed info")
+	}
+	c14nMethod := signedInfo.child(dsigNamespace, "CanonicalizationMethod")
+	if c14nMethod == nil || c14nMethod.attr("Algorithm") != exclusiveC14NAlgorithm {
+		return errors.New("saml: unsupported canonicalization method")
+	}
+	signatureMethod := signedInfo.child(dsigNamespace, "SignatureMethod")
+	if signatureMethod == nil {
+		return errors.New("saml: signature is missing signature method")
+	}
+	signatureHash, ok := signatureMethods[signatureMethod.attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("saml: unsupported signature method %s", signatureMethod.attr("Algorithm"))
+	}
+
+	references := signedInfo.childrenNamed(dsigNamespace, "Reference")
+	if len(references) != 1 {
+		return fmt.Errorf("saml: expected exactly one reference, found %d", len(references))
+	}
+	reference := references[0]
+	if id := el.attr("ID"); id == "" || reference.attr("URI") != "#"+id {
+		return errors.New("saml: signature does not reference the signed element")
+	}
+
+	var referencePrefixes []string
+	var canonicalized bool
+	if transforms := reference.child(dsigNamespace, "Transforms"); transforms != nil {
+		for _, transform := range transforms.childrenNamed(dsigNamespace, "Transform") {
+			switch transform.attr("Algorithm") {
+			case envelopedSignatureAlgorithm:
+			case exclusiveC14NAlgorithm:
+				canonicalized = true
+				referencePrefixes = inclusivePrefixes(transform)
+			default:
+				return fmt.Errorf("saml: unsupported transform %s", transform.attr("Algorithm"))
+			}
+		}
+	}
+	if !canonicalized {
+		return errors.New("saml: reference is not canonicalized")
+	}
+
+	digestMethod := reference.child(dsigNamespace, "DigestMethod")
+	if digestMethod == nil {
+		return errors.New("saml: reference is missing digest method")
+	}
+	digestHash, ok := digestMethods[digestMethod.attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("saml: unsupported digest method %s", digestMethod.attr("Algorithm"))
+	}
+	digestValue := reference.child(dsigNamespace, "DigestValue")
+	if digestValue == nil {
+		return errors.New("saml: reference is missing digest value")
+	}
+	expectedDigest, err := decodeBase64(digestValue.text())
+	if err != nil {
+		return fmt.Errorf("saml: error decoding digest value: %w", err)
+	}
+	h := digestHash.New()
+	h.Write(canonicalize(el, referencePrefixes, signature))
+	if subtle.ConstantTimeCompare(h.Sum(nil), expectedDigest) != 1 {
+		return errors.New("saml: digest of signed element did not match")
+	}
+
+	signatureValue := signature.child(dsigNamespace, "SignatureValue")
+	if signatureValue == nil {
+		return errors.New("saml: signature is missing signature value")
+	}
+	sig, err := decodeBase64(signatureValue.text())
+	if err != nil {
+		return fmt.Errorf("saml: error decoding signature value: %w", err)
+	}
+	h = signatureHash.New()
+	h.Write(canonicalize(signedInfo, inclusivePrefixes(c14nMethod), nil))
+	hashed := h.Sum(nil)
+
+	for _, cert := range certificates {
+		switch pub := cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(pub, signatureHash, hashed, sig) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			// XML signatures encode ECDSA signatures as the concatenation
+			// of r and s instead of using ASN.1
+			if len(sig)%2 != 0 {
+				continue
+			}
+			r := new(big.Int).SetBytes(sig[:len(sig)/2])
+			s := new(big.Int).SetBytes(sig[len(sig)/2:])
+			if ecdsa.Verify(pub, hashed, r, s) {
+				return nil
+			}
+		}
+	}
+	return errors.New("saml: signature could not be verified using any of the configured certificates")
+}
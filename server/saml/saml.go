@@ -0,0 +1,400 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package saml implements a SAML 2.0 service provider using the HTTP-Redirect
+// binding for authentication requests and the HTTP-POST binding for
+// responses. Signatures are expected to use exclusive canonicalization and
+// are verified against the configured certificates of the identity provider.
+// Encrypted assertions are not supported.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/offen/offen/server/keys"
+)
+
+const (
+	protocolNamespace  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	assertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+	metadataNamespace  = "urn:oasis:names:tc:SAML:2.0:metadata"
+
+	statusSuccess      = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	bearerConfirmation = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	bindingHTTPPost    = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	nameIDFormatEmail  = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+)
+
+// clockSkew is the leeway applied when checking validity periods.
+const clockSkew = 3 * time.Minute
+
+// ServiceProvider authenticates users with a single identity provider.
+type ServiceProvider struct {
+	entityID        string
+	acsURL          string
+	idpSSOURL       string
+	idpEntityID     string
+	idpCertificates []*x509.Certificate
+	now             func() time.Time
+}
+
+// Option is used to configure a ServiceProvider.
+type Option func(*ServiceProvider)
+
+// WithIdentityProviderEntityID requires assertions to be issued by the given
+// entity.
+func WithIdentityProviderEntityID(id string) Option {
+	return func(s *ServiceProvider) {
+		s.idpEntityID = id
+	}
+}
+
+// New creates a service provider of the given entity id that receives
+// responses at the given assertion consumer service URL. Users are sent to
+// the given single sign-on URL of the identity provider, whose responses
+// need to be signed by one of the given certificates.
+func New(entityID, acsURL, idpSSOURL string, idpCertificates []*x509.Certificate, opts ...Option) *ServiceProvider {
+	s := &ServiceProvider{
+		entityID:        entityID,
+		acsURL:          acsURL,
+		idpSSOURL:       idpSSOURL,
+		idpCertificates: idpCertificates,
+		now:             time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// ParseCertificates parses all PEM encoded certificates in the given data.
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("saml: error parsing certificate: %w", err)
+		}
+		certificates = append(certificates, cert)
+	}
+	if len(certificates) == 0 {
+		return nil, errors.New("saml: no certificates found")
+	}
+	return certificates, nil
+}
+
+type metadata struct {
+	XMLName         xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string   `xml:"entityID,attr"`
+	SPSSODescriptor struct {
+		ProtocolSupportEnumeration string `xml:"protocolSupportEnumeration,attr"`
+		AuthnRequestsSigned        bool   `xml:"AuthnRequestsSigned,attr"`
+		WantAssertionsSigned       bool   `xml:"WantAssertionsSigned,attr"`
+		NameIDFormat               string `xml:"NameIDFormat"`
+		AssertionConsumerService   struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+			Index    int    `xml:"index,attr"`
+		} `xml:"AssertionConsumerService"`
+	} `xml:"SPSSODescriptor"`
+}
+
+// Metadata returns the metadata document describing the service provider.
+func (s *ServiceProvider) Metadata() ([]byte, error) {
+	m := metadata{EntityID: s.entityID}
+	m.SPSSODescriptor.ProtocolSupportEnumeration = protocolNamespace
+	m.SPSSODescriptor.WantAssertionsSigned = true
+	m.SPSSODescriptor.NameIDFormat = nameIDFormatEmail
+	m.SPSSODescriptor.AssertionConsumerService.Binding = bindingHTTPPost
+	m.SPSSODescriptor.AssertionConsumerService.Location = s.acsURL
+	b, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("saml: error encoding metadata: %w", err)
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+type authnRequest struct {
+	XMLName                     xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string   `xml:"ID,attr"`
+	Version                     string   `xml:"Version,attr"`
+	IssueInstant                string   `xml:"IssueInstant,attr"`
+	Destination                 string   `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string   `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string   `xml:"ProtocolBinding,attr"`
+	Issuer                      struct {
+		XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+		Value   string   `xml:",chardata"`
+	}
+	NameIDPolicy struct {
+		Format      string `xml:"Format,attr"`
+		AllowCreate bool   `xml:"AllowCreate,attr"`
+	} `xml:"NameIDPolicy"`
+}
+
+// NewRequestID creates a random id for an authentication request.
+func NewRequestID() (string, error) {
+	b, err := keys.GenerateRandomBytes(20)
+	if err != nil {
+		return "", fmt.Errorf("saml: error creating request id: %w", err)
+	}
+	// ids need to start with a letter
+	return "id-" + hex.EncodeToString(b), nil
+}
+
+// AuthnRequestURL returns the URL users need to be redirected to for
+// authenticating with the identity provider. The response is expected to
+// reference the given request id.
+func (s *ServiceProvider) AuthnRequestURL(id, relayState string) (string, error) {
+	req := authnRequest{
+		ID:                          id,
+		Version:                     "2.0",
+		IssueInstant:                s.now().UTC().Format(time.RFC3339),
+		Destination:                 s.idpSSOURL,
+		AssertionConsumerServiceURL: s.acsURL,
+		ProtocolBinding:             bindingHTTPPost,
+	}
+	req.Issuer.Value = s.entityID
+	req.NameIDPolicy.Format = nameIDFormatEmail
+	req.NameIDPolicy.AllowCreate = true
+
+	b, err := xml.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("saml: error encoding request: %w", err)
+	}
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	w.Write(b)
+	w.Close()
+
+	u, err := url.Parse(s.idpSSOURL)
+	if err != nil {
+		return "", fmt.Errorf("saml: error parsing single sign-on url: %w", err)
+	}
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(buf.Bytes()))
+	if relayState != "" {
+		q.Set("RelayState", relayState)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Assertion contains the subject and the attributes asserted by the
+// identity provider.
+type Assertion struct {
+	NameID     string
+	Attributes map[string][]string
+}
+
+// Attribute returns the first value of the attribute of the given name.
+func (a *Assertion) Attribute(name string) string {
+	if values := a.Attributes[name]; len(values) != 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// HasAttributeValue checks whether the attribute of the given name contains
+// the given value.
+func (a *Assertion) HasAttributeValue(name, value string) bool {
+	for _, v := range a.Attributes[name] {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseResponse verifies the given base64 encoded response and returns the
+// assertion it contains. In case requestID is not empty, the response is
+// required to have been issued for this request. Either the response or the
+// assertion needs to be signed.
+func (s *ServiceProvider) ParseResponse(encodedResponse, requestID string) (*Assertion, error) {
+	raw, err := decodeBase64(encodedResponse)
+	if err != nil {
+		return nil, fmt.Errorf("saml: error decoding response: %w", err)
+	}
+	response, err := parseXML(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !response.is(protocolNamespace, "Response") {
+		return nil, errors.New("saml: document is not a response")
+	}
+	if err := checkUniqueIDs(response); err != nil {
+		return nil, err
+	}
+
+	if destination := response.attr("Destination"); destination != "" && destination != s.acsURL {
+		return nil, fmt.Errorf("saml: response has been sent to %s", destination)
+	}
+	if requestID != "" && response.attr("InResponseTo") != requestID {
+		return nil, errors.New("saml: response has not been issued for the given request")
+	}
+	status := response.child(protocolNamespace, "Status")
+	if status == nil {
+		return nil, errors.New("saml: response is missing status")
+	}
+	if code := status.child(protocolNamespace, "StatusCode"); code == nil || code.attr("Value") != statusSuccess {
+		return nil, errors.New("saml: response does not indicate success")
+	}
+
+	if len(response.childrenNamed(assertionNamespace, "EncryptedAssertion")) != 0 {
+		return nil, errors.New("saml: encrypted assertions are not supported")
+	}
+	assertions := response.childrenNamed(assertionNamespace, "Assertion")
+	if len(assertions) != 1 {
+		return nil, fmt.Errorf("saml: expected exactly one assertion, found %d", len(assertions))
+	}
+	assertion := assertions[0]
+
+	// only content of signed elements is ever read, so that unsigned content
+	// cannot be injected into a signed document
+	var signed bool
+	if response.child(dsigNamespace, "Signature") != nil {
+		if err := verifySignature(response, s.idpCertificates); err != nil {
+			return nil, err
+		}
+		signed = true
+	}
+	if assertion.child(dsigNamespace, "Signature") != nil {
+		if err := verifySignature(assertion, s.idpCertificates); err != nil {
+			return nil, err
+		}
+		signed = true
+	}
+	if !signed {
+		return nil, errors.New("saml: neither response nor assertion are signed")
+	}
+
+	return s.validateAssertion(assertion, requestID)
+}
+
+func (s *ServiceProvider) validateAssertion(assertion *element, requestID string) (*Assertion, error) {
+	now := s.now()
+	if s.idpEntityID != "" {
+		if issuer := assertion.child(assertionNamespace, "Issuer"); issuer == nil || issuer.text() != s.idpEntityID {
+			return nil, errors.New("saml: assertion has been issued by an unknown entity")
+		}
+	}
+
+	conditions := assertion.child(assertionNamespace, "Conditions")
+	if conditions == nil {
+		return nil, errors.New("saml: assertion is missing conditions")
+	}
+	if err := checkValidity(conditions, now); err != nil {
+		return nil, err
+	}
+	var audienceMatch bool
+	for _, restriction := range conditions.childrenNamed(assertionNamespace, "AudienceRestriction") {
+		for _, audience := range restriction.childrenNamed(assertionNamespace, "Audience") {
+			if audience.text() == s.entityID {
+				audienceMatch = true
+			}
+		}
+	}
+	if !audienceMatch {
+		return nil, errors.New("saml: assertion has not been issued for this service provider")
+	}
+
+	subject := assertion.child(assertionNamespace, "Subject")
+	if subject == nil {
+		return nil, errors.New("saml: assertion is missing subject")
+	}
+	var confirmed bool
+	for _, confirmation := range subject.childrenNamed(assertionNamespace, "SubjectConfirmation") {
+		if confirmation.attr("Method") != bearerConfirmation {
+			continue
+		}
+		data := confirmation.child(assertionNamespace, "SubjectConfirmationData")
+		if data == nil || data.attr("Recipient") != s.acsURL {
+			continue
+		}
+		if requestID != "" && data.attr("InResponseTo") != requestID {
+			continue
+		}
+		if checkValidity(data, now) != nil || data.attr("NotOnOrAfter") == "" {
+			continue
+		}
+		confirmed = true
+	}
+	if !confirmed {
+		return nil, errors.New("saml: subject could not be confirmed")
+	}
+
+	result := &Assertion{Attributes: map[string][]string{}}
+	if nameID := subject.child(assertionNamespace, "NameID"); nameID != nil {
+		result.NameID = nameID.text()
+	}
+	for _, statement := range assertion.childrenNamed(assertionNamespace, "AttributeStatement") {
+		for _, attribute := range statement.childrenNamed(assertionNamespace, "Attribute") {
+			name := attribute.attr("Name")
+			for _, value := range attribute.childrenNamed(assertionNamespace, "AttributeValue") {
+				result.Attributes[name] = append(result.Attributes[name], value.text())
+			}
+		}
+	}
+	return result, nil
+}
+
+// checkValidity checks the NotBefore and NotOnOrAfter attributes of the
+// given element in case they are present.
+func checkValidity(el *element, now time.Time) error {
+	if value := el.attr("NotBefore"); value != "" {
+		notBefore, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("saml: error parsing timestamp: %w", err)
+		}
+		if now.Add(clockSkew).Before(notBefore) {
+			return errors.New("saml: assertion is not valid yet")
+		}
+	}
+	if value := el.attr("NotOnOrAfter"); value != "" {
+		notOnOrAfter, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("saml: error parsing timestamp: %w", err)
+		}
+		if !now.Add(-clockSkew).Before(notOnOrAfter) {
+			return errors.New("saml: assertion has expired")
+		}
+	}
+	return nil
+}
+
+// checkUniqueIDs makes sure no two elements in the document use the same
+// id, which could otherwise be used for pointing a signature reference to
+// other content than is being read.
+func checkUniqueIDs(root *element) error {
+	seen := map[string]bool{}
+	var err error
+	root.walk(func(el *element) {
+		id := el.attr("ID")
+		if id == "" {
+			return
+		}
+		if seen[id] {
+			err = fmt.Errorf("saml: document contains duplicate id %s", id)
+		}
+		seen[id] = true
+	})
+	return err
+}
@@ -0,0 +1,305 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testEntityID = "https://offen.example.com/api/saml/metadata"
+	testACSURL   = "https://offen.example.com/api/login/saml/acs"
+	testSSOURL   = "https://idp.example.com/sso"
+)
+
+func newTestCertificate(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return key, cert
+}
+
+// sign inserts an enveloped signature for the element with the given id at
+// the signature placeholder of the given document.
+func sign(t *testing.T, document, id string, key *rsa.PrivateKey) string {
+	root, err := parseXML([]byte(strings.Replace(document, "{{signature}}", "", 1)))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	var target *element
+	root.walk(func(el *element) {
+		if el.attr("ID") == id {
+			target = el
+		}
+	})
+	digest := sha256.Sum256(canonicalize(target, nil, nil))
+
+	signedInfo := fmt.Sprintf(
+		`<ds:SignedInfo xmlns:ds="%s"><ds:CanonicalizationMethod Algorithm="%s"/><ds:SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"/><ds:Reference URI="#%s"><ds:Transforms><ds:Transform Algorithm="%s"/><ds:Transform Algorithm="%s"/></ds:Transforms><ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"/><ds:DigestValue>%s</ds:DigestValue></ds:Reference></ds:SignedInfo>`,
+		dsigNamespace, exclusiveC14NAlgorithm, id, envelopedSignatureAlgorithm, exclusiveC14NAlgorithm, base64.StdEncoding.EncodeToString(digest[:]),
+	)
+	signedInfoElement, _ := parseXML([]byte(signedInfo))
+	hashed := sha256.Sum256(canonicalize(signedInfoElement, nil, nil))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	signature := fmt.Sprintf(
+		`<ds:Signature xmlns:ds="%s">%s<ds:SignatureValue>%s</ds:SignatureValue></ds:Signature>`,
+		dsigNamespace, signedInfo, base64.StdEncoding.EncodeToString(sig),
+	)
+	return strings.Replace(document, "{{signature}}", signature, 1)
+}
+
+type responseValues struct {
+	Destination  string
+	InResponseTo string
+	Audience     string
+	NotOnOrAfter string
+	Email        string
+}
+
+func (r responseValues) document() string {
+	return fmt.Sprintf(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="response-a" Version="2.0" Destination="%s" InResponseTo="%s">
+  <saml:Issuer>https://idp.example.com</saml:Issuer>
+  <samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>
+  <saml:Assertion xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" ID="assertion-a" Version="2.0">
+    <saml:Issuer>https://idp.example.com</saml:Issuer>{{signature}}
+    <saml:Subject>
+      <saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">%s</saml:NameID>
+      <saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+        <saml:SubjectConfirmationData NotOnOrAfter="%s" Recipient="%s" InResponseTo="%s"/>
+      </saml:SubjectConfirmation>
+    </saml:Subject>
+    <saml:Conditions NotOnOrAfter="%s">
+      <saml:AudienceRestriction><saml:Audience>%s</saml:Audience></saml:AudienceRestriction>
+    </saml:Conditions>
+    <saml:AttributeStatement>
+      <saml:Attribute Name="role"><saml:AttributeValue xsi:type="xs:string">admin</saml:AttributeValue><saml:AttributeValue xsi:type="xs:string">viewer</saml:AttributeValue></saml:Attribute>
+    </saml:AttributeStatement>
+  </saml:Assertion>
+</samlp:Response>`, r.Destination, r.InResponseTo, r.Email, r.NotOnOrAfter, testACSURL, r.InResponseTo, r.NotOnOrAfter, r.Audience)
+}
+
+func defaultResponseValues() responseValues {
+	return responseValues{
+		Destination:  testACSURL,
+		InResponseTo: "request-a",
+		Audience:     testEntityID,
+		NotOnOrAfter: time.Now().Add(time.Minute * 5).UTC().Format(time.RFC3339),
+		Email:        "develop@offen.dev",
+	}
+}
+
+func TestServiceProvider_ParseResponse(t *testing.T) {
+	key, cert := newTestCertificate(t)
+	otherKey, _ := newTestCertificate(t)
+
+	encode := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	signed := func(modify func(*responseValues)) string {
+		values := defaultResponseValues()
+		modify(&values)
+		return encode(sign(t, values.document(), "assertion-a", key))
+	}
+
+	tests := []struct {
+		name           string
+		response       string
+		expectError    bool
+		expectedResult *Assertion
+	}{
+		{
+			"ok",
+			signed(func(*responseValues) {}),
+			false,
+			&Assertion{NameID: "develop@offen.dev", Attributes: map[string][]string{"role": {"admin", "viewer"}}},
+		},
+		{
+			"signed response",
+			encode(sign(t, strings.Replace(
+				strings.Replace(defaultResponseValues().document(), "{{signature}}", "", 1),
+				"<samlp:Status>", "{{signature}}<samlp:Status>", 1,
+			), "response-a", key)),
+			false,
+			&Assertion{NameID: "develop@offen.dev", Attributes: map[string][]string{"role": {"admin", "viewer"}}},
+		},
+		{
+			"unsigned",
+			encode(strings.Replace(defaultResponseValues().document(), "{{signature}}", "", 1)),
+			true,
+			nil,
+		},
+		{
+			"other key",
+			encode(sign(t, defaultResponseValues().document(), "assertion-a", otherKey)),
+			true,
+			nil,
+		},
+		{
+			"tampered",
+			encode(strings.Replace(
+				sign(t, defaultResponseValues().document(), "assertion-a", key),
+				">develop@offen.dev<", ">other@offen.dev<", 1,
+			)),
+			true,
+			nil,
+		},
+		{
+			"wrapped",
+			encode(strings.Replace(
+				sign(t, defaultResponseValues().document(), "assertion-a", key),
+				"<samlp:Status>", `<samlp:Extensions><saml:Assertion ID="assertion-a"/></samlp:Extensions><samlp:Status>`, 1,
+			)),
+			true,
+			nil,
+		},
+		{
+			"other audience",
+			signed(func(r *responseValues) { r.Audience = "https://other.example.com" }),
+			true,
+			nil,
+		},
+		{
+			"other destination",
+			signed(func(r *responseValues) { r.Destination = "https://other.example.com" }),
+			true,
+			nil,
+		},
+		{
+			"other request",
+			signed(func(r *responseValues) { r.InResponseTo = "request-b" }),
+			true,
+			nil,
+		},
+		{
+			"expired",
+			signed(func(r *responseValues) {
+				r.NotOnOrAfter = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+			}),
+			true,
+			nil,
+		},
+		{
+			"bad encoding",
+			"<xml>",
+			true,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sp := New(testEntityID, testACSURL, testSSOURL, []*x509.Certificate{cert}, WithIdentityProviderEntityID("https://idp.example.com"))
+			result, err := sp.ParseResponse(test.response, "request-a")
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if !reflect.DeepEqual(test.expectedResult, result) {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestServiceProvider_AuthnRequestURL(t *testing.T) {
+	sp := New(testEntityID, testACSURL, testSSOURL+"?tenant=a", nil)
+	id, err := NewRequestID()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	result, err := sp.AuthnRequestURL(id, "state")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	u, _ := url.Parse(result)
+	if u.Host != "idp.example.com" || u.Query().Get("tenant") != "a" || u.Query().Get("RelayState") != "state" {
+		t.Errorf("Unexpected url %s", result)
+	}
+	compressed, _ := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	request, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	root, err := parseXML(request)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !root.is(protocolNamespace, "AuthnRequest") || root.attr("ID") != id || root.attr("AssertionConsumerServiceURL") != testACSURL {
+		t.Errorf("Unexpected request %s", request)
+	}
+	if issuer := root.child(assertionNamespace, "Issuer"); issuer == nil || issuer.text() != testEntityID {
+		t.Errorf("Unexpected request %s", request)
+	}
+}
+
+func TestServiceProvider_Metadata(t *testing.T) {
+	sp := New(testEntityID, testACSURL, testSSOURL, nil)
+	b, err := sp.Metadata()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	root, err := parseXML(b)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !root.is(metadataNamespace, "EntityDescriptor") || root.attr("entityID") != testEntityID {
+		t.Errorf("Unexpected metadata %s", b)
+	}
+	descriptor := root.child(metadataNamespace, "SPSSODescriptor")
+	if descriptor == nil {
+		t.Fatalf("Unexpected metadata %s", b)
+	}
+	if acs := descriptor.child(metadataNamespace, "AssertionConsumerService"); acs == nil || acs.attr("Location") != testACSURL {
+		t.Errorf("Unexpected metadata %s", b)
+	}
+}
+
+func TestParseCertificates(t *testing.T) {
+	_, cert := newTestCertificate(t)
+	_, otherCert := newTestCertificate(t)
+	data := append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCert.Raw})...,
+	)
+	result, err := ParseCertificates(data)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result) != 2 {
+		t.Errorf("Unexpected result %v", result)
+	}
+	if _, err := ParseCertificates([]byte("abc")); err == nil {
+		t.Error("Expected error parsing bad data")
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// element is a minimal DOM node that keeps namespace prefixes as they appear
+// in the source document, which is required for canonicalizing signed
+// content. Children are either of type *element or string.
+type element struct {
+	prefix   string
+	local    string
+	attrs    []xml.Attr
+	children []interface{}
+	parent   *element
+}
+
+func parseXML(b []byte) (*element, error) {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	var root, current *element
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("saml: error parsing document: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			el := &element{
+				prefix: t.Name.Space,
+				local:  t.Name.Local,
+				attrs:  append([]xml.Attr{}, t.Attr...),
+				parent: current,
+			}
+			if current == nil {
+				if root != nil {
+					return nil, errors.New("saml: document has multiple root elements")
+				}
+				root = el
+			} else {
+				current.children = append(current.children, el)
+			}
+			current = el
+		case xml.EndElement:
+			if current == nil || current.prefix != t.Name.Space || current.local != t.Name.Local {
+				return nil, fmt.Errorf("saml: unexpected end element %s", t.Name.Local)
+			}
+			current = current.parent
+		case xml.CharData:
+			if current != nil {
+				current.children = append(current.children, string(t))
+			}
+		case xml.Directive:
+			// document type declarations are never expected in SAML messages
+			// and are rejected for not having to deal with entity expansion
+			return nil, errors.New("saml: document contains unsupported directive")
+		}
+	}
+	if root == nil || current != nil {
+		return nil, errors.New("saml: document is incomplete")
+	}
+	return root, nil
+}
+
+func isNamespaceDeclaration(a xml.Attr) bool {
+	return a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns")
+}
+
+// lookupNamespace returns the namespace URI bound to the given prefix in the
+// scope of the element.
+func (e *element) lookupNamespace(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlNamespace, true
+	}
+	for el := e; el != nil; el = el.parent {
+		for _, a := range el.attrs {
+			if prefix == "" && a.Name.Space == "" && a.Name.Local == "xmlns" {
+				return a.Value, true
+			}
+			if prefix != "" && a.Name.Space == "xmlns" && a.Name.Local == prefix {
+				return a.Value, true
+			}
+		}
+	}
+	return "", prefix == ""
+}
+
+func (e *element) namespace() string {
+	ns, _ := e.lookupNamespace(e.prefix)
+	return ns
+}
+
+func (e *element) is(namespace, local string) bool {
+	return e.local == local && e.namespace() == namespace
+}
+
+// attr returns the value of the unqualified attribute of the given name.
+func (e *element) attr(local string) string {
+	for _, a := range e.attrs {
+		if a.Name.Space == "" && a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (e *element) childrenNamed(namespace, local string) []*element {
+	var result []*element
+	for _, c := range e.children {
+		if el, ok := c.(*element); ok && el.is(namespace, local) {
+			result = append(result, el)
+		}
+	}
+	return result
+}
+
+func (e *element) child(namespace, local string) *element {
+	if children := e.childrenNamed(namespace, local); len(children) != 0 {
+		return children[0]
+	}
+	return nil
+}
+
+// text returns the trimmed character data contained in the element.
+func (e *element) text() string {
+	var b strings.Builder
+	for _, c := range e.children {
+		if s, ok := c.(string); ok {
+			b.WriteString(s)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// walk calls fn for the element and all of its descendants.
+func (e *element) walk(fn func(*element)) {
+	fn(e)
+	for _, c := range e.children {
+		if el, ok := c.(*element); ok {
+			el.walk(fn)
+		}
+	}
+}
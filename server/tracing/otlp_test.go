@@ -0,0 +1,85 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOTLPExporter_Export(t *testing.T) {
+	var received map[string]interface{}
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	exporter := NewOTLPExporter(server.URL+"/", "offen-test")
+	err := exporter.Export([]SpanData{
+		{
+			Name:         "gorm.query",
+			Kind:         SpanKindClient,
+			Context:      SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Sampled: true},
+			ParentSpanID: SpanID{3},
+			Start:        time.Unix(1, 0),
+			End:          time.Unix(2, 0),
+			Attributes:   map[string]interface{}{"db.system": "sqlite3", "rows": 12},
+			Err:          errors.New("did not work"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if path != "/v1/traces" {
+		t.Errorf("Unexpected path %s", path)
+	}
+
+	resourceSpans := received["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	service := resourceSpans["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	if service["value"].(map[string]interface{})["stringValue"] != "offen-test" {
+		t.Errorf("Unexpected service attribute %v", service)
+	}
+	span := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+	expected := map[string]interface{}{
+		"traceId":           "01000000000000000000000000000000",
+		"spanId":            "0200000000000000",
+		"parentSpanId":      "0300000000000000",
+		"name":              "gorm.query",
+		"kind":              float64(3),
+		"startTimeUnixNano": "1000000000",
+		"endTimeUnixNano":   "2000000000",
+	}
+	for key, value := range expected {
+		if span[key] != value {
+			t.Errorf("Expected %s to be %v, got %v", key, value, span[key])
+		}
+	}
+	status := span["status"].(map[string]interface{})
+	if status["code"] != float64(2) || status["message"] != "did not work" {
+		t.Errorf("Unexpected status %v", status)
+	}
+	attributes := span["attributes"].([]interface{})
+	rows := attributes[1].(map[string]interface{})
+	if rows["key"] != "rows" || rows["value"].(map[string]interface{})["intValue"] != "12" {
+		t.Errorf("Unexpected attribute %v", rows)
+	}
+}
+
+func TestOTLPExporter_ExportError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	exporter := NewOTLPExporter(server.URL+"/v1/traces", "offen")
+	if err := exporter.Export([]SpanData{{Name: "span"}}); err == nil {
+		t.Error("Expected error, got nil")
+	}
+}
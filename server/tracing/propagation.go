@@ -0,0 +1,83 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader is the header used for propagating span contexts as
+// defined by W3C Trace Context.
+const TraceparentHeader = "traceparent"
+
+const flagSampled = 0x01
+
+// ParseTraceparent parses the value of a traceparent header.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return sc, fmt.Errorf("tracing: malformed traceparent %q", value)
+	}
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || len(version) != 1 || version[0] == 0xff {
+		return sc, fmt.Errorf("tracing: invalid version in traceparent %q", value)
+	}
+	// future versions may append fields, version 00 must not
+	if version[0] == 0 && len(parts) != 4 {
+		return sc, fmt.Errorf("tracing: malformed traceparent %q", value)
+	}
+	if err := decodeID(sc.TraceID[:], parts[1]); err != nil || !sc.TraceID.IsValid() {
+		return sc, fmt.Errorf("tracing: invalid trace id in traceparent %q", value)
+	}
+	if err := decodeID(sc.SpanID[:], parts[2]); err != nil || !sc.SpanID.IsValid() {
+		return sc, fmt.Errorf("tracing: invalid parent id in traceparent %q", value)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return sc, fmt.Errorf("tracing: invalid flags in traceparent %q", value)
+	}
+	sc.Sampled = flags[0]&flagSampled == flagSampled
+	return sc, nil
+}
+
+func decodeID(dst []byte, value string) error {
+	if len(value) != hex.EncodedLen(len(dst)) || strings.ToLower(value) != value {
+		return fmt.Errorf("tracing: unexpected id %q", value)
+	}
+	_, err := hex.Decode(dst, []byte(value))
+	return err
+}
+
+// Traceparent formats the span context as the value of a traceparent header.
+func (s SpanContext) Traceparent() string {
+	var flags byte
+	if s.Sampled {
+		flags |= flagSampled
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", s.TraceID, s.SpanID, flags)
+}
+
+// Extract returns a context containing the span context propagated in the
+// given headers so spans started from it continue the remote trace. Invalid
+// headers are ignored.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKeyRemote, sc)
+}
+
+// Inject adds the span context of the span in the given context to the
+// given headers.
+func Inject(ctx context.Context, header http.Header) {
+	if sc := SpanFromContext(ctx).Context(); sc.IsValid() {
+		header.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
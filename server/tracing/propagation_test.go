@@ -0,0 +1,115 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name           string
+		value          string
+		expectedResult SpanContext
+		expectError    bool
+	}{
+		{
+			"sampled",
+			"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+			SpanContext{
+				TraceID: TraceID{0x0a, 0xf7, 0x65, 0x19, 0x16, 0xcd, 0x43, 0xdd, 0x84, 0x48, 0xeb, 0x21, 0x1c, 0x80, 0x31, 0x9c},
+				SpanID:  SpanID{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31},
+				Sampled: true,
+			},
+			false,
+		},
+		{
+			"not sampled",
+			"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00",
+			SpanContext{
+				TraceID: TraceID{0x0a, 0xf7, 0x65, 0x19, 0x16, 0xcd, 0x43, 0xdd, 0x84, 0x48, 0xeb, 0x21, 0x1c, 0x80, 0x31, 0x9c},
+				SpanID:  SpanID{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31},
+			},
+			false,
+		},
+		{
+			"future version",
+			"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra",
+			SpanContext{
+				TraceID: TraceID{0x0a, 0xf7, 0x65, 0x19, 0x16, 0xcd, 0x43, 0xdd, 0x84, 0x48, 0xeb, 0x21, 0x1c, 0x80, 0x31, 0x9c},
+				SpanID:  SpanID{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31},
+				Sampled: true,
+			},
+			false,
+		},
+		{
+			"empty",
+			"",
+			SpanContext{},
+			true,
+		},
+		{
+			"extra fields in version 00",
+			"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra",
+			SpanContext{},
+			true,
+		},
+		{
+			"zero trace id",
+			"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+			SpanContext{},
+			true,
+		},
+		{
+			"uppercase",
+			"00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01",
+			SpanContext{},
+			true,
+		},
+		{
+			"short span id",
+			"00-0af7651916cd43dd8448eb211c80319c-b7ad6b71-01",
+			SpanContext{},
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := ParseTraceparent(test.value)
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if !test.expectError && !reflect.DeepEqual(result, test.expectedResult) {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestExtractInject(t *testing.T) {
+	value := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	incoming := http.Header{}
+	incoming.Set(TraceparentHeader, value)
+
+	tracer := New(&mockExporter{})
+	defer tracer.Shutdown(context.Background())
+
+	ctx, span := tracer.Start(Extract(context.Background(), incoming), "span", SpanKindServer)
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+
+	propagated, err := ParseTraceparent(outgoing.Get(TraceparentHeader))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if propagated != span.Context() {
+		t.Errorf("Expected %v, got %v", span.Context(), propagated)
+	}
+	if propagated.TraceID.String() != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("Unexpected trace id %v", propagated.TraceID)
+	}
+}
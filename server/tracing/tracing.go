@@ -0,0 +1,361 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package tracing implements spans that follow the OpenTelemetry data model.
+// Span contexts are propagated using the W3C Trace Context format and
+// finished spans are exported in batches, e.g. to an OTLP collector.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// IsValid returns false for the all-zero trace id.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// IsValid returns false for the all-zero span id.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext is the part of a span that is propagated to child spans,
+// both in-process and across service boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid checks whether both trace and span id are set.
+func (s SpanContext) IsValid() bool {
+	return s.TraceID.IsValid() && s.SpanID.IsValid()
+}
+
+// SpanKind describes the relationship of a span to its surroundings. Values
+// match the ones used by OTLP.
+type SpanKind int
+
+// The supported kinds of spans.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// SpanData is the snapshot of a finished span that is handed to exporters.
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	Context      SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	Err          error
+}
+
+// Span is a traced operation. All methods are safe to be called on a nil
+// span, which is what is returned when tracing is disabled.
+type Span struct {
+	tracer *Tracer
+	lock   sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// Context returns the span context of the span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetAttribute adds the given key value pair to the span. Values are
+// expected to be strings, integers, floats or booleans.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Attributes[key] = value
+}
+
+// RecordError marks the span as failed. Passing a nil error is a no-op.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Err = err
+}
+
+// End finishes the span. Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.lock.Unlock()
+
+	if data.Context.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
+
+// Exporter sends finished spans to a backend.
+type Exporter interface {
+	Export(spans []SpanData) error
+}
+
+// Tracer creates spans and exports them in batches once they have ended.
+type Tracer struct {
+	exporter     Exporter
+	sampleRatio  float64
+	batchSize    int
+	batchTimeout time.Duration
+	logger       *logrus.Logger
+	queue        chan SpanData
+	stop         chan struct{}
+	stopOnce     sync.Once
+	done         chan struct{}
+}
+
+// Option is used to configure a tracer
+type Option func(*Tracer)
+
+// WithSampleRatio sets the share of traces that is recorded. Child spans
+// always follow the decision of their parent.
+func WithSampleRatio(ratio float64) Option {
+	return func(t *Tracer) {
+		t.sampleRatio = ratio
+	}
+}
+
+// WithBatchTimeout sets the maximum duration finished spans are held
+// before they are exported.
+func WithBatchTimeout(d time.Duration) Option {
+	return func(t *Tracer) {
+		t.batchTimeout = d
+	}
+}
+
+// WithLogger sets the logger used for reporting failed exports.
+func WithLogger(logger *logrus.Logger) Option {
+	return func(t *Tracer) {
+		t.logger = logger
+	}
+}
+
+// New creates a tracer that passes finished spans to the given exporter.
+// Callers are expected to call Shutdown so that pending spans are exported.
+func New(exporter Exporter, opts ...Option) *Tracer {
+	t := &Tracer{
+		exporter:     exporter,
+		sampleRatio:  1,
+		batchSize:    512,
+		batchTimeout: 5 * time.Second,
+		logger:       logrus.New(),
+		queue:        make(chan SpanData, 2048),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	go t.run()
+	return t
+}
+
+// Start creates a new span that is a child of the span found in the given
+// context, or of the remote span context extracted from an incoming request.
+// The returned context contains the new span. A nil tracer returns a nil
+// span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent := SpanFromContext(ctx).Context()
+	if !parent.IsValid() {
+		parent, _ = ctx.Value(contextKeyRemote).(SpanContext)
+	}
+
+	data := SpanData{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: map[string]interface{}{},
+	}
+	if parent.IsValid() {
+		data.Context.TraceID = parent.TraceID
+		data.Context.Sampled = parent.Sampled
+		data.ParentSpanID = parent.SpanID
+	} else {
+		data.Context.TraceID = newTraceID()
+		data.Context.Sampled = t.sample()
+	}
+	data.Context.SpanID = newSpanID()
+
+	span := &Span{tracer: t, data: data}
+	return context.WithValue(ctx, contextKeySpan, span), span
+}
+
+// Shutdown exports all pending spans and stops the tracer.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tracer) sample() bool {
+	switch {
+	case t.sampleRatio >= 1:
+		return true
+	case t.sampleRatio <= 0:
+		return false
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return float64(binary.BigEndian.Uint64(b[:])>>11)/(1<<53) < t.sampleRatio
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case t.queue <- data:
+	default:
+		// spans are dropped instead of blocking the application in case
+		// the exporter cannot keep up
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.batchTimeout)
+	defer ticker.Stop()
+
+	var batch []SpanData
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(batch); err != nil {
+			t.logger.WithError(err).WithField("spans", len(batch)).Warn("Error exporting spans")
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= t.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.stop:
+			for {
+				select {
+				case data := <-t.queue:
+					batch = append(batch, data)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+type contextKey int
+
+const (
+	contextKeySpan contextKey = iota
+	contextKeyRemote
+)
+
+// SpanFromContext returns the span stored in the given context or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(contextKeySpan).(*Span)
+	return span
+}
+
+var (
+	defaultTracer *Tracer
+	defaultLock   sync.RWMutex
+)
+
+// SetDefault sets the tracer used by Start. Passing nil disables tracing.
+func SetDefault(t *Tracer) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	defaultTracer = t
+}
+
+// Start creates a new span using the default tracer. In case no default
+// tracer is set, the context is returned as is alongside a nil span.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	defaultLock.RLock()
+	t := defaultTracer
+	defaultLock.RUnlock()
+	return t.Start(ctx, name, kind)
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockExporter struct {
+	lock  sync.Mutex
+	spans []SpanData
+	err   error
+}
+
+func (m *mockExporter) Export(spans []SpanData) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.spans = append(m.spans, spans...)
+	return m.err
+}
+
+func TestTracer_Start(t *testing.T) {
+	exporter := &mockExporter{}
+	tracer := New(exporter, WithBatchTimeout(time.Hour))
+
+	ctx, parent := tracer.Start(context.Background(), "parent", SpanKindServer)
+	_, child := tracer.Start(ctx, "child", SpanKindInternal)
+	child.SetAttribute("key", "value")
+	child.RecordError(errors.New("did not work"))
+	child.End()
+	child.End()
+	parent.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(exporter.spans) != 2 {
+		t.Fatalf("Unexpected number of exported spans %d", len(exporter.spans))
+	}
+	childData, parentData := exporter.spans[0], exporter.spans[1]
+	if childData.Context.TraceID != parentData.Context.TraceID {
+		t.Errorf("Expected child to share trace id with parent")
+	}
+	if childData.ParentSpanID != parentData.Context.SpanID {
+		t.Errorf("Unexpected parent span id %v", childData.ParentSpanID)
+	}
+	if parentData.ParentSpanID.IsValid() {
+		t.Errorf("Unexpected parent span id on root span %v", parentData.ParentSpanID)
+	}
+	if childData.Attributes["key"] != "value" {
+		t.Errorf("Unexpected attributes %v", childData.Attributes)
+	}
+	if childData.Err == nil {
+		t.Error("Expected error to be recorded")
+	}
+	if childData.End.Before(childData.Start) {
+		t.Errorf("Unexpected timing %v %v", childData.Start, childData.End)
+	}
+}
+
+func TestTracer_Sampling(t *testing.T) {
+	tests := []struct {
+		name          string
+		ratio         float64
+		remote        *SpanContext
+		expectSampled bool
+	}{
+		{
+			"always",
+			1,
+			nil,
+			true,
+		},
+		{
+			"never",
+			0,
+			nil,
+			false,
+		},
+		{
+			"sampled remote parent",
+			0,
+			&SpanContext{TraceID: TraceID{1}, SpanID: SpanID{1}, Sampled: true},
+			true,
+		},
+		{
+			"unsampled remote parent",
+			1,
+			&SpanContext{TraceID: TraceID{1}, SpanID: SpanID{1}, Sampled: false},
+			false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exporter := &mockExporter{}
+			tracer := New(exporter, WithSampleRatio(test.ratio))
+			ctx := context.Background()
+			if test.remote != nil {
+				ctx = context.WithValue(ctx, contextKeyRemote, *test.remote)
+			}
+			_, span := tracer.Start(ctx, "span", SpanKindServer)
+			span.End()
+			tracer.Shutdown(context.Background())
+
+			if span.Context().Sampled != test.expectSampled {
+				t.Errorf("Expected sampled to be %v", test.expectSampled)
+			}
+			if test.expectSampled != (len(exporter.spans) == 1) {
+				t.Errorf("Unexpected number of exported spans %d", len(exporter.spans))
+			}
+			if test.remote != nil && span.Context().TraceID != test.remote.TraceID {
+				t.Errorf("Unexpected trace id %v", span.Context().TraceID)
+			}
+		})
+	}
+}
+
+func TestStart_NoDefault(t *testing.T) {
+	ctx := context.Background()
+	nextCtx, span := Start(ctx, "span", SpanKindInternal)
+	if span != nil {
+		t.Errorf("Unexpected span %v", span)
+	}
+	if nextCtx != ctx {
+		t.Error("Expected context to be returned as is")
+	}
+	// calling methods on a nil span must not panic
+	span.SetAttribute("key", "value")
+	span.RecordError(errors.New("did not work"))
+	span.End()
+}
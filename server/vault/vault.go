@@ -0,0 +1,171 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client is a minimal client for the parts of the HashiCorp Vault HTTP API
+// that are used by Offen, i.e. reading secrets from the KV secrets engine,
+// encrypting values using the transit secrets engine and renewing its token.
+type Client struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+// New creates a client for the Vault server listening on the given address
+// that authenticates using the given token.
+func New(address, token string) *Client {
+	return &Client{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+type response struct {
+	Data json.RawMessage `json:"data"`
+	Auth *struct {
+		LeaseDuration int  `json:"lease_duration"`
+		Renewable     bool `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+func (c *Client) do(method, path string, payload interface{}) (*response, error) {
+	var body bytes.Buffer
+	if payload != nil {
+		if err := json.NewEncoder(&body).Encode(payload); err != nil {
+			return nil, fmt.Errorf("vault: error encoding payload: %w", err)
+		}
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/v1/%s", c.address, strings.TrimPrefix(path, "/")), &body)
+	if err != nil {
+		return nil, fmt.Errorf("vault: error creating request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", c.token)
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault: error performing request: %w", err)
+	}
+	defer res.Body.Close()
+
+	var result response
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("vault: error decoding response with status %d: %w", res.StatusCode, err)
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("vault: request to %s failed with status %d: %s", path, res.StatusCode, strings.Join(result.Errors, ", "))
+	}
+	return &result, nil
+}
+
+// ReadSecret reads the secret stored at the given path. Both versions of the
+// KV secrets engine are supported, i.e. for version 2 the path is expected to
+// contain the `data` segment.
+func (c *Client) ReadSecret(path string) (map[string]string, error) {
+	res, err := c.do(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("vault: error reading secret: %w", err)
+	}
+	var versioned struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(res.Data, &versioned); err == nil && versioned.Data != nil {
+		return versioned.Data, nil
+	}
+	var plain map[string]string
+	if err := json.Unmarshal(res.Data, &plain); err != nil {
+		return nil, fmt.Errorf("vault: error decoding secret: %w", err)
+	}
+	return plain, nil
+}
+
+// Encrypt encrypts the given plaintext using the transit key of the given
+// name and returns the resulting ciphertext.
+func (c *Client) Encrypt(keyName string, plaintext []byte) (string, error) {
+	res, err := c.do(http.MethodPost, fmt.Sprintf("transit/encrypt/%s", keyName), map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	})
+	if err != nil {
+		return "", fmt.Errorf("vault: error encrypting value: %w", err)
+	}
+	var data struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := json.Unmarshal(res.Data, &data); err != nil {
+		return "", fmt.Errorf("vault: error decoding ciphertext: %w", err)
+	}
+	return data.Ciphertext, nil
+}
+
+// Decrypt decrypts the given ciphertext using the transit key of the given
+// name.
+func (c *Client) Decrypt(keyName, ciphertext string) ([]byte, error) {
+	res, err := c.do(http.MethodPost, fmt.Sprintf("transit/decrypt/%s", keyName), map[string]string{
+		"ciphertext": ciphertext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("vault: error decrypting value: %w", err)
+	}
+	var data struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := json.Unmarshal(res.Data, &data); err != nil {
+		return nil, fmt.Errorf("vault: error decoding plaintext: %w", err)
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(data.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("vault: error decoding plaintext: %w", err)
+	}
+	return plaintext, nil
+}
+
+// RenewToken renews the lease of the client's token and returns the
+// resulting lease duration. A duration of zero signals that the token
+// does not expire or cannot be renewed.
+func (c *Client) RenewToken() (time.Duration, error) {
+	res, err := c.do(http.MethodPost, "auth/token/renew-self", nil)
+	if err != nil {
+		return 0, fmt.Errorf("vault: error renewing token: %w", err)
+	}
+	if res.Auth == nil || !res.Auth.Renewable {
+		return 0, nil
+	}
+	return time.Duration(res.Auth.LeaseDuration) * time.Second, nil
+}
+
+// KeepAlive renews the client's token each time half of its lease duration
+// has passed. It blocks until the given context is cancelled or the token
+// turns out not to be renewable. Errors are passed to handleErr and
+// renewal is retried after a minute.
+func (c *Client) KeepAlive(ctx context.Context, handleErr func(error)) {
+	for {
+		lease, err := c.RenewToken()
+		var wait time.Duration
+		switch {
+		case err != nil:
+			handleErr(err)
+			wait = time.Minute
+		case lease == 0:
+			return
+		default:
+			wait = lease / 2
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
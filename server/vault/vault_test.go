@@ -0,0 +1,147 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package vault
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClient_ReadSecret(t *testing.T) {
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		expectedResult map[string]string
+		expectError    bool
+	}{
+		{
+			"kv v1",
+			func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Vault-Token") != "token" {
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte(`{"errors":["permission denied"]}`))
+					return
+				}
+				w.Write([]byte(`{"data":{"secret":"value"}}`))
+			},
+			map[string]string{"secret": "value"},
+			false,
+		},
+		{
+			"kv v2",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"data":{"data":{"secret":"value"},"metadata":{"version":1}}}`))
+			},
+			map[string]string{"secret": "value"},
+			false,
+		},
+		{
+			"error response",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errors":[]}`))
+			},
+			nil,
+			true,
+		},
+		{
+			"bad response",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`<html>`))
+			},
+			nil,
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(test.handler)
+			defer srv.Close()
+			result, err := New(srv.URL, "token").ReadSecret("secret/data/offen")
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if !reflect.DeepEqual(test.expectedResult, result) {
+				t.Errorf("Expected %v, got %v", test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestClient_Transit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/offen":
+			w.Write([]byte(`{"data":{"ciphertext":"vault:v1:abc"}}`))
+		case "/v1/transit/decrypt/offen":
+			w.Write([]byte(`{"data":{"plaintext":"dmFsdWU="}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":["not found"]}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, "token")
+	ciphertext, err := c.Encrypt("offen", []byte("value"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if ciphertext != "vault:v1:abc" {
+		t.Errorf("Unexpected ciphertext %v", ciphertext)
+	}
+	plaintext, err := c.Decrypt("offen", ciphertext)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if string(plaintext) != "value" {
+		t.Errorf("Unexpected plaintext %v", plaintext)
+	}
+	if _, err := c.Encrypt("other", []byte("value")); err == nil {
+		t.Error("Expected error using unknown key")
+	}
+}
+
+func TestClient_KeepAlive(t *testing.T) {
+	t.Run("not renewable", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"auth":{"lease_duration":0,"renewable":false}}`))
+		}))
+		defer srv.Close()
+
+		done := make(chan struct{})
+		go func() {
+			New(srv.URL, "token").KeepAlive(context.Background(), func(err error) {
+				t.Errorf("Unexpected error %v", err)
+			})
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("Expected KeepAlive to return")
+		}
+	})
+	t.Run("renewable", func(t *testing.T) {
+		var calls int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Write([]byte(`{"auth":{"lease_duration":3600,"renewable":true}}`))
+		}))
+		defer srv.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		New(srv.URL, "token").KeepAlive(ctx, func(err error) {
+			t.Errorf("Unexpected error %v", err)
+		})
+		if calls != 1 {
+			t.Errorf("Unexpected number of renewals %d", calls)
+		}
+	})
+}
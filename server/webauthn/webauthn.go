@@ -0,0 +1,274 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package webauthn implements the server side verification of WebAuthn
+// registration and authentication ceremonies for credentials using ES256.
+// Attestation statements are not verified, i.e. all credentials are treated
+// as if they were registered using the "none" attestation format.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+
+	"github.com/offen/offen/server/keys"
+	"github.com/ugorji/go/codec"
+)
+
+// ChallengeLength is the number of random bytes used for challenges.
+const ChallengeLength = 32
+
+const (
+	flagUserPresent            = 0x01
+	flagAttestedCredentialData = 0x40
+
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+
+	coseKeyTypeEC2 = 2
+	coseAlgES256   = -7
+	coseCurveP256  = 1
+)
+
+// RelyingParty verifies ceremonies performed for the given relying party
+// identifier. Client data is only accepted from the given origins. In case no
+// origins are given, any origin whose host matches the identifier is accepted.
+type RelyingParty struct {
+	id      string
+	origins []string
+}
+
+// New creates a relying party for the given identifier.
+func New(id string, origins ...string) *RelyingParty {
+	return &RelyingParty{id: id, origins: origins}
+}
+
+// ID returns the relying party identifier.
+func (r *RelyingParty) ID() string {
+	return r.id
+}
+
+// Credential is a public key credential that has been registered by an
+// authenticator.
+type Credential struct {
+	ID []byte
+	// PublicKey is the PKIX encoded public key of the credential
+	PublicKey []byte
+	SignCount uint32
+}
+
+// NewChallenge creates a random challenge for a single ceremony.
+func NewChallenge() ([]byte, error) {
+	challenge, err := keys.GenerateRandomBytes(ChallengeLength)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: error creating challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// VerifyRegistration checks the response of an authenticator that has been
+// asked to create a credential using the given challenge and returns the
+// created credential.
+func (r *RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := r.verifyClientData(clientDataJSON, ceremonyCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	var attestation struct {
+		Format   string `codec:"fmt"`
+		AuthData []byte `codec:"authData"`
+	}
+	if err := decodeCBOR(attestationObject, &attestation); err != nil {
+		return nil, fmt.Errorf("webauthn: error decoding attestation object: %w", err)
+	}
+	data, err := r.parseAuthenticatorData(attestation.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if data.flags&flagAttestedCredentialData == 0 {
+		return nil, errors.New("webauthn: authenticator data does not contain a credential")
+	}
+
+	rest := data.rest
+	if len(rest) < 18 {
+		return nil, errors.New("webauthn: attested credential data is too short")
+	}
+	// the first 16 bytes are the AAGUID of the authenticator which is not
+	// used as attestation is not verified
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLength {
+		return nil, errors.New("webauthn: credential id is too short")
+	}
+	credentialID, coseKey := rest[:idLength], rest[idLength:]
+	publicKey, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: error encoding public key: %w", err)
+	}
+	return &Credential{
+		ID:        credentialID,
+		PublicKey: der,
+		SignCount: data.signCount,
+	}, nil
+}
+
+// VerifyAssertion checks the response of an authenticator that has been asked
+// to sign the given challenge using the given credential. It returns the
+// updated signature counter of the credential which is expected to be
+// persisted by the caller.
+func (r *RelyingParty) VerifyAssertion(credential *Credential, challenge, clientDataJSON, authenticatorData, signature []byte) (uint32, error) {
+	if err := r.verifyClientData(clientDataJSON, ceremonyGet, challenge); err != nil {
+		return 0, err
+	}
+	data, err := r.parseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, fmt.Errorf("webauthn: error parsing stored public key: %w", err)
+	}
+	publicKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return 0, errors.New("webauthn: stored public key is not an ecdsa key")
+	}
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(signature, &sig); err != nil {
+		return 0, fmt.Errorf("webauthn: error decoding signature: %w", err)
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authenticatorData...), clientDataHash[:]...))
+	if !ecdsa.Verify(publicKey, digest[:], sig.R, sig.S) {
+		return 0, errors.New("webauthn: invalid signature")
+	}
+
+	// authenticators that do not implement a counter always report zero,
+	// all others are required to increase the counter on each use, so a
+	// lower value indicates a cloned authenticator
+	if data.signCount != 0 || credential.SignCount != 0 {
+		if data.signCount <= credential.SignCount {
+			return 0, errors.New("webauthn: signature counter did not increase")
+		}
+	}
+	return data.signCount, nil
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (r *RelyingParty) verifyClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return fmt.Errorf("webauthn: error decoding client data: %w", err)
+	}
+	if data.Type != ceremony {
+		return fmt.Errorf("webauthn: unexpected ceremony type %s", data.Type)
+	}
+	received, err := base64.RawURLEncoding.DecodeString(data.Challenge)
+	if err != nil {
+		return fmt.Errorf("webauthn: error decoding challenge: %w", err)
+	}
+	if subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return errors.New("webauthn: challenge did not match")
+	}
+	if !r.allowsOrigin(data.Origin) {
+		return fmt.Errorf("webauthn: origin %s is not allowed", data.Origin)
+	}
+	return nil
+}
+
+func (r *RelyingParty) allowsOrigin(origin string) bool {
+	if len(r.origins) != 0 {
+		for _, allowed := range r.origins {
+			if origin == allowed {
+				return true
+			}
+		}
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Hostname() == r.id
+}
+
+type authenticatorData struct {
+	flags     byte
+	signCount uint32
+	rest      []byte
+}
+
+func (r *RelyingParty) parseAuthenticatorData(b []byte) (*authenticatorData, error) {
+	if len(b) < 37 {
+		return nil, errors.New("webauthn: authenticator data is too short")
+	}
+	rpIDHash := sha256.Sum256([]byte(r.id))
+	if !bytes.Equal(b[:32], rpIDHash[:]) {
+		return nil, errors.New("webauthn: relying party id did not match")
+	}
+	data := authenticatorData{
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+		rest:      b[37:],
+	}
+	if data.flags&flagUserPresent == 0 {
+		return nil, errors.New("webauthn: user was not present")
+	}
+	return &data, nil
+}
+
+func parseCOSEKey(b []byte) (*ecdsa.PublicKey, error) {
+	var key map[int]interface{}
+	if err := decodeCBOR(b, &key); err != nil {
+		return nil, fmt.Errorf("webauthn: error decoding public key: %w", err)
+	}
+	kty, _ := key[1].(int64)
+	alg, _ := key[3].(int64)
+	crv, _ := key[-1].(int64)
+	if kty != coseKeyTypeEC2 || alg != coseAlgES256 || crv != coseCurveP256 {
+		return nil, fmt.Errorf("webauthn: unsupported public key with type %d, algorithm %d and curve %d", kty, alg, crv)
+	}
+	x, _ := key[-2].([]byte)
+	y, _ := key[-3].([]byte)
+	if len(x) != 32 || len(y) != 32 {
+		return nil, errors.New("webauthn: public key has unexpected coordinates")
+	}
+	publicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return nil, errors.New("webauthn: public key is not on curve")
+	}
+	return publicKey, nil
+}
+
+func decodeCBOR(b []byte, v interface{}) error {
+	h := &codec.CborHandle{}
+	h.SignedInteger = true
+	return codec.NewDecoderBytes(b, h).Decode(v)
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+type mockAuthenticator struct {
+	rpID         string
+	credentialID []byte
+	key          *ecdsa.PrivateKey
+	signCount    uint32
+}
+
+func newMockAuthenticator(rpID string) *mockAuthenticator {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return &mockAuthenticator{rpID: rpID, credentialID: []byte("credential-a"), key: key}
+}
+
+func (m *mockAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(m.rpID))
+	b := append([]byte{}, rpIDHash[:]...)
+	b = append(b, flags)
+	counter := make([]byte, 4)
+	binary.BigEndian.PutUint32(counter, m.signCount)
+	b = append(b, counter...)
+	return append(b, attested...)
+}
+
+func (m *mockAuthenticator) create() []byte {
+	var coseKey []byte
+	codec.NewEncoderBytes(&coseKey, &codec.CborHandle{}).Encode(map[int]interface{}{
+		1:  coseKeyTypeEC2,
+		3:  coseAlgES256,
+		-1: coseCurveP256,
+		-2: padCoordinate(m.key.X.Bytes()),
+		-3: padCoordinate(m.key.Y.Bytes()),
+	})
+	attested := make([]byte, 18)
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(m.credentialID)))
+	attested = append(attested, m.credentialID...)
+	attested = append(attested, coseKey...)
+
+	var attestationObject []byte
+	codec.NewEncoderBytes(&attestationObject, &codec.CborHandle{}).Encode(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": m.authData(flagUserPresent|flagAttestedCredentialData, attested),
+	})
+	return attestationObject
+}
+
+func (m *mockAuthenticator) get(clientDataJSON []byte) ([]byte, []byte) {
+	m.signCount++
+	authData := m.authData(flagUserPresent, nil)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	r, s, _ := ecdsa.Sign(rand.Reader, m.key, digest[:])
+	signature, _ := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	return authData, signature
+}
+
+func padCoordinate(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+func clientDataFor(ceremony, origin string, challenge []byte) []byte {
+	return []byte(fmt.Sprintf(
+		`{"type":"%s","challenge":"%s","origin":"%s"}`,
+		ceremony, base64.RawURLEncoding.EncodeToString(challenge), origin,
+	))
+}
+
+func TestRelyingParty_VerifyRegistration(t *testing.T) {
+	challenge := []byte("challenge")
+	tests := []struct {
+		name           string
+		relyingParty   *RelyingParty
+		authenticator  *mockAuthenticator
+		clientDataJSON []byte
+		expectError    bool
+	}{
+		{
+			"ok",
+			New("offen.example.com"),
+			newMockAuthenticator("offen.example.com"),
+			clientDataFor(ceremonyCreate, "https://offen.example.com", challenge),
+			false,
+		},
+		{
+			"configured origin",
+			New("offen.example.com", "https://offen.example.com:8443"),
+			newMockAuthenticator("offen.example.com"),
+			clientDataFor(ceremonyCreate, "https://offen.example.com:8443", challenge),
+			false,
+		},
+		{
+			"bad origin",
+			New("offen.example.com"),
+			newMockAuthenticator("offen.example.com"),
+			clientDataFor(ceremonyCreate, "https://evil.example.com", challenge),
+			true,
+		},
+		{
+			"bad challenge",
+			New("offen.example.com"),
+			newMockAuthenticator("offen.example.com"),
+			clientDataFor(ceremonyCreate, "https://offen.example.com", []byte("other")),
+			true,
+		},
+		{
+			"bad ceremony",
+			New("offen.example.com"),
+			newMockAuthenticator("offen.example.com"),
+			clientDataFor(ceremonyGet, "https://offen.example.com", challenge),
+			true,
+		},
+		{
+			"bad relying party",
+			New("offen.example.com"),
+			newMockAuthenticator("evil.example.com"),
+			clientDataFor(ceremonyCreate, "https://offen.example.com", challenge),
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			credential, err := test.relyingParty.VerifyRegistration(challenge, test.clientDataJSON, test.authenticator.create())
+			if (err != nil) != test.expectError {
+				t.Errorf("Unexpected error value %v", err)
+			}
+			if !test.expectError && string(credential.ID) != "credential-a" {
+				t.Errorf("Unexpected credential %v", credential)
+			}
+		})
+	}
+}
+
+func TestRelyingParty_VerifyAssertion(t *testing.T) {
+	rp := New("offen.example.com")
+	authenticator := newMockAuthenticator("offen.example.com")
+	credential, err := rp.VerifyRegistration(
+		[]byte("challenge"),
+		clientDataFor(ceremonyCreate, "https://offen.example.com", []byte("challenge")),
+		authenticator.create(),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		clientDataJSON := clientDataFor(ceremonyGet, "https://offen.example.com", []byte("login"))
+		authData, signature := authenticator.get(clientDataJSON)
+		signCount, err := rp.VerifyAssertion(credential, []byte("login"), clientDataJSON, authData, signature)
+		if err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if signCount != 1 {
+			t.Errorf("Expected sign count of 1, got %d", signCount)
+		}
+	})
+	t.Run("bad signature", func(t *testing.T) {
+		clientDataJSON := clientDataFor(ceremonyGet, "https://offen.example.com", []byte("login"))
+		authData, _ := authenticator.get(clientDataJSON)
+		_, signature := newMockAuthenticator("offen.example.com").get(clientDataJSON)
+		if _, err := rp.VerifyAssertion(credential, []byte("login"), clientDataJSON, authData, signature); err == nil {
+			t.Error("Expected error, got nil")
+		}
+	})
+	t.Run("cloned authenticator", func(t *testing.T) {
+		clientDataJSON := clientDataFor(ceremonyGet, "https://offen.example.com", []byte("login"))
+		authData, signature := authenticator.get(clientDataJSON)
+		stale := *credential
+		stale.SignCount = 99
+		if _, err := rp.VerifyAssertion(&stale, []byte("login"), clientDataJSON, authData, signature); err == nil {
+			t.Error("Expected error, got nil")
+		}
+	})
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package webhooks
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+	"github.com/oklog/ulid"
+)
+
+// WeeklyAggregate is the data sent with weekly_aggregate.ready. Usage data is
+// end-to-end encrypted, so it only contains what can be derived from event
+// metadata.
+type WeeklyAggregate struct {
+	From            time.Time `json:"from"`
+	Until           time.Time `json:"until"`
+	Events          int       `json:"events"`
+	Users           int       `json:"users"`
+	AnonymousEvents int       `json:"anonymousEvents"`
+}
+
+// RetentionPurge is the data sent with retention_purge.completed.
+type RetentionPurge struct {
+	Removed int `json:"removed"`
+}
+
+// AccountUserAdded is the data sent with account_user.added. Webhooks are
+// registered by admins of an account, but the email address of the account
+// user is not included nonetheless.
+type AccountUserAdded struct {
+	Role string `json:"role"`
+}
+
+// NewAccountUserAdded returns the data sent when an account user has been
+// granted the given role.
+func NewAccountUserAdded(role persistence.AccountUserRole) AccountUserAdded {
+	if role == persistence.AccountUserRoleAdmin {
+		return AccountUserAdded{Role: "admin"}
+	}
+	return AccountUserAdded{Role: "viewer"}
+}
+
+// StartOfWeek returns the beginning of the week the given time is in. Weeks
+// start on Monday, 00:00 UTC.
+func StartOfWeek(t time.Time) time.Time {
+	t = t.UTC()
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, time.UTC)
+}
+
+// DispatchWeeklyAggregates aggregates the events of the week before the one
+// the given time is in for all accounts that have webhooks subscribed to
+// weekly_aggregate.ready and dispatches the result.
+func (d *Dispatcher) DispatchWeeklyAggregates(now time.Time) error {
+	if d == nil {
+		return nil
+	}
+	until := StartOfWeek(now)
+	from := until.AddDate(0, 0, -7)
+	webhooks, err := d.store.LookupWebhooks("", persistence.WebhookEventWeeklyAggregate)
+	if err != nil {
+		return fmt.Errorf("webhooks: error looking up webhooks: %w", err)
+	}
+	for _, accountID := range accountIDs(webhooks) {
+		aggregate, err := d.aggregate(accountID, from, until)
+		if err != nil {
+			return err
+		}
+		if err := d.Dispatch(accountID, persistence.WebhookEventWeeklyAggregate, aggregate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DispatchRetentionPurge dispatches the number of events removed for each
+// account that has webhooks subscribed to retention_purge.completed.
+func (d *Dispatcher) DispatchRetentionPurge(result persistence.ExpireResult) error {
+	if d == nil {
+		return nil
+	}
+	webhooks, err := d.store.LookupWebhooks("", persistence.WebhookEventRetentionPurge)
+	if err != nil {
+		return fmt.Errorf("webhooks: error looking up webhooks: %w", err)
+	}
+	for _, accountID := range accountIDs(webhooks) {
+		if err := d.Dispatch(accountID, persistence.WebhookEventRetentionPurge, RetentionPurge{
+			Removed: result.RemovedByAccountID[accountID],
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) aggregate(accountID string, from, until time.Time) (WeeklyAggregate, error) {
+	result := WeeklyAggregate{From: from, Until: until}
+	since, err := persistence.EventIDAt(from)
+	if err != nil {
+		return result, fmt.Errorf("webhooks: error creating event id: %w", err)
+	}
+	account, err := d.store.GetAccount(accountID, true, since)
+	if err != nil {
+		return result, fmt.Errorf("webhooks: error looking up account %s: %w", accountID, err)
+	}
+	if account.Events == nil {
+		return result, nil
+	}
+	users := map[string]bool{}
+	for _, event := range (*account.Events)[accountID] {
+		id, err := ulid.Parse(event.EventID)
+		if err != nil || !ulid.Time(id.Time()).Before(until) {
+			continue
+		}
+		result.Events++
+		if event.SecretID == nil {
+			result.AnonymousEvents++
+			continue
+		}
+		users[*event.SecretID] = true
+	}
+	result.Users = len(users)
+	return result, nil
+}
+
+func accountIDs(webhooks []persistence.WebhookResult) []string {
+	var result []string
+	seen := map[string]bool{}
+	for _, webhook := range webhooks {
+		if seen[webhook.AccountID] {
+			continue
+		}
+		seen[webhook.AccountID] = true
+		result = append(result, webhook.AccountID)
+	}
+	return result
+}
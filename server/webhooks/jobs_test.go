@@ -0,0 +1,98 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+func TestStartOfWeek(t *testing.T) {
+	tests := []struct {
+		name     string
+		t        time.Time
+		expected time.Time
+	}{
+		{"monday", time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"wednesday", time.Date(2020, 6, 3, 13, 12, 0, 0, time.UTC), time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"sunday", time.Date(2020, 6, 7, 23, 59, 0, 0, time.UTC), time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"other zone", time.Date(2020, 6, 1, 1, 0, 0, 0, time.FixedZone("CEST", 7200)), time.Date(2020, 5, 25, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := StartOfWeek(test.t); !result.Equal(test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+func mustEventID(t time.Time) string {
+	id, err := persistence.EventIDAt(t)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func TestDispatcher_Jobs(t *testing.T) {
+	payloads := make(chan Payload, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload Payload
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	defer server.Close()
+
+	secretA, secretB := "secret-a", "secret-b"
+	store := &mockStore{
+		webhooks: []persistence.WebhookResult{
+			{WebhookID: "webhook-a", AccountID: "account-a", URL: server.URL, Events: []string{persistence.WebhookEventWeeklyAggregate, persistence.WebhookEventRetentionPurge}},
+		},
+		events: []persistence.EventResult{
+			{EventID: mustEventID(time.Date(2020, 5, 25, 12, 0, 0, 0, time.UTC)), SecretID: &secretA},
+			{EventID: mustEventID(time.Date(2020, 5, 26, 12, 0, 0, 0, time.UTC)), SecretID: &secretA},
+			{EventID: mustEventID(time.Date(2020, 5, 27, 12, 0, 0, 0, time.UTC)), SecretID: &secretB},
+			{EventID: mustEventID(time.Date(2020, 5, 28, 12, 0, 0, 0, time.UTC))},
+			// events of the current week are not included
+			{EventID: mustEventID(time.Date(2020, 6, 1, 0, 30, 0, 0, time.UTC)), SecretID: &secretB},
+		},
+	}
+	d := New(store, WithHTTPClient(server.Client()))
+
+	if err := d.DispatchWeeklyAggregates(time.Date(2020, 6, 1, 1, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	payload := <-payloads
+	b, _ := json.Marshal(payload.Data)
+	var aggregate WeeklyAggregate
+	json.Unmarshal(b, &aggregate)
+	if payload.Event != persistence.WebhookEventWeeklyAggregate || aggregate.Events != 4 || aggregate.Users != 2 || aggregate.AnonymousEvents != 1 {
+		t.Errorf("Unexpected payload %v", payload)
+	}
+	if !aggregate.From.Equal(time.Date(2020, 5, 25, 0, 0, 0, 0, time.UTC)) || !aggregate.Until.Equal(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected range %v", aggregate)
+	}
+
+	if err := d.DispatchRetentionPurge(persistence.ExpireResult{
+		Removed:            12,
+		RemovedByAccountID: map[string]int{"account-a": 4, "account-b": 8},
+	}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	payload = <-payloads
+	if payload.Event != persistence.WebhookEventRetentionPurge || payload.Data.(map[string]interface{})["removed"] != 4.0 {
+		t.Errorf("Unexpected payload %v", payload)
+	}
+
+	if err := d.Close(context.Background()); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
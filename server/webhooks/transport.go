@@ -0,0 +1,97 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package webhooks
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// nonPublicNetworks contains all ranges webhooks are not allowed to connect
+// to. Webhook URLs are controlled by account admins, so without this
+// restriction they could be used for reaching services that are only
+// exposed to the host or the internal network Offen is running in.
+var nonPublicNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var result []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		result = append(result, network)
+	}
+	return result
+}
+
+func isPublicIP(ip net.IP) bool {
+	// IPv4 addresses mapped into IPv6 are checked against the IPv4 ranges
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// rejectNonPublic is used as the Control func of the dialer. It is called
+// after the host name has been resolved, so it also applies to host names
+// resolving to internal addresses and to redirects.
+func rejectNonPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("webhooks: error parsing address %s: %w", address, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("webhooks: refusing to connect to non-public address %s", host)
+	}
+	return nil
+}
+
+// newClient creates the default client used for delivering payloads, which
+// is only able to connect to public addresses.
+func newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: defaultTimeout,
+		Control: rejectNonPublic,
+	}
+	return &http.Client{
+		Timeout: defaultTimeout,
+		Transport: &http.Transport{
+			// proxies are not used as the proxy would connect to the
+			// target instead of the dialer
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: defaultTimeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     time.Second * 90,
+		},
+	}
+}
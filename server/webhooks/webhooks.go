@@ -0,0 +1,278 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package webhooks delivers signed JSON payloads to the webhooks operators
+// have registered for their accounts.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+	"github.com/sirupsen/logrus"
+)
+
+// Headers sent with each delivery.
+const (
+	// SignatureHeader contains the time the payload has been signed at and
+	// the HMAC-SHA256 of the timestamp and the body, keyed with the secret of
+	// the webhook, e.g. `t=1591012800,v1=5257a8...`. The signed message is
+	// the timestamp and the body joined by a dot.
+	SignatureHeader = "X-Offen-Signature"
+	// EventHeader contains the event the payload has been sent for.
+	EventHeader = "X-Offen-Event"
+	// DeliveryHeader contains the id of the delivery. It does not change when
+	// a delivery is retried.
+	DeliveryHeader = "X-Offen-Delivery"
+)
+
+const (
+	defaultAttempts = 5
+	defaultBackoff  = time.Second * 10
+	defaultTimeout  = time.Second * 10
+	// response bodies are drained so connections can be reused, but only up
+	// to this size
+	maxResponseSize = 1024
+)
+
+// Store is used for looking up webhooks and recording their deliveries.
+type Store interface {
+	LookupWebhooks(accountID, event string) ([]persistence.WebhookResult, error)
+	RecordWebhookDelivery(delivery persistence.WebhookDeliveryResult) (persistence.WebhookDeliveryResult, error)
+	GetAccount(accountID string, events bool, eventsSince string) (persistence.AccountResult, error)
+}
+
+// Payload is the body of each delivery.
+type Payload struct {
+	Event     string      `json:"event"`
+	AccountID string      `json:"accountId"`
+	Created   time.Time   `json:"created"`
+	Data      interface{} `json:"data"`
+}
+
+// Dispatcher delivers payloads in the background. Failed deliveries are
+// retried using exponential backoff.
+type Dispatcher struct {
+	store    Store
+	client   *http.Client
+	logger   *logrus.Logger
+	attempts int
+	backoff  time.Duration
+	wg       sync.WaitGroup
+	done     chan struct{}
+	once     sync.Once
+}
+
+// Option is used for configuring a Dispatcher.
+type Option func(*Dispatcher)
+
+// WithHTTPClient sets the client used for delivering payloads. The default
+// client refuses to connect to non-public addresses, which a custom client
+// will need to take care of itself.
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = client
+	}
+}
+
+// WithRetries sets the number of attempts made for each delivery and the
+// time to wait before the first retry. The wait time doubles after each
+// retry.
+func WithRetries(attempts int, backoff time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.attempts = attempts
+		d.backoff = backoff
+	}
+}
+
+// WithLogger sets the logger used for reporting errors.
+func WithLogger(logger *logrus.Logger) Option {
+	return func(d *Dispatcher) {
+		d.logger = logger
+	}
+}
+
+// New creates a Dispatcher using the given store.
+func New(store Store, opts ...Option) *Dispatcher {
+	d := &Dispatcher{
+		store:    store,
+		client:   newClient(),
+		logger:   logrus.New(),
+		attempts: defaultAttempts,
+		backoff:  defaultBackoff,
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Sign returns the value of the signature header for the given body.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", t, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Verify checks whether the given signature header value matches the body.
+// Signatures that are older than the given tolerance are rejected.
+func Verify(secret, signature string, body []byte, tolerance time.Duration) bool {
+	var timestamp string
+	for _, part := range strings.Split(signature, ",") {
+		if strings.HasPrefix(part, "t=") {
+			timestamp = strings.TrimPrefix(part, "t=")
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	signedAt := time.Unix(seconds, 0)
+	if tolerance > 0 && time.Since(signedAt) > tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, signedAt, body)), []byte(signature))
+}
+
+// Dispatch delivers the given data to all webhooks of the account that are
+// subscribed to the event. It returns after the deliveries have been
+// recorded, the payloads are sent in the background. Calling Dispatch on a
+// nil Dispatcher is a no-op.
+func (d *Dispatcher) Dispatch(accountID, event string, data interface{}) error {
+	if d == nil {
+		return nil
+	}
+	webhooks, err := d.store.LookupWebhooks(accountID, event)
+	if err != nil {
+		return fmt.Errorf("webhooks: error looking up webhooks: %w", err)
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(Payload{
+		Event:     event,
+		AccountID: accountID,
+		Created:   time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return fmt.Errorf("webhooks: error encoding payload: %w", err)
+	}
+	for _, webhook := range webhooks {
+		delivery, err := d.store.RecordWebhookDelivery(persistence.WebhookDeliveryResult{
+			WebhookID: webhook.WebhookID,
+			Event:     event,
+			Payload:   string(body),
+		})
+		if err != nil {
+			return fmt.Errorf("webhooks: error recording delivery: %w", err)
+		}
+		d.wg.Add(1)
+		go func(webhook persistence.WebhookResult, delivery persistence.WebhookDeliveryResult) {
+			defer d.wg.Done()
+			d.deliver(webhook, delivery, body)
+		}(webhook, delivery)
+	}
+	return nil
+}
+
+// Close waits for all pending deliveries including their retries. In case
+// the context is done before, pending retries are abandoned.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	finished := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		d.once.Do(func() {
+			close(d.done)
+		})
+		return fmt.Errorf("webhooks: error waiting for deliveries: %w", ctx.Err())
+	}
+}
+
+func (d *Dispatcher) deliver(webhook persistence.WebhookResult, delivery persistence.WebhookDeliveryResult, body []byte) {
+	wait := d.backoff
+	for {
+		delivery.Attempts++
+		statusCode, err := d.send(webhook, delivery, body)
+		delivery.StatusCode = statusCode
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		delivery.Delivered = err == nil
+		retry := err != nil && retryable(statusCode) && delivery.Attempts < d.attempts
+
+		if _, recordErr := d.store.RecordWebhookDelivery(delivery); recordErr != nil {
+			d.logger.WithError(recordErr).WithField("delivery", delivery.DeliveryID).Error("Error recording webhook delivery")
+		}
+		if !retry {
+			return
+		}
+		select {
+		case <-time.After(wait):
+			wait *= 2
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) send(webhook persistence.WebhookResult, delivery persistence.WebhookDeliveryResult, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("webhooks: error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Offen-Webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.DeliveryID)
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, time.Now(), body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("webhooks: error performing request: %w", err)
+	}
+	defer res.Body.Close()
+	// the response body is not recorded as it would allow reading responses
+	// of arbitrary services using the delivery log
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, maxResponseSize))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhooks: unexpected status code %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// retryable checks whether a delivery that has failed with the given status
+// code is worth retrying. Client errors other than timeouts and rate limits
+// are not expected to go away.
+func retryable(statusCode int) bool {
+	if statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return statusCode < 400 || statusCode > 499
+}
@@ -0,0 +1,247 @@
+// Copyright 2020 - Offen Authors <hioffen@posteo.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/offen/offen/server/persistence"
+)
+
+type mockStore struct {
+	lock       sync.Mutex
+	webhooks   []persistence.WebhookResult
+	events     []persistence.EventResult
+	deliveries map[string]persistence.WebhookDeliveryResult
+	err        error
+}
+
+func (m *mockStore) LookupWebhooks(accountID, event string) ([]persistence.WebhookResult, error) {
+	var result []persistence.WebhookResult
+	for _, webhook := range m.webhooks {
+		if accountID != "" && webhook.AccountID != accountID {
+			continue
+		}
+		for _, e := range webhook.Events {
+			if e == event {
+				result = append(result, webhook)
+			}
+		}
+	}
+	return result, m.err
+}
+
+func (m *mockStore) RecordWebhookDelivery(delivery persistence.WebhookDeliveryResult) (persistence.WebhookDeliveryResult, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.deliveries == nil {
+		m.deliveries = map[string]persistence.WebhookDeliveryResult{}
+	}
+	if delivery.DeliveryID == "" {
+		delivery.DeliveryID = delivery.WebhookID + "-delivery"
+	}
+	m.deliveries[delivery.DeliveryID] = delivery
+	return delivery, nil
+}
+
+func (m *mockStore) GetAccount(accountID string, events bool, since string) (persistence.AccountResult, error) {
+	return persistence.AccountResult{
+		AccountID: accountID,
+		Events:    &persistence.EventsByAccountID{accountID: m.events},
+	}, m.err
+}
+
+func (m *mockStore) delivery(id string) persistence.WebhookDeliveryResult {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.deliveries[id]
+}
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"event":"account_user.added"}`)
+	now := time.Now()
+	signature := Sign("secret", now, body)
+	if !Verify("secret", signature, body, time.Minute) {
+		t.Error("Expected signature to be valid")
+	}
+	if Verify("other", signature, body, time.Minute) {
+		t.Error("Expected signature using other secret to be invalid")
+	}
+	if Verify("secret", signature, []byte(`{}`), time.Minute) {
+		t.Error("Expected signature of other body to be invalid")
+	}
+	if Verify("secret", Sign("secret", now.Add(-time.Hour), body), body, time.Minute) {
+		t.Error("Expected outdated signature to be invalid")
+	}
+	if Verify("secret", "v1=abc", body, time.Minute) {
+		t.Error("Expected signature without timestamp to be invalid")
+	}
+}
+
+func TestDispatcher_Dispatch(t *testing.T) {
+	tests := []struct {
+		name              string
+		statusCodes       []int
+		expectedAttempts  int
+		expectedDelivered bool
+	}{
+		{"ok", []int{http.StatusOK}, 1, true},
+		{"retried", []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusNoContent}, 3, true},
+		{"client error", []int{http.StatusNotFound}, 1, false},
+		{"attempts exceeded", []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK}, 3, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lock sync.Mutex
+			var requests int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				status := test.statusCodes[requests]
+				requests++
+				lock.Unlock()
+				body, _ := ioutil.ReadAll(r.Body)
+				if !Verify("secret", r.Header.Get(SignatureHeader), body, time.Minute) {
+					t.Errorf("Unexpected signature %v", r.Header.Get(SignatureHeader))
+				}
+				if r.Header.Get(EventHeader) != persistence.WebhookEventAccountUserAdded || r.Header.Get(DeliveryHeader) != "webhook-a-delivery" {
+					t.Errorf("Unexpected headers %v", r.Header)
+				}
+				var payload Payload
+				if err := json.Unmarshal(body, &payload); err != nil || payload.AccountID != "account-a" {
+					t.Errorf("Unexpected payload %s", body)
+				}
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			store := &mockStore{
+				webhooks: []persistence.WebhookResult{
+					{WebhookID: "webhook-a", AccountID: "account-a", URL: server.URL, Secret: "secret", Events: []string{persistence.WebhookEventAccountUserAdded}},
+					{WebhookID: "webhook-b", AccountID: "account-a", URL: server.URL, Secret: "secret", Events: []string{persistence.WebhookEventRetentionPurge}},
+				},
+			}
+			d := New(store, WithRetries(3, time.Millisecond), WithHTTPClient(server.Client()))
+			if err := d.Dispatch("account-a", persistence.WebhookEventAccountUserAdded, NewAccountUserAdded(persistence.AccountUserRoleViewer)); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if err := d.Close(context.Background()); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+
+			delivery := store.delivery("webhook-a-delivery")
+			if delivery.Attempts != test.expectedAttempts || delivery.Delivered != test.expectedDelivered {
+				t.Errorf("Unexpected delivery %v", delivery)
+			}
+			if !test.expectedDelivered && delivery.Error == "" {
+				t.Error("Expected error to be recorded")
+			}
+			if len(store.deliveries) != 1 {
+				t.Errorf("Unexpected deliveries %v", store.deliveries)
+			}
+		})
+	}
+	t.Run("store error", func(t *testing.T) {
+		d := New(&mockStore{err: errors.New("did not work")})
+		if err := d.Dispatch("account-a", persistence.WebhookEventAccountUserAdded, nil); err == nil {
+			t.Error("Expected error")
+		}
+	})
+	t.Run("nil dispatcher", func(t *testing.T) {
+		var d *Dispatcher
+		if err := d.Dispatch("account-a", persistence.WebhookEventAccountUserAdded, nil); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+	})
+}
+
+func TestDispatcher_NonPublicAddress(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	store := &mockStore{
+		webhooks: []persistence.WebhookResult{
+			{WebhookID: "webhook-a", AccountID: "account-a", URL: server.URL, Secret: "secret", Events: []string{persistence.WebhookEventAccountUserAdded}},
+		},
+	}
+	d := New(store, WithRetries(1, time.Millisecond))
+	if err := d.Dispatch("account-a", persistence.WebhookEventAccountUserAdded, nil); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("Expected no requests to reach the server, got %d", requests)
+	}
+	if delivery := store.delivery("webhook-a-delivery"); delivery.Delivered || delivery.Error == "" {
+		t.Errorf("Unexpected delivery %v", delivery)
+	}
+}
+
+func TestDispatcher_ResponseNotRecorded(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("internal details"))
+	}))
+	defer server.Close()
+
+	store := &mockStore{
+		webhooks: []persistence.WebhookResult{
+			{WebhookID: "webhook-a", AccountID: "account-a", URL: server.URL, Secret: "secret", Events: []string{persistence.WebhookEventAccountUserAdded}},
+		},
+	}
+	d := New(store, WithHTTPClient(server.Client()))
+	if err := d.Dispatch("account-a", persistence.WebhookEventAccountUserAdded, nil); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	delivery := store.delivery("webhook-a-delivery")
+	if delivery.StatusCode != http.StatusNotFound || strings.Contains(delivery.Error, "internal details") {
+		t.Errorf("Unexpected delivery %v", delivery)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{"1.1.1.1", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.20.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+	}
+	for _, test := range tests {
+		t.Run(test.ip, func(t *testing.T) {
+			if result := isPublicIP(net.ParseIP(test.ip)); result != test.expected {
+				t.Errorf("Expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
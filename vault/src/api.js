@@ -6,7 +6,12 @@
 var path = require('path')
 var handleFetchResponse = require('offen/fetch-response')
 
-exports.getAccount = getAccountWith(window.location.origin + '/api/accounts')
+// EVENT_SCHEMA_VERSION is the version of the event payload schema sent by
+// this client. It needs to be updated whenever the format of the encrypted
+// payload changes.
+var EVENT_SCHEMA_VERSION = 1
+
+exports.getAccount = getAccountWith(window.location.origin + '/api/v1/accounts')
 exports.getAccountWith = getAccountWith
 
 function getAccountWith (accountsUrl) {
@@ -23,7 +28,7 @@ function getAccountWith (accountsUrl) {
   }
 }
 
-exports.getEvents = getEventsWith(window.location.origin + '/api/events')
+exports.getEvents = getEventsWith(window.location.origin + '/api/v1/events')
 exports.getEventsWith = getEventsWith
 
 function getEventsWith (accountsUrl) {
@@ -49,29 +54,37 @@ function getEventsWith (accountsUrl) {
   }
 }
 
-exports.postEvent = postEventWith(window.location.origin + '/api/events')
+exports.postEvent = postEventWith(window.location.origin + '/api/v1/events')
 exports.postEventWith = postEventWith
 
 function postEventWith (eventsUrl) {
-  return function (accountId, payload, anonymous) {
+  return function (accountId, payload, anonymous, honeypot, domain) {
     var url = new window.URL(eventsUrl)
     if (anonymous) {
       url.pathname += '/anonymous'
     }
+    var body = {
+      accountId: accountId,
+      payload: payload,
+      schemaVersion: EVENT_SCHEMA_VERSION
+    }
+    if (honeypot) {
+      body.honeypot = true
+    }
+    if (domain) {
+      body.domain = domain
+    }
     return window
       .fetch(url, {
         method: 'POST',
         credentials: 'include',
-        body: JSON.stringify({
-          accountId: accountId,
-          payload: payload
-        })
+        body: JSON.stringify(body)
       })
       .then(handleFetchResponse)
   }
 }
 
-exports.getDeletedEvents = getDeletedEventsWith(window.location.origin + '/api/deleted')
+exports.getDeletedEvents = getDeletedEventsWith(window.location.origin + '/api/v1/deleted')
 exports.getDeletedEventsWith = getDeletedEventsWith
 
 function getDeletedEventsWith (deletedEventsUrl) {
@@ -92,7 +105,7 @@ function getDeletedEventsWith (deletedEventsUrl) {
   }
 }
 
-exports.getPublicKey = getPublicKeyWith(window.location.origin + '/api/exchange')
+exports.getPublicKey = getPublicKeyWith(window.location.origin + '/api/v1/exchange')
 exports.getPublicKeyWith = getPublicKeyWith
 
 function getPublicKeyWith (exchangeUrl) {
@@ -111,7 +124,7 @@ function getPublicKeyWith (exchangeUrl) {
   }
 }
 
-exports.postUserSecret = postUserSecretWith(window.location.origin + '/api/exchange')
+exports.postUserSecret = postUserSecretWith(window.location.origin + '/api/v1/exchange')
 exports.postUserSecretWith = postUserSecretWith
 
 function postUserSecretWith (exchangeUrl) {
@@ -126,7 +139,7 @@ function postUserSecretWith (exchangeUrl) {
   }
 }
 
-exports.login = loginWith(window.location.origin + '/api/login')
+exports.login = loginWith(window.location.origin + '/api/v1/login')
 exports.loginWith = loginWith
 
 function loginWith (loginUrl) {
@@ -151,7 +164,7 @@ function loginWith (loginUrl) {
   }
 }
 
-exports.logout = logoutWith(window.location.origin + '/api/logout')
+exports.logout = logoutWith(window.location.origin + '/api/v1/logout')
 exports.logoutWith = logoutWith
 
 function logoutWith (logoutUrl) {
@@ -165,7 +178,7 @@ function logoutWith (logoutUrl) {
   }
 }
 
-exports.changePassword = changePasswordWith(window.location.origin + '/api/change-password')
+exports.changePassword = changePasswordWith(window.location.origin + '/api/v1/change-password')
 exports.changePasswordWith = changePasswordWith
 
 function changePasswordWith (loginUrl) {
@@ -183,7 +196,7 @@ function changePasswordWith (loginUrl) {
   }
 }
 
-exports.forgotPassword = forgotPasswordWith(window.location.origin + '/api/forgot-password')
+exports.forgotPassword = forgotPasswordWith(window.location.origin + '/api/v1/forgot-password')
 exports.forgotPasswordWith = forgotPasswordWith
 
 function forgotPasswordWith (forgotUrl) {
@@ -200,7 +213,7 @@ function forgotPasswordWith (forgotUrl) {
   }
 }
 
-exports.resetPassword = resetPasswordWith(window.location.origin + '/api/reset-password')
+exports.resetPassword = resetPasswordWith(window.location.origin + '/api/v1/reset-password')
 exports.resetPasswordWith = resetPasswordWith
 
 function resetPasswordWith (resetUrl) {
@@ -218,7 +231,7 @@ function resetPasswordWith (resetUrl) {
   }
 }
 
-exports.changeEmail = changeEmailWith(window.location.origin + '/api/change-email')
+exports.changeEmail = changeEmailWith(window.location.origin + '/api/v1/change-email')
 exports.changeEmailWith = changeEmailWith
 
 function changeEmailWith (loginUrl) {
@@ -237,7 +250,7 @@ function changeEmailWith (loginUrl) {
   }
 }
 
-exports.purge = purgeWith(window.location.origin + '/api/purge')
+exports.purge = purgeWith(window.location.origin + '/api/v1/purge')
 exports.purgeWith = purgeWith
 
 function purgeWith (purgeUrl) {
@@ -255,7 +268,7 @@ function purgeWith (purgeUrl) {
   }
 }
 
-exports.shareAccount = shareAccountWith(window.location.origin + '/api/share-account')
+exports.shareAccount = shareAccountWith(window.location.origin + '/api/v1/share-account')
 exports.shareAccountWith = shareAccountWith
 
 function shareAccountWith (inviteUrl) {
@@ -280,7 +293,7 @@ function shareAccountWith (inviteUrl) {
   }
 }
 
-exports.join = joinWith(window.location.origin + '/api/join')
+exports.join = joinWith(window.location.origin + '/api/v1/join')
 exports.joinWith = joinWith
 
 function joinWith (joinUrl) {
@@ -299,7 +312,7 @@ function joinWith (joinUrl) {
   }
 }
 
-exports.createAccount = createAccountWith(window.location.origin + '/api/accounts')
+exports.createAccount = createAccountWith(window.location.origin + '/api/v1/accounts')
 exports.createAccountWith = createAccountWith
 
 function createAccountWith (createUrl) {
@@ -318,7 +331,7 @@ function createAccountWith (createUrl) {
   }
 }
 
-exports.retireAccount = retireAccountWith(window.location.origin + '/api/accounts')
+exports.retireAccount = retireAccountWith(window.location.origin + '/api/v1/accounts')
 exports.retireAccountWith = retireAccountWith
 
 function retireAccountWith (deleteUrl) {
@@ -332,7 +345,7 @@ function retireAccountWith (deleteUrl) {
   }
 }
 
-exports.setup = setupWith(window.location.origin + '/api/setup')
+exports.setup = setupWith(window.location.origin + '/api/v1/setup')
 exports.setupWith = setupWith
 
 function setupWith (setupUrl) {
@@ -351,7 +364,7 @@ function setupWith (setupUrl) {
   }
 }
 
-exports.setupStatus = setupStatusWith(window.location.origin + '/api/setup')
+exports.setupStatus = setupStatusWith(window.location.origin + '/api/v1/setup')
 exports.setupStatusWith = setupStatusWith
 
 function setupStatusWith (setupUrl) {
@@ -364,3 +377,69 @@ function setupStatusWith (setupUrl) {
       .then(handleFetchResponse)
   }
 }
+
+exports.getReferrerRules = getReferrerRulesWith(window.location.origin + '/api/v1/referrer-rules')
+exports.getReferrerRulesWith = getReferrerRulesWith
+
+function getReferrerRulesWith (rulesUrl) {
+  return function () {
+    return window
+      .fetch(rulesUrl, {
+        method: 'GET',
+        credentials: 'include'
+      })
+      .then(handleFetchResponse)
+  }
+}
+
+exports.getHints = getHintsWith(window.location.origin + '/api/v1/hints')
+exports.getHintsWith = getHintsWith
+
+function getHintsWith (hintsUrl) {
+  return function () {
+    return window
+      .fetch(hintsUrl, {
+        method: 'GET',
+        credentials: 'include'
+      })
+      .then(handleFetchResponse)
+  }
+}
+
+exports.getEventTypes = getEventTypesWith(window.location.origin + '/api/v1/accounts')
+exports.getEventTypesWith = getEventTypesWith
+
+function getEventTypesWith (accountsUrl) {
+  return function (accountId) {
+    var url = new window.URL(accountsUrl)
+    url.pathname += '/' + accountId + '/event-types'
+    return window
+      .fetch(url, {
+        method: 'GET',
+        credentials: 'include'
+      })
+      .then(handleFetchResponse)
+      .then(function (response) {
+        return response.eventTypes
+      })
+  }
+}
+
+exports.getGoals = getGoalsWith(window.location.origin + '/api/v1/accounts')
+exports.getGoalsWith = getGoalsWith
+
+function getGoalsWith (accountsUrl) {
+  return function (accountId) {
+    var url = new window.URL(accountsUrl)
+    url.pathname += '/' + accountId + '/goals'
+    return window
+      .fetch(url, {
+        method: 'GET',
+        credentials: 'include'
+      })
+      .then(handleFetchResponse)
+      .then(function (response) {
+        return response.goals
+      })
+  }
+}
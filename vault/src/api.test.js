@@ -50,4 +50,25 @@ describe('src/api.js', function () {
         })
     })
   })
+
+  describe('getReferrerRules', function () {
+    before(function () {
+      fetchMock.get('https://server.offen.dev/referrer-rules', {
+        status: 200,
+        body: { version: 'abc', rules: [{ pattern: '^t\\.co$', name: 'Twitter', category: 'social' }] }
+      })
+    })
+
+    after(function () {
+      fetchMock.restore()
+    })
+
+    it('calls the given endpoint', function () {
+      var get = api.getReferrerRulesWith('https://server.offen.dev/referrer-rules')
+      return get()
+        .then(function (result) {
+          assert.deepStrictEqual(result, { version: 'abc', rules: [{ pattern: '^t\\.co$', name: 'Twitter', category: 'social' }] })
+        })
+    })
+  })
 })
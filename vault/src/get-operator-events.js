@@ -19,9 +19,49 @@ function getOperatorEventsWith (queries, storage, api) {
     if (!matchingAccount) {
       return Promise.reject(new Error('No matching key found for account with id ' + query.accountId))
     }
-    return ensureSyncWith(storage, api)(query.accountId, matchingAccount.keyEncryptionKey)
-      .then(function (account) {
-        return queries.getDefaultStats(query.accountId, query, account.privateJwk)
+    // referrers are classified using the ruleset served by the server,
+    // failing to fetch it only skips classification
+    var referrerRules = Promise.resolve()
+      .then(function () {
+        return api.getReferrerRules()
+      })
+      .then(function (ruleset) {
+        return ruleset.rules
+      })
+      .catch(function () {
+        return null
+      })
+    // custom events are only aggregated for the event types defined for
+    // the account
+    var eventTypes = Promise.resolve()
+      .then(function () {
+        return api.getEventTypes(query.accountId)
+      })
+      .catch(function () {
+        return null
+      })
+    // conversions are only counted for the goals defined for the account
+    var goals = Promise.resolve()
+      .then(function () {
+        return api.getGoals(query.accountId)
+      })
+      .catch(function () {
+        return null
+      })
+    return Promise.all([
+      ensureSyncWith(storage, api)(query.accountId, matchingAccount.keyEncryptionKey),
+      referrerRules,
+      eventTypes,
+      goals
+    ])
+      .then(function (results) {
+        var account = results[0]
+        var statsQuery = Object.assign({}, query, {
+          referrerRules: results[1],
+          eventTypes: results[2],
+          goals: results[3]
+        })
+        return queries.getDefaultStats(query.accountId, statsQuery, account.privateJwk)
           .then(function (stats) {
             return Object.assign(stats, { account: account })
           })
@@ -82,7 +82,13 @@ describe('src/get-operator-events', function () {
           getAccount: sinon.stub().resolves({
             accountId: 'account-a',
             encryptedPrivateKey: encryptedPrivateKey
-          })
+          }),
+          getReferrerRules: sinon.stub().resolves({
+            version: 'abc',
+            rules: [{ pattern: '^t\\.co$', name: 'Twitter', category: 'social' }]
+          }),
+          getEventTypes: sinon.stub().resolves([{ name: 'signup', aggregation: 'count' }]),
+          getGoals: sinon.stub().rejects(new Error('did not work'))
         }
         var getOperatorEvents = getOperatorEventsWith(mockQueries, mockStorage, mockApi)
         return getOperatorEvents(
@@ -102,6 +108,12 @@ describe('src/get-operator-events', function () {
                 encryptedPrivateKey: encryptedPrivateKey
               }
             })
+            assert(mockQueries.getDefaultStats.calledWith('account-a', {
+              accountId: 'account-a',
+              referrerRules: [{ pattern: '^t\\.co$', name: 'Twitter', category: 'social' }],
+              eventTypes: [{ name: 'signup', aggregation: 'count' }],
+              goals: null
+            }))
           })
       })
     })
@@ -18,7 +18,7 @@ function handleAnalyticsEventWith (relayEvent) {
   return function (message) {
     var accountId = message.payload.accountId
     var event = message.payload.event
-    return relayEvent(accountId, event, false)
+    return relayEvent(accountId, event, false, message.payload.honeypot, message.payload.domain)
   }
 }
 
@@ -29,7 +29,7 @@ function handleAnonymousEventWith (relayEvent) {
   return function (message) {
     var accountId = message.payload.accountId
     var event = message.payload.event
-    return relayEvent(accountId, event, true)
+    return relayEvent(accountId, event, true, message.payload.honeypot, message.payload.domain)
   }
 }
 
@@ -56,6 +56,13 @@ function eventDuplexer (event, respond, next) {
     timestamp: now,
     sessionId: getSessionId(event.data.payload.accountId)
   })
+  // the domain the event has been recorded on is taken from the origin of
+  // the message so that it cannot be spoofed by the embedding page. It is
+  // sent in plaintext so the server can check it against the domains of
+  // the account.
+  try {
+    event.data.payload.domain = new window.URL(event.origin).hostname
+  } catch (err) {}
   // strip search parameters from referrers as they might contain sensitive information
   if (event.data.payload.event.referrer) {
     var cleanedReferrer = new window.URL(event.data.payload.event.referrer)
@@ -93,6 +93,29 @@ function Queries (storage) {
     var realtimeLowerBound = toLowerBound(subMinutes(now, 15))
     var realtimeUpperBound = toUpperBound(now)
     var realtimeEvents = getAllEvents(accountId, realtimeLowerBound, realtimeUpperBound)
+
+    // There are two types of queries happening here: those that rely solely
+    // on the IndexedDB indices, and those that require the event payload
+    // (which might be encrypted and therefore not indexable).
+    // Theoretically *all* queries could be done on the set of events after
+    // encryption, yet it seems using the IndexedDB API where possible makes
+    // more sense and performs better.
+    var decryptions = [eventsInBounds, realtimeEvents]
+      .map(function (asyncSet) {
+        return asyncSet
+          .then(function (set) {
+            return accountId && privateJwk
+              ? doDecrypt(set, accountId, privateJwk)
+              : set
+          })
+          .then(function (events) {
+            return _.compact(events.map(validateAndParseEvent))
+          })
+      })
+
+    var decryptedEvents = decryptions[0]
+    var realtime = decryptions[1]
+
     // `pageviews` is a list of basic metrics grouped by the given range
     // and resolution. It contains the number of pageviews, unique visitors
     // for operators and accounts for users.
@@ -104,7 +127,17 @@ function Queries (storage) {
         var upperBound = toUpperBound(endOf[resolution](date))
         var eventsInBounds = getAllEvents(accountId, lowerBound, upperBound)
 
-        var pageviews = stats.pageviews(eventsInBounds)
+        // pageviews are counted using the index, so custom events need to
+        // be subtracted after decrypting them
+        var customEventsInBounds = decryptedEvents.then(function (events) {
+          return _.filter(events, function (event) {
+            return !isPageview(event) && event.eventId >= lowerBound && event.eventId <= upperBound
+          })
+        })
+        var pageviews = Promise.all([stats.pageviews(eventsInBounds), stats.pageviews(customEventsInBounds)])
+          .then(function (counts) {
+            return counts[0] - counts[1]
+          })
         var visitors = stats.visitors(eventsInBounds)
         var accounts = stats.accounts(eventsInBounds)
 
@@ -143,41 +176,45 @@ function Queries (storage) {
         return stats.retention.apply(stats, chunks)
       })
 
-    // There are two types of queries happening here: those that rely solely
-    // on the IndexedDB indices, and those that require the event payload
-    // (which might be encrypted and therefore not indexable).
-    // Theoretically *all* queries could be done on the set of events after
-    // encryption, yet it seems using the IndexedDB API where possible makes
-    // more sense and performs better.
-    var decryptions = [eventsInBounds, realtimeEvents]
-      .map(function (asyncSet) {
-        return asyncSet
-          .then(function (set) {
-            return accountId && privateJwk
-              ? doDecrypt(set, accountId, privateJwk)
-              : set
-          })
-          .then(function (events) {
-            return _.compact(events.map(validateAndParseEvent))
+    // custom events are not pageviews, so most metrics skip them
+    var decryptedPageviews = decryptedEvents.then(pageviewsOnly)
+    var realtimePageviews = realtime.then(pageviewsOnly)
+    var loss = stats.loss(decryptedEvents)
+    var uniqueSessions = stats.uniqueSessions(decryptedPageviews)
+    var bounceRate = stats.bounceRate(decryptedPageviews)
+    var referrers = stats.referrers(decryptedPageviews)
+    var referrerCategories = stats.referrerCategories(decryptedPageviews, query && query.referrerRules)
+    var pages = stats.pages(decryptedPageviews)
+    var domains = stats.domains(decryptedPageviews)
+    var campaigns = stats.campaigns(decryptedPageviews)
+    var sources = stats.sources(decryptedPageviews)
+    var campaignPerformance = stats.campaignPerformance(decryptedPageviews)
+    var avgPageload = stats.avgPageload(decryptedPageviews)
+    var pagePerformance = stats.pagePerformance(decryptedPageviews)
+    var avgPageDepth = stats.avgPageDepth(decryptedPageviews)
+    var landingPages = stats.landingPages(decryptedPageviews)
+    var exitPages = stats.exitPages(decryptedPageviews)
+    var mobileShare = stats.mobileShare(decryptedPageviews)
+
+    // `funnels` contains the conversion rates of the funnels defined for the
+    // account. Definitions are passed by the caller as they are stored on
+    // the server.
+    var funnels = Promise.all(((query && query.funnels) || [])
+      .map(function (funnel) {
+        return stats.funnel(decryptedEvents, funnel.steps)
+          .then(function (steps) {
+            return { funnelId: funnel.funnelId, name: funnel.name, steps: steps }
           })
-      })
+      }))
 
-    var decryptedEvents = decryptions[0]
-    var realtime = decryptions[1]
-    var loss = stats.loss(decryptedEvents)
-    var uniqueSessions = stats.uniqueSessions(decryptedEvents)
-    var bounceRate = stats.bounceRate(decryptedEvents)
-    var referrers = stats.referrers(decryptedEvents)
-    var pages = stats.pages(decryptedEvents)
-    var campaigns = stats.campaigns(decryptedEvents)
-    var sources = stats.sources(decryptedEvents)
-    var avgPageload = stats.avgPageload(decryptedEvents)
-    var avgPageDepth = stats.avgPageDepth(decryptedEvents)
-    var landingPages = stats.landingPages(decryptedEvents)
-    var exitPages = stats.exitPages(decryptedEvents)
-    var mobileShare = stats.mobileShare(decryptedEvents)
-
-    var livePages = stats.activePages(realtime)
+    // `customEvents` contains the aggregated values of the custom event
+    // types defined for the account.
+    var customEvents = stats.customEvents(decryptedEvents, (query && query.eventTypes) || [])
+
+    // `goals` contains the conversions of the goals defined for the account.
+    var goals = stats.goals(decryptedEvents, (query && query.goals) || [])
+
+    var livePages = stats.activePages(realtimePageviews)
     var liveUsers = stats.visitors(realtime)
 
     return Promise
@@ -201,7 +238,14 @@ function Queries (storage) {
         sources,
         retentionMatrix,
         empty,
-        returningUsers
+        returningUsers,
+        funnels,
+        campaignPerformance,
+        referrerCategories,
+        customEvents,
+        goals,
+        pagePerformance,
+        domains
       ])
       .then(function (results) {
         return {
@@ -225,6 +269,13 @@ function Queries (storage) {
           retentionMatrix: results[17],
           empty: results[18],
           returningUsers: results[19],
+          funnels: results[20],
+          campaignPerformance: results[21],
+          referrerCategories: results[22],
+          customEvents: results[23],
+          goals: results[24],
+          pagePerformance: results[25],
+          domains: results[26],
           resolution: resolution,
           range: range
         }
@@ -239,6 +290,14 @@ function Queries (storage) {
   }
 }
 
+function isPageview (event) {
+  return !event.payload || event.payload.type !== 'CUSTOM'
+}
+
+function pageviewsOnly (events) {
+  return _.filter(events, isPageview)
+}
+
 module.exports.validateAndParseEvent = validateAndParseEvent
 function validateAndParseEvent (event) {
   if (!payloadSchema(event.payload)) {
@@ -60,13 +60,14 @@ describe('src/queries.js', function () {
                 'referrers', 'pages', 'pageviews', 'bounceRate', 'loss',
                 'avgPageload', 'avgPageDepth', 'landingPages', 'exitPages',
                 'mobileShare', 'livePages', 'liveUsers', 'campaigns',
-                'sources', 'retentionMatrix', 'empty', 'returningUsers', 'resolution', 'range'
+                'sources', 'retentionMatrix', 'empty', 'returningUsers', 'funnels', 'campaignPerformance', 'referrerCategories', 'customEvents', 'goals', 'pagePerformance', 'domains', 'resolution', 'range'
               ]
             )
             assert.strictEqual(data.uniqueUsers, 0)
             assert.strictEqual(data.uniqueAccounts, 0)
             assert.strictEqual(data.uniqueSessions, 0)
             assert.strictEqual(data.mobileShare, null)
+            assert.deepStrictEqual(data.funnels, [])
 
             assert.deepStrictEqual(data.referrers, [])
 
@@ -324,7 +325,7 @@ describe('src/queries.js', function () {
                 'referrers', 'pages', 'pageviews', 'bounceRate', 'loss',
                 'avgPageload', 'avgPageDepth', 'landingPages', 'exitPages',
                 'mobileShare', 'livePages', 'liveUsers', 'campaigns',
-                'sources', 'retentionMatrix', 'empty', 'returningUsers', 'resolution', 'range'
+                'sources', 'retentionMatrix', 'empty', 'returningUsers', 'funnels', 'campaignPerformance', 'referrerCategories', 'customEvents', 'goals', 'pagePerformance', 'domains', 'resolution', 'range'
               ]
             )
 
@@ -375,7 +376,7 @@ describe('src/queries.js', function () {
                 'referrers', 'pages', 'pageviews', 'bounceRate', 'loss',
                 'avgPageload', 'avgPageDepth', 'landingPages', 'exitPages',
                 'mobileShare', 'livePages', 'liveUsers', 'campaigns',
-                'sources', 'retentionMatrix', 'empty', 'returningUsers', 'resolution', 'range'
+                'sources', 'retentionMatrix', 'empty', 'returningUsers', 'funnels', 'campaignPerformance', 'referrerCategories', 'customEvents', 'goals', 'pagePerformance', 'domains', 'resolution', 'range'
               ]
             )
 
@@ -419,7 +420,7 @@ describe('src/queries.js', function () {
                 'referrers', 'pages', 'pageviews', 'bounceRate', 'loss',
                 'avgPageload', 'avgPageDepth', 'landingPages', 'exitPages',
                 'mobileShare', 'livePages', 'liveUsers', 'campaigns',
-                'sources', 'retentionMatrix', 'empty', 'returningUsers', 'resolution', 'range'
+                'sources', 'retentionMatrix', 'empty', 'returningUsers', 'funnels', 'campaignPerformance', 'referrerCategories', 'customEvents', 'goals', 'pagePerformance', 'domains', 'resolution', 'range'
               ]
             )
 
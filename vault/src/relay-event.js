@@ -13,31 +13,50 @@ module.exports.relayEventWith = relayEventWith
 // relayEvent transmits the given event to the server API associating it with
 // the given accountId. It ensures a local user secret exists for the given
 // accountId and uses it to encrypt the event payload before performing the request.
+// In case `honeypot` is set, the server is told that the event has been
+// sent by a remote controlled browser. `domain` is the host name of the page
+// the event has been recorded on.
 function relayEventWith (api, ensureUserSecret) {
-  var relayEvent = bindCrypto(function (accountId, payload, anonymous) {
+  // hints are requested once and added to all events. Events are still sent
+  // in case hints cannot be retrieved.
+  var hints = null
+  function getHints () {
+    hints = hints || api.getHints().catch(function () {
+      hints = null
+      return {}
+    })
+    return hints
+  }
+
+  var relayEvent = bindCrypto(function (accountId, payload, anonymous, honeypot, domain) {
     var crypto = this
     // `flush` is not supposed to be part of the public signature, but will only
     // be used when the function recursively calls itself
-    var flush = arguments[3] || false
+    var flush = arguments[5] || false
     // if data is collected anonymously, the account's public key is used
     // for encrypting the payload instead
     var getSecret = anonymous
       ? api.getPublicKey(accountId).then(crypto.encryptAsymmetricWith)
       : ensureUserSecret(accountId, flush).then(crypto.encryptSymmetricWith)
 
-    return getSecret
-      .then(function (encryptEventPayload) {
+    return Promise.all([getSecret, getHints()])
+      .then(function (results) {
+        var encryptEventPayload = results[0]
+        var country = results[1] && results[1].country
+        if (payload && country) {
+          payload = Object.assign({}, payload, { country: country })
+        }
         return encryptEventPayload(payload)
       })
       .then(function (encryptedEventPayload) {
         return api
-          .postEvent(accountId, encryptedEventPayload, anonymous)
+          .postEvent(accountId, encryptedEventPayload, anonymous, honeypot, domain)
           .catch(function (err) {
             // a 400 response is sent in case no cookie is present in the request.
             // This means the secret exchange can happen one more time
             // before retrying to send the event.
             if (err.status === 400 && !flush && !anonymous) {
-              return relayEvent(accountId, payload, anonymous, true)
+              return relayEvent(accountId, payload, anonymous, honeypot, domain, true)
             }
             throw err
           })
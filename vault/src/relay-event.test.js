@@ -30,9 +30,14 @@ describe('src/relay-event.js', function () {
       return Promise.resolve(userSecret)
     }
 
+    function mockGetHints () {
+      return Promise.resolve({ country: 'DE' })
+    }
+
     it('sends an augmented and encrypted event payload to the server', function (done) {
       let err
       var mockApi = {
+        getHints: mockGetHints,
         postEvent: function (accountId, payload) {
           try {
             assert(payload)
@@ -54,6 +59,7 @@ describe('src/relay-event.js', function () {
     it('retries on a 400 error', function () {
       var numCalled = 0
       var mockApi = {
+        getHints: mockGetHints,
         postEvent: function (event) {
           numCalled++
           if (numCalled === 1) {
@@ -71,8 +77,90 @@ describe('src/relay-event.js', function () {
         })
     })
 
+    it('passes the honeypot flag when retrying', function () {
+      var flags = []
+      var mockApi = {
+        getHints: mockGetHints,
+        postEvent: function (accountId, payload, anonymous, honeypot) {
+          flags.push(honeypot)
+          if (flags.length === 1) {
+            var err = new Error('bad request')
+            err.status = 400
+            return Promise.reject(err)
+          }
+          return Promise.resolve()
+        }
+      }
+      var relayEvent = relayEventWith(mockApi, mockEnsureUserSecret)
+      return relayEvent('account-id-token', { payload: 'data' }, false, true)
+        .then(function () {
+          assert.deepStrictEqual(flags, [true, true])
+        })
+    })
+
+    it('passes the domain when retrying', function () {
+      var domains = []
+      var mockApi = {
+        getHints: mockGetHints,
+        postEvent: function (accountId, payload, anonymous, honeypot, domain) {
+          domains.push(domain)
+          if (domains.length === 1) {
+            var err = new Error('bad request')
+            err.status = 400
+            return Promise.reject(err)
+          }
+          return Promise.resolve()
+        }
+      }
+      var relayEvent = relayEventWith(mockApi, mockEnsureUserSecret)
+      return relayEvent('account-id-token', { payload: 'data' }, false, false, 'www.offen.dev')
+        .then(function () {
+          assert.deepStrictEqual(domains, ['www.offen.dev', 'www.offen.dev'])
+        })
+    })
+
+    it('requests hints only once', function () {
+      var numCalled = 0
+      var mockApi = {
+        getHints: function () {
+          numCalled++
+          return Promise.resolve({ country: 'DE' })
+        },
+        postEvent: function () {
+          return Promise.resolve()
+        }
+      }
+      var relayEvent = relayEventWith(mockApi, mockEnsureUserSecret)
+      return relayEvent('account-id-token', { payload: 'data' })
+        .then(function () {
+          return relayEvent('account-id-token', { payload: 'other' })
+        })
+        .then(function () {
+          assert.strictEqual(numCalled, 1)
+        })
+    })
+
+    it('sends events when hints cannot be retrieved', function () {
+      var numCalled = 0
+      var mockApi = {
+        getHints: function () {
+          return Promise.reject(new Error('Does not work.'))
+        },
+        postEvent: function () {
+          numCalled++
+          return Promise.resolve()
+        }
+      }
+      var relayEvent = relayEventWith(mockApi, mockEnsureUserSecret)
+      return relayEvent('account-id-token', { payload: 'data' })
+        .then(function () {
+          assert.strictEqual(numCalled, 1)
+        })
+    })
+
     it('rejects on api failing', function (done) {
       var mockApi = {
+        getHints: mockGetHints,
         postEvent: function (event) {
           return Promise.reject(new Error('Does not work.'))
         }
@@ -65,9 +65,90 @@ function referrers (events) {
   })
 }
 
+// `referrerCategories` classifies the referrer of the first event of each
+// session as direct, internal, search, social or other traffic using the
+// given rules, and returns the number of sessions per category along with
+// the sources it consists of. Rules match the host of the referrer and are
+// evaluated in order. Without rules, search and social traffic is counted
+// as other.
+exports.referrerCategories = consumeAsync(referrerCategories)
+
+function referrerCategories (events, rules) {
+  var matchers = _.map(rules || [], function (rule) {
+    return _.extend({ re: new RegExp(rule.pattern) }, rule)
+  })
+  return _.chain(events)
+    .filter(function (event) {
+      return event.secretId && event.payload && event.payload.sessionId && event.payload.href
+    })
+    .groupBy(_.property(['payload', 'sessionId']))
+    .values()
+    .map(function (eventsInSession) {
+      var first = _.first(_.sortBy(eventsInSession, 'eventId'))
+      var referrer = first.payload.referrer
+      if (!referrer) {
+        return { category: 'direct', source: null }
+      }
+      if (referrer.host === first.payload.href.host) {
+        return { category: 'internal', source: null }
+      }
+      var host = referrer.host || referrer.href
+      var match = _.find(matchers, function (matcher) {
+        return matcher.re.test(host)
+      })
+      return match
+        ? { category: match.category, source: match.name }
+        : { category: 'other', source: host }
+    })
+    .groupBy('category')
+    .pairs()
+    .map(function (pair) {
+      return {
+        key: pair[0],
+        count: pair[1].length,
+        sources: _.chain(pair[1])
+          .pluck('source')
+          .compact()
+          .countBy(_.identity)
+          .pairs()
+          .map(function (sourcePair) {
+            return { key: sourcePair[0], count: sourcePair[1] }
+          })
+          .sortBy('count')
+          .reverse()
+          .value()
+      }
+    })
+    .sortBy('count')
+    .reverse()
+    .value()
+}
+
 // `campaigns` groups the referrer values by their `utm_campaign` if present
 exports.campaigns = consumeAsync(_queryParam('utm_campaign'))
 
+var utmPayloadKeys = {
+  utm_source: 'utmSource',
+  utm_medium: 'utmMedium',
+  utm_campaign: 'utmCampaign'
+}
+
+// `_utmValue` returns the value of the given UTM parameter for an event.
+// Events that have been recorded before UTM parameters have been captured
+// separately fall back to the query string of their URL.
+function _utmValue (event, key) {
+  var captured = event.payload[utmPayloadKeys[key]]
+  if (captured) {
+    return captured
+  }
+  if (!event.payload.href) {
+    return null
+  }
+  return event.payload.rawHref
+    ? event.payload.rawHref.searchParams.get(key)
+    : event.payload.href.searchParams.get(key)
+}
+
 function _queryParam (key) {
   return function (events) {
     return _.chain(events)
@@ -75,9 +156,7 @@ function _queryParam (key) {
       .map(function (event) {
         return {
           sessionId: event.payload.sessionId,
-          value: event.payload.rawHref
-            ? event.payload.rawHref.searchParams.get(key)
-            : event.payload.href.searchParams.get(key)
+          value: _utmValue(event, key)
         }
       })
       .uniq(false, JSON.stringify)
@@ -118,6 +197,56 @@ function _queryParam (key) {
 // `sources` groups the referrer values by their `utm_source` if present
 exports.sources = consumeAsync(_queryParam('utm_source'))
 
+// `campaignPerformance` attributes sessions to the combination of UTM
+// source, medium and campaign of their first event and returns the number
+// of sessions, unique visitors, pageviews and the bounce rate for each
+// combination, sorted by the number of sessions.
+exports.campaignPerformance = consumeAsync(campaignPerformance)
+
+function campaignPerformance (events) {
+  return _.chain(events)
+    .filter(function (event) {
+      return event.secretId && event.payload && event.payload.sessionId
+    })
+    .groupBy(_.property(['payload', 'sessionId']))
+    .values()
+    .map(function (eventsInSession) {
+      var first = _.first(_.sortBy(eventsInSession, 'eventId'))
+      return {
+        source: _utmValue(first, 'utm_source'),
+        medium: _utmValue(first, 'utm_medium'),
+        campaign: _utmValue(first, 'utm_campaign'),
+        secretId: first.secretId,
+        pageviews: eventsInSession.length
+      }
+    })
+    .filter(function (session) {
+      return session.source || session.medium || session.campaign
+    })
+    .groupBy(function (session) {
+      return JSON.stringify([session.source, session.medium, session.campaign])
+    })
+    .values()
+    .map(function (sessions) {
+      return {
+        source: sessions[0].source,
+        medium: sessions[0].medium,
+        campaign: sessions[0].campaign,
+        sessions: sessions.length,
+        visitors: _.uniq(_.pluck(sessions, 'secretId')).length,
+        pageviews: _.reduce(sessions, function (sum, session) {
+          return sum + session.pageviews
+        }, 0),
+        bounceRate: _.filter(sessions, function (session) {
+          return session.pageviews === 1
+        }).length / sessions.length
+      }
+    })
+    .sortBy('sessions')
+    .reverse()
+    .value()
+}
+
 function _referrers (events, groupFn) {
   var uniqueForeign = events
     .filter(function (event) {
@@ -163,6 +292,34 @@ function _referrers (events, groupFn) {
     .value()
 }
 
+// `domains` breaks down pageviews by the domain of the page they have been
+// recorded on and returns the number of pageviews, unique visitors and
+// sessions for each domain, sorted by the number of pageviews.
+exports.domains = consumeAsync(domains)
+
+function domains (events) {
+  return _.chain(events)
+    .filter(function (event) {
+      return event.payload && event.payload.href
+    })
+    .groupBy(function (event) {
+      return event.payload.href.hostname
+    })
+    .pairs()
+    .map(function (pair) {
+      var eventsOnDomain = pair[1]
+      return {
+        key: pair[0],
+        pageviews: eventsOnDomain.length,
+        visitors: _.chain(eventsOnDomain).pluck('secretId').compact().uniq().value().length,
+        sessions: _.chain(eventsOnDomain).map(_.property(['payload', 'sessionId'])).compact().uniq().value().length
+      }
+    })
+    .sortBy('pageviews')
+    .reverse()
+    .value()
+}
+
 // `pages` contains all pages visited sorted by the number of pageviews.
 // URLs are stripped off potential query strings and hash parameters
 // before grouping.
@@ -250,6 +407,46 @@ function avgPageload (events) {
   return total / count
 }
 
+// `pagePerformance` contains the 50th and 95th percentile of the load time
+// buckets sent with pageviews, grouped by a clean URL and sorted by the
+// number of samples. Load times are only sent by sites that opted into
+// collecting them, so pages without samples are skipped. As buckets are
+// upper bounds, percentiles are upper bounds too.
+exports.pagePerformance = consumeAsync(pagePerformance)
+
+function pagePerformance (events) {
+  return _.chain(events)
+    .filter(function (event) {
+      return event.payload && event.payload.href && event.payload.loadTime > 0
+    })
+    .groupBy(function (event) {
+      return event.payload.href.origin + event.payload.href.pathname
+    })
+    .pairs()
+    .map(function (pair) {
+      var values = _.chain(pair[1])
+        .map(_.property(['payload', 'loadTime']))
+        .sortBy(_.identity)
+        .value()
+      return {
+        key: pair[0],
+        p50: percentile(values, 0.5),
+        p95: percentile(values, 0.95),
+        count: values.length
+      }
+    })
+    .sortBy('count')
+    .reverse()
+    .value()
+}
+
+// `percentile` returns the nearest-rank percentile p of the given sorted
+// list of values.
+function percentile (sortedValues, p) {
+  var rank = Math.ceil(p * sortedValues.length)
+  return sortedValues[Math.max(rank - 1, 0)]
+}
+
 // `avgPageDepth` calculates the average session length in the given
 // set of events
 exports.avgPageDepth = consumeAsync(avgPageDepth)
@@ -421,6 +618,161 @@ function returningUsers (events, allEvents) {
   return 1 - (newUsers.length / usersInRange.length)
 }
 
+// `funnel` computes the number of users that have completed each step of
+// the given funnel in order. Steps either match the URL (stripped off query
+// and hash parameters) or the type of an event, which for custom events is
+// their name. Conversion rates are given relative to the first step.
+exports.funnel = consumeAsync(funnel)
+
+function funnel (events, steps) {
+  steps = steps || []
+  var matchers = steps.map(function (step) {
+    if (step.type === 'url') {
+      var url = new window.URL(step.value)
+      if (!/\/$/.test(url.pathname)) {
+        url.pathname += '/'
+      }
+      var href = url.origin + url.pathname
+      return function (event) {
+        return Boolean(event.payload.href) &&
+          event.payload.href.origin + event.payload.href.pathname === href
+      }
+    }
+    return function (event) {
+      return event.payload.type === step.value ||
+        (event.payload.type === 'CUSTOM' && event.payload.name === step.value)
+    }
+  })
+
+  var completed = _.chain(events)
+    .filter(function (event) {
+      return event.secretId && event.payload
+    })
+    .groupBy('secretId')
+    .values()
+    .map(function (eventsOfUser) {
+      return _.chain(eventsOfUser)
+        .sortBy('eventId')
+        .reduce(function (reached, event) {
+          if (reached < matchers.length && matchers[reached](event)) {
+            return reached + 1
+          }
+          return reached
+        }, 0)
+        .value()
+    })
+    .value()
+
+  var result = steps.map(function (step, index) {
+    var users = _.filter(completed, function (reached) {
+      return reached > index
+    }).length
+    return { type: step.type, value: step.value, users: users }
+  })
+  var entered = result.length ? result[0].users : 0
+  return result.map(function (step) {
+    return _.extend(step, {
+      conversion: entered === 0 ? 0 : step.users / entered
+    })
+  })
+}
+
+// `customEvents` aggregates the custom events of each of the given event
+// types. Depending on the aggregation of the type, `value` is the number of
+// events, the number of unique users, or the sum or average of the values
+// sent with the events. Custom events of types that are not given are
+// skipped.
+exports.customEvents = consumeAsync(customEvents)
+
+function customEvents (events, eventTypes) {
+  var byName = _.chain(events)
+    .filter(function (event) {
+      return event.payload && event.payload.type === 'CUSTOM'
+    })
+    .groupBy(_.property(['payload', 'name']))
+    .value()
+
+  return _.map(eventTypes || [], function (eventType) {
+    var matching = byName[eventType.name] || []
+    var values = _.chain(matching)
+      .map(_.property(['payload', 'value']))
+      .filter(_.isFinite)
+      .value()
+    var sum = _.reduce(values, function (acc, value) {
+      return acc + value
+    }, 0)
+    var value
+    switch (eventType.aggregation) {
+      case 'users':
+        value = countKeys('secretId', true)(matching)
+        break
+      case 'sum':
+        value = sum
+        break
+      case 'average':
+        value = values.length ? sum / values.length : 0
+        break
+      default:
+        value = matching.length
+    }
+    return {
+      name: eventType.name,
+      aggregation: eventType.aggregation,
+      count: matching.length,
+      value: value
+    }
+  })
+}
+
+// `goals` computes the conversions of each of the given goals. URL goals
+// match pageviews whose URL (stripped off query and hash parameters) matches
+// the goal's pattern, where `*` matches any sequence of characters. Event
+// goals match custom events of the given name. Conversion rates are given
+// relative to the number of unique users.
+exports.goals = consumeAsync(goals)
+
+function goals (events, definitions) {
+  events = _.filter(events, function (event) {
+    return event.secretId && event.payload
+  })
+  var visitors = countKeys('secretId', true)(events)
+
+  return _.map(definitions || [], function (goal) {
+    var matches
+    if (goal.type === 'url') {
+      var pattern = goalPattern(goal.value)
+      matches = function (event) {
+        return event.payload.type !== 'CUSTOM' &&
+          Boolean(event.payload.href) &&
+          pattern.test(event.payload.href.origin + event.payload.href.pathname)
+      }
+    } else {
+      matches = function (event) {
+        return event.payload.type === 'CUSTOM' && event.payload.name === goal.value
+      }
+    }
+    var matching = _.filter(events, matches)
+    var users = countKeys('secretId', true)(matching)
+    return {
+      goalId: goal.goalId,
+      name: goal.name,
+      type: goal.type,
+      value: goal.value,
+      conversions: matching.length,
+      users: users,
+      conversionRate: visitors === 0 ? 0 : users / visitors
+    }
+  })
+}
+
+function goalPattern (value) {
+  var parts = value.replace(/\/+$/, '').split('*').map(function (part) {
+    return part.replace(/[.+?^${}()|[\]\\]/g, '\\$&')
+  })
+  // trailing slashes are ignored when matching, same as for funnel steps
+  return new RegExp('^' + parts.join('.*') + '/?$')
+}
+
 exports.pageviews = consumeAsync(countKeys('secretId', false))
 // `visitors` is the number of unique users for the given
 //  set of events.
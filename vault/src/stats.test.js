@@ -120,6 +120,32 @@ describe('src/stats.js', function () {
     })
   })
 
+  describe('stats.pagePerformance(events)', function () {
+    it('calculates percentiles of load times per page', function () {
+      return stats.pagePerformance([
+        {},
+        { payload: { href: new window.URL('https://www.example.net/foo'), loadTime: 250 } },
+        { payload: { href: new window.URL('https://www.example.net/foo?param=bar'), loadTime: 100 } },
+        { payload: { href: new window.URL('https://www.example.net/foo'), loadTime: 5000 } },
+        { payload: { href: new window.URL('https://www.example.net/foo'), loadTime: 250 } },
+        { payload: { href: new window.URL('https://www.example.net/foo') } },
+        { payload: { href: new window.URL('https://beep.boop/site#!/foo'), loadTime: 1000 } }
+      ])
+        .then(function (result) {
+          assert.deepStrictEqual(result, [
+            { key: 'https://www.example.net/foo', p50: 250, p95: 5000, count: 4 },
+            { key: 'https://beep.boop/site', p50: 1000, p95: 1000, count: 1 }
+          ])
+        })
+    })
+    it('returns an empty array when no load times are present', function () {
+      return stats.pagePerformance([{ payload: { href: new window.URL('https://www.example.net/foo') } }])
+        .then(function (result) {
+          assert.deepStrictEqual(result, [])
+        })
+    })
+  })
+
   describe('stats.avgPageDepth(events)', function () {
     it('returns the average session length', function () {
       return stats.avgPageDepth([
@@ -235,6 +261,45 @@ describe('src/stats.js', function () {
     })
   })
 
+  describe('stats.referrerCategories(events, rules)', function () {
+    var rules = [
+      { pattern: '^(www\\.)?google\\.[a-z]{2,3}$', name: 'Google', category: 'search' },
+      { pattern: '^t\\.co$', name: 'Twitter', category: 'social' }
+    ]
+    var page = new window.URL('https://www.example.net/')
+    var events = [
+      {},
+      { eventId: 'e-01', secretId: 'user-a', payload: { sessionId: 'session-a', href: page, referrer: new window.URL('https://www.google.de/') } },
+      { eventId: 'e-02', secretId: 'user-a', payload: { sessionId: 'session-a', href: page, referrer: new window.URL('https://www.example.net/foo/') } },
+      { eventId: 'e-03', secretId: 'user-b', payload: { sessionId: 'session-b', href: page, referrer: new window.URL('https://google.com/') } },
+      { eventId: 'e-04', secretId: 'user-b', payload: { sessionId: 'session-c', href: page, referrer: new window.URL('https://t.co/') } },
+      { eventId: 'e-05', secretId: 'user-c', payload: { sessionId: 'session-d', href: page, referrer: new window.URL('https://www.example.net/bar/') } },
+      { eventId: 'e-06', secretId: 'user-c', payload: { sessionId: 'session-e', href: page, referrer: '' } },
+      { eventId: 'e-07', secretId: 'user-c', payload: { sessionId: 'session-f', href: page, referrer: '' } },
+      { eventId: 'e-08', secretId: 'user-d', payload: { sessionId: 'session-g', href: page, referrer: '' } },
+      { eventId: 'e-09', secretId: null, payload: { sessionId: 'session-h', href: page, referrer: new window.URL('https://t.co/') } }
+    ]
+    it('returns the number of sessions per category', function () {
+      return stats.referrerCategories(events, rules)
+        .then(function (result) {
+          assert.deepStrictEqual(result, [
+            { key: 'direct', count: 3, sources: [] },
+            { key: 'search', count: 2, sources: [{ key: 'Google', count: 2 }] },
+            { key: 'internal', count: 1, sources: [] },
+            { key: 'social', count: 1, sources: [{ key: 'Twitter', count: 1 }] }
+          ])
+        })
+    })
+    it('counts unknown referrers as other when given no rules', function () {
+      return stats.referrerCategories(events.slice(3, 5), null)
+        .then(function (result) {
+          assert.deepStrictEqual(result, [
+            { key: 'other', count: 2, sources: [{ key: 't.co', count: 1 }, { key: 'google.com', count: 1 }] }
+          ])
+        })
+    })
+  })
+
   describe('stats.campaigns(events)', function () {
     it('returns sorted referrer campaigns from foreign domains grouped by host', function () {
       return stats.campaigns([
@@ -253,6 +318,17 @@ describe('src/stats.js', function () {
           ])
         })
     })
+    it('prefers captured UTM parameters over the query string', function () {
+      return stats.campaigns([
+        { payload: { sessionId: 'session-a', href: new window.URL('https://www.example.net/foo?utm_campaign=beep'), utmCampaign: 'boop' } },
+        { payload: { sessionId: 'session-b', href: new window.URL('https://www.example.net/foo'), utmCampaign: 'boop' } }
+      ])
+        .then(function (result) {
+          assert.deepStrictEqual(result, [
+            { key: 'boop', count: [2, 1] }
+          ])
+        })
+    })
     it('returns an empty array when given an empty array', function () {
       return stats.campaigns([])
         .then(function (result) {
@@ -261,6 +337,58 @@ describe('src/stats.js', function () {
     })
   })
 
+  describe('stats.campaignPerformance(events)', function () {
+    it('returns metrics for each combination of UTM parameters', function () {
+      return stats.campaignPerformance([
+        {},
+        { eventId: 'e-01', secretId: 'user-a', payload: { sessionId: 'session-a', href: new window.URL('https://www.example.net/?utm_source=news'), utmSource: 'news', utmMedium: 'email' } },
+        { eventId: 'e-02', secretId: 'user-a', payload: { sessionId: 'session-a', href: new window.URL('https://www.example.net/foo') } },
+        { eventId: 'e-03', secretId: 'user-a', payload: { sessionId: 'session-b', href: new window.URL('https://www.example.net/?utm_source=news&utm_medium=email') } },
+        { eventId: 'e-04', secretId: 'user-b', payload: { sessionId: 'session-c', href: new window.URL('https://www.example.net/'), utmSource: 'news', utmMedium: 'email' } },
+        { eventId: 'e-05', secretId: 'user-b', payload: { sessionId: 'session-d', href: new window.URL('https://www.example.net/'), utmCampaign: 'sale' } },
+        { eventId: 'e-06', secretId: 'user-c', payload: { sessionId: 'session-e', href: new window.URL('https://www.example.net/') } },
+        { eventId: 'e-07', secretId: null, payload: { sessionId: 'session-f', href: new window.URL('https://www.example.net/'), utmCampaign: 'sale' } }
+      ])
+        .then(function (result) {
+          assert.deepStrictEqual(result, [
+            { source: 'news', medium: 'email', campaign: null, sessions: 3, visitors: 2, pageviews: 4, bounceRate: 2 / 3 },
+            { source: null, medium: null, campaign: 'sale', sessions: 1, visitors: 1, pageviews: 1, bounceRate: 1 }
+          ])
+        })
+    })
+    it('returns an empty array when given an empty array', function () {
+      return stats.campaignPerformance([])
+        .then(function (result) {
+          assert.deepStrictEqual(result, [])
+        })
+    })
+  })
+
+  describe('stats.domains(events)', function () {
+    it('breaks down pageviews by domain', function () {
+      return stats.domains([
+        {},
+        { secretId: 'user-a', payload: { sessionId: 'session-a', href: new window.URL('https://www.example.net/foo') } },
+        { secretId: 'user-a', payload: { sessionId: 'session-a', href: new window.URL('https://www.example.net/bar?param=bar') } },
+        { secretId: 'user-b', payload: { sessionId: 'session-b', href: new window.URL('https://www.example.net:8443/foo') } },
+        { secretId: 'user-a', payload: { sessionId: 'session-c', href: new window.URL('https://shop.example.net/') } },
+        { secretId: null, payload: { href: new window.URL('https://shop.example.net/') } }
+      ])
+        .then(function (result) {
+          assert.deepStrictEqual(result, [
+            { key: 'www.example.net', pageviews: 3, visitors: 2, sessions: 2 },
+            { key: 'shop.example.net', pageviews: 2, visitors: 1, sessions: 1 }
+          ])
+        })
+    })
+    it('returns an empty array when given no events', function () {
+      return stats.domains([])
+        .then(function (result) {
+          assert.deepStrictEqual(result, [])
+        })
+    })
+  })
+
   describe('stats.pages(events)', function () {
     it('returns a sorted list of pages grouped by a clean URL', function () {
       return stats.pages([
@@ -394,6 +522,128 @@ describe('src/stats.js', function () {
     })
   })
 
+  describe('stats.funnel(events, steps)', function () {
+    it('returns the number of users completing each step in order', function () {
+      return stats.funnel([
+        {},
+        { eventId: 'e-01', secretId: 'user-a', payload: { type: 'PAGEVIEW', href: new window.URL('https://www.example.net/') } },
+        { eventId: 'e-02', secretId: 'user-a', payload: { type: 'PAGEVIEW', href: new window.URL('https://www.example.net/pricing/?plan=a') } },
+        { eventId: 'e-03', secretId: 'user-a', payload: { type: 'SIGNUP' } },
+        { eventId: 'e-04', secretId: 'user-b', payload: { type: 'PAGEVIEW', href: new window.URL('https://www.example.net/pricing/') } },
+        { eventId: 'e-05', secretId: 'user-b', payload: { type: 'PAGEVIEW', href: new window.URL('https://www.example.net/') } },
+        { eventId: 'e-06', secretId: 'user-c', payload: { type: 'PAGEVIEW', href: new window.URL('https://www.example.net/') } },
+        { eventId: 'e-07', secretId: 'user-c', payload: { type: 'PAGEVIEW', href: new window.URL('https://www.example.net/pricing/') } },
+        { eventId: 'e-08', secretId: 'user-d', payload: { type: 'PAGEVIEW', href: new window.URL('https://www.example.net/') } },
+        { eventId: 'e-09', secretId: null, payload: { type: 'PAGEVIEW', href: new window.URL('https://www.example.net/') } }
+      ], [
+        { type: 'url', value: 'https://www.example.net' },
+        { type: 'url', value: 'https://www.example.net/pricing' },
+        { type: 'event', value: 'SIGNUP' }
+      ])
+        .then(function (result) {
+          assert.deepStrictEqual(result, [
+            { type: 'url', value: 'https://www.example.net', users: 4, conversion: 1 },
+            { type: 'url', value: 'https://www.example.net/pricing', users: 2, conversion: 0.5 },
+            { type: 'event', value: 'SIGNUP', users: 1, conversion: 0.25 }
+          ])
+        })
+    })
+    it('matches custom events by name', function () {
+      return stats.funnel([
+        { eventId: 'e-01', secretId: 'user-a', payload: { type: 'PAGEVIEW', href: new window.URL('https://www.example.net/') } },
+        { eventId: 'e-02', secretId: 'user-a', payload: { type: 'CUSTOM', name: 'signup', href: new window.URL('https://www.example.net/') } },
+        { eventId: 'e-03', secretId: 'user-b', payload: { type: 'PAGEVIEW', href: new window.URL('https://www.example.net/') } },
+        { eventId: 'e-04', secretId: 'user-b', payload: { type: 'CUSTOM', name: 'download', href: new window.URL('https://www.example.net/') } }
+      ], [
+        { type: 'url', value: 'https://www.example.net' },
+        { type: 'event', value: 'signup' }
+      ])
+        .then(function (result) {
+          assert.deepStrictEqual(result, [
+            { type: 'url', value: 'https://www.example.net', users: 2, conversion: 1 },
+            { type: 'event', value: 'signup', users: 1, conversion: 0.5 }
+          ])
+        })
+    })
+    it('returns 0 values when given no events', function () {
+      return stats.funnel([], [{ type: 'event', value: 'PAGEVIEW' }])
+        .then(function (result) {
+          assert.deepStrictEqual(result, [{ type: 'event', value: 'PAGEVIEW', users: 0, conversion: 0 }])
+        })
+    })
+  })
+
+  describe('stats.customEvents(events, eventTypes)', function () {
+    it('aggregates custom events of the given types', function () {
+      return stats.customEvents([
+        {},
+        { secretId: 'user-a', payload: { type: 'PAGEVIEW' } },
+        { secretId: 'user-a', payload: { type: 'CUSTOM', name: 'signup' } },
+        { secretId: 'user-b', payload: { type: 'CUSTOM', name: 'signup' } },
+        { secretId: 'user-b', payload: { type: 'CUSTOM', name: 'signup' } },
+        { secretId: 'user-a', payload: { type: 'CUSTOM', name: 'download', value: 12 } },
+        { secretId: 'user-b', payload: { type: 'CUSTOM', name: 'download', value: 4 } },
+        { secretId: 'user-b', payload: { type: 'CUSTOM', name: 'download' } },
+        { secretId: 'user-c', payload: { type: 'CUSTOM', name: 'unknown' } }
+      ], [
+        { name: 'signup', aggregation: 'count' },
+        { name: 'signup', aggregation: 'users' },
+        { name: 'download', aggregation: 'sum' },
+        { name: 'download', aggregation: 'average' },
+        { name: 'newsletter', aggregation: 'average' }
+      ])
+        .then(function (result) {
+          assert.deepStrictEqual(result, [
+            { name: 'signup', aggregation: 'count', count: 3, value: 3 },
+            { name: 'signup', aggregation: 'users', count: 3, value: 2 },
+            { name: 'download', aggregation: 'sum', count: 3, value: 16 },
+            { name: 'download', aggregation: 'average', count: 3, value: 8 },
+            { name: 'newsletter', aggregation: 'average', count: 0, value: 0 }
+          ])
+        })
+    })
+    it('returns an empty list when given no event types', function () {
+      return stats.customEvents([{ payload: { type: 'CUSTOM', name: 'signup' } }])
+        .then(function (result) {
+          assert.deepStrictEqual(result, [])
+        })
+    })
+  })
+
+  describe('stats.goals(events, goals)', function () {
+    it('computes conversions for url and event goals', function () {
+      return stats.goals([
+        {},
+        { secretId: 'user-a', payload: { type: 'PAGEVIEW', href: new window.URL('https://www.offen.dev/') } },
+        { secretId: 'user-a', payload: { type: 'PAGEVIEW', href: new window.URL('https://www.offen.dev/checkout/123/thanks/?ref=mail') } },
+        { secretId: 'user-b', payload: { type: 'PAGEVIEW', href: new window.URL('https://www.offen.dev/checkout/456/thanks/') } },
+        { secretId: 'user-b', payload: { type: 'PAGEVIEW', href: new window.URL('https://www.offen.dev/checkout/456/thanks/') } },
+        { secretId: 'user-c', payload: { type: 'PAGEVIEW', href: new window.URL('https://www.offen.dev/checkout/') } },
+        { secretId: 'user-c', payload: { type: 'CUSTOM', name: 'signup', href: new window.URL('https://www.offen.dev/checkout/1/thanks/') } },
+        { secretId: 'user-d', payload: { type: 'PAGEVIEW', href: new window.URL('https://www.offen.dev/') } }
+      ], [
+        { goalId: 'goal-a', name: 'Checkout', type: 'url', value: 'https://www.offen.dev/checkout/*/thanks' },
+        { goalId: 'goal-b', name: 'Signup', type: 'event', value: 'signup' },
+        { goalId: 'goal-c', name: 'Home', type: 'url', value: 'https://www.offen.dev/' },
+        { goalId: 'goal-d', name: 'Other', type: 'url', value: 'https://offen.dev/*' }
+      ])
+        .then(function (result) {
+          assert.deepStrictEqual(result, [
+            { goalId: 'goal-a', name: 'Checkout', type: 'url', value: 'https://www.offen.dev/checkout/*/thanks', conversions: 3, users: 2, conversionRate: 0.5 },
+            { goalId: 'goal-b', name: 'Signup', type: 'event', value: 'signup', conversions: 1, users: 1, conversionRate: 0.25 },
+            { goalId: 'goal-c', name: 'Home', type: 'url', value: 'https://www.offen.dev/', conversions: 2, users: 2, conversionRate: 0.5 },
+            { goalId: 'goal-d', name: 'Other', type: 'url', value: 'https://offen.dev/*', conversions: 0, users: 0, conversionRate: 0 }
+          ])
+        })
+    })
+    it('returns an empty list when given no goals', function () {
+      return stats.goals([{ secretId: 'user-a', payload: { type: 'CUSTOM', name: 'signup' } }])
+        .then(function (result) {
+          assert.deepStrictEqual(result, [])
+        })
+    })
+  })
+
   describe('stats.retention(...events)', function () {
     it('returns a retention matrix for the given event chunks', function () {
       return stats.retention(